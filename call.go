@@ -0,0 +1,157 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package humboldt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// IsRequest returns true if the message is a request sent with
+// Client.Call, to be answered with Reply or ReplyError.
+func (m *Message) IsRequest() bool {
+	return m.request != nil
+}
+
+// Reply answers a request with a reply carrying the specified
+// payload.  ErrNotRequest is returned if the message is not a
+// request.  If the message was delivered to several subscriptions,
+// only the first reply reaches the caller.
+func (m *Message) Reply(payload []byte) error {
+	return m.reply(payload, false)
+}
+
+// ReplyError answers a request with an error reply describing the
+// failure of the request; the caller's Call returns an error
+// wrapping ErrCallFailed and carrying the description.
+// ErrNotRequest is returned if the message is not a request.
+func (m *Message) ReplyError(err error) error {
+	return m.reply([]byte(err.Error()), true)
+}
+
+// reply sends a reply to a request.
+func (m *Message) reply(payload []byte, isError bool) error {
+	if m.request == nil {
+		return ErrNotRequest
+	}
+	d := &proto.Data{
+		Dest:     m.Source,
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: m.Protocol,
+		Payload:  payload,
+		Reply:    true,
+		Error:    isError,
+	}
+
+	return m.client.sendData(d, m.request)
+}
+
+// Call sends a request to the specified destination node and waits
+// for the reply, returning its payload.  The request is delivered to
+// the clients subscribed to the application protocol at the
+// destination, which answer it with Message.Reply or
+// Message.ReplyError; an error reply is returned as an error wrapping
+// ErrCallFailed, as is a failure of the overlay to deliver the
+// request, such as the absence of a route to the destination.  If
+// the context expires before the reply arrives, an error wrapping
+// both ErrCallTimeout and the context's error is returned; if it is
+// cancelled, ErrCallCanceled is wrapped instead.  A reply arriving
+// afterwards is discarded.  The round trip of each call answered is
+// recorded in the latency histogram of its destination; see
+// Client.Latency.
+func (cl *Client) Call(ctx context.Context, dest proto.NodeID, protocol uint8, payload []byte) ([]byte, error) {
+	id := cl.nextID.Add(1)
+	ch := make(chan *proto.Data, 1)
+	cl.lock.Lock()
+	if cl.calls == nil {
+		cl.lock.Unlock()
+		return nil, ErrClientClosed
+	}
+	cl.calls[id] = ch
+	cl.lock.Unlock()
+	defer cl.forget(id)
+
+	d := &proto.Data{
+		Dest:     dest,
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: protocol,
+		Payload:  payload,
+	}
+	cl.Latency.Sent(dest, &proto.Header{Protocol: protocol}, id)
+	if err := cl.sendData(d, &proto.Request{ID: id}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-ch:
+		cl.Latency.Received(dest, &proto.Header{Reply: true, Protocol: protocol}, id)
+		if reply.Error {
+			return nil, fmt.Errorf("call to %s: %w: %s", dest, ErrCallFailed, reply.Payload)
+		}
+		return reply.Payload, nil
+
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("call to %s: %w: %w", dest, ErrCallTimeout, ctx.Err())
+		}
+		return nil, fmt.Errorf("call to %s: %w: %w", dest, ErrCallCanceled, ctx.Err())
+
+	case <-cl.done:
+		return nil, ErrClientClosed
+	}
+}
+
+// sendData sends a data message carrying the request extension.
+func (cl *Client) sendData(d *proto.Data, req *proto.Request) error {
+	f, err := d.Frame()
+	if err != nil {
+		return err
+	}
+	f.Extensions = proto.Extensions{req.Extension()}
+	f.Header.Protocol = f.Extensions.Link(f.Header.Protocol)
+
+	return cl.Conduit.Send(f)
+}
+
+// forget forgets a call which has completed.
+func (cl *Client) forget(id uint32) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	delete(cl.calls, id)
+}
+
+// answer passes a reply to the call awaiting it.  Replies to unknown
+// or completed calls are discarded.
+func (cl *Client) answer(d *proto.Data, exts proto.Extensions) {
+	req := proto.FindRequest(exts)
+	if req == nil {
+		return
+	}
+
+	cl.lock.Lock()
+	ch := cl.calls[req.ID]
+	delete(cl.calls, req.ID)
+	cl.lock.Unlock()
+
+	if ch != nil {
+		reply := *d
+		reply.Payload = append([]byte(nil), d.Payload...)
+		ch <- &reply
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package humboldt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// sendRequest sends a data message carrying a request extension to
+// the client.
+func (n *testNode) sendRequest(t *testing.T, d *proto.Data, id uint32) {
+	t.Helper()
+
+	f, err := d.Frame()
+	require.NoError(t, err)
+	f.Extensions = proto.Extensions{(&proto.Request{ID: id}).Extension()}
+	f.Header.Protocol = f.Extensions.Link(f.Header.Protocol)
+	require.NoError(t, n.w.WriteFrame(f))
+}
+
+// request reads a request from the client, returning it and its
+// correlation ID.
+func (n *testNode) request(t *testing.T) (*proto.Data, uint32) {
+	t.Helper()
+
+	f := <-n.frames
+	require.NotNil(t, f)
+	require.Equal(t, proto.ProtoData, f.Protocol())
+	d, err := proto.DecodeDataFrame(f)
+	require.NoError(t, err)
+	req := proto.FindRequest(f.Extensions)
+	require.NotNil(t, req)
+
+	return d, req.ID
+}
+
+// call makes a call in the background, returning a channel on which
+// its result is sent.
+func call(ctx context.Context, cl *Client, dest proto.NodeID) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		reply, err := cl.Call(ctx, dest, 42, []byte("request"))
+		if err == nil && string(reply) != "reply" {
+			err = errors.New("unexpected reply " + string(reply))
+		}
+		result <- err
+	}()
+
+	return result
+}
+
+func TestClientCallBase(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	result := call(context.Background(), obj, proto.NodeID{2})
+
+	d, id := node.request(t)
+	assert.Equal(t, &proto.Data{
+		Dest:     proto.NodeID{2},
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: 42,
+		Payload:  []byte("request"),
+	}, d)
+	node.sendRequest(t, &proto.Data{Source: proto.NodeID{2}, Protocol: 42, Payload: []byte("reply"), Reply: true}, id)
+	assert.NoError(t, <-result)
+	h := obj.Latency.Histogram(proto.NodeID{2})
+	require.NotNil(t, h)
+	assert.Equal(t, uint64(1), h.Count())
+}
+
+func TestClientCallError(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	result := call(context.Background(), obj, proto.NodeID{2})
+
+	_, id := node.request(t)
+	node.sendRequest(t, &proto.Data{Protocol: 42, Payload: []byte("no route"), Reply: true, Error: true}, id)
+	err = <-result
+	assert.ErrorIs(t, err, ErrCallFailed)
+	assert.ErrorContains(t, err, "no route")
+	assert.NotNil(t, obj.Latency.Histogram(proto.NodeID{2}))
+}
+
+func TestClientCallOtherReply(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	result := call(context.Background(), obj, proto.NodeID{2})
+
+	_, id := node.request(t)
+	node.sendRequest(t, &proto.Data{Protocol: 42, Payload: []byte("other"), Reply: true}, id+1)
+	node.sendRequest(t, &proto.Data{Protocol: 42, Payload: []byte("reply"), Reply: true}, id)
+	assert.NoError(t, <-result)
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	result := call(ctx, obj, proto.NodeID{2})
+
+	node.request(t)
+	err = <-result
+	assert.ErrorIs(t, err, ErrCallTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, obj.calls)
+}
+
+func TestClientCallCanceled(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	result := call(ctx, obj, proto.NodeID{2})
+
+	node.request(t)
+	cancel()
+	err = <-result
+	assert.ErrorIs(t, err, ErrCallCanceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClientCallClosed(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+
+	result, err := obj.Call(context.Background(), proto.NodeID{2}, 42, []byte("request"))
+
+	assert.ErrorIs(t, err, ErrClientClosed)
+	assert.Nil(t, result)
+}
+
+func TestClientCallTooLong(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	result, err := obj.Call(context.Background(), proto.NodeID{2}, 42, make([]byte, proto.MaxLength))
+
+	assert.ErrorIs(t, err, proto.ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestMessageReplyBase(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	node.control(t)
+	node.sendRequest(t, &proto.Data{Source: proto.NodeID{3}, Protocol: 42, Payload: []byte("request")}, 7)
+	msg := <-s.C
+	require.True(t, msg.IsRequest())
+
+	err = msg.Reply([]byte("reply"))
+
+	assert.NoError(t, err)
+	d, id := node.request(t)
+	assert.Equal(t, uint32(7), id)
+	assert.Equal(t, &proto.Data{
+		Dest:     proto.NodeID{3},
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: 42,
+		Payload:  []byte("reply"),
+		Reply:    true,
+	}, d)
+}
+
+func TestMessageReplyError(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	node.control(t)
+	node.sendRequest(t, &proto.Data{Source: proto.NodeID{3}, Protocol: 42}, 7)
+	msg := <-s.C
+
+	err = msg.ReplyError(errors.New("bad request"))
+
+	assert.NoError(t, err)
+	d, _ := node.request(t)
+	assert.True(t, d.Reply)
+	assert.True(t, d.Error)
+	assert.Equal(t, []byte("bad request"), d.Payload)
+}
+
+func TestMessageReplyNotRequest(t *testing.T) {
+	obj := &Message{Source: proto.NodeID{3}, Protocol: 42}
+
+	err := obj.Reply([]byte("reply"))
+
+	assert.ErrorIs(t, err, ErrNotRequest)
+	assert.False(t, obj.IsRequest())
+}
@@ -0,0 +1,470 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package humboldt contains the client API for the Humboldt overlay.
+// A Client connects to a Humboldt node over a conduit and negotiates
+// as a client rather than as a peer node: it is not part of the
+// overlay, and relies on the node it is connected to for delivering
+// the messages it sends and receives.  Messages carry an application
+// protocol number, and are addressed to nodes by node ID; a client
+// receives the messages addressed to its node by subscribing to
+// their application protocols.  Clients may also publish messages to
+// topics, which are delivered to the clients subscribing to the
+// topics at any node of the overlay.  Request/response protocols are
+// supported by Client.Call, which sends a request and waits for the
+// reply, and Message.Reply, with which the recipient answers it.
+package humboldt
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Protocol versions and major versions of the wire format supported
+// by the client.
+const (
+	MinProto = proto.Version  // Minimum supported protocol version
+	MaxProto = proto.Version  // Maximum supported protocol version
+	MinMajor = 0              // Minimum supported major version
+	MaxMajor = proto.MaxMajor // Maximum supported major version
+)
+
+// Message describes a message received by a client.
+type Message struct {
+	Source   proto.NodeID // Identifier of the originating node
+	Protocol uint8        // Application protocol of the payload
+	Topic    string       // Topic the message was published to, if any
+	Payload  []byte       // The application payload
+
+	client  *Client        // The client, if the message is a request
+	request *proto.Request // The request extension, if any
+}
+
+// Subscription describes a client's subscription to the messages of
+// an application protocol, or to the messages published to a topic.
+// Messages are delivered on the channel, which is closed when the
+// subscription is cancelled or the client closes.  If the channel's
+// buffer is full, messages are dropped.
+type Subscription struct {
+	C <-chan *Message // Channel on which messages are delivered
+
+	client   *Client       // The client
+	protocol uint8         // The application protocol
+	topic    string        // The topic; empty for protocol subscriptions
+	ch       chan *Message // The channel, for sending
+	dropped  atomic.Uint64 // Count of messages dropped
+}
+
+// Protocol returns the application protocol of the subscription.
+func (s *Subscription) Protocol() uint8 {
+	return s.protocol
+}
+
+// Topic returns the topic of the subscription, or the empty string
+// if it is a subscription to an application protocol.
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Dropped returns the number of messages dropped because the
+// channel's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Cancel cancels the subscription, closing the channel.  When the
+// last subscription to an application protocol or topic is
+// cancelled, the node is told to stop delivering its messages.
+func (s *Subscription) Cancel() error {
+	cl := s.client
+	cl.ctl.Lock()
+	defer cl.ctl.Unlock()
+
+	if !cl.remove(s) {
+		return nil
+	}
+	if s.topic != "" {
+		return cl.topicControl(proto.ControlTopicUnsub, s.topic)
+	}
+
+	return cl.control(proto.ControlUnsub, s.protocol)
+}
+
+// Client is a client of a Humboldt node.
+type Client struct {
+	Conduit *conduit.Conduit      // The conduit to the node
+	Latency *proto.LatencyTracker // Round-trip times of calls, by destination
+
+	lock   sync.Mutex                  // Protects the subscriptions
+	ctl    sync.Mutex                  // Serializes subscription changes
+	subs   map[uint8][]*Subscription   // Subscriptions, by protocol
+	topics map[string][]*Subscription  // Subscriptions, by topic
+	calls  map[uint32]chan *proto.Data // Calls awaiting replies, by correlation ID
+	nextID atomic.Uint32               // Counter allocating correlation IDs
+	once   sync.Once                   // Ensures the conduit is closed once
+	done   chan struct{}               // Closed when the client closes
+	err    error                       // Error which closed the client
+}
+
+// Dial dials a Humboldt node and negotiates the conduit, returning a
+// client.
+func Dial(ctx context.Context, config conduit.Config, uri string, opts ...conduit.DialerOption) (*Client, error) {
+	c, err := dial(ctx, config, uri, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(c)
+}
+
+// NewClient negotiates a new conduit to a Humboldt node as a client,
+// returning the client.  The conduit is closed if the negotiation
+// fails or the peer is not a node.  The client reads from the
+// conduit until it is closed.
+func NewClient(c *conduit.Conduit) (*Client, error) {
+	if err := c.Negotiate(&proto.Negotiator{
+		MinProto: MinProto,
+		MaxProto: MaxProto,
+		MinMajor: MinMajor,
+		MaxMajor: MaxMajor,
+	}); err != nil {
+		c.Close() //nolint:errcheck
+		return nil, err
+	}
+	if id, _ := c.Peer.(proto.NodeID); id.IsZero() {
+		c.CloseWithReason(ErrNotNode) //nolint:errcheck
+		return nil, ErrNotNode
+	}
+
+	cl := &Client{
+		Conduit: c,
+		Latency: &proto.LatencyTracker{},
+		subs:    map[uint8][]*Subscription{},
+		topics:  map[string][]*Subscription{},
+		calls:   map[uint32]chan *proto.Data{},
+		done:    make(chan struct{}),
+	}
+	go cl.recv()
+
+	return cl, nil
+}
+
+// Node returns the node ID of the node the client is connected to.
+func (cl *Client) Node() proto.NodeID {
+	id, _ := cl.Conduit.Peer.(proto.NodeID)
+
+	return id
+}
+
+// Send sends a message to the specified destination node.  The
+// destination may also be an anycast destination, as returned by
+// proto.AnycastID, in which case the message is delivered to the
+// nearest node claiming it.  The payload must fit in a single PDU.
+func (cl *Client) Send(dest proto.NodeID, protocol uint8, payload []byte) error {
+	d := &proto.Data{
+		Dest:     dest,
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: protocol,
+		Payload:  payload,
+	}
+	f, err := d.Frame()
+	if err != nil {
+		return err
+	}
+
+	return cl.Conduit.Send(f)
+}
+
+// Publish publishes a message to a topic.  The message is delivered
+// to the clients subscribing to the topic, at the node the client is
+// connected to and at any other node.  The payload must fit in a
+// single PDU.
+func (cl *Client) Publish(topic string, payload []byte) error {
+	if topic == "" || len(topic) > proto.MaxTopicSize {
+		return ErrBadTopic
+	}
+	p := &proto.Publish{
+		HopLimit: proto.DefaultHopLimit,
+		Topic:    topic,
+		Payload:  payload,
+	}
+	f, err := p.Frame()
+	if err != nil {
+		return err
+	}
+
+	return cl.Conduit.Send(f)
+}
+
+// control sends a subscription control message to the node.
+func (cl *Client) control(t proto.ControlType, protocol uint8) error {
+	msg := &proto.ControlMessage{Type: t, Body: []byte{protocol}}
+
+	return cl.Conduit.Send(msg.Frame())
+}
+
+// topicControl sends a topic subscription control message to the
+// node.
+func (cl *Client) topicControl(t proto.ControlType, topic string) error {
+	msg := &proto.ControlMessage{Type: t, Body: []byte(topic)}
+
+	return cl.Conduit.Send(msg.Frame())
+}
+
+// Subscribe subscribes to the messages of an application protocol
+// addressed to the node, returning a subscription whose channel has
+// the specified buffer size.  When the first subscription to an
+// application protocol is made, the node is told to begin delivering
+// its messages.
+func (cl *Client) Subscribe(protocol uint8, buffer int) (*Subscription, error) {
+	ch := make(chan *Message, buffer)
+	s := &Subscription{C: ch, client: cl, protocol: protocol, ch: ch}
+
+	cl.ctl.Lock()
+	defer cl.ctl.Unlock()
+
+	cl.lock.Lock()
+	if cl.subs == nil {
+		cl.lock.Unlock()
+		return nil, ErrClientClosed
+	}
+	first := len(cl.subs[protocol]) == 0
+	cl.subs[protocol] = append(cl.subs[protocol], s)
+	cl.lock.Unlock()
+
+	if first {
+		if err := cl.control(proto.ControlSub, protocol); err != nil {
+			cl.remove(s)
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// SubscribeTopic subscribes to the messages published to a topic,
+// returning a subscription whose channel has the specified buffer
+// size.  When the first subscription to a topic is made, the node is
+// told to begin delivering its messages; the subscription takes a
+// moment to reach the other nodes, and messages published elsewhere
+// in the meantime are not delivered.
+func (cl *Client) SubscribeTopic(topic string, buffer int) (*Subscription, error) {
+	if topic == "" || len(topic) > proto.MaxTopicSize {
+		return nil, ErrBadTopic
+	}
+	ch := make(chan *Message, buffer)
+	s := &Subscription{C: ch, client: cl, topic: topic, ch: ch}
+
+	cl.ctl.Lock()
+	defer cl.ctl.Unlock()
+
+	cl.lock.Lock()
+	if cl.topics == nil {
+		cl.lock.Unlock()
+		return nil, ErrClientClosed
+	}
+	first := len(cl.topics[topic]) == 0
+	cl.topics[topic] = append(cl.topics[topic], s)
+	cl.lock.Unlock()
+
+	if first {
+		if err := cl.topicControl(proto.ControlTopicSub, topic); err != nil {
+			cl.remove(s)
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// remove removes a subscription, closing its channel.  It returns
+// true if it was the last subscription to its protocol or topic.
+func (cl *Client) remove(s *Subscription) bool {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if s.topic != "" {
+		return removeSub(cl.topics, s.topic, s)
+	}
+
+	return removeSub(cl.subs, s.protocol, s)
+}
+
+// removeSub removes a subscription from a map of subscriptions,
+// closing its channel.  It returns true if it was the last
+// subscription under its key.
+func removeSub[K comparable](m map[K][]*Subscription, key K, s *Subscription) bool {
+	subs := m[key]
+	idx := slices.Index(subs, s)
+	if idx < 0 {
+		return false
+	}
+	close(s.ch)
+	subs = slices.Delete(subs, idx, idx+1)
+	if len(subs) > 0 {
+		m[key] = subs
+		return false
+	}
+	delete(m, key)
+
+	return true
+}
+
+// deliver delivers a message to the subscriptions to its protocol.
+// If the message is a request, the request extension is retained so
+// that the message may be replied to.
+func (cl *Client) deliver(d *proto.Data, exts proto.Extensions) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	subs := cl.subs[d.Protocol]
+	if len(subs) == 0 {
+		return
+	}
+	msg := &Message{
+		Source:   d.Source,
+		Protocol: d.Protocol,
+		Payload:  append([]byte(nil), d.Payload...),
+	}
+	if req := proto.FindRequest(exts); req != nil {
+		msg.client = cl
+		msg.request = req
+	}
+	send(subs, msg)
+}
+
+// deliverTopic delivers a published message to the subscriptions to
+// its topic.
+func (cl *Client) deliverTopic(p *proto.Publish) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	subs := cl.topics[p.Topic]
+	if len(subs) == 0 {
+		return
+	}
+	msg := &Message{
+		Source:  p.Source,
+		Topic:   p.Topic,
+		Payload: append([]byte(nil), p.Payload...),
+	}
+	send(subs, msg)
+}
+
+// send sends a message on the channels of subscriptions, dropping it
+// for those whose buffers are full.
+func send(subs []*Subscription, msg *Message) {
+	for _, s := range subs {
+		select {
+		case s.ch <- msg:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// recv reads from the conduit until it is closed.
+func (cl *Client) recv() {
+	for {
+		f, err := cl.Conduit.Recv()
+		if err != nil {
+			cl.shutdown(err)
+			return
+		}
+
+		switch f.Protocol() {
+		case proto.ProtoData:
+			d, err := proto.DecodeDataFrame(f)
+			if err != nil {
+				continue
+			}
+			if d.Reply {
+				cl.answer(d, f.Extensions)
+			} else {
+				cl.deliver(d, f.Extensions)
+			}
+
+		case proto.ProtoPubSub:
+			if p, err := proto.DecodePublish(f.Payload); err == nil {
+				cl.deliverTopic(p)
+			}
+
+		case proto.ProtoControl:
+			msg := &proto.ControlMessage{}
+			if _, err := msg.FromBytes(f.Payload); err == nil && msg.Type == proto.ControlClose {
+				cl.once.Do(func() {
+					cl.Conduit.HandleClose(msg)
+				})
+			}
+		}
+	}
+}
+
+// shutdown marks the client as closed, closing the channels of the
+// subscriptions.
+func (cl *Client) shutdown(err error) {
+	cl.once.Do(func() {
+		cl.Conduit.Close() //nolint:errcheck
+	})
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	cl.err = err
+	for _, subs := range cl.subs {
+		for _, s := range subs {
+			close(s.ch)
+		}
+	}
+	for _, subs := range cl.topics {
+		for _, s := range subs {
+			close(s.ch)
+		}
+	}
+	cl.subs = nil
+	cl.topics = nil
+	cl.calls = nil
+	close(cl.done)
+}
+
+// Done returns a channel which is closed when the client closes.
+func (cl *Client) Done() <-chan struct{} {
+	return cl.done
+}
+
+// Err returns the error which caused the client to close, once it
+// has closed.  It is nil while the client is open.
+func (cl *Client) Err() error {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	return cl.err
+}
+
+// Close closes the client, notifying the node, and waits for the
+// subscriptions to be closed.
+func (cl *Client) Close() error {
+	var err error
+	cl.once.Do(func() {
+		err = cl.Conduit.CloseWithReason(nil)
+	})
+	<-cl.done
+
+	return err
+}
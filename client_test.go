@@ -0,0 +1,409 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package humboldt
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// testNode is the node end of a client conduit.
+type testNode struct {
+	link   net.Conn          // The node end of the link
+	w      *proto.Writer     // Writes frames to the client
+	frames chan *proto.Frame // Frames received from the client
+}
+
+// newTestClient constructs a client connected to a test node with
+// the specified node ID.
+func newTestClient(t *testing.T, id proto.NodeID) (*Client, *testNode, error) {
+	t.Helper()
+
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	node := &testNode{link: c2, w: proto.NewWriter(c2), frames: make(chan *proto.Frame, 10)}
+	go func() {
+		defer close(node.frames)
+		n := &proto.Negotiator{MinProto: 1, MaxProto: 1, NodeID: id}
+		if _, err := n.Negotiate(c2); err != nil {
+			return
+		}
+		r := proto.NewReader(c2)
+		for {
+			f, err := r.ReadFrame()
+			if err != nil {
+				return
+			}
+			node.frames <- f
+		}
+	}()
+
+	cl, err := NewClient(&conduit.Conduit{State: conduit.Active, Link: c1})
+
+	return cl, node, err
+}
+
+// control reads a control message from the client.
+func (n *testNode) control(t *testing.T) *proto.ControlMessage {
+	t.Helper()
+
+	f := <-n.frames
+	require.NotNil(t, f)
+	require.Equal(t, proto.ProtoControl, f.Protocol())
+	msg := &proto.ControlMessage{}
+	_, err := msg.FromBytes(f.Payload)
+	require.NoError(t, err)
+
+	return msg
+}
+
+// send sends a data message to the client.
+func (n *testNode) send(t *testing.T, d *proto.Data) {
+	t.Helper()
+
+	f, err := d.Frame()
+	require.NoError(t, err)
+	require.NoError(t, n.w.WriteFrame(f))
+}
+
+// publish sends a published message to the client.
+func (n *testNode) publish(t *testing.T, p *proto.Publish) {
+	t.Helper()
+
+	f, err := p.Frame()
+	require.NoError(t, err)
+	require.NoError(t, n.w.WriteFrame(f))
+}
+
+func TestDialBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go (&proto.Negotiator{MaxProto: 1, NodeID: proto.NodeID{1}}).Negotiate(c2) //nolint:errcheck
+	defer patcher.SetVar(&dial, func(ctx context.Context, config conduit.Config, uri string, opts ...conduit.DialerOption) (*conduit.Conduit, error) {
+		assert.Equal(t, "mem:node", uri)
+		return &conduit.Conduit{State: conduit.Active, Link: c1}, nil
+	}).Install().Restore()
+
+	result, err := Dial(context.Background(), nil, "mem:node")
+
+	require.NoError(t, err)
+	assert.Equal(t, proto.NodeID{1}, result.Node())
+}
+
+func TestDialError(t *testing.T) {
+	defer patcher.SetVar(&dial, func(ctx context.Context, config conduit.Config, uri string, opts ...conduit.DialerOption) (*conduit.Conduit, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := Dial(context.Background(), nil, "mem:node")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestNewClientBase(t *testing.T) {
+	result, _, err := newTestClient(t, proto.NodeID{1})
+
+	require.NoError(t, err)
+	assert.Equal(t, proto.NodeID{1}, result.Node())
+	assert.Equal(t, uint32(1), result.Conduit.Proto)
+	assert.NoError(t, result.Err())
+}
+
+func TestNewClientNotNode(t *testing.T) {
+	result, _, err := newTestClient(t, proto.NodeID{})
+
+	assert.ErrorIs(t, err, ErrNotNode)
+	assert.Nil(t, result)
+}
+
+func TestNewClientNegotiateError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	c2.Close()
+	c := &conduit.Conduit{State: conduit.Active, Link: c1}
+
+	result, err := NewClient(c)
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	assert.Equal(t, conduit.Error, c.State)
+}
+
+func TestClientSendBase(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	err = obj.Send(proto.NodeID{2}, 42, []byte("payload"))
+
+	assert.NoError(t, err)
+	f := <-node.frames
+	assert.Equal(t, proto.ProtoData, f.Protocol())
+	d, err := proto.DecodeData(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, &proto.Data{
+		Dest:     proto.NodeID{2},
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: 42,
+		Payload:  []byte("payload"),
+	}, d)
+}
+
+func TestClientSendTooLong(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	err = obj.Send(proto.NodeID{2}, 42, make([]byte, proto.MaxLength))
+
+	assert.ErrorIs(t, err, proto.ErrTooLong)
+}
+
+func TestClientSubscribe(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	s1, err1 := obj.Subscribe(42, 1)
+	s2, err2 := obj.Subscribe(42, 1)
+
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.Equal(t, uint8(42), s1.Protocol())
+	assert.Equal(t, &proto.ControlMessage{Type: proto.ControlSub, Body: []byte{42}}, node.control(t))
+	node.send(t, &proto.Data{Dest: proto.NodeID{1}, Source: proto.NodeID{3}, Protocol: 42, Payload: []byte("hello")})
+	expected := &Message{Source: proto.NodeID{3}, Protocol: 42, Payload: []byte("hello")}
+	assert.Equal(t, expected, <-s1.C)
+	assert.Equal(t, expected, <-s2.C)
+}
+
+func TestClientSubscribeOther(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	node.control(t)
+
+	node.send(t, &proto.Data{Protocol: 43, Payload: []byte("other")})
+	node.send(t, &proto.Data{Protocol: 42, Payload: []byte("hello")})
+
+	assert.Equal(t, []byte("hello"), (<-s.C).Payload)
+}
+
+func TestClientSubscribeDropped(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	node.control(t)
+
+	node.send(t, &proto.Data{Protocol: 42, Payload: []byte("one")})
+	node.send(t, &proto.Data{Protocol: 42, Payload: []byte("two")})
+
+	require.Eventually(t, func() bool {
+		return s.Dropped() == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []byte("one"), (<-s.C).Payload)
+}
+
+func TestClientSubscribeClosed(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+
+	result, err := obj.Subscribe(42, 1)
+
+	assert.ErrorIs(t, err, ErrClientClosed)
+	assert.Nil(t, result)
+}
+
+func TestSubscriptionCancel(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s1, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	s2, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	node.control(t)
+
+	err1 := s1.Cancel()
+	err2 := s2.Cancel()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &proto.ControlMessage{Type: proto.ControlUnsub, Body: []byte{42}}, node.control(t))
+	_, ok := <-s1.C
+	assert.False(t, ok)
+	_, ok = <-s2.C
+	assert.False(t, ok)
+	assert.NoError(t, s1.Cancel())
+}
+
+func TestClientPublishBase(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	err = obj.Publish("news", []byte("payload"))
+
+	assert.NoError(t, err)
+	f := <-node.frames
+	assert.Equal(t, proto.ProtoPubSub, f.Protocol())
+	p, err := proto.DecodePublish(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, &proto.Publish{
+		HopLimit: proto.DefaultHopLimit,
+		Topic:    "news",
+		Targets:  []proto.NodeID{},
+		Payload:  []byte("payload"),
+	}, p)
+}
+
+func TestClientPublishBadTopic(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	err1 := obj.Publish("", []byte("payload"))
+	err2 := obj.Publish(strings.Repeat("x", proto.MaxTopicSize+1), []byte("payload"))
+
+	assert.ErrorIs(t, err1, ErrBadTopic)
+	assert.ErrorIs(t, err2, ErrBadTopic)
+}
+
+func TestClientPublishTooLong(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	err = obj.Publish("news", make([]byte, proto.MaxLength))
+
+	assert.ErrorIs(t, err, proto.ErrTooLong)
+}
+
+func TestClientSubscribeTopic(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	s1, err1 := obj.SubscribeTopic("news", 1)
+	s2, err2 := obj.SubscribeTopic("news", 1)
+
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.Equal(t, "news", s1.Topic())
+	assert.Equal(t, &proto.ControlMessage{Type: proto.ControlTopicSub, Body: []byte("news")}, node.control(t))
+	node.publish(t, &proto.Publish{Source: proto.NodeID{3}, Topic: "news", Payload: []byte("hello")})
+	expected := &Message{Source: proto.NodeID{3}, Topic: "news", Payload: []byte("hello")}
+	assert.Equal(t, expected, <-s1.C)
+	assert.Equal(t, expected, <-s2.C)
+}
+
+func TestClientSubscribeTopicOther(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s, err := obj.SubscribeTopic("news", 1)
+	require.NoError(t, err)
+	node.control(t)
+
+	node.publish(t, &proto.Publish{Topic: "weather", Payload: []byte("other")})
+	node.publish(t, &proto.Publish{Topic: "news", Payload: []byte("hello")})
+
+	assert.Equal(t, []byte("hello"), (<-s.C).Payload)
+}
+
+func TestClientSubscribeTopicBadTopic(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	result, err := obj.SubscribeTopic("", 1)
+
+	assert.ErrorIs(t, err, ErrBadTopic)
+	assert.Nil(t, result)
+}
+
+func TestClientSubscribeTopicClosed(t *testing.T) {
+	obj, _, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+
+	result, err := obj.SubscribeTopic("news", 1)
+
+	assert.ErrorIs(t, err, ErrClientClosed)
+	assert.Nil(t, result)
+}
+
+func TestSubscriptionCancelTopic(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s1, err := obj.SubscribeTopic("news", 1)
+	require.NoError(t, err)
+	s2, err := obj.SubscribeTopic("news", 1)
+	require.NoError(t, err)
+	node.control(t)
+
+	err1 := s1.Cancel()
+	err2 := s2.Cancel()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &proto.ControlMessage{Type: proto.ControlTopicUnsub, Body: []byte("news")}, node.control(t))
+	_, ok := <-s1.C
+	assert.False(t, ok)
+	_, ok = <-s2.C
+	assert.False(t, ok)
+}
+
+func TestClientClose(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+	s, err := obj.Subscribe(42, 1)
+	require.NoError(t, err)
+	node.control(t)
+	ts, err := obj.SubscribeTopic("news", 1)
+	require.NoError(t, err)
+	node.control(t)
+
+	err = obj.Close()
+
+	assert.NoError(t, err)
+	msg := node.control(t)
+	assert.Equal(t, proto.ControlClose, msg.Type)
+	_, ok := <-s.C
+	assert.False(t, ok)
+	_, ok = <-ts.C
+	assert.False(t, ok)
+	assert.Error(t, obj.Err())
+	assert.NoError(t, s.Cancel())
+}
+
+func TestClientPeerClose(t *testing.T) {
+	obj, node, err := newTestClient(t, proto.NodeID{1})
+	require.NoError(t, err)
+
+	require.NoError(t, node.w.WriteFrame(proto.CloseFrame("going away")))
+
+	<-obj.Done()
+	assert.ErrorIs(t, context.Cause(obj.Conduit.Context()), conduit.ErrPeerClosed)
+	assert.Error(t, obj.Err())
+}
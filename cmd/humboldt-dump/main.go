@@ -0,0 +1,247 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Command humboldt-dump pretty-prints the PDUs recorded in capture
+// files, as written by a Humboldt node whose configuration sets the
+// "capture" key, or on request with "humboldtctl capture".  Each
+// file named on the command line is read in turn; with no files, or
+// with "-", the capture is read from the standard input.  Each PDU
+// is printed on one line, giving the time it was exchanged, the
+// conduit, identified by its number within the capture, its remote
+// URI, and its peer, the direction, and a summary of the PDU.  The
+// flags are:
+//
+//	-conduit N       prints only the PDUs of conduit N
+//	-v               follows each PDU with its decoded structure
+//	-x               follows each PDU with a hexadecimal dump
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// ErrUsage is returned if humboldt-dump is invoked incorrectly.
+var ErrUsage = errors.New("usage: humboldt-dump [-conduit N] [-v] [-x] [FILE...]")
+
+// controlTypes maps control message types to their names.
+var controlTypes = map[proto.ControlType]string{
+	proto.ControlDrain:      "drain",
+	proto.ControlDrainAck:   "drain-ack",
+	proto.ControlHello:      "hello",
+	proto.ControlPing:       "ping",
+	proto.ControlPong:       "pong",
+	proto.ControlBind:       "bind",
+	proto.ControlClose:      "close",
+	proto.ControlSub:        "sub",
+	proto.ControlUnsub:      "unsub",
+	proto.ControlKeepalive:  "keepalive",
+	proto.ControlProbe:      "probe",
+	proto.ControlProbeAck:   "probe-ack",
+	proto.ControlTopicSub:   "topic-sub",
+	proto.ControlTopicUnsub: "topic-unsub",
+}
+
+// extension summarizes an extension.
+func extension(ext *proto.Extension) string {
+	switch ext.Number {
+	case proto.ExtFlood:
+		fl := &proto.Flood{}
+		if _, err := fl.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("flood(origin=%s seq=%d ttl=%d)", fl.Origin, fl.Sequence, fl.TTL)
+		}
+	case proto.ExtChecksum:
+		ck := &proto.Checksum{}
+		if _, err := ck.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("checksum(alg=%d)", ck.Algorithm)
+		}
+	case proto.ExtChannel:
+		ch := &proto.Channel{}
+		if _, err := ch.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("channel(id=%d flags=%#02x)", ch.ID, ch.Flags)
+		}
+	case proto.ExtRequest:
+		r := &proto.Request{}
+		if _, err := r.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("request(id=%d)", r.ID)
+		}
+	}
+
+	return fmt.Sprintf("ext=%#02x(len=%d)", ext.Number, len(ext.Data))
+}
+
+// control summarizes the payload of a control PDU.
+func control(payload []byte) string {
+	msg := &proto.ControlMessage{}
+	if _, err := msg.FromBytes(payload); err != nil {
+		return "empty"
+	}
+	name, ok := controlTypes[msg.Type]
+	if !ok {
+		name = fmt.Sprintf("type=%d", msg.Type)
+	}
+
+	switch msg.Type {
+	case proto.ControlHello:
+		hello := &proto.Hello{}
+		if _, err := hello.FromBytes(msg.Body); err == nil {
+			return fmt.Sprintf("%s min=%d max=%d node=%s", name, hello.MinProto, hello.MaxProto, hello.NodeID)
+		}
+	case proto.ControlPing, proto.ControlPong, proto.ControlProbe, proto.ControlProbeAck:
+		if len(msg.Body) >= proto.PingSize {
+			return fmt.Sprintf("%s seq=%d", name, binary.BigEndian.Uint64(msg.Body))
+		}
+	case proto.ControlClose, proto.ControlSub, proto.ControlUnsub, proto.ControlTopicSub, proto.ControlTopicUnsub:
+		return fmt.Sprintf("%s %q", name, msg.Body)
+	}
+
+	return fmt.Sprintf("%s body=%d", name, len(msg.Body))
+}
+
+// payload summarizes the payload of a PDU.
+func payload(f *proto.Frame) string {
+	switch f.Protocol() {
+	case proto.ProtoControl:
+		return control(f.Payload)
+	case proto.ProtoLinkState:
+		if ls, err := proto.DecodeLinkState(f.Payload); err == nil {
+			return fmt.Sprintf("origin=%s seq=%d links=%d", ls.Origin, ls.Sequence, len(ls.Links))
+		}
+	case proto.ProtoData:
+		if d, err := proto.DecodeData(f.Payload); err == nil {
+			return fmt.Sprintf("dest=%s src=%s hops=%d proto=%d payload=%d", d.Dest, d.Source, d.HopLimit, d.Protocol, len(d.Payload))
+		}
+	}
+
+	return fmt.Sprintf("payload=%d", len(f.Payload))
+}
+
+// describe summarizes a PDU.
+func describe(pdu []byte) string {
+	f, err := proto.Decode(pdu)
+	if err != nil {
+		return fmt.Sprintf("malformed: %s", err)
+	}
+
+	parts := []string{proto.ProtocolName(f.Protocol())}
+	if f.Header.Reply {
+		parts = append(parts, "reply")
+	}
+	if f.Header.Error {
+		parts = append(parts, "error")
+	}
+	for _, ext := range f.Extensions {
+		parts = append(parts, extension(ext))
+	}
+
+	return strings.Join(append(parts, payload(f)), " ")
+}
+
+// options describes the options of humboldt-dump.
+type options struct {
+	only    int64 // Conduit whose PDUs are printed, if not negative
+	verbose bool  // Follow each PDU with its decoded structure
+	hexDump bool  // Follow each PDU with a hexadecimal dump
+}
+
+// dump prints the PDUs recorded in a capture.
+func dump(r io.Reader, out io.Writer, opts *options) error {
+	cr := conduit.NewCaptureReader(r)
+	for {
+		rec, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if opts.only >= 0 && int64(rec.Conduit) != opts.only {
+			continue
+		}
+
+		name := rec.Name
+		if name == "" {
+			name = "-"
+		}
+		if rec.Peer != "" {
+			name += " [" + rec.Peer + "]"
+		}
+		fmt.Fprintf(out, "%s #%d %s %-3s len=%d %s\n",
+			rec.Time.UTC().Format(time.RFC3339Nano), rec.Conduit, name,
+			rec.Direction, len(rec.PDU), describe(rec.PDU))
+		if opts.verbose {
+			if f, err := proto.Decode(rec.PDU); err == nil {
+				fmt.Fprint(out, proto.Dump(f))
+			}
+		}
+		if opts.hexDump {
+			fmt.Fprint(out, hex.Dump(rec.PDU))
+		}
+	}
+}
+
+// run runs humboldt-dump with the specified arguments, reading the
+// standard input from in and writing the PDUs to out.
+func run(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("humboldt-dump", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts := &options{}
+	flags.Int64Var(&opts.only, "conduit", -1, "Conduit whose PDUs are printed")
+	flags.BoolVar(&opts.verbose, "v", false, "Follow each PDU with its decoded structure")
+	flags.BoolVar(&opts.hexDump, "x", false, "Follow each PDU with a hexadecimal dump")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, path := range files {
+		if path == "-" {
+			if err := dump(in, out, opts); err != nil {
+				return fmt.Errorf("stdin: %w", err)
+			}
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = dump(f, out, opts)
+		f.Close() //nolint:errcheck
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "humboldt-dump: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// writeCapture captures the sending of the frames over a conduit,
+// returning the capture.
+func writeCapture(t *testing.T, frames ...*proto.Frame) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	cp, err := conduit.NewCapture(buf)
+	require.NoError(t, err)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go io.Copy(io.Discard, c2) //nolint:errcheck
+	defer c2.Close()
+	uri, err := conduit.Parse("tcp://127.0.0.1:1234")
+	require.NoError(t, err)
+	c := &conduit.Conduit{Link: c1, RemoteURI: uri, Peer: proto.NodeID{2}, Integrity: true}
+	c.SetCapture(cp)
+	for _, f := range frames {
+		require.NoError(t, c.Send(f))
+	}
+	require.NoError(t, cp.Close())
+
+	return buf.Bytes()
+}
+
+// encode encodes a frame.
+func encode(t *testing.T, f *proto.Frame) []byte {
+	t.Helper()
+
+	pdu := make([]byte, f.Size())
+	_, err := f.ToBytes(pdu)
+	require.NoError(t, err)
+
+	return pdu
+}
+
+func TestDescribeControl(t *testing.T) {
+	body := make([]byte, proto.PingSize)
+	binary.BigEndian.PutUint64(body, 42)
+	f := (&proto.ControlMessage{Type: proto.ControlPing, Body: body}).Frame()
+	pdu := encode(t, f)
+
+	result := describe(pdu)
+
+	assert.Equal(t, "control(0) ping seq=42", result)
+}
+
+func TestDescribeClose(t *testing.T) {
+	f := (&proto.ControlMessage{Type: proto.ControlClose, Body: []byte("bye")}).Frame()
+	pdu := encode(t, f)
+
+	result := describe(pdu)
+
+	assert.Equal(t, `control(0) close "bye"`, result)
+}
+
+func TestDescribeData(t *testing.T) {
+	payload, err := (&proto.Data{
+		Dest:     proto.NodeID{1},
+		Source:   proto.NodeID{2},
+		HopLimit: 8,
+		Protocol: 42,
+		Payload:  []byte("hello"),
+	}).Encode()
+	require.NoError(t, err)
+	req := &proto.Request{ID: 7}
+	f := &proto.Frame{
+		Header:     proto.Header{Reply: true},
+		Extensions: proto.Extensions{req.Extension()},
+		Payload:    payload,
+	}
+	f.Header.Protocol = f.Extensions.Link(proto.ProtoData)
+	pdu := encode(t, f)
+
+	result := describe(pdu)
+
+	assert.Equal(t, "data(3) reply request(id=7) dest="+proto.NodeID{1}.String()+
+		" src="+proto.NodeID{2}.String()+" hops=8 proto=42 payload=5", result)
+}
+
+func TestDescribeUnknown(t *testing.T) {
+	f := &proto.Frame{
+		Extensions: proto.Extensions{{Number: 0x90, Data: []byte{1, 2}}},
+		Payload:    []byte("abc"),
+	}
+	f.Header.Protocol = f.Extensions.Link(99)
+	pdu := encode(t, f)
+
+	result := describe(pdu)
+
+	assert.Equal(t, "unknown(99) ext=0x90(len=2) payload=3", result)
+}
+
+func TestDescribeExtensions(t *testing.T) {
+	ck, err := proto.ComputeChecksum(proto.ChecksumCRC32C, []byte("abc"))
+	require.NoError(t, err)
+	f := &proto.Frame{
+		Header: proto.Header{Error: true},
+		Extensions: proto.Extensions{
+			(&proto.Flood{Origin: proto.NodeID{1}, Sequence: 5, TTL: 3}).Extension(),
+			ck.Extension(),
+			(&proto.Channel{ID: 9, Flags: 0x01}).Extension(),
+			{Number: proto.ExtFlood},
+		},
+		Payload: []byte("abc"),
+	}
+	f.Header.Protocol = f.Extensions.Link(proto.ProtoGossip)
+	pdu := encode(t, f)
+
+	result := describe(pdu)
+
+	assert.Equal(t, "gossip(2) error flood(origin="+proto.NodeID{1}.String()+" seq=5 ttl=3) checksum(alg=1) channel(id=9 flags=0x01) ext=0x80(len=0) payload=3", result)
+}
+
+func TestDescribeHello(t *testing.T) {
+	hello := &proto.Hello{MinProto: 1, MaxProto: 2, NodeID: proto.NodeID{3}}
+	body := make([]byte, hello.Size())
+	_, err := hello.ToBytes(body)
+	require.NoError(t, err)
+	pdu := encode(t, (&proto.ControlMessage{Type: proto.ControlHello, Body: body}).Frame())
+
+	result := describe(pdu)
+
+	assert.Equal(t, "control(0) hello min=1 max=2 node="+proto.NodeID{3}.String(), result)
+}
+
+func TestDescribeControlEmpty(t *testing.T) {
+	pdu := encode(t, &proto.Frame{Header: proto.Header{Protocol: proto.ProtoControl}})
+
+	result := describe(pdu)
+
+	assert.Equal(t, "control(0) empty", result)
+}
+
+func TestDescribeControlUnknown(t *testing.T) {
+	pdu := encode(t, (&proto.ControlMessage{Type: 200, Body: []byte("ab")}).Frame())
+
+	result := describe(pdu)
+
+	assert.Equal(t, "control(0) type=200 body=2", result)
+}
+
+func TestDescribeLinkState(t *testing.T) {
+	f, err := (&proto.LinkState{Origin: proto.NodeID{1}, Sequence: 4, Links: []proto.Link{{}}}).Frame()
+	require.NoError(t, err)
+	pdu := encode(t, f)
+
+	result := describe(pdu)
+
+	assert.Equal(t, "linkstate(1) origin="+proto.NodeID{1}.String()+" seq=4 links=1", result)
+}
+
+func TestDescribeMalformed(t *testing.T) {
+	result := describe([]byte{0})
+
+	assert.True(t, strings.HasPrefix(result, "malformed: "))
+}
+
+func TestRunBase(t *testing.T) {
+	data := writeCapture(t, &proto.Frame{
+		Header:  proto.Header{Protocol: proto.ProtoGossip},
+		Payload: []byte("hello"),
+	})
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	out := &bytes.Buffer{}
+
+	err := run([]string{path}, nil, out)
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), " #0 tcp://127.0.0.1:1234 ["+proto.NodeID{2}.String()+"] out len=9 gossip(2) payload=5\n")
+}
+
+func TestRunStdinHex(t *testing.T) {
+	data := writeCapture(t, &proto.Frame{
+		Header:  proto.Header{Protocol: proto.ProtoGossip},
+		Payload: []byte("hello"),
+	})
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-x", "-"}, bytes.NewReader(data), out)
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "gossip(2) payload=5\n00000000  00 02 00 05 68 65 6c 6c  6f")
+}
+
+func TestRunVerbose(t *testing.T) {
+	data := writeCapture(t, &proto.Frame{
+		Header:  proto.Header{Protocol: proto.ProtoGossip},
+		Payload: []byte("hello"),
+	})
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-v"}, bytes.NewReader(data), out)
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "gossip(2) payload=5\nheader: major=0 protocol=gossip(2) length=5 flags=-\n")
+}
+
+func TestRunConduit(t *testing.T) {
+	data := writeCapture(t, &proto.Frame{
+		Header:  proto.Header{Protocol: proto.ProtoGossip},
+		Payload: []byte("hello"),
+	})
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-conduit", "1"}, bytes.NewReader(data), out)
+
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+}
+
+func TestRunBadCapture(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := run(nil, strings.NewReader("not a capture file"), out)
+
+	assert.ErrorIs(t, err, conduit.ErrBadCapture)
+}
+
+func TestRunMissing(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := run([]string{filepath.Join(t.TempDir(), "missing")}, nil, out)
+
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRunUsage(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-bogus"}, nil, out)
+
+	assert.ErrorIs(t, err, ErrUsage)
+}
+
+func TestRunAnonymous(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cp, err := conduit.NewCapture(buf)
+	require.NoError(t, err)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go io.Copy(io.Discard, c2) //nolint:errcheck
+	defer c2.Close()
+	c := &conduit.Conduit{Link: c1, Integrity: true}
+	c.SetCapture(cp)
+	require.NoError(t, c.Send(&proto.Frame{Header: proto.Header{Protocol: proto.ProtoGossip}}))
+	require.NoError(t, cp.Close())
+	out := &bytes.Buffer{}
+
+	err = run(nil, buf, out)
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), " #0 - out ")
+}
+
+func TestRunBadCaptureFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	require.NoError(t, os.WriteFile(path, []byte("not a capture file"), 0o644))
+	out := &bytes.Buffer{}
+
+	err := run([]string{path}, nil, out)
+
+	assert.ErrorIs(t, err, conduit.ErrBadCapture)
+	assert.ErrorContains(t, err, path)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Command humboldt runs a Humboldt node.  The node is configured by
+// the file given with the -config flag, with environment variable
+// overrides applied as described by the config package; see
+// node.Config for the keys configuring the node itself.
+//
+// With the -check flag, the configuration is checked without starting
+// the node: the listen and peer URIs are parsed, their mechanisms are
+// looked up, their query parameters are converted into listener or
+// dialer options, and they are canonicalized, as by
+// conduit.CheckListen and conduit.CheckDial.  A report line is
+// printed for each URI, and the command exits with a non-zero status
+// if any check failed.
+//
+// Sending SIGHUP causes the configuration file to be reloaded and
+// applied to the running node; sending SIGTERM or SIGINT causes the
+// node to shut down gracefully.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/node"
+)
+
+// ErrUsage is returned if humboldt is invoked incorrectly.
+var ErrUsage = errors.New("usage: humboldt [-check] [-config FILE]")
+
+// run runs a node with the configuration file at the specified path
+// until a terminating signal is received, in which case the node is
+// shut down gracefully, or the context is cancelled.
+func run(ctx context.Context, path string, sigs <-chan os.Signal, log *slog.Logger) error {
+	cfg, err := node.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	n, err := node.New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := n.Start(ctx); err != nil {
+		return err
+	}
+	defer n.Stop()
+	log.Info("node started", "node_id", n.ID, "listen", n.Addrs())
+
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				log.Info("shutting down", "signal", sig)
+				if err := n.Shutdown(ctx); err != nil {
+					log.Warn("traffic did not drain", "err", err)
+				}
+				return nil
+			}
+
+			cfg, err := node.LoadConfig(path)
+			if err != nil {
+				log.Error("failed to reload configuration", "err", err)
+				continue
+			}
+			n.Reload(cfg)
+			log.Info("configuration reloaded")
+
+		case <-ctx.Done():
+			log.Info("shutting down", "err", ctx.Err())
+			return nil
+		}
+	}
+}
+
+// check checks the listen and peer URIs of the configuration file at
+// the specified path, without starting a node, and writes a report
+// line for each URI to the writer.  An error wrapping
+// conduit.ErrCheckFailed is returned if any URI failed its check.
+func check(path string, out io.Writer) error {
+	cfg, err := node.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	listens, errListen := conduit.CheckListen(cfg.Listen...)
+	peers, errDial := conduit.CheckDial(cfg.Peers...)
+	for _, result := range append(listens, peers...) {
+		fmt.Fprintln(out, result)
+	}
+
+	return errors.Join(errListen, errDial)
+}
+
+// humboldt runs humboldt with the specified arguments, writing the
+// check report to the writer.  Unless only checking the
+// configuration, the node is run as by run.
+func humboldt(ctx context.Context, args []string, out io.Writer, sigs <-chan os.Signal, log *slog.Logger) error {
+	flags := flag.NewFlagSet("humboldt", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	path := flags.String("config", "", "Path of the configuration file")
+	dryRun := flags.Bool("check", false, "Check the configuration without starting the node")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+	if flags.NArg() != 0 {
+		return ErrUsage
+	}
+
+	if *dryRun {
+		return check(*path, out)
+	}
+
+	return run(ctx, *path, sigs, log)
+}
+
+func main() {
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	conduit.SetLogger(log)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	if err := humboldt(context.Background(), os.Args[1:], os.Stdout, sigs, log); err != nil {
+		fmt.Fprintf(os.Stderr, "humboldt: %s\n", err)
+		os.Exit(1)
+	}
+}
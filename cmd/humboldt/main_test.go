@@ -0,0 +1,172 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestRunBase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "humboldt.yml")
+	idFile := filepath.Join(dir, "node-id")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [mem:cmd-run]\nnode_id_file: "+idFile+"\n"), 0o644))
+	sigs := make(chan os.Signal)
+	done := make(chan error, 1)
+	go func() {
+		done <- run(context.Background(), path, sigs, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}()
+
+	sigs <- syscall.SIGHUP
+	require.NoError(t, os.WriteFile(path, []byte("bogus: [\n"), 0o644))
+	sigs <- syscall.SIGHUP
+	sigs <- syscall.SIGTERM
+
+	assert.NoError(t, <-done)
+	id, err := proto.LoadNodeID(idFile)
+	assert.NoError(t, err)
+	assert.False(t, id.IsZero())
+}
+
+func TestRunContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [mem:cmd-run-context]\n"), 0o644))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := run(ctx, path, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.NoError(t, err)
+}
+
+func TestRunConfigError(t *testing.T) {
+	err := run(context.Background(), "humboldt.toml", nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Error(t, err)
+}
+
+func TestRunListenError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [bogus://]\n"), 0o644))
+
+	err := run(context.Background(), path, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.ErrorContains(t, err, "bogus://")
+}
+
+func TestCheckBase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [mem:cmd-check]\npeers: [mem:cmd-check-peer]\n"), 0o644))
+	out := &bytes.Buffer{}
+
+	err := check(path, out)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "mem:cmd-check: OK: [mem:cmd-check]\nmem:cmd-check-peer: OK: [mem:cmd-check-peer]\n", out.String())
+}
+
+func TestCheckFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [mem:cmd-check-failed]\npeers: [bogus://]\n"), 0o644))
+	out := &bytes.Buffer{}
+
+	err := check(path, out)
+
+	assert.ErrorIs(t, err, conduit.ErrCheckFailed)
+	assert.Contains(t, out.String(), "mem:cmd-check-failed: OK")
+	assert.Contains(t, out.String(), "bogus://: FAILED")
+}
+
+func TestCheckOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [\"mem:cmd-check-listen?connect_timeout=1s\"]\npeers: [\"mem:cmd-check-peer?keepalive=notaduration\"]\n"), 0o644))
+	out := &bytes.Buffer{}
+
+	err := check(path, out)
+
+	assert.ErrorIs(t, err, conduit.ErrCheckFailed)
+	assert.Contains(t, out.String(), "mem:cmd-check-listen?connect_timeout=1s: FAILED")
+	assert.Contains(t, out.String(), conduit.ErrUnknownOption.Error())
+	assert.Contains(t, out.String(), "mem:cmd-check-peer?keepalive=notaduration: FAILED")
+	assert.Contains(t, out.String(), conduit.ErrBadOption.Error())
+}
+
+func TestCheckConfigError(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := check("humboldt.toml", out)
+
+	assert.Error(t, err)
+	assert.Empty(t, out.String())
+}
+
+func TestRunNodeError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("node_id_file: "+filepath.Join(path, "node-id")+"\n"), 0o644))
+
+	err := run(context.Background(), path, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Error(t, err)
+}
+
+func TestHumboldtCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [mem:cmd-humboldt-check]\n"), 0o644))
+	out := &bytes.Buffer{}
+
+	err := humboldt(context.Background(), []string{"-check", "-config", path}, out, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "mem:cmd-humboldt-check: OK: [mem:cmd-humboldt-check]\n", out.String())
+}
+
+func TestHumboldtRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "humboldt.yml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: [mem:cmd-humboldt-run]\n"), 0o644))
+	sigs := make(chan os.Signal, 1)
+	sigs <- syscall.SIGINT
+	out := &bytes.Buffer{}
+
+	err := humboldt(context.Background(), []string{"-config", path}, out, sigs, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+}
+
+func TestHumboldtBadFlag(t *testing.T) {
+	err := humboldt(context.Background(), []string{"-bogus"}, &bytes.Buffer{}, nil, nil)
+
+	assert.ErrorIs(t, err, ErrUsage)
+}
+
+func TestHumboldtExtraArgs(t *testing.T) {
+	err := humboldt(context.Background(), []string{"-config", "humboldt.yml", "extra"}, &bytes.Buffer{}, nil, nil)
+
+	assert.ErrorIs(t, err, ErrUsage)
+}
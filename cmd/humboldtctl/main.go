@@ -0,0 +1,234 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Command humboldtctl inspects and manages a running Humboldt node
+// through its administrative control socket, which is opened if the
+// node's configuration sets the "admin" key.  The socket address is
+// given with the -admin flag, in the form accepted by
+// node.ListenAdmin.  The commands are:
+//
+//	conduits         lists the open conduits, with their statistics
+//	routes           lists the routes of the routing table
+//	dial URI         adds a peer and dials it
+//	drop NODE-ID     closes the conduit to a peer node
+//	stats            summarizes the statistics of the node
+//	capture FILE     captures the PDUs exchanged by the node to a file
+//	capture-stop     stops capturing PDUs
+//
+// Capture files are written by the node, on its host, and must be new
+// files in the directory named by the capture_dir setting of the
+// node's configuration; a relative path is made absolute with respect
+// to the current directory of humboldtctl.  They may be read with
+// humboldt-dump.
+//
+// With the -json flag, results are written as JSON rather than as
+// tables.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hydralang/humboldt/node"
+	"github.com/hydralang/humboldt/proto"
+	"github.com/hydralang/humboldt/routing"
+)
+
+// DefaultAdmin is the default address of the administrative control
+// socket.
+const DefaultAdmin = "unix:/run/humboldt/admin.sock"
+
+// ErrUsage is returned if humboldtctl is invoked incorrectly.
+var ErrUsage = errors.New("usage: humboldtctl [-admin ADDR] [-json] conduits|routes|dial URI|drop NODE-ID|stats|capture FILE|capture-stop")
+
+// command describes a command.
+type command struct {
+	args int                                                           // Number of arguments
+	exec func(c *node.AdminClient, args []string) (interface{}, error) // Performs the command
+	text func(w io.Writer, result interface{})                         // Writes the result as text
+}
+
+// commands maps the names of the commands to their descriptions.
+var commands = map[string]command{
+	"conduits": {
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			return c.Conduits()
+		},
+		text: conduitsText,
+	},
+	"routes": {
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			return c.Routes()
+		},
+		text: routesText,
+	},
+	"dial": {
+		args: 1,
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			return nil, c.Dial(args[0])
+		},
+	},
+	"drop": {
+		args: 1,
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			id, err := proto.ParseNodeID(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return nil, c.Drop(id)
+		},
+	},
+	"stats": {
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			return c.Stats()
+		},
+		text: statsText,
+	},
+	"capture": {
+		args: 1,
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return nil, c.Capture(path)
+		},
+	},
+	"capture-stop": {
+		exec: func(c *node.AdminClient, args []string) (interface{}, error) {
+			return nil, c.Capture("")
+		},
+	},
+}
+
+// activity formats the time of the last activity on a conduit.
+func activity(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// conduitsText writes a table of conduits.
+func conduitsText(w io.Writer, result interface{}) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tKIND\tREMOTE\tPDUS IN\tPDUS OUT\tBYTES IN\tBYTES OUT\tERRORS\tLAST ACTIVITY")
+	for _, info := range result.([]node.ConduitInfo) {
+		kind := "outbound"
+		switch {
+		case info.Client:
+			kind = "client"
+		case info.Inbound:
+			kind = "inbound"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			info.Peer, kind, info.RemoteURI,
+			info.Stats.PDUsIn, info.Stats.PDUsOut, info.Stats.BytesIn, info.Stats.BytesOut,
+			info.Stats.Errors, activity(info.Stats.LastActivity))
+	}
+	tw.Flush() //nolint:errcheck
+}
+
+// routesText writes a table of routes.
+func routesText(w io.Writer, result interface{}) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEST\tNEXT HOP\tCOST\tHOPS")
+	for _, r := range result.([]routing.Route) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", r.Dest, r.NextHop, r.Cost, r.Hops)
+	}
+	tw.Flush() //nolint:errcheck
+}
+
+// statsText writes the statistics of the node.
+func statsText(w io.Writer, result interface{}) {
+	s := result.(*node.NodeStats)
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "node id:\t%s\n", s.NodeID)
+	fmt.Fprintf(tw, "peers:\t%d\n", s.Peers)
+	fmt.Fprintf(tw, "clients:\t%d\n", s.Clients)
+	fmt.Fprintf(tw, "routes:\t%d\n", s.Routes)
+	fmt.Fprintf(tw, "pdus in:\t%d\n", s.Totals.PDUsIn)
+	fmt.Fprintf(tw, "pdus out:\t%d\n", s.Totals.PDUsOut)
+	fmt.Fprintf(tw, "bytes in:\t%d\n", s.Totals.BytesIn)
+	fmt.Fprintf(tw, "bytes out:\t%d\n", s.Totals.BytesOut)
+	fmt.Fprintf(tw, "errors:\t%d\n", s.Totals.Errors)
+	fmt.Fprintf(tw, "reconnects:\t%d\n", s.Totals.Reconnects)
+	fmt.Fprintf(tw, "last activity:\t%s\n", activity(s.Totals.LastActivity))
+	ids := make([]proto.NodeID, 0, len(s.Latency))
+	for id := range s.Latency {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, proto.NodeID.Compare)
+	for _, id := range ids {
+		h := s.Latency[id]
+		fmt.Fprintf(tw, "latency %s:\tcount %d p50 %s p90 %s p99 %s max %s\n", id, h.Count, h.P50, h.P90, h.P99, h.Max)
+	}
+	tw.Flush() //nolint:errcheck
+}
+
+// run runs humboldtctl with the specified arguments, writing the
+// result to the writer.
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("humboldtctl", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	admin := flags.String("admin", DefaultAdmin, "Address of the administrative control socket")
+	asJSON := flags.Bool("json", false, "Write results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+	if flags.NArg() < 1 {
+		return ErrUsage
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok || flags.NArg() != cmd.args+1 {
+		return ErrUsage
+	}
+
+	// Perform the command
+	c, err := node.DialAdmin(*admin)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	result, err := cmd.exec(c, flags.Args()[1:])
+	if err != nil || cmd.text == nil {
+		return err
+	}
+
+	// Write the result
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	cmd.text(out, result)
+
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "humboldtctl: %s\n", err)
+		os.Exit(1)
+	}
+}
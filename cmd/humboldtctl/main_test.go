@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/node"
+	"github.com/hydralang/humboldt/proto"
+	"github.com/hydralang/humboldt/routing"
+)
+
+// startNode starts a node serving its administrative control socket
+// on a loopback address, returning the address.
+func startNode(t *testing.T, name string, peers ...string) (*node.Node, string) {
+	t.Helper()
+
+	n, err := node.New(&node.Config{
+		Listen: []string{"mem:" + name},
+		Peers:  peers,
+		Admin:  "127.0.0.1:0",
+	})
+	require.NoError(t, err)
+	require.NoError(t, n.Start(context.Background()))
+	t.Cleanup(n.Stop)
+
+	return n, n.AdminAddr().String()
+}
+
+func TestRunConduits(t *testing.T) {
+	startNode(t, "ctl-conduits-b")
+	_, addr := startNode(t, "ctl-conduits-a", "mem:ctl-conduits-b")
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-admin", addr, "conduits"}, out)
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "PEER")
+}
+
+func TestRunRoutesJSON(t *testing.T) {
+	_, addr := startNode(t, "ctl-routes")
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-admin", addr, "-json", "routes"}, out)
+
+	require.NoError(t, err)
+	var result []routing.Route
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+}
+
+func TestRunRoutesText(t *testing.T) {
+	_, addr := startNode(t, "ctl-routes-text")
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-admin", addr, "routes"}, out)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "DEST  NEXT HOP  COST  HOPS\n", out.String())
+}
+
+func TestRunDial(t *testing.T) {
+	n, addr := startNode(t, "ctl-dial")
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-admin", addr, "dial", "mem:ctl-dial-b"}, out)
+
+	assert.NoError(t, err)
+	assert.Empty(t, out.String())
+	require.Len(t, n.Manager.Peers(), 1)
+	assert.Equal(t, "mem:ctl-dial-b", n.Manager.Peers()[0].URI)
+}
+
+func TestRunDropBadNodeID(t *testing.T) {
+	_, addr := startNode(t, "ctl-drop")
+
+	err := run([]string{"-admin", addr, "drop", "bogus"}, &bytes.Buffer{})
+
+	assert.Error(t, err)
+}
+
+func TestRunDropNoConduit(t *testing.T) {
+	_, addr := startNode(t, "ctl-drop-none")
+
+	err := run([]string{"-admin", addr, "drop", "00000000000000000000000000000002"}, &bytes.Buffer{})
+
+	assert.ErrorContains(t, err, node.ErrNoConduit.Error())
+}
+
+func TestRunStats(t *testing.T) {
+	n, addr := startNode(t, "ctl-stats")
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-admin", addr, "stats"}, out)
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "node id:       "+n.ID.String()+"\n")
+	assert.Contains(t, out.String(), "last activity: -\n")
+}
+
+func TestRunStatsLatency(t *testing.T) {
+	n, addr := startNode(t, "ctl-stats-latency")
+	n.Latency.Sent(proto.NodeID{2}, &proto.Header{Protocol: 42}, 7)
+	n.Latency.Received(proto.NodeID{2}, &proto.Header{Reply: true, Protocol: 42}, 7)
+	out := &bytes.Buffer{}
+
+	err := run([]string{"-admin", addr, "stats"}, out)
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "latency "+proto.NodeID{2}.String()+": count 1 p50 ")
+}
+
+func TestRunCapture(t *testing.T) {
+	n, addr := startNode(t, "ctl-capture")
+	dir := t.TempDir()
+	cfg := *n.Config()
+	cfg.CaptureDir = dir
+	n.Reload(&cfg)
+	t.Chdir(dir)
+
+	err := run([]string{"-admin", addr, "capture", "capture.pcapng"}, &bytes.Buffer{})
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "capture.pcapng"))
+	assert.NoError(t, run([]string{"-admin", addr, "capture-stop"}, &bytes.Buffer{}))
+}
+
+func TestRunCaptureError(t *testing.T) {
+	_, addr := startNode(t, "ctl-capture-error")
+
+	err := run([]string{"-admin", addr, "capture", filepath.Join(t.TempDir(), "capture.pcapng")}, &bytes.Buffer{})
+
+	assert.ErrorContains(t, err, node.ErrCaptureDenied.Error())
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-bogus"},
+		{"bogus"},
+		{"dial"},
+		{"capture"},
+		{"stats", "extra"},
+	} {
+		err := run(args, &bytes.Buffer{})
+
+		assert.ErrorIs(t, err, ErrUsage, args)
+	}
+}
+
+func TestRunDialAdminError(t *testing.T) {
+	err := run([]string{"-admin", "10.0.0.1:7070", "stats"}, &bytes.Buffer{})
+
+	assert.ErrorIs(t, err, node.ErrAdminAddr)
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSourceBuckets is the number of per-source token buckets kept by
+// a listener before idle buckets are discarded.
+const maxSourceBuckets = 1024
+
+// AcceptFilter is an option for Listen that examines each conduit
+// accepted by the transport, before any sniffing or security layer
+// handshake, returning an error to reject it.  Rejected conduits are
+// closed, and Accept returns an AcceptError with reason
+// AcceptRejected wrapping the error, which Server ignores.  Several
+// filters may be given; they are applied in order.
+type AcceptFilter func(c *Conduit) error
+
+// ListenApply applies the option to a net.ListenConfig.  The option
+// does not modify the configuration; it is applied by Listen.
+func (af AcceptFilter) ListenApply(lc *net.ListenConfig) {}
+
+// remoteIP returns the IP address of the remote end of a conduit, or
+// nil if it does not have one.
+func remoteIP(c *Conduit) net.IP {
+	if c.RemoteURI == nil {
+		return nil
+	}
+	ip, _ := parseIP(c.RemoteURI.Hostname())
+
+	return ip
+}
+
+// containsIP tests whether any of a list of networks contains an IP
+// address.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowSources returns an AcceptFilter rejecting conduits whose
+// remote addresses are not within any of the specified networks.
+// Conduits without a remote IP address, such as those of the mem
+// transport, are rejected.
+func AllowSources(nets ...*net.IPNet) AcceptFilter {
+	return func(c *Conduit) error {
+		if ip := remoteIP(c); ip == nil || !containsIP(nets, ip) {
+			return ErrSourceDenied
+		}
+
+		return nil
+	}
+}
+
+// DenySources returns an AcceptFilter rejecting conduits whose remote
+// addresses are within any of the specified networks.
+func DenySources(nets ...*net.IPNet) AcceptFilter {
+	return func(c *Conduit) error {
+		if ip := remoteIP(c); ip != nil && containsIP(nets, ip) {
+			return ErrSourceDenied
+		}
+
+		return nil
+	}
+}
+
+// ParseSources parses a comma-separated list of networks in CIDR
+// notation, such as "10.0.0.0/8,2001:db8::/32", for use with
+// AllowSources and DenySources.  A bare IP address denotes a network
+// containing only that address.  If an entry cannot be parsed, an
+// error wrapping ErrBadSource is returned.
+func ParseSources(value string) ([]*net.IPNet, error) {
+	result := []*net.IPNet{}
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%q: %w", s, ErrBadSource)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w: %w", s, ErrBadSource, err)
+		}
+		result = append(result, n)
+	}
+
+	return result, nil
+}
+
+// SourceRate is an option for Listen that limits the rate at which
+// conduits are accepted from each remote IP address.  Conduits
+// arriving while the limit for their source is exceeded are closed,
+// and Accept returns an AcceptError with reason AcceptRejected
+// wrapping ErrAcceptRate.  Like AcceptRate, the limit is applied
+// before any security layer handshake.
+type SourceRate Rate
+
+// ListenApply applies the option to a net.ListenConfig.  The option
+// does not modify the configuration; it is applied by Listen.
+func (sr SourceRate) ListenApply(lc *net.ListenConfig) {}
+
+// Defaults for the handshakes of listeners with a security layer.
+const (
+	DefaultMaxHandshakes    = 16               // Handshakes run at a time
+	DefaultHandshakeTimeout = 10 * time.Second // Time allowed for each handshake
+)
+
+// MaxHandshakes is an option for Listen that runs the security layer
+// handshakes of accepted conduits concurrently, up to the specified
+// number at a time, so that peers slow to complete their handshakes
+// do not hold up the others.  No further conduits are accepted from
+// the transport while the limit is reached.  Listeners with a
+// security layer default to DefaultMaxHandshakes; a value of 0 runs
+// the handshakes one at a time.
+type MaxHandshakes int
+
+// ListenApply applies the option to a net.ListenConfig.  The option
+// does not modify the configuration; it is applied by Listen.
+func (mh MaxHandshakes) ListenApply(lc *net.ListenConfig) {}
+
+// ListenApply applies the option to a net.ListenConfig.  For Listen,
+// HandshakeTimeout sets a deadline on each conduit accepted by the
+// transport, bounding the time allowed for its security layer
+// handshake, including any sniffing, before the conduit is returned
+// by Accept; the deadline is cleared once the handshake completes.
+// Listeners with a security layer default to DefaultHandshakeTimeout;
+// a value of 0 disables the deadline.  The option does not modify the
+// configuration; it is applied by Listen.
+func (ht HandshakeTimeout) ListenApply(lc *net.ListenConfig) {}
+
+// filterListen wraps a transport listener to apply any AcceptFilter
+// and SourceRate options present in the options.
+func filterListen(l Listener, opts []ListenerOption) Listener {
+	var filters []AcceptFilter
+	for _, opt := range opts {
+		if af, ok := opt.(AcceptFilter); ok && af != nil {
+			filters = append(filters, af)
+		}
+	}
+	var rate Rate
+	if sr := findOption[SourceRate](opts); sr != nil {
+		rate = Rate(*sr)
+	}
+	if len(filters) == 0 && rate.Rate <= 0 {
+		return l
+	}
+
+	return &filterListener{Listener: l, filters: filters, rate: rate}
+}
+
+// filterListener is a Listener applying filters and per-source rate
+// limits to the conduits accepted by the wrapped listener.
+type filterListener struct {
+	Listener
+
+	filters []AcceptFilter // The filters to apply
+
+	lock    sync.Mutex              // Protects the buckets
+	rate    Rate                    // The per-source accept rate
+	buckets map[string]*tokenBucket // Buckets by source
+}
+
+// allow takes a token from the bucket of a source, returning false
+// if none is available.  Once there are many buckets, those that have
+// refilled are discarded, as they are indistinguishable from new
+// buckets.
+func (l *filterListener) allow(source string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.buckets) >= maxSourceBuckets {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate.Rate >= l.rate.burst() {
+				delete(l.buckets, key)
+			}
+		}
+	}
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	b, ok := l.buckets[source]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[source] = b
+	}
+
+	return b.allow(l.rate, now)
+}
+
+// check applies the filters and per-source rate limit to a conduit.
+func (l *filterListener) check(c *Conduit) error {
+	for _, filter := range l.filters {
+		if err := filter(c); err != nil {
+			return err
+		}
+	}
+
+	if l.rate.Rate > 0 {
+		source := ""
+		if c.RemoteURI != nil {
+			source = c.RemoteURI.Hostname()
+		}
+		if !l.allow(source, timeNow()) {
+			return ErrAcceptRate
+		}
+	}
+
+	return nil
+}
+
+// Accept waits for and returns the next conduit to the listener.
+func (l *filterListener) Accept() (*Conduit, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.check(c); err != nil {
+		c.Close() //nolint:errcheck
+		return nil, &AcceptError{Reason: AcceptRejected, RemoteURI: c.RemoteURI, Err: err}
+	}
+
+	return c, nil
+}
+
+// deadlineListen wraps a transport listener to apply any
+// HandshakeTimeout option present in the options.
+func deadlineListen(l Listener, opts []ListenerOption) Listener {
+	ht := findOption[HandshakeTimeout](opts)
+	if ht == nil || *ht <= 0 {
+		return l
+	}
+
+	return &deadlineListener{Listener: l, timeout: time.Duration(*ht)}
+}
+
+// deadlineListener is a Listener setting a deadline on the conduits
+// accepted by the wrapped listener, bounding the time allowed for the
+// security layer handshake.  The deadline is cleared by the
+// handshakeListener wrapping the security layer.
+type deadlineListener struct {
+	Listener
+
+	timeout time.Duration // The time allowed for the handshake
+}
+
+// Accept waits for and returns the next conduit to the listener.
+func (l *deadlineListener) Accept() (*Conduit, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Link != nil {
+		c.Link.SetDeadline(time.Now().Add(l.timeout)) //nolint:errcheck
+	}
+
+	return c, nil
+}
+
+// handshakeDefaults returns the options applied by default to
+// listeners with a security layer, so that no peer may hold up the
+// handshakes of the others for long.  Options passed to Listen
+// override them.
+func handshakeDefaults() []ListenerOption {
+	return []ListenerOption{
+		MaxHandshakes(DefaultMaxHandshakes),
+		HandshakeTimeout(DefaultHandshakeTimeout),
+	}
+}
+
+// handshakeListen wraps a listener, including any security layer, to
+// apply any MaxHandshakes and HandshakeTimeout options present in the
+// options.
+func handshakeListen(l Listener, opts []ListenerOption) Listener {
+	workers := 0
+	if mh := findOption[MaxHandshakes](opts); mh != nil {
+		workers = int(*mh)
+	}
+	ht := findOption[HandshakeTimeout](opts)
+	deadline := ht != nil && *ht > 0
+	if workers <= 0 && !deadline {
+		return l
+	}
+
+	result := &handshakeListener{
+		Listener: l,
+		deadline: deadline,
+		results:  make(chan acceptResult),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	result.wg.Add(max(workers, 1))
+	for range max(workers, 1) {
+		go result.work()
+	}
+	go func() {
+		result.wg.Wait()
+		close(result.exited)
+	}()
+
+	return result
+}
+
+// handshakeListener is a Listener accepting conduits from the
+// wrapped listener, and so running their handshakes, in several
+// goroutines at once.  It clears the deadlines set by
+// deadlineListener once the handshakes complete.
+type handshakeListener struct {
+	Listener
+
+	deadline bool              // Deadlines must be cleared
+	results  chan acceptResult // Results of the workers
+	done     chan struct{}     // Closed when the listener is closed
+	exited   chan struct{}     // Closed when the workers have exited
+	wg       sync.WaitGroup    // Tracks the workers
+	errOnce  sync.Once         // Controls setting err
+	err      error             // The error stopping the workers
+	stop     sync.Once         // Controls closing done
+}
+
+// work accepts conduits from the wrapped listener until it fails with
+// an error that may not be retried or the listener is closed.
+func (l *handshakeListener) work() {
+	defer l.wg.Done()
+
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil && !ClassifyAccept(err).Retry() {
+			l.errOnce.Do(func() { l.err = err })
+			return
+		}
+		if c != nil && l.deadline && c.Link != nil {
+			c.Link.SetDeadline(time.Time{}) //nolint:errcheck
+		}
+
+		select {
+		case l.results <- acceptResult{c: c, err: err}:
+		case <-l.done:
+			if c != nil {
+				closeLink(c)
+			}
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next conduit to the listener.
+// Conduits failing the handshake or rejected are reported as the
+// wrapped listener reports them.
+func (l *handshakeListener) Accept() (*Conduit, error) {
+	select {
+	case res := <-l.results:
+		return res.c, res.err
+	case <-l.exited:
+		return nil, l.err
+	}
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors, and conduits whose handshakes complete
+// afterwards are closed.
+func (l *handshakeListener) Close() error {
+	l.stop.Do(func() { close(l.done) })
+
+	return l.Listener.Close()
+}
@@ -0,0 +1,414 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteConduit constructs a conduit over a pipe with the specified
+// remote URI.
+func remoteConduit(t *testing.T, uri string) (*Conduit, net.Conn) {
+	c, remote := pipeConduit(t)
+	if uri != "" {
+		c.RemoteURI = mustParse(uri)
+	}
+
+	return c, remote
+}
+
+// mustSources parses a list of networks, panicking on error.
+func mustSources(value string) []*net.IPNet {
+	nets, err := ParseSources(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return nets
+}
+
+// blockingListener is a Listener whose first Accept waits for a
+// release before returning.
+type blockingListener struct {
+	*chanListener
+
+	release chan struct{} // Closed to release the first Accept
+	first   chan bool     // Holds a value until the first Accept
+}
+
+func newBlockingListener() *blockingListener {
+	result := &blockingListener{
+		chanListener: newChanListener(),
+		release:      make(chan struct{}),
+		first:        make(chan bool, 1),
+	}
+	result.first <- true
+
+	return result
+}
+
+func (l *blockingListener) Accept() (*Conduit, error) {
+	select {
+	case <-l.first:
+		c, err := l.chanListener.Accept()
+		<-l.release
+		return c, err
+	default:
+		return l.chanListener.Accept()
+	}
+}
+
+func TestAcceptFilterListenApply(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	AcceptFilter(nil).ListenApply(lc)
+
+	assert.Equal(t, &net.ListenConfig{}, lc)
+}
+
+func TestSourceRateListenApply(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	SourceRate{}.ListenApply(lc)
+
+	assert.Equal(t, &net.ListenConfig{}, lc)
+}
+
+func TestMaxHandshakesListenApply(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	MaxHandshakes(DefaultMaxHandshakes).ListenApply(lc)
+
+	assert.Equal(t, &net.ListenConfig{}, lc)
+}
+
+func TestHandshakeTimeoutListenApply(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	HandshakeTimeout(DefaultHandshakeTimeout).ListenApply(lc)
+
+	assert.Equal(t, &net.ListenConfig{}, lc)
+}
+
+func TestAllowSources(t *testing.T) {
+	filter := AllowSources(mustSources("10.0.0.0/8,::1")...)
+	allowed, _ := remoteConduit(t, "tcp://10.1.2.3:1234")
+	allowed6, _ := remoteConduit(t, "tcp://[::1]:1234")
+	denied, _ := remoteConduit(t, "tcp://192.168.1.1:1234")
+	unknown, _ := remoteConduit(t, "")
+
+	assert.NoError(t, filter(allowed))
+	assert.NoError(t, filter(allowed6))
+	assert.Same(t, ErrSourceDenied, filter(denied))
+	assert.Same(t, ErrSourceDenied, filter(unknown))
+}
+
+func TestDenySources(t *testing.T) {
+	filter := DenySources(mustSources("10.0.0.0/8")...)
+	allowed, _ := remoteConduit(t, "tcp://192.168.1.1:1234")
+	denied, _ := remoteConduit(t, "tcp://10.1.2.3:1234")
+	unknown, _ := remoteConduit(t, "")
+
+	assert.NoError(t, filter(allowed))
+	assert.Same(t, ErrSourceDenied, filter(denied))
+	assert.NoError(t, filter(unknown))
+}
+
+func TestParseSources(t *testing.T) {
+	result, err := ParseSources(" 10.0.0.0/8, 192.168.1.1,,2001:db8::1 ")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1/32", "2001:db8::1/128"}, []string{
+		result[0].String(), result[1].String(), result[2].String(),
+	})
+}
+
+func TestParseSourcesErrors(t *testing.T) {
+	for _, value := range []string{"bogus", "10.0.0.0/33"} {
+		result, err := ParseSources(value)
+
+		assert.ErrorIs(t, err, ErrBadSource, value)
+		assert.Nil(t, result, value)
+	}
+}
+
+func TestFilterListenBase(t *testing.T) {
+	l := newChanListener()
+	filter := DenySources()
+
+	result := filterListen(l, []ListenerOption{KeepAlive(0), filter, SourceRate{Rate: 5}})
+
+	require.IsType(t, &filterListener{}, result)
+	assert.Same(t, l, result.(*filterListener).Listener)
+	assert.Len(t, result.(*filterListener).filters, 1)
+	assert.Equal(t, Rate{Rate: 5}, result.(*filterListener).rate)
+}
+
+func TestFilterListenNoOption(t *testing.T) {
+	l := newChanListener()
+
+	result := filterListen(l, []ListenerOption{KeepAlive(0), SourceRate{}})
+
+	assert.Same(t, l, result)
+}
+
+func TestFilterListenerAcceptBase(t *testing.T) {
+	l := newChanListener()
+	c, _ := remoteConduit(t, "tcp://10.0.0.1:1234")
+	obj := &filterListener{Listener: l, filters: []AcceptFilter{DenySources(mustSources("192.168.0.0/16")...)}}
+	go func() { l.conduits <- c }()
+
+	result, err := obj.Accept()
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+}
+
+func TestFilterListenerAcceptRejected(t *testing.T) {
+	l := newChanListener()
+	c, r := remoteConduit(t, "tcp://192.168.1.1:1234")
+	obj := &filterListener{Listener: l, filters: []AcceptFilter{DenySources(mustSources("192.168.0.0/16")...)}}
+	go func() { l.conduits <- c }()
+
+	result, err := obj.Accept()
+
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrSourceDenied)
+	var acceptErr *AcceptError
+	require.ErrorAs(t, err, &acceptErr)
+	assert.Equal(t, AcceptRejected, acceptErr.Reason)
+	assert.Same(t, c.RemoteURI, acceptErr.RemoteURI)
+	_, err = r.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestFilterListenerAcceptSourceRate(t *testing.T) {
+	l := newChanListener()
+	c1, _ := remoteConduit(t, "tcp://10.0.0.1:1234")
+	c2, _ := remoteConduit(t, "tcp://10.0.0.2:1234")
+	c3, _ := remoteConduit(t, "tcp://10.0.0.1:1235")
+	obj := &filterListener{Listener: l, rate: Rate{Rate: 0.001}}
+	go func() {
+		l.conduits <- c1
+		l.conduits <- c2
+		l.conduits <- c3
+	}()
+
+	result1, err1 := obj.Accept()
+	result2, err2 := obj.Accept()
+	result3, err3 := obj.Accept()
+
+	assert.NoError(t, err1)
+	assert.Same(t, c1, result1)
+	assert.NoError(t, err2)
+	assert.Same(t, c2, result2)
+	assert.ErrorIs(t, err3, ErrAcceptRate)
+	assert.Equal(t, AcceptRejected, ClassifyAccept(err3))
+	assert.Nil(t, result3)
+}
+
+func TestFilterListenerAcceptError(t *testing.T) {
+	l := newChanListener()
+	l.Close()
+	obj := &filterListener{Listener: l, filters: []AcceptFilter{DenySources()}}
+
+	result, err := obj.Accept()
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, result)
+}
+
+func TestFilterListenerAllowPrunes(t *testing.T) {
+	now := time.Unix(1000, 0)
+	obj := &filterListener{rate: Rate{Rate: 1}, buckets: map[string]*tokenBucket{}}
+	for i := range maxSourceBuckets {
+		obj.buckets[string(rune(i))] = &tokenBucket{tokens: 0, last: now}
+	}
+	obj.buckets["idle"] = &tokenBucket{tokens: 0, last: now.Add(-time.Hour)}
+
+	result := obj.allow("new", now)
+
+	assert.True(t, result)
+	assert.Len(t, obj.buckets, maxSourceBuckets+1)
+	assert.Nil(t, obj.buckets["idle"])
+}
+
+func TestDeadlineListenBase(t *testing.T) {
+	l := newChanListener()
+
+	result := deadlineListen(l, []ListenerOption{KeepAlive(0), HandshakeTimeout(time.Second)})
+
+	require.IsType(t, &deadlineListener{}, result)
+	assert.Same(t, l, result.(*deadlineListener).Listener)
+	assert.Equal(t, time.Second, result.(*deadlineListener).timeout)
+}
+
+func TestDeadlineListenNoOption(t *testing.T) {
+	l := newChanListener()
+
+	result := deadlineListen(l, []ListenerOption{KeepAlive(0), HandshakeTimeout(0)})
+
+	assert.Same(t, l, result)
+}
+
+func TestDeadlineListenerAccept(t *testing.T) {
+	l := newChanListener()
+	c, _ := pipeConduit(t)
+	obj := &deadlineListener{Listener: l, timeout: time.Millisecond}
+	go func() { l.conduits <- c }()
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c, result)
+	_, err = result.Link.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestDeadlineListenerAcceptError(t *testing.T) {
+	l := newChanListener()
+	l.Close()
+	obj := &deadlineListener{Listener: l, timeout: time.Second}
+
+	result, err := obj.Accept()
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, result)
+}
+
+func TestHandshakeDefaults(t *testing.T) {
+	result := handshakeDefaults()
+
+	assert.Equal(t, []ListenerOption{
+		MaxHandshakes(DefaultMaxHandshakes),
+		HandshakeTimeout(DefaultHandshakeTimeout),
+	}, result)
+}
+
+func TestHandshakeListenDefaults(t *testing.T) {
+	l := newChanListener()
+
+	result := handshakeListen(l, handshakeDefaults())
+	defer result.Close()
+
+	require.IsType(t, &handshakeListener{}, result)
+	assert.True(t, result.(*handshakeListener).deadline)
+}
+
+func TestHandshakeListenNoOption(t *testing.T) {
+	l := newChanListener()
+
+	result := handshakeListen(l, []ListenerOption{KeepAlive(0)})
+
+	assert.Same(t, l, result)
+}
+
+func TestHandshakeListenDeadline(t *testing.T) {
+	l := newChanListener()
+
+	result := handshakeListen(l, []ListenerOption{HandshakeTimeout(time.Second)})
+	defer result.Close()
+
+	require.IsType(t, &handshakeListener{}, result)
+	assert.True(t, result.(*handshakeListener).deadline)
+}
+
+func TestHandshakeListenerConcurrent(t *testing.T) {
+	l := newBlockingListener()
+	c1, _ := pipeConduit(t)
+	c2, _ := pipeConduit(t)
+	obj := handshakeListen(l, []ListenerOption{MaxHandshakes(2)})
+	defer obj.Close()
+	l.conduits <- c1
+	l.conduits <- c2
+
+	result2, err2 := obj.Accept()
+	close(l.release)
+	result1, err1 := obj.Accept()
+
+	assert.NoError(t, err2)
+	assert.Same(t, c2, result2)
+	assert.NoError(t, err1)
+	assert.Same(t, c1, result1)
+}
+
+func TestHandshakeListenerClearsDeadline(t *testing.T) {
+	l := newChanListener()
+	c, r := pipeConduit(t)
+	obj := handshakeListen(&deadlineListener{Listener: l, timeout: time.Nanosecond}, []ListenerOption{HandshakeTimeout(time.Nanosecond)})
+	defer obj.Close()
+	l.conduits <- c
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c, result)
+	go r.Write([]byte("x")) //nolint:errcheck
+	_, err = result.Link.Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
+
+func TestHandshakeListenerRetryable(t *testing.T) {
+	acceptErr := &AcceptError{Reason: AcceptHandshake, Err: assert.AnError}
+	c := &Conduit{}
+	l := &mockListener{}
+	l.On("Accept").Return(nil, acceptErr).Once()
+	l.On("Accept").Return(c, nil).Once()
+	l.On("Accept").Return(nil, net.ErrClosed)
+	obj := handshakeListen(l, []ListenerOption{MaxHandshakes(1)})
+
+	result1, err1 := obj.Accept()
+	result2, err2 := obj.Accept()
+	result3, err3 := obj.Accept()
+
+	assert.Same(t, acceptErr, err1)
+	assert.Nil(t, result1)
+	assert.NoError(t, err2)
+	assert.Same(t, c, result2)
+	assert.ErrorIs(t, err3, net.ErrClosed)
+	assert.Nil(t, result3)
+}
+
+func TestHandshakeListenerClose(t *testing.T) {
+	l := newChanListener()
+	obj := handshakeListen(l, []ListenerOption{MaxHandshakes(4)})
+
+	err := obj.Close()
+	obj.Close() //nolint:errcheck
+
+	assert.NoError(t, err)
+	result, err := obj.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed))
+	assert.Nil(t, result)
+}
+
+func TestHandshakeListenerCloseDiscards(t *testing.T) {
+	l := newChanListener()
+	c, r := pipeConduit(t)
+	obj := handshakeListen(l, []ListenerOption{MaxHandshakes(1)})
+	l.conduits <- c
+
+	obj.Close() //nolint:errcheck
+
+	_, err := r.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
@@ -0,0 +1,444 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME challenge types.
+const (
+	ACMEChallengeALPN = "tls-alpn-01" // Challenge answered by the TLS listener
+	ACMEChallengeDNS  = "dns-01"      // Challenge answered by publishing a TXT record
+)
+
+// DefaultACMERenewBefore is the default time before a certificate
+// obtained through ACME expires at which it is renewed.
+const DefaultACMERenewBefore = 30 * 24 * time.Hour
+
+// Names of the entries in the ACME cache.  The account key is shared
+// with the cache layout used for the ALPN challenge.
+const (
+	acmeAccountKey = "acme_account+key" // Cache entry holding the account key
+	acmeDNSSuffix  = "+dns"             // Suffix of the cache entry holding a certificate
+)
+
+// ACMEDNSProvider publishes the TXT records used to answer DNS
+// challenges.
+type ACMEDNSProvider interface {
+	// Present publishes a TXT record with the specified fully
+	// qualified name and value.
+	Present(ctx context.Context, fqdn, value string) error
+
+	// CleanUp removes a TXT record published by Present.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// ACMEDNSHook is an ACMEDNSProvider that runs the named command to
+// publish and remove TXT records.  The command is invoked with the
+// arguments "present" or "cleanup", followed by the fully qualified
+// name and the value of the record, and must exit successfully once
+// the change has been made.
+type ACMEDNSHook string
+
+// run runs the hook command.
+func (h ACMEDNSHook) run(ctx context.Context, op, fqdn, value string) error {
+	out, err := execCommand(ctx, string(h), op, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("acme: dns hook %s: %w: %s", op, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Present publishes a TXT record with the specified fully qualified
+// name and value.
+func (h ACMEDNSHook) Present(ctx context.Context, fqdn, value string) error {
+	return h.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp removes a TXT record published by Present.
+func (h ACMEDNSHook) CleanUp(ctx context.Context, fqdn, value string) error {
+	return h.run(ctx, "cleanup", fqdn, value)
+}
+
+// acmeClient describes the operations of an ACME client used to
+// answer DNS challenges.  It is implemented by *acme.Client.
+type acmeClient interface {
+	Register(ctx context.Context, acct *acme.Account, prompt func(tosURL string) bool) (*acme.Account, error)
+	AuthorizeOrder(ctx context.Context, id []acme.AuthzID, opt ...acme.OrderOption) (*acme.Order, error)
+	GetAuthorization(ctx context.Context, url string) (*acme.Authorization, error)
+	DNS01ChallengeRecord(token string) (string, error)
+	Accept(ctx context.Context, chal *acme.Challenge) (*acme.Challenge, error)
+	WaitAuthorization(ctx context.Context, url string) (*acme.Authorization, error)
+	WaitOrder(ctx context.Context, url string) (*acme.Order, error)
+	CreateOrderCert(ctx context.Context, url string, csr []byte, bundle bool) ([][]byte, string, error)
+}
+
+// mkACMEClient constructs an ACME client.
+func mkACMEClient(key crypto.Signer, directory string) acmeClient {
+	return &acme.Client{Key: key, DirectoryURL: directory}
+}
+
+// ACME obtains certificates for the public hostnames of a node's
+// listeners from an ACME certificate authority, such as Let's
+// Encrypt, and renews them before they expire.  Certificates are
+// obtained when first needed by a handshake.  With the TLS-ALPN
+// challenge, the authority validates control of each hostname by
+// connecting to port 443 of the host, so a TLS listener must accept
+// connections there; with the DNS challenge, the DNS provider
+// publishes the records the authority looks up instead.  The account
+// key and the certificates are stored in the cache directory, if one
+// is given, so that they survive restarts.  The exported fields must
+// be set before calling Config.
+type ACME struct {
+	sync.Mutex
+
+	Directory   string          // Directory URL of the authority; defaults to Let's Encrypt
+	Email       string          // Contact address for the account; may be empty
+	Hosts       []string        // Hostnames to obtain certificates for; required
+	Cache       string          // Directory caching the account key and certificates; may be empty
+	Challenge   string          // Challenge type; defaults to ACMEChallengeALPN
+	DNS         ACMEDNSProvider // Publishes records for ACMEChallengeDNS
+	RenewBefore time.Duration   // Time before expiry to renew; defaults to DefaultACMERenewBefore
+
+	issueLock sync.Mutex       // Serializes obtaining certificates
+	cache     autocert.Cache   // The cache, if any
+	client    acmeClient       // Client for DNS challenges, once registered
+	cert      *tls.Certificate // Current certificate for DNS challenges
+	renewing  bool             // True while a certificate is being renewed
+}
+
+// directory returns the directory URL of the authority.
+func (a *ACME) directory() string {
+	if a.Directory == "" {
+		return acme.LetsEncryptURL
+	}
+
+	return a.Directory
+}
+
+// renewBefore returns the time before expiry at which certificates
+// are renewed.
+func (a *ACME) renewBefore() time.Duration {
+	if a.RenewBefore <= 0 {
+		return DefaultACMERenewBefore
+	}
+
+	return a.RenewBefore
+}
+
+// Config returns a copy of a TLS configuration which presents
+// certificates obtained through ACME.  An error wrapping ErrBadConfig
+// is returned if the ACME configuration is incomplete.
+func (a *ACME) Config(base *tls.Config) (*tls.Config, error) {
+	if len(a.Hosts) == 0 {
+		return nil, fmt.Errorf("acme: hosts: %w", ErrBadConfig)
+	}
+	if a.Cache != "" {
+		a.cache = autocert.DirCache(a.Cache)
+	}
+
+	conf := base.Clone()
+	conf.Certificates = nil
+	switch a.Challenge {
+	case "", ACMEChallengeALPN:
+		a.alpnConfig(conf)
+	case ACMEChallengeDNS:
+		if a.DNS == nil {
+			return nil, fmt.Errorf("acme: dns: %w", ErrBadConfig)
+		}
+		conf.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return a.getCertificate(hello.Context())
+		}
+		conf.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return a.getCertificate(info.Context())
+		}
+	default:
+		return nil, fmt.Errorf("acme: challenge %q: %w", a.Challenge, ErrBadConfig)
+	}
+
+	return conf, nil
+}
+
+// alpnConfig sets up a TLS configuration to obtain certificates using
+// the TLS-ALPN challenge.  The challenge protocol is only negotiated
+// with clients offering it, so the protocols negotiated with peers
+// are unaffected.
+func (a *ACME) alpnConfig(conf *tls.Config) {
+	m := &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		HostPolicy:  autocert.HostWhitelist(a.Hosts...),
+		Email:       a.Email,
+		RenewBefore: a.renewBefore(),
+		Client:      &acme.Client{DirectoryURL: a.directory()},
+	}
+	if a.cache != nil {
+		m.Cache = a.cache
+	}
+
+	conf.GetCertificate = m.GetCertificate
+	conf.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return m.GetCertificate(&tls.ClientHelloInfo{ServerName: a.Hosts[0]})
+	}
+	next := conf.GetConfigForClient
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			srv := conf.Clone()
+			srv.GetConfigForClient = nil
+			srv.NextProtos = []string{acme.ALPNProto}
+			return srv, nil
+		} else if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+}
+
+// acmeExpiring reports whether a certificate is missing or expires
+// within the specified duration.
+func acmeExpiring(cert *tls.Certificate, within time.Duration) bool {
+	return cert == nil || cert.Leaf == nil || timeNow().Add(within).After(cert.Leaf.NotAfter)
+}
+
+// getCertificate returns the current certificate obtained with the
+// DNS challenge.  A certificate is obtained if there is none, or if
+// the current one has expired; one due for renewal is renewed in the
+// background.
+func (a *ACME) getCertificate(ctx context.Context) (*tls.Certificate, error) {
+	a.Lock()
+	if a.cert == nil && a.cache != nil {
+		if data, err := a.cache.Get(ctx, a.Hosts[0]+acmeDNSSuffix); err == nil {
+			a.cert, _ = acmeParse(data)
+		}
+	}
+	cert := a.cert
+	renew := !a.renewing && acmeExpiring(cert, a.renewBefore())
+	if renew && !acmeExpiring(cert, 0) {
+		a.renewing = true
+		a.Unlock()
+		go a.renew()
+		return cert, nil
+	}
+	a.Unlock()
+
+	if acmeExpiring(cert, 0) {
+		return a.update(ctx, "acme obtain")
+	}
+
+	return cert, nil
+}
+
+// renew renews the certificate in the background.
+func (a *ACME) renew() {
+	a.update(context.Background(), "acme renew") //nolint:errcheck
+
+	a.Lock()
+	defer a.Unlock()
+	a.renewing = false
+}
+
+// update obtains a new certificate and makes it the current one,
+// unless another goroutine has done so in the meantime.
+func (a *ACME) update(ctx context.Context, msg string) (*tls.Certificate, error) {
+	a.issueLock.Lock()
+	defer a.issueLock.Unlock()
+
+	a.Lock()
+	cert := a.cert
+	a.Unlock()
+	if !acmeExpiring(cert, a.renewBefore()) {
+		return cert, nil
+	}
+
+	cert, err := a.obtain(ctx)
+	logResult(msg, err, "hosts", a.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.cert = cert
+
+	return cert, nil
+}
+
+// account returns the ACME client, loading or generating the account
+// key and registering the account if necessary.  Must be called with
+// the issue lock held.
+func (a *ACME) account(ctx context.Context) (acmeClient, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+
+	// Load or generate the account key
+	var key crypto.Signer
+	if a.cache != nil {
+		if data, err := a.cache.Get(ctx, acmeAccountKey); err == nil {
+			if block, _ := pem.Decode(data); block != nil {
+				key, _ = x509.ParseECPrivateKey(block.Bytes)
+			}
+		}
+	}
+	if key == nil {
+		tmp, err := ecdsa.GenerateKey(elliptic.P256(), randReader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(tmp)
+		if err != nil {
+			return nil, err
+		}
+		if a.cache != nil {
+			data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+			if err := a.cache.Put(ctx, acmeAccountKey, data); err != nil {
+				return nil, fmt.Errorf("acme: %w", err)
+			}
+		}
+		key = tmp
+	}
+
+	// Register the account
+	client := mkACMEClientPatch(key, a.directory())
+	acct := &acme.Account{}
+	if a.Email != "" {
+		acct.Contact = []string{"mailto:" + a.Email}
+	}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("acme: %w", err)
+	}
+	a.client = client
+
+	return client, nil
+}
+
+// authorize answers the DNS challenge of an authorization.
+func (a *ACME) authorize(ctx context.Context, client acmeClient, url string) error {
+	z, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	} else if z.Status == acme.StatusValid {
+		return nil
+	}
+
+	// Select the DNS challenge
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == ACMEChallengeDNS {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s: %w", z.Identifier.Value, ErrNoChallenge)
+	}
+
+	// Publish the record and wait for the authority to check it
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + z.Identifier.Value + "."
+	if err := a.DNS.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	defer a.DNS.CleanUp(context.Background(), fqdn, value) //nolint:errcheck
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, z.URI)
+
+	return err
+}
+
+// obtain obtains a new certificate using the DNS challenge, storing
+// it in the cache.  Must be called with the issue lock held.
+func (a *ACME) obtain(ctx context.Context) (*tls.Certificate, error) {
+	client, err := a.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Place the order and answer its challenges
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(a.Hosts...))
+	if err != nil {
+		return nil, fmt.Errorf("acme: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := a.authorize(ctx, client, url); err != nil {
+			return nil, fmt.Errorf("acme: %w", err)
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("acme: %w", err)
+	}
+
+	// Request the certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), randReader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(randReader, &x509.CertificateRequest{DNSNames: a.Hosts}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("acme: %w", err)
+	}
+
+	// Store it in the cache
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, der := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if a.cache != nil {
+		if err := a.cache.Put(ctx, a.Hosts[0]+acmeDNSSuffix, data); err != nil {
+			return nil, fmt.Errorf("acme: %w", err)
+		}
+	}
+
+	return acmeParse(data)
+}
+
+// acmeParse parses a certificate and its key, as stored in the cache.
+func acmeParse(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
@@ -0,0 +1,474 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+)
+
+// fakeACME is a fake ACME client, issuing certificates valid for the
+// specified duration.
+type fakeACME struct {
+	sync.Mutex
+
+	valid       time.Duration     // Validity of the certificates issued
+	challenges  []string          // Challenge types offered
+	registerErr error             // Error returned by Register
+	keys        []crypto.Signer   // Account keys of the clients
+	accepted    []string          // Tokens of the challenges accepted
+	issued      int               // Number of certificates issued
+	authz       map[string]string // Status of each authorization
+	dns         *fakeDNS          // The DNS provider
+}
+
+// fakeDNS is a fake DNS provider, recording the records present.
+type fakeDNS struct {
+	sync.Mutex
+
+	present map[string]string // Records present, by name
+	seen    map[string]string // All records published, by name
+	err     error             // Error returned by Present
+}
+
+func (d *fakeDNS) Present(ctx context.Context, fqdn, value string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	d.present[fqdn] = value
+	d.seen[fqdn] = value
+	return nil
+}
+
+func (d *fakeDNS) CleanUp(ctx context.Context, fqdn, value string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	delete(d.present, fqdn)
+	return nil
+}
+
+// acmeFake installs a fake ACME client and returns it.  The fake DNS
+// provider it checks is in its dns field.
+func acmeFake(t *testing.T, valid time.Duration) *fakeACME {
+	fake := &fakeACME{
+		valid:      valid,
+		challenges: []string{ACMEChallengeALPN, ACMEChallengeDNS},
+		authz:      map[string]string{},
+		dns:        &fakeDNS{present: map[string]string{}, seen: map[string]string{}},
+	}
+	p := patcher.SetVar(&mkACMEClientPatch, func(key crypto.Signer, directory string) acmeClient {
+		fake.Lock()
+		defer fake.Unlock()
+		fake.keys = append(fake.keys, key)
+		return fake
+	}).Install()
+	t.Cleanup(func() { p.Restore() })
+
+	return fake
+}
+
+func (f *fakeACME) Register(ctx context.Context, acct *acme.Account, prompt func(tosURL string) bool) (*acme.Account, error) {
+	return acct, f.registerErr
+}
+
+func (f *fakeACME) AuthorizeOrder(ctx context.Context, id []acme.AuthzID, opt ...acme.OrderOption) (*acme.Order, error) {
+	result := &acme.Order{URI: "order", FinalizeURL: "finalize"}
+	for _, i := range id {
+		result.AuthzURLs = append(result.AuthzURLs, i.Value)
+	}
+
+	return result, nil
+}
+
+func (f *fakeACME) GetAuthorization(ctx context.Context, url string) (*acme.Authorization, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	result := &acme.Authorization{
+		URI:        url,
+		Status:     f.authz[url],
+		Identifier: acme.AuthzID{Type: "dns", Value: url},
+	}
+	for _, typ := range f.challenges {
+		result.Challenges = append(result.Challenges, &acme.Challenge{Type: typ, Token: typ + ":" + url})
+	}
+
+	return result, nil
+}
+
+func (f *fakeACME) DNS01ChallengeRecord(token string) (string, error) {
+	return "record:" + token, nil
+}
+
+func (f *fakeACME) Accept(ctx context.Context, chal *acme.Challenge) (*acme.Challenge, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.accepted = append(f.accepted, chal.Token)
+	return chal, nil
+}
+
+func (f *fakeACME) WaitAuthorization(ctx context.Context, url string) (*acme.Authorization, error) {
+	f.dns.Lock()
+	defer f.dns.Unlock()
+
+	if f.dns.present["_acme-challenge."+url+"."] != "record:dns-01:"+url {
+		return nil, assert.AnError
+	}
+	return &acme.Authorization{URI: url, Status: acme.StatusValid}, nil
+}
+
+func (f *fakeACME) WaitOrder(ctx context.Context, url string) (*acme.Order, error) {
+	return &acme.Order{URI: url, FinalizeURL: "finalize"}, nil
+}
+
+func (f *fakeACME) CreateOrderCert(ctx context.Context, url string, csr []byte, bundle bool) ([][]byte, string, error) {
+	req, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.issued++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(f.issued)),
+		DNSNames:     req.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(f.valid),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, req.PublicKey, key)
+
+	return [][]byte{der}, "cert", err
+}
+
+func TestACMEDNSHookPresent(t *testing.T) {
+	var args []string
+	defer patcher.SetVar(&execCommand, func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		args = append([]string{name}, arg...)
+		return exec.CommandContext(ctx, "true")
+	}).Install().Restore()
+	obj := ACMEDNSHook("/usr/local/bin/dns-hook")
+
+	err := obj.Present(context.Background(), "_acme-challenge.example.com.", "value")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/usr/local/bin/dns-hook", "present", "_acme-challenge.example.com.", "value"}, args)
+}
+
+func TestACMEDNSHookCleanUp(t *testing.T) {
+	var args []string
+	defer patcher.SetVar(&execCommand, func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		args = append([]string{name}, arg...)
+		return exec.CommandContext(ctx, "true")
+	}).Install().Restore()
+	obj := ACMEDNSHook("/usr/local/bin/dns-hook")
+
+	err := obj.CleanUp(context.Background(), "_acme-challenge.example.com.", "value")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/usr/local/bin/dns-hook", "cleanup", "_acme-challenge.example.com.", "value"}, args)
+}
+
+func TestACMEDNSHookError(t *testing.T) {
+	defer patcher.SetVar(&execCommand, func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "false")
+	}).Install().Restore()
+	obj := ACMEDNSHook("/usr/local/bin/dns-hook")
+
+	err := obj.Present(context.Background(), "_acme-challenge.example.com.", "value")
+
+	var exitErr *exec.ExitError
+	assert.ErrorAs(t, err, &exitErr)
+}
+
+func TestACMEDirectoryDefault(t *testing.T) {
+	obj := &ACME{}
+
+	result := obj.directory()
+
+	assert.Equal(t, acme.LetsEncryptURL, result)
+}
+
+func TestACMEDirectorySet(t *testing.T) {
+	obj := &ACME{Directory: "https://acme.example.com/directory"}
+
+	result := obj.directory()
+
+	assert.Equal(t, "https://acme.example.com/directory", result)
+}
+
+func TestACMERenewBeforeDefault(t *testing.T) {
+	obj := &ACME{}
+
+	result := obj.renewBefore()
+
+	assert.Equal(t, DefaultACMERenewBefore, result)
+}
+
+func TestACMERenewBeforeSet(t *testing.T) {
+	obj := &ACME{RenewBefore: time.Hour}
+
+	result := obj.renewBefore()
+
+	assert.Equal(t, time.Hour, result)
+}
+
+func TestACMEConfigALPN(t *testing.T) {
+	obj := &ACME{Hosts: []string{"example.com"}, Cache: t.TempDir()}
+	base := &tls.Config{Certificates: []tls.Certificate{{}}}
+
+	result, err := obj.Config(base)
+
+	assert.NoError(t, err)
+	assert.Nil(t, result.Certificates)
+	assert.NotNil(t, result.GetCertificate)
+	assert.NotNil(t, result.GetClientCertificate)
+	assert.NotNil(t, obj.cache)
+	assert.Len(t, base.Certificates, 1)
+	challenge, err := result.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{acme.ALPNProto}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{acme.ALPNProto}, challenge.NextProtos)
+	normal, err := result.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{"h2"}})
+	assert.NoError(t, err)
+	assert.Nil(t, normal)
+}
+
+func TestACMEConfigALPNNext(t *testing.T) {
+	next := &tls.Config{}
+	obj := &ACME{Hosts: []string{"example.com"}}
+
+	result, err := obj.Config(&tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return next, nil
+		},
+	})
+
+	assert.NoError(t, err)
+	conf, err := result.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Same(t, next, conf)
+}
+
+func TestACMEConfigALPNRejectsHost(t *testing.T) {
+	obj := &ACME{Hosts: []string{"example.com"}}
+	conf, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := conf.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestACMEConfigNoHosts(t *testing.T) {
+	obj := &ACME{}
+
+	result, err := obj.Config(&tls.Config{})
+
+	assert.ErrorIs(t, err, ErrBadConfig)
+	assert.Nil(t, result)
+}
+
+func TestACMEConfigNoDNS(t *testing.T) {
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS}
+
+	result, err := obj.Config(&tls.Config{})
+
+	assert.ErrorIs(t, err, ErrBadConfig)
+	assert.Nil(t, result)
+}
+
+func TestACMEConfigBadChallenge(t *testing.T) {
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: "http-01"}
+
+	result, err := obj.Config(&tls.Config{})
+
+	assert.ErrorIs(t, err, ErrBadConfig)
+	assert.Nil(t, result)
+}
+
+func TestACMEConfigDNS(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	obj := &ACME{Hosts: []string{"example.com", "www.example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns}
+	conf, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := conf.GetCertificate(&tls.ClientHelloInfo{})
+
+	assert.NoError(t, err)
+	require.NotNil(t, result)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, result.Leaf.DNSNames)
+	assert.Equal(t, []string{"dns-01:example.com", "dns-01:www.example.com"}, fake.accepted)
+	assert.Equal(t, map[string]string{
+		"_acme-challenge.example.com.":     "record:dns-01:example.com",
+		"_acme-challenge.www.example.com.": "record:dns-01:www.example.com",
+	}, fake.dns.seen)
+	assert.Empty(t, fake.dns.present)
+	client, err := conf.GetClientCertificate(&tls.CertificateRequestInfo{})
+	assert.NoError(t, err)
+	assert.Same(t, result, client)
+	assert.Equal(t, 1, fake.issued)
+}
+
+func TestACMEGetCertificateCached(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	cache := t.TempDir()
+	first := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns, Cache: cache}
+	_, err := first.Config(&tls.Config{})
+	require.NoError(t, err)
+	cert, err := first.getCertificate(context.Background())
+	require.NoError(t, err)
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns, Cache: cache}
+	_, err = obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, cert.Certificate, result.Certificate)
+	assert.Equal(t, 1, fake.issued)
+}
+
+func TestACMEGetCertificateAccountCached(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	cache := t.TempDir()
+	for range 2 {
+		obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns, Cache: cache}
+		_, err := obj.Config(&tls.Config{})
+		require.NoError(t, err)
+
+		_, err = obj.account(context.Background())
+
+		require.NoError(t, err)
+	}
+
+	require.Len(t, fake.keys, 2)
+	assert.True(t, fake.keys[0].(*ecdsa.PrivateKey).Equal(fake.keys[1]))
+}
+
+func TestACMEGetCertificateRenew(t *testing.T) {
+	fake := acmeFake(t, time.Hour)
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns, RenewBefore: time.Minute}
+	_, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+	first, err := obj.getCertificate(context.Background())
+	require.NoError(t, err)
+	obj.RenewBefore = 2 * time.Hour
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.NoError(t, err)
+	assert.Same(t, first, result)
+	assert.Eventually(t, func() bool {
+		obj.Lock()
+		defer obj.Unlock()
+		return !obj.renewing
+	}, time.Second, time.Millisecond)
+	fake.Lock()
+	defer fake.Unlock()
+	assert.Equal(t, 2, fake.issued)
+}
+
+func TestACMEGetCertificateAuthorized(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	fake.authz["example.com"] = acme.StatusValid
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns}
+	_, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.NoError(t, err)
+	assert.NotNil(t, result)
+	assert.Empty(t, fake.accepted)
+}
+
+func TestACMEGetCertificateNoChallenge(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	fake.challenges = []string{ACMEChallengeALPN}
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns}
+	_, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.ErrorIs(t, err, ErrNoChallenge)
+	assert.Nil(t, result)
+}
+
+func TestACMEGetCertificateDNSError(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	fake.dns.err = assert.AnError
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns}
+	_, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+	assert.Empty(t, fake.accepted)
+}
+
+func TestACMEGetCertificateRegistered(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	fake.registerErr = acme.ErrAccountAlreadyExists
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns}
+	_, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.NoError(t, err)
+	assert.NotNil(t, result)
+}
+
+func TestACMEGetCertificateRegisterError(t *testing.T) {
+	fake := acmeFake(t, 90*24*time.Hour)
+	fake.registerErr = assert.AnError
+	obj := &ACME{Hosts: []string{"example.com"}, Challenge: ACMEChallengeDNS, DNS: fake.dns}
+	_, err := obj.Config(&tls.Config{})
+	require.NoError(t, err)
+
+	result, err := obj.getCertificate(context.Background())
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+	assert.Nil(t, obj.client)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// ifaceAddr is an address of a network interface.
+type ifaceAddr struct {
+	IP   net.IP // The address
+	Zone string // The name of the interface, used as the IPv6 zone
+}
+
+// listInterfaceAddrs returns the addresses of the network interfaces
+// that are up.
+func listInterfaceAddrs() ([]ifaceAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ifaceAddr{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				result = append(result, ifaceAddr{IP: ipnet.IP, Zone: iface.Name})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// AdvertisableOptions selects the interface addresses included by
+// URI.Advertisable.  Loopback and link-local addresses are excluded
+// by default, as they are of no use to peers on other hosts.
+type AdvertisableOptions struct {
+	Loopback  bool // Include loopback addresses
+	LinkLocal bool // Include link-local addresses, with their zones
+}
+
+// include tests whether an interface address is to be included.
+func (o AdvertisableOptions) include(ip net.IP) bool {
+	switch {
+	case ip.IsUnspecified() || ip.IsMulticast():
+		return false
+	case ip.IsLoopback():
+		return o.Loopback
+	case ip.IsLinkLocalUnicast():
+		return o.LinkLocal
+	default:
+		return true
+	}
+}
+
+// IsWildcard tests if the conduit URI is a wildcard URI, such as a
+// listener bound to all interfaces reports from Addr: its host is
+// empty, "0.0.0.0", or "::", and it has a port.
+func (u *URI) IsWildcard() bool {
+	if u.Discovery != "" || u.Host == "" || u.hasLiteralHost() {
+		return false
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return false
+	}
+	if host == "" {
+		return true
+	}
+	ip, _ := parseIP(host)
+
+	return ip != nil && ip.IsUnspecified()
+}
+
+// Advertisable returns the list of URIs at which a listener with the
+// URI, as returned by its Addr method, may be reached by peers.  If
+// the URI is a wildcard URI, the addresses of the machine's network
+// interfaces that are up are enumerated, and a URI is constructed for
+// each with the same scheme, port, path, and query; a host of
+// "0.0.0.0" gives only IPv4 addresses, while an empty host or "::",
+// which accept both IPv4 and IPv6 connections, give both.  Otherwise,
+// the URI itself is returned.  An error wrapping ErrNoAddresses is
+// returned if no interface addresses are usable.
+func (u *URI) Advertisable(opts AdvertisableOptions) ([]*URI, error) {
+	if !u.IsWildcard() {
+		return []*URI{u}, nil
+	}
+
+	host, port, _ := net.SplitHostPort(u.Host)
+	v4only := false
+	if ip, _ := parseIP(host); ip != nil && ip.To4() != nil {
+		v4only = true
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*URI{}
+	seen := map[string]bool{}
+	for _, addr := range addrs {
+		ip := addr.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		} else if v4only {
+			continue
+		}
+		if !opts.include(ip) {
+			continue
+		}
+
+		zone := ""
+		if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+			zone = addr.Zone
+		}
+		hostPort := net.JoinHostPort(joinZone(ip, zone), port)
+		if seen[hostPort] {
+			continue
+		}
+		seen[hostPort] = true
+
+		result = append(result, &URI{
+			URL: url.URL{
+				Scheme:   u.Scheme,
+				Host:     hostPort,
+				Path:     u.Path,
+				RawQuery: u.RawQuery,
+			},
+			Transport: u.Transport,
+			Security:  u.Security,
+		})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%s: %w", u, ErrNoAddresses)
+	}
+
+	return result, nil
+}
+
+// AdvertisableURIs returns the list of URIs at which a listener may
+// be reached by peers, as described for URI.Advertisable.
+func AdvertisableURIs(l Listener, opts AdvertisableOptions) ([]*URI, error) {
+	return l.Addr().Advertisable(opts)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeInterfaceAddrs() ([]ifaceAddr, error) {
+	return []ifaceAddr{
+		{IP: net.ParseIP("127.0.0.1"), Zone: "lo"},
+		{IP: net.ParseIP("::1"), Zone: "lo"},
+		{IP: net.ParseIP("192.0.2.1"), Zone: "eth0"},
+		{IP: net.ParseIP("fe80::1"), Zone: "eth0"},
+		{IP: net.ParseIP("2001:db8::1"), Zone: "eth0"},
+		{IP: net.ParseIP("192.0.2.1"), Zone: "eth1"},
+		{IP: net.ParseIP("169.254.0.1"), Zone: "eth1"},
+	}, nil
+}
+
+func uriStrings(uris []*URI) []string {
+	result := []string{}
+	for _, u := range uris {
+		result = append(result, u.String())
+	}
+
+	return result
+}
+
+func TestURIIsWildcardTrue(t *testing.T) {
+	for _, text := range []string{
+		"tcp://0.0.0.0:1234",
+		"tcp://[::]:1234",
+		"tcp://:1234",
+		"udp://0.0.0.0:1234",
+	} {
+		u, err := Parse(text)
+		require.NoError(t, err)
+
+		assert.True(t, u.IsWildcard(), text)
+	}
+}
+
+func TestURIIsWildcardFalse(t *testing.T) {
+	for _, text := range []string{
+		"tcp://127.0.0.1:1234",
+		"tcp://[::1]:1234",
+		"tcp://example.com:1234",
+		"tcp+srv://example.com",
+		"mem:wildcard",
+	} {
+		u, err := Parse(text)
+		require.NoError(t, err)
+
+		assert.False(t, u.IsWildcard(), text)
+	}
+}
+
+func TestURIAdvertisableNotWildcard(t *testing.T) {
+	u, err := Parse("tcp://192.0.2.1:1234")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, func() ([]ifaceAddr, error) {
+		t.Fatal("unexpected interface enumeration")
+		return nil, nil
+	}).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{u}, result)
+}
+
+func TestURIAdvertisableIPv4(t *testing.T) {
+	u, err := Parse("tcp://0.0.0.0:1234/path?q=v")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, fakeInterfaceAddrs).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tcp://192.0.2.1:1234/path?q=v"}, uriStrings(result))
+	assert.Equal(t, u.Transport, result[0].Transport)
+	assert.Equal(t, u.Security, result[0].Security)
+}
+
+func TestURIAdvertisableDualStack(t *testing.T) {
+	u, err := Parse("tcp://[::]:1234")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, fakeInterfaceAddrs).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"tcp://192.0.2.1:1234",
+		"tcp://[2001:db8::1]:1234",
+	}, uriStrings(result))
+}
+
+func TestURIAdvertisableEmptyHost(t *testing.T) {
+	u, err := Parse("tcp://:1234")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, fakeInterfaceAddrs).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"tcp://192.0.2.1:1234",
+		"tcp://[2001:db8::1]:1234",
+	}, uriStrings(result))
+}
+
+func TestURIAdvertisableAll(t *testing.T) {
+	u, err := Parse("tcp://[::]:1234")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, fakeInterfaceAddrs).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{
+		Loopback:  true,
+		LinkLocal: true,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"tcp://127.0.0.1:1234",
+		"tcp://[::1]:1234",
+		"tcp://192.0.2.1:1234",
+		"tcp://[fe80::1%25eth0]:1234",
+		"tcp://[2001:db8::1]:1234",
+		"tcp://169.254.0.1:1234",
+	}, uriStrings(result))
+}
+
+func TestURIAdvertisableInterfaceError(t *testing.T) {
+	u, err := Parse("tcp://0.0.0.0:1234")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, func() ([]ifaceAddr, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestURIAdvertisableNoAddresses(t *testing.T) {
+	u, err := Parse("tcp://0.0.0.0:1234")
+	require.NoError(t, err)
+	defer patcher.SetVar(&interfaceAddrs, func() ([]ifaceAddr, error) {
+		return []ifaceAddr{
+			{IP: net.ParseIP("127.0.0.1"), Zone: "lo"},
+			{IP: net.ParseIP("2001:db8::1"), Zone: "eth0"},
+		}, nil
+	}).Install().Restore()
+
+	result, err := u.Advertisable(AdvertisableOptions{})
+
+	assert.True(t, errors.Is(err, ErrNoAddresses))
+	assert.Nil(t, result)
+}
+
+func TestAdvertisableURIs(t *testing.T) {
+	u, err := Parse("tcp://0.0.0.0:1234")
+	require.NoError(t, err)
+	l := &mockListener{}
+	l.On("Addr").Return(u)
+	defer patcher.SetVar(&interfaceAddrs, fakeInterfaceAddrs).Install().Restore()
+
+	result, err := AdvertisableURIs(l, AdvertisableOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tcp://192.0.2.1:1234"}, uriStrings(result))
+	l.AssertExpectations(t)
+}
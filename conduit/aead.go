@@ -0,0 +1,317 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Constants for sealed messages.
+const (
+	aeadKeySize  = chacha20poly1305.KeySize  // Size of the key
+	aeadTagSize  = chacha20poly1305.Overhead // Size of the authentication tag
+	aeadMaxMsg   = 65535                     // Maximum size of a sealed message
+	aeadMaxPlain = aeadMaxMsg - aeadTagSize  // Maximum plaintext of a sealed message
+
+	aeadNonceSize   = 8                            // Size of the explicit nonce of a datagram
+	aeadMaxDatagram = aeadMaxPlain - aeadNonceSize // Maximum plaintext of a datagram
+	aeadReplayLimit = 64                           // Size of the datagram replay window
+)
+
+// aeadCipher is a ChaCha20-Poly1305 cipher with a counter nonce.  It
+// serves as the CipherState of the Noise protocol, and protects the
+// data of conduits established by the security layer mechanisms.
+// Over links which preserve message boundaries, where datagrams may
+// be lost or reordered, each datagram carries its nonce explicitly,
+// and a replay window rejects datagrams already received.
+type aeadCipher struct {
+	k      [aeadKeySize]byte // The key
+	hasKey bool              // Flag indicating the key is set
+	n      uint64            // The nonce
+	window replayWindow      // Nonces of the datagrams received
+}
+
+// nonceBytes returns the encoded form of a nonce.
+func nonceBytes(n uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+
+	return nonce
+}
+
+// seal encrypts the plaintext with the associated data and the
+// specified nonce.  If there is no key, the plaintext is returned.
+func (c *aeadCipher) seal(n uint64, ad, plaintext []byte) []byte {
+	if !c.hasKey {
+		return append([]byte(nil), plaintext...)
+	}
+	aead, _ := chacha20poly1305.New(c.k[:])
+
+	return aead.Seal(nil, nonceBytes(n), plaintext, ad)
+}
+
+// open decrypts the ciphertext with the associated data and the
+// specified nonce.  If there is no key, the ciphertext is returned.
+func (c *aeadCipher) open(n uint64, ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return append([]byte(nil), ciphertext...), nil
+	}
+	aead, _ := chacha20poly1305.New(c.k[:])
+	plaintext, err := aead.Open(nil, nonceBytes(n), ciphertext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	return plaintext, nil
+}
+
+// encrypt encrypts the plaintext with the associated data, advancing
+// the nonce.  If there is no key, the plaintext is returned.
+func (c *aeadCipher) encrypt(ad, plaintext []byte) []byte {
+	if !c.hasKey {
+		return c.seal(0, ad, plaintext)
+	}
+	c.n++
+
+	return c.seal(c.n-1, ad, plaintext)
+}
+
+// decrypt decrypts the ciphertext with the associated data, advancing
+// the nonce.  If there is no key, the ciphertext is returned.
+func (c *aeadCipher) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return c.open(0, ad, ciphertext)
+	}
+	c.n++
+
+	return c.open(c.n-1, ad, ciphertext)
+}
+
+// encryptDatagram encrypts a datagram, prefixing the ciphertext with
+// the nonce, so that the datagram may be decrypted regardless of the
+// datagrams lost or reordered before it.
+func (c *aeadCipher) encryptDatagram(plaintext []byte) []byte {
+	msg := make([]byte, aeadNonceSize, aeadNonceSize+len(plaintext)+aeadTagSize)
+	binary.BigEndian.PutUint64(msg, c.n)
+	c.n++
+
+	return append(msg, c.seal(c.n-1, nil, plaintext)...)
+}
+
+// decryptDatagram decrypts a datagram encrypted by encryptDatagram.
+// Datagrams which have already been received, or which are too old
+// for the replay window to tell, are rejected with an error wrapping
+// ErrReplay.
+func (c *aeadCipher) decryptDatagram(msg []byte) ([]byte, error) {
+	if len(msg) < aeadNonceSize {
+		return nil, ErrShortMessage
+	}
+	n := binary.BigEndian.Uint64(msg)
+	if !c.window.check(n) {
+		return nil, fmt.Errorf("nonce %d: %w", n, ErrReplay)
+	}
+	plaintext, err := c.open(n, nil, msg[aeadNonceSize:])
+	if err != nil {
+		return nil, err
+	}
+	c.window.update(n)
+
+	return plaintext, nil
+}
+
+// replayWindow tracks the nonces of the most recent datagrams
+// received, so that replayed datagrams may be rejected.  The zero
+// value has seen no nonce.
+type replayWindow struct {
+	seen bool   // Flag indicating a nonce has been seen
+	top  uint64 // Highest nonce seen
+	bits uint64 // Nonces seen, by distance below top
+}
+
+// check tests whether a nonce may be accepted.
+func (w *replayWindow) check(n uint64) bool {
+	switch {
+	case !w.seen || n > w.top:
+		return true
+	case w.top-n >= aeadReplayLimit:
+		return false
+	default:
+		return w.bits&(1<<(w.top-n)) == 0
+	}
+}
+
+// update records a nonce as seen.  The nonce must have been accepted
+// by check.
+func (w *replayWindow) update(n uint64) {
+	switch {
+	case !w.seen:
+		w.seen, w.top, w.bits = true, n, 1
+	case n > w.top:
+		if n-w.top >= aeadReplayLimit {
+			w.bits = 0
+		} else {
+			w.bits <<= n - w.top
+		}
+		w.top = n
+		w.bits |= 1
+	default:
+		w.bits |= 1 << (w.top - n)
+	}
+}
+
+// aeadConn is a net.Conn that encrypts and decrypts data using the
+// cipher states negotiated by a security layer handshake.
+type aeadConn struct {
+	net.Conn
+
+	boundaries bool        // Flag indicating the link preserves message boundaries
+	rlock      sync.Mutex  // Protects the receive state
+	recv       *aeadCipher // Cipher for received messages
+	buf        []byte      // Decrypted data not yet read
+	wlock      sync.Mutex  // Protects the send state
+	send       *aeadCipher // Cipher for sent messages
+}
+
+// writeSealed writes a single sealed message to the link, prefixed
+// by its length.
+func writeSealed(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// readSealed reads a single sealed message from the link.  If the
+// link preserves message boundaries, the message must be carried by
+// a single read.
+func readSealed(r io.Reader, boundaries bool) ([]byte, error) {
+	if boundaries {
+		buf := make([]byte, 2+aeadMaxMsg)
+		n, err := r.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 2 || int(binary.BigEndian.Uint16(buf)) != n-2 {
+			return nil, ErrShortMessage
+		}
+		return buf[2:n], nil
+	}
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Read reads data from the connection.  If the link preserves
+// message boundaries, each read returns the contents of a single
+// datagram, the excess being discarded if the buffer is too small;
+// datagrams which fail to decrypt, such as forged or replayed ones,
+// are dropped.
+func (c *aeadConn) Read(b []byte) (int, error) {
+	c.rlock.Lock()
+	defer c.rlock.Unlock()
+
+	if c.boundaries {
+		return c.readDatagram(b)
+	}
+
+	for len(c.buf) == 0 {
+		msg, err := readSealed(c.Conn, c.boundaries)
+		if err != nil {
+			return 0, err
+		}
+		if c.buf, err = c.recv.decrypt(nil, msg); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+// readDatagram reads a single datagram from a link which preserves
+// message boundaries.  Must be called with the receive lock held.
+func (c *aeadConn) readDatagram(b []byte) (int, error) {
+	for {
+		msg, err := readSealed(c.Conn, true)
+		if errors.Is(err, ErrShortMessage) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		plaintext, err := c.recv.decryptDatagram(msg)
+		if err != nil {
+			continue
+		}
+
+		return copy(b, plaintext), nil
+	}
+}
+
+// Write writes data to the connection.  Data larger than the maximum
+// sealed message is split across several messages.  If the link
+// preserves message boundaries, the data is instead sent as a single
+// datagram, and an error wrapping ErrDatagramTooLong is returned if it
+// does not fit.
+func (c *aeadConn) Write(b []byte) (int, error) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+
+	if c.boundaries {
+		if len(b) > aeadMaxDatagram {
+			return 0, fmt.Errorf("%d bytes: %w", len(b), ErrDatagramTooLong)
+		}
+		if err := writeSealed(c.Conn, c.send.encryptDatagram(b)); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	n := 0
+	for {
+		chunk := b[n:]
+		if len(chunk) > aeadMaxPlain {
+			chunk = chunk[:aeadMaxPlain]
+		}
+		if err := writeSealed(c.Conn, c.send.encrypt(nil, chunk)); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		if n >= len(b) {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAEADCipherNoKey(t *testing.T) {
+	obj := &aeadCipher{}
+
+	ct := obj.encrypt([]byte("ad"), []byte("text"))
+	pt, err := obj.decrypt([]byte("ad"), ct)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("text"), ct)
+	assert.Equal(t, []byte("text"), pt)
+	assert.Equal(t, uint64(0), obj.n)
+}
+
+func TestAEADCipherRoundTrip(t *testing.T) {
+	enc := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	dec := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+
+	ct1 := enc.encrypt([]byte("ad"), []byte("text"))
+	ct2 := enc.encrypt([]byte("ad"), []byte("text"))
+	pt1, err1 := dec.decrypt([]byte("ad"), ct1)
+	pt2, err2 := dec.decrypt([]byte("ad"), ct2)
+
+	assert.NotEqual(t, ct1, ct2)
+	assert.Len(t, ct1, 4+aeadTagSize)
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, []byte("text"), pt1)
+	assert.Equal(t, []byte("text"), pt2)
+}
+
+func TestAEADCipherDecryptError(t *testing.T) {
+	enc := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	dec := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	ct := enc.encrypt(nil, []byte("text"))
+
+	pt, err := dec.decrypt([]byte("ad"), ct)
+
+	assert.ErrorIs(t, err, ErrDecrypt)
+	assert.Nil(t, pt)
+}
+
+func TestReadSealedStream(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go writeSealed(c1, []byte("message")) //nolint:errcheck
+
+	result, err := readSealed(c2, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("message"), result)
+}
+
+func TestReadSealedStreamTruncated(t *testing.T) {
+	r := strings.NewReader("\x00\x07mess")
+
+	result, err := readSealed(r, false)
+
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Nil(t, result)
+}
+
+func TestReadSealedBoundaries(t *testing.T) {
+	r := strings.NewReader("\x00\x07message")
+
+	result, err := readSealed(r, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("message"), result)
+}
+
+func TestReadSealedBoundariesBadLength(t *testing.T) {
+	r := strings.NewReader("\x00\x09message")
+
+	result, err := readSealed(r, true)
+
+	assert.ErrorIs(t, err, ErrShortMessage)
+	assert.Nil(t, result)
+}
+
+// datagramConn is a net.Conn preserving message boundaries, which
+// reads the queued datagrams and records those written.
+type datagramConn struct {
+	net.Conn
+
+	in  [][]byte // Datagrams to read
+	out [][]byte // Datagrams written
+}
+
+func (c *datagramConn) Read(b []byte) (int, error) {
+	if len(c.in) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.in[0])
+	c.in = c.in[1:]
+
+	return n, nil
+}
+
+func (c *datagramConn) Write(b []byte) (int, error) {
+	c.out = append(c.out, append([]byte(nil), b...))
+
+	return len(b), nil
+}
+
+// newDatagramPair constructs a pair of aeadConns over datagramConns
+// sharing a key.
+func newDatagramPair() (*aeadConn, *datagramConn, *aeadConn, *datagramConn) {
+	sendLink, recvLink := &datagramConn{}, &datagramConn{}
+	sender := &aeadConn{
+		Conn:       sendLink,
+		boundaries: true,
+		send:       &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}},
+	}
+	receiver := &aeadConn{
+		Conn:       recvLink,
+		boundaries: true,
+		recv:       &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}},
+	}
+
+	return sender, sendLink, receiver, recvLink
+}
+
+func TestAEADConnDatagramsReordered(t *testing.T) {
+	sender, sendLink, receiver, recvLink := newDatagramPair()
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		_, err := sender.Write([]byte(msg))
+		assert.NoError(t, err)
+	}
+	recvLink.in = [][]byte{sendLink.out[2], sendLink.out[0], sendLink.out[3]}
+	buf := make([]byte, 16)
+
+	n1, err1 := receiver.Read(buf)
+	msg1 := string(buf[:n1])
+	n2, err2 := receiver.Read(buf)
+	msg2 := string(buf[:n2])
+	n3, err3 := receiver.Read(buf)
+	msg3 := string(buf[:n3])
+
+	assert.NoError(t, err1)
+	assert.Equal(t, "three", msg1)
+	assert.NoError(t, err2)
+	assert.Equal(t, "one", msg2)
+	assert.NoError(t, err3)
+	assert.Equal(t, "four", msg3)
+}
+
+func TestAEADConnDatagramsDropped(t *testing.T) {
+	sender, sendLink, receiver, recvLink := newDatagramPair()
+	sender.Write([]byte("one")) //nolint:errcheck
+	sender.Write([]byte("two")) //nolint:errcheck
+	forged := append([]byte(nil), sendLink.out[1]...)
+	forged[len(forged)-1] ^= 0xff
+	recvLink.in = [][]byte{sendLink.out[0], sendLink.out[0], {0x00}, forged, sendLink.out[1]}
+	buf := make([]byte, 16)
+
+	n1, err1 := receiver.Read(buf)
+	msg1 := string(buf[:n1])
+	n2, err2 := receiver.Read(buf)
+	msg2 := string(buf[:n2])
+	_, err3 := receiver.Read(buf)
+
+	assert.NoError(t, err1)
+	assert.Equal(t, "one", msg1)
+	assert.NoError(t, err2)
+	assert.Equal(t, "two", msg2)
+	assert.Same(t, io.EOF, err3)
+}
+
+func TestAEADConnDatagramShortBuffer(t *testing.T) {
+	sender, sendLink, receiver, recvLink := newDatagramPair()
+	sender.Write([]byte("datagram")) //nolint:errcheck
+	sender.Write([]byte("next"))     //nolint:errcheck
+	recvLink.in = sendLink.out
+	buf := make([]byte, 4)
+
+	n1, err1 := receiver.Read(buf)
+	msg1 := string(buf[:n1])
+	n2, err2 := receiver.Read(buf)
+	msg2 := string(buf[:n2])
+
+	assert.NoError(t, err1)
+	assert.Equal(t, "data", msg1)
+	assert.NoError(t, err2)
+	assert.Equal(t, "next", msg2)
+}
+
+func TestAEADConnDatagramTooLong(t *testing.T) {
+	sender, sendLink, _, _ := newDatagramPair()
+
+	n, err := sender.Write(make([]byte, aeadMaxDatagram+1))
+
+	assert.ErrorIs(t, err, ErrDatagramTooLong)
+	assert.Equal(t, 0, n)
+	assert.Empty(t, sendLink.out)
+}
+
+func TestAEADConnDatagramMaximum(t *testing.T) {
+	sender, sendLink, receiver, recvLink := newDatagramPair()
+
+	n, err := sender.Write(make([]byte, aeadMaxDatagram))
+
+	assert.NoError(t, err)
+	assert.Equal(t, aeadMaxDatagram, n)
+	require.Len(t, sendLink.out, 1)
+	recvLink.in = sendLink.out
+	m, err := receiver.Read(make([]byte, aeadMaxDatagram))
+	assert.NoError(t, err)
+	assert.Equal(t, aeadMaxDatagram, m)
+}
+
+func TestAEADCipherDecryptDatagramReplay(t *testing.T) {
+	enc := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	dec := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	msg := enc.encryptDatagram([]byte("text"))
+
+	pt1, err1 := dec.decryptDatagram(msg)
+	pt2, err2 := dec.decryptDatagram(msg)
+
+	assert.NoError(t, err1)
+	assert.Equal(t, []byte("text"), pt1)
+	assert.ErrorIs(t, err2, ErrReplay)
+	assert.Nil(t, pt2)
+}
+
+func TestAEADCipherDecryptDatagramShort(t *testing.T) {
+	dec := &aeadCipher{hasKey: true}
+
+	pt, err := dec.decryptDatagram([]byte{1, 2, 3})
+
+	assert.ErrorIs(t, err, ErrShortMessage)
+	assert.Nil(t, pt)
+}
+
+func TestAEADCipherDecryptDatagramForged(t *testing.T) {
+	enc := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	dec := &aeadCipher{hasKey: true, k: [aeadKeySize]byte{1, 2, 3}}
+	msg := enc.encryptDatagram([]byte("text"))
+	msg[len(msg)-1] ^= 0xff
+
+	pt, err := dec.decryptDatagram(msg)
+
+	assert.ErrorIs(t, err, ErrDecrypt)
+	assert.Nil(t, pt)
+	assert.False(t, dec.window.seen)
+}
+
+func TestReplayWindow(t *testing.T) {
+	obj := &replayWindow{}
+
+	assert.True(t, obj.check(5))
+	obj.update(5)
+	assert.False(t, obj.check(5))
+	assert.True(t, obj.check(3))
+	obj.update(3)
+	assert.False(t, obj.check(3))
+	assert.True(t, obj.check(4))
+	assert.True(t, obj.check(70))
+	obj.update(70)
+	assert.False(t, obj.check(5))
+	assert.True(t, obj.check(7))
+	obj.update(200)
+	assert.False(t, obj.check(70))
+	assert.True(t, obj.check(199))
+	assert.False(t, obj.check(200))
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import "sync"
+
+// Audit event types.
+const (
+	AuditAccept      = "accept"       // A conduit was accepted by a listener
+	AuditSoftLimit   = "soft-limit"   // A conduit exceeded a soft usage limit
+	AuditHardLimit   = "hard-limit"   // A conduit was closed for exceeding a hard usage limit
+	AuditPeerTimeout = "peer-timeout" // A conduit's peer stopped sending keepalives
+)
+
+// AuditEvent describes an event of interest to operators.
+type AuditEvent struct {
+	Event   string   // The type of event
+	Conduit *Conduit // The conduit the event concerns
+	Err     error    // Error describing the event, if any
+}
+
+// Auditor is an interface for receivers of audit events.
+type Auditor interface {
+	// Audit is called with each audit event.  It must not block.
+	Audit(ev *AuditEvent)
+}
+
+// AuditorFunc is an adaptor allowing an ordinary function to be used
+// as an Auditor.
+type AuditorFunc func(ev *AuditEvent)
+
+// Audit is called with each audit event.  It must not block.
+func (f AuditorFunc) Audit(ev *AuditEvent) {
+	f(ev)
+}
+
+// auditor is the current auditor.
+var (
+	auditor     Auditor
+	auditorLock sync.RWMutex
+)
+
+// SetAuditor sets the auditor to receive audit events, returning the
+// previous auditor.  Passing nil disables auditing.
+func SetAuditor(a Auditor) Auditor {
+	auditorLock.Lock()
+	defer auditorLock.Unlock()
+
+	prev := auditor
+	auditor = a
+
+	return prev
+}
+
+// audit reports an audit event to the auditor, if one is set.
+func audit(event string, c *Conduit) {
+	auditError(event, c, nil)
+}
+
+// auditError reports an audit event described by an error to the
+// auditor, if one is set.
+func auditError(event string, c *Conduit, err error) {
+	auditorLock.RLock()
+	a := auditor
+	auditorLock.RUnlock()
+
+	if a != nil {
+		a.Audit(&AuditEvent{
+			Event:   event,
+			Conduit: c,
+			Err:     err,
+		})
+	}
+}
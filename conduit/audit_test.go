@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditorFuncImplementsAuditor(t *testing.T) {
+	assert.Implements(t, (*Auditor)(nil), AuditorFunc(nil))
+}
+
+func TestAuditorFuncAudit(t *testing.T) {
+	ev := &AuditEvent{}
+	called := false
+	obj := AuditorFunc(func(e *AuditEvent) {
+		assert.Same(t, ev, e)
+		called = true
+	})
+
+	obj.Audit(ev)
+
+	assert.True(t, called)
+}
+
+func TestSetAuditor(t *testing.T) {
+	a1 := AuditorFunc(func(ev *AuditEvent) {})
+	defer SetAuditor(SetAuditor(nil))
+
+	result1 := SetAuditor(a1)
+	result2 := SetAuditor(nil)
+
+	assert.Nil(t, result1)
+	assert.NotNil(t, result2)
+}
+
+func TestAuditBase(t *testing.T) {
+	c := &Conduit{}
+	events := []*AuditEvent{}
+	defer SetAuditor(SetAuditor(AuditorFunc(func(ev *AuditEvent) {
+		events = append(events, ev)
+	})))
+
+	audit(AuditAccept, c)
+
+	assert.Equal(t, []*AuditEvent{{Event: AuditAccept, Conduit: c}}, events)
+}
+
+func TestAuditErrorBase(t *testing.T) {
+	c := &Conduit{}
+	events := []*AuditEvent{}
+	defer SetAuditor(SetAuditor(AuditorFunc(func(ev *AuditEvent) {
+		events = append(events, ev)
+	})))
+
+	auditError(AuditSoftLimit, c, assert.AnError)
+
+	assert.Equal(t, []*AuditEvent{{Event: AuditSoftLimit, Conduit: c, Err: assert.AnError}}, events)
+}
+
+func TestAuditNoAuditor(t *testing.T) {
+	defer SetAuditor(SetAuditor(nil))
+
+	audit(AuditAccept, &Conduit{})
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"slices"
+)
+
+// Authorizer is invoked after a TLS handshake with the peer
+// certificate chain, leaf first; if the chain was verified, it is the
+// first verified chain.  The chain is empty if the peer presented no
+// certificate.  It returns the name of the principal, which is set
+// as the Principal of the conduit, or an error to reject the
+// conduit.
+type Authorizer interface {
+	// Authorize authorizes the peer, returning the name of the
+	// principal.
+	Authorize(chain []*x509.Certificate) (string, error)
+}
+
+// AuthorizerFunc is an adaptor allowing a function to be used as an
+// Authorizer.
+type AuthorizerFunc func(chain []*x509.Certificate) (string, error)
+
+// Authorize authorizes the peer, returning the name of the principal.
+func (f AuthorizerFunc) Authorize(chain []*x509.Certificate) (string, error) {
+	return f(chain)
+}
+
+// peerChain returns the peer certificate chain of a TLS session.
+func peerChain(cs *tls.ConnectionState) []*x509.Certificate {
+	if len(cs.VerifiedChains) > 0 {
+		return cs.VerifiedChains[0]
+	}
+
+	return cs.PeerCertificates
+}
+
+// authorize invokes the authorizer for a TLS session, returning the
+// name of the principal.  If the authorizer is nil, the common name
+// of the peer certificate, if any, is used, and no peer is rejected.
+func authorize(auth Authorizer, cs *tls.ConnectionState) (string, error) {
+	chain := peerChain(cs)
+	if auth == nil {
+		if len(chain) > 0 {
+			return chain[0].Subject.CommonName, nil
+		}
+		return "", nil
+	}
+
+	principal, err := auth.Authorize(chain)
+	if err != nil {
+		return "", err
+	}
+
+	return principal, nil
+}
+
+// leaf returns the leaf certificate of a chain.
+func leaf(chain []*x509.Certificate) (*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no peer certificate: %w", ErrUnauthorized)
+	}
+
+	return chain[0], nil
+}
+
+// allowed checks a principal against an allow list; an empty list
+// allows all principals.
+func allowed(allow []string, principal string) error {
+	if len(allow) > 0 && !slices.Contains(allow, principal) {
+		return fmt.Errorf("principal %q: %w", principal, ErrUnauthorized)
+	}
+
+	return nil
+}
+
+// CommonNameAuthorizer is an Authorizer identifying the peer by the
+// common name of its certificate.  If Allow is not empty, only the
+// listed common names are authorized.
+type CommonNameAuthorizer struct {
+	Allow []string // Authorized common names; if empty, all are authorized
+}
+
+// Authorize authorizes the peer, returning the name of the principal.
+func (a *CommonNameAuthorizer) Authorize(chain []*x509.Certificate) (string, error) {
+	cert, err := leaf(chain)
+	if err != nil {
+		return "", err
+	}
+	if cert.Subject.CommonName == "" {
+		return "", fmt.Errorf("no common name: %w", ErrUnauthorized)
+	}
+	if err := allowed(a.Allow, cert.Subject.CommonName); err != nil {
+		return "", err
+	}
+
+	return cert.Subject.CommonName, nil
+}
+
+// SANs returns the subject alternative names of a certificate: the
+// URIs, followed by the DNS names, email addresses, and IP addresses.
+func SANs(cert *x509.Certificate) []string {
+	result := []string{}
+	for _, u := range cert.URIs {
+		result = append(result, u.String())
+	}
+	result = append(result, cert.DNSNames...)
+	result = append(result, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		result = append(result, ip.String())
+	}
+
+	return result
+}
+
+// SANAuthorizer is an Authorizer identifying the peer by a subject
+// alternative name of its certificate, as returned by SANs.  If Allow
+// is empty, the first name is the principal; otherwise, the first
+// name in the allow list is the principal, and peers with none of the
+// listed names are rejected.
+type SANAuthorizer struct {
+	Allow []string // Authorized names; if empty, all are authorized
+}
+
+// Authorize authorizes the peer, returning the name of the principal.
+func (a *SANAuthorizer) Authorize(chain []*x509.Certificate) (string, error) {
+	cert, err := leaf(chain)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range SANs(cert) {
+		if allowed(a.Allow, name) == nil {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no authorized subject alternative name: %w", ErrUnauthorized)
+}
+
+// SPKIFingerprint returns the fingerprint of the public key of a
+// certificate, in the form "sha256:" followed by the hex-encoded
+// SHA-256 hash of the subject public key info.
+func SPKIFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// SPKIAuthorizer is an Authorizer identifying the peer by the
+// fingerprint of the public key of its certificate, as returned by
+// SPKIFingerprint.  If Allow is not empty, only the listed
+// fingerprints are authorized; this permits pinning self-signed
+// certificates.
+type SPKIAuthorizer struct {
+	Allow []string // Authorized fingerprints; if empty, all are authorized
+}
+
+// Authorize authorizes the peer, returning the name of the principal.
+func (a *SPKIAuthorizer) Authorize(chain []*x509.Certificate) (string, error) {
+	cert, err := leaf(chain)
+	if err != nil {
+		return "", err
+	}
+	fp := SPKIFingerprint(cert)
+	if err := allowed(a.Allow, fp); err != nil {
+		return "", err
+	}
+
+	return fp, nil
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert generates a self-signed certificate from a template.
+func testCert(t *testing.T, tmpl *x509.Certificate) *x509.Certificate {
+	cert, _ := testCertKey(t, tmpl)
+
+	return cert
+}
+
+// testCertKey generates a self-signed certificate from a template,
+// returning the certificate and its key.
+func testCertKey(t *testing.T, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(1)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert, key
+}
+
+func TestAuthorizerFuncImplementsAuthorizer(t *testing.T) {
+	assert.Implements(t, (*Authorizer)(nil), AuthorizerFunc(nil))
+}
+
+func TestAuthorizerFuncAuthorize(t *testing.T) {
+	chain := []*x509.Certificate{{}}
+	var actual []*x509.Certificate
+	obj := AuthorizerFunc(func(c []*x509.Certificate) (string, error) {
+		actual = c
+		return "principal", nil
+	})
+
+	result, err := obj.Authorize(chain)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "principal", result)
+	assert.Equal(t, chain, actual)
+}
+
+func TestPeerChainVerified(t *testing.T) {
+	cert1 := &x509.Certificate{Raw: []byte("one")}
+	cert2 := &x509.Certificate{Raw: []byte("two")}
+	cs := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert1},
+		VerifiedChains:   [][]*x509.Certificate{{cert2}, {cert1}},
+	}
+
+	result := peerChain(cs)
+
+	assert.Equal(t, []*x509.Certificate{cert2}, result)
+}
+
+func TestPeerChainUnverified(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("one")}
+	cs := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+
+	result := peerChain(cs)
+
+	assert.Equal(t, []*x509.Certificate{cert}, result)
+}
+
+func TestAuthorizeDefault(t *testing.T) {
+	cs := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "peer"}}},
+	}
+
+	result, err := authorize(nil, cs)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "peer", result)
+}
+
+func TestAuthorizeDefaultNoCert(t *testing.T) {
+	result, err := authorize(nil, &tls.ConnectionState{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "", result)
+}
+
+func TestAuthorizeBase(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("one")}
+	cs := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+	var actual []*x509.Certificate
+	auth := AuthorizerFunc(func(c []*x509.Certificate) (string, error) {
+		actual = c
+		return "principal", nil
+	})
+
+	result, err := authorize(auth, cs)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "principal", result)
+	assert.Equal(t, []*x509.Certificate{cert}, actual)
+}
+
+func TestAuthorizeError(t *testing.T) {
+	auth := AuthorizerFunc(func(c []*x509.Certificate) (string, error) {
+		return "principal", assert.AnError
+	})
+
+	result, err := authorize(auth, &tls.ConnectionState{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, "", result)
+}
+
+func TestAllowedEmpty(t *testing.T) {
+	err := allowed(nil, "anyone")
+
+	assert.NoError(t, err)
+}
+
+func TestAllowedListed(t *testing.T) {
+	err := allowed([]string{"one", "two"}, "two")
+
+	assert.NoError(t, err)
+}
+
+func TestAllowedUnlisted(t *testing.T) {
+	err := allowed([]string{"one", "two"}, "three")
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestCommonNameAuthorizerAuthorizeBase(t *testing.T) {
+	chain := []*x509.Certificate{{Subject: pkix.Name{CommonName: "peer"}}}
+	obj := &CommonNameAuthorizer{Allow: []string{"other", "peer"}}
+
+	result, err := obj.Authorize(chain)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "peer", result)
+}
+
+func TestCommonNameAuthorizerAuthorizeAny(t *testing.T) {
+	chain := []*x509.Certificate{{Subject: pkix.Name{CommonName: "peer"}}}
+	obj := &CommonNameAuthorizer{}
+
+	result, err := obj.Authorize(chain)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "peer", result)
+}
+
+func TestCommonNameAuthorizerAuthorizeNoCert(t *testing.T) {
+	obj := &CommonNameAuthorizer{}
+
+	result, err := obj.Authorize(nil)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestCommonNameAuthorizerAuthorizeNoName(t *testing.T) {
+	chain := []*x509.Certificate{{}}
+	obj := &CommonNameAuthorizer{}
+
+	result, err := obj.Authorize(chain)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestCommonNameAuthorizerAuthorizeRejected(t *testing.T) {
+	chain := []*x509.Certificate{{Subject: pkix.Name{CommonName: "peer"}}}
+	obj := &CommonNameAuthorizer{Allow: []string{"other"}}
+
+	result, err := obj.Authorize(chain)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestSANs(t *testing.T) {
+	cert := &x509.Certificate{
+		DNSNames:       []string{"peer.example.com"},
+		EmailAddresses: []string{"peer@example.com"},
+		IPAddresses:    []net.IP{net.IPv4(127, 0, 0, 1)},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/peer"}},
+	}
+
+	result := SANs(cert)
+
+	assert.Equal(t, []string{
+		"spiffe://example.com/peer",
+		"peer.example.com",
+		"peer@example.com",
+		"127.0.0.1",
+	}, result)
+}
+
+func TestSANsEmpty(t *testing.T) {
+	result := SANs(&x509.Certificate{})
+
+	assert.Equal(t, []string{}, result)
+}
+
+func TestSANAuthorizerAuthorizeBase(t *testing.T) {
+	chain := []*x509.Certificate{{DNSNames: []string{"one.example.com", "two.example.com"}}}
+	obj := &SANAuthorizer{Allow: []string{"two.example.com"}}
+
+	result, err := obj.Authorize(chain)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "two.example.com", result)
+}
+
+func TestSANAuthorizerAuthorizeAny(t *testing.T) {
+	chain := []*x509.Certificate{{DNSNames: []string{"one.example.com", "two.example.com"}}}
+	obj := &SANAuthorizer{}
+
+	result, err := obj.Authorize(chain)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "one.example.com", result)
+}
+
+func TestSANAuthorizerAuthorizeNoCert(t *testing.T) {
+	obj := &SANAuthorizer{}
+
+	result, err := obj.Authorize(nil)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestSANAuthorizerAuthorizeNoNames(t *testing.T) {
+	chain := []*x509.Certificate{{}}
+	obj := &SANAuthorizer{}
+
+	result, err := obj.Authorize(chain)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestSANAuthorizerAuthorizeRejected(t *testing.T) {
+	chain := []*x509.Certificate{{DNSNames: []string{"one.example.com"}}}
+	obj := &SANAuthorizer{Allow: []string{"two.example.com"}}
+
+	result, err := obj.Authorize(chain)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestSPKIFingerprint(t *testing.T) {
+	cert := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("spki")}
+
+	result := SPKIFingerprint(cert)
+
+	assert.Equal(t, "sha256:6feecc8c16c5551d9feb3eb5f77e2da773bf68bd9ef9c52927ceb2c86e56892b", result)
+}
+
+func TestSPKIFingerprintStable(t *testing.T) {
+	cert := testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "peer"}})
+
+	result := SPKIFingerprint(cert)
+
+	assert.Equal(t, result, SPKIFingerprint(cert))
+	assert.NotEqual(t, result, SPKIFingerprint(testCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "peer"}})))
+}
+
+func TestSPKIAuthorizerAuthorizeBase(t *testing.T) {
+	cert := testCert(t, &x509.Certificate{})
+	fp := SPKIFingerprint(cert)
+	obj := &SPKIAuthorizer{Allow: []string{fp}}
+
+	result, err := obj.Authorize([]*x509.Certificate{cert})
+
+	assert.NoError(t, err)
+	assert.Equal(t, fp, result)
+}
+
+func TestSPKIAuthorizerAuthorizeNoCert(t *testing.T) {
+	obj := &SPKIAuthorizer{}
+
+	result, err := obj.Authorize(nil)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestSPKIAuthorizerAuthorizeRejected(t *testing.T) {
+	cert := testCert(t, &x509.Certificate{})
+	obj := &SPKIAuthorizer{Allow: []string{"sha256:00"}}
+
+	result, err := obj.Authorize([]*x509.Certificate{cert})
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import "crypto/tls"
+
+// Parameters of the TLS exporter channel binding, as defined by RFC
+// 9266.
+const (
+	BindingLabel = "EXPORTER-Channel-Binding" // Exporter label
+	BindingSize  = 32                         // Size of the binding
+)
+
+// tlsBinding returns the channel binding of a TLS session, or nil if
+// it cannot be exported.
+func tlsBinding(cs *tls.ConnectionState) []byte {
+	binding, err := cs.ExportKeyingMaterial(BindingLabel, nil, BindingSize)
+	if err != nil {
+		return nil
+	}
+
+	return binding
+}
@@ -0,0 +1,399 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// BundlePolicy selects how a Bundle spreads frames across its
+// members.
+type BundlePolicy int
+
+// Defined bundle policies.
+const (
+	BundleFailover BundlePolicy = iota // Send over the best member; others are standbys
+	BundleSpread                       // Spread frames across members in proportion to their quality
+)
+
+// Parameters for estimating the quality of bundle members.
+const (
+	DefaultBundleRTT  = time.Second // RTT assumed for members not yet measured
+	BundleLossPenalty = 10.0        // Factor by which loss inflates the effective RTT
+	bundleLossWeight  = 1.0 / 8     // Weight of each ping in the loss estimate
+)
+
+// BundlePath describes a member of a bundle, as returned by
+// Bundle.Paths.
+type BundlePath struct {
+	Conduit *Conduit      // The member conduit
+	RTT     time.Duration // Round-trip time estimate; 0 if not yet measured
+	Loss    float64       // Estimated fraction of pings lost, 0 to 1
+	Up      bool          // False if the peer has stopped answering pings
+}
+
+// bundleMember is a member of a bundle.
+type bundleMember struct {
+	c        *Conduit      // The member conduit
+	pinger   *proto.Pinger // Measures the RTT and loss of the member
+	loss     float64       // Estimated loss
+	awaiting bool          // The last ping has not been answered
+	down     bool          // Peer has stopped answering pings
+	current  float64       // Current weight, for BundleSpread
+}
+
+// score returns the effective RTT of the member, inflated by its
+// loss; lower is better.  Must be called with the bundle lock held.
+func (m *bundleMember) score() float64 {
+	rtt, _ := m.c.RTTEstimate()
+	if rtt <= 0 {
+		rtt = DefaultBundleRTT
+	}
+
+	return float64(rtt) * (1 + BundleLossPenalty*m.loss)
+}
+
+// Bundle maintains multiple conduits to the same peer, such as
+// conduits over different interfaces or transports, and presents
+// them as a single logical conduit.  Each member is pinged to
+// estimate its round-trip time and loss, and frames sent are placed
+// on the members according to the policy; when sending over a member
+// fails, the member is removed, and the frame is sent over another.
+// Members whose peers stop answering pings are only used if no other
+// members remain.  Frames received over any member are returned by
+// Recv; frames sent over different members may be received out of
+// order.
+//
+// The bundle reads from its members, so the members must not be used
+// directly once added.  The peer must answer pings, as a Bundle or a
+// node does.  The exported fields must be set before adding members.
+type Bundle struct {
+	Policy        BundlePolicy                // How frames are spread across members
+	PingInterval  time.Duration               // Interval between pings of each member
+	PingMaxMissed int                         // Unanswered pings before a member is down
+	OnRemove      func(c *Conduit, err error) // Called when a failed member is removed; may be nil
+
+	lock    sync.Mutex        // Protects the members
+	once    sync.Once         // Controls initialization
+	members []*bundleMember   // The members, in the order added
+	frames  chan *proto.Frame // Frames received over the members
+	done    chan struct{}     // Closed when the bundle is closed
+	closed  bool              // Bundle has been closed
+	wg      sync.WaitGroup    // Tracks the member readers
+}
+
+// init initializes the bundle.
+func (b *Bundle) init() {
+	b.once.Do(func() {
+		b.frames = make(chan *proto.Frame)
+		b.done = make(chan struct{})
+	})
+}
+
+// Add adds a conduit to the bundle.  It begins pinging the conduit
+// and reading from it.
+func (b *Bundle) Add(c *Conduit) error {
+	b.init()
+	m := &bundleMember{c: c}
+	m.pinger = c.Pinger(b.PingInterval, b.PingMaxMissed, func() {
+		b.setDown(m, true)
+	})
+	m.pinger.OnResponsive = func() {
+		b.setDown(m, false)
+	}
+	onRTT := m.pinger.OnRTT
+	m.pinger.OnRTT = func(rtt, dev time.Duration) {
+		onRTT(rtt, dev)
+		b.answered(m)
+	}
+	m.pinger.Send = func(f *proto.Frame) error {
+		if isPing(f) {
+			b.pinged(m)
+		}
+		return c.Send(f)
+	}
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return ErrBundleClosed
+	}
+	b.members = append(b.members, m)
+	b.wg.Add(1)
+	b.lock.Unlock()
+
+	m.pinger.Start()
+	go b.read(m)
+
+	return nil
+}
+
+// isPing tests if a frame is a ping.
+func isPing(f *proto.Frame) bool {
+	if f.Protocol() != proto.ProtoControl {
+		return false
+	}
+	msg := &proto.ControlMessage{}
+	if _, err := msg.FromBytes(f.Payload); err != nil {
+		return false
+	}
+
+	return msg.Type == proto.ControlPing
+}
+
+// pinged updates the loss estimate of a member when a ping is sent.
+// The previous ping counts as lost if it was not answered.
+func (b *Bundle) pinged(m *bundleMember) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	m.loss *= 1 - bundleLossWeight
+	if m.awaiting {
+		m.loss += bundleLossWeight
+	}
+	m.awaiting = true
+}
+
+// answered records that a ping over a member was answered.
+func (b *Bundle) answered(m *bundleMember) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	m.awaiting = false
+}
+
+// setDown marks a member down or up.
+func (b *Bundle) setDown(m *bundleMember, down bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	m.down = down
+}
+
+// read reads frames from a member until it fails.  Ping-related
+// control messages are handled by the member's pinger.
+func (b *Bundle) read(m *bundleMember) {
+	defer b.wg.Done()
+
+	for {
+		f, err := m.c.Recv()
+		if err != nil {
+			b.fail(m, err)
+			return
+		}
+
+		if f.Protocol() == proto.ProtoControl {
+			msg := &proto.ControlMessage{}
+			if _, err := msg.FromBytes(f.Payload); err == nil && m.pinger.Handle(msg) {
+				continue
+			}
+		}
+
+		select {
+		case b.frames <- f:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// detach removes a member from the bundle, returning false if it had
+// already been removed.
+func (b *Bundle) detach(m *bundleMember) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i, other := range b.members {
+		if other == m {
+			b.members = append(b.members[:i:i], b.members[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// fail removes a failed member from the bundle and closes it.
+func (b *Bundle) fail(m *bundleMember, err error) {
+	if !b.detach(m) {
+		return
+	}
+
+	m.pinger.Stop()
+	m.c.Close() //nolint:errcheck
+	if b.OnRemove != nil {
+		b.OnRemove(m.c, err)
+	}
+}
+
+// Remove removes a conduit from the bundle and closes it.  It returns
+// false if the conduit is not a member.
+func (b *Bundle) Remove(c *Conduit) bool {
+	b.lock.Lock()
+	var m *bundleMember
+	for _, other := range b.members {
+		if other.c == c {
+			m = other
+			break
+		}
+	}
+	b.lock.Unlock()
+
+	if m == nil || !b.detach(m) {
+		return false
+	}
+	m.pinger.Stop()
+	c.Close() //nolint:errcheck
+
+	return true
+}
+
+// Members returns the member conduits, in the order added.
+func (b *Bundle) Members() []*Conduit {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	result := make([]*Conduit, len(b.members))
+	for i, m := range b.members {
+		result[i] = m.c
+	}
+
+	return result
+}
+
+// Paths describes the members, in the order added.
+func (b *Bundle) Paths() []BundlePath {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	result := make([]BundlePath, len(b.members))
+	for i, m := range b.members {
+		rtt, _ := m.c.RTTEstimate()
+		result[i] = BundlePath{Conduit: m.c, RTT: rtt, Loss: m.loss, Up: !m.down}
+	}
+
+	return result
+}
+
+// pick selects the member over which to send a frame, or nil if
+// there are none.  Members which are down are considered only if all
+// the members are down.
+func (b *Bundle) pick() *bundleMember {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	candidates := make([]*bundleMember, 0, len(b.members))
+	for _, m := range b.members {
+		if !m.down {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = b.members
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Select the member with the lowest score
+	if b.Policy != BundleSpread {
+		best := candidates[0]
+		for _, m := range candidates[1:] {
+			if m.score() < best.score() {
+				best = m
+			}
+		}
+		return best
+	}
+
+	// Smooth weighted round-robin, weighting by inverse score
+	var best *bundleMember
+	total := 0.0
+	for _, m := range candidates {
+		weight := 1 / m.score()
+		m.current += weight
+		total += weight
+		if best == nil || m.current > best.current {
+			best = m
+		}
+	}
+	best.current -= total
+
+	return best
+}
+
+// Send sends a frame over a member of the bundle selected by the
+// policy.  If sending fails, the member is removed, and the frame is
+// sent over another member.  If no members remain, ErrNoMembers is
+// returned.
+func (b *Bundle) Send(frame *proto.Frame) error {
+	for {
+		m := b.pick()
+		if m == nil {
+			return ErrNoMembers
+		}
+
+		err := m.c.Send(frame)
+		if err == nil || encodeError(err) {
+			return err
+		}
+		b.fail(m, err)
+	}
+}
+
+// Recv receives the next frame received over any member of the
+// bundle.  It waits for members to be added if there are none.  If
+// the bundle is closed, ErrBundleClosed is returned.
+func (b *Bundle) Recv(ctx context.Context) (*proto.Frame, error) {
+	b.init()
+
+	select {
+	case f := <-b.frames:
+		return f, nil
+	case <-b.done:
+		return nil, ErrBundleClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the bundle and all its members, and waits for the
+// member readers to exit.
+func (b *Bundle) Close() error {
+	b.init()
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return nil
+	}
+	b.closed = true
+	members := b.members
+	b.members = nil
+	close(b.done)
+	b.lock.Unlock()
+
+	for _, m := range members {
+		m.pinger.Stop()
+		m.c.Close() //nolint:errcheck
+	}
+	b.wg.Wait()
+
+	return nil
+}
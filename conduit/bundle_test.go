@@ -0,0 +1,300 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// bundlePeer is the peer end of a bundle member.
+type bundlePeer struct {
+	c      *Conduit          // The peer conduit
+	frames chan *proto.Frame // Frames received, other than pings
+}
+
+// bundlePipe constructs a conduit for use as a bundle member, along
+// with its peer, which answers pings and collects the other frames
+// received.
+func bundlePipe(t *testing.T) (*Conduit, *bundlePeer) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	peer := &bundlePeer{
+		c:      &Conduit{Link: remote, Integrity: true},
+		frames: make(chan *proto.Frame, 10),
+	}
+	go func() {
+		for {
+			f, err := peer.c.Recv()
+			if err != nil {
+				return
+			}
+			msg := &proto.ControlMessage{}
+			if f.Protocol() == proto.ProtoControl {
+				if _, err := msg.FromBytes(f.Payload); err == nil && msg.Type == proto.ControlPing {
+					msg.Type = proto.ControlPong
+					peer.c.Send(msg.Frame()) //nolint:errcheck
+					continue
+				}
+			}
+			peer.frames <- f
+		}
+	}()
+
+	return &Conduit{Link: local, Integrity: true}, peer
+}
+
+// bundleFrame constructs a frame for testing.
+func bundleFrame(payload string) *proto.Frame {
+	return &proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte(payload)}
+}
+
+func TestBundleMemberScoreUnmeasured(t *testing.T) {
+	obj := &bundleMember{c: &Conduit{}}
+
+	result := obj.score()
+
+	assert.Equal(t, float64(DefaultBundleRTT), result)
+}
+
+func TestBundleMemberScoreLoss(t *testing.T) {
+	obj := &bundleMember{c: &Conduit{RTT: 1000}, loss: 0.5}
+
+	result := obj.score()
+
+	assert.Equal(t, float64(6*time.Millisecond), result)
+}
+
+func TestIsPing(t *testing.T) {
+	ping := &proto.ControlMessage{Type: proto.ControlPing, Body: make([]byte, proto.PingSize)}
+	pong := &proto.ControlMessage{Type: proto.ControlPong, Body: make([]byte, proto.PingSize)}
+
+	assert.True(t, isPing(ping.Frame()))
+	assert.False(t, isPing(pong.Frame()))
+	assert.False(t, isPing(bundleFrame("x")))
+	assert.False(t, isPing(&proto.Frame{Header: proto.Header{Protocol: proto.ProtoControl}}))
+}
+
+func TestBundlePingedLoss(t *testing.T) {
+	obj := &Bundle{}
+	m := &bundleMember{}
+
+	obj.pinged(m)
+	obj.pinged(m)
+	obj.answered(m)
+	obj.pinged(m)
+
+	assert.InDelta(t, bundleLossWeight*(1-bundleLossWeight), m.loss, 1e-9)
+	assert.True(t, m.awaiting)
+}
+
+func TestBundlePickEmpty(t *testing.T) {
+	obj := &Bundle{}
+
+	result := obj.pick()
+
+	assert.Nil(t, result)
+}
+
+func TestBundlePickFailover(t *testing.T) {
+	m1 := &bundleMember{c: &Conduit{RTT: 50000}}
+	m2 := &bundleMember{c: &Conduit{RTT: 10000}}
+	m3 := &bundleMember{c: &Conduit{RTT: 1000}, down: true}
+	obj := &Bundle{members: []*bundleMember{m1, m2, m3}}
+
+	result := obj.pick()
+
+	assert.Same(t, m2, result)
+}
+
+func TestBundlePickFailoverLoss(t *testing.T) {
+	m1 := &bundleMember{c: &Conduit{RTT: 10000}, loss: 0.5}
+	m2 := &bundleMember{c: &Conduit{RTT: 20000}}
+	obj := &Bundle{members: []*bundleMember{m1, m2}}
+
+	result := obj.pick()
+
+	assert.Same(t, m2, result)
+}
+
+func TestBundlePickAllDown(t *testing.T) {
+	m1 := &bundleMember{c: &Conduit{RTT: 50000}, down: true}
+	m2 := &bundleMember{c: &Conduit{RTT: 10000}, down: true}
+	obj := &Bundle{members: []*bundleMember{m1, m2}}
+
+	result := obj.pick()
+
+	assert.Same(t, m2, result)
+}
+
+func TestBundlePickSpread(t *testing.T) {
+	m1 := &bundleMember{c: &Conduit{RTT: 30000}}
+	m2 := &bundleMember{c: &Conduit{RTT: 10000}}
+	obj := &Bundle{Policy: BundleSpread, members: []*bundleMember{m1, m2}}
+	counts := map[*bundleMember]int{}
+
+	for range 8 {
+		counts[obj.pick()]++
+	}
+
+	assert.Equal(t, map[*bundleMember]int{m1: 2, m2: 6}, counts)
+}
+
+func TestBundleSendBase(t *testing.T) {
+	c1, p1 := bundlePipe(t)
+	obj := &Bundle{}
+	defer obj.Close()
+	require.NoError(t, obj.Add(c1))
+
+	err := obj.Send(bundleFrame("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), (<-p1.frames).Payload)
+}
+
+func TestBundleSendFailover(t *testing.T) {
+	c1, p1 := bundlePipe(t)
+	c2, p2 := bundlePipe(t)
+	c1.updateRTT(time.Millisecond, 0)
+	c2.updateRTT(10*time.Millisecond, 0)
+	removed := make(chan *Conduit, 1)
+	obj := &Bundle{OnRemove: func(c *Conduit, err error) {
+		removed <- c
+	}}
+	defer obj.Close()
+	require.NoError(t, obj.Add(c1))
+	require.NoError(t, obj.Add(c2))
+	p1.c.Link.Close()
+
+	err := obj.Send(bundleFrame("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), (<-p2.frames).Payload)
+	assert.Same(t, c1, <-removed)
+	assert.Equal(t, []*Conduit{c2}, obj.Members())
+}
+
+func TestBundleSendNoMembers(t *testing.T) {
+	obj := &Bundle{}
+
+	err := obj.Send(bundleFrame("hello"))
+
+	assert.ErrorIs(t, err, ErrNoMembers)
+}
+
+func TestBundleRecvBase(t *testing.T) {
+	c1, p1 := bundlePipe(t)
+	c2, p2 := bundlePipe(t)
+	obj := &Bundle{}
+	defer obj.Close()
+	require.NoError(t, obj.Add(c1))
+	require.NoError(t, obj.Add(c2))
+	go p1.c.Send(bundleFrame("one")) //nolint:errcheck
+	go p2.c.Send(bundleFrame("two")) //nolint:errcheck
+
+	result1, err1 := obj.Recv(context.Background())
+	result2, err2 := obj.Recv(context.Background())
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.ElementsMatch(t, []string{"one", "two"}, []string{string(result1.Payload), string(result2.Payload)})
+}
+
+func TestBundleRecvContext(t *testing.T) {
+	obj := &Bundle{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := obj.Recv(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestBundleRecvClosed(t *testing.T) {
+	obj := &Bundle{}
+	obj.Close()
+
+	result, err := obj.Recv(context.Background())
+
+	assert.ErrorIs(t, err, ErrBundleClosed)
+	assert.Nil(t, result)
+}
+
+func TestBundlePings(t *testing.T) {
+	c1, _ := bundlePipe(t)
+	obj := &Bundle{PingInterval: time.Millisecond}
+	defer obj.Close()
+
+	require.NoError(t, obj.Add(c1))
+
+	require.Eventually(t, func() bool {
+		paths := obj.Paths()
+		return len(paths) == 1 && paths[0].RTT > 0
+	}, 5*time.Second, time.Millisecond)
+	paths := obj.Paths()
+	assert.Same(t, c1, paths[0].Conduit)
+	assert.True(t, paths[0].Up)
+}
+
+func TestBundlePathsDown(t *testing.T) {
+	m := &bundleMember{c: &Conduit{RTT: 1000}, loss: 0.25, down: true}
+	obj := &Bundle{members: []*bundleMember{m}}
+
+	result := obj.Paths()
+
+	assert.Equal(t, []BundlePath{{Conduit: m.c, RTT: time.Millisecond, Loss: 0.25}}, result)
+}
+
+func TestBundleRemove(t *testing.T) {
+	c1, _ := bundlePipe(t)
+	c2, _ := bundlePipe(t)
+	obj := &Bundle{OnRemove: func(c *Conduit, err error) {
+		t.Error("unexpected OnRemove")
+	}}
+	defer obj.Close()
+	require.NoError(t, obj.Add(c1))
+
+	assert.True(t, obj.Remove(c1))
+	assert.False(t, obj.Remove(c2))
+	assert.Empty(t, obj.Members())
+	_, err := c1.Link.Write([]byte{0})
+	assert.Error(t, err)
+	assert.Equal(t, Closed, c1.State)
+	assert.ErrorIs(t, context.Cause(c1.Context()), ErrConduitClosed)
+}
+
+func TestBundleClose(t *testing.T) {
+	c1, _ := bundlePipe(t)
+	obj := &Bundle{}
+	require.NoError(t, obj.Add(c1))
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.Empty(t, obj.Members())
+	assert.ErrorIs(t, obj.Add(c1), ErrBundleClosed)
+	assert.NoError(t, obj.Close())
+}
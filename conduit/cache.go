@@ -0,0 +1,202 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Defaults for Cache.
+const (
+	DefaultCacheTTL   = 30 * time.Second // Default time results lacking a TTL are cached
+	DefaultCacheStale = 5 * time.Minute  // Default time expired results may be served
+)
+
+// cacheEntry is a cached canonicalization.
+type cacheEntry struct {
+	res     []*Resolution // The resolutions
+	expires time.Time     // When the entry must be refreshed
+}
+
+// Cache caches the results of canonicalizing conduit URIs, including
+// the results of discovery mechanisms, so that repeatedly dialing a
+// peer does not repeat the lookups.  A result is cached for the
+// least of the TTLs of its resolutions, as reported by the resolver
+// or discovery mechanism; if none of them has a TTL, the configured
+// TTL is used.  Once a result expires, the next canonicalization of
+// the URI repeats the lookups; if that fails, the expired result
+// continues to be served until it is older than the stale limit, so
+// that peers remain reachable during resolver outages.  Failed
+// lookups are not cached.  A Cache is used by canonicalization when
+// it is carried by the context, as set by WithCache.  The zero value
+// is ready to use; a Cache must not be copied after first use.
+type Cache struct {
+	TTL   time.Duration // Time results lacking a TTL are cached; if zero, DefaultCacheTTL is used
+	Stale time.Duration // Time expired results may be served; if zero, DefaultCacheStale is used, and if negative, they are not served
+
+	lock    sync.Mutex             // Protects the entries
+	entries map[string]*cacheEntry // Cached results, by URI
+}
+
+// ttl returns the time a result may be cached.
+func (c *Cache) ttl(res []*Resolution) time.Duration {
+	var result time.Duration
+	for _, r := range res {
+		if r.TTL > 0 && (result == 0 || r.TTL < result) {
+			result = r.TTL
+		}
+	}
+	if result == 0 {
+		result = c.TTL
+		if result <= 0 {
+			result = DefaultCacheTTL
+		}
+	}
+
+	return result
+}
+
+// stale returns the time expired results may be served.
+func (c *Cache) stale() time.Duration {
+	switch {
+	case c.Stale < 0:
+		return 0
+	case c.Stale == 0:
+		return DefaultCacheStale
+	default:
+		return c.Stale
+	}
+}
+
+// get retrieves the cached result for a URI, and whether it has
+// expired.  It returns nil if there is no result or it is too stale
+// to serve.
+func (c *Cache) get(key string, now time.Time) ([]*Resolution, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	switch {
+	case !ok:
+		return nil, false
+	case now.Before(e.expires):
+		return e.res, false
+	case now.Before(e.expires.Add(c.stale())):
+		return e.res, true
+	default:
+		delete(c.entries, key)
+		return nil, false
+	}
+}
+
+// put caches the result for a URI, discarding any entries too stale
+// to serve.
+func (c *Cache) put(key string, res []*Resolution, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stale := c.stale()
+	for k, e := range c.entries {
+		if !now.Before(e.expires.Add(stale)) {
+			delete(c.entries, k)
+		}
+	}
+	if c.entries == nil {
+		c.entries = map[string]*cacheEntry{}
+	}
+	c.entries[key] = &cacheEntry{res: res, expires: now.Add(c.ttl(res))}
+}
+
+// lookup canonicalizes a URI, using the cached result if it has not
+// expired, and serving an expired result if canonicalization fails.
+func (c *Cache) lookup(ctx context.Context, u *URI) ([]*Resolution, error) {
+	key := u.String()
+	cached, expired := c.get(key, timeNow())
+	if cached != nil && !expired {
+		return append([]*Resolution{}, cached...), nil
+	}
+
+	res, err := u.resolve(ctx)
+	if err != nil {
+		if cached == nil || ctx.Err() != nil {
+			return nil, err
+		}
+		if l := getLogger(); l != nil {
+			l.Warn("serving stale canonicalization", append(uriArgs(u), "error", err)...)
+		}
+		return append([]*Resolution{}, cached...), nil
+	}
+	c.put(key, res, timeNow())
+
+	return append([]*Resolution{}, res...), nil
+}
+
+// Resolve canonicalizes a conduit URI as URI.ResolveContext does,
+// using the cache.
+func (c *Cache) Resolve(ctx context.Context, u *URI, r HostResolver) ([]*Resolution, error) {
+	return u.ResolveContext(WithCache(ctx, c), r)
+}
+
+// Canonicalize canonicalizes a conduit URI as URI.CanonicalizeContext
+// does, using the cache.
+func (c *Cache) Canonicalize(ctx context.Context, u *URI, r HostResolver) ([]*URI, error) {
+	return u.CanonicalizeContext(WithCache(ctx, c), r)
+}
+
+// Invalidate discards the cached result for a URI, so that the next
+// canonicalization repeats the lookups.
+func (c *Cache) Invalidate(u *URI) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, u.String())
+}
+
+// Flush discards all the cached results.
+func (c *Cache) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = nil
+}
+
+// Len returns the number of cached results, including expired
+// results that may still be served.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries)
+}
+
+// cacheKey is the context key for the Cache.
+type cacheKey struct{}
+
+// WithCache returns a copy of a context carrying a Cache, which is
+// used to cache the results of canonicalizing conduit URIs, such as
+// by DialAll and ListenAll.
+func WithCache(ctx context.Context, c *Cache) context.Context {
+	return context.WithValue(ctx, cacheKey{}, c)
+}
+
+// ContextCache returns the Cache carried by a context, as set by
+// WithCache, or nil if there is none.
+func ContextCache(ctx context.Context) *Cache {
+	c, _ := ctx.Value(cacheKey{}).(*Cache)
+
+	return c
+}
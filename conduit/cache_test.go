@@ -0,0 +1,227 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCacheTTLResolutions(t *testing.T) {
+	obj := &Cache{TTL: time.Hour}
+
+	result := obj.ttl([]*Resolution{{TTL: 2 * time.Minute}, {}, {TTL: time.Minute}})
+
+	assert.Equal(t, time.Minute, result)
+}
+
+func TestCacheTTLConfigured(t *testing.T) {
+	obj := &Cache{TTL: time.Hour}
+
+	result := obj.ttl([]*Resolution{{}})
+
+	assert.Equal(t, time.Hour, result)
+}
+
+func TestCacheTTLDefault(t *testing.T) {
+	obj := &Cache{}
+
+	result := obj.ttl(nil)
+
+	assert.Equal(t, DefaultCacheTTL, result)
+}
+
+func TestCacheStale(t *testing.T) {
+	assert.Equal(t, DefaultCacheStale, (&Cache{}).stale())
+	assert.Equal(t, time.Hour, (&Cache{Stale: time.Hour}).stale())
+	assert.Equal(t, time.Duration(0), (&Cache{Stale: -1}).stale())
+}
+
+func TestCacheResolve(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return([]net.IP{net.ParseIP("10.0.0.1")}, nil).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{}
+
+	first, err1 := obj.Resolve(context.Background(), u, r)
+	second, err2 := obj.Resolve(context.Background(), u, r)
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, first, second)
+	assert.Equal(t, "tcp://10.0.0.1:1234", second[0].URI.String())
+	assert.Equal(t, 1, obj.Len())
+	r.AssertExpectations(t)
+}
+
+func TestCacheCanonicalize(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return([]net.IP{net.ParseIP("10.0.0.1")}, nil).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{}
+	_, err := obj.Canonicalize(context.Background(), u, r)
+	assert.NoError(t, err)
+
+	result, err := obj.Canonicalize(context.Background(), u, r)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{mustParse("tcp://10.0.0.1:1234")}, result)
+	r.AssertExpectations(t)
+}
+
+func TestCacheResolveExpired(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return([]net.IP{net.ParseIP("10.0.0.2")}, nil).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{entries: map[string]*cacheEntry{
+		u.String(): {
+			res:     []*Resolution{{URI: mustParse("tcp://10.0.0.1:1234"), Source: SourceDNS}},
+			expires: time.Now().Add(-time.Second),
+		},
+	}}
+
+	result, err := obj.Canonicalize(context.Background(), u, r)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{mustParse("tcp://10.0.0.2:1234")}, result)
+	r.AssertExpectations(t)
+}
+
+func TestCacheResolveServesStale(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return(nil, assert.AnError).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{entries: map[string]*cacheEntry{
+		u.String(): {
+			res:     []*Resolution{{URI: mustParse("tcp://10.0.0.1:1234"), Source: SourceDNS}},
+			expires: time.Now().Add(-time.Second),
+		},
+	}}
+
+	result, err := obj.Canonicalize(context.Background(), u, r)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{mustParse("tcp://10.0.0.1:1234")}, result)
+	assert.Equal(t, 1, obj.Len())
+	r.AssertExpectations(t)
+}
+
+func TestCacheResolveTooStale(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return(nil, assert.AnError).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{Stale: time.Minute, entries: map[string]*cacheEntry{
+		u.String(): {
+			res:     []*Resolution{{URI: mustParse("tcp://10.0.0.1:1234"), Source: SourceDNS}},
+			expires: time.Now().Add(-time.Hour),
+		},
+	}}
+
+	result, err := obj.Canonicalize(context.Background(), u, r)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	assert.Equal(t, 0, obj.Len())
+	r.AssertExpectations(t)
+}
+
+func TestCacheResolveStaleCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return(nil, context.Canceled).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{entries: map[string]*cacheEntry{
+		u.String(): {
+			res:     []*Resolution{{URI: mustParse("tcp://10.0.0.1:1234"), Source: SourceDNS}},
+			expires: time.Now().Add(-time.Second),
+		},
+	}}
+
+	result, err := obj.Canonicalize(ctx, u, r)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestCacheResolveFailsUncached(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return(nil, assert.AnError).Once()
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{}
+
+	result, err := obj.Resolve(context.Background(), u, r)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	assert.Equal(t, 0, obj.Len())
+	r.AssertExpectations(t)
+}
+
+func TestCachePutDiscardsStale(t *testing.T) {
+	now := time.Now()
+	obj := &Cache{Stale: time.Minute, entries: map[string]*cacheEntry{
+		"old": {expires: now.Add(-time.Hour)},
+		"new": {expires: now.Add(-time.Second)},
+	}}
+
+	obj.put("key", []*Resolution{{TTL: time.Second}}, now)
+
+	assert.Equal(t, 2, obj.Len())
+	assert.Nil(t, obj.entries["old"])
+	assert.Equal(t, now.Add(time.Second), obj.entries["key"].expires)
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	u := mustParse("tcp://example.com:1234")
+	obj := &Cache{entries: map[string]*cacheEntry{
+		u.String(): {expires: time.Now().Add(time.Hour)},
+		"other":    {expires: time.Now().Add(time.Hour)},
+	}}
+
+	obj.Invalidate(u)
+
+	assert.Equal(t, 1, obj.Len())
+	assert.NotNil(t, obj.entries["other"])
+}
+
+func TestCacheFlush(t *testing.T) {
+	obj := &Cache{entries: map[string]*cacheEntry{
+		"key": {expires: time.Now().Add(time.Hour)},
+	}}
+
+	obj.Flush()
+
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestContextCacheDefault(t *testing.T) {
+	result := ContextCache(context.Background())
+
+	assert.Nil(t, result)
+}
+
+func TestContextCacheSet(t *testing.T) {
+	c := &Cache{}
+
+	result := ContextCache(WithCache(context.Background(), c))
+
+	assert.Same(t, c, result)
+}
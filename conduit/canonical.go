@@ -0,0 +1,188 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Source identifies where a canonical URI came from.
+type Source int
+
+// Sources of canonical URIs.
+const (
+	SourceLiteral   Source = iota // The URI was already canonical
+	SourceDNS                     // Resolved from DNS A or AAAA records
+	SourceSRV                     // The target of a DNS SRV record
+	SourceDiscovery               // Returned by a discovery mechanism
+)
+
+// sourceNames contains the names of the sources.
+var sourceNames = map[Source]string{
+	SourceLiteral:   "literal",
+	SourceDNS:       "dns",
+	SourceSRV:       "srv",
+	SourceDiscovery: "discovery",
+}
+
+// String returns the name of the source.
+func (s Source) String() string {
+	if name, ok := sourceNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Source(%d)", int(s))
+}
+
+// Resolution describes a single canonical URI resulting from
+// canonicalizing a conduit URI, along with where it came from.  It
+// allows tools to explain why a particular address is being dialed.
+type Resolution struct {
+	URI       *URI          // The canonical URI
+	Source    Source        // Where the URI came from
+	Name      string        // The host name or SRV target resolved, if any
+	Discovery string        // The discovery mechanism used, if any
+	TTL       time.Duration // Time the result may be cached; 0 if unknown
+	Priority  uint16        // Priority of the SRV record, if any; lower is preferred
+	Weight    uint16        // Weight of the SRV record, if any
+}
+
+// String returns a one-line description of the resolution.
+func (r *Resolution) String() string {
+	result := fmt.Sprintf("%s (%s", r.URI, r.Source)
+	if r.Discovery != "" {
+		result += " via " + r.Discovery
+	}
+	if r.Name != "" {
+		result += " " + r.Name
+	}
+	if r.TTL > 0 {
+		result += fmt.Sprintf(", ttl %s", r.TTL)
+	}
+
+	return result + ")"
+}
+
+// Resolver is an optional interface a Discovery mechanism may
+// implement to describe the sources of the URIs it returns.  Results
+// of discovery mechanisms not implementing Resolver are attributed to
+// SourceDiscovery.
+type Resolver interface {
+	// Resolve is passed a URI and returns a list of resolutions
+	// describing the canonical URIs retrieved from the discovery
+	// mechanism, in the same order Discover would return them.
+	// The lookup should be abandoned if the context is cancelled
+	// or its deadline passes.
+	Resolve(ctx context.Context, u *URI) ([]*Resolution, error)
+}
+
+// HostResolver looks up the addresses of host names and the port
+// numbers of service names while canonicalizing conduit URIs, and
+// the SRV records used by SRV discovery.  It is
+// implemented by *net.Resolver, which allows canonicalization to use
+// a specific DNS server, and may be implemented by other resolvers,
+// such as for split-horizon DNS or SecureResolver.  The lookups
+// should be abandoned if the context is cancelled or its deadline
+// passes.
+type HostResolver interface {
+	// LookupIP looks up the IP addresses of a host.  The network
+	// is "ip", "ip4", or "ip6".
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+
+	// LookupPort looks up the port number of a service for a
+	// transport.
+	LookupPort(ctx context.Context, network, service string) (int, error)
+
+	// LookupSRV looks up the SRV records of a service.  If the
+	// service and protocol are empty, the name is looked up
+	// directly; otherwise, "_service._proto.name" is looked up.
+	// It returns the canonical name and the records, sorted by
+	// priority.
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// systemResolver is the HostResolver used when none is specified.  It
+// uses the system's resolver.
+type systemResolver struct{}
+
+// LookupIP looks up the IP addresses of a host.
+func (systemResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return lookupIP(ctx, network, host)
+}
+
+// LookupPort looks up the port number of a service.
+func (systemResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	return lookupPort(ctx, network, service)
+}
+
+// LookupSRV looks up the SRV records of a service.
+func (systemResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return lookupSRV(ctx, service, proto, name)
+}
+
+// resolverKey is the context key for the HostResolver.
+type resolverKey struct{}
+
+// WithResolver returns a copy of a context carrying a HostResolver,
+// which is used to canonicalize conduit URIs when no resolver is
+// passed explicitly, such as by DialAll and ListenAll.  Discovery
+// mechanisms may retrieve it with ContextResolver.
+func WithResolver(ctx context.Context, r HostResolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, r)
+}
+
+// ContextResolver returns the HostResolver carried by a context, as
+// set by WithResolver, or a resolver using the system's resolver if
+// there is none.
+func ContextResolver(ctx context.Context) HostResolver {
+	if r, ok := ctx.Value(resolverKey{}).(HostResolver); ok && r != nil {
+		return r
+	}
+
+	return systemResolver{}
+}
+
+// resolutionURIs returns the canonical URIs described by a list of
+// resolutions.
+func resolutionURIs(res []*Resolution) []*URI {
+	if res == nil {
+		return nil
+	}
+
+	result := make([]*URI, len(res))
+	for i, r := range res {
+		result[i] = r.URI
+	}
+
+	return result
+}
+
+// discoveryResolutions attributes the URIs returned by a discovery
+// mechanism lacking a Resolver implementation.
+func discoveryResolutions(name string, uris []*URI) []*Resolution {
+	if uris == nil {
+		return nil
+	}
+
+	result := make([]*Resolution, len(uris))
+	for i, u := range uris {
+		result[i] = &Resolution{URI: u, Source: SourceDiscovery, Discovery: name}
+	}
+
+	return result
+}
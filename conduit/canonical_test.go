@@ -0,0 +1,191 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockResolver struct {
+	mockDiscovery
+}
+
+func (m *mockResolver) Resolve(ctx context.Context, u *URI) ([]*Resolution, error) {
+	args := m.MethodCalled("Resolve", ctx, u)
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.([]*Resolution), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+type mockHostResolver struct {
+	mock.Mock
+}
+
+func (m *mockHostResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	args := m.MethodCalled("LookupIP", ctx, network, host)
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.([]net.IP), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+func (m *mockHostResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	args := m.MethodCalled("LookupPort", ctx, network, service)
+
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockHostResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	args := m.MethodCalled("LookupSRV", ctx, service, proto, name)
+
+	if tmp := args.Get(1); tmp != nil {
+		return args.String(0), tmp.([]*net.SRV), args.Error(2)
+	}
+
+	return args.String(0), nil, args.Error(2)
+}
+
+func TestSourceString(t *testing.T) {
+	assert.Equal(t, "srv", SourceSRV.String())
+	assert.Equal(t, "Source(42)", Source(42).String())
+}
+
+func TestResolutionStringLiteral(t *testing.T) {
+	obj := &Resolution{URI: mustParse("tcp://127.0.0.1:1234")}
+
+	result := obj.String()
+
+	assert.Equal(t, "tcp://127.0.0.1:1234 (literal)", result)
+}
+
+func TestResolutionStringFull(t *testing.T) {
+	obj := &Resolution{
+		URI:       mustParse("tcp://10.0.0.1:1234"),
+		Source:    SourceSRV,
+		Name:      "node1.local.",
+		Discovery: "mdns",
+		TTL:       2 * time.Minute,
+	}
+
+	result := obj.String()
+
+	assert.Equal(t, "tcp://10.0.0.1:1234 (srv via mdns node1.local., ttl 2m0s)", result)
+}
+
+func TestResolutionURIs(t *testing.T) {
+	u1 := &URI{URL: url.URL{Host: "127.0.0.1:1"}}
+	u2 := &URI{URL: url.URL{Host: "127.0.0.1:2"}}
+
+	result := resolutionURIs([]*Resolution{{URI: u1}, {URI: u2}})
+
+	assert.Equal(t, []*URI{u1, u2}, result)
+}
+
+func TestResolutionURIsNil(t *testing.T) {
+	result := resolutionURIs(nil)
+
+	assert.Nil(t, result)
+}
+
+func TestDiscoveryResolutions(t *testing.T) {
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1"}}
+
+	result := discoveryResolutions("disc", []*URI{u})
+
+	assert.Equal(t, []*Resolution{
+		{URI: u, Source: SourceDiscovery, Discovery: "disc"},
+	}, result)
+}
+
+func TestDiscoveryResolutionsNil(t *testing.T) {
+	result := discoveryResolutions("disc", nil)
+
+	assert.Nil(t, result)
+}
+
+func TestSystemResolverLookupIP(t *testing.T) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, network, host string) ([]net.IP, error) {
+		assert.Equal(t, "ip", network)
+		assert.Equal(t, "example.com", host)
+		return []net.IP{net.IPv6loopback}, nil
+	}).Install().Restore()
+
+	result, err := systemResolver{}.LookupIP(context.Background(), "ip", "example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.IPv6loopback}, result)
+}
+
+func TestSystemResolverLookupPort(t *testing.T) {
+	defer patcher.SetVar(&lookupPort, func(_ context.Context, network, service string) (int, error) {
+		assert.Equal(t, "tcp", network)
+		assert.Equal(t, "http", service)
+		return 80, nil
+	}).Install().Restore()
+
+	result, err := systemResolver{}.LookupPort(context.Background(), "tcp", "http")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 80, result)
+}
+
+func TestSystemResolverLookupSRV(t *testing.T) {
+	srvs := []*net.SRV{{Target: "node1.example.com.", Port: 1234}}
+	defer patcher.SetVar(&lookupSRV, func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "humboldt", service)
+		assert.Equal(t, "tcp", proto)
+		assert.Equal(t, "example.com", name)
+		return "_humboldt._tcp.example.com.", srvs, nil
+	}).Install().Restore()
+
+	cname, result, err := systemResolver{}.LookupSRV(context.Background(), "humboldt", "tcp", "example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "_humboldt._tcp.example.com.", cname)
+	assert.Equal(t, srvs, result)
+}
+
+func TestContextResolverDefault(t *testing.T) {
+	result := ContextResolver(context.Background())
+
+	assert.Equal(t, systemResolver{}, result)
+}
+
+func TestContextResolverSet(t *testing.T) {
+	r := &mockHostResolver{}
+
+	result := ContextResolver(WithResolver(context.Background(), r))
+
+	assert.Same(t, r, result)
+}
+
+func TestContextResolverNil(t *testing.T) {
+	result := ContextResolver(WithResolver(context.Background(), nil))
+
+	assert.Equal(t, systemResolver{}, result)
+}
@@ -0,0 +1,460 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// CaptureLinkType is the link type of the interfaces of a capture
+// file.  Humboldt has no assigned link type, so LINKTYPE_USER0 is
+// used; the captured packets are complete Humboldt PDUs.
+const CaptureLinkType uint16 = 147
+
+// MaxCaptureBlock is the largest block accepted by CaptureReader.
+const MaxCaptureBlock = 1 << 20
+
+// Constants used in the pcap-ng encoding of captures.
+const (
+	pcapSHB       uint32 = 0x0a0d0d0a // Section header block type
+	pcapIDB       uint32 = 0x00000001 // Interface description block type
+	pcapEPB       uint32 = 0x00000006 // Enhanced packet block type
+	pcapMagic     uint32 = 0x1a2b3c4d // Byte-order magic
+	pcapOptEnd    uint16 = 0          // End of the options
+	pcapOptApp    uint16 = 4          // Application writing the section
+	pcapOptName   uint16 = 2          // Name of an interface
+	pcapOptDesc   uint16 = 3          // Description of an interface
+	pcapOptRes    uint16 = 9          // Timestamp resolution of an interface
+	pcapOptFlags  uint16 = 2          // Flags of a packet
+	pcapNanoRes   uint8  = 9          // Resolution of nanoseconds
+	pcapDirection uint32 = 0x3        // Direction bits of the packet flags
+)
+
+// Direction is the direction in which a captured PDU was exchanged.
+// The values are those of the direction bits of the flags of a
+// pcap-ng enhanced packet block.
+type Direction uint8
+
+// Directions of captured PDUs.
+const (
+	Inbound  Direction = 1 // PDU was received from the peer
+	Outbound Direction = 2 // PDU was sent to the peer
+)
+
+// String returns the name of the direction.
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "in"
+	case Outbound:
+		return "out"
+	}
+
+	return "unknown"
+}
+
+// pcapOption is an option of a pcap-ng block.
+type pcapOption struct {
+	code  uint16 // The option code
+	value []byte // The option value
+}
+
+// pad4 returns the padding needed to align a length to 4 bytes.
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// pcapBlock encodes a pcap-ng block of the specified type, with the
+// specified body and options.
+func pcapBlock(kind uint32, body []byte, opts ...pcapOption) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, kind)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = append(data, body...)
+	data = append(data, make([]byte, pad4(len(body)))...)
+	if len(opts) > 0 {
+		for _, opt := range opts {
+			data = binary.LittleEndian.AppendUint16(data, opt.code)
+			data = binary.LittleEndian.AppendUint16(data, uint16(len(opt.value)))
+			data = append(data, opt.value...)
+			data = append(data, make([]byte, pad4(len(opt.value)))...)
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(pcapOptEnd))
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)+4))
+
+	return binary.LittleEndian.AppendUint32(data, uint32(len(data)+4))
+}
+
+// Capture writes the PDUs exchanged over conduits to a capture file
+// in the pcap-ng format, which may be read with CaptureReader or with
+// tools such as Wireshark.  Each conduit is described by an interface
+// of the capture, whose name is the remote URI of the conduit and
+// whose description is its peer; the interface ID thus identifies
+// the conduit within the capture.  Each PDU is recorded with the time
+// and the direction in which it was exchanged.  Captures are enabled
+// on conduits with Conduit.SetCapture, and may be shared by any
+// number of conduits.  Captured PDUs are written as they are
+// exchanged; write errors stop the capture, and are returned by
+// Close.
+type Capture struct {
+	lock   sync.Mutex          // Protects the state
+	w      io.Writer           // The writer of the capture file
+	closer io.Closer           // Closes the capture file; may be nil
+	ifaces map[*Conduit]uint32 // Interface IDs of the conduits
+	closed bool                // The capture has been closed
+	err    error               // The first error writing the capture
+}
+
+// NewCapture constructs a capture writing to the specified writer.
+// The section header is written immediately.
+func NewCapture(w io.Writer) (*Capture, error) {
+	body := binary.LittleEndian.AppendUint32(nil, pcapMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0))
+	if _, err := w.Write(pcapBlock(pcapSHB, body, pcapOption{code: pcapOptApp, value: []byte("humboldt")})); err != nil {
+		return nil, err
+	}
+
+	return &Capture{
+		w:      w,
+		ifaces: map[*Conduit]uint32{},
+	}, nil
+}
+
+// CreateCapture creates a capture file at the specified path, which
+// must not already exist, so that a capture cannot overwrite another
+// file.  Since the captured PDUs may carry sensitive data, the file is
+// only readable by its owner.  Closing the capture closes the file.
+func CreateCapture(path string) (*Capture, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := NewCapture(f)
+	if err != nil {
+		f.Close() //nolint:errcheck
+		return nil, err
+	}
+	cp.closer = f
+
+	return cp, nil
+}
+
+// Close stops the capture, closing the capture file if it was opened
+// by CreateCapture.  The first error writing the capture is returned.
+func (cp *Capture) Close() error {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.closed {
+		return cp.err
+	}
+	cp.closed = true
+	if cp.closer != nil {
+		if err := cp.closer.Close(); err != nil && cp.err == nil {
+			cp.err = err
+		}
+	}
+
+	return cp.err
+}
+
+// write writes a block to the capture file.  It must be called with
+// the lock held.
+func (cp *Capture) write(block []byte) {
+	if _, err := cp.w.Write(block); err != nil {
+		cp.err = err
+	}
+}
+
+// iface returns the interface ID of a conduit, writing its interface
+// description block the first time the conduit is seen.  It must be
+// called with the lock held.
+func (cp *Capture) iface(c *Conduit) uint32 {
+	if id, ok := cp.ifaces[c]; ok {
+		return id
+	}
+
+	id := uint32(len(cp.ifaces))
+	cp.ifaces[c] = id
+	body := binary.LittleEndian.AppendUint16(nil, CaptureLinkType)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0)
+	opts := []pcapOption{{code: pcapOptRes, value: []byte{pcapNanoRes}}}
+	if c.RemoteURI != nil {
+		opts = append(opts, pcapOption{code: pcapOptName, value: []byte(c.RemoteURI.String())})
+	}
+	if c.Peer != nil {
+		opts = append(opts, pcapOption{code: pcapOptDesc, value: []byte(fmt.Sprint(c.Peer))})
+	}
+	cp.write(pcapBlock(pcapIDB, body, opts...))
+
+	return id
+}
+
+// record records a PDU exchanged over a conduit.
+func (cp *Capture) record(c *Conduit, dir Direction, pdu []byte) {
+	now := timeNow()
+
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.closed || cp.err != nil {
+		return
+	}
+	id := cp.iface(c)
+	ts := uint64(now.UnixNano())
+	body := binary.LittleEndian.AppendUint32(nil, id)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(pdu)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(pdu)))
+	body = append(body, pdu...)
+	flags := binary.LittleEndian.AppendUint32(nil, uint32(dir))
+	cp.write(pcapBlock(pcapEPB, body, pcapOption{code: pcapOptFlags, value: flags}))
+}
+
+// captureState holds the capture of a conduit.
+type captureState struct {
+	sync.Mutex
+
+	capture *Capture // The capture recording the PDUs; may be nil
+}
+
+// SetCapture sets the capture recording the PDUs sent and received
+// over the conduit by Send, Recv, and PDUs.  Passing nil stops
+// capturing.  Data exchanged directly over the link is not captured.
+func (c *Conduit) SetCapture(cp *Capture) {
+	c.capture.Lock()
+	defer c.capture.Unlock()
+
+	c.capture.capture = cp
+}
+
+// capturing returns the capture of the conduit, if any.
+func (c *Conduit) capturing() *Capture {
+	c.capture.Lock()
+	defer c.capture.Unlock()
+
+	return c.capture.capture
+}
+
+// captureFrame records a frame exchanged over the conduit, if the
+// conduit is being captured.
+func (c *Conduit) captureFrame(dir Direction, f *proto.Frame) {
+	if cp := c.capturing(); cp != nil {
+		data := make([]byte, f.Size())
+		if n, err := f.ToBytes(data); err == nil {
+			cp.record(c, dir, data[:n])
+		}
+	}
+}
+
+// capturePDU records a PDU, given as its header and the data
+// following it, received over the conduit, if the conduit is being
+// captured.
+func (c *Conduit) capturePDU(dir Direction, hdr *proto.Header, body []byte) {
+	if cp := c.capturing(); cp != nil {
+		data := make([]byte, proto.HeaderSize, proto.HeaderSize+len(body))
+		hdr.ToBytes(data) //nolint:errcheck
+		cp.record(c, dir, append(data, body...))
+	}
+}
+
+// CaptureRecord describes a PDU read from a capture file.
+type CaptureRecord struct {
+	Time      time.Time // Time the PDU was exchanged
+	Conduit   uint32    // Interface ID identifying the conduit
+	Name      string    // Name of the interface: the remote URI of the conduit
+	Peer      string    // Description of the interface: the peer of the conduit
+	Direction Direction // Direction in which the PDU was exchanged; 0 if unknown
+	PDU       []byte    // The PDU
+}
+
+// captureIface describes an interface of a capture file being read.
+type captureIface struct {
+	name string // Name of the interface
+	peer string // Description of the interface
+	res  uint64 // Timestamp units per second
+}
+
+// CaptureReader reads the PDUs recorded in a capture file, as
+// written by Capture.  Files written by other tools are accepted, as
+// long as they are in the pcap-ng format; blocks other than section
+// headers, interface descriptions, and enhanced packets are skipped.
+type CaptureReader struct {
+	r      *bufio.Reader    // The reader of the capture file
+	order  binary.ByteOrder // Byte order of the current section
+	ifaces []captureIface   // Interfaces of the current section
+}
+
+// NewCaptureReader constructs a reader of a capture file.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next PDU recorded in the capture file.  At the end
+// of the file, io.EOF is returned; errors wrapping ErrBadCapture are
+// returned for malformed files.
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	for {
+		kind, body, err := cr.block()
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case pcapIDB:
+			cr.ifaces = append(cr.ifaces, cr.parseIface(body))
+		case pcapEPB:
+			return cr.parsePacket(body)
+		}
+	}
+}
+
+// block reads the next block, returning its type and its body,
+// including its options.
+func (cr *CaptureReader) block() (uint32, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(cr.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w: %w", ErrBadCapture, err)
+		}
+		return 0, nil, err
+	}
+
+	// A section header sets the byte order
+	kind := binary.LittleEndian.Uint32(hdr)
+	if kind == pcapSHB {
+		magic, err := cr.r.Peek(4)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %w", ErrBadCapture, err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == pcapMagic:
+			cr.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == pcapMagic:
+			cr.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("%w: bad byte-order magic", ErrBadCapture)
+		}
+		cr.ifaces = nil
+	} else if cr.order == nil {
+		return 0, nil, fmt.Errorf("%w: missing section header", ErrBadCapture)
+	}
+	kind = cr.order.Uint32(hdr)
+
+	// Read the rest of the block
+	length := cr.order.Uint32(hdr[4:])
+	if length < 12 || length%4 != 0 || length > MaxCaptureBlock {
+		return 0, nil, fmt.Errorf("%w: bad block length %d", ErrBadCapture, length)
+	}
+	data := make([]byte, length-8)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrBadCapture, err)
+	}
+	if cr.order.Uint32(data[len(data)-4:]) != length {
+		return 0, nil, fmt.Errorf("%w: mismatched block lengths", ErrBadCapture)
+	}
+
+	return kind, data[:len(data)-4], nil
+}
+
+// options parses the options of a block, calling the function with
+// each.
+func (cr *CaptureReader) options(data []byte, f func(code uint16, value []byte)) {
+	for len(data) >= 4 {
+		code := cr.order.Uint16(data)
+		length := int(cr.order.Uint16(data[2:]))
+		if code == pcapOptEnd || 4+length > len(data) {
+			return
+		}
+		f(code, data[4:4+length])
+		data = data[min(4+length+pad4(length), len(data)):]
+	}
+}
+
+// parseIface parses the body of an interface description block.
+func (cr *CaptureReader) parseIface(body []byte) captureIface {
+	iface := captureIface{res: 1e6}
+	if len(body) < 8 {
+		return iface
+	}
+	cr.options(body[8:], func(code uint16, value []byte) {
+		switch {
+		case code == pcapOptName:
+			iface.name = string(value)
+		case code == pcapOptDesc:
+			iface.peer = string(value)
+		case code == pcapOptRes && len(value) > 0 && value[0]&0x80 != 0:
+			iface.res = 1 << min(value[0]&0x7f, 63)
+		case code == pcapOptRes && len(value) > 0:
+			iface.res = 1
+			for range min(value[0], 19) {
+				iface.res *= 10
+			}
+		}
+	})
+
+	return iface
+}
+
+// parsePacket parses the body of an enhanced packet block.
+func (cr *CaptureReader) parsePacket(body []byte) (*CaptureRecord, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("%w: short packet block", ErrBadCapture)
+	}
+	id := cr.order.Uint32(body)
+	if int(id) >= len(cr.ifaces) {
+		return nil, fmt.Errorf("%w: unknown interface %d", ErrBadCapture, id)
+	}
+	iface := cr.ifaces[id]
+	ts := uint64(cr.order.Uint32(body[4:]))<<32 | uint64(cr.order.Uint32(body[8:]))
+	length := int(cr.order.Uint32(body[12:]))
+	if length > len(body)-20 {
+		return nil, fmt.Errorf("%w: truncated packet", ErrBadCapture)
+	}
+
+	frac := ts % iface.res
+	if iface.res > 1e9 {
+		frac /= iface.res / 1e9
+	} else {
+		frac = frac * 1e9 / iface.res
+	}
+	rec := &CaptureRecord{
+		Time:    time.Unix(int64(ts/iface.res), int64(frac)),
+		Conduit: id,
+		Name:    iface.name,
+		Peer:    iface.peer,
+		PDU:     body[20 : 20+length],
+	}
+	opts := body[min(20+length+pad4(length), len(body)):]
+	cr.options(opts, func(code uint16, value []byte) {
+		if code == pcapOptFlags && len(value) >= 4 {
+			rec.Direction = Direction(cr.order.Uint32(value) & pcapDirection)
+		}
+	})
+
+	return rec, nil
+}
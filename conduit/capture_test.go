@@ -0,0 +1,302 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+type failWriter struct {
+	after int
+}
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if w.after <= 0 {
+		return 0, assert.AnError
+	}
+	w.after--
+
+	return len(b), nil
+}
+
+// readRecords reads all the records of a capture.
+func readRecords(t *testing.T, data []byte) []*CaptureRecord {
+	t.Helper()
+
+	r := NewCaptureReader(bytes.NewReader(data))
+	result := []*CaptureRecord{}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return result
+		}
+		require.NoError(t, err)
+		result = append(result, rec)
+	}
+}
+
+func TestDirectionString(t *testing.T) {
+	assert.Equal(t, "in", Inbound.String())
+	assert.Equal(t, "out", Outbound.String())
+	assert.Equal(t, "unknown", Direction(0).String())
+}
+
+func TestPcapBlock(t *testing.T) {
+	result := pcapBlock(pcapEPB, []byte{1, 2, 3}, pcapOption{code: 2, value: []byte{4}})
+
+	assert.Equal(t, []byte{
+		6, 0, 0, 0, 28, 0, 0, 0,
+		1, 2, 3, 0,
+		2, 0, 1, 0, 4, 0, 0, 0,
+		0, 0, 0, 0,
+		28, 0, 0, 0,
+	}, result)
+}
+
+func TestNewCaptureBase(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	result, err := NewCapture(buf)
+
+	require.NoError(t, err)
+	assert.NotNil(t, result)
+	assert.Equal(t, []byte{0x0a, 0x0d, 0x0d, 0x0a}, buf.Bytes()[:4])
+	assert.Empty(t, readRecords(t, buf.Bytes()))
+}
+
+func TestNewCaptureError(t *testing.T) {
+	result, err := NewCapture(&failWriter{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestCreateCaptureBase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+
+	result, err := CreateCapture(path)
+
+	require.NoError(t, err)
+	assert.NoError(t, result.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, readRecords(t, data))
+}
+
+func TestCreateCaptureExists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	require.NoError(t, os.WriteFile(path, []byte("keep"), 0o600))
+
+	result, err := CreateCapture(path)
+
+	assert.ErrorIs(t, err, fs.ErrExist)
+	assert.Nil(t, result)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("keep"), data)
+}
+
+func TestCreateCaptureError(t *testing.T) {
+	result, err := CreateCapture(filepath.Join(t.TempDir(), "missing", "capture.pcapng"))
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestCaptureCloseTwice(t *testing.T) {
+	obj, err := NewCapture(io.Discard)
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+
+	err = obj.Close()
+
+	assert.NoError(t, err)
+}
+
+func TestCaptureRecordWriteError(t *testing.T) {
+	obj, err := NewCapture(&failWriter{after: 1})
+	require.NoError(t, err)
+
+	obj.record(&Conduit{}, Outbound, []byte{0, 3, 0, 0})
+	obj.record(&Conduit{}, Outbound, []byte{0, 3, 0, 0})
+
+	assert.Same(t, assert.AnError, obj.Close())
+}
+
+func TestCaptureRecordClosed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	obj, err := NewCapture(buf)
+	require.NoError(t, err)
+	require.NoError(t, obj.Close())
+
+	obj.record(&Conduit{}, Outbound, []byte{0, 3, 0, 0})
+
+	assert.Empty(t, readRecords(t, buf.Bytes()))
+}
+
+func TestConduitCaptureSendRecv(t *testing.T) {
+	start := time.Now()
+	buf := &bytes.Buffer{}
+	cp, err := NewCapture(buf)
+	require.NoError(t, err)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	uri, err := Parse("tcp://127.0.0.1:1234")
+	require.NoError(t, err)
+	sender := &Conduit{Link: c1, RemoteURI: uri, Integrity: true}
+	receiver := &Conduit{Link: c2, Peer: proto.NodeID{2}}
+	sender.SetCapture(cp)
+	receiver.SetCapture(cp)
+	frame := &proto.Frame{Header: proto.Header{Protocol: proto.ProtoData}, Payload: []byte("hello")}
+	done := make(chan error, 1)
+	go func() { done <- sender.Send(frame) }()
+
+	_, err = receiver.Recv()
+
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	require.NoError(t, cp.Close())
+	result := readRecords(t, buf.Bytes())
+	require.Len(t, result, 2)
+	if result[0].Direction == Inbound {
+		result[0], result[1] = result[1], result[0]
+	}
+	for _, rec := range result {
+		assert.False(t, rec.Time.Before(start))
+		assert.False(t, rec.Time.After(time.Now()))
+		rec.Time = time.Time{}
+	}
+	pdu := []byte{0, 3, 0, 5, 'h', 'e', 'l', 'l', 'o'}
+	assert.Equal(t, &CaptureRecord{
+		Conduit:   result[0].Conduit,
+		Name:      "tcp://127.0.0.1:1234",
+		Direction: Outbound,
+		PDU:       pdu,
+	}, result[0])
+	assert.Equal(t, &CaptureRecord{
+		Conduit:   1 - result[0].Conduit,
+		Peer:      proto.NodeID{2}.String(),
+		Direction: Inbound,
+		PDU:       pdu,
+	}, result[1])
+}
+
+func TestConduitCapturePDUs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cp, err := NewCapture(buf)
+	require.NoError(t, err)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	obj := &Conduit{Link: c2}
+	obj.SetCapture(cp)
+	go func() {
+		c1.Write([]byte{0, 3, 0, 2, 'h', 'i'})
+	}()
+
+	for range obj.PDUs(context.Background()) {
+		break
+	}
+
+	require.NoError(t, cp.Close())
+	result := readRecords(t, buf.Bytes())
+	require.Len(t, result, 1)
+	assert.Equal(t, Inbound, result[0].Direction)
+	assert.Equal(t, []byte{0, 3, 0, 2, 'h', 'i'}, result[0].PDU)
+}
+
+func TestConduitSetCaptureNil(t *testing.T) {
+	cp, err := NewCapture(io.Discard)
+	require.NoError(t, err)
+	obj := &Conduit{}
+	obj.SetCapture(cp)
+
+	obj.SetCapture(nil)
+
+	assert.Nil(t, obj.capturing())
+}
+
+// bigEndianBlock encodes a pcap-ng block in big-endian byte order.
+func bigEndianBlock(kind uint32, body []byte) []byte {
+	body = append(body, make([]byte, pad4(len(body)))...)
+	data := binary.BigEndian.AppendUint32(nil, kind)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(body)+12))
+	data = append(data, body...)
+
+	return binary.BigEndian.AppendUint32(data, uint32(len(body)+12))
+}
+
+func TestCaptureReaderBigEndian(t *testing.T) {
+	shb := binary.BigEndian.AppendUint32(nil, pcapMagic)
+	shb = append(shb, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	idb := []byte{0, 147, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0x83, 0, 0, 0, 0, 0, 0, 0}
+	epb := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0, 1, 0, 0, 0, 1, 0xaa}
+	data := bigEndianBlock(pcapSHB, shb)
+	data = append(data, bigEndianBlock(0x0bad, nil)...)
+	data = append(data, bigEndianBlock(pcapIDB, idb)...)
+	data = append(data, bigEndianBlock(pcapEPB, epb)...)
+
+	result := readRecords(t, data)
+
+	require.Len(t, result, 1)
+	assert.Equal(t, time.Unix(2, 500000000), result[0].Time)
+	assert.Equal(t, Direction(0), result[0].Direction)
+	assert.Equal(t, []byte{0xaa}, result[0].PDU)
+}
+
+func TestCaptureReaderErrors(t *testing.T) {
+	shb := pcapBlock(pcapSHB, binary.LittleEndian.AppendUint32(nil, pcapMagic))
+	tests := map[string][]byte{
+		"truncated header":   shb[:6],
+		"missing section":    pcapBlock(pcapIDB, make([]byte, 8)),
+		"bad magic":          pcapBlock(pcapSHB, make([]byte, 4)),
+		"short magic":        shb[:9],
+		"bad length":         append(shb[:4:4], 5, 0, 0, 0),
+		"truncated block":    shb[:len(shb)-1],
+		"mismatched lengths": append(shb[:len(shb)-4:len(shb)-4], 0, 0, 0, 0),
+		"short packet":       append(shb, pcapBlock(pcapEPB, make([]byte, 16))...),
+		"unknown interface":  append(shb, pcapBlock(pcapEPB, make([]byte, 20))...),
+		"truncated packet": append(append(shb, pcapBlock(pcapIDB, make([]byte, 8))...),
+			pcapBlock(pcapEPB, append(make([]byte, 12), 9, 0, 0, 0, 9, 0, 0, 0))...),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := NewCaptureReader(bytes.NewReader(data))
+
+			result, err := r.Next()
+
+			assert.ErrorIs(t, err, ErrBadCapture)
+			assert.Nil(t, result)
+		})
+	}
+}
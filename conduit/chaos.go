@@ -0,0 +1,114 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	mrand "math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Chaos describes faults to inject into the writes over a conduit,
+// for exercising the error handling of its users.  Each fault is
+// given as the probability, from 0 to 1, that it is applied to a
+// write.  A delayed write is held back for a random time of up to
+// MaxDelay; a truncated write sends only a random prefix of the PDU
+// while reporting that all of it was written; a reset closes the
+// link; and a reordered write is held back and sent after the next
+// one.  Reordering only applies to transports which preserve message
+// boundaries, as it would otherwise corrupt the framing of the
+// stream.
+type Chaos struct {
+	Delay    float64       // Probability of delaying a write
+	MaxDelay time.Duration // Maximum delay of a delayed write
+	Truncate float64       // Probability of truncating a write
+	Reset    float64       // Probability of resetting the link
+	Reorder  float64       // Probability of reordering a datagram
+	Seed     uint64        // Seed for the faults; 0 for a random seed
+}
+
+// Apply wraps the link of a conduit so that the faults are injected
+// into its writes.  It must be called before the conduit is
+// negotiated or used.
+func (ch *Chaos) Apply(c *Conduit) {
+	seed := ch.Seed
+	if seed == 0 {
+		seed = mrand.Uint64()
+	}
+
+	c.Link = &chaosConn{
+		Conn:       c.Link,
+		chaos:      *ch,
+		boundaries: c.Boundaries,
+		rng:        mrand.New(mrand.NewPCG(seed, seed)),
+	}
+}
+
+// chaosConn is a wrapper for a net.Conn which injects faults into
+// its writes.
+type chaosConn struct {
+	net.Conn
+
+	chaos      Chaos       // The faults to inject
+	boundaries bool        // The link preserves message boundaries
+	lock       sync.Mutex  // Serializes the writes
+	rng        *mrand.Rand // Source of the faults
+	held       []byte      // A datagram held back for reordering
+}
+
+// roll reports whether a fault with the given probability applies.
+// It must be called with the lock held.
+func (cc *chaosConn) roll(p float64) bool {
+	return p > 0 && cc.rng.Float64() < p
+}
+
+// Write writes data to the connection, injecting faults.
+func (cc *chaosConn) Write(b []byte) (int, error) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	if cc.roll(cc.chaos.Reset) {
+		cc.held = nil
+		cc.Conn.Close() //nolint:errcheck
+		return 0, ErrChaosReset
+	}
+	if cc.chaos.MaxDelay > 0 && cc.roll(cc.chaos.Delay) {
+		time.Sleep(time.Duration(cc.rng.Int64N(int64(cc.chaos.MaxDelay)) + 1))
+	}
+
+	data := b
+	if len(data) > 0 && cc.roll(cc.chaos.Truncate) {
+		data = data[:cc.rng.IntN(len(data))]
+	}
+	if cc.boundaries {
+		if cc.held == nil && cc.roll(cc.chaos.Reorder) {
+			cc.held = append([]byte{}, data...)
+			return len(b), nil
+		}
+		defer func() {
+			if cc.held != nil {
+				cc.Conn.Write(cc.held) //nolint:errcheck
+				cc.held = nil
+			}
+		}()
+	}
+
+	if _, err := cc.Conn.Write(data); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestChaosApply(t *testing.T) {
+	link := &mockConn{}
+	c := &Conduit{Link: link, Boundaries: true}
+	obj := &Chaos{Reset: 0.5, Seed: 42}
+
+	obj.Apply(c)
+
+	cc, ok := c.Link.(*chaosConn)
+	assert.True(t, ok)
+	assert.Same(t, link, cc.Conn)
+	assert.Equal(t, *obj, cc.chaos)
+	assert.True(t, cc.boundaries)
+	assert.NotNil(t, cc.rng)
+}
+
+func TestChaosApplyRandomSeed(t *testing.T) {
+	c := &Conduit{Link: &mockConn{}}
+	obj := &Chaos{}
+
+	obj.Apply(c)
+
+	assert.NotNil(t, c.Link.(*chaosConn).rng)
+}
+
+func TestChaosConnWriteBase(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte("hello")).Return(5, nil)
+	c := &Conduit{Link: link}
+	(&Chaos{Seed: 1}).Apply(c)
+
+	result, err := c.Link.Write([]byte("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	link.AssertExpectations(t)
+}
+
+func TestChaosConnWriteError(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte("hello")).Return(0, assert.AnError)
+	c := &Conduit{Link: link}
+	(&Chaos{Seed: 1}).Apply(c)
+
+	result, err := c.Link.Write([]byte("hello"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, 0, result)
+}
+
+func TestChaosConnWriteReset(t *testing.T) {
+	link := &mockConn{}
+	link.On("Close").Return(nil)
+	c := &Conduit{Link: link}
+	(&Chaos{Reset: 1, Seed: 1}).Apply(c)
+
+	result, err := c.Link.Write([]byte("hello"))
+
+	assert.Same(t, ErrChaosReset, err)
+	assert.Equal(t, 0, result)
+	link.AssertExpectations(t)
+	link.AssertNotCalled(t, "Write", mock.Anything)
+}
+
+func TestChaosConnWriteDelay(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte("hello")).Return(5, nil)
+	c := &Conduit{Link: link}
+	(&Chaos{Delay: 1, MaxDelay: time.Millisecond, Seed: 1}).Apply(c)
+
+	result, err := c.Link.Write([]byte("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	link.AssertExpectations(t)
+}
+
+func TestChaosConnWriteTruncate(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", mock.MatchedBy(func(b []byte) bool {
+		return len(b) < 5 && string(b) == "hello"[:len(b)]
+	})).Return(0, nil)
+	c := &Conduit{Link: link}
+	(&Chaos{Truncate: 1, Seed: 1}).Apply(c)
+
+	result, err := c.Link.Write([]byte("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	link.AssertExpectations(t)
+}
+
+func TestChaosConnWriteReorder(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte("two")).Return(3, nil).Once()
+	link.On("Write", []byte("one")).Return(3, nil).Once()
+	c := &Conduit{Link: link, Boundaries: true}
+	(&Chaos{Reorder: 1, Seed: 1}).Apply(c)
+
+	result1, err1 := c.Link.Write([]byte("one"))
+	result2, err2 := c.Link.Write([]byte("two"))
+
+	assert.NoError(t, err1)
+	assert.Equal(t, 3, result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, 3, result2)
+	link.AssertExpectations(t)
+	assert.Equal(t, []byte("two"), link.Calls[0].Arguments[0])
+	assert.Equal(t, []byte("one"), link.Calls[1].Arguments[0])
+}
+
+func TestChaosConnWriteReorderStream(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte("one")).Return(3, nil).Once()
+	c := &Conduit{Link: link}
+	(&Chaos{Reorder: 1, Seed: 1}).Apply(c)
+
+	result, err := c.Link.Write([]byte("one"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result)
+	link.AssertExpectations(t)
+}
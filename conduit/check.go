@@ -0,0 +1,137 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import "fmt"
+
+// CheckResult describes the result of checking a single URI.
+type CheckResult struct {
+	URI         string        // The URI that was checked
+	Canonical   []*URI        // The canonical URIs it resolves to
+	Resolutions []*Resolution // Where each canonical URI came from
+	Err         error         // Any error encountered while checking
+}
+
+// String returns a one-line report describing the result.
+func (cr *CheckResult) String() string {
+	if cr.Err != nil {
+		return fmt.Sprintf("%s: FAILED: %s", cr.URI, cr.Err)
+	}
+
+	return fmt.Sprintf("%s: OK: %s", cr.URI, cr.Canonical)
+}
+
+// checkURI checks a single URI, converting its query parameters into
+// options with the specified function.
+func checkURI(uri string, options func(u *URI) error) *CheckResult {
+	result := &CheckResult{URI: uri}
+
+	// Parse the URI
+	u, err := Parse(uri)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	// Make sure the mechanisms exist
+	if u.Transport == "" || lookupTransport(u.Transport) == nil {
+		result.Err = fmt.Errorf("%q: %w", u.Transport, ErrUnknownTransport)
+		return result
+	}
+	if u.Security != "" && lookupSecurity(u.Security) == nil {
+		result.Err = fmt.Errorf("%q: %w", u.Security, ErrUnknownSecurity)
+		return result
+	}
+
+	// Make sure the options are valid
+	if err := options(u); err != nil {
+		result.Err = err
+		return result
+	}
+
+	// Canonicalize it
+	result.Resolutions, result.Err = u.Resolve()
+	result.Canonical = resolutionURIs(result.Resolutions)
+
+	return result
+}
+
+// listenOptions checks that the query parameters of a URI are valid
+// listener options.
+func listenOptions(u *URI) error {
+	_, err := u.ListenOptions()
+	return err
+}
+
+// dialOptions checks that the query parameters of a URI are valid
+// dialer options.
+func dialOptions(u *URI) error {
+	_, err := u.DialOptions()
+	return err
+}
+
+// anyOptions checks that the query parameters of a URI are valid
+// listener or dialer options.  If they are neither, the error from
+// the listener options is returned.
+func anyOptions(u *URI) error {
+	err := listenOptions(u)
+	if err != nil && dialOptions(u) == nil {
+		return nil
+	}
+
+	return err
+}
+
+// check checks a list of URIs, converting their query parameters into
+// options with the specified function.
+func check(uris []string, options func(u *URI) error) ([]*CheckResult, error) {
+	var err error
+	results := make([]*CheckResult, 0, len(uris))
+	for _, uri := range uris {
+		result := checkURI(uri, options)
+		if result.Err != nil {
+			err = ErrCheckFailed
+		}
+		results = append(results, result)
+	}
+
+	return results, err
+}
+
+// Check validates a list of URIs without binding or dialing: each URI
+// is parsed, its transport and security mechanisms are looked up, its
+// query parameters are converted into options, and it is
+// canonicalized.  This allows configurations to be verified before
+// they are deployed.  The query parameters must be valid as either
+// listener or dialer options; see CheckListen and CheckDial to check
+// URIs for one use.  A result is returned for every URI; if any URI
+// failed its check, ErrCheckFailed is also returned.
+func Check(uris ...string) ([]*CheckResult, error) {
+	return check(uris, anyOptions)
+}
+
+// CheckListen validates a list of URIs to listen on, as for Check,
+// requiring their query parameters to be valid listener options, as
+// they are for URI.Listen.
+func CheckListen(uris ...string) ([]*CheckResult, error) {
+	return check(uris, listenOptions)
+}
+
+// CheckDial validates a list of URIs to dial, as for Check, requiring
+// their query parameters to be valid dialer options, as they are for
+// URI.Dial.
+func CheckDial(uris ...string) ([]*CheckResult, error) {
+	return check(uris, dialOptions)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResultStringOK(t *testing.T) {
+	u, _ := Parse("tcp://127.0.0.1:1234")
+	obj := &CheckResult{
+		URI:       "tcp://localhost:1234",
+		Canonical: []*URI{u},
+	}
+
+	result := obj.String()
+
+	assert.Equal(t, "tcp://localhost:1234: OK: [tcp://127.0.0.1:1234]", result)
+}
+
+func TestCheckResultStringFailed(t *testing.T) {
+	obj := &CheckResult{
+		URI: "tcp://localhost:1234",
+		Err: assert.AnError,
+	}
+
+	result := obj.String()
+
+	assert.Equal(t, "tcp://localhost:1234: FAILED: "+assert.AnError.Error(), result)
+}
+
+func TestCheckURIBase(t *testing.T) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		assert.Equal(t, "localhost", host)
+		return []net.IP{loopback}, nil
+	}).Install().Restore()
+
+	result := checkURI("tcp://localhost:1234", anyOptions)
+
+	assert.NoError(t, result.Err)
+	assert.Equal(t, "tcp://localhost:1234", result.URI)
+	assert.Len(t, result.Canonical, 1)
+	assert.Equal(t, "tcp://127.0.0.1:1234", result.Canonical[0].String())
+	assert.Equal(t, []*Resolution{
+		{URI: result.Canonical[0], Source: SourceDNS, Name: "localhost"},
+	}, result.Resolutions)
+}
+
+func TestCheckURIParseError(t *testing.T) {
+	result := checkURI("://localhost:1234", anyOptions)
+
+	assert.Error(t, result.Err)
+	assert.Nil(t, result.Canonical)
+}
+
+func TestCheckURIUnknownTransport(t *testing.T) {
+	result := checkURI("bogus://localhost:1234", anyOptions)
+
+	assert.ErrorIs(t, result.Err, ErrUnknownTransport)
+	assert.Nil(t, result.Canonical)
+}
+
+func TestCheckURIUnknownSecurity(t *testing.T) {
+	result := checkURI("tcp+bogus://localhost:1234", anyOptions)
+
+	assert.ErrorIs(t, result.Err, ErrUnknownSecurity)
+	assert.Nil(t, result.Canonical)
+}
+
+func TestCheckURICanonicalizeError(t *testing.T) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result := checkURI("tcp://localhost:1234", anyOptions)
+
+	assert.Same(t, assert.AnError, result.Err)
+	assert.Nil(t, result.Canonical)
+}
+
+func TestCheckURIUnknownOption(t *testing.T) {
+	result := checkURI("tcp://127.0.0.1:1234?bogus=1", anyOptions)
+
+	assert.ErrorIs(t, result.Err, ErrUnknownOption)
+	assert.Nil(t, result.Canonical)
+}
+
+func TestCheckURIBadOption(t *testing.T) {
+	result := checkURI("tcp://127.0.0.1:1234?keepalive=notaduration", anyOptions)
+
+	assert.ErrorIs(t, result.Err, ErrBadOption)
+	assert.Nil(t, result.Canonical)
+}
+
+func TestAnyOptionsListen(t *testing.T) {
+	u, _ := Parse("tcp://127.0.0.1:1234?maxhandshakes=4")
+
+	err := anyOptions(u)
+
+	assert.NoError(t, err)
+}
+
+func TestAnyOptionsDial(t *testing.T) {
+	u, _ := Parse("tcp://127.0.0.1:1234?connect_timeout=1s")
+
+	err := anyOptions(u)
+
+	assert.NoError(t, err)
+}
+
+func TestCheckBase(t *testing.T) {
+	results, err := Check("tcp://127.0.0.1:1234", "udp://127.0.0.1:1234")
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.NoError(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+}
+
+func TestCheckFailed(t *testing.T) {
+	results, err := Check("tcp://127.0.0.1:1234", "bogus://127.0.0.1:1234")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 2)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrUnknownTransport)
+}
+
+func TestCheckUnknownOption(t *testing.T) {
+	results, err := Check("tcp://127.0.0.1:1234?bogus=1")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrUnknownOption)
+}
+
+func TestCheckBadOption(t *testing.T) {
+	results, err := Check("tcp://127.0.0.1:1234?keepalive=notaduration")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrBadOption)
+}
+
+func TestCheckListenBase(t *testing.T) {
+	results, err := CheckListen("tcp://127.0.0.1:1234?maxhandshakes=4")
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.NoError(t, results[0].Err)
+}
+
+func TestCheckListenDialOption(t *testing.T) {
+	results, err := CheckListen("tcp://127.0.0.1:1234?connect_timeout=1s")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrUnknownOption)
+}
+
+func TestCheckListenBadOption(t *testing.T) {
+	results, err := CheckListen("tcp://127.0.0.1:1234?keepalive=notaduration")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrBadOption)
+}
+
+func TestCheckDialBase(t *testing.T) {
+	results, err := CheckDial("tcp://127.0.0.1:1234?connect_timeout=1s")
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.NoError(t, results[0].Err)
+}
+
+func TestCheckDialListenOption(t *testing.T) {
+	results, err := CheckDial("tcp://127.0.0.1:1234?maxhandshakes=4")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrUnknownOption)
+}
+
+func TestCheckDialBadOption(t *testing.T) {
+	results, err := CheckDial("tcp://127.0.0.1:1234?keepalive=notaduration")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrBadOption)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// CloseTimeout is the maximum time CloseWithReason waits for the
+// frames queued on the lanes of a conduit to be written, and for the
+// close notice to be written after them.
+const CloseTimeout = time.Second
+
+// CloseWithReason closes the conduit gracefully.  The frames queued
+// on the lanes of the conduit are given up to CloseTimeout to be
+// written; then a close notice carrying the reason, if any, is sent
+// to the peer, and the conduit is closed as by Close.  The notice is
+// a control protocol ControlClose message with the Error bit set,
+// which the peer should pass to HandleClose.  Failing to send the
+// notice does not prevent the conduit from being closed, but the
+// error is returned.
+func (c *Conduit) CloseWithReason(reason error) error {
+	return c.closeWithReason(reason, CloseTimeout)
+}
+
+// closeWithReason implements CloseWithReason with the specified
+// timeout.
+func (c *Conduit) closeWithReason(reason error, timeout time.Duration) error {
+	if c.Link == nil {
+		return c.Close()
+	}
+	deadline := time.Now().Add(timeout)
+
+	// Wait for the lanes to flush
+	timer := time.NewTimer(timeout)
+	select {
+	case <-c.lanes.flushed():
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	// Send the close notice
+	msg := ""
+	if reason != nil {
+		msg = reason.Error()
+	}
+	c.Link.SetWriteDeadline(deadline) //nolint:errcheck
+	err := c.Send(proto.CloseFrame(msg))
+
+	// Close the conduit
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// HandleClose processes a close notice received over the conduit.
+// It returns false if the message is not a close notice.  Otherwise,
+// the conduit is closed, and its context is cancelled with an error
+// wrapping ErrPeerClosed and describing the reason given by the peer.
+func (c *Conduit) HandleClose(msg *proto.ControlMessage) bool {
+	if msg.Type != proto.ControlClose {
+		return false
+	}
+
+	err := ErrPeerClosed
+	if len(msg.Body) > 0 {
+		err = fmt.Errorf("%w: %s", ErrPeerClosed, msg.Body)
+	}
+	c.cancelContext(err)
+	c.Close() //nolint:errcheck
+
+	return true
+}
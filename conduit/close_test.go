@@ -0,0 +1,172 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// assertCloseFrame asserts that a frame is a close notice with the
+// specified reason.
+func assertCloseFrame(t *testing.T, reason string, f *proto.Frame) {
+	t.Helper()
+
+	require.NotNil(t, f)
+	assert.True(t, f.Header.Error)
+	assert.Equal(t, proto.ProtoControl, f.Protocol())
+	assert.Equal(t, proto.CloseFrame(reason).Payload, f.Payload)
+}
+
+func TestConduitCloseWithReasonBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	peer := &Conduit{Link: c2}
+	frames := make(chan *proto.Frame, 1)
+	go func() {
+		f, _ := peer.Recv()
+		frames <- f
+	}()
+
+	err := obj.CloseWithReason(errors.New("going away"))
+
+	assert.NoError(t, err)
+	assertCloseFrame(t, "going away", <-frames)
+	assert.Equal(t, Closed, obj.State)
+	assert.Same(t, ErrConduitClosed, context.Cause(obj.Context()))
+	_, err = c2.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestConduitCloseWithReasonNoReason(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	peer := &Conduit{Link: c2}
+	frames := make(chan *proto.Frame, 1)
+	go func() {
+		f, _ := peer.Recv()
+		frames <- f
+	}()
+
+	err := obj.CloseWithReason(nil)
+
+	assert.NoError(t, err)
+	assertCloseFrame(t, "", <-frames)
+	assert.Equal(t, Closed, obj.State)
+}
+
+func TestConduitCloseWithReasonNoLink(t *testing.T) {
+	obj := &Conduit{State: Open}
+
+	err := obj.CloseWithReason(assert.AnError)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Closed, obj.State)
+}
+
+func TestConduitCloseWithReasonFlushesLanes(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	peer := &Conduit{Link: c2}
+	lane := obj.OpenLane(1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- lane.Send(context.Background(), laneTestFrame(5, 16))
+	}()
+	require.Eventually(t, func() bool {
+		obj.lanes.Lock()
+		defer obj.lanes.Unlock()
+		return obj.lanes.running
+	}, time.Second, time.Millisecond)
+	frames := make(chan *proto.Frame, 2)
+	go func() {
+		for range 2 {
+			f, _ := peer.Recv()
+			frames <- f
+		}
+	}()
+
+	err := obj.CloseWithReason(nil)
+
+	assert.NoError(t, err)
+	assert.NoError(t, <-errs)
+	assert.Equal(t, uint8(5), (<-frames).Header.Protocol)
+	assertCloseFrame(t, "", <-frames)
+}
+
+func TestConduitCloseWithReasonTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+
+	err := obj.closeWithReason(assert.AnError, 10*time.Millisecond)
+
+	var netErr net.Error
+	assert.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Equal(t, Closed, obj.State)
+	_, err = c2.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestConduitHandleCloseBase(t *testing.T) {
+	link := &mockConn{}
+	link.On("Close").Return(nil)
+	obj := &Conduit{State: Open, Link: link}
+
+	result := obj.HandleClose(&proto.ControlMessage{Type: proto.ControlClose, Body: []byte("going away")})
+
+	assert.True(t, result)
+	assert.Equal(t, Closed, obj.State)
+	cause := context.Cause(obj.Context())
+	assert.ErrorIs(t, cause, ErrPeerClosed)
+	assert.EqualError(t, cause, "peer closed the conduit: going away")
+	link.AssertExpectations(t)
+}
+
+func TestConduitHandleCloseNoReason(t *testing.T) {
+	link := &mockConn{}
+	link.On("Close").Return(nil)
+	obj := &Conduit{State: Open, Link: link}
+
+	result := obj.HandleClose(&proto.ControlMessage{Type: proto.ControlClose})
+
+	assert.True(t, result)
+	assert.Equal(t, Closed, obj.State)
+	assert.Same(t, ErrPeerClosed, context.Cause(obj.Context()))
+}
+
+func TestConduitHandleCloseOther(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{State: Open, Link: link}
+
+	result := obj.HandleClose(&proto.ControlMessage{Type: proto.ControlDrain})
+
+	assert.False(t, result)
+	assert.Equal(t, Open, obj.State)
+	link.AssertNotCalled(t, "Close", mock.Anything)
+}
@@ -14,7 +14,13 @@
 
 package conduit
 
-import "net"
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
 
 // State indicates the state the conduit is in.
 type State int
@@ -36,14 +42,63 @@ type Conduit struct {
 	MinProto     uint32      // Minimum supported protocol version
 	MaxProto     uint32      // Maximum supported protocol version
 	Proto        uint32      // Selected protocol version
+	Major        uint8       // Selected major version of the wire format
 	RTT          uint32      // Estimated round-trip time
 	Deviation    uint32      // Estimated round-trip time deviation
+	Bandwidth    uint64      // Estimated bandwidth, in bits per second
+	Loss         float64     // Estimated fraction of pings lost
 	Peer         interface{} // Peer or client description
 	Confidential bool        // Flag indicating conduit is confidential
 	Integrity    bool        // Flag indicating conduit is integrity-protected
+	Boundaries   bool        // Flag indicating conduit preserves message boundaries
 	Principal    string      // Name of the principal from security layer
 	Strength     uint32      // Estimate of the encryption strength
+	Binding      []byte      // Channel binding of the security layer
+	Bound        bool        // Flag indicating negotiation verified the binding
 	LocalURI     *URI        // Local conduit URI
 	RemoteURI    *URI        // Remote conduit URI
-	Link         net.Conn    // Network connection
+	// Deprecated: Link provides raw access to the network
+	// connection, and bypasses the framing of PDUs; use Send and
+	// Recv instead.
+	Link         net.Conn          // Network connection
+	Fingerprints map[string]string // Transport fingerprints of the peer
+	// NegotiateTimeout bounds the time allowed for Negotiate; if
+	// zero, DefaultNegotiateTimeout is used.
+	NegotiateTimeout time.Duration
+	// Checksum selects the algorithm of the checksum extension
+	// added by Send to frames sent over a conduit that is not
+	// integrity-protected; if zero, proto.ChecksumCRC32C is used.
+	Checksum uint8
+
+	lock      sync.Mutex     // Protects the round-trip time estimates
+	drain     drainState     // Drain state of the conduit
+	xchg      exchange       // Framing state for Send and Recv
+	lanes     laneState      // Lanes scheduling frames sent over the conduit
+	ctx       contextState   // Context cancelled when the conduit dies
+	usage     usageState     // Usage measured against the usage policy
+	keepalive keepaliveState // Carrier-level keepalive state
+	queue     sendQueue      // Frames queued for sending
+	rate      rateState      // Rate limits on received PDUs
+	stats     statsState     // Counters of the PDUs exchanged
+	capture   captureState   // Capture of the PDUs exchanged
+}
+
+// Reader constructs a proto.Reader for reading PDUs from the conduit.
+// The framing strategy is selected based on the Boundaries flag: if
+// the conduit preserves message boundaries, each message carries one
+// PDU; otherwise, PDUs are delimited by their length.  The reader is
+// not buffered, and should not be used along with Recv.
+func (c *Conduit) Reader() *proto.Reader {
+	if c.Boundaries {
+		return proto.NewMessageReader(c.Link)
+	}
+
+	return proto.NewReader(c.Link)
+}
+
+// Writer constructs a proto.Writer for writing PDUs to the conduit.
+// Each PDU is written with a single write, so that on conduits
+// preserving message boundaries, each message carries one PDU.
+func (c *Conduit) Writer() *proto.Writer {
+	return proto.NewWriter(c.Link)
 }
@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// handshakePair runs a security layer handshake over a pipe,
+// returning the two conduits and the errors.
+func handshakePair[C any](handshake func(context.Context, *Conduit, C, bool) error, initCfg, respCfg C) (*Conduit, *Conduit, error, error) {
+	c1, c2 := net.Pipe()
+	init := &Conduit{State: Active, Link: c1}
+	resp := &Conduit{State: Passive, Link: c2}
+	errs := make(chan error)
+	go func() {
+		err := handshake(context.Background(), resp, respCfg, false)
+		if err != nil {
+			c2.Close()
+		}
+		errs <- err
+	}()
+	initErr := handshake(context.Background(), init, initCfg, true)
+	if initErr != nil {
+		c1.Close()
+	}
+
+	return init, resp, initErr, <-errs
+}
+
+func TestConduitReaderStream(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{Link: link}
+
+	result := obj.Reader()
+
+	assert.Equal(t, proto.NewReader(link), result)
+}
+
+func TestConduitReaderBoundaries(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{Link: link, Boundaries: true}
+
+	result := obj.Reader()
+
+	assert.Equal(t, proto.NewMessageReader(link), result)
+}
+
+func TestConduitWriter(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		obj.Writer().WriteFrame(&proto.Frame{ //nolint:errcheck
+			Header:  proto.Header{Protocol: 0x17},
+			Payload: []byte("abc"),
+		})
+	}()
+
+	buf := make([]byte, 16)
+	n, err := remote.Read(buf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c'}, buf[:n])
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduittest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+)
+
+// ErrInjected is a convenient error for faults to inject.
+var ErrInjected = errors.New("injected fault")
+
+// Op identifies the operations on a conduit's link that faults may
+// be injected into.
+type Op int
+
+// Operations on a link.
+const (
+	OpRead  Op = iota // Read from the link
+	OpWrite           // Write to the link
+)
+
+// String returns the name of the operation.
+func (o Op) String() string {
+	switch o {
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	}
+
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// Fault describes a fault to inject into an operation on a link.  A
+// fault fires when the operation is attempted after After further
+// operations of the same kind have completed; it delays the
+// operation, then closes the link if Close is set, and fails the
+// operation with Err if it is set.  A fault fires once unless Sticky
+// is set, in which case it fires for every subsequent operation.
+type Fault struct {
+	Op     Op            // The operation to inject the fault into
+	After  int           // Number of operations to allow first
+	Delay  time.Duration // Delay before performing the operation
+	Close  bool          // Close the link before performing the operation
+	Err    error         // Error to return instead of performing the operation
+	Sticky bool          // Fire for every subsequent operation
+}
+
+// pending is a fault waiting to fire.
+type pending struct {
+	fault Fault // The fault
+	at    int   // Operation count at which the fault fires
+}
+
+// Injector is a net.Conn wrapping the link of a conduit, injecting
+// faults into its operations.  It allows handlers of application
+// protocols to be tested against failures occurring at specific
+// points without requiring an unreliable network.
+type Injector struct {
+	net.Conn
+
+	lock    sync.Mutex                  // Protects the faults and counts
+	faults  []*pending                  // Faults waiting to fire
+	counts  [2]int                      // Counts of attempted operations
+	onFault func(op Op, n int, f Fault) // Called when a fault fires
+}
+
+// Inject wraps the link of a conduit with an Injector, which is
+// returned.  It must be called before the conduit is first used.
+func Inject(c *conduit.Conduit) *Injector {
+	inj := &Injector{Conn: c.Link}
+	c.Link = inj
+
+	return inj
+}
+
+// Add adds faults to the injector.  The After field of each fault is
+// counted from the time it is added.
+func (inj *Injector) Add(faults ...Fault) *Injector {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+
+	for _, f := range faults {
+		inj.faults = append(inj.faults, &pending{
+			fault: f,
+			at:    inj.counts[f.Op] + f.After,
+		})
+	}
+
+	return inj
+}
+
+// FailAfter adds a fault failing the operation with err after n
+// operations have succeeded.
+func (inj *Injector) FailAfter(op Op, n int, err error) *Injector {
+	return inj.Add(Fault{Op: op, After: n, Err: err})
+}
+
+// DelayAfter adds a fault delaying the operation by d after n
+// operations have completed.
+func (inj *Injector) DelayAfter(op Op, n int, d time.Duration) *Injector {
+	return inj.Add(Fault{Op: op, After: n, Delay: d})
+}
+
+// CloseAfter adds a fault closing the link before the operation
+// after n operations have succeeded.
+func (inj *Injector) CloseAfter(op Op, n int) *Injector {
+	return inj.Add(Fault{Op: op, After: n, Close: true})
+}
+
+// OnFault sets a function to be called whenever a fault fires, with
+// the operation, the number of operations previously attempted, and
+// the fault.  It is called before the fault is applied, and may be
+// used to coordinate the test with the faulted operation.
+func (inj *Injector) OnFault(f func(op Op, n int, fault Fault)) *Injector {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+
+	inj.onFault = f
+
+	return inj
+}
+
+// Clear discards all faults which have not yet fired, as well as all
+// sticky faults.
+func (inj *Injector) Clear() {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+
+	inj.faults = nil
+}
+
+// Count returns the number of operations of the specified kind that
+// have been attempted.
+func (inj *Injector) Count(op Op) int {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+
+	return inj.counts[op]
+}
+
+// fire counts an operation, returning the faults that fire for it,
+// the number of operations previously attempted, and the function to
+// call for each fault.
+func (inj *Injector) fire(op Op) ([]Fault, int, func(op Op, n int, f Fault)) {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+
+	n := inj.counts[op]
+	inj.counts[op]++
+
+	var result []Fault
+	kept := inj.faults[:0]
+	for _, p := range inj.faults {
+		if p.fault.Op != op || n < p.at {
+			kept = append(kept, p)
+			continue
+		}
+		result = append(result, p.fault)
+		if p.fault.Sticky {
+			kept = append(kept, p)
+		}
+	}
+	inj.faults = kept
+
+	return result, n, inj.onFault
+}
+
+// apply applies the faults firing for an operation, returning the
+// error to fail the operation with, if any.
+func (inj *Injector) apply(op Op) error {
+	faults, n, onFault := inj.fire(op)
+
+	var err error
+	for _, f := range faults {
+		if onFault != nil {
+			onFault(op, n, f)
+		}
+		if f.Delay > 0 {
+			time.Sleep(f.Delay)
+		}
+		if f.Close {
+			inj.Conn.Close() //nolint:errcheck
+		}
+		if f.Err != nil && err == nil {
+			err = f.Err
+		}
+	}
+
+	return err
+}
+
+// Read reads data from the link, subject to the faults.
+func (inj *Injector) Read(b []byte) (int, error) {
+	if err := inj.apply(OpRead); err != nil {
+		return 0, err
+	}
+
+	return inj.Conn.Read(b)
+}
+
+// Write writes data to the link, subject to the faults.
+func (inj *Injector) Write(b []byte) (int, error) {
+	if err := inj.apply(OpWrite); err != nil {
+		return 0, err
+	}
+
+	return inj.Conn.Write(b)
+}
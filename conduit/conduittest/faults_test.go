@@ -0,0 +1,162 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduittest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// injectPipe returns a conduit over one end of a pipe with an
+// injector, along with the other end.
+func injectPipe(t *testing.T) (*conduit.Conduit, *Injector, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	c := &conduit.Conduit{State: conduit.Open, Link: local}
+
+	return c, Inject(c), remote
+}
+
+// drain discards data written to a pipe.
+func drain(c net.Conn) {
+	go io.Copy(io.Discard, c) //nolint:errcheck
+}
+
+func TestOpString(t *testing.T) {
+	assert.Equal(t, "read", OpRead.String())
+	assert.Equal(t, "write", OpWrite.String())
+	assert.Equal(t, "Op(5)", Op(5).String())
+}
+
+func TestInject(t *testing.T) {
+	c, inj, _ := injectPipe(t)
+
+	assert.Same(t, inj, c.Link)
+	assert.NotNil(t, inj.Conn)
+}
+
+func TestInjectorFailAfter(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	drain(remote)
+	inj.FailAfter(OpWrite, 2, ErrInjected)
+
+	_, err1 := c.Link.Write([]byte("one"))
+	_, err2 := c.Link.Write([]byte("two"))
+	_, err3 := c.Link.Write([]byte("three"))
+	_, err4 := c.Link.Write([]byte("four"))
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Same(t, ErrInjected, err3)
+	assert.NoError(t, err4)
+	assert.Equal(t, 4, inj.Count(OpWrite))
+	assert.Equal(t, 0, inj.Count(OpRead))
+}
+
+func TestInjectorAfterCountsFromAdd(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	drain(remote)
+	c.Link.Write([]byte("one")) //nolint:errcheck
+	inj.FailAfter(OpWrite, 0, ErrInjected)
+
+	_, err := c.Link.Write([]byte("two"))
+
+	assert.Same(t, ErrInjected, err)
+}
+
+func TestInjectorSticky(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	drain(remote)
+	inj.Add(Fault{Op: OpWrite, After: 1, Err: ErrInjected, Sticky: true})
+
+	_, err1 := c.Link.Write([]byte("one"))
+	_, err2 := c.Link.Write([]byte("two"))
+	_, err3 := c.Link.Write([]byte("three"))
+	inj.Clear()
+	_, err4 := c.Link.Write([]byte("four"))
+
+	assert.NoError(t, err1)
+	assert.Same(t, ErrInjected, err2)
+	assert.Same(t, ErrInjected, err3)
+	assert.NoError(t, err4)
+}
+
+func TestInjectorDelayAfter(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	go remote.Write([]byte("data")) //nolint:errcheck
+	inj.DelayAfter(OpRead, 0, 50*time.Millisecond)
+	start := time.Now()
+
+	n, err := c.Link.Read(make([]byte, 4))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestInjectorCloseAfter(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	inj.CloseAfter(OpRead, 0)
+
+	_, err := c.Link.Read(make([]byte, 4))
+	_, rerr := remote.Read(make([]byte, 4))
+
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.ErrorIs(t, rerr, io.EOF)
+}
+
+func TestInjectorOnFault(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	drain(remote)
+	type call struct {
+		op Op
+		n  int
+	}
+	calls := []call{}
+	inj.OnFault(func(op Op, n int, f Fault) {
+		assert.Equal(t, 1, inj.Count(OpWrite)-n)
+		calls = append(calls, call{op, n})
+	}).FailAfter(OpWrite, 1, ErrInjected)
+
+	c.Link.Write([]byte("one")) //nolint:errcheck
+	c.Link.Write([]byte("two")) //nolint:errcheck
+
+	assert.Equal(t, []call{{OpWrite, 1}}, calls)
+}
+
+func TestInjectorSendRecv(t *testing.T) {
+	c, inj, remote := injectPipe(t)
+	peer := &conduit.Conduit{State: conduit.Open, Link: remote}
+	inj.FailAfter(OpRead, 0, ErrInjected)
+	go c.Send((&proto.ControlMessage{Type: proto.ControlPing}).Frame()) //nolint:errcheck
+
+	frame, err := peer.Recv()
+	_, recvErr := c.Recv()
+
+	assert.NoError(t, err)
+	assert.NotNil(t, frame)
+	assert.True(t, errors.Is(recvErr, ErrInjected))
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package conduittest contains a harness for soak testing conduit
+// mechanisms.  A Soak starts a number of echo servers listening on a
+// URI and a number of clients, each of which sends a schedule of
+// payloads to one of the servers and reads them back.  When the
+// clients finish, the harness verifies that every payload was echoed
+// intact and that each server received exactly the data sent by its
+// clients.  It may be used with any registered transport and
+// security layer mechanism, given a suitable configuration.
+//
+// The package also contains an Injector, which wraps the link of a
+// conduit to inject errors, delays, and forced closes into its reads
+// and writes, allowing handlers of application protocols to be
+// tested against failure interleavings.
+package conduittest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+)
+
+// Defaults for Soak.
+const (
+	DefaultMessages = 10   // Default maximum messages per client
+	DefaultMaxSize  = 1024 // Default maximum payload size
+)
+
+// Errors reported by Soak.
+var (
+	ErrEchoMismatch   = errors.New("echoed data does not match")
+	ErrServerMismatch = errors.New("data received by server does not match")
+)
+
+// Soak describes a soak test.  The zero values of the numeric fields
+// select the defaults.
+type Soak struct {
+	URI      string                                    // Listen URI of the servers; use port 0
+	Config   conduit.Config                            // Configuration for the mechanisms
+	Servers  int                                       // Number of servers; defaults to 1
+	Clients  int                                       // Number of clients; defaults to 1
+	Messages int                                       // Maximum payloads sent by each client
+	MaxSize  int                                       // Maximum size of each payload
+	MaxDelay time.Duration                             // Maximum delay before each payload
+	Seed     uint64                                    // Seed for the random schedules
+	Schedule func(client int, rng *rand.Rand) [][]byte // Generates a client's payloads, if set
+}
+
+// ClientResult describes the outcome for a single client.  Clients
+// are assigned to the servers in turn.
+type ClientResult struct {
+	Server    int      // Index of the server the client used
+	LocalURI  string   // Local URI of the client's conduit
+	RemoteURI string   // Remote URI of the client's conduit
+	Sent      [][]byte // The payloads sent
+	Echoed    [][]byte // The payloads echoed back
+	Err       error    // Error encountered by the client
+}
+
+// Result describes the outcome of a soak test.
+type Result struct {
+	Servers  []string            // The URIs of the servers
+	Clients  []*ClientResult     // The outcomes for the clients
+	Received []map[string][]byte // Data received by each server, by remote URI
+	Errors   []map[string]error  // Errors encountered by each server, by remote URI
+}
+
+// Schemes returns the URI schemes of all combinations of registered
+// transport and security layer mechanisms, suitable for running a
+// soak test over each.
+func Schemes() []string {
+	result := []string{}
+	for _, tr := range conduit.Transports() {
+		result = append(result, tr)
+		for _, sec := range conduit.Securities() {
+			result = append(result, tr+"+"+sec)
+		}
+	}
+
+	return result
+}
+
+// schedule generates the payloads for a client.
+func (s *Soak) schedule(client int, rng *rand.Rand) [][]byte {
+	if s.Schedule != nil {
+		return s.Schedule(client, rng)
+	}
+
+	messages, maxSize := s.Messages, s.MaxSize
+	if messages <= 0 {
+		messages = DefaultMessages
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	result := make([][]byte, 1+rng.IntN(messages))
+	for i := range result {
+		result[i] = make([]byte, 1+rng.IntN(maxSize))
+		for j := range result[i] {
+			result[i][j] = byte(rng.Uint32())
+		}
+	}
+
+	return result
+}
+
+// echo is a handler that echoes data back to the clients, recording
+// the data received.
+type echo struct {
+	sync.Mutex
+
+	received map[string][]byte // Data received, by remote URI
+	errors   map[string]error  // Errors encountered, by remote URI
+}
+
+// Handle echoes data over the conduit until it is closed.
+func (e *echo) Handle(ctx context.Context, c *conduit.Conduit) {
+	stop := context.AfterFunc(ctx, func() {
+		c.Link.Close() //nolint:errcheck
+	})
+	defer stop()
+
+	uri := c.RemoteURI.String()
+	buf := make([]byte, 65536)
+	for {
+		n, err := c.Link.Read(buf)
+		if n > 0 {
+			e.Lock()
+			e.received[uri] = append(e.received[uri], buf[:n]...)
+			e.Unlock()
+			if _, werr := c.Link.Write(buf[:n]); werr != nil && err == nil {
+				err = werr
+			}
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				e.Lock()
+				e.errors[uri] = err
+				e.Unlock()
+			}
+			return
+		}
+	}
+}
+
+// client runs a single client.
+func (s *Soak) client(ctx context.Context, idx int, uri string, res *ClientResult) {
+	rng := rand.New(rand.NewPCG(s.Seed, uint64(idx)))
+	res.Sent = s.schedule(idx, rng)
+
+	c, err := conduit.Dial(ctx, s.Config, uri)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	defer c.Link.Close() //nolint:errcheck
+	res.LocalURI = c.LocalURI.String()
+	res.RemoteURI = c.RemoteURI.String()
+
+	for _, msg := range res.Sent {
+		if s.MaxDelay > 0 {
+			time.Sleep(time.Duration(rng.Int64N(int64(s.MaxDelay))))
+		}
+		if _, err := c.Link.Write(msg); err != nil {
+			res.Err = err
+			return
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(c.Link, buf); err != nil {
+			res.Err = err
+			return
+		}
+		res.Echoed = append(res.Echoed, buf)
+	}
+}
+
+// Run runs the soak test.  It returns the result, and an error
+// joining all the errors encountered and the integrity failures
+// detected.
+func (s *Soak) Run(ctx context.Context) (*Result, error) {
+	servers, clients := max(s.Servers, 1), max(s.Clients, 1)
+	result := &Result{
+		Servers:  make([]string, servers),
+		Clients:  make([]*ClientResult, clients),
+		Received: make([]map[string][]byte, servers),
+		Errors:   make([]map[string]error, servers),
+	}
+
+	// Start the servers
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	handlers := make([]*echo, servers)
+	serveErrs := make([]error, servers)
+	wg := &sync.WaitGroup{}
+	for i := range servers {
+		l, err := conduit.Listen(ctx, s.Config, s.URI)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return nil, fmt.Errorf("server %d: %w", i, err)
+		}
+		result.Servers[i] = l.Addr().String()
+		handlers[i] = &echo{received: map[string][]byte{}, errors: map[string]error{}}
+		srv := &conduit.Server{Listener: l, Handler: handlers[i]}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Serve(sctx); err != nil {
+				serveErrs[i] = fmt.Errorf("server %d: %w", i, err)
+			}
+		}()
+	}
+
+	// Run the clients to completion
+	cwg := &sync.WaitGroup{}
+	for i := range clients {
+		result.Clients[i] = &ClientResult{Server: i % servers}
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			s.client(ctx, i, result.Servers[i%servers], result.Clients[i])
+		}()
+	}
+	cwg.Wait()
+
+	// Shut down the servers
+	cancel()
+	wg.Wait()
+	errs := serveErrs
+	for i, h := range handlers {
+		result.Received[i] = h.received
+		result.Errors[i] = h.errors
+		for uri, err := range h.errors {
+			errs = append(errs, fmt.Errorf("server %d: client %s: %w", i, uri, err))
+		}
+	}
+
+	// Verify the data
+	for i, res := range result.Clients {
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("client %d: %w", i, res.Err))
+			continue
+		}
+		sent := bytes.Join(res.Sent, nil)
+		if !bytes.Equal(sent, bytes.Join(res.Echoed, nil)) {
+			errs = append(errs, fmt.Errorf("client %d: %w", i, ErrEchoMismatch))
+		}
+		if !bytes.Equal(sent, result.Received[res.Server][res.LocalURI]) {
+			errs = append(errs, fmt.Errorf("client %d: server %d: %w", i, res.Server, ErrServerMismatch))
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// Execute runs the soak test, failing the test if any errors are
+// encountered or integrity failures detected.
+func (s *Soak) Execute(t testing.TB) *Result {
+	t.Helper()
+
+	result, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("soak test of %s: %v", s.URI, err)
+	}
+
+	return result
+}
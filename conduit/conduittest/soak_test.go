@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduittest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/conduit"
+)
+
+func TestSchemes(t *testing.T) {
+	result := Schemes()
+
+	assert.Contains(t, result, "tcp")
+	assert.Contains(t, result, "udp")
+	assert.Contains(t, result, "mem")
+}
+
+func TestSoakScheduleRandom(t *testing.T) {
+	obj := &Soak{Messages: 5, MaxSize: 16}
+
+	result := obj.schedule(0, rand.New(rand.NewPCG(1, 0)))
+
+	assert.NotEmpty(t, result)
+	assert.LessOrEqual(t, len(result), 5)
+	for _, msg := range result {
+		assert.NotEmpty(t, msg)
+		assert.LessOrEqual(t, len(msg), 16)
+	}
+	assert.Equal(t, result, obj.schedule(0, rand.New(rand.NewPCG(1, 0))))
+}
+
+func TestSoakScheduleFixed(t *testing.T) {
+	obj := &Soak{
+		Schedule: func(client int, rng *rand.Rand) [][]byte {
+			return [][]byte{{byte(client)}}
+		},
+	}
+
+	result := obj.schedule(3, nil)
+
+	assert.Equal(t, [][]byte{{3}}, result)
+}
+
+func TestSoakRunBase(t *testing.T) {
+	obj := &Soak{URI: "tcp://127.0.0.1:0", Servers: 2, Clients: 3}
+
+	result, err := obj.Run(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, result.Servers, 2)
+	for i, cli := range result.Clients {
+		assert.Equal(t, i%2, cli.Server)
+		assert.Equal(t, result.Servers[cli.Server], cli.RemoteURI)
+		assert.Equal(t, cli.Sent, cli.Echoed)
+	}
+}
+
+func TestSoakRunListenError(t *testing.T) {
+	obj := &Soak{URI: "bogus://127.0.0.1:0"}
+
+	result, err := obj.Run(context.Background())
+
+	assert.ErrorIs(t, err, conduit.ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestSoakExecuteBase(t *testing.T) {
+	obj := &Soak{URI: "mem:", Clients: 2, Messages: 4, MaxSize: 64, MaxDelay: time.Millisecond, Seed: 1}
+
+	result := obj.Execute(t)
+
+	assert.Len(t, result.Clients, 2)
+	for _, cli := range result.Clients {
+		assert.Equal(t, cli.Sent, cli.Echoed)
+	}
+}
+
+// fatalTB is a testing.TB recording the message passed to Fatalf.
+type fatalTB struct {
+	testing.TB
+
+	msg string // The message passed to Fatalf
+}
+
+// Helper marks the calling function as a test helper.
+func (tb *fatalTB) Helper() {}
+
+// Fatalf records the message.
+func (tb *fatalTB) Fatalf(format string, args ...any) {
+	tb.msg = fmt.Sprintf(format, args...)
+}
+
+func TestSoakExecuteFailed(t *testing.T) {
+	tb := &fatalTB{}
+	obj := &Soak{URI: "bogus:"}
+
+	result := obj.Execute(tb)
+
+	assert.Nil(t, result)
+	assert.Contains(t, tb.msg, "soak test of bogus:")
+}
@@ -31,3 +31,11 @@ func (m *mockConfig) ForSecurity(name string) interface{} {
 
 	return args.Get(0)
 }
+
+// securityCfg returns a configuration for the named security layer.
+func securityCfg(name string, sc interface{}) *mockConfig {
+	cfg := &mockConfig{}
+	cfg.On("ForSecurity", name).Return(sc)
+
+	return cfg
+}
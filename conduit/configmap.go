@@ -0,0 +1,1083 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/ecdh"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"gopkg.in/yaml.v3"
+
+	"github.com/hydralang/humboldt/config"
+)
+
+// ConfigMap is an implementation of Config that maps mechanism names
+// to their configurations.  It may be constructed directly or loaded
+// from a file using LoadConfig.  In a configuration file, the
+// transport mechanism configurations are given by the "transport"
+// map, and the security layer mechanism configurations by the
+// "security" map; for example:
+//
+//	transport:
+//	  quic:
+//	    keepalive: 30s
+//	    tls:
+//	      cert: /etc/humboldt/cert.pem
+//	      key: /etc/humboldt/key.pem
+//
+// The "usage" map, if present, gives the usage policy for conduits,
+// as decoded by DecodeUsagePolicy; the "rate" map, if present, gives
+// the rate limits for conduits, as decoded by DecodeRateLimit; the
+// "chaos" map, if present, gives the faults to inject into conduits
+// for debugging, as decoded by DecodeChaos; the "resolver" map, if
+// present, gives the secure DNS server used to look up peers, as
+// decoded by DecodeResolver; the "cache" map, if present, gives the
+// caching of canonicalization results, as decoded by DecodeCache; and
+// the "selector" string, if present, names the Selector ordering the
+// canonical URIs dialed, as accepted by NewSelector.
+type ConfigMap struct {
+	Transports map[string]interface{} // Transport mechanism configurations
+	Securities map[string]interface{} // Security layer mechanism configurations
+	Usage      *UsagePolicy           // Usage policy for conduits; may be nil
+	Rate       *RateLimit             // Rate limits for conduits; may be nil
+	Chaos      *Chaos                 // Faults to inject into conduits; may be nil
+	Resolver   HostResolver           // Resolver for looking up peers; may be nil
+	Cache      *Cache                 // Cache for canonicalization results; may be nil
+	Selector   Selector               // Orders the canonical URIs dialed; may be nil
+}
+
+// ForTransport retrieves the configuration for a specified transport
+// mechanism.
+func (c *ConfigMap) ForTransport(name string) interface{} {
+	return c.Transports[name]
+}
+
+// ForSecurity retrieves the configuration for a specified security
+// layer mechanism.
+func (c *ConfigMap) ForSecurity(name string) interface{} {
+	return c.Securities[name]
+}
+
+// ConfigDecoder is a function that decodes the raw configuration of a
+// mechanism, as loaded from a configuration file, into the type
+// expected by the mechanism.
+type ConfigDecoder func(raw map[string]interface{}) (interface{}, error)
+
+// Registries of configuration decoders.  Mechanisms without a decoder
+// receive the raw configuration.
+var (
+	transConfigs = map[string]ConfigDecoder{
+		"quic":        decodeQUICConfig,
+		"httpconnect": decodeHTTPConnectConfig,
+	}
+	secConfigs = map[string]ConfigDecoder{
+		"noise": decodeNoiseConfig,
+		"psk":   decodePSKConfig,
+		"tls":   decodeTLSSecConfig,
+	}
+	configLock sync.RWMutex
+)
+
+// RegisterTransportConfig registers a configuration decoder for a
+// transport mechanism.
+func RegisterTransportConfig(name string, dec ConfigDecoder) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	transConfigs[name] = dec
+}
+
+// RegisterSecurityConfig registers a configuration decoder for a
+// security layer mechanism.
+func RegisterSecurityConfig(name string, dec ConfigDecoder) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	secConfigs[name] = dec
+}
+
+// decodeSection decodes the mechanism configurations of a section of
+// the configuration tree.
+func decodeSection(key string, raw interface{}, decoders map[string]ConfigDecoder) (map[string]interface{}, error) {
+	section, ok := raw.(map[string]interface{})
+	if raw != nil && !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+
+	result := map[string]interface{}{}
+	for name, tmp := range section {
+		conf, ok := tmp.(map[string]interface{})
+		if tmp != nil && !ok {
+			return nil, fmt.Errorf("%s %q: %w", key, name, ErrBadConfig)
+		} else if conf == nil {
+			conf = map[string]interface{}{}
+		}
+
+		dec, ok := decoders[name]
+		if !ok {
+			result[name] = conf
+			continue
+		}
+		obj, err := dec(conf)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", key, name, err)
+		}
+		result[name] = obj
+	}
+
+	return result, nil
+}
+
+// DecodeConfig decodes a configuration tree, as returned by
+// LoadConfigTree, into a ConfigMap.  Keys other than "transport",
+// "security", "usage", "rate", "chaos", "resolver", "cache", and
+// "selector" are ignored, so that the same tree may carry the
+// configuration of other components.
+func DecodeConfig(tree map[string]interface{}) (*ConfigMap, error) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	trans, err := decodeSection("transport", tree["transport"], transConfigs)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := decodeSection("security", tree["security"], secConfigs)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := cfgMap(tree, "usage")
+	if err != nil {
+		return nil, err
+	}
+	var policy *UsagePolicy
+	if usage != nil {
+		if policy, err = DecodeUsagePolicy(usage); err != nil {
+			return nil, fmt.Errorf("usage: %w", err)
+		}
+	}
+	rate, err := cfgMap(tree, "rate")
+	if err != nil {
+		return nil, err
+	}
+	var limit *RateLimit
+	if rate != nil {
+		if limit, err = DecodeRateLimit(rate); err != nil {
+			return nil, fmt.Errorf("rate: %w", err)
+		}
+	}
+	faults, err := cfgMap(tree, "chaos")
+	if err != nil {
+		return nil, err
+	}
+	var chaos *Chaos
+	if faults != nil {
+		if chaos, err = DecodeChaos(faults); err != nil {
+			return nil, fmt.Errorf("chaos: %w", err)
+		}
+	}
+	resRaw, err := cfgMap(tree, "resolver")
+	if err != nil {
+		return nil, err
+	}
+	var resolver HostResolver
+	if resRaw != nil {
+		if resolver, err = DecodeResolver(resRaw); err != nil {
+			return nil, fmt.Errorf("resolver: %w", err)
+		}
+	}
+	cacheRaw, err := cfgMap(tree, "cache")
+	if err != nil {
+		return nil, err
+	}
+	var cache *Cache
+	if cacheRaw != nil {
+		if cache, err = DecodeCache(cacheRaw); err != nil {
+			return nil, fmt.Errorf("cache: %w", err)
+		}
+	}
+	selName, err := ConfigString(tree, "selector")
+	if err != nil {
+		return nil, err
+	}
+	var sel Selector
+	if selName != "" {
+		if sel, err = NewSelector(selName); err != nil {
+			return nil, fmt.Errorf("selector: %w: %w", ErrBadConfig, err)
+		}
+	}
+
+	return &ConfigMap{
+		Transports: trans,
+		Securities: sec,
+		Usage:      policy,
+		Rate:       limit,
+		Chaos:      chaos,
+		Resolver:   resolver,
+		Cache:      cache,
+		Selector:   sel,
+	}, nil
+}
+
+// unmarshalConfig unmarshals configuration data in the specified
+// format, which must be "json" or "yaml", into a configuration tree.
+func unmarshalConfig(data []byte, format string) (map[string]interface{}, error) {
+	tree := map[string]interface{}{}
+	switch format {
+	case "json":
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadConfig, err)
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadConfig, err)
+		}
+	default:
+		return nil, fmt.Errorf("%q: %w", format, ErrConfigFormat)
+	}
+	if tree == nil {
+		tree = map[string]interface{}{}
+	}
+
+	return tree, nil
+}
+
+// ParseConfig parses configuration data in the specified format,
+// which must be "json" or "yaml".  Environment variable overrides are
+// not applied.
+func ParseConfig(data []byte, format string) (*ConfigMap, error) {
+	tree, err := unmarshalConfig(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeConfig(tree)
+}
+
+// LoadConfigTree loads the configuration file at the specified path,
+// then applies any environment variable overrides using
+// config.Overlay with the default prefix; for instance, the keepalive
+// of the QUIC transport may be set with
+// HUMBOLDT__TRANSPORT__QUIC__KEEPALIVE.  The format is selected by
+// the file extension, which must be ".json", ".yaml", or ".yml".  If
+// the path is empty, the configuration tree is constructed from the
+// environment variables alone.
+func LoadConfigTree(path string) (map[string]interface{}, error) {
+	tree := map[string]interface{}{}
+	if path != "" {
+		format := ""
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			format = "json"
+		case ".yaml", ".yml":
+			format = "yaml"
+		default:
+			return nil, fmt.Errorf("%s: %w", path, ErrConfigFormat)
+		}
+
+		data, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if tree, err = unmarshalConfig(data, format); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := config.Overlay(tree, config.EnvPrefix, osEnviron()); err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+// LoadConfig loads the configuration file at the specified path as
+// LoadConfigTree does, and decodes it into a ConfigMap.
+func LoadConfig(path string) (*ConfigMap, error) {
+	tree, err := LoadConfigTree(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeConfig(tree)
+}
+
+// ConfigString retrieves a string value from a raw configuration, as
+// returned by LoadConfigTree.  An error wrapping ErrBadConfig is
+// returned if the value is not a string.
+func ConfigString(raw map[string]interface{}, key string) (string, error) {
+	switch v := raw[key].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+}
+
+// cfgBool retrieves a boolean value from a raw configuration.
+// Strings, as set by environment variables, are parsed.
+func cfgBool(raw map[string]interface{}, key string) (bool, error) {
+	switch v := raw[key].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w: %w", key, ErrBadConfig, err)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+}
+
+// ConfigDuration retrieves a duration from a raw configuration, as
+// returned by LoadConfigTree.  Strings are parsed with
+// time.ParseDuration; numbers are in seconds.  An error wrapping
+// ErrBadConfig is returned if the value is not a duration.
+func ConfigDuration(raw map[string]interface{}, key string) (time.Duration, error) {
+	switch v := raw[key].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w: %w", key, ErrBadConfig, err)
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+}
+
+// cfgFloat retrieves a number from a raw configuration.  Strings, as
+// set by environment variables, are parsed.
+func cfgFloat(raw map[string]interface{}, key string) (float64, error) {
+	switch v := raw[key].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w: %w", key, ErrBadConfig, err)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+}
+
+// cfgMap retrieves a nested configuration from a raw configuration.
+func cfgMap(raw map[string]interface{}, key string) (map[string]interface{}, error) {
+	switch v := raw[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+}
+
+// DecodeTLSConfig decodes a raw TLS configuration.  The recognized
+// keys are "cert" and "key", giving the PEM files containing the
+// certificate and private key; "ca", giving a PEM file of CA
+// certificates used to verify peers; "server_name";
+// "insecure_skip_verify"; "session_cache", the number of sessions
+// cached so that redials may resume them; and the duration "reload".
+// If a CA file is given, client certificates are required and
+// verified against it.  If "reload" is given, the files are loaded by
+// a TLSReloader checking them for changes at that interval.  The
+// "acme" map, if present, configures obtaining the certificate
+// through ACME instead, as decoded by cfgACME.  If "workload_api" is
+// given, the certificate and the trust bundles used to verify peers
+// are fetched from the SPIFFE Workload API at that address instead,
+// or at the address given by the environment if it is "default".  It
+// may be used by the decoders of mechanisms that use TLS.
+func DecodeTLSConfig(raw map[string]interface{}) (*tls.Config, error) {
+	a, err := cfgACME(raw, "acme")
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	workload, err := ConfigString(raw, "workload_api")
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	} else if workload != "" && a != nil {
+		return nil, fmt.Errorf("tls: workload_api: %w", ErrBadConfig)
+	}
+	result, err := decodeTLSFiles(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case a != nil:
+		return a.Config(result)
+	case workload == "default":
+		return (&WorkloadAPI{}).Config(result), nil
+	case workload != "":
+		w := &WorkloadAPI{Addr: workload}
+		if _, err := w.socket(); err != nil {
+			return nil, fmt.Errorf("tls: workload_api: %w", err)
+		}
+		return w.Config(result), nil
+	}
+
+	return result, nil
+}
+
+// cfgACME retrieves the ACME configuration from a raw configuration.
+// The recognized keys are "directory", "email", "cache", "challenge",
+// "hosts", the duration "renew_before", and "dns_hook", naming a
+// command run as described for ACMEDNSHook.
+func cfgACME(raw map[string]interface{}, key string) (*ACME, error) {
+	conf, err := cfgMap(raw, key)
+	if err != nil || conf == nil {
+		return nil, err
+	}
+
+	vals := map[string]string{}
+	for _, k := range []string{"directory", "email", "cache", "challenge", "dns_hook"} {
+		if vals[k], err = ConfigString(conf, k); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	result := &ACME{
+		Directory: vals["directory"],
+		Email:     vals["email"],
+		Cache:     vals["cache"],
+		Challenge: vals["challenge"],
+	}
+	if vals["dns_hook"] != "" {
+		result.DNS = ACMEDNSHook(vals["dns_hook"])
+	}
+	if result.Hosts, err = ConfigList(conf, "hosts"); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	if result.RenewBefore, err = ConfigDuration(conf, "renew_before"); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return result, nil
+}
+
+// decodeTLSFiles decodes a raw TLS configuration, loading the files
+// it names.
+func decodeTLSFiles(raw map[string]interface{}) (*tls.Config, error) {
+	vals := map[string]string{}
+	for _, key := range []string{"cert", "key", "ca", "server_name"} {
+		v, err := ConfigString(raw, key)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		vals[key] = v
+	}
+	insecure, err := cfgBool(raw, "insecure_skip_verify")
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	sessions, err := cfgFloat(raw, "session_cache")
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	reload, err := ConfigDuration(raw, "reload")
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	result := &tls.Config{
+		ServerName:         vals["server_name"],
+		InsecureSkipVerify: insecure, //nolint:gosec
+	}
+	if sessions > 0 {
+		result.ClientSessionCache = tls.NewLRUClientSessionCache(int(sessions))
+	}
+
+	// Load the files through a reloader
+	if reload > 0 {
+		r := &TLSReloader{CertFile: vals["cert"], KeyFile: vals["key"], CAFile: vals["ca"], Interval: reload}
+		if err := r.Reload(); err != nil {
+			return nil, err
+		}
+		if vals["ca"] != "" {
+			result.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return r.Config(result), nil
+	}
+
+	// Load the certificate
+	if vals["cert"] != "" || vals["key"] != "" {
+		cert, err := loadX509KeyPair(vals["cert"], vals["key"])
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+
+	// Load the CA certificates
+	if vals["ca"] != "" {
+		data, err := readFile(vals["ca"])
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("tls: ca: %w", ErrBadConfig)
+		}
+		result.RootCAs = pool
+		result.ClientCAs = pool
+		result.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return result, nil
+}
+
+// decodeQUICConfig decodes the raw configuration of the QUIC
+// transport.  The recognized keys are "tls", a TLS configuration as
+// described for DecodeTLSConfig; "authorize" and "allow", selecting
+// the authorizer for peers; and the durations "keepalive",
+// "max_idle", and "handshake_timeout".
+func decodeQUICConfig(raw map[string]interface{}) (interface{}, error) {
+	result := &QUICConfig{}
+
+	// Decode the TLS configuration
+	tlsRaw, err := cfgMap(raw, "tls")
+	if err != nil {
+		return nil, err
+	}
+	if tlsRaw != nil {
+		if result.TLS, err = DecodeTLSConfig(tlsRaw); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Authorizer, err = cfgAuthorizer(raw); err != nil {
+		return nil, err
+	}
+
+	// Decode the QUIC configuration
+	qc := &quic.Config{}
+	durations := map[string]*time.Duration{
+		"keepalive":         &qc.KeepAlivePeriod,
+		"max_idle":          &qc.MaxIdleTimeout,
+		"handshake_timeout": &qc.HandshakeIdleTimeout,
+	}
+	set := false
+	for key, ptr := range durations {
+		if *ptr, err = ConfigDuration(raw, key); err != nil {
+			return nil, err
+		}
+		set = set || *ptr != 0
+	}
+	if set {
+		result.QUIC = qc
+	}
+
+	return result, nil
+}
+
+// decodeHTTPConnectConfig decodes the raw configuration of the HTTP
+// CONNECT transport.  The recognized keys are "proxy", the URL of the
+// proxy; "tls", a TLS configuration as described for DecodeTLSConfig;
+// and "header", a map of additional request headers.
+func decodeHTTPConnectConfig(raw map[string]interface{}) (interface{}, error) {
+	result := &HTTPConnectConfig{}
+
+	// Decode the proxy URL
+	proxy, err := ConfigString(raw, "proxy")
+	if err != nil {
+		return nil, err
+	}
+	if proxy != "" {
+		if result.Proxy, err = url.Parse(proxy); err != nil {
+			return nil, fmt.Errorf("proxy: %w: %w", ErrBadConfig, err)
+		}
+	}
+
+	// Decode the TLS configuration
+	tlsRaw, err := cfgMap(raw, "tls")
+	if err != nil {
+		return nil, err
+	}
+	if tlsRaw != nil {
+		if result.TLS, err = DecodeTLSConfig(tlsRaw); err != nil {
+			return nil, err
+		}
+	}
+
+	// Decode the headers
+	hdrRaw, err := cfgMap(raw, "header")
+	if err != nil {
+		return nil, err
+	}
+	if hdrRaw != nil {
+		result.Header = http.Header{}
+		for key := range hdrRaw {
+			val, err := ConfigString(hdrRaw, key)
+			if err != nil {
+				return nil, err
+			}
+			result.Header.Set(key, val)
+		}
+	}
+
+	return result, nil
+}
+
+// cfgKey decodes a base64-encoded key from a configuration, checking
+// that its size is within the specified bounds.  A maximum of 0
+// indicates there is no upper bound.
+func cfgKey(key, val string, minSize, maxSize int) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", key, ErrBadConfig, err)
+	}
+	if len(data) < minSize || (maxSize > 0 && len(data) > maxSize) {
+		return nil, fmt.Errorf("%s: %w: bad key size %d", key, ErrBadConfig, len(data))
+	}
+
+	return data, nil
+}
+
+// cfgKeyString retrieves a base64-encoded key from a raw
+// configuration, either directly from the specified key or from the
+// file named by the key with "_file" appended.
+func cfgKeyString(raw map[string]interface{}, key string) (string, error) {
+	val, err := ConfigString(raw, key)
+	if err != nil {
+		return "", err
+	}
+	file, err := ConfigString(raw, key+"_file")
+	if err != nil {
+		return "", err
+	}
+	if val == "" && file != "" {
+		data, err := readFile(file)
+		if err != nil {
+			return "", fmt.Errorf("%s_file: %w", key, err)
+		}
+		val = string(data)
+	}
+
+	return val, nil
+}
+
+// ConfigList retrieves a list of strings from a raw configuration, as
+// returned by LoadConfigTree.  The list may also be given as a
+// comma-separated string.  An error wrapping ErrBadConfig is returned
+// if the value is not a list of strings.
+func ConfigList(raw map[string]interface{}, key string) ([]string, error) {
+	var result []string
+	switch v := raw[key].(type) {
+	case nil:
+	case []interface{}:
+		for _, tmp := range v {
+			item, ok := tmp.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: %w", key, ErrBadConfig)
+			}
+			result = append(result, item)
+		}
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+
+	return result, nil
+}
+
+// cfgAuthorizer decodes the authorizer for peers presenting TLS
+// certificates.  The key "authorize" selects the authorizer: "cn",
+// "san", "spki", or "spiffe", for CommonNameAuthorizer,
+// SANAuthorizer, SPKIAuthorizer, and SPIFFEAuthorizer, respectively;
+// "allow" lists the names, fingerprints, or SPIFFE ID patterns
+// authorized.  If "workload_api" is set in the same configuration,
+// SPIFFEAuthorizer is the default.
+func cfgAuthorizer(raw map[string]interface{}) (Authorizer, error) {
+	kind, err := ConfigString(raw, "authorize")
+	if err != nil {
+		return nil, err
+	}
+	allow, err := ConfigList(raw, "allow")
+	if err != nil {
+		return nil, err
+	}
+
+	workload, err := ConfigString(raw, "workload_api")
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "":
+		if workload != "" {
+			return &SPIFFEAuthorizer{Allow: allow}, nil
+		} else if allow != nil {
+			return nil, fmt.Errorf("allow: %w", ErrBadConfig)
+		}
+		return nil, nil
+	case "cn":
+		return &CommonNameAuthorizer{Allow: allow}, nil
+	case "san":
+		return &SANAuthorizer{Allow: allow}, nil
+	case "spki":
+		return &SPKIAuthorizer{Allow: allow}, nil
+	case "spiffe":
+		return &SPIFFEAuthorizer{Allow: allow}, nil
+	}
+
+	return nil, fmt.Errorf("authorize: %q: %w", kind, ErrBadConfig)
+}
+
+// decodeNoiseConfig decodes the raw configuration of the Noise
+// security layer.  The recognized keys are "key", the base64-encoded
+// X25519 static private key, or "key_file", a file containing it;
+// "peers", a list of the base64-encoded static public keys of allowed
+// peers, which may also be given as a comma-separated string;
+// "prologue"; and the duration "timeout".
+func decodeNoiseConfig(raw map[string]interface{}) (interface{}, error) {
+	result := &NoiseConfig{}
+
+	// Decode the static key
+	key, err := cfgKeyString(raw, "key")
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		data, err := cfgKey("key", key, NoiseKeySize, NoiseKeySize)
+		if err != nil {
+			return nil, err
+		}
+		if result.Static, err = ecdh.X25519().NewPrivateKey(data); err != nil {
+			return nil, fmt.Errorf("key: %w: %w", ErrBadConfig, err)
+		}
+	}
+
+	// Decode the allowed peers
+	peers, err := ConfigList(raw, "peers")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range peers {
+		data, err := cfgKey("peers", p, NoiseKeySize, NoiseKeySize)
+		if err != nil {
+			return nil, err
+		}
+		result.Peers = append(result.Peers, data)
+	}
+
+	// Decode the remaining options
+	prologue, err := ConfigString(raw, "prologue")
+	if err != nil {
+		return nil, err
+	}
+	if prologue != "" {
+		result.Prologue = []byte(prologue)
+	}
+	if result.Timeout, err = ConfigDuration(raw, "timeout"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// decodePSKConfig decodes the raw configuration of the PSK security
+// layer.  The recognized keys are "identity"; "key", the
+// base64-encoded pre-shared key, or "key_file", a file containing it;
+// "keys", a map of identities to base64-encoded pre-shared keys; and
+// the duration "timeout".
+func decodePSKConfig(raw map[string]interface{}) (interface{}, error) {
+	result := &PSKConfig{}
+
+	// Decode the identity and key
+	var err error
+	if result.Identity, err = ConfigString(raw, "identity"); err != nil {
+		return nil, err
+	}
+	if len(result.Identity) > PSKMaxIdentity {
+		return nil, fmt.Errorf("identity: %w", ErrBadConfig)
+	}
+	key, err := cfgKeyString(raw, "key")
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		if result.Key, err = cfgKey("key", key, PSKMinKeySize, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// Decode the keys of the peers
+	keysRaw, err := cfgMap(raw, "keys")
+	if err != nil {
+		return nil, err
+	}
+	if keysRaw != nil {
+		result.Keys = map[string][]byte{}
+		for ident := range keysRaw {
+			val, err := ConfigString(keysRaw, ident)
+			if err != nil {
+				return nil, fmt.Errorf("keys: %w", err)
+			}
+			if result.Keys[ident], err = cfgKey("keys: "+ident, val, PSKMinKeySize, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if result.Timeout, err = ConfigDuration(raw, "timeout"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// decodeTLSSecConfig decodes the raw configuration of the TLS
+// security layer.  The recognized keys are those described for
+// DecodeTLSConfig; "authorize" and "allow", selecting the authorizer
+// for peers; and the duration "timeout".
+func decodeTLSSecConfig(raw map[string]interface{}) (interface{}, error) {
+	result := &TLSConfig{}
+
+	var err error
+	if result.TLS, err = DecodeTLSConfig(raw); err != nil {
+		return nil, err
+	}
+	if result.Authorizer, err = cfgAuthorizer(raw); err != nil {
+		return nil, err
+	}
+	if result.Timeout, err = ConfigDuration(raw, "timeout"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// cfgLimit retrieves a usage limit from a raw configuration.  The
+// limit is a map with the keys "soft" and "hard".
+func cfgLimit(raw map[string]interface{}, key string) (Limit, error) {
+	limit := Limit{}
+	conf, err := cfgMap(raw, key)
+	if err != nil || conf == nil {
+		return limit, err
+	}
+
+	if limit.Soft, err = cfgFloat(conf, "soft"); err != nil {
+		return limit, fmt.Errorf("%s: %w", key, err)
+	}
+	if limit.Hard, err = cfgFloat(conf, "hard"); err != nil {
+		return limit, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return limit, nil
+}
+
+// DecodeUsagePolicy decodes a raw usage policy.  The recognized keys
+// are "messages", "bytes", and "outstanding", giving the limits on
+// the corresponding measures as maps with the keys "soft" and
+// "hard"; and "window", giving the window over which rates are
+// measured.  For example:
+//
+//	usage:
+//	  messages:
+//	    soft: 500
+//	    hard: 1000
+//	  outstanding:
+//	    hard: 64
+func DecodeUsagePolicy(raw map[string]interface{}) (*UsagePolicy, error) {
+	var err error
+	result := &UsagePolicy{}
+	if result.Messages, err = cfgLimit(raw, "messages"); err != nil {
+		return nil, err
+	}
+	if result.Bytes, err = cfgLimit(raw, "bytes"); err != nil {
+		return nil, err
+	}
+	if result.Outstanding, err = cfgLimit(raw, "outstanding"); err != nil {
+		return nil, err
+	}
+	if result.Window, err = ConfigDuration(raw, "window"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// cfgRate retrieves a rate limit from a raw configuration.  The rate
+// is a map with the keys "rate" and "burst".
+func cfgRate(raw map[string]interface{}, key string) (Rate, error) {
+	rate := Rate{}
+	conf, err := cfgMap(raw, key)
+	if err != nil || conf == nil {
+		return rate, err
+	}
+
+	if rate.Rate, err = cfgFloat(conf, "rate"); err != nil {
+		return rate, fmt.Errorf("%s: %w", key, err)
+	}
+	if rate.Burst, err = cfgFloat(conf, "burst"); err != nil {
+		return rate, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return rate, nil
+}
+
+// DecodeRateLimit decodes raw rate limits.  The recognized keys are
+// "pdus" and "bytes", giving the limits on the corresponding rates as
+// maps with the keys "rate" and "burst".  For example:
+//
+//	rate:
+//	  pdus:
+//	    rate: 1000
+//	    burst: 2000
+//	  bytes:
+//	    rate: 1048576
+func DecodeRateLimit(raw map[string]interface{}) (*RateLimit, error) {
+	var err error
+	result := &RateLimit{}
+	if result.PDUs, err = cfgRate(raw, "pdus"); err != nil {
+		return nil, err
+	}
+	if result.Bytes, err = cfgRate(raw, "bytes"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DecodeChaos decodes the raw faults to inject into conduits.  The
+// recognized keys are "delay", "truncate", "reset", and "reorder",
+// giving the probabilities of the corresponding faults; "max_delay",
+// giving the maximum delay of a delayed write; and "seed", giving
+// the seed for the faults.  For example:
+//
+//	chaos:
+//	  delay: 0.1
+//	  max_delay: 500ms
+//	  reset: 0.001
+func DecodeChaos(raw map[string]interface{}) (*Chaos, error) {
+	var err error
+	result := &Chaos{}
+	for key, p := range map[string]*float64{
+		"delay":    &result.Delay,
+		"truncate": &result.Truncate,
+		"reset":    &result.Reset,
+		"reorder":  &result.Reorder,
+	} {
+		if *p, err = cfgFloat(raw, key); err != nil {
+			return nil, err
+		}
+		if *p < 0 || *p > 1 {
+			return nil, fmt.Errorf("%s: %w", key, ErrBadConfig)
+		}
+	}
+	if result.MaxDelay, err = ConfigDuration(raw, "max_delay"); err != nil {
+		return nil, err
+	}
+	seed, err := cfgFloat(raw, "seed")
+	if err != nil {
+		return nil, err
+	}
+	result.Seed = uint64(seed)
+
+	return result, nil
+}
+
+// DecodeResolver decodes the raw configuration of a SecureResolver.
+// The recognized keys are "server", the "https" or "tls" URL of the
+// DNS server, which is required; "tls", a TLS configuration as
+// described for DecodeTLSConfig; and "timeout", the time allowed for
+// each query.  For example:
+//
+//	resolver:
+//	  server: https://dns.example/dns-query
+//	  timeout: 2s
+func DecodeResolver(raw map[string]interface{}) (*SecureResolver, error) {
+	server, err := ConfigString(raw, "server")
+	if err != nil {
+		return nil, err
+	}
+	tlsRaw, err := cfgMap(raw, "tls")
+	if err != nil {
+		return nil, err
+	}
+	var conf *tls.Config
+	if tlsRaw != nil {
+		if conf, err = DecodeTLSConfig(tlsRaw); err != nil {
+			return nil, err
+		}
+	}
+	result, err := NewSecureResolver(server, conf)
+	if err != nil {
+		return nil, fmt.Errorf("server: %w: %w", ErrBadConfig, err)
+	}
+	if result.Timeout, err = ConfigDuration(raw, "timeout"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DecodeCache decodes the raw configuration of a Cache.  The
+// recognized keys are "ttl", the time results lacking a TTL are
+// cached, and "stale", the time expired results may be served if the
+// lookups fail, or a negative duration to never serve them.  For
+// example:
+//
+//	cache:
+//	  ttl: 1m
+//	  stale: 1h
+func DecodeCache(raw map[string]interface{}) (*Cache, error) {
+	var err error
+	result := &Cache{}
+	if result.TTL, err = ConfigDuration(raw, "ttl"); err != nil {
+		return nil, err
+	}
+	if result.TTL < 0 {
+		return nil, fmt.Errorf("ttl: %w", ErrBadConfig)
+	}
+	if result.Stale, err = ConfigDuration(raw, "stale"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// contextState holds the context associated with a conduit.  The
+// context is created on first use, so that conduits constructed by
+// mechanisms need not initialize it.
+type contextState struct {
+	sync.Mutex
+
+	ctx    context.Context         // The conduit's context
+	cancel context.CancelCauseFunc // Cancels the context
+	closed bool                    // Close has been called
+}
+
+// get returns the context and its cancel function, creating them if
+// necessary.
+func (cs *contextState) get() (context.Context, context.CancelCauseFunc) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.ctx == nil {
+		cs.ctx, cs.cancel = context.WithCancelCause(context.Background())
+	}
+
+	return cs.ctx, cs.cancel
+}
+
+// Context returns the context associated with the conduit.  The
+// context is cancelled when the conduit is closed, when negotiation
+// fails, or when Send or Recv fails, so that goroutines, timers, and
+// downstream calls tied to the conduit may be cleaned up when the
+// link dies.  The error causing the cancellation may be retrieved
+// using context.Cause; it is ErrConduitClosed if the conduit was
+// closed.
+func (c *Conduit) Context() context.Context {
+	ctx, _ := c.ctx.get()
+
+	return ctx
+}
+
+// cancelContext cancels the context of the conduit with the
+// specified cause.  The first cause is retained.
+func (c *Conduit) cancelContext(cause error) {
+	_, cancel := c.ctx.get()
+	cancel(cause)
+}
+
+// interruptRecv arranges for a read from the conduit blocked when the
+// context is done to be interrupted, by setting the read deadline of
+// the link.  The returned function must be called once the read
+// returns; it reports whether the read was interrupted, in which case
+// the deadline is cleared, so that the conduit may be read again.
+func (c *Conduit) interruptRecv(ctx context.Context) func() bool {
+	set := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(set)
+		c.Link.SetReadDeadline(time.Now()) //nolint:errcheck
+	})
+
+	return func() bool {
+		if stop() {
+			return false
+		}
+		<-set
+		c.Link.SetReadDeadline(time.Time{}) //nolint:errcheck
+
+		return true
+	}
+}
+
+// linkFailed cancels the context of the conduit in response to an
+// error reading from or writing to the link.  Timeouts, such as those
+// resulting from read deadlines set to interrupt a read, do not
+// indicate that the link has died, and are ignored.
+func (c *Conduit) linkFailed(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return
+	}
+
+	c.cancelContext(err)
+}
+
+// Close closes the conduit.  The conduit transitions to the Closed
+// state, unless it is in the Error state, and its context is
+// cancelled.  The peer is not notified; use CloseWithReason to close
+// the conduit gracefully.  Close may be called more than once, and
+// from more than one goroutine.
+func (c *Conduit) Close() error {
+	c.StopKeepalive()
+	c.cancelContext(ErrConduitClosed)
+	c.ctx.Lock()
+	if !c.ctx.closed && c.State != Error {
+		c.State = Closed
+	}
+	c.ctx.closed = true
+	c.ctx.Unlock()
+
+	if c.Link == nil {
+		return nil
+	}
+
+	return c.Link.Close()
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestConduitContextBase(t *testing.T) {
+	obj := &Conduit{}
+
+	result := obj.Context()
+
+	assert.NoError(t, result.Err())
+	assert.Same(t, result, obj.Context())
+}
+
+func TestConduitCloseBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	ctx := obj.Context()
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, Closed, obj.State)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.Same(t, ErrConduitClosed, context.Cause(ctx))
+	_, err = c2.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestConduitCloseNoLink(t *testing.T) {
+	obj := &Conduit{State: Open}
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, Closed, obj.State)
+	assert.Same(t, ErrConduitClosed, context.Cause(obj.Context()))
+}
+
+func TestConduitCloseError(t *testing.T) {
+	obj := &Conduit{State: Error, Error: assert.AnError}
+	obj.cancelContext(assert.AnError)
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, Error, obj.State)
+	assert.Same(t, assert.AnError, context.Cause(obj.Context()))
+}
+
+func TestConduitCloseConcurrent(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		obj.Close() //nolint:errcheck
+	}()
+
+	obj.Close() //nolint:errcheck
+	<-done
+
+	assert.Equal(t, Closed, obj.State)
+	assert.Same(t, ErrConduitClosed, context.Cause(obj.Context()))
+}
+
+func TestConduitLinkFailedBase(t *testing.T) {
+	obj := &Conduit{}
+
+	obj.linkFailed(assert.AnError)
+
+	assert.Same(t, assert.AnError, context.Cause(obj.Context()))
+}
+
+func TestConduitLinkFailedTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	c1.SetReadDeadline(time.Now()) //nolint:errcheck
+	_, timeoutErr := c1.Read(make([]byte, 1))
+	obj := &Conduit{}
+
+	obj.linkFailed(timeoutErr)
+
+	assert.NoError(t, obj.Context().Err())
+}
+
+func TestConduitContextRecvEOF(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	obj := &Conduit{Link: c1}
+	c2.Close()
+
+	_, err := obj.Recv()
+
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, io.EOF, context.Cause(obj.Context()))
+}
+
+func TestConduitContextSendEncodeError(t *testing.T) {
+	obj := &Conduit{Link: &mockConn{}}
+
+	err := obj.Send(&proto.Frame{Header: proto.Header{Major: proto.MaxMajor + 1}})
+
+	assert.ErrorIs(t, err, proto.ErrMaxVersion)
+	assert.NoError(t, obj.Context().Err())
+}
+
+func TestConduitContextNegotiateError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	obj := &Conduit{State: Active, Link: c1}
+	c2.Close()
+
+	err := obj.Negotiate(&proto.Negotiator{})
+
+	assert.Error(t, err)
+	assert.Same(t, err, context.Cause(obj.Context()))
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultDialDelay is the default delay between the starts of
+// successive connection attempts made by DialAll, as recommended by
+// RFC 8305.
+const DefaultDialDelay = 250 * time.Millisecond
+
+// happyOrder orders a list of canonical URIs for dialing, as
+// described by RFC 8305: IPv6 and IPv4 addresses are interleaved,
+// beginning with IPv6, and the relative order within each family is
+// preserved.  URIs without an IP address are placed at the end.
+func happyOrder(uris []*URI) []*URI {
+	var v6, v4, other []*URI
+	for _, u := range uris {
+		ip, _ := parseIP(u.Hostname())
+		switch {
+		case ip == nil:
+			other = append(other, u)
+		case ip.To4() == nil:
+			v6 = append(v6, u)
+		default:
+			v4 = append(v4, u)
+		}
+	}
+
+	result := make([]*URI, 0, len(uris))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			result = append(result, v6[i])
+		}
+		if i < len(v4) {
+			result = append(result, v4[i])
+		}
+	}
+
+	return append(result, other...)
+}
+
+// dialOrder assembles the list of canonical URIs to dial from a list
+// of resolutions ordered by a Selector, applying happyOrder to each
+// run of resolutions of equal SRV priority, so that addresses of
+// lower priority are not attempted before those of higher priority.
+func dialOrder(res []*Resolution) []*URI {
+	result := make([]*URI, 0, len(res))
+	for i := 0; i < len(res); {
+		j := i + 1
+		for j < len(res) && res[j].Priority == res[i].Priority {
+			j++
+		}
+		result = append(result, happyOrder(resolutionURIs(res[i:j]))...)
+		i = j
+	}
+
+	return result
+}
+
+// cloneDialerOptions copies the options that are modified by the
+// mechanisms while dialing, so that concurrent attempts do not
+// interfere with each other.
+func cloneDialerOptions(opts []DialerOption) []DialerOption {
+	result := make([]DialerOption, len(opts))
+	for i, opt := range opts {
+		if la, ok := opt.(*LocalAddrOption); ok {
+			tmp := *la
+			opt = &tmp
+		}
+		result[i] = opt
+	}
+
+	return result
+}
+
+// dialResult is the result of a single connection attempt.
+type dialResult struct {
+	c   *Conduit // The conduit, if successful
+	err error    // The error, if not
+}
+
+// DialAll canonicalizes the URI and dials the resulting canonical
+// URIs, returning the first conduit to be successfully established.
+// As described by RFC 8305 ("Happy Eyeballs"), the attempts are
+// started in turn, alternating between IPv6 and IPv4 addresses, with
+// the specified delay between the start of each attempt; an attempt
+// failing causes the next to be started immediately.  Once a conduit
+// is established, the remaining attempts are cancelled, and any
+// conduits they establish are closed.  If the delay is not positive,
+// DefaultDialDelay is used.  If all attempts fail, the errors are
+// joined.  Host names are looked up with the resolver carried by the
+// context; see WithResolver.  The canonical URIs are ordered by the
+// Selector carried by the context, as set by WithSelector, before the
+// families are interleaved within each SRV priority; if the Selector
+// implements Observer, it is told the outcome of each attempt.
+func (u *URI) DialAll(ctx context.Context, config Config, delay time.Duration, opts ...DialerOption) (*Conduit, error) {
+	if delay <= 0 {
+		delay = DefaultDialDelay
+	}
+
+	// Canonicalize the URI and order the canonical URIs
+	res, err := u.ResolveContext(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	sel := ContextSelector(ctx)
+	obs, _ := sel.(Observer)
+	uris := dialOrder(sel.Select(res))
+	if len(uris) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	// Set up for the attempts
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(uris))
+	pending := 0
+	next := 0
+	start := func() {
+		cu, attemptOpts := uris[next], cloneDialerOptions(opts)
+		next++
+		pending++
+		go func() {
+			begin := timeNow()
+			c, err := cu.Dial(ctx, config, attemptOpts...)
+			if obs != nil && ctx.Err() == nil {
+				obs.Observe(cu, timeNow().Sub(begin), err)
+			}
+			results <- dialResult{c: c, err: err}
+		}()
+	}
+
+	// Run the attempts
+	errs := []error{}
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				go closeLosers(results, pending)
+				return res.c, nil
+			}
+			errs = append(errs, res.err)
+			if next < len(uris) {
+				start()
+				timer.Reset(delay)
+			}
+
+		case <-timer.C:
+			if next < len(uris) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// closeLosers closes the conduits established by attempts that
+// completed after the winner.
+func closeLosers(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if res := <-results; res.err == nil && res.c.Link != nil {
+			res.c.Link.Close() //nolint:errcheck
+		}
+	}
+}
+
+// DialAll parses the URI, then canonicalizes and dials it as
+// described for URI.DialAll.
+func DialAll(ctx context.Context, config Config, uri string, delay time.Duration, opts ...DialerOption) (*Conduit, error) {
+	// Parse the URI
+	u, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dial it
+	return u.DialAll(ctx, config, delay, opts...)
+}
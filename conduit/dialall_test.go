@@ -0,0 +1,268 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHappyOrder(t *testing.T) {
+	uris := []*URI{
+		mustParse("tcp://127.0.0.1:1"),
+		mustParse("tcp://127.0.0.2:1"),
+		mustParse("tcp://127.0.0.3:1"),
+		mustParse("tcp:"),
+		mustParse("tcp://[::1]:1"),
+		mustParse("tcp://[::2]:1"),
+	}
+
+	result := happyOrder(uris)
+
+	assert.Equal(t, []*URI{uris[4], uris[0], uris[5], uris[1], uris[2], uris[3]}, result)
+}
+
+func TestDialOrder(t *testing.T) {
+	res := []*Resolution{
+		{URI: mustParse("tcp://127.0.0.1:1"), Priority: 10},
+		{URI: mustParse("tcp://[::1]:1"), Priority: 10},
+		{URI: mustParse("tcp://127.0.0.2:1"), Priority: 20},
+		{URI: mustParse("tcp://[::2]:1"), Priority: 20},
+	}
+
+	result := dialOrder(res)
+
+	assert.Equal(t, []*URI{res[1].URI, res[0].URI, res[3].URI, res[2].URI}, result)
+}
+
+func TestCloneDialerOptions(t *testing.T) {
+	la := LocalAddr(mustParse("tcp://127.0.0.1:0"))
+	ka := KeepAlive(time.Second)
+
+	result := cloneDialerOptions([]DialerOption{la, ka})
+
+	assert.Len(t, result, 2)
+	assert.NotSame(t, la, result[0])
+	assert.Equal(t, la, result[0])
+	assert.Equal(t, ka, result[1])
+}
+
+// happyMech returns a mechanism for testing DialAll, along with a
+// patcher installing it as the tcp transport.
+func happyMech() (*mockMechanism, patcher.Patcher) {
+	mech := &mockMechanism{}
+	return mech, patcher.NewPatchMaster(
+		patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+			return mech
+		}),
+		patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}, nil
+		}),
+	)
+}
+
+// uriHost matches a URI with the specified host.
+func uriHost(host string) interface{} {
+	return mock.MatchedBy(func(u *URI) bool {
+		return u.Host == host
+	})
+}
+
+func TestURIDialAllFirst(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(c, nil)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), cfg, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	mech.AssertExpectations(t)
+}
+
+func TestURIDialAllFallback(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(nil, assert.AnError)
+	mech.On("Dial", mock.Anything, cfg, uriHost("127.0.0.1:1234"), []DialerOption{}).Return(c, nil)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), cfg, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	mech.AssertExpectations(t)
+}
+
+func TestURIDialAllSelector(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, uriHost("127.0.0.1:1234"), []DialerOption{}).Return(c, nil)
+	sel := &mockSelector{}
+	sel.On("Select", mock.Anything).Return(func(res []*Resolution) []*Resolution {
+		return res[:1]
+	})
+	sel.On("Observe", uriHost("127.0.0.1:1234"), mock.Anything, nil)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(WithSelector(context.Background(), sel), cfg, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	mech.AssertExpectations(t)
+	sel.AssertExpectations(t)
+}
+
+func TestURIDialAllObserveFailure(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(nil, assert.AnError)
+	mech.On("Dial", mock.Anything, cfg, uriHost("127.0.0.1:1234"), []DialerOption{}).Return(c, nil)
+	sel := &mockSelector{}
+	sel.On("Select", mock.Anything).Return(func(res []*Resolution) []*Resolution {
+		return res
+	})
+	sel.On("Observe", uriHost("[::1]:1234"), mock.Anything, assert.AnError)
+	sel.On("Observe", uriHost("127.0.0.1:1234"), mock.Anything, nil)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(WithSelector(context.Background(), sel), cfg, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	mech.AssertExpectations(t)
+	sel.AssertExpectations(t)
+}
+
+func TestURIDialAllStaggered(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	cancelled := make(chan struct{})
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+		close(cancelled)
+	})
+	mech.On("Dial", mock.Anything, cfg, uriHost("127.0.0.1:1234"), []DialerOption{}).Return(c, nil)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), cfg, time.Millisecond)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	<-cancelled
+	mech.AssertExpectations(t)
+}
+
+func TestURIDialAllCloseLosers(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	link := &mockConn{}
+	link.On("Close").Return(nil).Run(func(args mock.Arguments) {
+		close(closed)
+	})
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(&Conduit{Link: link}, nil).Run(func(args mock.Arguments) {
+		<-release
+	})
+	mech.On("Dial", mock.Anything, cfg, uriHost("127.0.0.1:1234"), []DialerOption{}).Return(c, nil)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), cfg, time.Millisecond)
+	close(release)
+	<-closed
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	link.AssertExpectations(t)
+}
+
+func TestURIDialAllAllFail(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(nil, assert.AnError)
+	mech.On("Dial", mock.Anything, cfg, uriHost("127.0.0.1:1234"), []DialerOption{}).Return(nil, context.DeadlineExceeded)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), cfg, 0)
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+}
+
+func TestURIDialAllCanonicalizeError(t *testing.T) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), &mockConfig{}, 0)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestURIDialAllNoAddresses(t *testing.T) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return []net.IP{}, nil
+	}).Install().Restore()
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.DialAll(context.Background(), &mockConfig{}, 0)
+
+	assert.ErrorIs(t, err, ErrNoAddresses)
+	assert.Nil(t, result)
+}
+
+func TestDialAllBase(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, uriHost("[::1]:1234"), []DialerOption{}).Return(c, nil)
+
+	result, err := DialAll(context.Background(), cfg, "tcp://example.com:1234", 0)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+}
+
+func TestDialAllParseError(t *testing.T) {
+	result, err := DialAll(context.Background(), &mockConfig{}, "%", 0)
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
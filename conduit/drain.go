@@ -0,0 +1,164 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"sync"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// drainState tracks the draining of a conduit.  Draining is
+// initiated by one side of the conduit, which asks the peer to stop
+// sending new application traffic; the peer completes any in-flight
+// exchanges, then acknowledges, after which the conduit may be
+// closed without losing traffic.
+type drainState struct {
+	sync.Mutex
+
+	requested bool          // Drain was requested of the peer
+	acked     bool          // Peer's drain request was acknowledged
+	drained   chan struct{} // Closed when the peer acknowledges
+	peer      chan struct{} // Closed when the peer requests a drain
+}
+
+// channels returns the drain channels, creating them if necessary.
+// Must be called with the lock held.
+func (d *drainState) channels() (chan struct{}, chan struct{}) {
+	if d.drained == nil {
+		d.drained = make(chan struct{})
+		d.peer = make(chan struct{})
+	}
+
+	return d.drained, d.peer
+}
+
+// isClosed tests if a channel has been closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendControl sends a control protocol message over the conduit.
+func (c *Conduit) sendControl(t proto.ControlType) error {
+	msg := &proto.ControlMessage{Type: t}
+
+	return c.Send(msg.Frame())
+}
+
+// Drain asks the peer to stop sending new application traffic over
+// the conduit, in preparation for closing it.  The peer may complete
+// in-flight exchanges before acknowledging; the channel returned by
+// Drained is closed when it does.  Calling Drain more than once has
+// no further effect.
+func (c *Conduit) Drain() error {
+	c.drain.Lock()
+	defer c.drain.Unlock()
+
+	if c.drain.requested {
+		return nil
+	}
+	if err := c.sendControl(proto.ControlDrain); err != nil {
+		return err
+	}
+	c.drain.requested = true
+
+	return nil
+}
+
+// Drained returns a channel that is closed when the peer has
+// acknowledged a drain requested by Drain.
+func (c *Conduit) Drained() <-chan struct{} {
+	c.drain.Lock()
+	defer c.drain.Unlock()
+
+	drained, _ := c.drain.channels()
+
+	return drained
+}
+
+// PeerDrain returns a channel that is closed when the peer has asked
+// to drain the conduit.  Once that happens, no new application
+// exchanges should be started over the conduit, and AckDrain should
+// be called when the in-flight exchanges have completed.
+func (c *Conduit) PeerDrain() <-chan struct{} {
+	c.drain.Lock()
+	defer c.drain.Unlock()
+
+	_, peer := c.drain.channels()
+
+	return peer
+}
+
+// PeerDraining tests if the peer has asked to drain the conduit.
+func (c *Conduit) PeerDraining() bool {
+	c.drain.Lock()
+	defer c.drain.Unlock()
+
+	_, peer := c.drain.channels()
+
+	return isClosed(peer)
+}
+
+// AckDrain acknowledges a drain requested by the peer.  It should be
+// called once the in-flight exchanges over the conduit have
+// completed.  Calling AckDrain more than once has no further effect.
+func (c *Conduit) AckDrain() error {
+	c.drain.Lock()
+	defer c.drain.Unlock()
+
+	_, peer := c.drain.channels()
+	if !isClosed(peer) {
+		return ErrNotDraining
+	} else if c.drain.acked {
+		return nil
+	}
+	if err := c.sendControl(proto.ControlDrainAck); err != nil {
+		return err
+	}
+	c.drain.acked = true
+
+	return nil
+}
+
+// HandleDrain processes a drain-related control protocol message
+// received over the conduit.  It returns false if the message is not
+// related to draining.  Unsolicited acknowledgments are ignored.
+func (c *Conduit) HandleDrain(msg *proto.ControlMessage) bool {
+	c.drain.Lock()
+	defer c.drain.Unlock()
+
+	drained, peer := c.drain.channels()
+	switch msg.Type {
+	case proto.ControlDrain:
+		if !isClosed(peer) {
+			close(peer)
+		}
+
+	case proto.ControlDrainAck:
+		if c.drain.requested && !isClosed(drained) {
+			close(drained)
+		}
+
+	default:
+		return false
+	}
+
+	return true
+}
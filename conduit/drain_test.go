@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+var (
+	drainFrame    = []byte{0x00, 0x00, 0x00, 0x01, 0x01}
+	drainAckFrame = []byte{0x00, 0x00, 0x00, 0x01, 0x02}
+)
+
+func TestIsClosed(t *testing.T) {
+	ch := make(chan struct{})
+
+	assert.False(t, isClosed(ch))
+	close(ch)
+	assert.True(t, isClosed(ch))
+}
+
+func TestConduitDrainBase(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", drainFrame).Return(len(drainFrame), nil).Once()
+	obj := &Conduit{Link: link, Integrity: true}
+
+	err1 := obj.Drain()
+	err2 := obj.Drain()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.True(t, obj.drain.requested)
+	link.AssertExpectations(t)
+}
+
+func TestConduitDrainError(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", drainFrame).Return(0, assert.AnError)
+	obj := &Conduit{Link: link, Integrity: true}
+
+	err := obj.Drain()
+
+	assert.Same(t, assert.AnError, err)
+	assert.False(t, obj.drain.requested)
+	link.AssertExpectations(t)
+}
+
+func TestConduitDrained(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", drainFrame).Return(len(drainFrame), nil)
+	obj := &Conduit{Link: link, Integrity: true}
+	ch := obj.Drained()
+	assert.NoError(t, obj.Drain())
+
+	assert.False(t, isClosed(obj.drain.drained))
+	assert.True(t, obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrainAck}))
+	assert.True(t, obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrainAck}))
+
+	<-ch
+}
+
+func TestConduitDrainedUnsolicited(t *testing.T) {
+	obj := &Conduit{}
+	ch := obj.Drained()
+
+	result := obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrainAck})
+
+	assert.True(t, result)
+	assert.False(t, isClosed(obj.drain.drained))
+	assert.Equal(t, (<-chan struct{})(obj.drain.drained), ch)
+}
+
+func TestConduitPeerDrain(t *testing.T) {
+	obj := &Conduit{}
+	ch := obj.PeerDrain()
+	assert.False(t, obj.PeerDraining())
+
+	assert.True(t, obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrain}))
+	assert.True(t, obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrain}))
+
+	<-ch
+	assert.True(t, obj.PeerDraining())
+}
+
+func TestConduitHandleDrainOther(t *testing.T) {
+	obj := &Conduit{}
+
+	result := obj.HandleDrain(&proto.ControlMessage{Type: 0x7f})
+
+	assert.False(t, result)
+	assert.False(t, obj.PeerDraining())
+}
+
+func TestConduitAckDrainBase(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", drainAckFrame).Return(len(drainAckFrame), nil).Once()
+	obj := &Conduit{Link: link, Integrity: true}
+	obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrain})
+
+	err1 := obj.AckDrain()
+	err2 := obj.AckDrain()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.True(t, obj.drain.acked)
+	link.AssertExpectations(t)
+}
+
+func TestConduitAckDrainNotDraining(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{Link: link}
+
+	err := obj.AckDrain()
+
+	assert.ErrorIs(t, err, ErrNotDraining)
+	link.AssertExpectations(t)
+}
+
+func TestConduitAckDrainError(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", drainAckFrame).Return(0, assert.AnError)
+	obj := &Conduit{Link: link, Integrity: true}
+	obj.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrain})
+
+	err := obj.AckDrain()
+
+	assert.Same(t, assert.AnError, err)
+	assert.False(t, obj.drain.acked)
+	link.AssertExpectations(t)
+}
@@ -18,8 +18,74 @@ import "errors"
 
 // Common simple errors that may be returned by the conduit package.
 var (
-	ErrUnknownDiscovery = errors.New("unknown discovery mechanism")
-	ErrUnknownSecurity  = errors.New("unknown security layer mechanism")
-	ErrUnknownTransport = errors.New("unknown transport mechanism")
-	ErrNotCanonical     = errors.New("URI is not canonical")
+	ErrUnknownDiscovery  = errors.New("unknown discovery mechanism")
+	ErrUnknownSecurity   = errors.New("unknown security layer mechanism")
+	ErrUnknownTransport  = errors.New("unknown transport mechanism")
+	ErrNotCanonical      = errors.New("URI is not canonical")
+	ErrCheckFailed       = errors.New("one or more URIs failed validation")
+	ErrMissingConfig     = errors.New("missing or invalid mechanism configuration")
+	ErrNoSourceAddr      = errors.New("no suitable local address for destination")
+	ErrNotDraining       = errors.New("peer has not requested a drain")
+	ErrBadState          = errors.New("conduit is in the wrong state")
+	ErrBadConfig         = errors.New("invalid configuration")
+	ErrConfigFormat      = errors.New("unknown configuration file format")
+	ErrNoAddresses       = errors.New("URI has no canonical addresses")
+	ErrGaveUp            = errors.New("too many failed connection attempts")
+	ErrClosed            = errors.New("persistent conduit is closed")
+	ErrNotStarted        = errors.New("persistent conduit has not been started")
+	ErrNotConnected      = errors.New("persistent conduit is not connected")
+	ErrDrainTimeout      = errors.New("handlers did not return before the drain timeout")
+	ErrUnknownProxy      = errors.New("unsupported proxy scheme")
+	ErrProxyRefused      = errors.New("proxy refused the connection")
+	ErrNoListen          = errors.New("transport does not support listening")
+	ErrNoiseHandshake    = errors.New("noise handshake failed")
+	ErrDecrypt           = errors.New("message failed to decrypt")
+	ErrNoisePeer         = errors.New("noise peer key is not allowed")
+	ErrShortMessage      = errors.New("message is truncated")
+	ErrReplay            = errors.New("datagram has already been received")
+	ErrDatagramTooLong   = errors.New("message is too long for a single datagram")
+	ErrPSKAuth           = errors.New("pre-shared key authentication failed")
+	ErrPSKUnknown        = errors.New("no pre-shared key for identity")
+	ErrPSKShortKey       = errors.New("pre-shared key is too short")
+	ErrUnsupportedOption = errors.New("socket option is not supported on this platform")
+	ErrUnauthorized      = errors.New("peer is not authorized")
+	ErrNotStream         = errors.New("security layer requires a stream transport")
+	ErrLaneClosed        = errors.New("lane is closed")
+	ErrConduitClosed     = errors.New("conduit is closed")
+	ErrSoftLimit         = errors.New("soft usage limit exceeded")
+	ErrHardLimit         = errors.New("hard usage limit exceeded")
+	ErrUnknownOption     = errors.New("unknown URI option")
+	ErrBadOption         = errors.New("invalid URI option")
+	ErrOptionRange       = errors.New("URI option value is out of range")
+	ErrPeerClosed        = errors.New("peer closed the conduit")
+	ErrNoListener        = errors.New("no listener at address")
+	ErrAddressInUse      = errors.New("address is already in use")
+	ErrPeerTimeout       = errors.New("peer stopped sending keepalives")
+	ErrQueueFull         = errors.New("send queue is full")
+	ErrAcceptRate        = errors.New("accept rate limit exceeded")
+	ErrBadSource         = errors.New("invalid source network")
+	ErrNoMembers         = errors.New("bundle has no member conduits")
+	ErrBundleClosed      = errors.New("bundle is closed")
+	ErrMuxClosed         = errors.New("mux is closed")
+	ErrChannelClosed     = errors.New("channel is closed")
+	ErrChannelWindow     = errors.New("frame exceeds the channel window")
+	ErrNoChallenge       = errors.New("no supported ACME challenge offered")
+	ErrBadSPIFFEID       = errors.New("invalid SPIFFE ID")
+	ErrNoSVID            = errors.New("workload API returned no SVID")
+	ErrWorkloadAPI       = errors.New("workload API request failed")
+	ErrShortProto        = errors.New("truncated protobuf message")
+	ErrNoBundle          = errors.New("no trust bundle for trust domain")
+	ErrSTUNUnsupported   = errors.New("transport does not support STUN")
+	ErrBadSTUN           = errors.New("invalid STUN response")
+	ErrSTUNFailed        = errors.New("STUN binding request failed")
+	ErrNoSTUNResponse    = errors.New("no response from STUN server")
+	ErrPunchUnsupported  = errors.New("transport does not support hole punching")
+	ErrPunchConflict     = errors.New("conduit to the address is already open on the socket")
+	ErrBadRelayURI       = errors.New("invalid relay URI")
+	ErrChaosReset        = errors.New("link reset by chaos injection")
+	ErrBadCapture        = errors.New("invalid capture file")
+	ErrBadResolver       = errors.New("invalid secure resolver server")
+	ErrBadDNSResponse    = errors.New("invalid DNS response")
+	ErrUnknownSelector   = errors.New("unknown selector")
+	ErrSourceDenied      = errors.New("source address denied")
 )
@@ -0,0 +1,265 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bufio"
+	"errors"
+	"sync"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// exchange holds the framing state used to exchange PDUs over a
+// conduit.  The reader and writer are created on first use, so that
+// data buffered by the reader is never lost between calls.
+type exchange struct {
+	once   sync.Once     // Controls creation of the reader and writer
+	recv   sync.Mutex    // Serializes reads
+	reader *proto.Reader // Reader for received PDUs
+	writer *proto.Writer // Writer for sent PDUs
+	limits proto.Limiter // Quotas on received PDUs
+}
+
+// framers returns the reader and writer for the conduit, creating
+// them if necessary.  For byte streams, the reader is buffered, so
+// that small PDUs do not each require multiple reads from the link.
+func (c *Conduit) framers() (*proto.Reader, *proto.Writer) {
+	c.xchg.once.Do(func() {
+		if c.Boundaries {
+			c.xchg.reader = proto.NewMessageReader(c.Link)
+		} else {
+			c.xchg.reader = proto.NewReader(bufio.NewReader(c.Link))
+		}
+		c.xchg.writer = proto.NewWriter(c.Link)
+	})
+
+	return c.xchg.reader, c.xchg.writer
+}
+
+// SetQuotas sets the quotas enforced on the PDUs received over the
+// conduit by Recv and PDUs.  A PDU violating the quota for its
+// protocol is discarded, and unless it is itself a reply, an error
+// reply is sent to the peer.
+func (c *Conduit) SetQuotas(quotas proto.Quotas) {
+	c.xchg.limits.SetQuotas(quotas)
+}
+
+// SetMaxPDUSize sets the maximum size of the PDUs exchanged over the
+// conduit, including the header.  A received PDU announcing a larger
+// size is discarded without being buffered, and unless it is itself a
+// reply, an error reply is sent to the peer; Send refuses to send
+// larger PDUs, returning an error wrapping proto.ErrOversize.  A size
+// of 0 removes the limit.
+func (c *Conduit) SetMaxPDUSize(size int) {
+	r, w := c.framers()
+
+	c.xchg.recv.Lock()
+	defer c.xchg.recv.Unlock()
+
+	r.SetMaxSize(size)
+	w.SetMaxSize(size)
+}
+
+// oversize checks if an error returned by the reader reports an
+// oversize PDU, sending the error reply if so.  The link remains
+// usable after an oversize PDU.
+func (c *Conduit) oversize(err error) bool {
+	var oe *proto.OversizeError
+	if !errors.As(err, &oe) {
+		return false
+	}
+	c.countError(err)
+
+	if reply := oe.Reply(); reply != nil {
+		c.Send(reply) //nolint:errcheck
+	}
+
+	return true
+}
+
+// admit checks a received frame against the quotas, returning false
+// if it should be discarded.
+func (c *Conduit) admit(f *proto.Frame) bool {
+	err := c.xchg.limits.Check(f.Protocol(), f.Size())
+	if err == nil {
+		return true
+	}
+	c.countError(err)
+
+	if reply := proto.QuotaReply(f); reply != nil {
+		c.Send(reply) //nolint:errcheck
+	}
+
+	return false
+}
+
+// recvPDU receives a single PDU from the conduit, returning the
+// decoded header and the bytes following it.  PDUs whose extension
+// chains can be parsed are subject to the quotas, and their checksum
+// extensions are verified; the extensions are not removed.
+func (c *Conduit) recvPDU() (*proto.Header, []byte, error) {
+	r, _ := c.framers()
+
+	c.xchg.recv.Lock()
+	defer c.xchg.recv.Unlock()
+
+	for {
+		hdr, body, err := r.ReadPDU()
+		if c.oversize(err) {
+			continue
+		} else if err != nil {
+			c.countError(err)
+			return nil, nil, c.readFailed(err)
+		}
+		c.countIn(proto.HeaderSize + len(body))
+		c.capturePDU(Inbound, hdr, body)
+		if err := c.throttle(proto.HeaderSize + len(body)); err != nil {
+			return nil, nil, err
+		}
+
+		exts, _, payload, err := proto.ParseExtensions(hdr.Protocol, body, nil)
+		f := &proto.Frame{Header: *hdr, Extensions: exts, Payload: payload}
+		if c.received(f) {
+			continue
+		}
+		if err != nil || c.admit(f) {
+			if err := c.account(proto.HeaderSize + len(body)); err != nil {
+				return nil, nil, err
+			}
+			if _, ok := c.verify(f); err != nil || ok {
+				return hdr, body, nil
+			}
+		}
+	}
+}
+
+// encodeError tests if an error returned by proto.Writer resulted
+// from failing to encode the frame, rather than from writing it.
+func encodeError(err error) bool {
+	return errors.Is(err, proto.ErrTooLong) || errors.Is(err, proto.ErrBadLength) ||
+		errors.Is(err, proto.ErrMaxVersion) || errors.Is(err, proto.ErrShortOutput) ||
+		errors.Is(err, proto.ErrOversize)
+}
+
+// checksum adds a checksum extension to a frame to be sent, if the
+// conduit is not integrity-protected.
+func (c *Conduit) checksum(frame *proto.Frame) (*proto.Frame, error) {
+	if c.Integrity {
+		return frame, nil
+	}
+
+	alg := c.Checksum
+	if alg == 0 {
+		alg = proto.ChecksumCRC32C
+	}
+
+	return proto.AddChecksum(frame, alg)
+}
+
+// verify verifies the checksum extension of a received frame,
+// returning the frame with the extension removed.  If the checksum
+// does not match, the frame is discarded, and unless it is itself a
+// reply, an error reply is sent to the peer.
+func (c *Conduit) verify(f *proto.Frame) (*proto.Frame, bool) {
+	result, err := proto.VerifyChecksum(f)
+	if err == nil {
+		return result, true
+	}
+	c.countError(err)
+
+	if reply := proto.ErrorReply(f); reply != nil {
+		c.Send(reply) //nolint:errcheck
+	}
+
+	return nil, false
+}
+
+// Send sends a frame over the conduit.  The frame is encoded and
+// written with a single write, and concurrent calls are serialized,
+// so frames are never interleaved.  Writes that block for too long
+// are reported as slow operations.  If the conduit is not
+// integrity-protected, a checksum extension is added to the frame
+// sent, so that the peer may detect corruption; the passed-in frame
+// is not modified.
+func (c *Conduit) Send(frame *proto.Frame) error {
+	frame, err := c.checksum(frame)
+	if err != nil {
+		return err
+	}
+
+	_, w := c.framers()
+	defer TraceSlow(SlowWrite, c, "")()
+
+	err = w.WriteFrame(frame)
+	if err == nil {
+		c.countOut(frame.Size())
+		c.captureFrame(Outbound, frame)
+	} else {
+		c.countError(err)
+		if !encodeError(err) {
+			c.linkFailed(err)
+		}
+	}
+
+	return err
+}
+
+// Recv receives the next frame from the conduit.  Reads from the
+// link are buffered, and partial reads are handled, so the complete
+// frame is always returned.  An io.EOF error is returned if the
+// conduit is closed between frames; if it is closed in the middle of
+// a frame, io.ErrUnexpectedEOF is returned instead.  Frames violating
+// the quotas set by SetQuotas or exceeding the maximum size set by
+// SetMaxPDUSize are discarded, and received frames are counted
+// against the usage policy set by SetUsagePolicy.  Reading is delayed
+// as necessary to enforce the rate limits set by SetRateLimit.
+// Frames carrying a checksum extension are verified, and the
+// extension is removed; frames whose checksums do not match are
+// discarded, and unless they are themselves replies, an error reply
+// is sent.  Keepalive PDUs are consumed; see StartKeepalive.
+func (c *Conduit) Recv() (*proto.Frame, error) {
+	r, _ := c.framers()
+
+	c.xchg.recv.Lock()
+	defer c.xchg.recv.Unlock()
+
+	for {
+		f, err := r.ReadFrame()
+		if c.oversize(err) {
+			continue
+		} else if err != nil {
+			c.countError(err)
+			return nil, c.readFailed(err)
+		}
+		c.countIn(f.Size())
+		c.captureFrame(Inbound, f)
+		if err := c.throttle(f.Size()); err != nil {
+			return nil, err
+		}
+		if c.received(f) {
+			continue
+		}
+
+		if c.admit(f) {
+			if err := c.account(f.Size()); err != nil {
+				return nil, err
+			}
+			if f, ok := c.verify(f); ok {
+				return f, nil
+			}
+		}
+	}
+}
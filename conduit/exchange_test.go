@@ -0,0 +1,354 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestConduitFramersStream(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{Link: link}
+
+	r1, w1 := obj.framers()
+	r2, w2 := obj.framers()
+
+	assert.NotNil(t, r1)
+	assert.Same(t, r1, r2)
+	assert.Equal(t, proto.NewWriter(link), w1)
+	assert.Same(t, w1, w2)
+}
+
+func TestConduitFramersBoundaries(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{Link: link, Boundaries: true}
+
+	r, w := obj.framers()
+
+	assert.Equal(t, proto.NewMessageReader(link), r)
+	assert.Equal(t, proto.NewWriter(link), w)
+}
+
+func TestConduitSend(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte{0x00, 0x17, 0x00, 0x01, 'x'}).Return(5, nil)
+	obj := &Conduit{Link: link, Integrity: true}
+
+	err := obj.Send(&proto.Frame{
+		Header:  proto.Header{Protocol: 0x17},
+		Payload: []byte("x"),
+	})
+
+	assert.NoError(t, err)
+	link.AssertExpectations(t)
+}
+
+func TestConduitSendChecksum(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte{
+		0x00, 0x81, 0x00, 0x0a,
+		0xa0, 0x17, 0x00, 0x05, 0x01, 0xa9, 0x3c, 0x5f, 0x93,
+		'x',
+	}).Return(14, nil)
+	obj := &Conduit{Link: link}
+	frame := &proto.Frame{
+		Header:  proto.Header{Protocol: 0x17},
+		Payload: []byte("x"),
+	}
+
+	err := obj.Send(frame)
+
+	assert.NoError(t, err)
+	link.AssertExpectations(t)
+	assert.Equal(t, &proto.Frame{
+		Header:  proto.Header{Protocol: 0x17},
+		Payload: []byte("x"),
+	}, frame)
+}
+
+func TestConduitSendChecksumUnknown(t *testing.T) {
+	link := &mockConn{}
+	obj := &Conduit{Link: link, Checksum: 0x7f}
+
+	err := obj.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}})
+
+	assert.ErrorIs(t, err, proto.ErrUnknownChecksum)
+	link.AssertExpectations(t)
+}
+
+func TestConduitRecvChecksum(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{ //nolint:errcheck
+			0x00, 0x81, 0x00, 0x0c,
+			0xa0, 0x17, 0x00, 0x05, 0x01, 0x36, 0x4b, 0x3f, 0xb7,
+			'a', 'b', 'c',
+		})
+		remote.Close()
+	}()
+
+	result, err := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &proto.Frame{
+		Header:     proto.Header{Protocol: 0x17, Length: 12},
+		Extensions: proto.Extensions{},
+		Payload:    []byte("abc"),
+	}, result)
+}
+
+func TestConduitRecvChecksumBad(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Integrity: true}
+	replies := make(chan []byte, 1)
+	go func() {
+		remote.Write([]byte{ //nolint:errcheck
+			0x00, 0x81, 0x00, 0x0c,
+			0xa0, 0x17, 0x00, 0x05, 0x01, 0x36, 0x4b, 0x3f, 0xb7,
+			'a', 'b', 'd',
+		})
+		buf := make([]byte, 16)
+		n, _ := remote.Read(buf)
+		replies <- buf[:n]
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x01, 'e'}) //nolint:errcheck
+		remote.Close()
+	}()
+
+	result, err := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("e"), result.Payload)
+	assert.Equal(t, []byte{0x0c, 0x17, 0x00, 0x00}, <-replies)
+	assert.Equal(t, uint64(1), obj.Stats().Errors)
+}
+
+func TestConduitRecvBase(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c', 0x00, 0x18}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x01, 'd'})                                   //nolint:errcheck
+		remote.Close()
+	}()
+
+	result1, err1 := obj.Recv()
+	result2, err2 := obj.Recv()
+	result3, err3 := obj.Recv()
+
+	assert.NoError(t, err1)
+	assert.Equal(t, &proto.Frame{
+		Header:     proto.Header{Protocol: 0x17, Length: 3},
+		Extensions: proto.Extensions{},
+		Payload:    []byte("abc"),
+	}, result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &proto.Frame{
+		Header:     proto.Header{Protocol: 0x18, Length: 1},
+		Extensions: proto.Extensions{},
+		Payload:    []byte("d"),
+	}, result2)
+	assert.Same(t, io.EOF, err3)
+	assert.Nil(t, result3)
+}
+
+func TestConduitRecvTruncated(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x03, 'a'}) //nolint:errcheck
+		remote.Close()
+	}()
+
+	result, err := obj.Recv()
+
+	assert.Same(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, result)
+}
+
+func TestConduitRecvBoundaries(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Boundaries: true}
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x01, 'a', 'b'}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x18, 0x00, 0x01, 'c'})      //nolint:errcheck
+		remote.Close()
+	}()
+
+	result1, err1 := obj.Recv()
+	result2, err2 := obj.Recv()
+
+	assert.ErrorIs(t, err1, proto.ErrBadLength)
+	assert.Nil(t, result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &proto.Frame{
+		Header:     proto.Header{Protocol: 0x18, Length: 1},
+		Extensions: proto.Extensions{},
+		Payload:    []byte("c"),
+	}, result2)
+}
+
+func TestConduitRecvPDUs(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x01, 'a', 0x00, 0x18, 0x00, 0x01, 'b'}) //nolint:errcheck
+		remote.Close()
+	}()
+
+	frame, err := obj.Recv()
+	hdr, body, pduErr := obj.recvPDU()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), frame.Payload)
+	assert.NoError(t, pduErr)
+	assert.Equal(t, uint8(0x18), hdr.Protocol)
+	assert.Equal(t, []byte("b"), body)
+}
+
+func TestConduitSetQuotas(t *testing.T) {
+	obj := &Conduit{}
+
+	obj.SetQuotas(proto.Quotas{0x17: {MaxSize: 10}})
+
+	assert.Equal(t, proto.Quotas{0x17: {MaxSize: 10}}, obj.xchg.limits.Quotas)
+}
+
+func TestConduitRecvQuota(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Integrity: true}
+	obj.SetQuotas(proto.Quotas{0x17: {MaxSize: 5}})
+	replies := make(chan []byte, 1)
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x02, 'a', 'b'}) //nolint:errcheck
+		buf := make([]byte, 16)
+		n, _ := remote.Read(buf)
+		replies <- buf[:n]
+		remote.Write([]byte{0x08, 0x17, 0x00, 0x02, 'c', 'd'}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x01, 'e'})      //nolint:errcheck
+		remote.Close()
+	}()
+
+	result, err := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("e"), result.Payload)
+	assert.Equal(t, []byte{0x0c, 0x17, 0x00, 0x00}, <-replies)
+}
+
+func TestConduitRecvPDUQuota(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	obj.SetQuotas(proto.Quotas{0x17: {MaxSize: 5}})
+	go func() {
+		remote.Write([]byte{0x08, 0x17, 0x00, 0x02, 'a', 'b', 0x00, 0x17, 0x00, 0x01, 'c'}) //nolint:errcheck
+		remote.Close()
+	}()
+
+	hdr, body, err := obj.recvPDU()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &proto.Header{Protocol: 0x17, Length: 1}, hdr)
+	assert.Equal(t, []byte("c"), body)
+}
+
+func TestConduitRecvPDUChecksumBad(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Integrity: true}
+	go func() {
+		remote.Write([]byte{ //nolint:errcheck
+			0x08, 0x81, 0x00, 0x0c,
+			0xa0, 0x17, 0x00, 0x05, 0x01, 0x36, 0x4b, 0x3f, 0xb7,
+			'a', 'b', 'd',
+			0x00, 0x17, 0x00, 0x01, 'c',
+		})
+		remote.Close()
+	}()
+
+	hdr, body, err := obj.recvPDU()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &proto.Header{Protocol: 0x17, Length: 1}, hdr)
+	assert.Equal(t, []byte("c"), body)
+}
+
+func TestConduitRecvOversize(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Integrity: true}
+	obj.SetMaxPDUSize(6)
+	replies := make(chan []byte, 1)
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c'}) //nolint:errcheck
+		buf := make([]byte, 16)
+		n, _ := remote.Read(buf)
+		replies <- buf[:n]
+		remote.Write([]byte{0x08, 0x17, 0x00, 0x03, 'd', 'e', 'f'}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x02, 'g', 'h'})      //nolint:errcheck
+		remote.Close()
+	}()
+
+	result, err := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("gh"), result.Payload)
+	assert.Equal(t, []byte{0x0c, 0x17, 0x00, 0x00}, <-replies)
+	assert.NoError(t, obj.Context().Err())
+}
+
+func TestConduitRecvPDUOversize(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	obj.SetMaxPDUSize(6)
+	go func() {
+		remote.Write([]byte{0x08, 0x17, 0x00, 0x03, 'a', 'b', 'c', 0x00, 0x17, 0x00, 0x01, 'd'}) //nolint:errcheck
+		remote.Close()
+	}()
+
+	hdr, body, err := obj.recvPDU()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &proto.Header{Protocol: 0x17, Length: 1}, hdr)
+	assert.Equal(t, []byte("d"), body)
+}
+
+func TestConduitSendOversize(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	obj := &Conduit{Link: local}
+	obj.SetMaxPDUSize(6)
+
+	err := obj.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("abc")})
+
+	assert.ErrorIs(t, err, proto.ErrOversize)
+	assert.NoError(t, obj.Context().Err())
+}
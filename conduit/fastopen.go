@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// DefaultFastOpenQueue is the default length of the queue of pending
+// TCP fast open requests on a listener.
+const DefaultFastOpenQueue = 256
+
+// FastOpenOption is an option for Dial and Listen that enables TCP
+// fast open, allowing the first data sent over a connection to be
+// carried in its SYN and saving a round trip when a peer is
+// reconnected.  On listeners, it sets SockOptFastOpen to the queue
+// length; on dialers, it sets SockOptFastOpenConnect, so that the
+// connection is completed by the first write.  A dialed connection
+// to an unreachable peer therefore reports its error on the first
+// write rather than from Dial.  The option is ignored on platforms
+// not supporting fast open and by transports other than TCP.
+type FastOpenOption struct {
+	Queue int // Length of the queue of pending requests on listeners
+}
+
+// FastOpen returns an option enabling TCP fast open with the default
+// queue length.
+func FastOpen() FastOpenOption {
+	return FastOpenOption{Queue: DefaultFastOpenQueue}
+}
+
+// fastOpen returns a Control function setting a fast open socket
+// option on TCP sockets, ignoring it if the platform does not support
+// it.
+func fastOpen(opt SockOpt, value int) controlFunc {
+	return func(network, address string, c syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		if err := SetSockOpt(c, opt, value); err != nil && !errors.Is(err, ErrUnsupportedOption) {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// DialApply applies the option to a net.Dialer.
+func (o FastOpenOption) DialApply(d *net.Dialer) {
+	d.Control = chainControl(d.Control, fastOpen(SockOptFastOpenConnect, 1))
+}
+
+// ListenApply applies the option to a net.ListenConfig.
+func (o FastOpenOption) ListenApply(lc *net.ListenConfig) {
+	queue := o.Queue
+	if queue <= 0 {
+		queue = DefaultFastOpenQueue
+	}
+	lc.Control = chainControl(lc.Control, fastOpen(SockOptFastOpen, queue))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFastOpen(t *testing.T) {
+	assert.Equal(t, FastOpenOption{Queue: DefaultFastOpenQueue}, FastOpen())
+}
+
+func TestFastOpenOptionImplementsDialerOption(t *testing.T) {
+	assert.Implements(t, (*DialerOption)(nil), FastOpen())
+}
+
+func TestFastOpenOptionImplementsListenerOption(t *testing.T) {
+	assert.Implements(t, (*ListenerOption)(nil), FastOpen())
+}
+
+func TestFastOpenOptionDialApply(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{
+		SockOptFastOpenConnect: {6, 30},
+	}).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		assert.Equal(t, 6, level)
+		assert.Equal(t, 30, opt)
+		assert.Equal(t, 1, value)
+		return nil
+	}).Install().Restore()
+	dialer := &net.Dialer{}
+
+	FastOpen().DialApply(dialer)
+
+	assert.NoError(t, dialer.Control("tcp4", "addr", c))
+	c.AssertExpectations(t)
+}
+
+func TestFastOpenOptionListenApply(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{
+		SockOptFastOpen: {6, 23},
+	}).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		assert.Equal(t, 6, level)
+		assert.Equal(t, 23, opt)
+		assert.Equal(t, 5, value)
+		return nil
+	}).Install().Restore()
+	lc := &net.ListenConfig{}
+
+	FastOpenOption{Queue: 5}.ListenApply(lc)
+
+	assert.NoError(t, lc.Control("tcp6", "addr", c))
+	c.AssertExpectations(t)
+}
+
+func TestFastOpenOptionListenApplyDefaultQueue(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{
+		SockOptFastOpen: {6, 23},
+	}).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		assert.Equal(t, DefaultFastOpenQueue, value)
+		return nil
+	}).Install().Restore()
+	lc := &net.ListenConfig{}
+
+	FastOpenOption{}.ListenApply(lc)
+
+	assert.NoError(t, lc.Control("tcp", "addr", c))
+}
+
+func TestFastOpenOptionUnsupported(t *testing.T) {
+	c := &mockRawConn{}
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{}).Install().Restore()
+	dialer := &net.Dialer{}
+
+	FastOpen().DialApply(dialer)
+
+	assert.NoError(t, dialer.Control("tcp4", "addr", c))
+	c.AssertExpectations(t)
+}
+
+func TestFastOpenOptionNotTCP(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	FastOpen().ListenApply(lc)
+
+	assert.NoError(t, lc.Control("udp4", "addr", &mockRawConn{}))
+}
+
+func TestFastOpenOptionError(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{
+		SockOptFastOpen: {6, 23},
+	}).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		return syscall.EPERM
+	}).Install().Restore()
+	lc := &net.ListenConfig{}
+
+	FastOpen().ListenApply(lc)
+
+	assert.ErrorIs(t, lc.Control("tcp", "addr", c), syscall.EPERM)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Keys for the Fingerprints map of a conduit.
+const (
+	FingerprintTCP = "tcp" // TCP characteristics
+	FingerprintTLS = "tls" // JA4-style TLS client hello fingerprint
+)
+
+// Extension numbers omitted from the sorted extension hash of the TLS
+// fingerprint, since they are reflected elsewhere.
+const (
+	tlsExtServerName = 0x0000
+	tlsExtALPN       = 0x0010
+)
+
+// isGREASE tests if a TLS cipher suite, extension, or other value is
+// a GREASE value, of the form 0x?a?a.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// tlsHash computes the truncated SHA-256 hash used by the TLS
+// fingerprint.
+func tlsHash(s string) string {
+	if s == "" {
+		return strings.Repeat("0", 12)
+	}
+
+	sum := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// tlsVersion converts a TLS version number into the two-character
+// form used by the TLS fingerprint.
+func tlsVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	}
+
+	return "00"
+}
+
+// hexList formats a list of values as sorted or unsorted 4-digit hex
+// numbers separated by commas, omitting GREASE values and any values
+// in the skip set.
+func hexList(vals []uint16, sorted bool, skip ...uint16) (string, int) {
+	items := []string{}
+outer:
+	for _, v := range vals {
+		if isGREASE(v) {
+			continue
+		}
+		for _, s := range skip {
+			if v == s {
+				continue outer
+			}
+		}
+		items = append(items, fmt.Sprintf("%04x", v))
+	}
+	if sorted {
+		sort.Strings(items)
+	}
+
+	return strings.Join(items, ","), len(items)
+}
+
+// TLSFingerprint computes a JA4-style fingerprint of a TLS client
+// hello.  The proto argument is 't' for TLS over TCP or 'q' for
+// QUIC.  The fingerprint has three sections separated by
+// underscores: a summary of the protocol, the highest supported TLS
+// version, the presence of SNI, the cipher suite and extension
+// counts, and the first ALPN protocol; a truncated hash of the
+// sorted cipher suites; and a truncated hash of the sorted
+// extensions and the signature algorithms.
+func TLSFingerprint(proto byte, hello *tls.ClientHelloInfo) string {
+	// Determine the highest version
+	vers := uint16(0)
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > vers {
+			vers = v
+		}
+	}
+
+	// Determine SNI and ALPN
+	sni := byte('i')
+	if hello.ServerName != "" {
+		sni = 'd'
+	}
+	alpn := "00"
+	if len(hello.SupportedProtos) > 0 && hello.SupportedProtos[0] != "" {
+		p := hello.SupportedProtos[0]
+		alpn = string([]byte{p[0], p[len(p)-1]})
+	}
+
+	// Assemble the lists
+	ciphers, nCiphers := hexList(hello.CipherSuites, true)
+	_, nExts := hexList(hello.Extensions, false)
+	exts, _ := hexList(hello.Extensions, true, tlsExtServerName, tlsExtALPN)
+	sigs := make([]uint16, len(hello.SignatureSchemes))
+	for i, s := range hello.SignatureSchemes {
+		sigs[i] = uint16(s)
+	}
+	sigList, _ := hexList(sigs, false)
+	if sigList != "" {
+		exts += "_" + sigList
+	}
+
+	return fmt.Sprintf("%c%s%c%02d%02d%s_%s_%s", proto, tlsVersion(vers), sni, min(nCiphers, 99), min(nExts, 99), alpn, tlsHash(ciphers), tlsHash(exts))
+}
+
+// TCPFingerprint computes a fingerprint describing the
+// characteristics of an accepted TCP connection: the address family
+// and the maximum segment size negotiated with the peer.  An empty
+// string is returned if the characteristics cannot be determined.
+func TCPFingerprint(c net.Conn) string {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return ""
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return ""
+	}
+
+	// Get the maximum segment size
+	mss, err := GetSockOpt(raw, SockOptMaxSeg)
+	if err != nil {
+		return ""
+	}
+
+	// Determine the address family
+	family := "4"
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		family = "6"
+	}
+
+	return fmt.Sprintf("%s:mss=%d", family, mss)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsGREASE(t *testing.T) {
+	assert.True(t, isGREASE(0x0a0a))
+	assert.True(t, isGREASE(0xfafa))
+	assert.False(t, isGREASE(0x1a0a))
+	assert.False(t, isGREASE(0x1301))
+}
+
+func TestTLSHash(t *testing.T) {
+	assert.Equal(t, "000000000000", tlsHash(""))
+	assert.Equal(t, "2cf24dba5fb0", tlsHash("hello"))
+}
+
+func TestTLSVersion(t *testing.T) {
+	assert.Equal(t, "13", tlsVersion(tls.VersionTLS13))
+	assert.Equal(t, "12", tlsVersion(tls.VersionTLS12))
+	assert.Equal(t, "11", tlsVersion(tls.VersionTLS11))
+	assert.Equal(t, "10", tlsVersion(tls.VersionTLS10))
+	assert.Equal(t, "00", tlsVersion(0x0200))
+}
+
+func TestHexListBase(t *testing.T) {
+	result, count := hexList([]uint16{0x1302, 0x0a0a, 0x1301, 0x0010}, false, 0x0010)
+
+	assert.Equal(t, "1302,1301", result)
+	assert.Equal(t, 2, count)
+}
+
+func TestHexListSorted(t *testing.T) {
+	result, count := hexList([]uint16{0x1302, 0x0a0a, 0x1301}, true)
+
+	assert.Equal(t, "1301,1302", result)
+	assert.Equal(t, 2, count)
+}
+
+func TestTLSFingerprintBase(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{0x2a2a, 0x1302, 0x1301},
+		ServerName:        "example.com",
+		SupportedProtos:   []string{"humboldt"},
+		SupportedVersions: []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+		SignatureSchemes:  []tls.SignatureScheme{0x0403, 0x0804},
+		Extensions:        []uint16{0x0000, 0x0010, 0x002b, 0x000d},
+	}
+
+	result := TLSFingerprint('q', hello)
+
+	assert.Equal(t, "q13d0204ht_"+tlsHash("1301,1302")+"_"+tlsHash("000d,002b_0403,0804"), result)
+}
+
+func TestTLSFingerprintEmpty(t *testing.T) {
+	result := TLSFingerprint('t', &tls.ClientHelloInfo{})
+
+	assert.Equal(t, "t00i000000_000000000000_000000000000", result)
+}
+
+func TestTCPFingerprintNotSyscallConn(t *testing.T) {
+	result := TCPFingerprint(&mockConn{})
+
+	assert.Equal(t, "", result)
+}
+
+func testTCPPair(t *testing.T, network, addr string) (net.Conn, net.Conn) {
+	l, err := net.Listen(network, addr)
+	require.NoError(t, err)
+	defer l.Close()
+	c1, err := net.Dial(network, l.Addr().String())
+	require.NoError(t, err)
+	c2, err := l.Accept()
+	require.NoError(t, err)
+
+	return c1, c2
+}
+
+func TestTCPFingerprintBase(t *testing.T) {
+	c1, c2 := testTCPPair(t, "tcp4", "127.0.0.1:0")
+	defer c1.Close()
+	defer c2.Close()
+	defer patcher.SetVar(&getsockoptInt, func(fd, level, opt int) (int, error) {
+		assert.Equal(t, sockOpts[SockOptMaxSeg].level, level)
+		assert.Equal(t, sockOpts[SockOptMaxSeg].name, opt)
+		return 1448, nil
+	}).Install().Restore()
+
+	result := TCPFingerprint(c2)
+
+	assert.Equal(t, "4:mss=1448", result)
+}
+
+func TestTCPFingerprintIPv6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback unavailable")
+	}
+	l.Close()
+	c1, c2 := testTCPPair(t, "tcp6", "[::1]:0")
+	defer c1.Close()
+	defer c2.Close()
+	defer patcher.SetVar(&getsockoptInt, func(fd, level, opt int) (int, error) {
+		return 1428, nil
+	}).Install().Restore()
+
+	result := TCPFingerprint(c2)
+
+	assert.Equal(t, "6:mss=1428", result)
+}
+
+func TestTCPFingerprintError(t *testing.T) {
+	c1, c2 := testTCPPair(t, "tcp4", "127.0.0.1:0")
+	defer c1.Close()
+	defer c2.Close()
+	defer patcher.SetVar(&getsockoptInt, func(fd, level, opt int) (int, error) {
+		return 0, assert.AnError
+	}).Install().Restore()
+
+	result := TCPFingerprint(c2)
+
+	assert.Equal(t, "", result)
+}
@@ -16,150 +16,17 @@ package conduit_test
 
 import (
 	"context"
-	"io"
-	"sync"
+	"math/rand/v2"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/conduit/conduittest"
 )
 
-type Server struct {
-	sync.Mutex
-	Wg        *sync.WaitGroup
-	Listener  conduit.Listener
-	URI       string
-	Data      map[string][][]byte
-	Errors    map[string]error
-	AcceptErr error
-}
-
-func NewServer(cfg conduit.Config, uri string) (*Server, error) {
-	l, err := conduit.Listen(context.Background(), cfg, uri)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Server{
-		Wg:       &sync.WaitGroup{},
-		Listener: l,
-		URI:      l.Addr().String(),
-		Data:     map[string][][]byte{},
-		Errors:   map[string]error{},
-	}, nil
-}
-
-func (s *Server) Echo(c *conduit.Conduit) {
-	defer s.Wg.Done()
-	uri := c.RemoteURI.String()
-	buf := make([]byte, 1024)
-	for {
-		inLen, err := c.Link.Read(buf)
-		if err != nil {
-			if err != io.EOF {
-				s.Lock()
-				s.Errors[uri] = err
-				s.Unlock()
-			}
-			break
-		}
-		tmp := make([]byte, inLen)
-		copy(tmp, buf)
-		s.Lock()
-		s.Data[uri] = append(s.Data[uri], tmp)
-		s.Unlock()
-		_, err = c.Link.Write(buf[:inLen])
-		if err != nil {
-			s.Lock()
-			s.Errors[uri] = err
-			s.Unlock()
-			break
-		}
-	}
-}
-
-func (s *Server) Accept() {
-	defer s.Wg.Done()
-	for {
-		c, err := s.Listener.Accept()
-		if err != nil {
-			s.Lock()
-			s.AcceptErr = err
-			s.Unlock()
-			break
-		}
-		s.Wg.Add(1)
-		go s.Echo(c)
-	}
-}
-
-func (s *Server) Start() {
-	s.Wg.Add(1)
-	go s.Accept()
-}
-
-func (s *Server) Close() {
-	s.Lock()
-	if s.Listener != nil {
-		s.Listener.Close()
-		s.Listener = nil
-	}
-	s.Unlock()
-	s.Wg.Wait()
-}
-
-type Client struct {
-	Wg      *sync.WaitGroup
-	Conduit *conduit.Conduit
-	URI     string
-	Out     [][]byte
-	In      [][]byte
-	Error   error
-}
-
-func NewClient(wg *sync.WaitGroup, cfg conduit.Config, uri string, data [][]byte) (*Client, error) {
-	c, err := conduit.Dial(context.Background(), cfg, uri)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Client{
-		Wg:      wg,
-		Conduit: c,
-		URI:     c.LocalURI.String(),
-		Out:     data,
-	}, nil
-}
-
-func (c *Client) Send() {
-	defer c.Wg.Done()
-	defer c.Conduit.Link.Close()
-	inBuf := make([]byte, 1024)
-	for _, buf := range c.Out {
-		_, err := c.Conduit.Link.Write(buf)
-		if err != nil {
-			c.Error = err
-			return
-		}
-
-		inLen, err := c.Conduit.Link.Read(inBuf)
-		if err != nil {
-			c.Error = err
-			return
-		}
-		tmp := make([]byte, inLen)
-		copy(tmp, inBuf)
-		c.In = append(c.In, tmp)
-	}
-}
-
-func (c *Client) Start() {
-	c.Wg.Add(1)
-	go c.Send()
-}
-
 type Config struct {
 	Transport map[string]interface{}
 	Security  map[string]interface{}
@@ -181,42 +48,91 @@ type Scenario struct {
 }
 
 func (s *Scenario) Execute(t *testing.T) {
-	// Construct and start the server
-	server, err := NewServer(s.Cfg, s.URI)
+	payloads := [][][]byte{s.Cli1, s.Cli2}
+	soak := &conduittest.Soak{
+		URI:     s.URI,
+		Clients: len(payloads),
+		Schedule: func(client int, rng *rand.Rand) [][]byte {
+			return payloads[client]
+		},
+	}
+	if s.Cfg != nil {
+		soak.Config = s.Cfg
+	}
+
+	// Run the clients against the server
+	result := soak.Execute(t)
+
+	// Check the clients
+	for i, cli := range result.Clients {
+		assert.Equal(t, result.Servers[0], cli.RemoteURI)
+		assert.Equal(t, payloads[i], cli.Echoed)
+	}
+}
+
+// PunchScenario describes a conduit punched between two listeners.
+type PunchScenario struct {
+	URI string  // Listen URI of both listeners
+	Cfg *Config // Configuration
+}
+
+func (s *PunchScenario) Execute(t *testing.T) {
+	var cfg conduit.Config
+	if s.Cfg != nil {
+		cfg = s.Cfg
+	}
+	ctx := context.Background()
+	l1, err := conduit.Listen(ctx, cfg, s.URI)
+	require.NoError(t, err)
+	defer l1.Close()
+	l2, err := conduit.Listen(ctx, cfg, s.URI)
 	require.NoError(t, err)
-	server.Start()
-	defer server.Close()
+	defer l2.Close()
+	accepted := make(chan *conduit.Conduit, 1)
+	go func() {
+		if c, err := l2.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
 
-	// Construct the clients
-	wg := &sync.WaitGroup{}
-	cli1, err := NewClient(wg, s.Cfg, server.URI, s.Cli1)
+	// Probe from one listener and punch from the other
+	require.NoError(t, conduit.Probe(ctx, l2, l1.Addr()))
+	c1, err := conduit.Punch(ctx, cfg, l1, l2.Addr())
 	require.NoError(t, err)
-	cli2, err := NewClient(wg, s.Cfg, server.URI, s.Cli2)
+	defer c1.Link.Close()
+	_, err = c1.Link.Write([]byte("punch"))
 	require.NoError(t, err)
+	var c2 *conduit.Conduit
+	select {
+	case c2 = <-accepted:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "punched conduit not accepted")
+	}
+	defer c2.Link.Close()
+	buf := make([]byte, 16)
+	n, err := c2.Link.Read(buf)
 
-	// Start them
-	cli1.Start()
-	cli2.Start()
+	// Check the conduits
+	require.NoError(t, err)
+	assert.Equal(t, "punch", string(buf[:n]))
+	assert.Equal(t, conduit.Active, c1.State)
+	assert.Equal(t, l2.Addr().String(), c1.RemoteURI.String())
+	assert.Equal(t, l1.Addr().String(), c2.RemoteURI.String())
+}
 
-	// Wait for clients to finish
-	wg.Wait()
+func TestSoak(t *testing.T) {
+	for _, uri := range []string{"tcp://127.0.0.1:0", "udp://127.0.0.1:0"} {
+		t.Run(uri, func(t *testing.T) {
+			soak := &conduittest.Soak{
+				URI:     uri,
+				Servers: 2,
+				Clients: 6,
+				Seed:    1,
+			}
 
-	// Shut down the server
-	server.Close()
+			result := soak.Execute(t)
 
-	// Check the clients
-	assert.Equal(t, server.URI, cli1.Conduit.RemoteURI.String())
-	assert.Equal(t, s.Cli1, cli1.In)
-	assert.NoError(t, cli1.Error)
-	assert.Equal(t, server.URI, cli2.Conduit.RemoteURI.String())
-	assert.Equal(t, s.Cli2, cli2.In)
-	assert.NoError(t, cli2.Error)
-
-	// Check the server
-	cli1URI := cli1.Conduit.LocalURI.String()
-	require.Contains(t, server.Data, cli1URI)
-	assert.Equal(t, s.Cli1, server.Data[cli1URI])
-	cli2URI := cli2.Conduit.LocalURI.String()
-	require.Contains(t, server.Data, cli2URI)
-	assert.Equal(t, s.Cli2, server.Data[cli2URI])
+			assert.Len(t, result.Clients, 6)
+		})
+	}
 }
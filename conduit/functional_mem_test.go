@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/conduit/conduittest"
+)
+
+func TestMem(t *testing.T) {
+	t.Parallel()
+	s := &Scenario{
+		URI:  "mem:",
+		Cli1: [][]byte{[]byte("test"), []byte("one\n"), []byte("two\r\n")},
+		Cli2: [][]byte{[]byte("test2"), []byte("three\r"), []byte("four")},
+	}
+
+	s.Execute(t)
+}
+
+func TestMemNoise(t *testing.T) {
+	t.Parallel()
+	s := &Scenario{
+		URI:  "mem+noise:",
+		Cli1: [][]byte{[]byte("test"), []byte("one\n"), []byte("two\r\n")},
+		Cli2: [][]byte{[]byte("test2"), []byte("three\r"), []byte("four")},
+		Cfg:  noiseConfig(t),
+	}
+
+	s.Execute(t)
+}
+
+func TestMemSoak(t *testing.T) {
+	t.Parallel()
+	soak := &conduittest.Soak{
+		URI:     "mem:",
+		Servers: 2,
+		Clients: 6,
+		Seed:    1,
+	}
+
+	result := soak.Execute(t)
+
+	assert.Len(t, result.Clients, 6)
+}
+
+func TestMemNamed(t *testing.T) {
+	t.Parallel()
+	l, err := conduit.Listen(context.Background(), nil, "mem:functional-named")
+	require.NoError(t, err)
+	defer l.Close()
+
+	dup, err := conduit.Listen(context.Background(), nil, "mem:functional-named")
+	assert.ErrorIs(t, err, conduit.ErrAddressInUse)
+	assert.Nil(t, dup)
+
+	l.Close()
+	c, err := conduit.Dial(context.Background(), nil, "mem:functional-named")
+	assert.ErrorIs(t, err, conduit.ErrNoListener)
+	assert.Nil(t, c)
+}
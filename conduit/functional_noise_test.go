@@ -0,0 +1,74 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/conduit/conduittest"
+)
+
+// noiseConfig returns a configuration for the Noise security layer
+// with a fresh static key.
+func noiseConfig(t *testing.T) *Config {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return &Config{
+		Security: map[string]interface{}{
+			"noise": &conduit.NoiseConfig{Static: key},
+		},
+	}
+}
+
+func TestNoise(t *testing.T) {
+	s := &Scenario{
+		URI:  "tcp+noise://127.0.0.1:0",
+		Cli1: [][]byte{[]byte("test"), []byte("one\n"), []byte("two\r\n")},
+		Cli2: [][]byte{[]byte("test2"), []byte("three\r"), []byte("four")},
+		Cfg:  noiseConfig(t),
+	}
+
+	s.Execute(t)
+}
+
+func TestNoisePunch(t *testing.T) {
+	s := &PunchScenario{
+		URI: "udp+noise://127.0.0.1:0",
+		Cfg: noiseConfig(t),
+	}
+
+	s.Execute(t)
+}
+
+func TestNoiseSoak(t *testing.T) {
+	soak := &conduittest.Soak{
+		URI:     "tcp+noise://127.0.0.1:0",
+		Config:  noiseConfig(t),
+		Servers: 2,
+		Clients: 6,
+		Seed:    1,
+	}
+
+	result := soak.Execute(t)
+
+	assert.Len(t, result.Clients, 6)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/conduit/conduittest"
+)
+
+// testTLSConfig generates a self-signed certificate for 127.0.0.1 and
+// returns a TLS configuration usable by both client and server.
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "humboldt-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+			Leaf:        cert,
+		}},
+		RootCAs:    pool,
+		ClientCAs:  pool,
+		ServerName: "127.0.0.1",
+	}
+}
+
+func TestQUIC(t *testing.T) {
+	s := &Scenario{
+		URI:  "quic://127.0.0.1:0",
+		Cli1: [][]byte{[]byte("test"), []byte("one\n"), []byte("two\r\n")},
+		Cli2: [][]byte{[]byte("test2"), []byte("three\r"), []byte("four")},
+		Cfg: &Config{
+			Transport: map[string]interface{}{
+				"quic": &conduit.QUICConfig{
+					TLS: testTLSConfig(t),
+				},
+			},
+		},
+	}
+
+	s.Execute(t)
+}
+
+func TestQUICPunch(t *testing.T) {
+	s := &PunchScenario{
+		URI: "quic://127.0.0.1:0",
+		Cfg: &Config{
+			Transport: map[string]interface{}{
+				"quic": &conduit.QUICConfig{
+					TLS: testTLSConfig(t),
+				},
+			},
+		},
+	}
+
+	s.Execute(t)
+}
+
+func TestQUICBinding(t *testing.T) {
+	cfg := &Config{
+		Transport: map[string]interface{}{
+			"quic": &conduit.QUICConfig{
+				TLS: testTLSConfig(t),
+			},
+		},
+	}
+	l, err := conduit.Listen(context.Background(), cfg, "quic://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan *conduit.Conduit, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	c, err := conduit.Dial(context.Background(), cfg, l.Addr().String())
+	require.NoError(t, err)
+	defer c.Link.Close()
+	_, err = c.Link.Write([]byte("x"))
+	require.NoError(t, err)
+	peer := <-accepted
+	require.NotNil(t, peer)
+	defer peer.Link.Close()
+
+	assert.Len(t, c.Binding, conduit.BindingSize)
+	assert.Equal(t, c.Binding, peer.Binding)
+}
+
+func TestQUICPrincipal(t *testing.T) {
+	conf := testTLSConfig(t)
+	fp := conduit.SPKIFingerprint(conf.Certificates[0].Leaf)
+	cfg := &Config{
+		Transport: map[string]interface{}{
+			"quic": &conduit.QUICConfig{
+				TLS:        conf,
+				Authorizer: &conduit.SPKIAuthorizer{Allow: []string{fp}},
+			},
+		},
+	}
+	l, err := conduit.Listen(context.Background(), cfg, "quic://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	c, err := conduit.Dial(context.Background(), cfg, l.Addr().String())
+	require.NoError(t, err)
+	defer c.Link.Close()
+
+	assert.Equal(t, fp, c.Principal)
+}
+
+func TestQUICRejected(t *testing.T) {
+	cfg := &Config{
+		Transport: map[string]interface{}{
+			"quic": &conduit.QUICConfig{
+				TLS:        testTLSConfig(t),
+				Authorizer: &conduit.SPKIAuthorizer{Allow: []string{"sha256:00"}},
+			},
+		},
+	}
+	l, err := conduit.Listen(context.Background(), cfg, "quic://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	result, err := conduit.Dial(context.Background(), cfg, l.Addr().String())
+
+	assert.ErrorIs(t, err, conduit.ErrUnauthorized)
+	assert.Nil(t, result)
+}
+
+func TestQUICSoak(t *testing.T) {
+	soak := &conduittest.Soak{
+		URI: "quic://127.0.0.1:0",
+		Config: &Config{
+			Transport: map[string]interface{}{
+				"quic": &conduit.QUICConfig{
+					TLS: testTLSConfig(t),
+				},
+			},
+		},
+		Servers: 2,
+		Clients: 6,
+		Seed:    1,
+	}
+
+	result := soak.Execute(t)
+
+	assert.Len(t, result.Clients, 6)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+)
+
+// healthCheck is a fallback handler answering HTTP health checks.
+func healthCheck(ctx context.Context, c *conduit.Conduit) {
+	req, err := http.ReadRequest(bufio.NewReader(c.Link))
+	if err != nil {
+		return
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+	}
+	resp.Write(c.Link) //nolint:errcheck
+}
+
+func TestSniff(t *testing.T) {
+	for _, uri := range []string{"tcp://127.0.0.1:0", "tcp+noise://127.0.0.1:0"} {
+		t.Run(uri, func(t *testing.T) {
+			cfg := noiseConfig(t)
+			l, err := conduit.Listen(context.Background(), cfg, uri, conduit.Sniff(conduit.HandlerFunc(healthCheck)))
+			require.NoError(t, err)
+			defer l.Close()
+			accepted := make(chan *conduit.Conduit, 1)
+			go func() {
+				c, _ := l.Accept()
+				accepted <- c
+			}()
+
+			// The health check is answered by the fallback handler
+			resp, err := http.Get("http://" + l.Addr().Host + "/healthz")
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			// Humboldt traffic is returned by Accept
+			c, err := conduit.Dial(context.Background(), cfg, l.Addr().String())
+			require.NoError(t, err)
+			defer c.Link.Close()
+			_, err = c.Link.Write([]byte("humboldt traffic"))
+			require.NoError(t, err)
+			peer := <-accepted
+			require.NotNil(t, peer)
+			defer peer.Link.Close()
+			buf := make([]byte, 16)
+			_, err = io.ReadFull(peer.Link, buf)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("humboldt traffic"), buf)
+		})
+	}
+}
@@ -15,7 +15,15 @@
 package conduit_test
 
 import (
+	"context"
+	"io"
+	"net"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
 )
 
 func TestTCP(t *testing.T) {
@@ -27,3 +35,85 @@ func TestTCP(t *testing.T) {
 
 	s.Execute(t)
 }
+
+func TestTCPQueryOptions(t *testing.T) {
+	l, err := conduit.Listen(context.Background(), nil, "tcp://127.0.0.1:0?keepalive=30s&nodelay=false")
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan *conduit.Conduit, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	c, err := conduit.Dial(context.Background(), nil, l.Addr().String()+"?nodelay=false")
+	require.NoError(t, err)
+	defer c.Link.Close()
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+
+	_, err = c.Link.Write([]byte("test"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(srv.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test"), buf)
+}
+
+func TestTCPFastOpen(t *testing.T) {
+	l, err := conduit.Listen(context.Background(), nil, "tcp://127.0.0.1:0", conduit.FastOpen())
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan *conduit.Conduit, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	// With fast open, the connection completes with the first write
+	c, err := conduit.Dial(context.Background(), nil, l.Addr().String()+"?fastopen=1")
+	require.NoError(t, err)
+	defer c.Link.Close()
+	_, err = c.Link.Write([]byte("test"))
+	require.NoError(t, err)
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(srv.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test"), buf)
+}
+
+func TestTCPQueryOptionsUnknown(t *testing.T) {
+	l, err := conduit.Listen(context.Background(), nil, "tcp://127.0.0.1:0?bogus=1")
+
+	assert.ErrorIs(t, err, conduit.ErrUnknownOption)
+	assert.Nil(t, l)
+}
+
+func TestTCPListenAll(t *testing.T) {
+	l, err := conduit.ListenAll(context.Background(), nil, "tcp://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan *conduit.Conduit, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	c, err := conduit.Dial(context.Background(), nil, l.Addr().String())
+	require.NoError(t, err)
+	defer c.Link.Close()
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+
+	assert.Len(t, l.Addrs(), 1)
+	assert.Equal(t, c.LocalURI.String(), srv.RemoteURI.String())
+	require.NoError(t, l.Close())
+	_, err = l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/conduit/conduittest"
+)
+
+// tlsConfig returns a configuration for the TLS security layer.
+func tlsConfig(conf *tls.Config, auth conduit.Authorizer) *Config {
+	return &Config{
+		Security: map[string]interface{}{
+			"tls": &conduit.TLSConfig{TLS: conf, Authorizer: auth},
+		},
+	}
+}
+
+// tlsPair dials a listener, returning both ends of the conduit.
+func tlsPair(t *testing.T, srvCfg, cliCfg *Config) (*conduit.Conduit, *conduit.Conduit) {
+	l, err := conduit.Listen(context.Background(), srvCfg, "tcp+tls://127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	accepted := make(chan *conduit.Conduit, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	c, err := conduit.Dial(context.Background(), cliCfg, l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Link.Close() })
+	peer := <-accepted
+	require.NotNil(t, peer)
+	t.Cleanup(func() { peer.Link.Close() })
+
+	return c, peer
+}
+
+func TestTLS(t *testing.T) {
+	s := &Scenario{
+		URI:  "tcp+tls://127.0.0.1:0",
+		Cli1: [][]byte{[]byte("test"), []byte("one\n"), []byte("two\r\n")},
+		Cli2: [][]byte{[]byte("test2"), []byte("three\r"), []byte("four")},
+		Cfg:  tlsConfig(testTLSConfig(t), nil),
+	}
+
+	s.Execute(t)
+}
+
+func TestTLSPrincipal(t *testing.T) {
+	conf := testTLSConfig(t)
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+	fp := conduit.SPKIFingerprint(conf.Certificates[0].Leaf)
+	srvCfg := tlsConfig(conf, &conduit.CommonNameAuthorizer{Allow: []string{"humboldt-test"}})
+	cliCfg := tlsConfig(conf, &conduit.SPKIAuthorizer{Allow: []string{fp}})
+
+	c, peer := tlsPair(t, srvCfg, cliCfg)
+
+	assert.True(t, c.Confidential)
+	assert.True(t, c.Integrity)
+	assert.Equal(t, fp, c.Principal)
+	assert.Equal(t, "humboldt-test", peer.Principal)
+	assert.Len(t, c.Binding, conduit.BindingSize)
+	assert.Equal(t, c.Binding, peer.Binding)
+	assert.Contains(t, peer.Fingerprints, conduit.FingerprintTLS)
+}
+
+func TestTLSResumption(t *testing.T) {
+	conf := testTLSConfig(t)
+	conf.ClientSessionCache = tls.NewLRUClientSessionCache(8)
+	cfg := tlsConfig(conf, nil)
+	l, err := conduit.Listen(context.Background(), cfg, "tcp+tls://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Link.Write([]byte("hello")) //nolint:errcheck
+			c.Link.Close()
+		}
+	}()
+	dial := func() bool {
+		c, err := conduit.Dial(context.Background(), cfg, l.Addr().String())
+		require.NoError(t, err)
+		defer c.Link.Close()
+		_, err = io.ReadAll(c.Link)
+		require.NoError(t, err)
+		return c.Link.(*tls.Conn).ConnectionState().DidResume
+	}
+
+	first := dial()
+	second := dial()
+
+	assert.False(t, first)
+	assert.True(t, second)
+}
+
+func TestTLSRejected(t *testing.T) {
+	conf := testTLSConfig(t)
+	cfg := tlsConfig(conf, conduit.AuthorizerFunc(func(chain []*x509.Certificate) (string, error) {
+		return "", conduit.ErrUnauthorized
+	}))
+	l, err := conduit.Listen(context.Background(), tlsConfig(conf, nil), "tcp+tls://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go l.Accept() //nolint:errcheck
+
+	result, err := conduit.Dial(context.Background(), cfg, l.Addr().String())
+
+	assert.ErrorIs(t, err, conduit.ErrUnauthorized)
+	assert.Nil(t, result)
+}
+
+func TestTLSSoak(t *testing.T) {
+	soak := &conduittest.Soak{
+		URI:     "tcp+tls://127.0.0.1:0",
+		Config:  tlsConfig(testTLSConfig(t), nil),
+		Servers: 2,
+		Clients: 6,
+		Seed:    1,
+	}
+
+	result := soak.Execute(t)
+
+	assert.Len(t, result.Clients, 6)
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			defer c.Close()
+			io.Copy(io.Discard, c) //nolint:errcheck
+		}
+	}()
+	cfg := tlsConfig(testTLSConfig(t), nil)
+
+	c, err := conduit.Dial(context.Background(), cfg, "tcp+tls://"+l.Addr().String(), conduit.HandshakeTimeout(10*time.Millisecond))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, c)
+}
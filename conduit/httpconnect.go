@@ -0,0 +1,197 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPConnectConfig is the configuration for the HTTP CONNECT
+// transport.  It must be returned by
+// Config.ForTransport("httpconnect").
+type HTTPConnectConfig struct {
+	Proxy  *url.URL    // URL of the proxy; required
+	TLS    *tls.Config // TLS configuration for "https" proxies; may be nil
+	Header http.Header // Additional headers for the CONNECT request
+}
+
+// httpConnectConfig retrieves the HTTP CONNECT configuration.
+func httpConnectConfig(config Config) (*HTTPConnectConfig, error) {
+	if config == nil {
+		return nil, fmt.Errorf("httpconnect: %w", ErrMissingConfig)
+	}
+	hc, ok := config.ForTransport("httpconnect").(*HTTPConnectConfig)
+	if !ok || hc == nil || hc.Proxy == nil {
+		return nil, fmt.Errorf("httpconnect: %w", ErrMissingConfig)
+	}
+	if hc.Proxy.Scheme != "http" && hc.Proxy.Scheme != "https" {
+		return nil, fmt.Errorf("httpconnect: proxy %q: %w", hc.Proxy.Redacted(), ErrUnknownProxy)
+	}
+
+	return hc, nil
+}
+
+// bufferedConn is a net.Conn that first returns data buffered while
+// reading the proxy's response.
+type bufferedConn struct {
+	net.Conn
+
+	r *bufio.Reader // Reader holding the buffered data
+}
+
+// Read reads data from the connection.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// httpConnect issues the CONNECT request for the target over the
+// connection to the proxy, returning the tunneled connection.
+func httpConnect(ctx context.Context, c net.Conn, hc *HTTPConnectConfig, target string) (net.Conn, error) {
+	// Abort the exchange if the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Unix(1, 0)) //nolint:errcheck
+	})
+	defer stop()
+
+	// Send the request
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: http.Header{},
+	}
+	for key, vals := range hc.Header {
+		req.Header[key] = vals
+	}
+	if user := hc.Proxy.User; user != nil {
+		pass, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	// Read the response
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy %q: %s: %w", hc.Proxy.Redacted(), resp.Status, ErrProxyRefused)
+	}
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: c, r: r}, nil
+	}
+
+	return c, nil
+}
+
+// HTTPConnectMech is a mechanism for conduits tunneled through an
+// HTTP proxy using the CONNECT method.  The proxy is given by the
+// configuration, and the host and port of the URI are the target of
+// the tunnel.  Listening is not supported.
+type HTTPConnectMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m HTTPConnectMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	hc, err := httpConnectConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the source address and construct the dialer
+	proxyHost := hc.Proxy.Host
+	if hc.Proxy.Port() == "" {
+		port := "80"
+		if hc.Proxy.Scheme == "https" {
+			port = "443"
+		}
+		proxyHost = net.JoinHostPort(hc.Proxy.Hostname(), port)
+	}
+	if err := selectSources(opts, proxyHost); err != nil {
+		return nil, err
+	}
+	dialer, err := mkDialerPatch(opts, tcpFilter(0))
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect to the proxy
+	c, err := dialer.DialContext(ctx, "tcp", proxyHost)
+	if err != nil {
+		return nil, err
+	}
+	if hc.Proxy.Scheme == "https" {
+		tlsConf := &tls.Config{}
+		if hc.TLS != nil {
+			tlsConf = hc.TLS.Clone()
+		}
+		if tlsConf.ServerName == "" {
+			tlsConf.ServerName = hc.Proxy.Hostname()
+		}
+		tc := tls.Client(c, tlsConf)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			c.Close() //nolint:errcheck
+			return nil, err
+		}
+		c = tc
+	}
+
+	// Establish the tunnel
+	tunnel, err := httpConnect(ctx, c, hc, u.Host)
+	if err != nil {
+		c.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	// Construct and return a Conduit
+	return &Conduit{
+		State:     Active,
+		LocalURI:  TCPAddr2URI(c.LocalAddr()),
+		RemoteURI: u,
+		Link:      tunnel,
+	}, nil
+}
+
+// Listen opens a transport in passive mode.  Listening is not
+// supported by the HTTP CONNECT transport.
+func (m HTTPConnectMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	return nil, fmt.Errorf("httpconnect: %w", ErrNoListen)
+}
+
+// init initializes the HTTP CONNECT transport.
+func init() {
+	RegisterTransport("httpconnect", HTTPConnectMech(0))
+}
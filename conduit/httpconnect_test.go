@@ -0,0 +1,194 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxy is an HTTP handler implementing a CONNECT proxy,
+// requiring the specified Proxy-Authorization if it is not empty.
+func connectProxy(auth string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && r.Header.Get("Proxy-Authorization") != auth {
+			http.Error(w, "authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		c, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")) //nolint:errcheck
+		go io.Copy(target, c)                                          //nolint:errcheck
+		io.Copy(c, target)                                             //nolint:errcheck
+	})
+}
+
+// httpConnectCfg returns a configuration for the HTTP CONNECT
+// transport using the test server as the proxy.
+func httpConnectCfg(t *testing.T, srv *httptest.Server) *mockConfig {
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	hc := &HTTPConnectConfig{Proxy: u}
+	if srv.TLS != nil {
+		hc.TLS = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "httpconnect").Return(hc)
+
+	return cfg
+}
+
+// echoThrough dials the target using the HTTP CONNECT transport,
+// returning the echoed data.
+func echoThrough(cfg Config, target *URI) ([]byte, error) {
+	c, err := HTTPConnectMech(0).Dial(context.Background(), cfg, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Link.Close()
+
+	if _, err := c.Link.Write([]byte("hello")); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(c.Link)
+}
+
+func TestHTTPConnectMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), HTTPConnectMech(0))
+}
+
+func TestHTTPConnectConfigNil(t *testing.T) {
+	result, err := httpConnectConfig(nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestHTTPConnectConfigMissing(t *testing.T) {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "httpconnect").Return(&HTTPConnectConfig{})
+
+	result, err := httpConnectConfig(cfg)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestHTTPConnectConfigBadScheme(t *testing.T) {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "httpconnect").Return(&HTTPConnectConfig{
+		Proxy: &url.URL{Scheme: "socks5", Host: "proxy:1080"},
+	})
+
+	result, err := httpConnectConfig(cfg)
+
+	assert.ErrorIs(t, err, ErrUnknownProxy)
+	assert.Nil(t, result)
+}
+
+func TestHTTPConnectMechDialBase(t *testing.T) {
+	srv := httptest.NewServer(connectProxy(""))
+	defer srv.Close()
+
+	result, err := echoThrough(httpConnectCfg(t, srv), echoTarget(t))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result)
+}
+
+func TestHTTPConnectMechDialAuth(t *testing.T) {
+	srv := httptest.NewServer(connectProxy("Basic dXNlcjpwYXNz"))
+	defer srv.Close()
+	cfg := httpConnectCfg(t, srv)
+	cfg.ForTransport("httpconnect").(*HTTPConnectConfig).Proxy.User = url.UserPassword("user", "pass")
+
+	result, err := echoThrough(cfg, echoTarget(t))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result)
+}
+
+func TestHTTPConnectMechDialRefused(t *testing.T) {
+	srv := httptest.NewServer(connectProxy("Basic dXNlcjpwYXNz"))
+	defer srv.Close()
+
+	result, err := echoThrough(httpConnectCfg(t, srv), echoTarget(t))
+
+	assert.ErrorIs(t, err, ErrProxyRefused)
+	assert.Nil(t, result)
+}
+
+func TestHTTPConnectMechDialTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(connectProxy(""))
+	defer srv.Close()
+
+	result, err := echoThrough(httpConnectCfg(t, srv), echoTarget(t))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result)
+}
+
+func TestHTTPConnectMechDialCancelled(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			defer c.Close()
+			io.Copy(io.Discard, c) //nolint:errcheck
+		}
+	}()
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "httpconnect").Return(&HTTPConnectConfig{
+		Proxy: &url.URL{Scheme: "http", Host: l.Addr().String()},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+
+	result, err := HTTPConnectMech(0).Dial(ctx, cfg, echoTarget(t), nil)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestHTTPConnectMechListen(t *testing.T) {
+	result, err := HTTPConnectMech(0).Listen(context.Background(), nil, nil, nil)
+
+	assert.ErrorIs(t, err, ErrNoListen)
+	assert.Nil(t, result)
+}
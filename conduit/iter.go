@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"iter"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Conduits returns an iterator over the conduits accepted by a
+// listener.  Conduits failing their handshakes or rejected by policy
+// are skipped; iteration ends when Accept returns any other error,
+// which includes the listener being closed.  Cancelling the context
+// closes the listener, which also ends the iteration.
+func Conduits(ctx context.Context, l Listener) iter.Seq[*Conduit] {
+	return func(yield func(*Conduit) bool) {
+		stop := context.AfterFunc(ctx, func() {
+			l.Close() //nolint:errcheck
+		})
+		defer stop()
+
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				if reason := ClassifyAccept(err); reason == AcceptHandshake || reason == AcceptRejected {
+					continue
+				}
+				return
+			}
+
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// PDUs returns an iterator over the PDUs received on the conduit.
+// Each iteration yields the PDU header and the bytes following it.
+// PDUs are read in the same way as for Recv, and the two may be
+// mixed.  Iteration ends when the conduit is closed or a read error
+// occurs.  Cancelling the context interrupts any blocked read, which
+// also ends the iteration; the conduit may still be read afterwards.
+func (c *Conduit) PDUs(ctx context.Context) iter.Seq2[*proto.Header, []byte] {
+	return func(yield func(*proto.Header, []byte) bool) {
+		for ctx.Err() == nil {
+			interrupted := c.interruptRecv(ctx)
+			hdr, body, err := c.recvPDU()
+			interrupted()
+			if err != nil {
+				return
+			}
+
+			if !yield(hdr, body) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestConduitsBase(t *testing.T) {
+	c1 := &Conduit{}
+	c2 := &Conduit{}
+	l := &mockListener{}
+	l.On("Accept").Return(c1, nil).Once()
+	l.On("Accept").Return(c2, nil).Once()
+	l.On("Accept").Return(nil, assert.AnError)
+
+	result := []*Conduit{}
+	for c := range Conduits(context.Background(), l) {
+		result = append(result, c)
+	}
+
+	assert.Equal(t, []*Conduit{c1, c2}, result)
+	l.AssertExpectations(t)
+}
+
+func TestConduitsSkipsFailures(t *testing.T) {
+	c1 := &Conduit{}
+	l := &mockListener{}
+	l.On("Accept").Return(nil, &AcceptError{Reason: AcceptHandshake, Err: assert.AnError}).Once()
+	l.On("Accept").Return(nil, &AcceptError{Reason: AcceptRejected, Err: ErrUnauthorized}).Once()
+	l.On("Accept").Return(c1, nil).Once()
+	l.On("Accept").Return(nil, net.ErrClosed)
+
+	result := []*Conduit{}
+	for c := range Conduits(context.Background(), l) {
+		result = append(result, c)
+	}
+
+	assert.Equal(t, []*Conduit{c1}, result)
+	l.AssertNumberOfCalls(t, "Accept", 4)
+}
+
+func TestConduitsBreak(t *testing.T) {
+	c1 := &Conduit{}
+	l := &mockListener{}
+	l.On("Accept").Return(c1, nil)
+
+	result := []*Conduit{}
+	for c := range Conduits(context.Background(), l) {
+		result = append(result, c)
+		break
+	}
+
+	assert.Equal(t, []*Conduit{c1}, result)
+	l.AssertNumberOfCalls(t, "Accept", 1)
+	l.AssertNotCalled(t, "Close")
+}
+
+func TestConduitsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := make(chan struct{})
+	l := &mockListener{}
+	l.On("Close").Return(nil).Run(func(args mock.Arguments) {
+		close(closed)
+	})
+	l.On("Accept").Return(nil, net.ErrClosed).Run(func(args mock.Arguments) {
+		<-closed
+	})
+
+	cancel()
+	count := 0
+	for range Conduits(ctx, l) {
+		count++
+	}
+
+	assert.Equal(t, 0, count)
+	l.AssertExpectations(t)
+}
+
+func TestConduitPDUsBase(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{0x00, 0x01, 0x00, 0x01, 'a'})
+		remote.Write([]byte{0x00, 0x02, 0x00, 0x02, 'b', 'c'})
+		remote.Close()
+	}()
+
+	protos := []uint8{}
+	bodies := [][]byte{}
+	for hdr, body := range obj.PDUs(context.Background()) {
+		protos = append(protos, hdr.Protocol)
+		bodies = append(bodies, body)
+	}
+
+	assert.Equal(t, []uint8{1, 2}, protos)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("bc")}, bodies)
+}
+
+func TestConduitPDUsBreak(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{0x00, 0x01, 0x00, 0x01, 'a'})
+	}()
+
+	count := 0
+	for range obj.PDUs(context.Background()) {
+		count++
+		break
+	}
+
+	assert.Equal(t, 1, count)
+}
+
+func TestConduitPDUsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	obj := &Conduit{Link: local}
+
+	go cancel()
+	count := 0
+	for range obj.PDUs(ctx) {
+		count++
+	}
+
+	assert.Equal(t, 0, count)
+}
+
+func TestConduitPDUsCancelThenRecv(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	obj := &Conduit{Link: local}
+	go cancel()
+	for range obj.PDUs(ctx) {
+	}
+	go remote.Write([]byte{0x00, 0x01, 0x00, 0x01, 'a'}) //nolint:errcheck
+
+	result, err := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), result.Payload)
+}
+
+func TestConduitPDUsBoundaries(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Boundaries: true}
+	go func() {
+		remote.Write([]byte{0x00, 0x01, 0x00, 0x01, 'a'})
+		remote.Write([]byte{0x00, 0x02, 0x00, 0x02, 'b'})
+		remote.Write([]byte{0x00, 0x03, 0x00, 0x02, 'c', 'd'})
+		remote.Close()
+	}()
+
+	protos := []uint8{}
+	bodies := [][]byte{}
+	for hdr, body := range obj.PDUs(context.Background()) {
+		protos = append(protos, hdr.Protocol)
+		bodies = append(bodies, body)
+	}
+
+	assert.Equal(t, []uint8{1}, protos)
+	assert.Equal(t, [][]byte{[]byte("a")}, bodies)
+}
+
+func TestConduitPDUsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	obj := &Conduit{Link: local}
+	cancel()
+
+	count := 0
+	for range obj.PDUs(ctx) {
+		count++
+	}
+
+	assert.Equal(t, 0, count)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Defaults for StartKeepalive.
+const (
+	DefaultKeepaliveInterval  = 15 * time.Second // Default interval between keepalives
+	DefaultKeepaliveMaxMissed = 3                // Default keepalive intervals before the peer is dead
+)
+
+// keepaliveState tracks the carrier-level keepalive of a conduit.
+// Keepalive PDUs are sent at a fixed interval, so that middleboxes
+// see traffic on idle links; every PDU received from the peer,
+// including its keepalives, shows that the peer is alive.
+type keepaliveState struct {
+	sync.Mutex
+
+	interval  time.Duration // Interval between keepalives
+	maxMissed int           // Intervals without traffic before the peer is dead
+	last      time.Time     // Time a PDU was last received
+	timer     *time.Timer   // Timer for the next keepalive
+	timedOut  bool          // Peer was declared dead
+}
+
+// StartKeepalive starts sending keepalive PDUs over the conduit at
+// the specified interval.  If no PDU is received from the peer for
+// maxMissed intervals, the peer is declared dead: an AuditPeerTimeout
+// event is reported, the context of the conduit is cancelled with
+// ErrPeerTimeout, and the link is closed, so that any blocked Recv
+// returns.  Recv then transitions the conduit to the Error state and
+// returns ErrPeerTimeout.  Keepalive PDUs received from the peer are
+// consumed by Recv, so the peer should use keepalives as well, with
+// an interval no longer than this one.  A zero interval or maxMissed
+// selects the defaults.  Calling StartKeepalive while keepalives are
+// running changes the interval and threshold.
+func (c *Conduit) StartKeepalive(interval time.Duration, maxMissed int) {
+	if interval <= 0 {
+		interval = DefaultKeepaliveInterval
+	}
+	if maxMissed <= 0 {
+		maxMissed = DefaultKeepaliveMaxMissed
+	}
+
+	c.keepalive.Lock()
+	defer c.keepalive.Unlock()
+
+	c.keepalive.interval = interval
+	c.keepalive.maxMissed = maxMissed
+	if c.keepalive.timer != nil {
+		return
+	}
+	c.keepalive.last = timeNow()
+	c.keepalive.timer = afterFunc(interval, c.keepaliveTick)
+}
+
+// StopKeepalive stops sending keepalive PDUs over the conduit.  It is
+// called when the conduit is closed.
+func (c *Conduit) StopKeepalive() {
+	c.keepalive.Lock()
+	defer c.keepalive.Unlock()
+
+	if c.keepalive.timer != nil {
+		c.keepalive.timer.Stop()
+		c.keepalive.timer = nil
+	}
+}
+
+// keepaliveTick is called at each keepalive interval.  It checks if
+// the peer is dead, and if not, sends a keepalive.
+func (c *Conduit) keepaliveTick() {
+	c.keepalive.Lock()
+	if c.keepalive.timer == nil || c.Context().Err() != nil {
+		c.keepalive.timer = nil
+		c.keepalive.Unlock()
+		return
+	}
+
+	// Check if the peer is dead
+	if timeNow().Sub(c.keepalive.last) >= time.Duration(c.keepalive.maxMissed)*c.keepalive.interval {
+		c.keepalive.timer = nil
+		c.keepalive.timedOut = true
+		c.keepalive.Unlock()
+
+		auditError(AuditPeerTimeout, c, ErrPeerTimeout)
+		c.cancelContext(ErrPeerTimeout)
+		if c.Link != nil {
+			c.Link.Close() //nolint:errcheck
+		}
+		return
+	}
+
+	c.keepalive.timer = afterFunc(c.keepalive.interval, c.keepaliveTick)
+	c.keepalive.Unlock()
+
+	c.sendControl(proto.ControlKeepalive) //nolint:errcheck
+}
+
+// received records the receipt of a PDU from the peer.  It returns
+// true if the PDU is a keepalive, which should be consumed.
+func (c *Conduit) received(f *proto.Frame) bool {
+	c.keepalive.Lock()
+	c.keepalive.last = timeNow()
+	c.keepalive.Unlock()
+
+	if f.Protocol() != proto.ProtoControl {
+		return false
+	}
+	msg := &proto.ControlMessage{}
+	if _, err := msg.FromBytes(f.Payload); err != nil {
+		return false
+	}
+
+	return msg.Type == proto.ControlKeepalive
+}
+
+// readFailed handles an error reading from the link.  If the peer was
+// declared dead by the keepalive, the conduit transitions to the
+// Error state, and ErrPeerTimeout is returned in place of the error.
+func (c *Conduit) readFailed(err error) error {
+	c.keepalive.Lock()
+	timedOut := c.keepalive.timedOut
+	c.keepalive.Unlock()
+
+	if !timedOut {
+		c.linkFailed(err)
+		return err
+	}
+
+	c.State = Error
+	c.Error = ErrPeerTimeout
+
+	return ErrPeerTimeout
+}
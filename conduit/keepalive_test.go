@@ -0,0 +1,191 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestConduitStartKeepaliveBase(t *testing.T) {
+	now, fire := fakeClock(t)
+	obj := &Conduit{}
+
+	obj.StartKeepalive(time.Second, 2)
+
+	assert.Equal(t, time.Second, obj.keepalive.interval)
+	assert.Equal(t, 2, obj.keepalive.maxMissed)
+	assert.Equal(t, *now, obj.keepalive.last)
+	assert.NotNil(t, obj.keepalive.timer)
+	assert.NotNil(t, *fire)
+	obj.StopKeepalive()
+}
+
+func TestConduitStartKeepaliveDefaults(t *testing.T) {
+	defer patcher.SetVar(&afterFunc, func(d time.Duration, f func()) *time.Timer {
+		assert.Equal(t, DefaultKeepaliveInterval, d)
+		return time.NewTimer(time.Hour)
+	}).Install().Restore()
+	obj := &Conduit{}
+
+	obj.StartKeepalive(0, 0)
+
+	assert.Equal(t, DefaultKeepaliveInterval, obj.keepalive.interval)
+	assert.Equal(t, DefaultKeepaliveMaxMissed, obj.keepalive.maxMissed)
+	obj.StopKeepalive()
+}
+
+func TestConduitStartKeepaliveRunning(t *testing.T) {
+	now, _ := fakeClock(t)
+	obj := &Conduit{}
+	obj.StartKeepalive(time.Second, 2)
+	timer := obj.keepalive.timer
+	*now = now.Add(time.Second)
+
+	obj.StartKeepalive(5*time.Second, 4)
+
+	assert.Equal(t, 5*time.Second, obj.keepalive.interval)
+	assert.Equal(t, 4, obj.keepalive.maxMissed)
+	assert.Equal(t, time.Unix(1000, 0), obj.keepalive.last)
+	assert.Same(t, timer, obj.keepalive.timer)
+	obj.StopKeepalive()
+}
+
+func TestConduitStopKeepalive(t *testing.T) {
+	fakeClock(t)
+	obj := &Conduit{}
+	obj.StartKeepalive(time.Second, 2)
+
+	obj.StopKeepalive()
+
+	assert.Nil(t, obj.keepalive.timer)
+}
+
+func TestConduitKeepaliveTickSend(t *testing.T) {
+	now, fire := fakeClock(t)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1, Integrity: true}
+	obj.StartKeepalive(time.Second, 2)
+	defer obj.Close()
+	*now = now.Add(time.Second)
+	received := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := c2.Read(buf)
+		received <- buf[:n]
+	}()
+
+	(*fire)()
+
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x01, 0x0a}, <-received)
+	assert.NotNil(t, obj.keepalive.timer)
+	assert.NoError(t, obj.Context().Err())
+}
+
+func TestConduitKeepaliveTickTimeout(t *testing.T) {
+	now, fire := fakeClock(t)
+	events := []*AuditEvent{}
+	defer SetAuditor(SetAuditor(AuditorFunc(func(ev *AuditEvent) {
+		events = append(events, ev)
+	})))
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	obj.StartKeepalive(time.Second, 2)
+	*now = now.Add(2 * time.Second)
+
+	(*fire)()
+	result, err := obj.Recv()
+
+	assert.ErrorIs(t, err, ErrPeerTimeout)
+	assert.Nil(t, result)
+	assert.Equal(t, Error, obj.State)
+	assert.Same(t, ErrPeerTimeout, obj.Error)
+	assert.Same(t, ErrPeerTimeout, context.Cause(obj.Context()))
+	assert.Equal(t, []*AuditEvent{{Event: AuditPeerTimeout, Conduit: obj, Err: ErrPeerTimeout}}, events)
+	assert.Nil(t, obj.keepalive.timer)
+}
+
+func TestConduitKeepaliveTickStopped(t *testing.T) {
+	_, fire := fakeClock(t)
+	obj := &Conduit{State: Open}
+	obj.StartKeepalive(time.Second, 2)
+	obj.Close() //nolint:errcheck
+
+	(*fire)()
+
+	assert.Nil(t, obj.keepalive.timer)
+	assert.Same(t, ErrConduitClosed, context.Cause(obj.Context()))
+}
+
+func TestConduitReceivedKeepalive(t *testing.T) {
+	now, _ := fakeClock(t)
+	obj := &Conduit{}
+	msg := &proto.ControlMessage{Type: proto.ControlKeepalive}
+
+	result := obj.received(msg.Frame())
+
+	assert.True(t, result)
+	assert.Equal(t, *now, obj.keepalive.last)
+}
+
+func TestConduitReceivedOther(t *testing.T) {
+	obj := &Conduit{}
+	msg := &proto.ControlMessage{Type: proto.ControlPing}
+
+	assert.False(t, obj.received(msg.Frame()))
+	assert.False(t, obj.received(&proto.Frame{Header: proto.Header{Protocol: 0x17}}))
+	assert.False(t, obj.received(&proto.Frame{}))
+}
+
+func TestConduitRecvKeepalive(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	go func() {
+		remote.Write([]byte{0x00, 0x00, 0x00, 0x01, 0x0a}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x01, 'a'})  //nolint:errcheck
+		remote.Close()
+	}()
+
+	result, err := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), result.Payload)
+}
+
+func TestConduitKeepaliveDeadPeer(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go io.Copy(io.Discard, c2) //nolint:errcheck
+	obj := &Conduit{State: Open, Link: c1}
+	obj.StartKeepalive(5*time.Millisecond, 2)
+	defer obj.Close()
+
+	_, err := obj.Recv()
+
+	require.ErrorIs(t, err, ErrPeerTimeout)
+	assert.Equal(t, Error, obj.State)
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Constants for lanes.
+const (
+	DefaultLaneWeight = 1    // Weight of lanes opened with no weight
+	LaneQuantum       = 4096 // Bytes a lane may send per round, per unit of weight
+)
+
+// laneFrame is a frame queued for sending over a lane.
+type laneFrame struct {
+	frame *proto.Frame // The frame to send
+	size  int          // The encoded size of the frame
+	done  chan error   // Receives the result of sending the frame
+}
+
+// laneState holds the lanes of a conduit and the state of the
+// scheduler selecting the next frame to send.  The scheduler uses
+// deficit round robin: on each visit, a lane with frames queued is
+// credited with its quantum, and sends frames as long as the credit
+// covers them, so that each lane receives a share of the conduit
+// proportional to its weight, whatever the sizes of the frames.
+type laneState struct {
+	sync.Mutex
+
+	lanes    []*Lane         // The open lanes
+	next     int             // Index of the lane being visited
+	credited bool            // The lane being visited has been credited
+	running  bool            // The writer is running
+	idle     []chan struct{} // Closed when the writer stops
+}
+
+// Lane is a priority-tagged logical sub-channel of a conduit.  Frames
+// sent over the lanes of a conduit are interleaved in proportion to
+// the weights of the lanes, so that traffic on a lane with a large
+// weight, such as latency-sensitive control requests, is delayed
+// little by bulk traffic on a lane with a small weight.  Lanes only
+// schedule the frames sent by the local side; frames sent with
+// Conduit.Send bypass the lanes.
+type Lane struct {
+	c       *Conduit     // The conduit
+	weight  int          // The weight of the lane
+	deficit int          // Bytes the lane may send this round
+	queue   []*laneFrame // The frames awaiting sending
+	closed  bool         // The lane has been closed
+}
+
+// OpenLane opens a lane over the conduit with the specified weight.
+// If the weight is not positive, DefaultLaneWeight is used.
+func (c *Conduit) OpenLane(weight int) *Lane {
+	if weight <= 0 {
+		weight = DefaultLaneWeight
+	}
+	l := &Lane{
+		c:      c,
+		weight: weight,
+	}
+
+	c.lanes.Lock()
+	defer c.lanes.Unlock()
+	c.lanes.lanes = append(c.lanes.lanes, l)
+
+	return l
+}
+
+// Weight returns the weight of the lane.
+func (l *Lane) Weight() int {
+	l.c.lanes.Lock()
+	defer l.c.lanes.Unlock()
+
+	return l.weight
+}
+
+// SetWeight changes the weight of the lane.  If the weight is not
+// positive, DefaultLaneWeight is used.
+func (l *Lane) SetWeight(weight int) {
+	if weight <= 0 {
+		weight = DefaultLaneWeight
+	}
+
+	l.c.lanes.Lock()
+	defer l.c.lanes.Unlock()
+	l.weight = weight
+}
+
+// Send sends a frame over the lane, waiting until it has been
+// written to the conduit.  If the context is cancelled before the
+// frame is written, the frame is discarded and the context's error
+// is returned.
+func (l *Lane) Send(ctx context.Context, frame *proto.Frame) error {
+	lf := &laneFrame{
+		frame: frame,
+		size:  frame.Size(),
+		done:  make(chan error, 1),
+	}
+
+	// Queue the frame, starting the writer if necessary
+	ls := &l.c.lanes
+	ls.Lock()
+	if l.closed {
+		ls.Unlock()
+		return ErrLaneClosed
+	}
+	l.queue = append(l.queue, lf)
+	if !ls.running {
+		ls.running = true
+		go l.c.laneWriter()
+	}
+	ls.Unlock()
+
+	select {
+	case err := <-lf.done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Discard the frame if it has not been dequeued
+	ls.Lock()
+	if idx := slices.Index(l.queue, lf); idx >= 0 {
+		l.queue = slices.Delete(l.queue, idx, idx+1)
+		ls.Unlock()
+		return ctx.Err()
+	}
+	ls.Unlock()
+
+	return <-lf.done
+}
+
+// Close closes the lane.  Frames awaiting sending are discarded, and
+// their senders receive ErrLaneClosed.
+func (l *Lane) Close() error {
+	ls := &l.c.lanes
+	ls.Lock()
+	defer ls.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	for _, lf := range l.queue {
+		lf.done <- ErrLaneClosed
+	}
+	l.queue = nil
+
+	// Remove the lane from the scheduler
+	idx := slices.Index(ls.lanes, l)
+	ls.lanes = slices.Delete(ls.lanes, idx, idx+1)
+	if idx < ls.next {
+		ls.next--
+	} else if idx == ls.next {
+		ls.credited = false
+	}
+	if ls.next >= len(ls.lanes) {
+		ls.next = 0
+	}
+
+	return nil
+}
+
+// dequeue selects the next frame to send.  It returns nil, marking
+// the writer as stopped, if no frames are queued.
+func (ls *laneState) dequeue() *laneFrame {
+	ls.Lock()
+	defer ls.Unlock()
+
+	if !slices.ContainsFunc(ls.lanes, func(l *Lane) bool { return len(l.queue) > 0 }) {
+		ls.running = false
+		for _, ch := range ls.idle {
+			close(ch)
+		}
+		ls.idle = nil
+		return nil
+	}
+
+	for {
+		l := ls.lanes[ls.next]
+		if len(l.queue) > 0 {
+			if !ls.credited {
+				l.deficit += l.weight * LaneQuantum
+				ls.credited = true
+			}
+			if lf := l.queue[0]; lf.size <= l.deficit {
+				l.deficit -= lf.size
+				l.queue = l.queue[1:]
+				if len(l.queue) == 0 {
+					l.deficit = 0
+					ls.advance()
+				}
+				return lf
+			}
+		} else {
+			l.deficit = 0
+		}
+
+		ls.advance()
+	}
+}
+
+// flushed returns a channel that is closed once the writer has sent
+// all the frames queued on the lanes.
+func (ls *laneState) flushed() chan struct{} {
+	ls.Lock()
+	defer ls.Unlock()
+
+	ch := make(chan struct{})
+	if ls.running {
+		ls.idle = append(ls.idle, ch)
+	} else {
+		close(ch)
+	}
+
+	return ch
+}
+
+// advance moves the scheduler to the next lane.  Must be called with
+// the lock held.
+func (ls *laneState) advance() {
+	ls.next = (ls.next + 1) % len(ls.lanes)
+	ls.credited = false
+}
+
+// laneWriter sends the frames queued on the lanes of the conduit
+// until none remain.
+func (c *Conduit) laneWriter() {
+	for lf := c.lanes.dequeue(); lf != nil; lf = c.lanes.dequeue() {
+		lf.done <- c.Send(lf.frame)
+	}
+}
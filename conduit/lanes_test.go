@@ -0,0 +1,228 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// laneTestFrame returns a frame for the protocol with the specified
+// encoded size.
+func laneTestFrame(protocol uint8, size int) *proto.Frame {
+	return &proto.Frame{
+		Header:  proto.Header{Protocol: protocol},
+		Payload: make([]byte, size-proto.HeaderSize),
+	}
+}
+
+// laneQueue queues frames on a lane without starting the writer.
+func laneQueue(l *Lane, frames ...*proto.Frame) {
+	for _, f := range frames {
+		l.queue = append(l.queue, &laneFrame{frame: f, size: f.Size(), done: make(chan error, 1)})
+	}
+}
+
+// laneOrder dequeues all the frames, returning their protocols.
+func laneOrder(c *Conduit) []uint8 {
+	result := []uint8{}
+	for lf := c.lanes.dequeue(); lf != nil; lf = c.lanes.dequeue() {
+		result = append(result, lf.frame.Header.Protocol)
+	}
+
+	return result
+}
+
+func TestConduitOpenLaneBase(t *testing.T) {
+	obj := &Conduit{}
+
+	result := obj.OpenLane(5)
+
+	assert.Same(t, obj, result.c)
+	assert.Equal(t, 5, result.Weight())
+	assert.Equal(t, []*Lane{result}, obj.lanes.lanes)
+}
+
+func TestConduitOpenLaneDefault(t *testing.T) {
+	obj := &Conduit{}
+
+	result := obj.OpenLane(0)
+
+	assert.Equal(t, DefaultLaneWeight, result.Weight())
+}
+
+func TestLaneSetWeight(t *testing.T) {
+	obj := (&Conduit{}).OpenLane(1)
+
+	obj.SetWeight(3)
+	assert.Equal(t, 3, obj.Weight())
+	obj.SetWeight(-1)
+	assert.Equal(t, DefaultLaneWeight, obj.Weight())
+}
+
+func TestLaneStateDequeueWeighted(t *testing.T) {
+	c := &Conduit{}
+	control := c.OpenLane(3)
+	bulk := c.OpenLane(1)
+	for range 6 {
+		laneQueue(control, laneTestFrame(1, LaneQuantum))
+		laneQueue(bulk, laneTestFrame(2, LaneQuantum))
+	}
+
+	result := laneOrder(c)
+
+	assert.Equal(t, []uint8{1, 1, 1, 2, 1, 1, 1, 2, 2, 2, 2, 2}, result)
+	assert.False(t, c.lanes.running)
+}
+
+func TestLaneStateDequeueSizes(t *testing.T) {
+	c := &Conduit{}
+	small := c.OpenLane(1)
+	large := c.OpenLane(1)
+	for range 4 {
+		laneQueue(small, laneTestFrame(1, LaneQuantum/4))
+	}
+	laneQueue(large, laneTestFrame(2, 2*LaneQuantum), laneTestFrame(2, LaneQuantum/4))
+
+	result := laneOrder(c)
+
+	assert.Equal(t, []uint8{1, 1, 1, 1, 2, 2}, result)
+}
+
+func TestLaneStateDequeueEmpty(t *testing.T) {
+	c := &Conduit{}
+	c.OpenLane(1)
+	c.lanes.running = true
+
+	result := c.lanes.dequeue()
+
+	assert.Nil(t, result)
+	assert.False(t, c.lanes.running)
+}
+
+func TestLaneStateFlushedIdle(t *testing.T) {
+	c := &Conduit{}
+
+	result := c.lanes.flushed()
+
+	assert.True(t, isClosed(result))
+}
+
+func TestLaneStateFlushedRunning(t *testing.T) {
+	c := &Conduit{}
+	c.OpenLane(1)
+	c.lanes.running = true
+
+	result := c.lanes.flushed()
+	running := isClosed(result)
+	c.lanes.dequeue()
+
+	assert.False(t, running)
+	assert.True(t, isClosed(result))
+	assert.Nil(t, c.lanes.idle)
+}
+
+func TestLaneSendBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{Link: c1}
+	peer := &Conduit{Link: c2}
+	lane := obj.OpenLane(1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- lane.Send(context.Background(), laneTestFrame(5, 16))
+	}()
+
+	result, err := peer.Recv()
+
+	require.NoError(t, err)
+	assert.Equal(t, uint8(5), result.Header.Protocol)
+	assert.NoError(t, <-errs)
+}
+
+func TestLaneSendClosed(t *testing.T) {
+	obj := (&Conduit{}).OpenLane(1)
+	obj.Close() //nolint:errcheck
+
+	err := obj.Send(context.Background(), laneTestFrame(5, 16))
+
+	assert.ErrorIs(t, err, ErrLaneClosed)
+}
+
+func TestLaneSendCancelled(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{Link: c1}
+	lane := obj.OpenLane(1)
+
+	// The first frame blocks the writer, as nothing reads the pipe
+	go lane.Send(context.Background(), laneTestFrame(5, 16)) //nolint:errcheck
+	require.Eventually(t, func() bool {
+		obj.lanes.Lock()
+		defer obj.lanes.Unlock()
+		return obj.lanes.running && len(lane.queue) == 0
+	}, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := lane.Send(ctx, laneTestFrame(6, 16))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	obj.lanes.Lock()
+	assert.Empty(t, lane.queue)
+	obj.lanes.Unlock()
+}
+
+func TestLaneClose(t *testing.T) {
+	c := &Conduit{}
+	lane1 := c.OpenLane(1)
+	obj := c.OpenLane(1)
+	lane3 := c.OpenLane(1)
+	laneQueue(obj, laneTestFrame(5, 16))
+	pending := obj.queue[0]
+	c.lanes.next = 2
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, <-pending.done, ErrLaneClosed)
+	assert.Empty(t, obj.queue)
+	assert.Equal(t, []*Lane{lane1, lane3}, c.lanes.lanes)
+	assert.Equal(t, 1, c.lanes.next)
+	assert.NoError(t, obj.Close())
+}
+
+func TestLaneCloseLast(t *testing.T) {
+	c := &Conduit{}
+	c.OpenLane(1)
+	obj := c.OpenLane(1)
+	c.lanes.next = 1
+	c.lanes.credited = true
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, c.lanes.next)
+	assert.False(t, c.lanes.credited)
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// acceptResult is the result of a single Accept call on one of the
+// listeners multiplexed by a MultiListener, or by one of the workers
+// of a handshakeListener.
+type acceptResult struct {
+	c   *Conduit // The conduit, if successful
+	err error    // The error, if not
+}
+
+// MultiListener is an implementation of Listener which multiplexes
+// the conduits accepted by several listeners, such as those opened
+// by ListenAll on each canonical URI of a listen URI.
+type MultiListener struct {
+	Listeners []Listener // The underlying listeners
+
+	results chan acceptResult // Results of the accept loops
+	done    chan struct{}     // Closed when the listener is closed
+	once    sync.Once         // Ensures done is closed only once
+	wg      sync.WaitGroup    // Tracks the accept loops
+}
+
+// NewMultiListener constructs a MultiListener which multiplexes the
+// specified listeners.  The listeners are owned by the
+// MultiListener, and are closed when it is closed.
+func NewMultiListener(listeners ...Listener) *MultiListener {
+	l := &MultiListener{
+		Listeners: listeners,
+		results:   make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+
+	// Start the accept loops
+	l.wg.Add(len(listeners))
+	for _, sub := range listeners {
+		go l.acceptLoop(sub)
+	}
+	go func() {
+		l.wg.Wait()
+		close(l.results)
+	}()
+
+	return l
+}
+
+// acceptLoop accepts conduits from one of the underlying listeners.
+// Errors which permit accepting to continue are passed on, and the
+// loop exits once the listener is closed or a fatal error has been
+// passed on.  Since the results are not buffered, the loop proceeds
+// no faster than Accept is called, and thus any delays imposed by the
+// caller after temporary failures apply to the listener as well.
+func (l *MultiListener) acceptLoop(sub Listener) {
+	defer l.wg.Done()
+
+	for {
+		c, err := sub.Accept()
+		reason := ClassifyAccept(err)
+		if err != nil && reason == AcceptClosed {
+			return
+		}
+
+		select {
+		case l.results <- acceptResult{c: c, err: err}:
+		case <-l.done:
+			if c != nil && c.Link != nil {
+				c.Link.Close() //nolint:errcheck
+			}
+			return
+		}
+
+		if err != nil && !reason.Retry() {
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next conduit to any of the
+// underlying listeners.  Once all the underlying listeners are closed
+// or have failed, net.ErrClosed is returned.
+func (l *MultiListener) Accept() (*Conduit, error) {
+	select {
+	case res, ok := <-l.results:
+		if !ok {
+			return nil, net.ErrClosed
+		}
+		return res.c, res.err
+
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener and all the underlying listeners.  Any
+// blocked Accept operations will be unblocked and return errors.
+// The errors returned by the underlying listeners are joined.
+func (l *MultiListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+
+	errs := []error{}
+	for _, sub := range l.Listeners {
+		if err := sub.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Addr returns the network URI of the first underlying listener.  Use
+// Addrs to obtain the URIs of all the listeners.
+func (l *MultiListener) Addr() *URI {
+	if len(l.Listeners) == 0 {
+		return nil
+	}
+
+	return l.Listeners[0].Addr()
+}
+
+// Addrs returns the network URIs of all the underlying listeners.
+func (l *MultiListener) Addrs() []*URI {
+	result := make([]*URI, len(l.Listeners))
+	for i, sub := range l.Listeners {
+		result[i] = sub.Addr()
+	}
+
+	return result
+}
+
+// ListenAll canonicalizes the URI and opens a listener on each of the
+// resulting canonical URIs, returning a MultiListener multiplexing
+// them.  Duplicate canonical URIs are listened on only once.  If any
+// of the listeners cannot be opened, those already opened are closed
+// and the error is returned.  Note that, if the URI specifies port 0,
+// each listener will be assigned a port independently.  Host names
+// are looked up with the resolver carried by the context; see
+// WithResolver.
+func (u *URI) ListenAll(ctx context.Context, config Config, opts ...ListenerOption) (*MultiListener, error) {
+	// Canonicalize the URI
+	uris, err := u.CanonicalizeContext(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the listeners
+	listeners := []Listener{}
+	seen := map[string]bool{}
+	for _, cu := range uris {
+		key := cu.Key()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		l, err := cu.Listen(ctx, config, opts...)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close() //nolint:errcheck
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	return NewMultiListener(listeners...), nil
+}
+
+// ListenAll parses the URI, then canonicalizes it and listens on the
+// results as described for URI.ListenAll.
+func ListenAll(ctx context.Context, config Config, uri string, opts ...ListenerOption) (*MultiListener, error) {
+	// Parse the URI
+	u, err := Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Listen on it
+	return u.ListenAll(ctx, config, opts...)
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// closedListener returns a mock listener which accepts the specified
+// conduits, then reports that it has been closed.
+func closedListener(conduits ...*Conduit) *mockListener {
+	l := &mockListener{}
+	for _, c := range conduits {
+		l.On("Accept").Return(c, nil).Once()
+	}
+	l.On("Accept").Return(nil, net.ErrClosed)
+
+	return l
+}
+
+func TestMultiListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &MultiListener{})
+}
+
+func TestMultiListenerAcceptBase(t *testing.T) {
+	c1, c2 := &Conduit{}, &Conduit{}
+	l1, l2 := closedListener(c1), closedListener(c2)
+	obj := NewMultiListener(l1, l2)
+
+	r1, err1 := obj.Accept()
+	r2, err2 := obj.Accept()
+	r3, err3 := obj.Accept()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.ElementsMatch(t, []*Conduit{c1, c2}, []*Conduit{r1, r2})
+	assert.ErrorIs(t, err3, net.ErrClosed)
+	assert.Nil(t, r3)
+	l1.AssertExpectations(t)
+	l2.AssertExpectations(t)
+}
+
+func TestMultiListenerAcceptRetry(t *testing.T) {
+	c := &Conduit{}
+	ae := &AcceptError{Reason: AcceptHandshake, Err: assert.AnError}
+	l := &mockListener{}
+	l.On("Accept").Return(nil, ae).Once()
+	l.On("Accept").Return(c, nil).Once()
+	l.On("Accept").Return(nil, net.ErrClosed)
+	obj := NewMultiListener(l)
+
+	r1, err1 := obj.Accept()
+	r2, err2 := obj.Accept()
+	r3, err3 := obj.Accept()
+
+	assert.Same(t, ae, err1)
+	assert.Nil(t, r1)
+	assert.NoError(t, err2)
+	assert.Same(t, c, r2)
+	assert.ErrorIs(t, err3, net.ErrClosed)
+	assert.Nil(t, r3)
+}
+
+func TestMultiListenerAcceptFatal(t *testing.T) {
+	l := &mockListener{}
+	l.On("Accept").Return(nil, assert.AnError).Once()
+	obj := NewMultiListener(l)
+
+	r1, err1 := obj.Accept()
+	r2, err2 := obj.Accept()
+
+	assert.Same(t, assert.AnError, err1)
+	assert.Nil(t, r1)
+	assert.ErrorIs(t, err2, net.ErrClosed)
+	assert.Nil(t, r2)
+	l.AssertExpectations(t)
+}
+
+func TestMultiListenerClose(t *testing.T) {
+	release := make(chan struct{})
+	l1 := &mockListener{}
+	l1.On("Accept").Return(nil, net.ErrClosed).Run(func(args mock.Arguments) {
+		<-release
+	})
+	l1.On("Close").Return(nil).Run(func(args mock.Arguments) {
+		close(release)
+	})
+	l2 := closedListener()
+	l2.On("Close").Return(assert.AnError)
+	obj := NewMultiListener(l1, l2)
+
+	err := obj.Close()
+	result, acceptErr := obj.Accept()
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorIs(t, acceptErr, net.ErrClosed)
+	assert.Nil(t, result)
+	l1.AssertCalled(t, "Close")
+	l2.AssertCalled(t, "Close")
+}
+
+func TestMultiListenerCloseDiscards(t *testing.T) {
+	closed := make(chan struct{})
+	link := &mockConn{}
+	link.On("Close").Return(nil).Run(func(args mock.Arguments) {
+		close(closed)
+	})
+	release := make(chan struct{})
+	l := &mockListener{}
+	l.On("Accept").Return(&Conduit{Link: link}, nil).Once().Run(func(args mock.Arguments) {
+		<-release
+	})
+	l.On("Close").Return(nil)
+	obj := NewMultiListener(l)
+
+	err := obj.Close()
+	close(release)
+	<-closed
+
+	assert.NoError(t, err)
+	link.AssertExpectations(t)
+}
+
+func TestMultiListenerAddr(t *testing.T) {
+	u1, u2 := mustParse("tcp://127.0.0.1:1234"), mustParse("tcp://[::1]:1234")
+	l1, l2 := closedListener(), closedListener()
+	l1.On("Addr").Return(u1)
+	l2.On("Addr").Return(u2)
+	obj := NewMultiListener(l1, l2)
+
+	assert.Same(t, u1, obj.Addr())
+	assert.Equal(t, []*URI{u1, u2}, obj.Addrs())
+}
+
+func TestMultiListenerAddrEmpty(t *testing.T) {
+	obj := NewMultiListener()
+
+	assert.Nil(t, obj.Addr())
+	assert.Equal(t, []*URI{}, obj.Addrs())
+}
+
+// listenAllMech returns a mechanism for testing ListenAll, along with
+// a patcher installing it as the tcp transport.
+func listenAllMech(ips ...string) (*mockMechanism, patcher.Patcher) {
+	mech := &mockMechanism{}
+	return mech, patcher.NewPatchMaster(
+		patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+			return mech
+		}),
+		patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+			result := []net.IP{}
+			for _, ip := range ips {
+				result = append(result, net.ParseIP(ip))
+			}
+			return result, nil
+		}),
+	)
+}
+
+func TestURIListenAllBase(t *testing.T) {
+	mech, p := listenAllMech("127.0.0.1", "::1", "127.0.0.1")
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	l1, l2 := closedListener(), closedListener()
+	mech.On("Listen", mock.Anything, cfg, uriHost("127.0.0.1:1234"), mock.Anything).Return(l1, nil).Once()
+	mech.On("Listen", mock.Anything, cfg, uriHost("[::1]:1234"), mock.Anything).Return(l2, nil).Once()
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.ListenAll(context.Background(), cfg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Listener{l1, l2}, result.Listeners)
+	mech.AssertExpectations(t)
+}
+
+func TestURIListenAllListenError(t *testing.T) {
+	mech, p := listenAllMech("127.0.0.1", "::1")
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	l1 := &mockListener{}
+	l1.On("Close").Return(nil)
+	mech.On("Listen", mock.Anything, cfg, uriHost("127.0.0.1:1234"), mock.Anything).Return(l1, nil)
+	mech.On("Listen", mock.Anything, cfg, uriHost("[::1]:1234"), mock.Anything).Return(nil, assert.AnError)
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.ListenAll(context.Background(), cfg)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	l1.AssertExpectations(t)
+}
+
+func TestURIListenAllCanonicalizeError(t *testing.T) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.ListenAll(context.Background(), &mockConfig{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestURIListenAllNoAddresses(t *testing.T) {
+	_, p := listenAllMech()
+	defer p.Install().Restore()
+	u := mustParse("tcp://example.com:1234")
+
+	result, err := u.ListenAll(context.Background(), &mockConfig{})
+
+	assert.ErrorIs(t, err, ErrNoAddresses)
+	assert.Nil(t, result)
+}
+
+func TestListenAllBase(t *testing.T) {
+	mech, p := listenAllMech("127.0.0.1")
+	defer p.Install().Restore()
+	cfg := &mockConfig{}
+	l := closedListener()
+	mech.On("Listen", mock.Anything, cfg, uriHost("127.0.0.1:1234"), mock.Anything).Return(l, nil)
+
+	result, err := ListenAll(context.Background(), cfg, "tcp://example.com:1234")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Listener{l}, result.Listeners)
+}
+
+func TestListenAllParseError(t *testing.T) {
+	result, err := ListenAll(context.Background(), &mockConfig{}, "%")
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
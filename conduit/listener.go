@@ -14,6 +14,12 @@
 
 package conduit
 
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
 // Listener is a variation on the net.Listener interface that returns
 // Conduit objects instead of net.Conn objects.
 type Listener interface {
@@ -28,3 +34,109 @@ type Listener interface {
 	// Addr returns the listener's network URI.
 	Addr() *URI
 }
+
+// AcceptReason classifies the failures of Listener.Accept, allowing
+// accept loops to decide whether to continue accepting.
+type AcceptReason int
+
+// Reasons for Accept failures.
+const (
+	AcceptFatal     AcceptReason = iota // Unclassified; the listener is unusable
+	AcceptClosed                        // The listener was closed
+	AcceptTemporary                     // Temporary failure; retry after a delay
+	AcceptHandshake                     // A conduit failed its handshake
+	AcceptRejected                      // A conduit was rejected by policy
+)
+
+// acceptReasonNames contains the names of the accept reasons.
+var acceptReasonNames = map[AcceptReason]string{
+	AcceptFatal:     "fatal",
+	AcceptClosed:    "closed",
+	AcceptTemporary: "temporary",
+	AcceptHandshake: "handshake",
+	AcceptRejected:  "rejected",
+}
+
+// String returns the name of the accept reason.
+func (r AcceptReason) String() string {
+	if name, ok := acceptReasonNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("AcceptReason(%d)", int(r))
+}
+
+// Retry returns true if accepting may continue after a failure with
+// the reason.
+func (r AcceptReason) Retry() bool {
+	return r == AcceptTemporary || r == AcceptHandshake || r == AcceptRejected
+}
+
+// AcceptError is returned by listeners when an accepted conduit is
+// discarded, such as when it fails the handshake of a security layer
+// or its peer is rejected.  The listener remains usable.
+type AcceptError struct {
+	Reason    AcceptReason // The reason for the failure
+	RemoteURI *URI         // The remote URI of the discarded conduit, if known
+	Err       error        // The underlying error
+}
+
+// acceptError constructs an AcceptError for a conduit failing its
+// handshake, classifying peer rejections appropriately.
+func acceptError(c *Conduit, err error) *AcceptError {
+	reason := AcceptHandshake
+	if rejected(err) {
+		reason = AcceptRejected
+	}
+
+	return &AcceptError{Reason: reason, RemoteURI: c.RemoteURI, Err: err}
+}
+
+// Error returns the error message.
+func (e *AcceptError) Error() string {
+	if e.RemoteURI != nil {
+		return fmt.Sprintf("accept %s: %s: %s", e.RemoteURI, e.Reason, e.Err)
+	}
+
+	return fmt.Sprintf("accept: %s: %s", e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *AcceptError) Unwrap() error {
+	return e.Err
+}
+
+// rejected returns true if the error indicates that a peer was
+// rejected by policy.
+func rejected(err error) bool {
+	return errors.Is(err, ErrUnauthorized) ||
+		errors.Is(err, ErrNoisePeer) ||
+		errors.Is(err, ErrPSKUnknown) ||
+		errors.Is(err, ErrPSKAuth)
+}
+
+// ClassifyAccept classifies an error returned by Listener.Accept.
+// An AcceptError reports its own reason; otherwise, net.ErrClosed
+// indicates the listener was closed, and errors reporting themselves
+// as temporary, such as those from file descriptor exhaustion, are
+// temporary.  Any other error is fatal.
+func ClassifyAccept(err error) AcceptReason {
+	var ae *AcceptError
+	if errors.As(err, &ae) {
+		return ae.Reason
+	}
+
+	if errors.Is(err, net.ErrClosed) {
+		return AcceptClosed
+	}
+
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) && temp.Temporary() {
+		return AcceptTemporary
+	}
+	if rejected(err) {
+		return AcceptRejected
+	}
+
+	return AcceptFatal
+}
@@ -14,7 +14,15 @@
 
 package conduit
 
-import "github.com/stretchr/testify/mock"
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
 
 type mockListener struct {
 	mock.Mock
@@ -45,3 +53,67 @@ func (m *mockListener) Addr() *URI {
 
 	return nil
 }
+
+func TestAcceptReasonString(t *testing.T) {
+	assert.Equal(t, "handshake", AcceptHandshake.String())
+	assert.Equal(t, "AcceptReason(42)", AcceptReason(42).String())
+}
+
+func TestAcceptReasonRetry(t *testing.T) {
+	assert.False(t, AcceptFatal.Retry())
+	assert.False(t, AcceptClosed.Retry())
+	assert.True(t, AcceptTemporary.Retry())
+	assert.True(t, AcceptHandshake.Retry())
+	assert.True(t, AcceptRejected.Retry())
+}
+
+func TestAcceptErrorError(t *testing.T) {
+	u, _ := Parse("tcp+tls://127.0.0.1:1234")
+	obj := &AcceptError{Reason: AcceptHandshake, RemoteURI: u, Err: assert.AnError}
+
+	assert.Equal(t, "accept tcp+tls://127.0.0.1:1234: handshake: "+assert.AnError.Error(), obj.Error())
+	assert.ErrorIs(t, obj, assert.AnError)
+}
+
+func TestAcceptErrorErrorNoURI(t *testing.T) {
+	obj := &AcceptError{Reason: AcceptRejected, Err: assert.AnError}
+
+	assert.Equal(t, "accept: rejected: "+assert.AnError.Error(), obj.Error())
+}
+
+func TestAcceptErrorHandshake(t *testing.T) {
+	u, _ := Parse("tcp+noise://127.0.0.1:1234")
+
+	result := acceptError(&Conduit{RemoteURI: u}, assert.AnError)
+
+	assert.Equal(t, &AcceptError{Reason: AcceptHandshake, RemoteURI: u, Err: assert.AnError}, result)
+}
+
+func TestAcceptErrorRejected(t *testing.T) {
+	err := fmt.Errorf("noise: %w", ErrNoisePeer)
+
+	result := acceptError(&Conduit{}, err)
+
+	assert.Equal(t, &AcceptError{Reason: AcceptRejected, Err: err}, result)
+}
+
+func TestClassifyAccept(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		result AcceptReason
+	}{
+		{"AcceptError", fmt.Errorf("wrapped: %w", &AcceptError{Reason: AcceptHandshake}), AcceptHandshake},
+		{"Closed", fmt.Errorf("accept: %w", net.ErrClosed), AcceptClosed},
+		{"Temporary", &net.OpError{Op: "accept", Err: syscall.EMFILE}, AcceptTemporary},
+		{"Unauthorized", fmt.Errorf("tls: %w", ErrUnauthorized), AcceptRejected},
+		{"PSKUnknown", ErrPSKUnknown, AcceptRejected},
+		{"Other", assert.AnError, AcceptFatal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.result, ClassifyAccept(test.err))
+		})
+	}
+}
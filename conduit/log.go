@@ -0,0 +1,130 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// logger is the current logger.
+var (
+	logger     *slog.Logger
+	loggerLock sync.RWMutex
+)
+
+// SetLogger sets the logger to receive structured log records
+// describing dial attempts, canonicalization results, discovery
+// lookups, accept failures, and security layer handshakes, returning
+// the previous logger.  Successful operations are logged at debug
+// level, and failures at warning level.  Passing nil disables
+// logging.
+func SetLogger(l *slog.Logger) *slog.Logger {
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+
+	prev := logger
+	logger = l
+
+	return prev
+}
+
+// getLogger returns the current logger, or nil if logging is
+// disabled.
+func getLogger() *slog.Logger {
+	loggerLock.RLock()
+	defer loggerLock.RUnlock()
+
+	return logger
+}
+
+// uriArgs returns the structured fields describing a URI: the URI
+// itself and the names of its mechanisms.
+func uriArgs(u *URI) []any {
+	if u == nil {
+		return nil
+	}
+
+	args := []any{"uri", u.String(), "transport", u.Transport}
+	if u.Security != "" {
+		args = append(args, "security", u.Security)
+	}
+	if u.Discovery != "" {
+		args = append(args, "discovery", u.Discovery)
+	}
+
+	return args
+}
+
+// logResolutions is a slog.LogValuer describing the results of
+// canonicalization, so that they are only formatted if logged.
+type logResolutions []*Resolution
+
+// LogValue returns the value to log.
+func (r logResolutions) LogValue() slog.Value {
+	result := make([]string, len(r))
+	for i, res := range r {
+		result[i] = res.String()
+	}
+
+	return slog.AnyValue(result)
+}
+
+// logResult logs the outcome of an operation: at debug level if it
+// succeeded, or at warning level, including the error, if it failed.
+func logResult(msg string, err error, args ...any) {
+	l := getLogger()
+	if l == nil {
+		return
+	}
+
+	if err != nil {
+		l.Warn(msg+" failed", append(args, "error", err)...)
+		return
+	}
+	l.Debug(msg, args...)
+}
+
+// logHandshake logs the outcome of the handshake of a security layer
+// mechanism on an accepted conduit.
+func logHandshake(mech string, c *Conduit, err error) {
+	args := append(uriArgs(c.RemoteURI), "security", mech)
+	if err == nil {
+		args = append(args, "principal", c.Principal)
+	}
+	logResult("handshake", err, args...)
+}
+
+// logAccept logs a failure of Listener.Accept.  Fatal failures are
+// logged at error level.
+func logAccept(reason AcceptReason, err error) {
+	l := getLogger()
+	if l == nil {
+		return
+	}
+
+	level := slog.LevelWarn
+	if reason == AcceptFatal {
+		level = slog.LevelError
+	}
+	args := []any{"reason", reason.String(), "error", err}
+	var ae *AcceptError
+	if errors.As(err, &ae) && ae.RemoteURI != nil {
+		args = append(args, "remote", ae.RemoteURI.String())
+	}
+	l.Log(context.Background(), level, "accept failed", args...)
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// logRecord is a simplified log record captured by logCapture.
+type logRecord struct {
+	Level slog.Level
+	Msg   string
+	Attrs map[string]any
+}
+
+// logCapture is a slog.Handler which captures the records logged.
+type logCapture struct {
+	sync.Mutex
+
+	records []logRecord
+}
+
+func (h *logCapture) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *logCapture) Handle(ctx context.Context, r slog.Record) error {
+	rec := logRecord{Level: r.Level, Msg: r.Message, Attrs: map[string]any{}}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.Attrs[a.Key] = a.Value.Resolve().Any()
+		return true
+	})
+
+	h.Lock()
+	defer h.Unlock()
+	h.records = append(h.records, rec)
+
+	return nil
+}
+
+func (h *logCapture) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *logCapture) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// captureLogs installs a logger capturing the records logged,
+// returning the handler and a function restoring the previous
+// logger.
+func captureLogs() (*logCapture, func()) {
+	h := &logCapture{}
+	prev := SetLogger(slog.New(h))
+
+	return h, func() { SetLogger(prev) }
+}
+
+func TestSetLogger(t *testing.T) {
+	l := slog.New(&logCapture{})
+	defer SetLogger(SetLogger(nil))
+
+	result1 := SetLogger(l)
+	result2 := SetLogger(nil)
+
+	assert.Nil(t, result1)
+	assert.Same(t, l, result2)
+	assert.Nil(t, getLogger())
+}
+
+func TestURIArgsBase(t *testing.T) {
+	result := uriArgs(mustParse("tcp+tls.srv://example.com:1234"))
+
+	assert.Equal(t, []any{
+		"uri", "tcp+tls.srv://example.com:1234",
+		"transport", "tcp",
+		"security", "tls",
+		"discovery", "srv",
+	}, result)
+}
+
+func TestURIArgsTransportOnly(t *testing.T) {
+	result := uriArgs(mustParse("tcp://127.0.0.1:1234"))
+
+	assert.Equal(t, []any{"uri", "tcp://127.0.0.1:1234", "transport", "tcp"}, result)
+}
+
+func TestURIArgsNil(t *testing.T) {
+	result := uriArgs(nil)
+
+	assert.Nil(t, result)
+}
+
+func TestLogResolutions(t *testing.T) {
+	obj := logResolutions{{URI: mustParse("tcp://127.0.0.1:1234"), Source: SourceLiteral}}
+
+	result := obj.LogValue()
+
+	assert.Equal(t, []string{"tcp://127.0.0.1:1234 (literal)"}, result.Any())
+}
+
+func TestLogResultDisabled(t *testing.T) {
+	defer SetLogger(SetLogger(nil))
+
+	logResult("dial", assert.AnError, "key", "value")
+}
+
+func TestLogResultSuccess(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+
+	logResult("dial", nil, "key", "value")
+
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelDebug, Msg: "dial", Attrs: map[string]any{"key": "value"}},
+	}, h.records)
+}
+
+func TestLogResultFailure(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+
+	logResult("dial", assert.AnError, "key", "value")
+
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelWarn, Msg: "dial failed", Attrs: map[string]any{"key": "value", "error": assert.AnError}},
+	}, h.records)
+}
+
+func TestLogHandshakeSuccess(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+	c := &Conduit{RemoteURI: mustParse("tcp://127.0.0.1:1234"), Principal: "peer"}
+
+	logHandshake("tls", c, nil)
+
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelDebug, Msg: "handshake", Attrs: map[string]any{
+			"uri":       "tcp://127.0.0.1:1234",
+			"transport": "tcp",
+			"security":  "tls",
+			"principal": "peer",
+		}},
+	}, h.records)
+}
+
+func TestLogHandshakeFailure(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+	c := &Conduit{RemoteURI: mustParse("tcp://127.0.0.1:1234")}
+
+	logHandshake("tls", c, assert.AnError)
+
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelWarn, Msg: "handshake failed", Attrs: map[string]any{
+			"uri":       "tcp://127.0.0.1:1234",
+			"transport": "tcp",
+			"security":  "tls",
+			"error":     assert.AnError,
+		}},
+	}, h.records)
+}
+
+func TestLogAcceptDisabled(t *testing.T) {
+	defer SetLogger(SetLogger(nil))
+
+	logAccept(AcceptFatal, assert.AnError)
+}
+
+func TestLogAcceptFatal(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+
+	logAccept(AcceptFatal, assert.AnError)
+
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelError, Msg: "accept failed", Attrs: map[string]any{
+			"reason": "fatal",
+			"error":  assert.AnError,
+		}},
+	}, h.records)
+}
+
+func TestLogAcceptRemote(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+	err := &AcceptError{Reason: AcceptHandshake, RemoteURI: mustParse("tcp://127.0.0.1:1234"), Err: assert.AnError}
+
+	logAccept(AcceptHandshake, err)
+
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelWarn, Msg: "accept failed", Attrs: map[string]any{
+			"reason": "handshake",
+			"error":  err,
+			"remote": "tcp://127.0.0.1:1234",
+		}},
+	}, h.records)
+}
+
+func TestURIDialLogged(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+		return mech
+	}).Install().Restore()
+	u := mustParse("tcp://127.0.0.1:1234")
+	mech.On("Dial", mock.Anything, nil, u, mock.Anything).Return(nil, assert.AnError)
+
+	_, err := u.Dial(context.Background(), nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelWarn, Msg: "dial failed", Attrs: map[string]any{
+			"uri":       "tcp://127.0.0.1:1234",
+			"transport": "tcp",
+			"error":     assert.AnError,
+		}},
+	}, h.records)
+}
+
+func TestURIResolveLogged(t *testing.T) {
+	h, restore := captureLogs()
+	defer restore()
+	disc := &mockDiscovery{}
+	u := mustParse("tcp.disc://example.com:1234")
+	result := mustParse("tcp://127.0.0.1:1234")
+	disc.On("Discover", mock.Anything, u).Return([]*URI{result}, nil)
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+
+	_, err := u.Resolve()
+
+	assert.NoError(t, err)
+	args := map[string]any{
+		"uri":       "tcp.disc://example.com:1234",
+		"transport": "tcp",
+		"discovery": "disc",
+	}
+	assert.Equal(t, []logRecord{
+		{Level: slog.LevelDebug, Msg: "discover", Attrs: merge(args, map[string]any{"count": int64(1)})},
+		{Level: slog.LevelDebug, Msg: "canonicalize", Attrs: merge(args, map[string]any{
+			"results": []string{"tcp://127.0.0.1:1234 (discovery via disc)"},
+		})},
+	}, h.records)
+}
+
+// merge merges maps, returning a new map.
+func merge(maps ...map[string]any) map[string]any {
+	result := map[string]any{}
+	for _, m := range maps {
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+
+	return result
+}
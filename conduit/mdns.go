@@ -0,0 +1,456 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Constants used by the mDNS discovery mechanism.
+const (
+	MDNSPort           = 5353                      // The mDNS port
+	MDNSDefaultTimeout = time.Second               // Default time to wait for responses
+	MDNSTTL            = 120                       // TTL of advertised records, in seconds
+	mdnsCacheFlush     = dnsmessage.Class(1 << 15) // Cache-flush bit of the class
+)
+
+// MDNSGroup is the IPv4 multicast group used by mDNS.
+var MDNSGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: MDNSPort}
+
+// mdnsName converts a host or service name into a fully qualified DNS
+// name.
+func mdnsName(name string) (dnsmessage.Name, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	return dnsmessage.NewName(name)
+}
+
+// mdnsQuery builds a PTR query for the specified service.
+func mdnsQuery(service string) ([]byte, error) {
+	name, err := mdnsName(service)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &dnsmessage.Message{
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+
+	return msg.Pack()
+}
+
+// mdnsRecords accumulates the records received in mDNS responses.
+type mdnsRecords struct {
+	instances map[string]bool                   // Service instance names
+	srvs      map[string]dnsmessage.SRVResource // SRV records by instance
+	addrs     map[string][]net.IP               // Addresses by host name
+	ttls      map[string]uint32                 // Minimum TTLs of SRV and address records by name
+}
+
+// newMDNSRecords constructs an empty mdnsRecords.
+func newMDNSRecords() *mdnsRecords {
+	return &mdnsRecords{
+		instances: map[string]bool{},
+		srvs:      map[string]dnsmessage.SRVResource{},
+		addrs:     map[string][]net.IP{},
+		ttls:      map[string]uint32{},
+	}
+}
+
+// ttl records the TTL of a record, keeping the minimum for each name.
+func (r *mdnsRecords) ttl(name string, ttl uint32) {
+	if old, ok := r.ttls[name]; !ok || ttl < old {
+		r.ttls[name] = ttl
+	}
+}
+
+// add parses an mDNS response and adds the relevant records.
+// Malformed responses are ignored.
+func (r *mdnsRecords) add(service string, data []byte) {
+	msg := &dnsmessage.Message{}
+	if err := msg.Unpack(data); err != nil || !msg.Response {
+		return
+	}
+
+	service = strings.ToLower(strings.TrimSuffix(service, ".") + ".")
+	records := append(msg.Answers, msg.Additionals...) //nolint:gocritic
+	for _, rr := range records {
+		name := strings.ToLower(rr.Header.Name.String())
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == service {
+				r.instances[strings.ToLower(body.PTR.String())] = true
+			}
+
+		case *dnsmessage.SRVResource:
+			r.srvs[name] = *body
+			r.ttls[name] = rr.Header.TTL
+
+		case *dnsmessage.AResource:
+			r.addrs[name] = append(r.addrs[name], net.IP(body.A[:]))
+			r.ttl(name, rr.Header.TTL)
+
+		case *dnsmessage.AAAAResource:
+			r.addrs[name] = append(r.addrs[name], net.IP(body.AAAA[:]))
+			r.ttl(name, rr.Header.TTL)
+		}
+	}
+}
+
+// uris assembles the list of canonical URIs from the accumulated
+// records.  The scheme of the resulting URIs is constructed from the
+// transport and security of the template URI.
+func (r *mdnsRecords) uris(tmpl *URI) []*URI {
+	return resolutionURIs(r.resolutions(tmpl))
+}
+
+// resolutions assembles the list of resolutions from the accumulated
+// records, attributing each URI to the SRV target it was derived
+// from.  The TTL of each resolution is the lesser of the TTLs of the
+// SRV record and the address records of its target.
+func (r *mdnsRecords) resolutions(tmpl *URI) []*Resolution {
+	scheme := tmpl.Transport
+	if tmpl.Security != "" {
+		scheme += "+" + tmpl.Security
+	}
+
+	// Sort the instances for a deterministic result
+	instances := make([]string, 0, len(r.instances))
+	for inst := range r.instances {
+		instances = append(instances, inst)
+	}
+	sort.Strings(instances)
+
+	result := []*Resolution{}
+	seen := map[string]bool{}
+	for _, inst := range instances {
+		srv, ok := r.srvs[inst]
+		if !ok {
+			continue
+		}
+
+		port := strconv.Itoa(int(srv.Port))
+		target := strings.ToLower(srv.Target.String())
+		ttl := min(r.ttls[inst], r.ttls[target])
+		for _, ip := range r.addrs[target] {
+			host := net.JoinHostPort(ip.String(), port)
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+
+			result = append(result, &Resolution{
+				URI: &URI{
+					URL: url.URL{
+						Scheme:   scheme,
+						Host:     host,
+						Path:     tmpl.Path,
+						RawQuery: tmpl.RawQuery,
+					},
+					Transport: tmpl.Transport,
+					Security:  tmpl.Security,
+				},
+				Source:    SourceSRV,
+				Name:      target,
+				Discovery: tmpl.Discovery,
+				TTL:       time.Duration(ttl) * time.Second,
+				Priority:  srv.Priority,
+				Weight:    srv.Weight,
+			})
+		}
+	}
+
+	return result
+}
+
+// MDNSDiscovery is a discovery mechanism using multicast DNS service
+// discovery.  The host portion of the URI gives the service name; for
+// instance, "tcp.mdns://_humboldt._tcp.local" canonicalizes to the
+// set of peers advertising the "_humboldt._tcp.local" service on the
+// local network.
+type MDNSDiscovery struct {
+	Timeout time.Duration // Time to wait for responses
+}
+
+// Discover is passed a URI and returns a list of canonical URIs
+// retrieved from the discovery mechanism.  The list may be in a
+// priority order, or may be in an arbitrary randomized order,
+// depending on the mechanism.
+func (d *MDNSDiscovery) Discover(ctx context.Context, u *URI) ([]*URI, error) {
+	res, err := d.Resolve(ctx, u)
+
+	return resolutionURIs(res), err
+}
+
+// Resolve is passed a URI and returns a list of resolutions
+// describing the canonical URIs retrieved from the discovery
+// mechanism.  Each is attributed to the SRV target it was derived
+// from.  Responses are collected until the timeout elapses or the
+// context's deadline passes, whichever is sooner; if the context is
+// cancelled, the lookup is abandoned.
+func (d *MDNSDiscovery) Resolve(ctx context.Context, u *URI) ([]*Resolution, error) {
+	service := u.Hostname()
+	query, err := mdnsQuery(service)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open a socket for the query; since the source port is not
+	// the mDNS port, responders reply directly to us
+	pc, err := mdnsListenPatch()
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	// Send the query
+	if _, err := pc.WriteTo(query, MDNSGroup); err != nil {
+		return nil, err
+	}
+
+	// Collect responses until the timeout
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = MDNSDefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := pc.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		pc.SetReadDeadline(time.Unix(1, 0)) //nolint:errcheck
+	})
+	defer stop()
+	records := newMDNSRecords()
+	buf := make([]byte, UDPMaxDatagram)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+
+		records.add(service, buf[:n])
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+
+	return records.resolutions(u), nil
+}
+
+// MDNSAdvertiser advertises a canonical URI via multicast DNS so that
+// it may be discovered by the mDNS discovery mechanism.
+type MDNSAdvertiser struct {
+	Service  string         // The service name
+	Instance string         // The instance name
+	URI      *URI           // The URI being advertised
+	PC       net.PacketConn // The multicast packet connection
+
+	done chan struct{} // Closed when the advertiser exits
+}
+
+// Advertise begins advertising a canonical URI under the specified
+// service and instance names; the instance name must be unique on
+// the local network, and is typically derived from the host name.
+// The advertiser responds to queries until closed.
+func Advertise(service, instance string, u *URI) (*MDNSAdvertiser, error) {
+	if !u.IsCanonical() || u.Host == "" {
+		return nil, fmt.Errorf("%s: %w", u, ErrNotCanonical)
+	}
+
+	pc, err := mdnsListenGroupPatch()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &MDNSAdvertiser{
+		Service:  service,
+		Instance: instance,
+		URI:      u,
+		PC:       pc,
+		done:     make(chan struct{}),
+	}
+	go a.serve()
+
+	return a, nil
+}
+
+// answer constructs the response to a query, returning nil if the
+// query is not for the advertised service.
+func (a *MDNSAdvertiser) answer(data []byte) ([]byte, error) {
+	msg := &dnsmessage.Message{}
+	if err := msg.Unpack(data); err != nil || msg.Response {
+		return nil, err
+	}
+
+	// Look for a question about the service
+	svcName, err := mdnsName(a.Service)
+	if err != nil {
+		return nil, err
+	}
+	var question *dnsmessage.Question
+	for i, q := range msg.Questions {
+		if strings.EqualFold(q.Name.String(), svcName.String()) &&
+			(q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+			question = &msg.Questions[i]
+			break
+		}
+	}
+	if question == nil {
+		return nil, nil
+	}
+
+	// Construct the names and address
+	instName, err := mdnsName(a.Instance + "." + a.Service)
+	if err != nil {
+		return nil, err
+	}
+	hostName, err := mdnsName(a.Instance + ".local")
+	if err != nil {
+		return nil, err
+	}
+	host, portStr, err := net.SplitHostPort(a.URI.Host)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	ip, _ := parseIP(host)
+	var addr dnsmessage.ResourceBody
+	addrType := dnsmessage.TypeA
+	if ip4 := ip.To4(); ip4 != nil {
+		a := &dnsmessage.AResource{}
+		copy(a.A[:], ip4)
+		addr = a
+	} else {
+		a := &dnsmessage.AAAAResource{}
+		copy(a.AAAA[:], ip.To16())
+		addr = a
+		addrType = dnsmessage.TypeAAAA
+	}
+
+	// Build the response
+	resp := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            msg.ID,
+			Response:      true,
+			Authoritative: true,
+		},
+		Questions: []dnsmessage.Question{*question},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{
+				Name:  svcName,
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET,
+				TTL:   MDNSTTL,
+			},
+			Body: &dnsmessage.PTRResource{PTR: instName},
+		}},
+		Additionals: []dnsmessage.Resource{
+			{
+				Header: dnsmessage.ResourceHeader{
+					Name:  instName,
+					Type:  dnsmessage.TypeSRV,
+					Class: dnsmessage.ClassINET | mdnsCacheFlush,
+					TTL:   MDNSTTL,
+				},
+				Body: &dnsmessage.SRVResource{
+					Target: hostName,
+					Port:   uint16(port),
+				},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{
+					Name:  hostName,
+					Type:  addrType,
+					Class: dnsmessage.ClassINET | mdnsCacheFlush,
+					TTL:   MDNSTTL,
+				},
+				Body: addr,
+			},
+		},
+	}
+
+	return resp.Pack()
+}
+
+// serve responds to queries received on the multicast socket.
+// Responses are sent directly to the querier.
+func (a *MDNSAdvertiser) serve() {
+	defer close(a.done)
+
+	buf := make([]byte, UDPMaxDatagram)
+	for {
+		n, addr, err := a.PC.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		resp, err := a.answer(buf[:n])
+		if err != nil || resp == nil {
+			continue
+		}
+		a.PC.WriteTo(resp, addr) //nolint:errcheck
+	}
+}
+
+// Close stops advertising the URI.
+func (a *MDNSAdvertiser) Close() error {
+	err := a.PC.Close()
+	<-a.done
+
+	return err
+}
+
+// mdnsListen opens a socket for sending mDNS queries.
+func mdnsListen() (net.PacketConn, error) {
+	return net.ListenUDP("udp4", &net.UDPAddr{})
+}
+
+// mdnsListenGroup opens a socket joined to the mDNS multicast
+// group.
+func mdnsListenGroup() (net.PacketConn, error) {
+	return net.ListenMulticastUDP("udp4", nil, MDNSGroup)
+}
+
+// init initializes the mDNS discovery mechanism.
+func init() {
+	RegisterDiscovery("mdns", &MDNSDiscovery{})
+}
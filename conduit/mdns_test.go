@@ -0,0 +1,371 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func mustParse(rawuri string) *URI {
+	u, err := Parse(rawuri)
+	if err != nil {
+		panic(err)
+	}
+
+	return u
+}
+
+func testMDNSQuery(t *testing.T, service string, qType dnsmessage.Type) []byte {
+	msg := &dnsmessage.Message{
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(service),
+			Type:  qType,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	data, err := msg.Pack()
+	require.NoError(t, err)
+
+	return data
+}
+
+func testMDNSAdvertiser(host string) *MDNSAdvertiser {
+	return &MDNSAdvertiser{
+		Service:  "_humboldt._tcp.local",
+		Instance: "node1",
+		URI: &URI{
+			URL:       url.URL{Host: host},
+			Transport: "tcp",
+			Security:  "tls",
+		},
+	}
+}
+
+func TestMDNSQuery(t *testing.T) {
+	result, err := mdnsQuery("_humboldt._tcp.local")
+
+	assert.NoError(t, err)
+	msg := &dnsmessage.Message{}
+	require.NoError(t, msg.Unpack(result))
+	assert.False(t, msg.Response)
+	assert.Equal(t, []dnsmessage.Question{{
+		Name:  dnsmessage.MustNewName("_humboldt._tcp.local."),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}}, msg.Questions)
+}
+
+func TestMDNSAdvertiserAnswerBase(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+	records := newMDNSRecords()
+
+	result, err := a.answer(testMDNSQuery(t, "_HUMBOLDT._tcp.local.", dnsmessage.TypePTR))
+	records.add("_humboldt._tcp.local", result)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{
+		{
+			URL:       mustParse("tcp+tls://10.0.0.1:1234").URL,
+			Transport: "tcp",
+			Security:  "tls",
+		},
+	}, records.uris(&URI{Transport: "tcp", Security: "tls"}))
+}
+
+func TestMDNSAdvertiserAnswerIPv6(t *testing.T) {
+	a := testMDNSAdvertiser("[fe80::1]:1234")
+	records := newMDNSRecords()
+
+	result, err := a.answer(testMDNSQuery(t, "_humboldt._tcp.local.", dnsmessage.TypeALL))
+	records.add("_humboldt._tcp.local.", result)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{
+		{
+			URL:       mustParse("tcp://[fe80::1]:1234").URL,
+			Transport: "tcp",
+		},
+	}, records.uris(&URI{Transport: "tcp"}))
+}
+
+func TestMDNSAdvertiserAnswerOtherService(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+
+	result, err := a.answer(testMDNSQuery(t, "_other._tcp.local.", dnsmessage.TypePTR))
+
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestMDNSAdvertiserAnswerOtherType(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+
+	result, err := a.answer(testMDNSQuery(t, "_humboldt._tcp.local.", dnsmessage.TypeA))
+
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestMDNSAdvertiserAnswerIgnoresResponses(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+	resp, err := a.answer(testMDNSQuery(t, "_humboldt._tcp.local.", dnsmessage.TypePTR))
+	require.NoError(t, err)
+
+	result, err := a.answer(resp)
+
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestMDNSAdvertiserAnswerMalformed(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+
+	result, err := a.answer([]byte("bogus"))
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestMDNSRecordsAddMalformed(t *testing.T) {
+	records := newMDNSRecords()
+
+	records.add("_humboldt._tcp.local", []byte("bogus"))
+
+	assert.Equal(t, newMDNSRecords(), records)
+}
+
+func TestMDNSRecordsURIsMissingSRV(t *testing.T) {
+	records := newMDNSRecords()
+	records.instances["node1._humboldt._tcp.local."] = true
+
+	result := records.uris(&URI{Transport: "tcp"})
+
+	assert.Equal(t, []*URI{}, result)
+}
+
+func TestMDNSDiscoveryImplementsDiscovery(t *testing.T) {
+	assert.Implements(t, (*Discovery)(nil), &MDNSDiscovery{})
+}
+
+func TestMDNSDiscoveryDiscoverBase(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+	resp, err := a.answer(testMDNSQuery(t, "_humboldt._tcp.local.", dnsmessage.TypePTR))
+	require.NoError(t, err)
+	pc := &mockPacketConn{}
+	pc.On("WriteTo", mock.Anything, MDNSGroup).Return(0, nil)
+	pc.On("SetReadDeadline", mock.Anything).Return(nil)
+	pc.On("ReadFrom", mock.Anything).Return(resp, nil, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return(resp, nil, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, os.ErrDeadlineExceeded)
+	pc.On("Close").Return(nil)
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	obj := &MDNSDiscovery{}
+
+	result, err := obj.Discover(context.Background(), mustParse("tcp+tls.mdns://_humboldt._tcp.local"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{
+		{
+			URL:       mustParse("tcp+tls://10.0.0.1:1234").URL,
+			Transport: "tcp",
+			Security:  "tls",
+		},
+	}, result)
+	pc.AssertExpectations(t)
+}
+
+func TestMDNSDiscoveryResolve(t *testing.T) {
+	a := testMDNSAdvertiser("10.0.0.1:1234")
+	resp, err := a.answer(testMDNSQuery(t, "_humboldt._tcp.local.", dnsmessage.TypePTR))
+	require.NoError(t, err)
+	pc := &mockPacketConn{}
+	pc.On("WriteTo", mock.Anything, MDNSGroup).Return(0, nil)
+	pc.On("SetReadDeadline", mock.Anything).Return(nil)
+	pc.On("ReadFrom", mock.Anything).Return(resp, nil, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, os.ErrDeadlineExceeded)
+	pc.On("Close").Return(nil)
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	obj := &MDNSDiscovery{}
+
+	result, err := obj.Resolve(context.Background(), mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{
+		{
+			URI: &URI{
+				URL:       mustParse("tcp://10.0.0.1:1234").URL,
+				Transport: "tcp",
+			},
+			Source:    SourceSRV,
+			Name:      "node1.local.",
+			Discovery: "mdns",
+			TTL:       MDNSTTL * time.Second,
+		},
+	}, result)
+	pc.AssertExpectations(t)
+}
+
+func TestMDNSDiscoveryResolveContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	pc := &mockPacketConn{}
+	pc.On("WriteTo", mock.Anything, MDNSGroup).Return(0, nil)
+	pc.On("SetReadDeadline", deadline).Return(nil)
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, os.ErrDeadlineExceeded)
+	pc.On("Close").Return(nil)
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	obj := &MDNSDiscovery{Timeout: 2 * time.Hour}
+
+	result, err := obj.Resolve(ctx, mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{}, result)
+	pc.AssertExpectations(t)
+}
+
+func TestMDNSDiscoveryResolveCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pc := &mockPacketConn{}
+	pc.On("WriteTo", mock.Anything, MDNSGroup).Return(0, nil)
+	pc.On("SetReadDeadline", mock.Anything).Return(nil)
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, os.ErrDeadlineExceeded)
+	pc.On("Close").Return(nil)
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	obj := &MDNSDiscovery{}
+
+	result, err := obj.Resolve(ctx, mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestMDNSDiscoveryDiscoverListenError(t *testing.T) {
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj := &MDNSDiscovery{}
+
+	result, err := obj.Discover(context.Background(), mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestMDNSDiscoveryDiscoverWriteError(t *testing.T) {
+	pc := &mockPacketConn{}
+	pc.On("WriteTo", mock.Anything, MDNSGroup).Return(0, assert.AnError)
+	pc.On("Close").Return(nil)
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	obj := &MDNSDiscovery{}
+
+	result, err := obj.Discover(context.Background(), mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	pc.AssertExpectations(t)
+}
+
+func TestMDNSDiscoveryDiscoverReadError(t *testing.T) {
+	pc := &mockPacketConn{}
+	pc.On("WriteTo", mock.Anything, MDNSGroup).Return(0, nil)
+	pc.On("SetReadDeadline", mock.Anything).Return(nil)
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, assert.AnError)
+	pc.On("Close").Return(nil)
+	defer patcher.SetVar(&mdnsListenPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	obj := &MDNSDiscovery{}
+
+	result, err := obj.Discover(context.Background(), mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	pc.AssertExpectations(t)
+}
+
+func TestAdvertiseBase(t *testing.T) {
+	query := testMDNSQuery(t, "_humboldt._tcp.local.", dnsmessage.TypePTR)
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4321}
+	closed := make(chan struct{})
+	pc := &mockPacketConn{}
+	pc.On("ReadFrom", mock.Anything).Return(query, addr, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return([]byte("bogus"), addr, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, net.ErrClosed).Run(func(args mock.Arguments) {
+		<-closed
+	})
+	pc.On("WriteTo", mock.Anything, addr).Return(0, nil).Once()
+	pc.On("Close").Return(nil).Run(func(args mock.Arguments) {
+		close(closed)
+	})
+	defer patcher.SetVar(&mdnsListenGroupPatch, func() (net.PacketConn, error) {
+		return pc, nil
+	}).Install().Restore()
+	u := mustParse("tcp://10.0.0.1:1234")
+
+	result, err := Advertise("_humboldt._tcp.local", "node1", u)
+	require.NoError(t, err)
+	err = result.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "_humboldt._tcp.local", result.Service)
+	assert.Equal(t, "node1", result.Instance)
+	assert.Same(t, u, result.URI)
+	pc.AssertExpectations(t)
+}
+
+func TestAdvertiseNotCanonical(t *testing.T) {
+	defer patcher.SetVar(&mdnsListenGroupPatch, func() (net.PacketConn, error) {
+		panic("unexpected call")
+	}).Install().Restore()
+
+	result, err := Advertise("_humboldt._tcp.local", "node1", mustParse("tcp.mdns://_humboldt._tcp.local"))
+
+	assert.ErrorIs(t, err, ErrNotCanonical)
+	assert.Nil(t, result)
+}
+
+func TestAdvertiseListenError(t *testing.T) {
+	defer patcher.SetVar(&mdnsListenGroupPatch, func() (net.PacketConn, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := Advertise("_humboldt._tcp.local", "node1", mustParse("tcp://10.0.0.1:1234"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
@@ -14,30 +14,151 @@
 
 package conduit
 
-import "context"
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// mechLock protects the mechanism registries.  Mechanisms are
+// normally registered at init time, but may be registered or
+// unregistered at any time by embedding applications.
+var mechLock sync.RWMutex
 
 // Discovery describes a discovery mechanism.
 type Discovery interface {
 	// Discover is passed a URI and returns a list of canonical
 	// URIs retrieved from the discovery mechanism.  The list may
 	// be in a priority order, or may be in an arbitrary
-	// randomized order, depending on the mechanism.
+	// randomized order, depending on the mechanism.  The lookup
+	// should be abandoned if the context is cancelled or its
+	// deadline passes.
+	Discover(ctx context.Context, u *URI) ([]*URI, error)
+}
+
+// LegacyDiscovery describes a discovery mechanism written before the
+// Discover method accepted a context.  Use AdaptDiscovery to register
+// it.
+type LegacyDiscovery interface {
+	// Discover is passed a URI and returns a list of canonical
+	// URIs retrieved from the discovery mechanism.
 	Discover(u *URI) ([]*URI, error)
 }
 
+// legacyResolver describes a discovery mechanism written before the
+// Resolve method of Resolver accepted a context.
+type legacyResolver interface {
+	Resolve(u *URI) ([]*Resolution, error)
+}
+
+// legacyDiscovery adapts a LegacyDiscovery to the Discovery and
+// Resolver interfaces.
+type legacyDiscovery struct {
+	d LegacyDiscovery // The legacy discovery mechanism
+}
+
+// AdaptDiscovery adapts a discovery mechanism written before the
+// Discover method accepted a context.  The lookup cannot be
+// interrupted, but if the context is cancelled or its deadline passes
+// first, the adapted Discover returns immediately with the context's
+// error, and the results of the lookup are discarded.  A Resolve
+// method taking no context is likewise adapted to Resolver.
+func AdaptDiscovery(d LegacyDiscovery) Discovery {
+	return &legacyDiscovery{d: d}
+}
+
+// callLegacy calls a legacy lookup, returning early if the context is
+// done first.  The lookup is not made at all if the context is already
+// done.
+func callLegacy[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	if ctx.Done() == nil {
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := f()
+		ch <- result{v: v, err: err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Discover is passed a URI and returns a list of canonical URIs
+// retrieved from the discovery mechanism.
+func (l *legacyDiscovery) Discover(ctx context.Context, u *URI) ([]*URI, error) {
+	return callLegacy(ctx, func() ([]*URI, error) {
+		return l.d.Discover(u)
+	})
+}
+
+// Resolve is passed a URI and returns a list of resolutions
+// describing the canonical URIs retrieved from the discovery
+// mechanism.  If the legacy mechanism does not implement Resolve,
+// the results of Discover are attributed to SourceDiscovery.
+func (l *legacyDiscovery) Resolve(ctx context.Context, u *URI) ([]*Resolution, error) {
+	if r, ok := l.d.(legacyResolver); ok {
+		return callLegacy(ctx, func() ([]*Resolution, error) {
+			return r.Resolve(u)
+		})
+	}
+
+	uris, err := l.Discover(ctx, u)
+
+	return discoveryResolutions(u.Discovery, uris), err
+}
+
 // discMechs is a registry of discovery mechanisms.
 var discMechs = map[string]Discovery{}
 
 // RegisterDiscovery registers a discovery mechanism.
 func RegisterDiscovery(name string, mech Discovery) {
+	mechLock.Lock()
+	defer mechLock.Unlock()
+
 	discMechs[name] = mech
 }
 
+// UnregisterDiscovery removes a discovery mechanism from the registry.
+func UnregisterDiscovery(name string) {
+	mechLock.Lock()
+	defer mechLock.Unlock()
+
+	delete(discMechs, name)
+}
+
 // LookupDiscovery is used to look up a discovery mechanism.
 func LookupDiscovery(name string) Discovery {
+	mechLock.RLock()
+	defer mechLock.RUnlock()
+
 	return discMechs[name]
 }
 
+// Discoveries returns the sorted names of the registered discovery
+// mechanisms.
+func Discoveries() []string {
+	mechLock.RLock()
+	defer mechLock.RUnlock()
+
+	return slices.Sorted(maps.Keys(discMechs))
+}
+
 // Mechanism describes a conduit transport or security mechanism.
 type Mechanism interface {
 	// Dial opens a conduit in active mode; that is, for
@@ -60,23 +181,69 @@ var secMechs = map[string]Mechanism{}
 
 // RegisterSecurity registers a security layer mechanism.
 func RegisterSecurity(name string, mech Mechanism) {
+	mechLock.Lock()
+	defer mechLock.Unlock()
+
 	secMechs[name] = mech
 }
 
+// UnregisterSecurity removes a security layer mechanism from the registry.
+func UnregisterSecurity(name string) {
+	mechLock.Lock()
+	defer mechLock.Unlock()
+
+	delete(secMechs, name)
+}
+
 // LookupSecurity is used to look up a security layer mechanism.
 func LookupSecurity(name string) Mechanism {
+	mechLock.RLock()
+	defer mechLock.RUnlock()
+
 	return secMechs[name]
 }
 
+// Securities returns the sorted names of the registered security layer
+// mechanisms.
+func Securities() []string {
+	mechLock.RLock()
+	defer mechLock.RUnlock()
+
+	return slices.Sorted(maps.Keys(secMechs))
+}
+
 // transMechs is a registry of transport mechanisms.
 var transMechs = map[string]Mechanism{}
 
 // RegisterTransport registers a transport mechanism.
 func RegisterTransport(name string, mech Mechanism) {
+	mechLock.Lock()
+	defer mechLock.Unlock()
+
 	transMechs[name] = mech
 }
 
+// UnregisterTransport removes a transport mechanism from the registry.
+func UnregisterTransport(name string) {
+	mechLock.Lock()
+	defer mechLock.Unlock()
+
+	delete(transMechs, name)
+}
+
 // LookupTransport is used to look up a transport mechanism.
 func LookupTransport(name string) Mechanism {
+	mechLock.RLock()
+	defer mechLock.RUnlock()
+
 	return transMechs[name]
 }
+
+// Transports returns the sorted names of the registered transport
+// mechanisms.
+func Transports() []string {
+	mechLock.RLock()
+	defer mechLock.RUnlock()
+
+	return slices.Sorted(maps.Keys(transMechs))
+}
@@ -17,6 +17,7 @@ package conduit
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/klmitch/patcher"
 	"github.com/stretchr/testify/assert"
@@ -27,7 +28,21 @@ type mockDiscovery struct {
 	mock.Mock
 }
 
-func (m *mockDiscovery) Discover(u *URI) ([]*URI, error) {
+func (m *mockDiscovery) Discover(ctx context.Context, u *URI) ([]*URI, error) {
+	args := m.MethodCalled("Discover", ctx, u)
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.([]*URI), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+type mockLegacyDiscovery struct {
+	mock.Mock
+}
+
+func (m *mockLegacyDiscovery) Discover(u *URI) ([]*URI, error) {
 	args := m.MethodCalled("Discover", u)
 
 	if tmp := args.Get(0); tmp != nil {
@@ -37,6 +52,97 @@ func (m *mockDiscovery) Discover(u *URI) ([]*URI, error) {
 	return nil, args.Error(1)
 }
 
+type mockLegacyResolver struct {
+	mockLegacyDiscovery
+}
+
+func (m *mockLegacyResolver) Resolve(u *URI) ([]*Resolution, error) {
+	args := m.MethodCalled("Resolve", u)
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.([]*Resolution), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+func TestAdaptDiscoveryDiscover(t *testing.T) {
+	u := mustParse("tcp.legacy://example.com:1234")
+	uris := []*URI{mustParse("tcp://127.0.0.1:1234")}
+	d := &mockLegacyDiscovery{}
+	d.On("Discover", u).Return(uris, assert.AnError)
+	obj := AdaptDiscovery(d)
+
+	result, err := obj.Discover(context.Background(), u)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, uris, result)
+	d.AssertExpectations(t)
+}
+
+func TestAdaptDiscoveryDiscoverCancelled(t *testing.T) {
+	u := mustParse("tcp.legacy://example.com:1234")
+	release := make(chan struct{})
+	defer close(release)
+	d := &mockLegacyDiscovery{}
+	d.On("Discover", u).Return(nil, nil).Run(func(args mock.Arguments) {
+		<-release
+	})
+	obj := AdaptDiscovery(d)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := obj.Discover(ctx, u)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestAdaptDiscoveryDiscoverDeadline(t *testing.T) {
+	u := mustParse("tcp.legacy://example.com:1234")
+	release := make(chan struct{})
+	defer close(release)
+	d := &mockLegacyDiscovery{}
+	d.On("Discover", u).Return(nil, nil).Run(func(args mock.Arguments) {
+		<-release
+	})
+	obj := AdaptDiscovery(d)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	result, err := obj.Discover(ctx, u)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+}
+
+func TestAdaptDiscoveryResolveLegacy(t *testing.T) {
+	u := mustParse("tcp.legacy://example.com:1234")
+	res := []*Resolution{{URI: mustParse("tcp://127.0.0.1:1234"), Source: SourceSRV}}
+	d := &mockLegacyResolver{}
+	d.On("Resolve", u).Return(res, nil)
+	obj := AdaptDiscovery(d).(Resolver)
+
+	result, err := obj.Resolve(context.Background(), u)
+
+	assert.NoError(t, err)
+	assert.Equal(t, res, result)
+	d.AssertExpectations(t)
+}
+
+func TestAdaptDiscoveryResolveDiscover(t *testing.T) {
+	u := mustParse("tcp.legacy://example.com:1234")
+	uris := []*URI{mustParse("tcp://127.0.0.1:1234")}
+	d := &mockLegacyDiscovery{}
+	d.On("Discover", u).Return(uris, nil)
+	obj := AdaptDiscovery(d).(Resolver)
+
+	result, err := obj.Resolve(context.Background(), u)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{{URI: uris[0], Source: SourceDiscovery, Discovery: "legacy"}}, result)
+}
+
 func TestRegisterDiscovery(t *testing.T) {
 	mech := &mockDiscovery{}
 	defer patcher.SetVar(&discMechs, map[string]Discovery{}).Install().Restore()
@@ -59,6 +165,32 @@ func TestLookupDiscovery(t *testing.T) {
 	assert.Same(t, mech, result)
 }
 
+func TestUnregisterDiscovery(t *testing.T) {
+	mech := &mockDiscovery{}
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"test":  mech,
+		"other": mech,
+	}).Install().Restore()
+
+	UnregisterDiscovery("test")
+
+	assert.Equal(t, map[string]Discovery{
+		"other": mech,
+	}, discMechs)
+}
+
+func TestDiscoveries(t *testing.T) {
+	mech := &mockDiscovery{}
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"test":  mech,
+		"other": mech,
+	}).Install().Restore()
+
+	result := Discoveries()
+
+	assert.Equal(t, []string{"other", "test"}, result)
+}
+
 type mockMechanism struct {
 	mock.Mock
 }
@@ -94,6 +226,32 @@ func TestRegisterSecurity(t *testing.T) {
 	}, secMechs)
 }
 
+func TestUnregisterSecurity(t *testing.T) {
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&secMechs, map[string]Mechanism{
+		"test":  mech,
+		"other": mech,
+	}).Install().Restore()
+
+	UnregisterSecurity("test")
+
+	assert.Equal(t, map[string]Mechanism{
+		"other": mech,
+	}, secMechs)
+}
+
+func TestSecurities(t *testing.T) {
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&secMechs, map[string]Mechanism{
+		"test":  mech,
+		"other": mech,
+	}).Install().Restore()
+
+	result := Securities()
+
+	assert.Equal(t, []string{"other", "test"}, result)
+}
+
 func TestLookupSecurity(t *testing.T) {
 	mech := &mockMechanism{}
 	defer patcher.SetVar(&secMechs, map[string]Mechanism{
@@ -116,6 +274,32 @@ func TestRegisterTransport(t *testing.T) {
 	}, transMechs)
 }
 
+func TestUnregisterTransport(t *testing.T) {
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&transMechs, map[string]Mechanism{
+		"test":  mech,
+		"other": mech,
+	}).Install().Restore()
+
+	UnregisterTransport("test")
+
+	assert.Equal(t, map[string]Mechanism{
+		"other": mech,
+	}, transMechs)
+}
+
+func TestTransports(t *testing.T) {
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&transMechs, map[string]Mechanism{
+		"test":  mech,
+		"other": mech,
+	}).Install().Restore()
+
+	result := Transports()
+
+	assert.Equal(t, []string{"other", "test"}, result)
+}
+
 func TestLookupTransport(t *testing.T) {
 	mech := &mockMechanism{}
 	defer patcher.SetVar(&transMechs, map[string]Mechanism{
@@ -126,3 +310,24 @@ func TestLookupTransport(t *testing.T) {
 
 	assert.Same(t, mech, result)
 }
+
+func TestRegistryConcurrent(t *testing.T) {
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&transMechs, map[string]Mechanism{}).Install().Restore()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			RegisterTransport("test", mech)
+			UnregisterTransport("test")
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		LookupTransport("test")
+		Transports()
+	}
+	<-done
+
+	assert.Equal(t, map[string]Mechanism{}, transMechs)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// MemAddr is the address of an endpoint of the in-memory transport.
+type MemAddr string
+
+// Network returns the name of the network.
+func (a MemAddr) Network() string {
+	return "mem"
+}
+
+// String returns the string form of the address.
+func (a MemAddr) String() string {
+	return string(a)
+}
+
+// MemAddr2URI converts an address of the in-memory transport into an
+// appropriate URI.
+func MemAddr2URI(addr net.Addr) *URI {
+	return &URI{
+		URL: url.URL{
+			Scheme: "mem",
+			Opaque: addr.String(),
+		},
+		Transport: "mem",
+	}
+}
+
+// memNames allocates names for anonymous endpoints.
+var memNames atomic.Uint64
+
+// memName returns the address named by a URI of the in-memory
+// transport, allocating a new name if the URI does not name one.
+// The name is given as the opaque part of the URI, as in "mem:node1".
+func memName(u *URI) MemAddr {
+	if u.Opaque != "" {
+		return MemAddr(u.Opaque)
+	}
+
+	return MemAddr(strconv.FormatUint(memNames.Add(1), 10))
+}
+
+// memRegistry is the registry of listeners of the in-memory
+// transport, by address.
+var (
+	memRegistry = map[MemAddr]*MemListener{}
+	memLock     sync.Mutex
+)
+
+// lookupMem looks up the listener at an address.
+func lookupMem(addr MemAddr) *MemListener {
+	memLock.Lock()
+	defer memLock.Unlock()
+
+	return memRegistry[addr]
+}
+
+// memConn is an implementation of net.Conn for one end of an
+// in-memory pipe.
+type memConn struct {
+	net.Conn
+
+	local  MemAddr // Address of this end of the pipe
+	remote MemAddr // Address of the other end of the pipe
+}
+
+// LocalAddr returns the local network address.
+func (c *memConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the remote network address.
+func (c *memConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// MemMech is a mechanism for the in-memory transport.  Conduits are
+// carried over synchronous in-memory pipes between listeners and
+// dialers in the same process, allowing tests and simulations to
+// create conduits without using real sockets.  Listeners are named
+// by URIs such as "mem:node1"; listening on "mem:" allocates a new
+// name.
+type MemMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m MemMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	remote := MemAddr(u.Opaque)
+	l := lookupMem(remote)
+	if l == nil {
+		return nil, fmt.Errorf("%s: %w", u, ErrNoListener)
+	}
+
+	// Connect to the listener
+	local := memName(&URI{})
+	c1, c2 := net.Pipe()
+	cli := &memConn{Conn: c1, local: local, remote: remote}
+	srv := &memConn{Conn: c2, local: remote, remote: local}
+	select {
+	case l.conns <- srv:
+	case <-l.done:
+		return nil, fmt.Errorf("%s: %w", u, ErrNoListener)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return &Conduit{
+		State:     Active,
+		LocalURI:  MemAddr2URI(local),
+		RemoteURI: u,
+		Link:      cli,
+	}, nil
+}
+
+// Listen opens a transport in passive mode; that is, for
+// connection-oriented transports, Listen creates a listener that may
+// accept connections.  For those transports that are not
+// connection-oriented, the listener synthesizes the appropriate
+// state.
+func (m MemMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	addr := memName(u)
+	l := &MemListener{
+		URI:   MemAddr2URI(addr),
+		conns: make(chan *memConn),
+		done:  make(chan struct{}),
+	}
+
+	// Register the listener
+	memLock.Lock()
+	defer memLock.Unlock()
+	if _, ok := memRegistry[addr]; ok {
+		return nil, fmt.Errorf("%s: %w", u, ErrAddressInUse)
+	}
+	memRegistry[addr] = l
+
+	return l, nil
+}
+
+// MemListener is an implementation of Listener for the in-memory
+// transport.
+type MemListener struct {
+	URI *URI // URI contains the URI of the listener
+
+	conns chan *memConn // Connections from dialers
+	done  chan struct{} // Closed when the listener is closed
+	once  sync.Once     // Ensures the listener is closed only once
+}
+
+// Accept waits for and returns the next conduit to the listener.
+func (l *MemListener) Accept() (*Conduit, error) {
+	select {
+	case c := <-l.conns:
+		result := &Conduit{
+			State:     Passive,
+			LocalURI:  l.URI,
+			RemoteURI: MemAddr2URI(c.remote),
+			Link:      c,
+		}
+		audit(AuditAccept, result)
+
+		return result, nil
+
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors, and the address of the listener is
+// released.
+func (l *MemListener) Close() error {
+	l.once.Do(func() {
+		memLock.Lock()
+		defer memLock.Unlock()
+
+		addr := MemAddr(l.URI.Opaque)
+		if memRegistry[addr] == l {
+			delete(memRegistry, addr)
+		}
+		close(l.done)
+	})
+
+	return nil
+}
+
+// Addr returns the listener's network URI.
+func (l *MemListener) Addr() *URI {
+	return l.URI
+}
+
+// init initializes the in-memory transport.
+func init() {
+	RegisterTransport("mem", MemMech(0))
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemAddrImplementsAddr(t *testing.T) {
+	assert.Implements(t, (*net.Addr)(nil), MemAddr(""))
+}
+
+func TestMemAddr(t *testing.T) {
+	obj := MemAddr("node1")
+
+	assert.Equal(t, "mem", obj.Network())
+	assert.Equal(t, "node1", obj.String())
+}
+
+func TestMemAddr2URI(t *testing.T) {
+	result := MemAddr2URI(MemAddr("node1"))
+
+	assert.Equal(t, &URI{
+		URL: url.URL{
+			Scheme: "mem",
+			Opaque: "node1",
+		},
+		Transport: "mem",
+	}, result)
+	assert.Equal(t, "mem:node1", result.String())
+	assert.True(t, result.IsCanonical())
+}
+
+func TestMemNameNamed(t *testing.T) {
+	result := memName(mustParse("mem:node1"))
+
+	assert.Equal(t, MemAddr("node1"), result)
+}
+
+func TestMemNameAnonymous(t *testing.T) {
+	result1 := memName(mustParse("mem:"))
+	result2 := memName(mustParse("mem:"))
+
+	assert.NotEqual(t, MemAddr(""), result1)
+	assert.NotEqual(t, result1, result2)
+}
+
+func TestMemMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), MemMech(0))
+}
+
+func TestMemMechListenBase(t *testing.T) {
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{}).Install().Restore()
+
+	result, err := MemMech(0).Listen(context.Background(), nil, mustParse("mem:node1"), nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, "mem:node1", result.Addr().String())
+	assert.Same(t, result, memRegistry["node1"])
+}
+
+func TestMemMechListenInUse(t *testing.T) {
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{
+		"node1": {},
+	}).Install().Restore()
+
+	result, err := MemMech(0).Listen(context.Background(), nil, mustParse("mem:node1"), nil)
+
+	assert.ErrorIs(t, err, ErrAddressInUse)
+	assert.Nil(t, result)
+}
+
+func TestMemMechDialBase(t *testing.T) {
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{}).Install().Restore()
+	l, err := MemMech(0).Listen(context.Background(), nil, mustParse("mem:node1"), nil)
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan *Conduit, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	u := mustParse("mem:node1")
+
+	result, err := MemMech(0).Dial(context.Background(), nil, u, nil)
+
+	require.NoError(t, err)
+	defer result.Link.Close()
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+	assert.Equal(t, Active, result.State)
+	assert.Same(t, u, result.RemoteURI)
+	assert.Equal(t, Passive, srv.State)
+	assert.Equal(t, result.LocalURI, srv.RemoteURI)
+	assert.Same(t, l.Addr(), srv.LocalURI)
+	assert.Equal(t, MemAddr("node1"), result.Link.RemoteAddr())
+	assert.Equal(t, result.Link.LocalAddr(), srv.Link.RemoteAddr())
+	go result.Link.Write([]byte("test")) //nolint:errcheck
+	buf := make([]byte, 4)
+	_, err = srv.Link.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test"), buf)
+}
+
+func TestMemMechDialNoListener(t *testing.T) {
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{}).Install().Restore()
+
+	result, err := MemMech(0).Dial(context.Background(), nil, mustParse("mem:node1"), nil)
+
+	assert.ErrorIs(t, err, ErrNoListener)
+	assert.Nil(t, result)
+}
+
+func TestMemMechDialClosed(t *testing.T) {
+	l := &MemListener{URI: mustParse("mem:node1"), done: make(chan struct{})}
+	close(l.done)
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{
+		"node1": l,
+	}).Install().Restore()
+
+	result, err := MemMech(0).Dial(context.Background(), nil, mustParse("mem:node1"), nil)
+
+	assert.ErrorIs(t, err, ErrNoListener)
+	assert.Nil(t, result)
+}
+
+func TestMemMechDialCancelled(t *testing.T) {
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{
+		"node1": {URI: mustParse("mem:node1"), done: make(chan struct{})},
+	}).Install().Restore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := MemMech(0).Dial(ctx, nil, mustParse("mem:node1"), nil)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestMemListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &MemListener{})
+}
+
+func TestMemListenerClose(t *testing.T) {
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{}).Install().Restore()
+	l, err := MemMech(0).Listen(context.Background(), nil, mustParse("mem:node1"), nil)
+	require.NoError(t, err)
+
+	assert.NoError(t, l.Close())
+	assert.NoError(t, l.Close())
+
+	assert.NotContains(t, memRegistry, MemAddr("node1"))
+	result, err := l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, result)
+}
+
+func TestMemListenerCloseReplaced(t *testing.T) {
+	other := &MemListener{}
+	defer patcher.SetVar(&memRegistry, map[MemAddr]*MemListener{
+		"node1": other,
+	}).Install().Restore()
+	obj := &MemListener{URI: mustParse("mem:node1"), done: make(chan struct{})}
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.Same(t, other, memRegistry["node1"])
+}
@@ -0,0 +1,425 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Defaults for Mux.
+const (
+	DefaultChannelWindow = 256 * 1024 // Default receive window of each channel, in bytes
+	DefaultAcceptBacklog = 16         // Default number of channels awaiting Accept
+)
+
+// signal wakes a goroutine waiting on a channel of capacity 1,
+// without blocking.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Mux multiplexes several independent channels over a single
+// conduit.  Each PDU sent over a channel carries a channel extension
+// identifying the channel, and each channel has a flow-control window
+// limiting the payload bytes the peer may send before the receiver
+// has consumed them, so that a channel whose receiver is slow cannot
+// block the others.  Channels opened by the initiator of the conduit
+// have odd identifiers, and those opened by the other side have even
+// ones, so that both sides may open channels at the same time.
+//
+// The mux reads from the conduit, so the conduit must not be read
+// directly once the mux is started; PDUs received without a channel
+// extension are passed to OnFrame.  Window updates are sent through
+// the conduit's send queue, which must not be configured to discard
+// frames.  The exported fields must be set before calling Start.
+type Mux struct {
+	Conduit   *Conduit                 // The conduit to multiplex
+	Initiator bool                     // True on the side that dialed the conduit
+	Window    uint32                   // Receive window of each channel; defaults to DefaultChannelWindow
+	Backlog   int                      // Channels awaiting Accept; defaults to DefaultAcceptBacklog
+	OnFrame   func(frame *proto.Frame) // Receives PDUs without a channel extension; may be nil
+
+	lock     sync.Mutex          // Protects the state
+	channels map[uint32]*Channel // Open channels, by identifier
+	next     uint32              // Identifier of the next channel opened
+	pending  []*Channel          // Channels opened by the peer, awaiting Accept
+	accepted chan struct{}       // Signaled when a channel is opened by the peer
+	done     chan struct{}       // Closed when the mux shuts down
+	err      error               // Error causing the shutdown
+	reader   chan struct{}       // Closed when the reader exits
+}
+
+// Channel is a channel multiplexed over a conduit by a Mux.
+type Channel struct {
+	m          *Mux           // The mux
+	id         uint32         // Identifier of the channel
+	credit     uint32         // Payload bytes that may be sent
+	peerWindow uint32         // Receive window of the peer, once known
+	frames     []*proto.Frame // Frames received, awaiting Recv
+	avail      uint32         // Payload bytes the peer may send
+	consumed   uint32         // Bytes received since the last window update
+	sentClose  bool           // The local side has closed the channel
+	recvClose  bool           // The peer has closed the channel
+	readable   chan struct{}  // Signaled when frames arrive or the peer closes
+	writable   chan struct{}  // Signaled when credit is granted
+}
+
+// window returns the receive window of each channel.
+func (m *Mux) window() uint32 {
+	if m.Window == 0 {
+		return DefaultChannelWindow
+	}
+
+	return m.Window
+}
+
+// backlog returns the number of channels that may await Accept.
+func (m *Mux) backlog() int {
+	if m.Backlog <= 0 {
+		return DefaultAcceptBacklog
+	}
+
+	return m.Backlog
+}
+
+// Start starts reading from the conduit.
+func (m *Mux) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.done != nil {
+		return
+	}
+	m.channels = map[uint32]*Channel{}
+	m.next = 2
+	if m.Initiator {
+		m.next = 1
+	}
+	m.accepted = make(chan struct{}, 1)
+	m.done = make(chan struct{})
+	m.reader = make(chan struct{})
+
+	go m.read()
+}
+
+// newChannel constructs a channel and registers it.  Must be called
+// with the lock held.
+func (m *Mux) newChannel(id uint32) *Channel {
+	ch := &Channel{
+		m:        m,
+		id:       id,
+		avail:    m.window(),
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+	m.channels[id] = ch
+
+	return ch
+}
+
+// shutdown shuts down the mux with the specified error, waking all
+// the goroutines waiting on it.
+func (m *Mux) shutdown(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.err == nil {
+		m.err = err
+		close(m.done)
+	}
+}
+
+// read reads and dispatches PDUs until the conduit fails.
+func (m *Mux) read() {
+	defer close(m.reader)
+
+	for {
+		f, err := m.Conduit.Recv()
+		if err != nil {
+			m.shutdown(err)
+			return
+		}
+
+		payload, ext, err := proto.StripChannel(f)
+		if err != nil {
+			if m.OnFrame != nil {
+				m.OnFrame(f)
+			}
+			continue
+		}
+		reply, err := m.handle(payload, ext)
+		if err != nil {
+			m.shutdown(err)
+			m.Conduit.CloseWithReason(err) //nolint:errcheck
+			return
+		} else if reply != nil {
+			m.enqueue(reply)
+		}
+	}
+}
+
+// handle handles a PDU received over a channel, returning the channel
+// control PDU to send in reply, if any.  An error is returned if the
+// peer violates the flow control.
+func (m *Mux) handle(f *proto.Frame, ext *proto.Channel) (*proto.Channel, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ch := m.channels[ext.ID]
+	switch {
+	case ext.Flags&proto.ChannelOpen != 0:
+		if ch != nil || (ext.ID%2 == 1) == m.Initiator {
+			return nil, nil
+		}
+		if len(m.pending) >= m.backlog() {
+			return &proto.Channel{ID: ext.ID, Flags: proto.ChannelClose}, nil
+		}
+		ch = m.newChannel(ext.ID)
+		ch.credit = ext.Window
+		ch.peerWindow = ext.Window
+		m.pending = append(m.pending, ch)
+		signal(m.accepted)
+		return &proto.Channel{ID: ext.ID, Flags: proto.ChannelWindow, Window: ch.avail}, nil
+
+	case ch == nil:
+		// Ignore PDUs for channels which have been closed
+
+	case ext.Flags&(proto.ChannelWindow|proto.ChannelClose) != 0:
+		if ext.Flags&proto.ChannelWindow != 0 {
+			if ch.peerWindow == 0 {
+				ch.peerWindow = ext.Window
+			}
+			ch.credit += ext.Window
+			signal(ch.writable)
+		}
+		if ext.Flags&proto.ChannelClose != 0 {
+			ch.recvClose = true
+			signal(ch.readable)
+			signal(ch.writable)
+			m.release(ch)
+		}
+
+	case ch.recvClose:
+		// Ignore data sent after the peer closed the channel
+
+	default:
+		size := uint32(len(f.Payload))
+		if size > ch.avail {
+			return nil, ErrChannelWindow
+		}
+		ch.avail -= size
+		ch.frames = append(ch.frames, f)
+		signal(ch.readable)
+	}
+
+	return nil, nil
+}
+
+// enqueue queues a channel control PDU for sending.
+func (m *Mux) enqueue(ext *proto.Channel) {
+	m.Conduit.Enqueue(context.Background(), ext.Frame()) //nolint:errcheck
+}
+
+// release forgets a channel once both sides have closed it and its
+// frames have been received.  Must be called with the lock held.
+func (m *Mux) release(ch *Channel) {
+	if ch.sentClose && ch.recvClose && len(ch.frames) == 0 {
+		delete(m.channels, ch.id)
+	}
+}
+
+// Open opens a channel.  Frames may be sent over the channel once the
+// peer has granted it a window.
+func (m *Mux) Open() (*Channel, error) {
+	m.lock.Lock()
+	if m.done == nil {
+		m.lock.Unlock()
+		return nil, ErrMuxClosed
+	} else if m.err != nil {
+		err := m.err
+		m.lock.Unlock()
+		return nil, err
+	}
+	ch := m.newChannel(m.next)
+	m.next += 2
+	m.lock.Unlock()
+
+	if err := m.Conduit.Send((&proto.Channel{ID: ch.id, Flags: proto.ChannelOpen, Window: ch.avail}).Frame()); err != nil {
+		m.lock.Lock()
+		delete(m.channels, ch.id)
+		m.lock.Unlock()
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Accept waits for and returns the next channel opened by the peer.
+func (m *Mux) Accept(ctx context.Context) (*Channel, error) {
+	for {
+		m.lock.Lock()
+		if m.done == nil {
+			m.lock.Unlock()
+			return nil, ErrMuxClosed
+		}
+		if len(m.pending) > 0 {
+			ch := m.pending[0]
+			m.pending = m.pending[1:]
+			m.lock.Unlock()
+			return ch, nil
+		}
+		err, done, accepted := m.err, m.done, m.accepted
+		m.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-accepted:
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close shuts down the mux and closes the conduit.  Operations on the
+// channels return ErrMuxClosed.
+func (m *Mux) Close() error {
+	m.lock.Lock()
+	reader := m.reader
+	m.lock.Unlock()
+	if reader == nil {
+		return nil
+	}
+
+	m.shutdown(ErrMuxClosed)
+	err := m.Conduit.Close()
+	<-reader
+
+	return err
+}
+
+// ID returns the identifier of the channel.
+func (ch *Channel) ID() uint32 {
+	return ch.id
+}
+
+// Send sends a frame over the channel, waiting until the peer has
+// granted enough window for its payload.  If the payload is larger
+// than the peer's receive window, ErrChannelWindow is returned; if
+// the peer refused the channel, ErrChannelClosed is returned.  The
+// passed-in frame is not modified.
+func (ch *Channel) Send(ctx context.Context, frame *proto.Frame) error {
+	m := ch.m
+	size := uint32(len(frame.Payload))
+	for {
+		m.lock.Lock()
+		switch {
+		case m.err != nil:
+			err := m.err
+			m.lock.Unlock()
+			return err
+		case ch.sentClose || (ch.recvClose && ch.peerWindow == 0):
+			m.lock.Unlock()
+			return ErrChannelClosed
+		case ch.peerWindow > 0 && size > ch.peerWindow:
+			m.lock.Unlock()
+			return ErrChannelWindow
+		case ch.credit >= size && ch.peerWindow > 0:
+			ch.credit -= size
+			m.lock.Unlock()
+			return m.Conduit.Send(proto.AddChannel(frame, &proto.Channel{ID: ch.id}))
+		}
+		m.lock.Unlock()
+
+		select {
+		case <-ch.writable:
+		case <-m.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Recv receives the next frame sent by the peer over the channel.
+// Once the peer has closed the channel and all its frames have been
+// received, io.EOF is returned.  Receiving frames grants the peer
+// further window.
+func (ch *Channel) Recv(ctx context.Context) (*proto.Frame, error) {
+	m := ch.m
+	for {
+		m.lock.Lock()
+		if len(ch.frames) > 0 {
+			f := ch.frames[0]
+			ch.frames = ch.frames[1:]
+			ch.consumed += uint32(len(f.Payload))
+			var grant uint32
+			if ch.consumed >= m.window()/2 {
+				grant, ch.consumed = ch.consumed, 0
+				ch.avail += grant
+			}
+			m.release(ch)
+			m.lock.Unlock()
+
+			if grant > 0 {
+				m.enqueue(&proto.Channel{ID: ch.id, Flags: proto.ChannelWindow, Window: grant})
+			}
+			return f, nil
+		}
+		recvClose, err := ch.recvClose, m.err
+		m.lock.Unlock()
+		if recvClose {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ch.readable:
+		case <-m.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes the local side of the channel: no further frames may
+// be sent, but frames sent by the peer may still be received until
+// the peer closes its side.
+func (ch *Channel) Close() error {
+	m := ch.m
+	m.lock.Lock()
+	if ch.sentClose {
+		m.lock.Unlock()
+		return nil
+	} else if m.err != nil {
+		err := m.err
+		m.lock.Unlock()
+		return err
+	}
+	ch.sentClose = true
+	m.release(ch)
+	m.lock.Unlock()
+
+	return m.Conduit.Send((&proto.Channel{ID: ch.id, Flags: proto.ChannelClose}).Frame())
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// muxPair constructs and starts a pair of muxes over the ends of a
+// pipe, with the specified receive window and accept backlog.
+func muxPair(t *testing.T, window uint32, backlog int) (*Mux, *Mux) {
+	local, remote := net.Pipe()
+	m1 := &Mux{Conduit: &Conduit{Link: local, Integrity: true}, Initiator: true, Window: window, Backlog: backlog}
+	m2 := &Mux{Conduit: &Conduit{Link: remote, Integrity: true}, Window: window, Backlog: backlog}
+	m1.Start()
+	m2.Start()
+	t.Cleanup(func() {
+		m1.Close()
+		m2.Close()
+	})
+
+	return m1, m2
+}
+
+// muxOpen opens a channel on the first mux and accepts it on the
+// second.
+func muxOpen(t *testing.T, m1, m2 *Mux) (*Channel, *Channel) {
+	ch1, err := m1.Open()
+	require.NoError(t, err)
+	ch2, err := m2.Accept(context.Background())
+	require.NoError(t, err)
+
+	return ch1, ch2
+}
+
+// muxTimeout returns a context which expires shortly.
+func muxTimeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+func TestMuxWindowDefault(t *testing.T) {
+	obj := &Mux{}
+
+	result := obj.window()
+
+	assert.Equal(t, uint32(DefaultChannelWindow), result)
+}
+
+func TestMuxWindowSet(t *testing.T) {
+	obj := &Mux{Window: 1024}
+
+	result := obj.window()
+
+	assert.Equal(t, uint32(1024), result)
+}
+
+func TestMuxBacklogDefault(t *testing.T) {
+	obj := &Mux{}
+
+	result := obj.backlog()
+
+	assert.Equal(t, DefaultAcceptBacklog, result)
+}
+
+func TestMuxBacklogSet(t *testing.T) {
+	obj := &Mux{Backlog: 3}
+
+	result := obj.backlog()
+
+	assert.Equal(t, 3, result)
+}
+
+func TestMuxOpenBase(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+
+	ch1, err1 := m1.Open()
+	ch2, err2 := m2.Open()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, uint32(1), ch1.ID())
+	assert.Equal(t, uint32(2), ch2.ID())
+	acc2, err := m2.Accept(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), acc2.ID())
+	acc1, err := m1.Accept(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), acc1.ID())
+}
+
+func TestMuxOpenNotStarted(t *testing.T) {
+	obj := &Mux{}
+
+	result, err := obj.Open()
+
+	assert.ErrorIs(t, err, ErrMuxClosed)
+	assert.Nil(t, result)
+}
+
+func TestMuxOpenClosed(t *testing.T) {
+	m1, _ := muxPair(t, 1024, 0)
+	m1.Close()
+
+	result, err := m1.Open()
+
+	assert.ErrorIs(t, err, ErrMuxClosed)
+	assert.Nil(t, result)
+}
+
+func TestMuxAcceptNotStarted(t *testing.T) {
+	obj := &Mux{}
+
+	result, err := obj.Accept(context.Background())
+
+	assert.ErrorIs(t, err, ErrMuxClosed)
+	assert.Nil(t, result)
+}
+
+func TestMuxAcceptTimeout(t *testing.T) {
+	_, m2 := muxPair(t, 1024, 0)
+
+	result, err := m2.Accept(muxTimeout(t))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+}
+
+func TestMuxAcceptPeerClosed(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	m1.Close()
+
+	result, err := m2.Accept(context.Background())
+
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, result)
+}
+
+func TestMuxAcceptBacklog(t *testing.T) {
+	m1, _ := muxPair(t, 1024, 1)
+	ch1, err := m1.Open()
+	require.NoError(t, err)
+	refused, err := m1.Open()
+	require.NoError(t, err)
+
+	err = refused.Send(context.Background(), bundleFrame("abc"))
+
+	assert.ErrorIs(t, err, ErrChannelClosed)
+	assert.NoError(t, ch1.Send(context.Background(), bundleFrame("abc")))
+}
+
+func TestMuxOnFrame(t *testing.T) {
+	local, remote := net.Pipe()
+	frames := make(chan *proto.Frame, 1)
+	obj := &Mux{
+		Conduit: &Conduit{Link: local, Integrity: true},
+		OnFrame: func(frame *proto.Frame) {
+			frames <- frame
+		},
+	}
+	obj.Start()
+	defer obj.Close()
+	peer := &Conduit{Link: remote, Integrity: true}
+	defer remote.Close()
+
+	err := peer.Send(bundleFrame("abc"))
+
+	assert.NoError(t, err)
+	f := <-frames
+	assert.Equal(t, []byte("abc"), f.Payload)
+}
+
+func TestMuxHandleWindowViolation(t *testing.T) {
+	local, remote := net.Pipe()
+	obj := &Mux{Conduit: &Conduit{Link: local, Integrity: true}, Window: 4}
+	obj.Start()
+	defer obj.Close()
+	peer := &Conduit{Link: remote, Integrity: true}
+	defer remote.Close()
+	frames := make(chan *proto.Frame, 1)
+	go func() {
+		var last *proto.Frame
+		for {
+			f, err := peer.Recv()
+			if err != nil {
+				frames <- last
+				return
+			}
+			last = f
+		}
+	}()
+	require.NoError(t, peer.Send((&proto.Channel{ID: 1, Flags: proto.ChannelOpen, Window: 4}).Frame()))
+	ch, err := obj.Accept(context.Background())
+	require.NoError(t, err)
+
+	peer.Send(proto.AddChannel(bundleFrame("abcdef"), &proto.Channel{ID: 1})) //nolint:errcheck
+
+	_, err = ch.Recv(context.Background())
+	assert.ErrorIs(t, err, ErrChannelWindow)
+	assertCloseFrame(t, ErrChannelWindow.Error(), <-frames)
+}
+
+func TestMuxClose(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	ch1, ch2 := muxOpen(t, m1, m2)
+
+	err := m1.Close()
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, ch1.Send(context.Background(), bundleFrame("abc")), ErrMuxClosed)
+	_, err = ch2.Recv(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMuxCloseNotStarted(t *testing.T) {
+	obj := &Mux{}
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+}
+
+func TestChannelSendRecv(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	ch1, ch2 := muxOpen(t, m1, m2)
+
+	err1 := ch1.Send(context.Background(), bundleFrame("ping"))
+	f1, rerr1 := ch2.Recv(context.Background())
+	err2 := ch2.Send(context.Background(), bundleFrame("pong"))
+	f2, rerr2 := ch1.Recv(context.Background())
+
+	assert.NoError(t, err1)
+	assert.NoError(t, rerr1)
+	assert.Equal(t, uint8(0x17), f1.Protocol())
+	assert.Equal(t, []byte("ping"), f1.Payload)
+	assert.NoError(t, err2)
+	assert.NoError(t, rerr2)
+	assert.Equal(t, []byte("pong"), f2.Payload)
+}
+
+func TestChannelSendBlocked(t *testing.T) {
+	m1, m2 := muxPair(t, 8, 0)
+	ch1, ch2 := muxOpen(t, m1, m2)
+	require.NoError(t, ch1.Send(context.Background(), bundleFrame("abcdef")))
+
+	err := ch1.Send(muxTimeout(t), bundleFrame("abcdef"))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = ch2.Recv(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, ch1.Send(context.Background(), bundleFrame("abcdef")))
+}
+
+func TestChannelSendIndependent(t *testing.T) {
+	m1, m2 := muxPair(t, 8, 0)
+	slow, _ := muxOpen(t, m1, m2)
+	fast1, fast2 := muxOpen(t, m1, m2)
+	require.NoError(t, slow.Send(context.Background(), bundleFrame("abcdefgh")))
+
+	err := fast1.Send(context.Background(), bundleFrame("abc"))
+
+	assert.NoError(t, err)
+	f, err := fast2.Recv(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), f.Payload)
+}
+
+func TestChannelSendTooLarge(t *testing.T) {
+	m1, m2 := muxPair(t, 8, 0)
+	ch1, _ := muxOpen(t, m1, m2)
+
+	err := ch1.Send(context.Background(), bundleFrame("abcdefghi"))
+
+	assert.ErrorIs(t, err, ErrChannelWindow)
+}
+
+func TestChannelSendClosed(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	ch1, _ := muxOpen(t, m1, m2)
+	require.NoError(t, ch1.Close())
+
+	err := ch1.Send(context.Background(), bundleFrame("abc"))
+
+	assert.ErrorIs(t, err, ErrChannelClosed)
+}
+
+func TestChannelRecvTimeout(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	_, ch2 := muxOpen(t, m1, m2)
+
+	result, err := ch2.Recv(muxTimeout(t))
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+}
+
+func TestChannelCloseHalf(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	ch1, ch2 := muxOpen(t, m1, m2)
+	require.NoError(t, ch1.Send(context.Background(), bundleFrame("abc")))
+
+	err := ch1.Close()
+
+	assert.NoError(t, err)
+	f, err := ch2.Recv(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), f.Payload)
+	_, err = ch2.Recv(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, ch2.Send(context.Background(), bundleFrame("def")))
+	f, err = ch1.Recv(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("def"), f.Payload)
+}
+
+func TestChannelCloseRelease(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	ch1, ch2 := muxOpen(t, m1, m2)
+	require.NoError(t, ch1.Close())
+	_, err := ch2.Recv(context.Background())
+	require.ErrorIs(t, err, io.EOF)
+
+	err = ch2.Close()
+
+	assert.NoError(t, err)
+	m2.lock.Lock()
+	assert.NotContains(t, m2.channels, ch2.ID())
+	m2.lock.Unlock()
+	_, err = ch1.Recv(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Eventually(t, func() bool {
+		m1.lock.Lock()
+		defer m1.lock.Unlock()
+		return len(m1.channels) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestChannelCloseTwice(t *testing.T) {
+	m1, m2 := muxPair(t, 1024, 0)
+	ch1, _ := muxOpen(t, m1, m2)
+	require.NoError(t, ch1.Close())
+
+	err := ch1.Close()
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// DefaultNegotiateTimeout is the time allowed for the protocol 0
+// negotiation if the conduit does not set NegotiateTimeout.
+const DefaultNegotiateTimeout = 10 * time.Second
+
+// Negotiate runs the protocol 0 negotiation over a new conduit, which
+// must be in the Active or Passive state.  On success, MinProto and
+// MaxProto are set to the range of versions supported by the peer,
+// Proto is set to the selected version, Major is set to the selected
+// major version of the wire format, which is used for the PDUs
+// exchanged from then on, Peer is set to the node ID of the peer, and
+// the conduit transitions to the Open state.  On failure, the conduit
+// transitions to the Error state, and the error is saved in the Error
+// field.  The negotiation is bound to the conduit's Binding, and
+// Bound is set if the peer's channel binding proof was verified.  The
+// negotiation must complete within the conduit's NegotiateTimeout.
+func (c *Conduit) Negotiate(n *proto.Negotiator) error {
+	if c.State != Active && c.State != Passive {
+		return fmt.Errorf("state %d: %w", c.State, ErrBadState)
+	}
+
+	// Bound the negotiation
+	timeout := c.NegotiateTimeout
+	if timeout <= 0 {
+		timeout = DefaultNegotiateTimeout
+	}
+	c.Link.SetDeadline(timeNow().Add(timeout)) //nolint:errcheck
+	defer c.Link.SetDeadline(time.Time{})      //nolint:errcheck
+
+	// Run the negotiation
+	defer TraceSlow(SlowNegotiate, c, "")()
+	r, w := c.framers()
+	bound := *n
+	bound.Binding = c.Binding
+	result, err := bound.NegotiateFrames(r, w)
+	if err != nil {
+		c.State = Error
+		c.Error = err
+		c.cancelContext(err)
+		return err
+	}
+
+	// Update the conduit
+	c.MinProto = result.Peer.MinProto
+	c.MaxProto = result.Peer.MaxProto
+	c.Proto = result.Proto
+	c.Major = result.Major
+	r.SetMajors(result.Major, result.Major)
+	w.SetMajor(result.Major)
+	c.Peer = result.Peer.NodeID
+	c.Bound = result.Bound
+	c.State = Open
+
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/ed25519"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestConduitNegotiateBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{State: Active, Link: c1}
+	peer := &proto.Negotiator{MinProto: 1, MaxProto: 4, NodeID: proto.NodeID{2}}
+	go peer.Negotiate(c2) //nolint:errcheck
+
+	err := obj.Negotiate(&proto.Negotiator{MinProto: 0, MaxProto: 2, NodeID: proto.NodeID{1}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Open, obj.State)
+	assert.NoError(t, obj.Error)
+	assert.Equal(t, uint32(1), obj.MinProto)
+	assert.Equal(t, uint32(4), obj.MaxProto)
+	assert.Equal(t, uint32(2), obj.Proto)
+	assert.Equal(t, proto.NodeID{2}, obj.Peer)
+	assert.Same(t, c1, obj.Link)
+}
+
+func TestConduitNegotiateMajor(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{State: Active, Link: c1}
+	peer := &proto.Negotiator{MaxProto: 1, MaxMajor: 3, NodeID: proto.NodeID{2}}
+	go peer.Negotiate(c2) //nolint:errcheck
+
+	err := obj.Negotiate(&proto.Negotiator{MaxProto: 1, NodeID: proto.NodeID{1}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Open, obj.State)
+	assert.Equal(t, uint8(0), obj.Major)
+}
+
+func TestConduitNegotiateBadState(t *testing.T) {
+	obj := &Conduit{State: Open}
+
+	err := obj.Negotiate(&proto.Negotiator{})
+
+	assert.ErrorIs(t, err, ErrBadState)
+	assert.Equal(t, &Conduit{State: Open}, obj)
+}
+
+func TestConduitNegotiateError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	obj := &Conduit{State: Passive, Link: c1}
+	peer := &proto.Negotiator{MinProto: 3, MaxProto: 4}
+	go func() {
+		peer.Negotiate(c2) //nolint:errcheck
+		c2.Close()
+	}()
+
+	err := obj.Negotiate(&proto.Negotiator{MinProto: 0, MaxProto: 2})
+
+	assert.ErrorIs(t, err, proto.ErrNoCommonVersion)
+	assert.Equal(t, Error, obj.State)
+	assert.Same(t, err, obj.Error)
+}
+
+func TestConduitNegotiateTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go io.Copy(io.Discard, c2) //nolint:errcheck
+	obj := &Conduit{State: Active, Link: c1, NegotiateTimeout: 10 * time.Millisecond}
+
+	err := obj.Negotiate(&proto.Negotiator{MaxProto: 1})
+
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Equal(t, Error, obj.State)
+}
+
+func TestConduitNegotiateBound(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	keys := proto.Ed25519Keys{proto.NodeID{1}: pub, proto.NodeID{2}: pub}
+	obj := &Conduit{State: Active, Link: c1, Binding: []byte("binding")}
+	peer := &proto.Negotiator{
+		MaxProto: 1,
+		NodeID:   proto.NodeID{2},
+		Binding:  []byte("binding"),
+		Signer:   proto.Ed25519Signer(priv),
+		Verifier: keys,
+	}
+	go peer.Negotiate(c2) //nolint:errcheck
+	n := &proto.Negotiator{MaxProto: 1, NodeID: proto.NodeID{1}, Signer: proto.Ed25519Signer(priv), Verifier: keys}
+
+	err := obj.Negotiate(n)
+
+	assert.NoError(t, err)
+	assert.True(t, obj.Bound)
+	assert.Nil(t, n.Binding)
+}
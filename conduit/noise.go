@@ -0,0 +1,244 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// DefaultNoiseHandshakeTimeout is the time allowed for a conduit to
+// complete the Noise handshake.
+const DefaultNoiseHandshakeTimeout = 10 * time.Second
+
+// NoiseConfig is the configuration for the Noise security layer.  It
+// must be returned by Config.ForSecurity("noise").
+type NoiseConfig struct {
+	Static   *ecdh.PrivateKey // Local static X25519 key; required
+	Peers    [][]byte         // Static public keys of allowed peers; if empty, any peer is allowed
+	Prologue []byte           // Prologue bound into the handshake; must match the peer's
+	Timeout  time.Duration    // Handshake timeout
+}
+
+// noiseConfig retrieves the Noise configuration.
+func noiseConfig(config Config) (*NoiseConfig, error) {
+	if config == nil {
+		return nil, fmt.Errorf("noise: %w", ErrMissingConfig)
+	}
+	nc, ok := config.ForSecurity("noise").(*NoiseConfig)
+	if !ok || nc == nil || nc.Static == nil || nc.Static.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("noise: %w", ErrMissingConfig)
+	}
+
+	return nc, nil
+}
+
+// allowed tests if a peer static key is allowed by the configuration.
+func (nc *NoiseConfig) allowed(key *ecdh.PublicKey) bool {
+	if len(nc.Peers) == 0 {
+		return true
+	}
+	for _, peer := range nc.Peers {
+		if string(peer) == string(key.Bytes()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NoiseFingerprint returns the fingerprint of a Noise static public
+// key, as used for the Principal of a Noise conduit.
+func NoiseFingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// noiseHandshakeConduit runs the Noise handshake over a conduit
+// established by the underlying transport, replacing its link with
+// the encrypted connection and setting its security properties.
+func noiseHandshakeConduit(ctx context.Context, c *Conduit, nc *NoiseConfig, initiator bool) error {
+	// Abort the handshake if the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		c.Link.SetDeadline(time.Unix(1, 0)) //nolint:errcheck
+	})
+	defer stop()
+
+	// Exchange the handshake messages
+	hs := newNoiseHandshake(initiator, nc.Static, nc.Prologue)
+	for idx := 0; idx < 3; idx++ {
+		if (idx%2 == 0) == initiator {
+			msg, err := hs.writeMessage(idx)
+			if err != nil {
+				return err
+			}
+			if err := writeSealed(c.Link, msg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		msg, err := readSealed(c.Link, c.Boundaries)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: %w", ErrNoiseHandshake, err)
+		}
+		if err := hs.readMessage(idx, msg); err != nil {
+			return err
+		}
+	}
+	if !stop() {
+		return ctx.Err()
+	}
+
+	// Check the peer
+	if !nc.allowed(hs.rs) {
+		return fmt.Errorf("noise: %s: %w", NoiseFingerprint(hs.rs.Bytes()), ErrNoisePeer)
+	}
+
+	// Update the conduit
+	send, recv := hs.split()
+	c.Link = &aeadConn{
+		Conn:       c.Link,
+		boundaries: c.Boundaries,
+		recv:       recv,
+		send:       send,
+	}
+	c.Confidential = true
+	c.Integrity = true
+	c.Strength = 128
+	c.Principal = NoiseFingerprint(hs.rs.Bytes())
+	c.Binding = hs.h
+
+	return nil
+}
+
+// NoiseMech is a security layer mechanism implementing the Noise XX
+// handshake over any underlying transport.  Both sides authenticate
+// using the static keys of their configurations.
+type NoiseMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m NoiseMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	nc, err := noiseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mech := lookupTransport(u.Transport)
+	if mech == nil {
+		return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
+	}
+
+	// Dial the transport and run the handshake
+	c, err := dialTransport(ctx, mech, config, u, opts)
+	if err != nil {
+		return nil, err
+	}
+	hctx, cancel := handshakeContext(ctx, opts, nc.Timeout, DefaultNoiseHandshakeTimeout)
+	defer cancel()
+	err = traceSpan(hctx, "handshake", u, func(ctx context.Context) error {
+		return noiseHandshakeConduit(ctx, c, nc, true)
+	})
+	if err != nil {
+		closeLink(c)
+		return nil, err
+	}
+	c.LocalURI = securityURI(c.LocalURI, "noise")
+
+	return c, nil
+}
+
+// Listen opens a transport in passive mode; that is, for
+// connection-oriented transports, Listen creates a listener that may
+// accept connections.  For those transports that are not
+// connection-oriented, the listener synthesizes the appropriate
+// state.
+func (m NoiseMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	nc, err := noiseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenTransport(ctx, config, u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NoiseListener{
+		L:      l,
+		Config: nc,
+	}, nil
+}
+
+// NoiseListener is an implementation of Listener for the Noise
+// security layer.
+type NoiseListener struct {
+	L      Listener     // Underlying transport listener
+	Config *NoiseConfig // The Noise configuration
+}
+
+// Accept waits for and returns the next conduit to the listener.
+// Conduits failing the handshake are closed, and an AcceptError
+// describing the failure is returned.
+func (l *NoiseListener) Accept() (*Conduit, error) {
+	timeout := l.Config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultNoiseHandshakeTimeout
+	}
+
+	c, err := l.L.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the handshake
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err = noiseHandshakeConduit(ctx, c, l.Config, false)
+	cancel()
+	logHandshake("noise", c, err)
+	if err != nil {
+		closeLink(c)
+		return nil, acceptError(c, err)
+	}
+	c.LocalURI = securityURI(c.LocalURI, "noise")
+	c.RemoteURI = securityURI(c.RemoteURI, "noise")
+
+	return c, nil
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors.
+func (l *NoiseListener) Close() error {
+	return l.L.Close()
+}
+
+// Addr returns the listener's network URI.
+func (l *NoiseListener) Addr() *URI {
+	return securityURI(l.L.Addr(), "noise")
+}
+
+// init initializes the Noise security layer.
+func init() {
+	RegisterSecurity("noise", NoiseMech(0))
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Constants for the Noise protocol.
+const (
+	NoiseProtocol = "Noise_XX_25519_ChaChaPoly_SHA256" // Protocol name
+	NoiseKeySize  = 32                                 // Size of keys
+)
+
+// noiseHKDF implements the HKDF function of the Noise protocol,
+// returning two outputs.
+func noiseHKDF(ck, ikm []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{0x01})
+	out1 := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1)
+	mac.Write([]byte{0x02})
+
+	return out1, mac.Sum(nil)
+}
+
+// noiseHandshake is the HandshakeState of the Noise protocol for the
+// XX pattern:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+type noiseHandshake struct {
+	cipher    aeadCipher       // The cipher state
+	ck        []byte           // The chaining key
+	h         []byte           // The handshake hash
+	initiator bool             // Flag indicating this side initiates
+	s         *ecdh.PrivateKey // The local static key
+	e         *ecdh.PrivateKey // The local ephemeral key
+	rs        *ecdh.PublicKey  // The remote static key
+	re        *ecdh.PublicKey  // The remote ephemeral key
+}
+
+// newNoiseHandshake initializes a handshake.
+func newNoiseHandshake(initiator bool, s *ecdh.PrivateKey, prologue []byte) *noiseHandshake {
+	h := make([]byte, sha256.Size)
+	copy(h, NoiseProtocol)
+	hs := &noiseHandshake{
+		ck:        append([]byte(nil), h...),
+		h:         h,
+		initiator: initiator,
+		s:         s,
+	}
+	hs.mixHash(prologue)
+
+	return hs
+}
+
+// mixHash mixes data into the handshake hash.
+func (hs *noiseHandshake) mixHash(data []byte) {
+	sum := sha256.New()
+	sum.Write(hs.h)
+	sum.Write(data)
+	hs.h = sum.Sum(nil)
+}
+
+// mixKey mixes key material into the chaining key and sets the key.
+func (hs *noiseHandshake) mixKey(ikm []byte) {
+	ck, k := noiseHKDF(hs.ck, ikm)
+	hs.ck = ck
+	copy(hs.cipher.k[:], k)
+	hs.cipher.hasKey = true
+	hs.cipher.n = 0
+}
+
+// dh performs a Diffie-Hellman operation and mixes in the result.
+func (hs *noiseHandshake) dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) error {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNoiseHandshake, err)
+	}
+	hs.mixKey(secret)
+
+	return nil
+}
+
+// encryptAndHash encrypts the plaintext and mixes the ciphertext into
+// the handshake hash.
+func (hs *noiseHandshake) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := hs.cipher.encrypt(hs.h, plaintext)
+	hs.mixHash(ciphertext)
+
+	return ciphertext
+}
+
+// decryptAndHash decrypts the ciphertext and mixes it into the
+// handshake hash.
+func (hs *noiseHandshake) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := hs.cipher.decrypt(hs.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixHash(ciphertext)
+
+	return plaintext, nil
+}
+
+// readKey reads a public key from the front of a message, decrypting
+// it if a key is set.
+func (hs *noiseHandshake) readKey(msg []byte, encrypted bool) (*ecdh.PublicKey, []byte, error) {
+	size := NoiseKeySize
+	if encrypted {
+		size += aeadTagSize
+	}
+	if len(msg) < size {
+		return nil, nil, fmt.Errorf("%w: %w", ErrNoiseHandshake, ErrShortMessage)
+	}
+
+	var raw []byte
+	if encrypted {
+		var err error
+		if raw, err = hs.decryptAndHash(msg[:size]); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		raw = msg[:size]
+		hs.mixHash(raw)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrNoiseHandshake, err)
+	}
+
+	return key, msg[size:], nil
+}
+
+// writeMessage produces the next handshake message.  The index is 0,
+// 1, or 2, and must alternate appropriately with readMessage.
+func (hs *noiseHandshake) writeMessage(idx int) ([]byte, error) {
+	var msg []byte
+	switch idx {
+	case 0: // -> e
+		var err error
+		if hs.e, err = ecdh.X25519().GenerateKey(randReader); err != nil {
+			return nil, err
+		}
+		msg = append(msg, hs.e.PublicKey().Bytes()...)
+		hs.mixHash(hs.e.PublicKey().Bytes())
+
+	case 1: // <- e, ee, s, es
+		var err error
+		if hs.e, err = ecdh.X25519().GenerateKey(randReader); err != nil {
+			return nil, err
+		}
+		msg = append(msg, hs.e.PublicKey().Bytes()...)
+		hs.mixHash(hs.e.PublicKey().Bytes())
+		if err := hs.dh(hs.e, hs.re); err != nil {
+			return nil, err
+		}
+		msg = append(msg, hs.encryptAndHash(hs.s.PublicKey().Bytes())...)
+		if err := hs.dh(hs.s, hs.re); err != nil {
+			return nil, err
+		}
+
+	case 2: // -> s, se
+		msg = append(msg, hs.encryptAndHash(hs.s.PublicKey().Bytes())...)
+		if err := hs.dh(hs.s, hs.re); err != nil {
+			return nil, err
+		}
+	}
+
+	// Add the (empty) payload
+	return append(msg, hs.encryptAndHash(nil)...), nil
+}
+
+// readMessage processes the next handshake message from the peer.
+func (hs *noiseHandshake) readMessage(idx int, msg []byte) error {
+	var err error
+	switch idx {
+	case 0: // -> e
+		if hs.re, msg, err = hs.readKey(msg, false); err != nil {
+			return err
+		}
+
+	case 1: // <- e, ee, s, es
+		if hs.re, msg, err = hs.readKey(msg, false); err != nil {
+			return err
+		}
+		if err := hs.dh(hs.e, hs.re); err != nil {
+			return err
+		}
+		if hs.rs, msg, err = hs.readKey(msg, true); err != nil {
+			return err
+		}
+		if err := hs.dh(hs.e, hs.rs); err != nil {
+			return err
+		}
+
+	case 2: // -> s, se
+		if hs.rs, msg, err = hs.readKey(msg, true); err != nil {
+			return err
+		}
+		if err := hs.dh(hs.e, hs.rs); err != nil {
+			return err
+		}
+	}
+
+	// Process the payload
+	_, err = hs.decryptAndHash(msg)
+
+	return err
+}
+
+// split returns the cipher states for sending and receiving once the
+// handshake is complete.
+func (hs *noiseHandshake) split() (*aeadCipher, *aeadCipher) {
+	k1, k2 := noiseHKDF(hs.ck, nil)
+	c1 := &aeadCipher{hasKey: true}
+	copy(c1.k[:], k1)
+	c2 := &aeadCipher{hasKey: true}
+	copy(c2.k[:], k2)
+
+	if hs.initiator {
+		return c1, c2
+	}
+
+	return c2, c1
+}
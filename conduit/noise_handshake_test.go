@@ -0,0 +1,126 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/ecdh"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noiseKey generates a static key for tests.
+func noiseKey(t *testing.T) *ecdh.PrivateKey {
+	key, err := ecdh.X25519().GenerateKey(randReader)
+	require.NoError(t, err)
+
+	return key
+}
+
+// runNoiseHandshake runs a handshake between two handshake states,
+// passing each message through the tamper function.
+func runNoiseHandshake(init, resp *noiseHandshake, tamper func(idx int, msg []byte)) error {
+	sides := []*noiseHandshake{init, resp}
+	for idx := 0; idx < 3; idx++ {
+		msg, err := sides[idx%2].writeMessage(idx)
+		if err != nil {
+			return err
+		}
+		if tamper != nil {
+			tamper(idx, msg)
+		}
+		if err := sides[(idx+1)%2].readMessage(idx, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestNoiseHKDF(t *testing.T) {
+	out1, out2 := noiseHKDF([]byte("chaining key"), []byte("input"))
+	out3, out4 := noiseHKDF([]byte("chaining key"), []byte("input"))
+
+	assert.Len(t, out1, 32)
+	assert.Len(t, out2, 32)
+	assert.NotEqual(t, out1, out2)
+	assert.Equal(t, out1, out3)
+	assert.Equal(t, out2, out4)
+}
+
+func TestNoiseHandshakeBase(t *testing.T) {
+	initKey, respKey := noiseKey(t), noiseKey(t)
+	init := newNoiseHandshake(true, initKey, []byte("prologue"))
+	resp := newNoiseHandshake(false, respKey, []byte("prologue"))
+
+	err := runNoiseHandshake(init, resp, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, respKey.PublicKey().Bytes(), init.rs.Bytes())
+	assert.Equal(t, initKey.PublicKey().Bytes(), resp.rs.Bytes())
+	assert.Equal(t, init.h, resp.h)
+	initSend, initRecv := init.split()
+	respSend, respRecv := resp.split()
+	assert.Equal(t, initSend.k, respRecv.k)
+	assert.Equal(t, initRecv.k, respSend.k)
+	assert.NotEqual(t, initSend.k, initRecv.k)
+}
+
+func TestNoiseHandshakeMessageSizes(t *testing.T) {
+	init := newNoiseHandshake(true, noiseKey(t), nil)
+	resp := newNoiseHandshake(false, noiseKey(t), nil)
+	sizes := []int{}
+
+	err := runNoiseHandshake(init, resp, func(idx int, msg []byte) {
+		sizes = append(sizes, len(msg))
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{32, 96, 64}, sizes)
+}
+
+func TestNoiseHandshakePrologueMismatch(t *testing.T) {
+	init := newNoiseHandshake(true, noiseKey(t), []byte("one"))
+	resp := newNoiseHandshake(false, noiseKey(t), []byte("two"))
+
+	err := runNoiseHandshake(init, resp, nil)
+
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestNoiseHandshakeTampered(t *testing.T) {
+	for idx := 0; idx < 3; idx++ {
+		init := newNoiseHandshake(true, noiseKey(t), nil)
+		resp := newNoiseHandshake(false, noiseKey(t), nil)
+
+		err := runNoiseHandshake(init, resp, func(i int, msg []byte) {
+			if i == idx {
+				msg[len(msg)-1] ^= 0x01
+			}
+		})
+
+		assert.Error(t, err, "message %d", idx)
+	}
+}
+
+func TestNoiseHandshakeShortMessage(t *testing.T) {
+	resp := newNoiseHandshake(false, noiseKey(t), nil)
+
+	err := resp.readMessage(0, []byte{1, 2, 3})
+
+	assert.ErrorIs(t, err, ErrNoiseHandshake)
+	assert.ErrorIs(t, err, ErrShortMessage)
+}
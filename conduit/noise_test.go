@@ -0,0 +1,224 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoiseMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), NoiseMech(0))
+}
+
+func TestNoiseListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &NoiseListener{})
+}
+
+func TestNoiseConfigBase(t *testing.T) {
+	nc := &NoiseConfig{Static: noiseKey(t)}
+
+	result, err := noiseConfig(securityCfg("noise", nc))
+
+	assert.NoError(t, err)
+	assert.Same(t, nc, result)
+}
+
+func TestNoiseConfigNil(t *testing.T) {
+	result, err := noiseConfig(nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestNoiseConfigMissingKey(t *testing.T) {
+	result, err := noiseConfig(securityCfg("noise", &NoiseConfig{}))
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestNoiseConfigAllowed(t *testing.T) {
+	key1, key2 := noiseKey(t), noiseKey(t)
+	open := &NoiseConfig{}
+	obj := &NoiseConfig{Peers: [][]byte{key1.PublicKey().Bytes()}}
+
+	assert.True(t, open.allowed(key2.PublicKey()))
+	assert.True(t, obj.allowed(key1.PublicKey()))
+	assert.False(t, obj.allowed(key2.PublicKey()))
+}
+
+func TestNoiseFingerprint(t *testing.T) {
+	result := NoiseFingerprint([]byte("key"))
+
+	assert.Equal(t, "sha256:2c70e12b7a0646f92279f427c7b38e7334d8e5389cff167a1dc30e73f826b683", result)
+}
+
+func TestNoiseHandshakeConduitBase(t *testing.T) {
+	initKey, respKey := noiseKey(t), noiseKey(t)
+
+	init, resp, initErr, respErr := handshakePair(noiseHandshakeConduit, &NoiseConfig{Static: initKey}, &NoiseConfig{Static: respKey})
+	defer init.Link.Close()
+	defer resp.Link.Close()
+
+	require.NoError(t, initErr)
+	require.NoError(t, respErr)
+	assert.True(t, init.Confidential)
+	assert.True(t, init.Integrity)
+	assert.Equal(t, uint32(128), init.Strength)
+	assert.Equal(t, NoiseFingerprint(respKey.PublicKey().Bytes()), init.Principal)
+	assert.Equal(t, NoiseFingerprint(initKey.PublicKey().Bytes()), resp.Principal)
+	assert.Len(t, init.Binding, 32)
+	assert.Equal(t, init.Binding, resp.Binding)
+
+	// Exchange data, including a write larger than a single message
+	data := []byte(strings.Repeat("0123456789", 10000))
+	go init.Link.Write(data) //nolint:errcheck
+	buf := make([]byte, len(data))
+	_, err := io.ReadFull(resp.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf)
+}
+
+func TestNoiseHandshakeConduitPeerRejected(t *testing.T) {
+	initKey, respKey := noiseKey(t), noiseKey(t)
+	respCfg := &NoiseConfig{Static: respKey, Peers: [][]byte{noiseKey(t).PublicKey().Bytes()}}
+
+	_, resp, _, respErr := handshakePair(noiseHandshakeConduit, &NoiseConfig{Static: initKey}, respCfg)
+
+	assert.ErrorIs(t, respErr, ErrNoisePeer)
+	assert.Empty(t, resp.Principal)
+	assert.False(t, resp.Confidential)
+}
+
+func TestNoiseHandshakeConduitPrologueMismatch(t *testing.T) {
+	initCfg := &NoiseConfig{Static: noiseKey(t), Prologue: []byte("one")}
+	respCfg := &NoiseConfig{Static: noiseKey(t), Prologue: []byte("two")}
+
+	_, _, initErr, respErr := handshakePair(noiseHandshakeConduit, initCfg, respCfg)
+
+	assert.ErrorIs(t, initErr, ErrDecrypt)
+	assert.Error(t, respErr)
+}
+
+func TestNoiseHandshakeConduitCancelled(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{State: Passive, Link: c1}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := noiseHandshakeConduit(ctx, obj, &NoiseConfig{Static: noiseKey(t)}, false)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNoiseMechDialListen(t *testing.T) {
+	cliKey, srvKey := noiseKey(t), noiseKey(t)
+	srvCfg := securityCfg("noise", &NoiseConfig{Static: srvKey, Peers: [][]byte{cliKey.PublicKey().Bytes()}})
+	cliCfg := securityCfg("noise", &NoiseConfig{Static: cliKey, Peers: [][]byte{srvKey.PublicKey().Bytes()}})
+	u, _ := Parse("tcp+noise://127.0.0.1:0")
+	l, err := NoiseMech(0).Listen(context.Background(), srvCfg, u, nil)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, "tcp+noise", l.Addr().Scheme)
+	accepted := make(chan *Conduit, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+		close(accepted)
+	}()
+
+	cli, err := NoiseMech(0).Dial(context.Background(), cliCfg, l.Addr(), nil)
+
+	require.NoError(t, err)
+	defer cli.Link.Close()
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+	assert.Equal(t, NoiseFingerprint(srvKey.PublicKey().Bytes()), cli.Principal)
+	assert.Equal(t, NoiseFingerprint(cliKey.PublicKey().Bytes()), srv.Principal)
+	assert.Equal(t, "tcp+noise", srv.LocalURI.Scheme)
+	assert.Equal(t, "tcp+noise", srv.RemoteURI.Scheme)
+	cli.Link.Write([]byte("hello")) //nolint:errcheck
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(srv.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), buf)
+}
+
+func TestNoiseMechListenRejected(t *testing.T) {
+	cliKey, srvKey := noiseKey(t), noiseKey(t)
+	srvCfg := securityCfg("noise", &NoiseConfig{Static: srvKey, Peers: [][]byte{srvKey.PublicKey().Bytes()}})
+	cliCfg := securityCfg("noise", &NoiseConfig{Static: cliKey})
+	u, _ := Parse("tcp+noise://127.0.0.1:0")
+	l, err := NoiseMech(0).Listen(context.Background(), srvCfg, u, nil)
+	require.NoError(t, err)
+	defer l.Close()
+	failed := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		failed <- err
+	}()
+
+	cli, err := NoiseMech(0).Dial(context.Background(), cliCfg, l.Addr(), nil)
+	if err == nil {
+		defer cli.Link.Close()
+	}
+
+	err = <-failed
+	assert.ErrorIs(t, err, ErrNoisePeer)
+	assert.Equal(t, AcceptRejected, ClassifyAccept(err))
+}
+func TestNoiseMechDialMissingConfig(t *testing.T) {
+	u, _ := Parse("tcp+noise://127.0.0.1:1234")
+
+	result, err := NoiseMech(0).Dial(context.Background(), nil, u, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestNoiseMechDialUnknownTransport(t *testing.T) {
+	u, _ := Parse("bogus+noise://127.0.0.1:1234")
+
+	result, err := NoiseMech(0).Dial(context.Background(), securityCfg("noise", &NoiseConfig{Static: noiseKey(t)}), u, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestNoiseMechListenUnknownTransport(t *testing.T) {
+	u, _ := Parse("bogus+noise://127.0.0.1:1234")
+
+	result, err := NoiseMech(0).Listen(context.Background(), securityCfg("noise", &NoiseConfig{Static: noiseKey(t)}), u, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestNoiseMechRegistered(t *testing.T) {
+	assert.Equal(t, NoiseMech(0), LookupSecurity("noise"))
+}
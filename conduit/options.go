@@ -16,6 +16,8 @@ package conduit
 
 import (
 	"context"
+	"fmt"
+	"math/bits"
 	"net"
 	"syscall"
 	"time"
@@ -51,6 +53,18 @@ type dialerFilter interface {
 // returns it.  The return type is an iDialer, allowing for testing in
 // isolation.
 func mkDialer(opts []DialerOption, filt dialerFilter) (iDialer, error) {
+	result, err := mkNetDialer(opts, filt)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// mkNetDialer constructs a net.Dialer from the specified options and
+// returns it.  It is used by mechanisms which do not dial through a
+// net.Dialer, but apply its settings themselves.
+func mkNetDialer(opts []DialerOption, filt dialerFilter) (*net.Dialer, error) {
 	result := &net.Dialer{}
 
 	// Apply options
@@ -125,28 +139,206 @@ func (ka KeepAlive) ListenApply(lc *net.ListenConfig) {
 	lc.KeepAlive = time.Duration(ka)
 }
 
-// control is an option for Dial and Listen that sets the Control
-// option.
+// NoDelay is an option for Dial and Listen that controls whether the
+// TCP transport disables Nagle's algorithm on its connections.  It is
+// disabled by default, so NoDelay(false) may be used to enable it.
+// Other transports ignore the option.
+type NoDelay bool
+
+// DialApply applies the option to a net.Dialer.  The option is
+// applied to the connection by the transport, so this is a no-op.
+func (nd NoDelay) DialApply(d *net.Dialer) {}
+
+// ListenApply applies the option to a net.ListenConfig.  The option
+// is applied to each accepted connection by the transport, so this is
+// a no-op.
+func (nd NoDelay) ListenApply(lc *net.ListenConfig) {}
+
+// findOption returns the last option of type T in a list of options,
+// or nil if there is none.
+func findOption[T, O any](opts []O) *T {
+	var result *T
+	for _, opt := range opts {
+		if o, ok := any(opt).(T); ok {
+			result = &o
+		}
+	}
+
+	return result
+}
+
+// findNoDelay returns the last NoDelay option in a list of options,
+// or nil if there is none.
+func findNoDelay[O any](opts []O) *NoDelay {
+	return findOption[NoDelay](opts)
+}
+
+// setNoDelay applies a NoDelay option to a connection, if it is a TCP
+// connection.
+func setNoDelay(c net.Conn, nd *NoDelay) error {
+	if tc, ok := c.(*net.TCPConn); ok && nd != nil {
+		return tc.SetNoDelay(bool(*nd))
+	}
+
+	return nil
+}
+
+// ConnectTimeout is an option for Dial that bounds the time allowed
+// for the transport to establish its connection, including any
+// proxies.  It does not include the security layer handshake.
+type ConnectTimeout time.Duration
+
+// DialApply applies the option to a net.Dialer.
+func (ct ConnectTimeout) DialApply(d *net.Dialer) {
+	d.Timeout = time.Duration(ct)
+}
+
+// HandshakeTimeout is an option for Dial that bounds the time allowed
+// for the security layer handshake, overriding the timeout from the
+// configuration of the security layer.
+type HandshakeTimeout time.Duration
+
+// DialApply applies the option to a net.Dialer.  The option is
+// applied by the security layer, so this is a no-op.
+func (ht HandshakeTimeout) DialApply(d *net.Dialer) {}
+
+// NegotiateTimeout is an option for Dial that sets the
+// NegotiateTimeout of the conduit, bounding the time allowed for the
+// protocol 0 negotiation.
+type NegotiateTimeout time.Duration
+
+// DialApply applies the option to a net.Dialer.  The option is
+// applied to the conduit once it has been dialed, so this is a
+// no-op.
+func (nt NegotiateTimeout) DialApply(d *net.Dialer) {}
+
+// connectContext bounds the context for the transport connection of a
+// dialed conduit by the ConnectTimeout option, if one is given.
+func connectContext(ctx context.Context, opts []DialerOption) (context.Context, context.CancelFunc) {
+	if ct := findOption[ConnectTimeout](opts); ct != nil && *ct > 0 {
+		return context.WithTimeout(ctx, time.Duration(*ct))
+	}
+
+	return ctx, func() {}
+}
+
+// handshakeContext bounds the context for the security layer
+// handshake of a dialed conduit.  The HandshakeTimeout option takes
+// precedence over the timeout from the configuration, which takes
+// precedence over the default.
+func handshakeContext(ctx context.Context, opts []DialerOption, timeout, def time.Duration) (context.Context, context.CancelFunc) {
+	if ht := findOption[HandshakeTimeout](opts); ht != nil {
+		timeout = time.Duration(*ht)
+	} else if timeout <= 0 {
+		timeout = def
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// controlFunc is the type of the Control hook of net.Dialer and
+// net.ListenConfig.
+type controlFunc func(network, address string, c syscall.RawConn) error
+
+// chainControl chains two Control hooks, so that the second is called
+// after the first succeeds.  Either may be nil.
+func chainControl(first, second controlFunc) controlFunc {
+	if first == nil {
+		return second
+	} else if second == nil {
+		return first
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		if err := first(network, address, c); err != nil {
+			return err
+		}
+
+		return second(network, address, c)
+	}
+}
+
+// control is an option for Dial and Listen that adds a function to
+// the Control option.  The functions added by several such options
+// are called in order.
 type control struct {
-	Control func(network, address string, c syscall.RawConn) error
+	Control controlFunc
 }
 
 // DialApply applies the option to a net.Dialer.
 func (c control) DialApply(d *net.Dialer) {
-	d.Control = c.Control
+	d.Control = chainControl(d.Control, c.Control)
 }
 
 // ListenApply applies the option to a net.ListenConfig.
 func (c control) ListenApply(lc *net.ListenConfig) {
-	lc.Control = c.Control
+	lc.Control = chainControl(lc.Control, c.Control)
+}
+
+// SocketOption is an option for Dial and Listen that sets a socket
+// option on the socket before it is connected or bound.  If the
+// socket option is not supported on the platform, dialing or
+// listening fails with an error wrapping ErrUnsupportedOption.
+type SocketOption struct {
+	Opt   SockOpt // The socket option
+	Value int     // The value of an integer option
+	Str   string  // The value of a string option, such as SockOptBindToDevice
+}
+
+// ReusePort returns an option that sets SO_REUSEPORT, allowing
+// several listeners to bind the same port.
+func ReusePort() SocketOption {
+	return SocketOption{Opt: SockOptReusePort, Value: 1}
+}
+
+// ReadBuffer returns an option that sets the size of the socket
+// receive buffer.
+func ReadBuffer(n int) SocketOption {
+	return SocketOption{Opt: SockOptReadBuffer, Value: n}
+}
+
+// WriteBuffer returns an option that sets the size of the socket send
+// buffer.
+func WriteBuffer(n int) SocketOption {
+	return SocketOption{Opt: SockOptWriteBuffer, Value: n}
+}
+
+// BindToDevice returns an option that binds the socket to the named
+// network interface.
+func BindToDevice(name string) SocketOption {
+	return SocketOption{Opt: SockOptBindToDevice, Str: name}
+}
+
+// control sets the socket option on a raw connection.
+func (o SocketOption) control(network, address string, c syscall.RawConn) error {
+	if o.Opt == SockOptBindToDevice {
+		return SetSockOptString(c, o.Opt, o.Str)
+	}
+
+	return SetSockOpt(c, o.Opt, o.Value)
+}
+
+// DialApply applies the option to a net.Dialer.
+func (o SocketOption) DialApply(d *net.Dialer) {
+	d.Control = chainControl(d.Control, o.control)
+}
+
+// ListenApply applies the option to a net.ListenConfig.
+func (o SocketOption) ListenApply(lc *net.ListenConfig) {
+	lc.Control = chainControl(lc.Control, o.control)
 }
 
 // LocalAddrOption is an option that sets the LocalAddr configuration
 // value for a Dialer.  It requires validation by the mechanism, so
 // may not be used by some mechanisms.
 type LocalAddrOption struct {
-	URI  *URI     // The URI for the local side of the connection
-	Addr net.Addr // The address
+	URI        *URI         // The URI for the local side of the connection
+	Addr       net.Addr     // The address
+	Candidates []*URI       // Candidate URIs for the local side
+	Policy     SourcePolicy // Policy for selecting among the candidates
 }
 
 // LocalAddr returns an option that sets the LocalAddr configuration
@@ -159,7 +351,153 @@ func LocalAddr(uri *URI) *LocalAddrOption {
 	}
 }
 
+// LocalAddrs returns an option that sets the LocalAddr configuration
+// value for the Dialer to one of several candidate URIs, selected by
+// the policy based on the destination of the connection.  If the
+// policy is nil, LongestPrefix is used.  The candidate URIs must be
+// canonical.
+func LocalAddrs(policy SourcePolicy, uris ...*URI) *LocalAddrOption {
+	if policy == nil {
+		policy = LongestPrefix
+	}
+
+	return &LocalAddrOption{
+		Candidates: uris,
+		Policy:     policy,
+	}
+}
+
 // DialApply applies the option to a net.Dialer.
 func (la *LocalAddrOption) DialApply(d *net.Dialer) {
 	d.LocalAddr = la.Addr
 }
+
+// selectSource selects the URI for the local side of the connection
+// from the candidates, given the destination host and port.  It does
+// nothing if there are no candidates.
+func (la *LocalAddrOption) selectSource(dest string) error {
+	if len(la.Candidates) == 0 {
+		return nil
+	}
+
+	// Determine the destination IP address
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		host = dest
+	}
+	destIP, _ := parseIP(host)
+	if destIP == nil {
+		ips, err := lookupIP(context.Background(), "ip", host)
+		if err != nil {
+			return fmt.Errorf("destination %q: %w", dest, err)
+		} else if len(ips) == 0 {
+			return fmt.Errorf("destination %q: %w", dest, ErrNoSourceAddr)
+		}
+		destIP = ips[0]
+	}
+
+	// Collect the candidate addresses
+	ips := make([]net.IP, 0, len(la.Candidates))
+	uris := make([]*URI, 0, len(la.Candidates))
+	for _, u := range la.Candidates {
+		if !u.IsCanonical() {
+			return fmt.Errorf("local address %q: %w", u, ErrNotCanonical)
+		}
+		if ip, _ := parseIP(u.Hostname()); ip != nil {
+			ips = append(ips, ip)
+			uris = append(uris, u)
+		}
+	}
+
+	// Apply the policy
+	choice := la.Policy.SelectSource(destIP, ips)
+	for i, ip := range ips {
+		if choice != nil && ip.Equal(choice) {
+			la.URI = uris[i]
+			return nil
+		}
+	}
+
+	return fmt.Errorf("destination %q: %w", dest, ErrNoSourceAddr)
+}
+
+// selectSources applies source address selection to any LocalAddr
+// options with candidates.  Mechanisms supporting the LocalAddr
+// option call this with the destination before filtering the
+// options.
+func selectSources(opts []DialerOption, dest string) error {
+	for _, opt := range opts {
+		if la, ok := opt.(*LocalAddrOption); ok {
+			if err := la.selectSource(dest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SourcePolicy is an interface for policies that select the source
+// address to use for reaching a destination when a node has multiple
+// local addresses.
+type SourcePolicy interface {
+	// SelectSource selects one of the candidate addresses for
+	// reaching the destination.  It returns nil if none of the
+	// candidates is suitable.
+	SelectSource(dest net.IP, candidates []net.IP) net.IP
+}
+
+// SourcePolicyFunc is an adaptor allowing an ordinary function to be
+// used as a SourcePolicy.
+type SourcePolicyFunc func(dest net.IP, candidates []net.IP) net.IP
+
+// SelectSource selects one of the candidate addresses for reaching
+// the destination.  It returns nil if none of the candidates is
+// suitable.
+func (f SourcePolicyFunc) SelectSource(dest net.IP, candidates []net.IP) net.IP {
+	return f(dest, candidates)
+}
+
+// commonPrefix returns the number of leading bits two addresses of
+// the same length have in common.
+func commonPrefix(a, b net.IP) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+
+	return len(a) * 8
+}
+
+// longestPrefix implements the LongestPrefix policy.
+func longestPrefix(dest net.IP, candidates []net.IP) net.IP {
+	dest4 := dest.To4()
+
+	var result net.IP
+	best := -1
+	for _, cand := range candidates {
+		// Only consider candidates of the same address family
+		var prefix int
+		if cand4 := cand.To4(); dest4 != nil && cand4 != nil {
+			prefix = commonPrefix(dest4, cand4)
+		} else if dest4 == nil && cand4 == nil {
+			prefix = commonPrefix(dest.To16(), cand.To16())
+		} else {
+			continue
+		}
+
+		if prefix > best {
+			result = cand
+			best = prefix
+		}
+	}
+
+	return result
+}
+
+// LongestPrefix is the default source address selection policy.  It
+// selects the candidate of the same address family as the
+// destination which shares the longest prefix with it; ties are
+// broken in favor of the earliest candidate.
+var LongestPrefix SourcePolicy = SourcePolicyFunc(longestPrefix)
@@ -21,8 +21,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klmitch/patcher"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type mockDialer struct {
@@ -242,6 +244,152 @@ func TestKeepAliveDialApply(t *testing.T) {
 	}, dialer)
 }
 
+func TestNoDelayImplementsDialerOption(t *testing.T) {
+	assert.Implements(t, (*DialerOption)(nil), NoDelay(true))
+}
+
+func TestNoDelayImplementsListenerOption(t *testing.T) {
+	assert.Implements(t, (*ListenerOption)(nil), NoDelay(true))
+}
+
+func TestFindNoDelayBase(t *testing.T) {
+	result := findNoDelay([]DialerOption{NoDelay(true), KeepAlive(time.Second), NoDelay(false)})
+
+	require.NotNil(t, result)
+	assert.Equal(t, NoDelay(false), *result)
+}
+
+func TestFindNoDelayMissing(t *testing.T) {
+	result := findNoDelay([]ListenerOption{KeepAlive(time.Second)})
+
+	assert.Nil(t, result)
+}
+
+func TestFindOptionBase(t *testing.T) {
+	result := findOption[KeepAlive]([]DialerOption{KeepAlive(time.Second), NoDelay(true), KeepAlive(time.Minute)})
+
+	require.NotNil(t, result)
+	assert.Equal(t, KeepAlive(time.Minute), *result)
+}
+
+func TestFindOptionMissing(t *testing.T) {
+	result := findOption[ConnectTimeout]([]DialerOption{KeepAlive(time.Second)})
+
+	assert.Nil(t, result)
+}
+
+func TestConnectTimeoutImplementsDialerOption(t *testing.T) {
+	assert.Implements(t, (*DialerOption)(nil), ConnectTimeout(time.Second))
+}
+
+func TestConnectTimeoutDialApply(t *testing.T) {
+	dialer := &net.Dialer{}
+	obj := ConnectTimeout(time.Second)
+
+	obj.DialApply(dialer)
+
+	assert.Equal(t, &net.Dialer{
+		Timeout: time.Second,
+	}, dialer)
+}
+
+func TestHandshakeTimeoutDialApply(t *testing.T) {
+	dialer := &net.Dialer{}
+	obj := HandshakeTimeout(time.Second)
+
+	obj.DialApply(dialer)
+
+	assert.Equal(t, &net.Dialer{}, dialer)
+}
+
+func TestNegotiateTimeoutDialApply(t *testing.T) {
+	dialer := &net.Dialer{}
+	obj := NegotiateTimeout(time.Second)
+
+	obj.DialApply(dialer)
+
+	assert.Equal(t, &net.Dialer{}, dialer)
+}
+
+func TestConnectContextBase(t *testing.T) {
+	result, cancel := connectContext(context.Background(), []DialerOption{ConnectTimeout(time.Hour)})
+	defer cancel()
+
+	deadline, ok := result.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Minute)
+}
+
+func TestConnectContextNone(t *testing.T) {
+	ctx := context.Background()
+
+	result, cancel := connectContext(ctx, []DialerOption{KeepAlive(time.Second)})
+	defer cancel()
+
+	assert.Equal(t, ctx, result)
+}
+
+func TestHandshakeContextOption(t *testing.T) {
+	result, cancel := handshakeContext(context.Background(), []DialerOption{HandshakeTimeout(time.Hour)}, time.Minute, time.Second)
+	defer cancel()
+
+	deadline, ok := result.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Minute)
+}
+
+func TestHandshakeContextOptionDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	result, cancel := handshakeContext(ctx, []DialerOption{HandshakeTimeout(0)}, time.Minute, time.Second)
+	defer cancel()
+
+	assert.Equal(t, ctx, result)
+}
+
+func TestHandshakeContextConfig(t *testing.T) {
+	result, cancel := handshakeContext(context.Background(), nil, time.Hour, time.Second)
+	defer cancel()
+
+	deadline, ok := result.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Minute)
+}
+
+func TestHandshakeContextDefault(t *testing.T) {
+	result, cancel := handshakeContext(context.Background(), nil, 0, time.Hour)
+	defer cancel()
+
+	deadline, ok := result.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Minute)
+}
+
+func TestSetNoDelayTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	nd := NoDelay(false)
+
+	err = setNoDelay(c, &nd)
+
+	assert.NoError(t, err)
+}
+
+func TestSetNoDelayOther(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	nd := NoDelay(false)
+
+	err := setNoDelay(c1, &nd)
+
+	assert.NoError(t, err)
+}
+
 func TestKeepAliveListenApply(t *testing.T) {
 	lc := &net.ListenConfig{}
 	obj := KeepAlive(time.Second)
@@ -261,30 +409,150 @@ func TestControlImplementsListenerOption(t *testing.T) {
 	assert.Implements(t, (*ListenerOption)(nil), control{})
 }
 
+func TestChainControlBase(t *testing.T) {
+	calls := []string{}
+	first := func(network, address string, c syscall.RawConn) error {
+		calls = append(calls, "first")
+		return nil
+	}
+	second := func(network, address string, c syscall.RawConn) error {
+		calls = append(calls, "second")
+		return nil
+	}
+
+	result := chainControl(first, second)
+
+	assert.NoError(t, result("net", "addr", nil))
+	assert.Equal(t, []string{"first", "second"}, calls)
+}
+
+func TestChainControlError(t *testing.T) {
+	first := func(network, address string, c syscall.RawConn) error {
+		return assert.AnError
+	}
+	second := func(network, address string, c syscall.RawConn) error {
+		t.Error("second control called")
+		return nil
+	}
+
+	result := chainControl(first, second)
+
+	assert.Same(t, assert.AnError, result("net", "addr", nil))
+}
+
+func TestChainControlNil(t *testing.T) {
+	called := false
+	f := func(network, address string, c syscall.RawConn) error {
+		called = true
+		return nil
+	}
+
+	assert.Nil(t, chainControl(nil, nil))
+	assert.NoError(t, chainControl(nil, f)("net", "addr", nil))
+	assert.True(t, called)
+	called = false
+	assert.NoError(t, chainControl(f, nil)("net", "addr", nil))
+	assert.True(t, called)
+}
+
 func TestControlDialApply(t *testing.T) {
+	calls := []string{}
 	dialer := &net.Dialer{
 		Control: func(network, address string, c syscall.RawConn) error {
+			calls = append(calls, "existing")
 			return nil
 		},
 	}
-	obj := control{}
+	obj := control{Control: func(network, address string, c syscall.RawConn) error {
+		calls = append(calls, "added")
+		return nil
+	}}
 
 	obj.DialApply(dialer)
 
-	assert.Equal(t, &net.Dialer{}, dialer)
+	assert.NoError(t, dialer.Control("net", "addr", nil))
+	assert.Equal(t, []string{"existing", "added"}, calls)
 }
 
 func TestControlListenApply(t *testing.T) {
+	calls := []string{}
 	lc := &net.ListenConfig{
 		Control: func(network, address string, c syscall.RawConn) error {
+			calls = append(calls, "existing")
 			return nil
 		},
 	}
-	obj := control{}
+	obj := control{Control: func(network, address string, c syscall.RawConn) error {
+		calls = append(calls, "added")
+		return nil
+	}}
 
 	obj.ListenApply(lc)
 
-	assert.Equal(t, &net.ListenConfig{}, lc)
+	assert.NoError(t, lc.Control("net", "addr", nil))
+	assert.Equal(t, []string{"existing", "added"}, calls)
+}
+
+func TestSocketOptionImplementsDialerOption(t *testing.T) {
+	assert.Implements(t, (*DialerOption)(nil), SocketOption{})
+}
+
+func TestSocketOptionImplementsListenerOption(t *testing.T) {
+	assert.Implements(t, (*ListenerOption)(nil), SocketOption{})
+}
+
+func TestSocketOptionConstructors(t *testing.T) {
+	assert.Equal(t, SocketOption{Opt: SockOptReusePort, Value: 1}, ReusePort())
+	assert.Equal(t, SocketOption{Opt: SockOptReadBuffer, Value: 4096}, ReadBuffer(4096))
+	assert.Equal(t, SocketOption{Opt: SockOptWriteBuffer, Value: 8192}, WriteBuffer(8192))
+	assert.Equal(t, SocketOption{Opt: SockOptBindToDevice, Str: "eth0"}, BindToDevice("eth0"))
+}
+
+func TestSocketOptionDialApply(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		assert.Equal(t, 5, fd)
+		assert.Equal(t, 1, level)
+		assert.Equal(t, 2, opt)
+		assert.Equal(t, 1, value)
+		return nil
+	}).Install().Restore()
+	dialer := &net.Dialer{}
+	obj := SocketOption{Opt: SockOptReuseAddr, Value: 1}
+
+	obj.DialApply(dialer)
+
+	assert.NoError(t, dialer.Control("net", "addr", c))
+	c.AssertExpectations(t)
+}
+
+func TestSocketOptionListenApplyString(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&setsockoptString, func(fd, level, opt int, value string) error {
+		assert.Equal(t, 25, opt)
+		assert.Equal(t, "eth0", value)
+		return nil
+	}).Install().Restore()
+	lc := &net.ListenConfig{}
+	obj := BindToDevice("eth0")
+
+	obj.ListenApply(lc)
+
+	assert.NoError(t, lc.Control("net", "addr", c))
+	c.AssertExpectations(t)
+}
+
+func TestSocketOptionListenApplyUnsupported(t *testing.T) {
+	c := &mockRawConn{}
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	lc := &net.ListenConfig{}
+	obj := ReusePort()
+
+	obj.ListenApply(lc)
+
+	assert.ErrorIs(t, lc.Control("net", "addr", c), ErrUnsupportedOption)
 }
 
 func TestLocalAddrOptionImplementsDialerOption(t *testing.T) {
@@ -314,3 +582,214 @@ func TestLocalAddrOptionDialApply(t *testing.T) {
 		LocalAddr: addr,
 	}, dialer)
 }
+
+func TestLocalAddrs(t *testing.T) {
+	u1 := &URI{}
+	u2 := &URI{}
+	policy := SourcePolicyFunc(func(dest net.IP, candidates []net.IP) net.IP {
+		return nil
+	})
+
+	result := LocalAddrs(policy, u1, u2)
+
+	assert.Equal(t, []*URI{u1, u2}, result.Candidates)
+	assert.NotNil(t, result.Policy)
+	assert.Nil(t, result.URI)
+}
+
+func TestLocalAddrsDefaultPolicy(t *testing.T) {
+	result := LocalAddrs(nil)
+
+	assert.NotNil(t, result.Policy)
+	assert.Equal(t, net.ParseIP("10.0.0.2"), result.Policy.SelectSource(net.ParseIP("10.0.0.1"), []net.IP{
+		net.ParseIP("192.168.0.1"),
+		net.ParseIP("10.0.0.2"),
+	}))
+}
+
+func TestLocalAddrOptionSelectSourceNoCandidates(t *testing.T) {
+	u := &URI{}
+	obj := LocalAddr(u)
+
+	err := obj.selectSource("10.0.0.1:1234")
+
+	assert.NoError(t, err)
+	assert.Same(t, u, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceBase(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	u2, _ := Parse("tcp://10.1.2.3:0")
+	u3, _ := Parse("tcp://[2001:db8::1]:0")
+	obj := LocalAddrs(nil, u1, u2, u3)
+
+	err := obj.selectSource("10.1.9.9:1234")
+
+	assert.NoError(t, err)
+	assert.Same(t, u2, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceIPv6(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	u2, _ := Parse("tcp://[2001:db8::1]:0")
+	obj := LocalAddrs(nil, u1, u2)
+
+	err := obj.selectSource("[2001:db8:1::1]:1234")
+
+	assert.NoError(t, err)
+	assert.Same(t, u2, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceHostOnly(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	obj := LocalAddrs(nil, u1)
+
+	err := obj.selectSource("192.168.1.1")
+
+	assert.NoError(t, err)
+	assert.Same(t, u1, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceLookup(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	u2, _ := Parse("tcp://10.1.2.3:0")
+	obj := LocalAddrs(nil, u1, u2)
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		assert.Equal(t, "peer.example.com", host)
+		return []net.IP{net.ParseIP("192.168.1.1")}, nil
+	}).Install().Restore()
+
+	err := obj.selectSource("peer.example.com:1234")
+
+	assert.NoError(t, err)
+	assert.Same(t, u1, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceLookupError(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	obj := LocalAddrs(nil, u1)
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	err := obj.selectSource("peer.example.com:1234")
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceLookupEmpty(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	obj := LocalAddrs(nil, u1)
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return []net.IP{}, nil
+	}).Install().Restore()
+
+	err := obj.selectSource("peer.example.com:1234")
+
+	assert.ErrorIs(t, err, ErrNoSourceAddr)
+	assert.Nil(t, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceNotCanonical(t *testing.T) {
+	u1, _ := Parse("tcp://localhost:0")
+	obj := LocalAddrs(nil, u1)
+
+	err := obj.selectSource("127.0.0.1:1234")
+
+	assert.ErrorIs(t, err, ErrNotCanonical)
+	assert.Nil(t, obj.URI)
+}
+
+func TestLocalAddrOptionSelectSourceNoMatch(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	obj := LocalAddrs(nil, u1)
+
+	err := obj.selectSource("[2001:db8::2]:1234")
+
+	assert.ErrorIs(t, err, ErrNoSourceAddr)
+	assert.Nil(t, obj.URI)
+}
+
+func TestSelectSourcesBase(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	u2, _ := Parse("tcp://10.1.2.3:0")
+	la := LocalAddrs(nil, u1, u2)
+	opt := &mockDialerOption{}
+
+	err := selectSources([]DialerOption{opt, la}, "10.0.0.1:1234")
+
+	assert.NoError(t, err)
+	assert.Same(t, u2, la.URI)
+}
+
+func TestSelectSourcesError(t *testing.T) {
+	u1, _ := Parse("tcp://192.168.1.5:0")
+	la := LocalAddrs(nil, u1)
+
+	err := selectSources([]DialerOption{la}, "[2001:db8::2]:1234")
+
+	assert.ErrorIs(t, err, ErrNoSourceAddr)
+}
+
+func TestSourcePolicyFuncImplementsSourcePolicy(t *testing.T) {
+	assert.Implements(t, (*SourcePolicy)(nil), SourcePolicyFunc(nil))
+}
+
+func TestSourcePolicyFuncSelectSource(t *testing.T) {
+	dest := net.ParseIP("10.0.0.1")
+	cands := []net.IP{net.ParseIP("10.0.0.2")}
+	obj := SourcePolicyFunc(func(d net.IP, c []net.IP) net.IP {
+		assert.Equal(t, dest, d)
+		assert.Equal(t, cands, c)
+		return c[0]
+	})
+
+	result := obj.SelectSource(dest, cands)
+
+	assert.Equal(t, cands[0], result)
+}
+
+func TestCommonPrefix(t *testing.T) {
+	assert.Equal(t, 32, commonPrefix(net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 1).To4()))
+	assert.Equal(t, 30, commonPrefix(net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4()))
+	assert.Equal(t, 0, commonPrefix(net.IPv4(10, 0, 0, 1).To4(), net.IPv4(192, 0, 0, 1).To4()))
+}
+
+func TestLongestPrefixBase(t *testing.T) {
+	result := longestPrefix(net.ParseIP("10.1.1.1"), []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("192.168.1.1"),
+		net.ParseIP("10.1.2.3"),
+		net.ParseIP("10.1.1.200"),
+	})
+
+	assert.Equal(t, net.ParseIP("10.1.1.200"), result)
+}
+
+func TestLongestPrefixTie(t *testing.T) {
+	result := longestPrefix(net.ParseIP("10.1.1.1"), []net.IP{
+		net.ParseIP("192.168.1.1"),
+		net.ParseIP("172.16.1.1"),
+	})
+
+	assert.Equal(t, net.ParseIP("192.168.1.1"), result)
+}
+
+func TestLongestPrefixIPv6(t *testing.T) {
+	result := longestPrefix(net.ParseIP("2001:db8:1::1"), []net.IP{
+		net.ParseIP("10.1.1.1"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("2001:db8:1::2"),
+	})
+
+	assert.Equal(t, net.ParseIP("2001:db8:1::2"), result)
+}
+
+func TestLongestPrefixNone(t *testing.T) {
+	result := longestPrefix(net.ParseIP("10.1.1.1"), []net.IP{
+		net.ParseIP("2001:db8::1"),
+	})
+
+	assert.Nil(t, result)
+}
@@ -15,18 +15,48 @@
 package conduit
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	mrand "math/rand/v2"
 	"net"
-	"syscall"
+	"os"
+	"os/exec"
+	"time"
+
+	"golang.org/x/net/proxy"
 )
 
 // Patch points for isolating functions during testing.
 var (
-	lookupIP            func(string) ([]net.IP, error)                                          = net.LookupIP
-	lookupPort          func(string, string) (int, error)                                       = net.LookupPort
-	lookupSecurity      func(string) Mechanism                                                  = LookupSecurity
-	lookupTransport     func(string) Mechanism                                                  = LookupTransport
-	mkDialerPatch       func(opts []DialerOption, filt dialerFilter) (iDialer, error)           = mkDialer
-	mkListenConfigPatch func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error) = mkListenConfig
-	setsockoptInt       func(fd, level, opt, value int) error                                   = syscall.SetsockoptInt
-	resolveTCPAddr      func(network, address string) (*net.TCPAddr, error)                     = net.ResolveTCPAddr
+	interfaceAddrs       func() ([]ifaceAddr, error)                                                                      = listInterfaceAddrs
+	lookupIP             func(ctx context.Context, network, host string) ([]net.IP, error)                                = net.DefaultResolver.LookupIP
+	lookupPort           func(ctx context.Context, network, service string) (int, error)                                  = net.DefaultResolver.LookupPort
+	lookupSRV            func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)               = net.DefaultResolver.LookupSRV
+	lookupSecurity       func(string) Mechanism                                                                           = LookupSecurity
+	lookupTransport      func(string) Mechanism                                                                           = LookupTransport
+	mkDialerPatch        func(opts []DialerOption, filt dialerFilter) (iDialer, error)                                    = mkDialer
+	mkListenConfigPatch  func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error)                          = mkListenConfig
+	setsockoptInt        func(fd, level, opt, value int) error                                                            = sysSetsockoptInt
+	setsockoptString     func(fd, level, opt int, value string) error                                                     = sysSetsockoptString
+	getsockoptInt        func(fd, level, opt int) (int, error)                                                            = sysGetsockoptInt
+	resolveTCPAddr       func(network, address string) (*net.TCPAddr, error)                                              = net.ResolveTCPAddr
+	resolveUDPAddr       func(network, address string) (*net.UDPAddr, error)                                              = net.ResolveUDPAddr
+	dialUDP              func(network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error)                            = net.DialUDP
+	listenPacket         func(lc *net.ListenConfig, ctx context.Context, network, address string) (net.PacketConn, error) = (*net.ListenConfig).ListenPacket
+	mdnsListenPatch      func() (net.PacketConn, error)                                                                   = mdnsListen
+	mdnsListenGroupPatch func() (net.PacketConn, error)                                                                   = mdnsListenGroup
+	timeNow              func() time.Time                                                                                 = time.Now
+	afterFunc            func(d time.Duration, f func()) *time.Timer                                                      = time.AfterFunc
+	readFile             func(name string) ([]byte, error)                                                                = os.ReadFile
+	getenv               func(key string) string                                                                          = os.Getenv
+	osEnviron            func() []string                                                                                  = os.Environ
+	loadX509KeyPair      func(certFile, keyFile string) (tls.Certificate, error)                                          = tls.LoadX509KeyPair
+	proxySOCKS5          func(network, address string, auth *proxy.Auth, forward proxy.Dialer) (proxy.Dialer, error)      = proxy.SOCKS5
+	randFloat            func() float64                                                                                   = mrand.Float64
+	randReader           io.Reader                                                                                        = rand.Reader
+	mkACMEClientPatch    func(key crypto.Signer, directory string) acmeClient                                             = mkACMEClient
+	execCommand          func(ctx context.Context, name string, arg ...string) *exec.Cmd                                  = exec.CommandContext
 )
@@ -0,0 +1,474 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// PersistentState indicates the state of a persistent conduit.
+type PersistentState int
+
+// Defined persistent conduit states.
+const (
+	PersistentConnecting PersistentState = iota // Dialing the URI
+	PersistentOpen                              // Conduit is established
+	PersistentBackoff                           // Waiting to dial again
+	PersistentClosed                            // Stopped or gave up
+	PersistentDraining                          // Lease expired; draining
+)
+
+// String returns the name of the state.
+func (s PersistentState) String() string {
+	switch s {
+	case PersistentConnecting:
+		return "connecting"
+	case PersistentOpen:
+		return "open"
+	case PersistentBackoff:
+		return "backoff"
+	case PersistentClosed:
+		return "closed"
+	case PersistentDraining:
+		return "draining"
+	}
+
+	return fmt.Sprintf("PersistentState(%d)", int(s))
+}
+
+// Defaults for Backoff.
+const (
+	DefaultBackoffInitial    = 500 * time.Millisecond
+	DefaultBackoffMax        = 30 * time.Second
+	DefaultBackoffMultiplier = 2.0
+)
+
+// Backoff describes an exponential backoff policy.  Zero values
+// select the defaults.  A conduit that fails before it has been open
+// for the maximum delay counts as a failure, so that a peer which
+// accepts and then drops connections is not dialed in a tight loop.
+type Backoff struct {
+	Initial    time.Duration // Delay after the first failure
+	Max        time.Duration // Maximum delay
+	Multiplier float64       // Factor by which the delay grows
+	Jitter     float64       // Fraction of the delay to randomize, 0 to 1
+	MaxRetries int           // Failures allowed before giving up; 0 for no limit
+}
+
+// Delay returns the delay after the specified number of consecutive
+// failures, which must be at least 1.  With jitter, the delay is
+// reduced by a random amount up to the jitter fraction.
+func (b Backoff) Delay(failures int) time.Duration {
+	initial, maxDelay, mult := b.Initial, b.Max, b.Multiplier
+	if initial <= 0 {
+		initial = DefaultBackoffInitial
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultBackoffMax
+	}
+	if mult < 1 {
+		mult = DefaultBackoffMultiplier
+	}
+
+	// Compute the delay
+	delay := float64(initial)
+	for i := 1; i < failures && delay < float64(maxDelay); i++ {
+		delay *= mult
+	}
+	delay = min(delay, float64(maxDelay))
+
+	// Apply the jitter
+	if b.Jitter > 0 {
+		delay -= delay * min(b.Jitter, 1) * randFloat()
+	}
+
+	return time.Duration(delay)
+}
+
+// stable returns how long a conduit must stay open before its failure
+// no longer counts toward the consecutive failures.
+func (b Backoff) stable() time.Duration {
+	if b.Max <= 0 {
+		return DefaultBackoffMax
+	}
+
+	return b.Max
+}
+
+// DefaultLeaseDrainTimeout is the time allowed for the peer to
+// acknowledge the drain of a conduit whose lease has expired.
+const DefaultLeaseDrainTimeout = 5 * time.Second
+
+// Lease describes the maximum lifetime of the conduits maintained by
+// a Persistent.  When the lease of a conduit expires, it is drained
+// and closed, and the URI is dialed again, so that credentials are
+// periodically re-validated by the security layer handshake.  The
+// zero value places no limit on the lifetime.
+type Lease struct {
+	Lifetime       time.Duration // Maximum lifetime of each conduit; 0 for no limit
+	Jitter         float64       // Fraction of the lifetime to randomize, 0 to 1
+	DrainTimeout   time.Duration // Time to wait for the peer to acknowledge the drain
+	Recanonicalize bool          // Canonicalize the URI again, rather than redialing the same address
+}
+
+// Duration returns the lifetime of a new conduit, or 0 if there is no
+// limit.  With jitter, the lifetime is reduced by a random amount up
+// to the jitter fraction, so that conduits established together do
+// not all expire together.
+func (l Lease) Duration() time.Duration {
+	if l.Lifetime <= 0 {
+		return 0
+	}
+
+	lifetime := float64(l.Lifetime)
+	if l.Jitter > 0 {
+		lifetime -= lifetime * min(l.Jitter, 1) * randFloat()
+	}
+
+	return max(time.Duration(lifetime), 1)
+}
+
+// PersistentEvent describes a state change of a persistent conduit.
+type PersistentEvent struct {
+	State    PersistentState // The new state
+	Conduit  *Conduit        // The conduit, when Open
+	Failures int             // Consecutive failures so far
+	Delay    time.Duration   // The delay, when in Backoff
+	Err      error           // The error causing the change, if any
+}
+
+// Persistent maintains a conduit to a URI, dialing it again with
+// exponential backoff whenever it fails.  The URI is dialed with
+// DialAll, so it need not be canonical.  Failures of the conduit are
+// detected by Send and Recv, or when the conduit is closed; callers
+// using the conduit directly must report other failures by calling
+// Fail.  If a lease is set, the conduit is
+// drained and replaced when its lease expires; the drain
+// acknowledgment is only seen if the caller passes control messages
+// received over the conduit to Conduit.HandleDrain.  The exported
+// fields must be set before calling Start.
+type Persistent struct {
+	URI     *URI                      // The URI to dial
+	Config  Config                    // The configuration for the mechanisms
+	Options []DialerOption            // Options for dialing
+	Backoff Backoff                   // The backoff policy
+	Lease   Lease                     // The lease limiting the lifetime of conduits
+	OnEvent func(ev *PersistentEvent) // Called on state changes; must not block
+
+	lock    sync.Mutex         // Protects the state
+	current *Conduit           // The current conduit, if open
+	ready   chan struct{}      // Closed when open or closed
+	closed  bool               // Persistent conduit has been closed
+	err     error              // Error causing closure
+	fail    chan *Conduit      // Reports failed conduits
+	cancel  context.CancelFunc // Stops the persistent conduit
+	done    chan struct{}      // Closed when stopped
+}
+
+// Start begins maintaining the conduit.  Cancelling the context has
+// the same effect as calling Stop.
+func (p *Persistent) Start(ctx context.Context) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.done != nil {
+		return
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.ready = make(chan struct{})
+	p.fail = make(chan *Conduit, 1)
+	p.done = make(chan struct{})
+
+	go p.run(ctx)
+}
+
+// Stop stops maintaining the conduit and closes it.
+func (p *Persistent) Stop() {
+	p.lock.Lock()
+	cancel, done := p.cancel, p.done
+	p.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// emit reports a state change.
+func (p *Persistent) emit(ev *PersistentEvent) {
+	if p.OnEvent != nil {
+		p.OnEvent(ev)
+	}
+}
+
+// setCurrent sets the current conduit.  When the current conduit is
+// cleared, any failure reported for it and not yet seen by run is
+// discarded, so that it does not cause the next conduit to be closed.
+func (p *Persistent) setCurrent(c *Conduit) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.current = c
+	if c != nil {
+		close(p.ready)
+		return
+	}
+	select {
+	case <-p.fail:
+	default:
+	}
+	if isClosed(p.ready) {
+		p.ready = make(chan struct{})
+	}
+}
+
+// close marks the persistent conduit closed.
+func (p *Persistent) close(failures int, err error) {
+	p.lock.Lock()
+	p.current = nil
+	p.closed = true
+	p.err = err
+	if !isClosed(p.ready) {
+		close(p.ready)
+	}
+	p.lock.Unlock()
+
+	p.emit(&PersistentEvent{State: PersistentClosed, Failures: failures, Err: err})
+}
+
+// run maintains the conduit until stopped.
+func (p *Persistent) run(ctx context.Context) {
+	defer close(p.done)
+
+	failures := 0
+	connects := uint64(0)
+	var redial *URI
+	for {
+		// Dial the URI, or the address of an expired conduit
+		target := p.URI
+		if redial != nil {
+			target, redial = redial, nil
+		}
+		p.emit(&PersistentEvent{State: PersistentConnecting, Failures: failures})
+		c, err := target.DialAll(ctx, p.Config, 0, p.Options...)
+		if err != nil {
+			if ctx.Err() != nil {
+				p.close(failures, nil)
+				return
+			}
+
+			// Back off before trying again
+			failures++
+			if !p.backoff(ctx, failures, err) {
+				return
+			}
+			continue
+		}
+
+		// The conduit is open; wait for it to fail or expire
+		c.setReconnects(connects)
+		connects++
+		p.setCurrent(c)
+		p.emit(&PersistentEvent{State: PersistentOpen, Conduit: c})
+		opened := timeNow()
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if lifetime := p.Lease.Duration(); lifetime > 0 {
+			timer = time.NewTimer(lifetime)
+			expired = timer.C
+		}
+		failed := false
+		select {
+		case <-p.fail:
+			failed = true
+		case <-c.Context().Done():
+			failed = true
+		case <-expired:
+			p.expire(ctx, c)
+			if !p.Lease.Recanonicalize {
+				redial = c.RemoteURI
+			}
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		p.setCurrent(nil)
+		closeLink(c)
+		if ctx.Err() != nil {
+			p.close(0, nil)
+			return
+		}
+
+		// Back off if the conduit did not stay up long enough
+		if !failed || timeNow().Sub(opened) >= p.Backoff.stable() {
+			failures = 0
+			continue
+		}
+		failures++
+		if !p.backoff(ctx, failures, nil) {
+			return
+		}
+	}
+}
+
+// backoff waits for the backoff delay after the specified number of
+// consecutive failures, the last caused by err, if known.  It returns
+// false if the persistent conduit was closed, either because it gave
+// up or because the context is done.
+func (p *Persistent) backoff(ctx context.Context, failures int, err error) bool {
+	if p.Backoff.MaxRetries > 0 && failures > p.Backoff.MaxRetries {
+		if err != nil {
+			err = fmt.Errorf("%s: %w: %w", p.URI, ErrGaveUp, err)
+		} else {
+			err = fmt.Errorf("%s: %w", p.URI, ErrGaveUp)
+		}
+		p.close(failures, err)
+		return false
+	}
+
+	delay := p.Backoff.Delay(failures)
+	p.emit(&PersistentEvent{State: PersistentBackoff, Failures: failures, Delay: delay, Err: err})
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		p.close(failures, nil)
+		return false
+	}
+}
+
+// expire drains a conduit whose lease has expired.  It returns when
+// the peer acknowledges the drain, the drain timeout expires, or the
+// conduit fails.
+func (p *Persistent) expire(ctx context.Context, c *Conduit) {
+	p.emit(&PersistentEvent{State: PersistentDraining, Conduit: c})
+	if err := c.Drain(); err != nil {
+		return
+	}
+
+	timeout := p.Lease.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultLeaseDrainTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.Drained():
+	case <-timer.C:
+	case <-p.fail:
+	case <-c.Context().Done():
+	case <-ctx.Done():
+	}
+}
+
+// Conduit returns the current conduit, or nil if it is not open.
+func (p *Persistent) Conduit() *Conduit {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.current
+}
+
+// Wait waits until the conduit is open, returning it.  An error
+// wrapping ErrClosed is returned if the persistent conduit is closed.
+func (p *Persistent) Wait(ctx context.Context) (*Conduit, error) {
+	for {
+		p.lock.Lock()
+		c, closed, err, ready := p.current, p.closed, p.err, p.ready
+		p.lock.Unlock()
+
+		switch {
+		case c != nil:
+			return c, nil
+		case closed && err != nil:
+			return nil, fmt.Errorf("%w: %w", ErrClosed, err)
+		case closed:
+			return nil, ErrClosed
+		case ready == nil:
+			return nil, ErrNotStarted
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Fail reports that the specified conduit has failed, causing it to
+// be closed and the URI to be dialed again.  Reports concerning a
+// conduit that is no longer current are ignored.
+func (p *Persistent) Fail(c *Conduit) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if c == nil || p.current != c {
+		return
+	}
+	select {
+	case p.fail <- c:
+	default:
+	}
+}
+
+// Send sends a frame over the current conduit.  If the conduit is not
+// open, ErrNotConnected is returned; if sending fails, the conduit is
+// reported as failed.
+func (p *Persistent) Send(frame *proto.Frame) error {
+	c := p.Conduit()
+	if c == nil {
+		return ErrNotConnected
+	}
+
+	err := c.Send(frame)
+	if err != nil {
+		p.Fail(c)
+	}
+
+	return err
+}
+
+// Recv waits for the conduit to be open, then receives the next frame
+// from it.  If receiving fails, the conduit is reported as failed,
+// and the error is returned; the caller may call Recv again to
+// receive from the replacement conduit.  If the context is done
+// while receiving, the read is interrupted and the context's error
+// is returned; the conduit is not reported as failed.
+func (p *Persistent) Recv(ctx context.Context) (*proto.Frame, error) {
+	c, err := p.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	interrupted := c.interruptRecv(ctx)
+	f, err := c.Recv()
+	if interrupted() && err != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		p.Fail(c)
+		return nil, err
+	}
+
+	return f, nil
+}
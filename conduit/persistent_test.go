@@ -0,0 +1,609 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestPersistentStateString(t *testing.T) {
+	assert.Equal(t, "connecting", PersistentConnecting.String())
+	assert.Equal(t, "open", PersistentOpen.String())
+	assert.Equal(t, "backoff", PersistentBackoff.String())
+	assert.Equal(t, "closed", PersistentClosed.String())
+	assert.Equal(t, "draining", PersistentDraining.String())
+	assert.Equal(t, "PersistentState(17)", PersistentState(17).String())
+}
+
+func TestBackoffDelayDefaults(t *testing.T) {
+	obj := Backoff{}
+
+	assert.Equal(t, DefaultBackoffInitial, obj.Delay(1))
+	assert.Equal(t, 2*DefaultBackoffInitial, obj.Delay(2))
+	assert.Equal(t, DefaultBackoffMax, obj.Delay(100))
+}
+
+func TestBackoffDelayGrowth(t *testing.T) {
+	obj := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 3}
+
+	assert.Equal(t, time.Second, obj.Delay(1))
+	assert.Equal(t, 3*time.Second, obj.Delay(2))
+	assert.Equal(t, 9*time.Second, obj.Delay(3))
+	assert.Equal(t, 10*time.Second, obj.Delay(4))
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	defer patcher.SetVar(&randFloat, func() float64 {
+		return 0.5
+	}).Install().Restore()
+	obj := Backoff{Initial: time.Second, Jitter: 0.5}
+
+	assert.Equal(t, 750*time.Millisecond, obj.Delay(1))
+}
+
+func TestLeaseDurationUnlimited(t *testing.T) {
+	obj := Lease{}
+
+	assert.Equal(t, time.Duration(0), obj.Duration())
+}
+
+func TestLeaseDurationBase(t *testing.T) {
+	obj := Lease{Lifetime: time.Hour}
+
+	assert.Equal(t, time.Hour, obj.Duration())
+}
+
+func TestLeaseDurationJitter(t *testing.T) {
+	defer patcher.SetVar(&randFloat, func() float64 {
+		return 0.5
+	}).Install().Restore()
+	obj := Lease{Lifetime: time.Hour, Jitter: 0.5}
+
+	assert.Equal(t, 45*time.Minute, obj.Duration())
+}
+
+// persistentEvents returns an event callback that sends the event
+// states to a channel.
+func persistentEvents() (func(ev *PersistentEvent), chan PersistentState) {
+	ch := make(chan PersistentState, 100)
+	return func(ev *PersistentEvent) {
+		ch <- ev.State
+	}, ch
+}
+
+// nextState waits for the next state from the channel.
+func nextState(t *testing.T, ch chan PersistentState) PersistentState {
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for state")
+		return PersistentClosed
+	}
+}
+
+func TestPersistentReconnect(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	l1, r1 := net.Pipe()
+	defer r1.Close()
+	l2, r2 := net.Pipe()
+	defer r2.Close()
+	c1 := &Conduit{Link: l1}
+	c2 := &Conduit{Link: l2}
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c1, nil).Once()
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError).Once()
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c2, nil).Once()
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Millisecond},
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	defer obj.Stop()
+
+	result, err := obj.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, c1, result)
+	assert.Equal(t, PersistentConnecting, nextState(t, states))
+	assert.Equal(t, PersistentOpen, nextState(t, states))
+
+	obj.Fail(c1)
+	for _, s := range []PersistentState{
+		PersistentBackoff, PersistentConnecting,
+		PersistentBackoff, PersistentConnecting, PersistentOpen,
+	} {
+		assert.Equal(t, s, nextState(t, states))
+	}
+	result, err = obj.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, c2, result)
+	assert.Equal(t, uint64(0), c1.Stats().Reconnects)
+	assert.Equal(t, uint64(1), c2.Stats().Reconnects)
+	_, err = r1.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	obj.Stop()
+	assert.Equal(t, PersistentClosed, nextState(t, states))
+	assert.Nil(t, obj.Conduit())
+	_, err = obj.Wait(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestPersistentConduitClosed(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	l1, r1 := net.Pipe()
+	defer r1.Close()
+	l2, r2 := net.Pipe()
+	defer r2.Close()
+	c1 := &Conduit{Link: l1}
+	c2 := &Conduit{Link: l2}
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c1, nil).Once()
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c2, nil).Once()
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Millisecond},
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	defer obj.Stop()
+	assert.Equal(t, PersistentConnecting, nextState(t, states))
+	assert.Equal(t, PersistentOpen, nextState(t, states))
+
+	c1.Close()
+
+	for _, s := range []PersistentState{
+		PersistentBackoff, PersistentConnecting, PersistentOpen,
+	} {
+		assert.Equal(t, s, nextState(t, states))
+	}
+	assert.Same(t, c2, obj.Conduit())
+}
+
+func TestPersistentStableFailure(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	l1, r1 := net.Pipe()
+	defer r1.Close()
+	l2, r2 := net.Pipe()
+	defer r2.Close()
+	c1 := &Conduit{Link: l1}
+	c2 := &Conduit{Link: l2}
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c1, nil).Once()
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c2, nil).Once()
+	now := time.Now()
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}).Install().Restore()
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Hour, Max: time.Minute},
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	defer obj.Stop()
+	assert.Equal(t, PersistentConnecting, nextState(t, states))
+	assert.Equal(t, PersistentOpen, nextState(t, states))
+
+	obj.Fail(c1)
+
+	assert.Equal(t, PersistentConnecting, nextState(t, states))
+	assert.Equal(t, PersistentOpen, nextState(t, states))
+	assert.Same(t, c2, obj.Conduit())
+}
+
+func TestPersistentAcceptAndClose(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	for range 3 {
+		l, r := net.Pipe()
+		r.Close()
+		mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&Conduit{Link: l}, nil).Once()
+	}
+	events := make(chan *PersistentEvent, 100)
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Millisecond, MaxRetries: 2},
+		OnEvent: func(ev *PersistentEvent) { events <- ev },
+	}
+	obj.Start(context.Background())
+	defer obj.Stop()
+
+	var err error
+	for !errors.Is(err, ErrClosed) {
+		_, err = obj.Recv(context.Background())
+	}
+
+	assert.ErrorIs(t, err, ErrGaveUp)
+	mech.AssertNumberOfCalls(t, "Dial", 3)
+	var backoffs []*PersistentEvent
+	for len(events) > 0 {
+		if ev := <-events; ev.State == PersistentBackoff {
+			backoffs = append(backoffs, ev)
+		}
+	}
+	if assert.Len(t, backoffs, 2) {
+		assert.Equal(t, 1, backoffs[0].Failures)
+		assert.Equal(t, time.Millisecond, backoffs[0].Delay)
+		assert.Equal(t, 2, backoffs[1].Failures)
+		assert.Equal(t, 2*time.Millisecond, backoffs[1].Delay)
+	}
+}
+
+func TestPersistentGiveUp(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError)
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Millisecond, MaxRetries: 2},
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	defer obj.Stop()
+
+	result, err := obj.Wait(context.Background())
+
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, err, ErrGaveUp)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+	mech.AssertNumberOfCalls(t, "Dial", 3)
+	for _, s := range []PersistentState{
+		PersistentConnecting, PersistentBackoff,
+		PersistentConnecting, PersistentBackoff,
+		PersistentConnecting, PersistentClosed,
+	} {
+		assert.Equal(t, s, nextState(t, states))
+	}
+}
+
+func TestPersistentWaitNotStarted(t *testing.T) {
+	obj := &Persistent{}
+
+	result, err := obj.Wait(context.Background())
+
+	assert.ErrorIs(t, err, ErrNotStarted)
+	assert.Nil(t, result)
+}
+
+func TestPersistentSendNotConnected(t *testing.T) {
+	obj := &Persistent{}
+
+	err := obj.Send(nil)
+
+	assert.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestPersistentFailStale(t *testing.T) {
+	obj := &Persistent{current: &Conduit{}, fail: make(chan *Conduit, 1)}
+
+	obj.Fail(&Conduit{})
+
+	assert.Len(t, obj.fail, 0)
+}
+
+func TestPersistentSetCurrentDiscardsFailure(t *testing.T) {
+	c := &Conduit{}
+	obj := &Persistent{current: c, ready: make(chan struct{}), fail: make(chan *Conduit, 1)}
+	close(obj.ready)
+	obj.Fail(c)
+
+	obj.setCurrent(nil)
+
+	assert.Len(t, obj.fail, 0)
+	assert.False(t, isClosed(obj.ready))
+}
+
+func TestPersistentStartTwice(t *testing.T) {
+	obj, _ := startPersistent(t, &Conduit{})
+	done := obj.done
+
+	obj.Start(context.Background())
+
+	assert.Equal(t, done, obj.done)
+}
+
+func TestPersistentStopWhileDialing(t *testing.T) {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	dialing := make(chan struct{})
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		close(dialing)
+		<-args.Get(0).(context.Context).Done()
+	}).Return(nil, context.Canceled)
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	<-dialing
+
+	obj.Stop()
+
+	assert.Equal(t, PersistentConnecting, nextState(t, states))
+	assert.Equal(t, PersistentClosed, nextState(t, states))
+	_, err := obj.Wait(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestPersistentWaitCancelled(t *testing.T) {
+	obj := &Persistent{ready: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := obj.Wait(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestPersistentFailPending(t *testing.T) {
+	c := &Conduit{}
+	obj := &Persistent{current: c, fail: make(chan *Conduit, 1)}
+	obj.Fail(c)
+
+	obj.Fail(c)
+
+	assert.Len(t, obj.fail, 1)
+}
+
+// startPersistent starts a persistent conduit dialing the specified
+// conduits in turn, returning it and a channel of its states.
+func startPersistent(t *testing.T, conduits ...*Conduit) (*Persistent, chan PersistentState) {
+	mech, p := happyMech()
+	p.Install()
+	t.Cleanup(func() { p.Restore() })
+	for _, c := range conduits {
+		mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(c, nil).Once()
+	}
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError)
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://127.0.0.1:1"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Hour},
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	t.Cleanup(obj.Stop)
+	assert.Equal(t, PersistentConnecting, nextState(t, states))
+	assert.Equal(t, PersistentOpen, nextState(t, states))
+
+	return obj, states
+}
+
+func TestPersistentSendBase(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	obj, _ := startPersistent(t, &Conduit{Link: l, Integrity: true})
+	peer := &Conduit{Link: r}
+	go obj.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("hello")}) //nolint:errcheck
+
+	result, err := peer.Recv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result.Payload)
+}
+
+func TestPersistentSendFailed(t *testing.T) {
+	l, r := net.Pipe()
+	r.Close()
+	obj, states := startPersistent(t, &Conduit{Link: l, Integrity: true})
+
+	err := obj.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("hello")})
+
+	assert.Error(t, err)
+	assert.Equal(t, PersistentBackoff, nextState(t, states))
+}
+
+func TestPersistentRecvBase(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	obj, _ := startPersistent(t, &Conduit{Link: l})
+	peer := &Conduit{Link: r, Integrity: true}
+	go peer.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("hello")}) //nolint:errcheck
+
+	result, err := obj.Recv(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result.Payload)
+}
+
+func TestPersistentRecvNotStarted(t *testing.T) {
+	obj := &Persistent{}
+
+	result, err := obj.Recv(context.Background())
+
+	assert.ErrorIs(t, err, ErrNotStarted)
+	assert.Nil(t, result)
+}
+
+func TestPersistentRecvFailed(t *testing.T) {
+	l, r := net.Pipe()
+	r.Close()
+	obj, states := startPersistent(t, &Conduit{Link: l})
+
+	result, err := obj.Recv(context.Background())
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+	assert.Equal(t, PersistentBackoff, nextState(t, states))
+}
+
+func TestPersistentRecvCancelled(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	c := &Conduit{Link: l}
+	obj, _ := startPersistent(t, c)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	result, err := obj.Recv(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+	assert.Same(t, c, obj.Conduit())
+	peer := &Conduit{Link: r, Integrity: true}
+	go peer.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("hello")}) //nolint:errcheck
+	result, err = obj.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result.Payload)
+}
+
+// leaseTest runs a persistent conduit whose first conduit's lease
+// expires, returning the replacement conduit.
+func leaseTest(t *testing.T, lease Lease, redial string) *Conduit {
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	l1, r1 := net.Pipe()
+	defer r1.Close()
+	l2, r2 := net.Pipe()
+	defer r2.Close()
+	go io.Copy(io.Discard, r1) //nolint:errcheck
+	go io.Copy(io.Discard, r2) //nolint:errcheck
+	c1 := &Conduit{Link: l1, RemoteURI: mustParse("tcp://192.0.2.1:1234")}
+	c2 := &Conduit{Link: l2}
+	mech.On("Dial", mock.Anything, mock.Anything, uriHost("[::1]:1234"), mock.Anything).Return(c1, nil).Once()
+	mech.On("Dial", mock.Anything, mock.Anything, uriHost(redial), mock.Anything).Return(c2, nil).Once()
+	mech.On("Dial", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, assert.AnError)
+	onEvent, states := persistentEvents()
+	obj := &Persistent{
+		URI:     mustParse("tcp://example.com:1234"),
+		Config:  &mockConfig{},
+		Backoff: Backoff{Initial: time.Hour},
+		Lease:   lease,
+		OnEvent: onEvent,
+	}
+	obj.Start(context.Background())
+	defer obj.Stop()
+
+	for _, s := range []PersistentState{
+		PersistentConnecting, PersistentOpen, PersistentDraining,
+		PersistentConnecting, PersistentOpen,
+	} {
+		assert.Equal(t, s, nextState(t, states))
+	}
+	_, err := l1.Write([]byte("x"))
+	assert.Error(t, err)
+
+	return obj.Conduit()
+}
+
+func TestPersistentLeaseRedial(t *testing.T) {
+	result := leaseTest(t, Lease{Lifetime: 20 * time.Millisecond, DrainTimeout: time.Millisecond}, "192.0.2.1:1234")
+
+	assert.NotNil(t, result)
+}
+
+func TestPersistentLeaseRecanonicalize(t *testing.T) {
+	result := leaseTest(t, Lease{
+		Lifetime:       20 * time.Millisecond,
+		DrainTimeout:   time.Millisecond,
+		Recanonicalize: true,
+	}, "[::1]:1234")
+
+	assert.NotNil(t, result)
+}
+
+func TestPersistentExpireDrained(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	c := &Conduit{Link: l}
+	obj := &Persistent{Lease: Lease{DrainTimeout: time.Hour}, fail: make(chan *Conduit, 1)}
+	go func() {
+		peer := &Conduit{Link: r}
+		peer.Recv() //nolint:errcheck
+		c.HandleDrain(&proto.ControlMessage{Type: proto.ControlDrainAck})
+	}()
+
+	obj.expire(context.Background(), c)
+
+	assert.True(t, isClosed(c.drain.drained))
+}
+
+func TestPersistentExpireDrainError(t *testing.T) {
+	l, r := net.Pipe()
+	r.Close()
+	onEvent, states := persistentEvents()
+	obj := &Persistent{OnEvent: onEvent}
+
+	obj.expire(context.Background(), &Conduit{Link: l})
+
+	assert.Equal(t, PersistentDraining, nextState(t, states))
+}
+
+func TestPersistentExpireFailed(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	go io.Copy(io.Discard, r) //nolint:errcheck
+	c := &Conduit{Link: l}
+	obj := &Persistent{fail: make(chan *Conduit, 1)}
+	obj.fail <- c
+
+	obj.expire(context.Background(), c)
+
+	assert.Len(t, obj.fail, 0)
+}
+
+func TestPersistentExpireClosed(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	go io.Copy(io.Discard, r) //nolint:errcheck
+	c := &Conduit{Link: l}
+	obj := &Persistent{fail: make(chan *Conduit, 1)}
+	c.cancelContext(ErrConduitClosed)
+
+	obj.expire(context.Background(), c)
+
+	assert.Len(t, obj.fail, 0)
+}
+
+func TestPersistentExpireCancelled(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	go io.Copy(io.Discard, r) //nolint:errcheck
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	obj := &Persistent{fail: make(chan *Conduit, 1)}
+
+	obj.expire(ctx, &Conduit{Link: l})
+
+	assert.Len(t, obj.fail, 0)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// updateRTT updates the round-trip time estimates of the conduit.
+// The RTT and Deviation fields are in microseconds.
+func (c *Conduit) updateRTT(rtt, dev time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.RTT = uint32(min(rtt.Microseconds(), int64(^uint32(0))))
+	c.Deviation = uint32(min(dev.Microseconds(), int64(^uint32(0))))
+}
+
+// RTTEstimate returns the round-trip time estimate of the conduit and
+// its deviation.  It is safe to call while a Pinger is updating the
+// estimate.
+func (c *Conduit) RTTEstimate() (time.Duration, time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return time.Duration(c.RTT) * time.Microsecond, time.Duration(c.Deviation) * time.Microsecond
+}
+
+// updateBandwidth updates the bandwidth estimate of the conduit.
+func (c *Conduit) updateBandwidth(bps uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Bandwidth = bps
+}
+
+// updateLoss updates the loss estimate of the conduit.
+func (c *Conduit) updateLoss(loss float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.Loss = loss
+}
+
+// LinkEstimate returns the bandwidth and loss estimates of the
+// conduit.  It is safe to call while a Pinger is updating the
+// estimates.
+func (c *Conduit) LinkEstimate() (uint64, float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.Bandwidth, c.Loss
+}
+
+// Pinger constructs a proto.Pinger that sends pings over the conduit
+// and maintains its RTT, Deviation, Bandwidth, and Loss fields; the
+// bandwidth is only measured if the caller sets the pinger's
+// ProbeEvery.  The unresponsive function, if not nil, is called when
+// the peer stops responding to pings.  The caller must start the
+// pinger, and must pass ping and pong control messages received over
+// the conduit to its Handle method.
+func (c *Conduit) Pinger(interval time.Duration, maxMissed int, unresponsive func()) *proto.Pinger {
+	return &proto.Pinger{
+		Interval:       interval,
+		MaxMissed:      maxMissed,
+		Send:           c.Send,
+		OnRTT:          c.updateRTT,
+		OnBandwidth:    c.updateBandwidth,
+		OnLoss:         c.updateLoss,
+		OnUnresponsive: unresponsive,
+	}
+}
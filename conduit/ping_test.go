@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestConduitUpdateRTT(t *testing.T) {
+	obj := &Conduit{}
+
+	obj.updateRTT(1500*time.Microsecond, 2*time.Hour)
+
+	assert.Equal(t, uint32(1500), obj.RTT)
+	assert.Equal(t, ^uint32(0), obj.Deviation)
+}
+
+func TestConduitRTTEstimate(t *testing.T) {
+	obj := &Conduit{RTT: 1500, Deviation: 250}
+
+	rtt, dev := obj.RTTEstimate()
+
+	assert.Equal(t, 1500*time.Microsecond, rtt)
+	assert.Equal(t, 250*time.Microsecond, dev)
+}
+
+func TestConduitLinkEstimate(t *testing.T) {
+	obj := &Conduit{}
+	obj.updateBandwidth(1000000)
+	obj.updateLoss(0.25)
+
+	bandwidth, loss := obj.LinkEstimate()
+
+	assert.Equal(t, uint64(1000000), bandwidth)
+	assert.Equal(t, 0.25, loss)
+}
+
+func TestConduitPinger(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", []byte{0x00, 0x00, 0x00, 0x09, 0x05, 0, 0, 0, 0, 0, 0, 0, 7}).Return(13, nil)
+	obj := &Conduit{Link: link, Integrity: true}
+	called := false
+
+	result := obj.Pinger(time.Second, 5, func() {
+		called = true
+	})
+	result.OnRTT(time.Millisecond, time.Microsecond)
+	result.OnBandwidth(8000)
+	result.OnLoss(0.5)
+	result.OnUnresponsive()
+	result.Handle(&proto.ControlMessage{Type: proto.ControlPing, Body: []byte{0, 0, 0, 0, 0, 0, 0, 7}})
+
+	assert.Equal(t, time.Second, result.Interval)
+	assert.Equal(t, 5, result.MaxMissed)
+	assert.Equal(t, uint32(1000), obj.RTT)
+	assert.Equal(t, uint32(1), obj.Deviation)
+	assert.Equal(t, uint64(8000), obj.Bandwidth)
+	assert.Equal(t, 0.5, obj.Loss)
+	assert.True(t, called)
+	link.AssertExpectations(t)
+}
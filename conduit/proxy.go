@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyOption is an option for Dial that establishes outgoing
+// conduits through a proxy.  It is applied by the mechanism, and may
+// be unsupported by some mechanisms; mechanisms that do not support
+// proxies ignore the option.
+type ProxyOption struct {
+	URL *url.URL // The URL of the proxy
+}
+
+// Proxy returns an option that establishes outgoing conduits through
+// the proxy at the specified URL.  The only supported scheme is
+// "socks5"; a username and password in the URL are used to
+// authenticate to the proxy.
+func Proxy(u *url.URL) *ProxyOption {
+	return &ProxyOption{
+		URL: u,
+	}
+}
+
+// DialApply applies the option to a net.Dialer.  It has no effect.
+func (p *ProxyOption) DialApply(d *net.Dialer) {}
+
+// dialer wraps a dialer so that connections are established through
+// the proxy.
+func (p *ProxyOption) dialer(forward iDialer) (iDialer, error) {
+	switch p.URL.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if p.URL.User != nil {
+			pass, _ := p.URL.User.Password()
+			auth = &proxy.Auth{
+				User:     p.URL.User.Username(),
+				Password: pass,
+			}
+		}
+		d, err := proxySOCKS5("tcp", p.URL.Host, auth, forward)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %w", p.URL.Redacted(), err)
+		}
+		return d.(iDialer), nil
+	}
+
+	return nil, fmt.Errorf("proxy %q: %w", p.URL.Redacted(), ErrUnknownProxy)
+}
+
+// findProxy returns the last proxy option, or nil if there is none.
+func findProxy(opts []DialerOption) *ProxyOption {
+	var result *ProxyOption
+	for _, opt := range opts {
+		if p, ok := opt.(*ProxyOption); ok {
+			result = p
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socks5Serve serves a single SOCKS5 client on the connection,
+// requiring the specified credentials if user is not empty.
+func socks5Serve(c net.Conn, user, pass string) {
+	defer c.Close()
+
+	// Select the authentication method
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(c, hdr); err != nil {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+	if user == "" {
+		c.Write([]byte{5, 0}) //nolint:errcheck
+	} else {
+		c.Write([]byte{5, 2}) //nolint:errcheck
+		buf := make([]byte, 2)
+		io.ReadFull(c, buf) //nolint:errcheck
+		u := make([]byte, buf[1])
+		io.ReadFull(c, u)       //nolint:errcheck
+		io.ReadFull(c, buf[:1]) //nolint:errcheck
+		p := make([]byte, buf[0])
+		io.ReadFull(c, p) //nolint:errcheck
+		if string(u) != user || string(p) != pass {
+			c.Write([]byte{1, 1}) //nolint:errcheck
+			return
+		}
+		c.Write([]byte{1, 0}) //nolint:errcheck
+	}
+
+	// Read the request; only IPv4 addresses are supported
+	req := make([]byte, 10)
+	if _, err := io.ReadFull(c, req); err != nil || req[3] != 1 {
+		return
+	}
+	addr := net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(req[8:]))))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) //nolint:errcheck
+		return
+	}
+	defer target.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) //nolint:errcheck
+
+	// Relay the data
+	go io.Copy(target, c) //nolint:errcheck
+	io.Copy(c, target)    //nolint:errcheck
+}
+
+// socks5Proxy starts a SOCKS5 proxy, returning its URL.
+func socks5Proxy(t *testing.T, user, pass string) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go socks5Serve(c, user, pass)
+		}
+	}()
+
+	u := &url.URL{Scheme: "socks5", Host: l.Addr().String()}
+	if user != "" {
+		u.User = url.UserPassword(user, pass)
+	}
+
+	return u
+}
+
+// echoTarget starts a TCP server echoing a single read on each
+// connection, returning its URI.
+func echoTarget(t *testing.T) *URI {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			n, _ := c.Read(buf)
+			c.Write(buf[:n]) //nolint:errcheck
+			c.Close()
+		}
+	}()
+
+	return TCPAddr2URI(l.Addr())
+}
+
+func TestProxy(t *testing.T) {
+	u := &url.URL{Scheme: "socks5", Host: "127.0.0.1:1080"}
+
+	result := Proxy(u)
+
+	assert.Equal(t, &ProxyOption{URL: u}, result)
+}
+
+func TestFindProxy(t *testing.T) {
+	p1 := Proxy(&url.URL{Scheme: "socks5"})
+	p2 := Proxy(&url.URL{Scheme: "socks5"})
+
+	assert.Nil(t, findProxy([]DialerOption{KeepAlive(0)}))
+	assert.Same(t, p2, findProxy([]DialerOption{p1, KeepAlive(0), p2}))
+}
+
+func TestProxyOptionDialerUnknown(t *testing.T) {
+	obj := Proxy(&url.URL{Scheme: "gopher", Host: "proxy:70"})
+
+	result, err := obj.dialer(&net.Dialer{})
+
+	assert.ErrorIs(t, err, ErrUnknownProxy)
+	assert.Nil(t, result)
+}
+
+// dialThrough dials the target through the proxy, returning the
+// echoed data.
+func dialThrough(t *testing.T, p *url.URL, target *URI) ([]byte, error) {
+	c, err := TCPMech(0).Dial(context.Background(), nil, target, []DialerOption{Proxy(p)})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Link.Close()
+
+	if _, err := c.Link.Write([]byte("hello")); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(c.Link)
+}
+
+func TestTCPMechDialProxy(t *testing.T) {
+	result, err := dialThrough(t, socks5Proxy(t, "", ""), echoTarget(t))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result)
+}
+
+func TestTCPMechDialProxyAuth(t *testing.T) {
+	result, err := dialThrough(t, socks5Proxy(t, "user", "pass"), echoTarget(t))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), result)
+}
+
+func TestTCPMechDialProxyAuthFailed(t *testing.T) {
+	p := socks5Proxy(t, "user", "pass")
+	p.User = url.UserPassword("user", "wrong")
+
+	result, err := dialThrough(t, p, echoTarget(t))
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
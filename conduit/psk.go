@@ -0,0 +1,390 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Parameters of the PSK security layer.
+const (
+	PSKMinKeySize              = 16               // Minimum size of a pre-shared key
+	PSKMaxIdentity             = 255              // Maximum length of an identity
+	DefaultPSKHandshakeTimeout = 10 * time.Second // Time allowed for conduits to authenticate
+
+	pskNonceSize = 32                // Size of the handshake nonces
+	pskProtocol  = "humboldt-psk-v1" // Protocol label bound into the transcript
+)
+
+// PSKConfig is the configuration for the PSK security layer.  It must
+// be returned by Config.ForSecurity("psk").  A listening node
+// authenticates each peer with the key for the peer's identity from
+// Keys, falling back to Key for identities not listed; a dialing node
+// selects its key the same way using its own Identity.
+type PSKConfig struct {
+	Identity string            // Identity sent to the peer
+	Key      []byte            // The pre-shared key
+	Keys     map[string][]byte // Pre-shared keys, by identity
+	Timeout  time.Duration     // Handshake timeout
+}
+
+// pskConfig retrieves the PSK configuration.
+func pskConfig(config Config) (*PSKConfig, error) {
+	if config == nil {
+		return nil, fmt.Errorf("psk: %w", ErrMissingConfig)
+	}
+	pc, ok := config.ForSecurity("psk").(*PSKConfig)
+	if !ok || pc == nil || (len(pc.Key) == 0 && len(pc.Keys) == 0) || len(pc.Identity) > PSKMaxIdentity {
+		return nil, fmt.Errorf("psk: %w", ErrMissingConfig)
+	}
+
+	return pc, nil
+}
+
+// key selects the key to use for the specified identity.
+func (pc *PSKConfig) key(identity string) ([]byte, error) {
+	key, ok := pc.Keys[identity]
+	if !ok {
+		key = pc.Key
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("psk: %q: %w", identity, ErrPSKUnknown)
+	}
+	if len(key) < PSKMinKeySize {
+		return nil, fmt.Errorf("psk: %q: %w", identity, ErrPSKShortKey)
+	}
+
+	return key, nil
+}
+
+// pskHandshake holds the state of the PSK handshake.  The initiator
+// and responder exchange nonces and identities, then each proves
+// knowledge of the key with an HMAC over the transcript:
+//
+//	-> nonce_i, identity_i
+//	<- nonce_r, mac_r, identity_r
+//	-> mac_i
+//
+// The keys protecting the stream in each direction are derived from
+// the pre-shared key and the transcript using HKDF.
+type pskHandshake struct {
+	transcript []byte // Hash of the nonces and identities
+	key        []byte // The pre-shared key
+}
+
+// newPSKHandshake computes the transcript of a handshake.
+func newPSKHandshake(key, nonceI []byte, identI string, nonceR []byte, identR string) *pskHandshake {
+	h := sha256.New()
+	h.Write([]byte(pskProtocol))
+	for _, part := range [][]byte{nonceI, []byte(identI), nonceR, []byte(identR)} {
+		h.Write([]byte{byte(len(part))})
+		h.Write(part)
+	}
+
+	return &pskHandshake{
+		transcript: h.Sum(nil),
+		key:        key,
+	}
+}
+
+// mac computes the proof for the specified role.
+func (hs *pskHandshake) mac(role string) []byte {
+	mac := hmac.New(sha256.New, hs.key)
+	mac.Write([]byte(role))
+	mac.Write(hs.transcript)
+
+	return mac.Sum(nil)
+}
+
+// derive derives a key for the specified purpose.
+func (hs *pskHandshake) derive(purpose string, size int) []byte {
+	key, _ := hkdf.Key(sha256.New, hs.key, hs.transcript, pskProtocol+" "+purpose, size)
+
+	return key
+}
+
+// ciphers returns the cipher states for sending and receiving.
+func (hs *pskHandshake) ciphers(initiator bool) (*aeadCipher, *aeadCipher) {
+	i2r := &aeadCipher{hasKey: true}
+	copy(i2r.k[:], hs.derive("initiator", aeadKeySize))
+	r2i := &aeadCipher{hasKey: true}
+	copy(r2i.k[:], hs.derive("responder", aeadKeySize))
+
+	if initiator {
+		return i2r, r2i
+	}
+
+	return r2i, i2r
+}
+
+// pskNonce generates a handshake nonce.
+func pskNonce() ([]byte, error) {
+	nonce := make([]byte, pskNonceSize)
+	if _, err := io.ReadFull(randReader, nonce); err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+// pskRead reads a handshake message, which must be at least the
+// specified size.
+func pskRead(ctx context.Context, c *Conduit, size int) ([]byte, error) {
+	msg, err := readSealed(c.Link, c.Boundaries)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", ErrPSKAuth, err)
+	}
+	if len(msg) < size || len(msg) > size+PSKMaxIdentity {
+		return nil, fmt.Errorf("%w: %w", ErrPSKAuth, ErrShortMessage)
+	}
+
+	return msg, nil
+}
+
+// pskInitiate runs the initiator side of the handshake, returning the
+// completed handshake and the identity of the peer.
+func pskInitiate(ctx context.Context, c *Conduit, pc *PSKConfig) (*pskHandshake, string, error) {
+	key, err := pc.key(pc.Identity)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Send our nonce and identity
+	nonceI, err := pskNonce()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := writeSealed(c.Link, append(append([]byte{}, nonceI...), pc.Identity...)); err != nil {
+		return nil, "", err
+	}
+
+	// Read the responder's proof
+	msg, err := pskRead(ctx, c, pskNonceSize+sha256.Size)
+	if err != nil {
+		return nil, "", err
+	}
+	identR := string(msg[pskNonceSize+sha256.Size:])
+	hs := newPSKHandshake(key, nonceI, pc.Identity, msg[:pskNonceSize], identR)
+	if !hmac.Equal(hs.mac("responder"), msg[pskNonceSize:pskNonceSize+sha256.Size]) {
+		return nil, "", fmt.Errorf("psk: %q: %w", identR, ErrPSKAuth)
+	}
+
+	// Send our proof
+	if err := writeSealed(c.Link, hs.mac("initiator")); err != nil {
+		return nil, "", err
+	}
+
+	return hs, identR, nil
+}
+
+// pskRespond runs the responder side of the handshake, returning the
+// completed handshake and the identity of the peer.
+func pskRespond(ctx context.Context, c *Conduit, pc *PSKConfig) (*pskHandshake, string, error) {
+	// Read the initiator's nonce and identity
+	msg, err := pskRead(ctx, c, pskNonceSize)
+	if err != nil {
+		return nil, "", err
+	}
+	identI := string(msg[pskNonceSize:])
+	key, err := pc.key(identI)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Send our nonce and proof
+	nonceR, err := pskNonce()
+	if err != nil {
+		return nil, "", err
+	}
+	hs := newPSKHandshake(key, msg[:pskNonceSize], identI, nonceR, pc.Identity)
+	reply := append(append(append([]byte{}, nonceR...), hs.mac("responder")...), pc.Identity...)
+	if err := writeSealed(c.Link, reply); err != nil {
+		return nil, "", err
+	}
+
+	// Check the initiator's proof
+	if msg, err = pskRead(ctx, c, sha256.Size); err != nil {
+		return nil, "", err
+	}
+	if !hmac.Equal(hs.mac("initiator"), msg) {
+		return nil, "", fmt.Errorf("psk: %q: %w", identI, ErrPSKAuth)
+	}
+
+	return hs, identI, nil
+}
+
+// pskHandshakeConduit runs the PSK handshake over a conduit
+// established by the underlying transport, replacing its link with
+// the encrypted connection and setting its security properties.
+func pskHandshakeConduit(ctx context.Context, c *Conduit, pc *PSKConfig, initiator bool) error {
+	// Abort the handshake if the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		c.Link.SetDeadline(time.Unix(1, 0)) //nolint:errcheck
+	})
+	defer stop()
+
+	// Run the handshake
+	var hs *pskHandshake
+	var peer string
+	var err error
+	if initiator {
+		hs, peer, err = pskInitiate(ctx, c, pc)
+	} else {
+		hs, peer, err = pskRespond(ctx, c, pc)
+	}
+	if err != nil {
+		return err
+	}
+	if !stop() {
+		return ctx.Err()
+	}
+
+	// Update the conduit
+	send, recv := hs.ciphers(initiator)
+	c.Link = &aeadConn{
+		Conn:       c.Link,
+		boundaries: c.Boundaries,
+		recv:       recv,
+		send:       send,
+	}
+	c.Confidential = true
+	c.Integrity = true
+	c.Strength = 8 * uint32(min(len(hs.key), aeadKeySize))
+	c.Principal = peer
+	c.Binding = hs.derive("binding", BindingSize)
+
+	return nil
+}
+
+// PSKMech is a security layer mechanism authenticating both sides
+// with a pre-shared key over any underlying transport.  It is
+// intended for deployments where managing certificates is
+// impractical.
+type PSKMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m PSKMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	pc, err := pskConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mech := lookupTransport(u.Transport)
+	if mech == nil {
+		return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
+	}
+
+	// Dial the transport and run the handshake
+	c, err := dialTransport(ctx, mech, config, u, opts)
+	if err != nil {
+		return nil, err
+	}
+	hctx, cancel := handshakeContext(ctx, opts, pc.Timeout, DefaultPSKHandshakeTimeout)
+	defer cancel()
+	err = traceSpan(hctx, "handshake", u, func(ctx context.Context) error {
+		return pskHandshakeConduit(ctx, c, pc, true)
+	})
+	if err != nil {
+		closeLink(c)
+		return nil, err
+	}
+	c.LocalURI = securityURI(c.LocalURI, "psk")
+
+	return c, nil
+}
+
+// Listen opens a transport in passive mode; that is, for
+// connection-oriented transports, Listen creates a listener that may
+// accept connections.  For those transports that are not
+// connection-oriented, the listener synthesizes the appropriate
+// state.
+func (m PSKMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	pc, err := pskConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenTransport(ctx, config, u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PSKListener{
+		L:      l,
+		Config: pc,
+	}, nil
+}
+
+// PSKListener is an implementation of Listener for the PSK security
+// layer.
+type PSKListener struct {
+	L      Listener   // Underlying transport listener
+	Config *PSKConfig // The PSK configuration
+}
+
+// Accept waits for and returns the next conduit to the listener.
+// Conduits failing to authenticate are closed, and an AcceptError
+// describing the failure is returned.
+func (l *PSKListener) Accept() (*Conduit, error) {
+	timeout := l.Config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPSKHandshakeTimeout
+	}
+
+	c, err := l.L.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the handshake
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err = pskHandshakeConduit(ctx, c, l.Config, false)
+	cancel()
+	logHandshake("psk", c, err)
+	if err != nil {
+		closeLink(c)
+		return nil, acceptError(c, err)
+	}
+	c.LocalURI = securityURI(c.LocalURI, "psk")
+	c.RemoteURI = securityURI(c.RemoteURI, "psk")
+
+	return c, nil
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors.
+func (l *PSKListener) Close() error {
+	return l.L.Close()
+}
+
+// Addr returns the listener's network URI.
+func (l *PSKListener) Addr() *URI {
+	return securityURI(l.L.Addr(), "psk")
+}
+
+// init initializes the PSK security layer.
+func init() {
+	RegisterSecurity("psk", PSKMech(0))
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Keys for the PSK tests.
+var (
+	pskKey1 = []byte("0123456789abcdef0123456789abcdef")
+	pskKey2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestPSKMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), PSKMech(0))
+}
+
+func TestPSKListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &PSKListener{})
+}
+
+func TestPSKConfigBase(t *testing.T) {
+	pc := &PSKConfig{Key: pskKey1}
+
+	result, err := pskConfig(securityCfg("psk", pc))
+
+	assert.NoError(t, err)
+	assert.Same(t, pc, result)
+}
+
+func TestPSKConfigNil(t *testing.T) {
+	result, err := pskConfig(nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestPSKConfigMissingKey(t *testing.T) {
+	result, err := pskConfig(securityCfg("psk", &PSKConfig{Identity: "node"}))
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestPSKConfigLongIdentity(t *testing.T) {
+	result, err := pskConfig(securityCfg("psk", &PSKConfig{Identity: strings.Repeat("x", 256), Key: pskKey1}))
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestPSKConfigKey(t *testing.T) {
+	obj := &PSKConfig{
+		Key:  pskKey1,
+		Keys: map[string][]byte{"node2": pskKey2, "short": []byte("short")},
+	}
+
+	key1, err1 := obj.key("node1")
+	key2, err2 := obj.key("node2")
+	_, err3 := obj.key("short")
+
+	assert.NoError(t, err1)
+	assert.Equal(t, pskKey1, key1)
+	assert.NoError(t, err2)
+	assert.Equal(t, pskKey2, key2)
+	assert.ErrorIs(t, err3, ErrPSKShortKey)
+}
+
+func TestPSKConfigKeyUnknown(t *testing.T) {
+	obj := &PSKConfig{Keys: map[string][]byte{"node2": pskKey2}}
+
+	result, err := obj.key("node1")
+
+	assert.ErrorIs(t, err, ErrPSKUnknown)
+	assert.Nil(t, result)
+}
+
+func TestPSKHandshakeKeys(t *testing.T) {
+	hs := newPSKHandshake(pskKey1, make([]byte, 32), "node1", make([]byte, 32), "node2")
+	other := newPSKHandshake(pskKey1, make([]byte, 32), "node1", make([]byte, 32), "node3")
+
+	initSend, initRecv := hs.ciphers(true)
+	respSend, respRecv := hs.ciphers(false)
+
+	assert.Equal(t, initSend.k, respRecv.k)
+	assert.Equal(t, initRecv.k, respSend.k)
+	assert.NotEqual(t, initSend.k, initRecv.k)
+	assert.NotEqual(t, hs.mac("initiator"), hs.mac("responder"))
+	assert.NotEqual(t, hs.transcript, other.transcript)
+}
+
+func TestPSKHandshakeConduitBase(t *testing.T) {
+	initCfg := &PSKConfig{Identity: "node1", Key: pskKey1}
+	respCfg := &PSKConfig{Identity: "node2", Keys: map[string][]byte{"node1": pskKey1}}
+
+	init, resp, initErr, respErr := handshakePair(pskHandshakeConduit, initCfg, respCfg)
+	defer init.Link.Close()
+	defer resp.Link.Close()
+
+	require.NoError(t, initErr)
+	require.NoError(t, respErr)
+	assert.True(t, init.Confidential)
+	assert.True(t, init.Integrity)
+	assert.Equal(t, uint32(256), init.Strength)
+	assert.Equal(t, "node2", init.Principal)
+	assert.Equal(t, "node1", resp.Principal)
+	assert.Len(t, init.Binding, BindingSize)
+	assert.Equal(t, init.Binding, resp.Binding)
+
+	// Exchange data in both directions
+	go init.Link.Write([]byte("ping")) //nolint:errcheck
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(resp.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ping"), buf)
+	go resp.Link.Write([]byte("pong")) //nolint:errcheck
+	_, err = io.ReadFull(init.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pong"), buf)
+}
+
+func TestPSKHandshakeConduitWrongKey(t *testing.T) {
+	initCfg := &PSKConfig{Identity: "node1", Key: pskKey1}
+	respCfg := &PSKConfig{Identity: "node2", Key: pskKey2}
+
+	_, resp, initErr, respErr := handshakePair(pskHandshakeConduit, initCfg, respCfg)
+
+	assert.ErrorIs(t, initErr, ErrPSKAuth)
+	assert.Error(t, respErr)
+	assert.False(t, resp.Confidential)
+}
+
+func TestPSKHandshakeConduitUnknownIdentity(t *testing.T) {
+	initCfg := &PSKConfig{Identity: "node3", Key: pskKey1}
+	respCfg := &PSKConfig{Identity: "node2", Keys: map[string][]byte{"node1": pskKey1}}
+
+	_, _, initErr, respErr := handshakePair(pskHandshakeConduit, initCfg, respCfg)
+
+	assert.ErrorIs(t, respErr, ErrPSKUnknown)
+	assert.Error(t, initErr)
+}
+
+func TestPSKHandshakeConduitRandError(t *testing.T) {
+	defer patcher.SetVar(&randReader, io.Reader(strings.NewReader(""))).Install().Restore()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{State: Active, Link: c1}
+
+	err := pskHandshakeConduit(context.Background(), obj, &PSKConfig{Key: pskKey1}, true)
+
+	assert.True(t, errors.Is(err, io.EOF))
+}
+
+func TestPSKHandshakeConduitCancelled(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Conduit{State: Passive, Link: c1}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := pskHandshakeConduit(ctx, obj, &PSKConfig{Key: pskKey1}, false)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPSKMechDialListen(t *testing.T) {
+	srvCfg := securityCfg("psk", &PSKConfig{Identity: "server", Keys: map[string][]byte{"client": pskKey1}})
+	cliCfg := securityCfg("psk", &PSKConfig{Identity: "client", Key: pskKey1})
+	u, _ := Parse("tcp+psk://127.0.0.1:0")
+	l, err := PSKMech(0).Listen(context.Background(), srvCfg, u, nil)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, "tcp+psk", l.Addr().Scheme)
+	accepted := make(chan *Conduit, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+		close(accepted)
+	}()
+
+	cli, err := PSKMech(0).Dial(context.Background(), cliCfg, l.Addr(), nil)
+
+	require.NoError(t, err)
+	defer cli.Link.Close()
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+	assert.Equal(t, "server", cli.Principal)
+	assert.Equal(t, "client", srv.Principal)
+	assert.Equal(t, "tcp+psk", srv.LocalURI.Scheme)
+	assert.Equal(t, "tcp+psk", srv.RemoteURI.Scheme)
+}
+
+func TestPSKMechDialMissingConfig(t *testing.T) {
+	u, _ := Parse("tcp+psk://127.0.0.1:1234")
+
+	result, err := PSKMech(0).Dial(context.Background(), nil, u, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestPSKMechDialUnknownTransport(t *testing.T) {
+	u, _ := Parse("bogus+psk://127.0.0.1:1234")
+
+	result, err := PSKMech(0).Dial(context.Background(), securityCfg("psk", &PSKConfig{Key: pskKey1}), u, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestPSKMechListenUnknownTransport(t *testing.T) {
+	u, _ := Parse("bogus+psk://127.0.0.1:1234")
+
+	result, err := PSKMech(0).Listen(context.Background(), securityCfg("psk", &PSKConfig{Key: pskKey1}), u, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestPSKMechRegistered(t *testing.T) {
+	assert.Equal(t, PSKMech(0), LookupSecurity("psk"))
+}
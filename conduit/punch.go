@@ -0,0 +1,210 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Constants used for hole punching.
+const (
+	PunchProbes   = 5                     // Probes sent to open the bindings
+	PunchInterval = 20 * time.Millisecond // Interval between the probes
+)
+
+// punchSocket is implemented by listeners from whose sockets conduits
+// may be dialed through punched holes.
+type punchSocket interface {
+	stunSocket
+
+	// punchDial opens a conduit in active mode from the socket to
+	// the specified address.
+	punchDial(ctx context.Context, config Config, raddr *net.UDPAddr) (*Conduit, error)
+}
+
+// punchLayer runs a security layer over a conduit dialed through a
+// punched hole.
+type punchLayer func(ctx context.Context, c *Conduit) error
+
+// handshakeLayer returns a punchLayer running a security layer
+// handshake, bounded by the configured timeout or the default, and
+// adding the name of the security layer to the URIs of the conduit.
+func handshakeLayer(name string, timeout, def time.Duration, handshake func(ctx context.Context, c *Conduit) error) punchLayer {
+	return func(ctx context.Context, c *Conduit) error {
+		hctx, cancel := handshakeContext(ctx, nil, timeout, def)
+		defer cancel()
+		if err := handshake(hctx, c); err != nil {
+			return err
+		}
+		c.LocalURI = securityURI(c.LocalURI, name)
+		c.RemoteURI = securityURI(c.RemoteURI, name)
+
+		return nil
+	}
+}
+
+// noiseLayer returns a punchLayer running the initiator side of the
+// Noise handshake.
+func noiseLayer(nc *NoiseConfig) punchLayer {
+	return handshakeLayer("noise", nc.Timeout, DefaultNoiseHandshakeTimeout, func(ctx context.Context, c *Conduit) error {
+		return noiseHandshakeConduit(ctx, c, nc, true)
+	})
+}
+
+// pskLayer returns a punchLayer running the initiator side of the PSK
+// handshake.
+func pskLayer(pc *PSKConfig) punchLayer {
+	return handshakeLayer("psk", pc.Timeout, DefaultPSKHandshakeTimeout, func(ctx context.Context, c *Conduit) error {
+		return pskHandshakeConduit(ctx, c, pc, true)
+	})
+}
+
+// punchSocketOf returns the socket underlying a listener, looking
+// through security layers and other listener wrappers, if conduits
+// may be dialed from it through punched holes.  The security layers
+// to run over those conduits are returned as well, innermost first.
+func punchSocketOf(l Listener) (punchSocket, []punchLayer, bool) {
+	var layers []punchLayer
+	for {
+		switch tmp := l.(type) {
+		case punchSocket:
+			for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+				layers[i], layers[j] = layers[j], layers[i]
+			}
+			return tmp, layers, true
+		case *NoiseListener:
+			layers = append(layers, noiseLayer(tmp.Config))
+			l = tmp.L
+		case *PSKListener:
+			layers = append(layers, pskLayer(tmp.Config))
+			l = tmp.L
+		case *rateListener:
+			l = tmp.Listener
+		case *sniffListener:
+			l = tmp.Listener
+		case *filterListener:
+			l = tmp.Listener
+		case *deadlineListener:
+			l = tmp.Listener
+		case *handshakeListener:
+			l = tmp.Listener
+		default:
+			return nil, nil, false
+		}
+	}
+}
+
+// Punchable tests whether conduits may be established through holes
+// punched from the socket of a listener; that is, whether Probe and
+// Punch may be used with it.  Only UDP and QUIC listeners, optionally
+// wrapped by the Noise or PSK security layers, are punchable.
+func Punchable(l Listener) bool {
+	_, _, ok := punchSocketOf(l)
+
+	return ok
+}
+
+// probe sends PunchProbes hole punching probes from a socket to an
+// address, PunchInterval apart.
+func probe(ctx context.Context, sock punchSocket, raddr *net.UDPAddr) error {
+	ticker := time.NewTicker(PunchInterval)
+	defer ticker.Stop()
+
+	for i := range PunchProbes {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		msg, err := stunIndication()
+		if err != nil {
+			return err
+		}
+		if _, err := sock.stunWriteTo(msg, raddr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Probe punches a hole toward a remote URI through the network
+// address translators in front of a UDP or QUIC listener, so that the
+// conduit the remote node dials with Punch may reach the listener.
+// PunchProbes STUN binding indications, which elicit no response and
+// which listeners discard, are sent from the listener's socket to the
+// host of the remote URI, PunchInterval apart.  Only the host of the
+// remote URI is used.  If the listener is not punchable, an error
+// wrapping ErrPunchUnsupported is returned.
+func Probe(ctx context.Context, l Listener, remote *URI) error {
+	sock, _, ok := punchSocketOf(l)
+	if !ok {
+		return fmt.Errorf("%s: %w", l.Addr(), ErrPunchUnsupported)
+	}
+	raddr, err := resolveUDPAddr("udp", remote.Host)
+	if err != nil {
+		return fmt.Errorf("%s: %w", remote, err)
+	}
+
+	return probe(ctx, sock, raddr)
+}
+
+// Punch dials a conduit to a remote URI through a hole punched from
+// the socket of a UDP or QUIC listener, while the remote node sends
+// probes toward the listener with Probe.  Probes are first sent as
+// Probe sends them, opening the bindings of the local network address
+// translators, then the conduit is dialed from the listener's
+// socket, so that it passes through them.  The security layers of
+// the listener are run over the conduit in active mode, so that the
+// conduit is secured as the remote listener expects if both
+// listeners are configured alike.  Only the host of the remote URI is
+// used.  If the listener is not punchable, an error wrapping
+// ErrPunchUnsupported is returned, and if the socket already carries
+// a conduit to the remote address, ErrPunchConflict.
+func Punch(ctx context.Context, config Config, l Listener, remote *URI) (*Conduit, error) {
+	sock, layers, ok := punchSocketOf(l)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", l.Addr(), ErrPunchUnsupported)
+	}
+	raddr, err := resolveUDPAddr("udp", remote.Host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", remote, err)
+	}
+
+	// Open the bindings and dial the conduit
+	if err := probe(ctx, sock, raddr); err != nil {
+		return nil, fmt.Errorf("%s: %w", remote, err)
+	}
+	c, err := sock.punchDial(ctx, config, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", remote, err)
+	}
+
+	// Run the security layers
+	for _, layer := range layers {
+		if err := layer(ctx, c); err != nil {
+			closeLink(c)
+			return nil, fmt.Errorf("%s: %w", remote, err)
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePunchSocket is a listener implementing punchSocket for testing.
+type fakePunchSocket struct {
+	fakeSTUNSocket
+
+	conduit *Conduit // Conduit to return from punchDial
+	err     error    // Error to return from punchDial
+}
+
+// punchDial returns the conduit or error.
+func (s *fakePunchSocket) punchDial(ctx context.Context, config Config, raddr *net.UDPAddr) (*Conduit, error) {
+	return s.conduit, s.err
+}
+
+// Accept is not used.
+func (s *fakePunchSocket) Accept() (*Conduit, error) {
+	return nil, net.ErrClosed
+}
+
+// Close is not used.
+func (s *fakePunchSocket) Close() error {
+	return nil
+}
+
+// Addr returns an empty URI.
+func (s *fakePunchSocket) Addr() *URI {
+	return &URI{}
+}
+
+func TestPunchSocketOfBase(t *testing.T) {
+	udp := &UDPListener{}
+	quicL := &QUICListener{}
+	for name, test := range map[string]struct {
+		l      Listener
+		sock   punchSocket
+		layers int
+	}{
+		"udp":    {udp, udp, 0},
+		"quic":   {quicL, quicL, 0},
+		"noise":  {&NoiseListener{L: udp, Config: &NoiseConfig{}}, udp, 1},
+		"psk":    {&PSKListener{L: &NoiseListener{L: udp, Config: &NoiseConfig{}}, Config: &PSKConfig{}}, udp, 2},
+		"rate":   {&rateListener{Listener: &sniffListener{Listener: udp}}, udp, 0},
+		"accept": {&handshakeListener{Listener: &NoiseListener{L: &deadlineListener{Listener: &filterListener{Listener: udp}}, Config: &NoiseConfig{}}}, udp, 1},
+	} {
+		sock, layers, ok := punchSocketOf(test.l)
+
+		assert.True(t, ok, name)
+		assert.Same(t, test.sock, sock, name)
+		assert.Len(t, layers, test.layers, name)
+	}
+}
+
+func TestPunchSocketOfUnsupported(t *testing.T) {
+	for name, l := range map[string]Listener{
+		"tcp": &TCPListener{},
+		"tls": &TLSListener{L: &UDPListener{}},
+	} {
+		sock, layers, ok := punchSocketOf(l)
+
+		assert.False(t, ok, name)
+		assert.Nil(t, sock, name)
+		assert.Nil(t, layers, name)
+	}
+}
+
+func TestPunchable(t *testing.T) {
+	assert.True(t, Punchable(&NoiseListener{L: &UDPListener{}, Config: &NoiseConfig{}}))
+	assert.False(t, Punchable(&TCPListener{}))
+}
+
+func TestProbeBase(t *testing.T) {
+	sock := &fakePunchSocket{fakeSTUNSocket: fakeSTUNSocket{sent: make(chan []byte, PunchProbes)}}
+
+	err := probe(context.Background(), sock, &net.UDPAddr{})
+
+	assert.NoError(t, err)
+	require.Len(t, sock.sent, PunchProbes)
+	assert.True(t, isSTUNIndication(<-sock.sent))
+}
+
+func TestProbeContext(t *testing.T) {
+	sock := &fakePunchSocket{fakeSTUNSocket: fakeSTUNSocket{sent: make(chan []byte, PunchProbes)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := probe(ctx, sock, &net.UDPAddr{})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, sock.sent, 1)
+}
+
+func TestProbeWriteError(t *testing.T) {
+	sock := &fakePunchSocket{fakeSTUNSocket: fakeSTUNSocket{err: assert.AnError}}
+
+	err := probe(context.Background(), sock, &net.UDPAddr{})
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestProbeUnsupported(t *testing.T) {
+	l := &TCPListener{URI: &URI{}}
+
+	err := Probe(context.Background(), l, &URI{})
+
+	assert.ErrorIs(t, err, ErrPunchUnsupported)
+}
+
+func TestProbeResolveError(t *testing.T) {
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	err := Probe(context.Background(), &UDPListener{}, &URI{})
+
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestProbeDiscarded(t *testing.T) {
+	l1, err := Listen(context.Background(), nil, "udp://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l1.Close()
+	l2, err := Listen(context.Background(), nil, "udp://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l2.Close()
+	accepted := make(chan *Conduit, 1)
+	go func() {
+		if c, err := l2.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	err = Probe(context.Background(), l1, l2.Addr())
+
+	assert.NoError(t, err)
+	select {
+	case <-accepted:
+		assert.Fail(t, "probe accepted as a conduit")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPunchUnsupported(t *testing.T) {
+	l := &TCPListener{URI: &URI{}}
+
+	result, err := Punch(context.Background(), nil, l, &URI{})
+
+	assert.ErrorIs(t, err, ErrPunchUnsupported)
+	assert.Nil(t, result)
+}
+
+func TestPunchResolveError(t *testing.T) {
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := Punch(context.Background(), nil, &UDPListener{}, &URI{})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+}
+
+func TestPunchConflict(t *testing.T) {
+	l, err := Listen(context.Background(), nil, "udp://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	remote, err := Parse("udp://127.0.0.1:9")
+	require.NoError(t, err)
+	c, err := Punch(context.Background(), nil, l, remote)
+	require.NoError(t, err)
+	defer c.Link.Close()
+
+	result, err := Punch(context.Background(), nil, l, remote)
+
+	assert.ErrorIs(t, err, ErrPunchConflict)
+	assert.Nil(t, result)
+}
+
+func TestPunchLayerError(t *testing.T) {
+	link := &mockConn{}
+	link.On("Write", mock.Anything).Return(0, assert.AnError)
+	link.On("Close").Return(nil)
+	sock := &fakePunchSocket{
+		fakeSTUNSocket: fakeSTUNSocket{sent: make(chan []byte, PunchProbes)},
+		conduit:        &Conduit{Link: link},
+	}
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return &net.UDPAddr{}, nil
+	}).Install().Restore()
+	l := &NoiseListener{L: sock, Config: &NoiseConfig{}}
+
+	result, err := Punch(context.Background(), nil, l, &URI{})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+	link.AssertCalled(t, "Close")
+}
+
+func TestPunchProbeError(t *testing.T) {
+	sock := &fakePunchSocket{fakeSTUNSocket: fakeSTUNSocket{err: assert.AnError}}
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return &net.UDPAddr{}, nil
+	}).Install().Restore()
+
+	result, err := Punch(context.Background(), nil, sock, &URI{})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+}
+
+func TestPunchDialError(t *testing.T) {
+	sock := &fakePunchSocket{
+		fakeSTUNSocket: fakeSTUNSocket{sent: make(chan []byte, PunchProbes)},
+		err:            assert.AnError,
+	}
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return &net.UDPAddr{}, nil
+	}).Install().Restore()
+
+	result, err := Punch(context.Background(), nil, sock, &URI{})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+	assert.Len(t, sock.sent, PunchProbes)
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueryOption describes an option that may be given as a query
+// parameter of a conduit URI; for instance, the URI
+// "tcp://10.0.0.1:1234?keepalive=30s&nodelay=false" sets the
+// KeepAlive and NoDelay options.  Either constructor may be nil if
+// the option does not apply to dialing or listening.
+type QueryOption struct {
+	Dial   func(value string) (DialerOption, error)   // Constructs the option for dialing
+	Listen func(value string) (ListenerOption, error) // Constructs the option for listening
+}
+
+// queryDuration constructs a duration option, such as KeepAlive,
+// from a duration.
+func queryDuration[T ~int64](value string) (T, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return T(d), nil
+}
+
+// queryNoDelay constructs a NoDelay option from a boolean.
+func queryNoDelay(value string) (NoDelay, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, err
+	}
+
+	return NoDelay(b), nil
+}
+
+// queryReusePort constructs a SocketOption setting SO_REUSEPORT from
+// a boolean.
+func queryReusePort(value string) (SocketOption, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return SocketOption{}, err
+	}
+
+	result := ReusePort()
+	if !b {
+		result.Value = 0
+	}
+
+	return result, nil
+}
+
+// queryBuffer constructs a SocketOption setting a buffer size from an
+// integer.
+func queryBuffer(opt SockOpt, value string) (SocketOption, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return SocketOption{}, err
+	} else if n <= 0 {
+		return SocketOption{}, fmt.Errorf("%w: buffer size %d must be positive", ErrOptionRange, n)
+	}
+
+	return SocketOption{Opt: opt, Value: n}, nil
+}
+
+// queryFastOpen constructs a FastOpenOption from a queue length.
+func queryFastOpen(value string) (FastOpenOption, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return FastOpenOption{}, err
+	} else if n <= 0 {
+		return FastOpenOption{}, fmt.Errorf("%w: queue length %d must be positive", ErrOptionRange, n)
+	}
+
+	return FastOpenOption{Queue: n}, nil
+}
+
+// queryAcceptRate constructs an AcceptRate option from a rate in
+// conduits per second.
+func queryAcceptRate(value string) (AcceptRate, error) {
+	r, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return AcceptRate{}, err
+	} else if r <= 0 {
+		return AcceptRate{}, fmt.Errorf("%w: rate %g must be positive", ErrOptionRange, r)
+	}
+
+	return AcceptRate{Rate: r}, nil
+}
+
+// querySourceRate constructs a SourceRate option from a rate in
+// conduits per second.
+func querySourceRate(value string) (SourceRate, error) {
+	r, err := queryAcceptRate(value)
+
+	return SourceRate(r), err
+}
+
+// queryMaxHandshakes constructs a MaxHandshakes option from an
+// integer; 0 runs the handshakes one at a time.
+func queryMaxHandshakes(value string) (MaxHandshakes, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	} else if n < 0 {
+		return 0, fmt.Errorf("%w: handshake limit %d must not be negative", ErrOptionRange, n)
+	}
+
+	return MaxHandshakes(n), nil
+}
+
+// querySources constructs an AcceptFilter from a comma-separated list
+// of networks, using the specified constructor.
+func querySources(value string, filter func(...*net.IPNet) AcceptFilter) (AcceptFilter, error) {
+	nets, err := ParseSources(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter(nets...), nil
+}
+
+// queryOpts is the registry of query options.
+var (
+	queryOpts = map[string]QueryOption{
+		"keepalive": {
+			Dial:   func(v string) (DialerOption, error) { return queryDuration[KeepAlive](v) },
+			Listen: func(v string) (ListenerOption, error) { return queryDuration[KeepAlive](v) },
+		},
+		"reuseport": {
+			Dial:   func(v string) (DialerOption, error) { return queryReusePort(v) },
+			Listen: func(v string) (ListenerOption, error) { return queryReusePort(v) },
+		},
+		"rcvbuf": {
+			Dial:   func(v string) (DialerOption, error) { return queryBuffer(SockOptReadBuffer, v) },
+			Listen: func(v string) (ListenerOption, error) { return queryBuffer(SockOptReadBuffer, v) },
+		},
+		"sndbuf": {
+			Dial:   func(v string) (DialerOption, error) { return queryBuffer(SockOptWriteBuffer, v) },
+			Listen: func(v string) (ListenerOption, error) { return queryBuffer(SockOptWriteBuffer, v) },
+		},
+		"bindtodevice": {
+			Dial:   func(v string) (DialerOption, error) { return BindToDevice(v), nil },
+			Listen: func(v string) (ListenerOption, error) { return BindToDevice(v), nil },
+		},
+		"fastopen": {
+			Dial:   func(v string) (DialerOption, error) { return queryFastOpen(v) },
+			Listen: func(v string) (ListenerOption, error) { return queryFastOpen(v) },
+		},
+		"connect_timeout": {
+			Dial: func(v string) (DialerOption, error) { return queryDuration[ConnectTimeout](v) },
+		},
+		"handshake_timeout": {
+			Dial:   func(v string) (DialerOption, error) { return queryDuration[HandshakeTimeout](v) },
+			Listen: func(v string) (ListenerOption, error) { return queryDuration[HandshakeTimeout](v) },
+		},
+		"negotiate_timeout": {
+			Dial: func(v string) (DialerOption, error) { return queryDuration[NegotiateTimeout](v) },
+		},
+		"nodelay": {
+			Dial:   func(v string) (DialerOption, error) { return queryNoDelay(v) },
+			Listen: func(v string) (ListenerOption, error) { return queryNoDelay(v) },
+		},
+		"acceptrate": {
+			Listen: func(v string) (ListenerOption, error) { return queryAcceptRate(v) },
+		},
+		"sourcerate": {
+			Listen: func(v string) (ListenerOption, error) { return querySourceRate(v) },
+		},
+		"maxhandshakes": {
+			Listen: func(v string) (ListenerOption, error) { return queryMaxHandshakes(v) },
+		},
+		"allow": {
+			Listen: func(v string) (ListenerOption, error) { return querySources(v, AllowSources) },
+		},
+		"deny": {
+			Listen: func(v string) (ListenerOption, error) { return querySources(v, DenySources) },
+		},
+	}
+	queryLock sync.RWMutex
+)
+
+// RegisterQueryOption registers an option that may be given as a
+// query parameter of a conduit URI.
+func RegisterQueryOption(name string, opt QueryOption) {
+	queryLock.Lock()
+	defer queryLock.Unlock()
+
+	queryOpts[name] = opt
+}
+
+// queryParams parses the query parameters of a URI, returning them in
+// sorted order so that errors are reported deterministically.  Each
+// parameter may be given only once.
+func queryParams(u *URI) ([]string, url.Values, error) {
+	if u.RawQuery == "" {
+		return nil, nil, nil
+	}
+
+	vals, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w: %w", u, ErrBadOption, err)
+	}
+	keys := make([]string, 0, len(vals))
+	for key, v := range vals {
+		if len(v) > 1 {
+			return nil, nil, fmt.Errorf("%s: %q: %w: given more than once", u, key, ErrBadOption)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, vals, nil
+}
+
+// queryOption looks up a query option.
+func queryOption(u *URI, key string) (QueryOption, error) {
+	queryLock.RLock()
+	defer queryLock.RUnlock()
+
+	opt, ok := queryOpts[key]
+	if !ok {
+		return opt, fmt.Errorf("%s: %q: %w", u, key, ErrUnknownOption)
+	}
+
+	return opt, nil
+}
+
+// DialOptions converts the query parameters of the URI into dialer
+// options.  An error wrapping ErrUnknownOption is returned if a
+// parameter does not name an option applying to dialing, and an error
+// wrapping ErrBadOption if its value is invalid.
+func (u *URI) DialOptions() ([]DialerOption, error) {
+	keys, vals, err := queryParams(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []DialerOption
+	for _, key := range keys {
+		qo, err := queryOption(u, key)
+		if err != nil {
+			return nil, err
+		}
+		if qo.Dial == nil {
+			return nil, fmt.Errorf("%s: %q: %w", u, key, ErrUnknownOption)
+		}
+		opt, err := qo.Dial(vals.Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q: %w: %w", u, key, ErrBadOption, err)
+		}
+		result = append(result, opt)
+	}
+
+	return result, nil
+}
+
+// ListenOptions converts the query parameters of the URI into
+// listener options.  An error wrapping ErrUnknownOption is returned if
+// a parameter does not name an option applying to listening, and an
+// error wrapping ErrBadOption if its value is invalid.
+func (u *URI) ListenOptions() ([]ListenerOption, error) {
+	keys, vals, err := queryParams(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ListenerOption
+	for _, key := range keys {
+		qo, err := queryOption(u, key)
+		if err != nil {
+			return nil, err
+		}
+		if qo.Listen == nil {
+			return nil, fmt.Errorf("%s: %q: %w", u, key, ErrUnknownOption)
+		}
+		opt, err := qo.Listen(vals.Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q: %w: %w", u, key, ErrBadOption, err)
+		}
+		result = append(result, opt)
+	}
+
+	return result, nil
+}
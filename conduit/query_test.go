@@ -0,0 +1,293 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterQueryOption(t *testing.T) {
+	opt := QueryOption{}
+	defer patcher.SetVar(&queryOpts, map[string]QueryOption{}).Install().Restore()
+
+	RegisterQueryOption("test", opt)
+
+	assert.Equal(t, map[string]QueryOption{"test": opt}, queryOpts)
+}
+
+func TestURIDialOptionsBase(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?nodelay=false&keepalive=30s")
+
+	result, err := obj.DialOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []DialerOption{KeepAlive(30 * time.Second), NoDelay(false)}, result)
+}
+
+func TestURIDialOptionsTimeouts(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?connect_timeout=1s&handshake_timeout=2s&negotiate_timeout=3s")
+
+	result, err := obj.DialOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []DialerOption{
+		ConnectTimeout(time.Second),
+		HandshakeTimeout(2 * time.Second),
+		NegotiateTimeout(3 * time.Second),
+	}, result)
+}
+
+func TestURIListenOptionsTimeouts(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?connect_timeout=1s")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrUnknownOption)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsSocket(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?bindtodevice=eth0&rcvbuf=4096&reuseport=false&sndbuf=8192")
+
+	result, err := obj.DialOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []DialerOption{
+		BindToDevice("eth0"),
+		ReadBuffer(4096),
+		SocketOption{Opt: SockOptReusePort},
+		WriteBuffer(8192),
+	}, result)
+}
+
+func TestURIListenOptionsSocket(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?reuseport=true&rcvbuf=4096")
+
+	result, err := obj.ListenOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerOption{ReadBuffer(4096), ReusePort()}, result)
+}
+
+func TestURIListenOptionsFastOpen(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?fastopen=16")
+
+	result, err := obj.ListenOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerOption{FastOpenOption{Queue: 16}}, result)
+}
+
+func TestURIListenOptionsAcceptRate(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?acceptrate=2.5")
+
+	result, err := obj.ListenOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerOption{AcceptRate{Rate: 2.5}}, result)
+}
+
+func TestURIListenOptionsAcceptPipeline(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?handshake_timeout=5s&maxhandshakes=8&sourcerate=0.5")
+
+	result, err := obj.ListenOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerOption{HandshakeTimeout(5 * time.Second), MaxHandshakes(8), SourceRate{Rate: 0.5}}, result)
+}
+
+func TestURIListenOptionsSerialHandshakes(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?maxhandshakes=0")
+
+	result, err := obj.ListenOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerOption{MaxHandshakes(0)}, result)
+}
+
+func TestURIListenOptionsSources(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?allow=10.0.0.0/8&deny=10.0.0.1")
+	allowed, _ := remoteConduit(t, "tcp://10.0.0.2:1234")
+	denied, _ := remoteConduit(t, "tcp://10.0.0.1:1234")
+
+	result, err := obj.ListenOptions()
+
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	assert.NoError(t, result[0].(AcceptFilter)(allowed))
+	assert.NoError(t, result[1].(AcceptFilter)(allowed))
+	assert.ErrorIs(t, result[1].(AcceptFilter)(denied), ErrSourceDenied)
+}
+
+func TestURIListenOptionsBadBuffer(t *testing.T) {
+	for _, uri := range []string{
+		"tcp://10.0.0.1:1234?rcvbuf=big",
+		"tcp://10.0.0.1:1234?sndbuf=0",
+		"tcp://10.0.0.1:1234?reuseport=maybe",
+		"tcp://10.0.0.1:1234?fastopen=0",
+		"tcp://10.0.0.1:1234?acceptrate=fast",
+		"tcp://10.0.0.1:1234?acceptrate=0",
+		"tcp://10.0.0.1:1234?sourcerate=0",
+		"tcp://10.0.0.1:1234?maxhandshakes=-1",
+		"tcp://10.0.0.1:1234?maxhandshakes=many",
+		"tcp://10.0.0.1:1234?allow=bogus",
+	} {
+		result, err := mustParse(uri).ListenOptions()
+
+		assert.ErrorIs(t, err, ErrBadOption, uri)
+		assert.Nil(t, result, uri)
+	}
+}
+
+func TestURIListenOptionsBufferRange(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?sndbuf=0")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.ErrorIs(t, err, ErrOptionRange)
+	assert.Nil(t, result)
+}
+
+func TestURIListenOptionsFastOpenRange(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?fastopen=0")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.ErrorIs(t, err, ErrOptionRange)
+	assert.Nil(t, result)
+}
+
+func TestURIListenOptionsAcceptRateRange(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?acceptrate=0")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.ErrorIs(t, err, ErrOptionRange)
+	assert.Nil(t, result)
+}
+
+func TestURIListenOptionsMaxHandshakesRange(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?maxhandshakes=-1")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.ErrorIs(t, err, ErrOptionRange)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsNone(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234")
+
+	result, err := obj.DialOptions()
+
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsUnknown(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?bogus=1")
+
+	result, err := obj.DialOptions()
+
+	assert.ErrorIs(t, err, ErrUnknownOption)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsNotDial(t *testing.T) {
+	defer patcher.SetVar(&queryOpts, map[string]QueryOption{
+		"listenonly": {Listen: func(v string) (ListenerOption, error) { return KeepAlive(0), nil }},
+	}).Install().Restore()
+	obj := mustParse("tcp://10.0.0.1:1234?listenonly=1")
+
+	result, err := obj.DialOptions()
+
+	assert.ErrorIs(t, err, ErrUnknownOption)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsBadValue(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?keepalive=forever")
+
+	result, err := obj.DialOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsRepeated(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?nodelay=true&nodelay=false")
+
+	result, err := obj.DialOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.Nil(t, result)
+}
+
+func TestURIDialOptionsMalformed(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?nodelay=%zz")
+
+	result, err := obj.DialOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.Nil(t, result)
+}
+
+func TestURIListenOptionsBase(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?keepalive=15s&nodelay=1")
+
+	result, err := obj.ListenOptions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ListenerOption{KeepAlive(15 * time.Second), NoDelay(true)}, result)
+}
+
+func TestURIListenOptionsUnknown(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?bogus=1")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrUnknownOption)
+	assert.Nil(t, result)
+}
+
+func TestURIListenOptionsNotListen(t *testing.T) {
+	defer patcher.SetVar(&queryOpts, map[string]QueryOption{
+		"dialonly": {Dial: func(v string) (DialerOption, error) { return KeepAlive(0), nil }},
+	}).Install().Restore()
+	obj := mustParse("tcp://10.0.0.1:1234?dialonly=1")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrUnknownOption)
+	assert.Nil(t, result)
+}
+
+func TestURIListenOptionsBadValue(t *testing.T) {
+	obj := mustParse("tcp://10.0.0.1:1234?nodelay=maybe")
+
+	result, err := obj.ListenOptions()
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.Nil(t, result)
+}
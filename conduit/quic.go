@@ -0,0 +1,504 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// QUICALPN is the ALPN protocol identifier used for QUIC conduits if
+// the TLS configuration does not specify one.
+const QUICALPN = "humboldt"
+
+// quicErrUnauthorized is the QUIC application error code used when
+// closing connections from peers rejected by the authorizer.
+const quicErrUnauthorized quic.ApplicationErrorCode = 1
+
+// QUICConfig is the configuration for the QUIC transport.  It must be
+// returned by Config.ForTransport("quic").
+type QUICConfig struct {
+	TLS        *tls.Config  // TLS configuration; required
+	QUIC       *quic.Config // QUIC configuration; may be nil
+	Authorizer Authorizer   // Authorizes peers and names their principals; may be nil
+}
+
+// quicConfig retrieves the QUIC configuration, returning a TLS
+// configuration with the ALPN protocol set.
+func quicConfig(config Config) (*tls.Config, *quic.Config, error) {
+	if config == nil {
+		return nil, nil, fmt.Errorf("quic: %w", ErrMissingConfig)
+	}
+	qc, ok := config.ForTransport("quic").(*QUICConfig)
+	if !ok || qc == nil || qc.TLS == nil {
+		return nil, nil, fmt.Errorf("quic: %w", ErrMissingConfig)
+	}
+
+	// Set up the ALPN protocol, including in the configurations
+	// selected for each client
+	tlsConf := qc.TLS
+	if len(tlsConf.NextProtos) == 0 {
+		tlsConf = tlsConf.Clone()
+		tlsConf.NextProtos = []string{QUICALPN}
+		if next := tlsConf.GetConfigForClient; next != nil {
+			tlsConf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				conf, err := next(hello)
+				if conf != nil && len(conf.NextProtos) == 0 {
+					conf = conf.Clone()
+					conf.NextProtos = []string{QUICALPN}
+				}
+				return conf, err
+			}
+		}
+	}
+
+	return tlsConf, qc.QUIC, nil
+}
+
+// quicAuthorizer retrieves the authorizer from the QUIC
+// configuration, which must be valid.
+func quicAuthorizer(config Config) Authorizer {
+	return config.ForTransport("quic").(*QUICConfig).Authorizer
+}
+
+// QUICAddr2URI converts an address in the form returned by QUIC
+// connections into an appropriate URI.
+func QUICAddr2URI(addr net.Addr) *URI {
+	return &URI{
+		URL: url.URL{
+			Scheme: "quic",
+			Host:   addr.String(),
+		},
+		Transport: "quic",
+	}
+}
+
+// quicRecordHello wraps a TLS configuration so that the fingerprint
+// of each client hello is recorded, keyed by the remote address.
+func quicRecordHello(conf *tls.Config, hellos *sync.Map) *tls.Config {
+	conf = conf.Clone()
+	next := conf.GetConfigForClient
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if hello.Conn != nil {
+			hellos.Store(hello.Conn.RemoteAddr().String(), TLSFingerprint('q', hello))
+		}
+
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+
+	return conf
+}
+
+// quicLocalAddr selects the local address to use for reaching the
+// specified remote address.  Connecting a UDP socket sends no
+// packets, but causes the kernel to select the source address.
+func quicLocalAddr(raddr *net.UDPAddr) (*net.UDPAddr, error) {
+	c, err := dialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	la := c.LocalAddr().(*net.UDPAddr)
+
+	return &net.UDPAddr{IP: la.IP, Zone: la.Zone}, nil
+}
+
+// quicConn is an implementation of net.Conn for a single QUIC
+// stream.
+type quicConn struct {
+	*quic.Stream
+
+	conn        *quic.Conn     // The QUIC connection carrying the stream
+	dialed      bool           // The QUIC connection was dialed, and is owned
+	pc          net.PacketConn // The packet connection, if owned
+	fingerprint string         // Client hello fingerprint, if accepted
+}
+
+// Close closes the stream.  For outgoing conduits, the QUIC
+// connection is closed as well, along with the underlying packet
+// connection unless it is shared with a listener.
+func (c *quicConn) Close() error {
+	c.Stream.CancelRead(0)
+	err := c.Stream.Close()
+
+	if c.dialed {
+		c.conn.CloseWithError(0, "") //nolint:errcheck
+	}
+	if c.pc != nil {
+		c.pc.Close() //nolint:errcheck
+	}
+
+	return err
+}
+
+// Read reads data from the stream.  The peer closing the QUIC
+// connection without an error is reported as io.EOF.
+func (c *quicConn) Read(b []byte) (int, error) {
+	n, err := c.Stream.Read(b)
+
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == 0 {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// LocalAddr returns the local network address.
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// quicConduit constructs a conduit for a QUIC stream, invoking the
+// authorizer to name the principal.
+func quicConduit(state State, c *quicConn, local, remote *URI, auth Authorizer) (*Conduit, error) {
+	cs := c.conn.ConnectionState().TLS
+	principal, err := authorize(auth, &cs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conduit{
+		State:        state,
+		Confidential: true,
+		Integrity:    true,
+		Principal:    principal,
+		Binding:      tlsBinding(&cs),
+		LocalURI:     local,
+		RemoteURI:    remote,
+		Link:         c,
+	}, nil
+}
+
+// quicFilter is an implementation of dialerFilter that catches the
+// LocalAddr option and converts the URI appropriately to fill in the
+// LocalAddr of the Dialer.
+type quicFilter int
+
+// DialFilter filters the option.
+func (f quicFilter) DialFilter(o DialerOption) error {
+	return filterUDPLocalAddr(o, "quic")
+}
+
+// QUICMech is a mechanism for QUIC conduits.  Each conduit is mapped
+// onto a single QUIC stream.
+type QUICMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.  The local
+// address and socket options of the dialer options are applied to
+// the packet connection carrying the QUIC connection.
+func (m QUICMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	tlsConf, quicConf, err := quicConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the source address and construct the dialer
+	if err := selectSources(opts, u.Host); err != nil {
+		return nil, err
+	}
+	dialer, err := mkNetDialer(opts, quicFilter(0))
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the remote address and select a local one
+	raddr, err := resolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	laddr, _ := dialer.LocalAddr.(*net.UDPAddr)
+	if laddr == nil {
+		if laddr, err = quicLocalAddr(raddr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Open the packet connection
+	lc := &net.ListenConfig{Control: dialer.Control}
+	pc, err := listenPacket(lc, ctx, "udp", laddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Establish the QUIC connection and open a stream
+	conn, err := quic.Dial(ctx, pc, raddr, tlsConf, quicConf)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "") //nolint:errcheck
+		pc.Close()
+		return nil, err
+	}
+
+	c := &quicConn{
+		Stream: stream,
+		conn:   conn,
+		dialed: true,
+		pc:     pc,
+	}
+	result, err := quicConduit(Active, c, QUICAddr2URI(pc.LocalAddr()), u, quicAuthorizer(config))
+	if err != nil {
+		c.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Listen opens a transport in passive mode; that is, for
+// connection-oriented transports, Listen creates a listener that may
+// accept connections.  For those transports that are not
+// connection-oriented, the listener synthesizes the appropriate
+// state.
+func (m QUICMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	tlsConf, quicConf, err := quicConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record client hello fingerprints
+	hellos := &sync.Map{}
+	tlsConf = quicRecordHello(tlsConf, hellos)
+
+	// Construct the listener config and open the packet connection
+	lc, err := mkListenConfigPatch(opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := lc.ListenPacket(ctx, "udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the QUIC listener
+	tr := &quic.Transport{Conn: pc}
+	ql, err := tr.Listen(tlsConf, quicConf)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	l := &QUICListener{
+		L:       ql,
+		PC:      pc,
+		tr:      tr,
+		URI:     QUICAddr2URI(ql.Addr()),
+		auth:    quicAuthorizer(config),
+		hellos:  hellos,
+		streams: make(chan *quicConn),
+		done:    make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	go l.acceptLoop()
+	go l.stunLoop()
+
+	return l, nil
+}
+
+// QUICListener is an implementation of Listener for the QUIC
+// transport.  It accepts QUIC connections, then returns a conduit for
+// each stream opened on those connections.  Packets which are not QUIC
+// packets are read from the socket as STUN responses.
+type QUICListener struct {
+	L   *quic.Listener // Underlying QUIC listener
+	PC  net.PacketConn // Underlying packet connection
+	URI *URI           // URI contains the URI of the listener
+
+	tr      *quic.Transport    // The QUIC transport on the socket
+	stun    stunDemux          // Demultiplexes STUN responses
+	auth    Authorizer         // Authorizer for peers
+	hellos  *sync.Map          // Client hello fingerprints by address
+	streams chan *quicConn     // Accepted streams
+	done    chan struct{}      // Closed when the listener is closed
+	once    sync.Once          // Ensures done is closed only once
+	ctx     context.Context    // Context for accept operations
+	cancel  context.CancelFunc // Cancels the context
+}
+
+// acceptLoop accepts QUIC connections.
+func (l *QUICListener) acceptLoop() {
+	defer l.shutdown()
+
+	for {
+		conn, err := l.L.Accept(l.ctx)
+		if err != nil {
+			return
+		}
+
+		go l.streamLoop(conn)
+	}
+}
+
+// stunLoop reads the packets received on the socket which are not
+// QUIC packets, delivering the STUN responses among them.
+func (l *QUICListener) stunLoop() {
+	buf := make([]byte, UDPMaxDatagram)
+	for {
+		n, _, err := l.tr.ReadNonQUICPacket(l.ctx, buf)
+		if err != nil {
+			return
+		}
+
+		dgram := make([]byte, n)
+		copy(dgram, buf[:n])
+		l.stun.deliver(dgram)
+	}
+}
+
+// streamLoop accepts streams on a QUIC connection.
+func (l *QUICListener) streamLoop(conn *quic.Conn) {
+	fp := ""
+	if tmp, ok := l.hellos.LoadAndDelete(conn.RemoteAddr().String()); ok {
+		fp = tmp.(string)
+	}
+
+	for {
+		stream, err := conn.AcceptStream(l.ctx)
+		if err != nil {
+			return
+		}
+
+		select {
+		case l.streams <- &quicConn{Stream: stream, conn: conn, fingerprint: fp}:
+		case <-l.done:
+			stream.CancelRead(0)
+			stream.Close() //nolint:errcheck
+			return
+		}
+	}
+}
+
+// shutdown marks the listener as done.
+func (l *QUICListener) shutdown() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+// Accept waits for and returns the next conduit to the listener.
+// Connections from peers rejected by the authorizer are closed, and
+// an AcceptError describing the rejection is returned.
+func (l *QUICListener) Accept() (*Conduit, error) {
+	select {
+	case c := <-l.streams:
+		remote := QUICAddr2URI(c.conn.RemoteAddr())
+		result, err := quicConduit(Passive, c, l.URI, remote, l.auth)
+		if err != nil {
+			c.conn.CloseWithError(quicErrUnauthorized, "unauthorized") //nolint:errcheck
+			return nil, &AcceptError{Reason: AcceptRejected, RemoteURI: remote, Err: err}
+		}
+		result.Fingerprints = map[string]string{}
+		if c.fingerprint != "" {
+			result.Fingerprints[FingerprintTLS] = c.fingerprint
+		}
+		audit(AuditAccept, result)
+
+		return result, nil
+
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors.
+func (l *QUICListener) Close() error {
+	l.shutdown()
+	l.cancel()
+	err := l.L.Close()
+	l.tr.Close() //nolint:errcheck
+	l.PC.Close() //nolint:errcheck
+
+	return err
+}
+
+// Addr returns the listener's network URI.
+func (l *QUICListener) Addr() *URI {
+	return l.URI
+}
+
+// stunResponses returns the demultiplexer delivering the STUN
+// responses received on the socket.
+func (l *QUICListener) stunResponses() *stunDemux {
+	return &l.stun
+}
+
+// stunWriteTo sends a STUN message from the socket.
+func (l *QUICListener) stunWriteTo(msg []byte, addr net.Addr) (int, error) {
+	return l.tr.WriteTo(msg, addr)
+}
+
+// punchDial opens a conduit in active mode from the socket to the
+// specified address, dialing a QUIC connection through the
+// listener's transport.
+func (l *QUICListener) punchDial(ctx context.Context, config Config, raddr *net.UDPAddr) (*Conduit, error) {
+	tlsConf, quicConf, err := quicConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Establish the QUIC connection and open a stream
+	conn, err := l.tr.Dial(ctx, raddr, tlsConf, quicConf)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "") //nolint:errcheck
+		return nil, err
+	}
+
+	c := &quicConn{
+		Stream: stream,
+		conn:   conn,
+		dialed: true,
+	}
+	result, err := quicConduit(Active, c, l.URI, QUICAddr2URI(raddr), quicAuthorizer(config))
+	if err != nil {
+		c.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// init initializes the QUIC transport.
+func init() {
+	RegisterTransport("quic", QUICMech(0))
+}
@@ -0,0 +1,412 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQUICConfigBase(t *testing.T) {
+	tlsConf := &tls.Config{}
+	quicConf := &quic.Config{}
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "quic").Return(&QUICConfig{
+		TLS:  tlsConf,
+		QUIC: quicConf,
+	})
+
+	resultTLS, resultQUIC, err := quicConfig(cfg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{QUICALPN}, resultTLS.NextProtos)
+	assert.Nil(t, tlsConf.NextProtos)
+	assert.Same(t, quicConf, resultQUIC)
+	cfg.AssertExpectations(t)
+}
+
+func TestQUICConfigGetConfigForClient(t *testing.T) {
+	srvConf := &tls.Config{ServerName: "server"}
+	tlsConf := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return srvConf, nil
+		},
+	}
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "quic").Return(&QUICConfig{
+		TLS: tlsConf,
+	})
+
+	resultTLS, _, err := quicConfig(cfg)
+
+	assert.NoError(t, err)
+	result, err := resultTLS.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "server", result.ServerName)
+	assert.Equal(t, []string{QUICALPN}, result.NextProtos)
+	assert.Nil(t, srvConf.NextProtos)
+	cfg.AssertExpectations(t)
+}
+
+func TestQUICConfigALPN(t *testing.T) {
+	tlsConf := &tls.Config{NextProtos: []string{"other"}}
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "quic").Return(&QUICConfig{
+		TLS: tlsConf,
+	})
+
+	resultTLS, resultQUIC, err := quicConfig(cfg)
+
+	assert.NoError(t, err)
+	assert.Same(t, tlsConf, resultTLS)
+	assert.Nil(t, resultQUIC)
+	cfg.AssertExpectations(t)
+}
+
+func TestQUICConfigNilConfig(t *testing.T) {
+	resultTLS, resultQUIC, err := quicConfig(nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, resultTLS)
+	assert.Nil(t, resultQUIC)
+}
+
+func TestQUICConfigMissing(t *testing.T) {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "quic").Return(nil)
+
+	resultTLS, resultQUIC, err := quicConfig(cfg)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, resultTLS)
+	assert.Nil(t, resultQUIC)
+	cfg.AssertExpectations(t)
+}
+
+func TestQUICConfigNoTLS(t *testing.T) {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "quic").Return(&QUICConfig{})
+
+	resultTLS, resultQUIC, err := quicConfig(cfg)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, resultTLS)
+	assert.Nil(t, resultQUIC)
+	cfg.AssertExpectations(t)
+}
+
+func TestQUICAddr2URI(t *testing.T) {
+	addr := &mockAddr{}
+	addr.On("String").Return("127.0.0.1:1234")
+
+	result := QUICAddr2URI(addr)
+
+	assert.Equal(t, &URI{
+		URL: url.URL{
+			Scheme: "quic",
+			Host:   "127.0.0.1:1234",
+		},
+		Transport: "quic",
+	}, result)
+	addr.AssertExpectations(t)
+}
+
+func TestQUICLocalAddrBase(t *testing.T) {
+	raddr := &net.UDPAddr{IP: loopback, Port: 1234}
+
+	result, err := quicLocalAddr(raddr)
+
+	assert.NoError(t, err)
+	assert.True(t, result.IP.Equal(loopback))
+	assert.Equal(t, 0, result.Port)
+}
+
+func TestQUICLocalAddrError(t *testing.T) {
+	raddr := &net.UDPAddr{IP: loopback, Port: 1234}
+	defer patcher.SetVar(&dialUDP, func(network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := quicLocalAddr(raddr)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestQUICRecordHelloBase(t *testing.T) {
+	addr := &mockAddr{}
+	c := &mockConn{}
+	addr.On("String").Return("127.0.0.1:1234")
+	c.On("RemoteAddr").Return(addr)
+	hello := &tls.ClientHelloInfo{Conn: c}
+	hellos := &sync.Map{}
+	conf := &tls.Config{ServerName: "example.com"}
+
+	result := quicRecordHello(conf, hellos)
+	cfg, err := result.GetConfigForClient(hello)
+
+	assert.NotSame(t, conf, result)
+	assert.Nil(t, conf.GetConfigForClient)
+	assert.Equal(t, "example.com", result.ServerName)
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+	fp, ok := hellos.Load("127.0.0.1:1234")
+	assert.True(t, ok)
+	assert.Equal(t, TLSFingerprint('q', hello), fp)
+}
+
+func TestQUICRecordHelloChained(t *testing.T) {
+	hello := &tls.ClientHelloInfo{}
+	hellos := &sync.Map{}
+	other := &tls.Config{}
+	conf := &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			assert.Same(t, hello, h)
+			return other, assert.AnError
+		},
+	}
+
+	result := quicRecordHello(conf, hellos)
+	cfg, err := result.GetConfigForClient(hello)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Same(t, other, cfg)
+	hellos.Range(func(k, v interface{}) bool {
+		t.Errorf("unexpected entry %v", k)
+		return true
+	})
+}
+
+func TestQUICFilterImplementsDialerFilter(t *testing.T) {
+	assert.Implements(t, (*dialerFilter)(nil), quicFilter(0))
+}
+
+func TestQUICFilterDialFilterLocalAddr(t *testing.T) {
+	opt := &LocalAddrOption{
+		URI: &URI{
+			URL: url.URL{
+				Scheme: "quic",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "quic",
+		},
+	}
+	obj := quicFilter(0)
+
+	err := obj.DialFilter(opt)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &net.UDPAddr{
+		IP: loopback,
+	}, opt.Addr)
+}
+
+func TestQUICFilterDialFilterLocalAddrBadTransport(t *testing.T) {
+	opt := &LocalAddrOption{
+		URI: &URI{
+			URL: url.URL{
+				Scheme: "udp",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "udp",
+		},
+	}
+	obj := quicFilter(0)
+
+	err := obj.DialFilter(opt)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, opt.Addr)
+}
+
+func TestQUICMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), QUICMech(0))
+}
+
+func quicTestConfig() *mockConfig {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "quic").Return(&QUICConfig{
+		TLS: &tls.Config{},
+	})
+
+	return cfg
+}
+
+func TestQUICMechDialConfigError(t *testing.T) {
+	obj := QUICMech(0)
+
+	result, err := obj.Dial(context.Background(), nil, &URI{}, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechDialResolveError(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Dial(context.Background(), quicTestConfig(), u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechDialLocalAddrError(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	defer patcher.SetVar(&dialUDP, func(network string, laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Dial(context.Background(), quicTestConfig(), u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechDialListenError(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	defer patcher.SetVar(&listenPacket, func(lc *net.ListenConfig, ctx context.Context, network, address string) (net.PacketConn, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Dial(context.Background(), quicTestConfig(), u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechDialOptionError(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	opt := LocalAddr(&URI{
+		URL: url.URL{
+			Scheme: "udp",
+			Host:   "127.0.0.1:1234",
+		},
+		Transport: "udp",
+	})
+
+	result, err := obj.Dial(context.Background(), quicTestConfig(), u, []DialerOption{opt})
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechDialLocalAddrOption(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	opt := LocalAddr(&URI{
+		URL: url.URL{
+			Scheme: "quic",
+			Host:   "127.0.0.1:1234",
+		},
+		Transport: "quic",
+	})
+	var address string
+	defer patcher.SetVar(&listenPacket, func(lc *net.ListenConfig, ctx context.Context, network, addr string) (net.PacketConn, error) {
+		address = addr
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Dial(context.Background(), quicTestConfig(), u, []DialerOption{opt})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	assert.Equal(t, "127.0.0.1:0", address)
+}
+
+func TestQUICMechDialCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+
+	result, err := obj.Dial(ctx, quicTestConfig(), u, nil)
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechListenConfigError(t *testing.T) {
+	obj := QUICMech(0)
+
+	result, err := obj.Listen(context.Background(), nil, &URI{}, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechListenMkListenConfigError(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:0"}}
+	defer patcher.SetVar(&mkListenConfigPatch, func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Listen(context.Background(), quicTestConfig(), u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestQUICMechListenError(t *testing.T) {
+	ctx := context.Background()
+	obj := QUICMech(0)
+	lc := &mockListenConfig{}
+	u := &URI{URL: url.URL{Host: "127.0.0.1:0"}}
+	lc.On("ListenPacket", ctx, "udp", "127.0.0.1:0").Return(nil, assert.AnError)
+	defer patcher.SetVar(&mkListenConfigPatch, func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error) {
+		return lc, nil
+	}).Install().Restore()
+
+	result, err := obj.Listen(ctx, quicTestConfig(), u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	lc.AssertExpectations(t)
+}
+
+func TestQUICMechListenBase(t *testing.T) {
+	obj := QUICMech(0)
+	u := &URI{URL: url.URL{Host: "127.0.0.1:0"}}
+
+	result, err := obj.Listen(context.Background(), quicTestConfig(), u, nil)
+
+	assert.NoError(t, err)
+	l, ok := result.(*QUICListener)
+	assert.True(t, ok)
+	assert.Equal(t, "quic", l.Addr().Transport)
+	assert.NoError(t, l.Close())
+	c, err := l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, c)
+}
+
+func TestQUICListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &QUICListener{})
+}
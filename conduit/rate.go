@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Rate describes a token-bucket rate limit: a sustained rate, and a
+// burst that may briefly exceed it.  A zero rate indicates no limit.
+type Rate struct {
+	Rate  float64 // Sustained rate per second
+	Burst float64 // Maximum burst; defaults to the rate, or 1 if the rate is less
+}
+
+// burst returns the burst size of the rate.
+func (r Rate) burst() float64 {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+
+	return max(r.Rate, 1)
+}
+
+// RateLimit describes the rate limits on the PDUs received over a
+// conduit.  Unlike the hard limits of a UsagePolicy, which close the
+// conduit, rate limits delay reading further PDUs until the peer is
+// back within its limits, so that the transport applies backpressure
+// to the peer.
+type RateLimit struct {
+	PDUs  Rate // Limits PDUs received per second
+	Bytes Rate // Limits bytes received per second
+}
+
+// tokenBucket implements a token bucket.  Tokens may be borrowed, in
+// which case the bucket goes into debt, and the caller must wait
+// until the debt is repaid.
+type tokenBucket struct {
+	tokens float64   // Tokens available; negative when in debt
+	last   time.Time // Time tokens were last added
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *tokenBucket) refill(r Rate, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = r.burst()
+	} else {
+		b.tokens = min(r.burst(), b.tokens+now.Sub(b.last).Seconds()*r.Rate)
+	}
+	b.last = now
+}
+
+// take takes tokens from the bucket, returning the time to wait
+// before they would have been available.
+func (b *tokenBucket) take(r Rate, now time.Time, n float64) time.Duration {
+	if r.Rate <= 0 {
+		return 0
+	}
+
+	b.refill(r, now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / r.Rate * float64(time.Second))
+}
+
+// allow takes a token from the bucket if one is available, returning
+// false if not.
+func (b *tokenBucket) allow(r Rate, now time.Time) bool {
+	if r.Rate <= 0 {
+		return true
+	}
+
+	b.refill(r, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// rateState tracks the rate limits of a conduit.
+type rateState struct {
+	sync.Mutex
+
+	limit *RateLimit  // The limits enforced
+	pdus  tokenBucket // Bucket for PDUs
+	bytes tokenBucket // Bucket for bytes
+}
+
+// SetRateLimit sets the rate limits enforced on the PDUs received
+// over the conduit by Recv and PDUs.  A nil limit disables
+// enforcement.
+func (c *Conduit) SetRateLimit(limit *RateLimit) {
+	c.rate.Lock()
+	defer c.rate.Unlock()
+
+	c.rate.limit = limit
+	c.rate.pdus = tokenBucket{}
+	c.rate.bytes = tokenBucket{}
+}
+
+// throttle counts a received PDU against the rate limits, waiting
+// until the peer is back within its limits.  If the conduit's context
+// is cancelled while waiting, its cause is returned.
+func (c *Conduit) throttle(size int) error {
+	c.rate.Lock()
+	if c.rate.limit == nil {
+		c.rate.Unlock()
+		return nil
+	}
+	now := timeNow()
+	delay := max(
+		c.rate.pdus.take(c.rate.limit.PDUs, now, 1),
+		c.rate.bytes.take(c.rate.limit.Bytes, now, float64(size)),
+	)
+	c.rate.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	ctx := c.Context()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// AcceptRate is an option for Listen that limits the rate at which
+// conduits are accepted.  Conduits arriving while the limit is
+// exceeded are closed, and Accept returns an AcceptError with reason
+// AcceptRejected wrapping ErrAcceptRate, which Server ignores.  The
+// limit is applied to the conduits returned by the transport, before
+// any security layer handshake.
+type AcceptRate Rate
+
+// ListenApply applies the option to a net.ListenConfig.  The option
+// does not modify the configuration; it is applied by Listen.
+func (ar AcceptRate) ListenApply(lc *net.ListenConfig) {}
+
+// rateListen wraps a transport listener to apply any AcceptRate
+// option present in the options.
+func rateListen(l Listener, opts []ListenerOption) Listener {
+	ar := findOption[AcceptRate](opts)
+	if ar == nil || ar.Rate <= 0 {
+		return l
+	}
+
+	return &rateListener{Listener: l, rate: Rate(*ar)}
+}
+
+// rateListener is a Listener limiting the rate at which the wrapped
+// listener accepts conduits.
+type rateListener struct {
+	Listener
+
+	lock   sync.Mutex  // Protects the bucket
+	rate   Rate        // The accept rate
+	bucket tokenBucket // Bucket for accepted conduits
+}
+
+// Accept waits for and returns the next conduit to the listener.
+func (l *rateListener) Accept() (*Conduit, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.lock.Lock()
+	ok := l.bucket.allow(l.rate, timeNow())
+	l.lock.Unlock()
+	if !ok {
+		c.Close() //nolint:errcheck
+		return nil, &AcceptError{Reason: AcceptRejected, RemoteURI: c.RemoteURI, Err: ErrAcceptRate}
+	}
+
+	return c, nil
+}
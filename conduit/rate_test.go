@@ -0,0 +1,302 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rateClock patches the clock, returning a pointer to the current
+// time.
+func rateClock(t *testing.T) *time.Time {
+	now := time.Unix(1000, 0)
+	p := patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	})
+	p.Install()
+	t.Cleanup(func() {
+		p.Restore()
+	})
+
+	return &now
+}
+
+func TestRateBurstExplicit(t *testing.T) {
+	obj := Rate{Rate: 10, Burst: 20}
+
+	result := obj.burst()
+
+	assert.Equal(t, 20.0, result)
+}
+
+func TestRateBurstDefault(t *testing.T) {
+	obj := Rate{Rate: 10}
+
+	result := obj.burst()
+
+	assert.Equal(t, 10.0, result)
+}
+
+func TestRateBurstSlow(t *testing.T) {
+	obj := Rate{Rate: 0.5}
+
+	result := obj.burst()
+
+	assert.Equal(t, 1.0, result)
+}
+
+func TestTokenBucketTakeUnlimited(t *testing.T) {
+	obj := &tokenBucket{}
+
+	result := obj.take(Rate{}, time.Unix(1000, 0), 5)
+
+	assert.Equal(t, time.Duration(0), result)
+	assert.Equal(t, &tokenBucket{}, obj)
+}
+
+func TestTokenBucketTakeAvailable(t *testing.T) {
+	obj := &tokenBucket{}
+
+	result := obj.take(Rate{Rate: 10}, time.Unix(1000, 0), 4)
+
+	assert.Equal(t, time.Duration(0), result)
+	assert.Equal(t, &tokenBucket{tokens: 6, last: time.Unix(1000, 0)}, obj)
+}
+
+func TestTokenBucketTakeDebt(t *testing.T) {
+	obj := &tokenBucket{tokens: 1, last: time.Unix(1000, 0)}
+
+	result := obj.take(Rate{Rate: 10}, time.Unix(1000, 0), 6)
+
+	assert.Equal(t, 500*time.Millisecond, result)
+	assert.Equal(t, -5.0, obj.tokens)
+}
+
+func TestTokenBucketTakeRefill(t *testing.T) {
+	obj := &tokenBucket{tokens: -5, last: time.Unix(1000, 0)}
+
+	result := obj.take(Rate{Rate: 10}, time.Unix(1001, 0), 2)
+
+	assert.Equal(t, time.Duration(0), result)
+	assert.Equal(t, &tokenBucket{tokens: 3, last: time.Unix(1001, 0)}, obj)
+}
+
+func TestTokenBucketTakeRefillCapped(t *testing.T) {
+	obj := &tokenBucket{tokens: 0, last: time.Unix(1000, 0)}
+
+	result := obj.take(Rate{Rate: 10, Burst: 15}, time.Unix(1100, 0), 1)
+
+	assert.Equal(t, time.Duration(0), result)
+	assert.Equal(t, 14.0, obj.tokens)
+}
+
+func TestTokenBucketAllowUnlimited(t *testing.T) {
+	obj := &tokenBucket{}
+
+	result := obj.allow(Rate{}, time.Unix(1000, 0))
+
+	assert.True(t, result)
+}
+
+func TestTokenBucketAllowBase(t *testing.T) {
+	obj := &tokenBucket{}
+
+	result := obj.allow(Rate{Rate: 1, Burst: 2}, time.Unix(1000, 0))
+
+	assert.True(t, result)
+	assert.Equal(t, 1.0, obj.tokens)
+}
+
+func TestTokenBucketAllowExhausted(t *testing.T) {
+	obj := &tokenBucket{tokens: 0.5, last: time.Unix(1000, 0)}
+
+	result := obj.allow(Rate{Rate: 1}, time.Unix(1000, 0))
+
+	assert.False(t, result)
+	assert.Equal(t, 0.5, obj.tokens)
+}
+
+func TestConduitSetRateLimit(t *testing.T) {
+	limit := &RateLimit{PDUs: Rate{Rate: 10}}
+	obj := &Conduit{}
+	obj.rate.pdus.tokens = -3
+	obj.rate.bytes.tokens = -7
+
+	obj.SetRateLimit(limit)
+
+	assert.Same(t, limit, obj.rate.limit)
+	assert.Equal(t, tokenBucket{}, obj.rate.pdus)
+	assert.Equal(t, tokenBucket{}, obj.rate.bytes)
+}
+
+func TestConduitThrottleNoLimit(t *testing.T) {
+	obj := &Conduit{}
+
+	err := obj.throttle(100)
+
+	assert.NoError(t, err)
+}
+
+func TestConduitThrottleWithinLimit(t *testing.T) {
+	now := rateClock(t)
+	obj := &Conduit{}
+	obj.SetRateLimit(&RateLimit{PDUs: Rate{Rate: 10}, Bytes: Rate{Rate: 1000}})
+
+	err := obj.throttle(100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, tokenBucket{tokens: 9, last: *now}, obj.rate.pdus)
+	assert.Equal(t, tokenBucket{tokens: 900, last: *now}, obj.rate.bytes)
+}
+
+func TestConduitThrottleDelay(t *testing.T) {
+	rateClock(t)
+	obj := &Conduit{}
+	obj.SetRateLimit(&RateLimit{Bytes: Rate{Rate: 1000, Burst: 10}})
+	start := time.Now()
+
+	err := obj.throttle(30)
+
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestConduitThrottleCancelled(t *testing.T) {
+	rateClock(t)
+	obj := &Conduit{}
+	obj.SetRateLimit(&RateLimit{PDUs: Rate{Rate: 1}})
+	obj.cancelContext(assert.AnError)
+	require.NoError(t, obj.throttle(0))
+
+	err := obj.throttle(0)
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestAcceptRateImplementsListenerOption(t *testing.T) {
+	assert.Implements(t, (*ListenerOption)(nil), AcceptRate{})
+}
+
+func TestAcceptRateListenApply(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	AcceptRate{Rate: 1}.ListenApply(lc)
+
+	assert.Equal(t, &net.ListenConfig{}, lc)
+}
+
+func TestRateListenBase(t *testing.T) {
+	l := newChanListener()
+
+	result := rateListen(l, []ListenerOption{KeepAlive(0), AcceptRate{Rate: 5}})
+
+	require.IsType(t, &rateListener{}, result)
+	assert.Same(t, l, result.(*rateListener).Listener)
+	assert.Equal(t, Rate{Rate: 5}, result.(*rateListener).rate)
+}
+
+func TestRateListenNoOption(t *testing.T) {
+	l := newChanListener()
+
+	result := rateListen(l, []ListenerOption{KeepAlive(0)})
+
+	assert.Same(t, l, result)
+}
+
+func TestRateListenZero(t *testing.T) {
+	l := newChanListener()
+
+	result := rateListen(l, []ListenerOption{AcceptRate{}})
+
+	assert.Same(t, l, result)
+}
+
+func TestRateListenerAcceptBase(t *testing.T) {
+	rateClock(t)
+	l := newChanListener()
+	c, _ := pipeConduit(t)
+	obj := &rateListener{Listener: l, rate: Rate{Rate: 1}}
+	go func() { l.conduits <- c }()
+
+	result, err := obj.Accept()
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+}
+
+func TestRateListenerAcceptRejected(t *testing.T) {
+	rateClock(t)
+	l := newChanListener()
+	c1, _ := pipeConduit(t)
+	c2, r2 := pipeConduit(t)
+	c2.RemoteURI = mustParse("tcp://10.0.0.1:1234")
+	obj := &rateListener{Listener: l, rate: Rate{Rate: 1}}
+	go func() {
+		l.conduits <- c1
+		l.conduits <- c2
+	}()
+	_, err := obj.Accept()
+	require.NoError(t, err)
+
+	result, err := obj.Accept()
+
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrAcceptRate)
+	var acceptErr *AcceptError
+	require.ErrorAs(t, err, &acceptErr)
+	assert.Equal(t, AcceptRejected, acceptErr.Reason)
+	assert.Same(t, c2.RemoteURI, acceptErr.RemoteURI)
+	_, err = r2.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestRateListenerAcceptError(t *testing.T) {
+	l := newChanListener()
+	l.Close()
+	obj := &rateListener{Listener: l, rate: Rate{Rate: 1}}
+
+	result, err := obj.Accept()
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, result)
+}
+
+func TestConduitRecvThrottled(t *testing.T) {
+	rateClock(t)
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local}
+	obj.SetRateLimit(&RateLimit{PDUs: Rate{Rate: 1}})
+	obj.cancelContext(assert.AnError)
+	go func() {
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c', 0x00, 0x18}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x01, 'd'})                                   //nolint:errcheck
+		remote.Close()
+	}()
+
+	result1, err1 := obj.Recv()
+	result2, err2 := obj.Recv()
+
+	assert.NoError(t, err1)
+	assert.Equal(t, []byte("abc"), result1.Payload)
+	assert.Same(t, assert.AnError, err2)
+	assert.Nil(t, result2)
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// RelayDialer opens tunnels through relay nodes.  It is implemented
+// by nodes, which carry the tunnels over their conduits to the relay
+// nodes.
+type RelayDialer interface {
+	// DialRelay opens a tunnel to the target node through the
+	// relay node, over an open conduit to the relay node.  The
+	// local and remote addresses of the connection returned
+	// should be RelayAddr values.
+	DialRelay(ctx context.Context, relay, target proto.NodeID) (net.Conn, error)
+}
+
+// RelayConfig is the configuration for the relay transport.  It must
+// be returned by Config.ForTransport("relay").
+type RelayConfig struct {
+	Dialer RelayDialer // Opens the tunnels; required
+}
+
+// relayConfig retrieves the relay configuration.
+func relayConfig(config Config) (*RelayConfig, error) {
+	if config == nil {
+		return nil, fmt.Errorf("relay: %w", ErrMissingConfig)
+	}
+	rc, ok := config.ForTransport("relay").(*RelayConfig)
+	if !ok || rc == nil || rc.Dialer == nil {
+		return nil, fmt.Errorf("relay: %w", ErrMissingConfig)
+	}
+
+	return rc, nil
+}
+
+// RelayAddr is the address of one end of a tunnel through a relay
+// node: the relay node and the node at that end.
+type RelayAddr struct {
+	Relay proto.NodeID // Identifier of the relay node
+	Node  proto.NodeID // Identifier of the node at the end
+}
+
+// Network returns the name of the network.
+func (a *RelayAddr) Network() string {
+	return "relay"
+}
+
+// String returns the address in the form "relay/node".
+func (a *RelayAddr) String() string {
+	return a.Relay.String() + "/" + a.Node.String()
+}
+
+// RelayAddr2URI converts the address of one end of a tunnel into a
+// URI of the form "relay://relay/node".
+func RelayAddr2URI(addr *RelayAddr) *URI {
+	return &URI{
+		URL: url.URL{
+			Scheme: "relay",
+			Host:   addr.Relay.String(),
+			Path:   "/" + addr.Node.String(),
+		},
+		Transport: "relay",
+	}
+}
+
+// parseRelayURI parses the relay and target node identifiers from a
+// relay URI.
+func parseRelayURI(u *URI) (*RelayAddr, error) {
+	relay, err := proto.ParseNodeID(u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: relay node: %w", u, ErrBadRelayURI)
+	}
+	target, err := proto.ParseNodeID(strings.TrimPrefix(u.Path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: target node: %w", u, ErrBadRelayURI)
+	}
+
+	return &RelayAddr{Relay: relay, Node: target}, nil
+}
+
+// RelayMech is a mechanism for conduits tunneled through a relay
+// node, over a conduit to it which is already open.  The relay node
+// splices the tunnel to a second tunnel, over its own conduit to the
+// target node, so that nodes which cannot reach each other directly
+// may still establish a conduit.  URIs take the form
+// "relay://relay-node/target-node", where the node identifiers are
+// in hexadecimal; such URIs are always canonical.  The tunnels are
+// opened by the RelayDialer given by the configuration.  Listening is
+// not supported: the nodes serve the tunnels opened to them
+// themselves.
+type RelayMech int
+
+// literalHost marks the hosts of relay URIs as node identifiers
+// rather than network addresses.
+func (m RelayMech) literalHost() {}
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m RelayMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	rc, err := relayConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := parseRelayURI(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the tunnel
+	tunnel, err := rc.Dialer.DialRelay(ctx, addr.Relay, addr.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct and return a Conduit
+	var local *URI
+	if la, ok := tunnel.LocalAddr().(*RelayAddr); ok {
+		local = RelayAddr2URI(la)
+	}
+	return &Conduit{
+		State:     Active,
+		LocalURI:  local,
+		RemoteURI: u,
+		Link:      tunnel,
+	}, nil
+}
+
+// Listen opens a transport in passive mode.  Listening is not
+// supported by the relay transport.
+func (m RelayMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	return nil, fmt.Errorf("relay: %w", ErrNoListen)
+}
+
+// init initializes the relay transport.
+func init() {
+	RegisterTransport("relay", RelayMech(0))
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// mockRelayDialer is a mock RelayDialer.
+type mockRelayDialer struct {
+	mock.Mock
+}
+
+func (m *mockRelayDialer) DialRelay(ctx context.Context, relay, target proto.NodeID) (net.Conn, error) {
+	args := m.MethodCalled("DialRelay", ctx, relay, target)
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.(net.Conn), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+const testRelayURI = "relay://01000000000000000000000000000000/02000000000000000000000000000000"
+
+func TestRelayMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), RelayMech(0))
+}
+
+func TestRelayMechLiteralHost(t *testing.T) {
+	RelayMech(0).literalHost()
+
+	assert.Implements(t, (*literalHost)(nil), RelayMech(0))
+}
+
+func TestRelayConfigNil(t *testing.T) {
+	result, err := relayConfig(nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestRelayConfigMissing(t *testing.T) {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "relay").Return(&RelayConfig{})
+
+	result, err := relayConfig(cfg)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestRelayAddr(t *testing.T) {
+	obj := &RelayAddr{Relay: proto.NodeID{1}, Node: proto.NodeID{2}}
+
+	assert.Equal(t, "relay", obj.Network())
+	assert.Equal(t, "01000000000000000000000000000000/02000000000000000000000000000000", obj.String())
+	assert.Equal(t, testRelayURI, RelayAddr2URI(obj).String())
+}
+
+func TestParseRelayURIBase(t *testing.T) {
+	u, err := Parse(testRelayURI)
+	require.NoError(t, err)
+
+	result, err := parseRelayURI(u)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &RelayAddr{Relay: proto.NodeID{1}, Node: proto.NodeID{2}}, result)
+	assert.True(t, u.IsCanonical())
+}
+
+func TestParseRelayURIBad(t *testing.T) {
+	for _, uri := range []string{
+		"relay://relay/02000000000000000000000000000000",
+		"relay://01000000000000000000000000000000/target",
+		"relay://01000000000000000000000000000000",
+	} {
+		u, err := Parse(uri)
+		require.NoError(t, err)
+
+		result, err := parseRelayURI(u)
+
+		assert.ErrorIs(t, err, ErrBadRelayURI, uri)
+		assert.Nil(t, result, uri)
+	}
+}
+
+func TestRelayMechDialBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	tunnel := &relayTestConn{Conn: c1, local: &RelayAddr{Relay: proto.NodeID{1}, Node: proto.NodeID{3}}}
+	dialer := &mockRelayDialer{}
+	dialer.On("DialRelay", mock.Anything, proto.NodeID{1}, proto.NodeID{2}).Return(tunnel, nil)
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "relay").Return(&RelayConfig{Dialer: dialer})
+
+	result, err := Dial(context.Background(), cfg, testRelayURI)
+
+	require.NoError(t, err)
+	assert.Equal(t, Active, result.State)
+	assert.Same(t, tunnel, result.Link)
+	assert.Equal(t, testRelayURI, result.RemoteURI.String())
+	assert.Equal(t, "relay://01000000000000000000000000000000/03000000000000000000000000000000", result.LocalURI.String())
+}
+
+func TestRelayMechDialConfigError(t *testing.T) {
+	result, err := RelayMech(0).Dial(context.Background(), nil, &URI{}, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestRelayMechDialBadURI(t *testing.T) {
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "relay").Return(&RelayConfig{Dialer: &mockRelayDialer{}})
+
+	result, err := RelayMech(0).Dial(context.Background(), cfg, &URI{URL: url.URL{Host: "relay"}}, nil)
+
+	assert.ErrorIs(t, err, ErrBadRelayURI)
+	assert.Nil(t, result)
+}
+
+func TestRelayMechDialError(t *testing.T) {
+	dialer := &mockRelayDialer{}
+	dialer.On("DialRelay", mock.Anything, proto.NodeID{1}, proto.NodeID{2}).Return(nil, assert.AnError)
+	cfg := &mockConfig{}
+	cfg.On("ForTransport", "relay").Return(&RelayConfig{Dialer: dialer})
+
+	result, err := Dial(context.Background(), cfg, testRelayURI)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestRelayMechListen(t *testing.T) {
+	result, err := RelayMech(0).Listen(context.Background(), nil, &URI{}, nil)
+
+	assert.ErrorIs(t, err, ErrNoListen)
+	assert.Nil(t, result)
+}
+
+// relayTestConn is a net.Conn with a relay address.
+type relayTestConn struct {
+	net.Conn
+
+	local *RelayAddr // The local address
+}
+
+// LocalAddr returns the local address.
+func (c *relayTestConn) LocalAddr() net.Addr {
+	return c.local
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Constants used by SecureResolver.
+const (
+	DefaultDoTPort         = "853"                     // Default port of DNS over TLS servers
+	DefaultResolverTimeout = 5 * time.Second           // Default time allowed for each query
+	dnsMessageType         = "application/dns-message" // Media type of DNS over HTTPS messages
+	maxDNSMessage          = 65535                     // Maximum size of a DNS message
+)
+
+// SecureResolver is a HostResolver that sends its queries to a
+// recursive DNS server over an encrypted connection, using either DNS
+// over HTTPS, as described by RFC 8484, or DNS over TLS, as described
+// by RFC 7858, so that the names looked up are not disclosed to the
+// network.  The server is given by a URL: an "https" URL gives the
+// URL of a DNS over HTTPS server, such as
+// "https://dns.example/dns-query", and a "tls" URL gives the host and
+// optional port of a DNS over TLS server, such as
+// "tls://dns.example".  Each DNS over TLS query is made over a new
+// connection.  Service names are looked up by the system, as they do
+// not involve DNS.  A SecureResolver must not be copied after first
+// use.
+type SecureResolver struct {
+	Server  *url.URL      // URL of the server; required
+	TLS     *tls.Config   // TLS configuration for the server; may be nil
+	Timeout time.Duration // Time allowed for each query; if zero, DefaultResolverTimeout is used
+
+	once   sync.Once    // Controls creation of the HTTP client
+	client *http.Client // HTTP client for DNS over HTTPS
+}
+
+// NewSecureResolver constructs a SecureResolver for the server with
+// the specified URL, which must be an "https" or "tls" URL.  The TLS
+// configuration may be nil.
+func NewSecureResolver(server string, conf *tls.Config) (*SecureResolver, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w: %w", server, ErrBadResolver, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("%q: %w", server, ErrBadResolver)
+	}
+
+	return &SecureResolver{Server: u, TLS: conf}, nil
+}
+
+// httpClient returns the HTTP client used for DNS over HTTPS.
+func (r *SecureResolver) httpClient() *http.Client {
+	r.once.Do(func() {
+		r.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   r.TLS,
+				ForceAttemptHTTP2: true,
+			},
+		}
+	})
+
+	return r.client
+}
+
+// exchangeHTTPS sends a query to a DNS over HTTPS server, returning
+// the response.
+func (r *SecureResolver) exchangeHTTPS(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Server.String(), bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %w", resp.StatusCode, ErrBadDNSResponse)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessage+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxDNSMessage {
+		return nil, fmt.Errorf("size %d: %w", len(data), ErrBadDNSResponse)
+	}
+
+	return data, nil
+}
+
+// exchangeTLS sends a query to a DNS over TLS server, returning the
+// response.
+func (r *SecureResolver) exchangeTLS(ctx context.Context, query []byte) ([]byte, error) {
+	addr := r.Server.Host
+	if r.Server.Port() == "" {
+		addr = net.JoinHostPort(r.Server.Hostname(), DefaultDoTPort)
+	}
+	conf := &tls.Config{}
+	if r.TLS != nil {
+		conf = r.TLS.Clone()
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = r.Server.Hostname()
+	}
+
+	// Connect to the server
+	conn, err := (&tls.Dialer{Config: conf}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint:errcheck
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0)) //nolint:errcheck
+	})
+	defer stop()
+
+	// Send the query, preceded by its length
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	// Read the response
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(buf))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// query looks up the records of a type for a name, returning the
+// answers of that type.
+func (r *SecureResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	name, err := mdnsName(host)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+
+	// Bound the query
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultResolverTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Select the exchange; DNS over HTTPS uses the ID 0 so that
+	// responses may be cached by HTTP caches
+	var id uint16
+	exchange := r.exchangeHTTPS
+	switch r.Server.Scheme {
+	case "https":
+	case "tls":
+		var b [2]byte
+		if _, err := io.ReadFull(randReader, b[:]); err != nil {
+			return nil, err
+		}
+		id = binary.BigEndian.Uint16(b[:])
+		exchange = r.exchangeTLS
+	default:
+		return nil, fmt.Errorf("%q: %w", r.Server.Redacted(), ErrBadResolver)
+	}
+
+	// Build and send the query
+	msg := &dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	data, err := exchange(ctx, query)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host, Server: r.Server.Redacted(), IsTimeout: ctx.Err() != nil}
+	}
+
+	// Check the response
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(data); err != nil || !resp.Response || resp.ID != id {
+		return nil, &net.DNSError{Err: ErrBadDNSResponse.Error(), Name: host, Server: r.Server.Redacted()}
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.Server.Redacted(), IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: resp.RCode.String(), Name: host, Server: r.Server.Redacted(), IsTemporary: resp.RCode == dnsmessage.RCodeServerFailure}
+	}
+
+	result := []dnsmessage.Resource{}
+	for _, rr := range resp.Answers {
+		if rr.Header.Type == qtype {
+			result = append(result, rr)
+		}
+	}
+
+	return result, nil
+}
+
+// LookupIP looks up the IP addresses of a host.  The network is "ip",
+// "ip4", or "ip6".
+func (r *SecureResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var qtypes []dnsmessage.Type
+	switch network {
+	case "ip":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA}
+	case "ip4":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+
+	result := []net.IP{}
+	for _, qtype := range qtypes {
+		answers, err := r.query(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range answers {
+			switch body := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				result = append(result, net.IP(body.A[:]))
+			case *dnsmessage.AAAAResource:
+				result = append(result, net.IP(body.AAAA[:]))
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.Server.Redacted(), IsNotFound: true}
+	}
+
+	return result, nil
+}
+
+// LookupPort looks up the port number of a service.  Service names
+// are looked up by the system.
+func (r *SecureResolver) LookupPort(ctx context.Context, network, service string) (int, error) {
+	return lookupPort(ctx, network, service)
+}
+
+// LookupSRV looks up the SRV records of a service, in the same way as
+// net.Resolver.LookupSRV: if the service and protocol are empty, the
+// name is looked up directly, and otherwise, the name
+// "_service._proto.name" is looked up.  The records are sorted by
+// priority, and by decreasing weight within each priority.
+func (r *SecureResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+
+	answers, err := r.query(ctx, target, dnsmessage.TypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+	cname := ""
+	result := []*net.SRV{}
+	for _, rr := range answers {
+		body := rr.Body.(*dnsmessage.SRVResource)
+		cname = rr.Header.Name.String()
+		result = append(result, &net.SRV{
+			Target:   body.Target.String(),
+			Port:     body.Port,
+			Priority: body.Priority,
+			Weight:   body.Weight,
+		})
+	}
+	sortSRV(result)
+
+	return cname, result, nil
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDNSAnswer answers a DNS query from a fixed set of records.
+func testDNSAnswer(query []byte) []byte {
+	msg := &dnsmessage.Message{}
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) != 1 {
+		return []byte("bad")
+	}
+	q := msg.Questions[0]
+	msg.Response = true
+	hdr := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+	switch {
+	case q.Name.String() == "node1.example.com." && q.Type == dnsmessage.TypeA:
+		msg.Answers = []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}}}
+	case q.Name.String() == "node1.example.com." && q.Type == dnsmessage.TypeAAAA:
+		msg.Answers = []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}}}}
+	case q.Name.String() == "_humboldt._tcp.example.com." && q.Type == dnsmessage.TypeSRV:
+		target := dnsmessage.MustNewName("node1.example.com.")
+		msg.Answers = []dnsmessage.Resource{
+			{Header: hdr, Body: &dnsmessage.SRVResource{Priority: 20, Weight: 1, Port: 1235, Target: target}},
+			{Header: hdr, Body: &dnsmessage.SRVResource{Priority: 10, Weight: 1, Port: 1234, Target: target}},
+		}
+	case q.Name.String() == "fail.example.com.":
+		msg.RCode = dnsmessage.RCodeServerFailure
+	case q.Name.String() != "node1.example.com.":
+		msg.RCode = dnsmessage.RCodeNameError
+	}
+	resp, _ := msg.Pack()
+
+	return resp
+}
+
+// testDoHServer starts a DNS over HTTPS server and returns a
+// resolver using it.
+func testDoHServer(t *testing.T) *SecureResolver {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query, _ := io.ReadAll(req.Body)
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dnsMessageType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(testDNSAnswer(query)) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL + "/dns-query")
+
+	return &SecureResolver{Server: u, TLS: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+}
+
+// testDoTServer starts a DNS over TLS server and returns a resolver
+// using it.
+func testDoTServer(t *testing.T) *SecureResolver {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var size [2]byte
+				if _, err := io.ReadFull(conn, size[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := testDNSAnswer(query)
+				binary.BigEndian.PutUint16(size[:], uint16(len(resp)))
+				conn.Write(append(size[:], resp...)) //nolint:errcheck
+			}()
+		}
+	}()
+	u, _ := url.Parse("tls://" + ln.Addr().String())
+
+	return &SecureResolver{Server: u, TLS: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+}
+
+func TestNewSecureResolver(t *testing.T) {
+	result, err := NewSecureResolver("tls://dns.example", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "tls://dns.example", result.Server.String())
+}
+
+func TestNewSecureResolverBadScheme(t *testing.T) {
+	result, err := NewSecureResolver("udp://dns.example", nil)
+
+	assert.ErrorIs(t, err, ErrBadResolver)
+	assert.Nil(t, result)
+}
+
+func TestNewSecureResolverBadURL(t *testing.T) {
+	result, err := NewSecureResolver("https://dns.example:bad", nil)
+
+	assert.ErrorIs(t, err, ErrBadResolver)
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupIPHTTPS(t *testing.T) {
+	obj := testDoHServer(t)
+
+	result, err := obj.LookupIP(context.Background(), "ip", "node1.example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.IPv6loopback, net.IPv4(10, 0, 0, 1).To4()}, result)
+}
+
+func TestSecureResolverLookupIPTLS(t *testing.T) {
+	defer patcher.SetVar(&randReader, bytes.NewReader([]byte{0x12, 0x34})).Install().Restore()
+	obj := testDoTServer(t)
+
+	result, err := obj.LookupIP(context.Background(), "ip4", "node1.example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, result)
+}
+
+func TestSecureResolverLookupIPTLSRandFails(t *testing.T) {
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+	obj := &SecureResolver{Server: &url.URL{Scheme: "tls", Host: "dns.example"}}
+
+	result, err := obj.LookupIP(context.Background(), "ip4", "node1.example.com")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupIPNotFound(t *testing.T) {
+	obj := testDoHServer(t)
+
+	result, err := obj.LookupIP(context.Background(), "ip6", "missing.example.com")
+
+	dnsErr := &net.DNSError{}
+	require.True(t, errors.As(err, &dnsErr))
+	assert.True(t, dnsErr.IsNotFound)
+	assert.Equal(t, "missing.example.com", dnsErr.Name)
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupIPServerFailure(t *testing.T) {
+	obj := testDoHServer(t)
+
+	result, err := obj.LookupIP(context.Background(), "ip4", "fail.example.com")
+
+	dnsErr := &net.DNSError{}
+	require.True(t, errors.As(err, &dnsErr))
+	assert.True(t, dnsErr.IsTemporary)
+	assert.False(t, dnsErr.IsNotFound)
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupIPBadNetwork(t *testing.T) {
+	obj := testDoHServer(t)
+
+	result, err := obj.LookupIP(context.Background(), "tcp", "node1.example.com")
+
+	assert.Equal(t, net.UnknownNetworkError("tcp"), err)
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupIPBadScheme(t *testing.T) {
+	obj := &SecureResolver{Server: &url.URL{Scheme: "udp", Host: "dns.example"}}
+
+	result, err := obj.LookupIP(context.Background(), "ip", "node1.example.com")
+
+	assert.ErrorIs(t, err, ErrBadResolver)
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupIPHTTPStatus(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	obj := &SecureResolver{Server: u, TLS: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+
+	result, err := obj.LookupIP(context.Background(), "ip", "node1.example.com")
+
+	dnsErr := &net.DNSError{}
+	require.True(t, errors.As(err, &dnsErr))
+	assert.Contains(t, dnsErr.Err, ErrBadDNSResponse.Error())
+	assert.Nil(t, result)
+}
+
+func TestSecureResolverLookupPort(t *testing.T) {
+	defer patcher.SetVar(&lookupPort, func(_ context.Context, network, service string) (int, error) {
+		assert.Equal(t, "tcp", network)
+		assert.Equal(t, "http", service)
+		return 80, nil
+	}).Install().Restore()
+	obj := &SecureResolver{}
+
+	result, err := obj.LookupPort(context.Background(), "tcp", "http")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 80, result)
+}
+
+func TestSecureResolverLookupSRV(t *testing.T) {
+	obj := testDoHServer(t)
+
+	cname, result, err := obj.LookupSRV(context.Background(), "humboldt", "tcp", "example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "_humboldt._tcp.example.com.", cname)
+	assert.Equal(t, []*net.SRV{
+		{Target: "node1.example.com.", Port: 1234, Priority: 10, Weight: 1},
+		{Target: "node1.example.com.", Port: 1235, Priority: 20, Weight: 1},
+	}, result)
+}
+
+func TestSecureResolverLookupSRVName(t *testing.T) {
+	defer patcher.SetVar(&randReader, bytes.NewReader([]byte{0x12, 0x34})).Install().Restore()
+	obj := testDoTServer(t)
+
+	cname, result, err := obj.LookupSRV(context.Background(), "", "", "_humboldt._tcp.example.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "_humboldt._tcp.example.com.", cname)
+	assert.Len(t, result, 2)
+}
+
+func TestSecureResolverLookupSRVNotFound(t *testing.T) {
+	obj := testDoHServer(t)
+
+	cname, result, err := obj.LookupSRV(context.Background(), "other", "tcp", "example.com")
+
+	dnsErr := &net.DNSError{}
+	require.True(t, errors.As(err, &dnsErr))
+	assert.True(t, dnsErr.IsNotFound)
+	assert.Equal(t, "", cname)
+	assert.Nil(t, result)
+}
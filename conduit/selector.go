@@ -0,0 +1,262 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Selector orders the resolutions resulting from canonicalizing a
+// conduit URI, deciding the order in which DialAll attempts the
+// canonical URIs.  The list passed to Select must not be modified.
+type Selector interface {
+	// Select returns the resolutions in the order they should
+	// be attempted.
+	Select(res []*Resolution) []*Resolution
+}
+
+// Observer is an optional interface a Selector may implement to learn
+// the outcome of each connection attempt made by DialAll.  Attempts
+// abandoned because another succeeded are not reported.
+type Observer interface {
+	// Observe reports the outcome of a connection attempt to a
+	// canonical URI, and the time it took.
+	Observe(u *URI, rtt time.Duration, err error)
+}
+
+// srvTargets splits a list of resolutions into runs of consecutive
+// resolutions derived from the same SRV target, so that the weight
+// of a target is not multiplied by the number of its addresses.
+func srvTargets(res []*Resolution) [][]*Resolution {
+	result := [][]*Resolution{}
+	for _, r := range res {
+		if n := len(result); n > 0 && result[n-1][0].Name == r.Name && result[n-1][0].Priority == r.Priority {
+			result[n-1] = append(result[n-1], r)
+			continue
+		}
+		result = append(result, []*Resolution{r})
+	}
+
+	return result
+}
+
+// weightedOrder orders a list of SRV targets of equal priority, as
+// described by RFC 2782: each target is selected at random with a
+// probability proportional to its weight, and targets of zero weight
+// are left at the end in their original order.
+func weightedOrder(targets [][]*Resolution) {
+	sum := 0
+	for _, t := range targets {
+		sum += int(t[0].Weight)
+	}
+	for sum > 0 && len(targets) > 1 {
+		n := int(randFloat() * float64(sum))
+		s := 0
+		for i := range targets {
+			s += int(targets[i][0].Weight)
+			if s > n {
+				targets[0], targets[i] = targets[i], targets[0]
+				break
+			}
+		}
+		sum -= int(targets[0][0].Weight)
+		targets = targets[1:]
+	}
+}
+
+// SRVSelector is the default Selector.  It orders resolutions by the
+// priority of the SRV records they were derived from, and orders the
+// SRV targets of equal priority at random, weighted by the weights of
+// their records, as described by RFC 2782.  The addresses of each
+// target are kept together, in their original order.  Resolutions
+// not derived from SRV records have a priority and weight of zero,
+// so their order is preserved.
+type SRVSelector struct{}
+
+// Select returns the resolutions in the order they should be
+// attempted.
+func (SRVSelector) Select(res []*Resolution) []*Resolution {
+	targets := srvTargets(res)
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targets[i][0].Priority < targets[j][0].Priority
+	})
+	for i := 0; i < len(targets); {
+		j := i + 1
+		for j < len(targets) && targets[j][0].Priority == targets[i][0].Priority {
+			j++
+		}
+		weightedOrder(targets[i:j])
+		i = j
+	}
+
+	result := make([]*Resolution, 0, len(res))
+	for _, t := range targets {
+		result = append(result, t...)
+	}
+
+	return result
+}
+
+// RoundRobinSelector is a Selector which rotates the resolutions,
+// starting each selection one resolution later than the last, so
+// that successive dials spread over the canonical URIs.  The zero
+// value is ready to use; a RoundRobinSelector must not be copied
+// after first use.
+type RoundRobinSelector struct {
+	next atomic.Uint64 // Count of selections made
+}
+
+// Select returns the resolutions in the order they should be
+// attempted.
+func (s *RoundRobinSelector) Select(res []*Resolution) []*Resolution {
+	if len(res) == 0 {
+		return []*Resolution{}
+	}
+
+	start := int((s.next.Add(1) - 1) % uint64(len(res)))
+	result := make([]*Resolution, 0, len(res))
+	result = append(result, res[start:]...)
+
+	return append(result, res[:start]...)
+}
+
+// RandomSelector is a Selector which shuffles the resolutions.
+type RandomSelector struct{}
+
+// Select returns the resolutions in the order they should be
+// attempted.
+func (RandomSelector) Select(res []*Resolution) []*Resolution {
+	result := append([]*Resolution{}, res...)
+	for i := len(result) - 1; i > 0; i-- {
+		j := int(randFloat() * float64(i+1))
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result
+}
+
+// RTTSelector is a Selector which prefers the canonical URIs that
+// have connected the fastest.  It observes the connection attempts
+// made by DialAll, keeping a smoothed round-trip time for each
+// canonical URI, computed as for TCP (RFC 6298).  Resolutions are
+// ordered by their smoothed round-trip times, followed by those not
+// yet attempted, followed by those whose last attempt failed; the
+// original order is preserved among equals.  The zero value is ready
+// to use; an RTTSelector must not be copied after first use.
+type RTTSelector struct {
+	lock   sync.Mutex               // Protects the history
+	rtts   map[string]time.Duration // Smoothed round-trip times, by URI key
+	failed map[string]bool          // URIs whose last attempt failed
+}
+
+// rank returns the rank of a canonical URI: its smoothed round-trip
+// time, or a rank after all times if it has not succeeded.
+func (s *RTTSelector) rank(key string) (int, time.Duration) {
+	switch {
+	case s.failed[key]:
+		return 2, 0
+	case s.rtts[key] > 0:
+		return 0, s.rtts[key]
+	default:
+		return 1, 0
+	}
+}
+
+// Select returns the resolutions in the order they should be
+// attempted.
+func (s *RTTSelector) Select(res []*Resolution) []*Resolution {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := append([]*Resolution{}, res...)
+	sort.SliceStable(result, func(i, j int) bool {
+		ci, ri := s.rank(result[i].URI.Key())
+		cj, rj := s.rank(result[j].URI.Key())
+		if ci != cj {
+			return ci < cj
+		}
+
+		return ri < rj
+	})
+
+	return result
+}
+
+// Observe reports the outcome of a connection attempt to a canonical
+// URI, and the time it took.
+func (s *RTTSelector) Observe(u *URI, rtt time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := u.Key()
+	if err != nil {
+		if s.failed == nil {
+			s.failed = map[string]bool{}
+		}
+		s.failed[key] = true
+		return
+	}
+
+	if s.rtts == nil {
+		s.rtts = map[string]time.Duration{}
+	}
+	delete(s.failed, key)
+	if old, ok := s.rtts[key]; ok {
+		rtt = old + (rtt-old)/8
+	}
+	s.rtts[key] = max(rtt, 1)
+}
+
+// NewSelector constructs a Selector by name: "srv" for SRVSelector,
+// "round-robin" for RoundRobinSelector, "random" for RandomSelector,
+// and "rtt" for RTTSelector.
+func NewSelector(name string) (Selector, error) {
+	switch name {
+	case "srv":
+		return SRVSelector{}, nil
+	case "round-robin":
+		return &RoundRobinSelector{}, nil
+	case "random":
+		return RandomSelector{}, nil
+	case "rtt":
+		return &RTTSelector{}, nil
+	default:
+		return nil, fmt.Errorf("%q: %w", name, ErrUnknownSelector)
+	}
+}
+
+// selectorKey is the context key for the Selector.
+type selectorKey struct{}
+
+// WithSelector returns a copy of a context carrying a Selector, which
+// is used by DialAll to order the canonical URIs it attempts.
+func WithSelector(ctx context.Context, s Selector) context.Context {
+	return context.WithValue(ctx, selectorKey{}, s)
+}
+
+// ContextSelector returns the Selector carried by a context, as set
+// by WithSelector, or an SRVSelector if there is none.
+func ContextSelector(ctx context.Context) Selector {
+	if s, ok := ctx.Value(selectorKey{}).(Selector); ok && s != nil {
+		return s
+	}
+
+	return SRVSelector{}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockSelector struct {
+	mock.Mock
+}
+
+func (m *mockSelector) Select(res []*Resolution) []*Resolution {
+	args := m.MethodCalled("Select", res)
+
+	return args.Get(0).(func([]*Resolution) []*Resolution)(res)
+}
+
+func (m *mockSelector) Observe(u *URI, rtt time.Duration, err error) {
+	m.MethodCalled("Observe", u, rtt, err)
+}
+
+// testResolutions constructs resolutions for testing selectors.
+func testResolutions(names ...string) []*Resolution {
+	result := make([]*Resolution, len(names))
+	for i, name := range names {
+		result[i] = &Resolution{URI: mustParse("tcp://" + name + ":1234"), Name: name}
+	}
+
+	return result
+}
+
+// sequence returns a function returning successive values, for
+// patching randFloat.
+func sequence(vals ...float64) func() float64 {
+	return func() float64 {
+		v := vals[0]
+		vals = vals[1:]
+		return v
+	}
+}
+
+func TestSRVTargets(t *testing.T) {
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	res[1].Name = res[0].Name
+	res[2].Name = res[0].Name
+	res[2].Priority = 10
+
+	result := srvTargets(res)
+
+	assert.Equal(t, [][]*Resolution{{res[0], res[1]}, {res[2]}}, result)
+}
+
+func TestSRVSelectorSelect(t *testing.T) {
+	defer patcher.SetVar(&randFloat, sequence(0.9, 0.5)).Install().Restore()
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+	res[0].Priority, res[0].Weight = 20, 1
+	res[1].Priority, res[1].Weight = 10, 1
+	res[2].Priority, res[2].Weight = 10, 3
+	res[3].Priority, res[3].Weight = 10, 0
+
+	result := SRVSelector{}.Select(res)
+
+	assert.Equal(t, []*Resolution{res[2], res[1], res[3], res[0]}, result)
+	assert.Equal(t, "10.0.0.1", res[0].Name)
+}
+
+func TestSRVSelectorSelectWeightless(t *testing.T) {
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	result := SRVSelector{}.Select(res)
+
+	assert.Equal(t, res, result)
+}
+
+func TestWeightedOrder(t *testing.T) {
+	defer patcher.SetVar(&randFloat, sequence(0.1, 0.9)).Install().Restore()
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	res[0].Weight = 1
+	res[1].Weight = 2
+	res[2].Weight = 1
+	targets := srvTargets(res)
+
+	weightedOrder(targets)
+
+	assert.Equal(t, [][]*Resolution{{res[0]}, {res[2]}, {res[1]}}, targets)
+}
+
+func TestRoundRobinSelectorSelect(t *testing.T) {
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	obj := &RoundRobinSelector{}
+
+	first := obj.Select(res)
+	second := obj.Select(res)
+	third := obj.Select(res)
+	fourth := obj.Select(res)
+
+	assert.Equal(t, res, first)
+	assert.Equal(t, []*Resolution{res[1], res[2], res[0]}, second)
+	assert.Equal(t, []*Resolution{res[2], res[0], res[1]}, third)
+	assert.Equal(t, res, fourth)
+}
+
+func TestRoundRobinSelectorSelectEmpty(t *testing.T) {
+	obj := &RoundRobinSelector{}
+
+	result := obj.Select(nil)
+
+	assert.Equal(t, []*Resolution{}, result)
+}
+
+func TestRandomSelectorSelect(t *testing.T) {
+	defer patcher.SetVar(&randFloat, sequence(0.0, 0.9)).Install().Restore()
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	result := RandomSelector{}.Select(res)
+
+	assert.Equal(t, []*Resolution{res[2], res[1], res[0]}, result)
+	assert.Equal(t, "10.0.0.1", res[0].Name)
+}
+
+func TestRTTSelectorSelect(t *testing.T) {
+	res := testResolutions("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+	obj := &RTTSelector{}
+	obj.Observe(res[0].URI, time.Millisecond, assert.AnError)
+	obj.Observe(res[2].URI, 20*time.Millisecond, nil)
+	obj.Observe(res[3].URI, 10*time.Millisecond, nil)
+
+	result := obj.Select(res)
+
+	assert.Equal(t, []*Resolution{res[3], res[2], res[1], res[0]}, result)
+}
+
+func TestRTTSelectorObserve(t *testing.T) {
+	u := mustParse("tcp://10.0.0.1:1234")
+	obj := &RTTSelector{}
+
+	obj.Observe(u, 80*time.Millisecond, nil)
+	obj.Observe(u, 0, assert.AnError)
+	obj.Observe(u, 160*time.Millisecond, nil)
+
+	assert.Equal(t, 90*time.Millisecond, obj.rtts[u.Key()])
+	assert.False(t, obj.failed[u.Key()])
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := map[string]Selector{
+		"srv":         SRVSelector{},
+		"round-robin": &RoundRobinSelector{},
+		"random":      RandomSelector{},
+		"rtt":         &RTTSelector{},
+	}
+
+	for name, expected := range tests {
+		result, err := NewSelector(name)
+
+		assert.NoError(t, err)
+		assert.IsType(t, expected, result)
+	}
+}
+
+func TestNewSelectorUnknown(t *testing.T) {
+	result, err := NewSelector("bogus")
+
+	assert.True(t, errors.Is(err, ErrUnknownSelector))
+	assert.Nil(t, result)
+}
+
+func TestContextSelectorDefault(t *testing.T) {
+	result := ContextSelector(context.Background())
+
+	assert.Equal(t, SRVSelector{}, result)
+}
+
+func TestContextSelectorSet(t *testing.T) {
+	s := &RTTSelector{}
+
+	result := ContextSelector(WithSelector(context.Background(), s))
+
+	assert.Same(t, s, result)
+}
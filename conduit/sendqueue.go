@@ -0,0 +1,287 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// DefaultQueueDepth is the depth of the send queue of a conduit if
+// the queue configuration does not specify one.
+const DefaultQueueDepth = 256
+
+// QueuePolicy selects the behavior of the send queue of a conduit
+// when a frame is queued while the queue is full.
+type QueuePolicy int
+
+// Send queue policies.
+const (
+	QueueBlock      QueuePolicy = iota // Wait for room in the queue
+	QueueDropOldest                    // Discard the oldest queued frame
+	QueueDropNewest                    // Discard the frame being queued
+	QueueError                         // Return ErrQueueFull
+)
+
+// QueueConfig describes the configuration of the send queue of a
+// conduit.  The zero value selects the defaults.
+type QueueConfig struct {
+	Depth        int                  // Maximum frames queued; defaults to DefaultQueueDepth
+	HighWater    int                  // Depth at which the queue becomes congested; defaults to Depth
+	LowWater     int                  // Depth at which congestion clears; defaults to half of HighWater
+	Policy       QueuePolicy          // Behavior when the queue is full
+	OnCongestion func(congested bool) // Called when the queue becomes or stops being congested
+}
+
+// depth returns the depth of the queue.
+func (qc *QueueConfig) depth() int {
+	if qc.Depth <= 0 {
+		return DefaultQueueDepth
+	}
+
+	return qc.Depth
+}
+
+// highWater returns the high watermark of the queue.
+func (qc *QueueConfig) highWater() int {
+	if qc.HighWater <= 0 || qc.HighWater > qc.depth() {
+		return qc.depth()
+	}
+
+	return qc.HighWater
+}
+
+// lowWater returns the low watermark of the queue.
+func (qc *QueueConfig) lowWater() int {
+	if qc.LowWater <= 0 || qc.LowWater >= qc.highWater() {
+		return qc.highWater() / 2
+	}
+
+	return qc.LowWater
+}
+
+// sendQueue holds the send queue of a conduit.  Frames are written by
+// a goroutine started when the first frame is queued, which stops
+// once the queue is empty.
+type sendQueue struct {
+	sync.Mutex
+
+	config    QueueConfig    // The queue configuration
+	frames    []*proto.Frame // The queued frames
+	running   bool           // The writer is running
+	congested bool           // The depth reached the high watermark
+	dropped   uint64         // Frames discarded by the policy
+	space     chan struct{}  // Closed when a frame is dequeued
+}
+
+// update updates the congestion state after the depth changes,
+// returning the function to call to notify the observer, if the state
+// changed.  Must be called with the lock held.
+func (q *sendQueue) update() func() {
+	congested := q.congested
+	switch {
+	case !congested && len(q.frames) >= q.config.highWater():
+		congested = true
+	case congested && len(q.frames) <= q.config.lowWater():
+		congested = false
+	default:
+		return func() {}
+	}
+	q.congested = congested
+
+	if cb := q.config.OnCongestion; cb != nil {
+		return func() { cb(congested) }
+	}
+	return func() {}
+}
+
+// SetQueue configures the send queue of the conduit.  Frames already
+// queued are retained, even if they exceed the new depth.
+func (c *Conduit) SetQueue(config QueueConfig) {
+	c.queue.Lock()
+	c.queue.config = config
+	notify := c.queue.update()
+	c.queue.Unlock()
+
+	notify()
+}
+
+// Enqueue queues a frame for sending over the conduit, returning
+// without waiting for it to be written, so that a slow peer does not
+// stall the caller.  If the queue is full, the queue policy selects
+// the behavior: QueueBlock waits for room until the context is
+// cancelled, returning the context's error; QueueDropOldest discards
+// the oldest queued frame; QueueDropNewest discards the frame; and
+// QueueError returns ErrQueueFull.  If the conduit's context has been
+// cancelled, its cause is returned.  Errors writing queued frames are
+// not reported to the caller, but cancel the conduit's context as for
+// Send; the remaining queued frames are then discarded.
+func (c *Conduit) Enqueue(ctx context.Context, frame *proto.Frame) error {
+	return c.enqueue(ctx, frame, true)
+}
+
+// TryEnqueue queues a frame for sending over the conduit as for
+// Enqueue, but never waits for room in the queue: under the
+// QueueBlock policy, ErrQueueFull is returned if the queue is full.
+// It suits callers which must not block, such as goroutines reading
+// from conduits.
+func (c *Conduit) TryEnqueue(frame *proto.Frame) error {
+	return c.enqueue(context.Background(), frame, false)
+}
+
+// enqueue implements Enqueue and TryEnqueue.  If wait is false, the
+// QueueBlock policy acts as QueueError.
+func (c *Conduit) enqueue(ctx context.Context, frame *proto.Frame, wait bool) error {
+	cctx := c.Context()
+	q := &c.queue
+
+	q.Lock()
+	for len(q.frames) >= q.config.depth() {
+		if cctx.Err() != nil {
+			q.Unlock()
+			return context.Cause(cctx)
+		}
+
+		switch q.config.Policy {
+		case QueueDropOldest:
+			q.frames = q.frames[1:]
+			q.dropped++
+			continue
+		case QueueDropNewest:
+			q.dropped++
+			q.Unlock()
+			return nil
+		case QueueError:
+			q.Unlock()
+			return ErrQueueFull
+		}
+		if !wait {
+			q.Unlock()
+			return ErrQueueFull
+		}
+
+		// Wait for room in the queue
+		if q.space == nil {
+			q.space = make(chan struct{})
+		}
+		space := q.space
+		q.Unlock()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-cctx.Done():
+			return context.Cause(cctx)
+		}
+		q.Lock()
+	}
+	if cctx.Err() != nil {
+		q.Unlock()
+		return context.Cause(cctx)
+	}
+
+	// Queue the frame, starting the writer if necessary
+	q.frames = append(q.frames, frame)
+	notify := q.update()
+	if !q.running {
+		q.running = true
+		go c.queueWriter()
+	}
+	q.Unlock()
+
+	notify()
+	return nil
+}
+
+// dequeue removes the next frame from the queue, returning nil and
+// marking the writer as stopped if there are none.  If the conduit's
+// context has been cancelled, the queued frames are discarded.
+func (c *Conduit) dequeue() (*proto.Frame, func()) {
+	q := &c.queue
+	q.Lock()
+	defer q.Unlock()
+
+	if c.Context().Err() != nil {
+		q.frames = nil
+	}
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+	if len(q.frames) == 0 {
+		q.running = false
+		return nil, q.update()
+	}
+
+	f := q.frames[0]
+	q.frames[0] = nil
+	q.frames = q.frames[1:]
+
+	return f, q.update()
+}
+
+// queueWriter writes the frames queued on the conduit until none
+// remain.
+func (c *Conduit) queueWriter() {
+	for {
+		f, notify := c.dequeue()
+		notify()
+		if f == nil {
+			return
+		}
+		c.Send(f) //nolint:errcheck
+	}
+}
+
+// QueueDepth returns the number of frames in the send queue of the
+// conduit, which routing layers may use to prefer less-congested
+// paths.
+func (c *Conduit) QueueDepth() int {
+	c.queue.Lock()
+	defer c.queue.Unlock()
+
+	return len(c.queue.frames)
+}
+
+// QueueIdle reports whether the send queue of the conduit is empty
+// and no queued frame is being written.
+func (c *Conduit) QueueIdle() bool {
+	c.queue.Lock()
+	defer c.queue.Unlock()
+
+	return !c.queue.running
+}
+
+// Congested reports whether the send queue of the conduit is
+// congested: its depth has reached the high watermark, and has not
+// since fallen to the low watermark.
+func (c *Conduit) Congested() bool {
+	c.queue.Lock()
+	defer c.queue.Unlock()
+
+	return c.queue.congested
+}
+
+// QueueDropped returns the number of frames discarded from the send
+// queue of the conduit by the QueueDropOldest and QueueDropNewest
+// policies.
+func (c *Conduit) QueueDropped() uint64 {
+	c.queue.Lock()
+	defer c.queue.Unlock()
+
+	return c.queue.dropped
+}
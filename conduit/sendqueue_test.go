@@ -0,0 +1,269 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// queueFrame constructs a distinct frame for queueing.
+func queueFrame(payload byte) *proto.Frame {
+	return &proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte{payload}}
+}
+
+// stalledConduit constructs a conduit whose queue writer is marked as
+// running, so that queued frames stay in the queue.
+func stalledConduit(config QueueConfig) *Conduit {
+	c := &Conduit{State: Open}
+	c.SetQueue(config)
+	c.queue.running = true
+
+	return c
+}
+
+func TestQueueConfigDefaults(t *testing.T) {
+	obj := &QueueConfig{}
+
+	assert.Equal(t, DefaultQueueDepth, obj.depth())
+	assert.Equal(t, DefaultQueueDepth, obj.highWater())
+	assert.Equal(t, DefaultQueueDepth/2, obj.lowWater())
+}
+
+func TestQueueConfigSet(t *testing.T) {
+	obj := &QueueConfig{Depth: 10, HighWater: 8, LowWater: 3}
+
+	assert.Equal(t, 10, obj.depth())
+	assert.Equal(t, 8, obj.highWater())
+	assert.Equal(t, 3, obj.lowWater())
+}
+
+func TestQueueConfigClamped(t *testing.T) {
+	obj := &QueueConfig{Depth: 10, HighWater: 20, LowWater: 15}
+
+	assert.Equal(t, 10, obj.highWater())
+	assert.Equal(t, 5, obj.lowWater())
+}
+
+func TestConduitEnqueueBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1, Integrity: true}
+	defer obj.Close()
+
+	for i := range 3 {
+		require.NoError(t, obj.Enqueue(context.Background(), queueFrame(byte(i))))
+	}
+
+	buf := make([]byte, 15)
+	_, err := io.ReadFull(c2, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x00, 0x17, 0x00, 0x01, 0,
+		0x00, 0x17, 0x00, 0x01, 1,
+		0x00, 0x17, 0x00, 0x01, 2,
+	}, buf)
+	assert.Eventually(t, func() bool {
+		return obj.QueueDepth() == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestConduitEnqueueDropOldest(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 2, Policy: QueueDropOldest})
+	f1, f2, f3 := queueFrame(1), queueFrame(2), queueFrame(3)
+
+	for _, f := range []*proto.Frame{f1, f2, f3} {
+		require.NoError(t, obj.Enqueue(context.Background(), f))
+	}
+
+	assert.Equal(t, []*proto.Frame{f2, f3}, obj.queue.frames)
+	assert.Equal(t, uint64(1), obj.QueueDropped())
+}
+
+func TestConduitEnqueueDropNewest(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 2, Policy: QueueDropNewest})
+	f1, f2, f3 := queueFrame(1), queueFrame(2), queueFrame(3)
+
+	for _, f := range []*proto.Frame{f1, f2, f3} {
+		require.NoError(t, obj.Enqueue(context.Background(), f))
+	}
+
+	assert.Equal(t, []*proto.Frame{f1, f2}, obj.queue.frames)
+	assert.Equal(t, uint64(1), obj.QueueDropped())
+}
+
+func TestConduitEnqueueError(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 1, Policy: QueueError})
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(1)))
+
+	err := obj.Enqueue(context.Background(), queueFrame(2))
+
+	assert.Same(t, ErrQueueFull, err)
+	assert.Equal(t, 1, obj.QueueDepth())
+	assert.Equal(t, uint64(0), obj.QueueDropped())
+}
+
+func TestConduitEnqueueBlock(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 1})
+	f1, f2 := queueFrame(1), queueFrame(2)
+	require.NoError(t, obj.Enqueue(context.Background(), f1))
+	done := make(chan error, 1)
+	go func() {
+		done <- obj.Enqueue(context.Background(), f2)
+	}()
+	require.Eventually(t, func() bool {
+		obj.queue.Lock()
+		defer obj.queue.Unlock()
+		return obj.queue.space != nil
+	}, time.Second, time.Millisecond)
+
+	result, _ := obj.dequeue()
+
+	assert.Same(t, f1, result)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []*proto.Frame{f2}, obj.queue.frames)
+}
+
+func TestConduitEnqueueBlockCancelled(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 1})
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(1)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := obj.Enqueue(ctx, queueFrame(2))
+
+	assert.Same(t, context.Canceled, err)
+	assert.Equal(t, 1, obj.QueueDepth())
+}
+
+func TestConduitTryEnqueueFull(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 1})
+	require.NoError(t, obj.TryEnqueue(queueFrame(1)))
+
+	err := obj.TryEnqueue(queueFrame(2))
+
+	assert.Same(t, ErrQueueFull, err)
+	assert.Equal(t, 1, obj.QueueDepth())
+	assert.Nil(t, obj.queue.space)
+}
+
+func TestConduitTryEnqueueDropOldest(t *testing.T) {
+	obj := stalledConduit(QueueConfig{Depth: 1, Policy: QueueDropOldest})
+	f := queueFrame(2)
+	require.NoError(t, obj.TryEnqueue(queueFrame(1)))
+
+	err := obj.TryEnqueue(f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*proto.Frame{f}, obj.queue.frames)
+	assert.Equal(t, uint64(1), obj.QueueDropped())
+}
+
+func TestConduitEnqueueClosed(t *testing.T) {
+	obj := &Conduit{State: Open}
+	obj.Close() //nolint:errcheck
+
+	err := obj.Enqueue(context.Background(), queueFrame(1))
+
+	assert.Same(t, ErrConduitClosed, err)
+	assert.Equal(t, 0, obj.QueueDepth())
+}
+
+func TestConduitEnqueueCongestion(t *testing.T) {
+	events := []bool{}
+	obj := stalledConduit(QueueConfig{
+		Depth:     4,
+		HighWater: 2,
+		LowWater:  1,
+		OnCongestion: func(congested bool) {
+			events = append(events, congested)
+		},
+	})
+
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(1)))
+	assert.False(t, obj.Congested())
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(2)))
+	assert.True(t, obj.Congested())
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(3)))
+	_, notify := obj.dequeue()
+	notify()
+	assert.True(t, obj.Congested())
+	_, notify = obj.dequeue()
+	notify()
+
+	assert.False(t, obj.Congested())
+	assert.Equal(t, []bool{true, false}, events)
+}
+
+func TestConduitSetQueueCongestion(t *testing.T) {
+	events := []bool{}
+	obj := stalledConduit(QueueConfig{})
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(1)))
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(2)))
+
+	obj.SetQueue(QueueConfig{
+		Depth: 2,
+		OnCongestion: func(congested bool) {
+			events = append(events, congested)
+		},
+	})
+
+	assert.True(t, obj.Congested())
+	assert.Equal(t, []bool{true}, events)
+}
+
+func TestConduitQueueIdle(t *testing.T) {
+	obj := stalledConduit(QueueConfig{})
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(1)))
+	assert.False(t, obj.QueueIdle())
+
+	result, _ := obj.dequeue()
+	assert.NotNil(t, result)
+	assert.False(t, obj.QueueIdle())
+	result, _ = obj.dequeue()
+
+	assert.Nil(t, result)
+	assert.True(t, obj.QueueIdle())
+}
+
+func TestConduitDequeueEmpty(t *testing.T) {
+	obj := stalledConduit(QueueConfig{})
+
+	result, notify := obj.dequeue()
+	notify()
+
+	assert.Nil(t, result)
+	assert.False(t, obj.queue.running)
+}
+
+func TestConduitDequeueClosed(t *testing.T) {
+	obj := stalledConduit(QueueConfig{})
+	require.NoError(t, obj.Enqueue(context.Background(), queueFrame(1)))
+	obj.Close() //nolint:errcheck
+
+	result, _ := obj.dequeue()
+
+	assert.Nil(t, result)
+	assert.Equal(t, 0, obj.QueueDepth())
+	assert.False(t, obj.queue.running)
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultDrainTimeout is the default time a Server waits for its
+// handlers to return when shutting down.
+const DefaultDrainTimeout = 30 * time.Second
+
+// Delays applied by Server after temporary Accept failures.  The
+// delay starts at the minimum and doubles with each consecutive
+// failure, up to the maximum.
+const (
+	MinAcceptDelay = 5 * time.Millisecond
+	MaxAcceptDelay = time.Second
+)
+
+// Handler is an interface for handlers of conduits accepted by a
+// Server.
+type Handler interface {
+	// Handle handles the conduit.  The context is cancelled when
+	// the server shuts down or the context of the conduit is
+	// cancelled, after which the handler should return promptly.
+	// The server closes the conduit when the handler returns.
+	Handle(ctx context.Context, c *Conduit)
+}
+
+// HandlerFunc is an adaptor allowing an ordinary function to be used
+// as a Handler.
+type HandlerFunc func(ctx context.Context, c *Conduit)
+
+// Handle handles the conduit.
+func (f HandlerFunc) Handle(ctx context.Context, c *Conduit) {
+	f(ctx, c)
+}
+
+// Server runs the accept loop for a Listener, dispatching each
+// accepted conduit to a handler in its own goroutine.  The number of
+// concurrent conduits may be limited, in which case no further
+// conduits are accepted until a handler returns.  Accept failures
+// are classified with ClassifyAccept and counted; failed handshakes
+// and rejected peers are ignored, and temporary failures are retried
+// after a delay.  The exported fields must be set before calling
+// Serve.
+type Server struct {
+	Listener     Listener      // The listener to accept conduits from
+	Handler      Handler       // The handler for accepted conduits
+	MaxConduits  int           // Maximum concurrent conduits; 0 for no limit
+	DrainTimeout time.Duration // Time to wait for handlers on shutdown
+	Usage        *UsagePolicy  // Usage policy for accepted conduits; may be nil
+	Rate         *RateLimit    // Rate limits for accepted conduits; may be nil
+	Chaos        *Chaos        // Faults to inject into accepted conduits; may be nil
+
+	wg         sync.WaitGroup          // Tracks the running handlers
+	lock       sync.Mutex              // Protects conduits and acceptErrs
+	conduits   map[*Conduit]struct{}   // The conduits being handled
+	acceptErrs map[AcceptReason]uint64 // Counts of Accept failures
+}
+
+// Count returns the number of conduits currently being handled.
+func (s *Server) Count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.conduits)
+}
+
+// AcceptErrors returns the number of Accept failures encountered by
+// the server, by reason.
+func (s *Server) AcceptErrors() map[AcceptReason]uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := make(map[AcceptReason]uint64, len(s.acceptErrs))
+	for reason, count := range s.acceptErrs {
+		result[reason] = count
+	}
+
+	return result
+}
+
+// acceptFailed classifies and counts an Accept failure, returning
+// its reason.
+func (s *Server) acceptFailed(err error) AcceptReason {
+	reason := ClassifyAccept(err)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.acceptErrs == nil {
+		s.acceptErrs = map[AcceptReason]uint64{}
+	}
+	s.acceptErrs[reason]++
+	logAccept(reason, err)
+
+	return reason
+}
+
+// track adds or removes a conduit from the set being handled.
+func (s *Server) track(c *Conduit, add bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if add {
+		if s.conduits == nil {
+			s.conduits = map[*Conduit]struct{}{}
+		}
+		s.conduits[c] = struct{}{}
+	} else {
+		delete(s.conduits, c)
+	}
+}
+
+// handle runs the handler for a conduit.
+func (s *Server) handle(ctx context.Context, c *Conduit, release func()) {
+	defer s.wg.Done()
+	defer release()
+	defer s.track(c, false)
+	defer closeLink(c)
+
+	// Stop the handler if the conduit dies
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.Context(), cancel)
+	defer stop()
+
+	s.Handler.Handle(ctx, c)
+}
+
+// closeLink closes a conduit, ignoring any error.
+func closeLink(c *Conduit) {
+	c.Close() //nolint:errcheck
+}
+
+// Serve accepts conduits from the listener until the context is
+// cancelled or accepting fails with an error that may not be
+// retried.  It then closes the listener and waits up to the drain
+// timeout for the handlers to return, after which the remaining
+// conduits are closed.  Serve returns nil if the
+// context was cancelled and the handlers returned in time; otherwise,
+// it returns the error from the listener or an error wrapping
+// ErrDrainTimeout.
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Close the listener when the context is cancelled
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		s.Listener.Close() //nolint:errcheck
+	}()
+
+	// Set up the concurrency limit
+	var sem chan struct{}
+	if s.MaxConduits > 0 {
+		sem = make(chan struct{}, s.MaxConduits)
+	}
+	release := func() {
+		if sem != nil {
+			<-sem
+		}
+	}
+
+	var err error
+	delay := time.Duration(0)
+	for {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		c, acceptErr := s.Listener.Accept()
+		if acceptErr != nil {
+			release()
+			if ctx.Err() != nil {
+				break
+			}
+
+			reason := s.acceptFailed(acceptErr)
+			if !reason.Retry() {
+				err = acceptErr
+				break
+			}
+			if reason == AcceptTemporary {
+				delay = min(max(2*delay, MinAcceptDelay), MaxAcceptDelay)
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+				}
+				timer.Stop()
+			}
+			continue
+		}
+		delay = 0
+
+		if s.Usage != nil {
+			c.SetUsagePolicy(s.Usage)
+		}
+		if s.Rate != nil {
+			c.SetRateLimit(s.Rate)
+		}
+		if s.Chaos != nil {
+			s.Chaos.Apply(c)
+		}
+		s.track(c, true)
+		s.wg.Add(1)
+		go s.handle(ctx, c, release)
+	}
+
+	// Stop accepting and drain the handlers
+	cancel()
+	<-stopped
+	if drainErr := s.drain(); err == nil {
+		err = drainErr
+	}
+
+	return err
+}
+
+// drain waits for the handlers to return, closing the remaining
+// conduits if they do not return within the drain timeout.
+func (s *Server) drain() error {
+	timeout := s.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.wg.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+	}
+
+	// Close the remaining conduits to unblock the handlers
+	s.lock.Lock()
+	remaining := len(s.conduits)
+	for c := range s.conduits {
+		closeLink(c)
+	}
+	s.lock.Unlock()
+	<-done
+
+	return fmt.Errorf("%d conduits: %w", remaining, ErrDrainTimeout)
+}
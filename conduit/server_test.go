@@ -0,0 +1,288 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// chanListener is a Listener that accepts conduits from a channel.
+type chanListener struct {
+	conduits chan *Conduit
+	once     sync.Once
+	closed   chan struct{}
+}
+
+func newChanListener() *chanListener {
+	return &chanListener{
+		conduits: make(chan *Conduit),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (l *chanListener) Accept() (*Conduit, error) {
+	select {
+	case c := <-l.conduits:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *chanListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *chanListener) Addr() *URI {
+	return nil
+}
+
+// pipeConduit returns a conduit over one end of a pipe, along with
+// the other end.
+func pipeConduit(t *testing.T) (*Conduit, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+
+	return &Conduit{Link: local}, remote
+}
+
+func TestServerServeBase(t *testing.T) {
+	l := newChanListener()
+	handled := make(chan *Conduit, 2)
+	obj := &Server{
+		Listener: l,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			handled <- c
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c1, r1 := pipeConduit(t)
+	c2, _ := pipeConduit(t)
+
+	l.conduits <- c1
+	l.conduits <- c2
+	h1, h2 := <-handled, <-handled
+	assert.True(t, (h1 == c1 && h2 == c2) || (h1 == c2 && h2 == c1))
+	cancel()
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, obj.Count())
+	_, err := r1.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestServerServeUsage(t *testing.T) {
+	l := newChanListener()
+	handled := make(chan *Conduit, 1)
+	policy := &UsagePolicy{Outstanding: Limit{Hard: 1}}
+	obj := &Server{
+		Listener: l,
+		Usage:    policy,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			handled <- c
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c, _ := pipeConduit(t)
+
+	l.conduits <- c
+	<-handled
+	cancel()
+
+	assert.NoError(t, <-done)
+	assert.Same(t, policy, c.usage.policy)
+}
+
+func TestServerServeRate(t *testing.T) {
+	l := newChanListener()
+	handled := make(chan *Conduit, 1)
+	limit := &RateLimit{PDUs: Rate{Rate: 10}}
+	obj := &Server{
+		Listener: l,
+		Rate:     limit,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			handled <- c
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c, _ := pipeConduit(t)
+
+	l.conduits <- c
+	<-handled
+	cancel()
+
+	assert.NoError(t, <-done)
+	assert.Same(t, limit, c.rate.limit)
+}
+
+func TestServerServeChaos(t *testing.T) {
+	l := newChanListener()
+	handled := make(chan *Conduit, 1)
+	chaos := &Chaos{Reset: 1}
+	obj := &Server{
+		Listener: l,
+		Chaos:    chaos,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			handled <- c
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c, _ := pipeConduit(t)
+
+	l.conduits <- c
+	<-handled
+	cancel()
+
+	assert.NoError(t, <-done)
+	assert.IsType(t, &chaosConn{}, c.Link)
+}
+
+func TestServerServeConduitDies(t *testing.T) {
+	l := newChanListener()
+	stopped := make(chan error, 1)
+	obj := &Server{
+		Listener: l,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			<-ctx.Done()
+			stopped <- context.Cause(c.Context())
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c, _ := pipeConduit(t)
+
+	l.conduits <- c
+	c.cancelContext(assert.AnError)
+
+	assert.Same(t, assert.AnError, <-stopped)
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestServerServeAcceptError(t *testing.T) {
+	l := &mockListener{}
+	l.On("Accept").Return(nil, assert.AnError)
+	l.On("Close").Return(nil)
+	obj := &Server{Listener: l}
+
+	err := obj.Serve(context.Background())
+
+	assert.Same(t, assert.AnError, err)
+	l.AssertCalled(t, "Close")
+}
+
+func TestServerServeAcceptRetry(t *testing.T) {
+	l := &mockListener{}
+	l.On("Accept").Return(nil, &AcceptError{Reason: AcceptHandshake, Err: assert.AnError}).Once()
+	l.On("Accept").Return(nil, &AcceptError{Reason: AcceptRejected, Err: ErrUnauthorized}).Once()
+	l.On("Accept").Return(nil, &net.OpError{Op: "accept", Err: syscall.EMFILE}).Twice()
+	l.On("Accept").Return(nil, assert.AnError)
+	l.On("Close").Return(nil)
+	obj := &Server{Listener: l}
+
+	err := obj.Serve(context.Background())
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, map[AcceptReason]uint64{
+		AcceptFatal:     1,
+		AcceptTemporary: 2,
+		AcceptHandshake: 1,
+		AcceptRejected:  1,
+	}, obj.AcceptErrors())
+	l.AssertNumberOfCalls(t, "Accept", 5)
+}
+
+func TestServerServeAcceptTemporaryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &mockListener{}
+	l.On("Accept").Return(nil, &net.OpError{Op: "accept", Err: syscall.EMFILE}).Run(func(args mock.Arguments) {
+		cancel()
+	})
+	l.On("Close").Return(nil)
+	obj := &Server{Listener: l}
+
+	err := obj.Serve(ctx)
+
+	assert.NoError(t, err)
+}
+
+func TestServerServeMaxConduits(t *testing.T) {
+	l := newChanListener()
+	release := make(chan struct{})
+	obj := &Server{
+		Listener:    l,
+		MaxConduits: 1,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			<-release
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c1, _ := pipeConduit(t)
+	c2, _ := pipeConduit(t)
+
+	l.conduits <- c1
+	select {
+	case l.conduits <- c2:
+		t.Fatal("accepted conduit beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 1, obj.Count())
+	release <- struct{}{}
+	l.conduits <- c2
+	close(release)
+	cancel()
+
+	assert.NoError(t, <-done)
+}
+
+func TestServerServeDrainTimeout(t *testing.T) {
+	l := newChanListener()
+	obj := &Server{
+		Listener:     l,
+		DrainTimeout: time.Millisecond,
+		Handler: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			c.Link.Read(make([]byte, 1)) //nolint:errcheck
+		}),
+	}
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- obj.Serve(ctx) }()
+	c, _ := pipeConduit(t)
+
+	l.conduits <- c
+	cancel()
+
+	assert.ErrorIs(t, <-done, ErrDrainTimeout)
+}
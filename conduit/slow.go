@@ -0,0 +1,179 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Slow operation types.
+const (
+	SlowWrite     = "write"     // Writing a single PDU
+	SlowDial      = "dial"      // Dialing a conduit
+	SlowNegotiate = "negotiate" // Protocol negotiation over a conduit
+	SlowHandler   = "handler"   // Handling a received PDU
+)
+
+// Default thresholds for slow operations.
+const (
+	DefaultSlowWrite     = time.Second
+	DefaultSlowDial      = 10 * time.Second
+	DefaultSlowNegotiate = 5 * time.Second
+	DefaultSlowHandler   = time.Second
+)
+
+// maxStackSize is the maximum size of a stack snapshot.
+const maxStackSize = 1 << 20
+
+// SlowEvent describes an operation that exceeded its threshold.  Two
+// events are reported for each slow operation: one when the threshold
+// is exceeded, which includes a snapshot of the stacks of all
+// goroutines taken while the operation is stalled, and one when the
+// operation finally completes, with Done set.
+type SlowEvent struct {
+	Op        string        // The type of operation
+	Conduit   *Conduit      // The conduit, if any
+	Detail    string        // Additional detail, such as a URI
+	Threshold time.Duration // The threshold that was exceeded
+	Elapsed   time.Duration // Time elapsed since the operation began
+	Done      bool          // Flag indicating operation has completed
+	Stack     []byte        // Stacks of all goroutines, if not done
+}
+
+// SlowTracer is an interface for receivers of slow operation events.
+type SlowTracer interface {
+	// Slow is called with each slow operation event.  It must not
+	// block.
+	Slow(ev *SlowEvent)
+}
+
+// SlowTracerFunc is an adaptor allowing an ordinary function to be
+// used as a SlowTracer.
+type SlowTracerFunc func(ev *SlowEvent)
+
+// Slow is called with each slow operation event.  It must not block.
+func (f SlowTracerFunc) Slow(ev *SlowEvent) {
+	f(ev)
+}
+
+// slowTracer is the current slow operation tracer, and
+// slowThresholds contains the thresholds for each operation.
+var (
+	slowTracer     SlowTracer
+	slowThresholds = map[string]time.Duration{
+		SlowWrite:     DefaultSlowWrite,
+		SlowDial:      DefaultSlowDial,
+		SlowNegotiate: DefaultSlowNegotiate,
+		SlowHandler:   DefaultSlowHandler,
+	}
+	slowLock sync.RWMutex
+)
+
+// SetSlowTracer sets the tracer to receive slow operation events,
+// returning the previous tracer.  Passing nil disables tracing.
+func SetSlowTracer(t SlowTracer) SlowTracer {
+	slowLock.Lock()
+	defer slowLock.Unlock()
+
+	prev := slowTracer
+	slowTracer = t
+
+	return prev
+}
+
+// SetSlowThreshold sets the threshold for the specified type of
+// operation, returning the previous threshold.  A threshold of 0
+// disables tracing of that type of operation.
+func SetSlowThreshold(op string, threshold time.Duration) time.Duration {
+	slowLock.Lock()
+	defer slowLock.Unlock()
+
+	prev := slowThresholds[op]
+	slowThresholds[op] = threshold
+
+	return prev
+}
+
+// stackSnapshot returns the stacks of all goroutines.
+func stackSnapshot() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackSize {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// TraceSlow begins tracing an operation, returning a function to be
+// called when the operation completes.  If the operation exceeds the
+// threshold for its type, events are reported to the tracer.  The
+// conduit and detail are included in the events, and may be empty.
+// TraceSlow is used internally for writes, dials, and negotiations,
+// and may be used by applications to trace PDU handlers.
+func TraceSlow(op string, c *Conduit, detail string) func() {
+	slowLock.RLock()
+	tracer := slowTracer
+	threshold := slowThresholds[op]
+	slowLock.RUnlock()
+
+	if tracer == nil || threshold <= 0 {
+		return func() {}
+	}
+
+	// Arrange to report the operation if it stalls
+	start := timeNow()
+	var lock sync.Mutex
+	stalled, finished := false, false
+	timer := afterFunc(threshold, func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if finished {
+			return
+		}
+		stalled = true
+		tracer.Slow(&SlowEvent{
+			Op:        op,
+			Conduit:   c,
+			Detail:    detail,
+			Threshold: threshold,
+			Elapsed:   timeNow().Sub(start),
+			Stack:     stackSnapshot(),
+		})
+	})
+
+	return func() {
+		timer.Stop()
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		finished = true
+		if stalled {
+			tracer.Slow(&SlowEvent{
+				Op:        op,
+				Conduit:   c,
+				Detail:    detail,
+				Threshold: threshold,
+				Elapsed:   timeNow().Sub(start),
+				Done:      true,
+			})
+		}
+	}
+}
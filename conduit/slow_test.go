@@ -0,0 +1,165 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowTracerFuncImplementsSlowTracer(t *testing.T) {
+	assert.Implements(t, (*SlowTracer)(nil), SlowTracerFunc(nil))
+}
+
+func TestSlowTracerFuncSlow(t *testing.T) {
+	ev := &SlowEvent{}
+	called := false
+	obj := SlowTracerFunc(func(e *SlowEvent) {
+		assert.Same(t, ev, e)
+		called = true
+	})
+
+	obj.Slow(ev)
+
+	assert.True(t, called)
+}
+
+func TestSetSlowTracer(t *testing.T) {
+	t1 := SlowTracerFunc(func(ev *SlowEvent) {})
+	defer SetSlowTracer(SetSlowTracer(nil))
+
+	result1 := SetSlowTracer(t1)
+	result2 := SetSlowTracer(nil)
+
+	assert.Nil(t, result1)
+	assert.NotNil(t, result2)
+}
+
+func TestSetSlowThreshold(t *testing.T) {
+	defer SetSlowThreshold(SlowWrite, SetSlowThreshold(SlowWrite, DefaultSlowWrite))
+
+	result1 := SetSlowThreshold(SlowWrite, time.Minute)
+	result2 := SetSlowThreshold(SlowWrite, DefaultSlowWrite)
+
+	assert.Equal(t, DefaultSlowWrite, result1)
+	assert.Equal(t, time.Minute, result2)
+}
+
+func TestStackSnapshot(t *testing.T) {
+	result := stackSnapshot()
+
+	assert.Contains(t, string(result), "TestStackSnapshot")
+}
+
+// fakeClock patches the clock and the timers created by afterFunc,
+// which are expected to be set for a second, returning a pointer to
+// the current time and to the function that would be called by the
+// timer.
+func fakeClock(t *testing.T) (*time.Time, *func()) {
+	now := time.Unix(1000, 0)
+	var fire func()
+	p := patcher.NewPatchMaster(
+		patcher.SetVar(&timeNow, func() time.Time {
+			return now
+		}),
+		patcher.SetVar(&afterFunc, func(d time.Duration, f func()) *time.Timer {
+			assert.Equal(t, time.Second, d)
+			fire = f
+			return time.NewTimer(time.Hour)
+		}),
+	)
+	p.Install()
+	t.Cleanup(func() {
+		p.Restore()
+	})
+
+	return &now, &fire
+}
+
+func TestTraceSlowNoTracer(t *testing.T) {
+	defer SetSlowTracer(SetSlowTracer(nil))
+	_, fire := fakeClock(t)
+
+	TraceSlow(SlowWrite, nil, "")()
+
+	assert.Nil(t, *fire)
+}
+
+func TestTraceSlowDisabled(t *testing.T) {
+	defer SetSlowTracer(SetSlowTracer(SlowTracerFunc(func(ev *SlowEvent) {
+		t.Fail()
+	})))
+	defer SetSlowThreshold(SlowWrite, SetSlowThreshold(SlowWrite, 0))
+	_, fire := fakeClock(t)
+
+	TraceSlow(SlowWrite, nil, "")()
+
+	assert.Nil(t, *fire)
+}
+
+func TestTraceSlowFast(t *testing.T) {
+	events := []*SlowEvent{}
+	defer SetSlowTracer(SetSlowTracer(SlowTracerFunc(func(ev *SlowEvent) {
+		events = append(events, ev)
+	})))
+	defer SetSlowThreshold(SlowWrite, SetSlowThreshold(SlowWrite, time.Second))
+	_, fire := fakeClock(t)
+
+	done := TraceSlow(SlowWrite, nil, "")
+	done()
+	(*fire)()
+
+	assert.Equal(t, []*SlowEvent{}, events)
+}
+
+func TestTraceSlowStalled(t *testing.T) {
+	c := &Conduit{}
+	events := []*SlowEvent{}
+	defer SetSlowTracer(SetSlowTracer(SlowTracerFunc(func(ev *SlowEvent) {
+		events = append(events, ev)
+	})))
+	defer SetSlowThreshold(SlowWrite, SetSlowThreshold(SlowWrite, time.Second))
+	now, fire := fakeClock(t)
+
+	done := TraceSlow(SlowWrite, c, "detail")
+	*now = now.Add(time.Second)
+	(*fire)()
+	*now = now.Add(time.Second)
+	done()
+
+	assert.Len(t, events, 2)
+	assert.NotEmpty(t, events[0].Stack)
+	events[0].Stack = nil
+	assert.Equal(t, []*SlowEvent{
+		{
+			Op:        SlowWrite,
+			Conduit:   c,
+			Detail:    "detail",
+			Threshold: time.Second,
+			Elapsed:   time.Second,
+		},
+		{
+			Op:        SlowWrite,
+			Conduit:   c,
+			Detail:    "detail",
+			Threshold: time.Second,
+			Elapsed:   2 * time.Second,
+			Done:      true,
+		},
+	}, events)
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Defaults for the Sniff option.
+const (
+	DefaultSniffSize    = 16              // Default number of bytes to peek
+	DefaultSniffTimeout = 2 * time.Second // Default time to wait for the bytes
+)
+
+// Sniffer is an interface for classifying the first bytes received
+// over an accepted conduit.
+type Sniffer interface {
+	// Sniff reports whether the data identifies traffic that is
+	// not Humboldt traffic and should be routed to the fallback
+	// handler.  The data may be shorter than requested if the
+	// peer sent less before the timeout expired.
+	Sniff(data []byte) bool
+}
+
+// SnifferFunc is an adaptor allowing an ordinary function to be used
+// as a Sniffer.
+type SnifferFunc func(data []byte) bool
+
+// Sniff reports whether the data identifies traffic that is not
+// Humboldt traffic.
+func (f SnifferFunc) Sniff(data []byte) bool {
+	return f(data)
+}
+
+// httpPrefixes are the prefixes of HTTP/1.x requests and of the
+// HTTP/2 connection preface.
+var httpPrefixes = [][]byte{
+	[]byte("GET "),
+	[]byte("HEAD "),
+	[]byte("POST "),
+	[]byte("PUT "),
+	[]byte("DELETE "),
+	[]byte("OPTIONS "),
+	[]byte("PATCH "),
+	[]byte("TRACE "),
+	[]byte("CONNECT "),
+	[]byte("PRI * HTTP/2.0"),
+}
+
+// sniffHTTP implements HTTPSniffer.
+func sniffHTTP(data []byte) bool {
+	for _, prefix := range httpPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HTTPSniffer is the default Sniffer.  It identifies HTTP requests,
+// such as the health checks issued by load balancers.
+var HTTPSniffer Sniffer = SnifferFunc(sniffHTTP)
+
+// SniffOption is an option for Listen that peeks at the first bytes
+// received over each conduit accepted by the transport, before any
+// security layer handshake, and routes those the sniffer identifies
+// as foreign traffic to a fallback handler rather than returning
+// them from Accept.  Conduits closed by the peer before sending
+// anything, such as those opened by TCP health checks, are closed
+// and skipped.  Conduits of transports preserving message boundaries
+// are not sniffed.  Note that the peek is performed by Accept, so a
+// peer sending nothing delays other conduits by up to the timeout.
+type SniffOption struct {
+	Sniffer  Sniffer       // Classifies the data; defaults to HTTPSniffer
+	Fallback Handler       // Handles foreign conduits; required
+	Size     int           // Number of bytes to peek; defaults to DefaultSniffSize
+	Timeout  time.Duration // Time to wait for the bytes; defaults to DefaultSniffTimeout
+}
+
+// Sniff returns an option for Listen that routes foreign traffic, as
+// identified by HTTPSniffer, to the fallback handler.  The fallback
+// handler is run in its own goroutine; its context is cancelled when
+// the listener is closed, and the conduit is closed when it returns.
+func Sniff(fallback Handler) *SniffOption {
+	return &SniffOption{
+		Fallback: fallback,
+	}
+}
+
+// ListenApply applies the option to a net.ListenConfig.  The option
+// does not modify the configuration; it is applied by Listen.
+func (so *SniffOption) ListenApply(lc *net.ListenConfig) {}
+
+// sniffListen wraps a transport listener to apply any SniffOption
+// present in the options.
+func sniffListen(l Listener, opts []ListenerOption) Listener {
+	for _, opt := range opts {
+		if so, ok := opt.(*SniffOption); ok && so.Fallback != nil {
+			sl := &sniffListener{Listener: l, opt: so}
+			sl.ctx, sl.cancel = context.WithCancel(context.Background())
+			return sl
+		}
+	}
+
+	return l
+}
+
+// sniffListener is a Listener that sniffs the conduits accepted by
+// the wrapped listener.
+type sniffListener struct {
+	Listener
+
+	opt    *SniffOption       // The sniff option
+	ctx    context.Context    // Context for fallback handlers
+	cancel context.CancelFunc // Cancels the context
+}
+
+// sniff peeks at the first bytes of the conduit, returning the
+// buffered link and the data peeked.
+func (l *sniffListener) sniff(c *Conduit) (net.Conn, []byte, error) {
+	size, timeout := l.opt.Size, l.opt.Timeout
+	if size <= 0 {
+		size = DefaultSniffSize
+	}
+	if timeout <= 0 {
+		timeout = DefaultSniffTimeout
+	}
+
+	c.Link.SetReadDeadline(time.Now().Add(timeout)) //nolint:errcheck
+	r := bufio.NewReader(c.Link)
+	data, err := r.Peek(size)
+	c.Link.SetReadDeadline(time.Time{}) //nolint:errcheck
+
+	// Partial data is sniffed; a peer sending nothing before the
+	// timeout is passed through
+	var netErr net.Error
+	if len(data) > 0 || (errors.As(err, &netErr) && netErr.Timeout()) {
+		err = nil
+	}
+
+	return &bufferedConn{Conn: c.Link, r: r}, data, err
+}
+
+// fallback runs the fallback handler for a conduit.
+func (l *sniffListener) fallback(c *Conduit) {
+	defer closeLink(c)
+
+	l.opt.Fallback.Handle(l.ctx, c)
+}
+
+// Accept waits for and returns the next conduit to the listener.
+// Foreign conduits are dispatched to the fallback handler, and
+// conduits closed before sending anything are skipped.
+func (l *sniffListener) Accept() (*Conduit, error) {
+	sniffer := l.opt.Sniffer
+	if sniffer == nil {
+		sniffer = HTTPSniffer
+	}
+
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil || c.Boundaries || c.Link == nil {
+			return c, err
+		}
+
+		link, data, err := l.sniff(c)
+		if err != nil {
+			closeLink(c)
+			continue
+		}
+		c.Link = link
+		if len(data) > 0 && sniffer.Sniff(data) {
+			go l.fallback(c)
+			continue
+		}
+
+		return c, nil
+	}
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors, and the contexts of the fallback
+// handlers are cancelled.
+func (l *sniffListener) Close() error {
+	l.cancel()
+
+	return l.Listener.Close()
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sniffTestListener is a Listener returning conduits from a channel.
+type sniffTestListener struct {
+	conduits chan *Conduit
+	closed   bool
+}
+
+func (l *sniffTestListener) Accept() (*Conduit, error) {
+	c, ok := <-l.conduits
+	if !ok {
+		return nil, net.ErrClosed
+	}
+
+	return c, nil
+}
+
+func (l *sniffTestListener) Close() error {
+	l.closed = true
+
+	return nil
+}
+
+func (l *sniffTestListener) Addr() *URI {
+	return &URI{}
+}
+
+// sniffPipe returns a conduit for one end of a pipe, and the other
+// end.
+func sniffPipe() (*Conduit, net.Conn) {
+	c1, c2 := net.Pipe()
+
+	return &Conduit{State: Passive, Link: c1}, c2
+}
+
+func TestSnifferFuncImplementsSniffer(t *testing.T) {
+	assert.Implements(t, (*Sniffer)(nil), SnifferFunc(nil))
+}
+
+func TestSnifferFuncSniff(t *testing.T) {
+	var actual []byte
+	obj := SnifferFunc(func(data []byte) bool {
+		actual = data
+		return true
+	})
+
+	result := obj.Sniff([]byte("data"))
+
+	assert.True(t, result)
+	assert.Equal(t, []byte("data"), actual)
+}
+
+func TestHTTPSniffer(t *testing.T) {
+	for data, expected := range map[string]bool{
+		"GET /healthz HTTP/1.1\r\n": true,
+		"HEAD / HTTP/1.0\r\n":       true,
+		"OPTIONS * HTTP/1.1\r\n":    true,
+		"PRI * HTTP/2.0\r\n\r\n":    true,
+		"GET":                       false,
+		"\x16\x03\x01\x02\x00":      false,
+		"\x00\x01\x02\x03":          false,
+		"":                          false,
+	} {
+		assert.Equal(t, expected, HTTPSniffer.Sniff([]byte(data)), "%q", data)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	fallback := HandlerFunc(func(ctx context.Context, c *Conduit) {})
+
+	result := Sniff(fallback)
+
+	assert.NotNil(t, result.Fallback)
+	assert.Nil(t, result.Sniffer)
+}
+
+func TestSniffOptionImplementsListenerOption(t *testing.T) {
+	assert.Implements(t, (*ListenerOption)(nil), &SniffOption{})
+}
+
+func TestSniffOptionListenApply(t *testing.T) {
+	lc := &net.ListenConfig{}
+
+	(&SniffOption{}).ListenApply(lc)
+
+	assert.Equal(t, &net.ListenConfig{}, lc)
+}
+
+func TestSniffListenBase(t *testing.T) {
+	l := &sniffTestListener{}
+	opt := Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {}))
+
+	result := sniffListen(l, []ListenerOption{KeepAlive(0), opt})
+
+	require.IsType(t, &sniffListener{}, result)
+	assert.Same(t, l, result.(*sniffListener).Listener)
+	assert.Same(t, opt, result.(*sniffListener).opt)
+}
+
+func TestSniffListenNoOption(t *testing.T) {
+	l := &sniffTestListener{}
+
+	result := sniffListen(l, []ListenerOption{KeepAlive(0)})
+
+	assert.Same(t, l, result)
+}
+
+func TestSniffListenNoFallback(t *testing.T) {
+	l := &sniffTestListener{}
+
+	result := sniffListen(l, []ListenerOption{&SniffOption{}})
+
+	assert.Same(t, l, result)
+}
+
+func TestSniffListenerAcceptHumboldt(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit, 1)}
+	c, peer := sniffPipe()
+	defer peer.Close()
+	l.conduits <- c
+	obj := sniffListen(l, []ListenerOption{Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {
+		t.Error("unexpected fallback")
+	}))})
+	go peer.Write([]byte("\x00\x01humboldt hello")) //nolint:errcheck
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c, result)
+	buf := make([]byte, 16)
+	_, err = io.ReadFull(result.Link, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x00\x01humboldt hello"), buf)
+}
+
+func TestSniffListenerAcceptFallback(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit, 2)}
+	c1, peer1 := sniffPipe()
+	defer peer1.Close()
+	c2, peer2 := sniffPipe()
+	defer peer2.Close()
+	l.conduits <- c1
+	l.conduits <- c2
+	handled := make(chan []byte, 1)
+	obj := sniffListen(l, []ListenerOption{Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {
+		buf := make([]byte, 4)
+		io.ReadFull(c.Link, buf) //nolint:errcheck
+		handled <- buf
+	}))})
+	go peer1.Write([]byte("GET /healthz HTTP/1.1\r\n")) //nolint:errcheck
+	go peer2.Write([]byte("humboldt traffic"))          //nolint:errcheck
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c2, result)
+	assert.Equal(t, []byte("GET "), <-handled)
+}
+
+func TestSniffListenerAcceptClosed(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit, 2)}
+	c1, peer1 := sniffPipe()
+	c2, peer2 := sniffPipe()
+	defer peer2.Close()
+	l.conduits <- c1
+	l.conduits <- c2
+	obj := sniffListen(l, []ListenerOption{Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {
+		t.Error("unexpected fallback")
+	}))})
+	peer1.Close()
+	go peer2.Write([]byte("humboldt traffic")) //nolint:errcheck
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c2, result)
+}
+
+func TestSniffListenerAcceptTimeout(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit, 1)}
+	c, peer := sniffPipe()
+	defer peer.Close()
+	l.conduits <- c
+	opt := Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {
+		t.Error("unexpected fallback")
+	}))
+	opt.Timeout = 10 * time.Millisecond
+	obj := sniffListen(l, []ListenerOption{opt})
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c, result)
+}
+
+func TestSniffListenerAcceptPartial(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit, 2)}
+	c1, peer1 := sniffPipe()
+	defer peer1.Close()
+	c2, peer2 := sniffPipe()
+	defer peer2.Close()
+	l.conduits <- c1
+	l.conduits <- c2
+	handled := make(chan struct{})
+	opt := &SniffOption{
+		Fallback: HandlerFunc(func(ctx context.Context, c *Conduit) {
+			close(handled)
+		}),
+		Size:    64,
+		Timeout: 50 * time.Millisecond,
+	}
+	obj := sniffListen(l, []ListenerOption{opt})
+	go peer1.Write([]byte("HEAD / HTTP/1.0\r\n\r\n")) //nolint:errcheck
+	go peer2.Write([]byte("humboldt traffic"))        //nolint:errcheck
+
+	result, err := obj.Accept()
+
+	require.NoError(t, err)
+	assert.Same(t, c2, result)
+	<-handled
+}
+
+func TestSniffListenerAcceptBoundaries(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit, 1)}
+	c := &Conduit{State: Passive, Boundaries: true, Link: &mockConn{}}
+	l.conduits <- c
+	obj := sniffListen(l, []ListenerOption{Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {}))})
+
+	result, err := obj.Accept()
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	assert.Same(t, c.Link, result.Link)
+}
+
+func TestSniffListenerAcceptError(t *testing.T) {
+	l := &sniffTestListener{conduits: make(chan *Conduit)}
+	close(l.conduits)
+	obj := sniffListen(l, []ListenerOption{Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {}))})
+
+	result, err := obj.Accept()
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, result)
+}
+
+func TestSniffListenerClose(t *testing.T) {
+	l := &sniffTestListener{}
+	obj := sniffListen(l, []ListenerOption{Sniff(HandlerFunc(func(ctx context.Context, c *Conduit) {}))}).(*sniffListener)
+
+	err := obj.Close()
+
+	assert.NoError(t, err)
+	assert.True(t, l.closed)
+	assert.Error(t, obj.ctx.Err())
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// SockOpt identifies a socket option.  The socket options are mapped
+// onto the raw options of each platform; options a platform does not
+// support report ErrUnsupportedOption.
+type SockOpt int
+
+// Socket options.
+const (
+	SockOptReuseAddr       SockOpt = iota // Allow reuse of local addresses
+	SockOptReusePort                      // Allow several sockets to bind the same port
+	SockOptBindToDevice                   // Bind the socket to a network interface
+	SockOptTOS                            // IPv4 type of service
+	SockOptTrafficClass                   // IPv6 traffic class
+	SockOptFastOpen                       // TCP fast open; the value is the queue length for listeners
+	SockOptMaxSeg                         // TCP maximum segment size
+	SockOptReadBuffer                     // Size of the socket receive buffer
+	SockOptWriteBuffer                    // Size of the socket send buffer
+	SockOptFastOpenConnect                // TCP fast open for dialers; connects on the first write
+)
+
+// sockOptNames maps socket options to their names.
+var sockOptNames = map[SockOpt]string{
+	SockOptReuseAddr:       "reuseaddr",
+	SockOptReusePort:       "reuseport",
+	SockOptBindToDevice:    "bindtodevice",
+	SockOptTOS:             "tos",
+	SockOptTrafficClass:    "tclass",
+	SockOptFastOpen:        "fastopen",
+	SockOptMaxSeg:          "maxseg",
+	SockOptReadBuffer:      "rcvbuf",
+	SockOptWriteBuffer:     "sndbuf",
+	SockOptFastOpenConnect: "fastopenconnect",
+}
+
+// String returns the name of the socket option.
+func (o SockOpt) String() string {
+	if name, ok := sockOptNames[o]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SockOpt(%d)", int(o))
+}
+
+// sockOptDesc describes the raw socket option implementing a SockOpt
+// on the current platform.
+type sockOptDesc struct {
+	level int // The option level
+	name  int // The option name
+}
+
+// Supported tests if the socket option is supported on the current
+// platform.
+func (o SockOpt) Supported() bool {
+	_, ok := sockOpts[o]
+
+	return ok
+}
+
+// lookupSockOpt looks up the raw socket option implementing a
+// SockOpt.
+func lookupSockOpt(opt SockOpt) (sockOptDesc, error) {
+	desc, ok := sockOpts[opt]
+	if !ok {
+		return sockOptDesc{}, fmt.Errorf("%s: %w", opt, ErrUnsupportedOption)
+	}
+
+	return desc, nil
+}
+
+// SetSockOpt sets an integer socket option on a raw connection.
+func SetSockOpt(c syscall.RawConn, opt SockOpt, value int) error {
+	desc, err := lookupSockOpt(opt)
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = setsockoptInt(int(fd), desc.level, desc.name, value)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("%s: %w", opt, sockErr)
+	}
+
+	return nil
+}
+
+// SetSockOptString sets a string socket option, such as
+// SockOptBindToDevice, on a raw connection.
+func SetSockOptString(c syscall.RawConn, opt SockOpt, value string) error {
+	desc, err := lookupSockOpt(opt)
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = setsockoptString(int(fd), desc.level, desc.name, value)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("%s: %w", opt, sockErr)
+	}
+
+	return nil
+}
+
+// GetSockOpt retrieves an integer socket option from a raw
+// connection.
+func GetSockOpt(c syscall.RawConn, opt SockOpt) (int, error) {
+	desc, err := lookupSockOpt(opt)
+	if err != nil {
+		return 0, err
+	}
+
+	value := 0
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		value, sockErr = getsockoptInt(int(fd), desc.level, desc.name)
+	}); err != nil {
+		return 0, err
+	}
+	if sockErr != nil {
+		return 0, fmt.Errorf("%s: %w", opt, sockErr)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build linux
+
+package conduit
+
+import "golang.org/x/sys/unix"
+
+// sockOpts maps socket options to the raw socket options of the
+// platform.
+var sockOpts = map[SockOpt]sockOptDesc{
+	SockOptReuseAddr:       {unix.SOL_SOCKET, unix.SO_REUSEADDR},
+	SockOptReusePort:       {unix.SOL_SOCKET, unix.SO_REUSEPORT},
+	SockOptBindToDevice:    {unix.SOL_SOCKET, unix.SO_BINDTODEVICE},
+	SockOptTOS:             {unix.IPPROTO_IP, unix.IP_TOS},
+	SockOptTrafficClass:    {unix.IPPROTO_IPV6, unix.IPV6_TCLASS},
+	SockOptFastOpen:        {unix.IPPROTO_TCP, unix.TCP_FASTOPEN},
+	SockOptMaxSeg:          {unix.IPPROTO_TCP, unix.TCP_MAXSEG},
+	SockOptReadBuffer:      {unix.SOL_SOCKET, unix.SO_RCVBUF},
+	SockOptWriteBuffer:     {unix.SOL_SOCKET, unix.SO_SNDBUF},
+	SockOptFastOpenConnect: {unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT},
+}
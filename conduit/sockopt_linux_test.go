@@ -0,0 +1,58 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build linux
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSockOptsLinux(t *testing.T) {
+	l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer l.Close()
+	raw, err := l.SyscallConn()
+	require.NoError(t, err)
+
+	for _, opt := range []SockOpt{SockOptReuseAddr, SockOptReusePort} {
+		assert.NoError(t, SetSockOpt(raw, opt, 1), opt.String())
+		value, err := GetSockOpt(raw, opt)
+		assert.NoError(t, err, opt.String())
+		assert.Equal(t, 1, value, opt.String())
+	}
+}
+
+func TestSocketOptionsLinux(t *testing.T) {
+	lc, err := mkListenConfig([]ListenerOption{ReusePort(), ReadBuffer(65536)}, nil)
+	require.NoError(t, err)
+	l1, err := lc.Listen(context.Background(), "tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l1.Close()
+
+	l2, err := lc.Listen(context.Background(), "tcp4", l1.Addr().String())
+	require.NoError(t, err)
+	defer l2.Close()
+	raw, err := l2.(*net.TCPListener).SyscallConn()
+	require.NoError(t, err)
+	value, err := GetSockOpt(raw, SockOptReadBuffer)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, value, 65536)
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testSockOpts is a socket option table for tests.
+var testSockOpts = map[SockOpt]sockOptDesc{
+	SockOptReuseAddr:    {1, 2},
+	SockOptBindToDevice: {1, 25},
+}
+
+// controlConn returns a mock raw connection that calls the control
+// function with the specified file descriptor.
+func controlConn(fd uintptr) *mockRawConn {
+	c := &mockRawConn{}
+	c.On("Control", mock.Anything).Run(func(args mock.Arguments) {
+		args[0].(func(fd uintptr))(fd)
+	}).Return(nil)
+
+	return c
+}
+
+func TestSockOptString(t *testing.T) {
+	assert.Equal(t, "reuseaddr", SockOptReuseAddr.String())
+	assert.Equal(t, "maxseg", SockOptMaxSeg.String())
+	assert.Equal(t, "SockOpt(99)", SockOpt(99).String())
+}
+
+func TestSockOptSupported(t *testing.T) {
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+
+	assert.True(t, SockOptReuseAddr.Supported())
+	assert.False(t, SockOptFastOpen.Supported())
+}
+
+func TestSockOptsNames(t *testing.T) {
+	for opt := range sockOpts {
+		assert.Contains(t, sockOptNames, opt)
+	}
+}
+
+func TestSetSockOptBase(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		assert.Equal(t, 5, fd)
+		assert.Equal(t, 1, level)
+		assert.Equal(t, 2, opt)
+		assert.Equal(t, 1, value)
+		return nil
+	}).Install().Restore()
+
+	err := SetSockOpt(c, SockOptReuseAddr, 1)
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
+func TestSetSockOptUnsupported(t *testing.T) {
+	c := &mockRawConn{}
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+
+	err := SetSockOpt(c, SockOptFastOpen, 1)
+
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+	c.AssertExpectations(t)
+}
+
+func TestSetSockOptControlError(t *testing.T) {
+	c := &mockRawConn{}
+	c.On("Control", mock.Anything).Return(assert.AnError)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+
+	err := SetSockOpt(c, SockOptReuseAddr, 1)
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestSetSockOptError(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
+		return assert.AnError
+	}).Install().Restore()
+
+	err := SetSockOpt(c, SockOptReuseAddr, 1)
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "reuseaddr")
+}
+
+func TestSetSockOptStringBase(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&setsockoptString, func(fd, level, opt int, value string) error {
+		assert.Equal(t, 5, fd)
+		assert.Equal(t, 1, level)
+		assert.Equal(t, 25, opt)
+		assert.Equal(t, "eth0", value)
+		return nil
+	}).Install().Restore()
+
+	err := SetSockOptString(c, SockOptBindToDevice, "eth0")
+
+	assert.NoError(t, err)
+}
+
+func TestSetSockOptStringUnsupported(t *testing.T) {
+	c := &mockRawConn{}
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{}).Install().Restore()
+
+	err := SetSockOptString(c, SockOptBindToDevice, "eth0")
+
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+}
+
+func TestSetSockOptStringError(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&setsockoptString, func(fd, level, opt int, value string) error {
+		return assert.AnError
+	}).Install().Restore()
+
+	err := SetSockOptString(c, SockOptBindToDevice, "eth0")
+
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestGetSockOptBase(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&getsockoptInt, func(fd, level, opt int) (int, error) {
+		assert.Equal(t, 5, fd)
+		assert.Equal(t, 1, level)
+		assert.Equal(t, 2, opt)
+		return 1, nil
+	}).Install().Restore()
+
+	result, err := GetSockOpt(c, SockOptReuseAddr)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+}
+
+func TestGetSockOptUnsupported(t *testing.T) {
+	c := &mockRawConn{}
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+
+	result, err := GetSockOpt(c, SockOptMaxSeg)
+
+	assert.ErrorIs(t, err, ErrUnsupportedOption)
+	assert.Equal(t, 0, result)
+}
+
+func TestGetSockOptControlError(t *testing.T) {
+	c := &mockRawConn{}
+	c.On("Control", mock.Anything).Return(assert.AnError)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+
+	result, err := GetSockOpt(c, SockOptReuseAddr)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, 0, result)
+}
+
+func TestGetSockOptError(t *testing.T) {
+	c := controlConn(5)
+	defer patcher.SetVar(&sockOpts, testSockOpts).Install().Restore()
+	defer patcher.SetVar(&getsockoptInt, func(fd, level, opt int) (int, error) {
+		return 0, assert.AnError
+	}).Install().Restore()
+
+	result, err := GetSockOpt(c, SockOptReuseAddr)
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 0, result)
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build unix
+
+package conduit
+
+import "golang.org/x/sys/unix"
+
+// sysSetsockoptInt sets an integer socket option.
+func sysSetsockoptInt(fd, level, opt, value int) error {
+	return unix.SetsockoptInt(fd, level, opt, value)
+}
+
+// sysSetsockoptString sets a string socket option.
+func sysSetsockoptString(fd, level, opt int, value string) error {
+	return unix.SetsockoptString(fd, level, opt, value)
+}
+
+// sysGetsockoptInt retrieves an integer socket option.
+func sysGetsockoptInt(fd, level, opt int) (int, error) {
+	return unix.GetsockoptInt(fd, level, opt)
+}
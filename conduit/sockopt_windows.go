@@ -0,0 +1,51 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build windows
+
+package conduit
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// sockOpts maps socket options to the raw socket options of the
+// platform.  SO_REUSEADDR is omitted, since on Windows it permits
+// other sockets to steal the address rather than allowing rebinding
+// of addresses in TIME_WAIT.
+var sockOpts = map[SockOpt]sockOptDesc{
+	SockOptTOS:         {windows.IPPROTO_IP, windows.IP_TOS},
+	SockOptFastOpen:    {windows.IPPROTO_TCP, windows.TCP_FASTOPEN},
+	SockOptMaxSeg:      {windows.IPPROTO_TCP, windows.TCP_MAXSEG},
+	SockOptReadBuffer:  {windows.SOL_SOCKET, windows.SO_RCVBUF},
+	SockOptWriteBuffer: {windows.SOL_SOCKET, windows.SO_SNDBUF},
+}
+
+// sysSetsockoptInt sets an integer socket option.
+func sysSetsockoptInt(fd, level, opt, value int) error {
+	return windows.SetsockoptInt(windows.Handle(fd), level, opt, value)
+}
+
+// sysSetsockoptString sets a string socket option.  No string
+// options are supported on Windows.
+func sysSetsockoptString(fd, level, opt int, value string) error {
+	return fmt.Errorf("string option %d: %w", opt, ErrUnsupportedOption)
+}
+
+// sysGetsockoptInt retrieves an integer socket option.
+func sysGetsockoptInt(fd, level, opt int) (int, error) {
+	return windows.GetsockoptInt(windows.Handle(fd), level, opt)
+}
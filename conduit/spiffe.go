@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SPIFFEScheme is the URI scheme of SPIFFE IDs.
+const SPIFFEScheme = "spiffe"
+
+// spiffeValid reports whether a string contains only the characters
+// permitted in a trust domain name or, if upper is true, a path
+// segment of a SPIFFE ID.
+func spiffeValid(s string, upper bool) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		case upper && c >= 'A' && c <= 'Z':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseSPIFFEID parses a SPIFFE ID, such as
+// "spiffe://example.org/ns/prod/sa/web", returning its trust domain
+// and path.  An error wrapping ErrBadSPIFFEID is returned if the ID
+// does not conform to the SPIFFE specification.
+func ParseSPIFFEID(id string) (string, string, error) {
+	u, err := url.Parse(id)
+	switch {
+	case err != nil:
+		return "", "", fmt.Errorf("%q: %w: %w", id, ErrBadSPIFFEID, err)
+	case u.Scheme != SPIFFEScheme || u.Opaque != "" || u.User != nil || u.Port() != "":
+		return "", "", fmt.Errorf("%q: %w", id, ErrBadSPIFFEID)
+	case u.RawQuery != "" || u.Fragment != "" || strings.ContainsAny(id, "?#"):
+		return "", "", fmt.Errorf("%q: %w", id, ErrBadSPIFFEID)
+	case u.Host == "" || !spiffeValid(u.Host, false):
+		return "", "", fmt.Errorf("%q: trust domain: %w", id, ErrBadSPIFFEID)
+	}
+
+	// Check the path segments
+	if u.Path != "" {
+		for _, seg := range strings.Split(u.Path[1:], "/") {
+			if seg == "" || seg == "." || seg == ".." || !spiffeValid(seg, true) {
+				return "", "", fmt.Errorf("%q: path: %w", id, ErrBadSPIFFEID)
+			}
+		}
+	}
+
+	return u.Host, u.Path, nil
+}
+
+// SPIFFEID returns the SPIFFE ID of an X.509 SVID, which is its only
+// URI subject alternative name.
+func SPIFFEID(cert *x509.Certificate) (string, error) {
+	if len(cert.URIs) != 1 {
+		return "", fmt.Errorf("%d URI names: %w", len(cert.URIs), ErrBadSPIFFEID)
+	}
+	id := cert.URIs[0].String()
+	if _, _, err := ParseSPIFFEID(id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// SPIFFEMatch reports whether a SPIFFE ID matches a pattern.  A
+// pattern is a SPIFFE ID whose trust domain and path segments are
+// matched as by path.Match, so "*" matches any single segment; a
+// final segment of "**" matches any number of remaining segments,
+// including none.  For example, "spiffe://example.org/ns/*/sa/web"
+// matches the web service account in any namespace, and
+// "spiffe://example.org/**" matches every ID in the trust domain.
+func SPIFFEMatch(pattern, id string) bool {
+	pat, ok := strings.CutPrefix(pattern, SPIFFEScheme+"://")
+	if !ok {
+		return false
+	}
+	rest, ok := strings.CutPrefix(id, SPIFFEScheme+"://")
+	if !ok {
+		return false
+	}
+
+	pats := strings.Split(pat, "/")
+	segs := strings.Split(rest, "/")
+	for i, p := range pats {
+		if p == "**" && i == len(pats)-1 {
+			return true
+		} else if i >= len(segs) {
+			return false
+		} else if ok, err := path.Match(p, segs[i]); err != nil || !ok {
+			return false
+		}
+	}
+
+	return len(pats) == len(segs)
+}
+
+// SPIFFEAuthorizer is an Authorizer identifying the peer by the
+// SPIFFE ID of its X.509 SVID.  If Allow is not empty, only IDs
+// matching one of the listed patterns, as described for SPIFFEMatch,
+// are authorized.
+type SPIFFEAuthorizer struct {
+	Allow []string // Patterns of authorized IDs; if empty, all are authorized
+}
+
+// Authorize authorizes the peer, returning the name of the principal.
+func (a *SPIFFEAuthorizer) Authorize(chain []*x509.Certificate) (string, error) {
+	cert, err := leaf(chain)
+	if err != nil {
+		return "", err
+	}
+	id, err := SPIFFEID(cert)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	if len(a.Allow) == 0 {
+		return id, nil
+	}
+
+	for _, pattern := range a.Allow {
+		if SPIFFEMatch(pattern, id) {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("principal %q: %w", id, ErrUnauthorized)
+}
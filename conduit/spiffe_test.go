@@ -0,0 +1,172 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spiffeCert returns a certificate with the specified URI names.
+func spiffeCert(uris ...string) *x509.Certificate {
+	result := &x509.Certificate{}
+	for _, uri := range uris {
+		u, _ := url.Parse(uri)
+		result.URIs = append(result.URIs, u)
+	}
+
+	return result
+}
+
+func TestParseSPIFFEIDValid(t *testing.T) {
+	for id, expected := range map[string][2]string{
+		"spiffe://example.org":                    {"example.org", ""},
+		"spiffe://example.org/ns/prod/sa/web":     {"example.org", "/ns/prod/sa/web"},
+		"spiffe://trust-domain_1.example/Svc.A-b": {"trust-domain_1.example", "/Svc.A-b"},
+	} {
+		td, path, err := ParseSPIFFEID(id)
+
+		assert.NoError(t, err, id)
+		assert.Equal(t, expected[0], td, id)
+		assert.Equal(t, expected[1], path, id)
+	}
+}
+
+func TestParseSPIFFEIDInvalid(t *testing.T) {
+	for _, id := range []string{
+		"https://example.org/web",
+		"spiffe:example.org",
+		"spiffe://",
+		"spiffe://Example.org/web",
+		"spiffe://example.org:8443/web",
+		"spiffe://user@example.org/web",
+		"spiffe://example.org/web?x=1",
+		"spiffe://example.org/web#frag",
+		"spiffe://example.org/",
+		"spiffe://example.org/a//b",
+		"spiffe://example.org/a/../b",
+		"spiffe://example.org/a%20b",
+		"%zz",
+	} {
+		td, path, err := ParseSPIFFEID(id)
+
+		assert.ErrorIs(t, err, ErrBadSPIFFEID, id)
+		assert.Equal(t, "", td, id)
+		assert.Equal(t, "", path, id)
+	}
+}
+
+func TestSPIFFEIDBase(t *testing.T) {
+	result, err := SPIFFEID(spiffeCert("spiffe://example.org/web"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/web", result)
+}
+
+func TestSPIFFEIDCount(t *testing.T) {
+	result, err := SPIFFEID(spiffeCert("spiffe://example.org/web", "spiffe://example.org/db"))
+
+	assert.ErrorIs(t, err, ErrBadSPIFFEID)
+	assert.Equal(t, "", result)
+}
+
+func TestSPIFFEIDInvalid(t *testing.T) {
+	result, err := SPIFFEID(spiffeCert("https://example.org/web"))
+
+	assert.ErrorIs(t, err, ErrBadSPIFFEID)
+	assert.Equal(t, "", result)
+}
+
+func TestSPIFFEMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern  string
+		id       string
+		expected bool
+	}{
+		{"spiffe://example.org/web", "spiffe://example.org/web", true},
+		{"spiffe://example.org/web", "spiffe://example.org/db", false},
+		{"spiffe://example.org/web", "spiffe://other.org/web", false},
+		{"spiffe://example.org/ns/*/sa/web", "spiffe://example.org/ns/prod/sa/web", true},
+		{"spiffe://example.org/ns/*/sa/web", "spiffe://example.org/ns/prod/sa/db", false},
+		{"spiffe://example.org/ns/*", "spiffe://example.org/ns/prod/sa/web", false},
+		{"spiffe://example.org/**", "spiffe://example.org/ns/prod/sa/web", true},
+		{"spiffe://example.org/**", "spiffe://example.org", true},
+		{"spiffe://example.org/**", "spiffe://other.org/web", false},
+		{"spiffe://*.example.org/web", "spiffe://east.example.org/web", true},
+		{"spiffe://example.org/web-*", "spiffe://example.org/web-1", true},
+		{"spiffe://example.org", "spiffe://example.org/web", false},
+		{"spiffe://example.org/web/extra", "spiffe://example.org/web", false},
+		{"spiffe://example.org/[", "spiffe://example.org/[", false},
+		{"example.org/web", "spiffe://example.org/web", false},
+		{"spiffe://example.org/web", "example.org/web", false},
+	} {
+		result := SPIFFEMatch(c.pattern, c.id)
+
+		assert.Equal(t, c.expected, result, "%s ~ %s", c.pattern, c.id)
+	}
+}
+
+func TestSPIFFEAuthorizerImplementsAuthorizer(t *testing.T) {
+	assert.Implements(t, (*Authorizer)(nil), &SPIFFEAuthorizer{})
+}
+
+func TestSPIFFEAuthorizerAuthorizeBase(t *testing.T) {
+	obj := &SPIFFEAuthorizer{}
+
+	result, err := obj.Authorize([]*x509.Certificate{spiffeCert("spiffe://example.org/web")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/web", result)
+}
+
+func TestSPIFFEAuthorizerAuthorizeAllowed(t *testing.T) {
+	obj := &SPIFFEAuthorizer{Allow: []string{"spiffe://other.org/**", "spiffe://example.org/ns/*/web"}}
+
+	result, err := obj.Authorize([]*x509.Certificate{spiffeCert("spiffe://example.org/ns/prod/web")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/ns/prod/web", result)
+}
+
+func TestSPIFFEAuthorizerAuthorizeRejected(t *testing.T) {
+	obj := &SPIFFEAuthorizer{Allow: []string{"spiffe://example.org/db"}}
+
+	result, err := obj.Authorize([]*x509.Certificate{spiffeCert("spiffe://example.org/web")})
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
+
+func TestSPIFFEAuthorizerAuthorizeNoID(t *testing.T) {
+	obj := &SPIFFEAuthorizer{}
+
+	result, err := obj.Authorize([]*x509.Certificate{spiffeCert()})
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.ErrorIs(t, err, ErrBadSPIFFEID)
+	assert.Equal(t, "", result)
+}
+
+func TestSPIFFEAuthorizerAuthorizeNoChain(t *testing.T) {
+	obj := &SPIFFEAuthorizer{}
+
+	result, err := obj.Authorize(nil)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "", result)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortSRV sorts SRV records by priority, and by decreasing weight
+// within each priority.
+func sortSRV(srvs []*net.SRV) {
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+
+		return srvs[i].Weight > srvs[j].Weight
+	})
+}
+
+// SRVDiscovery is a discovery mechanism using DNS SRV records.  The
+// host portion of the URI gives the name of the SRV records; for
+// instance, "tcp.srv://_humboldt._tcp.example.com" canonicalizes to
+// the addresses of the targets of the SRV records for
+// "_humboldt._tcp.example.com", in the order of the records.  The
+// lookups are made using the HostResolver carried by the context, as
+// set by WithResolver, so a SecureResolver may be used to avoid
+// disclosing the names to the network.
+type SRVDiscovery struct{}
+
+// Discover is passed a URI and returns a list of canonical URIs
+// retrieved from the discovery mechanism.  The list may be in a
+// priority order, or may be in an arbitrary randomized order,
+// depending on the mechanism.
+func (d *SRVDiscovery) Discover(ctx context.Context, u *URI) ([]*URI, error) {
+	res, err := d.Resolve(ctx, u)
+
+	return resolutionURIs(res), err
+}
+
+// Resolve is passed a URI and returns a list of resolutions
+// describing the canonical URIs retrieved from the discovery
+// mechanism.  Each is attributed to the SRV target it was derived
+// from.  Targets whose addresses cannot be looked up are skipped.
+func (d *SRVDiscovery) Resolve(ctx context.Context, u *URI) ([]*Resolution, error) {
+	r := ContextResolver(ctx)
+	_, srvs, err := r.LookupSRV(ctx, "", "", u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := u.Transport
+	if u.Security != "" {
+		scheme += "+" + u.Security
+	}
+	result := []*Resolution{}
+	seen := map[string]bool{}
+	for _, srv := range srvs {
+		// A target of "." means the service is not available
+		target := strings.ToLower(srv.Target)
+		if target == "." || target == "" {
+			continue
+		}
+
+		ips, err := r.LookupIP(ctx, "ip", target)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, ip := range ips {
+			host := net.JoinHostPort(ip.String(), port)
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+
+			result = append(result, &Resolution{
+				URI: &URI{
+					URL: url.URL{
+						Scheme:   scheme,
+						Host:     host,
+						Path:     u.Path,
+						RawQuery: u.RawQuery,
+					},
+					Transport: u.Transport,
+					Security:  u.Security,
+				},
+				Source:    SourceSRV,
+				Name:      target,
+				Discovery: u.Discovery,
+				Priority:  srv.Priority,
+				Weight:    srv.Weight,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func init() {
+	RegisterDiscovery("srv", &SRVDiscovery{})
+}
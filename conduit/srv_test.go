@@ -0,0 +1,128 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSortSRV(t *testing.T) {
+	srvs := []*net.SRV{
+		{Target: "a.", Priority: 20, Weight: 5},
+		{Target: "b.", Priority: 10, Weight: 1},
+		{Target: "c.", Priority: 10, Weight: 9},
+	}
+
+	sortSRV(srvs)
+
+	assert.Equal(t, []string{"c.", "b.", "a."}, []string{srvs[0].Target, srvs[1].Target, srvs[2].Target})
+}
+
+func TestSRVDiscoveryDiscover(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupSRV", mock.Anything, "", "", "_humboldt._tcp.example.com").Return("_humboldt._tcp.example.com.", []*net.SRV{
+		{Target: "node1.example.com.", Port: 1234},
+	}, nil)
+	r.On("LookupIP", mock.Anything, "ip", "node1.example.com.").Return([]net.IP{net.ParseIP("10.0.0.1")}, nil)
+	obj := &SRVDiscovery{}
+
+	result, err := obj.Discover(WithResolver(context.Background(), r), mustParse("tcp+tls.srv://_humboldt._tcp.example.com"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{
+		{
+			URL:       mustParse("tcp+tls://10.0.0.1:1234").URL,
+			Transport: "tcp",
+			Security:  "tls",
+		},
+	}, result)
+	r.AssertExpectations(t)
+}
+
+func TestSRVDiscoveryResolve(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupSRV", mock.Anything, "", "", "_humboldt._tcp.example.com").Return("_humboldt._tcp.example.com.", []*net.SRV{
+		{Target: "Node1.example.com.", Port: 1234, Priority: 10, Weight: 5},
+		{Target: "node2.example.com.", Port: 1234},
+		{Target: "node3.example.com.", Port: 1234},
+		{Target: ".", Port: 1234},
+	}, nil)
+	r.On("LookupIP", mock.Anything, "ip", "node1.example.com.").Return([]net.IP{net.ParseIP("10.0.0.1")}, nil)
+	r.On("LookupIP", mock.Anything, "ip", "node2.example.com.").Return(nil, assert.AnError)
+	r.On("LookupIP", mock.Anything, "ip", "node3.example.com.").Return([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("::1")}, nil)
+	obj := &SRVDiscovery{}
+
+	result, err := obj.Resolve(WithResolver(context.Background(), r), mustParse("tcp.srv://_humboldt._tcp.example.com"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{
+		{
+			URI: &URI{
+				URL:       mustParse("tcp://10.0.0.1:1234").URL,
+				Transport: "tcp",
+			},
+			Source:    SourceSRV,
+			Name:      "node1.example.com.",
+			Discovery: "srv",
+			Priority:  10,
+			Weight:    5,
+		},
+		{
+			URI: &URI{
+				URL:       mustParse("tcp://[::1]:1234").URL,
+				Transport: "tcp",
+			},
+			Source:    SourceSRV,
+			Name:      "node3.example.com.",
+			Discovery: "srv",
+		},
+	}, result)
+	r.AssertExpectations(t)
+}
+
+func TestSRVDiscoveryResolveLookupFails(t *testing.T) {
+	r := &mockHostResolver{}
+	r.On("LookupSRV", mock.Anything, "", "", "_humboldt._tcp.example.com").Return("", nil, assert.AnError)
+	obj := &SRVDiscovery{}
+
+	result, err := obj.Resolve(WithResolver(context.Background(), r), mustParse("tcp.srv://_humboldt._tcp.example.com"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	r.AssertExpectations(t)
+}
+
+func TestSRVDiscoveryResolveCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &mockHostResolver{}
+	r.On("LookupSRV", mock.Anything, "", "", "_humboldt._tcp.example.com").Return("_humboldt._tcp.example.com.", []*net.SRV{
+		{Target: "node1.example.com.", Port: 1234},
+	}, nil)
+	r.On("LookupIP", mock.Anything, "ip", "node1.example.com.").Run(func(mock.Arguments) {
+		cancel()
+	}).Return(nil, context.Canceled)
+	obj := &SRVDiscovery{}
+
+	result, err := obj.Resolve(WithResolver(ctx, r), mustParse("tcp.srv://_humboldt._tcp.example.com"))
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+	r.AssertExpectations(t)
+}
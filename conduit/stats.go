@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the counters of a conduit, as returned by
+// Conduit.Stats.  PDUs are counted by Send, Recv, and PDUs; data
+// exchanged directly over the link is not counted.
+type Stats struct {
+	BytesIn      uint64    `json:"bytes_in"`      // Bytes of PDUs received
+	BytesOut     uint64    `json:"bytes_out"`     // Bytes of PDUs sent
+	PDUsIn       uint64    `json:"pdus_in"`       // PDUs received, including those discarded
+	PDUsOut      uint64    `json:"pdus_out"`      // PDUs sent
+	LastActivity time.Time `json:"last_activity"` // Time a PDU was last sent or received
+	Errors       uint64    `json:"errors"`        // Failed reads and writes, and received PDUs discarded
+	Reconnects   uint64    `json:"reconnects"`    // Conduits its Persistent established before it
+}
+
+// statsState holds the counters of a conduit.
+type statsState struct {
+	sync.Mutex
+
+	stats Stats // The counters
+}
+
+// Stats returns a snapshot of the counters of the conduit.  It may be
+// called concurrently with the other methods of the conduit.
+func (c *Conduit) Stats() Stats {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+
+	return c.stats.stats
+}
+
+// countIn counts a PDU received over the conduit.
+func (c *Conduit) countIn(size int) {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+
+	c.stats.stats.PDUsIn++
+	c.stats.stats.BytesIn += uint64(size)
+	c.stats.stats.LastActivity = timeNow()
+}
+
+// countOut counts a PDU sent over the conduit.
+func (c *Conduit) countOut(size int) {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+
+	c.stats.stats.PDUsOut++
+	c.stats.stats.BytesOut += uint64(size)
+	c.stats.stats.LastActivity = timeNow()
+}
+
+// countError counts an error exchanging PDUs over the conduit.  The
+// peer closing the conduit between PDUs is not an error.
+func (c *Conduit) countError(err error) {
+	if errors.Is(err, io.EOF) {
+		return
+	}
+
+	c.stats.Lock()
+	defer c.stats.Unlock()
+
+	c.stats.stats.Errors++
+}
+
+// setReconnects sets the number of times the conduit has been
+// replaced by its Persistent.
+func (c *Conduit) setReconnects(n uint64) {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+
+	c.stats.stats.Reconnects = n
+}
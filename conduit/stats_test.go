@@ -0,0 +1,119 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestConduitStatsZero(t *testing.T) {
+	obj := &Conduit{}
+
+	result := obj.Stats()
+
+	assert.Equal(t, Stats{}, result)
+}
+
+func TestConduitCountIn(t *testing.T) {
+	now := *rateClock(t)
+	obj := &Conduit{}
+
+	obj.countIn(10)
+	obj.countIn(5)
+
+	assert.Equal(t, Stats{PDUsIn: 2, BytesIn: 15, LastActivity: now}, obj.Stats())
+}
+
+func TestConduitCountOut(t *testing.T) {
+	now := *rateClock(t)
+	obj := &Conduit{}
+
+	obj.countOut(10)
+	obj.countOut(5)
+
+	assert.Equal(t, Stats{PDUsOut: 2, BytesOut: 15, LastActivity: now}, obj.Stats())
+}
+
+func TestConduitCountErrorBase(t *testing.T) {
+	obj := &Conduit{}
+
+	obj.countError(assert.AnError)
+
+	assert.Equal(t, Stats{Errors: 1}, obj.Stats())
+}
+
+func TestConduitCountErrorEOF(t *testing.T) {
+	obj := &Conduit{}
+
+	obj.countError(io.EOF)
+
+	assert.Equal(t, Stats{}, obj.Stats())
+}
+
+func TestConduitSetReconnects(t *testing.T) {
+	obj := &Conduit{}
+
+	obj.setReconnects(3)
+
+	assert.Equal(t, Stats{Reconnects: 3}, obj.Stats())
+}
+
+func TestConduitStatsExchange(t *testing.T) {
+	now := *rateClock(t)
+	local, remote := net.Pipe()
+	defer local.Close()
+	obj := &Conduit{Link: local, Integrity: true}
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(remote, buf)                                                //nolint:errcheck
+		remote.Write([]byte{0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c', 0x00, 0x18}) //nolint:errcheck
+		remote.Write([]byte{0x00, 0x01, 'd'})                                   //nolint:errcheck
+		remote.Close()
+	}()
+
+	err := obj.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("x")})
+	_, err1 := obj.Recv()
+	_, err2 := obj.Recv()
+	_, err3 := obj.Recv()
+
+	assert.NoError(t, err)
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Same(t, io.EOF, err3)
+	assert.Equal(t, Stats{
+		BytesIn:      12,
+		BytesOut:     5,
+		PDUsIn:       2,
+		PDUsOut:      1,
+		LastActivity: now,
+	}, obj.Stats())
+}
+
+func TestConduitStatsSendError(t *testing.T) {
+	local, remote := net.Pipe()
+	remote.Close()
+	obj := &Conduit{Link: local, Integrity: true}
+
+	err := obj.Send(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("x")})
+
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.Equal(t, Stats{Errors: 1}, obj.Stats())
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants used by the STUN client.
+const (
+	DefaultSTUNPort = 3478                   // Default port of STUN servers
+	STUNRequests    = 7                      // Requests sent before giving up
+	STUNInitialRTO  = 500 * time.Millisecond // Initial retransmission timeout
+)
+
+// STUN message constants, from RFC 5389.
+const (
+	stunHeaderLen      = 20         // Length of the message header
+	stunMagicCookie    = 0x2112a442 // Magic cookie of the header
+	stunBindingRequest = 0x0001     // Binding request message type
+	stunBindingInd     = 0x0011     // Binding indication message type
+	stunBindingSuccess = 0x0101     // Binding success response message type
+	stunBindingError   = 0x0111     // Binding error response message type
+	stunAttrMapped     = 0x0001     // MAPPED-ADDRESS attribute
+	stunAttrErrorCode  = 0x0009     // ERROR-CODE attribute
+	stunAttrXORMapped  = 0x0020     // XOR-MAPPED-ADDRESS attribute
+	stunFamilyIPv4     = 0x01       // IPv4 address family
+	stunFamilyIPv6     = 0x02       // IPv6 address family
+)
+
+// stunTxnID is the transaction ID of a STUN message.
+type stunTxnID [12]byte
+
+// stunRequest constructs a binding request with a random transaction
+// ID.
+func stunRequest() (stunTxnID, []byte, error) {
+	var txn stunTxnID
+	if _, err := io.ReadFull(randReader, txn[:]); err != nil {
+		return txn, nil, err
+	}
+
+	msg := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(msg, stunBindingRequest)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], txn[:])
+
+	return txn, msg, nil
+}
+
+// stunIndication constructs a binding indication with a random
+// transaction ID.  Indications elicit no response; they are sent as
+// hole punching probes, to open bindings in the network address
+// translators along the way.
+func stunIndication() ([]byte, error) {
+	_, msg, err := stunRequest()
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(msg, stunBindingInd)
+
+	return msg, nil
+}
+
+// isSTUNIndication tests whether a datagram is a binding indication.
+func isSTUNIndication(dgram []byte) bool {
+	if _, ok := stunTxn(dgram); !ok {
+		return false
+	}
+
+	return binary.BigEndian.Uint16(dgram) == stunBindingInd
+}
+
+// stunTxn returns the transaction ID of a STUN message, or false if
+// the datagram is not a STUN message.
+func stunTxn(dgram []byte) (stunTxnID, bool) {
+	var txn stunTxnID
+	if len(dgram) < stunHeaderLen || dgram[0]&0xc0 != 0 ||
+		binary.BigEndian.Uint32(dgram[4:]) != stunMagicCookie ||
+		int(binary.BigEndian.Uint16(dgram[2:]))+stunHeaderLen != len(dgram) {
+		return txn, false
+	}
+	copy(txn[:], dgram[8:])
+
+	return txn, true
+}
+
+// parseSTUNResponse parses a response to a binding request, returning
+// the mapped address it reports.  The XOR-MAPPED-ADDRESS attribute is
+// preferred to the MAPPED-ADDRESS attribute of older servers.  Error
+// responses are reported as errors wrapping ErrSTUNFailed.
+func parseSTUNResponse(msg []byte) (*net.UDPAddr, error) {
+	var mapped, xorMapped *net.UDPAddr
+	failure := ""
+	for attrs := msg[stunHeaderLen:]; len(attrs) > 0; {
+		if len(attrs) < 4 {
+			return nil, fmt.Errorf("%w: truncated attribute", ErrBadSTUN)
+		}
+		typ := binary.BigEndian.Uint16(attrs)
+		n := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+n {
+			return nil, fmt.Errorf("%w: truncated attribute", ErrBadSTUN)
+		}
+		val := attrs[4 : 4+n]
+		attrs = attrs[min(4+(n+3)&^3, len(attrs)):]
+
+		var err error
+		switch typ {
+		case stunAttrMapped:
+			mapped, err = stunAddr(val, nil)
+		case stunAttrXORMapped:
+			xorMapped, err = stunAddr(val, msg[4:stunHeaderLen])
+		case stunAttrErrorCode:
+			if len(val) < 4 {
+				return nil, fmt.Errorf("%w: truncated error code", ErrBadSTUN)
+			}
+			failure = fmt.Sprintf("%d %s", int(val[2]&0x07)*100+int(val[3]), val[4:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch binary.BigEndian.Uint16(msg) {
+	case stunBindingError:
+		return nil, fmt.Errorf("%w: %s", ErrSTUNFailed, failure)
+	case stunBindingSuccess:
+		if xorMapped != nil {
+			return xorMapped, nil
+		} else if mapped != nil {
+			return mapped, nil
+		}
+		return nil, fmt.Errorf("%w: no mapped address", ErrBadSTUN)
+	}
+
+	return nil, fmt.Errorf("%w: unexpected message type", ErrBadSTUN)
+}
+
+// stunAddr decodes the value of a MAPPED-ADDRESS or
+// XOR-MAPPED-ADDRESS attribute.  For the latter, key gives the magic
+// cookie and transaction ID with which the address is XORed.
+func stunAddr(val, key []byte) (*net.UDPAddr, error) {
+	if len(val) < 4 {
+		return nil, fmt.Errorf("%w: truncated address", ErrBadSTUN)
+	}
+	n := 0
+	switch val[1] {
+	case stunFamilyIPv4:
+		n = net.IPv4len
+	case stunFamilyIPv6:
+		n = net.IPv6len
+	default:
+		return nil, fmt.Errorf("%w: unknown address family %d", ErrBadSTUN, val[1])
+	}
+	if len(val) != 4+n {
+		return nil, fmt.Errorf("%w: truncated address", ErrBadSTUN)
+	}
+
+	port := binary.BigEndian.Uint16(val[2:])
+	ip := make(net.IP, n)
+	copy(ip, val[4:])
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// stunDemux delivers the STUN messages received on a socket shared
+// with a transport to the transactions awaiting them.
+type stunDemux struct {
+	sync.Mutex
+
+	txns map[stunTxnID]chan []byte // Transactions awaiting responses
+}
+
+// watch registers a transaction, returning the channel on which its
+// responses are delivered and a function unregistering it.
+func (d *stunDemux) watch(txn stunTxnID) (<-chan []byte, func()) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.txns == nil {
+		d.txns = map[stunTxnID]chan []byte{}
+	}
+	ch := make(chan []byte, 1)
+	d.txns[txn] = ch
+
+	return ch, func() {
+		d.Lock()
+		defer d.Unlock()
+
+		delete(d.txns, txn)
+	}
+}
+
+// deliver delivers a datagram to the transaction awaiting it.  It
+// returns false if the datagram is not a STUN message for a
+// registered transaction, in which case it belongs to the transport.
+// The datagram must not be modified afterward.
+func (d *stunDemux) deliver(dgram []byte) bool {
+	txn, ok := stunTxn(dgram)
+	if !ok {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	ch, ok := d.txns[txn]
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- dgram:
+	default:
+	}
+
+	return true
+}
+
+// stunSocket is implemented by listeners whose sockets may be used to
+// exchange STUN messages.
+type stunSocket interface {
+	// stunResponses returns the demultiplexer delivering the STUN
+	// responses received on the socket.
+	stunResponses() *stunDemux
+
+	// stunWriteTo sends a STUN message from the socket.
+	stunWriteTo(msg []byte, addr net.Addr) (int, error)
+}
+
+// stunSocketOf returns the socket underlying a listener, looking
+// through security layers and other listener wrappers, if it may be
+// used to exchange STUN messages.
+func stunSocketOf(l Listener) (stunSocket, bool) {
+	for {
+		switch tmp := l.(type) {
+		case stunSocket:
+			return tmp, true
+		case *NoiseListener:
+			l = tmp.L
+		case *PSKListener:
+			l = tmp.L
+		case *TLSListener:
+			l = tmp.L
+		case *rateListener:
+			l = tmp.Listener
+		case *sniffListener:
+			l = tmp.Listener
+		case *filterListener:
+			l = tmp.Listener
+		case *deadlineListener:
+			l = tmp.Listener
+		case *handshakeListener:
+			l = tmp.Listener
+		default:
+			return nil, false
+		}
+	}
+}
+
+// stunBinding sends binding requests from a socket to a STUN server,
+// returning the mapped address reported by the first response.  The
+// requests are retransmitted as RFC 5389 describes, starting with the
+// specified retransmission timeout and doubling it each time, until
+// STUNRequests requests have gone unanswered.
+func stunBinding(ctx context.Context, sock stunSocket, server net.Addr, rto time.Duration) (*net.UDPAddr, error) {
+	txn, req, err := stunRequest()
+	if err != nil {
+		return nil, err
+	}
+	responses, done := sock.stunResponses().watch(txn)
+	defer done()
+
+	for range STUNRequests {
+		if _, err := sock.stunWriteTo(req, server); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(rto)
+		select {
+		case msg := <-responses:
+			timer.Stop()
+			return parseSTUNResponse(msg)
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		rto *= 2
+	}
+
+	return nil, ErrNoSTUNResponse
+}
+
+// ExternalURI discovers the external URI of a UDP or QUIC listener:
+// the URI at which it may be reached through the network address
+// translators between it and the STUN server.  A binding request is
+// sent from the listener's socket to the server, given as "host" or
+// "host:port", and the URI returned is the listener's URI with the
+// host replaced by the mapped address in the response.  Requests are
+// retransmitted until a response is received, the context is done,
+// or STUNRequests requests have gone unanswered, in which case an
+// error wrapping ErrNoSTUNResponse is returned.  If the listener's
+// transport does not support STUN, an error wrapping
+// ErrSTUNUnsupported is returned.
+func ExternalURI(ctx context.Context, l Listener, server string) (*URI, error) {
+	sock, ok := stunSocketOf(l)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", l.Addr(), ErrSTUNUnsupported)
+	}
+
+	// Resolve the server address
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), strconv.Itoa(DefaultSTUNPort))
+	}
+	addr, err := resolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("stun server %s: %w", server, err)
+	}
+
+	mapped, err := stunBinding(ctx, sock, addr, STUNInitialRTO)
+	if err != nil {
+		return nil, fmt.Errorf("stun server %s: %w", server, err)
+	}
+	u := *l.Addr()
+	u.Host = mapped.String()
+
+	return &u, nil
+}
@@ -0,0 +1,439 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stunMessage constructs a STUN message with the specified type,
+// transaction ID, and attributes, given as alternating types and
+// values.
+func stunMessage(typ uint16, txn []byte, attrs ...interface{}) []byte {
+	msg := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(msg, typ)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], txn)
+	for i := 0; i < len(attrs); i += 2 {
+		val := attrs[i+1].([]byte)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(attrs[i].(int)))
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(val)))
+		msg = append(msg, val...)
+		msg = append(msg, make([]byte, (4-len(val)%4)%4)...)
+	}
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)-stunHeaderLen))
+
+	return msg
+}
+
+// stunAddrValue encodes an address attribute value, XORed with the
+// key if it is not nil.
+func stunAddrValue(addr *net.UDPAddr, key []byte) []byte {
+	ip := addr.IP.To4()
+	family := byte(stunFamilyIPv4)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = stunFamilyIPv6
+	}
+	port := uint16(addr.Port)
+	ip = bytes.Clone(ip)
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key)
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	val := []byte{0, family}
+	val = binary.BigEndian.AppendUint16(val, port)
+
+	return append(val, ip...)
+}
+
+// stunKey returns the key with which the addresses of a response to
+// the specified request are XORed.
+func stunKey(req []byte) []byte {
+	return req[4:stunHeaderLen]
+}
+
+// stunServer starts a fake STUN server, which passes each request and
+// the address it came from to the respond function and sends the
+// response it returns, if any.
+func stunServer(t *testing.T, respond func(req []byte, addr *net.UDPAddr) []byte) string {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, UDPMaxDatagram)
+		for {
+			n, addr, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if resp := respond(bytes.Clone(buf[:n]), addr); resp != nil {
+				pc.WriteToUDP(resp, addr) //nolint:errcheck
+			}
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+// stunMapping is a respond function for stunServer reporting a fixed
+// mapped address.
+func stunMapping(mapped *net.UDPAddr) func(req []byte, addr *net.UDPAddr) []byte {
+	return func(req []byte, addr *net.UDPAddr) []byte {
+		return stunMessage(stunBindingSuccess, req[8:stunHeaderLen], stunAttrXORMapped, stunAddrValue(mapped, stunKey(req)))
+	}
+}
+
+// fakeSTUNSocket is a stunSocket for testing.
+type fakeSTUNSocket struct {
+	stun stunDemux    // The demultiplexer
+	sent chan []byte  // The messages sent
+	err  error        // Error to return from stunWriteTo
+	resp func([]byte) // Called with each message sent
+}
+
+// stunResponses returns the demultiplexer.
+func (s *fakeSTUNSocket) stunResponses() *stunDemux {
+	return &s.stun
+}
+
+// stunWriteTo records a message sent.
+func (s *fakeSTUNSocket) stunWriteTo(msg []byte, addr net.Addr) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.sent <- msg
+	if s.resp != nil {
+		s.resp(msg)
+	}
+
+	return len(msg), nil
+}
+
+func TestSTUNRequestBase(t *testing.T) {
+	defer patcher.SetVar(&randReader, bytes.NewReader([]byte("0123456789ab"))).Install().Restore()
+
+	txn, msg, err := stunRequest()
+
+	require.NoError(t, err)
+	assert.Equal(t, stunTxnID([]byte("0123456789ab")), txn)
+	assert.Equal(t, append([]byte{0, 1, 0, 0, 0x21, 0x12, 0xa4, 0x42}, "0123456789ab"...), msg)
+}
+
+func TestSTUNRequestRandError(t *testing.T) {
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+
+	_, msg, err := stunRequest()
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, msg)
+}
+
+func TestSTUNIndicationBase(t *testing.T) {
+	defer patcher.SetVar(&randReader, bytes.NewReader([]byte("0123456789ab"))).Install().Restore()
+
+	result, err := stunIndication()
+
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0, 0x11, 0, 0, 0x21, 0x12, 0xa4, 0x42}, "0123456789ab"...), result)
+}
+
+func TestSTUNIndicationRandError(t *testing.T) {
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+
+	result, err := stunIndication()
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestIsSTUNIndication(t *testing.T) {
+	txn := []byte("0123456789ab")
+	for name, test := range map[string]struct {
+		dgram  []byte
+		expect bool
+	}{
+		"indication": {stunMessage(stunBindingInd, txn), true},
+		"request":    {stunMessage(stunBindingRequest, txn), false},
+		"not stun":   {[]byte("not stun"), false},
+	} {
+		result := isSTUNIndication(test.dgram)
+
+		assert.Equal(t, test.expect, result, name)
+	}
+}
+
+func TestSTUNTxnBase(t *testing.T) {
+	msg := stunMessage(stunBindingSuccess, []byte("0123456789ab"), stunAttrMapped, []byte{1, 2, 3, 4})
+
+	txn, ok := stunTxn(msg)
+
+	assert.True(t, ok)
+	assert.Equal(t, stunTxnID([]byte("0123456789ab")), txn)
+}
+
+func TestSTUNTxnNotSTUN(t *testing.T) {
+	msg := stunMessage(stunBindingSuccess, []byte("0123456789ab"))
+	for name, dgram := range map[string][]byte{
+		"short":  msg[:stunHeaderLen-1],
+		"bits":   append([]byte{0x40}, msg[1:]...),
+		"cookie": append(bytes.Clone(msg[:4]), append([]byte{0, 0, 0, 0}, msg[8:]...)...),
+		"length": append(bytes.Clone(msg), 0, 0, 0, 0),
+	} {
+		_, ok := stunTxn(dgram)
+
+		assert.False(t, ok, name)
+	}
+}
+
+func TestParseSTUNResponseXORMapped(t *testing.T) {
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 4242},
+		{IP: net.ParseIP("2001:db8::7"), Port: 4242},
+	} {
+		req := stunMessage(stunBindingRequest, []byte("0123456789ab"))
+		msg := stunMessage(stunBindingSuccess, []byte("0123456789ab"),
+			stunAttrMapped, stunAddrValue(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}, nil),
+			stunAttrXORMapped, stunAddrValue(addr, stunKey(req)),
+		)
+
+		result, err := parseSTUNResponse(msg)
+
+		assert.NoError(t, err)
+		assert.Equal(t, addr, result)
+	}
+}
+
+func TestParseSTUNResponseMapped(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 4242}
+	msg := stunMessage(stunBindingSuccess, []byte("0123456789ab"),
+		0x8022, []byte("server"),
+		stunAttrMapped, stunAddrValue(addr, nil),
+	)
+
+	result, err := parseSTUNResponse(msg)
+
+	assert.NoError(t, err)
+	assert.Equal(t, addr, result)
+}
+
+func TestParseSTUNResponseError(t *testing.T) {
+	msg := stunMessage(stunBindingError, []byte("0123456789ab"),
+		stunAttrErrorCode, append([]byte{0, 0, 4, 20}, "Unknown Attribute"...),
+	)
+
+	result, err := parseSTUNResponse(msg)
+
+	assert.ErrorIs(t, err, ErrSTUNFailed)
+	assert.ErrorContains(t, err, "420 Unknown Attribute")
+	assert.Nil(t, result)
+}
+
+func TestParseSTUNResponseBad(t *testing.T) {
+	txn := []byte("0123456789ab")
+	for name, msg := range map[string][]byte{
+		"no address":     stunMessage(stunBindingSuccess, txn),
+		"type":           stunMessage(stunBindingRequest, txn),
+		"short attr":     append(stunMessage(stunBindingSuccess, txn), 0, 1),
+		"long attr":      append(stunMessage(stunBindingSuccess, txn), 0, 1, 0, 8, 0, 0, 0, 0),
+		"short address":  stunMessage(stunBindingSuccess, txn, stunAttrMapped, []byte{0, 1}),
+		"family":         stunMessage(stunBindingSuccess, txn, stunAttrMapped, []byte{0, 3, 0, 1, 1, 2, 3, 4}),
+		"address length": stunMessage(stunBindingSuccess, txn, stunAttrMapped, []byte{0, 1, 0, 1, 1, 2, 3}),
+		"error code":     stunMessage(stunBindingError, txn, stunAttrErrorCode, []byte{0, 0, 4}),
+	} {
+		result, err := parseSTUNResponse(msg)
+
+		assert.ErrorIs(t, err, ErrBadSTUN, name)
+		assert.Nil(t, result, name)
+	}
+}
+
+func TestSTUNDemuxDeliver(t *testing.T) {
+	obj := &stunDemux{}
+	txn := stunTxnID([]byte("0123456789ab"))
+	ch, done := obj.watch(txn)
+	msg := stunMessage(stunBindingSuccess, txn[:])
+
+	assert.True(t, obj.deliver(msg))
+	assert.True(t, obj.deliver(msg))
+	assert.False(t, obj.deliver(stunMessage(stunBindingSuccess, []byte("ba9876543210"))))
+	assert.False(t, obj.deliver([]byte("not stun")))
+
+	assert.Equal(t, msg, <-ch)
+	done()
+	assert.False(t, obj.deliver(msg))
+	assert.Empty(t, obj.txns)
+}
+
+func TestSTUNSocketOf(t *testing.T) {
+	udp := &UDPListener{}
+	quicL := &QUICListener{}
+	for name, l := range map[string]Listener{
+		"udp":    udp,
+		"quic":   quicL,
+		"noise":  &NoiseListener{L: udp},
+		"psk":    &PSKListener{L: udp},
+		"tls":    &TLSListener{L: udp},
+		"rate":   &rateListener{Listener: &sniffListener{Listener: udp}},
+		"accept": &handshakeListener{Listener: &deadlineListener{Listener: &filterListener{Listener: udp}}},
+	} {
+		result, ok := stunSocketOf(l)
+
+		assert.True(t, ok, name)
+		if name == "quic" {
+			assert.Same(t, quicL, result, name)
+		} else {
+			assert.Same(t, udp, result, name)
+		}
+	}
+}
+
+func TestSTUNSocketOfUnsupported(t *testing.T) {
+	result, ok := stunSocketOf(&TLSListener{L: &TCPListener{}})
+
+	assert.False(t, ok)
+	assert.Nil(t, result)
+}
+
+func TestSTUNBindingRetransmit(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 4242}
+	sock := &fakeSTUNSocket{sent: make(chan []byte, STUNRequests)}
+	sock.resp = func(req []byte) {
+		if len(sock.sent) == 2 {
+			sock.stun.deliver(stunMapping(mapped)(req, nil))
+		}
+	}
+
+	result, err := stunBinding(context.Background(), sock, &net.UDPAddr{}, time.Millisecond)
+
+	assert.NoError(t, err)
+	assert.Equal(t, mapped, result)
+	assert.Len(t, sock.sent, 2)
+}
+
+func TestSTUNBindingNoResponse(t *testing.T) {
+	sock := &fakeSTUNSocket{sent: make(chan []byte, STUNRequests)}
+
+	result, err := stunBinding(context.Background(), sock, &net.UDPAddr{}, time.Microsecond)
+
+	assert.ErrorIs(t, err, ErrNoSTUNResponse)
+	assert.Nil(t, result)
+	assert.Len(t, sock.sent, STUNRequests)
+	assert.Empty(t, sock.stun.txns)
+}
+
+func TestSTUNBindingContext(t *testing.T) {
+	sock := &fakeSTUNSocket{sent: make(chan []byte, STUNRequests)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := stunBinding(ctx, sock, &net.UDPAddr{}, time.Hour)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestSTUNBindingWriteError(t *testing.T) {
+	sock := &fakeSTUNSocket{err: assert.AnError}
+
+	result, err := stunBinding(context.Background(), sock, &net.UDPAddr{}, time.Hour)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestExternalURIUDP(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 4242}
+	server := stunServer(t, stunMapping(mapped))
+	l, err := Listen(context.Background(), nil, "udp://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	result, err := ExternalURI(context.Background(), l, server)
+
+	require.NoError(t, err)
+	assert.Equal(t, "udp://203.0.113.7:4242", result.String())
+	assert.Equal(t, "udp", result.Transport)
+	assert.NotEqual(t, result.Host, l.Addr().Host)
+}
+
+func TestExternalURIUDPPassesDatagrams(t *testing.T) {
+	server := stunServer(t, func(req []byte, addr *net.UDPAddr) []byte {
+		return []byte("not stun")
+	})
+	l, err := Listen(context.Background(), nil, "udp://127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = ExternalURI(ctx, l, server)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	c, err := l.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, server, c.RemoteURI.Host)
+}
+
+func TestExternalURIQUIC(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 4242}
+	server := stunServer(t, stunMapping(mapped))
+	l, err := QUICMech(0).Listen(context.Background(), quicTestConfig(), &URI{URL: url.URL{Host: "127.0.0.1:0"}}, nil)
+	require.NoError(t, err)
+	defer l.Close()
+
+	result, err := ExternalURI(context.Background(), l, server)
+
+	require.NoError(t, err)
+	assert.Equal(t, "quic://[2001:db8::7]:4242", result.String())
+}
+
+func TestExternalURIUnsupported(t *testing.T) {
+	l, err := Listen(context.Background(), nil, "mem:external-uri-unsupported")
+	require.NoError(t, err)
+	defer l.Close()
+
+	result, err := ExternalURI(context.Background(), l, "127.0.0.1")
+
+	assert.ErrorIs(t, err, ErrSTUNUnsupported)
+	assert.Nil(t, result)
+}
+
+func TestExternalURIResolveError(t *testing.T) {
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		assert.Equal(t, "stun.example.com:3478", address)
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := ExternalURI(context.Background(), &UDPListener{}, "stun.example.com")
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "stun.example.com:3478")
+	assert.Nil(t, result)
+}
@@ -16,6 +16,7 @@ package conduit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
@@ -35,11 +36,14 @@ func TCPAddr2URI(addr net.Addr) *URI {
 }
 
 // tcpReuseAddr is an implementation of the Control option which sets
-// the "reuseaddr" flag on a listening socket.
+// the "reuseaddr" flag on a listening socket.  Platforms not
+// supporting the flag are ignored.
 func tcpReuseAddr(network, address string, c syscall.RawConn) error {
-	return c.Control(func(fd uintptr) {
-		setsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1) //nolint:errcheck
-	})
+	if err := SetSockOpt(c, SockOptReuseAddr, 1); err != nil && !errors.Is(err, ErrUnsupportedOption) {
+		return err
+	}
+
+	return nil
 }
 
 // tcpFilter is an implementation of dialerFilter that catches the
@@ -84,17 +88,36 @@ type TCPMech int
 // connection.  For those transports that are not connection-oriented,
 // the conduit will still be in the appropriate state.
 func (t TCPMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
-	// Construct the dialer
+	// Select the source address and construct the dialer; when
+	// dialing through a proxy, the source address is selected for
+	// reaching the proxy
+	p := findProxy(opts)
+	dest := u.Host
+	if p != nil {
+		dest = p.URL.Host
+	}
+	if err := selectSources(opts, dest); err != nil {
+		return nil, err
+	}
 	dialer, err := mkDialerPatch(opts, tcpFilter(0))
 	if err != nil {
 		return nil, err
 	}
+	if p != nil {
+		if dialer, err = p.dialer(dialer); err != nil {
+			return nil, err
+		}
+	}
 
 	// Dial the target
 	c, err := dialer.DialContext(ctx, "tcp", u.Host)
 	if err != nil {
 		return nil, err
 	}
+	if err := setNoDelay(c, findNoDelay(opts)); err != nil {
+		c.Close()
+		return nil, err
+	}
 
 	// Construct and return a Conduit
 	return &Conduit{
@@ -126,8 +149,9 @@ func (t TCPMech) Listen(ctx context.Context, config Config, u *URI, opts []Liste
 
 	// Return a listener
 	return &TCPListener{
-		L:   l,
-		URI: TCPAddr2URI(l.Addr()),
+		L:       l,
+		URI:     TCPAddr2URI(l.Addr()),
+		noDelay: findNoDelay(opts),
 	}, nil
 }
 
@@ -135,6 +159,8 @@ func (t TCPMech) Listen(ctx context.Context, config Config, u *URI, opts []Liste
 type TCPListener struct {
 	L   net.Listener // Underlying TCP listener
 	URI *URI         // URI contains the URI used to open the listener
+
+	noDelay *NoDelay // NoDelay option applied to accepted connections
 }
 
 // Accept waits for and returns the next conduit to the listener.
@@ -144,14 +170,25 @@ func (l *TCPListener) Accept() (*Conduit, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := setNoDelay(c, l.noDelay); err != nil {
+		c.Close()
+		return nil, &AcceptError{Reason: AcceptHandshake, RemoteURI: TCPAddr2URI(c.RemoteAddr()), Err: err}
+	}
 
 	// Wrap it in a conduit
-	return &Conduit{
-		State:     Passive,
-		LocalURI:  l.URI,
-		RemoteURI: TCPAddr2URI(c.RemoteAddr()),
-		Link:      c,
-	}, nil
+	result := &Conduit{
+		State:        Passive,
+		LocalURI:     l.URI,
+		RemoteURI:    TCPAddr2URI(c.RemoteAddr()),
+		Link:         c,
+		Fingerprints: map[string]string{},
+	}
+	if fp := TCPFingerprint(c); fp != "" {
+		result.Fingerprints[FingerprintTCP] = fp
+	}
+	audit(AuditAccept, result)
+
+	return result, nil
 }
 
 // Close closes the listener.  Any blocked Accept operations will be
@@ -18,7 +18,6 @@ import (
 	"context"
 	"net"
 	"net/url"
-	"syscall"
 	"testing"
 
 	"github.com/klmitch/patcher"
@@ -42,6 +41,18 @@ func TestTCPAddr2URI(t *testing.T) {
 	addr.AssertExpectations(t)
 }
 
+func TestTCPAddr2URIZone(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 1234, Zone: "eth0"}
+
+	result := TCPAddr2URI(addr)
+
+	assert.Equal(t, "[fe80::1%eth0]:1234", result.Host)
+	assert.True(t, result.IsCanonical())
+	parsed, err := Parse(result.String())
+	assert.NoError(t, err)
+	assert.True(t, result.Equal(parsed))
+}
+
 type mockRawConn struct {
 	mock.Mock
 }
@@ -68,10 +79,11 @@ func TestTCPReuseAddr(t *testing.T) {
 	c := &mockRawConn{}
 	c.On("Control", mock.Anything).Return(assert.AnError)
 	setsockoptCalled := false
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{SockOptReuseAddr: {1, 2}}).Install().Restore()
 	defer patcher.SetVar(&setsockoptInt, func(fd, level, opt, value int) error {
 		assert.Equal(t, 5, fd)
-		assert.Equal(t, syscall.SOL_SOCKET, level)
-		assert.Equal(t, syscall.SO_REUSEADDR, opt)
+		assert.Equal(t, 1, level)
+		assert.Equal(t, 2, opt)
 		assert.Equal(t, 1, value)
 		setsockoptCalled = true
 		return nil
@@ -87,6 +99,16 @@ func TestTCPReuseAddr(t *testing.T) {
 	assert.True(t, setsockoptCalled)
 }
 
+func TestTCPReuseAddrUnsupported(t *testing.T) {
+	c := &mockRawConn{}
+	defer patcher.SetVar(&sockOpts, map[SockOpt]sockOptDesc{}).Install().Restore()
+
+	err := tcpReuseAddr("net", "addr", c)
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
 func TestTCPFilterImplementsDialerFilter(t *testing.T) {
 	assert.Implements(t, (*dialerFilter)(nil), tcpFilter(0))
 }
@@ -385,6 +407,10 @@ func TestTCPListenerAcceptBase(t *testing.T) {
 	addr.On("String").Return("127.0.0.1:1234")
 	c.On("RemoteAddr").Return(addr)
 	l.On("Accept").Return(c, nil)
+	events := []*AuditEvent{}
+	defer SetAuditor(SetAuditor(AuditorFunc(func(ev *AuditEvent) {
+		events = append(events, ev)
+	})))
 
 	result, err := obj.Accept()
 
@@ -399,8 +425,10 @@ func TestTCPListenerAcceptBase(t *testing.T) {
 			},
 			Transport: "tcp",
 		},
-		Link: c,
+		Link:         c,
+		Fingerprints: map[string]string{},
 	}, result)
+	assert.Equal(t, []*AuditEvent{{Event: AuditAccept, Conduit: result}}, events)
 	l.AssertExpectations(t)
 	c.AssertExpectations(t)
 	addr.AssertExpectations(t)
@@ -455,3 +483,23 @@ func TestTCPListenerAddr(t *testing.T) {
 		},
 	}, result)
 }
+
+func TestTCPMechDialSelectSourceError(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	local, _ := Parse("tcp://[2001:db8::1]:0")
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:4321",
+		},
+	}
+	obj := TCPMech(0)
+	defer patcher.SetVar(&mkDialerPatch, func(opts []DialerOption, filt dialerFilter) (iDialer, error) {
+		panic("unexpected call")
+	}).Install().Restore()
+
+	result, err := obj.Dial(ctx, cfg, u, []DialerOption{LocalAddrs(nil, local)})
+
+	assert.ErrorIs(t, err, ErrNoSourceAddr)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultTLSHandshakeTimeout is the time allowed for a conduit to
+// complete the TLS handshake.
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// TLSConfig is the configuration for the TLS security layer.  It must
+// be returned by Config.ForSecurity("tls").
+type TLSConfig struct {
+	TLS        *tls.Config   // TLS configuration; required
+	Authorizer Authorizer    // Authorizes peers and names their principals; may be nil
+	Timeout    time.Duration // Handshake timeout
+}
+
+// tlsSecConfig retrieves the TLS security layer configuration.
+func tlsSecConfig(config Config) (*TLSConfig, error) {
+	if config == nil {
+		return nil, fmt.Errorf("tls: %w", ErrMissingConfig)
+	}
+	tc, ok := config.ForSecurity("tls").(*TLSConfig)
+	if !ok || tc == nil || tc.TLS == nil {
+		return nil, fmt.Errorf("tls: %w", ErrMissingConfig)
+	}
+
+	return tc, nil
+}
+
+// tlsStrength estimates the encryption strength of a TLS cipher
+// suite.
+func tlsStrength(suite uint16) uint32 {
+	if strings.Contains(tls.CipherSuiteName(suite), "AES_128") {
+		return 128
+	}
+
+	return 256
+}
+
+// tlsSecure updates a conduit once the TLS handshake has completed,
+// invoking the authorizer to name the principal.
+func tlsSecure(c *Conduit, tc *tls.Conn, auth Authorizer) error {
+	cs := tc.ConnectionState()
+	principal, err := authorize(auth, &cs)
+	if err != nil {
+		return err
+	}
+
+	c.Link = tc
+	c.Confidential = true
+	c.Integrity = true
+	c.Strength = tlsStrength(cs.CipherSuite)
+	c.Principal = principal
+	c.Binding = tlsBinding(&cs)
+
+	return nil
+}
+
+// TLSMech is a security layer mechanism running TLS over any
+// underlying stream transport.
+type TLSMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m TLSMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	tc, err := tlsSecConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mech := lookupTransport(u.Transport)
+	if mech == nil {
+		return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
+	}
+
+	// Dial the transport
+	c, err := dialTransport(ctx, mech, config, u, opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.Boundaries {
+		closeLink(c)
+		return nil, fmt.Errorf("tls: %q: %w", u.Transport, ErrNotStream)
+	}
+
+	// Run the handshake
+	conf := tc.TLS
+	if conf.ServerName == "" {
+		conf = conf.Clone()
+		host, _, _ := net.SplitHostPort(u.Host)
+		conf.ServerName, _ = splitZone(host)
+	}
+	tlsConn := tls.Client(c.Link, conf)
+	hctx, cancel := handshakeContext(ctx, opts, tc.Timeout, DefaultTLSHandshakeTimeout)
+	defer cancel()
+	err = traceSpan(hctx, "handshake", u, tlsConn.HandshakeContext)
+	if err != nil {
+		closeLink(c)
+		return nil, err
+	}
+	if err := tlsSecure(c, tlsConn, tc.Authorizer); err != nil {
+		tlsConn.Close() //nolint:errcheck
+		return nil, err
+	}
+	c.LocalURI = securityURI(c.LocalURI, "tls")
+
+	return c, nil
+}
+
+// Listen opens a transport in passive mode; that is, for
+// connection-oriented transports, Listen creates a listener that may
+// accept connections.  For those transports that are not
+// connection-oriented, the listener synthesizes the appropriate
+// state.
+func (m TLSMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	tc, err := tlsSecConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	tc, err = tlsTicketKeys(tc)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenTransport(ctx, config, u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TLSListener{
+		L:      l,
+		Config: tc,
+	}, nil
+}
+
+// tlsTicketKeys returns a copy of the TLS security layer
+// configuration with a session ticket key set.  Each handshake uses a
+// clone of the TLS configuration, and clones of a configuration whose
+// keys are managed automatically each generate their own, so an
+// explicit key is required for clients to resume their sessions.
+func tlsTicketKeys(tc *TLSConfig) (*TLSConfig, error) {
+	if tc.TLS.SessionTicketsDisabled || tc.TLS.SessionTicketKey != [32]byte{} {
+		return tc, nil
+	}
+
+	key := [32]byte{}
+	if _, err := io.ReadFull(randReader, key[:]); err != nil {
+		return nil, err
+	}
+	result := *tc
+	result.TLS = tc.TLS.Clone()
+	result.TLS.SetSessionTicketKeys([][32]byte{key})
+
+	return &result, nil
+}
+
+// TLSListener is an implementation of Listener for the TLS security
+// layer.
+type TLSListener struct {
+	L      Listener   // Underlying transport listener
+	Config *TLSConfig // The TLS configuration
+}
+
+// handshake runs the server side of the TLS handshake over an
+// accepted conduit, recording the fingerprint of the client hello.
+func (l *TLSListener) handshake(c *Conduit) error {
+	if c.Boundaries {
+		return fmt.Errorf("tls: %w", ErrNotStream)
+	}
+
+	timeout := l.Config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTLSHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Record the client hello fingerprint
+	fp := ""
+	conf := l.Config.TLS.Clone()
+	next := conf.GetConfigForClient
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		fp = TLSFingerprint('t', hello)
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+
+	tlsConn := tls.Server(c.Link, conf)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	if err := tlsSecure(c, tlsConn, l.Config.Authorizer); err != nil {
+		return err
+	}
+	if fp != "" {
+		if c.Fingerprints == nil {
+			c.Fingerprints = map[string]string{}
+		}
+		c.Fingerprints[FingerprintTLS] = fp
+	}
+
+	return nil
+}
+
+// Accept waits for and returns the next conduit to the listener.
+// Conduits failing the handshake or rejected by the authorizer are
+// closed, and an AcceptError describing the failure is returned.
+func (l *TLSListener) Accept() (*Conduit, error) {
+	c, err := l.L.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.handshake(c)
+	logHandshake("tls", c, err)
+	if err != nil {
+		closeLink(c)
+		return nil, acceptError(c, err)
+	}
+	c.LocalURI = securityURI(c.LocalURI, "tls")
+	c.RemoteURI = securityURI(c.RemoteURI, "tls")
+
+	return c, nil
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors.
+func (l *TLSListener) Close() error {
+	return l.L.Close()
+}
+
+// Addr returns the listener's network URI.
+func (l *TLSListener) Addr() *URI {
+	return securityURI(l.L.Addr(), "tls")
+}
+
+// init initializes the TLS security layer.
+func init() {
+	RegisterSecurity("tls", TLSMech(0))
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tlsTestCert generates a self-signed certificate for 127.0.0.1 with
+// the specified common name, returning the certificate and its key.
+func tlsTestCert(t *testing.T, cn string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	return testCertKey(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	})
+}
+
+// tlsTestConfig generates a self-signed certificate for 127.0.0.1
+// and returns a TLS configuration usable by both client and server.
+func tlsTestConfig(t *testing.T) *tls.Config {
+	cert, key := tlsTestCert(t, "peer")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+			Leaf:        cert,
+		}},
+		RootCAs:   pool,
+		ClientCAs: pool,
+	}
+}
+
+// tlsCfg returns a configuration for the TLS security layer.
+func tlsCfg(tc *TLSConfig) *mockConfig {
+	cfg := &mockConfig{}
+	cfg.On("ForSecurity", "tls").Return(tc)
+	cfg.On("ForTransport", "tcp").Return(nil)
+	cfg.On("ForTransport", "udp").Return(nil)
+
+	return cfg
+}
+
+func TestTLSMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), TLSMech(0))
+}
+
+func TestTLSListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &TLSListener{})
+}
+
+func TestTLSSecConfigBase(t *testing.T) {
+	tc := &TLSConfig{TLS: &tls.Config{}}
+
+	result, err := tlsSecConfig(tlsCfg(tc))
+
+	assert.NoError(t, err)
+	assert.Same(t, tc, result)
+}
+
+func TestTLSSecConfigNil(t *testing.T) {
+	result, err := tlsSecConfig(nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestTLSSecConfigMissingTLS(t *testing.T) {
+	result, err := tlsSecConfig(tlsCfg(&TLSConfig{}))
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestTLSTicketKeysBase(t *testing.T) {
+	tc := &TLSConfig{TLS: &tls.Config{ServerName: "example.com"}, Timeout: time.Second}
+
+	result, err := tlsTicketKeys(tc)
+
+	assert.NoError(t, err)
+	assert.NotSame(t, tc, result)
+	assert.NotSame(t, tc.TLS, result.TLS)
+	assert.Equal(t, "example.com", result.TLS.ServerName)
+	assert.Equal(t, time.Second, result.Timeout)
+}
+
+func TestTLSTicketKeysDisabled(t *testing.T) {
+	tc := &TLSConfig{TLS: &tls.Config{SessionTicketsDisabled: true}}
+
+	result, err := tlsTicketKeys(tc)
+
+	assert.NoError(t, err)
+	assert.Same(t, tc, result)
+}
+
+func TestTLSTicketKeysExplicit(t *testing.T) {
+	tc := &TLSConfig{TLS: &tls.Config{SessionTicketKey: [32]byte{1}}}
+
+	result, err := tlsTicketKeys(tc)
+
+	assert.NoError(t, err)
+	assert.Same(t, tc, result)
+}
+
+func TestTLSTicketKeysRandError(t *testing.T) {
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+
+	result, err := tlsTicketKeys(&TLSConfig{TLS: &tls.Config{}})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+}
+
+func TestTLSStrength128(t *testing.T) {
+	result := tlsStrength(tls.TLS_AES_128_GCM_SHA256)
+
+	assert.Equal(t, uint32(128), result)
+}
+
+func TestTLSStrength256(t *testing.T) {
+	result := tlsStrength(tls.TLS_CHACHA20_POLY1305_SHA256)
+
+	assert.Equal(t, uint32(256), result)
+}
+
+func TestTLSMechDialListen(t *testing.T) {
+	conf := tlsTestConfig(t)
+	conf.ClientAuth = tls.RequireAndVerifyClientCert
+	cfg := tlsCfg(&TLSConfig{TLS: conf})
+	u, _ := Parse("tcp+tls://127.0.0.1:0")
+	l, err := TLSMech(0).Listen(context.Background(), cfg, u, nil)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, "tcp+tls", l.Addr().Scheme)
+	accepted := make(chan *Conduit, 1)
+	failed := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		failed <- err
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+		close(accepted)
+	}()
+
+	// A peer failing the handshake must be reported
+	bogus, err := net.Dial("tcp", l.Addr().Host)
+	require.NoError(t, err)
+	_, err = bogus.Write([]byte("bogus handshake\r\n\r\n"))
+	require.NoError(t, err)
+	defer bogus.Close()
+	assert.Equal(t, AcceptHandshake, ClassifyAccept(<-failed))
+
+	cli, err := TLSMech(0).Dial(context.Background(), cfg, l.Addr(), nil)
+
+	require.NoError(t, err)
+	defer cli.Link.Close()
+	srv := <-accepted
+	require.NotNil(t, srv)
+	defer srv.Link.Close()
+	assert.Equal(t, "peer", cli.Principal)
+	assert.Equal(t, "peer", srv.Principal)
+	assert.NotZero(t, cli.Strength)
+	assert.Equal(t, "tcp+tls", cli.LocalURI.Scheme)
+	assert.Equal(t, "tcp+tls", srv.LocalURI.Scheme)
+	assert.Equal(t, "tcp+tls", srv.RemoteURI.Scheme)
+	assert.Contains(t, srv.Fingerprints, FingerprintTLS)
+}
+
+func TestTLSMechDialMissingConfig(t *testing.T) {
+	u, _ := Parse("tcp+tls://127.0.0.1:1234")
+
+	result, err := TLSMech(0).Dial(context.Background(), nil, u, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestTLSMechDialUnknownTransport(t *testing.T) {
+	u, _ := Parse("bogus+tls://127.0.0.1:1234")
+
+	result, err := TLSMech(0).Dial(context.Background(), tlsCfg(&TLSConfig{TLS: &tls.Config{}}), u, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestTLSMechDialNotStream(t *testing.T) {
+	u, _ := Parse("udp+tls://127.0.0.1:1234")
+
+	result, err := TLSMech(0).Dial(context.Background(), tlsCfg(&TLSConfig{TLS: &tls.Config{}}), u, nil)
+
+	assert.ErrorIs(t, err, ErrNotStream)
+	assert.Nil(t, result)
+}
+
+func TestTLSMechListenMissingConfig(t *testing.T) {
+	u, _ := Parse("tcp+tls://127.0.0.1:0")
+
+	result, err := TLSMech(0).Listen(context.Background(), nil, u, nil)
+
+	assert.ErrorIs(t, err, ErrMissingConfig)
+	assert.Nil(t, result)
+}
+
+func TestTLSMechListenUnknownTransport(t *testing.T) {
+	u, _ := Parse("bogus+tls://127.0.0.1:1234")
+
+	result, err := TLSMech(0).Listen(context.Background(), tlsCfg(&TLSConfig{TLS: &tls.Config{}}), u, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, result)
+}
+
+func TestTLSMechRegistered(t *testing.T) {
+	assert.Equal(t, TLSMech(0), LookupSecurity("tls"))
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultTLSReloadInterval is the default minimum interval between
+// checks of the files loaded by a TLSReloader.
+const DefaultTLSReloadInterval = time.Minute
+
+// TLSReloader loads a certificate and private key, and optionally a
+// bundle of CA certificates, from PEM files, and reloads them when
+// the files change.  This allows long-running nodes to rotate
+// certificates issued by short-lived CAs without restarting their
+// listeners.  The files are checked at most once per Interval, when a
+// handshake needs them; Watch may be used to check them in the
+// background as well.  If the changed files cannot be loaded, for
+// instance because the certificate has been replaced but the key has
+// not yet been, the previous certificates remain in use.  The
+// exported fields must be set before calling any of the methods.
+type TLSReloader struct {
+	sync.Mutex
+
+	CertFile string        // PEM file containing the certificate chain
+	KeyFile  string        // PEM file containing the private key
+	CAFile   string        // PEM file containing CA certificates; may be empty
+	Interval time.Duration // Minimum interval between checks; defaults to DefaultTLSReloadInterval
+
+	checked time.Time        // Time the files were last checked
+	loaded  bool             // True once the files have been loaded
+	data    [3][]byte        // Contents of the files when last loaded
+	cert    *tls.Certificate // The current certificate
+	pool    *x509.CertPool   // The current CA certificates
+}
+
+// interval returns the minimum interval between checks.
+func (r *TLSReloader) interval() time.Duration {
+	if r.Interval <= 0 {
+		return DefaultTLSReloadInterval
+	}
+
+	return r.Interval
+}
+
+// reload reads the files, loading the certificates if they have
+// changed.  It returns true if the certificates were replaced.  Must
+// be called with the lock held.
+func (r *TLSReloader) reload() (bool, error) {
+	r.checked = timeNow()
+
+	// Read the files
+	data := [3][]byte{}
+	for i, name := range []string{r.CertFile, r.KeyFile, r.CAFile} {
+		if name == "" {
+			continue
+		}
+		var err error
+		if data[i], err = readFile(name); err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+	}
+	if r.loaded && bytes.Equal(data[0], r.data[0]) && bytes.Equal(data[1], r.data[1]) && bytes.Equal(data[2], r.data[2]) {
+		return false, nil
+	}
+
+	// Load the certificate
+	var cert *tls.Certificate
+	if r.CertFile != "" || r.KeyFile != "" {
+		tmp, err := tls.X509KeyPair(data[0], data[1])
+		if err != nil {
+			return false, fmt.Errorf("tls: %w", err)
+		}
+		cert = &tmp
+	}
+
+	// Load the CA certificates
+	var pool *x509.CertPool
+	if r.CAFile != "" {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data[2]) {
+			return false, fmt.Errorf("tls: ca: %w", ErrBadConfig)
+		}
+	}
+
+	r.loaded = true
+	r.data = data
+	r.cert = cert
+	r.pool = pool
+
+	return true, nil
+}
+
+// Reload reads the files immediately, loading the certificates if
+// they have changed.  If they cannot be loaded, an error is returned
+// and the previous certificates remain in use.
+func (r *TLSReloader) Reload() error {
+	r.Lock()
+	defer r.Unlock()
+
+	_, err := r.reload()
+
+	return err
+}
+
+// current returns the current certificate and CA certificates,
+// reloading them first if the interval has elapsed since the files
+// were last checked or if force is true.  Reloads and failures to
+// reload are logged.
+func (r *TLSReloader) current(force bool) (*tls.Certificate, *x509.CertPool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if force || !r.loaded || timeNow().Sub(r.checked) >= r.interval() {
+		if changed, err := r.reload(); changed || err != nil {
+			logResult("tls reload", err, "cert", r.CertFile, "ca", r.CAFile)
+		}
+	}
+
+	return r.cert, r.pool
+}
+
+// Watch checks the files every Interval until the context is
+// cancelled, so that changes are picked up even while no handshakes
+// are taking place.
+func (r *TLSReloader) Watch(ctx context.Context) {
+	t := time.NewTicker(r.interval())
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			r.current(true)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// verify verifies the certificate chain presented by a server against
+// the current CA certificates.
+func (r *TLSReloader) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("tls: %w", ErrUnauthorized)
+	}
+
+	_, pool := r.current(false)
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+
+	return err
+}
+
+// Config returns a copy of a TLS configuration which presents the
+// current certificate and, if CAFile is set, verifies peers against
+// the current CA certificates.  The CA certificates used by clients
+// cannot be replaced, so clients verify the certificate chains
+// presented by servers themselves unless InsecureSkipVerify is set;
+// any VerifyConnection function of the configuration is called
+// afterwards.
+func (r *TLSReloader) Config(base *tls.Config) *tls.Config {
+	conf := base.Clone()
+	if r.CertFile != "" || r.KeyFile != "" {
+		conf.Certificates = nil
+		conf.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current(false)
+			return cert, nil
+		}
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current(false)
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		}
+	}
+	if r.CAFile == "" {
+		return conf
+	}
+
+	// Servers use a configuration with the current CA certificates
+	next := conf.GetConfigForClient
+	verify := conf.VerifyConnection
+	insecure := conf.InsecureSkipVerify
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		var srv *tls.Config
+		if next != nil {
+			tmp, err := next(hello)
+			if err != nil {
+				return nil, err
+			} else if tmp != nil {
+				srv = tmp.Clone()
+			}
+		}
+		if srv == nil {
+			srv = conf.Clone()
+			srv.GetConfigForClient = nil
+			srv.VerifyConnection = verify
+			srv.InsecureSkipVerify = insecure
+		}
+		_, srv.ClientCAs = r.current(false)
+		return srv, nil
+	}
+
+	// Clients verify servers themselves
+	conf.RootCAs = nil
+	conf.ClientCAs = nil
+	if !insecure {
+		conf.InsecureSkipVerify = true
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := r.verify(cs); err != nil {
+				return err
+			}
+			if verify != nil {
+				return verify(cs)
+			}
+			return nil
+		}
+	}
+
+	return conf
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reloadFiles writes a self-signed certificate with the specified
+// common name, its key, and a CA file containing it to a directory,
+// returning a reloader for them.
+func reloadFiles(t *testing.T, dir, cn string) *TLSReloader {
+	cert, key := tlsTestCert(t, cn)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	r := &TLSReloader{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	require.NoError(t, os.WriteFile(r.CertFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(r.KeyFile, keyPEM, 0o600))
+	require.NoError(t, os.WriteFile(r.CAFile, certPEM, 0o600))
+
+	return r
+}
+
+// reloadHandshake runs a TLS handshake between a client and a server
+// over a loopback connection, returning the certificate presented by
+// the server.
+func reloadHandshake(t *testing.T, cliConf, srvConf *tls.Config) (*x509.Certificate, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srvConf)
+	require.NoError(t, err)
+	defer l.Close()
+	srvErr := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			srvErr <- err
+			return
+		}
+		defer c.Close()
+		srvErr <- c.(*tls.Conn).Handshake()
+	}()
+
+	cli, err := tls.Dial("tcp", l.Addr().String(), cliConf)
+	if err != nil {
+		<-srvErr
+		return nil, err
+	}
+	defer cli.Close()
+	if err := <-srvErr; err != nil {
+		return nil, err
+	}
+
+	return cli.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestTLSReloaderIntervalDefault(t *testing.T) {
+	obj := &TLSReloader{}
+
+	result := obj.interval()
+
+	assert.Equal(t, DefaultTLSReloadInterval, result)
+}
+
+func TestTLSReloaderIntervalSet(t *testing.T) {
+	obj := &TLSReloader{Interval: time.Second}
+
+	result := obj.interval()
+
+	assert.Equal(t, time.Second, result)
+}
+
+func TestTLSReloaderReloadBase(t *testing.T) {
+	obj := reloadFiles(t, t.TempDir(), "one")
+
+	err := obj.Reload()
+
+	assert.NoError(t, err)
+	require.NotNil(t, obj.cert)
+	assert.Equal(t, "one", obj.cert.Leaf.Subject.CommonName)
+	assert.NotNil(t, obj.pool)
+}
+
+func TestTLSReloaderReloadUnchanged(t *testing.T) {
+	obj := reloadFiles(t, t.TempDir(), "one")
+	require.NoError(t, obj.Reload())
+	cert := obj.cert
+
+	err := obj.Reload()
+
+	assert.NoError(t, err)
+	assert.Same(t, cert, obj.cert)
+}
+
+func TestTLSReloaderReloadChanged(t *testing.T) {
+	dir := t.TempDir()
+	obj := reloadFiles(t, dir, "one")
+	require.NoError(t, obj.Reload())
+	reloadFiles(t, dir, "two")
+
+	err := obj.Reload()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "two", obj.cert.Leaf.Subject.CommonName)
+}
+
+func TestTLSReloaderReloadMismatch(t *testing.T) {
+	dir := t.TempDir()
+	obj := reloadFiles(t, dir, "one")
+	require.NoError(t, obj.Reload())
+	other := reloadFiles(t, t.TempDir(), "two")
+	data, err := os.ReadFile(other.CertFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(obj.CertFile, data, 0o600))
+
+	err = obj.Reload()
+
+	assert.Error(t, err)
+	assert.Equal(t, "one", obj.cert.Leaf.Subject.CommonName)
+}
+
+func TestTLSReloaderReloadBadCA(t *testing.T) {
+	dir := t.TempDir()
+	obj := reloadFiles(t, dir, "one")
+	require.NoError(t, os.WriteFile(obj.CAFile, []byte("bogus"), 0o600))
+
+	err := obj.Reload()
+
+	assert.ErrorIs(t, err, ErrBadConfig)
+	assert.Nil(t, obj.cert)
+}
+
+func TestTLSReloaderReloadMissing(t *testing.T) {
+	obj := &TLSReloader{CertFile: filepath.Join(t.TempDir(), "cert.pem")}
+
+	err := obj.Reload()
+
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestTLSReloaderCurrentInterval(t *testing.T) {
+	now := time.Now()
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	dir := t.TempDir()
+	obj := reloadFiles(t, dir, "one")
+	obj.Interval = time.Minute
+	obj.current(false)
+	reloadFiles(t, dir, "two")
+
+	before, _ := obj.current(false)
+	now = now.Add(time.Minute)
+	after, _ := obj.current(false)
+
+	assert.Equal(t, "one", before.Leaf.Subject.CommonName)
+	assert.Equal(t, "two", after.Leaf.Subject.CommonName)
+}
+
+func TestTLSReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	obj := reloadFiles(t, dir, "one")
+	obj.Interval = time.Millisecond
+	require.NoError(t, obj.Reload())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go obj.Watch(ctx)
+
+	reloadFiles(t, dir, "two")
+
+	assert.Eventually(t, func() bool {
+		obj.Lock()
+		defer obj.Unlock()
+		return obj.cert.Leaf.Subject.CommonName == "two"
+	}, time.Second, time.Millisecond)
+}
+
+func TestTLSReloaderConfigRotate(t *testing.T) {
+	dir := t.TempDir()
+	obj := reloadFiles(t, dir, "one")
+	require.NoError(t, obj.Reload())
+	conf := obj.Config(&tls.Config{ServerName: "127.0.0.1", ClientAuth: tls.RequireAndVerifyClientCert})
+	first, err := reloadHandshake(t, conf, conf)
+	require.NoError(t, err)
+	reloadFiles(t, dir, "two")
+	require.NoError(t, obj.Reload())
+
+	second, err := reloadHandshake(t, conf, conf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "one", first.Subject.CommonName)
+	assert.Equal(t, "two", second.Subject.CommonName)
+}
+
+func TestTLSReloaderConfigUntrusted(t *testing.T) {
+	srv := reloadFiles(t, t.TempDir(), "one")
+	require.NoError(t, srv.Reload())
+	cli := reloadFiles(t, t.TempDir(), "two")
+	require.NoError(t, cli.Reload())
+
+	result, err := reloadHandshake(t,
+		cli.Config(&tls.Config{ServerName: "127.0.0.1"}),
+		srv.Config(&tls.Config{}),
+	)
+
+	var unknown x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknown)
+	assert.Nil(t, result)
+}
+
+func TestTLSReloaderConfigInsecure(t *testing.T) {
+	srv := reloadFiles(t, t.TempDir(), "one")
+	require.NoError(t, srv.Reload())
+	cli := reloadFiles(t, t.TempDir(), "two")
+	require.NoError(t, cli.Reload())
+
+	result, err := reloadHandshake(t,
+		cli.Config(&tls.Config{InsecureSkipVerify: true}), //nolint:gosec
+		srv.Config(&tls.Config{}),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "one", result.Subject.CommonName)
+}
+
+func TestTLSReloaderConfigNoCA(t *testing.T) {
+	obj := reloadFiles(t, t.TempDir(), "one")
+	obj.CAFile = ""
+	require.NoError(t, obj.Reload())
+	base := &tls.Config{ServerName: "example.com"}
+
+	result := obj.Config(base)
+
+	assert.Nil(t, result.GetConfigForClient)
+	assert.Nil(t, result.VerifyConnection)
+	assert.False(t, result.InsecureSkipVerify)
+	cert, err := result.GetCertificate(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Same(t, obj.cert, cert)
+	assert.Nil(t, base.GetCertificate)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer used by the
+// conduit package.
+const TracerName = "github.com/hydralang/humboldt/conduit"
+
+// Span attribute keys describing conduit URIs.
+const (
+	AttrTransport = attribute.Key("conduit.transport") // Name of the transport mechanism
+	AttrSecurity  = attribute.Key("conduit.security")  // Name of the security layer mechanism
+	AttrDiscovery = attribute.Key("conduit.discovery") // Name of the discovery mechanism
+	AttrResults   = attribute.Key("conduit.results")   // Number of canonical URIs found
+	AttrAddress   = attribute.Key("server.address")    // Target host
+	AttrPort      = attribute.Key("server.port")       // Target port
+)
+
+// tracerProvider is the current tracer provider.
+var (
+	tracerProvider trace.TracerProvider
+	tracerLock     sync.RWMutex
+)
+
+// SetTracerProvider sets the OpenTelemetry tracer provider used to
+// trace dialing, listening, canonicalization, and discovery,
+// returning the previous provider.  Passing nil selects the global
+// tracer provider, which is the default.  URI.Dial, URI.Listen,
+// URI.Canonicalize, and discovery lookups start the "conduit.dial",
+// "conduit.listen", "conduit.canonicalize", and "conduit.discover"
+// spans; within a dial, connecting the transport and the handshake of
+// the security layer are traced by the "conduit.connect" and
+// "conduit.handshake" spans.
+func SetTracerProvider(tp trace.TracerProvider) trace.TracerProvider {
+	tracerLock.Lock()
+	defer tracerLock.Unlock()
+
+	prev := tracerProvider
+	tracerProvider = tp
+
+	return prev
+}
+
+// tracer returns the tracer to use.
+func tracer() trace.Tracer {
+	tracerLock.RLock()
+	tp := tracerProvider
+	tracerLock.RUnlock()
+
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(TracerName)
+}
+
+// uriAttrs returns the span attributes describing a URI: the names of
+// its mechanisms and the target host and port.
+func uriAttrs(u *URI) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{AttrTransport.String(u.Transport)}
+	if u.Security != "" {
+		attrs = append(attrs, AttrSecurity.String(u.Security))
+	}
+	if u.Discovery != "" {
+		attrs = append(attrs, AttrDiscovery.String(u.Discovery))
+	}
+	if host := u.Hostname(); host != "" {
+		attrs = append(attrs, AttrAddress.String(host))
+	}
+	if port := u.Port(); port != "" {
+		attrs = append(attrs, AttrPort.String(port))
+	}
+
+	return attrs
+}
+
+// startSpan starts a span named for the operation on the URI.
+func startSpan(ctx context.Context, op string, u *URI) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "conduit."+op, trace.WithAttributes(uriAttrs(u)...))
+}
+
+// endSpan ends a span, recording the error, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceSpan calls a function within a span named for the operation
+// on the URI.
+func traceSpan(ctx context.Context, op string, u *URI, f func(ctx context.Context) error) error {
+	ctx, span := startSpan(ctx, op, u)
+	err := f(ctx)
+	endSpan(span, err)
+
+	return err
+}
+
+// dialTransport dials a transport mechanism within a "connect" span,
+// bounded by the ConnectTimeout option.
+func dialTransport(ctx context.Context, mech Mechanism, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	ctx, cancel := connectContext(ctx, opts)
+	defer cancel()
+
+	var c *Conduit
+	err := traceSpan(ctx, "connect", u, func(ctx context.Context) error {
+		var err error
+		c, err = mech.Dial(ctx, config, u, opts)
+		return err
+	})
+
+	return c, err
+}
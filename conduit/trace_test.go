@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordSpans installs a tracer provider recording the spans ended,
+// returning the recorder and a function restoring the previous
+// provider.
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	rec := tracetest.NewSpanRecorder()
+	prev := SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	return rec, func() { SetTracerProvider(prev) }
+}
+
+// spanNames returns the names of the recorded spans.
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	result := make([]string, len(spans))
+	for i, span := range spans {
+		result[i] = span.Name()
+	}
+
+	return result
+}
+
+func TestSetTracerProvider(t *testing.T) {
+	tp := noop.NewTracerProvider()
+	defer SetTracerProvider(SetTracerProvider(nil))
+
+	result1 := SetTracerProvider(tp)
+	result2 := SetTracerProvider(nil)
+
+	assert.Nil(t, result1)
+	assert.Equal(t, tp, result2)
+}
+
+func TestTracerGlobal(t *testing.T) {
+	defer SetTracerProvider(SetTracerProvider(nil))
+
+	result := tracer()
+
+	assert.Equal(t, otel.GetTracerProvider().Tracer(TracerName), result)
+}
+
+func TestURIAttrsBase(t *testing.T) {
+	result := uriAttrs(mustParse("tcp+tls.srv://example.com:1234"))
+
+	assert.Equal(t, []attribute.KeyValue{
+		AttrTransport.String("tcp"),
+		AttrSecurity.String("tls"),
+		AttrDiscovery.String("srv"),
+		AttrAddress.String("example.com"),
+		AttrPort.String("1234"),
+	}, result)
+}
+
+func TestURIAttrsNoHost(t *testing.T) {
+	result := uriAttrs(mustParse("tcp:"))
+
+	assert.Equal(t, []attribute.KeyValue{AttrTransport.String("tcp")}, result)
+}
+
+func TestTraceSpanBase(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+	u := mustParse("tcp://127.0.0.1:1234")
+
+	err := traceSpan(context.Background(), "test", u, func(ctx context.Context) error {
+		return nil
+	})
+
+	assert.NoError(t, err)
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "conduit.test", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, uriAttrs(u), spans[0].Attributes())
+}
+
+func TestTraceSpanError(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+
+	err := traceSpan(context.Background(), "test", mustParse("tcp:"), func(ctx context.Context) error {
+		return assert.AnError
+	})
+
+	assert.Same(t, assert.AnError, err)
+	spans := rec.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, assert.AnError.Error(), spans[0].Status().Description)
+	assert.Len(t, spans[0].Events(), 1)
+}
+
+func TestURIDialTraced(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+	mech := &mockMechanism{}
+	defer patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+		return mech
+	}).Install().Restore()
+	u := mustParse("tcp://127.0.0.1:1234")
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, nil, u, mock.Anything).Return(c, nil)
+
+	result, err := u.Dial(context.Background(), nil)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	spans := rec.Ended()
+	require.Equal(t, []string{"conduit.connect", "conduit.dial"}, spanNames(spans))
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+}
+
+func TestURIListenTraced(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+	u := mustParse("tcp://example.com:1234")
+
+	_, err := u.Listen(context.Background(), nil)
+
+	assert.ErrorIs(t, err, ErrNotCanonical)
+	spans := rec.Ended()
+	require.Equal(t, []string{"conduit.listen"}, spanNames(spans))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
+
+func TestURIResolveTraced(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+	disc := &mockDiscovery{}
+	u := mustParse("tcp.disc://example.com:1234")
+	disc.On("Discover", mock.Anything, u).Return([]*URI{mustParse("tcp://127.0.0.1:1234")}, nil)
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+
+	_, err := u.Resolve()
+
+	assert.NoError(t, err)
+	spans := rec.Ended()
+	require.Equal(t, []string{"conduit.discover", "conduit.canonicalize"}, spanNames(spans))
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[1].Attributes(), AttrResults.Int(1))
+}
+
+func TestURIDialAllTraced(t *testing.T) {
+	rec, restore := recordSpans()
+	defer restore()
+	mech, p := happyMech()
+	defer p.Install().Restore()
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, nil, uriHost("[::1]:1234"), []DialerOption{}).Return(c, nil)
+	ctx, parent := tracer().Start(context.Background(), "parent")
+	u := mustParse("tcp://example.com:1234")
+
+	_, err := u.DialAll(ctx, nil, 0)
+	parent.End()
+
+	assert.NoError(t, err)
+	spans := rec.Ended()
+	require.Equal(t, []string{"conduit.canonicalize", "conduit.connect", "conduit.dial", "parent"}, spanNames(spans))
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[2].Parent().SpanID())
+}
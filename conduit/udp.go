@@ -0,0 +1,474 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Constants used by the UDP transport.
+const (
+	UDPMaxDatagram = 65535 // Maximum size of a UDP datagram
+	UDPQueueDepth  = 64    // Datagrams queued per peer before dropping
+)
+
+// UDPAddr2URI converts an address in the form returned by UDP
+// connections into an appropriate URI.
+func UDPAddr2URI(addr net.Addr) *URI {
+	return &URI{
+		URL: url.URL{
+			Scheme: "udp",
+			Host:   addr.String(),
+		},
+		Transport: "udp",
+	}
+}
+
+// udpFilter is an implementation of dialerFilter that catches the
+// LocalAddr option and converts the URI appropriately to fill in the
+// LocalAddr of the Dialer.
+type udpFilter int
+
+// DialFilter filters the option.
+func (f udpFilter) DialFilter(o DialerOption) error {
+	return filterUDPLocalAddr(o, "udp")
+}
+
+// filterUDPLocalAddr catches the LocalAddr option of a mechanism
+// running over UDP, converting its URI, which must be for the named
+// transport, to fill in the LocalAddr of the Dialer.
+func filterUDPLocalAddr(o DialerOption, transport string) error {
+	la, ok := o.(*LocalAddrOption)
+	if !ok {
+		return nil
+	}
+
+	// Make sure the URI is canonical
+	if !la.URI.IsCanonical() {
+		return fmt.Errorf("local address %q: %w", la.URI, ErrNotCanonical)
+	} else if la.URI.Transport != transport {
+		return fmt.Errorf("local address %q: %w", la.URI, ErrUnknownTransport)
+	}
+
+	// Get the UDPAddr
+	addr, err := resolveUDPAddr("udp", la.URI.Host)
+	if err != nil {
+		return fmt.Errorf("local address %q: %w", la.URI, err)
+	}
+
+	// Zero the port
+	addr.Port = 0
+
+	// Save it
+	la.Addr = addr
+
+	return nil
+}
+
+// UDPMech is a mechanism for UDP conduits.  Each datagram carries
+// exactly one write, so message boundaries are preserved.
+type UDPMech int
+
+// Dial opens a conduit in active mode; that is, for
+// connection-oriented transports, Dial causes initiation of a
+// connection.  For those transports that are not connection-oriented,
+// the conduit will still be in the appropriate state.
+func (m UDPMech) Dial(ctx context.Context, config Config, u *URI, opts []DialerOption) (*Conduit, error) {
+	// Select the source address and construct the dialer
+	if err := selectSources(opts, u.Host); err != nil {
+		return nil, err
+	}
+	dialer, err := mkDialerPatch(opts, udpFilter(0))
+	if err != nil {
+		return nil, err
+	}
+
+	// "Dial" the target; this just sets the default destination
+	c, err := dialer.DialContext(ctx, "udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct and return a Conduit
+	return &Conduit{
+		State:      Active,
+		LocalURI:   UDPAddr2URI(c.LocalAddr()),
+		RemoteURI:  u,
+		Link:       c,
+		Boundaries: true,
+	}, nil
+}
+
+// Listen opens a transport in passive mode; that is, for
+// connection-oriented transports, Listen creates a listener that may
+// accept connections.  For those transports that are not
+// connection-oriented, the listener synthesizes the appropriate
+// state.
+func (m UDPMech) Listen(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	// Construct the listener config
+	lc, err := mkListenConfigPatch(opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the packet connection
+	pc, err := lc.ListenPacket(ctx, "udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return a listener
+	l := newUDPListener(pc)
+	go l.readLoop()
+
+	return l, nil
+}
+
+// UDPListener is an implementation of Listener for the UDP transport.
+// Since UDP is not connection-oriented, the listener demultiplexes
+// incoming datagrams by remote address; the first datagram from a
+// previously unseen address causes a new Conduit to be returned from
+// Accept.
+type UDPListener struct {
+	sync.Mutex
+
+	PC  net.PacketConn // Underlying packet connection
+	URI *URI           // URI contains the URI of the listener
+
+	conns  map[string]*udpConn // Synthesized connections by address
+	stun   stunDemux           // Demultiplexes STUN responses
+	accept chan *udpConn       // Newly seen connections
+	done   chan struct{}       // Closed when the listener is closed
+	once   sync.Once           // Ensures done is closed only once
+}
+
+// newUDPListener constructs a UDPListener wrapping the specified
+// packet connection.  The caller is responsible for starting the
+// read loop.
+func newUDPListener(pc net.PacketConn) *UDPListener {
+	return &UDPListener{
+		PC:     pc,
+		URI:    UDPAddr2URI(pc.LocalAddr()),
+		conns:  map[string]*udpConn{},
+		accept: make(chan *udpConn, UDPQueueDepth),
+		done:   make(chan struct{}),
+	}
+}
+
+// readLoop reads datagrams from the packet connection and
+// demultiplexes them to the synthesized connections, or to the STUN
+// transactions awaiting them.  Hole punching probes are discarded.
+func (l *UDPListener) readLoop() {
+	defer l.shutdown()
+
+	buf := make([]byte, UDPMaxDatagram)
+	for {
+		n, addr, err := l.PC.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		// Copy the datagram
+		dgram := make([]byte, n)
+		copy(dgram, buf[:n])
+
+		if !l.stun.deliver(dgram) && !isSTUNIndication(dgram) {
+			l.deliver(addr, dgram)
+		}
+	}
+}
+
+// deliver delivers a datagram to the appropriate connection,
+// creating it if necessary.
+func (l *UDPListener) deliver(addr net.Addr, dgram []byte) {
+	l.Lock()
+	c, ok := l.conns[addr.String()]
+	if !ok {
+		c = newUDPConn(l, addr)
+		select {
+		case l.accept <- c:
+			l.conns[addr.String()] = c
+		default:
+			// Accept queue is full; drop the datagram
+			l.Unlock()
+			return
+		}
+	}
+	l.Unlock()
+
+	// Queue the datagram, dropping it if the queue is full
+	select {
+	case c.in <- dgram:
+	default:
+	}
+}
+
+// forget removes a connection from the demultiplexing map.
+func (l *UDPListener) forget(c *udpConn) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.conns[c.raddr.String()] == c {
+		delete(l.conns, c.raddr.String())
+	}
+}
+
+// shutdown marks the listener as done.
+func (l *UDPListener) shutdown() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+// Accept waits for and returns the next conduit to the listener.
+func (l *UDPListener) Accept() (*Conduit, error) {
+	select {
+	case c := <-l.accept:
+		return &Conduit{
+			State:      Passive,
+			LocalURI:   l.URI,
+			RemoteURI:  UDPAddr2URI(c.raddr),
+			Link:       c,
+			Boundaries: true,
+		}, nil
+
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.  Any blocked Accept operations will be
+// unblocked and return errors.
+func (l *UDPListener) Close() error {
+	l.shutdown()
+
+	return l.PC.Close()
+}
+
+// Addr returns the listener's network URI.
+func (l *UDPListener) Addr() *URI {
+	return l.URI
+}
+
+// stunResponses returns the demultiplexer delivering the STUN
+// responses received on the socket.
+func (l *UDPListener) stunResponses() *stunDemux {
+	return &l.stun
+}
+
+// stunWriteTo sends a STUN message from the socket.
+func (l *UDPListener) stunWriteTo(msg []byte, addr net.Addr) (int, error) {
+	return l.PC.WriteTo(msg, addr)
+}
+
+// punchDial opens a conduit in active mode from the socket to the
+// specified address.
+func (l *UDPListener) punchDial(ctx context.Context, config Config, raddr *net.UDPAddr) (*Conduit, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, ok := l.conns[raddr.String()]; ok {
+		return nil, fmt.Errorf("%s: %w", raddr, ErrPunchConflict)
+	}
+	c := newUDPConn(l, raddr)
+	l.conns[raddr.String()] = c
+
+	return &Conduit{
+		State:      Active,
+		LocalURI:   l.URI,
+		RemoteURI:  UDPAddr2URI(raddr),
+		Link:       c,
+		Boundaries: true,
+	}, nil
+}
+
+// udpConn is an implementation of net.Conn for a single remote
+// address on a UDP listener.
+type udpConn struct {
+	l     *UDPListener  // The listener
+	raddr net.Addr      // The remote address
+	in    chan []byte   // Incoming datagrams
+	done  chan struct{} // Closed when the connection is closed
+	once  sync.Once     // Ensures done is closed only once
+
+	rdeadline connDeadline // Read deadline
+	wdeadline connDeadline // Write deadline
+}
+
+// newUDPConn constructs a new udpConn.
+func newUDPConn(l *UDPListener, raddr net.Addr) *udpConn {
+	return &udpConn{
+		l:     l,
+		raddr: raddr,
+		in:    make(chan []byte, UDPQueueDepth),
+		done:  make(chan struct{}),
+	}
+}
+
+// Read reads a single datagram from the connection.  If the buffer
+// is too small, the excess is discarded.
+func (c *udpConn) Read(b []byte) (int, error) {
+	timeout := c.rdeadline.wait()
+	select {
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	select {
+	case dgram := <-c.in:
+		return copy(b, dgram), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.l.done:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write writes a single datagram to the remote address.  The packet
+// connection is shared with the other connections of the listener,
+// so its deadlines cannot be set; instead, the write fails if the
+// write deadline has passed when it starts.
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.wdeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	return c.l.PC.WriteTo(b, c.raddr)
+}
+
+// Close closes the connection.  The underlying packet connection is
+// shared, so it is left open.
+func (c *udpConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.l.forget(c)
+	})
+
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.l.PC.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *udpConn) SetDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+	c.wdeadline.set(t)
+
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read calls, including those
+// already blocked.
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.rdeadline.set(t)
+
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls.
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	c.wdeadline.set(t)
+
+	return nil
+}
+
+// connDeadline implements a deadline of a connection as a channel
+// which is closed once the deadline passes, so that blocked calls
+// may wait on it.  The channel is replaced whenever the deadline is
+// reset after having passed.  The zero value has no deadline.
+type connDeadline struct {
+	mu     sync.Mutex    // Protects the deadline
+	timer  *time.Timer   // Closes the channel when the deadline passes
+	cancel chan struct{} // Closed when the deadline has passed
+}
+
+// set sets the deadline; a zero time clears it.
+func (d *connDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Stop the timer, waiting for it to close the channel if it
+	// has already fired
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	// Replace the channel if the old deadline had passed
+	closed := false
+	if d.cancel != nil {
+		select {
+		case <-d.cancel:
+			closed = true
+		default:
+		}
+	}
+	if d.cancel == nil || closed {
+		d.cancel = make(chan struct{})
+	}
+	if t.IsZero() {
+		return
+	}
+
+	// Close the channel when the deadline passes
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.cancel)
+		return
+	}
+	cancel := d.cancel
+	d.timer = time.AfterFunc(dur, func() {
+		close(cancel)
+	})
+}
+
+// wait returns the channel which is closed when the deadline passes.
+func (d *connDeadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+
+	return d.cancel
+}
+
+// init initializes the UDP transport.
+func init() {
+	RegisterTransport("udp", UDPMech(0))
+}
@@ -0,0 +1,720 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockPacketConn struct {
+	mock.Mock
+}
+
+func (m *mockPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	args := m.MethodCalled("ReadFrom", p)
+
+	n := 0
+	if tmp := args.Get(0); tmp != nil {
+		n = copy(p, tmp.([]byte))
+	}
+	if tmp := args.Get(1); tmp != nil {
+		return n, tmp.(net.Addr), args.Error(2)
+	}
+
+	return n, nil, args.Error(2)
+}
+
+func (m *mockPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	args := m.MethodCalled("WriteTo", p, addr)
+
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockPacketConn) Close() error {
+	args := m.MethodCalled("Close")
+
+	return args.Error(0)
+}
+
+func (m *mockPacketConn) LocalAddr() net.Addr {
+	args := m.MethodCalled("LocalAddr")
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.(net.Addr)
+	}
+
+	return nil
+}
+
+func (m *mockPacketConn) SetDeadline(t time.Time) error {
+	args := m.MethodCalled("SetDeadline", t)
+
+	return args.Error(0)
+}
+
+func (m *mockPacketConn) SetReadDeadline(t time.Time) error {
+	args := m.MethodCalled("SetReadDeadline", t)
+
+	return args.Error(0)
+}
+
+func (m *mockPacketConn) SetWriteDeadline(t time.Time) error {
+	args := m.MethodCalled("SetWriteDeadline", t)
+
+	return args.Error(0)
+}
+
+func TestUDPAddr2URI(t *testing.T) {
+	addr := &mockAddr{}
+	addr.On("String").Return("127.0.0.1:1234")
+
+	result := UDPAddr2URI(addr)
+
+	assert.Equal(t, &URI{
+		URL: url.URL{
+			Scheme: "udp",
+			Host:   "127.0.0.1:1234",
+		},
+		Transport: "udp",
+	}, result)
+	addr.AssertExpectations(t)
+}
+
+func TestUDPFilterImplementsDialerFilter(t *testing.T) {
+	assert.Implements(t, (*dialerFilter)(nil), udpFilter(0))
+}
+
+func TestUDPFilterDialFilterBase(t *testing.T) {
+	opt := &mockDialerOption{}
+	obj := udpFilter(0)
+
+	err := obj.DialFilter(opt)
+
+	assert.NoError(t, err)
+	opt.AssertExpectations(t)
+}
+
+func TestUDPFilterDialFilterLocalAddr(t *testing.T) {
+	opt := &LocalAddrOption{
+		URI: &URI{
+			URL: url.URL{
+				Scheme: "udp",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "udp",
+		},
+	}
+	obj := udpFilter(0)
+
+	err := obj.DialFilter(opt)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &net.UDPAddr{
+		IP: loopback,
+	}, opt.Addr)
+}
+
+func TestUDPFilterDialFilterLocalAddrNonCanonical(t *testing.T) {
+	opt := &LocalAddrOption{
+		URI: &URI{
+			URL: url.URL{
+				Scheme: "udp.srv",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "udp",
+			Discovery: "srv",
+		},
+	}
+	obj := udpFilter(0)
+
+	err := obj.DialFilter(opt)
+
+	assert.ErrorIs(t, err, ErrNotCanonical)
+	assert.Nil(t, opt.Addr)
+}
+
+func TestUDPFilterDialFilterLocalAddrBadTransport(t *testing.T) {
+	opt := &LocalAddrOption{
+		URI: &URI{
+			URL: url.URL{
+				Scheme: "tcp",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "tcp",
+		},
+	}
+	obj := udpFilter(0)
+
+	err := obj.DialFilter(opt)
+
+	assert.ErrorIs(t, err, ErrUnknownTransport)
+	assert.Nil(t, opt.Addr)
+}
+
+func TestUDPFilterDialFilterLocalAddrBadAddress(t *testing.T) {
+	opt := &LocalAddrOption{
+		URI: &URI{
+			URL: url.URL{
+				Scheme: "udp",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "udp",
+		},
+	}
+	obj := udpFilter(0)
+	defer patcher.SetVar(&resolveUDPAddr, func(network, address string) (*net.UDPAddr, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	err := obj.DialFilter(opt)
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, opt.Addr)
+}
+
+func TestUDPMechImplementsMechanism(t *testing.T) {
+	assert.Implements(t, (*Mechanism)(nil), UDPMech(0))
+}
+
+func TestUDPMechDialBase(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	opt := &mockDialerOption{}
+	conn := &mockConn{}
+	addr := &mockAddr{}
+	dialer := &mockDialer{}
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:4321",
+		},
+	}
+	obj := UDPMech(0)
+	addr.On("String").Return("127.0.0.1:1234")
+	conn.On("LocalAddr").Return(addr)
+	dialer.On("DialContext", ctx, "udp", "127.0.0.1:4321").Return(conn, nil)
+	defer patcher.SetVar(&mkDialerPatch, func(opts []DialerOption, filt dialerFilter) (iDialer, error) {
+		assert.Equal(t, []DialerOption{opt}, opts)
+		assert.Equal(t, udpFilter(0), filt)
+		return dialer, nil
+	}).Install().Restore()
+
+	result, err := obj.Dial(ctx, cfg, u, []DialerOption{opt})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Conduit{
+		State: Active,
+		LocalURI: &URI{
+			URL: url.URL{
+				Scheme: "udp",
+				Host:   "127.0.0.1:1234",
+			},
+			Transport: "udp",
+		},
+		RemoteURI:  u,
+		Link:       conn,
+		Boundaries: true,
+	}, result)
+	addr.AssertExpectations(t)
+	conn.AssertExpectations(t)
+	dialer.AssertExpectations(t)
+}
+
+func TestUDPMechDialMkDialerError(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:4321",
+		},
+	}
+	obj := UDPMech(0)
+	defer patcher.SetVar(&mkDialerPatch, func(opts []DialerOption, filt dialerFilter) (iDialer, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Dial(ctx, cfg, u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestUDPMechDialError(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	dialer := &mockDialer{}
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:4321",
+		},
+	}
+	obj := UDPMech(0)
+	dialer.On("DialContext", ctx, "udp", "127.0.0.1:4321").Return(nil, assert.AnError)
+	defer patcher.SetVar(&mkDialerPatch, func(opts []DialerOption, filt dialerFilter) (iDialer, error) {
+		return dialer, nil
+	}).Install().Restore()
+
+	result, err := obj.Dial(ctx, cfg, u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	dialer.AssertExpectations(t)
+}
+
+func TestUDPMechListenBase(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	opt := &mockListenerOption{}
+	pc := &mockPacketConn{}
+	addr := &mockAddr{}
+	lc := &mockListenConfig{}
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:1234",
+		},
+	}
+	obj := UDPMech(0)
+	addr.On("String").Return("127.0.0.1:1234")
+	pc.On("LocalAddr").Return(addr)
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, assert.AnError)
+	lc.On("ListenPacket", ctx, "udp", "127.0.0.1:1234").Return(pc, nil)
+	defer patcher.SetVar(&mkListenConfigPatch, func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error) {
+		assert.Equal(t, []ListenerOption{opt}, opts)
+		assert.Nil(t, filt)
+		return lc, nil
+	}).Install().Restore()
+
+	result, err := obj.Listen(ctx, cfg, u, []ListenerOption{opt})
+
+	assert.NoError(t, err)
+	l, ok := result.(*UDPListener)
+	assert.True(t, ok)
+	assert.Same(t, pc, l.PC)
+	assert.Equal(t, &URI{
+		URL: url.URL{
+			Scheme: "udp",
+			Host:   "127.0.0.1:1234",
+		},
+		Transport: "udp",
+	}, l.URI)
+	<-l.done
+	lc.AssertExpectations(t)
+}
+
+func TestUDPMechListenMkListenConfigError(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:1234",
+		},
+	}
+	obj := UDPMech(0)
+	defer patcher.SetVar(&mkListenConfigPatch, func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := obj.Listen(ctx, cfg, u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestUDPMechListenError(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	lc := &mockListenConfig{}
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:1234",
+		},
+	}
+	obj := UDPMech(0)
+	lc.On("ListenPacket", ctx, "udp", "127.0.0.1:1234").Return(nil, assert.AnError)
+	defer patcher.SetVar(&mkListenConfigPatch, func(opts []ListenerOption, filt listenerFilter) (iListenConfig, error) {
+		return lc, nil
+	}).Install().Restore()
+
+	result, err := obj.Listen(ctx, cfg, u, nil)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+	lc.AssertExpectations(t)
+}
+
+func newTestUDPListener() (*UDPListener, *mockPacketConn) {
+	pc := &mockPacketConn{}
+	addr := &mockAddr{}
+	addr.On("String").Return("127.0.0.1:1234")
+	pc.On("LocalAddr").Return(addr)
+
+	return newUDPListener(pc), pc
+}
+
+func TestUDPListenerImplementsListener(t *testing.T) {
+	assert.Implements(t, (*Listener)(nil), &UDPListener{})
+}
+
+func TestUDPListenerReadLoop(t *testing.T) {
+	obj, pc := newTestUDPListener()
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+	pc.On("ReadFrom", mock.Anything).Return([]byte("one"), raddr, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return([]byte("two"), raddr, nil).Once()
+	pc.On("ReadFrom", mock.Anything).Return(nil, nil, assert.AnError)
+
+	obj.readLoop()
+
+	assert.Len(t, obj.conns, 1)
+	c := obj.conns[raddr.String()]
+	assert.Equal(t, []byte("one"), <-c.in)
+	assert.Equal(t, []byte("two"), <-c.in)
+	assert.Same(t, c, <-obj.accept)
+	select {
+	case <-obj.done:
+	default:
+		t.Error("listener not marked done")
+	}
+}
+
+func TestUDPListenerDeliverAcceptFull(t *testing.T) {
+	obj, _ := newTestUDPListener()
+	obj.accept = make(chan *udpConn)
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+
+	obj.deliver(raddr, []byte("data"))
+
+	assert.Len(t, obj.conns, 0)
+}
+
+func TestUDPListenerDeliverQueueFull(t *testing.T) {
+	obj, _ := newTestUDPListener()
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+	c := newUDPConn(obj, raddr)
+	c.in = make(chan []byte)
+	obj.conns[raddr.String()] = c
+
+	obj.deliver(raddr, []byte("data"))
+
+	assert.Len(t, obj.accept, 0)
+}
+
+func TestUDPListenerAcceptBase(t *testing.T) {
+	obj, _ := newTestUDPListener()
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+	c := newUDPConn(obj, raddr)
+	obj.accept <- c
+
+	result, err := obj.Accept()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Conduit{
+		State:    Passive,
+		LocalURI: obj.URI,
+		RemoteURI: &URI{
+			URL: url.URL{
+				Scheme: "udp",
+				Host:   "127.0.0.1:4321",
+			},
+			Transport: "udp",
+		},
+		Link:       c,
+		Boundaries: true,
+	}, result)
+}
+
+func TestUDPListenerAcceptClosed(t *testing.T) {
+	obj, _ := newTestUDPListener()
+	obj.shutdown()
+
+	result, err := obj.Accept()
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, result)
+}
+
+func TestUDPListenerClose(t *testing.T) {
+	obj, pc := newTestUDPListener()
+	pc.On("Close").Return(assert.AnError)
+
+	err := obj.Close()
+
+	assert.Same(t, assert.AnError, err)
+	select {
+	case <-obj.done:
+	default:
+		t.Error("listener not marked done")
+	}
+	pc.AssertExpectations(t)
+}
+
+func TestUDPListenerAddr(t *testing.T) {
+	obj, _ := newTestUDPListener()
+
+	result := obj.Addr()
+
+	assert.Same(t, obj.URI, result)
+}
+
+func TestUDPConnImplementsConn(t *testing.T) {
+	assert.Implements(t, (*net.Conn)(nil), &udpConn{})
+}
+
+func TestUDPConnReadBase(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.in <- []byte("datagram")
+	buf := make([]byte, 4)
+
+	n, err := obj.Read(buf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []byte("data"), buf)
+}
+
+func TestUDPConnReadClosed(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.Close()
+
+	n, err := obj.Read(make([]byte, 4))
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Equal(t, 0, n)
+}
+
+func TestUDPConnReadListenerClosed(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	l.shutdown()
+
+	n, err := obj.Read(make([]byte, 4))
+
+	assert.Same(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestUDPConnReadDeadlinePassed(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.SetReadDeadline(time.Now().Add(-time.Second))
+
+	n, err := obj.Read(make([]byte, 4))
+
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Equal(t, 0, n)
+}
+
+func TestUDPConnReadDeadlineExpires(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.SetDeadline(time.Now().Add(10 * time.Millisecond))
+
+	n, err := obj.Read(make([]byte, 4))
+
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Equal(t, 0, n)
+}
+
+func TestUDPConnWriteBase(t *testing.T) {
+	l, pc := newTestUDPListener()
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+	obj := newUDPConn(l, raddr)
+	pc.On("WriteTo", []byte("data"), raddr).Return(4, nil)
+
+	n, err := obj.Write([]byte("data"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	pc.AssertExpectations(t)
+}
+
+func TestUDPConnWriteClosed(t *testing.T) {
+	l, pc := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.Close()
+
+	n, err := obj.Write([]byte("data"))
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Equal(t, 0, n)
+	pc.AssertNotCalled(t, "WriteTo", mock.Anything, mock.Anything)
+}
+
+func TestUDPConnClose(t *testing.T) {
+	l, _ := newTestUDPListener()
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+	obj := newUDPConn(l, raddr)
+	l.conns[raddr.String()] = obj
+
+	err := obj.Close()
+	err2 := obj.Close()
+
+	assert.NoError(t, err)
+	assert.NoError(t, err2)
+	assert.Len(t, l.conns, 0)
+}
+
+func TestUDPConnAddrs(t *testing.T) {
+	l, pc := newTestUDPListener()
+	raddr := &net.UDPAddr{IP: loopback, Port: 4321}
+	obj := newUDPConn(l, raddr)
+
+	assert.Equal(t, pc.LocalAddr(), obj.LocalAddr())
+	assert.Same(t, raddr, obj.RemoteAddr())
+}
+
+func TestUDPConnReadDeadlineWakes(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	errs := make(chan error)
+	go func() {
+		_, err := obj.Read(make([]byte, 4))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	err := obj.SetReadDeadline(time.Now())
+
+	assert.NoError(t, err)
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("Read was not woken by the deadline")
+	}
+}
+
+func TestUDPConnReadDeadlineCleared(t *testing.T) {
+	l, _ := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.SetReadDeadline(time.Now().Add(-time.Second))
+	obj.SetReadDeadline(time.Time{})
+	obj.in <- []byte("data")
+
+	n, err := obj.Read(make([]byte, 4))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
+
+func TestUDPConnWriteDeadlinePassed(t *testing.T) {
+	l, pc := newTestUDPListener()
+	obj := newUDPConn(l, &net.UDPAddr{IP: loopback, Port: 4321})
+	obj.SetDeadline(time.Now().Add(-time.Second))
+
+	n, err := obj.Write([]byte("data"))
+
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Equal(t, 0, n)
+	pc.AssertNotCalled(t, "WriteTo", mock.Anything, mock.Anything)
+}
+
+func TestUDPConnSetWriteDeadline(t *testing.T) {
+	obj := &udpConn{}
+
+	err := obj.SetWriteDeadline(time.Now().Add(-time.Second))
+
+	assert.NoError(t, err)
+	assert.Nil(t, obj.rdeadline.cancel)
+	select {
+	case <-obj.wdeadline.wait():
+	default:
+		t.Error("write deadline has not passed")
+	}
+}
+
+func TestConnDeadlineZero(t *testing.T) {
+	obj := &connDeadline{}
+
+	ch := obj.wait()
+
+	select {
+	case <-ch:
+		t.Error("deadline has passed")
+	default:
+	}
+}
+
+func TestConnDeadlineExpires(t *testing.T) {
+	obj := &connDeadline{}
+
+	obj.set(time.Now().Add(10 * time.Millisecond))
+
+	select {
+	case <-obj.wait():
+	case <-time.After(time.Second):
+		t.Fatal("deadline did not pass")
+	}
+}
+
+func TestConnDeadlineExtended(t *testing.T) {
+	obj := &connDeadline{}
+	obj.set(time.Now().Add(10 * time.Millisecond))
+	ch := obj.wait()
+
+	obj.set(time.Now().Add(time.Hour))
+
+	assert.Equal(t, ch, obj.wait())
+	select {
+	case <-ch:
+		t.Error("deadline has passed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConnDeadlineReset(t *testing.T) {
+	obj := &connDeadline{}
+	obj.set(time.Now().Add(-time.Second))
+	ch := obj.wait()
+
+	obj.set(time.Time{})
+
+	assert.NotEqual(t, ch, obj.wait())
+	select {
+	case <-obj.wait():
+		t.Error("deadline has passed")
+	default:
+	}
+}
+
+func TestUDPMechDialSelectSourceError(t *testing.T) {
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	local, _ := Parse("udp://[2001:db8::1]:0")
+	u := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:4321",
+		},
+	}
+	obj := UDPMech(0)
+	defer patcher.SetVar(&mkDialerPatch, func(opts []DialerOption, filt dialerFilter) (iDialer, error) {
+		panic("unexpected call")
+	}).Install().Restore()
+
+	result, err := obj.Dial(ctx, cfg, u, []DialerOption{LocalAddrs(nil, local)})
+
+	assert.ErrorIs(t, err, ErrNoSourceAddr)
+	assert.Nil(t, result)
+}
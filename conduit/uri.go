@@ -21,6 +21,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // URI describes a full Humboldt conduit URI.  It is a variation on
@@ -33,13 +34,76 @@ type URI struct {
 	Discovery string // The discovery mechanism identifier
 }
 
+// escapeZone escapes the IPv6 zone identifier delimiter in the host
+// of a raw URI.  RFC 6874 requires the delimiter to be written as
+// "%25", but addresses are commonly written with a bare "%", as in
+// "tcp://[fe80::1%eth0]:1234"; the bare form is accepted by escaping
+// it before parsing.
+func escapeZone(rawuri string) string {
+	// Find the bracketed host in the authority
+	start := strings.Index(rawuri, "//")
+	if start < 0 {
+		return rawuri
+	}
+	authority := rawuri[start+2:]
+	if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+		authority = authority[:end]
+	}
+	open := strings.Index(authority, "[")
+	closing := strings.Index(authority, "]")
+	if open < 0 || closing < open {
+		return rawuri
+	}
+
+	// Escape the delimiter if necessary
+	host := authority[open:closing]
+	idx := strings.Index(host, "%")
+	if idx < 0 || strings.HasPrefix(host[idx:], "%25") {
+		return rawuri
+	}
+	idx += start + 2 + open
+
+	return rawuri[:idx] + "%25" + rawuri[idx+1:]
+}
+
+// splitZone splits an IP address into the address and its IPv6 zone
+// identifier, if any.
+func splitZone(host string) (string, string) {
+	addr, zone, _ := strings.Cut(host, "%")
+
+	return addr, zone
+}
+
+// parseIP parses a host as an IP address, which may include an IPv6
+// zone identifier, as in "fe80::1%eth0".  It returns the address and
+// the zone, or nil if the host is not a valid IP address.
+func parseIP(host string) (net.IP, string) {
+	addr, zone := splitZone(host)
+	ip := net.ParseIP(addr)
+	if ip == nil || (zone == "" && addr != host) || (zone != "" && ip.To4() != nil) {
+		return nil, ""
+	}
+
+	return ip, zone
+}
+
+// joinZone joins an IP address and an IPv6 zone identifier.
+func joinZone(ip net.IP, zone string) string {
+	if zone == "" {
+		return ip.String()
+	}
+
+	return ip.String() + "%" + zone
+}
+
 // Parse parses a raw URL into a conduit URI.  It is based on
-// url.Parse.
+// url.Parse.  IPv6 zone identifiers may be given with either a bare
+// or an escaped delimiter.
 func Parse(rawuri string) (*URI, error) {
 	result := &URI{}
 
 	// Begin by parsing the URL
-	tmpURL, err := url.Parse(rawuri)
+	tmpURL, err := url.Parse(escapeZone(rawuri))
 	if err != nil {
 		return nil, err
 	}
@@ -62,11 +126,28 @@ func Parse(rawuri string) (*URI, error) {
 	return result, nil
 }
 
+// literalHost is implemented by transport mechanisms whose URIs name
+// something other than network addresses in their hosts, such as the
+// relay transport.  Such hosts are canonical as given.
+type literalHost interface {
+	literalHost()
+}
+
+// hasLiteralHost tests whether the host of the URI is literal; that
+// is, whether its transport mechanism implements literalHost.
+func (u *URI) hasLiteralHost() bool {
+	_, ok := LookupTransport(u.Transport).(literalHost)
+
+	return ok
+}
+
 // IsCanonical tests if the conduit URI is canonical.  To be
 // canonical, no discovery mechanism may be specified, and the host
-// must be a raw IP address and the port must be numeric.  (If there
-// is no Host in the URI, the URI is canonical unless a discovery
-// mechanism was specified.)
+// must be a raw IP address, optionally with an IPv6 zone identifier,
+// and the port must be numeric.  (If there is no Host in the URI, or
+// the host does not name a network address, as for the relay
+// transport, the URI is canonical unless a discovery mechanism was
+// specified.)
 func (u *URI) IsCanonical() bool {
 	// If there's a discovery mechanism, the URI is not canonical
 	if u.Discovery != "" {
@@ -74,7 +155,7 @@ func (u *URI) IsCanonical() bool {
 	}
 
 	// If there's no host information, the URI is canonical
-	if u.Host == "" {
+	if u.Host == "" || u.hasLiteralHost() {
 		return true
 	}
 
@@ -91,30 +172,174 @@ func (u *URI) IsCanonical() bool {
 	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
 		return false
 	}
-	if net.ParseIP(host) == nil {
+	if ip, _ := parseIP(host); ip == nil {
 		return false
 	}
 
 	return true
 }
 
+// normalizeHost normalizes the host and port portion of a URI.  The
+// host name is lowercased and stripped of any trailing dot, IP
+// addresses are reduced to their shortest form, numeric ports are
+// stripped of leading zeros, and an empty port is removed.
+func normalizeHost(hostport string) string {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+	}
+
+	// Normalize the address, preserving any IPv6 zone
+	addr := host
+	if ip, zone := parseIP(host); ip != nil {
+		addr = joinZone(ip, zone)
+	} else {
+		addr = strings.TrimSuffix(strings.ToLower(addr), ".")
+	}
+
+	// Normalize the port
+	if n, err := strconv.ParseUint(port, 10, 16); err == nil {
+		port = strconv.FormatUint(n, 10)
+	}
+	if port != "" {
+		return net.JoinHostPort(addr, port)
+	}
+	if strings.Contains(addr, ":") {
+		return "[" + addr + "]"
+	}
+
+	return addr
+}
+
+// Normalize returns a normalized copy of the URI, suitable for
+// comparison.  The scheme is lowercased, the host and port are
+// normalized, and the query parameters are sorted by key.  Two URIs
+// that normalize to the same URI refer to the same endpoint.
+func (u *URI) Normalize() *URI {
+	result := *u
+	if u.User != nil {
+		user := *u.User
+		result.User = &user
+	}
+	result.Scheme = strings.ToLower(u.Scheme)
+	result.Transport = strings.ToLower(u.Transport)
+	result.Security = strings.ToLower(u.Security)
+	result.Discovery = strings.ToLower(u.Discovery)
+	if u.Host != "" {
+		result.Host = normalizeHost(u.Host)
+	}
+	if u.RawQuery != "" {
+		if query, err := url.ParseQuery(u.RawQuery); err == nil {
+			result.RawQuery = query.Encode()
+		}
+	}
+
+	return &result
+}
+
+// Key returns a string identifying the URI, suitable for use as a
+// map key.  URIs which are Equal have the same key.
+func (u *URI) Key() string {
+	return u.Normalize().String()
+}
+
+// Equal tests if two URIs refer to the same endpoint; that is, if
+// they are the same after normalization.  A nil URI is equal only to
+// another nil URI.
+func (u *URI) Equal(other *URI) bool {
+	if u == nil || other == nil {
+		return u == other
+	}
+
+	return u.Key() == other.Key()
+}
+
 // Canonicalize canonicalizes a conduit URI.  It returns a list of
 // conduit URIs, as it will call discovery mechanisms and include all
 // known IPs for a given hostname.
 func (u *URI) Canonicalize() ([]*URI, error) {
-	// If there's a discovery mechanism, look it up and call it
-	if u.Discovery != "" {
-		disc, ok := discMechs[u.Discovery]
-		if !ok {
-			return nil, fmt.Errorf("%q: %w", u.Discovery, ErrUnknownDiscovery)
-		}
+	return u.CanonicalizeContext(context.Background(), nil)
+}
+
+// CanonicalizeContext canonicalizes a conduit URI in the same way as
+// Canonicalize.  Host and service names are looked up with the
+// specified resolver; if it is nil, the resolver carried by the
+// context is used, as returned by ContextResolver.  The context is
+// passed to the resolver and to the discovery mechanism, which may
+// retrieve the resolver with ContextResolver, allowing the lookups
+// to be cancelled or time-bounded, and the canonicalization is
+// traced within it.  If the context carries a Cache, as set by
+// WithCache, the result is cached.
+func (u *URI) CanonicalizeContext(ctx context.Context, r HostResolver) ([]*URI, error) {
+	res, err := u.ResolveContext(ctx, r)
+
+	return resolutionURIs(res), err
+}
+
+// Resolve canonicalizes a conduit URI in the same way as
+// Canonicalize, but returns resolutions describing where each
+// canonical URI came from.
+func (u *URI) Resolve() ([]*Resolution, error) {
+	return u.ResolveContext(context.Background(), nil)
+}
+
+// ResolveContext canonicalizes a conduit URI in the same way as
+// CanonicalizeContext, but returns resolutions describing where each
+// canonical URI came from.
+func (u *URI) ResolveContext(ctx context.Context, r HostResolver) ([]*Resolution, error) {
+	if r != nil {
+		ctx = WithResolver(ctx, r)
+	}
+	ctx, span := startSpan(ctx, "canonicalize", u)
+	var res []*Resolution
+	var err error
+	if c := ContextCache(ctx); c != nil {
+		res, err = c.lookup(ctx, u)
+	} else {
+		res, err = u.resolve(ctx)
+	}
+	span.SetAttributes(AttrResults.Int(len(res)))
+	endSpan(span, err)
+	logResult("canonicalize", err, append(uriArgs(u), "results", logResolutions(res))...)
+
+	return res, err
+}
+
+// discover canonicalizes a conduit URI using its discovery
+// mechanism.
+func (u *URI) discover(ctx context.Context) ([]*Resolution, error) {
+	disc := LookupDiscovery(u.Discovery)
+	if disc == nil {
+		return nil, fmt.Errorf("%q: %w", u.Discovery, ErrUnknownDiscovery)
+	}
+	ctx, span := startSpan(ctx, "discover", u)
+
+	var res []*Resolution
+	var err error
+	if r, ok := disc.(Resolver); ok {
+		res, err = r.Resolve(ctx, u)
+	} else {
+		var uris []*URI
+		uris, err = disc.Discover(ctx, u)
+		res = discoveryResolutions(u.Discovery, uris)
+	}
+	span.SetAttributes(AttrResults.Int(len(res)))
+	endSpan(span, err)
+	logResult("discover", err, append(uriArgs(u), "count", len(res))...)
+
+	return res, err
+}
 
-		return disc.Discover(u)
+// resolve implements Resolve.
+func (u *URI) resolve(ctx context.Context) ([]*Resolution, error) {
+	// If there's a discovery mechanism, call it
+	if u.Discovery != "" {
+		return u.discover(ctx)
 	}
 
 	// If there's no host information, then the URI is canonical
-	if u.Host == "" {
-		return []*URI{u}, nil
+	if u.Host == "" || u.hasLiteralHost() {
+		return []*Resolution{{URI: u, Source: SourceLiteral}}, nil
 	}
 
 	// Split the host and port; use the net.SplitHostPort function
@@ -125,21 +350,26 @@ func (u *URI) Canonicalize() ([]*URI, error) {
 		return nil, err
 	}
 
-	// Build up the list of IPs
-	ips := []net.IP{}
-	if ip := net.ParseIP(host); ip != nil {
-		ips = append(ips, ip)
+	// Build up the list of addresses
+	addrs := []string{}
+	source, name := SourceLiteral, ""
+	if ip, zone := parseIP(host); ip != nil {
+		addrs = append(addrs, joinZone(ip, zone))
 	} else {
-		var err error
-		if ips, err = lookupIP(host); err != nil {
+		ips, err := ContextResolver(ctx).LookupIP(ctx, "ip", host)
+		if err != nil {
 			return nil, err
 		}
+		for _, ip := range ips {
+			addrs = append(addrs, ip.String())
+		}
+		source, name = SourceDNS, host
 	}
 
 	// Determine the canonical port
 	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
 		// Must be a service name
-		numPort, err := lookupPort(u.Transport, port)
+		numPort, err := ContextResolver(ctx).LookupPort(ctx, u.Transport, port)
 		if err != nil {
 			return nil, err
 		}
@@ -147,14 +377,14 @@ func (u *URI) Canonicalize() ([]*URI, error) {
 	}
 
 	// Assemble the list of URIs
-	URIs := make([]*URI, 0, len(ips))
-	for _, ip := range ips {
-		URIs = append(URIs, &URI{
+	res := make([]*Resolution, 0, len(addrs))
+	for _, addr := range addrs {
+		uri := &URI{
 			URL: url.URL{
 				Scheme:     u.Scheme,
 				Opaque:     u.Opaque,
 				User:       u.User,
-				Host:       net.JoinHostPort(ip.String(), port),
+				Host:       net.JoinHostPort(addr, port),
 				Path:       u.Path,
 				RawPath:    u.RawPath,
 				ForceQuery: u.ForceQuery,
@@ -164,49 +394,113 @@ func (u *URI) Canonicalize() ([]*URI, error) {
 			Transport: u.Transport,
 			Security:  u.Security,
 			Discovery: u.Discovery,
-		})
+		}
+		res = append(res, &Resolution{URI: uri, Source: source, Name: name})
+	}
+
+	return res, nil
+}
+
+// securityURI returns a copy of a transport URI with the named
+// security layer added to its scheme.  It is used by security layer
+// mechanisms to describe the conduits and listeners they wrap.
+func securityURI(u *URI, name string) *URI {
+	if u == nil || u.Security != "" {
+		return u
+	}
+	result := *u
+	result.Security = name
+	result.Scheme = result.Transport + "+" + name
+	if result.Discovery != "" {
+		result.Scheme += "." + result.Discovery
 	}
 
-	return URIs, nil
+	return &result
 }
 
 // Dial opens a conduit in active mode; that is, for
 // connection-oriented transports, Dial causes initiation of a
 // connection.  For those transports that are not connection-oriented,
-// the conduit will still be in the appropriate state.
+// the conduit will still be in the appropriate state.  Options given
+// by the query parameters of the URI, as returned by DialOptions,
+// are applied after the passed options.
 func (u *URI) Dial(ctx context.Context, config Config, opts ...DialerOption) (*Conduit, error) {
+	ctx, span := startSpan(ctx, "dial", u)
+	c, err := u.dial(ctx, config, opts)
+	endSpan(span, err)
+	logResult("dial", err, uriArgs(u)...)
+
+	return c, err
+}
+
+// dial implements Dial.
+func (u *URI) dial(ctx context.Context, config Config, opts []DialerOption) (*Conduit, error) {
 	if !u.IsCanonical() {
 		return nil, fmt.Errorf("%s: %w", u, ErrNotCanonical)
 	}
+	uriOpts, err := u.DialOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], uriOpts...)
 
 	// Make sure there's a transport mechanism
 	if u.Transport == "" {
 		return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
 	}
+	defer TraceSlow(SlowDial, nil, u.String())()
 
 	// Is there a security layer?
+	var c *Conduit
 	if u.Security != "" {
-		if mech := lookupSecurity(u.Security); mech != nil {
-			return mech.Dial(ctx, config, u, opts)
+		mech := lookupSecurity(u.Security)
+		if mech == nil {
+			return nil, fmt.Errorf("%s: %q: %w", u, u.Security, ErrUnknownSecurity)
+		}
+		c, err = mech.Dial(ctx, config, u, opts)
+	} else {
+		mech := lookupTransport(u.Transport)
+		if mech == nil {
+			return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
 		}
-		return nil, fmt.Errorf("%s: %q: %w", u, u.Security, ErrUnknownSecurity)
+		c, err = dialTransport(ctx, mech, config, u, opts)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if mech := lookupTransport(u.Transport); mech != nil {
-		return mech.Dial(ctx, config, u, opts)
+	// Apply the negotiation timeout
+	if nt := findOption[NegotiateTimeout](opts); nt != nil {
+		c.NegotiateTimeout = time.Duration(*nt)
 	}
-	return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
+
+	return c, nil
 }
 
 // Listen opens a transport in passive mode; that is, for
 // connection-oriented transports, Listen creates a listener that may
 // accept connections.  For those transports that are not
 // connection-oriented, the listener synthesizes the appropriate
-// state.
+// state.  Options given by the query parameters of the URI, as
+// returned by ListenOptions, are applied after the passed options.
 func (u *URI) Listen(ctx context.Context, config Config, opts ...ListenerOption) (Listener, error) {
+	ctx, span := startSpan(ctx, "listen", u)
+	l, err := u.listen(ctx, config, opts)
+	endSpan(span, err)
+
+	return l, err
+}
+
+// listen implements Listen.
+func (u *URI) listen(ctx context.Context, config Config, opts []ListenerOption) (Listener, error) {
 	if !u.IsCanonical() {
 		return nil, fmt.Errorf("%s: %w", u, ErrNotCanonical)
 	}
+	uriOpts, err := u.ListenOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], uriOpts...)
 
 	// Make sure there's a transport mechanism
 	if u.Transport == "" {
@@ -214,17 +508,41 @@ func (u *URI) Listen(ctx context.Context, config Config, opts ...ListenerOption)
 	}
 
 	// Is there a security layer?
+	var l Listener
 	if u.Security != "" {
-		if mech := lookupSecurity(u.Security); mech != nil {
-			return mech.Listen(ctx, config, u, opts)
+		mech := lookupSecurity(u.Security)
+		if mech == nil {
+			return nil, fmt.Errorf("%s: %q: %w", u, u.Security, ErrUnknownSecurity)
 		}
-		return nil, fmt.Errorf("%s: %q: %w", u, u.Security, ErrUnknownSecurity)
+		opts = append(handshakeDefaults(), opts...)
+		l, err = mech.Listen(ctx, config, u, opts)
+	} else {
+		l, err = listenTransport(ctx, config, u, opts)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return handshakeListen(l, opts), nil
+}
 
-	if mech := lookupTransport(u.Transport); mech != nil {
-		return mech.Listen(ctx, config, u, opts)
+// listenTransport opens a listener using the transport mechanism of
+// the URI, applying any AcceptFilter, SourceRate, AcceptRate,
+// SniffOption, and HandshakeTimeout options.  It is used by Listen
+// and by the security layer mechanisms, so that conduits are filtered
+// and sniffed before any security layer handshake.
+func listenTransport(ctx context.Context, config Config, u *URI, opts []ListenerOption) (Listener, error) {
+	mech := lookupTransport(u.Transport)
+	if mech == nil {
+		return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
+	}
+
+	l, err := mech.Listen(ctx, config, u, opts)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("%s: %q: %w", u, u.Transport, ErrUnknownTransport)
+
+	return deadlineListen(sniffListen(rateListen(filterListen(l, opts), opts), opts), opts), nil
 }
 
 // Dial opens a conduit in active mode; that is, for
@@ -19,9 +19,12 @@ import (
 	"net"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/klmitch/patcher"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseBase(t *testing.T) {
@@ -79,6 +82,23 @@ func TestParsePathological(t *testing.T) {
 	assert.Equal(t, "d2", result.Discovery)
 }
 
+func TestParseZone(t *testing.T) {
+	result, err := Parse("tcp://[fe80::1%eth0]:1234")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp", result.Scheme)
+	assert.Equal(t, "[fe80::1%eth0]:1234", result.Host)
+	assert.Equal(t, "fe80::1%eth0", result.Hostname())
+	assert.Equal(t, "tcp://[fe80::1%25eth0]:1234", result.String())
+}
+
+func TestParseZoneEscaped(t *testing.T) {
+	result, err := Parse("tcp://[fe80::1%25eth0]:1234")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[fe80::1%eth0]:1234", result.Host)
+}
+
 func TestParseError(t *testing.T) {
 	result, err := Parse("://127.0.0.1:1234")
 
@@ -152,6 +172,43 @@ func TestURIIsCanonicalHostName(t *testing.T) {
 	assert.False(t, result)
 }
 
+func TestURIIsCanonicalZone(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "[fe80::1%eth0]:1234",
+		},
+	}
+
+	result := obj.IsCanonical()
+
+	assert.True(t, result)
+}
+
+func TestURIIsCanonicalZoneIPv4(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1%eth0:1234",
+		},
+	}
+
+	result := obj.IsCanonical()
+
+	assert.False(t, result)
+}
+
+func TestURIIsCanonicalLiteralHost(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "01",
+		},
+		Transport: "relay",
+	}
+
+	result := obj.IsCanonical()
+
+	assert.True(t, result)
+}
+
 func TestURICanonicalizeBase(t *testing.T) {
 	obj := &URI{}
 
@@ -163,6 +220,22 @@ func TestURICanonicalizeBase(t *testing.T) {
 	}, result)
 }
 
+func TestURICanonicalizeLiteralHost(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "01",
+		},
+		Transport: "relay",
+	}
+
+	result, err := obj.Canonicalize()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{
+		obj,
+	}, result)
+}
+
 func TestURICanonicalizeDiscoveryMissing(t *testing.T) {
 	obj := &URI{
 		Discovery: "missing",
@@ -180,7 +253,7 @@ func TestURICanonicalizeDiscovery(t *testing.T) {
 	obj := &URI{
 		Discovery: "disc",
 	}
-	disc.On("Discover", obj).Return([]*URI{obj}, assert.AnError)
+	disc.On("Discover", mock.Anything, obj).Return([]*URI{obj}, assert.AnError)
 	defer patcher.SetVar(&discMechs, map[string]Discovery{
 		"disc": disc,
 	}).Install().Restore()
@@ -192,6 +265,210 @@ func TestURICanonicalizeDiscovery(t *testing.T) {
 	disc.AssertExpectations(t)
 }
 
+func TestURIResolveBase(t *testing.T) {
+	obj := &URI{}
+
+	result, err := obj.Resolve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{{URI: obj, Source: SourceLiteral}}, result)
+}
+
+func TestURIResolveDiscovery(t *testing.T) {
+	disc := &mockDiscovery{}
+	obj := &URI{
+		Discovery: "disc",
+	}
+	disc.On("Discover", mock.Anything, obj).Return([]*URI{obj}, nil)
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+
+	result, err := obj.Resolve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{{URI: obj, Source: SourceDiscovery, Discovery: "disc"}}, result)
+	disc.AssertExpectations(t)
+}
+
+func TestURIResolveResolver(t *testing.T) {
+	disc := &mockResolver{}
+	obj := &URI{
+		Discovery: "disc",
+	}
+	res := []*Resolution{{URI: obj, Source: SourceSRV, Name: "node1.local."}}
+	disc.On("Resolve", mock.Anything, obj).Return(res, nil)
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+
+	result, err := obj.Resolve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, res, result)
+	disc.AssertExpectations(t)
+}
+
+type ctxKey struct{}
+
+func TestURICanonicalizeContext(t *testing.T) {
+	disc := &mockDiscovery{}
+	obj := &URI{
+		Discovery: "disc",
+	}
+	result := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	disc.On("Discover", mock.MatchedBy(func(c context.Context) bool {
+		return c.Value(ctxKey{}) == "value"
+	}), obj).Return([]*URI{result}, nil)
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+
+	uris, err := obj.CanonicalizeContext(ctx, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{result}, uris)
+	disc.AssertExpectations(t)
+}
+
+func TestURIResolveContextResolver(t *testing.T) {
+	r := &mockHostResolver{}
+	obj := &URI{
+		URL: url.URL{
+			Host: "example.com:http",
+		},
+		Transport: "tcp",
+	}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return([]net.IP{net.IPv4(192, 0, 2, 1)}, nil)
+	r.On("LookupPort", mock.Anything, "tcp", "http").Return(80, nil)
+
+	result, err := obj.ResolveContext(context.Background(), r)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{
+		{
+			URI: &URI{
+				URL: url.URL{
+					Host: "192.0.2.1:80",
+				},
+				Transport: "tcp",
+			},
+			Source: SourceDNS,
+			Name:   "example.com",
+		},
+	}, result)
+	r.AssertExpectations(t)
+}
+
+func TestURIResolveContextResolverError(t *testing.T) {
+	r := &mockHostResolver{}
+	obj := &URI{
+		URL: url.URL{
+			Host: "example.com:1234",
+		},
+	}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return(nil, assert.AnError)
+
+	result, err := obj.ResolveContext(context.Background(), r)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestURIResolveContextResolverFromContext(t *testing.T) {
+	r := &mockHostResolver{}
+	obj := &URI{
+		URL: url.URL{
+			Host: "example.com:1234",
+		},
+	}
+	r.On("LookupIP", mock.Anything, "ip", "example.com").Return([]net.IP{net.IPv6loopback}, nil)
+
+	result, err := obj.CanonicalizeContext(WithResolver(context.Background(), r), nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{{URL: url.URL{Host: "[::1]:1234"}}}, result)
+	r.AssertExpectations(t)
+}
+
+func TestURIResolveContextResolverDiscovery(t *testing.T) {
+	r := &mockHostResolver{}
+	disc := &mockDiscovery{}
+	obj := &URI{
+		Discovery: "disc",
+	}
+	result := &URI{URL: url.URL{Host: "127.0.0.1:1234"}}
+	disc.On("Discover", mock.MatchedBy(func(c context.Context) bool {
+		return ContextResolver(c) == r
+	}), obj).Return([]*URI{result}, nil)
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+
+	uris, err := obj.CanonicalizeContext(context.Background(), r)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{result}, uris)
+	disc.AssertExpectations(t)
+}
+
+func TestURIResolveContextCancelled(t *testing.T) {
+	disc := AdaptDiscovery(&mockLegacyDiscovery{})
+	obj := &URI{
+		Discovery: "disc",
+	}
+	defer patcher.SetVar(&discMechs, map[string]Discovery{
+		"disc": disc,
+	}).Install().Restore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := obj.ResolveContext(ctx, nil)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+}
+
+func TestURIResolveCanonical(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:1234",
+		},
+	}
+
+	result, err := obj.Resolve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{{URI: obj, Source: SourceLiteral}}, result)
+}
+
+func TestURIResolveHostname(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "localhost:1234",
+		},
+	}
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
+		return []net.IP{net.IPv6loopback}, nil
+	}).Install().Restore()
+
+	result, err := obj.Resolve()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Resolution{
+		{
+			URI: &URI{
+				URL: url.URL{
+					Host: "[::1]:1234",
+				},
+			},
+			Source: SourceDNS,
+			Name:   "localhost",
+		},
+	}, result)
+}
+
 func TestURICanonicalizeCanonical(t *testing.T) {
 	obj := &URI{
 		URL: url.URL{
@@ -226,7 +503,7 @@ func TestURICanonicalizeHostname(t *testing.T) {
 			Host: "localhost:1234",
 		},
 	}
-	defer patcher.SetVar(&lookupIP, func(host string) ([]net.IP, error) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
 		assert.Equal(t, "localhost", host)
 		return []net.IP{
 			net.IPv4(127, 0, 0, 1),
@@ -257,7 +534,7 @@ func TestURICanonicalizeHostnameLookupFails(t *testing.T) {
 			Host: "localhost:1234",
 		},
 	}
-	defer patcher.SetVar(&lookupIP, func(host string) ([]net.IP, error) {
+	defer patcher.SetVar(&lookupIP, func(_ context.Context, _, host string) ([]net.IP, error) {
 		assert.Equal(t, "localhost", host)
 		return nil, assert.AnError
 	}).Install().Restore()
@@ -275,7 +552,7 @@ func TestURICanonicalizePort(t *testing.T) {
 		},
 		Transport: "tcp",
 	}
-	defer patcher.SetVar(&lookupPort, func(network, port string) (int, error) {
+	defer patcher.SetVar(&lookupPort, func(_ context.Context, network, port string) (int, error) {
 		assert.Equal(t, "tcp", network)
 		assert.Equal(t, "humboldt", port)
 		return 1234, nil
@@ -294,6 +571,31 @@ func TestURICanonicalizePort(t *testing.T) {
 	}, result)
 }
 
+func TestURICanonicalizePortZone(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "[fe80::1%eth0]:humboldt",
+		},
+		Transport: "tcp",
+	}
+	defer patcher.SetVar(&lookupPort, func(_ context.Context, network, port string) (int, error) {
+		return 1234, nil
+	}).Install().Restore()
+
+	result, err := obj.Canonicalize()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*URI{
+		{
+			URL: url.URL{
+				Host: "[fe80::1%eth0]:1234",
+			},
+			Transport: "tcp",
+		},
+	}, result)
+	assert.True(t, result[0].IsCanonical())
+}
+
 func TestURICanonicalizePortLookupFails(t *testing.T) {
 	obj := &URI{
 		URL: url.URL{
@@ -301,7 +603,7 @@ func TestURICanonicalizePortLookupFails(t *testing.T) {
 		},
 		Transport: "tcp",
 	}
-	defer patcher.SetVar(&lookupPort, func(network, port string) (int, error) {
+	defer patcher.SetVar(&lookupPort, func(_ context.Context, network, port string) (int, error) {
 		assert.Equal(t, "tcp", network)
 		assert.Equal(t, "humboldt", port)
 		return 0, assert.AnError
@@ -325,7 +627,7 @@ func TestURIDialBase(t *testing.T) {
 	cfg := &mockConfig{}
 	opt := &mockDialerOption{}
 	c := &Conduit{}
-	mech.On("Dial", ctx, cfg, obj, []DialerOption{opt}).Return(c, nil)
+	mech.On("Dial", mock.Anything, cfg, obj, []DialerOption{opt}).Return(c, nil)
 	securityCalled := false
 	transportCalled := false
 	defer patcher.NewPatchMaster(
@@ -363,7 +665,7 @@ func TestURIDialWithSecurity(t *testing.T) {
 	cfg := &mockConfig{}
 	opt := &mockDialerOption{}
 	c := &Conduit{}
-	mech.On("Dial", ctx, cfg, obj, []DialerOption{opt}).Return(c, nil)
+	mech.On("Dial", mock.Anything, cfg, obj, []DialerOption{opt}).Return(c, nil)
 	securityCalled := false
 	transportCalled := false
 	defer patcher.NewPatchMaster(
@@ -388,6 +690,52 @@ func TestURIDialWithSecurity(t *testing.T) {
 	assert.False(t, transportCalled)
 }
 
+func TestURIDialQueryOptions(t *testing.T) {
+	obj := mustParse("tcp://127.0.0.1:1234?keepalive=1s")
+	mech := &mockMechanism{}
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	opt := &mockDialerOption{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, obj, []DialerOption{opt, KeepAlive(time.Second)}).Return(c, nil)
+	defer patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+		return mech
+	}).Install().Restore()
+
+	result, err := obj.Dial(ctx, cfg, opt)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	mech.AssertExpectations(t)
+}
+
+func TestURIDialNegotiateTimeout(t *testing.T) {
+	obj := mustParse("tcp://127.0.0.1:1234?negotiate_timeout=5s")
+	mech := &mockMechanism{}
+	cfg := &mockConfig{}
+	c := &Conduit{}
+	mech.On("Dial", mock.Anything, cfg, obj, []DialerOption{NegotiateTimeout(5 * time.Second)}).Return(c, nil)
+	defer patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+		return mech
+	}).Install().Restore()
+
+	result, err := obj.Dial(context.Background(), cfg)
+
+	assert.NoError(t, err)
+	assert.Same(t, c, result)
+	assert.Equal(t, 5*time.Second, result.NegotiateTimeout)
+	mech.AssertExpectations(t)
+}
+
+func TestURIDialQueryOptionsError(t *testing.T) {
+	obj := mustParse("tcp://127.0.0.1:1234?bogus=1")
+
+	result, err := obj.Dial(context.Background(), &mockConfig{})
+
+	assert.ErrorIs(t, err, ErrUnknownOption)
+	assert.Nil(t, result)
+}
+
 func TestURIDialNotCanonical(t *testing.T) {
 	obj := &URI{
 		URL: url.URL{
@@ -541,7 +889,7 @@ func TestURIListenBase(t *testing.T) {
 	cfg := &mockConfig{}
 	opt := &mockListenerOption{}
 	l := &mockListener{}
-	mech.On("Listen", ctx, cfg, obj, []ListenerOption{opt}).Return(l, nil)
+	mech.On("Listen", mock.Anything, cfg, obj, []ListenerOption{opt}).Return(l, nil)
 	securityCalled := false
 	transportCalled := false
 	defer patcher.NewPatchMaster(
@@ -578,8 +926,12 @@ func TestURIListenWithSecurity(t *testing.T) {
 	ctx := context.Background()
 	cfg := &mockConfig{}
 	opt := &mockListenerOption{}
-	l := &mockListener{}
-	mech.On("Listen", ctx, cfg, obj, []ListenerOption{opt}).Return(l, nil)
+	l := newChanListener()
+	mech.On("Listen", mock.Anything, cfg, obj, []ListenerOption{
+		MaxHandshakes(DefaultMaxHandshakes),
+		HandshakeTimeout(DefaultHandshakeTimeout),
+		opt,
+	}).Return(l, nil)
 	securityCalled := false
 	transportCalled := false
 	defer patcher.NewPatchMaster(
@@ -597,13 +949,72 @@ func TestURIListenWithSecurity(t *testing.T) {
 
 	result, err := obj.Listen(ctx, cfg, opt)
 
-	assert.NoError(t, err)
-	assert.Same(t, l, result)
+	require.NoError(t, err)
+	defer result.Close()
+	require.IsType(t, &handshakeListener{}, result)
+	assert.Same(t, l, result.(*handshakeListener).Listener)
+	assert.True(t, result.(*handshakeListener).deadline)
 	mech.AssertExpectations(t)
 	assert.True(t, securityCalled)
 	assert.False(t, transportCalled)
 }
 
+func TestURIListenWithSecurityNoDefaults(t *testing.T) {
+	obj := &URI{
+		URL: url.URL{
+			Host: "127.0.0.1:1234",
+		},
+		Transport: "tcp",
+		Security:  "tls",
+	}
+	mech := &mockMechanism{}
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	l := &mockListener{}
+	mech.On("Listen", mock.Anything, cfg, obj, []ListenerOption{
+		MaxHandshakes(DefaultMaxHandshakes),
+		HandshakeTimeout(DefaultHandshakeTimeout),
+		MaxHandshakes(0),
+		HandshakeTimeout(0),
+	}).Return(l, nil)
+	defer patcher.SetVar(&lookupSecurity, func(name string) Mechanism {
+		return mech
+	}).Install().Restore()
+
+	result, err := obj.Listen(ctx, cfg, MaxHandshakes(0), HandshakeTimeout(0))
+
+	assert.NoError(t, err)
+	assert.Same(t, l, result)
+	mech.AssertExpectations(t)
+}
+
+func TestURIListenQueryOptions(t *testing.T) {
+	obj := mustParse("tcp://127.0.0.1:1234?nodelay=false")
+	mech := &mockMechanism{}
+	ctx := context.Background()
+	cfg := &mockConfig{}
+	l := &mockListener{}
+	mech.On("Listen", mock.Anything, cfg, obj, []ListenerOption{NoDelay(false)}).Return(l, nil)
+	defer patcher.SetVar(&lookupTransport, func(name string) Mechanism {
+		return mech
+	}).Install().Restore()
+
+	result, err := obj.Listen(ctx, cfg)
+
+	assert.NoError(t, err)
+	assert.Same(t, l, result)
+	mech.AssertExpectations(t)
+}
+
+func TestURIListenQueryOptionsError(t *testing.T) {
+	obj := mustParse("tcp://127.0.0.1:1234?nodelay=maybe")
+
+	result, err := obj.Listen(context.Background(), &mockConfig{})
+
+	assert.ErrorIs(t, err, ErrBadOption)
+	assert.Nil(t, result)
+}
+
 func TestURIListenNotCanonical(t *testing.T) {
 	obj := &URI{
 		URL: url.URL{
@@ -751,7 +1162,7 @@ func TestDialBase(t *testing.T) {
 	cfg := &mockConfig{}
 	opt := &mockDialerOption{}
 	c := &Conduit{}
-	mech.On("Dial", ctx, cfg, &URI{
+	mech.On("Dial", mock.Anything, cfg, &URI{
 		URL: url.URL{
 			Scheme: "tcp",
 			Host:   "127.0.0.1:1234",
@@ -791,7 +1202,7 @@ func TestListenBase(t *testing.T) {
 	cfg := &mockConfig{}
 	opt := &mockListenerOption{}
 	l := &mockListener{}
-	mech.On("Listen", ctx, cfg, &URI{
+	mech.On("Listen", mock.Anything, cfg, &URI{
 		URL: url.URL{
 			Scheme: "tcp",
 			Host:   "127.0.0.1:1234",
@@ -824,3 +1235,141 @@ func TestListenParseError(t *testing.T) {
 	assert.Nil(t, result)
 	mech.AssertExpectations(t)
 }
+
+func TestSecurityURI(t *testing.T) {
+	u, _ := Parse("tcp://127.0.0.1:1234")
+
+	result := securityURI(u, "noise")
+
+	assert.Equal(t, "tcp+noise://127.0.0.1:1234", result.String())
+	assert.Equal(t, "noise", result.Security)
+	assert.Equal(t, "tcp", u.Scheme)
+	assert.Nil(t, securityURI(nil, "noise"))
+	assert.Same(t, result, securityURI(result, "psk"))
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		result string
+	}{
+		{"Example.COM:1234", "example.com:1234"},
+		{"example.com.:1234", "example.com:1234"},
+		{"example.com:01234", "example.com:1234"},
+		{"example.com:", "example.com"},
+		{"example.com", "example.com"},
+		{"example.com:humboldt", "example.com:humboldt"},
+		{"[0:0:0:0:0:0:0:1]:1234", "[::1]:1234"},
+		{"[FE80::1%eth0]:1234", "[fe80::1%eth0]:1234"},
+		{"[::ffff:127.0.0.1]:1234", "127.0.0.1:1234"},
+		{"[::1]", "[::1]"},
+		{"[::1]:", "[::1]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			assert.Equal(t, test.result, normalizeHost(test.host))
+		})
+	}
+}
+
+func TestEscapeZone(t *testing.T) {
+	tests := []struct {
+		rawuri string
+		result string
+	}{
+		{"tcp://127.0.0.1:1234", "tcp://127.0.0.1:1234"},
+		{"tcp://[::1]:1234", "tcp://[::1]:1234"},
+		{"tcp://[fe80::1%eth0]:1234", "tcp://[fe80::1%25eth0]:1234"},
+		{"tcp://[fe80::1%25eth0]:1234", "tcp://[fe80::1%25eth0]:1234"},
+		{"tcp://[fe80::1%eth0]:1234/p%41?q=%41", "tcp://[fe80::1%25eth0]:1234/p%41?q=%41"},
+		{"tcp://host:1234/[a%41]", "tcp://host:1234/[a%41]"},
+		{"[::1%eth0]", "[::1%eth0]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rawuri, func(t *testing.T) {
+			assert.Equal(t, test.result, escapeZone(test.rawuri))
+		})
+	}
+}
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		host string
+		ip   net.IP
+		zone string
+	}{
+		{"127.0.0.1", net.ParseIP("127.0.0.1"), ""},
+		{"::1", net.IPv6loopback, ""},
+		{"fe80::1%eth0", net.ParseIP("fe80::1"), "eth0"},
+		{"fe80::1%", nil, ""},
+		{"127.0.0.1%eth0", nil, ""},
+		{"localhost", nil, ""},
+		{"localhost%eth0", nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			ip, zone := parseIP(test.host)
+
+			assert.Equal(t, test.ip, ip)
+			assert.Equal(t, test.zone, zone)
+		})
+	}
+}
+
+func TestJoinZone(t *testing.T) {
+	assert.Equal(t, "fe80::1", joinZone(net.ParseIP("fe80::1"), ""))
+	assert.Equal(t, "fe80::1%eth0", joinZone(net.ParseIP("fe80::1"), "eth0"))
+}
+
+func TestURINormalize(t *testing.T) {
+	obj := mustParse("TCP+TLS://User@Example.COM:01234/Path?b=2&a=1")
+
+	result := obj.Normalize()
+
+	assert.Equal(t, "tcp+tls://User@example.com:1234/Path?a=1&b=2", result.String())
+	assert.Equal(t, "tcp", result.Transport)
+	assert.Equal(t, "tls", result.Security)
+	assert.Equal(t, "Example.COM:01234", obj.Host)
+}
+
+func TestURINormalizeNoHost(t *testing.T) {
+	obj := mustParse("Mem:node")
+
+	result := obj.Normalize()
+
+	assert.Equal(t, "mem:node", result.String())
+}
+
+func TestURIKey(t *testing.T) {
+	obj := mustParse("udp://[0:0::1]:01234")
+
+	result := obj.Key()
+
+	assert.Equal(t, "udp://[::1]:1234", result)
+}
+
+func TestURIEqual(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   *URI
+		result bool
+	}{
+		{"Same", mustParse("tcp://127.0.0.1:1234"), mustParse("tcp://127.0.0.1:1234"), true},
+		{"Case", mustParse("TCP://Example.com:1234"), mustParse("tcp://example.COM:1234"), true},
+		{"IPv6", mustParse("tcp://[::0:1]:1234"), mustParse("tcp://[0::1]:1234"), true},
+		{"Query", mustParse("tcp://h:1?a=1&b=2"), mustParse("tcp://h:1?b=2&a=1"), true},
+		{"Port", mustParse("tcp://127.0.0.1:1234"), mustParse("tcp://127.0.0.1:1235"), false},
+		{"Scheme", mustParse("tcp://127.0.0.1:1234"), mustParse("udp://127.0.0.1:1234"), false},
+		{"Nil", mustParse("tcp://127.0.0.1:1234"), nil, false},
+		{"BothNil", nil, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.result, test.a.Equal(test.b))
+		})
+	}
+}
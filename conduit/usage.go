@@ -0,0 +1,259 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultUsageWindow is the default window over which the rates
+// limited by a UsagePolicy are measured.
+const DefaultUsageWindow = time.Second
+
+// Measures of conduit usage.
+const (
+	UsageMessages    = "messages"    // PDUs received per second
+	UsageBytes       = "bytes"       // Bytes received per second
+	UsageOutstanding = "outstanding" // Requests outstanding
+)
+
+// Limit describes the soft and hard limits on a single measure of
+// conduit usage.  Zero values indicate no limit.
+type Limit struct {
+	Soft float64 // Exceeding the soft limit emits a warning
+	Hard float64 // Exceeding the hard limit closes the conduit
+}
+
+// UsagePolicy describes the limits on the usage of a conduit by its
+// peer.  The message and byte rates are measured over PDUs received
+// by Recv or PDUs, in fixed windows; requests are outstanding between
+// a call to BeginRequest and a call to the function it returns.
+// When a soft limit is exceeded, an AuditSoftLimit event is reported
+// once per window, or for outstanding requests, once until the count
+// drops below the limit again.  When a hard limit is exceeded, an
+// AuditHardLimit event is reported and the conduit is closed, with
+// its Error and the cause of its context set to the UsageError.
+type UsagePolicy struct {
+	Messages    Limit         // Limits on PDUs received per second
+	Bytes       Limit         // Limits on bytes received per second
+	Outstanding Limit         // Limits on outstanding requests
+	Window      time.Duration // Window for measuring rates; 0 for the default
+}
+
+// UsageError describes a usage limit exceeded by a conduit.  It wraps
+// ErrSoftLimit or ErrHardLimit.
+type UsageError struct {
+	Measure string  // The measure exceeding its limit
+	Value   float64 // The value of the measure
+	Limit   float64 // The limit exceeded
+	Hard    bool    // True if the hard limit was exceeded
+}
+
+// Error returns the error message.
+func (e *UsageError) Error() string {
+	return fmt.Sprintf("%s: %g exceeds limit of %g: %s", e.Measure, e.Value, e.Limit, e.Unwrap())
+}
+
+// Unwrap returns ErrSoftLimit or ErrHardLimit, as appropriate.
+func (e *UsageError) Unwrap() error {
+	if e.Hard {
+		return ErrHardLimit
+	}
+
+	return ErrSoftLimit
+}
+
+// Usage describes the current usage of a conduit.
+type Usage struct {
+	Messages    float64 // PDUs received per second in the current window
+	Bytes       float64 // Bytes received per second in the current window
+	Outstanding int     // Requests outstanding
+	SoftLimits  uint64  // Number of soft limit warnings reported
+}
+
+// usageState tracks the usage of a conduit.
+type usageState struct {
+	sync.Mutex
+
+	policy      *UsagePolicy    // The policy enforced
+	start       time.Time       // Start of the current window
+	messages    float64         // PDUs received in the current window
+	bytes       float64         // Bytes received in the current window
+	outstanding int             // Requests outstanding
+	warned      map[string]bool // Soft limits already reported
+	softLimits  uint64          // Soft limit warnings reported
+}
+
+// window returns the rate window of the policy.
+func (p *UsagePolicy) window() time.Duration {
+	if p.Window <= 0 {
+		return DefaultUsageWindow
+	}
+
+	return p.Window
+}
+
+// SetUsagePolicy sets the usage policy enforced on the conduit.  A
+// nil policy disables enforcement.  Setting the policy resets the
+// rate measurements, but not the count of outstanding requests.
+func (c *Conduit) SetUsagePolicy(policy *UsagePolicy) {
+	c.usage.Lock()
+	defer c.usage.Unlock()
+
+	c.usage.policy = policy
+	c.usage.start = timeNow()
+	c.usage.messages = 0
+	c.usage.bytes = 0
+	c.usage.warned = nil
+}
+
+// Usage returns the current usage of the conduit.
+func (c *Conduit) Usage() Usage {
+	c.usage.Lock()
+	defer c.usage.Unlock()
+
+	result := Usage{
+		Outstanding: c.usage.outstanding,
+		SoftLimits:  c.usage.softLimits,
+	}
+	if c.usage.policy != nil {
+		secs := c.usage.policy.window().Seconds()
+		result.Messages = c.usage.messages / secs
+		result.Bytes = c.usage.bytes / secs
+	}
+
+	return result
+}
+
+// check checks the value of a measure against its limit, returning
+// the error to report, if any.  Soft limits are reported once until
+// the warning is reset.  Must be called with the lock held.
+func (u *usageState) check(measure string, value float64, limit Limit) *UsageError {
+	if limit.Hard > 0 && value > limit.Hard {
+		return &UsageError{Measure: measure, Value: value, Limit: limit.Hard, Hard: true}
+	}
+	if limit.Soft <= 0 || value <= limit.Soft || u.warned[measure] {
+		return nil
+	}
+
+	if u.warned == nil {
+		u.warned = map[string]bool{}
+	}
+	u.warned[measure] = true
+	u.softLimits++
+
+	return &UsageError{Measure: measure, Value: value, Limit: limit.Soft}
+}
+
+// enforce reports a usage error, closing the conduit if a hard limit
+// was exceeded.  It returns the error if the conduit was closed.
+func (c *Conduit) enforce(err *UsageError) error {
+	if err == nil {
+		return nil
+	}
+
+	if !err.Hard {
+		auditError(AuditSoftLimit, c, err)
+		return nil
+	}
+
+	auditError(AuditHardLimit, c, err)
+	c.State = Error
+	c.Error = err
+	c.cancelContext(err)
+	if c.Link != nil {
+		c.Link.Close() //nolint:errcheck
+	}
+
+	return err
+}
+
+// account counts a received PDU against the usage policy, returning
+// an error if the conduit was closed for exceeding a hard limit.
+func (c *Conduit) account(size int) error {
+	c.usage.Lock()
+	p := c.usage.policy
+	if p == nil {
+		c.usage.Unlock()
+		return nil
+	}
+
+	// Start a new window if necessary
+	now := timeNow()
+	window := p.window()
+	if now.Sub(c.usage.start) >= window {
+		c.usage.start = now
+		c.usage.messages = 0
+		c.usage.bytes = 0
+		delete(c.usage.warned, UsageMessages)
+		delete(c.usage.warned, UsageBytes)
+	}
+	c.usage.messages++
+	c.usage.bytes += float64(size)
+
+	// Check the rates
+	secs := window.Seconds()
+	errs := []*UsageError{
+		c.usage.check(UsageMessages, c.usage.messages/secs, p.Messages),
+		c.usage.check(UsageBytes, c.usage.bytes/secs, p.Bytes),
+	}
+	c.usage.Unlock()
+
+	for _, ue := range errs {
+		if err := c.enforce(ue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BeginRequest marks the start of a request received from the peer,
+// which remains outstanding until the returned function is called.
+// If the request would exceed the hard limit on outstanding requests,
+// the conduit is closed and an error is returned.
+func (c *Conduit) BeginRequest() (func(), error) {
+	c.usage.Lock()
+	var ue *UsageError
+	if p := c.usage.policy; p != nil {
+		ue = c.usage.check(UsageOutstanding, float64(c.usage.outstanding+1), p.Outstanding)
+	}
+	if ue == nil || !ue.Hard {
+		c.usage.outstanding++
+	}
+	c.usage.Unlock()
+
+	if err := c.enforce(ue); err != nil {
+		return nil, err
+	}
+
+	once := sync.Once{}
+	return func() {
+		once.Do(c.endRequest)
+	}, nil
+}
+
+// endRequest marks the end of an outstanding request.
+func (c *Conduit) endRequest() {
+	c.usage.Lock()
+	defer c.usage.Unlock()
+
+	c.usage.outstanding--
+	if p := c.usage.policy; p != nil && float64(c.usage.outstanding) <= p.Outstanding.Soft {
+		delete(c.usage.warned, UsageOutstanding)
+	}
+}
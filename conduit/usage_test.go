@@ -0,0 +1,214 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// usageEvents captures the audit events reported.
+func usageEvents() (*[]*AuditEvent, func()) {
+	events := &[]*AuditEvent{}
+	prev := SetAuditor(AuditorFunc(func(ev *AuditEvent) {
+		*events = append(*events, ev)
+	}))
+
+	return events, func() { SetAuditor(prev) }
+}
+
+func TestUsageErrorErrorSoft(t *testing.T) {
+	obj := &UsageError{Measure: UsageMessages, Value: 3, Limit: 2}
+
+	assert.Equal(t, "messages: 3 exceeds limit of 2: soft usage limit exceeded", obj.Error())
+	assert.ErrorIs(t, obj, ErrSoftLimit)
+}
+
+func TestUsageErrorErrorHard(t *testing.T) {
+	obj := &UsageError{Measure: UsageBytes, Value: 2048, Limit: 1024, Hard: true}
+
+	assert.Equal(t, "bytes: 2048 exceeds limit of 1024: hard usage limit exceeded", obj.Error())
+	assert.ErrorIs(t, obj, ErrHardLimit)
+}
+
+func TestUsagePolicyWindowDefault(t *testing.T) {
+	obj := &UsagePolicy{}
+
+	assert.Equal(t, DefaultUsageWindow, obj.window())
+}
+
+func TestUsagePolicyWindowSet(t *testing.T) {
+	obj := &UsagePolicy{Window: time.Minute}
+
+	assert.Equal(t, time.Minute, obj.window())
+}
+
+func TestConduitAccountNoPolicy(t *testing.T) {
+	obj := &Conduit{}
+
+	err := obj.account(100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{}, obj.Usage())
+}
+
+func TestConduitAccountSoft(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	events, restore := usageEvents()
+	defer restore()
+	obj := &Conduit{}
+	obj.SetUsagePolicy(&UsagePolicy{Messages: Limit{Soft: 2}})
+
+	for range 4 {
+		assert.NoError(t, obj.account(10))
+	}
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, AuditSoftLimit, (*events)[0].Event)
+	assert.Same(t, obj, (*events)[0].Conduit)
+	assert.Equal(t, &UsageError{Measure: UsageMessages, Value: 3, Limit: 2}, (*events)[0].Err)
+	assert.Equal(t, Usage{Messages: 4, Bytes: 40, SoftLimits: 1}, obj.Usage())
+}
+
+func TestConduitAccountWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	events, restore := usageEvents()
+	defer restore()
+	obj := &Conduit{}
+	obj.SetUsagePolicy(&UsagePolicy{Bytes: Limit{Soft: 15}, Window: 2 * time.Second})
+
+	assert.NoError(t, obj.account(20))
+	assert.NoError(t, obj.account(20))
+	now = now.Add(2 * time.Second)
+	assert.NoError(t, obj.account(10))
+	assert.NoError(t, obj.account(40))
+
+	assert.Len(t, *events, 2)
+	assert.Equal(t, Usage{Messages: 1, Bytes: 25, SoftLimits: 2}, obj.Usage())
+}
+
+func TestConduitAccountHard(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	events, restore := usageEvents()
+	defer restore()
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	obj.SetUsagePolicy(&UsagePolicy{Messages: Limit{Soft: 1, Hard: 2}})
+
+	err1 := obj.account(10)
+	err2 := obj.account(10)
+	err3 := obj.account(10)
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.ErrorIs(t, err3, ErrHardLimit)
+	assert.Equal(t, Error, obj.State)
+	assert.Same(t, err3, obj.Error)
+	assert.Same(t, err3, context.Cause(obj.Context()))
+	require.Len(t, *events, 2)
+	assert.Equal(t, AuditSoftLimit, (*events)[0].Event)
+	assert.Equal(t, AuditHardLimit, (*events)[1].Event)
+	_, err := c2.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestConduitBeginRequest(t *testing.T) {
+	events, restore := usageEvents()
+	defer restore()
+	obj := &Conduit{}
+	obj.SetUsagePolicy(&UsagePolicy{Outstanding: Limit{Soft: 1, Hard: 2}})
+
+	done1, err1 := obj.BeginRequest()
+	done2, err2 := obj.BeginRequest()
+	done3, err3 := obj.BeginRequest()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.ErrorIs(t, err3, ErrHardLimit)
+	assert.Nil(t, done3)
+	assert.Equal(t, 2, obj.Usage().Outstanding)
+	require.Len(t, *events, 2)
+	assert.Equal(t, &UsageError{Measure: UsageOutstanding, Value: 2, Limit: 1}, (*events)[0].Err)
+	done2()
+	done2()
+	assert.Equal(t, 1, obj.Usage().Outstanding)
+	done1()
+	assert.Equal(t, 0, obj.Usage().Outstanding)
+}
+
+func TestConduitBeginRequestRewarns(t *testing.T) {
+	events, restore := usageEvents()
+	defer restore()
+	obj := &Conduit{}
+	obj.SetUsagePolicy(&UsagePolicy{Outstanding: Limit{Soft: 1}})
+	done1, _ := obj.BeginRequest()
+	done2, _ := obj.BeginRequest()
+	done2()
+
+	_, err := obj.BeginRequest()
+
+	assert.NoError(t, err)
+	assert.Len(t, *events, 2)
+	done1()
+}
+
+func TestConduitBeginRequestNoPolicy(t *testing.T) {
+	obj := &Conduit{}
+
+	done, err := obj.BeginRequest()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, obj.Usage().Outstanding)
+	done()
+	assert.Equal(t, 0, obj.Usage().Outstanding)
+}
+
+func TestConduitRecvUsageHard(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	obj := &Conduit{State: Open, Link: c1}
+	obj.SetUsagePolicy(&UsagePolicy{Messages: Limit{Hard: 1}})
+	go func() {
+		w := proto.NewWriter(c2)
+		for range 2 {
+			w.WriteFrame(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("x")}) //nolint:errcheck
+		}
+	}()
+
+	_, err1 := obj.Recv()
+	_, err2 := obj.Recv()
+
+	assert.NoError(t, err1)
+	assert.ErrorIs(t, err2, ErrHardLimit)
+	assert.Equal(t, Error, obj.State)
+}
@@ -0,0 +1,531 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for WorkloadAPI.
+const (
+	DefaultWorkloadAPIAddr  = "unix:///tmp/spire-agent/public/api.sock" // Default address of the agent
+	DefaultWorkloadAPIRetry = 5 * time.Second                           // Default delay before reconnecting
+)
+
+// WorkloadAPIEnv is the environment variable giving the address of
+// the SPIFFE Workload API.
+const WorkloadAPIEnv = "SPIFFE_ENDPOINT_SOCKET"
+
+// workloadFetchX509 is the path of the gRPC method streaming X.509
+// SVIDs.
+const workloadFetchX509 = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+// X509SVID is an X.509 SVID fetched from the SPIFFE Workload API,
+// along with the trust bundles used to verify peers.
+type X509SVID struct {
+	ID          string                    // The SPIFFE ID
+	Certificate tls.Certificate           // The certificate chain and private key
+	Bundles     map[string]*x509.CertPool // Trust bundles, by trust domain
+}
+
+// WorkloadAPI is a client of the SPIFFE Workload API, which streams
+// the X.509 SVIDs of the workload from an agent, such as the SPIRE
+// agent, as they are issued and rotated.  Config returns TLS
+// configurations presenting the current SVID and verifying peers
+// against the current trust bundles, including those of federated
+// trust domains; SPIFFEAuthorizer may then be used to authorize peers
+// by their SPIFFE IDs.  The client is started when first needed, and
+// reconnects to the agent if the stream fails.  The exported fields
+// must be set before calling any of the methods.
+type WorkloadAPI struct {
+	sync.Mutex
+
+	Addr  string        // Address of the agent, as "unix:///path"; defaults from the environment
+	Retry time.Duration // Delay before reconnecting; defaults to DefaultWorkloadAPIRetry
+
+	cancel  context.CancelFunc // Stops the client
+	done    chan struct{}      // Closed when the client stops
+	updated chan struct{}      // Closed when the SVID is next updated
+	svid    *X509SVID          // The current SVID
+	err     error              // The last error encountered
+}
+
+// addr returns the address of the agent.
+func (w *WorkloadAPI) addr() string {
+	if w.Addr != "" {
+		return w.Addr
+	} else if env := getenv(WorkloadAPIEnv); env != "" {
+		return env
+	}
+
+	return DefaultWorkloadAPIAddr
+}
+
+// retry returns the delay before reconnecting.
+func (w *WorkloadAPI) retry() time.Duration {
+	if w.Retry <= 0 {
+		return DefaultWorkloadAPIRetry
+	}
+
+	return w.Retry
+}
+
+// socket returns the path of the agent's socket.
+func (w *WorkloadAPI) socket() (string, error) {
+	addr := w.addr()
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		path, ok = strings.CutPrefix(addr, "unix:")
+	}
+	if !ok || path == "" {
+		return "", fmt.Errorf("workload api: %q: %w", addr, ErrBadConfig)
+	}
+
+	return path, nil
+}
+
+// start starts the client, if it has not been started.  Must be
+// called with the lock held.
+func (w *WorkloadAPI) start() {
+	if w.done != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(context.Background())
+	w.done = make(chan struct{})
+	w.updated = make(chan struct{})
+	go w.run(ctx)
+}
+
+// Close stops the client.
+func (w *WorkloadAPI) Close() error {
+	w.Lock()
+	cancel, done := w.cancel, w.done
+	w.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	return nil
+}
+
+// run streams SVIDs from the agent until the context is cancelled.
+func (w *WorkloadAPI) run(ctx context.Context) {
+	defer close(w.done)
+
+	for {
+		err := w.fetch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logResult("workload api", err, "addr", w.addr())
+		w.Lock()
+		w.err = err
+		w.Unlock()
+
+		select {
+		case <-time.After(w.retry()):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fetch streams SVIDs from the agent, updating the current SVID with
+// each one received, until the stream fails.
+func (w *WorkloadAPI) fetch(ctx context.Context) error {
+	path, err := w.socket()
+	if err != nil {
+		return err
+	}
+
+	// Set up a client speaking unencrypted HTTP/2 over the socket
+	protos := &http.Protocols{}
+	protos.SetUnencryptedHTTP2(true)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Protocols: protos,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// Send the request, which is an empty message
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost"+workloadFetchX509, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Workload.Spiffe.Io", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("workload api: %w: HTTP status %d", ErrWorkloadAPI, resp.StatusCode)
+	}
+
+	// Process the responses
+	for {
+		msg, err := grpcRead(resp.Body)
+		if err == io.EOF {
+			return grpcStatus(resp)
+		} else if err != nil {
+			return err
+		}
+		svid, err := parseX509SVIDResponse(msg)
+		if err != nil {
+			return err
+		}
+		w.update(svid)
+	}
+}
+
+// update makes an SVID the current one, waking goroutines waiting for
+// it.
+func (w *WorkloadAPI) update(svid *X509SVID) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.svid = svid
+	w.err = nil
+	close(w.updated)
+	w.updated = make(chan struct{})
+	logResult("workload api update", nil, "id", svid.ID)
+}
+
+// SVID returns the current SVID, starting the client and waiting for
+// the first SVID to be received if necessary.
+func (w *WorkloadAPI) SVID(ctx context.Context) (*X509SVID, error) {
+	w.Lock()
+	w.start()
+	svid, updated, done := w.svid, w.updated, w.done
+	w.Unlock()
+	if svid != nil {
+		return svid, nil
+	}
+
+	select {
+	case <-updated:
+	case <-done:
+	case <-ctx.Done():
+		w.Lock()
+		defer w.Unlock()
+		if w.err != nil {
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), w.err)
+		}
+		return nil, ctx.Err()
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.svid == nil {
+		return nil, ErrNoSVID
+	}
+
+	return w.svid, nil
+}
+
+// verify verifies the certificate chain presented by a peer against
+// the trust bundle of the trust domain of its SPIFFE ID.
+func (w *WorkloadAPI) verify(ctx context.Context, cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no peer certificate: %w", ErrUnauthorized)
+	}
+	id, err := SPIFFEID(cs.PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+	td, _, _ := ParseSPIFFEID(id)
+
+	svid, err := w.SVID(ctx)
+	if err != nil {
+		return err
+	}
+	pool := svid.Bundles[td]
+	if pool == nil {
+		return fmt.Errorf("%s: %w", td, ErrNoBundle)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+
+	return err
+}
+
+// Config returns a copy of a TLS configuration which presents the
+// current SVID and verifies the SVIDs presented by peers against the
+// current trust bundles.  Peers are required to present SVIDs, and
+// are verified by their SPIFFE IDs rather than by host names, so the
+// configuration is suitable for both clients and servers; any
+// VerifyConnection function of the configuration is called
+// afterwards.
+func (w *WorkloadAPI) Config(base *tls.Config) *tls.Config {
+	conf := base.Clone()
+	conf.Certificates = nil
+	conf.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		svid, err := w.SVID(hello.Context())
+		if err != nil {
+			return nil, err
+		}
+		return &svid.Certificate, nil
+	}
+	conf.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		svid, err := w.SVID(info.Context())
+		if err != nil {
+			return nil, err
+		}
+		return &svid.Certificate, nil
+	}
+
+	// Verify peers against the trust bundles
+	conf.RootCAs = nil
+	conf.ClientCAs = nil
+	conf.ClientAuth = tls.RequireAnyClientCert
+	conf.InsecureSkipVerify = true
+	verify := conf.VerifyConnection
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultTLSHandshakeTimeout)
+		defer cancel()
+		if err := w.verify(ctx, cs); err != nil {
+			return err
+		}
+		if verify != nil {
+			return verify(cs)
+		}
+		return nil
+	}
+
+	return conf
+}
+
+// grpcRead reads a gRPC message from a stream, returning io.EOF if the
+// stream ends between messages.
+func grpcRead(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, fmt.Errorf("workload api: compressed message: %w", ErrBadConfig)
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(hdr[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return msg, nil
+}
+
+// grpcStatus returns an error describing the gRPC status at the end
+// of a response, if it was not successful.
+func grpcStatus(resp *http.Response) error {
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status == "" || status == "0" {
+		return io.EOF
+	}
+
+	return fmt.Errorf("workload api: %w: gRPC status %s: %s", ErrWorkloadAPI, status, resp.Trailer.Get("Grpc-Message"))
+}
+
+// protoFields decodes the length-delimited fields of a protobuf
+// message, calling the function with the number and contents of
+// each.  Fields of other wire types are skipped.
+func protoFields(msg []byte, f func(num uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return ErrShortProto
+		}
+		msg = msg[n:]
+
+		switch tag & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return ErrShortProto
+			}
+			msg = msg[n:]
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return ErrShortProto
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return ErrShortProto
+			}
+			if err := f(tag>>3, msg[n:n+int(size)]); err != nil {
+				return err
+			}
+			msg = msg[n+int(size):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return ErrShortProto
+			}
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("wire type %d: %w", tag&7, ErrShortProto)
+		}
+	}
+
+	return nil
+}
+
+// parseX509SVIDResponse decodes an X509SVIDResponse message, returning
+// the first, default SVID.
+func parseX509SVIDResponse(msg []byte) (*X509SVID, error) {
+	var result *X509SVID
+	federated := map[string]*x509.CertPool{}
+	err := protoFields(msg, func(num uint64, data []byte) error {
+		switch num {
+		case 1: // svids
+			if result == nil {
+				svid, err := parseX509SVID(data)
+				if err != nil {
+					return err
+				}
+				result = svid
+			}
+		case 3: // federated_bundles
+			var td string
+			var pool *x509.CertPool
+			err := protoFields(data, func(num uint64, data []byte) error {
+				var err error
+				switch num {
+				case 1:
+					td = strings.TrimPrefix(string(data), SPIFFEScheme+"://")
+				case 2:
+					pool, err = parseBundle(data)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if td != "" && pool != nil {
+				federated[td] = pool
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("workload api: %w", err)
+	} else if result == nil {
+		return nil, ErrNoSVID
+	}
+	for td, pool := range federated {
+		if result.Bundles[td] == nil {
+			result.Bundles[td] = pool
+		}
+	}
+
+	return result, nil
+}
+
+// parseBundle parses a trust bundle of concatenated DER certificates.
+func parseBundle(data []byte) (*x509.CertPool, error) {
+	certs, err := x509.ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool, nil
+}
+
+// parseX509SVID decodes an X509SVID message.
+func parseX509SVID(msg []byte) (*X509SVID, error) {
+	var chain, key, bundle []byte
+	result := &X509SVID{Bundles: map[string]*x509.CertPool{}}
+	err := protoFields(msg, func(num uint64, data []byte) error {
+		switch num {
+		case 1:
+			result.ID = string(data)
+		case 2:
+			chain = data
+		case 3:
+			key = data
+		case 4:
+			bundle = data
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the certificate and key
+	td, _, err := ParseSPIFFEID(result.ID)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := x509.ParseCertificates(chain)
+	if err != nil {
+		return nil, err
+	} else if len(certs) == 0 {
+		return nil, ErrNoSVID
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("svid key: %w", ErrBadConfig)
+	}
+	result.Certificate = tls.Certificate{PrivateKey: signer, Leaf: certs[0]}
+	for _, cert := range certs {
+		result.Certificate.Certificate = append(result.Certificate.Certificate, cert.Raw)
+	}
+
+	// Parse the bundle
+	if result.Bundles[td], err = parseBundle(bundle); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
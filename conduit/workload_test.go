@@ -0,0 +1,492 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package conduit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// workloadCA is a certificate authority for a trust domain.
+type workloadCA struct {
+	cert *x509.Certificate // The CA certificate
+	key  *ecdsa.PrivateKey // The CA key
+}
+
+// newWorkloadCA constructs a certificate authority.
+func newWorkloadCA(t *testing.T, td string) *workloadCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: td},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &workloadCA{cert: cert, key: key}
+}
+
+// svid issues an SVID with the specified SPIFFE ID, returning an
+// X509SVID message.
+func (ca *workloadCA) svid(t *testing.T, id string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	u, err := url.Parse(id)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{u},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	msg := protoField(nil, 1, []byte(id))
+	msg = protoField(msg, 2, der)
+	msg = protoField(msg, 3, keyDER)
+	msg = protoField(msg, 4, ca.cert.Raw)
+
+	return msg
+}
+
+// protoField appends a length-delimited protobuf field to a message.
+func protoField(msg []byte, num uint64, data []byte) []byte {
+	msg = binary.AppendUvarint(msg, num<<3|2)
+	msg = binary.AppendUvarint(msg, uint64(len(data)))
+
+	return append(msg, data...)
+}
+
+// workloadServer starts a fake Workload API server, which streams the
+// X509SVIDResponse messages sent on the returned channel to each
+// client.  Closing the channel ends the streams with the specified
+// gRPC status.
+func workloadServer(t *testing.T, status string) (string, chan []byte) {
+	dir, err := os.MkdirTemp("", "wl")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "api.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	msgs := make(chan []byte, 10)
+	protos := &http.Protocols{}
+	protos.SetUnencryptedHTTP2(true)
+	srv := &http.Server{
+		Protocols: protos,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != workloadFetchX509 || r.Header.Get("Workload.Spiffe.Io") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			io.Copy(io.Discard, r.Body) //nolint:errcheck
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case msg, ok := <-msgs:
+					if !ok {
+						w.Header().Set("Grpc-Status", status)
+						return
+					}
+					hdr := make([]byte, 5)
+					binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+					w.Write(append(hdr, msg...)) //nolint:errcheck
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}),
+	}
+	go srv.Serve(l) //nolint:errcheck
+	t.Cleanup(func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	})
+
+	return "unix://" + path, msgs
+}
+
+// workloadPair runs a TLS handshake between two configurations over a
+// loopback connection, returning the principals each side assigns the
+// other.
+func workloadPair(t *testing.T, cliConf, srvConf *tls.Config) (string, string, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srvConf)
+	require.NoError(t, err)
+	defer l.Close()
+	type result struct {
+		principal string
+		err       error
+	}
+	srvRes := make(chan result, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			srvRes <- result{err: err}
+			return
+		}
+		defer c.Close()
+		tc := c.(*tls.Conn)
+		if err := tc.Handshake(); err != nil {
+			srvRes <- result{err: err}
+			return
+		}
+		cs := tc.ConnectionState()
+		p, err := authorize(&SPIFFEAuthorizer{}, &cs)
+		srvRes <- result{principal: p, err: err}
+	}()
+
+	cli, err := tls.Dial("tcp", l.Addr().String(), cliConf)
+	if err != nil {
+		<-srvRes
+		return "", "", err
+	}
+	defer cli.Close()
+	cs := cli.ConnectionState()
+	cliPrincipal, err := authorize(&SPIFFEAuthorizer{}, &cs)
+	if err != nil {
+		return "", "", err
+	}
+	res := <-srvRes
+
+	return cliPrincipal, res.principal, res.err
+}
+
+func TestWorkloadAPIAddrSet(t *testing.T) {
+	obj := &WorkloadAPI{Addr: "unix:///run/agent.sock"}
+
+	result := obj.addr()
+
+	assert.Equal(t, "unix:///run/agent.sock", result)
+}
+
+func TestWorkloadAPIAddrEnv(t *testing.T) {
+	defer patcher.SetVar(&getenv, func(key string) string {
+		assert.Equal(t, WorkloadAPIEnv, key)
+		return "unix:///run/env.sock"
+	}).Install().Restore()
+	obj := &WorkloadAPI{}
+
+	result := obj.addr()
+
+	assert.Equal(t, "unix:///run/env.sock", result)
+}
+
+func TestWorkloadAPIAddrDefault(t *testing.T) {
+	defer patcher.SetVar(&getenv, func(key string) string {
+		return ""
+	}).Install().Restore()
+	obj := &WorkloadAPI{}
+
+	result := obj.addr()
+
+	assert.Equal(t, DefaultWorkloadAPIAddr, result)
+}
+
+func TestWorkloadAPIRetryDefault(t *testing.T) {
+	obj := &WorkloadAPI{}
+
+	result := obj.retry()
+
+	assert.Equal(t, DefaultWorkloadAPIRetry, result)
+}
+
+func TestWorkloadAPIRetrySet(t *testing.T) {
+	obj := &WorkloadAPI{Retry: time.Second}
+
+	result := obj.retry()
+
+	assert.Equal(t, time.Second, result)
+}
+
+func TestWorkloadAPISocket(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"unix:///run/agent.sock": "/run/agent.sock",
+		"unix:/run/agent.sock":   "/run/agent.sock",
+	} {
+		obj := &WorkloadAPI{Addr: addr}
+
+		result, err := obj.socket()
+
+		assert.NoError(t, err, addr)
+		assert.Equal(t, expected, result, addr)
+	}
+}
+
+func TestWorkloadAPISocketBad(t *testing.T) {
+	for _, addr := range []string{"tcp://127.0.0.1:8081", "unix://"} {
+		obj := &WorkloadAPI{Addr: addr}
+
+		result, err := obj.socket()
+
+		assert.ErrorIs(t, err, ErrBadConfig, addr)
+		assert.Equal(t, "", result, addr)
+	}
+}
+
+func TestWorkloadAPISVIDBase(t *testing.T) {
+	ca := newWorkloadCA(t, "example.org")
+	addr, msgs := workloadServer(t, "0")
+	msgs <- protoField(nil, 1, ca.svid(t, "spiffe://example.org/web"))
+	obj := &WorkloadAPI{Addr: addr}
+	defer obj.Close()
+
+	result, err := obj.SVID(context.Background())
+
+	assert.NoError(t, err)
+	require.NotNil(t, result)
+	assert.Equal(t, "spiffe://example.org/web", result.ID)
+	assert.NotNil(t, result.Certificate.Leaf)
+	assert.Len(t, result.Certificate.Certificate, 1)
+	assert.Contains(t, result.Bundles, "example.org")
+}
+
+func TestWorkloadAPISVIDRotated(t *testing.T) {
+	ca := newWorkloadCA(t, "example.org")
+	addr, msgs := workloadServer(t, "0")
+	msgs <- protoField(nil, 1, ca.svid(t, "spiffe://example.org/web"))
+	obj := &WorkloadAPI{Addr: addr}
+	defer obj.Close()
+	first, err := obj.SVID(context.Background())
+	require.NoError(t, err)
+
+	msgs <- protoField(nil, 1, ca.svid(t, "spiffe://example.org/web2"))
+
+	assert.Eventually(t, func() bool {
+		result, err := obj.SVID(context.Background())
+		return err == nil && result != first && result.ID == "spiffe://example.org/web2"
+	}, time.Second, time.Millisecond)
+}
+
+func TestWorkloadAPISVIDTimeout(t *testing.T) {
+	addr, msgs := workloadServer(t, "0")
+	obj := &WorkloadAPI{Addr: addr, Retry: time.Millisecond}
+	defer obj.Close()
+	obj.Lock()
+	obj.start()
+	obj.Unlock()
+	close(msgs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := obj.SVID(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+}
+
+func TestWorkloadAPISVIDStatus(t *testing.T) {
+	addr, msgs := workloadServer(t, "7")
+	close(msgs)
+	obj := &WorkloadAPI{Addr: addr, Retry: time.Hour}
+	defer obj.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := obj.SVID(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, ErrWorkloadAPI)
+	assert.ErrorContains(t, err, "gRPC status 7")
+	assert.Nil(t, result)
+}
+
+func TestWorkloadAPISVIDHTTPStatus(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", filepath.Join(dir, "api.sock"))
+	require.NoError(t, err)
+	protos := &http.Protocols{}
+	protos.SetUnencryptedHTTP2(true)
+	srv := &http.Server{
+		Protocols: protos,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	}
+	go srv.Serve(l) //nolint:errcheck
+	defer srv.Close()
+	obj := &WorkloadAPI{Addr: "unix://" + l.Addr().String(), Retry: time.Hour}
+	defer obj.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := obj.SVID(ctx)
+
+	assert.ErrorIs(t, err, ErrWorkloadAPI)
+	assert.ErrorContains(t, err, "HTTP status 503")
+	assert.Nil(t, result)
+}
+
+func TestWorkloadAPISVIDClosed(t *testing.T) {
+	obj := &WorkloadAPI{Addr: "unix:///nonexistent/api.sock", Retry: time.Hour}
+	obj.Lock()
+	obj.start()
+	obj.Unlock()
+	require.NoError(t, obj.Close())
+
+	result, err := obj.SVID(context.Background())
+
+	assert.ErrorIs(t, err, ErrNoSVID)
+	assert.Nil(t, result)
+}
+
+func TestWorkloadAPIConfigHandshake(t *testing.T) {
+	ca := newWorkloadCA(t, "example.org")
+	addr1, msgs1 := workloadServer(t, "0")
+	msgs1 <- protoField(nil, 1, ca.svid(t, "spiffe://example.org/client"))
+	addr2, msgs2 := workloadServer(t, "0")
+	msgs2 <- protoField(nil, 1, ca.svid(t, "spiffe://example.org/server"))
+	cli := &WorkloadAPI{Addr: addr1}
+	defer cli.Close()
+	srv := &WorkloadAPI{Addr: addr2}
+	defer srv.Close()
+
+	cliPrincipal, srvPrincipal, err := workloadPair(t, cli.Config(&tls.Config{}), srv.Config(&tls.Config{}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/server", cliPrincipal)
+	assert.Equal(t, "spiffe://example.org/client", srvPrincipal)
+}
+
+func TestWorkloadAPIConfigFederated(t *testing.T) {
+	ca1 := newWorkloadCA(t, "one.example")
+	ca2 := newWorkloadCA(t, "two.example")
+	bundle := protoField(protoField(nil, 1, []byte("spiffe://two.example")), 2, ca2.cert.Raw)
+	addr1, msgs1 := workloadServer(t, "0")
+	msgs1 <- protoField(protoField(nil, 1, ca1.svid(t, "spiffe://one.example/client")), 3, bundle)
+	addr2, msgs2 := workloadServer(t, "0")
+	msgs2 <- protoField(nil, 1, ca2.svid(t, "spiffe://two.example/server"))
+	cli := &WorkloadAPI{Addr: addr1}
+	defer cli.Close()
+	srv := &WorkloadAPI{Addr: addr2}
+	defer srv.Close()
+
+	cliPrincipal, srvPrincipal, err := workloadPair(t, cli.Config(&tls.Config{}), srv.Config(&tls.Config{}))
+
+	assert.ErrorIs(t, err, ErrNoBundle)
+	assert.Equal(t, "spiffe://two.example/server", cliPrincipal)
+	assert.Equal(t, "", srvPrincipal)
+}
+
+func TestWorkloadAPIConfigUntrusted(t *testing.T) {
+	ca1 := newWorkloadCA(t, "example.org")
+	ca2 := newWorkloadCA(t, "example.org")
+	addr1, msgs1 := workloadServer(t, "0")
+	msgs1 <- protoField(nil, 1, ca1.svid(t, "spiffe://example.org/client"))
+	addr2, msgs2 := workloadServer(t, "0")
+	msgs2 <- protoField(nil, 1, ca2.svid(t, "spiffe://example.org/server"))
+	cli := &WorkloadAPI{Addr: addr1}
+	defer cli.Close()
+	srv := &WorkloadAPI{Addr: addr2}
+	defer srv.Close()
+
+	_, _, err := workloadPair(t, cli.Config(&tls.Config{}), srv.Config(&tls.Config{}))
+
+	var unknown x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknown)
+}
+
+func TestProtoFieldsSkip(t *testing.T) {
+	msg := binary.AppendUvarint(nil, 1<<3|0)
+	msg = binary.AppendUvarint(msg, 300)
+	msg = binary.AppendUvarint(msg, 2<<3|1)
+	msg = append(msg, make([]byte, 8)...)
+	msg = binary.AppendUvarint(msg, 3<<3|5)
+	msg = append(msg, make([]byte, 4)...)
+	msg = protoField(msg, 4, []byte("data"))
+	fields := map[uint64]string{}
+
+	err := protoFields(msg, func(num uint64, data []byte) error {
+		fields[num] = string(data)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint64]string{4: "data"}, fields)
+}
+
+func TestProtoFieldsShort(t *testing.T) {
+	for _, msg := range [][]byte{
+		{0x80},
+		{1<<3 | 0, 0x80},
+		{1<<3 | 1, 0},
+		{1<<3 | 2, 5, 'a'},
+		{1<<3 | 5, 0},
+		{1<<3 | 3},
+	} {
+		err := protoFields(msg, func(num uint64, data []byte) error {
+			return nil
+		})
+
+		assert.ErrorIs(t, err, ErrShortProto, "%v", msg)
+	}
+}
+
+func TestParseX509SVIDResponseNoSVID(t *testing.T) {
+	result, err := parseX509SVIDResponse(protoField(nil, 2, []byte("crl")))
+
+	assert.ErrorIs(t, err, ErrNoSVID)
+	assert.Nil(t, result)
+}
+
+func TestParseX509SVIDBadID(t *testing.T) {
+	result, err := parseX509SVID(protoField(nil, 1, []byte("https://example.org")))
+
+	assert.ErrorIs(t, err, ErrBadSPIFFEID)
+	assert.Nil(t, result)
+}
+
+func TestParseX509SVIDNoCert(t *testing.T) {
+	result, err := parseX509SVID(protoField(nil, 1, []byte("spiffe://example.org/web")))
+
+	assert.ErrorIs(t, err, ErrNoSVID)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package config contains support for Humboldt configuration.
+// Configuration is represented as a tree of maps, as would be
+// produced by decoding a YAML or JSON document into an
+// interface{}.
+//
+// Any configuration key may be overridden by an environment variable.
+// The variable name is formed from a prefix (normally "HUMBOLDT"),
+// followed by the path to the key, with the elements of the path
+// separated by a double underscore ("__") and converted to upper
+// case.  Single underscores are part of the key name.  For example,
+// the following variables:
+//
+//	HUMBOLDT__LISTEN=tcp://0.0.0.0:1234,udp://0.0.0.0:1234
+//	HUMBOLDT__TRANSPORT__TCP__KEEPALIVE=30s
+//	HUMBOLDT__SECURITY__TLS__CERT_FILE=/etc/humboldt/cert.pem
+//
+// would set the "listen" key of the root map, the "keepalive" key of
+// the "tcp" map within the "transport" map, and the "cert_file" key
+// of the "tls" map within the "security" map.  Intermediate maps are
+// created as needed.  If the key being set already holds a list, the
+// value is split on commas; if it holds a boolean or a number, the
+// value is converted to the same type.  Otherwise, the value is
+// stored as a string.
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Constants related to environment variable overrides.
+const (
+	EnvPrefix    = "HUMBOLDT" // Default environment variable prefix
+	EnvSeparator = "__"       // Separator between path elements
+)
+
+// envValue converts an environment variable value to the same type as
+// the existing value.
+func envValue(name, value string, existing interface{}) (interface{}, error) {
+	switch existing.(type) {
+	case []interface{}:
+		result := []interface{}{}
+		if value != "" {
+			for _, item := range strings.Split(value, ",") {
+				result = append(result, strings.TrimSpace(item))
+			}
+		}
+		return result, nil
+
+	case []string:
+		if value == "" {
+			return []string{}, nil
+		}
+		result := strings.Split(value, ",")
+		for i, item := range result {
+			result[i] = strings.TrimSpace(item)
+		}
+		return result, nil
+
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, ErrBadValue)
+		}
+		return b, nil
+
+	case int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, ErrBadValue)
+		}
+		return i, nil
+
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, ErrBadValue)
+		}
+		return f, nil
+	}
+
+	return value, nil
+}
+
+// setPath sets the value at the specified path within the tree.
+func setPath(tree map[string]interface{}, name string, path []string, value string) error {
+	node := tree
+	for _, elem := range path[:len(path)-1] {
+		next, ok := node[elem]
+		if !ok {
+			child := map[string]interface{}{}
+			node[elem] = child
+			node = child
+			continue
+		}
+
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %q: %w", name, elem, ErrNotMap)
+		}
+		node = child
+	}
+
+	// Set the value
+	key := path[len(path)-1]
+	v, err := envValue(name, value, node[key])
+	if err != nil {
+		return err
+	}
+	node[key] = v
+
+	return nil
+}
+
+// Overlay applies environment variable overrides to a configuration
+// tree.  The environment is passed as a list of "NAME=value" strings,
+// as returned by os.Environ.  Only variables beginning with the
+// prefix followed by the separator are considered.  Variables are
+// applied in order, so later variables override earlier ones.
+func Overlay(tree map[string]interface{}, prefix string, environ []string) error {
+	prefix = strings.ToUpper(prefix) + EnvSeparator
+	for _, env := range environ {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(strings.ToUpper(name), prefix) {
+			continue
+		}
+
+		// Compute the path
+		path := strings.Split(strings.ToLower(name[len(prefix):]), EnvSeparator)
+		for _, elem := range path {
+			if elem == "" {
+				return fmt.Errorf("%s: %w", name, ErrBadVariable)
+			}
+		}
+
+		if err := setPath(tree, name, path, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvValueString(t *testing.T) {
+	result, err := envValue("VAR", "value", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "value", result)
+}
+
+func TestEnvValueList(t *testing.T) {
+	result, err := envValue("VAR", "a, b,c", []interface{}{"x"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b", "c"}, result)
+}
+
+func TestEnvValueListEmpty(t *testing.T) {
+	result, err := envValue("VAR", "", []interface{}{"x"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{}, result)
+}
+
+func TestEnvValueStringList(t *testing.T) {
+	result, err := envValue("VAR", "a, b,c", []string{"x"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, result)
+}
+
+func TestEnvValueStringListEmpty(t *testing.T) {
+	result, err := envValue("VAR", "", []string{"x"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, result)
+}
+
+func TestEnvValueBool(t *testing.T) {
+	result, err := envValue("VAR", "true", false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, true, result)
+}
+
+func TestEnvValueBoolError(t *testing.T) {
+	result, err := envValue("VAR", "bogus", false)
+
+	assert.ErrorIs(t, err, ErrBadValue)
+	assert.Nil(t, result)
+}
+
+func TestEnvValueInt(t *testing.T) {
+	result, err := envValue("VAR", "42", 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 42, result)
+}
+
+func TestEnvValueIntError(t *testing.T) {
+	result, err := envValue("VAR", "bogus", 5)
+
+	assert.ErrorIs(t, err, ErrBadValue)
+	assert.Nil(t, result)
+}
+
+func TestEnvValueFloat(t *testing.T) {
+	result, err := envValue("VAR", "4.5", 5.0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4.5, result)
+}
+
+func TestEnvValueFloatError(t *testing.T) {
+	result, err := envValue("VAR", "bogus", 5.0)
+
+	assert.ErrorIs(t, err, ErrBadValue)
+	assert.Nil(t, result)
+}
+
+func TestSetPathBase(t *testing.T) {
+	tree := map[string]interface{}{
+		"transport": map[string]interface{}{
+			"tcp": map[string]interface{}{
+				"keepalive": "15s",
+				"other":     "value",
+			},
+		},
+	}
+
+	err := setPath(tree, "VAR", []string{"transport", "tcp", "keepalive"}, "30s")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"transport": map[string]interface{}{
+			"tcp": map[string]interface{}{
+				"keepalive": "30s",
+				"other":     "value",
+			},
+		},
+	}, tree)
+}
+
+func TestSetPathCreates(t *testing.T) {
+	tree := map[string]interface{}{}
+
+	err := setPath(tree, "VAR", []string{"transport", "tcp", "keepalive"}, "30s")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"transport": map[string]interface{}{
+			"tcp": map[string]interface{}{
+				"keepalive": "30s",
+			},
+		},
+	}, tree)
+}
+
+func TestSetPathNotMap(t *testing.T) {
+	tree := map[string]interface{}{
+		"transport": "tcp",
+	}
+
+	err := setPath(tree, "VAR", []string{"transport", "tcp", "keepalive"}, "30s")
+
+	assert.ErrorIs(t, err, ErrNotMap)
+}
+
+func TestSetPathBadValue(t *testing.T) {
+	tree := map[string]interface{}{
+		"debug": false,
+	}
+
+	err := setPath(tree, "VAR", []string{"debug"}, "bogus")
+
+	assert.ErrorIs(t, err, ErrBadValue)
+	assert.Equal(t, false, tree["debug"])
+}
+
+func TestOverlayBase(t *testing.T) {
+	tree := map[string]interface{}{
+		"listen": []interface{}{"tcp://0.0.0.0:1234"},
+		"debug":  false,
+	}
+
+	err := Overlay(tree, "humboldt", []string{
+		"PATH=/bin:/usr/bin",
+		"HUMBOLDT__LISTEN=tcp://0.0.0.0:1234,udp://0.0.0.0:1234",
+		"HUMBOLDT__DEBUG=true",
+		"HUMBOLDT__SECURITY__TLS__CERT_FILE=/etc/humboldt/cert.pem",
+		"HUMBOLDTISH__DEBUG=false",
+		"BOGUS",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"listen": []interface{}{"tcp://0.0.0.0:1234", "udp://0.0.0.0:1234"},
+		"debug":  true,
+		"security": map[string]interface{}{
+			"tls": map[string]interface{}{
+				"cert_file": "/etc/humboldt/cert.pem",
+			},
+		},
+	}, tree)
+}
+
+func TestOverlayEmptyElement(t *testing.T) {
+	tree := map[string]interface{}{}
+
+	err := Overlay(tree, EnvPrefix, []string{
+		"HUMBOLDT__SECURITY____CERT_FILE=/etc/humboldt/cert.pem",
+	})
+
+	assert.ErrorIs(t, err, ErrBadVariable)
+}
+
+func TestOverlaySetError(t *testing.T) {
+	tree := map[string]interface{}{
+		"debug": false,
+	}
+
+	err := Overlay(tree, EnvPrefix, []string{
+		"HUMBOLDT__DEBUG=bogus",
+	})
+
+	assert.ErrorIs(t, err, ErrBadValue)
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import "errors"
+
+// Common simple errors that may be returned by the config package.
+var (
+	ErrBadVariable = errors.New("malformed environment variable name")
+	ErrBadValue    = errors.New("value does not match the type of the configuration key")
+	ErrNotMap      = errors.New("configuration key is not a map")
+)
@@ -0,0 +1,228 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package flood distributes PDUs to every node of the Humboldt
+// overlay.  A PDU marked for flooding carries the flood extension,
+// identifying the node originating it and a sequence number, and a
+// hop limit.  Each node receiving such a PDU for the first time
+// delivers it locally and forwards it over all of its conduits except
+// the one it arrived on; duplicates arriving over other paths are
+// recognized by their origin and sequence number and discarded.
+// Flooding is used to distribute link-state records and cluster-wide
+// notifications.
+package flood
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Defaults for the Flooder.
+const (
+	DefaultTTL     uint8         = 16              // Default hop limit of originated PDUs
+	DefaultSeenTTL time.Duration = 5 * time.Minute // Default time duplicates are remembered
+)
+
+// Sender is an interface for the conduits over which PDUs are
+// flooded.  It is implemented by *conduit.Conduit.
+type Sender interface {
+	// Send sends a frame over the conduit.
+	Send(f *proto.Frame) error
+}
+
+// seenKey identifies a flooded PDU.
+type seenKey struct {
+	origin proto.NodeID // The node originating the PDU
+	seq    uint64       // The sequence number assigned by the origin
+}
+
+// Flooder floods PDUs over a set of conduits.  Conduits are added as
+// they open and removed as they close.  Received PDUs carrying the
+// flood extension must be passed to the Handle method.  The zero
+// value is ready to use with the defaults, once Self is set.
+type Flooder struct {
+	sync.Mutex
+
+	Self    proto.NodeID                      // Identifier of the local node
+	TTL     uint8                             // Hop limit of originated PDUs
+	SeenTTL time.Duration                     // Time after which duplicates are forgotten
+	Deliver func(from Sender, f *proto.Frame) // Called with each new PDU received
+
+	seq   uint64                // Sequence number of the last PDU originated
+	conns map[Sender]struct{}   // Conduits to flood over
+	seen  map[seenKey]time.Time // Arrival times of the PDUs seen
+}
+
+// Add adds a conduit to flood over.
+func (fl *Flooder) Add(s Sender) {
+	fl.Lock()
+	defer fl.Unlock()
+
+	if fl.conns == nil {
+		fl.conns = map[Sender]struct{}{}
+	}
+	fl.conns[s] = struct{}{}
+}
+
+// Remove removes a conduit to flood over.
+func (fl *Flooder) Remove(s Sender) {
+	fl.Lock()
+	defer fl.Unlock()
+
+	delete(fl.conns, s)
+}
+
+// expire forgets PDUs seen longer ago than the seen TTL.  Must be
+// called with the lock held.
+func (fl *Flooder) expire(now time.Time) {
+	ttl := fl.SeenTTL
+	if ttl <= 0 {
+		ttl = DefaultSeenTTL
+	}
+
+	for key, seen := range fl.seen {
+		if now.Sub(seen) >= ttl {
+			delete(fl.seen, key)
+		}
+	}
+}
+
+// see records a PDU as seen, returning false if it has been seen
+// already.  Must be called with the lock held.
+func (fl *Flooder) see(key seenKey) bool {
+	now := timeNow()
+	if fl.seen == nil {
+		fl.seen = map[seenKey]time.Time{}
+	}
+	fl.expire(now)
+
+	if _, ok := fl.seen[key]; ok {
+		return false
+	}
+	fl.seen[key] = now
+
+	return true
+}
+
+// targets returns the conduits to forward over, excluding the one a
+// PDU arrived on.  Must be called with the lock held.
+func (fl *Flooder) targets(from Sender) []Sender {
+	result := make([]Sender, 0, len(fl.conns))
+	for s := range fl.conns {
+		if s != from {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// withFlood returns a copy of the frame with the flood extension set
+// at the front of the extension chain, replacing any existing flood
+// extension.
+func withFlood(f *proto.Frame, ext *proto.Flood) *proto.Frame {
+	exts := proto.Extensions{ext.Extension()}
+	for _, e := range f.Extensions {
+		if e.Number != proto.ExtFlood {
+			tmp := *e
+			exts = append(exts, &tmp)
+		}
+	}
+
+	result := *f
+	result.Extensions = exts
+	result.Header.Protocol = exts.Link(f.Protocol())
+
+	return &result
+}
+
+// send sends a frame over each of the conduits, returning the joined
+// errors.
+func send(targets []Sender, f *proto.Frame) error {
+	var errs []error
+	for _, s := range targets {
+		if err := s.Send(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Flood originates a flooded PDU, adding the flood extension to a
+// copy of the frame and sending it over all the conduits.  The
+// sequence number is seeded from the clock, so that PDUs originated
+// after a restart are not mistaken for duplicates.  Errors sending
+// over individual conduits are joined and returned.
+func (fl *Flooder) Flood(f *proto.Frame) error {
+	fl.Lock()
+	if fl.seq == 0 {
+		fl.seq = uint64(timeNow().UnixNano())
+	}
+	fl.seq++
+	ext := &proto.Flood{Origin: fl.Self, Sequence: fl.seq, TTL: fl.TTL}
+	if ext.TTL == 0 {
+		ext.TTL = DefaultTTL
+	}
+	fl.see(seenKey{origin: ext.Origin, seq: ext.Sequence})
+	targets := fl.targets(nil)
+	fl.Unlock()
+
+	return send(targets, withFlood(f, ext))
+}
+
+// Handle handles a PDU received over a conduit.  If the PDU has not
+// been seen before, it is passed to the Deliver callback and, if its
+// hop limit permits, forwarded over all the conduits except the one
+// it arrived on; Handle then returns true.  Duplicates and PDUs
+// originated by the local node are discarded, and Handle returns
+// false.  PDUs without a valid flood extension are rejected with an
+// error wrapping ErrNotFlooded.  Errors forwarding over individual
+// conduits are joined and returned.
+func (fl *Flooder) Handle(from Sender, f *proto.Frame) (bool, error) {
+	e := f.Extensions.Find(proto.ExtFlood)
+	if e == nil {
+		return false, ErrNotFlooded
+	}
+	ext := &proto.Flood{}
+	if _, err := ext.FromBytes(e.Data); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrNotFlooded, err)
+	}
+
+	fl.Lock()
+	if ext.Origin == fl.Self || !fl.see(seenKey{origin: ext.Origin, seq: ext.Sequence}) {
+		fl.Unlock()
+		return false, nil
+	}
+	var targets []Sender
+	if ext.TTL > 1 {
+		targets = fl.targets(from)
+	}
+	deliver := fl.Deliver
+	fl.Unlock()
+
+	if deliver != nil {
+		deliver(from, f)
+	}
+	if len(targets) == 0 {
+		return true, nil
+	}
+	ext.TTL--
+
+	return true, send(targets, withFlood(f, ext))
+}
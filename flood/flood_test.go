@@ -0,0 +1,354 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package flood
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+type mockSender struct {
+	mock.Mock
+}
+
+func (m *mockSender) Send(f *proto.Frame) error {
+	args := m.MethodCalled("Send", f)
+
+	return args.Error(0)
+}
+
+// floodExt extracts the flood extension from a frame.
+func floodExt(t *testing.T, f *proto.Frame) *proto.Flood {
+	t.Helper()
+
+	e := f.Extensions.Find(proto.ExtFlood)
+	if e == nil {
+		t.Fatal("frame has no flood extension")
+	}
+	result := &proto.Flood{}
+	if _, err := result.FromBytes(e.Data); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// flooded constructs a flooded frame for testing.
+func flooded(origin byte, seq uint64, ttl uint8) *proto.Frame {
+	return withFlood(&proto.Frame{
+		Header:  proto.Header{Protocol: 0x17},
+		Payload: []byte("payload"),
+	}, &proto.Flood{Origin: proto.NodeID{origin}, Sequence: seq, TTL: ttl})
+}
+
+func TestFlooderAddRemove(t *testing.T) {
+	s1, s2 := &mockSender{}, &mockSender{}
+	obj := &Flooder{}
+
+	obj.Add(s1)
+	obj.Add(s2)
+	obj.Remove(s1)
+
+	assert.Equal(t, map[Sender]struct{}{s2: {}}, obj.conns)
+}
+
+func TestFlooderRemoveEmpty(t *testing.T) {
+	obj := &Flooder{}
+
+	obj.Remove(&mockSender{})
+
+	assert.Nil(t, obj.conns)
+}
+
+func TestWithFloodBase(t *testing.T) {
+	f := &proto.Frame{
+		Header:     proto.Header{Protocol: 0x81},
+		Extensions: proto.Extensions{{Number: 0x81, Header: proto.ExtHeader{Protocol: 0x17}, Data: []byte("ext")}},
+		Payload:    []byte("payload"),
+	}
+
+	result := withFlood(f, &proto.Flood{Origin: proto.NodeID{1}, Sequence: 2, TTL: 3})
+
+	assert.Equal(t, proto.ExtFlood, result.Header.Protocol)
+	assert.Len(t, result.Extensions, 2)
+	assert.Equal(t, &proto.Flood{Origin: proto.NodeID{1}, Sequence: 2, TTL: 3}, floodExt(t, result))
+	assert.Equal(t, uint8(0x81), result.Extensions[0].Header.Protocol)
+	assert.Equal(t, []byte("ext"), result.Extensions[1].Data)
+	assert.Equal(t, uint8(0x17), result.Protocol())
+	assert.Equal(t, uint8(0x17), f.Protocol())
+	assert.Len(t, f.Extensions, 1)
+}
+
+func TestWithFloodReplace(t *testing.T) {
+	f := flooded(1, 2, 3)
+
+	result := withFlood(f, &proto.Flood{Origin: proto.NodeID{1}, Sequence: 2, TTL: 2})
+
+	assert.Len(t, result.Extensions, 1)
+	assert.Equal(t, uint8(2), floodExt(t, result).TTL)
+	assert.Equal(t, uint8(3), floodExt(t, f).TTL)
+	assert.Equal(t, uint8(0x17), result.Protocol())
+}
+
+func TestFlooderFloodBase(t *testing.T) {
+	defer patcher.SetVar(&timeNow, func() time.Time { return time.Unix(0, 1000) }).Install().Restore()
+	s1, s2 := &mockSender{}, &mockSender{}
+	var sent1, sent2 *proto.Frame
+	s1.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent1 = args.Get(0).(*proto.Frame)
+	})
+	s2.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent2 = args.Get(0).(*proto.Frame)
+	})
+	obj := &Flooder{Self: proto.NodeID{1}}
+	obj.Add(s1)
+	obj.Add(s2)
+
+	err := obj.Flood(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("payload")})
+
+	assert.NoError(t, err)
+	assert.Same(t, sent1, sent2)
+	assert.Equal(t, &proto.Flood{Origin: proto.NodeID{1}, Sequence: 1001, TTL: DefaultTTL}, floodExt(t, sent1))
+	assert.Equal(t, []byte("payload"), sent1.Payload)
+	assert.Equal(t, uint8(0x17), sent1.Protocol())
+	assert.Contains(t, obj.seen, seenKey{origin: proto.NodeID{1}, seq: 1001})
+}
+
+func TestFlooderFloodSequence(t *testing.T) {
+	s := &mockSender{}
+	var sent []*proto.Frame
+	s.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent = append(sent, args.Get(0).(*proto.Frame))
+	})
+	obj := &Flooder{Self: proto.NodeID{1}, TTL: 4}
+	obj.Add(s)
+
+	assert.NoError(t, obj.Flood(&proto.Frame{Header: proto.Header{Protocol: 0x17}}))
+	assert.NoError(t, obj.Flood(&proto.Frame{Header: proto.Header{Protocol: 0x17}}))
+
+	assert.Len(t, sent, 2)
+	assert.Equal(t, floodExt(t, sent[0]).Sequence+1, floodExt(t, sent[1]).Sequence)
+	assert.Equal(t, uint8(4), floodExt(t, sent[0]).TTL)
+}
+
+func TestFlooderFloodError(t *testing.T) {
+	s1, s2 := &mockSender{}, &mockSender{}
+	s1.On("Send", mock.Anything).Return(assert.AnError)
+	s2.On("Send", mock.Anything).Return(nil)
+	obj := &Flooder{Self: proto.NodeID{1}}
+	obj.Add(s1)
+	obj.Add(s2)
+
+	err := obj.Flood(&proto.Frame{Header: proto.Header{Protocol: 0x17}})
+
+	assert.ErrorIs(t, err, assert.AnError)
+	s2.AssertCalled(t, "Send", mock.Anything)
+}
+
+func TestFlooderHandleBase(t *testing.T) {
+	from, s1, s2 := &mockSender{}, &mockSender{}, &mockSender{}
+	var sent *proto.Frame
+	s1.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent = args.Get(0).(*proto.Frame)
+	})
+	s2.On("Send", mock.Anything).Return(nil)
+	var delivered *proto.Frame
+	obj := &Flooder{
+		Self: proto.NodeID{1},
+		Deliver: func(s Sender, f *proto.Frame) {
+			assert.Same(t, from, s)
+			delivered = f
+		},
+	}
+	obj.Add(from)
+	obj.Add(s1)
+	obj.Add(s2)
+	f := flooded(2, 5, 3)
+
+	result, err := obj.Handle(from, f)
+
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Same(t, f, delivered)
+	from.AssertNotCalled(t, "Send", mock.Anything)
+	s2.AssertCalled(t, "Send", mock.Anything)
+	assert.Equal(t, &proto.Flood{Origin: proto.NodeID{2}, Sequence: 5, TTL: 2}, floodExt(t, sent))
+	assert.Equal(t, uint8(3), floodExt(t, f).TTL)
+}
+
+func TestFlooderHandleDuplicate(t *testing.T) {
+	from, s := &mockSender{}, &mockSender{}
+	s.On("Send", mock.Anything).Return(nil)
+	delivered := 0
+	obj := &Flooder{
+		Self:    proto.NodeID{1},
+		Deliver: func(s Sender, f *proto.Frame) { delivered++ },
+	}
+	obj.Add(from)
+	obj.Add(s)
+
+	result1, err1 := obj.Handle(from, flooded(2, 5, 3))
+	result2, err2 := obj.Handle(s, flooded(2, 5, 2))
+
+	assert.NoError(t, err1)
+	assert.True(t, result1)
+	assert.NoError(t, err2)
+	assert.False(t, result2)
+	assert.Equal(t, 1, delivered)
+	s.AssertNumberOfCalls(t, "Send", 1)
+	from.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+func TestFlooderHandleExpired(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time { return now }).Install().Restore()
+	delivered := 0
+	obj := &Flooder{
+		Self:    proto.NodeID{1},
+		SeenTTL: time.Minute,
+		Deliver: func(s Sender, f *proto.Frame) { delivered++ },
+	}
+
+	obj.Handle(nil, flooded(2, 5, 1)) //nolint:errcheck
+	now = now.Add(time.Minute)
+	result, err := obj.Handle(nil, flooded(2, 5, 1))
+
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, 2, delivered)
+}
+
+func TestFlooderHandleSelf(t *testing.T) {
+	s := &mockSender{}
+	obj := &Flooder{
+		Self: proto.NodeID{1},
+		Deliver: func(s Sender, f *proto.Frame) {
+			t.Error("unexpected delivery")
+		},
+	}
+	obj.Add(s)
+
+	result, err := obj.Handle(nil, flooded(1, 5, 3))
+
+	assert.NoError(t, err)
+	assert.False(t, result)
+	s.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+func TestFlooderHandleLastHop(t *testing.T) {
+	s := &mockSender{}
+	delivered := 0
+	obj := &Flooder{
+		Self:    proto.NodeID{1},
+		Deliver: func(s Sender, f *proto.Frame) { delivered++ },
+	}
+	obj.Add(s)
+
+	result, err := obj.Handle(nil, flooded(2, 5, 1))
+
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, 1, delivered)
+	s.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+func TestFlooderHandleSendError(t *testing.T) {
+	s := &mockSender{}
+	s.On("Send", mock.Anything).Return(assert.AnError)
+	obj := &Flooder{Self: proto.NodeID{1}}
+	obj.Add(s)
+
+	result, err := obj.Handle(nil, flooded(2, 5, 3))
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.True(t, result)
+}
+
+func TestFlooderHandleNotFlooded(t *testing.T) {
+	obj := &Flooder{Self: proto.NodeID{1}}
+
+	result, err := obj.Handle(nil, &proto.Frame{Header: proto.Header{Protocol: 0x17}})
+
+	assert.ErrorIs(t, err, ErrNotFlooded)
+	assert.False(t, result)
+}
+
+func TestFlooderHandleBadExtension(t *testing.T) {
+	obj := &Flooder{Self: proto.NodeID{1}}
+	f := &proto.Frame{
+		Header:     proto.Header{Protocol: proto.ExtFlood},
+		Extensions: proto.Extensions{{Number: proto.ExtFlood, Header: proto.ExtHeader{Protocol: 0x17}, Data: []byte("short")}},
+	}
+
+	result, err := obj.Handle(nil, f)
+
+	assert.ErrorIs(t, err, ErrNotFlooded)
+	assert.ErrorIs(t, err, proto.ErrShortInput)
+	assert.False(t, result)
+}
+
+func TestFlooderMesh(t *testing.T) {
+	// Four nodes in a ring with a chord: 0-1, 1-2, 2-3, 3-0, 0-2
+	nodes := make([]*Flooder, 4)
+	delivered := make([]int, 4)
+	for i := range nodes {
+		nodes[i] = &Flooder{
+			Self:    proto.NodeID{byte(i)},
+			Deliver: func(s Sender, f *proto.Frame) { delivered[i]++ },
+		}
+	}
+	link := func(a, b int) {
+		nodes[a].Add(&loopSender{from: nodes[a], to: nodes[b]})
+		nodes[b].Add(&loopSender{from: nodes[b], to: nodes[a]})
+	}
+	link(0, 1)
+	link(1, 2)
+	link(2, 3)
+	link(3, 0)
+	link(0, 2)
+
+	err := nodes[0].Flood(&proto.Frame{Header: proto.Header{Protocol: 0x17}, Payload: []byte("payload")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 1, 1}, delivered)
+}
+
+// loopSender delivers frames directly to another Flooder.
+type loopSender struct {
+	from *Flooder // The flooder sending
+	to   *Flooder // The flooder receiving
+}
+
+func (l *loopSender) Send(f *proto.Frame) error {
+	// Find the reverse link, which is the arrival conduit
+	var back Sender
+	l.to.Lock()
+	for s := range l.to.conns {
+		if s.(*loopSender).to == l.from {
+			back = s
+		}
+	}
+	l.to.Unlock()
+
+	_, err := l.to.Handle(back, f)
+
+	return err
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package gossip implements the peer exchange protocol, over which
+// Humboldt nodes periodically send the advertisement records of the
+// peers they know of to a few of their neighbors.  Received records
+// are merged into the local peer store, so that a node configured
+// with a single bootstrap URI comes to know every node of the mesh,
+// along with the canonical URIs at which each may be reached.
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// unknownKeys is the Verifier used if none is set; it knows the keys
+// of no nodes.
+var unknownKeys = proto.VerifierFunc(func(id proto.NodeID, data, sig []byte) error {
+	return fmt.Errorf("node %s: %w", id, proto.ErrUnknownKey)
+})
+
+// Defaults for the Gossiper.
+const (
+	DefaultInterval = 30 * time.Second // Default interval between gossip rounds
+	DefaultFanout   = 3                // Default neighbors gossiped to each round
+)
+
+// Sender is an interface for the conduits over which gossip is sent.
+// It is implemented by *conduit.Conduit.
+type Sender interface {
+	// Send sends a frame over the conduit.
+	Send(f *proto.Frame) error
+}
+
+// Gossiper implements the peer exchange protocol.  Each round, it
+// sends the records of the peer store to a random selection of its
+// conduits; if there are too many records to fit in a single PDU, a
+// random selection of them is sent.  Conduits are added as they open
+// and removed as they close.  Received gossip PDUs must be passed to
+// the Handle method.
+type Gossiper struct {
+	sync.Mutex
+
+	Store    *peer.Store                                  // The peer store; required
+	Self     proto.NodeID                                 // Identifier of the local node
+	Interval time.Duration                                // Interval between gossip rounds
+	Fanout   int                                          // Neighbors gossiped to each round
+	Verifier proto.Verifier                               // Verifies the signatures of received records
+	Policy   proto.AdvertPolicy                           // Treatment of unsigned and invalid records
+	OnMerge  func(a *proto.Advert, st proto.AdvertStatus) // Called with each record merged
+
+	conns map[Sender]struct{} // Conduits to gossip over
+	stop  chan struct{}       // Closed to stop the gossiper
+	done  chan struct{}       // Closed when the gossiper has stopped
+}
+
+// Add adds a conduit to gossip over.
+func (g *Gossiper) Add(s Sender) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.conns == nil {
+		g.conns = map[Sender]struct{}{}
+	}
+	g.conns[s] = struct{}{}
+}
+
+// Remove removes a conduit to gossip over.
+func (g *Gossiper) Remove(s Sender) {
+	g.Lock()
+	defer g.Unlock()
+
+	delete(g.conns, s)
+}
+
+// Start starts gossiping in the background.
+func (g *Gossiper) Start() {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.stop != nil {
+		return
+	}
+	g.stop = make(chan struct{})
+	g.done = make(chan struct{})
+
+	go g.run(g.stop, g.done)
+}
+
+// Stop stops gossiping.
+func (g *Gossiper) Stop() {
+	g.Lock()
+	stop, done := g.stop, g.done
+	g.stop, g.done = nil, nil
+	g.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// run gossips until stopped.
+func (g *Gossiper) run(stop, done chan struct{}) {
+	defer close(done)
+
+	interval := g.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.Round() //nolint:errcheck
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Round performs a single gossip round, sending a gossip PDU to a
+// random selection of the conduits.  Errors sending over individual
+// conduits are joined and returned.
+func (g *Gossiper) Round() error {
+	g.Lock()
+	fanout := g.Fanout
+	if fanout <= 0 {
+		fanout = DefaultFanout
+	}
+	targets := make([]Sender, 0, len(g.conns))
+	for s := range g.conns {
+		targets = append(targets, s)
+	}
+	g.Unlock()
+
+	if len(targets) == 0 {
+		return nil
+	}
+	shuffle(len(targets), func(i, j int) {
+		targets[i], targets[j] = targets[j], targets[i]
+	})
+	if len(targets) > fanout {
+		targets = targets[:fanout]
+	}
+
+	f, err := g.Frame()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range targets {
+		if err := s.Send(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Frame constructs a gossip PDU carrying the records of the peer
+// store.  If the records do not all fit, a random selection of them
+// is included.  Records that cannot be encoded are omitted.
+func (g *Gossiper) Frame() (*proto.Frame, error) {
+	all := g.Store.All()
+	shuffle(len(all), func(i, j int) {
+		all[i], all[j] = all[j], all[i]
+	})
+
+	adverts := make([]*proto.Advert, 0, len(all))
+	size := proto.GossipHeaderSize
+	for _, a := range all {
+		rec, err := a.Encode()
+		if err != nil || size+proto.GossipSize(len(rec)) > proto.MaxLength {
+			continue
+		}
+		size += proto.GossipSize(len(rec))
+		adverts = append(adverts, a)
+	}
+
+	return proto.GossipFrame(adverts)
+}
+
+// Handle handles a gossip PDU received over a conduit, merging the
+// records it carries into the peer store.  Records describing the
+// local node are ignored.  The signatures of the other records are
+// checked according to the policy, and the records it rejects are not
+// merged; if no Verifier is set, all signatures are treated as
+// invalid.  It returns the number of records merged, and the joined
+// errors of the rejected records.
+func (g *Gossiper) Handle(f *proto.Frame) (int, error) {
+	adverts, err := proto.DecodeGossip(f.Payload)
+	if err != nil {
+		return 0, err
+	}
+
+	v := g.Verifier
+	if v == nil {
+		v = unknownKeys
+	}
+	count := 0
+	var errs []error
+	for _, a := range adverts {
+		if a.NodeID == g.Self {
+			continue
+		}
+		st, err := a.Check(v, g.Policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("gossip: %w", err))
+			continue
+		}
+		if g.Store.Merge(a) {
+			count++
+			if g.OnMerge != nil {
+				g.OnMerge(a, st)
+			}
+		}
+	}
+
+	return count, errors.Join(errs...)
+}
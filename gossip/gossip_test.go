@@ -0,0 +1,362 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package gossip
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+)
+
+type mockSender struct {
+	mock.Mock
+}
+
+func (m *mockSender) Send(f *proto.Frame) error {
+	args := m.MethodCalled("Send", f)
+
+	return args.Error(0)
+}
+
+// noShuffle is a replacement for shuffle which leaves the order
+// unchanged.
+func noShuffle(n int, swap func(i, j int)) {}
+
+// advert constructs an advertisement record for testing.
+func advert(id byte, issued int64) *proto.Advert {
+	return &proto.Advert{
+		Version:      proto.AdvertVersion,
+		NodeID:       proto.NodeID{id},
+		Issued:       time.Unix(issued, 0),
+		URIs:         []string{"tcp://node"},
+		Capabilities: []string{},
+	}
+}
+
+// signed constructs a signed advertisement record for testing.
+func signed(t *testing.T, id byte, issued int64, priv ed25519.PrivateKey) *proto.Advert {
+	t.Helper()
+
+	a := advert(id, issued)
+	require.NoError(t, a.Sign(proto.Ed25519Signer(priv)))
+
+	return a
+}
+
+// gossipFrame constructs a gossip frame for testing.
+func gossipFrame(t *testing.T, adverts ...*proto.Advert) *proto.Frame {
+	t.Helper()
+
+	f, err := proto.GossipFrame(adverts)
+	require.NoError(t, err)
+
+	return f
+}
+
+func TestGossiperAddRemove(t *testing.T) {
+	s1, s2 := &mockSender{}, &mockSender{}
+	obj := &Gossiper{}
+
+	obj.Add(s1)
+	obj.Add(s2)
+	obj.Remove(s1)
+
+	assert.Equal(t, map[Sender]struct{}{s2: {}}, obj.conns)
+}
+
+func TestGossiperFrameBase(t *testing.T) {
+	defer patcher.SetVar(&shuffle, noShuffle).Install().Restore()
+	store := &peer.Store{}
+	store.Merge(advert(2, 100))
+	store.Merge(advert(1, 100))
+	obj := &Gossiper{Store: store}
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, gossipFrame(t, advert(1, 100), advert(2, 100)), result)
+}
+
+func TestGossiperFrameEmpty(t *testing.T) {
+	obj := &Gossiper{Store: &peer.Store{}}
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, gossipFrame(t), result)
+}
+
+func TestGossiperFrameTooMany(t *testing.T) {
+	defer patcher.SetVar(&shuffle, noShuffle).Install().Restore()
+	store := &peer.Store{}
+	for i := byte(1); i <= 3; i++ {
+		a := advert(i, 100)
+		a.URIs = []string{strings.Repeat("u", 30000)}
+		store.Merge(a)
+	}
+	obj := &Gossiper{Store: store}
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	adverts, err := proto.DecodeGossip(result.Payload)
+	assert.NoError(t, err)
+	assert.Len(t, adverts, 2)
+	assert.Equal(t, proto.NodeID{1}, adverts[0].NodeID)
+	assert.Equal(t, proto.NodeID{2}, adverts[1].NodeID)
+}
+
+func TestGossiperFrameBadRecord(t *testing.T) {
+	defer patcher.SetVar(&shuffle, noShuffle).Install().Restore()
+	store := &peer.Store{}
+	bad := advert(1, 100)
+	bad.Version = 0xff
+	store.Merge(bad)
+	store.Merge(advert(2, 100))
+	obj := &Gossiper{Store: store}
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, gossipFrame(t, advert(2, 100)), result)
+}
+
+func TestGossiperRoundBase(t *testing.T) {
+	defer patcher.SetVar(&shuffle, noShuffle).Install().Restore()
+	store := &peer.Store{}
+	store.Merge(advert(2, 100))
+	obj := &Gossiper{Store: store, Fanout: 2}
+	senders := []*mockSender{{}, {}, {}}
+	for _, s := range senders {
+		s.On("Send", mock.Anything).Return(nil)
+		obj.Add(s)
+	}
+
+	err := obj.Round()
+
+	assert.NoError(t, err)
+	calls := 0
+	for _, s := range senders {
+		calls += len(s.Calls)
+		for _, c := range s.Calls {
+			assert.Equal(t, gossipFrame(t, advert(2, 100)), c.Arguments.Get(0))
+		}
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestGossiperRoundDefaultFanout(t *testing.T) {
+	obj := &Gossiper{Store: &peer.Store{}}
+	senders := make([]*mockSender, DefaultFanout+2)
+	for i := range senders {
+		senders[i] = &mockSender{}
+		senders[i].On("Send", mock.Anything).Return(nil)
+		obj.Add(senders[i])
+	}
+
+	err := obj.Round()
+
+	assert.NoError(t, err)
+	calls := 0
+	for _, s := range senders {
+		calls += len(s.Calls)
+	}
+	assert.Equal(t, DefaultFanout, calls)
+}
+
+func TestGossiperRoundNoConduits(t *testing.T) {
+	obj := &Gossiper{}
+
+	err := obj.Round()
+
+	assert.NoError(t, err)
+}
+
+func TestGossiperRoundSendError(t *testing.T) {
+	s := &mockSender{}
+	s.On("Send", mock.Anything).Return(assert.AnError)
+	obj := &Gossiper{Store: &peer.Store{}}
+	obj.Add(s)
+
+	err := obj.Round()
+
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestGossiperStartStop(t *testing.T) {
+	sent := make(chan *proto.Frame, 10)
+	s := &mockSender{}
+	s.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent <- args.Get(0).(*proto.Frame)
+	})
+	obj := &Gossiper{Store: &peer.Store{}, Interval: time.Millisecond}
+	obj.Add(s)
+
+	obj.Start()
+	obj.Start()
+	<-sent
+	obj.Stop()
+	obj.Stop()
+
+	assert.Nil(t, obj.stop)
+	assert.Nil(t, obj.done)
+}
+
+func TestGossiperHandleVerified(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	a := signed(t, 2, 100, priv)
+	store := &peer.Store{}
+	var merged []proto.AdvertStatus
+	obj := &Gossiper{
+		Store:    store,
+		Self:     proto.NodeID{1},
+		Verifier: proto.Ed25519Keys{proto.NodeID{2}: pub},
+		OnMerge: func(a *proto.Advert, st proto.AdvertStatus) {
+			merged = append(merged, st)
+		},
+	}
+
+	result, err := obj.Handle(gossipFrame(t, a))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+	assert.Equal(t, a, store.Get(proto.NodeID{2}))
+	assert.Equal(t, []proto.AdvertStatus{proto.AdvertVerified}, merged)
+}
+
+func TestGossiperHandleSelf(t *testing.T) {
+	store := &peer.Store{}
+	obj := &Gossiper{Store: store, Self: proto.NodeID{1}, Policy: proto.AdvertAllowAll}
+
+	result, err := obj.Handle(gossipFrame(t, advert(1, 100)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestGossiperHandleStale(t *testing.T) {
+	store := &peer.Store{}
+	store.Merge(advert(2, 200))
+	obj := &Gossiper{
+		Store:  store,
+		Policy: proto.AdvertAllowUnsigned,
+		OnMerge: func(a *proto.Advert, st proto.AdvertStatus) {
+			t.Error("unexpected merge")
+		},
+	}
+
+	result, err := obj.Handle(gossipFrame(t, advert(2, 100)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, time.Unix(200, 0), store.Get(proto.NodeID{2}).Issued)
+}
+
+func TestGossiperHandleUnsignedRejected(t *testing.T) {
+	store := &peer.Store{}
+	obj := &Gossiper{Store: store}
+
+	result, err := obj.Handle(gossipFrame(t, advert(2, 100), advert(3, 100)))
+
+	assert.ErrorIs(t, err, proto.ErrUnsignedAdvert)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestGossiperHandleUnsignedAllowed(t *testing.T) {
+	store := &peer.Store{}
+	var merged []proto.AdvertStatus
+	obj := &Gossiper{
+		Store:  store,
+		Policy: proto.AdvertAllowUnsigned,
+		OnMerge: func(a *proto.Advert, st proto.AdvertStatus) {
+			merged = append(merged, st)
+		},
+	}
+
+	result, err := obj.Handle(gossipFrame(t, advert(2, 100)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result)
+	assert.Equal(t, []proto.AdvertStatus{proto.AdvertUnsigned}, merged)
+}
+
+func TestGossiperHandleNoVerifier(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	store := &peer.Store{}
+	obj := &Gossiper{Store: store, Policy: proto.AdvertAllowUnsigned}
+
+	result, err := obj.Handle(gossipFrame(t, signed(t, 2, 100, priv), advert(3, 100)))
+
+	assert.ErrorIs(t, err, proto.ErrBadSignature)
+	assert.ErrorIs(t, err, proto.ErrUnknownKey)
+	assert.Equal(t, 1, result)
+	assert.Nil(t, store.Get(proto.NodeID{2}))
+	assert.NotNil(t, store.Get(proto.NodeID{3}))
+}
+
+func TestGossiperHandleBadPayload(t *testing.T) {
+	obj := &Gossiper{Store: &peer.Store{}}
+
+	result, err := obj.Handle(&proto.Frame{Header: proto.Header{Protocol: proto.ProtoGossip}, Payload: []byte{0x00}})
+
+	assert.ErrorIs(t, err, proto.ErrShortInput)
+	assert.Equal(t, 0, result)
+}
+
+// loopSender delivers frames directly to another Gossiper.
+type loopSender struct {
+	to *Gossiper // The gossiper receiving
+}
+
+func (l *loopSender) Send(f *proto.Frame) error {
+	_, err := l.to.Handle(f)
+
+	return err
+}
+
+func TestGossiperConverge(t *testing.T) {
+	// Nodes in a line: 0 - 1 - 2 - 3; each knows only itself
+	nodes := make([]*Gossiper, 4)
+	for i := range nodes {
+		store := &peer.Store{}
+		store.Merge(advert(byte(i), 100))
+		nodes[i] = &Gossiper{Store: store, Self: proto.NodeID{byte(i)}, Policy: proto.AdvertAllowUnsigned}
+	}
+	for i := 0; i+1 < len(nodes); i++ {
+		nodes[i].Add(&loopSender{to: nodes[i+1]})
+		nodes[i+1].Add(&loopSender{to: nodes[i]})
+	}
+
+	for round := 0; round < len(nodes); round++ {
+		for _, g := range nodes {
+			assert.NoError(t, g.Round())
+		}
+	}
+
+	for _, g := range nodes {
+		assert.Equal(t, len(nodes), g.Store.Len())
+	}
+}
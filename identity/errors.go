@@ -0,0 +1,27 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package identity
+
+import "errors"
+
+// Common simple errors that may be returned by the identity package.
+var (
+	ErrBadKey      = errors.New("identity key is not valid")
+	ErrBadRotation = errors.New("key rotation is not valid")
+	ErrWrongNode   = errors.New("key does not belong to the node")
+	ErrBadProof    = errors.New("proof of possession is not valid")
+	ErrBadEncoding = errors.New("identity encoding is not valid")
+	ErrUnknownNode = errors.New("node is not known")
+)
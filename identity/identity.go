@@ -0,0 +1,274 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package identity contains support for the identities of Humboldt
+// nodes.  A node's identity is an Ed25519 key pair; its node ID is
+// derived from the public key.  The key may be rotated: each rotation
+// is signed by the key it replaces, so peers presented with the chain
+// of rotations can follow it from the key the node ID was derived
+// from to the current key, and the node ID never changes.
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Context strings separating the uses of the identity key.
+const (
+	rotationContext = "humboldt key rotation\x00"
+	proofContext    = "humboldt proof of possession\x00"
+)
+
+// PEM block types used by Marshal.
+const (
+	PEMKey      = "PRIVATE KEY"
+	PEMRotation = "HUMBOLDT KEY ROTATION"
+)
+
+// RotationSize is the size of an encoded key rotation.
+const RotationSize = 2*ed25519.PublicKeySize + ed25519.SignatureSize
+
+// NodeIDFor derives the node ID from a public key.  The node ID is
+// the leading bytes of the SHA-256 digest of the key.
+func NodeIDFor(pub ed25519.PublicKey) proto.NodeID {
+	sum := sha256.Sum256(pub)
+
+	var id proto.NodeID
+	copy(id[:], sum[:])
+
+	return id
+}
+
+// Rotation records the replacement of one identity key by another.
+// It is signed by the key being replaced.
+type Rotation struct {
+	Old       ed25519.PublicKey // The key being replaced
+	New       ed25519.PublicKey // The replacement key
+	Signature []byte            // Signature of the new key by the old
+}
+
+// Verify verifies the signature of the rotation.
+func (r *Rotation) Verify() error {
+	if len(r.Old) != ed25519.PublicKeySize || len(r.New) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: %w", ErrBadRotation, ErrBadKey)
+	}
+	if !ed25519.Verify(r.Old, append([]byte(rotationContext), r.New...), r.Signature) {
+		return ErrBadRotation
+	}
+
+	return nil
+}
+
+// Bytes returns the encoding of the rotation, which is the old key,
+// the new key, and the signature.
+func (r *Rotation) Bytes() []byte {
+	data := make([]byte, 0, RotationSize)
+	data = append(data, r.Old...)
+	data = append(data, r.New...)
+
+	return append(data, r.Signature...)
+}
+
+// ParseRotation decodes a rotation encoded by Bytes.
+func ParseRotation(data []byte) (*Rotation, error) {
+	if len(data) != RotationSize {
+		return nil, ErrBadEncoding
+	}
+
+	return &Rotation{
+		Old:       bytes.Clone(data[:ed25519.PublicKeySize]),
+		New:       bytes.Clone(data[ed25519.PublicKeySize : 2*ed25519.PublicKeySize]),
+		Signature: bytes.Clone(data[2*ed25519.PublicKeySize:]),
+	}, nil
+}
+
+// CurrentKey follows a chain of rotations from the key a node ID was
+// derived from, returning the current key of the node.  If there are
+// no rotations, root is the current key; otherwise, root may be nil,
+// in which case the old key of the first rotation is used.
+func CurrentKey(id proto.NodeID, root ed25519.PublicKey, rotations []*Rotation) (ed25519.PublicKey, error) {
+	if root == nil && len(rotations) > 0 {
+		root = rotations[0].Old
+	}
+	if len(root) != ed25519.PublicKeySize {
+		return nil, ErrBadKey
+	}
+	if NodeIDFor(root) != id {
+		return nil, fmt.Errorf("node %s: %w", id, ErrWrongNode)
+	}
+
+	key := root
+	for i, r := range rotations {
+		if !key.Equal(r.Old) {
+			return nil, fmt.Errorf("node %s: rotation %d: %w", id, i, ErrBadRotation)
+		}
+		if err := r.Verify(); err != nil {
+			return nil, fmt.Errorf("node %s: rotation %d: %w", id, i, err)
+		}
+		key = r.New
+	}
+
+	return key, nil
+}
+
+// Identity is the identity of a node.
+type Identity struct {
+	ID        proto.NodeID       // The node ID
+	Key       ed25519.PrivateKey // The current identity key
+	Rotations []*Rotation        // Rotations from the original key
+}
+
+// Generate generates a new identity.
+func Generate() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(randReader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		ID:  NodeIDFor(key.Public().(ed25519.PublicKey)),
+		Key: key,
+	}, nil
+}
+
+// PublicKey returns the current public key of the identity.
+func (i *Identity) PublicKey() ed25519.PublicKey {
+	return i.Key.Public().(ed25519.PublicKey)
+}
+
+// RootKey returns the public key the node ID was derived from.
+func (i *Identity) RootKey() ed25519.PublicKey {
+	if len(i.Rotations) > 0 {
+		return i.Rotations[0].Old
+	}
+
+	return i.PublicKey()
+}
+
+// Rotate replaces the identity key with a newly generated key,
+// recording the rotation.  The node ID does not change.
+func (i *Identity) Rotate() error {
+	_, key, err := ed25519.GenerateKey(randReader)
+	if err != nil {
+		return err
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	i.Rotations = append(i.Rotations, &Rotation{
+		Old:       i.PublicKey(),
+		New:       pub,
+		Signature: ed25519.Sign(i.Key, append([]byte(rotationContext), pub...)),
+	})
+	i.Key = key
+
+	return nil
+}
+
+// Signer returns a signer for advertisement records using the current
+// identity key.
+func (i *Identity) Signer() proto.Signer {
+	return proto.Ed25519Signer(i.Key)
+}
+
+// Prove proves possession of the identity key by signing a challenge
+// issued by a peer, such as during a handshake.
+func (i *Identity) Prove(challenge []byte) []byte {
+	return ed25519.Sign(i.Key, append([]byte(proofContext), challenge...))
+}
+
+// VerifyProof verifies a proof of possession of the key produced by
+// Prove.
+func VerifyProof(key ed25519.PublicKey, challenge, proof []byte) error {
+	if len(key) != ed25519.PublicKeySize {
+		return ErrBadKey
+	}
+	if !ed25519.Verify(key, append([]byte(proofContext), challenge...), proof) {
+		return ErrBadProof
+	}
+
+	return nil
+}
+
+// Marshal encodes the identity as PEM: the current key as a PKCS #8
+// private key, followed by the rotations.
+func (i *Identity) Marshal() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(i.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	pem.Encode(buf, &pem.Block{Type: PEMKey, Bytes: der}) //nolint:errcheck
+	for _, r := range i.Rotations {
+		pem.Encode(buf, &pem.Block{Type: PEMRotation, Bytes: r.Bytes()}) //nolint:errcheck
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Parse decodes an identity encoded by Marshal.  The rotations are
+// verified, and the node ID is derived from the original key.
+func Parse(data []byte) (*Identity, error) {
+	var key ed25519.PrivateKey
+	var rotations []*Rotation
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case PEMKey:
+			tmp, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrBadEncoding, err)
+			}
+			var ok bool
+			if key, ok = tmp.(ed25519.PrivateKey); !ok {
+				return nil, fmt.Errorf("%w: %w", ErrBadEncoding, ErrBadKey)
+			}
+
+		case PEMRotation:
+			r, err := ParseRotation(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			rotations = append(rotations, r)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: no key", ErrBadEncoding)
+	}
+
+	// Verify the rotations lead to the key
+	i := &Identity{Key: key, Rotations: rotations}
+	i.ID = NodeIDFor(i.RootKey())
+	current, err := CurrentKey(i.ID, i.RootKey(), rotations)
+	if err != nil {
+		return nil, err
+	}
+	if !current.Equal(i.PublicKey()) {
+		return nil, fmt.Errorf("%w: key does not match rotations", ErrBadRotation)
+	}
+
+	return i, nil
+}
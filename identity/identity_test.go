@@ -0,0 +1,286 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// testIdentity generates an identity with the specified number of
+// rotations.
+func testIdentity(t *testing.T, rotations int) *Identity {
+	i, err := Generate()
+	require.NoError(t, err)
+	for j := 0; j < rotations; j++ {
+		require.NoError(t, i.Rotate())
+	}
+
+	return i
+}
+
+func TestNodeIDFor(t *testing.T) {
+	pub := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+
+	result := NodeIDFor(pub)
+
+	sum := sha256.Sum256(pub)
+	assert.Equal(t, sum[:proto.NodeIDSize], result[:])
+}
+
+func TestGenerate(t *testing.T) {
+	result, err := Generate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeIDFor(result.PublicKey()), result.ID)
+	assert.Equal(t, result.PublicKey(), result.RootKey())
+	assert.Empty(t, result.Rotations)
+}
+
+func TestGenerateError(t *testing.T) {
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+
+	result, err := Generate()
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+}
+
+func TestIdentityRotateError(t *testing.T) {
+	obj := testIdentity(t, 1)
+	key := obj.Key
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+
+	err := obj.Rotate()
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, key, obj.Key)
+	assert.Len(t, obj.Rotations, 1)
+}
+
+func TestIdentityRotate(t *testing.T) {
+	obj := testIdentity(t, 0)
+	id, root := obj.ID, obj.PublicKey()
+
+	err := obj.Rotate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, id, obj.ID)
+	assert.Equal(t, root, obj.RootKey())
+	assert.False(t, root.Equal(obj.PublicKey()))
+	assert.Len(t, obj.Rotations, 1)
+	assert.NoError(t, obj.Rotations[0].Verify())
+}
+
+func TestRotationVerifyBadKey(t *testing.T) {
+	obj := &Rotation{Old: ed25519.PublicKey("short")}
+
+	err := obj.Verify()
+
+	assert.ErrorIs(t, err, ErrBadRotation)
+	assert.ErrorIs(t, err, ErrBadKey)
+}
+
+func TestRotationVerifyBadSignature(t *testing.T) {
+	obj := testIdentity(t, 1).Rotations[0]
+	obj.Signature[0] ^= 0xff
+
+	err := obj.Verify()
+
+	assert.ErrorIs(t, err, ErrBadRotation)
+}
+
+func TestRotationBytes(t *testing.T) {
+	obj := testIdentity(t, 1).Rotations[0]
+
+	data := obj.Bytes()
+	result, err := ParseRotation(data)
+
+	assert.Len(t, data, RotationSize)
+	assert.NoError(t, err)
+	assert.Equal(t, obj, result)
+}
+
+func TestParseRotationShort(t *testing.T) {
+	result, err := ParseRotation(make([]byte, RotationSize-1))
+
+	assert.ErrorIs(t, err, ErrBadEncoding)
+	assert.Nil(t, result)
+}
+
+func TestCurrentKeyBase(t *testing.T) {
+	obj := testIdentity(t, 2)
+
+	result, err := CurrentKey(obj.ID, nil, obj.Rotations)
+
+	assert.NoError(t, err)
+	assert.Equal(t, obj.PublicKey(), result)
+}
+
+func TestCurrentKeyNoRotations(t *testing.T) {
+	obj := testIdentity(t, 0)
+
+	result, err := CurrentKey(obj.ID, obj.PublicKey(), nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, obj.PublicKey(), result)
+}
+
+func TestCurrentKeyWrongNode(t *testing.T) {
+	obj := testIdentity(t, 0)
+
+	result, err := CurrentKey(proto.NodeID{}, obj.PublicKey(), nil)
+
+	assert.ErrorIs(t, err, ErrWrongNode)
+	assert.Nil(t, result)
+}
+
+func TestCurrentKeyBrokenChain(t *testing.T) {
+	obj := testIdentity(t, 2)
+	other := testIdentity(t, 1)
+
+	result, err := CurrentKey(obj.ID, nil, []*Rotation{obj.Rotations[0], other.Rotations[0]})
+
+	assert.ErrorIs(t, err, ErrBadRotation)
+	assert.Nil(t, result)
+}
+
+func TestCurrentKeyForgedRotation(t *testing.T) {
+	obj := testIdentity(t, 2)
+	obj.Rotations[1].Signature[0] ^= 0xff
+
+	result, err := CurrentKey(obj.ID, nil, obj.Rotations)
+
+	assert.ErrorIs(t, err, ErrBadRotation)
+	assert.ErrorContains(t, err, "rotation 1")
+	assert.Nil(t, result)
+}
+
+func TestCurrentKeyBadKey(t *testing.T) {
+	result, err := CurrentKey(proto.NodeID{}, nil, nil)
+
+	assert.ErrorIs(t, err, ErrBadKey)
+	assert.Nil(t, result)
+}
+
+func TestIdentityProve(t *testing.T) {
+	obj := testIdentity(t, 1)
+
+	proof := obj.Prove([]byte("challenge"))
+
+	assert.NoError(t, VerifyProof(obj.PublicKey(), []byte("challenge"), proof))
+	assert.ErrorIs(t, VerifyProof(obj.PublicKey(), []byte("other"), proof), ErrBadProof)
+	assert.ErrorIs(t, VerifyProof(obj.RootKey(), []byte("challenge"), proof), ErrBadProof)
+	assert.ErrorIs(t, VerifyProof(nil, []byte("challenge"), proof), ErrBadKey)
+}
+
+func TestIdentitySigner(t *testing.T) {
+	obj := testIdentity(t, 0)
+	a := &proto.Advert{NodeID: obj.ID, URIs: []string{"tcp://127.0.0.1:1"}}
+
+	err := a.Sign(obj.Signer())
+
+	assert.NoError(t, err)
+	assert.NoError(t, a.Verify(proto.Ed25519Keys{obj.ID: obj.PublicKey()}))
+}
+
+func TestIdentityMarshal(t *testing.T) {
+	obj := testIdentity(t, 2)
+
+	data, err := obj.Marshal()
+	require.NoError(t, err)
+	result, err := Parse(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, obj, result)
+}
+
+func TestParseNoKey(t *testing.T) {
+	result, err := Parse(nil)
+
+	assert.ErrorIs(t, err, ErrBadEncoding)
+	assert.Nil(t, result)
+}
+
+func TestParseBadKey(t *testing.T) {
+	data := pem.EncodeToMemory(&pem.Block{Type: PEMKey, Bytes: []byte("bad")})
+
+	result, err := Parse(data)
+
+	assert.ErrorIs(t, err, ErrBadEncoding)
+	assert.Nil(t, result)
+}
+
+func TestParseMismatchedRotations(t *testing.T) {
+	obj := testIdentity(t, 1)
+	obj.Key = testIdentity(t, 0).Key
+	data, err := obj.Marshal()
+	require.NoError(t, err)
+
+	result, err := Parse(data)
+
+	assert.ErrorIs(t, err, ErrBadRotation)
+	assert.Nil(t, result)
+}
+
+func TestParseNotEd25519(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: PEMKey, Bytes: der})
+
+	result, err := Parse(data)
+
+	assert.ErrorIs(t, err, ErrBadEncoding)
+	assert.ErrorIs(t, err, ErrBadKey)
+	assert.Nil(t, result)
+}
+
+func TestParseBadRotation(t *testing.T) {
+	data, err := testIdentity(t, 0).Marshal()
+	require.NoError(t, err)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: PEMRotation, Bytes: []byte("short")})...)
+
+	result, err := Parse(data)
+
+	assert.ErrorIs(t, err, ErrBadEncoding)
+	assert.Nil(t, result)
+}
+
+func TestParseForgedRotation(t *testing.T) {
+	obj := testIdentity(t, 1)
+	obj.Rotations[0].Signature[0] ^= 0xff
+	data, err := obj.Marshal()
+	require.NoError(t, err)
+
+	result, err := Parse(data)
+
+	assert.ErrorIs(t, err, ErrBadRotation)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package identity
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Keyring tracks the current identity keys of known peers.  A peer
+// that rotates its key continues to be recognized under the same
+// node ID once the rotations are learned.  Its Lookup method may be
+// used with proto.Ed25519Verifier to verify advertisement records.
+type Keyring struct {
+	sync.RWMutex
+
+	keys map[proto.NodeID]ed25519.PublicKey // Current keys
+	seen map[proto.NodeID]int               // Rotations learned
+}
+
+// Learn learns the current key of a node from the key its node ID
+// was derived from and the chain of rotations.  Chains shorter than
+// one already learned are ignored, so that a stale chain cannot
+// restore a retired key.
+func (k *Keyring) Learn(id proto.NodeID, root ed25519.PublicKey, rotations []*Rotation) error {
+	key, err := CurrentKey(id, root, rotations)
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	if k.keys == nil {
+		k.keys = map[proto.NodeID]ed25519.PublicKey{}
+		k.seen = map[proto.NodeID]int{}
+	}
+	if n, ok := k.seen[id]; ok && n > len(rotations) {
+		return nil
+	}
+	k.keys[id] = key
+	k.seen[id] = len(rotations)
+
+	return nil
+}
+
+// Forget forgets the key of a node.
+func (k *Keyring) Forget(id proto.NodeID) {
+	k.Lock()
+	defer k.Unlock()
+
+	delete(k.keys, id)
+	delete(k.seen, id)
+}
+
+// Lookup returns the current key of a node.
+func (k *Keyring) Lookup(id proto.NodeID) (ed25519.PublicKey, error) {
+	k.RLock()
+	defer k.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("node %s: %w: %w", id, ErrUnknownNode, proto.ErrUnknownKey)
+	}
+
+	return key, nil
+}
+
+// Verifier returns a verifier for advertisement records using the
+// keys in the keyring.
+func (k *Keyring) Verifier() proto.Verifier {
+	return proto.Ed25519Verifier(k.Lookup)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package identity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestKeyringLearnRotated(t *testing.T) {
+	i := testIdentity(t, 0)
+	obj := &Keyring{}
+	assert.NoError(t, obj.Learn(i.ID, i.PublicKey(), nil))
+	assert.NoError(t, i.Rotate())
+
+	err := obj.Learn(i.ID, nil, i.Rotations)
+
+	assert.NoError(t, err)
+	result, err := obj.Lookup(i.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, i.PublicKey(), result)
+}
+
+func TestKeyringLearnStale(t *testing.T) {
+	i := testIdentity(t, 2)
+	obj := &Keyring{}
+	assert.NoError(t, obj.Learn(i.ID, nil, i.Rotations))
+
+	err := obj.Learn(i.ID, nil, i.Rotations[:1])
+
+	assert.NoError(t, err)
+	result, err := obj.Lookup(i.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, i.PublicKey(), result)
+}
+
+func TestKeyringLearnInvalid(t *testing.T) {
+	obj := &Keyring{}
+
+	err := obj.Learn(proto.NodeID{}, testIdentity(t, 0).PublicKey(), nil)
+
+	assert.ErrorIs(t, err, ErrWrongNode)
+}
+
+func TestKeyringForget(t *testing.T) {
+	i := testIdentity(t, 0)
+	obj := &Keyring{}
+	assert.NoError(t, obj.Learn(i.ID, i.PublicKey(), nil))
+
+	obj.Forget(i.ID)
+
+	result, err := obj.Lookup(i.ID)
+	assert.ErrorIs(t, err, ErrUnknownNode)
+	assert.ErrorIs(t, err, proto.ErrUnknownKey)
+	assert.Nil(t, result)
+}
+
+func TestKeyringVerifier(t *testing.T) {
+	i := testIdentity(t, 1)
+	obj := &Keyring{}
+	assert.NoError(t, obj.Learn(i.ID, nil, i.Rotations))
+	a := &proto.Advert{NodeID: i.ID}
+	assert.NoError(t, a.Sign(i.Signer()))
+
+	err := a.Verify(obj.Verifier())
+
+	assert.NoError(t, err)
+}
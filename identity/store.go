@@ -0,0 +1,91 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package identity
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// Store is an interface for storage of an encoded identity.  Stores
+// backed by an operating system keystore may be implemented by
+// applications.
+type Store interface {
+	// Load loads the encoded identity.  If no identity has been
+	// stored, it returns an error wrapping fs.ErrNotExist.
+	Load() ([]byte, error)
+
+	// Save saves the encoded identity.
+	Save(data []byte) error
+}
+
+// FileStore is a Store keeping the identity in a file.
+type FileStore string
+
+// Load loads the encoded identity.
+func (f FileStore) Load() ([]byte, error) {
+	return readFile(string(f))
+}
+
+// Save saves the encoded identity.  The file is replaced atomically,
+// and is readable only by its owner.
+func (f FileStore) Save(data []byte) error {
+	tmp := filepath.Join(filepath.Dir(string(f)), "."+filepath.Base(string(f))+".tmp")
+	if err := writeFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return rename(tmp, string(f))
+}
+
+// Load loads an identity from a store.
+func Load(s Store) (*Identity, error) {
+	data, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// LoadOrGenerate loads an identity from a store, generating and
+// saving a new identity if the store is empty.
+func LoadOrGenerate(s Store) (*Identity, error) {
+	i, err := Load(s)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return i, err
+	}
+
+	if i, err = Generate(); err != nil {
+		return nil, err
+	}
+	if err := i.Save(s); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Save saves the identity to a store.  It should be called after
+// Rotate.
+func (i *Identity) Save(s Store) error {
+	data, err := i.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return s.Save(data)
+}
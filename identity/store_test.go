@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package identity
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.pem")
+	obj := FileStore(path)
+
+	err := obj.Save([]byte("data"))
+
+	assert.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+	result, err := obj.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), result)
+}
+
+func TestFileStoreSaveWriteError(t *testing.T) {
+	defer patcher.SetVar(&writeFile, func(name string, data []byte, perm os.FileMode) error {
+		return assert.AnError
+	}).Install().Restore()
+
+	err := FileStore("identity.pem").Save([]byte("data"))
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestLoadOrGenerateNew(t *testing.T) {
+	obj := FileStore(filepath.Join(t.TempDir(), "identity.pem"))
+
+	result, err := LoadOrGenerate(obj)
+
+	assert.NoError(t, err)
+	loaded, err := Load(obj)
+	assert.NoError(t, err)
+	assert.Equal(t, result, loaded)
+}
+
+func TestLoadOrGenerateExisting(t *testing.T) {
+	obj := FileStore(filepath.Join(t.TempDir(), "identity.pem"))
+	i := testIdentity(t, 1)
+	require.NoError(t, i.Save(obj))
+
+	result, err := LoadOrGenerate(obj)
+
+	assert.NoError(t, err)
+	assert.Equal(t, i, result)
+}
+
+func TestLoadOrGenerateLoadError(t *testing.T) {
+	defer patcher.SetVar(&readFile, func(name string) ([]byte, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := LoadOrGenerate(FileStore("identity.pem"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestLoadOrGenerateGenerateError(t *testing.T) {
+	defer patcher.SetVar(&randReader, iotest.ErrReader(assert.AnError)).Install().Restore()
+
+	result, err := LoadOrGenerate(FileStore(filepath.Join(t.TempDir(), "identity.pem")))
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, result)
+}
+
+func TestLoadOrGenerateSaveError(t *testing.T) {
+	defer patcher.SetVar(&writeFile, func(name string, data []byte, perm os.FileMode) error {
+		return assert.AnError
+	}).Install().Restore()
+
+	result, err := LoadOrGenerate(FileStore(filepath.Join(t.TempDir(), "identity.pem")))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
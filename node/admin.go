@@ -0,0 +1,339 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+	"github.com/hydralang/humboldt/routing"
+)
+
+// AdminService is the name of the JSON-RPC service served on the
+// administrative control socket.  Its methods are:
+//
+//	Admin.Conduits  lists the open conduits, with their statistics
+//	Admin.Routes    lists the routes of the routing table
+//	Admin.Dial      adds a peer, given its URI, and dials it
+//	Admin.Drop      closes the conduit to a peer, given its node ID
+//	Admin.Stats     summarizes the statistics of the node, including
+//	                the latencies of client calls by destination
+//	Admin.Capture   captures PDUs to a file, given its path, or stops
+//	                capturing, given an empty path
+//
+// Methods taking no argument accept an empty object.
+const AdminService = "Admin"
+
+// ConduitInfo describes a conduit open on a node, as listed by the
+// Admin.Conduits operation.
+type ConduitInfo struct {
+	Peer      proto.NodeID  `json:"peer"`          // Node ID of the peer, if known
+	URI       string        `json:"uri,omitempty"` // URI the peer was added with, if any
+	Client    bool          `json:"client"`        // Conduit is from a client
+	Inbound   bool          `json:"inbound"`       // Conduit was dialed by the peer
+	Principal string        `json:"principal"`     // Name of the principal from security layer
+	LocalURI  string        `json:"local_uri"`     // Local conduit URI
+	RemoteURI string        `json:"remote_uri"`    // Remote conduit URI
+	Stats     conduit.Stats `json:"stats"`         // Statistics of the conduit
+}
+
+// NodeStats summarizes the statistics of a node, as returned by the
+// Admin.Stats operation.
+type NodeStats struct {
+	NodeID  proto.NodeID                              `json:"node_id"`           // Identifier of the node
+	Peers   int                                       `json:"peers"`             // Conduits open to peer nodes
+	Clients int                                       `json:"clients"`           // Conduits open from clients
+	Routes  int                                       `json:"routes"`            // Routes in the routing table
+	Totals  conduit.Stats                             `json:"totals"`            // Sums of the statistics of the open conduits
+	Latency map[proto.NodeID]*proto.HistogramSnapshot `json:"latency,omitempty"` // Round-trip times of client calls, by destination
+}
+
+// adminAddr splits an administrative control socket address into a
+// network and an address.  Addresses of the form "unix:/path" name
+// Unix domain sockets; other addresses are TCP addresses, which must
+// be on the loopback interface, so that the socket is not exposed to
+// the network.
+func adminAddr(addr string) (string, string, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return "", "", fmt.Errorf("%q: %w", addr, ErrAdminAddr)
+		}
+		return "unix", path, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("%q: %w: %w", addr, ErrAdminAddr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", "", fmt.Errorf("%q: %w", addr, ErrAdminAddr)
+	}
+
+	return "tcp", addr, nil
+}
+
+// ListenAdmin opens a listener for an administrative control socket.
+// The address is either "unix:" followed by the path of a Unix domain
+// socket, or a TCP address on the loopback interface, such as
+// "127.0.0.1:7070".
+func ListenAdmin(addr string) (net.Listener, error) {
+	network, address, err := adminAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.Listen(network, address)
+}
+
+// admin implements the administrative operations of a node.
+type admin struct {
+	n *Node // The node
+}
+
+// Conduits lists the conduits open to peer nodes, followed by those
+// from clients.
+func (a *admin) Conduits(args struct{}, reply *[]ConduitInfo) error {
+	*reply = a.n.conduits()
+	return nil
+}
+
+// Routes lists the routes of the routing table.
+func (a *admin) Routes(args struct{}, reply *[]routing.Route) error {
+	*reply = a.n.Routes.Routes()
+	return nil
+}
+
+// Dial adds a peer with the specified URI, which is then dialed.
+// Peers added this way are not saved to the configuration, and are
+// removed if the configuration is reloaded without them.
+func (a *admin) Dial(uri string, reply *struct{}) error {
+	return a.n.Manager.AddPeer(uri)
+}
+
+// Drop closes the conduit to the specified peer node, notifying the
+// peer with ErrDropped.  If the peer was added, it is dialed again.
+func (a *admin) Drop(id proto.NodeID, reply *struct{}) error {
+	c := a.n.Manager.Conduit(id)
+	if c == nil {
+		return fmt.Errorf("%s: %w", id, ErrNoConduit)
+	}
+
+	return c.CloseWithReason(ErrDropped)
+}
+
+// Stats summarizes the statistics of the node.
+func (a *admin) Stats(args struct{}, reply *NodeStats) error {
+	*reply = NodeStats{
+		NodeID:  a.n.ID,
+		Routes:  len(a.n.Routes.Routes()),
+		Latency: a.n.Latency.Snapshot(),
+	}
+	for _, info := range a.n.conduits() {
+		if info.Client {
+			reply.Clients++
+		} else {
+			reply.Peers++
+		}
+		t, s := &reply.Totals, info.Stats
+		t.BytesIn += s.BytesIn
+		t.BytesOut += s.BytesOut
+		t.PDUsIn += s.PDUsIn
+		t.PDUsOut += s.PDUsOut
+		t.Errors += s.Errors
+		t.Reconnects += s.Reconnects
+		if s.LastActivity.After(t.LastActivity) {
+			t.LastActivity = s.LastActivity
+		}
+	}
+
+	return nil
+}
+
+// Capture starts capturing the PDUs exchanged over the node's
+// conduits to a new file at the specified path, on the node's host;
+// an empty path stops capturing.  The file must be in the capture
+// directory of the node's configuration.
+func (a *admin) Capture(path string, reply *struct{}) error {
+	if path == "" {
+		return a.n.StopCapture()
+	}
+
+	path, err := a.n.capturePath(path)
+	if err != nil {
+		return err
+	}
+
+	return a.n.StartCapture(path)
+}
+
+// conduitInfo describes a conduit.
+func conduitInfo(c *conduit.Conduit) ConduitInfo {
+	id, _ := c.Peer.(proto.NodeID)
+	info := ConduitInfo{
+		Peer:      id,
+		Principal: c.Principal,
+		Stats:     c.Stats(),
+	}
+	if c.LocalURI != nil {
+		info.LocalURI = c.LocalURI.String()
+	}
+	if c.RemoteURI != nil {
+		info.RemoteURI = c.RemoteURI.String()
+	}
+
+	return info
+}
+
+// conduits describes the conduits open to peer nodes, followed by
+// those from clients, sorted by remote URI.
+func (n *Node) conduits() []ConduitInfo {
+	result := []ConduitInfo{}
+	for _, p := range n.Manager.Peers() {
+		if p.Conduit == nil {
+			continue
+		}
+		info := conduitInfo(p.Conduit)
+		info.URI = p.URI
+		info.Inbound = p.Inbound
+		result = append(result, info)
+	}
+
+	n.lock.Lock()
+	clients := make([]ConduitInfo, 0, len(n.clients))
+	for c := range n.clients {
+		info := conduitInfo(c)
+		info.Client = true
+		info.Inbound = true
+		clients = append(clients, info)
+	}
+	n.lock.Unlock()
+	slices.SortFunc(clients, func(a, b ConduitInfo) int {
+		return strings.Compare(a.RemoteURI, b.RemoteURI)
+	})
+
+	return append(result, clients...)
+}
+
+// serveAdmin serves the administrative control socket until the
+// context is cancelled.
+func (n *Node) serveAdmin(ctx context.Context, l net.Listener) {
+	srv := rpc.NewServer()
+	srv.RegisterName(AdminService, &admin{n: n}) //nolint:errcheck
+	stop := context.AfterFunc(ctx, func() {
+		l.Close() //nolint:errcheck
+	})
+	defer stop()
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() {
+				conn.Close() //nolint:errcheck
+			})
+			defer stop()
+			srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}()
+	}
+}
+
+// AdminClient is a client of the administrative control socket of a
+// node.  Errors returned by the node are reported as rpc.ServerError
+// values carrying the text of the error.
+type AdminClient struct {
+	client *rpc.Client // The JSON-RPC client
+}
+
+// DialAdmin connects to the administrative control socket of a node
+// at the specified address, in the form accepted by ListenAdmin.
+func DialAdmin(addr string) (*AdminClient, error) {
+	network, address, err := adminAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminClient{client: jsonrpc.NewClient(conn)}, nil
+}
+
+// Close closes the connection to the node.
+func (c *AdminClient) Close() error {
+	return c.client.Close()
+}
+
+// Conduits lists the conduits open on the node.
+func (c *AdminClient) Conduits() ([]ConduitInfo, error) {
+	var result []ConduitInfo
+	if err := c.client.Call(AdminService+".Conduits", struct{}{}, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Routes lists the routes of the node's routing table.
+func (c *AdminClient) Routes() ([]routing.Route, error) {
+	var result []routing.Route
+	if err := c.client.Call(AdminService+".Routes", struct{}{}, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Dial adds a peer to the node, which then dials it.
+func (c *AdminClient) Dial(uri string) error {
+	return c.client.Call(AdminService+".Dial", uri, &struct{}{})
+}
+
+// Drop closes the conduit from the node to a peer node.
+func (c *AdminClient) Drop(id proto.NodeID) error {
+	return c.client.Call(AdminService+".Drop", id, &struct{}{})
+}
+
+// Capture starts capturing the PDUs exchanged over the node's
+// conduits to a file at the specified path on the node's host, or
+// stops capturing if the path is empty.
+func (c *AdminClient) Capture(path string) error {
+	return c.client.Call(AdminService+".Capture", path, &struct{}{})
+}
+
+// Stats summarizes the statistics of the node.
+func (c *AdminClient) Stats() (*NodeStats, error) {
+	result := &NodeStats{}
+	if err := c.client.Call(AdminService+".Stats", struct{}{}, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt"
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// adminClient serves the administrative control socket of a node on
+// a loopback address, returning a client connected to it.
+func adminClient(t *testing.T, n *Node) *AdminClient {
+	t.Helper()
+
+	l, err := ListenAdmin("127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.serveAdmin(ctx, l)
+	}()
+	client, err := DialAdmin(l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		cancel()
+		<-done
+	})
+
+	return client
+}
+
+func TestAdminAddrUnix(t *testing.T) {
+	network, address, err := adminAddr("unix:/run/humboldt/admin.sock")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, "/run/humboldt/admin.sock", address)
+}
+
+func TestAdminAddrLoopback(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:7070", "[::1]:7070", "localhost:7070"} {
+		network, address, err := adminAddr(addr)
+
+		assert.NoError(t, err, addr)
+		assert.Equal(t, "tcp", network, addr)
+		assert.Equal(t, addr, address, addr)
+	}
+}
+
+func TestAdminAddrErrors(t *testing.T) {
+	for _, addr := range []string{"unix:", "127.0.0.1", "0.0.0.0:7070", "10.0.0.1:7070", "example.com:7070"} {
+		network, address, err := adminAddr(addr)
+
+		assert.ErrorIs(t, err, ErrAdminAddr, addr)
+		assert.Equal(t, "", network, addr)
+		assert.Equal(t, "", address, addr)
+	}
+}
+
+func TestListenAdminUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	result, err := ListenAdmin("unix:" + path)
+
+	require.NoError(t, err)
+	defer result.Close()
+	assert.Equal(t, path, result.Addr().String())
+}
+
+func TestListenAdminError(t *testing.T) {
+	result, err := ListenAdmin("0.0.0.0:0")
+
+	assert.ErrorIs(t, err, ErrAdminAddr)
+	assert.Nil(t, result)
+}
+
+func TestDialAdminError(t *testing.T) {
+	result, err := DialAdmin("10.0.0.1:7070")
+
+	assert.ErrorIs(t, err, ErrAdminAddr)
+	assert.Nil(t, result)
+}
+
+func TestAdminConduits(t *testing.T) {
+	startNode(t, 2, "admin-conduits-b")
+	obj := startNode(t, 1, "admin-conduits-a", "mem:admin-conduits-b")
+	peerOpen(t, obj.Manager, 2)
+	cli, err := humboldt.Dial(context.Background(), nil, "mem:admin-conduits-a")
+	require.NoError(t, err)
+	defer cli.Close()
+	require.Eventually(t, func() bool {
+		obj.lock.Lock()
+		defer obj.lock.Unlock()
+		return len(obj.clients) == 1
+	}, 5*time.Second, time.Millisecond)
+	client := adminClient(t, obj)
+
+	result, err := client.Conduits()
+
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	assert.Equal(t, proto.NodeID{2}, result[0].Peer)
+	assert.Equal(t, "mem:admin-conduits-b", result[0].URI)
+	assert.False(t, result[0].Client)
+	assert.False(t, result[0].Inbound)
+	assert.Equal(t, "mem:admin-conduits-b", result[0].RemoteURI)
+	assert.True(t, result[1].Client)
+	assert.True(t, result[1].Inbound)
+}
+
+func TestAdminRoutes(t *testing.T) {
+	startNode(t, 2, "admin-routes-b")
+	obj := startNode(t, 1, "admin-routes-a", "mem:admin-routes-b")
+	peerOpen(t, obj.Manager, 2)
+	client := adminClient(t, obj)
+
+	result, err := client.Routes()
+
+	require.NoError(t, err)
+	assert.Equal(t, obj.Routes.Routes(), result)
+}
+
+func TestAdminDial(t *testing.T) {
+	startNode(t, 2, "admin-dial-b")
+	obj := startNode(t, 1, "admin-dial-a")
+	client := adminClient(t, obj)
+
+	err := client.Dial("mem:admin-dial-b")
+
+	assert.NoError(t, err)
+	peerOpen(t, obj.Manager, 2)
+}
+
+func TestAdminDialError(t *testing.T) {
+	obj := startNode(t, 1, "admin-dial-error-a", "mem:admin-dial-error-b")
+	client := adminClient(t, obj)
+
+	err := client.Dial("mem:admin-dial-error-b")
+
+	assert.ErrorContains(t, err, ErrPeerExists.Error())
+}
+
+func TestAdminDrop(t *testing.T) {
+	other := startNode(t, 2, "admin-drop-b")
+	obj := startNode(t, 1, "admin-drop-a", "mem:admin-drop-b")
+	old := peerOpen(t, obj.Manager, 2).Conduit
+	var remote *conduit.Conduit
+	require.Eventually(t, func() bool {
+		remote = other.Manager.Conduit(proto.NodeID{1})
+		return remote != nil
+	}, 5*time.Second, time.Millisecond)
+	client := adminClient(t, obj)
+
+	err := client.Drop(proto.NodeID{2})
+
+	assert.NoError(t, err)
+	require.Eventually(t, func() bool {
+		c := obj.Manager.Conduit(proto.NodeID{2})
+		return c != nil && c != old
+	}, 5*time.Second, time.Millisecond)
+	<-remote.Context().Done()
+	assert.ErrorIs(t, context.Cause(remote.Context()), conduit.ErrPeerClosed)
+	assert.ErrorContains(t, context.Cause(remote.Context()), ErrDropped.Error())
+}
+
+func TestAdminDropNoConduit(t *testing.T) {
+	obj := startNode(t, 1, "admin-drop-none-a")
+	client := adminClient(t, obj)
+
+	err := client.Drop(proto.NodeID{2})
+
+	assert.ErrorContains(t, err, ErrNoConduit.Error())
+}
+
+func TestAdminStats(t *testing.T) {
+	startNode(t, 2, "admin-stats-b")
+	obj := startNode(t, 1, "admin-stats-a", "mem:admin-stats-b")
+	c := peerOpen(t, obj.Manager, 2).Conduit
+	require.Eventually(t, func() bool {
+		return c.Stats().PDUsIn > 0
+	}, 5*time.Second, time.Millisecond)
+	client := adminClient(t, obj)
+
+	result, err := client.Stats()
+
+	require.NoError(t, err)
+	assert.Equal(t, proto.NodeID{1}, result.NodeID)
+	assert.Equal(t, 1, result.Peers)
+	assert.Equal(t, 0, result.Clients)
+	assert.GreaterOrEqual(t, result.Totals.PDUsIn, uint64(1))
+	assert.False(t, result.Totals.LastActivity.IsZero())
+}
+
+func TestAdminStatsLatency(t *testing.T) {
+	obj := startNode(t, 1, "admin-stats-latency")
+	obj.Latency.Sent(proto.NodeID{2}, &proto.Header{Protocol: 42}, 7)
+	obj.Latency.Received(proto.NodeID{2}, &proto.Header{Reply: true, Protocol: 42}, 7)
+	client := adminClient(t, obj)
+
+	result, err := client.Stats()
+
+	require.NoError(t, err)
+	require.Contains(t, result.Latency, proto.NodeID{2})
+	assert.Equal(t, uint64(1), result.Latency[proto.NodeID{2}].Count)
+}
+
+// captureDir sets a temporary capture directory in the configuration
+// of a node, returning it.
+func captureDir(t *testing.T, n *Node) string {
+	dir := t.TempDir()
+	cfg := *n.Config()
+	cfg.CaptureDir = dir
+	n.Reload(&cfg)
+
+	return dir
+}
+
+func TestAdminCapture(t *testing.T) {
+	obj := startNode(t, 1, "admin-capture")
+	client := adminClient(t, obj)
+	dir := captureDir(t, obj)
+
+	err := client.Capture(filepath.Join(dir, "capture.pcapng"))
+
+	assert.NoError(t, err)
+	assert.NotNil(t, obj.capturing())
+	assert.NoError(t, client.Capture(""))
+	assert.Nil(t, obj.capturing())
+	assert.FileExists(t, filepath.Join(dir, "capture.pcapng"))
+}
+
+func TestAdminCaptureRelative(t *testing.T) {
+	obj := startNode(t, 1, "admin-capture-relative")
+	client := adminClient(t, obj)
+	dir := captureDir(t, obj)
+
+	err := client.Capture("capture.pcapng")
+
+	assert.NoError(t, err)
+	assert.NoError(t, client.Capture(""))
+	assert.FileExists(t, filepath.Join(dir, "capture.pcapng"))
+}
+
+func TestAdminCaptureNoDir(t *testing.T) {
+	obj := startNode(t, 1, "admin-capture-no-dir")
+	client := adminClient(t, obj)
+
+	err := client.Capture(filepath.Join(t.TempDir(), "capture.pcapng"))
+
+	assert.ErrorContains(t, err, ErrCaptureDenied.Error())
+	assert.Nil(t, obj.capturing())
+}
+
+func TestAdminCaptureOutside(t *testing.T) {
+	obj := startNode(t, 1, "admin-capture-outside")
+	client := adminClient(t, obj)
+	dir := captureDir(t, obj)
+	target := filepath.Join(t.TempDir(), "node-id")
+	require.NoError(t, os.WriteFile(target, []byte("keep"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Dir(target), filepath.Join(dir, "link")))
+
+	for _, path := range []string{
+		target,
+		filepath.Join(dir, "..", "capture.pcapng"),
+		filepath.Join(dir, "link", "capture.pcapng"),
+	} {
+		err := client.Capture(path)
+
+		assert.ErrorContains(t, err, ErrCaptureDenied.Error(), path)
+	}
+	assert.Nil(t, obj.capturing())
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("keep"), data)
+}
+
+func TestAdminCaptureExists(t *testing.T) {
+	obj := startNode(t, 1, "admin-capture-exists")
+	client := adminClient(t, obj)
+	path := filepath.Join(captureDir(t, obj), "capture.pcapng")
+	require.NoError(t, os.WriteFile(path, []byte("keep"), 0o600))
+
+	err := client.Capture(path)
+
+	assert.Error(t, err)
+	assert.Nil(t, obj.capturing())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("keep"), data)
+}
+
+func TestAdminCaptureError(t *testing.T) {
+	obj := startNode(t, 1, "admin-capture-error")
+	client := adminClient(t, obj)
+
+	err := client.Capture(filepath.Join(captureDir(t, obj), "missing", "capture.pcapng"))
+
+	assert.Error(t, err)
+	assert.Nil(t, obj.capturing())
+}
+
+func TestNodeStartAdmin(t *testing.T) {
+	defer patcher.SetVar(&generateNodeID, func() (proto.NodeID, error) {
+		return proto.NodeID{1}, nil
+	}).Install().Restore()
+	obj, err := New(&Config{Admin: "127.0.0.1:0"})
+	require.NoError(t, err)
+
+	err = obj.Start(context.Background())
+	require.NoError(t, err)
+	defer obj.Stop()
+	client, err := DialAdmin(obj.AdminAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	result, err := client.Stats()
+
+	assert.NoError(t, err)
+	assert.Equal(t, proto.NodeID{1}, result.NodeID)
+}
+
+func TestNodeStartAdminError(t *testing.T) {
+	defer patcher.SetVar(&listenAdmin, func(addr string) (net.Listener, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj, err := New(&Config{Admin: "127.0.0.1:0"})
+	require.NoError(t, err)
+
+	err = obj.Start(context.Background())
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "127.0.0.1:0")
+	assert.Nil(t, obj.AdminAddr())
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// CallExpiry is how long a node remembers a request sent by one of
+// its clients while awaiting the reply.  Replies arriving later are
+// discarded.
+const CallExpiry = 10 * time.Minute
+
+// call describes a request sent by a client, awaiting its reply.
+type call struct {
+	client  *conduit.Conduit // Conduit from the client
+	id      uint32           // The client's correlation ID
+	dest    proto.NodeID     // Destination of the request
+	expires time.Time        // When the call is forgotten
+}
+
+// trackCall records a request sent by a client, so that the reply
+// may be delivered to the client alone.  Since the correlation IDs
+// chosen by different clients may collide, the request is given a
+// correlation ID allocated by the node; the extension chain to send
+// the request with is returned.  The round trip of the request is
+// measured by the node's latency tracker.  Messages which are not
+// requests are passed through untouched.
+func (n *Node) trackCall(c *conduit.Conduit, d *proto.Data, exts proto.Extensions) proto.Extensions {
+	req := proto.FindRequest(exts)
+	if d.Reply || req == nil {
+		return exts
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.nextCall++
+	n.calls[n.nextCall] = &call{client: c, id: req.ID, dest: d.Dest, expires: time.Now().Add(CallExpiry)}
+	n.Latency.Sent(d.Dest, &proto.Header{Protocol: d.Protocol}, n.nextCall)
+
+	return proto.SetRequest(exts, &proto.Request{ID: n.nextCall})
+}
+
+// deliverReply delivers a reply to a request to the client that sent
+// the request, restoring the client's correlation ID, and records the
+// round trip of the request in the latency histogram of its
+// destination.  The reply is saved in the client's reply cache, to be
+// resent should the client retransmit the request; error replies are
+// not, so that a retransmission is forwarded afresh.  Replies to
+// unknown or forgotten requests are discarded.
+func (n *Node) deliverReply(d *proto.Data, exts proto.Extensions, req *proto.Request) error {
+	n.lock.Lock()
+	cl := n.calls[req.ID]
+	delete(n.calls, req.ID)
+	var q *queue
+	var replies *proto.ReplyCache
+	if cl != nil {
+		q = n.queues[cl.client]
+		replies = n.replies[cl.client]
+	}
+	n.lock.Unlock()
+	if cl != nil {
+		n.Latency.Received(cl.dest, &proto.Header{Reply: true, Protocol: d.Protocol}, req.ID)
+	}
+	if q == nil {
+		return nil
+	}
+
+	f, err := dataFrame(d, proto.SetRequest(exts, &proto.Request{ID: cl.id}))
+	if err != nil {
+		return err
+	}
+	if replies != nil {
+		if d.Error {
+			replies.Forget(f.Protocol(), cl.id)
+		} else {
+			replies.Sent(f) //nolint:errcheck
+		}
+	}
+
+	return q.Send(f)
+}
+
+// retransmitted checks a request received from a client against the
+// client's reply cache, so that a request the client retransmits,
+// perhaps because the reply was lost, is not handled twice.  If the
+// request is a retransmission, the cached reply, if any, is resent
+// to the client, and true is returned; the request must then be
+// dropped.
+func (n *Node) retransmitted(c *conduit.Conduit, f *proto.Frame) bool {
+	n.lock.Lock()
+	replies := n.replies[c]
+	q := n.queues[c]
+	n.lock.Unlock()
+	if replies == nil {
+		return false
+	}
+
+	reply, dup, _ := replies.Received(f)
+	if reply != nil && q != nil {
+		q.Send(reply) //nolint:errcheck
+	}
+
+	return dup
+}
+
+// refuse answers a request which could not be forwarded with an error
+// reply to its source, the payload describing the error.  Messages
+// which are not requests are not answered.
+func (n *Node) refuse(d *proto.Data, exts proto.Extensions, err error) {
+	req := proto.FindRequest(exts)
+	if d.Reply || req == nil {
+		return
+	}
+
+	reply := &proto.Data{
+		Dest:     d.Source,
+		Source:   n.ID,
+		HopLimit: proto.DefaultHopLimit,
+		Protocol: d.Protocol,
+		Payload:  []byte(err.Error()),
+		Reply:    true,
+		Error:    true,
+	}
+	n.forward(reply, proto.Extensions{req.Extension()}) //nolint:errcheck
+}
+
+// expireCalls forgets the requests whose replies have not arrived in
+// time, and those sent by clients which have disconnected.
+func (n *Node) expireCalls(now time.Time) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for id, cl := range n.calls {
+		if _, ok := n.queues[cl.client]; !ok || now.After(cl.expires) {
+			delete(n.calls, id)
+		}
+	}
+}
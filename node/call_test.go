@@ -0,0 +1,187 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt"
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// serve answers the requests received over a subscription by
+// echoing them back.
+func serve(sub *humboldt.Subscription) {
+	for msg := range sub.C {
+		msg.Reply(append([]byte("echo "), msg.Payload...)) //nolint:errcheck
+	}
+}
+
+func TestNodeCall(t *testing.T) {
+	entry := startNode(t, 1, "node-call-1")
+	server := startNode(t, 2, "node-call-2", "mem:node-call-1")
+	go serve(subscribe(t, "node-call-2", 42))
+	bystander := subscribe(t, "node-call-1", 42)
+	caller, err := humboldt.Dial(context.Background(), nil, "mem:node-call-1")
+	require.NoError(t, err)
+	defer caller.Close()
+	require.Eventually(t, func() bool {
+		_, ok := server.Routes.NextHop(proto.NodeID{1})
+		return ok
+	}, 5*time.Second, time.Millisecond)
+
+	// Requests sent before the server's subscription takes effect
+	// go unanswered, so each attempt is given a short deadline
+	var reply []byte
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		reply, err = caller.Call(ctx, server.ID, 42, []byte("hello"))
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+
+	assert.Equal(t, []byte("echo hello"), reply)
+	assert.Empty(t, bystander.C)
+	assert.NotNil(t, entry.Latency.Histogram(server.ID))
+	assert.NotNil(t, caller.Latency.Histogram(server.ID))
+}
+
+func TestNodeCallRetransmitted(t *testing.T) {
+	obj := startNode(t, 1, "node-call-retransmit")
+	sub := subscribe(t, "node-call-retransmit", 42)
+	caller, err := humboldt.Dial(context.Background(), nil, "mem:node-call-retransmit")
+	require.NoError(t, err)
+	defer caller.Close()
+	require.Eventually(t, func() bool {
+		obj.lock.Lock()
+		defer obj.lock.Unlock()
+		for _, subs := range obj.clients {
+			if subs[42] {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, time.Millisecond)
+	d := &proto.Data{Dest: obj.ID, HopLimit: proto.DefaultHopLimit, Protocol: 42, Payload: []byte("hello")}
+	f, err := d.Frame()
+	require.NoError(t, err)
+	f.Extensions = proto.Extensions{(&proto.Request{ID: 7}).Extension()}
+	f.Header.Protocol = f.Extensions.Link(f.Header.Protocol)
+	received := func() uint64 { return caller.Conduit.Stats().PDUsIn }
+
+	// Retransmit the request while it is being handled
+	require.NoError(t, caller.Conduit.Send(f))
+	var msg *humboldt.Message
+	select {
+	case msg = <-sub.C:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "request not delivered")
+	}
+	require.NoError(t, caller.Conduit.Send(f))
+	before := received()
+	require.NoError(t, msg.Reply([]byte("answer")))
+	require.Eventually(t, func() bool {
+		return received() > before
+	}, 5*time.Second, time.Millisecond)
+
+	// Retransmit the request once it has been answered
+	before = received()
+	require.NoError(t, caller.Conduit.Send(f))
+
+	assert.Eventually(t, func() bool {
+		return received() > before
+	}, 5*time.Second, time.Millisecond)
+	assert.Empty(t, sub.C)
+}
+
+func TestNodeCallNoRoute(t *testing.T) {
+	startNode(t, 1, "node-call-no-route")
+	caller, err := humboldt.Dial(context.Background(), nil, "mem:node-call-no-route")
+	require.NoError(t, err)
+	defer caller.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reply, err := caller.Call(ctx, proto.NodeID{2}, 42, []byte("hello"))
+
+	assert.ErrorIs(t, err, humboldt.ErrCallFailed)
+	assert.ErrorContains(t, err, ErrNoRoute.Error())
+	assert.Nil(t, reply)
+}
+
+func TestNodeTrackCall(t *testing.T) {
+	obj, err := New(&Config{})
+	require.NoError(t, err)
+	c := &conduit.Conduit{}
+	exts := proto.Extensions{(&proto.Request{ID: 7}).Extension()}
+
+	result := obj.trackCall(c, &proto.Data{Dest: proto.NodeID{2}}, exts)
+
+	req := proto.FindRequest(result)
+	require.NotNil(t, req)
+	assert.Equal(t, &call{client: c, id: 7, dest: proto.NodeID{2}, expires: obj.calls[req.ID].expires}, obj.calls[req.ID])
+}
+
+func TestNodeTrackCallReply(t *testing.T) {
+	obj, err := New(&Config{})
+	require.NoError(t, err)
+	exts := proto.Extensions{(&proto.Request{ID: 7}).Extension()}
+
+	result := obj.trackCall(&conduit.Conduit{}, &proto.Data{Reply: true}, exts)
+
+	assert.Equal(t, exts, result)
+	assert.Empty(t, obj.calls)
+}
+
+func TestNodeDeliverReplyLatency(t *testing.T) {
+	obj, err := New(&Config{})
+	require.NoError(t, err)
+	anycast := proto.AnycastID("service")
+	exts := obj.trackCall(&conduit.Conduit{}, &proto.Data{Dest: anycast, Protocol: 42}, proto.Extensions{(&proto.Request{ID: 7}).Extension()})
+	req := proto.FindRequest(exts)
+	require.NotNil(t, req)
+
+	err = obj.deliverReply(&proto.Data{Source: proto.NodeID{2}, Protocol: 42, Reply: true}, exts, req)
+
+	assert.NoError(t, err)
+	assert.Empty(t, obj.calls)
+	h := obj.Latency.Histogram(anycast)
+	require.NotNil(t, h)
+	assert.Equal(t, uint64(1), h.Count())
+}
+
+func TestNodeExpireCalls(t *testing.T) {
+	obj, err := New(&Config{})
+	require.NoError(t, err)
+	c := &conduit.Conduit{}
+	now := time.Now()
+	obj.lock.Lock()
+	obj.queues[c] = &queue{}
+	obj.calls[1] = &call{client: c, expires: now.Add(time.Minute)}
+	obj.calls[2] = &call{client: c, expires: now.Add(-time.Minute)}
+	obj.calls[3] = &call{client: &conduit.Conduit{}, expires: now.Add(time.Minute)}
+	obj.lock.Unlock()
+
+	obj.expireCalls(now)
+
+	assert.Len(t, obj.calls, 1)
+	assert.Contains(t, obj.calls, uint32(1))
+}
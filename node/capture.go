@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hydralang/humboldt/conduit"
+)
+
+// StartCapture starts capturing the PDUs exchanged over the node's
+// conduits, to peers and from clients, to a capture file at the
+// specified path, which must not already exist; see conduit.Capture
+// for the format.  Any capture already running is stopped first.
+// Conduits opened while the capture runs are captured as well; the
+// PDUs exchanged while negotiating them are not.
+func (n *Node) StartCapture(path string) error {
+	cp, err := createCapture(path)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	old := n.capture
+	n.capture = cp
+	n.lock.Unlock()
+	n.setCapture(cp)
+
+	if old != nil {
+		return old.Close()
+	}
+
+	return nil
+}
+
+// capturePath checks that a capture file requested through the
+// administrative control socket is in the configured capture
+// directory, returning its path with any symbolic links in the
+// directory resolved.  A relative path is taken relative to the
+// capture directory.  If no capture directory is configured, or the
+// file is outside it, an error wrapping ErrCaptureDenied is returned.
+func (n *Node) capturePath(path string) (string, error) {
+	dir := n.Config().CaptureDir
+	if dir == "" {
+		return "", fmt.Errorf("%w: no capture directory is configured", ErrCaptureDenied)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+
+	// Resolve the directory the file is in
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, parent)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", path, ErrCaptureDenied)
+	}
+
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+// StopCapture stops capturing the PDUs exchanged over the node's
+// conduits, closing the capture file.  The first error writing the
+// capture file is returned.
+func (n *Node) StopCapture() error {
+	n.lock.Lock()
+	old := n.capture
+	n.capture = nil
+	n.lock.Unlock()
+	if old == nil {
+		return nil
+	}
+	n.setCapture(nil)
+
+	return old.Close()
+}
+
+// capturing returns the capture of the node's conduits, if any.
+func (n *Node) capturing() *conduit.Capture {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.capture
+}
+
+// setCapture sets the capture of all the open conduits.
+func (n *Node) setCapture(cp *conduit.Capture) {
+	for _, p := range n.Manager.Peers() {
+		if p.Conduit != nil {
+			p.Conduit.SetCapture(cp)
+		}
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for c := range n.clients {
+		c.SetCapture(cp)
+	}
+}
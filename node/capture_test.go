@@ -0,0 +1,183 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt"
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// readCapture reads the PDUs recorded in a capture file, stopping at
+// the first record which has not yet been completely written.
+func readCapture(path string) []*conduit.CaptureRecord {
+	data, _ := os.ReadFile(path)
+	r := conduit.NewCaptureReader(bytes.NewReader(data))
+	result := []*conduit.CaptureRecord{}
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			return result
+		}
+		result = append(result, rec)
+	}
+}
+
+// captured tests if PDUs have been captured in both directions over
+// the conduit to the specified peer.
+func captured(path string, peer proto.NodeID) bool {
+	dirs := map[conduit.Direction]bool{}
+	for _, rec := range readCapture(path) {
+		if rec.Peer == peer.String() {
+			dirs[rec.Direction] = true
+		}
+	}
+
+	return dirs[conduit.Inbound] && dirs[conduit.Outbound]
+}
+
+func TestNodeStartCaptureBase(t *testing.T) {
+	startNode(t, 2, "capture-b")
+	obj := startNode(t, 1, "capture-a", "mem:capture-b")
+	peerOpen(t, obj.Manager, 2)
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+
+	err := obj.StartCapture(path)
+
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return captured(path, proto.NodeID{2})
+	}, 5*time.Second, time.Millisecond)
+	assert.NoError(t, obj.StopCapture())
+	assert.Nil(t, obj.capturing())
+	for _, rec := range readCapture(path) {
+		assert.Equal(t, "mem:capture-b", rec.Name)
+		_, err := proto.Decode(rec.PDU)
+		assert.NoError(t, err)
+	}
+}
+
+func TestNodeStartCaptureClient(t *testing.T) {
+	obj := startNode(t, 1, "capture-client")
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	cli, err := humboldt.Dial(context.Background(), nil, "mem:capture-client")
+	require.NoError(t, err)
+	defer cli.Close()
+	require.Eventually(t, func() bool {
+		obj.lock.Lock()
+		defer obj.lock.Unlock()
+		return len(obj.clients) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	err = obj.StartCapture(path)
+
+	require.NoError(t, err)
+	_, err = cli.Subscribe(42, 10)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(readCapture(path)) > 0
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, conduit.Inbound, readCapture(path)[0].Direction)
+}
+
+func TestNodeStartCaptureReplace(t *testing.T) {
+	obj := startNode(t, 1, "capture-replace")
+	dir := t.TempDir()
+	require.NoError(t, obj.StartCapture(filepath.Join(dir, "old.pcapng")))
+	old := obj.capturing()
+
+	err := obj.StartCapture(filepath.Join(dir, "new.pcapng"))
+
+	assert.NoError(t, err)
+	assert.NotSame(t, old, obj.capturing())
+	assert.NotNil(t, obj.capturing())
+}
+
+func TestNodeStartCaptureError(t *testing.T) {
+	defer patcher.SetVar(&createCapture, func(path string) (*conduit.Capture, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj := startNode(t, 1, "capture-error")
+
+	err := obj.StartCapture("capture.pcapng")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, obj.capturing())
+}
+
+func TestNodeStopCaptureNotCapturing(t *testing.T) {
+	obj := startNode(t, 1, "capture-stop-none")
+
+	err := obj.StopCapture()
+
+	assert.NoError(t, err)
+}
+
+func TestNodeStartConfigCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	obj, err := New(&Config{Capture: path})
+	require.NoError(t, err)
+
+	err = obj.Start(context.Background())
+
+	require.NoError(t, err)
+	assert.NotNil(t, obj.capturing())
+	obj.Stop()
+	assert.Nil(t, obj.capturing())
+	assert.FileExists(t, path)
+}
+
+func TestNodeStartConfigCaptureError(t *testing.T) {
+	defer patcher.SetVar(&createCapture, func(path string) (*conduit.Capture, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj, err := New(&Config{Capture: "capture.pcapng"})
+	require.NoError(t, err)
+
+	err = obj.Start(context.Background())
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "capture.pcapng")
+}
+
+func TestNodeReloadCapture(t *testing.T) {
+	obj := startNode(t, 1, "capture-reload")
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	cfg := *obj.Config()
+	cfg.Capture = path
+
+	obj.Reload(&cfg)
+	started := obj.capturing()
+	obj.Reload(&cfg)
+	same := obj.capturing()
+	stopped := cfg
+	stopped.Capture = ""
+	obj.Reload(&stopped)
+
+	assert.NotNil(t, started)
+	assert.Same(t, started, same)
+	assert.Nil(t, obj.capturing())
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/routing"
+)
+
+// DefaultLinkStateInterval is the default interval at which a node
+// refreshes its link-state record.
+const DefaultLinkStateInterval = 30 * time.Second
+
+// DefaultDrainTimeout is the default maximum time Node.Shutdown waits
+// for the traffic through the node to drain.
+const DefaultDrainTimeout = 30 * time.Second
+
+// DrainQuietPeriod is the time for which no data message may be
+// received by a node for Node.Shutdown to consider the traffic
+// through the node drained.
+const DrainQuietPeriod = 250 * time.Millisecond
+
+// StoredPeerDials is the number of peers remembered in the peer store
+// which a node dials when it starts, in addition to the configured
+// peers.
+const StoredPeerDials = 3
+
+// STUNInterval is the interval at which a node configured with STUN
+// servers rediscovers the external URIs of its listeners, so that
+// changes to the mappings of the network address translators are
+// followed.
+const STUNInterval = 5 * time.Minute
+
+// DefaultProbeEvery is the default number of pings between the
+// bandwidth probes of each peer.
+const DefaultProbeEvery = 6
+
+// linkCosts maps the names of the link cost functions which may be
+// configured to the functions.
+var linkCosts = map[string]routing.CostFunc{
+	"metric": routing.MetricCost,
+	"rtt":    routing.RTTCost,
+	"hops":   routing.HopCost,
+}
+
+// PunchTimeout is the time Node.Punch allows for the target node to
+// answer the introduction and for a conduit to be punched to it.
+const PunchTimeout = 10 * time.Second
+
+// Config is the configuration of a node.  In a configuration file,
+// the node is configured by the following keys of the root map,
+// alongside the mechanism configurations decoded by
+// conduit.DecodeConfig:
+//
+//	node_id_file: /var/lib/humboldt/node-id
+//	listen: [tcp://0.0.0.0:1234, quic://0.0.0.0:1234]
+//	peers: [tcp://peer.example.com:1234]
+//	peer_store: /var/lib/humboldt/peers.json
+//	ping_interval: 10s
+//	ping_max_missed: 3
+//	probe_every: 6
+//	link_cost: metric
+//	linkstate_interval: 30s
+//	drain_timeout: 30s
+//	admin: unix:/run/humboldt/admin.sock
+//	stun: [stun.example.com, stun2.example.com:3478]
+//	anycast: [log-collector]
+//	capture: /var/tmp/humboldt.pcapng
+//	capture_dir: /var/tmp/humboldt
+//
+// If no node ID file is given, a new node ID is generated each time
+// the node starts.  If a peer store file is given, the peers the node
+// dials are remembered in it, and the best of them are dialed again
+// when the node restarts; see Node.Peers.  The administrative control
+// socket is only opened if an address is given; see ListenAdmin for
+// the address forms.  If STUN servers are given, the external URIs of
+// the UDP and QUIC listeners are discovered through them; see
+// Node.ExternalURIs.
+//
+// The bandwidth of each peer is probed every probe_every pings, or
+// every DefaultProbeEvery pings if not given; a negative value
+// disables probing.  The costs of the links to the peers are computed
+// from their round-trip times, bandwidths, and losses by the cost
+// function named by link_cost: "metric", the default, for
+// routing.MetricCost, "rtt" for routing.RTTCost, or "hops" for
+// routing.HopCost.
+//
+// The node claims the anycast destinations of the services named by
+// anycast, so that data messages addressed to them are delivered to
+// the node if it is the nearest node claiming them; see
+// proto.AnycastID.
+//
+// If a capture file is given, the PDUs exchanged over the node's
+// conduits are captured to it; the file must not already exist.  See
+// Node.StartCapture.  Captures requested through the administrative
+// control socket are only written to files in the directory named by
+// capture_dir, and are refused if it is not set.
+type Config struct {
+	Conduit           *conduit.ConfigMap // Configurations of the mechanisms
+	NodeIDFile        string             // File holding the node ID
+	Listen            []string           // URIs to listen on
+	Peers             []string           // URIs of the peers to dial
+	PeerStore         string             // File remembering the peers
+	PingInterval      time.Duration      // Interval between pings of each peer
+	PingMaxMissed     int                // Unanswered pings before a peer is dropped
+	ProbeEvery        int                // Pings between bandwidth probes of each peer
+	LinkCost          string             // Name of the link cost function
+	LinkStateInterval time.Duration      // Interval between link-state refreshes
+	DrainTimeout      time.Duration      // Maximum time to drain traffic on shutdown
+	Admin             string             // Address of the administrative control socket
+	STUN              []string           // STUN servers for discovering external URIs
+	Anycast           []string           // Services whose anycast destinations are claimed
+	Capture           string             // File capturing the PDUs exchanged
+	CaptureDir        string             // Directory of captures requested by the admin socket
+}
+
+// cfgInt retrieves an integer from a raw configuration.  Strings, as
+// set by environment variables, are parsed.
+func cfgInt(raw map[string]interface{}, key string) (int, error) {
+	switch v := raw[key].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("%s: %w", key, ErrBadConfig)
+		}
+		return int(v), nil
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w: %w", key, ErrBadConfig, err)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("%s: %w", key, ErrBadConfig)
+	}
+}
+
+// costFunc returns the configured link cost function.
+func (cfg *Config) costFunc() (routing.CostFunc, error) {
+	if cfg.LinkCost == "" {
+		return routing.MetricCost, nil
+	}
+	f, ok := linkCosts[cfg.LinkCost]
+	if !ok {
+		return nil, fmt.Errorf("link_cost: %w: unknown cost function %q", ErrBadConfig, cfg.LinkCost)
+	}
+
+	return f, nil
+}
+
+// probeEvery returns the configured number of pings between bandwidth
+// probes, or 0 if probing is disabled.
+func (cfg *Config) probeEvery() int {
+	switch {
+	case cfg.ProbeEvery < 0:
+		return 0
+	case cfg.ProbeEvery == 0:
+		return DefaultProbeEvery
+	}
+
+	return cfg.ProbeEvery
+}
+
+// DecodeConfig decodes a configuration tree, as returned by
+// conduit.LoadConfigTree, into a node configuration.
+func DecodeConfig(tree map[string]interface{}) (*Config, error) {
+	var err error
+	cfg := &Config{}
+	if cfg.Conduit, err = conduit.DecodeConfig(tree); err != nil {
+		return nil, err
+	}
+	if cfg.NodeIDFile, err = conduit.ConfigString(tree, "node_id_file"); err != nil {
+		return nil, err
+	}
+	if cfg.Listen, err = conduit.ConfigList(tree, "listen"); err != nil {
+		return nil, err
+	}
+	if cfg.Peers, err = conduit.ConfigList(tree, "peers"); err != nil {
+		return nil, err
+	}
+	if cfg.PeerStore, err = conduit.ConfigString(tree, "peer_store"); err != nil {
+		return nil, err
+	}
+	if cfg.PingInterval, err = conduit.ConfigDuration(tree, "ping_interval"); err != nil {
+		return nil, err
+	}
+	if cfg.PingMaxMissed, err = cfgInt(tree, "ping_max_missed"); err != nil {
+		return nil, err
+	}
+	if cfg.ProbeEvery, err = cfgInt(tree, "probe_every"); err != nil {
+		return nil, err
+	}
+	if cfg.LinkCost, err = conduit.ConfigString(tree, "link_cost"); err != nil {
+		return nil, err
+	}
+	if _, err = cfg.costFunc(); err != nil {
+		return nil, err
+	}
+	if cfg.LinkStateInterval, err = conduit.ConfigDuration(tree, "linkstate_interval"); err != nil {
+		return nil, err
+	}
+	if cfg.DrainTimeout, err = conduit.ConfigDuration(tree, "drain_timeout"); err != nil {
+		return nil, err
+	}
+	if cfg.Admin, err = conduit.ConfigString(tree, "admin"); err != nil {
+		return nil, err
+	}
+	if cfg.STUN, err = conduit.ConfigList(tree, "stun"); err != nil {
+		return nil, err
+	}
+	if cfg.Anycast, err = conduit.ConfigList(tree, "anycast"); err != nil {
+		return nil, err
+	}
+	if cfg.Capture, err = conduit.ConfigString(tree, "capture"); err != nil {
+		return nil, err
+	}
+	if cfg.CaptureDir, err = conduit.ConfigString(tree, "capture_dir"); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadConfig loads the configuration file at the specified path,
+// applying environment variable overrides as conduit.LoadConfigTree
+// does, and decodes it into a node configuration.
+func LoadConfig(path string) (*Config, error) {
+	tree, err := loadConfigTree(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeConfig(tree)
+}
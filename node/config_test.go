@@ -0,0 +1,167 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/conduit"
+)
+
+func TestDecodeConfigBase(t *testing.T) {
+	tree := map[string]interface{}{
+		"node_id_file":       "/var/lib/humboldt/node-id",
+		"listen":             []interface{}{"tcp://:1234", "quic://:1234"},
+		"peers":              "tcp://peer1:1234, tcp://peer2:1234",
+		"peer_store":         "/var/lib/humboldt/peers.json",
+		"ping_interval":      "5s",
+		"ping_max_missed":    4,
+		"probe_every":        10,
+		"link_cost":          "rtt",
+		"linkstate_interval": 60,
+		"drain_timeout":      "10s",
+		"admin":              "unix:/run/humboldt/admin.sock",
+		"stun":               []interface{}{"stun.example.com"},
+		"anycast":            "log-collector",
+		"capture":            "/var/tmp/humboldt.pcapng",
+		"capture_dir":        "/var/tmp/humboldt",
+		"transport":          map[string]interface{}{"tcp": map[string]interface{}{}},
+	}
+
+	result, err := DecodeConfig(tree)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{
+		Conduit: &conduit.ConfigMap{
+			Transports: map[string]interface{}{"tcp": map[string]interface{}{}},
+			Securities: map[string]interface{}{},
+		},
+		NodeIDFile:        "/var/lib/humboldt/node-id",
+		Listen:            []string{"tcp://:1234", "quic://:1234"},
+		Peers:             []string{"tcp://peer1:1234", "tcp://peer2:1234"},
+		PeerStore:         "/var/lib/humboldt/peers.json",
+		PingInterval:      5 * time.Second,
+		PingMaxMissed:     4,
+		ProbeEvery:        10,
+		LinkCost:          "rtt",
+		LinkStateInterval: time.Minute,
+		DrainTimeout:      10 * time.Second,
+		Admin:             "unix:/run/humboldt/admin.sock",
+		STUN:              []string{"stun.example.com"},
+		Anycast:           []string{"log-collector"},
+		Capture:           "/var/tmp/humboldt.pcapng",
+		CaptureDir:        "/var/tmp/humboldt",
+	}, result)
+}
+
+func TestDecodeConfigEmpty(t *testing.T) {
+	result, err := DecodeConfig(map[string]interface{}{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{
+		Conduit: &conduit.ConfigMap{
+			Transports: map[string]interface{}{},
+			Securities: map[string]interface{}{},
+		},
+	}, result)
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	for key, tc := range map[string]struct {
+		val interface{}
+		err error
+	}{
+		"node_id_file":       {42, conduit.ErrBadConfig},
+		"listen":             {[]interface{}{42}, conduit.ErrBadConfig},
+		"peers":              {42, conduit.ErrBadConfig},
+		"peer_store":         {42, conduit.ErrBadConfig},
+		"ping_interval":      {"bogus", conduit.ErrBadConfig},
+		"ping_max_missed":    {1.5, ErrBadConfig},
+		"probe_every":        {"bogus", ErrBadConfig},
+		"link_cost":          {"bogus", ErrBadConfig},
+		"linkstate_interval": {true, conduit.ErrBadConfig},
+		"drain_timeout":      {"bogus", conduit.ErrBadConfig},
+		"admin":              {42, conduit.ErrBadConfig},
+		"stun":               {42, conduit.ErrBadConfig},
+		"anycast":            {42, conduit.ErrBadConfig},
+		"capture":            {42, conduit.ErrBadConfig},
+		"capture_dir":        {42, conduit.ErrBadConfig},
+	} {
+		t.Run(key, func(t *testing.T) {
+			result, err := DecodeConfig(map[string]interface{}{key: tc.val})
+
+			assert.ErrorIs(t, err, tc.err)
+			assert.ErrorContains(t, err, key)
+			assert.Nil(t, result)
+		})
+	}
+}
+
+func TestConfigProbeEvery(t *testing.T) {
+	assert.Equal(t, DefaultProbeEvery, (&Config{}).probeEvery())
+	assert.Equal(t, 10, (&Config{ProbeEvery: 10}).probeEvery())
+	assert.Equal(t, 0, (&Config{ProbeEvery: -1}).probeEvery())
+}
+
+func TestDecodeConfigConduitError(t *testing.T) {
+	result, err := DecodeConfig(map[string]interface{}{"transport": "bogus"})
+
+	assert.ErrorIs(t, err, conduit.ErrBadConfig)
+	assert.Nil(t, result)
+}
+
+func TestCfgInt(t *testing.T) {
+	raw := map[string]interface{}{"int": 3, "float": 4.0, "string": "5", "bad": "five"}
+
+	i1, err1 := cfgInt(raw, "int")
+	i2, err2 := cfgInt(raw, "float")
+	i3, err3 := cfgInt(raw, "string")
+	_, err4 := cfgInt(raw, "bad")
+
+	assert.NoError(t, err1)
+	assert.Equal(t, 3, i1)
+	assert.NoError(t, err2)
+	assert.Equal(t, 4, i2)
+	assert.NoError(t, err3)
+	assert.Equal(t, 5, i3)
+	assert.ErrorIs(t, err4, ErrBadConfig)
+}
+
+func TestLoadConfigBase(t *testing.T) {
+	defer patcher.SetVar(&loadConfigTree, func(path string) (map[string]interface{}, error) {
+		assert.Equal(t, "/etc/humboldt.yml", path)
+		return map[string]interface{}{"listen": "mem:node"}, nil
+	}).Install().Restore()
+
+	result, err := LoadConfig("/etc/humboldt.yml")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mem:node"}, result.Listen)
+}
+
+func TestLoadConfigError(t *testing.T) {
+	defer patcher.SetVar(&loadConfigTree, func(path string) (map[string]interface{}, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := LoadConfig("/etc/humboldt.yml")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
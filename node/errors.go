@@ -0,0 +1,42 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import "errors"
+
+// Common simple errors that may be returned by the node package.
+var (
+	ErrDuplicateConduit = errors.New("another conduit to the node is preferred")
+	ErrPeerExists       = errors.New("peer has already been added")
+	ErrUnknownPeer      = errors.New("peer has not been added")
+	ErrNotStarted       = errors.New("manager has not been started")
+	ErrNoNegotiator     = errors.New("manager has no negotiator")
+	ErrClientRefused    = errors.New("node does not accept clients")
+	ErrBadConfig        = errors.New("invalid node configuration")
+	ErrNoRoute          = errors.New("no route to the destination node")
+	ErrHopLimit         = errors.New("hop limit of the message is exhausted")
+	ErrAdminAddr        = errors.New("invalid administrative socket address")
+	ErrNoConduit        = errors.New("no conduit to the node is open")
+	ErrShuttingDown     = errors.New("node is shutting down")
+	ErrNotPunchable     = errors.New("node has no listeners through which holes may be punched")
+	ErrNoRendezvous     = errors.New("no peer can introduce the node")
+	ErrPunchInProgress  = errors.New("hole punching to the node is already in progress")
+	ErrPunchRefused     = errors.New("introduction to the node was refused")
+	ErrPunchFailed      = errors.New("hole punching to the node failed")
+	ErrRelayRefused     = errors.New("relay tunnel to the node was refused")
+	ErrBadSnapshot      = errors.New("node snapshot is not valid")
+	ErrCaptureDenied    = errors.New("capture file is outside the capture directory")
+	ErrDropped          = errors.New("conduit was dropped by the administrator")
+)
@@ -0,0 +1,608 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package node contains the components from which a Humboldt node is
+// assembled.  The Manager maintains the conduits to the node's peers:
+// it dials each desired peer, negotiates the conduit, and redials
+// with backoff when the conduit fails, and it accepts conduits dialed
+// by other nodes, keeping a single conduit to each peer node.
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// PeerState indicates the state of a peer.
+type PeerState int
+
+// Defined peer states.
+const (
+	PeerConnecting PeerState = iota // Dialing the peer
+	PeerOpen                        // A conduit to the peer is open
+	PeerBackoff                     // Waiting to dial again
+	PeerClosed                      // Gave up dialing the peer
+	PeerSelf                        // The URI connects to the local node
+)
+
+// String returns the name of the state.
+func (s PeerState) String() string {
+	switch s {
+	case PeerConnecting:
+		return "connecting"
+	case PeerOpen:
+		return "open"
+	case PeerBackoff:
+		return "backoff"
+	case PeerClosed:
+		return "closed"
+	case PeerSelf:
+		return "self"
+	}
+
+	return fmt.Sprintf("PeerState(%d)", int(s))
+}
+
+// Peer describes the state of a peer.  Peers added with AddPeer are
+// described by their URIs; peers which dialed the local node but
+// were not added are described only by their node IDs.
+type Peer struct {
+	URI      string           // URI of the peer, if added
+	NodeID   proto.NodeID     // Node ID of the peer, once known
+	State    PeerState        // State of the peer
+	Conduit  *conduit.Conduit // The conduit to the peer, when open
+	Inbound  bool             // Conduit was dialed by the peer
+	Failures int              // Consecutive failures to dial the peer
+	Err      error            // Error of the last failure
+}
+
+// peerEntry tracks a peer added with AddPeer.
+type peerEntry struct {
+	uri      string             // URI of the peer
+	id       proto.NodeID       // Node ID of the peer, once known
+	known    bool               // Node ID is known
+	state    PeerState          // State of the dialer
+	failures int                // Consecutive failures
+	err      error              // Error of the last failure
+	cancel   context.CancelFunc // Stops dialing the peer
+}
+
+// link is a conduit to a peer node.
+type link struct {
+	c         *conduit.Conduit // The conduit
+	inbound   bool             // Conduit was dialed by the peer
+	displaced chan struct{}    // Closed when another conduit is preferred
+}
+
+// Manager maintains the conduits to the peers of the local node.
+// Each peer added with AddPeer is dialed, and the conduit negotiated;
+// when the conduit fails, the peer is dialed again after a delay
+// computed by the backoff policy.  A peer whose URI turns out to
+// connect to the local node is marked with the PeerSelf state and is
+// not dialed again.  Conduits dialed by other nodes are passed to
+// Handle, which makes the Manager usable as the Handler of a
+// conduit.Server.  Only one conduit to each peer node is kept: if
+// two nodes dial each other at the same time, the conduit dialed by
+// the node with the lower node ID is kept, and the other is closed,
+// so that both nodes make the same choice.  While a peer has a
+// conduit open, whichever node dialed it, the peer is not dialed.
+// If Cache is set, the results of canonicalizing the peer URIs are
+// cached, so that redialing a peer does not repeat the lookups.  If
+// Selector is set, it decides the order in which the canonical URIs
+// of each peer are attempted; see conduit.DialAll.
+//
+// The Manager does not read from the conduits; the OnOpen callback
+// must arrange for the conduits to be read, so that failures are
+// detected.  The exported fields must be set before calling Start.
+//
+// When the node shuts down, Drain stops the Manager from dialing the
+// peers and establishing new conduits, while those already
+// established remain open until the Manager is stopped.
+type Manager struct {
+	Config     conduit.Config                                // The configuration for the mechanisms
+	Negotiator *proto.Negotiator                             // Negotiator, giving the local node ID; required
+	Options    []conduit.DialerOption                        // Options for dialing peers
+	Backoff    conduit.Backoff                               // The backoff policy for dialing peers
+	OnOpen     func(c *conduit.Conduit, inbound bool)        // Called when a conduit is established
+	OnClose    func(c *conduit.Conduit)                      // Called when an established conduit closes
+	OnClient   func(ctx context.Context, c *conduit.Conduit) // Serves a conduit from a client until it closes
+	OnDial     func(uri string, id proto.NodeID, err error)  // Called with the outcome of dialing a peer
+	Chaos      *conduit.Chaos                                // Faults to inject into dialed conduits; may be nil
+	Cache      *conduit.Cache                                // Cache for canonicalizing peer URIs; may be nil
+	Selector   conduit.Selector                              // Orders the canonical URIs of peers; may be nil
+
+	lock     sync.Mutex             // Protects the state
+	ctx      context.Context        // Context of the running manager
+	stop     context.CancelFunc     // Stops the manager
+	wg       sync.WaitGroup         // Tracks the dialers
+	peers    map[string]*peerEntry  // Peers added, by URI
+	links    map[proto.NodeID]*link // Established conduits, by node ID
+	draining bool                   // New conduits are refused
+}
+
+// Start starts the manager, dialing the peers which have been added.
+// Cancelling the context has the same effect as calling Stop.
+func (m *Manager) Start(ctx context.Context) error {
+	if m.Negotiator == nil {
+		return ErrNoNegotiator
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.ctx != nil {
+		return nil
+	}
+	m.ctx, m.stop = context.WithCancel(ctx)
+	for _, e := range m.peers {
+		m.startPeer(e)
+	}
+
+	return nil
+}
+
+// Stop stops the manager, closing all the conduits.  It waits for the
+// conduits dialed by the manager to close; conduits passed to Handle
+// are closed when Handle returns.
+func (m *Manager) Stop() {
+	m.lock.Lock()
+	stop := m.stop
+	m.lock.Unlock()
+
+	if stop != nil {
+		stop()
+		m.wg.Wait()
+	}
+}
+
+// Drain stops the manager from dialing the peers, and refuses the
+// conduits subsequently dialed by other nodes or by clients with a
+// close notice giving ErrShuttingDown as the reason.  The conduits
+// already established are left open; Stop closes them.
+func (m *Manager) Drain() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.draining = true
+}
+
+// Reconfigure replaces the configuration of the mechanisms, the
+// faults to inject, the URI cache, and the URI selector the manager
+// dials peers with.  A nil cache leaves the current cache in place.
+// Dials already in progress complete with the settings they started
+// with.
+func (m *Manager) Reconfigure(config conduit.Config, chaos *conduit.Chaos, cache *conduit.Cache, selector conduit.Selector) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Config = config
+	m.Chaos = chaos
+	if cache != nil {
+		m.Cache = cache
+	}
+	m.Selector = selector
+}
+
+// isDraining tests whether the manager is draining.
+func (m *Manager) isDraining() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.draining
+}
+
+// startPeer starts dialing a peer.  Must be called with the lock
+// held, after the manager has been started.
+func (m *Manager) startPeer(e *peerEntry) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	e.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.dialer(ctx, e)
+	}()
+}
+
+// AddPeer adds a peer to be dialed.  The URI is dialed with
+// conduit.DialAll, so it need not be canonical.  If the peer has
+// already been added, an error wrapping ErrPeerExists is returned.
+func (m *Manager) AddPeer(uri string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.peers[uri]; ok {
+		return fmt.Errorf("%s: %w", uri, ErrPeerExists)
+	}
+	if m.peers == nil {
+		m.peers = map[string]*peerEntry{}
+	}
+	e := &peerEntry{uri: uri}
+	m.peers[uri] = e
+	if m.ctx != nil {
+		m.startPeer(e)
+	}
+
+	return nil
+}
+
+// RemovePeer removes a peer, so that it is no longer dialed.  The
+// conduit to the peer is closed if the manager dialed it.  If the
+// peer has not been added, an error wrapping ErrUnknownPeer is
+// returned.
+func (m *Manager) RemovePeer(uri string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.peers[uri]
+	if !ok {
+		return fmt.Errorf("%s: %w", uri, ErrUnknownPeer)
+	}
+	delete(m.peers, uri)
+	if e.cancel != nil {
+		e.cancel()
+	}
+
+	return nil
+}
+
+// setState sets the state of the dialer of a peer.
+func (m *Manager) setState(e *peerEntry, state PeerState, failures int, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e.state = state
+	e.failures = failures
+	e.err = err
+}
+
+// established returns the conduit to the peer, if one is open.
+func (m *Manager) established(e *peerEntry) *conduit.Conduit {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if l, ok := m.links[e.id]; ok && e.known {
+		return l.c
+	}
+
+	return nil
+}
+
+// dialer maintains the conduit to a peer until stopped or drained.
+func (m *Manager) dialer(ctx context.Context, e *peerEntry) {
+	failures := 0
+	for ctx.Err() == nil && !m.isDraining() {
+		// Wait while a conduit to the peer is open
+		if c := m.established(e); c != nil {
+			m.setState(e, PeerOpen, 0, nil)
+			select {
+			case <-c.Context().Done():
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// Dial and negotiate the conduit
+		m.setState(e, PeerConnecting, failures, nil)
+		c, err := m.dial(ctx, e)
+		m.dialed(ctx, e, c, err)
+		if err == nil {
+			var l *link
+			if l, err = m.register(c, false); err == nil {
+				failures = 0
+				m.hold(ctx, l)
+				continue
+			}
+			c.CloseWithReason(err) //nolint:errcheck
+			if errors.Is(err, ErrDuplicateConduit) || errors.Is(err, ErrShuttingDown) {
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Stop dialing a URI which connects to the local node
+		if errors.Is(err, proto.ErrSelfConnection) {
+			m.setState(e, PeerSelf, 0, fmt.Errorf("%s: %w", e.uri, err))
+			return
+		}
+
+		// Back off before trying again
+		failures++
+		if m.Backoff.MaxRetries > 0 && failures > m.Backoff.MaxRetries {
+			m.setState(e, PeerClosed, failures, fmt.Errorf("%s: %w: %w", e.uri, conduit.ErrGaveUp, err))
+			return
+		}
+		delay := m.Backoff.Delay(failures)
+		m.setState(e, PeerBackoff, failures, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// dial dials and negotiates a conduit to a peer, recording its node
+// ID.
+func (m *Manager) dial(ctx context.Context, e *peerEntry) (*conduit.Conduit, error) {
+	m.lock.Lock()
+	config, chaos, cache, selector := m.Config, m.Chaos, m.Cache, m.Selector
+	m.lock.Unlock()
+
+	if cache != nil {
+		ctx = conduit.WithCache(ctx, cache)
+	}
+	if selector != nil {
+		ctx = conduit.WithSelector(ctx, selector)
+	}
+	c, err := dialAll(ctx, config, e.uri, 0, m.Options...)
+	if err != nil {
+		return nil, err
+	}
+	if chaos != nil {
+		chaos.Apply(c)
+	}
+	if err := c.Negotiate(m.Negotiator); err != nil {
+		c.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	m.lock.Lock()
+	e.id, e.known = c.Peer.(proto.NodeID)
+	m.lock.Unlock()
+
+	return c, nil
+}
+
+// dialed reports the outcome of dialing a peer to OnDial, unless the
+// dial was interrupted by the context.
+func (m *Manager) dialed(ctx context.Context, e *peerEntry, c *conduit.Conduit, err error) {
+	if m.OnDial == nil || ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		m.OnDial(e.uri, proto.NodeID{}, err)
+		return
+	}
+	id, _ := c.Peer.(proto.NodeID)
+	m.OnDial(e.uri, id, nil)
+}
+
+// prefer tests whether a new conduit to a peer node should replace
+// an existing one.  The conduit dialed by the node with the lower
+// node ID is preferred; if both conduits were dialed by the same
+// node, the new one is preferred, since the peer must have given up
+// on the existing one.
+func (m *Manager) prefer(id proto.NodeID, c, old *link) bool {
+	lowerDials := m.Negotiator.NodeID.Less(id)
+	if c.inbound == old.inbound {
+		return true
+	}
+
+	return c.inbound != lowerDials
+}
+
+// register registers a negotiated conduit to a peer node.  If another
+// conduit to the node is preferred, an error wrapping
+// ErrDuplicateConduit is returned, and if the manager is draining,
+// ErrShuttingDown; otherwise, the existing conduit, if any, is
+// displaced, causing it to be closed.
+func (m *Manager) register(c *conduit.Conduit, inbound bool) (*link, error) {
+	id, _ := c.Peer.(proto.NodeID)
+	l := &link{c: c, inbound: inbound, displaced: make(chan struct{})}
+
+	m.lock.Lock()
+	if m.draining {
+		m.lock.Unlock()
+		return nil, ErrShuttingDown
+	}
+	old, ok := m.links[id]
+	if ok && !m.prefer(id, l, old) {
+		m.lock.Unlock()
+		return nil, fmt.Errorf("node %s: %w", id, ErrDuplicateConduit)
+	}
+	if ok {
+		close(old.displaced)
+	}
+	if m.links == nil {
+		m.links = map[proto.NodeID]*link{}
+	}
+	m.links[id] = l
+	m.lock.Unlock()
+
+	if m.OnOpen != nil {
+		m.OnOpen(c, inbound)
+	}
+
+	return l, nil
+}
+
+// hold waits for an established conduit to close, be displaced, or
+// the context to be cancelled, then closes and unregisters the
+// conduit.
+func (m *Manager) hold(ctx context.Context, l *link) {
+	c := l.c
+	select {
+	case <-c.Context().Done():
+		c.Close() //nolint:errcheck
+	case <-l.displaced:
+		c.CloseWithReason(ErrDuplicateConduit) //nolint:errcheck
+	case <-ctx.Done():
+		c.Close() //nolint:errcheck
+	}
+
+	id, _ := c.Peer.(proto.NodeID)
+	m.lock.Lock()
+	if l, ok := m.links[id]; ok && l.c == c {
+		delete(m.links, id)
+	}
+	m.lock.Unlock()
+
+	if m.OnClose != nil {
+		m.OnClose(c)
+	}
+}
+
+// Handle handles a conduit dialed by another node, negotiating it and
+// keeping it open until it fails, the context is cancelled, or the
+// manager is stopped.  Conduits from clients, which negotiate with
+// the zero node ID, are instead passed to OnClient, or refused if it
+// is not set.  All conduits are refused while the manager is
+// draining.  The conduit is closed on return.
+func (m *Manager) Handle(ctx context.Context, c *conduit.Conduit) {
+	m.lock.Lock()
+	mctx := m.ctx
+	m.lock.Unlock()
+	if mctx == nil {
+		c.Close() //nolint:errcheck
+		return
+	}
+
+	if err := c.Negotiate(m.Negotiator); err != nil {
+		c.Close() //nolint:errcheck
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(mctx, cancel)
+	defer stop()
+
+	// Refuse conduits while draining
+	if m.isDraining() {
+		c.CloseWithReason(ErrShuttingDown) //nolint:errcheck
+		return
+	}
+
+	// Pass conduits from clients to the client handler
+	if id, _ := c.Peer.(proto.NodeID); id.IsZero() {
+		if m.OnClient == nil {
+			c.CloseWithReason(ErrClientRefused) //nolint:errcheck
+			return
+		}
+		m.OnClient(ctx, c)
+		c.Close() //nolint:errcheck
+		return
+	}
+
+	l, err := m.register(c, true)
+	if err != nil {
+		c.CloseWithReason(err) //nolint:errcheck
+		return
+	}
+	m.hold(ctx, l)
+}
+
+// Adopt adopts a conduit to a peer node which was dialed other than
+// by the manager, such as through a hole punched with
+// conduit.Punch.  The conduit is negotiated and registered as though
+// the manager had dialed it, and is kept open until it fails, is
+// displaced, or the manager is stopped; it is not redialed.  If the
+// conduit cannot be negotiated or registered, it is closed and the
+// error is returned.  If the manager has not been started, an error
+// wrapping ErrNotStarted is returned.
+func (m *Manager) Adopt(c *conduit.Conduit) error {
+	m.lock.Lock()
+	mctx := m.ctx
+	m.lock.Unlock()
+	if mctx == nil {
+		c.Close() //nolint:errcheck
+		return ErrNotStarted
+	}
+
+	if err := c.Negotiate(m.Negotiator); err != nil {
+		c.Close() //nolint:errcheck
+		return err
+	}
+	l, err := m.register(c, false)
+	if err != nil {
+		c.CloseWithReason(err) //nolint:errcheck
+		return err
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.hold(mctx, l)
+	}()
+
+	return nil
+}
+
+// Conduit returns the conduit to a peer node, or nil if none is open.
+func (m *Manager) Conduit(id proto.NodeID) *conduit.Conduit {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if l, ok := m.links[id]; ok {
+		return l.c
+	}
+
+	return nil
+}
+
+// Peers returns the states of the peers: first those added with
+// AddPeer, sorted by URI, then those with conduits open which were
+// not added, sorted by node ID.
+func (m *Manager) Peers() []*Peer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	result := make([]*Peer, 0, len(m.peers)+len(m.links))
+	seen := map[proto.NodeID]bool{}
+	for _, e := range m.peers {
+		p := &Peer{
+			URI:      e.uri,
+			NodeID:   e.id,
+			State:    e.state,
+			Failures: e.failures,
+			Err:      e.err,
+		}
+		if l, ok := m.links[e.id]; ok && e.known {
+			p.State = PeerOpen
+			p.Conduit = l.c
+			p.Inbound = l.inbound
+			seen[e.id] = true
+		}
+		result = append(result, p)
+	}
+	slices.SortFunc(result, func(a, b *Peer) int {
+		return strings.Compare(a.URI, b.URI)
+	})
+
+	others := make([]*Peer, 0, len(m.links))
+	for id, l := range m.links {
+		if !seen[id] {
+			others = append(others, &Peer{NodeID: id, State: PeerOpen, Conduit: l.c, Inbound: l.inbound})
+		}
+	}
+	slices.SortFunc(others, func(a, b *Peer) int {
+		return a.NodeID.Compare(b.NodeID)
+	})
+
+	return append(result, others...)
+}
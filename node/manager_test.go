@@ -0,0 +1,551 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// testNode is a node running a Manager for testing.
+type testNode struct {
+	mgr    *Manager       // The manager
+	cancel func()         // Stops the node
+	wg     sync.WaitGroup // Tracks the server and readers
+	closes chan *conduit.Conduit
+}
+
+// newTestNode starts a node with the specified ID, listening on a
+// named in-memory endpoint.
+func newTestNode(t *testing.T, id byte, name string) *testNode {
+	t.Helper()
+
+	n := &testNode{closes: make(chan *conduit.Conduit, 100)}
+	n.mgr = &Manager{
+		Negotiator: &proto.Negotiator{MaxProto: 1, NodeID: proto.NodeID{id}},
+		Backoff:    conduit.Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond},
+		OnOpen: func(c *conduit.Conduit, inbound bool) {
+			n.wg.Add(1)
+			go func() {
+				defer n.wg.Done()
+				for {
+					if _, err := c.Recv(); err != nil {
+						return
+					}
+				}
+			}()
+		},
+		OnClose: func(c *conduit.Conduit) {
+			n.closes <- c
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, n.mgr.Start(ctx))
+
+	l, err := conduit.Listen(ctx, nil, "mem:"+name)
+	require.NoError(t, err)
+	srv := &conduit.Server{Listener: l, Handler: n.mgr}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		srv.Serve(ctx) //nolint:errcheck
+	}()
+	n.cancel = func() {
+		cancel()
+		n.mgr.Stop()
+		n.wg.Wait()
+	}
+
+	return n
+}
+
+// peerOpen waits for the manager to have an open conduit to the
+// node, returning the peer.
+func peerOpen(t *testing.T, m *Manager, id byte) *Peer {
+	t.Helper()
+
+	var result *Peer
+	require.Eventually(t, func() bool {
+		for _, p := range m.Peers() {
+			if p.NodeID == (proto.NodeID{id}) && p.State == PeerOpen {
+				result = p
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, time.Millisecond)
+
+	return result
+}
+
+func TestPeerStateString(t *testing.T) {
+	assert.Equal(t, "connecting", PeerConnecting.String())
+	assert.Equal(t, "open", PeerOpen.String())
+	assert.Equal(t, "backoff", PeerBackoff.String())
+	assert.Equal(t, "closed", PeerClosed.String())
+	assert.Equal(t, "self", PeerSelf.String())
+	assert.Equal(t, "PeerState(42)", PeerState(42).String())
+}
+
+func TestManagerStartNoNegotiator(t *testing.T) {
+	obj := &Manager{}
+
+	err := obj.Start(context.Background())
+
+	assert.ErrorIs(t, err, ErrNoNegotiator)
+	assert.Nil(t, obj.ctx)
+}
+
+func TestManagerAddPeerExists(t *testing.T) {
+	obj := &Manager{}
+	require.NoError(t, obj.AddPeer("mem:peer"))
+
+	err := obj.AddPeer("mem:peer")
+
+	assert.ErrorIs(t, err, ErrPeerExists)
+	assert.Len(t, obj.peers, 1)
+}
+
+func TestManagerRemovePeerUnknown(t *testing.T) {
+	obj := &Manager{}
+
+	err := obj.RemovePeer("mem:peer")
+
+	assert.ErrorIs(t, err, ErrUnknownPeer)
+}
+
+func TestManagerPeersNotStarted(t *testing.T) {
+	obj := &Manager{}
+	require.NoError(t, obj.AddPeer("mem:b"))
+	require.NoError(t, obj.AddPeer("mem:a"))
+
+	result := obj.Peers()
+
+	assert.Equal(t, []*Peer{
+		{URI: "mem:a", State: PeerConnecting},
+		{URI: "mem:b", State: PeerConnecting},
+	}, result)
+}
+
+func TestManagerPrefer(t *testing.T) {
+	obj := &Manager{Negotiator: &proto.Negotiator{NodeID: proto.NodeID{2}}}
+	out, in := &link{}, &link{inbound: true}
+
+	// The lower node dials the preferred conduit
+	assert.True(t, obj.prefer(proto.NodeID{3}, out, in))
+	assert.False(t, obj.prefer(proto.NodeID{3}, in, out))
+	assert.True(t, obj.prefer(proto.NodeID{1}, in, out))
+	assert.False(t, obj.prefer(proto.NodeID{1}, out, in))
+
+	// The newer conduit replaces one dialed the same way
+	assert.True(t, obj.prefer(proto.NodeID{3}, in, in))
+	assert.True(t, obj.prefer(proto.NodeID{1}, out, out))
+}
+
+func TestManagerDial(t *testing.T) {
+	a := newTestNode(t, 1, "manager-dial-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-dial-b")
+	defer b.cancel()
+
+	require.NoError(t, a.mgr.AddPeer("mem:manager-dial-b"))
+
+	p := peerOpen(t, a.mgr, 2)
+	assert.Equal(t, "mem:manager-dial-b", p.URI)
+	assert.False(t, p.Inbound)
+	assert.Equal(t, proto.NodeID{2}, p.Conduit.Peer)
+	assert.Same(t, p.Conduit, a.mgr.Conduit(proto.NodeID{2}))
+	q := peerOpen(t, b.mgr, 1)
+	assert.Equal(t, "", q.URI)
+	assert.True(t, q.Inbound)
+	assert.Nil(t, b.mgr.Conduit(proto.NodeID{3}))
+}
+
+func TestManagerReconnect(t *testing.T) {
+	a := newTestNode(t, 1, "manager-reconnect-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-reconnect-b")
+	defer b.cancel()
+	require.NoError(t, a.mgr.AddPeer("mem:manager-reconnect-b"))
+	first := peerOpen(t, a.mgr, 2).Conduit
+
+	first.Link.Close() //nolint:errcheck
+
+	assert.Same(t, first, <-a.closes)
+	require.Eventually(t, func() bool {
+		c := a.mgr.Conduit(proto.NodeID{2})
+		return c != nil && c != first
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestManagerRemovePeer(t *testing.T) {
+	a := newTestNode(t, 1, "manager-remove-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-remove-b")
+	defer b.cancel()
+	require.NoError(t, a.mgr.AddPeer("mem:manager-remove-b"))
+	c := peerOpen(t, a.mgr, 2).Conduit
+
+	err := a.mgr.RemovePeer("mem:manager-remove-b")
+
+	assert.NoError(t, err)
+	assert.Same(t, c, <-a.closes)
+	assert.Empty(t, a.mgr.Peers())
+}
+
+func TestManagerCrossed(t *testing.T) {
+	a := newTestNode(t, 1, "manager-crossed-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-crossed-b")
+	defer b.cancel()
+
+	require.NoError(t, a.mgr.AddPeer("mem:manager-crossed-b"))
+	require.NoError(t, b.mgr.AddPeer("mem:manager-crossed-a"))
+
+	// Both nodes settle on the conduit dialed by the lower node
+	require.Eventually(t, func() bool {
+		pa, pb := a.mgr.Peers(), b.mgr.Peers()
+		return len(pa) == 1 && pa[0].State == PeerOpen && !pa[0].Inbound &&
+			len(pb) == 1 && pb[0].State == PeerOpen && pb[0].Inbound
+	}, 5*time.Second, time.Millisecond)
+	ca, cb := a.mgr.Conduit(proto.NodeID{2}), b.mgr.Conduit(proto.NodeID{1})
+	assert.Equal(t, ca.LocalURI.String(), cb.RemoteURI.String())
+	time.Sleep(50 * time.Millisecond)
+	assert.Same(t, ca, a.mgr.Conduit(proto.NodeID{2}))
+	assert.Same(t, cb, b.mgr.Conduit(proto.NodeID{1}))
+}
+
+func TestManagerGiveUp(t *testing.T) {
+	defer patcher.SetVar(&dialAll, func(ctx context.Context, config conduit.Config, uri string, delay time.Duration, opts ...conduit.DialerOption) (*conduit.Conduit, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj := &Manager{
+		Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}},
+		Backoff:    conduit.Backoff{Initial: time.Millisecond, MaxRetries: 2},
+	}
+	require.NoError(t, obj.AddPeer("mem:peer"))
+
+	require.NoError(t, obj.Start(context.Background()))
+	defer obj.Stop()
+
+	require.Eventually(t, func() bool {
+		return obj.Peers()[0].State == PeerClosed
+	}, 5*time.Second, time.Millisecond)
+	p := obj.Peers()[0]
+	assert.Equal(t, 3, p.Failures)
+	assert.ErrorIs(t, p.Err, conduit.ErrGaveUp)
+	assert.ErrorIs(t, p.Err, assert.AnError)
+}
+
+func TestManagerCache(t *testing.T) {
+	cache := &conduit.Cache{}
+	caches := make(chan *conduit.Cache, 1)
+	defer patcher.SetVar(&dialAll, func(ctx context.Context, config conduit.Config, uri string, delay time.Duration, opts ...conduit.DialerOption) (*conduit.Conduit, error) {
+		select {
+		case caches <- conduit.ContextCache(ctx):
+		default:
+		}
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj := &Manager{
+		Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}},
+		Backoff:    conduit.Backoff{Initial: time.Hour},
+		Cache:      cache,
+	}
+	require.NoError(t, obj.AddPeer("mem:peer"))
+
+	require.NoError(t, obj.Start(context.Background()))
+	defer obj.Stop()
+
+	assert.Same(t, cache, <-caches)
+}
+
+func TestManagerReconfigure(t *testing.T) {
+	config := &conduit.ConfigMap{}
+	chaos := &conduit.Chaos{}
+	cache := &conduit.Cache{}
+	sel := &conduit.RTTSelector{}
+	obj := &Manager{Cache: &conduit.Cache{}}
+
+	obj.Reconfigure(config, chaos, cache, sel)
+
+	assert.Same(t, config, obj.Config)
+	assert.Same(t, chaos, obj.Chaos)
+	assert.Same(t, cache, obj.Cache)
+	assert.Same(t, sel, obj.Selector)
+}
+
+func TestManagerReconfigureKeepsCache(t *testing.T) {
+	cache := &conduit.Cache{}
+	obj := &Manager{Cache: cache, Chaos: &conduit.Chaos{}, Selector: &conduit.RTTSelector{}}
+
+	obj.Reconfigure(nil, nil, nil, nil)
+
+	assert.Nil(t, obj.Config)
+	assert.Nil(t, obj.Chaos)
+	assert.Same(t, cache, obj.Cache)
+	assert.Nil(t, obj.Selector)
+}
+
+func TestManagerSelector(t *testing.T) {
+	sel := &conduit.RTTSelector{}
+	selectors := make(chan conduit.Selector, 1)
+	defer patcher.SetVar(&dialAll, func(ctx context.Context, config conduit.Config, uri string, delay time.Duration, opts ...conduit.DialerOption) (*conduit.Conduit, error) {
+		select {
+		case selectors <- conduit.ContextSelector(ctx):
+		default:
+		}
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj := &Manager{
+		Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}},
+		Backoff:    conduit.Backoff{Initial: time.Hour},
+		Selector:   sel,
+	}
+	require.NoError(t, obj.AddPeer("mem:peer"))
+
+	require.NoError(t, obj.Start(context.Background()))
+	defer obj.Stop()
+
+	assert.Same(t, sel, <-selectors)
+}
+
+func TestManagerBackoff(t *testing.T) {
+	defer patcher.SetVar(&dialAll, func(ctx context.Context, config conduit.Config, uri string, delay time.Duration, opts ...conduit.DialerOption) (*conduit.Conduit, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj := &Manager{
+		Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}},
+		Backoff:    conduit.Backoff{Initial: time.Hour},
+	}
+	require.NoError(t, obj.Start(context.Background()))
+	require.NoError(t, obj.AddPeer("mem:peer"))
+
+	require.Eventually(t, func() bool {
+		return obj.Peers()[0].State == PeerBackoff
+	}, 5*time.Second, time.Millisecond)
+	obj.Stop()
+
+	p := obj.Peers()[0]
+	assert.Equal(t, 1, p.Failures)
+	assert.Same(t, assert.AnError, p.Err)
+}
+
+func TestManagerSelf(t *testing.T) {
+	a := newTestNode(t, 1, "manager-self-a")
+	defer a.cancel()
+
+	require.NoError(t, a.mgr.AddPeer("mem:manager-self-a"))
+
+	require.Eventually(t, func() bool {
+		return a.mgr.Peers()[0].State == PeerSelf
+	}, 5*time.Second, time.Millisecond)
+	p := a.mgr.Peers()[0]
+	assert.ErrorIs(t, p.Err, proto.ErrSelfConnection)
+	assert.Len(t, a.mgr.Peers(), 1)
+	assert.Nil(t, a.mgr.Conduit(proto.NodeID{1}))
+}
+
+func TestManagerClient(t *testing.T) {
+	a := newTestNode(t, 1, "manager-client-a")
+	defer a.cancel()
+	clients := make(chan *conduit.Conduit, 1)
+	a.mgr.OnClient = func(ctx context.Context, c *conduit.Conduit) {
+		clients <- c
+		c.Recv() //nolint:errcheck
+	}
+	c, err := conduit.Dial(context.Background(), nil, "mem:manager-client-a")
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Negotiate(&proto.Negotiator{MaxProto: 1})
+
+	require.NoError(t, err)
+	assert.Equal(t, proto.NodeID{}, (<-clients).Peer)
+	assert.Empty(t, a.mgr.Peers())
+}
+
+func TestManagerClientRefused(t *testing.T) {
+	a := newTestNode(t, 1, "manager-client-refused-a")
+	defer a.cancel()
+	c, err := conduit.Dial(context.Background(), nil, "mem:manager-client-refused-a")
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Negotiate(&proto.Negotiator{MaxProto: 1}))
+
+	f, err := c.Recv()
+
+	require.NoError(t, err)
+	msg := &proto.ControlMessage{}
+	_, err = msg.FromBytes(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, proto.ControlClose, msg.Type)
+	assert.Contains(t, string(msg.Body), ErrClientRefused.Error())
+}
+
+func TestManagerHandleNotStarted(t *testing.T) {
+	obj := &Manager{Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}}}
+	c := &conduit.Conduit{State: conduit.Passive}
+
+	obj.Handle(context.Background(), c)
+
+	assert.Equal(t, conduit.Closed, c.State)
+	assert.Error(t, c.Context().Err())
+}
+
+func TestManagerStop(t *testing.T) {
+	a := newTestNode(t, 1, "manager-stop-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-stop-b")
+	defer b.cancel()
+	require.NoError(t, a.mgr.AddPeer("mem:manager-stop-b"))
+	c := peerOpen(t, a.mgr, 2).Conduit
+
+	a.mgr.Stop()
+
+	assert.Same(t, c, <-a.closes)
+	assert.Equal(t, conduit.Closed, c.State)
+	assert.Equal(t, proto.NodeID{1}, (<-b.closes).Peer)
+	assert.Empty(t, b.mgr.Peers())
+}
+
+func TestManagerDrain(t *testing.T) {
+	a := newTestNode(t, 1, "manager-drain-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-drain-b")
+	defer b.cancel()
+	require.NoError(t, a.mgr.AddPeer("mem:manager-drain-b"))
+	c := peerOpen(t, a.mgr, 2).Conduit
+
+	a.mgr.Drain()
+	b.mgr.Drain()
+
+	assert.Same(t, c, a.mgr.Conduit(proto.NodeID{2}))
+	c.Link.Close() //nolint:errcheck
+	assert.Same(t, c, <-a.closes)
+	assert.Never(t, func() bool {
+		return a.mgr.Conduit(proto.NodeID{2}) != nil
+	}, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestManagerDrainRefuses(t *testing.T) {
+	a := newTestNode(t, 1, "manager-drain-refuses-a")
+	defer a.cancel()
+	a.mgr.Drain()
+	c, err := conduit.Dial(context.Background(), nil, "mem:manager-drain-refuses-a")
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Negotiate(&proto.Negotiator{MaxProto: 1, NodeID: proto.NodeID{2}}))
+
+	f, err := c.Recv()
+
+	require.NoError(t, err)
+	msg := &proto.ControlMessage{}
+	_, err = msg.FromBytes(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, proto.ControlClose, msg.Type)
+	assert.Contains(t, string(msg.Body), ErrShuttingDown.Error())
+	assert.Empty(t, a.mgr.Peers())
+}
+
+func TestManagerRegisterDraining(t *testing.T) {
+	obj := &Manager{Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}}}
+	obj.Drain()
+
+	result, err := obj.register(&conduit.Conduit{Peer: proto.NodeID{2}}, false)
+
+	assert.ErrorIs(t, err, ErrShuttingDown)
+	assert.Nil(t, result)
+	assert.Nil(t, obj.Conduit(proto.NodeID{2}))
+}
+
+func TestManagerOnDial(t *testing.T) {
+	a := newTestNode(t, 1, "manager-on-dial-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-on-dial-b")
+	defer b.cancel()
+	type outcome struct {
+		uri string
+		id  proto.NodeID
+		err error
+	}
+	outcomes := make(chan outcome, 10)
+	a.mgr.OnDial = func(uri string, id proto.NodeID, err error) {
+		outcomes <- outcome{uri: uri, id: id, err: err}
+	}
+
+	require.NoError(t, a.mgr.AddPeer("mem:manager-on-dial-b"))
+	require.NoError(t, a.mgr.AddPeer("mem:manager-on-dial-missing"))
+
+	results := map[string]outcome{}
+	for len(results) < 2 {
+		o := <-outcomes
+		results[o.uri] = o
+	}
+	assert.Equal(t, proto.NodeID{2}, results["mem:manager-on-dial-b"].id)
+	assert.NoError(t, results["mem:manager-on-dial-b"].err)
+	assert.Equal(t, proto.NodeID{}, results["mem:manager-on-dial-missing"].id)
+	assert.Error(t, results["mem:manager-on-dial-missing"].err)
+}
+
+func TestManagerAdopt(t *testing.T) {
+	a := newTestNode(t, 1, "manager-adopt-a")
+	defer a.cancel()
+	b := newTestNode(t, 2, "manager-adopt-b")
+	defer b.cancel()
+	c, err := conduit.Dial(context.Background(), nil, "mem:manager-adopt-b")
+	require.NoError(t, err)
+
+	err = a.mgr.Adopt(c)
+
+	require.NoError(t, err)
+	p := peerOpen(t, a.mgr, 2)
+	assert.Equal(t, "", p.URI)
+	assert.False(t, p.Inbound)
+	assert.Same(t, c, p.Conduit)
+	assert.True(t, peerOpen(t, b.mgr, 1).Inbound)
+}
+
+func TestManagerAdoptNegotiateError(t *testing.T) {
+	a := newTestNode(t, 1, "manager-adopt-negotiate-error")
+	defer a.cancel()
+	c, err := conduit.Dial(context.Background(), nil, "mem:manager-adopt-negotiate-error")
+	require.NoError(t, err)
+
+	err = a.mgr.Adopt(c)
+
+	assert.ErrorIs(t, err, proto.ErrSelfConnection)
+	assert.Error(t, c.Context().Err())
+	assert.Empty(t, a.mgr.Peers())
+}
+
+func TestManagerAdoptNotStarted(t *testing.T) {
+	obj := &Manager{Negotiator: &proto.Negotiator{NodeID: proto.NodeID{1}}}
+	c := &conduit.Conduit{State: conduit.Active}
+
+	err := obj.Adopt(c)
+
+	assert.ErrorIs(t, err, ErrNotStarted)
+	assert.Equal(t, conduit.Closed, c.State)
+}
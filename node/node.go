@@ -0,0 +1,995 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/flood"
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+	"github.com/hydralang/humboldt/routing"
+)
+
+// Node is a Humboldt node.  It listens for conduits on the configured
+// URIs and maintains conduits to the configured peers using a
+// Manager.  Over each conduit to a peer node, it answers and sends
+// pings, and floods link-state records, from which it computes the
+// routes over which data messages are forwarded; see Forward.
+// Conduits from clients are served as well: data messages sent by
+// clients are forwarded toward their destinations, and data messages
+// addressed to the node are delivered to the clients subscribed to
+// their application protocols.  If configured, an administrative
+// control socket is served; see AdminAddr.  A node may be stopped
+// abruptly with Stop, or shut down gracefully with Shutdown.
+type Node struct {
+	ID      proto.NodeID          // Identifier of the node
+	Manager *Manager              // Maintains the conduits to the peers
+	Routes  *routing.Table        // The routing table
+	Flooder *flood.Flooder        // Floods link-state records
+	Hops    *proto.Pipeline       // Processes hop-by-hop extensions
+	Peers   *peer.Store           // Remembers the peers dialed
+	Latency *proto.LatencyTracker // Round-trip times of client calls, by destination
+
+	lock       sync.Mutex                              // Protects the state
+	config     *Config                                 // The current configuration
+	peerStore  string                                  // File the peer store is saved to
+	ctx        context.Context                         // Context of the running node
+	stop       context.CancelFunc                      // Stops the node
+	wg         sync.WaitGroup                          // Tracks the servers and readers
+	listeners  []conduit.Listener                      // The listeners
+	external   map[conduit.Listener]*conduit.URI       // External URIs of the listeners
+	admin      net.Listener                            // The administrative control socket
+	queues     map[*conduit.Conduit]*queue             // Send queues of the conduits
+	clients    map[*conduit.Conduit]map[uint8]bool     // Client subscriptions
+	topics     map[*conduit.Conduit]map[string]bool    // Client topic subscriptions
+	replies    map[*conduit.Conduit]*proto.ReplyCache  // Replies to client requests
+	nodeTopics map[proto.NodeID]*proto.Topics          // Topics records of the other nodes
+	topicSeq   uint64                                  // Sequence number of the topics record
+	punches    map[proto.NodeID]chan *proto.Rendezvous // Answers awaited by Punch, by target
+	tunnels    map[tunnelKey]*tunnel                   // Relay tunnels ending at the node
+	splices    map[tunnelKey]tunnelKey                 // Relay tunnels spliced by the node
+	nextTunnel uint32                                  // Counter allocating relay tunnel IDs
+	calls      map[uint32]*call                        // Requests sent by clients, by node correlation ID
+	nextCall   uint32                                  // Counter allocating request correlation IDs
+	lastData   atomic.Int64                            // When data was last received, in Unix nanoseconds
+	capture    *conduit.Capture                        // Capture of the PDUs exchanged, if enabled
+}
+
+// New constructs a node with the specified configuration.  The node
+// ID is loaded from the configured node ID file, which is created if
+// it does not exist, and the peer store from the configured peer
+// store file, if it exists.
+func New(cfg *Config) (*Node, error) {
+	var id proto.NodeID
+	var err error
+	if cfg.NodeIDFile != "" {
+		id, err = loadOrGenerateNodeID(cfg.NodeIDFile)
+	} else {
+		id, err = generateNodeID()
+	}
+	if err != nil {
+		return nil, err
+	}
+	cost, err := cfg.costFunc()
+	if err != nil {
+		return nil, err
+	}
+	peers := &peer.Store{}
+	if cfg.PeerStore != "" {
+		if peers, err = loadPeerStore(cfg.PeerStore); err != nil {
+			return nil, err
+		}
+	}
+
+	n := &Node{
+		ID:         id,
+		Routes:     routing.NewTable(id),
+		Hops:       &proto.Pipeline{},
+		Peers:      peers,
+		Latency:    &proto.LatencyTracker{Timeout: CallExpiry},
+		config:     cfg,
+		peerStore:  cfg.PeerStore,
+		queues:     map[*conduit.Conduit]*queue{},
+		clients:    map[*conduit.Conduit]map[uint8]bool{},
+		topics:     map[*conduit.Conduit]map[string]bool{},
+		replies:    map[*conduit.Conduit]*proto.ReplyCache{},
+		nodeTopics: map[proto.NodeID]*proto.Topics{},
+		topicSeq:   uint64(time.Now().UnixNano()),
+		tunnels:    map[tunnelKey]*tunnel{},
+		splices:    map[tunnelKey]tunnelKey{},
+		calls:      map[uint32]*call{},
+	}
+	n.Routes.SetCostFunc(cost)
+	for _, service := range cfg.Anycast {
+		n.Routes.Claim(proto.AnycastID(service), 0)
+	}
+	n.Flooder = &flood.Flooder{Self: id, Deliver: n.deliverFlood}
+	n.Manager = &Manager{
+		Negotiator: &proto.Negotiator{MinProto: proto.Version, MaxProto: proto.Version, MaxMajor: proto.MaxMajor, NodeID: id},
+		OnOpen:     n.open,
+		OnClose:    n.close,
+		OnClient:   n.serveClient,
+		OnDial:     n.dialed,
+		Cache:      &conduit.Cache{},
+	}
+	n.reconfigure(cfg)
+
+	return n, nil
+}
+
+// reconfigure passes the settings of the mechanisms from a
+// configuration through to the manager.
+func (n *Node) reconfigure(cfg *Config) {
+	config := &relayConfig{conduit: cfg.Conduit, relay: &conduit.RelayConfig{Dialer: n}}
+	if cfg.Conduit == nil {
+		n.Manager.Reconfigure(config, nil, nil, nil)
+		return
+	}
+	n.Manager.Reconfigure(config, cfg.Conduit.Chaos, cfg.Conduit.Cache, cfg.Conduit.Selector)
+}
+
+// Config returns the current configuration of the node.
+func (n *Node) Config() *Config {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.config
+}
+
+// Start starts the node, opening the listeners and dialing the peers:
+// the configured peers, and up to StoredPeerDials of the others
+// remembered in the peer store, in the order it ranks them.  If a
+// listener cannot be opened, the node is stopped and the error is
+// returned.
+func (n *Node) Start(ctx context.Context) error {
+	n.lock.Lock()
+	if n.ctx != nil {
+		n.lock.Unlock()
+		return nil
+	}
+	cfg := n.config
+	if cfg.Conduit != nil && cfg.Conduit.Resolver != nil {
+		ctx = conduit.WithResolver(ctx, cfg.Conduit.Resolver)
+	}
+	n.ctx, n.stop = context.WithCancel(ctx)
+	n.lock.Unlock()
+
+	// Open the listeners
+	for _, uri := range cfg.Listen {
+		l, err := listen(n.ctx, cfg.Conduit, uri)
+		if err != nil {
+			n.Stop()
+			return fmt.Errorf("listen on %s: %w", uri, err)
+		}
+		n.lock.Lock()
+		n.listeners = append(n.listeners, l)
+		n.lock.Unlock()
+
+		srv := &conduit.Server{Listener: l, Handler: n.Manager}
+		if cfg.Conduit != nil {
+			srv.Usage = cfg.Conduit.Usage
+			srv.Rate = cfg.Conduit.Rate
+			srv.Chaos = cfg.Conduit.Chaos
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			srv.Serve(n.ctx) //nolint:errcheck
+		}()
+	}
+
+	// Start capturing the PDUs exchanged
+	if cfg.Capture != "" {
+		if err := n.StartCapture(cfg.Capture); err != nil {
+			n.Stop()
+			return fmt.Errorf("capture to %s: %w", cfg.Capture, err)
+		}
+	}
+
+	// Open the administrative control socket
+	if cfg.Admin != "" {
+		l, err := listenAdmin(cfg.Admin)
+		if err != nil {
+			n.Stop()
+			return fmt.Errorf("admin on %s: %w", cfg.Admin, err)
+		}
+		n.lock.Lock()
+		n.admin = l
+		n.lock.Unlock()
+
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.serveAdmin(n.ctx, l)
+		}()
+	}
+
+	// Start the manager and dial the peers
+	if err := n.Manager.Start(n.ctx); err != nil {
+		n.Stop()
+		return err
+	}
+	for _, uri := range cfg.Peers {
+		n.Manager.AddPeer(uri) //nolint:errcheck
+	}
+	dials := 0
+	for _, uri := range n.Peers.Rank() {
+		if dials >= StoredPeerDials {
+			break
+		}
+		if !slices.Contains(cfg.Peers, uri) {
+			n.Manager.AddPeer(uri) //nolint:errcheck
+			dials++
+		}
+	}
+
+	// Discover the external URIs of the listeners
+	if len(cfg.STUN) > 0 {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.discover(n.ctx, cfg.STUN)
+		}()
+	}
+
+	// Refresh the link-state record periodically
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.refresh(n.ctx)
+	}()
+
+	return nil
+}
+
+// Stop stops the node, closing the listeners and all the conduits,
+// and waits for the node's goroutines to exit.  The peer store is
+// then saved.  The outcomes of dialing the peers are recorded in the
+// peer store, so that the node may rejoin the mesh through the peers
+// it remembers when it restarts.
+func (n *Node) Stop() {
+	n.lock.Lock()
+	stop := n.stop
+	n.lock.Unlock()
+
+	if stop != nil {
+		stop()
+		n.Manager.Stop()
+		n.wg.Wait()
+		n.savePeers()   //nolint:errcheck
+		n.StopCapture() //nolint:errcheck
+	}
+}
+
+// savePeers saves the peer store to its file, if any.
+func (n *Node) savePeers() error {
+	if n.peerStore == "" {
+		return nil
+	}
+
+	return n.Peers.Save(n.peerStore)
+}
+
+// dialed is called by the manager with the outcome of dialing a peer,
+// which is recorded in the peer store.  URIs which connect to the
+// local node are forgotten.  The store is saved when a peer is
+// reached, so that it is not lost if the node fails.
+func (n *Node) dialed(uri string, id proto.NodeID, err error) {
+	switch {
+	case errors.Is(err, proto.ErrSelfConnection):
+		n.Peers.Forget(uri)
+	case err != nil:
+		n.Peers.Failure(uri)
+	default:
+		n.Peers.Success(uri, id)
+		n.savePeers() //nolint:errcheck
+	}
+}
+
+// Shutdown shuts the node down gracefully.  New conduits are refused
+// and the peers are no longer dialed; the links of the node are
+// withdrawn from its link-state record, which is flooded so that the
+// other nodes route around it; and the traffic through the node is
+// given until the configured drain timeout, or until the context is
+// done, to drain.  The traffic has drained once the send queues of
+// the conduits are empty and no data message has been received for
+// DrainQuietPeriod.  Then a close notice is sent on each open
+// conduit, and once the notices have been written, or
+// conduit.CloseTimeout has passed, the node is stopped as by Stop.
+// If the traffic did not drain in time, the context error is
+// returned, but the node is stopped regardless.
+func (n *Node) Shutdown(ctx context.Context) error {
+	n.lock.Lock()
+	started := n.ctx != nil
+	timeout := n.config.DrainTimeout
+	n.lock.Unlock()
+	if !started {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+
+	// Refuse new conduits and withdraw the links
+	n.Manager.Drain()
+	if n.Routes.Withdraw() {
+		n.floodState()
+	}
+
+	// Wait for the traffic to drain
+	dctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := n.drain(dctx, DrainQuietPeriod)
+
+	// Send close notices and stop the node
+	n.lock.Lock()
+	for _, q := range n.queues {
+		q.Send(proto.CloseFrame(ErrShuttingDown.Error())) //nolint:errcheck
+	}
+	n.lock.Unlock()
+	cctx, cancel := context.WithTimeout(context.Background(), conduit.CloseTimeout)
+	defer cancel()
+	n.drain(cctx, 0) //nolint:errcheck
+	n.Stop()
+
+	return err
+}
+
+// drain waits until the send queues of the conduits are empty and no
+// data message has been received for the specified quiet period, or
+// until the context is done, in which case its error is returned.
+func (n *Node) drain(ctx context.Context, quiet time.Duration) error {
+	ticker := time.NewTicker(DrainQuietPeriod / 10)
+	defer ticker.Stop()
+
+	for !n.drained(quiet) {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// drained tests whether the send queues of the conduits are empty and
+// no data message has been received for the quiet period.
+func (n *Node) drained(quiet time.Duration) bool {
+	if time.Since(time.Unix(0, n.lastData.Load())) < quiet {
+		return false
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, q := range n.queues {
+		if !q.empty() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Addrs returns the URIs of the node's listeners.
+func (n *Node) Addrs() []*conduit.URI {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	result := make([]*conduit.URI, len(n.listeners))
+	for i, l := range n.listeners {
+		result[i] = l.Addr()
+	}
+
+	return result
+}
+
+// AdvertisedURIs returns the URIs at which the node's listeners may
+// be reached by peers: the URIs of the listeners, with those of
+// listeners bound to wildcard addresses replaced by the URIs of the
+// machine's interface addresses, excluding loopback and link-local
+// addresses; see conduit.URI.Advertisable.
+func (n *Node) AdvertisedURIs() []*conduit.URI {
+	n.lock.Lock()
+	listeners := slices.Clone(n.listeners)
+	n.lock.Unlock()
+
+	var result []*conduit.URI
+	for _, l := range listeners {
+		result = append(result, advertisable(l)...)
+	}
+
+	return result
+}
+
+// advertisable returns the URIs at which a listener may be reached by
+// peers.  If the interface addresses cannot be enumerated, the URI of
+// the listener is returned.
+func advertisable(l conduit.Listener) []*conduit.URI {
+	uris, err := advertisableURIs(l, conduit.AdvertisableOptions{})
+	if err != nil {
+		return []*conduit.URI{l.Addr()}
+	}
+
+	return uris
+}
+
+// ExternalURIs returns the external URIs of the node's listeners, as
+// discovered through the configured STUN servers, in the order of the
+// listeners.  These are the URIs at which the node's UDP and QUIC
+// listeners may be reached from outside the network address
+// translators it is behind.
+func (n *Node) ExternalURIs() []*conduit.URI {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	var result []*conduit.URI
+	for _, l := range n.listeners {
+		if u, ok := n.external[l]; ok {
+			result = append(result, u)
+		}
+	}
+
+	return result
+}
+
+// Advert returns an unsigned advertisement record for the node, for
+// gossip and discovery registration.  It lists the external URIs of
+// the node's listeners, followed by the advertised URIs of the
+// listeners themselves, as returned by AdvertisedURIs.
+func (n *Node) Advert() *proto.Advert {
+	a := &proto.Advert{
+		Version: proto.AdvertVersion,
+		NodeID:  n.ID,
+		Issued:  time.Now(),
+	}
+	for _, u := range append(n.ExternalURIs(), n.AdvertisedURIs()...) {
+		if uri := u.String(); !slices.Contains(a.URIs, uri) {
+			a.URIs = append(a.URIs, uri)
+		}
+	}
+
+	return a
+}
+
+// discover discovers the external URIs of the node's listeners
+// through the STUN servers, trying them in turn, and then again every
+// STUNInterval until the context is cancelled.
+func (n *Node) discover(ctx context.Context, servers []string) {
+	ticker := time.NewTicker(STUNInterval)
+	defer ticker.Stop()
+
+	for {
+		n.lock.Lock()
+		listeners := slices.Clone(n.listeners)
+		n.lock.Unlock()
+
+		for _, l := range listeners {
+			for _, server := range servers {
+				u, err := externalURI(ctx, l, server)
+				if errors.Is(err, conduit.ErrSTUNUnsupported) {
+					break
+				} else if err != nil {
+					continue
+				}
+
+				n.lock.Lock()
+				if n.external == nil {
+					n.external = map[conduit.Listener]*conduit.URI{}
+				}
+				n.external[l] = u
+				n.lock.Unlock()
+				break
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// AdminAddr returns the address of the node's administrative control
+// socket, or nil if it is not open.  Through the socket, operators may
+// inspect and manage the running node; see AdminService.
+func (n *Node) AdminAddr() net.Addr {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.admin == nil {
+		return nil
+	}
+
+	return n.admin.Addr()
+}
+
+// Reload applies a new configuration to the running node.  Peers no
+// longer configured are removed, and newly configured peers are
+// added.  The other settings take effect for conduits opened after
+// the reload, except for the node ID file, the peer store file, the
+// listen URIs, and the administrative control socket address, which
+// take effect only when the node is restarted.  A new link cost
+// function takes effect when the costs of the links are next
+// refreshed.  A change of the capture file starts or stops the
+// capture of all the conduits.
+func (n *Node) Reload(cfg *Config) {
+	n.lock.Lock()
+	old := n.config
+	n.config = cfg
+	n.lock.Unlock()
+	n.reconfigure(cfg)
+	if cost, err := cfg.costFunc(); err == nil {
+		n.Routes.SetCostFunc(cost)
+	}
+
+	for _, uri := range old.Peers {
+		if !slices.Contains(cfg.Peers, uri) {
+			n.Manager.RemovePeer(uri) //nolint:errcheck
+		}
+	}
+	for _, uri := range cfg.Peers {
+		if !slices.Contains(old.Peers, uri) {
+			n.Manager.AddPeer(uri) //nolint:errcheck
+		}
+	}
+	for _, service := range old.Anycast {
+		if !slices.Contains(cfg.Anycast, service) {
+			n.Unclaim(service)
+		}
+	}
+	for _, service := range cfg.Anycast {
+		if !slices.Contains(old.Anycast, service) {
+			n.Claim(service)
+		}
+	}
+	switch {
+	case cfg.Capture == old.Capture:
+	case cfg.Capture == "":
+		n.StopCapture() //nolint:errcheck
+	default:
+		n.StartCapture(cfg.Capture) //nolint:errcheck
+	}
+}
+
+// Claim claims the anycast destination of a named service for the
+// node, so that data messages addressed to it are delivered to the
+// node's clients when the node is the nearest claiming it.  The claim
+// is advertised in the node's link-state record.
+func (n *Node) Claim(service string) {
+	if n.Routes.Claim(proto.AnycastID(service), 0) {
+		n.floodState()
+	}
+}
+
+// Unclaim withdraws the node's claim of the anycast destination of a
+// named service.
+func (n *Node) Unclaim(service string) {
+	if n.Routes.Unclaim(proto.AnycastID(service)) {
+		n.floodState()
+	}
+}
+
+// floodState floods the local link-state record.
+func (n *Node) floodState() {
+	f, err := n.Routes.LocalState().Frame()
+	if err != nil {
+		return
+	}
+	n.Flooder.Flood(f) //nolint:errcheck
+}
+
+// refresh updates the costs of the links from the round-trip time,
+// bandwidth, and loss estimates of the conduits, and from the
+// congestion of their send queues, and floods the local link-state
+// record at the configured interval.  Since records are only flooded
+// when they change or are refreshed, refreshing is what brings newly
+// joined nodes up to date.
+func (n *Node) refresh(ctx context.Context) {
+	interval := n.Config().LinkStateInterval
+	if interval <= 0 {
+		interval = DefaultLinkStateInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, p := range n.Manager.Peers() {
+			if p.Conduit == nil {
+				continue
+			}
+			if rtt, _ := p.Conduit.RTTEstimate(); rtt > 0 {
+				bandwidth, loss := p.Conduit.LinkEstimate()
+				n.Routes.SetLinkMetrics(p.NodeID, routing.LinkMetrics{
+					RTT:       rtt,
+					Bandwidth: bandwidth,
+					Loss:      loss,
+					Congested: p.Conduit.Congested(),
+				})
+			}
+		}
+		n.floodState()
+		n.refreshTopics()
+		n.expireCalls(time.Now())
+	}
+}
+
+// addQueue constructs the send queue of a conduit.
+func (n *Node) addQueue(c *conduit.Conduit) *queue {
+	q := newQueue(c)
+	n.lock.Lock()
+	n.queues[c] = q
+	n.lock.Unlock()
+
+	return q
+}
+
+// removeQueue removes the send queue of a conduit, returning it.  The
+// frames still queued are discarded when the conduit closes.
+func (n *Node) removeQueue(c *conduit.Conduit) *queue {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	q := n.queues[c]
+	delete(n.queues, c)
+
+	return q
+}
+
+// queue returns the send queue of a conduit, or nil.
+func (n *Node) queue(c *conduit.Conduit) *queue {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.queues[c]
+}
+
+// open is called by the manager when a conduit to a peer node is
+// established.  It starts reading from the conduit and adds the link
+// to the routing table.
+func (n *Node) open(c *conduit.Conduit, inbound bool) {
+	c.SetCapture(n.capturing())
+	q := n.addQueue(c)
+	cfg := n.Config()
+	pinger := c.Pinger(cfg.PingInterval, cfg.PingMaxMissed, func() {
+		// Closing the conduit causes the reader, and then the
+		// manager, to notice the failure
+		c.Close() //nolint:errcheck
+	})
+	pinger.Send = q.Send
+	pinger.ProbeEvery = cfg.probeEvery()
+	pinger.Start()
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer pinger.Stop()
+		n.read(c, pinger)
+	}()
+
+	id, _ := c.Peer.(proto.NodeID)
+	n.Flooder.Add(q)
+	n.Routes.SetLinkMetrics(id, routing.LinkMetrics{})
+	n.floodState()
+}
+
+// close is called by the manager when a conduit to a peer node
+// closes.  The link is removed from the routing table, unless another
+// conduit to the peer has replaced it.
+func (n *Node) close(c *conduit.Conduit) {
+	if q := n.removeQueue(c); q != nil {
+		n.Flooder.Remove(q)
+	}
+	n.closeTunnels(c)
+
+	id, _ := c.Peer.(proto.NodeID)
+	if n.Manager.Conduit(id) == nil && n.Routes.RemoveLink(id) {
+		n.floodState()
+	}
+}
+
+// read reads and dispatches the frames received over a conduit to a
+// peer node until the conduit fails.
+func (n *Node) read(c *conduit.Conduit, pinger *proto.Pinger) {
+	for {
+		f, err := c.Recv()
+		if err != nil {
+			return
+		}
+
+		switch f.Protocol() {
+		case proto.ProtoControl:
+			msg := &proto.ControlMessage{}
+			if _, err := msg.FromBytes(f.Payload); err != nil {
+				continue
+			}
+			if c.HandleClose(msg) {
+				return
+			}
+			pinger.Handle(msg)
+
+		case proto.ProtoLinkState:
+			if q := n.queue(c); q != nil {
+				n.Flooder.Handle(q, f) //nolint:errcheck
+			}
+
+		case proto.ProtoRendezvous:
+			if r, err := proto.DecodeRendezvous(f.Payload); err == nil {
+				n.rendezvous(c, r)
+			}
+
+		case proto.ProtoRelay:
+			if r, err := proto.DecodeRelay(f.Payload); err == nil {
+				n.relay(c, r)
+			}
+
+		case proto.ProtoPubSub:
+			n.lastData.Store(time.Now().UnixNano())
+			n.readPubSub(c, f)
+
+		case proto.ProtoData:
+			n.lastData.Store(time.Now().UnixNano())
+			d, exts, err := n.receiveData(c, f)
+			if errors.Is(err, proto.ErrCloseConduit) {
+				c.Close() //nolint:errcheck
+				return
+			} else if err == nil {
+				if err := n.forward(d, exts); err != nil {
+					n.refuse(d, exts, err)
+				}
+			}
+		}
+	}
+}
+
+// receiveData decodes a data message received over a conduit, and
+// processes its hop-by-hop extensions, returning the extensions to
+// pass on.  If the pipeline rejects the frame because of an unknown
+// extension, an error reply is sent over the conduit unless the
+// conduit must be closed.
+func (n *Node) receiveData(c *conduit.Conduit, f *proto.Frame) (*proto.Data, proto.Extensions, error) {
+	d, err := proto.DecodeDataFrame(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	exts, err := n.Hops.Process(f)
+	if err != nil {
+		if errors.Is(err, proto.ErrUnknownExtension) && !errors.Is(err, proto.ErrCloseConduit) {
+			if reply := proto.ErrorReply(f); reply != nil {
+				if q := n.queue(c); q != nil {
+					q.Send(reply) //nolint:errcheck
+				}
+			}
+		}
+		return nil, nil, err
+	}
+
+	return d, exts, nil
+}
+
+// dataFrame constructs a frame carrying a data message and the
+// specified extensions.
+func dataFrame(d *proto.Data, exts proto.Extensions) (*proto.Frame, error) {
+	f, err := d.Frame()
+	if err != nil {
+		return nil, err
+	}
+	if len(exts) > 0 {
+		f.Extensions = exts
+		f.Header.Protocol = exts.Link(f.Header.Protocol)
+	}
+
+	return f, nil
+}
+
+// deliverFlood is called by the flooder with each new flooded PDU.
+// Link-state records are applied to the routing table, and topics
+// records are recorded.
+func (n *Node) deliverFlood(from flood.Sender, f *proto.Frame) {
+	switch f.Protocol() {
+	case proto.ProtoLinkState:
+		if ls, err := proto.DecodeLinkState(f.Payload); err == nil {
+			n.Routes.Update(ls)
+		}
+
+	case proto.ProtoPubSub:
+		if t, err := proto.DecodeTopics(f.Payload); err == nil {
+			n.updateTopics(t)
+		}
+	}
+}
+
+// Forward forwards a data message toward its destination.  Messages
+// addressed to the node, or to an anycast destination it claims, are
+// delivered to the clients subscribed to their application protocols;
+// other messages are sent to the next hop on the route to the
+// destination, with the hop limit decremented.  Messages addressed to
+// an anycast destination follow the route to the nearest node
+// claiming it.  If there is no route to the destination, an error
+// wrapping ErrNoRoute is returned.
+//
+// Replies to the requests clients send are delivered to the sending
+// client alone, and requests which cannot be forwarded are answered
+// with error replies.  The hop-by-hop extensions of data messages are
+// processed by the Hops pipeline at each node they transit;
+// end-to-end extensions are carried to the destination untouched.
+func (n *Node) Forward(d *proto.Data) error {
+	return n.forward(d, nil)
+}
+
+// forward forwards a data message toward its destination, carrying
+// the specified extensions.
+func (n *Node) forward(d *proto.Data, exts proto.Extensions) error {
+	if d.Dest == n.ID || n.Routes.Claimed(d.Dest) {
+		return n.deliver(d, exts)
+	}
+
+	if d.HopLimit <= 1 {
+		return fmt.Errorf("node %s: %w", d.Dest, ErrHopLimit)
+	}
+	hop, ok := n.Routes.NextHop(d.Dest)
+	if !ok {
+		return fmt.Errorf("node %s: %w", d.Dest, ErrNoRoute)
+	}
+	q := n.queue(n.Manager.Conduit(hop))
+	if q == nil {
+		return fmt.Errorf("node %s: %w", d.Dest, ErrNoRoute)
+	}
+	fwd := *d
+	fwd.HopLimit--
+	f, err := dataFrame(&fwd, exts)
+	if err != nil {
+		return err
+	}
+
+	return q.Send(f)
+}
+
+// deliver delivers a data message addressed to the node to the
+// clients subscribed to its application protocol, together with
+// the specified extensions.  Replies to requests are delivered to
+// the client which sent the request instead.
+func (n *Node) deliver(d *proto.Data, exts proto.Extensions) error {
+	if req := proto.FindRequest(exts); d.Reply && req != nil {
+		return n.deliverReply(d, exts, req)
+	}
+
+	f, err := dataFrame(d, exts)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	var targets []*queue
+	for c, subs := range n.clients {
+		if subs[d.Protocol] {
+			targets = append(targets, n.queues[c])
+		}
+	}
+	n.lock.Unlock()
+
+	var errs []error
+	for _, q := range targets {
+		if err := q.Send(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// subscribe updates the subscriptions of a client.
+func (n *Node) subscribe(c *conduit.Conduit, msg *proto.ControlMessage) {
+	if len(msg.Body) < 1 {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if msg.Type == proto.ControlSub {
+		n.clients[c][msg.Body[0]] = true
+	} else {
+		delete(n.clients[c], msg.Body[0])
+	}
+}
+
+// serveClient serves a conduit from a client until it closes or the
+// context is cancelled.  Data messages sent by the client are given
+// the node's ID as their source and forwarded, and messages the
+// client publishes are routed toward the topic's subscribers.
+func (n *Node) serveClient(ctx context.Context, c *conduit.Conduit) {
+	c.SetCapture(n.capturing())
+	n.addQueue(c)
+	n.lock.Lock()
+	n.clients[c] = map[uint8]bool{}
+	n.topics[c] = map[string]bool{}
+	n.replies[c] = &proto.ReplyCache{}
+	n.lock.Unlock()
+	defer func() {
+		n.removeQueue(c)
+		n.lock.Lock()
+		delete(n.clients, c)
+		delete(n.replies, c)
+		n.lock.Unlock()
+		n.dropTopics(c)
+	}()
+
+	stop := context.AfterFunc(ctx, func() {
+		c.Close() //nolint:errcheck
+	})
+	defer stop()
+
+	for {
+		f, err := c.Recv()
+		if err != nil {
+			return
+		}
+
+		switch f.Protocol() {
+		case proto.ProtoControl:
+			msg := &proto.ControlMessage{}
+			if _, err := msg.FromBytes(f.Payload); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case proto.ControlSub, proto.ControlUnsub:
+				n.subscribe(c, msg)
+			case proto.ControlTopicSub, proto.ControlTopicUnsub:
+				n.subscribeTopic(c, msg)
+			case proto.ControlClose:
+				return
+			}
+
+		case proto.ProtoData:
+			n.lastData.Store(time.Now().UnixNano())
+			d, exts, err := n.receiveData(c, f)
+			if errors.Is(err, proto.ErrCloseConduit) {
+				return
+			} else if err == nil && !n.retransmitted(c, f) {
+				d.Source = n.ID
+				if d.HopLimit == 0 {
+					d.HopLimit = proto.DefaultHopLimit
+				}
+				exts = n.trackCall(c, d, exts)
+				if err := n.forward(d, exts); err != nil {
+					n.refuse(d, exts, err)
+				}
+			}
+
+		case proto.ProtoPubSub:
+			n.lastData.Store(time.Now().UnixNano())
+			if p, err := proto.DecodePublish(f.Payload); err == nil {
+				n.Publish(p) //nolint:errcheck
+			}
+		}
+	}
+}
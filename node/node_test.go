@@ -0,0 +1,690 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt"
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// startNode starts a node with the specified ID, listening on a named
+// in-memory endpoint and dialing the specified peers.
+func startNode(t *testing.T, id byte, name string, peers ...string) *Node {
+	t.Helper()
+
+	defer patcher.SetVar(&generateNodeID, func() (proto.NodeID, error) {
+		return proto.NodeID{id}, nil
+	}).Install().Restore()
+	n, err := New(&Config{
+		Listen:            []string{"mem:" + name},
+		Peers:             peers,
+		PingInterval:      10 * time.Millisecond,
+		LinkStateInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, n.Start(context.Background()))
+	t.Cleanup(n.Stop)
+
+	return n
+}
+
+func TestNewNodeIDFile(t *testing.T) {
+	defer patcher.SetVar(&loadOrGenerateNodeID, func(path string) (proto.NodeID, error) {
+		assert.Equal(t, "/var/lib/humboldt/node-id", path)
+		return proto.NodeID{1}, nil
+	}).Install().Restore()
+
+	result, err := New(&Config{NodeIDFile: "/var/lib/humboldt/node-id"})
+
+	require.NoError(t, err)
+	assert.Equal(t, proto.NodeID{1}, result.ID)
+	assert.Equal(t, proto.NodeID{1}, result.Routes.Self())
+	assert.Equal(t, proto.NodeID{1}, result.Flooder.Self)
+	assert.Equal(t, proto.NodeID{1}, result.Manager.Negotiator.NodeID)
+}
+
+func TestNewError(t *testing.T) {
+	defer patcher.SetVar(&generateNodeID, func() (proto.NodeID, error) {
+		return proto.NodeID{}, assert.AnError
+	}).Install().Restore()
+
+	result, err := New(&Config{})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestNewLinkCostError(t *testing.T) {
+	result, err := New(&Config{LinkCost: "bogus"})
+
+	assert.ErrorIs(t, err, ErrBadConfig)
+	assert.Nil(t, result)
+}
+
+func TestNewPeerStore(t *testing.T) {
+	peers := &peer.Store{}
+	defer patcher.SetVar(&loadPeerStore, func(path string) (*peer.Store, error) {
+		assert.Equal(t, "/var/lib/humboldt/peers.json", path)
+		return peers, nil
+	}).Install().Restore()
+
+	result, err := New(&Config{PeerStore: "/var/lib/humboldt/peers.json"})
+
+	require.NoError(t, err)
+	assert.Same(t, peers, result.Peers)
+}
+
+func TestNewPeerStoreError(t *testing.T) {
+	defer patcher.SetVar(&loadPeerStore, func(path string) (*peer.Store, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := New(&Config{PeerStore: "/var/lib/humboldt/peers.json"})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestNodeStartListenError(t *testing.T) {
+	defer patcher.SetVar(&listen, func(ctx context.Context, config conduit.Config, uri string, opts ...conduit.ListenerOption) (conduit.Listener, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+	obj, err := New(&Config{Listen: []string{"mem:node"}})
+	require.NoError(t, err)
+
+	err = obj.Start(context.Background())
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.ErrorContains(t, err, "mem:node")
+}
+
+func TestNodeAddrs(t *testing.T) {
+	obj := startNode(t, 1, "node-addrs")
+
+	result := obj.Addrs()
+
+	require.Len(t, result, 1)
+	assert.Equal(t, "mem:node-addrs", result[0].String())
+}
+
+func TestNodeAdvertisedURIs(t *testing.T) {
+	obj := startNode(t, 1, "node-advertised-uris")
+
+	result := obj.AdvertisedURIs()
+
+	require.Len(t, result, 1)
+	assert.Equal(t, "mem:node-advertised-uris", result[0].String())
+}
+
+func TestNodeAdvertisedURIsWildcard(t *testing.T) {
+	obj := startNode(t, 1, "node-advertised-uris-wildcard")
+	defer patcher.SetVar(&advertisableURIs, func(l conduit.Listener, opts conduit.AdvertisableOptions) ([]*conduit.URI, error) {
+		assert.Equal(t, conduit.AdvertisableOptions{}, opts)
+		u, err := conduit.Parse("tcp://192.0.2.1:1234")
+		require.NoError(t, err)
+		return []*conduit.URI{u}, nil
+	}).Install().Restore()
+
+	result := obj.Advert()
+
+	assert.Equal(t, []string{"tcp://192.0.2.1:1234"}, result.URIs)
+}
+
+func TestNodeAdvertisedURIsError(t *testing.T) {
+	obj := startNode(t, 1, "node-advertised-uris-error")
+	defer patcher.SetVar(&advertisableURIs, func(l conduit.Listener, opts conduit.AdvertisableOptions) ([]*conduit.URI, error) {
+		return nil, conduit.ErrNoAddresses
+	}).Install().Restore()
+
+	result := obj.AdvertisedURIs()
+
+	require.Len(t, result, 1)
+	assert.Equal(t, "mem:node-advertised-uris-error", result[0].String())
+}
+
+func TestNodeRoute(t *testing.T) {
+	a := startNode(t, 1, "node-route-a")
+	b := startNode(t, 2, "node-route-b", "mem:node-route-a")
+	c := startNode(t, 3, "node-route-c", "mem:node-route-b")
+	sender, err := humboldt.Dial(context.Background(), nil, "mem:node-route-a")
+	require.NoError(t, err)
+	defer sender.Close()
+	receiver, err := humboldt.Dial(context.Background(), nil, "mem:node-route-c")
+	require.NoError(t, err)
+	defer receiver.Close()
+	sub, err := receiver.Subscribe(42, 10)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		route, ok := a.Routes.Route(c.ID)
+		return ok && route.NextHop == b.ID
+	}, 5*time.Second, time.Millisecond)
+	var msg *humboldt.Message
+	require.Eventually(t, func() bool {
+		require.NoError(t, sender.Send(c.ID, 42, []byte("hello")))
+		select {
+		case msg = <-sub.C:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+
+	assert.Equal(t, &humboldt.Message{Source: a.ID, Protocol: 42, Payload: []byte("hello")}, msg)
+}
+
+// startAnycastNode starts a node with the specified ID, listening on a
+// named in-memory endpoint, dialing the specified peer, and claiming
+// the anycast destination of the specified service.  Links cost one,
+// so that the nearest node is the one the fewest hops away.
+func startAnycastNode(t *testing.T, id byte, name, peer, service string) *Node {
+	t.Helper()
+
+	defer patcher.SetVar(&generateNodeID, func() (proto.NodeID, error) {
+		return proto.NodeID{id}, nil
+	}).Install().Restore()
+	cfg := &Config{
+		Listen:            []string{"mem:" + name},
+		PingInterval:      10 * time.Millisecond,
+		LinkStateInterval: 10 * time.Millisecond,
+		LinkCost:          "hops",
+	}
+	if peer != "" {
+		cfg.Peers = []string{peer}
+	}
+	if service != "" {
+		cfg.Anycast = []string{service}
+	}
+	n, err := New(cfg)
+	require.NoError(t, err)
+	require.NoError(t, n.Start(context.Background()))
+	t.Cleanup(n.Stop)
+
+	return n
+}
+
+// subscribe connects a client to a node and subscribes it to a
+// protocol.
+func subscribe(t *testing.T, name string, protocol uint8) *humboldt.Subscription {
+	t.Helper()
+
+	cl, err := humboldt.Dial(context.Background(), nil, "mem:"+name)
+	require.NoError(t, err)
+	t.Cleanup(func() { cl.Close() })
+	sub, err := cl.Subscribe(protocol, 10)
+	require.NoError(t, err)
+
+	return sub
+}
+
+func TestNodeAnycast(t *testing.T) {
+	// 1 -- 2 -- 3 -- 4, with 1 and 4 claiming the service
+	near := startAnycastNode(t, 1, "node-anycast-1", "", "log-collector")
+	mid := startAnycastNode(t, 2, "node-anycast-2", "mem:node-anycast-1", "")
+	startAnycastNode(t, 3, "node-anycast-3", "mem:node-anycast-2", "")
+	startAnycastNode(t, 4, "node-anycast-4", "mem:node-anycast-3", "log-collector")
+	nearSub := subscribe(t, "node-anycast-1", 42)
+	farSub := subscribe(t, "node-anycast-4", 42)
+	sender, err := humboldt.Dial(context.Background(), nil, "mem:node-anycast-2")
+	require.NoError(t, err)
+	defer sender.Close()
+	dest := proto.AnycastID("log-collector")
+
+	// Wait for both claims to be known, so that the route is the
+	// nearest rather than the first learned
+	require.Eventually(t, func() bool {
+		ls := mid.Routes.State(proto.NodeID{4})
+		route, ok := mid.Routes.Route(dest)
+		return ok && route.Claimant == near.ID && ls != nil && slices.ContainsFunc(ls.Links, func(l proto.Link) bool { return l.Anycast })
+	}, 5*time.Second, time.Millisecond)
+	var msg *humboldt.Message
+	require.Eventually(t, func() bool {
+		require.NoError(t, sender.Send(dest, 42, []byte("near")))
+		select {
+		case msg = <-nearSub.C:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+
+	assert.Equal(t, []byte("near"), msg.Payload)
+	assert.Empty(t, farSub.C)
+
+	// Once the near claimant leaves, messages reach the far one
+	near.Stop()
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, sender.Send(dest, 42, []byte("far")))
+		select {
+		case msg = <-farSub.C:
+			return string(msg.Payload) == "far"
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodeClaim(t *testing.T) {
+	obj := startNode(t, 1, "node-claim")
+	dest := proto.AnycastID("log-collector")
+
+	obj.Claim("log-collector")
+
+	assert.True(t, obj.Routes.Claimed(dest))
+	assert.Contains(t, obj.Routes.LocalState().Links, proto.Link{Neighbor: dest, Anycast: true})
+
+	obj.Unclaim("log-collector")
+
+	assert.False(t, obj.Routes.Claimed(dest))
+}
+
+func TestNodeReloadAnycast(t *testing.T) {
+	obj := startAnycastNode(t, 1, "node-reload-anycast", "", "old")
+	cfg := *obj.Config()
+	cfg.Anycast = []string{"new"}
+
+	obj.Reload(&cfg)
+
+	assert.False(t, obj.Routes.Claimed(proto.AnycastID("old")))
+	assert.True(t, obj.Routes.Claimed(proto.AnycastID("new")))
+}
+
+func TestNodeForwardNoRoute(t *testing.T) {
+	obj := startNode(t, 1, "node-forward-no-route")
+
+	err := obj.Forward(&proto.Data{Dest: proto.NodeID{2}, HopLimit: 2})
+
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
+
+func TestNodeForwardHopLimit(t *testing.T) {
+	obj := startNode(t, 1, "node-forward-hop-limit")
+
+	err := obj.Forward(&proto.Data{Dest: proto.NodeID{2}, HopLimit: 1})
+
+	assert.ErrorIs(t, err, ErrHopLimit)
+}
+
+func TestDataFrameBase(t *testing.T) {
+	d := &proto.Data{Dest: proto.NodeID{2}, HopLimit: 3, Protocol: 42}
+
+	result, err := dataFrame(d, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, proto.ProtoData, result.Header.Protocol)
+	assert.Empty(t, result.Extensions)
+}
+
+func TestDataFrameExtensions(t *testing.T) {
+	d := &proto.Data{Dest: proto.NodeID{2}, HopLimit: 3, Protocol: 42}
+	exts := proto.Extensions{{Number: 0x81}, {Number: 0x82}}
+
+	result, err := dataFrame(d, exts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0x81), result.Header.Protocol)
+	assert.Equal(t, exts, result.Extensions)
+	assert.Equal(t, uint8(0x82), exts[0].Header.Protocol)
+	assert.Equal(t, proto.ProtoData, result.Protocol())
+}
+
+func TestNodeReceiveDataBase(t *testing.T) {
+	obj := &Node{Hops: &proto.Pipeline{}}
+	obj.Hops.Handle(0x81, proto.HopHandlerFunc(func(f *proto.Frame, ext *proto.Extension) (*proto.Extension, error) {
+		return nil, nil
+	}))
+	d := &proto.Data{Dest: proto.NodeID{2}, HopLimit: 3, Protocol: 42, Payload: []byte("hello")}
+	e2e := &proto.Extension{Number: 0x82}
+	f, err := dataFrame(d, proto.Extensions{{Number: 0x81, Header: proto.ExtHeader{HopByHop: true}}, e2e})
+	require.NoError(t, err)
+
+	result, exts, err := obj.receiveData(&conduit.Conduit{}, f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, d, result)
+	assert.Equal(t, proto.Extensions{e2e}, exts)
+}
+
+func TestNodeReceiveDataUnknown(t *testing.T) {
+	q, r := pipeQueue(t)
+	obj := &Node{Hops: &proto.Pipeline{}, queues: map[*conduit.Conduit]*queue{q.c: q}}
+	d := &proto.Data{Dest: proto.NodeID{2}, HopLimit: 3, Protocol: 42}
+	f, err := dataFrame(d, proto.Extensions{{Number: 0x81, Header: proto.ExtHeader{HopByHop: true}}})
+	require.NoError(t, err)
+
+	result, exts, err := obj.receiveData(q.c, f)
+
+	assert.ErrorIs(t, err, proto.ErrUnknownExtension)
+	assert.Nil(t, result)
+	assert.Nil(t, exts)
+	reply, err := r.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, proto.ErrorReply(f).Header, reply.Header)
+}
+
+func TestNodeReceiveDataUnknownClose(t *testing.T) {
+	q, _ := pipeQueue(t)
+	obj := &Node{Hops: &proto.Pipeline{}, queues: map[*conduit.Conduit]*queue{q.c: q}}
+	d := &proto.Data{Dest: proto.NodeID{2}, HopLimit: 3, Protocol: 42}
+	f, err := dataFrame(d, proto.Extensions{{Number: 0x81, Header: proto.ExtHeader{HopByHop: true, Close: true}}})
+	require.NoError(t, err)
+
+	result, _, err := obj.receiveData(q.c, f)
+
+	assert.ErrorIs(t, err, proto.ErrCloseConduit)
+	assert.Nil(t, result)
+	assert.True(t, q.empty())
+}
+
+func TestNodeReadPeerClose(t *testing.T) {
+	l, r := net.Pipe()
+	defer r.Close()
+	c := &conduit.Conduit{State: conduit.Open, Link: l}
+	peer := &conduit.Conduit{Link: r, Integrity: true}
+	go peer.Send(proto.CloseFrame("going away")) //nolint:errcheck
+	obj := &Node{}
+
+	obj.read(c, nil)
+
+	assert.ErrorIs(t, context.Cause(c.Context()), conduit.ErrPeerClosed)
+	assert.ErrorContains(t, context.Cause(c.Context()), "going away")
+	assert.Equal(t, conduit.Closed, c.State)
+}
+
+func TestNodeReceiveDataBadPayload(t *testing.T) {
+	obj := &Node{Hops: &proto.Pipeline{}}
+
+	result, _, err := obj.receiveData(&conduit.Conduit{}, &proto.Frame{Header: proto.Header{Protocol: proto.ProtoData}})
+
+	assert.ErrorIs(t, err, proto.ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestNodeReload(t *testing.T) {
+	startNode(t, 2, "node-reload-b")
+	startNode(t, 3, "node-reload-c")
+	obj := startNode(t, 1, "node-reload-a", "mem:node-reload-b")
+	peerOpen(t, obj.Manager, 2)
+	cfg := *obj.Config()
+	cfg.Peers = []string{"mem:node-reload-c"}
+
+	obj.Reload(&cfg)
+
+	peerOpen(t, obj.Manager, 3)
+	assert.Same(t, &cfg, obj.Config())
+	for _, p := range obj.Manager.Peers() {
+		assert.NotEqual(t, "mem:node-reload-b", p.URI)
+	}
+}
+
+func TestNodeReloadConduit(t *testing.T) {
+	obj := startNode(t, 1, "node-reload-conduit-a")
+	cache := obj.Manager.Cache
+	cfg := *obj.Config()
+	cfg.Conduit = &conduit.ConfigMap{
+		Chaos:    &conduit.Chaos{},
+		Cache:    &conduit.Cache{},
+		Selector: &conduit.RTTSelector{},
+	}
+
+	obj.Reload(&cfg)
+
+	assert.NotSame(t, cache, obj.Manager.Cache)
+	assert.Same(t, cfg.Conduit.Chaos, obj.Manager.Chaos)
+	assert.Same(t, cfg.Conduit.Cache, obj.Manager.Cache)
+	assert.Same(t, cfg.Conduit.Selector, obj.Manager.Selector)
+	config, ok := obj.Manager.Config.(*relayConfig)
+	require.True(t, ok)
+	assert.Same(t, cfg.Conduit, config.conduit)
+}
+
+func TestNodeReloadLinkCost(t *testing.T) {
+	startNode(t, 2, "node-reload-cost-b")
+	obj := startNode(t, 1, "node-reload-cost-a", "mem:node-reload-cost-b")
+	peerOpen(t, obj.Manager, 2)
+	cfg := *obj.Config()
+	cfg.LinkCost = "hops"
+
+	obj.Reload(&cfg)
+
+	require.Eventually(t, func() bool {
+		route, ok := obj.Routes.Route(proto.NodeID{2})
+		return ok && route.Cost == 1
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodeLinkMetrics(t *testing.T) {
+	a := startNode(t, 1, "node-link-metrics-a")
+	startNode(t, 2, "node-link-metrics-b", "mem:node-link-metrics-a")
+	peerOpen(t, a.Manager, 2)
+
+	require.Eventually(t, func() bool {
+		bandwidth, _ := a.Manager.Conduit(proto.NodeID{2}).LinkEstimate()
+		return bandwidth > 0
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodeStop(t *testing.T) {
+	b := startNode(t, 2, "node-stop-b")
+	obj := startNode(t, 1, "node-stop-a", "mem:node-stop-b")
+	peerOpen(t, obj.Manager, 2)
+
+	obj.Stop()
+
+	assert.Empty(t, obj.Manager.Peers()[0].Conduit)
+	_, err := conduit.Dial(context.Background(), nil, "mem:node-stop-a")
+	assert.Error(t, err)
+	require.Eventually(t, func() bool {
+		_, ok := b.Routes.NextHop(obj.ID)
+		return !ok
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodeShutdownBase(t *testing.T) {
+	b := startNode(t, 2, "node-shutdown-b")
+	obj := startNode(t, 1, "node-shutdown-a", "mem:node-shutdown-b")
+	peerOpen(t, obj.Manager, 2)
+	peerOpen(t, b.Manager, 1)
+
+	err := obj.Shutdown(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, obj.Manager.Peers()[0].Conduit)
+	_, err = conduit.Dial(context.Background(), nil, "mem:node-shutdown-a")
+	assert.Error(t, err)
+	require.Eventually(t, func() bool {
+		ls := b.Routes.State(obj.ID)
+		return ls != nil && len(ls.Links) == 0
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, ok := b.Routes.NextHop(obj.ID)
+		return !ok
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodeShutdownRefuses(t *testing.T) {
+	obj := startNode(t, 1, "node-shutdown-refuses")
+	obj.lastData.Store(time.Now().Add(time.Hour).UnixNano())
+	done := make(chan error, 1)
+	go func() {
+		done <- obj.Shutdown(context.Background())
+	}()
+	require.Eventually(t, obj.Manager.isDraining, 5*time.Second, time.Millisecond)
+	c, err := conduit.Dial(context.Background(), nil, "mem:node-shutdown-refuses")
+	require.NoError(t, err)
+	defer c.Close()
+	require.NoError(t, c.Negotiate(&proto.Negotiator{MaxProto: proto.Version}))
+
+	f, err := c.Recv()
+
+	require.NoError(t, err)
+	msg := &proto.ControlMessage{}
+	_, err = msg.FromBytes(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, proto.ControlClose, msg.Type)
+	assert.Contains(t, string(msg.Body), ErrShuttingDown.Error())
+	obj.lastData.Store(0)
+	assert.NoError(t, <-done)
+}
+
+func TestNodeShutdownTimeout(t *testing.T) {
+	obj := startNode(t, 1, "node-shutdown-timeout")
+	obj.lastData.Store(time.Now().UnixNano())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := obj.Shutdown(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = conduit.Dial(context.Background(), nil, "mem:node-shutdown-timeout")
+	assert.Error(t, err)
+}
+
+func TestNodeShutdownDrainTimeout(t *testing.T) {
+	obj := startNode(t, 1, "node-shutdown-drain-timeout")
+	obj.config.DrainTimeout = 10 * time.Millisecond
+	obj.lastData.Store(time.Now().UnixNano())
+
+	err := obj.Shutdown(context.Background())
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNodeShutdownNotStarted(t *testing.T) {
+	obj, err := New(&Config{})
+	require.NoError(t, err)
+
+	err = obj.Shutdown(context.Background())
+
+	assert.NoError(t, err)
+}
+
+func TestNodePeerStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	startNode(t, 2, "node-peer-store-b")
+	obj, err := New(&Config{
+		NodeIDFile: filepath.Join(t.TempDir(), "node-id"),
+		Peers:      []string{"mem:node-peer-store-b"},
+		PeerStore:  path,
+	})
+	require.NoError(t, err)
+	require.NoError(t, obj.Start(context.Background()))
+	peerOpen(t, obj.Manager, 2)
+	obj.Stop()
+	restarted, err := New(&Config{PeerStore: path})
+	require.NoError(t, err)
+
+	err = restarted.Start(context.Background())
+	defer restarted.Stop()
+
+	require.NoError(t, err)
+	h := restarted.Peers.History("mem:node-peer-store-b")
+	require.NotNil(t, h)
+	assert.Equal(t, proto.NodeID{2}, h.NodeID)
+	peerOpen(t, restarted.Manager, 2)
+}
+
+func TestNodeStoredPeerDials(t *testing.T) {
+	obj, err := New(&Config{Peers: []string{"mem:node-stored-dials-0"}})
+	require.NoError(t, err)
+	for i := range StoredPeerDials + 2 {
+		obj.Peers.Failure(fmt.Sprintf("mem:node-stored-dials-%d", i))
+	}
+	obj.Manager.Backoff = conduit.Backoff{Initial: time.Hour}
+
+	err = obj.Start(context.Background())
+	defer obj.Stop()
+
+	require.NoError(t, err)
+	var uris []string
+	for _, p := range obj.Manager.Peers() {
+		uris = append(uris, p.URI)
+	}
+	assert.Len(t, uris, StoredPeerDials+1)
+	assert.Contains(t, uris, "mem:node-stored-dials-0")
+}
+
+func TestNodeDialed(t *testing.T) {
+	obj, err := New(&Config{})
+	require.NoError(t, err)
+
+	obj.dialed("mem:one", proto.NodeID{2}, nil)
+	obj.dialed("mem:two", proto.NodeID{}, assert.AnError)
+	obj.dialed("mem:self", proto.NodeID{2}, nil)
+	obj.dialed("mem:self", proto.NodeID{}, proto.ErrSelfConnection)
+
+	assert.Equal(t, 1, obj.Peers.History("mem:one").Successes)
+	assert.Equal(t, 1, obj.Peers.History("mem:two").Failures)
+	assert.Nil(t, obj.Peers.History("mem:self"))
+}
+
+func TestNodeExternalURIs(t *testing.T) {
+	external, err := conduit.Parse("udp://203.0.113.7:4242")
+	require.NoError(t, err)
+	defer patcher.SetVar(&externalURI, func(ctx context.Context, l conduit.Listener, server string) (*conduit.URI, error) {
+		switch {
+		case l.Addr().Transport != "udp":
+			return nil, conduit.ErrSTUNUnsupported
+		case server == "stun1.example.com":
+			return nil, conduit.ErrNoSTUNResponse
+		}
+		return external, nil
+	}).Install().Restore()
+	obj, err := New(&Config{
+		Listen: []string{"mem:node-external-uris", "udp://127.0.0.1:0"},
+		STUN:   []string{"stun1.example.com", "stun2.example.com"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, obj.Start(context.Background()))
+	defer obj.Stop()
+
+	require.Eventually(t, func() bool {
+		return len(obj.ExternalURIs()) > 0
+	}, 5*time.Second, time.Millisecond)
+	result := obj.Advert()
+
+	assert.Equal(t, []*conduit.URI{external}, obj.ExternalURIs())
+	assert.Equal(t, obj.ID, result.NodeID)
+	assert.Equal(t, proto.AdvertVersion, result.Version)
+	assert.Equal(t, []string{
+		"udp://203.0.113.7:4242",
+		"mem:node-external-uris",
+		obj.Addrs()[1].String(),
+	}, result.URIs)
+}
+
+func TestNodeAdvertNoSTUN(t *testing.T) {
+	obj := startNode(t, 1, "node-advert-no-stun")
+
+	result := obj.Advert()
+
+	assert.Empty(t, obj.ExternalURIs())
+	assert.Equal(t, []string{"mem:node-advert-no-stun"}, result.URIs)
+}
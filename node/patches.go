@@ -0,0 +1,37 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Patch points for isolating functions during testing.
+var (
+	dialAll              = conduit.DialAll
+	listen               = conduit.Listen
+	loadConfigTree       = conduit.LoadConfigTree
+	loadOrGenerateNodeID = proto.LoadOrGenerateNodeID
+	generateNodeID       = proto.GenerateNodeID
+	listenAdmin          = ListenAdmin
+	loadPeerStore        = peer.Load
+	externalURI          = conduit.ExternalURI
+	punch                = conduit.Punch
+	probe                = conduit.Probe
+	createCapture        = conduit.CreateCapture
+	advertisableURIs     = conduit.AdvertisableURIs
+)
@@ -0,0 +1,256 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// localTopics returns the sorted topics the node's clients subscribe
+// to.  Must be called with the lock held.
+func (n *Node) localTopics() []string {
+	var topics []string
+	for _, subs := range n.topics {
+		for topic := range subs {
+			if !slices.Contains(topics, topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	slices.Sort(topics)
+
+	return topics
+}
+
+// subscribeTopic updates the topic subscriptions of a client.  If the
+// topics the node's clients subscribe to change as a result, the
+// node's topics record is flooded.
+func (n *Node) subscribeTopic(c *conduit.Conduit, msg *proto.ControlMessage) {
+	if len(msg.Body) < 1 || len(msg.Body) > proto.MaxTopicSize {
+		return
+	}
+	topic := string(msg.Body)
+
+	n.lock.Lock()
+	before := n.localTopics()
+	if msg.Type == proto.ControlTopicSub {
+		n.topics[c][topic] = true
+	} else {
+		delete(n.topics[c], topic)
+	}
+	changed := !slices.Equal(before, n.localTopics())
+	n.lock.Unlock()
+
+	if changed {
+		n.floodTopics()
+	}
+}
+
+// dropTopics removes the topic subscriptions of a client whose
+// conduit has closed, flooding the node's topics record if the
+// topics its clients subscribe to change as a result.
+func (n *Node) dropTopics(c *conduit.Conduit) {
+	n.lock.Lock()
+	before := n.localTopics()
+	delete(n.topics, c)
+	changed := !slices.Equal(before, n.localTopics())
+	n.lock.Unlock()
+
+	if changed {
+		n.floodTopics()
+	}
+}
+
+// floodTopics floods the node's topics record, with a new sequence
+// number.
+func (n *Node) floodTopics() {
+	n.lock.Lock()
+	n.topicSeq++
+	t := &proto.Topics{Origin: n.ID, Sequence: n.topicSeq, Topics: n.localTopics()}
+	n.lock.Unlock()
+
+	f, err := t.Frame()
+	if err != nil {
+		return
+	}
+	n.Flooder.Flood(f) //nolint:errcheck
+}
+
+// refreshTopics floods the node's topics record if its clients
+// subscribe to any topics, so that newly joined nodes learn of them,
+// and forgets the records of nodes which are no longer reachable.
+func (n *Node) refreshTopics() {
+	n.lock.Lock()
+	for id := range n.nodeTopics {
+		if _, ok := n.Routes.NextHop(id); !ok {
+			delete(n.nodeTopics, id)
+		}
+	}
+	subscribed := len(n.localTopics()) > 0
+	n.lock.Unlock()
+
+	if subscribed {
+		n.floodTopics()
+	}
+}
+
+// updateTopics records a topics record flooded by another node,
+// unless a record with a later sequence number is already known.
+func (n *Node) updateTopics(t *proto.Topics) {
+	if t.Origin == n.ID {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if old, ok := n.nodeTopics[t.Origin]; ok && old.Sequence >= t.Sequence {
+		return
+	}
+	n.nodeTopics[t.Origin] = t
+}
+
+// subscribers returns the nodes whose clients subscribe to a topic,
+// including the node itself, omitting those the node has no route
+// to.
+func (n *Node) subscribers(topic string) []proto.NodeID {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	var targets []proto.NodeID
+	for _, subs := range n.topics {
+		if subs[topic] {
+			targets = append(targets, n.ID)
+			break
+		}
+	}
+	for id, t := range n.nodeTopics {
+		if !slices.Contains(t.Topics, topic) {
+			continue
+		}
+		if _, ok := n.Routes.NextHop(id); ok {
+			targets = append(targets, id)
+		}
+	}
+
+	return targets
+}
+
+// Publish publishes a message to a topic, delivering it to the
+// clients subscribing to the topic at the node and at the other
+// nodes whose topics records name it; each node floods a record of
+// the topics its clients subscribe to.  The message is given the
+// node's ID as its source, and is routed toward the subscribing
+// nodes along the routes to them, crossing each link at most once.
+// If no node subscribes to the topic, the message is discarded.
+func (n *Node) Publish(p *proto.Publish) error {
+	pub := *p
+	pub.Source = n.ID
+	if pub.HopLimit == 0 {
+		pub.HopLimit = proto.DefaultHopLimit
+	}
+	pub.Targets = n.subscribers(p.Topic)
+
+	return n.routePublish(&pub)
+}
+
+// routePublish routes a published message toward its targets.  If
+// the node is a target, the message is delivered to its subscribing
+// clients; the remaining targets are grouped by the next hop on the
+// routes to them, and a copy of the message, carrying the targets of
+// the group and with the hop limit decremented, is sent to each next
+// hop.  Targets the node has no route to are dropped.
+func (n *Node) routePublish(p *proto.Publish) error {
+	var errs []error
+	hops := map[proto.NodeID][]proto.NodeID{}
+	for _, id := range p.Targets {
+		if id == n.ID {
+			errs = append(errs, n.deliverPublish(p))
+			continue
+		}
+		if p.HopLimit <= 1 {
+			continue
+		}
+		if hop, ok := n.Routes.NextHop(id); ok && !slices.Contains(hops[hop], id) {
+			hops[hop] = append(hops[hop], id)
+		}
+	}
+
+	for hop, targets := range hops {
+		q := n.queue(n.Manager.Conduit(hop))
+		if q == nil {
+			continue
+		}
+		fwd := *p
+		fwd.HopLimit--
+		fwd.Targets = targets
+		f, err := fwd.Frame()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, q.Send(f))
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliverPublish delivers a published message to the clients
+// subscribing to its topic.
+func (n *Node) deliverPublish(p *proto.Publish) error {
+	local := *p
+	local.Targets = nil
+	f, err := local.Frame()
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	var targets []*queue
+	for c, subs := range n.topics {
+		if subs[p.Topic] {
+			targets = append(targets, n.queues[c])
+		}
+	}
+	n.lock.Unlock()
+
+	var errs []error
+	for _, q := range targets {
+		errs = append(errs, q.Send(f))
+	}
+
+	return errors.Join(errs...)
+}
+
+// readPubSub dispatches a publish/subscribe message received over a
+// conduit to a peer node.  Topics records are flooded; published
+// messages are routed toward their targets.
+func (n *Node) readPubSub(c *conduit.Conduit, f *proto.Frame) {
+	switch proto.PubSubTypeOf(f.Payload) {
+	case proto.PubSubTopics:
+		if q := n.queue(c); q != nil {
+			n.Flooder.Handle(q, f) //nolint:errcheck
+		}
+
+	case proto.PubSubPublish:
+		if p, err := proto.DecodePublish(f.Payload); err == nil {
+			n.routePublish(p) //nolint:errcheck
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// subscribeTopic dials a node and subscribes to a topic.
+func subscribeTopic(t *testing.T, name, topic string) *humboldt.Subscription {
+	t.Helper()
+
+	cl, err := humboldt.Dial(context.Background(), nil, "mem:"+name)
+	require.NoError(t, err)
+	t.Cleanup(func() { cl.Close() })
+	sub, err := cl.SubscribeTopic(topic, 10)
+	require.NoError(t, err)
+
+	return sub
+}
+
+// subscribers waits for a node to know the subscribers to a topic.
+func subscribers(t *testing.T, n *Node, topic string, ids ...proto.NodeID) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		result := n.subscribers(topic)
+		slices.SortFunc(result, func(a, b proto.NodeID) int {
+			return slices.Compare(a[:], b[:])
+		})
+		return slices.Equal(ids, result)
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodePublish(t *testing.T) {
+	// 1 -- 2 -- 3, and 2 -- 4, with 1 and 3 subscribing to the topic
+	startNode(t, 1, "node-publish-1")
+	mid := startNode(t, 2, "node-publish-2", "mem:node-publish-1")
+	startNode(t, 3, "node-publish-3", "mem:node-publish-2")
+	startNode(t, 4, "node-publish-4", "mem:node-publish-2")
+	sub1 := subscribeTopic(t, "node-publish-1", "news")
+	sub3 := subscribeTopic(t, "node-publish-3", "news")
+	other := subscribeTopic(t, "node-publish-4", "weather")
+	publisher, err := humboldt.Dial(context.Background(), nil, "mem:node-publish-2")
+	require.NoError(t, err)
+	defer publisher.Close()
+	subscribers(t, mid, "news", proto.NodeID{1}, proto.NodeID{3})
+
+	require.NoError(t, publisher.Publish("news", []byte("hello")))
+
+	for _, sub := range []*humboldt.Subscription{sub1, sub3} {
+		select {
+		case msg := <-sub.C:
+			assert.Equal(t, &humboldt.Message{Source: mid.ID, Topic: "news", Payload: []byte("hello")}, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("published message not delivered")
+		}
+	}
+	assert.Empty(t, other.C)
+}
+
+func TestNodePublishLocal(t *testing.T) {
+	obj := startNode(t, 1, "node-publish-local")
+	sub := subscribeTopic(t, "node-publish-local", "news")
+	publisher, err := humboldt.Dial(context.Background(), nil, "mem:node-publish-local")
+	require.NoError(t, err)
+	defer publisher.Close()
+	subscribers(t, obj, "news", obj.ID)
+
+	require.NoError(t, publisher.Publish("news", []byte("hello")))
+
+	select {
+	case msg := <-sub.C:
+		assert.Equal(t, []byte("hello"), msg.Payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("published message not delivered")
+	}
+}
+
+func TestNodeTopicsUnsubscribe(t *testing.T) {
+	startNode(t, 1, "node-topics-unsub-1")
+	obj := startNode(t, 2, "node-topics-unsub-2", "mem:node-topics-unsub-1")
+	sub := subscribeTopic(t, "node-topics-unsub-1", "news")
+	subscribers(t, obj, "news", proto.NodeID{1})
+
+	require.NoError(t, sub.Cancel())
+
+	subscribers(t, obj, "news")
+}
+
+func TestNodeUpdateTopicsStale(t *testing.T) {
+	obj := startNode(t, 1, "node-update-topics-stale")
+	obj.updateTopics(&proto.Topics{Origin: proto.NodeID{2}, Sequence: 2, Topics: []string{"new"}})
+
+	obj.updateTopics(&proto.Topics{Origin: proto.NodeID{2}, Sequence: 1, Topics: []string{"old"}})
+
+	assert.Equal(t, []string{"new"}, obj.nodeTopics[proto.NodeID{2}].Topics)
+}
+
+func TestNodeRoutePublishNoRoute(t *testing.T) {
+	obj := startNode(t, 1, "node-route-publish-no-route")
+
+	err := obj.routePublish(&proto.Publish{HopLimit: 2, Topic: "news", Targets: []proto.NodeID{{2}}})
+
+	assert.NoError(t, err)
+}
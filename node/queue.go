@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// queue sends frames through the send queue of a conduit.  Goroutines
+// reading from conduits send through queues, so that they never block
+// on a peer which is itself blocked sending to the node.  The depth
+// of the queue feeds the cost of the link; see Node.refresh.
+type queue struct {
+	c *conduit.Conduit // The conduit
+}
+
+// newQueue constructs a queue for a conduit.
+func newQueue(c *conduit.Conduit) *queue {
+	return &queue{c: c}
+}
+
+// Send queues a frame for sending without waiting.  If the queue is
+// full, the frame is discarded, and conduit.ErrQueueFull is returned.
+func (q *queue) Send(f *proto.Frame) error {
+	return q.c.TryEnqueue(f)
+}
+
+// SendWait queues a frame for sending, waiting while the queue is
+// full.  It returns once the frame is queued, the context is done,
+// or the conduit closes.
+func (q *queue) SendWait(ctx context.Context, f *proto.Frame) error {
+	return q.c.Enqueue(ctx, f)
+}
+
+// empty tests whether the queue is empty and no frame is being
+// written.
+func (q *queue) empty() bool {
+	return q.c.QueueIdle()
+}
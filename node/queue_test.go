@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// pipeQueue returns a queue for a conduit over a pipe, and a reader
+// for the frames written to the other end.
+func pipeQueue(t *testing.T) (*queue, *proto.Reader) {
+	c1, c2 := net.Pipe()
+	c := &conduit.Conduit{State: conduit.Open, Integrity: true, Link: c1}
+	t.Cleanup(func() {
+		c.Close() //nolint:errcheck
+		c2.Close()
+	})
+
+	return newQueue(c), proto.NewReader(c2)
+}
+
+// fillQueue queues frames until the queue is full.
+func fillQueue(t *testing.T, q *queue) {
+	for range conduit.DefaultQueueDepth + 1 {
+		if err := q.Send(&proto.Frame{}); err != nil {
+			require.ErrorIs(t, err, conduit.ErrQueueFull)
+			return
+		}
+	}
+	require.Fail(t, "queue did not fill")
+}
+
+func TestQueueSend(t *testing.T) {
+	obj, r := pipeQueue(t)
+	msg := &proto.ControlMessage{Type: proto.ControlPing, Body: []byte("ping")}
+
+	require.NoError(t, obj.Send(msg.Frame()))
+
+	f, err := r.ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x04ping"), f.Payload)
+}
+
+func TestQueueSendFull(t *testing.T) {
+	obj, _ := pipeQueue(t)
+	fillQueue(t, obj)
+
+	err := obj.Send(&proto.Frame{})
+
+	assert.ErrorIs(t, err, conduit.ErrQueueFull)
+	assert.Equal(t, conduit.DefaultQueueDepth, obj.c.QueueDepth())
+}
+
+func TestQueueSendClosed(t *testing.T) {
+	c := &conduit.Conduit{State: conduit.Open}
+	c.Close() //nolint:errcheck
+	obj := newQueue(c)
+
+	err := obj.Send(&proto.Frame{})
+
+	assert.ErrorIs(t, err, conduit.ErrConduitClosed)
+	assert.True(t, obj.empty())
+}
+
+func TestQueueSendWaitFull(t *testing.T) {
+	obj, r := pipeQueue(t)
+	fillQueue(t, obj)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.ReadFrame() //nolint:errcheck
+	}()
+
+	err := obj.SendWait(context.Background(), &proto.Frame{})
+
+	assert.NoError(t, err)
+}
+
+func TestQueueSendWaitCancelled(t *testing.T) {
+	obj, _ := pipeQueue(t)
+	fillQueue(t, obj)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := obj.SendWait(ctx, &proto.Frame{})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, conduit.DefaultQueueDepth, obj.c.QueueDepth())
+}
+
+func TestQueueSendWaitClosed(t *testing.T) {
+	c := &conduit.Conduit{State: conduit.Open}
+	c.Close() //nolint:errcheck
+	obj := newQueue(c)
+
+	err := obj.SendWait(context.Background(), &proto.Frame{})
+
+	assert.ErrorIs(t, err, conduit.ErrConduitClosed)
+	assert.True(t, obj.empty())
+}
+
+func TestQueueEmpty(t *testing.T) {
+	obj, r := pipeQueue(t)
+	assert.True(t, obj.empty())
+
+	require.NoError(t, obj.Send((&proto.ControlMessage{Type: proto.ControlPing}).Frame()))
+
+	assert.False(t, obj.empty())
+	_, err := r.ReadFrame()
+	require.NoError(t, err)
+	assert.Eventually(t, obj.empty, time.Second, time.Millisecond)
+}
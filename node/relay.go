@@ -0,0 +1,518 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// relayConfig is the configuration the node's manager dials with.  It
+// passes the configurations of the mechanisms through from the
+// node's configuration, but configures the relay transport mechanism
+// to dial through the node itself.
+type relayConfig struct {
+	conduit *conduit.ConfigMap   // Configurations of the mechanisms; may be nil
+	relay   *conduit.RelayConfig // Configuration of the relay transport
+}
+
+// ForTransport retrieves the configuration for a specified transport
+// mechanism.
+func (c *relayConfig) ForTransport(name string) interface{} {
+	if name == "relay" {
+		return c.relay
+	}
+	if c.conduit == nil {
+		return nil
+	}
+
+	return c.conduit.ForTransport(name)
+}
+
+// ForSecurity retrieves the configuration for a specified security
+// layer mechanism.
+func (c *relayConfig) ForSecurity(name string) interface{} {
+	if c.conduit == nil {
+		return nil
+	}
+
+	return c.conduit.ForSecurity(name)
+}
+
+// tunnelKey identifies a relay tunnel by the conduit carrying it and
+// its identifier on that conduit.
+type tunnelKey struct {
+	c  *conduit.Conduit // The conduit carrying the tunnel
+	id uint32           // Identifier of the tunnel on the conduit
+}
+
+// tunnel is the local end of a relay tunnel, used as the link of a
+// conduit.  Data written to it is sent over the conduit carrying the
+// tunnel as relay data messages, and the data received in them is
+// read from it.  Message boundaries are not preserved.
+type tunnel struct {
+	n      *Node              // The node
+	key    tunnelKey          // Identifies the tunnel
+	q      *queue             // Send queue of the conduit carrying the tunnel
+	local  *conduit.RelayAddr // Address of the local end
+	remote *conduit.RelayAddr // Address of the far end
+	opened chan error         // Outcome of opening a dialed tunnel
+	in     chan []byte        // Data received
+	ctx    context.Context    // Cancelled when the tunnel closes
+	cancel context.CancelFunc // Closes the tunnel
+	once   sync.Once          // Closes the tunnel once
+
+	rlock    sync.Mutex    // Serializes reads
+	buf      []byte        // Data received but not yet read
+	eof      bool          // The far end closed the tunnel
+	dlock    sync.Mutex    // Protects the read deadline
+	deadline time.Time     // Read deadline
+	dchanged chan struct{} // Closed when the read deadline changes
+}
+
+// newTunnel constructs and registers a tunnel.
+func (n *Node) newTunnel(key tunnelKey, q *queue, local, remote *conduit.RelayAddr) *tunnel {
+	t := &tunnel{
+		n:        n,
+		key:      key,
+		q:        q,
+		local:    local,
+		remote:   remote,
+		opened:   make(chan error, 1),
+		in:       make(chan []byte, conduit.DefaultQueueDepth),
+		dchanged: make(chan struct{}),
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+
+	n.lock.Lock()
+	n.tunnels[key] = t
+	n.lock.Unlock()
+
+	return t
+}
+
+// Read reads data received through the tunnel.  Once the far end
+// closes the tunnel and the data received has been read, io.EOF is
+// returned.
+func (t *tunnel) Read(b []byte) (int, error) {
+	t.rlock.Lock()
+	defer t.rlock.Unlock()
+
+	for len(t.buf) == 0 {
+		if err := t.wait(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, t.buf)
+	t.buf = t.buf[n:]
+
+	return n, nil
+}
+
+// wait waits for data to be received through the tunnel, the tunnel
+// to close, or the read deadline to pass.  A change of the deadline
+// while waiting returns no error and no data, so that the caller
+// waits again with the new deadline.
+func (t *tunnel) wait() error {
+	t.dlock.Lock()
+	deadline, changed := t.deadline, t.dchanged
+	t.dlock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case t.buf = <-t.in:
+	case <-t.ctx.Done():
+		// Data received before the tunnel closed is still read
+		select {
+		case t.buf = <-t.in:
+		default:
+			if t.eof {
+				return io.EOF
+			}
+			return net.ErrClosed
+		}
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-changed:
+	}
+
+	return nil
+}
+
+// Write sends data through the tunnel, split into relay data messages
+// as needed.  Write waits while the send queue of the conduit carrying
+// the tunnel is full.
+func (t *tunnel) Write(b []byte) (int, error) {
+	sent := 0
+	for sent < len(b) {
+		chunk := b[sent:min(len(b), sent+proto.MaxRelayData)]
+		f, err := (&proto.Relay{
+			Type:    proto.RelayData,
+			Tunnel:  t.key.id,
+			Payload: bytes.Clone(chunk),
+		}).Frame()
+		if err != nil {
+			return sent, err
+		}
+		if err := t.q.SendWait(t.ctx, f); err != nil {
+			if t.ctx.Err() != nil {
+				err = net.ErrClosed
+			}
+			return sent, err
+		}
+		sent += len(chunk)
+	}
+
+	return sent, nil
+}
+
+// Close closes the tunnel, notifying the far end.
+func (t *tunnel) Close() error {
+	t.n.closeTunnel(t, "tunnel closed", true)
+	return nil
+}
+
+// LocalAddr returns the address of the local end of the tunnel.
+func (t *tunnel) LocalAddr() net.Addr {
+	return t.local
+}
+
+// RemoteAddr returns the address of the far end of the tunnel.
+func (t *tunnel) RemoteAddr() net.Addr {
+	return t.remote
+}
+
+// SetDeadline sets the read deadline of the tunnel; writes have no
+// deadline.
+func (t *tunnel) SetDeadline(deadline time.Time) error {
+	return t.SetReadDeadline(deadline)
+}
+
+// SetReadDeadline sets the read deadline of the tunnel, including for
+// a read already waiting.
+func (t *tunnel) SetReadDeadline(deadline time.Time) error {
+	t.dlock.Lock()
+	defer t.dlock.Unlock()
+
+	t.deadline = deadline
+	close(t.dchanged)
+	t.dchanged = make(chan struct{})
+
+	return nil
+}
+
+// SetWriteDeadline does nothing; writes to a tunnel have no deadline.
+func (t *tunnel) SetWriteDeadline(deadline time.Time) error {
+	return nil
+}
+
+// closeTunnel closes a tunnel for the specified reason and
+// unregisters it.  If notify is set, the far end is sent a close
+// message giving the reason; otherwise, the far end closed the
+// tunnel, or the conduit carrying it closed.
+func (n *Node) closeTunnel(t *tunnel, reason string, notify bool) {
+	t.once.Do(func() {
+		n.lock.Lock()
+		if n.tunnels[t.key] == t {
+			delete(n.tunnels, t.key)
+		}
+		n.lock.Unlock()
+
+		if notify {
+			sendRelay(t.q, proto.RelayClose, t.key.id, []byte(reason)) //nolint:errcheck
+		} else {
+			t.eof = true
+		}
+		t.cancel()
+		select {
+		case t.opened <- fmt.Errorf("%w: %s", ErrRelayRefused, reason):
+		default:
+		}
+	})
+}
+
+// DialRelay opens a tunnel to a target node through a relay node, over
+// the conduit to the relay node.  It implements conduit.RelayDialer,
+// so that the relay transport mechanism dials through the node; the
+// tunnel is returned once the target accepts it.  If there is no
+// conduit to the relay node, an error wrapping ErrNoConduit is
+// returned; if the relay node or the target refuses the tunnel, an
+// error wrapping ErrRelayRefused is returned.
+//
+// Relay URIs take the form "relay://<relay node>/<target node>".  The
+// node also splices the tunnels its peers open through it, and serves
+// those opened to it as inbound conduits.
+func (n *Node) DialRelay(ctx context.Context, relay, target proto.NodeID) (net.Conn, error) {
+	c := n.Manager.Conduit(relay)
+	q := n.queue(c)
+	if q == nil {
+		return nil, fmt.Errorf("node %s: %w", relay, ErrNoConduit)
+	}
+
+	key := tunnelKey{c: c, id: n.tunnelID(c)}
+	t := n.newTunnel(key, q, &conduit.RelayAddr{Relay: relay, Node: n.ID}, &conduit.RelayAddr{Relay: relay, Node: target})
+	if err := sendRelay(q, proto.RelayOpen, key.id, target[:]); err != nil {
+		n.closeTunnel(t, err.Error(), false)
+		return nil, err
+	}
+
+	select {
+	case err := <-t.opened:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		n.closeTunnel(t, ctx.Err().Error(), true)
+		return nil, ctx.Err()
+	}
+
+	return t, nil
+}
+
+// tunnelID allocates an identifier for a tunnel opened over a conduit.
+// The node with the lower ID allocates even identifiers, and its peer
+// odd ones, so that the identifiers allocated at either end of the
+// conduit never collide.
+func (n *Node) tunnelID(c *conduit.Conduit) uint32 {
+	id, _ := c.Peer.(proto.NodeID)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.nextTunnel++
+	if id.Less(n.ID) {
+		return n.nextTunnel<<1 | 1
+	}
+	return n.nextTunnel << 1
+}
+
+// sendRelay sends a relay message over a conduit's send queue.
+func sendRelay(q *queue, typ proto.RelayType, id uint32, payload []byte) error {
+	f, err := (&proto.Relay{Type: typ, Tunnel: id, Payload: payload}).Frame()
+	if err != nil {
+		return err
+	}
+
+	return q.Send(f)
+}
+
+// relay handles a relay message received over a conduit to a peer
+// node.  Messages for tunnels ending at the node are handled by the
+// tunnels; messages for tunnels the node splices are passed on to the
+// far side of the splice.  An open message asks the node to relay to
+// a target node: a tunnel to the target is opened over the conduit to
+// it with a connect message, and spliced to the tunnel from the
+// dialer.  A connect message opens a tunnel to the node, which is
+// accepted and handled by the manager as an inbound conduit.
+func (n *Node) relay(c *conduit.Conduit, r *proto.Relay) {
+	q := n.queue(c)
+	if q == nil {
+		return
+	}
+	key := tunnelKey{c: c, id: r.Tunnel}
+
+	switch r.Type {
+	case proto.RelayOpen:
+		n.relayOpen(key, q, r)
+
+	case proto.RelayConnect:
+		n.relayConnect(key, q, r)
+
+	default:
+		n.lock.Lock()
+		t := n.tunnels[key]
+		far, spliced := n.splices[key]
+		if spliced && r.Type == proto.RelayClose {
+			delete(n.splices, key)
+			delete(n.splices, far)
+		}
+		n.lock.Unlock()
+
+		if t != nil {
+			t.handle(r)
+		} else if spliced {
+			n.forwardRelay(key, far, r)
+		}
+	}
+}
+
+// relayOpen handles a request to relay to a target node.
+func (n *Node) relayOpen(key tunnelKey, q *queue, r *proto.Relay) {
+	var target proto.NodeID
+	if len(r.Payload) != proto.NodeIDSize {
+		return
+	}
+	copy(target[:], r.Payload)
+	source, _ := key.c.Peer.(proto.NodeID)
+
+	fc := n.Manager.Conduit(target)
+	fq := n.queue(fc)
+	switch {
+	case n.Manager.isDraining():
+		sendRelay(q, proto.RelayClose, key.id, []byte(ErrShuttingDown.Error())) //nolint:errcheck
+		return
+	case fq == nil || target == source:
+		sendRelay(q, proto.RelayClose, key.id, []byte(ErrNoConduit.Error())) //nolint:errcheck
+		return
+	}
+
+	far := tunnelKey{c: fc, id: n.tunnelID(fc)}
+	n.lock.Lock()
+	if _, ok := n.splices[key]; ok {
+		n.lock.Unlock()
+		return
+	}
+	n.splices[key] = far
+	n.splices[far] = key
+	n.lock.Unlock()
+
+	if err := sendRelay(fq, proto.RelayConnect, far.id, source[:]); err != nil {
+		n.unsplice(key, far, err.Error())
+	}
+}
+
+// relayConnect handles a tunnel opened to the node by a relay node.
+func (n *Node) relayConnect(key tunnelKey, q *queue, r *proto.Relay) {
+	var source proto.NodeID
+	if len(r.Payload) != proto.NodeIDSize {
+		return
+	}
+	copy(source[:], r.Payload)
+	relay, _ := key.c.Peer.(proto.NodeID)
+
+	n.lock.Lock()
+	ctx := n.ctx
+	_, exists := n.tunnels[key]
+	n.lock.Unlock()
+	if exists {
+		return
+	}
+	if n.Manager.isDraining() {
+		sendRelay(q, proto.RelayClose, key.id, []byte(ErrShuttingDown.Error())) //nolint:errcheck
+		return
+	}
+
+	local := &conduit.RelayAddr{Relay: relay, Node: n.ID}
+	remote := &conduit.RelayAddr{Relay: relay, Node: source}
+	t := n.newTunnel(key, q, local, remote)
+	if err := sendRelay(q, proto.RelayAccept, key.id, nil); err != nil {
+		n.closeTunnel(t, err.Error(), false)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.Manager.Handle(ctx, &conduit.Conduit{
+			State:     conduit.Passive,
+			LocalURI:  conduit.RelayAddr2URI(local),
+			RemoteURI: conduit.RelayAddr2URI(remote),
+			Link:      t,
+		})
+	}()
+}
+
+// forwardRelay passes a relay message on to the far side of a splice.
+// If the message cannot be queued, both sides of the splice are
+// closed.
+func (n *Node) forwardRelay(key, far tunnelKey, r *proto.Relay) {
+	fq := n.queue(far.c)
+	if fq == nil {
+		n.unsplice(key, far, ErrNoConduit.Error())
+		return
+	}
+	if err := sendRelay(fq, r.Type, far.id, r.Payload); err != nil && r.Type != proto.RelayClose {
+		n.unsplice(key, far, err.Error())
+	}
+}
+
+// unsplice removes a splice, sending a close message giving the reason
+// to both sides.
+func (n *Node) unsplice(key, far tunnelKey, reason string) {
+	n.lock.Lock()
+	delete(n.splices, key)
+	delete(n.splices, far)
+	n.lock.Unlock()
+
+	for _, k := range []tunnelKey{key, far} {
+		if q := n.queue(k.c); q != nil {
+			sendRelay(q, proto.RelayClose, k.id, []byte(reason)) //nolint:errcheck
+		}
+	}
+}
+
+// handle handles a relay message for the tunnel.  Data which cannot
+// be buffered because the reader has fallen behind closes the tunnel.
+func (t *tunnel) handle(r *proto.Relay) {
+	switch r.Type {
+	case proto.RelayAccept:
+		select {
+		case t.opened <- nil:
+		default:
+		}
+
+	case proto.RelayData:
+		select {
+		case t.in <- bytes.Clone(r.Payload):
+		default:
+			t.n.closeTunnel(t, conduit.ErrQueueFull.Error(), true)
+		}
+
+	case proto.RelayClose:
+		t.n.closeTunnel(t, string(r.Payload), false)
+	}
+}
+
+// closeTunnels closes the tunnels carried over a conduit which has
+// closed, and the splices using them.
+func (n *Node) closeTunnels(c *conduit.Conduit) {
+	n.lock.Lock()
+	var tunnels []*tunnel
+	for key, t := range n.tunnels {
+		if key.c == c {
+			tunnels = append(tunnels, t)
+		}
+	}
+	var splices [][2]tunnelKey
+	for key, far := range n.splices {
+		if key.c == c {
+			splices = append(splices, [2]tunnelKey{key, far})
+		}
+	}
+	n.lock.Unlock()
+
+	for _, t := range tunnels {
+		n.closeTunnel(t, ErrNoConduit.Error(), false)
+	}
+	for _, s := range splices {
+		n.unsplice(s[0], s[1], ErrNoConduit.Error())
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// connected waits for a node to have a conduit to a peer.
+func connected(t *testing.T, n *Node, id proto.NodeID) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return n.Manager.Conduit(id) != nil
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestRelayConfigForTransport(t *testing.T) {
+	relay := &conduit.RelayConfig{}
+	obj := &relayConfig{
+		conduit: &conduit.ConfigMap{Transports: map[string]interface{}{"tcp": "tcp config"}},
+		relay:   relay,
+	}
+
+	assert.Equal(t, "tcp config", obj.ForTransport("tcp"))
+	assert.Same(t, relay, obj.ForTransport("relay"))
+	assert.Nil(t, obj.ForTransport("udp"))
+}
+
+func TestRelayConfigForSecurity(t *testing.T) {
+	obj := &relayConfig{
+		conduit: &conduit.ConfigMap{Securities: map[string]interface{}{"noise": "noise config"}},
+		relay:   &conduit.RelayConfig{},
+	}
+
+	assert.Equal(t, "noise config", obj.ForSecurity("noise"))
+	assert.Nil(t, obj.ForSecurity("psk"))
+}
+
+func TestRelayConfigNilConfigMap(t *testing.T) {
+	obj := &relayConfig{relay: &conduit.RelayConfig{}}
+
+	assert.Nil(t, obj.ForTransport("tcp"))
+	assert.Nil(t, obj.ForSecurity("noise"))
+}
+
+func TestNodeRelayBase(t *testing.T) {
+	r := startPunchNode(t, 1, "node-relay-r", "")
+	a := startPunchNode(t, 2, "node-relay-a", "mem:node-relay-r")
+	b := startPunchNode(t, 3, "node-relay-b", "mem:node-relay-r")
+	connected(t, a, r.ID)
+	connected(t, b, r.ID)
+
+	err := a.Manager.AddPeer(fmt.Sprintf("relay://%s/%s", r.ID, b.ID))
+
+	require.NoError(t, err)
+	connected(t, a, b.ID)
+	connected(t, b, a.ID)
+	c := a.Manager.Conduit(b.ID)
+	assert.Equal(t, "relay", c.RemoteURI.Transport)
+	assert.Equal(t, fmt.Sprintf("relay://%s/%s", r.ID, b.ID), c.RemoteURI.String())
+	assert.Equal(t, fmt.Sprintf("relay://%s/%s", r.ID, a.ID), b.Manager.Conduit(a.ID).RemoteURI.String())
+	assert.Equal(t, &conduit.RelayAddr{Relay: r.ID, Node: a.ID}, c.Link.LocalAddr())
+	require.Eventually(t, func() bool {
+		route, ok := a.Routes.Route(b.ID)
+		return ok && route.NextHop == b.ID
+	}, 5*time.Second, time.Millisecond)
+	r.lock.Lock()
+	assert.Len(t, r.splices, 2)
+	r.lock.Unlock()
+}
+
+func TestNodeRelayTeardown(t *testing.T) {
+	r := startPunchNode(t, 1, "node-relay-teardown-r", "")
+	a := startPunchNode(t, 2, "node-relay-teardown-a", "mem:node-relay-teardown-r")
+	b := startPunchNode(t, 3, "node-relay-teardown-b", "mem:node-relay-teardown-r")
+	connected(t, a, r.ID)
+	connected(t, b, r.ID)
+	require.NoError(t, a.Manager.AddPeer(fmt.Sprintf("relay://%s/%s", r.ID, b.ID)))
+	connected(t, a, b.ID)
+	c := a.Manager.Conduit(b.ID)
+
+	b.Stop()
+
+	require.Eventually(t, func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return len(r.splices) == 0
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return c.Context().Err() != nil
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodeDialRelayNoConduit(t *testing.T) {
+	a := startPunchNode(t, 2, "node-relay-noconduit-a", "")
+
+	_, err := a.DialRelay(context.Background(), proto.NodeID{1}, proto.NodeID{3})
+
+	assert.ErrorIs(t, err, ErrNoConduit)
+}
+
+func TestNodeDialRelayRefused(t *testing.T) {
+	r := startPunchNode(t, 1, "node-relay-refused-r", "")
+	a := startPunchNode(t, 2, "node-relay-refused-a", "mem:node-relay-refused-r")
+	connected(t, a, r.ID)
+
+	_, err := a.DialRelay(context.Background(), r.ID, proto.NodeID{3})
+
+	assert.ErrorIs(t, err, ErrRelayRefused)
+	assert.ErrorContains(t, err, ErrNoConduit.Error())
+	a.lock.Lock()
+	assert.Empty(t, a.tunnels)
+	a.lock.Unlock()
+}
+
+func TestNodeDialRelayCancelled(t *testing.T) {
+	r := startPunchNode(t, 1, "node-relay-cancelled-r", "")
+	a := startPunchNode(t, 2, "node-relay-cancelled-a", "mem:node-relay-cancelled-r")
+	connected(t, a, r.ID)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := a.DialRelay(ctx, r.ID, proto.NodeID{3})
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTunnelWrite(t *testing.T) {
+	n := &Node{tunnels: map[tunnelKey]*tunnel{}}
+	q, fr := pipeQueue(t)
+	obj := n.newTunnel(tunnelKey{id: 4}, q, nil, nil)
+	data := make([]byte, 2*proto.MaxRelayData+1)
+
+	count, err := obj.Write(data)
+
+	require.NoError(t, err)
+	assert.Equal(t, len(data), count)
+	for _, size := range []int{proto.MaxRelayData, proto.MaxRelayData, 1} {
+		f, err := fr.ReadFrame()
+		require.NoError(t, err)
+		r, err := proto.DecodeRelay(f.Payload)
+		require.NoError(t, err)
+		assert.Equal(t, &proto.Relay{Type: proto.RelayData, Tunnel: 4, Payload: make([]byte, size)}, r)
+	}
+}
+
+func TestTunnelWriteClosed(t *testing.T) {
+	n := &Node{tunnels: map[tunnelKey]*tunnel{}}
+	q, _ := pipeQueue(t)
+	obj := n.newTunnel(tunnelKey{id: 4}, q, nil, nil)
+	require.NoError(t, obj.Close())
+	fillQueue(t, q)
+
+	_, err := obj.Write([]byte("data"))
+
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestTunnelReadDeadline(t *testing.T) {
+	n := &Node{tunnels: map[tunnelKey]*tunnel{}}
+	obj := n.newTunnel(tunnelKey{}, nil, nil, nil)
+	require.NoError(t, obj.SetDeadline(time.Now().Add(time.Millisecond)))
+
+	_, err := obj.Read(make([]byte, 1))
+
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestTunnelReadDeadlineWhileBlocked(t *testing.T) {
+	n := &Node{tunnels: map[tunnelKey]*tunnel{}}
+	obj := n.newTunnel(tunnelKey{}, nil, nil, nil)
+	errs := make(chan error)
+	go func() {
+		_, err := obj.Read(make([]byte, 1))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	require.NoError(t, obj.SetReadDeadline(time.Unix(1, 0)))
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read was not interrupted")
+	}
+	require.NoError(t, obj.SetReadDeadline(time.Time{}))
+	obj.handle(&proto.Relay{Type: proto.RelayData, Payload: []byte("data")})
+	result := make([]byte, 8)
+	count, err := obj.Read(result)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(result[:count]))
+}
+
+func TestTunnelReadAfterFarClose(t *testing.T) {
+	n := &Node{tunnels: map[tunnelKey]*tunnel{}}
+	obj := n.newTunnel(tunnelKey{}, nil, nil, nil)
+	obj.handle(&proto.Relay{Type: proto.RelayData, Payload: []byte("data")})
+	obj.handle(&proto.Relay{Type: proto.RelayClose, Payload: []byte("done")})
+	result := make([]byte, 8)
+
+	n1, err1 := obj.Read(result)
+	_, err2 := obj.Read(result)
+
+	assert.NoError(t, err1)
+	assert.Equal(t, "data", string(result[:n1]))
+	assert.ErrorIs(t, err2, io.EOF)
+	assert.Empty(t, n.tunnels)
+}
+
+// relayNode returns a node with a send queue for a conduit, for
+// handling relay messages received over it, and a reader for the
+// frames sent.
+func relayNode(t *testing.T) (*Node, *queue, *proto.Reader) {
+	t.Helper()
+
+	q, fr := pipeQueue(t)
+	n := &Node{
+		ID:      proto.NodeID{1},
+		Manager: &Manager{},
+		queues:  map[*conduit.Conduit]*queue{q.c: q},
+		tunnels: map[tunnelKey]*tunnel{},
+		splices: map[tunnelKey]tunnelKey{},
+	}
+
+	return n, q, fr
+}
+
+// readRelay reads a relay message from a reader.
+func readRelay(t *testing.T, fr *proto.Reader) *proto.Relay {
+	t.Helper()
+
+	f, err := fr.ReadFrame()
+	require.NoError(t, err)
+	r, err := proto.DecodeRelay(f.Payload)
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestTunnelAddrs(t *testing.T) {
+	local := &conduit.RelayAddr{Relay: proto.NodeID{1}, Node: proto.NodeID{2}}
+	remote := &conduit.RelayAddr{Relay: proto.NodeID{1}, Node: proto.NodeID{3}}
+	obj := &tunnel{local: local, remote: remote}
+
+	assert.Same(t, local, obj.LocalAddr())
+	assert.Same(t, remote, obj.RemoteAddr())
+	assert.NoError(t, obj.SetWriteDeadline(time.Now()))
+}
+
+func TestNodeRelayOpenDraining(t *testing.T) {
+	n, q, fr := relayNode(t)
+	n.Manager.draining = true
+	target := proto.NodeID{3}
+
+	n.relay(q.c, &proto.Relay{Type: proto.RelayOpen, Tunnel: 5, Payload: target[:]})
+
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 5, Payload: []byte(ErrShuttingDown.Error())}, readRelay(t, fr))
+	assert.Empty(t, n.splices)
+}
+
+func TestNodeRelayConnectDraining(t *testing.T) {
+	n, q, fr := relayNode(t)
+	n.Manager.draining = true
+	source := proto.NodeID{2}
+
+	n.relay(q.c, &proto.Relay{Type: proto.RelayConnect, Tunnel: 6, Payload: source[:]})
+
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 6, Payload: []byte(ErrShuttingDown.Error())}, readRelay(t, fr))
+	assert.Empty(t, n.tunnels)
+}
+
+func TestNodeRelayUnknownTunnel(t *testing.T) {
+	n, q, fr := relayNode(t)
+	n.Manager.draining = true
+	target := proto.NodeID{3}
+
+	n.relay(q.c, &proto.Relay{Type: proto.RelayData, Tunnel: 99, Payload: []byte("data")})
+	n.relay(q.c, &proto.Relay{Type: proto.RelayClose, Tunnel: 99})
+	n.relay(q.c, &proto.Relay{Type: proto.RelayOpen, Tunnel: 5, Payload: target[:]})
+
+	// Only the refusal of the open is sent
+	assert.Equal(t, uint32(5), readRelay(t, fr).Tunnel)
+	assert.Empty(t, n.tunnels)
+	assert.Empty(t, n.splices)
+}
+
+func TestNodeRelayForwardNoConduit(t *testing.T) {
+	n, q, fr := relayNode(t)
+	key := tunnelKey{c: q.c, id: 5}
+	far := tunnelKey{c: &conduit.Conduit{}, id: 8}
+	n.splices[key] = far
+	n.splices[far] = key
+
+	n.relay(q.c, &proto.Relay{Type: proto.RelayData, Tunnel: 5, Payload: []byte("data")})
+
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 5, Payload: []byte(ErrNoConduit.Error())}, readRelay(t, fr))
+	assert.Empty(t, n.splices)
+}
+
+func TestNodeCloseTunnels(t *testing.T) {
+	n, q, fr := relayNode(t)
+	q2, fr2 := pipeQueue(t)
+	n.queues[q2.c] = q2
+	tun := n.newTunnel(tunnelKey{c: q.c, id: 2}, q, nil, nil)
+	other := n.newTunnel(tunnelKey{c: q2.c, id: 2}, q2, nil, nil)
+	key := tunnelKey{c: q.c, id: 4}
+	far := tunnelKey{c: q2.c, id: 8}
+	n.splices[key] = far
+	n.splices[far] = key
+
+	n.closeTunnels(q.c)
+
+	_, err := tun.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, other.ctx.Err())
+	assert.Equal(t, map[tunnelKey]*tunnel{other.key: other}, n.tunnels)
+	assert.Empty(t, n.splices)
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 4, Payload: []byte(ErrNoConduit.Error())}, readRelay(t, fr))
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 8, Payload: []byte(ErrNoConduit.Error())}, readRelay(t, fr2))
+}
+
+func TestNodeRelayNoQueue(t *testing.T) {
+	n, _, _ := relayNode(t)
+	target := proto.NodeID{3}
+
+	n.relay(&conduit.Conduit{}, &proto.Relay{Type: proto.RelayOpen, Tunnel: 5, Payload: target[:]})
+
+	assert.Empty(t, n.splices)
+}
+
+func TestNodeRelayBadPayload(t *testing.T) {
+	n, q, _ := relayNode(t)
+
+	n.relay(q.c, &proto.Relay{Type: proto.RelayOpen, Tunnel: 5, Payload: []byte{3}})
+	n.relay(q.c, &proto.Relay{Type: proto.RelayConnect, Tunnel: 6, Payload: []byte{2}})
+
+	assert.Empty(t, n.splices)
+	assert.Empty(t, n.tunnels)
+}
+
+func TestNodeRelaySplicedClose(t *testing.T) {
+	n, q, _ := relayNode(t)
+	q2, fr2 := pipeQueue(t)
+	n.queues[q2.c] = q2
+	key := tunnelKey{c: q.c, id: 5}
+	far := tunnelKey{c: q2.c, id: 8}
+	n.splices[key] = far
+	n.splices[far] = key
+
+	n.relay(q.c, &proto.Relay{Type: proto.RelayClose, Tunnel: 5, Payload: []byte("done")})
+
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 8, Payload: []byte("done")}, readRelay(t, fr2))
+	assert.Empty(t, n.splices)
+}
+
+func TestTunnelHandleOverrun(t *testing.T) {
+	n, q, fr := relayNode(t)
+	obj := n.newTunnel(tunnelKey{c: q.c, id: 2}, q, nil, nil)
+
+	for range conduit.DefaultQueueDepth + 1 {
+		obj.handle(&proto.Relay{Type: proto.RelayData, Tunnel: 2, Payload: []byte("data")})
+	}
+
+	assert.Equal(t, &proto.Relay{Type: proto.RelayClose, Tunnel: 2, Payload: []byte(conduit.ErrQueueFull.Error())}, readRelay(t, fr))
+	assert.Empty(t, n.tunnels)
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Punch establishes a conduit directly to a node which is reachable
+// only through other nodes, such as a node behind a network address
+// translator, by punching holes through the translators between them.
+// The node is introduced by a peer which has a conduit to it, as the
+// routing table shows: the peer is sent a rendezvous request listing
+// the URIs of the local node's UDP and QUIC listeners, external URIs
+// first, which it passes on to the target node.  The target answers
+// through the peer with its own URIs, and sends probes toward the
+// local node's URIs with conduit.Probe, while the local node punches
+// a conduit toward each of the target's URIs for which it has a
+// listener of the same kind, with conduit.Punch, until one is
+// established.  The conduit is then adopted by the Manager.  The
+// whole exchange is given PunchTimeout.
+//
+// If no conduit can be punched, an error wrapping ErrPunchFailed is
+// returned; the traffic to the target keeps being relayed along the
+// route through the mesh, which passes through the introducing peer
+// unless a better route exists, so the target remains reachable.  If
+// the local node has no punchable listeners, an error wrapping
+// ErrNotPunchable is returned; if no peer can introduce the node,
+// ErrNoRendezvous; and if the peer or the target refuses the
+// introduction, ErrPunchRefused.  If a conduit to the node is already
+// open, Punch returns immediately.
+func (n *Node) Punch(ctx context.Context, id proto.NodeID) error {
+	if n.Manager.Conduit(id) != nil {
+		return nil
+	}
+	uris := n.punchURIs()
+	if len(uris) == 0 {
+		return fmt.Errorf("node %s: %w", id, ErrNotPunchable)
+	}
+	q := n.queue(n.Manager.Conduit(n.introducer(id)))
+	if q == nil {
+		return fmt.Errorf("node %s: %w", id, ErrNoRendezvous)
+	}
+
+	// Register for the answer
+	answers := make(chan *proto.Rendezvous, 1)
+	n.lock.Lock()
+	if _, ok := n.punches[id]; ok {
+		n.lock.Unlock()
+		return fmt.Errorf("node %s: %w", id, ErrPunchInProgress)
+	}
+	if n.punches == nil {
+		n.punches = map[proto.NodeID]chan *proto.Rendezvous{}
+	}
+	n.punches[id] = answers
+	cfg := n.config
+	n.lock.Unlock()
+	defer func() {
+		n.lock.Lock()
+		delete(n.punches, id)
+		n.lock.Unlock()
+	}()
+
+	// Ask to be introduced and wait for the answer
+	ctx, cancel := context.WithTimeout(ctx, PunchTimeout)
+	defer cancel()
+	req := &proto.Rendezvous{Type: proto.RendezvousRequest, Initiator: n.ID, Target: id, URIs: uris}
+	if err := n.sendRendezvous(q, req); err != nil {
+		return fmt.Errorf("node %s: %w", id, err)
+	}
+	var answer *proto.Rendezvous
+	select {
+	case answer = <-answers:
+	case <-ctx.Done():
+		return fmt.Errorf("node %s: %w", id, ctx.Err())
+	}
+	if answer.Type == proto.RendezvousRefuse {
+		return fmt.Errorf("node %s: %w", id, ErrPunchRefused)
+	}
+
+	// Punch a conduit toward each of the target's URIs in turn
+	var errs []error
+	for _, uri := range answer.URIs {
+		remote, l := n.punchListener(uri)
+		if l == nil {
+			continue
+		}
+		c, err := punch(ctx, cfg.Conduit, l, remote)
+		if err == nil {
+			err = n.Manager.Adopt(c)
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	return fmt.Errorf("node %s: %w: %w", id, ErrPunchFailed, errors.Join(errs...))
+}
+
+// punchURIs returns the URIs of the node's punchable listeners, for
+// listing in rendezvous messages: the external URIs of the listeners,
+// followed by the advertised URIs of the listeners themselves.
+func (n *Node) punchURIs() []string {
+	n.lock.Lock()
+	var external []*conduit.URI
+	var punchable []conduit.Listener
+	for _, l := range n.listeners {
+		if !conduit.Punchable(l) {
+			continue
+		}
+		if u, ok := n.external[l]; ok {
+			external = append(external, u)
+		}
+		punchable = append(punchable, l)
+	}
+	n.lock.Unlock()
+	var addrs []*conduit.URI
+	for _, l := range punchable {
+		addrs = append(addrs, advertisable(l)...)
+	}
+
+	var result []string
+	for _, u := range append(external, addrs...) {
+		if uri := u.String(); !slices.Contains(result, uri) {
+			result = append(result, uri)
+		}
+	}
+
+	return result
+}
+
+// punchListener parses a URI listed in a rendezvous message and
+// returns it, along with the node's punchable listener of the same
+// transport and security layer, or nil if the node has none.
+func (n *Node) punchListener(uri string) (*conduit.URI, conduit.Listener) {
+	remote, err := conduit.Parse(uri)
+	if err != nil {
+		return nil, nil
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, l := range n.listeners {
+		addr := l.Addr()
+		if addr.Transport == remote.Transport && addr.Security == remote.Security && conduit.Punchable(l) {
+			return remote, l
+		}
+	}
+
+	return remote, nil
+}
+
+// introducer returns a peer with a conduit open which may introduce
+// the node to the target: one whose link-state record lists a link to
+// the target.  The zero node ID is returned if there is none.
+func (n *Node) introducer(target proto.NodeID) proto.NodeID {
+	for _, p := range n.Manager.Peers() {
+		if p.Conduit == nil || p.NodeID == target {
+			continue
+		}
+		ls := n.Routes.State(p.NodeID)
+		if ls == nil {
+			continue
+		}
+		for _, link := range ls.Links {
+			if link.Neighbor == target {
+				return p.NodeID
+			}
+		}
+	}
+
+	return proto.NodeID{}
+}
+
+// sendRendezvous sends a rendezvous message over a conduit's send
+// queue.
+func (n *Node) sendRendezvous(q *queue, r *proto.Rendezvous) error {
+	f, err := r.Frame()
+	if err != nil {
+		return err
+	}
+
+	return q.Send(f)
+}
+
+// rendezvous handles a rendezvous message received over a conduit to
+// a peer node.  Requests from initiators are passed on to the target
+// as offers, and answers and refusals from targets are passed back to
+// the initiator, or, if the node is the initiator, to the Punch call
+// awaiting them.  Offers introducing another node are answered, and
+// the node's probes are sent toward the initiator's URIs.  Messages
+// claiming to come from a node other than the peer are ignored.
+func (n *Node) rendezvous(c *conduit.Conduit, r *proto.Rendezvous) {
+	from, _ := c.Peer.(proto.NodeID)
+	reply := n.queue(c)
+	if reply == nil {
+		return
+	}
+
+	switch r.Type {
+	case proto.RendezvousRequest:
+		if r.Initiator != from {
+			return
+		}
+		q := n.queue(n.Manager.Conduit(r.Target))
+		if q == nil || r.Target == n.ID {
+			n.sendRendezvous(reply, &proto.Rendezvous{ //nolint:errcheck
+				Type:      proto.RendezvousRefuse,
+				Initiator: r.Initiator,
+				Target:    r.Target,
+			})
+			return
+		}
+		offer := *r
+		offer.Type = proto.RendezvousOffer
+		n.sendRendezvous(q, &offer) //nolint:errcheck
+
+	case proto.RendezvousOffer:
+		if r.Target != n.ID {
+			return
+		}
+		answer := &proto.Rendezvous{
+			Type:      proto.RendezvousAnswer,
+			Initiator: r.Initiator,
+			Target:    r.Target,
+			URIs:      n.punchURIs(),
+		}
+		if len(answer.URIs) == 0 || n.Manager.isDraining() {
+			answer.Type = proto.RendezvousRefuse
+			answer.URIs = nil
+		}
+		n.sendRendezvous(reply, answer) //nolint:errcheck
+		if answer.Type == proto.RendezvousAnswer {
+			n.probeAll(r.URIs)
+		}
+
+	case proto.RendezvousAnswer, proto.RendezvousRefuse:
+		if r.Initiator != n.ID {
+			if r.Target == from {
+				if q := n.queue(n.Manager.Conduit(r.Initiator)); q != nil {
+					n.sendRendezvous(q, r) //nolint:errcheck
+				}
+			}
+			return
+		}
+
+		n.lock.Lock()
+		answers := n.punches[r.Target]
+		n.lock.Unlock()
+		if answers != nil {
+			select {
+			case answers <- r:
+			default:
+			}
+		}
+	}
+}
+
+// probeAll sends probes toward each of the URIs listed in a
+// rendezvous message for which the node has a listener of the same
+// kind, in the background.
+func (n *Node) probeAll(uris []string) {
+	n.lock.Lock()
+	ctx := n.ctx
+	n.lock.Unlock()
+	if ctx == nil {
+		return
+	}
+
+	for _, uri := range uris {
+		remote, l := n.punchListener(uri)
+		if l == nil {
+			continue
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			probe(ctx, l, remote) //nolint:errcheck
+		}()
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/conduit"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// startPunchNode starts a node with the specified ID, listening on a
+// named in-memory endpoint and on the specified additional URIs, and
+// dialing the specified peer.
+func startPunchNode(t *testing.T, id byte, name, peer string, listen ...string) *Node {
+	t.Helper()
+
+	defer patcher.SetVar(&generateNodeID, func() (proto.NodeID, error) {
+		return proto.NodeID{id}, nil
+	}).Install().Restore()
+	cfg := &Config{
+		Listen:            append([]string{"mem:" + name}, listen...),
+		PingInterval:      10 * time.Millisecond,
+		LinkStateInterval: 10 * time.Millisecond,
+	}
+	if peer != "" {
+		cfg.Peers = []string{peer}
+	}
+	n, err := New(cfg)
+	require.NoError(t, err)
+	require.NoError(t, n.Start(context.Background()))
+	t.Cleanup(n.Stop)
+
+	return n
+}
+
+// introduced waits for a node to learn that its peer may introduce it
+// to the target.
+func introduced(t *testing.T, n *Node, target proto.NodeID) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return !n.introducer(target).IsZero()
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNodePunchBase(t *testing.T) {
+	r := startPunchNode(t, 1, "node-punch-r", "")
+	a := startPunchNode(t, 2, "node-punch-a", "mem:node-punch-r", "udp://127.0.0.1:0")
+	b := startPunchNode(t, 3, "node-punch-b", "mem:node-punch-r", "udp://127.0.0.1:0")
+	introduced(t, a, b.ID)
+
+	err := a.Punch(context.Background(), b.ID)
+
+	require.NoError(t, err)
+	c := a.Manager.Conduit(b.ID)
+	require.NotNil(t, c)
+	assert.Equal(t, "udp", c.RemoteURI.Transport)
+	assert.Equal(t, b.Addrs()[1].String(), c.RemoteURI.String())
+	require.Eventually(t, func() bool {
+		return b.Manager.Conduit(a.ID) != nil
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		route, ok := a.Routes.Route(b.ID)
+		return ok && route.NextHop == b.ID
+	}, 5*time.Second, time.Millisecond)
+	assert.NotNil(t, r.Manager.Conduit(a.ID))
+}
+
+func TestNodePunchConnected(t *testing.T) {
+	startPunchNode(t, 1, "node-punch-connected-b", "")
+	a := startPunchNode(t, 2, "node-punch-connected-a", "mem:node-punch-connected-b")
+	require.Eventually(t, func() bool {
+		return a.Manager.Conduit(proto.NodeID{1}) != nil
+	}, 5*time.Second, time.Millisecond)
+
+	err := a.Punch(context.Background(), proto.NodeID{1})
+
+	assert.NoError(t, err)
+}
+
+func TestNodePunchNotPunchable(t *testing.T) {
+	obj := startPunchNode(t, 1, "node-punch-not-punchable", "")
+
+	err := obj.Punch(context.Background(), proto.NodeID{2})
+
+	assert.ErrorIs(t, err, ErrNotPunchable)
+}
+
+func TestNodePunchNoRendezvous(t *testing.T) {
+	obj := startPunchNode(t, 1, "node-punch-no-rendezvous", "", "udp://127.0.0.1:0")
+
+	err := obj.Punch(context.Background(), proto.NodeID{2})
+
+	assert.ErrorIs(t, err, ErrNoRendezvous)
+}
+
+func TestNodePunchInProgress(t *testing.T) {
+	startPunchNode(t, 1, "node-punch-in-progress-r", "")
+	a := startPunchNode(t, 2, "node-punch-in-progress-a", "mem:node-punch-in-progress-r", "udp://127.0.0.1:0")
+	b := startPunchNode(t, 3, "node-punch-in-progress-b", "mem:node-punch-in-progress-r")
+	introduced(t, a, b.ID)
+	a.lock.Lock()
+	a.punches = map[proto.NodeID]chan *proto.Rendezvous{b.ID: nil}
+	a.lock.Unlock()
+
+	err := a.Punch(context.Background(), b.ID)
+
+	assert.ErrorIs(t, err, ErrPunchInProgress)
+}
+
+func TestNodePunchRefused(t *testing.T) {
+	startPunchNode(t, 1, "node-punch-refused-r", "")
+	a := startPunchNode(t, 2, "node-punch-refused-a", "mem:node-punch-refused-r", "udp://127.0.0.1:0")
+	b := startPunchNode(t, 3, "node-punch-refused-b", "mem:node-punch-refused-r")
+	introduced(t, a, b.ID)
+
+	err := a.Punch(context.Background(), b.ID)
+
+	assert.ErrorIs(t, err, ErrPunchRefused)
+	assert.Nil(t, a.Manager.Conduit(b.ID))
+}
+
+func TestNodePunchContext(t *testing.T) {
+	startPunchNode(t, 1, "node-punch-context-r", "")
+	a := startPunchNode(t, 2, "node-punch-context-a", "mem:node-punch-context-r", "udp://127.0.0.1:0")
+	b := startPunchNode(t, 3, "node-punch-context-b", "mem:node-punch-context-r", "udp://127.0.0.1:0")
+	introduced(t, a, b.ID)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := a.Punch(ctx, b.ID)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNodePunchFailed(t *testing.T) {
+	startPunchNode(t, 1, "node-punch-failed-r", "")
+	a := startPunchNode(t, 2, "node-punch-failed-a", "mem:node-punch-failed-r", "udp://127.0.0.1:0")
+	b := startPunchNode(t, 3, "node-punch-failed-b", "mem:node-punch-failed-r", "udp://127.0.0.1:0")
+	introduced(t, a, b.ID)
+	require.Eventually(t, func() bool {
+		_, ok := a.Routes.Route(b.ID)
+		return ok
+	}, 5*time.Second, time.Millisecond)
+	defer patcher.SetVar(&punch, func(ctx context.Context, config conduit.Config, l conduit.Listener, remote *conduit.URI) (*conduit.Conduit, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	err := a.Punch(context.Background(), b.ID)
+
+	assert.ErrorIs(t, err, ErrPunchFailed)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, a.Manager.Conduit(b.ID))
+	_, ok := a.Routes.Route(b.ID)
+	assert.True(t, ok)
+}
+
+func TestNodePunchURIs(t *testing.T) {
+	external, err := conduit.Parse("udp://203.0.113.7:4242")
+	require.NoError(t, err)
+	obj := startPunchNode(t, 1, "node-punch-uris", "", "udp://127.0.0.1:0")
+	obj.lock.Lock()
+	obj.external = map[conduit.Listener]*conduit.URI{obj.listeners[1]: external}
+	obj.lock.Unlock()
+
+	result := obj.punchURIs()
+
+	assert.Equal(t, []string{"udp://203.0.113.7:4242", obj.Addrs()[1].String()}, result)
+}
+
+func TestNodeRendezvousRequestNoTarget(t *testing.T) {
+	startPunchNode(t, 1, "node-rendezvous-no-target-r", "")
+	a := startPunchNode(t, 2, "node-rendezvous-no-target-a", "mem:node-rendezvous-no-target-r", "udp://127.0.0.1:0")
+	r := proto.NodeID{1}
+	require.Eventually(t, func() bool {
+		return a.Manager.Conduit(r) != nil
+	}, 5*time.Second, time.Millisecond)
+	answers := make(chan *proto.Rendezvous, 1)
+	a.lock.Lock()
+	a.punches = map[proto.NodeID]chan *proto.Rendezvous{{3}: answers}
+	a.lock.Unlock()
+
+	err := a.sendRendezvous(a.queue(a.Manager.Conduit(r)), &proto.Rendezvous{
+		Type:      proto.RendezvousRequest,
+		Initiator: a.ID,
+		Target:    proto.NodeID{3},
+	})
+
+	require.NoError(t, err)
+	select {
+	case result := <-answers:
+		assert.Equal(t, proto.RendezvousRefuse, result.Type)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "no refusal received")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+)
+
+// SnapshotVersion is the version of the node snapshot format.
+const SnapshotVersion = 1
+
+// Snapshot is a serializable snapshot of the runtime state of a node:
+// its peer store, and the link-state records of the remote nodes from
+// which its routes are computed.  Snapshots may be used to set up the
+// state of nodes in tests of complex topologies without waiting for
+// records to be flooded, or to carry the state of a node across a
+// restart or an upgrade.  The requests still pending and the reply
+// caches of the node's clients are not captured: each is bound to the
+// conduit from the client which sent the requests, and those conduits
+// do not outlive the node, so there is no client to deliver restored
+// replies to.  Users of a proto.Dispatcher outside a node may capture
+// its reply cache with proto.ReplyCache.Snapshot.  Nor are the node's
+// own links captured, since they follow the conduits to its peers.
+type Snapshot struct {
+	Version    int             `json:"version"`     // Version of the snapshot format
+	NodeID     proto.NodeID    `json:"node_id"`     // Identifier of the node
+	Peers      json.RawMessage `json:"peers"`       // The peer store, as encoded by peer.Store
+	LinkStates [][]byte        `json:"link_states"` // Encoded records of the remote nodes
+}
+
+// Snapshot returns a snapshot of the runtime state of the node.
+func (n *Node) Snapshot() (*Snapshot, error) {
+	peers, err := json.Marshal(n.Peers)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		Version:    SnapshotVersion,
+		NodeID:     n.ID,
+		Peers:      peers,
+		LinkStates: [][]byte{},
+	}
+	for _, ls := range n.Routes.States() {
+		data, err := ls.Encode()
+		if err != nil {
+			return nil, err
+		}
+		snap.LinkStates = append(snap.LinkStates, data)
+	}
+
+	return snap, nil
+}
+
+// Restore restores the runtime state of the node from a snapshot.
+// The contents of the peer store are replaced by those of the
+// snapshot, and the store is saved to its file, if any; the
+// link-state records of the snapshot are merged into the routing
+// table, replacing only older records, as by routing.Table.Update.
+// If the snapshot cannot be parsed, or is of a different node, an
+// error wrapping ErrBadSnapshot is returned, and the state of the
+// node is left unchanged.
+func (n *Node) Restore(snap *Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("node snapshot version %d: %w", snap.Version, proto.ErrSnapshotVersion)
+	}
+	if snap.NodeID != n.ID {
+		return fmt.Errorf("%w: snapshot of node %s", ErrBadSnapshot, snap.NodeID)
+	}
+
+	// Decode the peer store
+	peers := &peer.Store{}
+	if err := peers.UnmarshalJSON(snap.Peers); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+	}
+
+	// Decode the link-state records
+	states := make([]*proto.LinkState, 0, len(snap.LinkStates))
+	for _, data := range snap.LinkStates {
+		ls, err := proto.DecodeLinkState(data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrBadSnapshot, err)
+		}
+		states = append(states, ls)
+	}
+
+	// Restore the state
+	n.Peers.Replace(peers)
+	for _, ls := range states {
+		n.Routes.Update(ls)
+	}
+
+	return n.savePeers()
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package node
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+)
+
+func newSnapshotNode(t *testing.T, cfg *Config) *Node {
+	t.Helper()
+
+	defer patcher.SetVar(&generateNodeID, func() (proto.NodeID, error) {
+		return proto.NodeID{1}, nil
+	}).Install().Restore()
+	n, err := New(cfg)
+	require.NoError(t, err)
+
+	return n
+}
+
+func TestNodeSnapshotRestore(t *testing.T) {
+	src := newSnapshotNode(t, &Config{Listen: []string{"mem:node-snapshot-src"}})
+	src.Peers.Merge(&proto.Advert{
+		Version:      proto.AdvertVersion,
+		NodeID:       proto.NodeID{2},
+		Issued:       time.Unix(1000, 0),
+		URIs:         []string{"mem:two"},
+		Capabilities: []string{"relay"},
+	})
+	src.Peers.Success("mem:two", proto.NodeID{2})
+	src.Routes.Update(&proto.LinkState{
+		Origin:   proto.NodeID{2},
+		Sequence: 5,
+		Links:    []proto.Link{{Neighbor: proto.NodeID{1}, Cost: 10}},
+	})
+	path := filepath.Join(t.TempDir(), "peers.json")
+	dst := newSnapshotNode(t, &Config{Listen: []string{"mem:node-snapshot-dst"}, PeerStore: path})
+	dst.Peers.Failure("mem:three")
+
+	snap, err := src.Snapshot()
+	require.NoError(t, err)
+	data, err := json.Marshal(snap)
+	require.NoError(t, err)
+	result := &Snapshot{}
+	require.NoError(t, json.Unmarshal(data, result))
+	err = dst.Restore(result)
+
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotVersion, snap.Version)
+	assert.Equal(t, src.ID, snap.NodeID)
+	assert.Equal(t, src.Peers.All(), dst.Peers.All())
+	history := dst.Peers.History("mem:two")
+	require.NotNil(t, history)
+	assert.Equal(t, proto.NodeID{2}, history.NodeID)
+	assert.Equal(t, 1, history.Successes)
+	assert.True(t, src.Peers.History("mem:two").LastSuccess.Equal(history.LastSuccess))
+	assert.Nil(t, dst.Peers.History("mem:three"))
+	assert.Equal(t, src.Routes.States(), dst.Routes.States())
+	saved, err := peer.Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, src.Peers.All(), saved.All())
+}
+
+func TestNodeSnapshotAdvertError(t *testing.T) {
+	obj := newSnapshotNode(t, &Config{Listen: []string{"mem:node-snapshot-error"}})
+	obj.Peers.Merge(&proto.Advert{Version: 42, NodeID: proto.NodeID{2}})
+
+	result, err := obj.Snapshot()
+
+	assert.ErrorIs(t, err, proto.ErrAdvertVersion)
+	assert.Nil(t, result)
+}
+
+func TestNodeRestoreVersion(t *testing.T) {
+	obj := newSnapshotNode(t, &Config{Listen: []string{"mem:node-restore-version"}})
+
+	err := obj.Restore(&Snapshot{Version: 42})
+
+	assert.ErrorIs(t, err, proto.ErrSnapshotVersion)
+}
+
+func TestNodeRestoreBadLinkState(t *testing.T) {
+	obj := newSnapshotNode(t, &Config{Listen: []string{"mem:node-restore-bad-state"}})
+	obj.Peers.Failure("mem:two")
+
+	err := obj.Restore(&Snapshot{
+		Version:    SnapshotVersion,
+		NodeID:     obj.ID,
+		Peers:      json.RawMessage(`{"peers": [], "adverts": []}`),
+		LinkStates: [][]byte{{1}},
+	})
+
+	assert.ErrorIs(t, err, ErrBadSnapshot)
+	assert.NotNil(t, obj.Peers.History("mem:two"))
+}
+
+func TestNodeRestoreBadPeers(t *testing.T) {
+	obj := newSnapshotNode(t, &Config{Listen: []string{"mem:node-restore-bad-peers"}})
+
+	obj.Peers.Failure("mem:two")
+
+	err := obj.Restore(&Snapshot{
+		Version:    SnapshotVersion,
+		NodeID:     obj.ID,
+		Peers:      json.RawMessage(`{"peers": [null]}`),
+		LinkStates: [][]byte{},
+	})
+
+	assert.ErrorIs(t, err, ErrBadSnapshot)
+	assert.ErrorIs(t, err, peer.ErrBadStore)
+	assert.Empty(t, obj.Routes.States())
+	assert.NotNil(t, obj.Peers.History("mem:two"))
+}
+
+func TestNodeRestoreWrongNode(t *testing.T) {
+	obj := newSnapshotNode(t, &Config{Listen: []string{"mem:node-restore-wrong-node"}})
+	obj.Peers.Failure("mem:two")
+
+	err := obj.Restore(&Snapshot{
+		Version:    SnapshotVersion,
+		NodeID:     proto.NodeID{2},
+		Peers:      json.RawMessage(`{"peers": [], "adverts": []}`),
+		LinkStates: [][]byte{},
+	})
+
+	assert.ErrorIs(t, err, ErrBadSnapshot)
+	assert.NotNil(t, obj.Peers.History("mem:two"))
+}
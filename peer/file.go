@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// storeFile is the format of a saved store: a JSON object giving the
+// dialing histories, sorted by URI, and the encoded advertisement
+// records, sorted by node ID.
+type storeFile struct {
+	Peers   []*History `json:"peers"`   // The dialing histories
+	Adverts [][]byte   `json:"adverts"` // The encoded records
+}
+
+// Load loads a store saved to a file by Save.  If the file does not
+// exist, an empty store is returned.  If the file cannot be parsed,
+// an error wrapping ErrBadStore is returned.
+func Load(path string) (*Store, error) {
+	data, err := readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Store{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s := &Store{}
+	if err := s.decode(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// decode fills in an empty store from its encoded form.  If the data
+// cannot be parsed, an error wrapping ErrBadStore is returned.
+func (s *Store) decode(data []byte) error {
+	var f storeFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadStore, err)
+	}
+	for _, h := range f.Peers {
+		if h == nil || h.URI == "" {
+			return fmt.Errorf("%w: peer without URI", ErrBadStore)
+		}
+		*s.historyOf(h.URI) = *h
+	}
+	for _, data := range f.Adverts {
+		a, err := proto.DecodeAdvert(data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrBadStore, err)
+		}
+		s.Merge(a)
+	}
+
+	return nil
+}
+
+// encode returns the contents of the store in the format it is saved
+// in.
+func (s *Store) encode() (*storeFile, error) {
+	f := &storeFile{}
+	s.Lock()
+	f.Peers = make([]*History, 0, len(s.history))
+	for _, h := range s.history {
+		tmp := *h
+		f.Peers = append(f.Peers, &tmp)
+	}
+	s.Unlock()
+	slices.SortFunc(f.Peers, func(a, b *History) int {
+		return strings.Compare(a.URI, b.URI)
+	})
+	f.Adverts = [][]byte{}
+	for _, a := range s.All() {
+		data, err := a.Encode()
+		if err != nil {
+			return nil, err
+		}
+		f.Adverts = append(f.Adverts, data)
+	}
+
+	return f, nil
+}
+
+// MarshalJSON encodes the store as JSON, in the format written by
+// Save.
+func (s *Store) MarshalJSON() ([]byte, error) {
+	f, err := s.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON replaces the contents of the store with those encoded
+// by MarshalJSON.  If the data cannot be parsed, an error wrapping
+// ErrBadStore is returned, and the store is left unchanged.
+func (s *Store) UnmarshalJSON(data []byte) error {
+	tmp := &Store{}
+	if err := tmp.decode(data); err != nil {
+		return err
+	}
+
+	s.Replace(tmp)
+
+	return nil
+}
+
+// Save saves the store to a file.  The file is replaced atomically.
+func (s *Store) Save(path string) error {
+	f, err := s.encode()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := writeFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	return rename(tmp, path)
+}
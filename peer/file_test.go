@@ -0,0 +1,164 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package peer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	defer clock(10, 20).Install().Restore()
+	path := filepath.Join(t.TempDir(), "peers.json")
+	obj := &Store{}
+	for id := range byte(2) {
+		a := advert(id+1, 100)
+		a.Version = proto.AdvertVersion
+		a.Capabilities = []string{"relay"}
+		obj.Merge(a)
+	}
+	obj.Success("tcp://one", proto.NodeID{1})
+	obj.Failure("tcp://two")
+
+	err := obj.Save(path)
+
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(filepath.Dir(path), ".peers.json.tmp"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	result, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, obj.All(), result.All())
+	assert.Equal(t, obj.History("tcp://one"), result.History("tcp://one"))
+	assert.Equal(t, obj.History("tcp://two"), result.History("tcp://two"))
+	assert.Equal(t, obj.Rank(), result.Rank())
+}
+
+func TestStoreSaveEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	obj := &Store{}
+
+	err := obj.Save(path)
+
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"peers": [], "adverts": []}`, string(data))
+}
+
+func TestStoreSaveWriteError(t *testing.T) {
+	defer patcher.SetVar(&writeFile, func(name string, data []byte, perm os.FileMode) error {
+		assert.Equal(t, "/var/lib/humboldt/.peers.json.tmp", name)
+		return assert.AnError
+	}).Install().Restore()
+	obj := &Store{}
+
+	err := obj.Save("/var/lib/humboldt/peers.json")
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestStoreSaveAdvertError(t *testing.T) {
+	obj := &Store{}
+	obj.Merge(&proto.Advert{Version: 42, NodeID: proto.NodeID{1}})
+
+	err := obj.Save(filepath.Join(t.TempDir(), "peers.json"))
+
+	assert.ErrorIs(t, err, proto.ErrAdvertVersion)
+}
+
+func TestLoadMissing(t *testing.T) {
+	result, err := Load(filepath.Join(t.TempDir(), "peers.json"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Store{}, result)
+}
+
+func TestLoadReadError(t *testing.T) {
+	defer patcher.SetVar(&readFile, func(name string) ([]byte, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := Load("/var/lib/humboldt/peers.json")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestLoadBad(t *testing.T) {
+	for _, data := range []string{
+		`bogus`,
+		`{"peers": [{"failures": 1}]}`,
+		`{"peers": [null]}`,
+		`{"adverts": ["KgE="]}`,
+	} {
+		defer patcher.SetVar(&readFile, func(name string) ([]byte, error) {
+			return []byte(data), nil
+		}).Install().Restore()
+
+		result, err := Load("/var/lib/humboldt/peers.json")
+
+		assert.ErrorIs(t, err, ErrBadStore, data)
+		assert.ErrorContains(t, err, "/var/lib/humboldt/peers.json", data)
+		assert.Nil(t, result, data)
+	}
+}
+
+func TestStoreMarshalJSON(t *testing.T) {
+	defer clock(10, 20).Install().Restore()
+	obj := &Store{}
+	a := advert(1, 100)
+	a.Version = proto.AdvertVersion
+	a.Capabilities = []string{"relay"}
+	obj.Merge(a)
+	obj.Success("tcp://one", proto.NodeID{1})
+
+	data, err := json.Marshal(obj)
+
+	require.NoError(t, err)
+	result := &Store{}
+	result.Failure("tcp://two")
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err)
+	assert.Equal(t, obj.All(), result.All())
+	assert.Equal(t, obj.History("tcp://one"), result.History("tcp://one"))
+	assert.Nil(t, result.History("tcp://two"))
+}
+
+func TestStoreMarshalJSONAdvertError(t *testing.T) {
+	obj := &Store{}
+	obj.Merge(&proto.Advert{Version: 42, NodeID: proto.NodeID{1}})
+
+	_, err := json.Marshal(obj)
+
+	assert.ErrorIs(t, err, proto.ErrAdvertVersion)
+}
+
+func TestStoreUnmarshalJSONBad(t *testing.T) {
+	obj := &Store{}
+	obj.Failure("tcp://one")
+
+	err := obj.UnmarshalJSON([]byte(`{"peers": [null]}`))
+
+	assert.ErrorIs(t, err, ErrBadStore)
+	assert.NotNil(t, obj.History("tcp://one"))
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package peer keeps track of the peers known to a Humboldt node.
+// Peers are described by their advertisement records, which are
+// learned from configuration, discovery, and gossip; the store keeps
+// the most recently issued record for each node.  The store also
+// keeps the history of dialing the URIs of the peers, from which it
+// ranks them for dialing, and may be saved to a file, so that a node
+// which restarts may rejoin the mesh through the peers it knew.
+package peer
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// History is the history of dialing the URI of a peer.
+type History struct {
+	URI         string       `json:"uri"`          // The URI dialed
+	NodeID      proto.NodeID `json:"node_id"`      // Node reached, once known
+	Successes   int          `json:"successes"`    // Number of successful dials
+	Failures    int          `json:"failures"`     // Consecutive failed dials
+	LastSuccess time.Time    `json:"last_success"` // When last dialed successfully
+	LastFailure time.Time    `json:"last_failure"` // When dialing last failed
+}
+
+// compare compares two histories for ranking.  URIs with fewer
+// consecutive failures are ranked first, then those dialed
+// successfully most recently, then those dialed successfully most
+// often.
+func (h *History) compare(o *History) int {
+	if c := cmp.Compare(h.Failures, o.Failures); c != 0 {
+		return c
+	}
+	if c := o.LastSuccess.Compare(h.LastSuccess); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(o.Successes, h.Successes); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(h.URI, o.URI)
+}
+
+// Store is a store of the advertisement records of known peers and of
+// the history of dialing them.  The zero value is an empty store,
+// ready to use.  Records passed to and returned by the store must not
+// be modified.
+type Store struct {
+	sync.Mutex
+
+	adverts map[proto.NodeID]*proto.Advert // Records by node ID
+	history map[string]*History            // Dialing histories by URI
+}
+
+// Merge merges an advertisement record into the store.  The record
+// replaces the one held for the node if it was issued later.  It
+// returns true if the record was added or replaced one.
+func (s *Store) Merge(a *proto.Advert) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if old, ok := s.adverts[a.NodeID]; ok && !a.Issued.After(old.Issued) {
+		return false
+	}
+	if s.adverts == nil {
+		s.adverts = map[proto.NodeID]*proto.Advert{}
+	}
+	s.adverts[a.NodeID] = a
+
+	return true
+}
+
+// Get returns the record held for a node, or nil if the node is not
+// known.
+func (s *Store) Get(id proto.NodeID) *proto.Advert {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.adverts[id]
+}
+
+// Remove removes the record held for a node.  It returns true if the
+// node was known.
+func (s *Store) Remove(id proto.NodeID) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.adverts[id]; !ok {
+		return false
+	}
+	delete(s.adverts, id)
+
+	return true
+}
+
+// Len returns the number of known peers.
+func (s *Store) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.adverts)
+}
+
+// All returns the records of all known peers, sorted by node ID.
+func (s *Store) All() []*proto.Advert {
+	s.Lock()
+	result := make([]*proto.Advert, 0, len(s.adverts))
+	for _, a := range s.adverts {
+		result = append(result, a)
+	}
+	s.Unlock()
+
+	slices.SortFunc(result, func(a, b *proto.Advert) int {
+		return a.NodeID.Compare(b.NodeID)
+	})
+
+	return result
+}
+
+// Replace replaces the contents of the store with those of another
+// store, which must not be used afterwards.
+func (s *Store) Replace(o *Store) {
+	o.Lock()
+	adverts, history := o.adverts, o.history
+	o.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.adverts = adverts
+	s.history = history
+}
+
+// historyOf returns the dialing history of a URI, creating it if
+// necessary.  The store must be locked.
+func (s *Store) historyOf(uri string) *History {
+	h, ok := s.history[uri]
+	if !ok {
+		if s.history == nil {
+			s.history = map[string]*History{}
+		}
+		h = &History{URI: uri}
+		s.history[uri] = h
+	}
+
+	return h
+}
+
+// Success records that dialing a URI succeeded, reaching the
+// specified node.
+func (s *Store) Success(uri string, id proto.NodeID) {
+	s.Lock()
+	defer s.Unlock()
+
+	h := s.historyOf(uri)
+	h.NodeID = id
+	h.Successes++
+	h.Failures = 0
+	h.LastSuccess = timeNow()
+}
+
+// Failure records that dialing a URI failed.
+func (s *Store) Failure(uri string) {
+	s.Lock()
+	defer s.Unlock()
+
+	h := s.historyOf(uri)
+	h.Failures++
+	h.LastFailure = timeNow()
+}
+
+// Forget forgets the dialing history of a URI.  It returns true if
+// the URI was known.
+func (s *Store) Forget(uri string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.history[uri]; !ok {
+		return false
+	}
+	delete(s.history, uri)
+
+	return true
+}
+
+// History returns a copy of the dialing history of a URI, or nil if
+// the URI has not been dialed.
+func (s *Store) History(uri string) *History {
+	s.Lock()
+	defer s.Unlock()
+
+	h, ok := s.history[uri]
+	if !ok {
+		return nil
+	}
+	result := *h
+
+	return &result
+}
+
+// Rank returns the URIs of the known peers, ranked for dialing: the
+// URIs which have been dialed, and those of the advertisement records
+// which have not, which rank as though never dialed.  URIs with fewer
+// consecutive failures are ranked first, then those dialed
+// successfully most recently, then those dialed successfully most
+// often.
+func (s *Store) Rank() []string {
+	s.Lock()
+	hists := make([]*History, 0, len(s.history))
+	for _, h := range s.history {
+		hists = append(hists, h)
+	}
+	for _, a := range s.adverts {
+		for _, uri := range a.URIs {
+			if _, ok := s.history[uri]; !ok {
+				hists = append(hists, &History{URI: uri})
+			}
+		}
+	}
+	slices.SortFunc(hists, (*History).compare)
+	s.Unlock()
+
+	result := make([]string, 0, len(hists))
+	for _, h := range hists {
+		if len(result) == 0 || result[len(result)-1] != h.URI {
+			result = append(result, h.URI)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// advert constructs an advertisement record for testing.
+func advert(id byte, issued int64) *proto.Advert {
+	return &proto.Advert{
+		NodeID: proto.NodeID{id},
+		Issued: time.Unix(issued, 0),
+		URIs:   []string{"tcp://node"},
+	}
+}
+
+func TestStoreMergeBase(t *testing.T) {
+	a := advert(1, 100)
+	obj := &Store{}
+
+	result := obj.Merge(a)
+
+	assert.True(t, result)
+	assert.Same(t, a, obj.Get(proto.NodeID{1}))
+	assert.Equal(t, 1, obj.Len())
+}
+
+func TestStoreMergeNewer(t *testing.T) {
+	a := advert(1, 200)
+	obj := &Store{}
+	obj.Merge(advert(1, 100))
+
+	result := obj.Merge(a)
+
+	assert.True(t, result)
+	assert.Same(t, a, obj.Get(proto.NodeID{1}))
+}
+
+func TestStoreMergeStale(t *testing.T) {
+	a := advert(1, 200)
+	obj := &Store{}
+	obj.Merge(a)
+
+	assert.False(t, obj.Merge(advert(1, 200)))
+	assert.False(t, obj.Merge(advert(1, 100)))
+
+	assert.Same(t, a, obj.Get(proto.NodeID{1}))
+}
+
+func TestStoreGetMissing(t *testing.T) {
+	obj := &Store{}
+
+	result := obj.Get(proto.NodeID{1})
+
+	assert.Nil(t, result)
+}
+
+func TestStoreRemove(t *testing.T) {
+	obj := &Store{}
+	obj.Merge(advert(1, 100))
+
+	assert.False(t, obj.Remove(proto.NodeID{2}))
+	assert.True(t, obj.Remove(proto.NodeID{1}))
+
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestStoreReplace(t *testing.T) {
+	obj := &Store{}
+	obj.Merge(advert(1, 100))
+	obj.Failure("tcp://one")
+	other := &Store{}
+	other.Merge(advert(2, 100))
+	other.Success("tcp://two", proto.NodeID{2})
+
+	obj.Replace(other)
+
+	assert.Equal(t, []*proto.Advert{advert(2, 100)}, obj.All())
+	assert.Nil(t, obj.History("tcp://one"))
+	assert.NotNil(t, obj.History("tcp://two"))
+}
+
+func TestStoreAll(t *testing.T) {
+	obj := &Store{}
+	obj.Merge(advert(3, 100))
+	obj.Merge(advert(1, 100))
+	obj.Merge(advert(2, 100))
+
+	result := obj.All()
+
+	assert.Equal(t, []*proto.Advert{advert(1, 100), advert(2, 100), advert(3, 100)}, result)
+}
+
+func TestStoreAllEmpty(t *testing.T) {
+	obj := &Store{}
+
+	result := obj.All()
+
+	assert.Empty(t, result)
+}
+
+// at returns a time for testing, in UTC, as times are loaded.
+func at(sec int64) time.Time {
+	return time.Unix(sec, 0).UTC()
+}
+
+// clock patches timeNow to return the times in sequence.
+func clock(times ...int64) patcher.Patcher {
+	return patcher.SetVar(&timeNow, func() time.Time {
+		now := at(times[0])
+		times = times[1:]
+		return now
+	})
+}
+
+func TestStoreSuccess(t *testing.T) {
+	defer clock(10, 20, 30).Install().Restore()
+	obj := &Store{}
+	obj.Failure("tcp://node")
+
+	obj.Success("tcp://node", proto.NodeID{1})
+	obj.Success("tcp://node", proto.NodeID{2})
+
+	assert.Equal(t, &History{
+		URI:         "tcp://node",
+		NodeID:      proto.NodeID{2},
+		Successes:   2,
+		LastSuccess: at(30),
+		LastFailure: at(10),
+	}, obj.History("tcp://node"))
+}
+
+func TestStoreFailure(t *testing.T) {
+	defer clock(10, 20, 30).Install().Restore()
+	obj := &Store{}
+	obj.Success("tcp://node", proto.NodeID{1})
+
+	obj.Failure("tcp://node")
+	obj.Failure("tcp://node")
+
+	assert.Equal(t, &History{
+		URI:         "tcp://node",
+		NodeID:      proto.NodeID{1},
+		Successes:   1,
+		Failures:    2,
+		LastSuccess: at(10),
+		LastFailure: at(30),
+	}, obj.History("tcp://node"))
+}
+
+func TestStoreHistoryCopy(t *testing.T) {
+	obj := &Store{}
+	obj.Failure("tcp://node")
+
+	result := obj.History("tcp://node")
+	result.Failures = 5
+
+	assert.Equal(t, 1, obj.History("tcp://node").Failures)
+}
+
+func TestStoreHistoryMissing(t *testing.T) {
+	obj := &Store{}
+
+	result := obj.History("tcp://node")
+
+	assert.Nil(t, result)
+}
+
+func TestStoreForget(t *testing.T) {
+	obj := &Store{}
+	obj.Failure("tcp://node")
+
+	assert.False(t, obj.Forget("tcp://other"))
+	assert.True(t, obj.Forget("tcp://node"))
+
+	assert.Nil(t, obj.History("tcp://node"))
+}
+
+func TestStoreRank(t *testing.T) {
+	defer clock(10, 20, 30, 40, 50, 60, 70).Install().Restore()
+	obj := &Store{}
+	obj.Success("tcp://old", proto.NodeID{1})
+	obj.Success("tcp://often", proto.NodeID{2})
+	obj.Success("tcp://often", proto.NodeID{2})
+	obj.Success("tcp://recent", proto.NodeID{3})
+	obj.Failure("tcp://failing")
+	obj.Success("tcp://failed", proto.NodeID{4})
+	obj.Merge(&proto.Advert{NodeID: proto.NodeID{5}, URIs: []string{"tcp://advert", "tcp://recent"}})
+	obj.Merge(&proto.Advert{NodeID: proto.NodeID{6}, URIs: []string{"tcp://advert"}})
+	obj.Failure("tcp://failed")
+
+	result := obj.Rank()
+
+	assert.Equal(t, []string{
+		"tcp://recent",
+		"tcp://often",
+		"tcp://old",
+		"tcp://advert",
+		"tcp://failed",
+		"tcp://failing",
+	}, result)
+}
+
+func TestStoreRankEmpty(t *testing.T) {
+	obj := &Store{}
+
+	result := obj.Rank()
+
+	assert.Empty(t, result)
+}
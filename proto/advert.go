@@ -0,0 +1,308 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AdvertVersion is the current version of the advertisement record
+// format.
+const AdvertVersion uint8 = 1
+
+// Advert is an advertisement record, describing how to reach a node
+// and what it is capable of.  The same record is used by gossip, by
+// discovery publication, and by the peer store.  Records are signed
+// by the advertising node, so that receivers may verify that the
+// advertisement was not spoofed.
+type Advert struct {
+	Version      uint8     // Version of the record format
+	NodeID       NodeID    // Identifier of the advertised node
+	Issued       time.Time // When the record was issued
+	URIs         []string  // Conduit URIs at which the node may be reached
+	Capabilities []string  // Capabilities of the node
+	Signature    []byte    // Signature over the rest of the record
+}
+
+// AdvertCodec is an interface for the serialization of advertisement
+// records of a particular version.
+type AdvertCodec interface {
+	// EncodeAdvert encodes an advertisement record.
+	EncodeAdvert(a *Advert) ([]byte, error)
+
+	// DecodeAdvert decodes an advertisement record.  The first
+	// byte of the data is the version.
+	DecodeAdvert(data []byte) (*Advert, error)
+}
+
+// advertCodecs is a registry of advertisement record codecs, indexed
+// by version.
+var (
+	advertCodecs = map[uint8]AdvertCodec{
+		1: advertV1{},
+	}
+	advertLock sync.RWMutex
+)
+
+// RegisterAdvertCodec registers a codec for a version of the
+// advertisement record format.
+func RegisterAdvertCodec(version uint8, codec AdvertCodec) {
+	advertLock.Lock()
+	defer advertLock.Unlock()
+
+	advertCodecs[version] = codec
+}
+
+// lookupAdvertCodec looks up the codec for a version.
+func lookupAdvertCodec(version uint8) (AdvertCodec, error) {
+	advertLock.RLock()
+	defer advertLock.RUnlock()
+
+	codec, ok := advertCodecs[version]
+	if !ok {
+		return nil, fmt.Errorf("advert version %d: %w", version, ErrAdvertVersion)
+	}
+
+	return codec, nil
+}
+
+// Encode encodes the advertisement record using the codec for its
+// version.  A zero version is treated as AdvertVersion.
+func (a *Advert) Encode() ([]byte, error) {
+	version := a.Version
+	if version == 0 {
+		version = AdvertVersion
+	}
+	codec, err := lookupAdvertCodec(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.EncodeAdvert(a)
+}
+
+// DecodeAdvert decodes an advertisement record, selecting the codec
+// based on the version in the first byte.
+func DecodeAdvert(data []byte) (*Advert, error) {
+	if len(data) < 1 {
+		return nil, ErrShortInput
+	}
+	codec, err := lookupAdvertCodec(data[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.DecodeAdvert(data)
+}
+
+// SignedBytes returns the bytes covered by the signature of the
+// record, which is the encoding of the record without the signature.
+func (a *Advert) SignedBytes() ([]byte, error) {
+	tmp := *a
+	tmp.Signature = nil
+
+	return tmp.Encode()
+}
+
+// Signer is an interface for signing advertisement records.
+type Signer interface {
+	// Sign signs the data, returning the signature.
+	Sign(data []byte) ([]byte, error)
+}
+
+// SignerFunc is an adaptor allowing an ordinary function to be used
+// as a Signer.
+type SignerFunc func(data []byte) ([]byte, error)
+
+// Sign signs the data, returning the signature.
+func (f SignerFunc) Sign(data []byte) ([]byte, error) {
+	return f(data)
+}
+
+// Verifier is an interface for verifying the signatures of
+// advertisement records.
+type Verifier interface {
+	// Verify verifies the signature of data purporting to come
+	// from the specified node.  It returns an error if the
+	// signature is not valid.
+	Verify(id NodeID, data, sig []byte) error
+}
+
+// VerifierFunc is an adaptor allowing an ordinary function to be used
+// as a Verifier.
+type VerifierFunc func(id NodeID, data, sig []byte) error
+
+// Verify verifies the signature of data purporting to come from the
+// specified node.
+func (f VerifierFunc) Verify(id NodeID, data, sig []byte) error {
+	return f(id, data, sig)
+}
+
+// Sign signs the record with the specified signer, setting the
+// Signature field.
+func (a *Advert) Sign(s Signer) error {
+	data, err := a.SignedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := s.Sign(data)
+	if err != nil {
+		return err
+	}
+	a.Signature = sig
+
+	return nil
+}
+
+// Verify verifies the signature of the record with the specified
+// verifier.  Unsigned records are rejected.
+func (a *Advert) Verify(v Verifier) error {
+	if len(a.Signature) == 0 {
+		return fmt.Errorf("advert for %s: %w", a.NodeID, ErrBadSignature)
+	}
+	data, err := a.SignedBytes()
+	if err != nil {
+		return err
+	}
+	if err := v.Verify(a.NodeID, data, a.Signature); err != nil {
+		return fmt.Errorf("advert for %s: %w: %w", a.NodeID, ErrBadSignature, err)
+	}
+
+	return nil
+}
+
+// advertV1 is the codec for version 1 advertisement records.  The
+// encoding is the version byte, the node ID, the issue time in
+// seconds since the epoch as an unsigned 64-bit integer, the URIs and
+// the capabilities as 16-bit counts followed by strings with 16-bit
+// lengths, and the signature with a 16-bit length.  All integers are
+// big-endian.
+type advertV1 struct{}
+
+// putString appends a string with a 16-bit length.
+func putString(data []byte, s string) ([]byte, error) {
+	if len(s) > MaxLength {
+		return nil, ErrTooLong
+	}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(s)))
+
+	return append(data, s...), nil
+}
+
+// putStrings appends a list of strings with a 16-bit count.
+func putStrings(data []byte, list []string) ([]byte, error) {
+	if len(list) > MaxLength {
+		return nil, ErrTooLong
+	}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(list)))
+	for _, s := range list {
+		var err error
+		if data, err = putString(data, s); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// getBytes retrieves a byte sequence with a 16-bit length, returning
+// it and the remaining data.
+func getBytes(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, ErrShortInput
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, ErrShortInput
+	}
+
+	return data[2 : 2+n], data[2+n:], nil
+}
+
+// getStrings retrieves a list of strings with a 16-bit count,
+// returning it and the remaining data.
+func getStrings(data []byte) ([]string, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, ErrShortInput
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	result := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		s, rest, err := getBytes(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, string(s))
+		data = rest
+	}
+
+	return result, data, nil
+}
+
+// EncodeAdvert encodes an advertisement record.
+func (advertV1) EncodeAdvert(a *Advert) ([]byte, error) {
+	data := []byte{1}
+	data = append(data, a.NodeID[:]...)
+	data = binary.BigEndian.AppendUint64(data, uint64(a.Issued.Unix()))
+
+	var err error
+	if data, err = putStrings(data, a.URIs); err != nil {
+		return nil, fmt.Errorf("advert URIs: %w", err)
+	}
+	if data, err = putStrings(data, a.Capabilities); err != nil {
+		return nil, fmt.Errorf("advert capabilities: %w", err)
+	}
+	if len(a.Signature) > MaxLength {
+		return nil, fmt.Errorf("advert signature: %w", ErrTooLong)
+	}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.Signature)))
+
+	return append(data, a.Signature...), nil
+}
+
+// DecodeAdvert decodes an advertisement record.
+func (advertV1) DecodeAdvert(data []byte) (*Advert, error) {
+	if len(data) < 1+NodeIDSize+8 {
+		return nil, ErrShortInput
+	}
+	a := &Advert{Version: data[0]}
+	copy(a.NodeID[:], data[1:1+NodeIDSize])
+	a.Issued = time.Unix(int64(binary.BigEndian.Uint64(data[1+NodeIDSize:])), 0)
+	data = data[1+NodeIDSize+8:]
+
+	var err error
+	if a.URIs, data, err = getStrings(data); err != nil {
+		return nil, fmt.Errorf("advert URIs: %w", err)
+	}
+	if a.Capabilities, data, err = getStrings(data); err != nil {
+		return nil, fmt.Errorf("advert capabilities: %w", err)
+	}
+	sig, data, err := getBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("advert signature: %w", err)
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("advert: %w", ErrBadLength)
+	}
+	if len(sig) > 0 {
+		a.Signature = append([]byte{}, sig...)
+	}
+
+	return a, nil
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+var testAdvertData = []byte{
+	0x01,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0xe8,
+	0x00, 0x01,
+	0x00, 0x03, 'u', 'r', 'i',
+	0x00, 0x02,
+	0x00, 0x01, 'a',
+	0x00, 0x02, 'b', 'c',
+	0x00, 0x03, 's', 'i', 'g',
+}
+
+func testAdvert() *Advert {
+	return &Advert{
+		Version:      1,
+		NodeID:       NodeID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Issued:       time.Unix(1000, 0),
+		URIs:         []string{"uri"},
+		Capabilities: []string{"a", "bc"},
+		Signature:    []byte("sig"),
+	}
+}
+
+type mockAdvertCodec struct{}
+
+func (mockAdvertCodec) EncodeAdvert(a *Advert) ([]byte, error) {
+	return []byte{a.Version}, nil
+}
+
+func (mockAdvertCodec) DecodeAdvert(data []byte) (*Advert, error) {
+	return &Advert{Version: data[0]}, nil
+}
+
+func TestRegisterAdvertCodec(t *testing.T) {
+	defer patcher.SetVar(&advertCodecs, map[uint8]AdvertCodec{}).Install().Restore()
+
+	RegisterAdvertCodec(2, mockAdvertCodec{})
+
+	assert.Equal(t, map[uint8]AdvertCodec{2: mockAdvertCodec{}}, advertCodecs)
+}
+
+func TestAdvertEncodeBase(t *testing.T) {
+	result, err := testAdvert().Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testAdvertData, result)
+}
+
+func TestAdvertEncodeDefaultVersion(t *testing.T) {
+	obj := testAdvert()
+	obj.Version = 0
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testAdvertData, result)
+}
+
+func TestAdvertEncodeOtherVersion(t *testing.T) {
+	defer patcher.SetVar(&advertCodecs, map[uint8]AdvertCodec{2: mockAdvertCodec{}}).Install().Restore()
+
+	result, err := (&Advert{Version: 2}).Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2}, result)
+}
+
+func TestAdvertEncodeUnknownVersion(t *testing.T) {
+	result, err := (&Advert{Version: 0xff}).Encode()
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+	assert.Nil(t, result)
+}
+
+func TestAdvertEncodeTooManyURIs(t *testing.T) {
+	obj := testAdvert()
+	obj.URIs = make([]string, MaxLength+1)
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestAdvertEncodeLongCapability(t *testing.T) {
+	obj := testAdvert()
+	obj.Capabilities = []string{string(make([]byte, MaxLength+1))}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestAdvertEncodeLongSignature(t *testing.T) {
+	obj := testAdvert()
+	obj.Signature = make([]byte, MaxLength+1)
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodeAdvertBase(t *testing.T) {
+	result, err := DecodeAdvert(testAdvertData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testAdvert(), result)
+}
+
+func TestDecodeAdvertUnsigned(t *testing.T) {
+	data := append([]byte{}, testAdvertData[:len(testAdvertData)-5]...)
+	data = append(data, 0x00, 0x00)
+	expected := testAdvert()
+	expected.Signature = nil
+
+	result, err := DecodeAdvert(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestDecodeAdvertEmpty(t *testing.T) {
+	result, err := DecodeAdvert([]byte{})
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeAdvertUnknownVersion(t *testing.T) {
+	result, err := DecodeAdvert([]byte{0xff})
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+	assert.Nil(t, result)
+}
+
+func TestDecodeAdvertTruncated(t *testing.T) {
+	for i := 1; i < len(testAdvertData); i++ {
+		result, err := DecodeAdvert(testAdvertData[:i])
+
+		assert.ErrorIs(t, err, ErrShortInput, "length %d", i)
+		assert.Nil(t, result)
+	}
+}
+
+func TestDecodeAdvertTrailing(t *testing.T) {
+	result, err := DecodeAdvert(append(append([]byte{}, testAdvertData...), 0x00))
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestAdvertSignedBytes(t *testing.T) {
+	obj := testAdvert()
+
+	result, err := obj.SignedBytes()
+
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, testAdvertData[:len(testAdvertData)-5]...), 0x00, 0x00), result)
+	assert.Equal(t, []byte("sig"), obj.Signature)
+}
+
+func TestSignerFuncImplementsSigner(t *testing.T) {
+	assert.Implements(t, (*Signer)(nil), SignerFunc(nil))
+}
+
+func TestVerifierFuncImplementsVerifier(t *testing.T) {
+	assert.Implements(t, (*Verifier)(nil), VerifierFunc(nil))
+}
+
+func TestAdvertSignBase(t *testing.T) {
+	obj := testAdvert()
+	obj.Signature = nil
+	signed, _ := obj.SignedBytes()
+
+	err := obj.Sign(SignerFunc(func(data []byte) ([]byte, error) {
+		assert.Equal(t, signed, data)
+		return []byte("signature"), nil
+	}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("signature"), obj.Signature)
+}
+
+func TestAdvertSignEncodeError(t *testing.T) {
+	obj := &Advert{Version: 0xff}
+
+	err := obj.Sign(SignerFunc(func(data []byte) ([]byte, error) {
+		t.Fail()
+		return nil, nil
+	}))
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+}
+
+func TestAdvertSignError(t *testing.T) {
+	obj := testAdvert()
+
+	err := obj.Sign(SignerFunc(func(data []byte) ([]byte, error) {
+		return nil, assert.AnError
+	}))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, []byte("sig"), obj.Signature)
+}
+
+func TestAdvertVerifyBase(t *testing.T) {
+	obj := testAdvert()
+	signed, _ := obj.SignedBytes()
+
+	err := obj.Verify(VerifierFunc(func(id NodeID, data, sig []byte) error {
+		assert.Equal(t, obj.NodeID, id)
+		assert.Equal(t, signed, data)
+		assert.Equal(t, []byte("sig"), sig)
+		return nil
+	}))
+
+	assert.NoError(t, err)
+}
+
+func TestAdvertVerifyUnsigned(t *testing.T) {
+	obj := testAdvert()
+	obj.Signature = nil
+
+	err := obj.Verify(VerifierFunc(func(id NodeID, data, sig []byte) error {
+		t.Fail()
+		return nil
+	}))
+
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestAdvertVerifyEncodeError(t *testing.T) {
+	obj := &Advert{Version: 0xff, Signature: []byte("sig")}
+
+	err := obj.Verify(VerifierFunc(func(id NodeID, data, sig []byte) error {
+		t.Fail()
+		return nil
+	}))
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+}
+
+func TestAdvertVerifyFailed(t *testing.T) {
+	obj := testAdvert()
+
+	err := obj.Verify(VerifierFunc(func(id NodeID, data, sig []byte) error {
+		return assert.AnError
+	}))
+
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestAdvertRoundTrip(t *testing.T) {
+	obj := &Advert{
+		NodeID:       NodeID{0xff},
+		Issued:       time.Unix(1234567890, 0),
+		URIs:         []string{"tcp://127.0.0.1:1234", "quic://[::1]:1234"},
+		Capabilities: []string{},
+	}
+	key := []byte("key")
+	sign := SignerFunc(func(data []byte) ([]byte, error) {
+		return append(append([]byte{}, key...), data[:4]...), nil
+	})
+	verify := VerifierFunc(func(id NodeID, data, sig []byte) error {
+		if !bytes.Equal(sig, append(append([]byte{}, key...), data[:4]...)) {
+			return assert.AnError
+		}
+		return nil
+	})
+	assert.NoError(t, obj.Sign(sign))
+	data, err := obj.Encode()
+	assert.NoError(t, err)
+
+	result, err := DecodeAdvert(data)
+
+	assert.NoError(t, err)
+	assert.NoError(t, result.Verify(verify))
+	assert.Equal(t, obj.URIs, result.URIs)
+	assert.Equal(t, obj.Issued, result.Issued)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+// Ed25519Signer signs advertisement records with a node's Ed25519
+// identity key.
+type Ed25519Signer ed25519.PrivateKey
+
+// Sign signs the data, returning the signature.
+func (k Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	if len(k) != ed25519.PrivateKeySize {
+		return nil, ErrBadKey
+	}
+
+	return ed25519.Sign(ed25519.PrivateKey(k), data), nil
+}
+
+// Ed25519Verifier verifies the signatures of advertisement records
+// against the Ed25519 identity keys of the nodes.  It looks up the
+// public key of the specified node, returning an error wrapping
+// ErrUnknownKey if the node's key is not known.
+type Ed25519Verifier func(id NodeID) (ed25519.PublicKey, error)
+
+// Verify verifies the signature of data purporting to come from the
+// specified node.
+func (f Ed25519Verifier) Verify(id NodeID, data, sig []byte) error {
+	key, err := f(id)
+	if err != nil {
+		return err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return ErrBadKey
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// Ed25519Keys is a Verifier using a fixed set of Ed25519 identity
+// keys.
+type Ed25519Keys map[NodeID]ed25519.PublicKey
+
+// Verify verifies the signature of data purporting to come from the
+// specified node.
+func (k Ed25519Keys) Verify(id NodeID, data, sig []byte) error {
+	return Ed25519Verifier(func(id NodeID) (ed25519.PublicKey, error) {
+		key, ok := k[id]
+		if !ok {
+			return nil, fmt.Errorf("node %s: %w", id, ErrUnknownKey)
+		}
+		return key, nil
+	}).Verify(id, data, sig)
+}
+
+// AdvertPolicy describes how advertisement records that are unsigned
+// or whose signatures are invalid are treated on receipt.
+type AdvertPolicy int
+
+// Defined advertisement policies.
+const (
+	AdvertRequireSigned AdvertPolicy = iota // Reject unsigned and invalid records
+	AdvertAllowUnsigned                     // Flag unsigned records; reject invalid ones
+	AdvertAllowAll                          // Flag unsigned and invalid records
+)
+
+// AdvertStatus describes the result of verifying an advertisement
+// record on receipt.
+type AdvertStatus int
+
+// Defined advertisement statuses.
+const (
+	AdvertVerified AdvertStatus = iota // Signature is valid
+	AdvertUnsigned                     // Record is not signed
+	AdvertInvalid                      // Signature is not valid
+)
+
+// String returns the name of the status.
+func (s AdvertStatus) String() string {
+	switch s {
+	case AdvertVerified:
+		return "verified"
+	case AdvertUnsigned:
+		return "unsigned"
+	case AdvertInvalid:
+		return "invalid"
+	}
+
+	return fmt.Sprintf("AdvertStatus(%d)", int(s))
+}
+
+// Check verifies the signature of a received record and applies the
+// policy.  It returns the status of the record, and an error if the
+// policy rejects it.  Records the policy accepts despite a missing or
+// invalid signature are flagged by the returned status.
+func (a *Advert) Check(v Verifier, policy AdvertPolicy) (AdvertStatus, error) {
+	if len(a.Signature) == 0 {
+		if policy == AdvertRequireSigned {
+			return AdvertUnsigned, fmt.Errorf("advert for %s: %w", a.NodeID, ErrUnsignedAdvert)
+		}
+		return AdvertUnsigned, nil
+	}
+
+	if err := a.Verify(v); err != nil {
+		if policy == AdvertAllowAll {
+			return AdvertInvalid, nil
+		}
+		return AdvertInvalid, err
+	}
+
+	return AdvertVerified, nil
+}
+
+// ReceiveAdvert decodes a received advertisement record and checks
+// its signature according to the policy.  The record is returned
+// along with its status unless it could not be decoded or the policy
+// rejects it.
+func ReceiveAdvert(data []byte, v Verifier, policy AdvertPolicy) (*Advert, AdvertStatus, error) {
+	a, err := DecodeAdvert(data)
+	if err != nil {
+		return nil, AdvertInvalid, err
+	}
+
+	status, err := a.Check(v, policy)
+	if err != nil {
+		return nil, status, err
+	}
+
+	return a, status, nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeys returns an Ed25519 key pair derived from a fixed seed.
+func testKeys() (ed25519.PublicKey, ed25519.PrivateKey) {
+	priv := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	return priv.Public().(ed25519.PublicKey), priv
+}
+
+// signedAdvert returns a test advertisement signed with the test key.
+func signedAdvert(t *testing.T) (*Advert, Ed25519Keys) {
+	pub, priv := testKeys()
+	a := testAdvert()
+	assert.NoError(t, a.Sign(Ed25519Signer(priv)))
+
+	return a, Ed25519Keys{a.NodeID: pub}
+}
+
+func TestEd25519SignerBadKey(t *testing.T) {
+	result, err := Ed25519Signer([]byte("short")).Sign([]byte("data"))
+
+	assert.ErrorIs(t, err, ErrBadKey)
+	assert.Nil(t, result)
+}
+
+func TestEd25519VerifierBase(t *testing.T) {
+	a, keys := signedAdvert(t)
+
+	err := a.Verify(keys)
+
+	assert.NoError(t, err)
+	assert.Len(t, a.Signature, ed25519.SignatureSize)
+}
+
+func TestEd25519VerifierTampered(t *testing.T) {
+	a, keys := signedAdvert(t)
+	a.URIs = []string{"other"}
+
+	err := a.Verify(keys)
+
+	assert.ErrorIs(t, err, ErrBadSignature)
+}
+
+func TestEd25519VerifierUnknownKey(t *testing.T) {
+	a, _ := signedAdvert(t)
+
+	err := a.Verify(Ed25519Keys{})
+
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestEd25519VerifierBadKey(t *testing.T) {
+	a, _ := signedAdvert(t)
+
+	err := a.Verify(Ed25519Keys{a.NodeID: ed25519.PublicKey("short")})
+
+	assert.ErrorIs(t, err, ErrBadKey)
+}
+
+func TestAdvertStatusString(t *testing.T) {
+	assert.Equal(t, "verified", AdvertVerified.String())
+	assert.Equal(t, "unsigned", AdvertUnsigned.String())
+	assert.Equal(t, "invalid", AdvertInvalid.String())
+	assert.Equal(t, "AdvertStatus(17)", AdvertStatus(17).String())
+}
+
+func TestAdvertCheck(t *testing.T) {
+	signed, keys := signedAdvert(t)
+	unsigned := testAdvert()
+	unsigned.Signature = nil
+	invalid := testAdvert()
+	for _, tc := range []struct {
+		name   string
+		a      *Advert
+		policy AdvertPolicy
+		status AdvertStatus
+		err    error
+	}{
+		{"SignedRequire", signed, AdvertRequireSigned, AdvertVerified, nil},
+		{"SignedAllowAll", signed, AdvertAllowAll, AdvertVerified, nil},
+		{"UnsignedRequire", unsigned, AdvertRequireSigned, AdvertUnsigned, ErrUnsignedAdvert},
+		{"UnsignedAllow", unsigned, AdvertAllowUnsigned, AdvertUnsigned, nil},
+		{"InvalidRequire", invalid, AdvertRequireSigned, AdvertInvalid, ErrBadSignature},
+		{"InvalidAllowUnsigned", invalid, AdvertAllowUnsigned, AdvertInvalid, ErrBadSignature},
+		{"InvalidAllowAll", invalid, AdvertAllowAll, AdvertInvalid, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, err := tc.a.Check(keys, tc.policy)
+
+			assert.Equal(t, tc.status, status)
+			if tc.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestReceiveAdvertBase(t *testing.T) {
+	a, keys := signedAdvert(t)
+	data, err := a.Encode()
+	assert.NoError(t, err)
+
+	result, status, err := ReceiveAdvert(data, keys, AdvertRequireSigned)
+
+	assert.NoError(t, err)
+	assert.Equal(t, AdvertVerified, status)
+	assert.Equal(t, a.Signature, result.Signature)
+	assert.Equal(t, a.URIs, result.URIs)
+}
+
+func TestReceiveAdvertRejected(t *testing.T) {
+	a := testAdvert()
+	data, err := a.Encode()
+	assert.NoError(t, err)
+
+	result, status, err := ReceiveAdvert(data, Ed25519Keys{}, AdvertRequireSigned)
+
+	assert.ErrorIs(t, err, ErrBadSignature)
+	assert.Equal(t, AdvertInvalid, status)
+	assert.Nil(t, result)
+}
+
+func TestReceiveAdvertDecodeError(t *testing.T) {
+	result, status, err := ReceiveAdvert(nil, Ed25519Keys{}, AdvertRequireSigned)
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Equal(t, AdvertInvalid, status)
+	assert.Nil(t, result)
+}
@@ -14,9 +14,9 @@
 
 package proto
 
-import "fmt"
-
-// Constants used in the binary encoding of Header.
+// Constants used in the binary encoding of Header.  MaxMajor is the
+// highest major version of the wire format implemented by this
+// package; see RegisterMajorCodec.
 const (
 	HeaderSize int   = 4
 	MaxMajor   uint8 = 0
@@ -36,7 +36,8 @@ type Header struct {
 }
 
 // FromBytes is a method of Header that fills in the information from
-// a sequence of 4 bytes.
+// a sequence of 4 bytes.  Major versions with no registered codec are
+// rejected with an error wrapping ErrMaxVersion.
 func (h *Header) FromBytes(data []byte) (int, error) {
 	// Make sure we have enough data
 	if len(data) < HeaderSize {
@@ -45,8 +46,8 @@ func (h *Header) FromBytes(data []byte) (int, error) {
 
 	// Check the version
 	vers := (data[0] & MajorMask) >> MajorShift
-	if vers > MaxMajor {
-		return 0, fmt.Errorf("%d: %w", vers, ErrMaxVersion)
+	if _, err := lookupMajorCodec(vers); err != nil {
+		return 0, err
 	}
 
 	// Fill in the header
@@ -63,8 +64,8 @@ func (h *Header) FromBytes(data []byte) (int, error) {
 // sequence of 4 bytes.  The byte slice to fill in must be passed in.
 func (h *Header) ToBytes(data []byte) (int, error) {
 	// Make sure the version makes sense
-	if h.Major > MaxMajor {
-		return 0, fmt.Errorf("%d: %w", h.Major, ErrMaxVersion)
+	if _, err := lookupMajorCodec(h.Major); err != nil {
+		return 0, err
 	}
 
 	// Make sure we have enough space
@@ -131,6 +132,7 @@ func (h *ExtHeader) ToBytes(data []byte) (int, error) {
 	}
 
 	// Fill in the data
+	data[0] = 0
 	if h.Ignore {
 		data[0] |= IgnoreBit
 	}
@@ -194,6 +194,19 @@ func TestExtHeaderToBytesBase(t *testing.T) {
 	}, buf)
 }
 
+func TestExtHeaderToBytesReused(t *testing.T) {
+	obj := &ExtHeader{
+		Protocol: 0x17,
+	}
+	buf := []byte{0xff, 0xff, 0xff, 0xff}
+
+	result, err := obj.ToBytes(buf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ExtHeaderSize, result)
+	assert.Equal(t, []byte{0x00, 0x17, 0x00, 0x00}, buf)
+}
+
 func TestExtHeaderToBytesSmall(t *testing.T) {
 	obj := &ExtHeader{
 		Ignore:   true,
@@ -0,0 +1,133 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import "encoding/binary"
+
+// ExtChannel is the extension protocol number of the channel
+// extension, which identifies the channel of a conduit multiplexing
+// several independent channels that a PDU belongs to.  The extension
+// applies to a single conduit, and a PDU carrying it must not be
+// processed by a node that does not understand it, since the PDU
+// would be taken out of the context of its channel.
+const ExtChannel uint8 = 0x82
+
+// ChannelSize is the size of the data of a channel extension.
+const ChannelSize = 9
+
+// Channel extension flags.  PDUs carrying any of these flags are
+// channel control PDUs, and their payloads are ignored.
+const (
+	ChannelOpen   uint8 = 0x01 // Opens the channel; Window is the sender's receive window
+	ChannelClose  uint8 = 0x02 // The sender will send no more PDUs on the channel
+	ChannelWindow uint8 = 0x04 // Window is credit granted to the receiver, in bytes
+)
+
+// Channel describes the channel extension.
+type Channel struct {
+	ID     uint32 // Identifier of the channel
+	Flags  uint8  // Channel extension flags
+	Window uint32 // Window size or credit, if a flag calls for it
+}
+
+// FromBytes is a method of Channel that fills in the information from
+// the data of a channel extension.
+func (ch *Channel) FromBytes(data []byte) (int, error) {
+	// Make sure we have enough data
+	if len(data) < ChannelSize {
+		return 0, ErrShortInput
+	}
+
+	// Fill in the extension
+	ch.ID = binary.BigEndian.Uint32(data)
+	ch.Flags = data[4]
+	ch.Window = binary.BigEndian.Uint32(data[5:])
+
+	return ChannelSize, nil
+}
+
+// ToBytes is a method of Channel that encodes the extension data into
+// a sequence of bytes.  The byte slice to fill in must be passed in.
+func (ch *Channel) ToBytes(data []byte) (int, error) {
+	// Make sure we have enough space
+	if len(data) < ChannelSize {
+		return 0, ErrShortOutput
+	}
+
+	// Fill in the data
+	binary.BigEndian.PutUint32(data, ch.ID)
+	data[4] = ch.Flags
+	binary.BigEndian.PutUint32(data[5:], ch.Window)
+
+	return ChannelSize, nil
+}
+
+// Extension constructs the channel extension.  The extension applies
+// to a single hop, and the conduit must be closed by nodes that do
+// not understand it.
+func (ch *Channel) Extension() *Extension {
+	data := make([]byte, ChannelSize)
+	ch.ToBytes(data) //nolint:errcheck
+
+	return &Extension{
+		Number: ExtChannel,
+		Header: ExtHeader{Close: true, HopByHop: true},
+		Data:   data,
+	}
+}
+
+// Frame constructs a channel control PDU carrying the channel
+// extension, with no payload.
+func (ch *Channel) Frame() *Frame {
+	return AddChannel(&Frame{Header: Header{Protocol: ProtoControl}}, ch)
+}
+
+// AddChannel returns a copy of the frame with a channel extension
+// placed at the beginning of the extension chain.  The passed-in
+// frame is not modified.
+func AddChannel(f *Frame, ch *Channel) *Frame {
+	ext := ch.Extension()
+	ext.Header.Protocol = f.Header.Protocol
+
+	result := *f
+	result.Header.Protocol = ExtChannel
+	result.Extensions = append(Extensions{ext}, f.Extensions...)
+
+	return &result
+}
+
+// StripChannel decodes the channel extension of a frame, returning it
+// and a copy of the frame with the extension removed.  An error
+// wrapping ErrNoChannel is returned if the frame has no channel
+// extension.
+func StripChannel(f *Frame) (*Frame, *Channel, error) {
+	idx := -1
+	for i, ext := range f.Extensions {
+		if ext.Number == ExtChannel {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, nil, ErrNoChannel
+	}
+
+	ch := &Channel{}
+	if _, err := ch.FromBytes(f.Extensions[idx].Data); err != nil {
+		return nil, nil, err
+	}
+
+	return removeExtension(f, idx), ch, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelFromBytesBase(t *testing.T) {
+	obj := &Channel{}
+
+	n, err := obj.FromBytes([]byte{0x00, 0x00, 0x00, 0x05, 0x04, 0x00, 0x01, 0x00, 0x00, 0xff})
+
+	assert.NoError(t, err)
+	assert.Equal(t, ChannelSize, n)
+	assert.Equal(t, &Channel{ID: 5, Flags: ChannelWindow, Window: 65536}, obj)
+}
+
+func TestChannelFromBytesShort(t *testing.T) {
+	obj := &Channel{}
+
+	n, err := obj.FromBytes([]byte{0x00, 0x00, 0x00, 0x05})
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Equal(t, 0, n)
+}
+
+func TestChannelToBytesBase(t *testing.T) {
+	obj := &Channel{ID: 5, Flags: ChannelOpen, Window: 256}
+	data := make([]byte, ChannelSize)
+
+	n, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ChannelSize, n)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x05, 0x01, 0x00, 0x00, 0x01, 0x00}, data)
+}
+
+func TestChannelToBytesShort(t *testing.T) {
+	obj := &Channel{ID: 5}
+
+	n, err := obj.ToBytes(make([]byte, 4))
+
+	assert.ErrorIs(t, err, ErrShortOutput)
+	assert.Equal(t, 0, n)
+}
+
+func TestChannelExtension(t *testing.T) {
+	obj := &Channel{ID: 5}
+
+	result := obj.Extension()
+
+	assert.Equal(t, &Extension{
+		Number: ExtChannel,
+		Header: ExtHeader{Close: true, HopByHop: true},
+		Data:   []byte{0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00},
+	}, result)
+}
+
+func TestChannelFrame(t *testing.T) {
+	obj := &Channel{ID: 5, Flags: ChannelClose}
+
+	result := obj.Frame()
+
+	assert.Equal(t, ExtChannel, result.Header.Protocol)
+	require.Len(t, result.Extensions, 1)
+	assert.Equal(t, ProtoControl, result.Extensions[0].Header.Protocol)
+	assert.Empty(t, result.Payload)
+}
+
+func TestAddChannel(t *testing.T) {
+	f := &Frame{
+		Header:     Header{Protocol: 0x83},
+		Extensions: Extensions{{Number: 0x83, Header: ExtHeader{Protocol: 0x17}}},
+		Payload:    []byte("abc"),
+	}
+
+	result := AddChannel(f, &Channel{ID: 5})
+
+	assert.Equal(t, ExtChannel, result.Header.Protocol)
+	require.Len(t, result.Extensions, 2)
+	assert.Equal(t, uint8(0x83), result.Extensions[0].Header.Protocol)
+	assert.Equal(t, uint8(0x83), f.Header.Protocol)
+	assert.Len(t, f.Extensions, 1)
+}
+
+func TestStripChannelRoundTrip(t *testing.T) {
+	f := &Frame{Header: Header{Protocol: 0x17}, Payload: []byte("abc")}
+	wire := AddChannel(f, &Channel{ID: 5})
+	data := make([]byte, wire.Size())
+	_, err := wire.ToBytes(data)
+	require.NoError(t, err)
+	parsed, err := Decode(data)
+	require.NoError(t, err)
+
+	result, ch, err := StripChannel(parsed)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Channel{ID: 5}, ch)
+	assert.Equal(t, uint8(0x17), result.Header.Protocol)
+	assert.Empty(t, result.Extensions)
+	assert.Equal(t, []byte("abc"), result.Payload)
+}
+
+func TestStripChannelMissing(t *testing.T) {
+	result, ch, err := StripChannel(&Frame{Header: Header{Protocol: 0x17}})
+
+	assert.ErrorIs(t, err, ErrNoChannel)
+	assert.Nil(t, result)
+	assert.Nil(t, ch)
+}
+
+func TestStripChannelShort(t *testing.T) {
+	f := &Frame{
+		Header:     Header{Protocol: ExtChannel},
+		Extensions: Extensions{{Number: ExtChannel, Header: ExtHeader{Protocol: 0x17}, Data: []byte{0x00}}},
+	}
+
+	result, ch, err := StripChannel(f)
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+	assert.Nil(t, ch)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+// ExtChecksum is the extension protocol number of the checksum
+// extension, which carries a checksum of the payload of a PDU sent
+// over a conduit that is not integrity-protected.  The extension is
+// added and verified by the sending and receiving ends of each
+// conduit, and is never forwarded.
+const ExtChecksum uint8 = 0x81
+
+// Checksum algorithms.
+const (
+	ChecksumCRC32C  uint8 = 1 // CRC-32C (Castagnoli)
+	ChecksumBLAKE2s uint8 = 2 // BLAKE2s-256
+)
+
+// crc32c is the table for computing CRC-32C checksums.
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum describes the checksum extension.
+type Checksum struct {
+	Algorithm uint8  // The checksum algorithm
+	Digest    []byte // The checksum of the payload
+}
+
+// digestSize returns the size of the digest computed by a checksum
+// algorithm, or 0 if the algorithm is not known.
+func digestSize(alg uint8) int {
+	switch alg {
+	case ChecksumCRC32C:
+		return crc32.Size
+	case ChecksumBLAKE2s:
+		return blake2s.Size
+	}
+
+	return 0
+}
+
+// ComputeChecksum computes the checksum of a payload using the
+// specified algorithm.  An error wrapping ErrUnknownChecksum is
+// returned if the algorithm is not known.
+func ComputeChecksum(alg uint8, payload []byte) (*Checksum, error) {
+	ck := &Checksum{Algorithm: alg}
+	switch alg {
+	case ChecksumCRC32C:
+		ck.Digest = binary.BigEndian.AppendUint32(nil, crc32.Checksum(payload, crc32c))
+	case ChecksumBLAKE2s:
+		sum := blake2s.Sum256(payload)
+		ck.Digest = sum[:]
+	default:
+		return nil, fmt.Errorf("algorithm %d: %w", alg, ErrUnknownChecksum)
+	}
+
+	return ck, nil
+}
+
+// FromBytes is a method of Checksum that fills in the information
+// from the data of a checksum extension.  The digest refers to the
+// passed-in data.
+func (ck *Checksum) FromBytes(data []byte) (int, error) {
+	// Make sure we have enough data
+	if len(data) < 1 {
+		return 0, ErrShortInput
+	}
+	size := digestSize(data[0])
+	if size == 0 {
+		return 0, fmt.Errorf("algorithm %d: %w", data[0], ErrUnknownChecksum)
+	}
+	if len(data) < 1+size {
+		return 0, ErrShortInput
+	}
+
+	// Fill in the extension
+	ck.Algorithm = data[0]
+	ck.Digest = data[1 : 1+size]
+
+	return 1 + size, nil
+}
+
+// ToBytes is a method of Checksum that encodes the extension data
+// into a sequence of bytes.  The byte slice to fill in must be passed
+// in.
+func (ck *Checksum) ToBytes(data []byte) (int, error) {
+	// Make sure we have enough space
+	if len(data) < 1+len(ck.Digest) {
+		return 0, ErrShortOutput
+	}
+
+	// Fill in the data
+	data[0] = ck.Algorithm
+	copy(data[1:], ck.Digest)
+
+	return 1 + len(ck.Digest), nil
+}
+
+// Verify verifies that the checksum matches the payload, returning an
+// error wrapping ErrBadChecksum if it does not.
+func (ck *Checksum) Verify(payload []byte) error {
+	expected, err := ComputeChecksum(ck.Algorithm, payload)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expected.Digest, ck.Digest) {
+		return fmt.Errorf("algorithm %d: %w", ck.Algorithm, ErrBadChecksum)
+	}
+
+	return nil
+}
+
+// Extension constructs the checksum extension.  The extension applies
+// to a single hop, and may be ignored by nodes that do not understand
+// it.
+func (ck *Checksum) Extension() *Extension {
+	data := make([]byte, 1+len(ck.Digest))
+	ck.ToBytes(data) //nolint:errcheck
+
+	return &Extension{
+		Number: ExtChecksum,
+		Header: ExtHeader{Ignore: true, HopByHop: true},
+		Data:   data,
+	}
+}
+
+// AddChecksum returns a copy of the frame with a checksum extension
+// for its payload, computed using the specified algorithm, placed at
+// the beginning of the extension chain.  The passed-in frame is not
+// modified.
+func AddChecksum(f *Frame, alg uint8) (*Frame, error) {
+	ck, err := ComputeChecksum(alg, f.Payload)
+	if err != nil {
+		return nil, err
+	}
+	ext := ck.Extension()
+	ext.Header.Protocol = f.Header.Protocol
+
+	result := *f
+	result.Header.Protocol = ExtChecksum
+	result.Extensions = append(Extensions{ext}, f.Extensions...)
+
+	return &result, nil
+}
+
+// VerifyChecksum verifies the checksum extension of a frame, if it
+// has one, returning a copy of the frame with the extension removed.
+// Frames without a checksum extension are returned as is.  An error
+// wrapping ErrBadChecksum is returned if the checksum does not match
+// the payload; an error wrapping ErrUnknownChecksum is returned if the
+// algorithm is not known.
+func VerifyChecksum(f *Frame) (*Frame, error) {
+	idx := -1
+	for i, ext := range f.Extensions {
+		if ext.Number == ExtChecksum {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return f, nil
+	}
+
+	// Verify the checksum
+	ck := &Checksum{}
+	if _, err := ck.FromBytes(f.Extensions[idx].Data); err != nil {
+		return nil, err
+	}
+	if err := ck.Verify(f.Payload); err != nil {
+		return nil, err
+	}
+
+	return removeExtension(f, idx), nil
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2s"
+)
+
+func TestComputeChecksumCRC32C(t *testing.T) {
+	result, err := ComputeChecksum(ChecksumCRC32C, []byte("abc"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Checksum{
+		Algorithm: ChecksumCRC32C,
+		Digest:    []byte{0x36, 0x4b, 0x3f, 0xb7},
+	}, result)
+}
+
+func TestComputeChecksumBLAKE2s(t *testing.T) {
+	sum := blake2s.Sum256([]byte("abc"))
+
+	result, err := ComputeChecksum(ChecksumBLAKE2s, []byte("abc"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Checksum{Algorithm: ChecksumBLAKE2s, Digest: sum[:]}, result)
+}
+
+func TestComputeChecksumUnknown(t *testing.T) {
+	result, err := ComputeChecksum(0x7f, []byte("abc"))
+
+	assert.ErrorIs(t, err, ErrUnknownChecksum)
+	assert.Nil(t, result)
+}
+
+func TestChecksumFromBytesBase(t *testing.T) {
+	obj := &Checksum{}
+
+	n, err := obj.FromBytes([]byte{0x01, 0x36, 0x4b, 0x3f, 0xb7, 0xff})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, &Checksum{
+		Algorithm: ChecksumCRC32C,
+		Digest:    []byte{0x36, 0x4b, 0x3f, 0xb7},
+	}, obj)
+}
+
+func TestChecksumFromBytesEmpty(t *testing.T) {
+	obj := &Checksum{}
+
+	n, err := obj.FromBytes([]byte{})
+
+	assert.Same(t, ErrShortInput, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestChecksumFromBytesUnknown(t *testing.T) {
+	obj := &Checksum{}
+
+	n, err := obj.FromBytes([]byte{0x7f, 0x00})
+
+	assert.ErrorIs(t, err, ErrUnknownChecksum)
+	assert.Equal(t, 0, n)
+}
+
+func TestChecksumFromBytesShort(t *testing.T) {
+	obj := &Checksum{}
+
+	n, err := obj.FromBytes([]byte{0x01, 0x36, 0x4b, 0x3f})
+
+	assert.Same(t, ErrShortInput, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, &Checksum{}, obj)
+}
+
+func TestChecksumToBytesBase(t *testing.T) {
+	obj := &Checksum{Algorithm: ChecksumCRC32C, Digest: []byte{0x36, 0x4b, 0x3f, 0xb7}}
+	data := make([]byte, 5)
+
+	n, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte{0x01, 0x36, 0x4b, 0x3f, 0xb7}, data)
+}
+
+func TestChecksumToBytesShort(t *testing.T) {
+	obj := &Checksum{Algorithm: ChecksumCRC32C, Digest: []byte{0x36, 0x4b, 0x3f, 0xb7}}
+	data := make([]byte, 4)
+
+	n, err := obj.ToBytes(data)
+
+	assert.Same(t, ErrShortOutput, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestChecksumVerifyBase(t *testing.T) {
+	obj := &Checksum{Algorithm: ChecksumCRC32C, Digest: []byte{0x36, 0x4b, 0x3f, 0xb7}}
+
+	err := obj.Verify([]byte("abc"))
+
+	assert.NoError(t, err)
+}
+
+func TestChecksumVerifyMismatch(t *testing.T) {
+	obj := &Checksum{Algorithm: ChecksumCRC32C, Digest: []byte{0x36, 0x4b, 0x3f, 0xb7}}
+
+	err := obj.Verify([]byte("abd"))
+
+	assert.ErrorIs(t, err, ErrBadChecksum)
+}
+
+func TestChecksumVerifyUnknown(t *testing.T) {
+	obj := &Checksum{Algorithm: 0x7f}
+
+	err := obj.Verify([]byte("abc"))
+
+	assert.ErrorIs(t, err, ErrUnknownChecksum)
+}
+
+func TestChecksumExtension(t *testing.T) {
+	obj := &Checksum{Algorithm: ChecksumCRC32C, Digest: []byte{0x36, 0x4b, 0x3f, 0xb7}}
+
+	result := obj.Extension()
+
+	assert.Equal(t, &Extension{
+		Number: ExtChecksum,
+		Header: ExtHeader{Ignore: true, HopByHop: true},
+		Data:   []byte{0x01, 0x36, 0x4b, 0x3f, 0xb7},
+	}, result)
+}
+
+func TestAddChecksumBase(t *testing.T) {
+	ext := &Extension{Number: 0x82, Header: ExtHeader{Protocol: 0x17}}
+	f := &Frame{
+		Header:     Header{Protocol: 0x82},
+		Extensions: Extensions{ext},
+		Payload:    []byte("abc"),
+	}
+
+	result, err := AddChecksum(f, ChecksumCRC32C)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header: Header{Protocol: ExtChecksum},
+		Extensions: Extensions{
+			{
+				Number: ExtChecksum,
+				Header: ExtHeader{Ignore: true, HopByHop: true, Protocol: 0x82},
+				Data:   []byte{0x01, 0x36, 0x4b, 0x3f, 0xb7},
+			},
+			ext,
+		},
+		Payload: []byte("abc"),
+	}, result)
+	assert.Equal(t, uint8(0x82), f.Header.Protocol)
+	assert.Equal(t, Extensions{ext}, f.Extensions)
+}
+
+func TestAddChecksumUnknown(t *testing.T) {
+	result, err := AddChecksum(&Frame{}, 0x7f)
+
+	assert.ErrorIs(t, err, ErrUnknownChecksum)
+	assert.Nil(t, result)
+}
+
+func TestVerifyChecksumNone(t *testing.T) {
+	f := &Frame{Header: Header{Protocol: 0x17}, Payload: []byte("abc")}
+
+	result, err := VerifyChecksum(f)
+
+	assert.NoError(t, err)
+	assert.Same(t, f, result)
+}
+
+func TestVerifyChecksumFirst(t *testing.T) {
+	ext := &Extension{Number: 0x82, Header: ExtHeader{Protocol: 0x17}}
+	f, err := AddChecksum(&Frame{
+		Header:     Header{Protocol: 0x82},
+		Extensions: Extensions{ext},
+		Payload:    []byte("abc"),
+	}, ChecksumBLAKE2s)
+	require.NoError(t, err)
+
+	result, err := VerifyChecksum(f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:     Header{Protocol: 0x82},
+		Extensions: Extensions{ext},
+		Payload:    []byte("abc"),
+	}, result)
+}
+
+func TestVerifyChecksumLater(t *testing.T) {
+	ext := &Extension{Number: 0x82, Header: ExtHeader{Protocol: ExtChecksum}}
+	ck := &Extension{
+		Number: ExtChecksum,
+		Header: ExtHeader{Ignore: true, HopByHop: true, Protocol: 0x17},
+		Data:   []byte{0x01, 0x36, 0x4b, 0x3f, 0xb7},
+	}
+	f := &Frame{
+		Header:     Header{Protocol: 0x82},
+		Extensions: Extensions{ext, ck},
+		Payload:    []byte("abc"),
+	}
+
+	result, err := VerifyChecksum(f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:     Header{Protocol: 0x82},
+		Extensions: Extensions{{Number: 0x82, Header: ExtHeader{Protocol: 0x17}}},
+		Payload:    []byte("abc"),
+	}, result)
+	assert.Equal(t, ExtChecksum, ext.Header.Protocol)
+	assert.Equal(t, uint8(0x17), result.Protocol())
+}
+
+func TestVerifyChecksumMismatch(t *testing.T) {
+	f, err := AddChecksum(&Frame{Header: Header{Protocol: 0x17}, Payload: []byte("abc")}, ChecksumCRC32C)
+	require.NoError(t, err)
+	f.Payload = []byte("abd")
+
+	result, err := VerifyChecksum(f)
+
+	assert.ErrorIs(t, err, ErrBadChecksum)
+	assert.Nil(t, result)
+}
+
+func TestVerifyChecksumMalformed(t *testing.T) {
+	f := &Frame{
+		Header: Header{Protocol: ExtChecksum},
+		Extensions: Extensions{
+			{Number: ExtChecksum, Header: ExtHeader{Protocol: 0x17}, Data: []byte{0x01}},
+		},
+	}
+
+	result, err := VerifyChecksum(f)
+
+	assert.Same(t, ErrShortInput, err)
+	assert.Nil(t, result)
+}
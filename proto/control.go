@@ -0,0 +1,107 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+// ProtoControl is the protocol number of the control protocol, which
+// carries messages concerning the conduit itself rather than
+// application traffic.
+const ProtoControl uint8 = 0
+
+// ControlHeaderSize is the size of the control message header.
+const ControlHeaderSize int = 1
+
+// ControlType identifies the type of a control protocol message.
+type ControlType uint8
+
+// Control protocol message types.
+const (
+	ControlDrain      ControlType = 0x01 // Request to stop sending new traffic
+	ControlDrainAck   ControlType = 0x02 // Acknowledgment of a drain request
+	ControlHello      ControlType = 0x03 // Protocol version negotiation
+	ControlPing       ControlType = 0x04 // Echo request
+	ControlPong       ControlType = 0x05 // Echo reply
+	ControlBind       ControlType = 0x06 // Channel binding proof
+	ControlClose      ControlType = 0x07 // Notice that the conduit is closing
+	ControlSub        ControlType = 0x08 // Client subscription to a data protocol
+	ControlUnsub      ControlType = 0x09 // Client cancellation of a subscription
+	ControlKeepalive  ControlType = 0x0a // Carrier-level keepalive
+	ControlProbe      ControlType = 0x0b // Bandwidth probe, sent in pairs
+	ControlProbeAck   ControlType = 0x0c // Dispersion of a pair of bandwidth probes
+	ControlTopicSub   ControlType = 0x0d // Client subscription to a topic
+	ControlTopicUnsub ControlType = 0x0e // Client cancellation of a topic subscription
+)
+
+// ControlMessage describes a control protocol message, carried as the
+// payload of a PDU with protocol ProtoControl.
+type ControlMessage struct {
+	Type ControlType // The type of the message
+	Body []byte      // The body of the message
+}
+
+// FromBytes is a method of ControlMessage that fills in the
+// information from the payload of a control protocol PDU.  The body
+// refers to the passed-in data.
+func (m *ControlMessage) FromBytes(data []byte) (int, error) {
+	// Make sure we have enough data
+	if len(data) < ControlHeaderSize {
+		return 0, ErrShortInput
+	}
+
+	// Fill in the message
+	m.Type = ControlType(data[0])
+	m.Body = data[ControlHeaderSize:]
+
+	return len(data), nil
+}
+
+// ToBytes is a method of ControlMessage that encodes the message into
+// a sequence of bytes.  The byte slice to fill in must be passed in.
+func (m *ControlMessage) ToBytes(data []byte) (int, error) {
+	// Make sure we have enough space
+	if len(data) < ControlHeaderSize+len(m.Body) {
+		return 0, ErrShortOutput
+	}
+
+	// Fill in the data
+	data[0] = uint8(m.Type)
+	n := copy(data[ControlHeaderSize:], m.Body)
+
+	return ControlHeaderSize + n, nil
+}
+
+// Frame constructs a frame carrying the control message.
+func (m *ControlMessage) Frame() *Frame {
+	payload := make([]byte, ControlHeaderSize+len(m.Body))
+	m.ToBytes(payload) //nolint:errcheck
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoControl,
+		},
+		Payload: payload,
+	}
+}
+
+// CloseFrame constructs the frame sent to notify the peer that the
+// conduit is being closed: a ControlClose message with the Error bit
+// set, whose body is the reason for closing the conduit.  The reason
+// may be empty if the conduit is being closed normally.
+func CloseFrame(reason string) *Frame {
+	msg := &ControlMessage{Type: ControlClose, Body: []byte(reason)}
+	f := msg.Frame()
+	f.Header.Error = true
+
+	return f
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlMessageFromBytesBase(t *testing.T) {
+	obj := &ControlMessage{}
+
+	result, err := obj.FromBytes([]byte{0x01, 'b', 'o', 'd', 'y'})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	assert.Equal(t, &ControlMessage{
+		Type: ControlDrain,
+		Body: []byte("body"),
+	}, obj)
+}
+
+func TestControlMessageFromBytesShort(t *testing.T) {
+	obj := &ControlMessage{}
+
+	result, err := obj.FromBytes([]byte{})
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, &ControlMessage{}, obj)
+}
+
+func TestControlMessageToBytesBase(t *testing.T) {
+	obj := &ControlMessage{
+		Type: ControlDrainAck,
+		Body: []byte("body"),
+	}
+	data := make([]byte, 6)
+
+	result, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result)
+	assert.Equal(t, []byte{0x02, 'b', 'o', 'd', 'y', 0x00}, data)
+}
+
+func TestControlMessageToBytesShort(t *testing.T) {
+	obj := &ControlMessage{
+		Type: ControlDrainAck,
+		Body: []byte("body"),
+	}
+	data := make([]byte, 4)
+
+	result, err := obj.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrShortOutput)
+	assert.Equal(t, 0, result)
+}
+
+func TestControlMessageFrame(t *testing.T) {
+	obj := &ControlMessage{
+		Type: ControlDrain,
+		Body: []byte("body"),
+	}
+
+	result := obj.Frame()
+
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Protocol: ProtoControl,
+		},
+		Payload: []byte{0x01, 'b', 'o', 'd', 'y'},
+	}, result)
+}
+
+func TestCloseFrame(t *testing.T) {
+	result := CloseFrame("bye")
+
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Error:    true,
+			Protocol: ProtoControl,
+		},
+		Payload: []byte{0x07, 'b', 'y', 'e'},
+	}, result)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import "fmt"
+
+// ProtoData is the protocol number of the data protocol, which
+// carries application payloads addressed to nodes.  Clients, which
+// are not nodes of the overlay, send data messages to the node they
+// are connected to, which delivers or forwards them.
+const ProtoData uint8 = 3
+
+// DataHeaderSize is the size of the header of a data message: the
+// destination and source node identifiers, the hop limit, and the
+// application protocol number.
+const DataHeaderSize int = 2*NodeIDSize + 2
+
+// DefaultHopLimit is the hop limit given to data messages which do
+// not specify one.
+const DefaultHopLimit uint8 = 32
+
+// Data is a data message, carrying an application payload.  The
+// source of a message sent by a client is the zero node ID; the node
+// the client is connected to fills in its own.  Each node forwarding
+// the message decrements the hop limit, and the message is discarded
+// when it reaches zero, so that messages caught in transient routing
+// loops do not circulate indefinitely.  The Reply and Error flags are
+// carried in the carrier header rather than the message itself; see
+// ExtRequest.
+type Data struct {
+	Dest     NodeID // Identifier of the destination node
+	Source   NodeID // Identifier of the originating node
+	HopLimit uint8  // Remaining number of hops
+	Protocol uint8  // Application protocol of the payload
+	Payload  []byte // The application payload
+	Reply    bool   // Message is a reply to a request
+	Error    bool   // Reply reports the failure of the request
+}
+
+// Encode encodes the data message.  The message must fit in the
+// payload of a single PDU.
+func (d *Data) Encode() ([]byte, error) {
+	if DataHeaderSize+len(d.Payload) > MaxLength {
+		return nil, fmt.Errorf("data for %s: %w", d.Dest, ErrTooLong)
+	}
+
+	data := make([]byte, 0, DataHeaderSize+len(d.Payload))
+	data = append(data, d.Dest[:]...)
+	data = append(data, d.Source[:]...)
+	data = append(data, d.HopLimit, d.Protocol)
+
+	return append(data, d.Payload...), nil
+}
+
+// DecodeData decodes a data message.  The payload refers to the
+// passed-in data.
+func DecodeData(data []byte) (*Data, error) {
+	if len(data) < DataHeaderSize {
+		return nil, ErrShortInput
+	}
+	d := &Data{
+		HopLimit: data[2*NodeIDSize],
+		Protocol: data[2*NodeIDSize+1],
+		Payload:  data[DataHeaderSize:],
+	}
+	copy(d.Dest[:], data)
+	copy(d.Source[:], data[NodeIDSize:])
+
+	return d, nil
+}
+
+// DecodeDataFrame decodes the data message carried by a frame,
+// taking the Reply and Error flags from the carrier header.  The
+// payload refers to the frame's payload.
+func DecodeDataFrame(f *Frame) (*Data, error) {
+	d, err := DecodeData(f.Payload)
+	if err != nil {
+		return nil, err
+	}
+	d.Reply = f.Header.Reply
+	d.Error = f.Header.Error
+
+	return d, nil
+}
+
+// Frame constructs a frame carrying the data message.
+func (d *Data) Frame() (*Frame, error) {
+	payload, err := d.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Reply:    d.Reply,
+			Error:    d.Error,
+			Protocol: ProtoData,
+		},
+		Payload: payload,
+	}, nil
+}
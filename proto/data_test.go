@@ -0,0 +1,125 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDataData = []byte{
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x10, 0x2a,
+	'h', 'e', 'l', 'l', 'o',
+}
+
+func testData() *Data {
+	return &Data{
+		Dest:     NodeID{0x01},
+		Source:   NodeID{0x02},
+		HopLimit: 16,
+		Protocol: 42,
+		Payload:  []byte("hello"),
+	}
+}
+
+func TestDataEncodeBase(t *testing.T) {
+	obj := testData()
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testDataData, result)
+}
+
+func TestDataEncodeTooLong(t *testing.T) {
+	obj := &Data{Payload: make([]byte, MaxLength-DataHeaderSize+1)}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodeDataBase(t *testing.T) {
+	result, err := DecodeData(testDataData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testData(), result)
+}
+
+func TestDecodeDataShort(t *testing.T) {
+	result, err := DecodeData(testDataData[:DataHeaderSize-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeDataFrameBase(t *testing.T) {
+	f := &Frame{Header: Header{Reply: true, Error: true, Protocol: ProtoData}, Payload: testDataData}
+
+	result, err := DecodeDataFrame(f)
+
+	assert.NoError(t, err)
+	expected := testData()
+	expected.Reply = true
+	expected.Error = true
+	assert.Equal(t, expected, result)
+}
+
+func TestDecodeDataFrameShort(t *testing.T) {
+	f := &Frame{Header: Header{Protocol: ProtoData}, Payload: testDataData[:DataHeaderSize-1]}
+
+	result, err := DecodeDataFrame(f)
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDataFrameBase(t *testing.T) {
+	obj := testData()
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoData},
+		Payload: testDataData,
+	}, result)
+}
+
+func TestDataFrameReply(t *testing.T) {
+	obj := testData()
+	obj.Reply = true
+	obj.Error = true
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, Header{Reply: true, Error: true, Protocol: ProtoData}, result.Header)
+}
+
+func TestDataFrameTooLong(t *testing.T) {
+	obj := &Data{Payload: make([]byte, MaxLength)}
+
+	result, err := obj.Frame()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Handler is implemented by handlers of incoming frames for a single
+// protocol number.
+type Handler interface {
+	// HandleFrame handles an incoming frame.
+	HandleFrame(f *Frame) error
+}
+
+// HandlerFunc is an adapter allowing an ordinary function to be used
+// as a Handler.
+type HandlerFunc func(f *Frame) error
+
+// HandleFrame handles an incoming frame by calling the function.
+func (fn HandlerFunc) HandleFrame(f *Frame) error {
+	return fn(f)
+}
+
+// ExtensionHandler is implemented by handlers of a single extension
+// protocol number.
+type ExtensionHandler interface {
+	// HandleExtension handles an extension of an incoming frame.
+	// It is called before the frame is passed to the handler for
+	// its protocol; returning an error rejects the frame.
+	HandleExtension(f *Frame, ext *Extension) error
+}
+
+// ExtensionHandlerFunc is an adapter allowing an ordinary function to
+// be used as an ExtensionHandler.
+type ExtensionHandlerFunc func(f *Frame, ext *Extension) error
+
+// HandleExtension handles an extension by calling the function.
+func (fn ExtensionHandlerFunc) HandleExtension(f *Frame, ext *Extension) error {
+	return fn(f, ext)
+}
+
+// Dispatcher routes incoming frames to the handlers registered for
+// their protocol numbers, first passing each extension in the chain
+// to the handler registered for its extension number.  If a reply
+// cache is set, retransmitted requests are answered from the cache
+// rather than being handled again.  The zero value is ready to use,
+// and has no handlers registered and no reply cache.
+type Dispatcher struct {
+	sync.RWMutex
+
+	Replies *ReplyCache // Remembers replies to requests; nil disables deduplication
+
+	protocols  map[uint8]Handler
+	extensions map[uint8]ExtensionHandler
+}
+
+// Handle registers the handler for a protocol number, replacing any
+// existing handler.  A nil handler removes the registration.
+func (d *Dispatcher) Handle(protocol uint8, h Handler) {
+	d.Lock()
+	defer d.Unlock()
+
+	if h == nil {
+		delete(d.protocols, protocol)
+		return
+	}
+	if d.protocols == nil {
+		d.protocols = map[uint8]Handler{}
+	}
+	d.protocols[protocol] = h
+}
+
+// HandleExtension registers the handler for an extension protocol
+// number, replacing any existing handler.  A nil handler removes the
+// registration.
+func (d *Dispatcher) HandleExtension(number uint8, h ExtensionHandler) {
+	d.Lock()
+	defer d.Unlock()
+
+	if h == nil {
+		delete(d.extensions, number)
+		return
+	}
+	if d.extensions == nil {
+		d.extensions = map[uint8]ExtensionHandler{}
+	}
+	d.extensions[number] = h
+}
+
+// Known reports whether a handler is registered for an extension
+// number.  It is suitable for passing to ParseExtensions.
+func (d *Dispatcher) Known(number uint8) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	_, ok := d.extensions[number]
+	return ok
+}
+
+// lookup retrieves the handlers needed to dispatch a frame.  The
+// handlers for the extensions are returned in the order of the
+// chain, with nil entries for unknown extensions.
+func (d *Dispatcher) lookup(f *Frame) (Handler, []ExtensionHandler) {
+	d.RLock()
+	defer d.RUnlock()
+
+	exts := make([]ExtensionHandler, len(f.Extensions))
+	for i, ext := range f.Extensions {
+		exts[i] = d.extensions[ext.Number]
+	}
+
+	return d.protocols[f.Protocol()], exts
+}
+
+// Dispatch routes an incoming frame to the registered handlers.
+// Unknown extensions with the Ignore flag set are dropped from the
+// frame; any other unknown extension causes the frame to be rejected
+// with an error wrapping ErrUnknownExtension, which also wraps
+// ErrCloseConduit if the Close flag is set.  A frame for a protocol
+// with no registered handler is rejected with an error wrapping
+// ErrUnknownProtocol.  When a frame is rejected, Dispatch returns the
+// error reply to send to the peer, as constructed by ErrorReply; no
+// reply is returned if the conduit must be closed.  Errors returned
+// by the handlers are returned as is, with no reply.
+//
+// If a reply cache is set, requests carrying the request extension
+// are checked against it before the handlers are called.  A
+// duplicate of a request already answered is not handled again;
+// instead, the cached reply is returned, to be resent to the peer.
+// A duplicate of a request still being handled is dropped, and
+// neither a reply nor an error is returned.  If a handler returns an
+// error, the request is forgotten, so that a retransmission of it is
+// handled afresh.  The replies to requests must be passed to Sent to
+// be cached.
+func (d *Dispatcher) Dispatch(f *Frame) (*Frame, error) {
+	h, extHandlers := d.lookup(f)
+	in := f
+	req := FindRequest(f.Extensions)
+
+	// Check the extension chain
+	exts := Extensions{}
+	handlers := []ExtensionHandler{}
+	for i, ext := range f.Extensions {
+		switch {
+		case extHandlers[i] != nil:
+			exts = append(exts, ext)
+			handlers = append(handlers, extHandlers[i])
+		case ext.Header.Ignore:
+			// Drop the extension
+		case ext.Header.Close:
+			return nil, fmt.Errorf("extension %d: %w: %w", ext.Number, ErrUnknownExtension, ErrCloseConduit)
+		default:
+			return ErrorReply(f), fmt.Errorf("extension %d: %w", ext.Number, ErrUnknownExtension)
+		}
+	}
+	if h == nil {
+		return ErrorReply(f), fmt.Errorf("protocol %d: %w", f.Protocol(), ErrUnknownProtocol)
+	}
+	if len(exts) < len(f.Extensions) {
+		// Relink copies of the remaining extensions, so that the
+		// payload protocol is preserved when the last one is dropped
+		protocol := f.Protocol()
+		tmp := *f
+		tmp.Extensions = make(Extensions, len(exts))
+		for i, ext := range exts {
+			cp := *ext
+			tmp.Extensions[i] = &cp
+		}
+		tmp.Header.Protocol = tmp.Extensions.Link(protocol)
+		f = &tmp
+	}
+
+	// Answer retransmitted requests from the reply cache; the
+	// request extension may have been dropped from the frame
+	if d.Replies != nil {
+		if reply, dup, err := d.Replies.Received(in); dup {
+			return reply, err
+		}
+	}
+
+	// Call the handlers
+	err := d.handle(f, h, handlers)
+	if err != nil && d.Replies != nil && req != nil && !f.Header.Reply {
+		d.Replies.Forget(f.Protocol(), req.ID)
+	}
+
+	return nil, err
+}
+
+// handle calls the extension handlers and the protocol handler for a
+// frame.
+func (d *Dispatcher) handle(f *Frame, h Handler, handlers []ExtensionHandler) error {
+	for i, ext := range f.Extensions {
+		if err := handlers[i].HandleExtension(f, ext); err != nil {
+			return err
+		}
+	}
+
+	return h.HandleFrame(f)
+}
+
+// Sent is called with each frame sent in answer to the frames
+// dispatched.  If a reply cache is set, replies carrying the request
+// extension are saved in it, so that they may be resent should the
+// requests they answer be retransmitted; other frames are ignored.
+func (d *Dispatcher) Sent(f *Frame) error {
+	if d.Replies == nil {
+		return nil
+	}
+
+	return d.Replies.Sent(f)
+}
+
+// ErrorReply constructs the error reply sent when a frame is
+// rejected: an empty PDU for the same protocol with the Reply and
+// Error bits set.  Replies are never answered, so nil is returned if
+// the frame is itself a reply.
+func ErrorReply(f *Frame) *Frame {
+	if f.Header.Reply {
+		return nil
+	}
+
+	return &Frame{
+		Header: Header{
+			Reply:    true,
+			Error:    true,
+			Protocol: f.Protocol(),
+		},
+	}
+}
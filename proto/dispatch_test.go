@@ -0,0 +1,430 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records the frames and extensions it handles.
+type recorder struct {
+	frames []*Frame
+	exts   []uint8
+	err    error
+}
+
+func (r *recorder) HandleFrame(f *Frame) error {
+	r.frames = append(r.frames, f)
+	return r.err
+}
+
+func (r *recorder) HandleExtension(f *Frame, ext *Extension) error {
+	r.exts = append(r.exts, ext.Number)
+	return r.err
+}
+
+func TestHandlerFuncHandleFrame(t *testing.T) {
+	f := &Frame{}
+	var called *Frame
+	obj := HandlerFunc(func(frame *Frame) error {
+		called = frame
+		return assert.AnError
+	})
+
+	err := obj.HandleFrame(f)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Same(t, f, called)
+}
+
+func TestExtensionHandlerFuncHandleExtension(t *testing.T) {
+	f := &Frame{}
+	ext := &Extension{}
+	var called *Extension
+	obj := ExtensionHandlerFunc(func(frame *Frame, e *Extension) error {
+		called = e
+		return assert.AnError
+	})
+
+	err := obj.HandleExtension(f, ext)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Same(t, ext, called)
+}
+
+func TestDispatcherHandle(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+
+	obj.Handle(3, h)
+
+	assert.Equal(t, map[uint8]Handler{3: h}, obj.protocols)
+}
+
+func TestDispatcherHandleRemove(t *testing.T) {
+	obj := &Dispatcher{protocols: map[uint8]Handler{3: &recorder{}}}
+
+	obj.Handle(3, nil)
+
+	assert.Empty(t, obj.protocols)
+}
+
+func TestDispatcherHandleExtension(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+
+	obj.HandleExtension(0x81, h)
+
+	assert.Equal(t, map[uint8]ExtensionHandler{0x81: h}, obj.extensions)
+}
+
+func TestDispatcherHandleExtensionRemove(t *testing.T) {
+	obj := &Dispatcher{extensions: map[uint8]ExtensionHandler{0x81: &recorder{}}}
+
+	obj.HandleExtension(0x81, nil)
+
+	assert.Empty(t, obj.extensions)
+}
+
+func TestDispatcherKnown(t *testing.T) {
+	obj := &Dispatcher{extensions: map[uint8]ExtensionHandler{0x81: &recorder{}}}
+
+	assert.True(t, obj.Known(0x81))
+	assert.False(t, obj.Known(0x82))
+}
+
+func TestDispatcherDispatchBase(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	obj.HandleExtension(0x81, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Protocol: 3}},
+		},
+		Payload: []byte("abc"),
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+	assert.Equal(t, []uint8{0x81}, h.exts)
+	assert.Equal(t, []*Frame{f}, h.frames)
+	assert.Same(t, f, h.frames[0])
+}
+
+func TestDispatcherDispatchIgnore(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	obj.HandleExtension(0x82, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Ignore: true, Protocol: 0x82}},
+			{Number: 0x82, Header: ExtHeader{Protocol: 3}},
+		},
+		Payload: []byte("abc"),
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+	assert.Equal(t, []uint8{0x82}, h.exts)
+	assert.Len(t, h.frames, 1)
+	assert.Equal(t, Extensions{f.Extensions[1]}, h.frames[0].Extensions)
+	assert.Len(t, f.Extensions, 2)
+}
+
+func TestDispatcherDispatchUnknownExtension(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Protocol: 3}},
+		},
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.ErrorIs(t, err, ErrUnknownExtension)
+	assert.False(t, errors.Is(err, ErrCloseConduit))
+	assert.Equal(t, &Frame{Header: Header{Reply: true, Error: true, Protocol: 3}}, reply)
+	assert.Empty(t, h.frames)
+}
+
+func TestDispatcherDispatchUnknownExtensionClose(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Close: true, Protocol: 3}},
+		},
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.ErrorIs(t, err, ErrUnknownExtension)
+	assert.ErrorIs(t, err, ErrCloseConduit)
+	assert.Nil(t, reply)
+	assert.Empty(t, h.frames)
+}
+
+func TestDispatcherDispatchUnknownProtocol(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.HandleExtension(0x81, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Protocol: 3}},
+		},
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.ErrorIs(t, err, ErrUnknownProtocol)
+	assert.Equal(t, &Frame{Header: Header{Reply: true, Error: true, Protocol: 3}}, reply)
+	assert.Empty(t, h.exts)
+}
+
+func TestDispatcherDispatchUnknownProtocolReply(t *testing.T) {
+	obj := &Dispatcher{}
+	f := &Frame{Header: Header{Reply: true, Protocol: 3}}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.ErrorIs(t, err, ErrUnknownProtocol)
+	assert.Nil(t, reply)
+}
+
+func TestDispatcherDispatchExtensionError(t *testing.T) {
+	h := &recorder{err: assert.AnError}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	obj.HandleExtension(0x81, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Protocol: 3}},
+		},
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, reply)
+	assert.Empty(t, h.frames)
+}
+
+func TestDispatcherDispatchHandlerError(t *testing.T) {
+	h := &recorder{err: assert.AnError}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	f := &Frame{Header: Header{Protocol: 3}}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, reply)
+}
+
+func TestErrorReplyBase(t *testing.T) {
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Protocol: 0x17}},
+		},
+		Payload: []byte("abc"),
+	}
+
+	result := ErrorReply(f)
+
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Reply:    true,
+			Error:    true,
+			Protocol: 0x17,
+		},
+	}, result)
+}
+
+func TestErrorReplyReply(t *testing.T) {
+	f := &Frame{Header: Header{Reply: true, Protocol: 0x17}}
+
+	result := ErrorReply(f)
+
+	assert.Nil(t, result)
+}
+
+func TestDispatcherDispatchIgnoreLast(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Ignore: true, Protocol: 3}},
+		},
+	}
+
+	reply, err := obj.Dispatch(f)
+
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+	assert.Len(t, h.frames, 1)
+	assert.Equal(t, uint8(3), h.frames[0].Protocol())
+	assert.Equal(t, uint8(0x81), f.Header.Protocol)
+}
+
+// requestFrame constructs a frame carrying the request extension.
+func requestFrame(protocol uint8, id uint32, reply bool) *Frame {
+	f := &Frame{
+		Header:     Header{Reply: reply},
+		Extensions: Extensions{(&Request{ID: id}).Extension()},
+		Payload:    []byte("payload"),
+	}
+	f.Header.Protocol = f.Extensions.Link(protocol)
+
+	return f
+}
+
+func TestDispatcherDispatchDuplicate(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+	obj.Handle(3, h)
+	answer := requestFrame(3, 42, true)
+
+	reply1, err1 := obj.Dispatch(requestFrame(3, 42, false))
+	reply2, err2 := obj.Dispatch(requestFrame(3, 42, false))
+	errSent := obj.Sent(answer)
+	reply3, err3 := obj.Dispatch(requestFrame(3, 42, false))
+
+	assert.NoError(t, err1)
+	assert.Nil(t, reply1)
+	assert.NoError(t, err2)
+	assert.Nil(t, reply2)
+	assert.NoError(t, errSent)
+	assert.NoError(t, err3)
+	assert.Equal(t, answer.Header.Protocol, reply3.Header.Protocol)
+	assert.True(t, reply3.Header.Reply)
+	assert.Equal(t, uint8(3), reply3.Protocol())
+	assert.Equal(t, &Request{ID: 42}, FindRequest(reply3.Extensions))
+	assert.Equal(t, []byte("payload"), reply3.Payload)
+	assert.Len(t, h.frames, 1)
+}
+
+func TestDispatcherDispatchDistinctRequests(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+	obj.Handle(3, h)
+	obj.Handle(4, h)
+
+	_, err1 := obj.Dispatch(requestFrame(3, 42, false))
+	_, err2 := obj.Dispatch(requestFrame(3, 43, false))
+	_, err3 := obj.Dispatch(requestFrame(4, 42, false))
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.NoError(t, err3)
+	assert.Len(t, h.frames, 3)
+}
+
+func TestDispatcherDispatchDuplicateNoCache(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{}
+	obj.Handle(3, h)
+
+	_, err1 := obj.Dispatch(requestFrame(3, 42, false))
+	_, err2 := obj.Dispatch(requestFrame(3, 42, false))
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Len(t, h.frames, 2)
+}
+
+func TestDispatcherDispatchDuplicateReply(t *testing.T) {
+	h := &recorder{}
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+	obj.Handle(3, h)
+
+	_, err1 := obj.Dispatch(requestFrame(3, 42, true))
+	_, err2 := obj.Dispatch(requestFrame(3, 42, true))
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Len(t, h.frames, 2)
+	assert.Equal(t, 0, obj.Replies.Len())
+}
+
+func TestDispatcherDispatchDuplicateAfterError(t *testing.T) {
+	h := &recorder{err: assert.AnError}
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+	obj.Handle(3, h)
+
+	_, err1 := obj.Dispatch(requestFrame(3, 42, false))
+	_, err2 := obj.Dispatch(requestFrame(3, 42, false))
+
+	assert.Same(t, assert.AnError, err1)
+	assert.Same(t, assert.AnError, err2)
+	assert.Len(t, h.frames, 2)
+}
+
+func TestDispatcherSentNotReply(t *testing.T) {
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+
+	err := obj.Sent(requestFrame(3, 42, false))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, obj.Replies.Len())
+}
+
+func TestDispatcherSentNoRequest(t *testing.T) {
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+
+	err := obj.Sent(&Frame{Header: Header{Reply: true, Protocol: 3}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, obj.Replies.Len())
+}
+
+func TestDispatcherSentNoCache(t *testing.T) {
+	obj := &Dispatcher{}
+
+	err := obj.Sent(requestFrame(3, 42, true))
+
+	assert.NoError(t, err)
+}
+
+func TestDispatcherSentTooLong(t *testing.T) {
+	obj := &Dispatcher{Replies: &ReplyCache{}}
+	f := requestFrame(3, 42, true)
+	f.Payload = make([]byte, MaxLength+1)
+
+	err := obj.Sent(f)
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Equal(t, 0, obj.Replies.Len())
+}
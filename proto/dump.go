@@ -0,0 +1,181 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// protocolNames is a registry of the names of protocols and
+// extensions, indexed by protocol number.
+var (
+	protocolNames = map[uint8]string{
+		ProtoControl:    "control",
+		ProtoLinkState:  "linkstate",
+		ProtoGossip:     "gossip",
+		ProtoData:       "data",
+		ProtoRendezvous: "rendezvous",
+		ProtoRelay:      "relay",
+		ProtoPubSub:     "pubsub",
+		ExtFlood:        "flood",
+		ExtChecksum:     "checksum",
+		ExtChannel:      "channel",
+		ExtRequest:      "request",
+	}
+	protocolLock sync.RWMutex
+)
+
+// RegisterProtocolName registers the name of a protocol or extension,
+// used when formatting PDUs.
+func RegisterProtocolName(protocol uint8, name string) {
+	protocolLock.Lock()
+	defer protocolLock.Unlock()
+
+	protocolNames[protocol] = name
+}
+
+// ProtocolName returns the name of a protocol or extension, followed
+// by its number.  The number is given in hexadecimal for extensions.
+// Protocols with no registered name are named "unknown".
+func ProtocolName(protocol uint8) string {
+	protocolLock.RLock()
+	name, ok := protocolNames[protocol]
+	protocolLock.RUnlock()
+	if !ok {
+		name = "unknown"
+	}
+
+	if IsExtension(protocol) {
+		return fmt.Sprintf("%s(%#02x)", name, protocol)
+	}
+	return fmt.Sprintf("%s(%d)", name, protocol)
+}
+
+// flagString formats a set of flags as the names of those that are
+// set, joined by "|", or "-" if none is set.
+func flagString(names []string, set ...bool) string {
+	result := []string{}
+	for i, name := range names {
+		if set[i] {
+			result = append(result, name)
+		}
+	}
+	if len(result) == 0 {
+		return "-"
+	}
+
+	return strings.Join(result, "|")
+}
+
+// String returns a description of the header.
+func (h Header) String() string {
+	return fmt.Sprintf("major=%d protocol=%s length=%d flags=%s",
+		h.Major, ProtocolName(h.Protocol), h.Length,
+		flagString([]string{"reply", "error"}, h.Reply, h.Error))
+}
+
+// String returns a description of the extension header.
+func (h ExtHeader) String() string {
+	return fmt.Sprintf("protocol=%s length=%d flags=%s",
+		ProtocolName(h.Protocol), h.Length,
+		flagString([]string{"ignore", "close", "hop-by-hop"}, h.Ignore, h.Close, h.HopByHop))
+}
+
+// String returns a one-line description of the frame: the chain of
+// extensions leading to the protocol of the payload, the flags of the
+// header, and the size of the payload.
+func (f *Frame) String() string {
+	chain := []string{ProtocolName(f.Header.Protocol)}
+	for _, ext := range f.Extensions {
+		chain = append(chain, ProtocolName(ext.Header.Protocol))
+	}
+
+	return fmt.Sprintf("%s flags=%s payload=%d",
+		strings.Join(chain, " -> "),
+		flagString([]string{"reply", "error"}, f.Header.Reply, f.Header.Error),
+		len(f.Payload))
+}
+
+// extensionDetail describes the data of an extension known to this
+// package, returning an empty string for other extensions or for
+// data that cannot be decoded.
+func extensionDetail(ext *Extension) string {
+	switch ext.Number {
+	case ExtFlood:
+		fl := &Flood{}
+		if _, err := fl.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("origin=%s sequence=%d ttl=%d", fl.Origin, fl.Sequence, fl.TTL)
+		}
+	case ExtChecksum:
+		ck := &Checksum{}
+		if _, err := ck.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("algorithm=%d digest=%x", ck.Algorithm, ck.Digest)
+		}
+	case ExtChannel:
+		ch := &Channel{}
+		if _, err := ch.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("id=%d flags=%s window=%d", ch.ID,
+				flagString([]string{"open", "close", "window"},
+					ch.Flags&ChannelOpen != 0,
+					ch.Flags&ChannelClose != 0,
+					ch.Flags&ChannelWindow != 0),
+				ch.Window)
+		}
+	case ExtRequest:
+		r := &Request{}
+		if _, err := r.FromBytes(ext.Data); err == nil {
+			return fmt.Sprintf("id=%d", r.ID)
+		}
+	}
+
+	return ""
+}
+
+// indent indents each line of a block of text.
+func indent(buf *strings.Builder, text string) {
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n") {
+		buf.WriteString("    ")
+		buf.WriteString(line)
+	}
+	buf.WriteString("\n")
+}
+
+// Dump renders a frame in a multi-line, human-readable form, for use
+// in logs, diagnostic tools, and test failure messages.  The header
+// and the header of each extension are given with their flags
+// decoded and their protocols named; the data of the extensions known
+// to this package is decoded, and that of others is given in
+// hexadecimal, as is the payload.
+func Dump(f *Frame) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "header: %s\n", f.Header)
+	for _, ext := range f.Extensions {
+		fmt.Fprintf(buf, "extension %s: %s\n", ProtocolName(ext.Number), ext.Header)
+		if detail := extensionDetail(ext); detail != "" {
+			indent(buf, detail)
+		} else if len(ext.Data) > 0 {
+			indent(buf, hex.Dump(ext.Data))
+		}
+	}
+	fmt.Fprintf(buf, "payload %s: %d bytes\n", ProtocolName(f.Protocol()), len(f.Payload))
+	if len(f.Payload) > 0 {
+		indent(buf, hex.Dump(f.Payload))
+	}
+
+	return buf.String()
+}
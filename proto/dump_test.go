@@ -0,0 +1,100 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterProtocolName(t *testing.T) {
+	defer patcher.SetVar(&protocolNames, map[uint8]string{}).Install().Restore()
+
+	RegisterProtocolName(42, "test")
+
+	assert.Equal(t, map[uint8]string{42: "test"}, protocolNames)
+}
+
+func TestProtocolName(t *testing.T) {
+	assert.Equal(t, "data(3)", ProtocolName(ProtoData))
+	assert.Equal(t, "request(0x83)", ProtocolName(ExtRequest))
+	assert.Equal(t, "unknown(42)", ProtocolName(42))
+	assert.Equal(t, "unknown(0xf0)", ProtocolName(0xf0))
+}
+
+func TestHeaderString(t *testing.T) {
+	assert.Equal(t, "major=0 protocol=data(3) length=5 flags=-", Header{Protocol: ProtoData, Length: 5}.String())
+	assert.Equal(t, "major=1 protocol=control(0) length=0 flags=reply|error", Header{Major: 1, Reply: true, Error: true}.String())
+}
+
+func TestExtHeaderString(t *testing.T) {
+	assert.Equal(t, "protocol=data(3) length=4 flags=-", ExtHeader{Protocol: ProtoData, Length: 4}.String())
+	assert.Equal(t, "protocol=data(3) length=4 flags=ignore|close|hop-by-hop", ExtHeader{
+		Ignore:   true,
+		Close:    true,
+		HopByHop: true,
+		Protocol: ProtoData,
+		Length:   4,
+	}.String())
+}
+
+func TestFrameString(t *testing.T) {
+	exts := Extensions{(&Request{ID: 7}).Extension()}
+	f := &Frame{
+		Header:     Header{Protocol: exts.Link(ProtoData), Reply: true},
+		Extensions: exts,
+		Payload:    []byte("hello"),
+	}
+
+	result := f.String()
+
+	assert.Equal(t, "request(0x83) -> data(3) flags=reply payload=5", result)
+}
+
+func TestDumpBase(t *testing.T) {
+	exts := Extensions{
+		(&Flood{Origin: NodeID{1}, Sequence: 2, TTL: 3}).Extension(),
+		(&Channel{ID: 4, Flags: ChannelOpen | ChannelWindow, Window: 5}).Extension(),
+		{Number: 0x90, Header: ExtHeader{Ignore: true}, Data: []byte{1, 2}},
+	}
+	f := &Frame{
+		Header:     Header{Protocol: exts.Link(ProtoData)},
+		Extensions: exts,
+		Payload:    []byte("hello"),
+	}
+
+	result := Dump(f)
+
+	assert.Equal(t, "header: major=0 protocol=flood(0x80) length=0 flags=-\n"+
+		"extension flood(0x80): protocol=channel(0x82) length=0 flags=hop-by-hop\n"+
+		"    origin="+NodeID{1}.String()+" sequence=2 ttl=3\n"+
+		"extension channel(0x82): protocol=unknown(0x90) length=0 flags=close|hop-by-hop\n"+
+		"    id=4 flags=open|window window=5\n"+
+		"extension unknown(0x90): protocol=data(3) length=0 flags=ignore\n"+
+		"    00000000  01 02                                             |..|\n"+
+		"payload data(3): 5 bytes\n"+
+		"    00000000  68 65 6c 6c 6f                                    |hello|\n", result)
+}
+
+func TestDumpEmpty(t *testing.T) {
+	f := &Frame{Header: Header{Protocol: ProtoControl}}
+
+	result := Dump(f)
+
+	assert.Equal(t, "header: major=0 protocol=control(0) length=0 flags=-\n"+
+		"payload control(0): 0 bytes\n", result)
+}
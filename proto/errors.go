@@ -18,7 +18,32 @@ import "errors"
 
 // Common simple errors that may be returned by the conduit package.
 var (
-	ErrShortInput  = errors.New("input is too short")
-	ErrShortOutput = errors.New("output buffer is too small")
-	ErrMaxVersion  = errors.New("version is too high")
+	ErrShortInput        = errors.New("input is too short")
+	ErrShortOutput       = errors.New("output buffer is too small")
+	ErrMaxVersion        = errors.New("version is too high")
+	ErrMajorVersion      = errors.New("major version is not accepted")
+	ErrBadLength         = errors.New("length exceeds the enclosing PDU")
+	ErrTooLong           = errors.New("PDU is too long")
+	ErrUnknownExtension  = errors.New("unknown extension")
+	ErrCloseConduit      = errors.New("conduit must be closed")
+	ErrNegotiation       = errors.New("protocol negotiation failed")
+	ErrNoCommonVersion   = errors.New("no protocol version supported by both sides")
+	ErrQuotaSize         = errors.New("PDU exceeds the size quota")
+	ErrQuotaRate         = errors.New("PDU exceeds the rate quota")
+	ErrSnapshotVersion   = errors.New("unsupported snapshot version")
+	ErrAdvertVersion     = errors.New("unsupported advertisement record version")
+	ErrBadSignature      = errors.New("advertisement record signature is not valid")
+	ErrUnsignedAdvert    = errors.New("advertisement record is not signed")
+	ErrBadKey            = errors.New("identity key is not valid")
+	ErrUnknownKey        = errors.New("identity key of node is not known")
+	ErrBadBinding        = errors.New("channel binding proof is not valid")
+	ErrBadNodeID         = errors.New("node identifier is not valid")
+	ErrTooManyExtensions = errors.New("too many extensions in the chain")
+	ErrOversize          = errors.New("PDU exceeds the maximum size")
+	ErrSelfConnection    = errors.New("conduit connects the node to itself")
+	ErrUnknownProtocol   = errors.New("unknown protocol")
+	ErrBadChecksum       = errors.New("checksum does not match the payload")
+	ErrUnknownChecksum   = errors.New("unknown checksum algorithm")
+	ErrNoChannel         = errors.New("frame has no channel extension")
+	ErrBadMessageType    = errors.New("unexpected message type")
 )
@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import "fmt"
+
+// MaxExtensions is the maximum number of extensions in the chain of a
+// PDU, including extensions that are ignored.  Longer chains are
+// rejected, bounding the work done decoding adversarial PDUs.
+const MaxExtensions = 32
+
+// Extensions is a chain of extensions.  In the encoded form, each
+// extension header gives the protocol number of the next element of
+// the chain, which is either another extension or the payload.
+type Extensions []*Extension
+
+// ParseExtensions walks the extension chain at the beginning of data,
+// starting with the protocol number given by first.  The known
+// function reports whether an extension is understood; if it is nil,
+// all extensions are treated as understood.  An extension that is not
+// understood is dropped from the result if its Ignore flag is set;
+// otherwise, the PDU must be rejected, and an error wrapping
+// ErrUnknownExtension is returned.  If the Close flag is also set,
+// the error wraps ErrCloseConduit as well, indicating that the
+// conduit should be closed.  On success, the protocol number and
+// contents of the payload following the chain are returned.  The
+// data slices of the extensions refer to the passed-in data.  Chains
+// longer than MaxExtensions are rejected with ErrTooManyExtensions.
+func ParseExtensions(first uint8, data []byte, known func(uint8) bool) (Extensions, uint8, []byte, error) {
+	exts := Extensions{}
+	next := first
+	for count := 0; IsExtension(next); count++ {
+		if count >= MaxExtensions {
+			return nil, 0, nil, fmt.Errorf("extension %d: %w", next, ErrTooManyExtensions)
+		}
+		ext := &Extension{Number: next}
+		n, err := ext.Header.FromBytes(data)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("extension %d: %w", next, ErrBadLength)
+		}
+		if n+int(ext.Header.Length) > len(data) {
+			return nil, 0, nil, fmt.Errorf("extension %d: %w", next, ErrBadLength)
+		}
+		ext.Data = data[n : n+int(ext.Header.Length)]
+		data = data[n+int(ext.Header.Length):]
+		next = ext.Header.Protocol
+
+		// Handle unknown extensions
+		if known != nil && !known(ext.Number) {
+			switch {
+			case ext.Header.Ignore:
+				continue
+			case ext.Header.Close:
+				return nil, 0, nil, fmt.Errorf("extension %d: %w: %w", ext.Number, ErrUnknownExtension, ErrCloseConduit)
+			default:
+				return nil, 0, nil, fmt.Errorf("extension %d: %w", ext.Number, ErrUnknownExtension)
+			}
+		}
+
+		exts = append(exts, ext)
+	}
+
+	return exts, next, data, nil
+}
+
+// Link sets the next protocol numbers of the extension headers so
+// that each extension points to the one following it, and the last
+// one points to the payload protocol.  It returns the protocol number
+// of the first element of the chain, which should be placed in the
+// PDU header.
+func (e Extensions) Link(payload uint8) uint8 {
+	next := payload
+	for i := len(e) - 1; i >= 0; i-- {
+		e[i].Header.Protocol = next
+		next = e[i].Number
+	}
+
+	return next
+}
+
+// Find returns the first extension in the chain with the specified
+// extension protocol number, or nil if there is none.
+func (e Extensions) Find(number uint8) *Extension {
+	for _, ext := range e {
+		if ext.Number == number {
+			return ext
+		}
+	}
+
+	return nil
+}
+
+// Size returns the size of the encoded extension chain.
+func (e Extensions) Size() int {
+	size := 0
+	for _, ext := range e {
+		size += ExtHeaderSize + len(ext.Data)
+	}
+
+	return size
+}
+
+// ToBytes encodes the extension chain into a sequence of bytes.  The
+// byte slice to fill in must be passed in, and must be at least Size
+// bytes long.  The Length fields of the extension headers are
+// computed from the data; the next protocol numbers are encoded as
+// they are, so Link should be called first.
+func (e Extensions) ToBytes(data []byte) (int, error) {
+	if len(data) < e.Size() {
+		return 0, ErrShortOutput
+	}
+
+	n := 0
+	for _, ext := range e {
+		if len(ext.Data) > MaxLength {
+			return 0, fmt.Errorf("extension %d: %w", ext.Number, ErrTooLong)
+		}
+		hdr := ext.Header
+		hdr.Length = uint16(len(ext.Data))
+		for i := 0; i < ExtHeaderSize; i++ {
+			data[n+i] = 0
+		}
+		m, _ := hdr.ToBytes(data[n:])
+		n += m
+		n += copy(data[n:], ext.Data)
+	}
+
+	return n, nil
+}
+
+// removeExtension returns a copy of the frame with the extension at
+// the specified index removed from the chain.  The passed-in frame is
+// not modified.
+func removeExtension(f *Frame, idx int) *Frame {
+	result := *f
+	result.Extensions = append(append(Extensions{}, f.Extensions[:idx]...), f.Extensions[idx+1:]...)
+	if idx == 0 {
+		result.Header.Protocol = f.Extensions[0].Header.Protocol
+	} else {
+		prev := *result.Extensions[idx-1]
+		prev.Header.Protocol = f.Extensions[idx].Header.Protocol
+		result.Extensions[idx-1] = &prev
+	}
+
+	return &result
+}
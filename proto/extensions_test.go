@@ -0,0 +1,261 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testExtData = []byte{
+	0xa0, 0x82, 0x00, 0x02,
+	'e', '1',
+	0x00, 0x17, 0x00, 0x00,
+	'p', 'a', 'y',
+}
+
+func testKnown(nums ...uint8) func(uint8) bool {
+	return func(n uint8) bool {
+		for _, num := range nums {
+			if n == num {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestParseExtensionsBase(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x81, testExtData, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testFrame().Extensions, exts)
+	assert.Equal(t, uint8(0x17), next)
+	assert.Equal(t, []byte("pay"), payload)
+}
+
+func TestParseExtensionsNone(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x17, []byte("pay"), nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Extensions{}, exts)
+	assert.Equal(t, uint8(0x17), next)
+	assert.Equal(t, []byte("pay"), payload)
+}
+
+func TestParseExtensionsKnown(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x81, testExtData, testKnown(0x81, 0x82))
+
+	assert.NoError(t, err)
+	assert.Equal(t, testFrame().Extensions, exts)
+	assert.Equal(t, uint8(0x17), next)
+	assert.Equal(t, []byte("pay"), payload)
+}
+
+func TestParseExtensionsUnknownIgnored(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x81, testExtData, testKnown(0x82))
+
+	assert.NoError(t, err)
+	assert.Equal(t, Extensions{testFrame().Extensions[1]}, exts)
+	assert.Equal(t, uint8(0x17), next)
+	assert.Equal(t, []byte("pay"), payload)
+}
+
+func TestParseExtensionsUnknown(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x81, testExtData, testKnown(0x81))
+
+	assert.ErrorIs(t, err, ErrUnknownExtension)
+	assert.NotErrorIs(t, err, ErrCloseConduit)
+	assert.Nil(t, exts)
+	assert.Equal(t, uint8(0), next)
+	assert.Nil(t, payload)
+}
+
+func TestParseExtensionsUnknownClose(t *testing.T) {
+	data := []byte{
+		0x40, 0x17, 0x00, 0x00,
+		'p', 'a', 'y',
+	}
+
+	exts, next, payload, err := ParseExtensions(0x81, data, testKnown())
+
+	assert.ErrorIs(t, err, ErrUnknownExtension)
+	assert.ErrorIs(t, err, ErrCloseConduit)
+	assert.Nil(t, exts)
+	assert.Equal(t, uint8(0), next)
+	assert.Nil(t, payload)
+}
+
+func TestParseExtensionsShortHeader(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x81, []byte{0x00, 0x17}, nil)
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, exts)
+	assert.Equal(t, uint8(0), next)
+	assert.Nil(t, payload)
+}
+
+func TestParseExtensionsTooLong(t *testing.T) {
+	exts, next, payload, err := ParseExtensions(0x81, []byte{0x00, 0x17, 0x00, 0x03, 'e'}, nil)
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, exts)
+	assert.Equal(t, uint8(0), next)
+	assert.Nil(t, payload)
+}
+
+func TestParseExtensionsTooMany(t *testing.T) {
+	data := []byte{}
+	for i := 0; i < MaxExtensions; i++ {
+		data = append(data, 0x00, 0x81, 0x00, 0x00)
+	}
+
+	exts, next, payload, err := ParseExtensions(0x81, data, nil)
+
+	assert.ErrorIs(t, err, ErrTooManyExtensions)
+	assert.Nil(t, exts)
+	assert.Equal(t, uint8(0), next)
+	assert.Nil(t, payload)
+}
+
+func TestParseExtensionsMax(t *testing.T) {
+	data := []byte{}
+	for i := 1; i < MaxExtensions; i++ {
+		data = append(data, 0x00, 0x81, 0x00, 0x00)
+	}
+	data = append(data, 0x00, 0x17, 0x00, 0x00)
+
+	exts, next, payload, err := ParseExtensions(0x81, data, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, exts, MaxExtensions)
+	assert.Equal(t, uint8(0x17), next)
+	assert.Equal(t, []byte{}, payload)
+}
+
+func FuzzParseExtensions(f *testing.F) {
+	f.Add(uint8(0x81), testExtData)
+	f.Add(uint8(0x17), []byte("pay"))
+	f.Add(uint8(0x81), []byte{0x00, 0x17, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, first uint8, data []byte) {
+		exts, next, payload, err := ParseExtensions(first, data, nil)
+		if err != nil {
+			return
+		}
+
+		// The chain must fit and be consistent with the data
+		if len(exts) > MaxExtensions || IsExtension(next) {
+			t.Fatalf("bad chain: %d extensions, next %d", len(exts), next)
+		}
+		if exts.Size()+len(payload) != len(data) {
+			t.Fatalf("chain size %d + payload %d != data %d", exts.Size(), len(payload), len(data))
+		}
+	})
+}
+
+func TestExtensionsLinkBase(t *testing.T) {
+	obj := Extensions{
+		{Number: 0x81},
+		{Number: 0x82},
+	}
+
+	result := obj.Link(0x17)
+
+	assert.Equal(t, uint8(0x81), result)
+	assert.Equal(t, uint8(0x82), obj[0].Header.Protocol)
+	assert.Equal(t, uint8(0x17), obj[1].Header.Protocol)
+}
+
+func TestExtensionsLinkEmpty(t *testing.T) {
+	obj := Extensions{}
+
+	result := obj.Link(0x17)
+
+	assert.Equal(t, uint8(0x17), result)
+}
+
+func TestExtensionsFindBase(t *testing.T) {
+	obj := Extensions{
+		{Number: 0x81},
+		{Number: 0x82, Data: []byte("first")},
+		{Number: 0x82, Data: []byte("second")},
+	}
+
+	result := obj.Find(0x82)
+
+	assert.Same(t, obj[1], result)
+}
+
+func TestExtensionsFindMissing(t *testing.T) {
+	obj := Extensions{
+		{Number: 0x81},
+	}
+
+	result := obj.Find(0x82)
+
+	assert.Nil(t, result)
+}
+
+func TestExtensionsSize(t *testing.T) {
+	obj := testFrame().Extensions
+
+	assert.Equal(t, 10, obj.Size())
+}
+
+func TestExtensionsToBytesBase(t *testing.T) {
+	obj := Extensions{
+		{
+			Number: 0x81,
+			Header: ExtHeader{Ignore: true, HopByHop: true},
+			Data:   []byte("e1"),
+		},
+		{
+			Number: 0x82,
+		},
+	}
+	obj.Link(0x17)
+	data := bytes.Repeat([]byte{0xff}, 12)
+
+	result, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 10, result)
+	assert.Equal(t, testExtData[:10], data[:result])
+}
+
+func TestExtensionsToBytesShortOutput(t *testing.T) {
+	obj := testFrame().Extensions
+	data := make([]byte, 9)
+
+	result, err := obj.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrShortOutput)
+	assert.Equal(t, 0, result)
+}
+
+func TestExtensionsToBytesTooLong(t *testing.T) {
+	obj := Extensions{
+		{Number: 0x81, Data: make([]byte, MaxLength+1)},
+	}
+	data := make([]byte, obj.Size())
+
+	result, err := obj.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Equal(t, 0, result)
+}
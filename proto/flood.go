@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import "encoding/binary"
+
+// ExtFlood is the extension protocol number of the flood extension,
+// which marks a PDU for distribution to every node of the overlay.
+const ExtFlood uint8 = 0x80
+
+// FloodSize is the size of the data of a flood extension.
+const FloodSize int = NodeIDSize + 8 + 1
+
+// Flood describes the flood extension.  The origin and sequence
+// number together identify the PDU, allowing nodes to suppress
+// duplicates arriving over different paths.  The TTL is decremented
+// at each hop, and the PDU is not forwarded further once it reaches
+// zero.
+type Flood struct {
+	Origin   NodeID // Identifier of the node originating the PDU
+	Sequence uint64 // Sequence number assigned by the origin
+	TTL      uint8  // Remaining hop limit
+}
+
+// FromBytes is a method of Flood that fills in the information from
+// the data of a flood extension.
+func (fl *Flood) FromBytes(data []byte) (int, error) {
+	// Make sure we have enough data
+	if len(data) < FloodSize {
+		return 0, ErrShortInput
+	}
+
+	// Fill in the extension
+	copy(fl.Origin[:], data)
+	fl.Sequence = binary.BigEndian.Uint64(data[NodeIDSize:])
+	fl.TTL = data[NodeIDSize+8]
+
+	return FloodSize, nil
+}
+
+// ToBytes is a method of Flood that encodes the extension data into a
+// sequence of bytes.  The byte slice to fill in must be passed in.
+func (fl *Flood) ToBytes(data []byte) (int, error) {
+	// Make sure we have enough space
+	if len(data) < FloodSize {
+		return 0, ErrShortOutput
+	}
+
+	// Fill in the data
+	copy(data, fl.Origin[:])
+	binary.BigEndian.PutUint64(data[NodeIDSize:], fl.Sequence)
+	data[NodeIDSize+8] = fl.TTL
+
+	return FloodSize, nil
+}
+
+// Extension constructs the flood extension.  The extension is
+// processed at every hop, and must be understood by every node.
+func (fl *Flood) Extension() *Extension {
+	data := make([]byte, FloodSize)
+	fl.ToBytes(data) //nolint:errcheck
+
+	return &Extension{
+		Number: ExtFlood,
+		Header: ExtHeader{HopByHop: true},
+		Data:   data,
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFloodData = []byte{
+	0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+	0x08,
+}
+
+func TestFloodFromBytesBase(t *testing.T) {
+	obj := &Flood{}
+
+	n, err := obj.FromBytes(testFloodData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, FloodSize, n)
+	assert.Equal(t, &Flood{Origin: NodeID{0x01, 0x02}, Sequence: 0x100, TTL: 8}, obj)
+}
+
+func TestFloodFromBytesShort(t *testing.T) {
+	obj := &Flood{}
+
+	n, err := obj.FromBytes(testFloodData[:FloodSize-1])
+
+	assert.Same(t, ErrShortInput, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, &Flood{}, obj)
+}
+
+func TestFloodToBytesBase(t *testing.T) {
+	obj := &Flood{Origin: NodeID{0x01, 0x02}, Sequence: 0x100, TTL: 8}
+	data := make([]byte, FloodSize+1)
+
+	n, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, FloodSize, n)
+	assert.Equal(t, testFloodData, data[:n])
+}
+
+func TestFloodToBytesShort(t *testing.T) {
+	obj := &Flood{}
+
+	n, err := obj.ToBytes(make([]byte, FloodSize-1))
+
+	assert.Same(t, ErrShortOutput, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFloodExtension(t *testing.T) {
+	obj := &Flood{Origin: NodeID{0x01, 0x02}, Sequence: 0x100, TTL: 8}
+
+	result := obj.Extension()
+
+	assert.Equal(t, &Extension{
+		Number: ExtFlood,
+		Header: ExtHeader{HopByHop: true},
+		Data:   testFloodData,
+	}, result)
+	assert.True(t, IsExtension(result.Number))
+}
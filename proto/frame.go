@@ -0,0 +1,444 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Constants used in the framing of PDUs.
+const (
+	ExtProtocolBit uint8 = 0x80   // Protocol numbers identifying extensions
+	MaxLength      int   = 0xffff // Maximum length following the header
+)
+
+// IsExtension tests if a protocol number identifies an extension.
+// Extension protocol numbers have the high bit set; each extension
+// is preceded by an ExtHeader giving the number of the next protocol
+// in the chain.
+func IsExtension(protocol uint8) bool {
+	return protocol&ExtProtocolBit != 0
+}
+
+// Extension describes a single extension of a Humboldt PDU.
+type Extension struct {
+	Number uint8     // The extension protocol number
+	Header ExtHeader // The extension header
+	Data   []byte    // The extension data
+}
+
+// Frame describes a complete Humboldt PDU: the header, the chain of
+// extensions, and the payload.  When constructing a frame, the
+// Extensions.Link method may be used to set the protocol numbers of
+// the chain.
+type Frame struct {
+	Header     Header     // The PDU header
+	Extensions Extensions // The extensions
+	Payload    []byte     // The payload
+}
+
+// Protocol returns the protocol number of the payload, which is the
+// next protocol of the last extension, or the protocol of the header
+// if there are no extensions.
+func (f *Frame) Protocol() uint8 {
+	if len(f.Extensions) > 0 {
+		return f.Extensions[len(f.Extensions)-1].Header.Protocol
+	}
+
+	return f.Header.Protocol
+}
+
+// Size returns the size of the encoded frame, including the header.
+// The size of the body is computed by the codec for the major version
+// of the header, or as for major version 0 if it has no registered
+// codec.
+func (f *Frame) Size() int {
+	codec, err := lookupMajorCodec(f.Header.Major)
+	if err != nil {
+		codec = majorV0{}
+	}
+
+	return HeaderSize + codec.BodySize(f)
+}
+
+// FromBytes is a method of Frame that fills in the information from
+// an encoded PDU.  The body of the PDU is decoded by the codec for
+// its major version; in major version 0, the extension chain is
+// followed as long as the next protocol number identifies an
+// extension, and the remainder of the PDU is the payload.  The data
+// slices of the frame refer to the passed-in data.
+func (f *Frame) FromBytes(data []byte) (int, error) {
+	// Decode the header
+	hdr := Header{}
+	n, err := hdr.FromBytes(data)
+	if err != nil {
+		return 0, err
+	}
+	end := n + int(hdr.Length)
+	if end > len(data) {
+		return 0, ErrShortInput
+	}
+
+	// Decode the body
+	frame, err := decodeBody(&hdr, data[n:end])
+	if err != nil {
+		return 0, err
+	}
+	*f = *frame
+
+	return end, nil
+}
+
+// decodeBody decodes the body of a PDU with the codec for the major
+// version of its header.
+func decodeBody(hdr *Header, body []byte) (*Frame, error) {
+	codec, err := lookupMajorCodec(hdr.Major)
+	if err != nil {
+		return nil, err
+	}
+	f := &Frame{Header: *hdr}
+	if err := codec.DecodeBody(f, body); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Decode decodes a buffer containing exactly one complete PDU into a
+// Frame.  Unlike FromBytes, which permits data to follow the PDU, the
+// Length field of the header must account for all of the data
+// following the header, or an error wrapping ErrBadLength is
+// returned.  The extension chain must fit within the PDU and may not
+// exceed MaxExtensions.  Decode is intended for untrusted input, and
+// reports malformed PDUs with errors rather than panicking.  The data
+// slices of the frame refer to the passed-in data.
+func Decode(data []byte) (*Frame, error) {
+	f := &Frame{}
+	n, err := f.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("PDU size %d, buffer size %d: %w", n, len(data), ErrBadLength)
+	}
+
+	return f, nil
+}
+
+// ToBytes is a method of Frame that encodes the frame into a sequence
+// of bytes.  The byte slice to fill in must be passed in, and must be
+// at least Size bytes long.  The Length fields of the header and of
+// the extension headers are computed from the data.
+func (f *Frame) ToBytes(data []byte) (int, error) {
+	// Check the length
+	size := f.Size()
+	if size-HeaderSize > MaxLength {
+		return 0, fmt.Errorf("%d: %w", size-HeaderSize, ErrTooLong)
+	}
+	if len(data) < size {
+		return 0, ErrShortOutput
+	}
+
+	// Encode the header
+	hdr := f.Header
+	hdr.Length = uint16(size - HeaderSize)
+	n, err := hdr.ToBytes(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// Encode the body
+	codec, err := lookupMajorCodec(hdr.Major)
+	if err != nil {
+		return 0, err
+	}
+	m, err := codec.EncodeBody(f, data[n:])
+	if err != nil {
+		return 0, err
+	}
+
+	return n + m, nil
+}
+
+// Reader reads complete PDUs from an io.Reader.  Two framing
+// strategies are supported: for byte streams, the Length field of
+// the header is used to delimit PDUs; for readers that preserve
+// message boundaries, such as datagram transports, each message
+// carries exactly one PDU, and the Length field must match the size
+// of the message.
+type Reader struct {
+	r        io.Reader // The underlying reader
+	messages bool      // Reader preserves message boundaries
+	buf      []byte    // Message buffer
+	maxSize  int       // Maximum size of a PDU, including the header
+	majors   bool      // Accepted major versions are limited
+	minMajor uint8     // Minimum accepted major version
+	maxMajor uint8     // Maximum accepted major version
+}
+
+// OversizeError is the error returned by Reader when a PDU exceeds
+// the maximum size.  The body of the PDU is discarded without being
+// buffered, so that the next PDU may be read; the header is retained
+// so that an error reply may be sent.
+type OversizeError struct {
+	Header  Header // The header of the oversize PDU
+	MaxSize int    // The maximum size of a PDU
+}
+
+// Error returns the error message.
+func (e *OversizeError) Error() string {
+	return fmt.Sprintf("size %d, maximum %d: %s", HeaderSize+int(e.Header.Length), e.MaxSize, ErrOversize)
+}
+
+// Unwrap returns ErrOversize.
+func (e *OversizeError) Unwrap() error {
+	return ErrOversize
+}
+
+// Reply constructs the error reply to send to the peer, as for a PDU
+// violating a quota.  Since the extension chain of the PDU is not
+// read, the reply is for the protocol number in its header.  It
+// returns nil if the PDU is itself a reply.
+func (e *OversizeError) Reply() *Frame {
+	return QuotaReply(&Frame{Header: e.Header})
+}
+
+// NewReader constructs a new Reader wrapping the specified byte
+// stream reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: r,
+	}
+}
+
+// NewMessageReader constructs a new Reader wrapping the specified
+// reader, which must preserve message boundaries: each call to its
+// Read method must return exactly one message.
+func NewMessageReader(r io.Reader) *Reader {
+	return &Reader{
+		r:        r,
+		messages: true,
+	}
+}
+
+// SetMaxSize sets the maximum size of a PDU, including the header.
+// PDUs announcing a larger size are discarded, and ReadPDU and
+// ReadFrame return an *OversizeError.  A size of 0 removes the limit.
+// It must not be called concurrently with reads.
+func (r *Reader) SetMaxSize(size int) {
+	r.maxSize = size
+}
+
+// SetMajors limits the major versions of the wire format accepted
+// by the reader to a range.  PDUs of other major versions are
+// rejected, and ReadPDU and ReadFrame return an error wrapping
+// ErrMajorVersion; since the PDU is not read, the reader should not
+// be used further.  By default, PDUs of any major version with a
+// registered codec are accepted.  It must not be called concurrently
+// with reads.
+func (r *Reader) SetMajors(minMajor, maxMajor uint8) {
+	r.majors = true
+	r.minMajor = minMajor
+	r.maxMajor = maxMajor
+}
+
+// accept checks if the major version of a PDU is accepted.
+func (r *Reader) accept(hdr *Header) error {
+	if r.majors && (hdr.Major < r.minMajor || hdr.Major > r.maxMajor) {
+		return fmt.Errorf("major %d, accepted %d-%d: %w", hdr.Major, r.minMajor, r.maxMajor, ErrMajorVersion)
+	}
+
+	return nil
+}
+
+// oversize checks if a PDU exceeds the maximum size.
+func (r *Reader) oversize(hdr *Header) error {
+	if r.maxSize > 0 && HeaderSize+int(hdr.Length) > r.maxSize {
+		return &OversizeError{Header: *hdr, MaxSize: r.maxSize}
+	}
+
+	return nil
+}
+
+// readStream reads a PDU from a byte stream.
+func (r *Reader) readStream() (*Header, []byte, error) {
+	// Read and decode the header
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, nil, err
+	}
+	hdr := &Header{}
+	if _, err := hdr.FromBytes(buf); err != nil {
+		return nil, nil, err
+	}
+	if err := r.accept(hdr); err != nil {
+		return nil, nil, err
+	}
+
+	// Discard the body of an oversize PDU
+	if err := r.oversize(hdr); err != nil {
+		if _, cpErr := io.CopyN(io.Discard, r.r, int64(hdr.Length)); cpErr != nil {
+			if cpErr == io.EOF {
+				cpErr = io.ErrUnexpectedEOF
+			}
+			return nil, nil, cpErr
+		}
+		return nil, nil, err
+	}
+
+	// Read the rest of the PDU
+	body := make([]byte, hdr.Length)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+
+	return hdr, body, nil
+}
+
+// readMessage reads a PDU from a single message.
+func (r *Reader) readMessage() (*Header, []byte, error) {
+	if r.buf == nil {
+		r.buf = make([]byte, HeaderSize+MaxLength)
+	}
+
+	// Read the message and decode the header
+	n, err := r.r.Read(r.buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr := &Header{}
+	if _, err := hdr.FromBytes(r.buf[:n]); err != nil {
+		return nil, nil, err
+	}
+	if err := r.accept(hdr); err != nil {
+		return nil, nil, err
+	}
+	if HeaderSize+int(hdr.Length) != n {
+		return nil, nil, fmt.Errorf("message size %d: %w", n, ErrBadLength)
+	}
+	if err := r.oversize(hdr); err != nil {
+		return nil, nil, err
+	}
+
+	// Copy out the rest of the PDU
+	body := make([]byte, hdr.Length)
+	copy(body, r.buf[HeaderSize:n])
+
+	return hdr, body, nil
+}
+
+// ReadPDU reads a complete PDU, returning the decoded header and the
+// bytes following it.  For byte streams, an io.EOF error is returned
+// only if the underlying reader is at the end of its input before
+// any of the PDU is read.
+func (r *Reader) ReadPDU() (*Header, []byte, error) {
+	if r.messages {
+		return r.readMessage()
+	}
+
+	return r.readStream()
+}
+
+// ReadFrame reads a complete PDU and decodes it into a Frame.  For
+// byte streams, an io.EOF error is returned only if the underlying
+// reader is at the end of its input before any of the PDU is read.
+func (r *Reader) ReadFrame() (*Frame, error) {
+	hdr, body, err := r.ReadPDU()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeBody(hdr, body)
+}
+
+// Writer writes complete PDUs to an io.Writer.  Each frame is
+// written with a single call to the underlying writer, so the same
+// Writer serves both byte streams and writers preserving message
+// boundaries, where each PDU becomes one message.  Calls are
+// serialized, so frames are never interleaved.
+type Writer struct {
+	sync.Mutex
+
+	w       io.Writer // The underlying writer
+	maxSize int       // Maximum size of a PDU, including the header
+	major   uint8     // Major version of the PDUs written
+	stamp   bool      // Major version has been set
+}
+
+// NewWriter constructs a new Writer wrapping the specified writer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+	}
+}
+
+// SetMaxSize sets the maximum size of a PDU, including the header.
+// WriteFrame refuses to write larger frames, returning an error
+// wrapping ErrOversize.  A size of 0 removes the limit.
+func (w *Writer) SetMaxSize(size int) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.maxSize = size
+}
+
+// SetMajor sets the major version of the wire format used for the
+// frames written; the Major field of the header of the frames passed
+// to WriteFrame is then ignored.  Until it is called, frames are
+// written in the major version given by their headers.
+func (w *Writer) SetMajor(major uint8) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.major = major
+	w.stamp = true
+}
+
+// WriteFrame encodes a frame and writes it to the underlying writer,
+// using the major version set by SetMajor, if any.  The passed-in
+// frame is not modified.
+func (w *Writer) WriteFrame(f *Frame) error {
+	// Check the size
+	w.Lock()
+	maxSize := w.maxSize
+	major, stamp := w.major, w.stamp
+	w.Unlock()
+	if stamp && f.Header.Major != major {
+		frame := *f
+		frame.Header.Major = major
+		f = &frame
+	}
+	if size := f.Size(); maxSize > 0 && size > maxSize {
+		return fmt.Errorf("size %d, maximum %d: %w", size, maxSize, ErrOversize)
+	}
+
+	// Encode the frame
+	buf := make([]byte, f.Size())
+	if _, err := f.ToBytes(buf); err != nil {
+		return err
+	}
+
+	// Write it out
+	w.Lock()
+	defer w.Unlock()
+	_, err := w.w.Write(buf)
+
+	return err
+}
@@ -0,0 +1,636 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFrameData = []byte{
+	0x08, 0x81, 0x00, 0x0d,
+	0xa0, 0x82, 0x00, 0x02,
+	'e', '1',
+	0x00, 0x17, 0x00, 0x00,
+	'p', 'a', 'y',
+}
+
+func testFrame() *Frame {
+	return &Frame{
+		Header: Header{
+			Reply:    true,
+			Protocol: 0x81,
+			Length:   0x0d,
+		},
+		Extensions: Extensions{
+			{
+				Number: 0x81,
+				Header: ExtHeader{
+					Ignore:   true,
+					HopByHop: true,
+					Protocol: 0x82,
+					Length:   2,
+				},
+				Data: []byte("e1"),
+			},
+			{
+				Number: 0x82,
+				Header: ExtHeader{
+					Protocol: 0x17,
+				},
+				Data: []byte{},
+			},
+		},
+		Payload: []byte("pay"),
+	}
+}
+
+type errWriter struct {
+	writes [][]byte
+	err    error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, p)
+
+	return 0, w.err
+}
+
+func TestIsExtension(t *testing.T) {
+	assert.False(t, IsExtension(0x17))
+	assert.True(t, IsExtension(0x81))
+}
+
+func TestFrameProtocolBase(t *testing.T) {
+	obj := &Frame{
+		Header: Header{Protocol: 0x17},
+	}
+
+	assert.Equal(t, uint8(0x17), obj.Protocol())
+}
+
+func TestFrameProtocolExtensions(t *testing.T) {
+	obj := testFrame()
+
+	assert.Equal(t, uint8(0x17), obj.Protocol())
+}
+
+func TestFrameSize(t *testing.T) {
+	obj := testFrame()
+
+	assert.Equal(t, len(testFrameData), obj.Size())
+}
+
+func TestFrameFromBytesBase(t *testing.T) {
+	obj := &Frame{}
+	data := append(append([]byte{}, testFrameData...), 'x', 'y')
+
+	result, err := obj.FromBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, len(testFrameData), result)
+	assert.Equal(t, testFrame(), obj)
+}
+
+func TestFrameFromBytesNoExtensions(t *testing.T) {
+	obj := &Frame{}
+	data := []byte{0x00, 0x17, 0x00, 0x03, 'p', 'a', 'y'}
+
+	result, err := obj.FromBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, result)
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Protocol: 0x17,
+			Length:   3,
+		},
+		Extensions: Extensions{},
+		Payload:    []byte("pay"),
+	}, obj)
+}
+
+func TestFrameFromBytesBadHeader(t *testing.T) {
+	obj := &Frame{}
+
+	result, err := obj.FromBytes([]byte{0x00, 0x17})
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, &Frame{}, obj)
+}
+
+func TestFrameFromBytesShort(t *testing.T) {
+	obj := &Frame{}
+
+	result, err := obj.FromBytes(testFrameData[:len(testFrameData)-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, &Frame{}, obj)
+}
+
+func TestFrameFromBytesShortExtHeader(t *testing.T) {
+	obj := &Frame{}
+	data := []byte{0x00, 0x81, 0x00, 0x02, 0x00, 0x17}
+
+	result, err := obj.FromBytes(data)
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, &Frame{}, obj)
+}
+
+func TestFrameFromBytesExtTooLong(t *testing.T) {
+	obj := &Frame{}
+	data := []byte{
+		0x00, 0x81, 0x00, 0x06,
+		0x00, 0x17, 0x00, 0x03,
+		'e', '1',
+	}
+
+	result, err := obj.FromBytes(data)
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, &Frame{}, obj)
+}
+
+func TestDecodeBase(t *testing.T) {
+	result, err := Decode(testFrameData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testFrame(), result)
+}
+
+func TestDecodeShort(t *testing.T) {
+	result, err := Decode(testFrameData[:len(testFrameData)-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeTrailing(t *testing.T) {
+	data := append(append([]byte{}, testFrameData...), 'x')
+
+	result, err := Decode(data)
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestDecodeBadHeader(t *testing.T) {
+	result, err := Decode([]byte{0xf0, 0x17, 0x00, 0x00})
+
+	assert.ErrorIs(t, err, ErrMaxVersion)
+	assert.Nil(t, result)
+}
+
+func TestDecodeExtTooLong(t *testing.T) {
+	result, err := Decode([]byte{0x00, 0x81, 0x00, 0x05, 0x00, 0x17, 0x00, 0x02, 'e'})
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestDecodeTooManyExtensions(t *testing.T) {
+	data := []byte{0x00, 0x81, 0x00, uint8(4 * MaxExtensions)}
+	for i := 0; i < MaxExtensions; i++ {
+		data = append(data, 0x00, 0x81, 0x00, 0x00)
+	}
+
+	result, err := Decode(data)
+
+	assert.ErrorIs(t, err, ErrTooManyExtensions)
+	assert.Nil(t, result)
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(testFrameData)
+	f.Add([]byte{0x00, 0x17, 0x00, 0x00})
+	f.Add([]byte{0x0c, 0x00, 0x00, 0x02, 0x01, 0x02})
+	f.Add([]byte{0x00, 0x81, 0x00, 0x04, 0x00, 0x81, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := Decode(data)
+		if err != nil {
+			return
+		}
+
+		// A decoded frame must re-encode to the same size and
+		// decode to the same frame
+		if frame.Size() != len(data) {
+			t.Fatalf("frame size %d != data size %d", frame.Size(), len(data))
+		}
+		buf := make([]byte, frame.Size())
+		if _, err := frame.ToBytes(buf); err != nil {
+			t.Fatalf("re-encoding failed: %s", err)
+		}
+		again, err := Decode(buf)
+		if err != nil {
+			t.Fatalf("re-decoding failed: %s", err)
+		}
+		assert.Equal(t, frame, again)
+	})
+}
+
+func TestFrameToBytesBase(t *testing.T) {
+	obj := testFrame()
+	obj.Header.Length = 0
+	obj.Extensions[0].Header.Length = 0
+	data := bytes.Repeat([]byte{0xff}, len(testFrameData)+2)
+
+	result, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, len(testFrameData), result)
+	assert.Equal(t, testFrameData, data[:result])
+}
+
+func TestFrameToBytesTooLong(t *testing.T) {
+	obj := &Frame{
+		Payload: make([]byte, MaxLength+1),
+	}
+	data := make([]byte, obj.Size())
+
+	result, err := obj.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Equal(t, 0, result)
+}
+
+func TestFrameToBytesShortOutput(t *testing.T) {
+	obj := testFrame()
+	data := make([]byte, len(testFrameData)-1)
+
+	result, err := obj.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrShortOutput)
+	assert.Equal(t, 0, result)
+}
+
+func TestFrameToBytesBadHeader(t *testing.T) {
+	obj := testFrame()
+	obj.Header.Major = MaxMajor + 1
+	data := make([]byte, len(testFrameData))
+
+	result, err := obj.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrMaxVersion)
+	assert.Equal(t, 0, result)
+}
+
+func TestNewReader(t *testing.T) {
+	r := &bytes.Buffer{}
+
+	result := NewReader(r)
+
+	assert.Equal(t, &Reader{r: r}, result)
+}
+
+func TestNewMessageReader(t *testing.T) {
+	r := &bytes.Buffer{}
+
+	result := NewMessageReader(r)
+
+	assert.Equal(t, &Reader{r: r, messages: true}, result)
+}
+
+// msgReader is a reader returning one message per call to Read.
+type msgReader struct {
+	msgs [][]byte
+}
+
+func (r *msgReader) Read(p []byte) (int, error) {
+	if len(r.msgs) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.msgs[0])
+	r.msgs = r.msgs[1:]
+	return n, nil
+}
+
+func TestReaderReadPDUBase(t *testing.T) {
+	r := bytes.NewReader([]byte{0x08, 0x17, 0x00, 0x03, 'a', 'b', 'c', 'd'})
+
+	hdr, body, err := NewReader(r).ReadPDU()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Header{
+		Reply:    true,
+		Protocol: 0x17,
+		Length:   3,
+	}, hdr)
+	assert.Equal(t, []byte("abc"), body)
+}
+
+func TestReaderReadPDUEOF(t *testing.T) {
+	r := bytes.NewReader([]byte{})
+
+	hdr, body, err := NewReader(r).ReadPDU()
+
+	assert.Same(t, io.EOF, err)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUBadHeader(t *testing.T) {
+	r := bytes.NewReader([]byte{0xf0, 0x17, 0x00, 0x03, 'a', 'b', 'c'})
+
+	hdr, body, err := NewReader(r).ReadPDU()
+
+	assert.ErrorIs(t, err, ErrMaxVersion)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUShortBody(t *testing.T) {
+	r := bytes.NewReader([]byte{0x08, 0x17, 0x00, 0x03, 'a'})
+
+	hdr, body, err := NewReader(r).ReadPDU()
+
+	assert.Same(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUMissingBody(t *testing.T) {
+	r := bytes.NewReader([]byte{0x08, 0x17, 0x00, 0x03})
+
+	hdr, body, err := NewReader(r).ReadPDU()
+
+	assert.Same(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUMessageBase(t *testing.T) {
+	r := &msgReader{msgs: [][]byte{
+		{0x08, 0x17, 0x00, 0x03, 'a', 'b', 'c'},
+		{0x00, 0x18, 0x00, 0x01, 'd'},
+	}}
+	obj := NewMessageReader(r)
+
+	hdr1, body1, err1 := obj.ReadPDU()
+	hdr2, body2, err2 := obj.ReadPDU()
+	hdr3, body3, err3 := obj.ReadPDU()
+
+	assert.NoError(t, err1)
+	assert.Equal(t, &Header{
+		Reply:    true,
+		Protocol: 0x17,
+		Length:   3,
+	}, hdr1)
+	assert.Equal(t, []byte("abc"), body1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &Header{
+		Protocol: 0x18,
+		Length:   1,
+	}, hdr2)
+	assert.Equal(t, []byte("d"), body2)
+	assert.Same(t, io.EOF, err3)
+	assert.Nil(t, hdr3)
+	assert.Nil(t, body3)
+}
+
+func TestReaderReadPDUMessageShortHeader(t *testing.T) {
+	r := &msgReader{msgs: [][]byte{{0x08, 0x17}}}
+
+	hdr, body, err := NewMessageReader(r).ReadPDU()
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUMessageShortBody(t *testing.T) {
+	r := &msgReader{msgs: [][]byte{{0x08, 0x17, 0x00, 0x03, 'a'}}}
+
+	hdr, body, err := NewMessageReader(r).ReadPDU()
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUMessageTrailing(t *testing.T) {
+	r := &msgReader{msgs: [][]byte{{0x08, 0x17, 0x00, 0x01, 'a', 'b'}}}
+
+	hdr, body, err := NewMessageReader(r).ReadPDU()
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadFrameMessage(t *testing.T) {
+	obj := NewMessageReader(&msgReader{msgs: [][]byte{testFrameData}})
+
+	result, err := obj.ReadFrame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testFrame(), result)
+}
+
+func TestReaderReadFrameBase(t *testing.T) {
+	obj := NewReader(bytes.NewReader(append(append([]byte{}, testFrameData...), testFrameData...)))
+
+	result1, err1 := obj.ReadFrame()
+	result2, err2 := obj.ReadFrame()
+	result3, err3 := obj.ReadFrame()
+
+	assert.NoError(t, err1)
+	assert.Equal(t, testFrame(), result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, testFrame(), result2)
+	assert.Same(t, io.EOF, err3)
+	assert.Nil(t, result3)
+}
+
+func TestReaderReadFrameShortHeader(t *testing.T) {
+	obj := NewReader(bytes.NewReader(testFrameData[:2]))
+
+	result, err := obj.ReadFrame()
+
+	assert.Same(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, result)
+}
+
+func TestReaderReadFrameBadHeader(t *testing.T) {
+	obj := NewReader(bytes.NewReader([]byte{0xf0, 0x17, 0x00, 0x00}))
+
+	result, err := obj.ReadFrame()
+
+	assert.ErrorIs(t, err, ErrMaxVersion)
+	assert.Nil(t, result)
+}
+
+func TestReaderReadFrameShortBody(t *testing.T) {
+	obj := NewReader(bytes.NewReader(testFrameData[:HeaderSize]))
+
+	result, err := obj.ReadFrame()
+
+	assert.Same(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, result)
+}
+
+func TestReaderReadFrameBadFrame(t *testing.T) {
+	obj := NewReader(bytes.NewReader([]byte{0x00, 0x81, 0x00, 0x02, 0x00, 0x17}))
+
+	result, err := obj.ReadFrame()
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestNewWriter(t *testing.T) {
+	w := &bytes.Buffer{}
+
+	result := NewWriter(w)
+
+	assert.Same(t, w, result.w)
+}
+
+func TestWriterWriteFrameBase(t *testing.T) {
+	w := &errWriter{}
+	obj := NewWriter(w)
+
+	err := obj.WriteFrame(testFrame())
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{testFrameData}, w.writes)
+}
+
+func TestWriterWriteFrameEncodeError(t *testing.T) {
+	w := &errWriter{}
+	obj := NewWriter(w)
+
+	err := obj.WriteFrame(&Frame{Payload: make([]byte, MaxLength+1)})
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, w.writes)
+}
+
+func TestWriterWriteFrameWriteError(t *testing.T) {
+	w := &errWriter{err: assert.AnError}
+	obj := NewWriter(w)
+
+	err := obj.WriteFrame(testFrame())
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestOversizeError(t *testing.T) {
+	obj := &OversizeError{Header: Header{Protocol: 0x17, Length: 10}, MaxSize: 8}
+
+	assert.EqualError(t, obj, "size 14, maximum 8: PDU exceeds the maximum size")
+	assert.ErrorIs(t, obj, ErrOversize)
+}
+
+func TestOversizeErrorReply(t *testing.T) {
+	obj := &OversizeError{Header: Header{Protocol: 0x17, Length: 10}}
+
+	result := obj.Reply()
+
+	assert.Equal(t, &Frame{Header: Header{Reply: true, Error: true, Protocol: 0x17}}, result)
+}
+
+func TestOversizeErrorReplyToReply(t *testing.T) {
+	obj := &OversizeError{Header: Header{Reply: true, Protocol: 0x17, Length: 10}}
+
+	result := obj.Reply()
+
+	assert.Nil(t, result)
+}
+
+func TestReaderReadPDUOversize(t *testing.T) {
+	obj := NewReader(bytes.NewReader([]byte{
+		0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c',
+		0x00, 0x17, 0x00, 0x02, 'd', 'e',
+	}))
+	obj.SetMaxSize(6)
+
+	hdr, body, err := obj.ReadPDU()
+
+	oe := &OversizeError{}
+	assert.ErrorAs(t, err, &oe)
+	assert.Equal(t, Header{Protocol: 0x17, Length: 3}, oe.Header)
+	assert.Equal(t, 6, oe.MaxSize)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+
+	hdr, body, err = obj.ReadPDU()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Header{Protocol: 0x17, Length: 2}, hdr)
+	assert.Equal(t, []byte("de"), body)
+}
+
+func TestReaderReadPDUOversizeTruncated(t *testing.T) {
+	obj := NewReader(bytes.NewReader([]byte{0x00, 0x17, 0x00, 0x03, 'a'}))
+	obj.SetMaxSize(6)
+
+	hdr, body, err := obj.ReadPDU()
+
+	assert.Same(t, io.ErrUnexpectedEOF, err)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadPDUMessageOversize(t *testing.T) {
+	obj := NewMessageReader(bytes.NewReader([]byte{0x00, 0x17, 0x00, 0x03, 'a', 'b', 'c'}))
+	obj.SetMaxSize(6)
+
+	hdr, body, err := obj.ReadPDU()
+
+	assert.ErrorIs(t, err, ErrOversize)
+	assert.Nil(t, hdr)
+	assert.Nil(t, body)
+}
+
+func TestReaderReadFrameOversize(t *testing.T) {
+	obj := NewReader(bytes.NewReader(testFrameData))
+	obj.SetMaxSize(len(testFrameData) - 1)
+
+	result, err := obj.ReadFrame()
+
+	assert.ErrorIs(t, err, ErrOversize)
+	assert.Nil(t, result)
+}
+
+func TestWriterWriteFrameOversize(t *testing.T) {
+	w := &errWriter{}
+	obj := NewWriter(w)
+	obj.SetMaxSize(len(testFrameData) - 1)
+
+	err := obj.WriteFrame(testFrame())
+
+	assert.ErrorIs(t, err, ErrOversize)
+	assert.Empty(t, w.writes)
+}
+
+func TestWriterWriteFrameMaxSize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	obj := NewWriter(buf)
+	obj.SetMaxSize(len(testFrameData))
+
+	err := obj.WriteFrame(testFrame())
+
+	assert.NoError(t, err)
+	assert.Equal(t, testFrameData, buf.Bytes())
+}
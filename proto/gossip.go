@@ -0,0 +1,99 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ProtoGossip is the protocol number of the gossip protocol, over
+// which nodes exchange the advertisement records of the peers they
+// know of.
+const ProtoGossip uint8 = 2
+
+// GossipHeaderSize is the size of the header of a gossip message,
+// which is the count of records.
+const GossipHeaderSize int = 2
+
+// GossipSize returns the size a record of the specified encoded size
+// adds to a gossip message.
+func GossipSize(record int) int {
+	return 2 + record
+}
+
+// EncodeGossip encodes a gossip message carrying advertisement
+// records.  The message must fit in the payload of a single PDU.
+func EncodeGossip(adverts []*Advert) ([]byte, error) {
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(adverts)))
+	for _, a := range adverts {
+		rec, err := a.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("gossip advert for %s: %w", a.NodeID, err)
+		}
+		if data, err = putString(data, string(rec)); err != nil {
+			return nil, fmt.Errorf("gossip advert for %s: %w", a.NodeID, err)
+		}
+	}
+	if len(data) > MaxLength {
+		return nil, fmt.Errorf("gossip: %w", ErrTooLong)
+	}
+
+	return data, nil
+}
+
+// DecodeGossip decodes a gossip message, returning the advertisement
+// records it carries.  Signatures are not verified.
+func DecodeGossip(data []byte) ([]*Advert, error) {
+	if len(data) < GossipHeaderSize {
+		return nil, ErrShortInput
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[GossipHeaderSize:]
+
+	result := make([]*Advert, 0, count)
+	for i := 0; i < count; i++ {
+		rec, rest, err := getBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("gossip advert %d: %w", i, err)
+		}
+		a, err := DecodeAdvert(rec)
+		if err != nil {
+			return nil, fmt.Errorf("gossip advert %d: %w", i, err)
+		}
+		result = append(result, a)
+		data = rest
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("gossip: %w", ErrBadLength)
+	}
+
+	return result, nil
+}
+
+// GossipFrame constructs a frame carrying a gossip message.
+func GossipFrame(adverts []*Advert) (*Frame, error) {
+	payload, err := EncodeGossip(adverts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoGossip,
+		},
+		Payload: payload,
+	}, nil
+}
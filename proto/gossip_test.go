@@ -0,0 +1,128 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGossipData() []byte {
+	data := []byte{0x00, 0x02}
+	for i := 0; i < 2; i++ {
+		data = append(data, 0x00, byte(len(testAdvertData)))
+		data = append(data, testAdvertData...)
+	}
+
+	return data
+}
+
+func TestGossipSize(t *testing.T) {
+	assert.Equal(t, 12, GossipSize(10))
+}
+
+func TestEncodeGossipBase(t *testing.T) {
+	result, err := EncodeGossip([]*Advert{testAdvert(), testAdvert()})
+
+	assert.NoError(t, err)
+	assert.Equal(t, testGossipData(), result)
+	assert.Len(t, result, GossipHeaderSize+2*GossipSize(len(testAdvertData)))
+}
+
+func TestEncodeGossipEmpty(t *testing.T) {
+	result, err := EncodeGossip(nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x00}, result)
+}
+
+func TestEncodeGossipAdvertError(t *testing.T) {
+	a := testAdvert()
+	a.Version = 0xff
+
+	result, err := EncodeGossip([]*Advert{a})
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+	assert.Nil(t, result)
+}
+
+func TestEncodeGossipTooLong(t *testing.T) {
+	a := testAdvert()
+	a.URIs = []string{strings.Repeat("u", 30000)}
+
+	result, err := EncodeGossip([]*Advert{a, a, a})
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodeGossipBase(t *testing.T) {
+	result, err := DecodeGossip(testGossipData())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Advert{testAdvert(), testAdvert()}, result)
+}
+
+func TestDecodeGossipShortHeader(t *testing.T) {
+	result, err := DecodeGossip([]byte{0x00})
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeGossipShortRecord(t *testing.T) {
+	data := testGossipData()
+
+	result, err := DecodeGossip(data[:len(data)-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeGossipBadRecord(t *testing.T) {
+	result, err := DecodeGossip([]byte{0x00, 0x01, 0x00, 0x01, 0xff})
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+	assert.Nil(t, result)
+}
+
+func TestDecodeGossipTrailing(t *testing.T) {
+	result, err := DecodeGossip(append(testGossipData(), 0))
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestGossipFrameBase(t *testing.T) {
+	result, err := GossipFrame([]*Advert{testAdvert(), testAdvert()})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoGossip},
+		Payload: testGossipData(),
+	}, result)
+}
+
+func TestGossipFrameError(t *testing.T) {
+	a := testAdvert()
+	a.Version = 0xff
+
+	result, err := GossipFrame([]*Advert{a})
+
+	assert.ErrorIs(t, err, ErrAdvertVersion)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Constants describing the histogram buckets.  Values below
+// histLinear nanoseconds have their own buckets; above that, each
+// power of two is divided into histHalf buckets, bounding the
+// relative error of a recorded value to under 1/histHalf.
+const (
+	histBits   = 7
+	histLinear = 1 << histBits
+	histHalf   = histLinear / 2
+)
+
+// histBucket returns the index of the bucket containing the value.
+func histBucket(v uint64) int {
+	if v < histLinear {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histBits
+
+	return histLinear + (shift-1)*histHalf + int(v>>shift) - histHalf
+}
+
+// histValue returns the value representing a bucket, which is the
+// midpoint of the range of values it contains.
+func histValue(idx int) uint64 {
+	if idx < histLinear {
+		return uint64(idx)
+	}
+	k := idx - histLinear
+	shift := k/histHalf + 1
+	m := uint64(k%histHalf + histHalf)
+
+	return m<<shift + 1<<(shift-1)
+}
+
+// Histogram is an HDR-style histogram of latencies.  Values are
+// recorded with a relative precision of better than 1%, so quantiles
+// may be computed over any range of latencies with a small, fixed
+// amount of memory.  The zero value is an empty histogram.
+type Histogram struct {
+	sync.Mutex
+
+	counts []uint64      // Counts of values in each bucket
+	count  uint64        // Total number of values
+	sum    time.Duration // Sum of the values
+	min    time.Duration // Smallest value
+	max    time.Duration // Largest value
+}
+
+// Record records a latency.  Negative latencies are recorded as 0.
+func (h *Histogram) Record(d time.Duration) {
+	d = max(d, 0)
+	idx := histBucket(uint64(d))
+
+	h.Lock()
+	defer h.Unlock()
+
+	if idx >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, idx+1-len(h.counts))...)
+	}
+	h.counts[idx]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.count++
+	h.sum += d
+}
+
+// Count returns the number of recorded latencies.
+func (h *Histogram) Count() uint64 {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.count
+}
+
+// quantile computes a quantile.  Must be called with the lock held.
+func (h *Histogram) quantile(q float64) time.Duration {
+	switch {
+	case h.count == 0:
+		return 0
+	case q <= 0:
+		return h.min
+	case q >= 1:
+		return h.max
+	}
+
+	// Find the bucket containing the quantile
+	rank := uint64(q*float64(h.count) + 0.5)
+	rank = min(max(rank, 1), h.count)
+	seen := uint64(0)
+	for idx, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(max(time.Duration(histValue(idx)), h.min), h.max)
+		}
+	}
+
+	return h.max
+}
+
+// Quantile returns the latency at the specified quantile, between 0
+// and 1; for example, 0.99 yields the 99th percentile.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.quantile(q)
+}
+
+// Merge adds the latencies recorded by another histogram.
+func (h *Histogram) Merge(o *Histogram) {
+	o.Lock()
+	counts := append([]uint64(nil), o.counts...)
+	count, sum, omin, omax := o.count, o.sum, o.min, o.max
+	o.Unlock()
+
+	if count == 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if len(counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(counts)-len(h.counts))...)
+	}
+	for idx, n := range counts {
+		h.counts[idx] += n
+	}
+	if h.count == 0 || omin < h.min {
+		h.min = omin
+	}
+	h.max = max(h.max, omax)
+	h.count += count
+	h.sum += sum
+}
+
+// Reset discards the recorded latencies.
+func (h *Histogram) Reset() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.counts = nil
+	h.count = 0
+	h.sum = 0
+	h.min = 0
+	h.max = 0
+}
+
+// HistogramSnapshot summarizes a histogram for export.
+type HistogramSnapshot struct {
+	Count uint64        `json:"count"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	P999  time.Duration `json:"p999"`
+}
+
+// Snapshot returns a summary of the histogram.
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	h.Lock()
+	defer h.Unlock()
+
+	snap := &HistogramSnapshot{
+		Count: h.count,
+		Min:   h.min,
+		Max:   h.max,
+		P50:   h.quantile(0.5),
+		P90:   h.quantile(0.9),
+		P99:   h.quantile(0.99),
+		P999:  h.quantile(0.999),
+	}
+	if h.count > 0 {
+		snap.Mean = h.sum / time.Duration(h.count)
+	}
+
+	return snap
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistBucket(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 129, 255, 256, 1000, 123456789, 1 << 62} {
+		idx := histBucket(v)
+		rep := histValue(idx)
+
+		assert.InEpsilon(t, float64(v)+1, float64(rep)+1, 0.01, "value %d", v)
+		assert.Equal(t, idx, histBucket(rep), "value %d", v)
+	}
+}
+
+func TestHistBucketMonotonic(t *testing.T) {
+	prev := 0
+	for v := uint64(0); v < 100000; v += 7 {
+		idx := histBucket(v)
+		assert.GreaterOrEqual(t, idx, prev)
+		prev = idx
+	}
+}
+
+func TestHistogramRecord(t *testing.T) {
+	obj := &Histogram{}
+
+	obj.Record(-time.Second)
+	obj.Record(10 * time.Millisecond)
+	obj.Record(20 * time.Millisecond)
+
+	assert.Equal(t, uint64(3), obj.Count())
+	snap := obj.Snapshot()
+	assert.Equal(t, time.Duration(0), snap.Min)
+	assert.Equal(t, 20*time.Millisecond, snap.Max)
+	assert.Equal(t, 10*time.Millisecond, snap.Mean)
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	obj := &Histogram{}
+	for i := 1; i <= 1000; i++ {
+		obj.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	assert.InEpsilon(t, float64(500*time.Millisecond), float64(obj.Quantile(0.5)), 0.01)
+	assert.InEpsilon(t, float64(990*time.Millisecond), float64(obj.Quantile(0.99)), 0.01)
+	assert.Equal(t, time.Millisecond, obj.Quantile(0))
+	assert.Equal(t, time.Second, obj.Quantile(1))
+}
+
+func TestHistogramQuantileEmpty(t *testing.T) {
+	obj := &Histogram{}
+
+	assert.Equal(t, time.Duration(0), obj.Quantile(0.99))
+	assert.Equal(t, &HistogramSnapshot{}, obj.Snapshot())
+}
+
+func TestHistogramMerge(t *testing.T) {
+	obj := &Histogram{}
+	obj.Record(5 * time.Millisecond)
+	other := &Histogram{}
+	other.Record(time.Millisecond)
+	other.Record(time.Second)
+
+	obj.Merge(other)
+	obj.Merge(&Histogram{})
+
+	snap := obj.Snapshot()
+	assert.Equal(t, uint64(3), snap.Count)
+	assert.Equal(t, time.Millisecond, snap.Min)
+	assert.Equal(t, time.Second, snap.Max)
+	assert.InEpsilon(t, float64(5*time.Millisecond), float64(snap.P50), 0.01)
+}
+
+func TestHistogramReset(t *testing.T) {
+	obj := &Histogram{}
+	obj.Record(time.Second)
+
+	obj.Reset()
+
+	assert.Equal(t, &HistogramSnapshot{}, obj.Snapshot())
+}
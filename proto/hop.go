@@ -0,0 +1,115 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"fmt"
+	"sync"
+)
+
+// HopHandler is implemented by handlers of a single hop-by-hop
+// extension protocol number.
+type HopHandler interface {
+	// ProcessHop processes a hop-by-hop extension of a frame
+	// transiting the node.  It returns the extension to pass on to
+	// the next hop, which may be the passed-in extension, a
+	// modified copy, or nil to strip the extension from the frame.
+	// Returning an error causes the frame to be dropped.
+	ProcessHop(f *Frame, ext *Extension) (*Extension, error)
+}
+
+// HopHandlerFunc is an adapter allowing an ordinary function to be
+// used as a HopHandler.
+type HopHandlerFunc func(f *Frame, ext *Extension) (*Extension, error)
+
+// ProcessHop processes a hop-by-hop extension by calling the
+// function.
+func (fn HopHandlerFunc) ProcessHop(f *Frame, ext *Extension) (*Extension, error) {
+	return fn(f, ext)
+}
+
+// Pipeline processes the hop-by-hop extensions of frames as they
+// transit a node, passing each to the handler registered for its
+// extension number.  Extensions without the HopByHop flag are
+// end-to-end, and pass through the pipeline untouched.  The zero
+// value is ready to use, and has no handlers registered.
+type Pipeline struct {
+	sync.RWMutex
+
+	handlers map[uint8]HopHandler
+}
+
+// Handle registers the handler for a hop-by-hop extension number,
+// replacing any existing handler.  A nil handler removes the
+// registration.
+func (p *Pipeline) Handle(number uint8, h HopHandler) {
+	p.Lock()
+	defer p.Unlock()
+
+	if h == nil {
+		delete(p.handlers, number)
+		return
+	}
+	if p.handlers == nil {
+		p.handlers = map[uint8]HopHandler{}
+	}
+	p.handlers[number] = h
+}
+
+// handler retrieves the handler for an extension number.
+func (p *Pipeline) handler(number uint8) HopHandler {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.handlers[number]
+}
+
+// Process processes the hop-by-hop extensions of a frame, returning
+// the extension chain to pass on to the next hop; the frame itself
+// is not modified.  Unknown hop-by-hop extensions with the Ignore
+// flag set are passed on untouched, so that later hops understanding
+// them may process them; any other unknown hop-by-hop extension
+// causes the frame to be rejected with an error wrapping
+// ErrUnknownExtension, which also wraps ErrCloseConduit if the Close
+// flag is set.  Errors returned by the handlers are returned as is.
+func (p *Pipeline) Process(f *Frame) (Extensions, error) {
+	result := Extensions{}
+	for _, ext := range f.Extensions {
+		if !ext.Header.HopByHop {
+			result = append(result, ext)
+			continue
+		}
+
+		h := p.handler(ext.Number)
+		switch {
+		case h != nil:
+			next, err := h.ProcessHop(f, ext)
+			if err != nil {
+				return nil, err
+			}
+			if next != nil {
+				result = append(result, next)
+			}
+		case ext.Header.Ignore:
+			result = append(result, ext)
+		case ext.Header.Close:
+			return nil, fmt.Errorf("extension %d: %w: %w", ext.Number, ErrUnknownExtension, ErrCloseConduit)
+		default:
+			return nil, fmt.Errorf("extension %d: %w", ext.Number, ErrUnknownExtension)
+		}
+	}
+
+	return result, nil
+}
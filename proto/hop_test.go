@@ -0,0 +1,132 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHopHandlerFuncProcessHop(t *testing.T) {
+	f := &Frame{}
+	ext := &Extension{Number: 0x81}
+	obj := HopHandlerFunc(func(frame *Frame, e *Extension) (*Extension, error) {
+		return e, assert.AnError
+	})
+
+	result, err := obj.ProcessHop(f, ext)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Same(t, ext, result)
+}
+
+func TestPipelineHandle(t *testing.T) {
+	h := HopHandlerFunc(func(f *Frame, ext *Extension) (*Extension, error) {
+		return ext, nil
+	})
+	obj := &Pipeline{}
+
+	obj.Handle(0x81, h)
+
+	assert.Contains(t, obj.handlers, uint8(0x81))
+}
+
+func TestPipelineHandleRemove(t *testing.T) {
+	obj := &Pipeline{}
+	obj.Handle(0x81, HopHandlerFunc(func(f *Frame, ext *Extension) (*Extension, error) {
+		return ext, nil
+	}))
+
+	obj.Handle(0x81, nil)
+
+	assert.Empty(t, obj.handlers)
+}
+
+func TestPipelineProcessBase(t *testing.T) {
+	e2e := &Extension{Number: 0x81, Header: ExtHeader{Protocol: 0x82}}
+	strip := &Extension{Number: 0x82, Header: ExtHeader{HopByHop: true, Protocol: 0x83}}
+	mark := &Extension{Number: 0x83, Header: ExtHeader{HopByHop: true, Protocol: 0x84}, Data: []byte{0}}
+	unknown := &Extension{Number: 0x84, Header: ExtHeader{HopByHop: true, Ignore: true, Protocol: 3}}
+	f := &Frame{
+		Header:     Header{Protocol: 0x81},
+		Extensions: Extensions{e2e, strip, mark, unknown},
+	}
+	marked := &Extension{Number: 0x83, Header: mark.Header, Data: []byte{1}}
+	obj := &Pipeline{}
+	obj.Handle(0x81, HopHandlerFunc(func(f *Frame, ext *Extension) (*Extension, error) {
+		panic("end-to-end extension processed")
+	}))
+	obj.Handle(0x82, HopHandlerFunc(func(f *Frame, ext *Extension) (*Extension, error) {
+		return nil, nil
+	}))
+	obj.Handle(0x83, HopHandlerFunc(func(f *Frame, ext *Extension) (*Extension, error) {
+		return marked, nil
+	}))
+
+	result, err := obj.Process(f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Extensions{e2e, marked, unknown}, result)
+	assert.Equal(t, Extensions{e2e, strip, mark, unknown}, f.Extensions)
+}
+
+func TestPipelineProcessUnknown(t *testing.T) {
+	f := &Frame{
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{HopByHop: true, Protocol: 3}},
+		},
+	}
+	obj := &Pipeline{}
+
+	result, err := obj.Process(f)
+
+	assert.ErrorIs(t, err, ErrUnknownExtension)
+	assert.False(t, errors.Is(err, ErrCloseConduit))
+	assert.Nil(t, result)
+}
+
+func TestPipelineProcessUnknownClose(t *testing.T) {
+	f := &Frame{
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{HopByHop: true, Close: true, Protocol: 3}},
+		},
+	}
+	obj := &Pipeline{}
+
+	result, err := obj.Process(f)
+
+	assert.ErrorIs(t, err, ErrUnknownExtension)
+	assert.ErrorIs(t, err, ErrCloseConduit)
+	assert.Nil(t, result)
+}
+
+func TestPipelineProcessHandlerError(t *testing.T) {
+	f := &Frame{
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{HopByHop: true, Protocol: 3}},
+		},
+	}
+	obj := &Pipeline{}
+	obj.Handle(0x81, HopHandlerFunc(func(f *Frame, ext *Extension) (*Extension, error) {
+		return nil, assert.AnError
+	}))
+
+	result, err := obj.Process(f)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultLatencyTimeout is the default time after which a request
+// without a reply is no longer tracked.
+const DefaultLatencyTimeout = 30 * time.Second
+
+// latencyKey identifies an outstanding request to a peer.
+type latencyKey struct {
+	peer NodeID     // The peer the request was sent to
+	req  RequestKey // The request
+}
+
+// LatencyTracker measures the round-trip times of requests to each
+// peer, correlating replies with requests by protocol number and
+// correlation ID, and maintains a latency histogram for each peer.
+// The zero value is ready to use with the default timeout.
+type LatencyTracker struct {
+	sync.Mutex
+
+	Timeout time.Duration // Time after which unanswered requests are forgotten
+
+	pending map[latencyKey]time.Time // Send times of outstanding requests
+	peers   map[NodeID]*Histogram    // Latency histograms of the peers
+}
+
+// expire forgets requests that have not been answered within the
+// timeout.  Must be called with the lock held.
+func (lt *LatencyTracker) expire(now time.Time) {
+	timeout := lt.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLatencyTimeout
+	}
+
+	for key, sent := range lt.pending {
+		if now.Sub(sent) >= timeout {
+			delete(lt.pending, key)
+		}
+	}
+}
+
+// Sent is called with the header and correlation ID of a PDU sent to
+// a peer.  Requests are tracked until their replies are received;
+// PDUs with the Reply bit set are ignored.
+func (lt *LatencyTracker) Sent(peer NodeID, hdr *Header, id uint32) {
+	if hdr.Reply {
+		return
+	}
+	now := timeNow()
+
+	lt.Lock()
+	defer lt.Unlock()
+
+	if lt.pending == nil {
+		lt.pending = map[latencyKey]time.Time{}
+	}
+	lt.expire(now)
+	lt.pending[latencyKey{peer: peer, req: RequestKey{Protocol: hdr.Protocol, ID: id}}] = now
+}
+
+// Received is called with the header and correlation ID of a PDU
+// received from a peer.  If it is the reply to a tracked request, the
+// round-trip time is recorded in the peer's histogram and returned
+// along with true.
+func (lt *LatencyTracker) Received(peer NodeID, hdr *Header, id uint32) (time.Duration, bool) {
+	if !hdr.Reply {
+		return 0, false
+	}
+	now := timeNow()
+
+	lt.Lock()
+	key := latencyKey{peer: peer, req: RequestKey{Protocol: hdr.Protocol, ID: id}}
+	sent, ok := lt.pending[key]
+	if !ok {
+		lt.Unlock()
+		return 0, false
+	}
+	delete(lt.pending, key)
+	if lt.peers == nil {
+		lt.peers = map[NodeID]*Histogram{}
+	}
+	h, ok := lt.peers[peer]
+	if !ok {
+		h = &Histogram{}
+		lt.peers[peer] = h
+	}
+	lt.Unlock()
+
+	rtt := now.Sub(sent)
+	h.Record(rtt)
+
+	return rtt, true
+}
+
+// Histogram returns the latency histogram of a peer, or nil if no
+// round trips to the peer have been measured.
+func (lt *LatencyTracker) Histogram(peer NodeID) *Histogram {
+	lt.Lock()
+	defer lt.Unlock()
+
+	return lt.peers[peer]
+}
+
+// Forget discards the outstanding requests and the histogram of a
+// peer, such as when the conduit to it is closed.
+func (lt *LatencyTracker) Forget(peer NodeID) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	for key := range lt.pending {
+		if key.peer == peer {
+			delete(lt.pending, key)
+		}
+	}
+	delete(lt.peers, peer)
+}
+
+// Snapshot returns summaries of the latency histograms of all peers,
+// suitable for export to metrics systems.
+func (lt *LatencyTracker) Snapshot() map[NodeID]*HistogramSnapshot {
+	lt.Lock()
+	peers := make(map[NodeID]*Histogram, len(lt.peers))
+	for peer, h := range lt.peers {
+		peers[peer] = h
+	}
+	lt.Unlock()
+
+	result := make(map[NodeID]*HistogramSnapshot, len(peers))
+	for peer, h := range peers {
+		result[peer] = h.Snapshot()
+	}
+
+	return result
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+// latencyClock returns a patcher installing a clock that advances by
+// the specified step on each call.
+func latencyClock(step time.Duration) patcher.Patcher {
+	now := time.Unix(1000, 0)
+	return patcher.SetVar(&timeNow, func() time.Time {
+		now = now.Add(step)
+		return now
+	})
+}
+
+func TestLatencyTrackerRoundTrip(t *testing.T) {
+	defer latencyClock(10 * time.Millisecond).Install().Restore()
+	obj := &LatencyTracker{}
+	peer := NodeID{1}
+
+	obj.Sent(peer, &Header{Protocol: 5}, 42)
+	rtt, ok := obj.Received(peer, &Header{Protocol: 5, Reply: true}, 42)
+
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, rtt)
+	assert.Equal(t, uint64(1), obj.Histogram(peer).Count())
+	_, ok = obj.Received(peer, &Header{Protocol: 5, Reply: true}, 42)
+	assert.False(t, ok)
+}
+
+func TestLatencyTrackerIgnored(t *testing.T) {
+	obj := &LatencyTracker{}
+	peer := NodeID{1}
+
+	obj.Sent(peer, &Header{Protocol: 5, Reply: true}, 42)
+	obj.Sent(peer, &Header{Protocol: 5}, 43)
+	_, ok1 := obj.Received(peer, &Header{Protocol: 5}, 43)
+	_, ok2 := obj.Received(NodeID{2}, &Header{Protocol: 5, Reply: true}, 43)
+	_, ok3 := obj.Received(peer, &Header{Protocol: 6, Reply: true}, 43)
+
+	assert.False(t, ok1)
+	assert.False(t, ok2)
+	assert.False(t, ok3)
+	assert.Nil(t, obj.Histogram(peer))
+}
+
+func TestLatencyTrackerTimeout(t *testing.T) {
+	defer latencyClock(time.Second).Install().Restore()
+	obj := &LatencyTracker{Timeout: time.Second}
+	peer := NodeID{1}
+
+	obj.Sent(peer, &Header{Protocol: 5}, 1)
+	obj.Sent(peer, &Header{Protocol: 5}, 2)
+
+	assert.Len(t, obj.pending, 1)
+}
+
+func TestLatencyTrackerForget(t *testing.T) {
+	obj := &LatencyTracker{}
+	peer := NodeID{1}
+	obj.Sent(peer, &Header{Protocol: 5}, 1)
+	obj.Received(peer, &Header{Protocol: 5, Reply: true}, 1)
+	obj.Sent(peer, &Header{Protocol: 5}, 2)
+	obj.Sent(NodeID{2}, &Header{Protocol: 5}, 2)
+
+	obj.Forget(peer)
+
+	assert.Nil(t, obj.Histogram(peer))
+	assert.Len(t, obj.pending, 1)
+}
+
+func TestLatencyTrackerSnapshot(t *testing.T) {
+	defer latencyClock(time.Millisecond).Install().Restore()
+	obj := &LatencyTracker{}
+	obj.Sent(NodeID{1}, &Header{Protocol: 5}, 1)
+	obj.Received(NodeID{1}, &Header{Protocol: 5, Reply: true}, 1)
+
+	result := obj.Snapshot()
+
+	assert.Len(t, result, 1)
+	assert.Equal(t, time.Millisecond, result[NodeID{1}].P99)
+	data, err := json.Marshal(result)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"01000000000000000000000000000000":{"count":1`)
+}
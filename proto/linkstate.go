@@ -0,0 +1,128 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ProtoLinkState is the protocol number of the link-state protocol,
+// which carries descriptions of the links of each node so that every
+// node may compute routes through the overlay.
+const ProtoLinkState uint8 = 1
+
+// Sizes of the components of an encoded link-state record.
+const (
+	LinkStateHeaderSize int = NodeIDSize + 8 + 2 // Origin, sequence, and link count
+	LinkSize            int = NodeIDSize + 4     // Neighbor and cost
+)
+
+// MaxLinkCost is the greatest cost a link may be advertised with;
+// greater costs are advertised as MaxLinkCost.
+const MaxLinkCost uint32 = 1<<31 - 1
+
+// linkAnycast is the bit of an encoded link cost flagging an anycast
+// claim.
+const linkAnycast uint32 = 1 << 31
+
+// Link describes a link from a node to one of its neighbors.  A link
+// may instead describe a claim of an anycast destination, identified
+// by AnycastID, by the node; claims are encoded with the high bit of
+// the cost set, which nodes that do not implement anycast see as a
+// link of prohibitive cost to a node which does not advertise a link
+// back, and so never route over.
+type Link struct {
+	Neighbor NodeID // Identifier of the neighbor, or of the anycast destination
+	Cost     uint32 // Cost of sending over the link
+	Anycast  bool   // The link is a claim of an anycast destination
+}
+
+// LinkState is a link-state record, describing the links of a node.
+// Each node originates records for its own links, incrementing the
+// sequence number whenever they change, so that receivers may discard
+// stale records.
+type LinkState struct {
+	Origin   NodeID // Identifier of the node originating the record
+	Sequence uint64 // Sequence number of the record
+	Links    []Link // Links of the originating node
+}
+
+// Encode encodes the link-state record.  The record must fit in the
+// payload of a single PDU.
+func (ls *LinkState) Encode() ([]byte, error) {
+	if LinkStateHeaderSize+len(ls.Links)*LinkSize > MaxLength {
+		return nil, fmt.Errorf("link-state links: %w", ErrTooLong)
+	}
+
+	data := make([]byte, 0, LinkStateHeaderSize+len(ls.Links)*LinkSize)
+	data = append(data, ls.Origin[:]...)
+	data = binary.BigEndian.AppendUint64(data, ls.Sequence)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(ls.Links)))
+	for _, l := range ls.Links {
+		cost := min(l.Cost, MaxLinkCost)
+		if l.Anycast {
+			cost |= linkAnycast
+		}
+		data = append(data, l.Neighbor[:]...)
+		data = binary.BigEndian.AppendUint32(data, cost)
+	}
+
+	return data, nil
+}
+
+// DecodeLinkState decodes a link-state record.
+func DecodeLinkState(data []byte) (*LinkState, error) {
+	if len(data) < LinkStateHeaderSize {
+		return nil, ErrShortInput
+	}
+	ls := &LinkState{}
+	copy(ls.Origin[:], data)
+	ls.Sequence = binary.BigEndian.Uint64(data[NodeIDSize:])
+	count := int(binary.BigEndian.Uint16(data[NodeIDSize+8:]))
+	data = data[LinkStateHeaderSize:]
+
+	switch {
+	case len(data) < count*LinkSize:
+		return nil, ErrShortInput
+	case len(data) > count*LinkSize:
+		return nil, ErrBadLength
+	}
+	ls.Links = make([]Link, count)
+	for i := range ls.Links {
+		copy(ls.Links[i].Neighbor[:], data)
+		cost := binary.BigEndian.Uint32(data[NodeIDSize:])
+		ls.Links[i].Cost = cost &^ linkAnycast
+		ls.Links[i].Anycast = cost&linkAnycast != 0
+		data = data[LinkSize:]
+	}
+
+	return ls, nil
+}
+
+// Frame constructs a frame carrying the link-state record.
+func (ls *LinkState) Frame() (*Frame, error) {
+	payload, err := ls.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoLinkState,
+		},
+		Payload: payload,
+	}, nil
+}
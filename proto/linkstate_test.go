@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLinkStateData = []byte{
+	0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2a,
+	0x00, 0x02,
+	0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x0a,
+	0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x01, 0x00, 0x00,
+}
+
+func testLinkState() *LinkState {
+	return &LinkState{
+		Origin:   NodeID{0x01, 0x02},
+		Sequence: 42,
+		Links: []Link{
+			{Neighbor: NodeID{0x03}, Cost: 10},
+			{Neighbor: NodeID{0x04}, Cost: 0x10000},
+		},
+	}
+}
+
+func TestLinkStateEncodeBase(t *testing.T) {
+	obj := testLinkState()
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testLinkStateData, result)
+}
+
+func TestLinkStateEncodeAnycast(t *testing.T) {
+	obj := &LinkState{Links: []Link{
+		{Neighbor: NodeID{0x03}, Cost: 10, Anycast: true},
+		{Neighbor: NodeID{0x04}, Cost: 0xffffffff},
+	}}
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x80, 0x00, 0x00, 0x0a}, result[LinkStateHeaderSize+NodeIDSize:LinkStateHeaderSize+LinkSize])
+	assert.Equal(t, []byte{0x7f, 0xff, 0xff, 0xff}, result[LinkStateHeaderSize+LinkSize+NodeIDSize:])
+}
+
+func TestLinkStateEncodeTooLong(t *testing.T) {
+	obj := &LinkState{Links: make([]Link, (MaxLength-LinkStateHeaderSize)/LinkSize+1)}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestLinkStateEncodeMax(t *testing.T) {
+	obj := &LinkState{Links: make([]Link, (MaxLength-LinkStateHeaderSize)/LinkSize)}
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(result), MaxLength)
+}
+
+func TestDecodeLinkStateBase(t *testing.T) {
+	result, err := DecodeLinkState(testLinkStateData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testLinkState(), result)
+}
+
+func TestDecodeLinkStateAnycast(t *testing.T) {
+	obj := &LinkState{Origin: NodeID{0x01}, Links: []Link{
+		{Neighbor: NodeID{0x03}, Cost: 10, Anycast: true},
+		{Neighbor: NodeID{0x04}, Cost: MaxLinkCost},
+	}}
+	data, err := obj.Encode()
+	assert.NoError(t, err)
+
+	result, err := DecodeLinkState(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, obj, result)
+}
+
+func TestDecodeLinkStateEmpty(t *testing.T) {
+	obj := &LinkState{Origin: NodeID{1}, Sequence: 1}
+	data, _ := obj.Encode()
+
+	result, err := DecodeLinkState(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &LinkState{Origin: NodeID{1}, Sequence: 1, Links: []Link{}}, result)
+}
+
+func TestDecodeLinkStateShortHeader(t *testing.T) {
+	result, err := DecodeLinkState(testLinkStateData[:LinkStateHeaderSize-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeLinkStateShortLinks(t *testing.T) {
+	result, err := DecodeLinkState(testLinkStateData[:len(testLinkStateData)-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeLinkStateTrailing(t *testing.T) {
+	result, err := DecodeLinkState(append(append([]byte{}, testLinkStateData...), 0))
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestLinkStateFrameBase(t *testing.T) {
+	obj := testLinkState()
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoLinkState},
+		Payload: testLinkStateData,
+	}, result)
+}
+
+func TestLinkStateFrameError(t *testing.T) {
+	obj := &LinkState{Links: make([]Link, MaxLength)}
+
+	result, err := obj.Frame()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,336 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Version is the version of the Humboldt protocol implemented by this
+// package.  Protocol version 0 is the negotiation itself.
+const Version uint32 = 1
+
+// HelloSize is the size of the body of a hello message.  A hello
+// giving a range of major versions of the wire format other than the
+// default, major version 0 alone, is extended to HelloMajorSize.
+const (
+	HelloSize      int = 8 + NodeIDSize
+	HelloMajorSize int = HelloSize + 2
+)
+
+// bindContext separates channel binding proofs from other uses of the
+// identity key.
+const bindContext = "humboldt channel binding\x00"
+
+// Hello describes the body of a hello message, which is exchanged by
+// both sides of a new conduit to negotiate the protocol version and
+// the major version of the wire format.
+type Hello struct {
+	MinProto uint32 // Minimum supported protocol version
+	MaxProto uint32 // Maximum supported protocol version
+	NodeID   NodeID // Identifier of the sending node
+	MinMajor uint8  // Minimum supported major version of the wire format
+	MaxMajor uint8  // Maximum supported major version of the wire format
+}
+
+// FromBytes is a method of Hello that fills in the information from
+// the body of a hello message.  A hello that is not extended with a
+// range of major versions gives major version 0 alone; peers that
+// predate the range send such hellos, and ignore the range in the
+// hellos they receive.
+func (h *Hello) FromBytes(data []byte) (int, error) {
+	// Make sure we have enough data
+	if len(data) < HelloSize {
+		return 0, ErrShortInput
+	}
+
+	// Fill in the hello
+	h.MinProto = binary.BigEndian.Uint32(data[0:4])
+	h.MaxProto = binary.BigEndian.Uint32(data[4:8])
+	copy(h.NodeID[:], data[8:HelloSize])
+	h.MinMajor = 0
+	h.MaxMajor = 0
+	if len(data) < HelloMajorSize {
+		return HelloSize, nil
+	}
+	h.MinMajor = data[HelloSize]
+	h.MaxMajor = data[HelloSize+1]
+
+	return HelloMajorSize, nil
+}
+
+// Size returns the size of the encoded hello, which is HelloMajorSize
+// if it gives a range of major versions other than the default.
+func (h *Hello) Size() int {
+	if h.MinMajor == 0 && h.MaxMajor == 0 {
+		return HelloSize
+	}
+
+	return HelloMajorSize
+}
+
+// ToBytes is a method of Hello that encodes the hello into a sequence
+// of bytes.  The byte slice to fill in must be passed in, and must be
+// at least Size bytes long.
+func (h *Hello) ToBytes(data []byte) (int, error) {
+	// Make sure we have enough space
+	size := h.Size()
+	if len(data) < size {
+		return 0, ErrShortOutput
+	}
+
+	// Fill in the data
+	binary.BigEndian.PutUint32(data[0:4], h.MinProto)
+	binary.BigEndian.PutUint32(data[4:8], h.MaxProto)
+	copy(data[8:HelloSize], h.NodeID[:])
+	if size == HelloMajorSize {
+		data[HelloSize] = h.MinMajor
+		data[HelloSize+1] = h.MaxMajor
+	}
+
+	return size, nil
+}
+
+// bytes returns the encoding of the hello.
+func (h *Hello) bytes() []byte {
+	body := make([]byte, h.Size())
+	h.ToBytes(body) //nolint:errcheck
+
+	return body
+}
+
+// IsClient tests whether the hello was sent by a client.  Clients are
+// not nodes of the overlay, and send the zero node identifier.
+func (h *Hello) IsClient() bool {
+	return h.NodeID.IsZero()
+}
+
+// Negotiation describes the result of a successful protocol
+// negotiation.
+type Negotiation struct {
+	Proto uint32 // The selected protocol version
+	Major uint8  // The selected major version of the wire format
+	Peer  Hello  // The hello sent by the peer
+	Bound bool   // The peer's channel binding proof was verified
+}
+
+// Negotiator implements the protocol 0 negotiation performed when a
+// conduit is established.  Both sides send a hello message giving
+// the range of protocol versions they support and their node
+// identifiers; each side then selects the highest version supported
+// by both.  Since the exchange is symmetric, both sides select the
+// same version without a further round trip.  If the peer sends the
+// node identifier of this node, the conduit connects the node to
+// itself, and the negotiation fails with ErrSelfConnection.  Clients,
+// which use the overlay without being nodes of it, negotiate with the
+// zero node identifier.
+//
+// The major version of the wire format used for the PDUs exchanged
+// once the negotiation completes is selected in the same way, as the
+// highest major version supported by both sides; the negotiation
+// itself uses major version 0.  Both sides must have a codec
+// registered for each major version in their range; see
+// RegisterMajorCodec.  Peers that predate the negotiation of the
+// major version support major version 0 alone.
+//
+// The negotiation may be bound to the secure channel underlying the
+// conduit.  If a Signer is set, this node proves possession of its
+// identity key by signing the channel binding along with both hello
+// messages; if a Verifier is set, the peer must send such a proof.
+// Since a man in the middle terminating the secure channel sees a
+// different channel binding on each side, it cannot relay the proofs.
+type Negotiator struct {
+	MinProto uint32   // Minimum supported protocol version
+	MaxProto uint32   // Maximum supported protocol version
+	MinMajor uint8    // Minimum supported major version of the wire format
+	MaxMajor uint8    // Maximum supported major version of the wire format
+	NodeID   NodeID   // Identifier of this node
+	Binding  []byte   // Channel binding of the underlying secure channel
+	Signer   Signer   // Signs the channel binding proof, if set
+	Verifier Verifier // Verifies the peer's channel binding proof, if set
+}
+
+// bindData returns the data covered by a channel binding proof
+// signed by the sender of the first hello.  Only the fields common to
+// all hellos are covered, since peers that predate the range of major
+// versions do not see it; the range is protected by the secure
+// channel the proof binds.
+func bindData(binding []byte, signer, other *Hello) []byte {
+	data := append([]byte(bindContext), binding...)
+	data = append(data, signer.bytes()[:HelloSize]...)
+
+	return append(data, other.bytes()[:HelloSize]...)
+}
+
+// selectProto selects the protocol version given the peer's hello.
+func (n *Negotiator) selectProto(peer *Hello) (uint32, error) {
+	lo := max(n.MinProto, peer.MinProto)
+	hi := min(n.MaxProto, peer.MaxProto)
+	if lo > hi {
+		return 0, fmt.Errorf("local %d-%d, peer %d-%d: %w", n.MinProto, n.MaxProto, peer.MinProto, peer.MaxProto, ErrNoCommonVersion)
+	}
+
+	return hi, nil
+}
+
+// selectMajor selects the major version of the wire format given the
+// peer's hello.
+func (n *Negotiator) selectMajor(peer *Hello) (uint8, error) {
+	lo := max(n.MinMajor, peer.MinMajor)
+	hi := min(n.MaxMajor, peer.MaxMajor)
+	if lo > hi {
+		return 0, fmt.Errorf("major local %d-%d, peer %d-%d: %w", n.MinMajor, n.MaxMajor, peer.MinMajor, peer.MaxMajor, ErrNoCommonVersion)
+	}
+
+	return hi, nil
+}
+
+// readControl reads a control message of the specified type from
+// the peer, returning its body.
+func readControl(r *Reader, t ControlType) ([]byte, error) {
+	f, err := r.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	if f.Protocol() != ProtoControl {
+		return nil, fmt.Errorf("protocol %d: %w", f.Protocol(), ErrNegotiation)
+	}
+	msg := &ControlMessage{}
+	if _, err := msg.FromBytes(f.Payload); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNegotiation, err)
+	}
+	if msg.Type != t {
+		return nil, fmt.Errorf("message type %d: %w", msg.Type, ErrNegotiation)
+	}
+
+	return msg.Body, nil
+}
+
+// readHello reads the peer's hello message.
+func readHello(r *Reader) (*Hello, error) {
+	body, err := readControl(r, ControlHello)
+	if err != nil {
+		return nil, err
+	}
+	hello := &Hello{}
+	if _, err := hello.FromBytes(body); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNegotiation, err)
+	}
+
+	return hello, nil
+}
+
+// Negotiate performs the negotiation over the specified link, which
+// must be a byte stream.  The hello message is sent while the peer's
+// hello is read, so the negotiation succeeds over unbuffered links.
+func (n *Negotiator) Negotiate(link io.ReadWriter) (*Negotiation, error) {
+	return n.NegotiateFrames(NewReader(link), NewWriter(link))
+}
+
+// NegotiateFrames is like Negotiate, but reads and writes the hello
+// messages using the specified Reader and Writer, which allows the
+// negotiation to take place over links preserving message
+// boundaries.
+func (n *Negotiator) NegotiateFrames(r *Reader, w *Writer) (*Negotiation, error) {
+	// Construct and send the hello
+	hello := &Hello{
+		MinProto: n.MinProto,
+		MaxProto: n.MaxProto,
+		NodeID:   n.NodeID,
+		MinMajor: n.MinMajor,
+		MaxMajor: n.MaxMajor,
+	}
+	msg := &ControlMessage{Type: ControlHello, Body: hello.bytes()}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- w.WriteFrame(msg.Frame())
+	}()
+
+	// Read the peer's hello
+	peer, err := readHello(r)
+	if sendErr := <-sent; sendErr != nil {
+		return nil, sendErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Detect a conduit to this node itself
+	if peer.NodeID == n.NodeID && !n.NodeID.IsZero() {
+		return nil, fmt.Errorf("node %s: %w", peer.NodeID, ErrSelfConnection)
+	}
+
+	// Select the protocol version
+	proto, err := n.selectProto(peer)
+	if err != nil {
+		return nil, err
+	}
+	major, err := n.selectMajor(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exchange the channel binding proofs
+	bound, err := n.bind(r, w, hello, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Negotiation{
+		Proto: proto,
+		Major: major,
+		Peer:  *peer,
+		Bound: bound,
+	}, nil
+}
+
+// bind sends this node's channel binding proof if there is a Signer,
+// and reads and verifies the peer's if there is a Verifier.  It
+// returns true if the peer's proof was verified.
+func (n *Negotiator) bind(r *Reader, w *Writer, hello, peer *Hello) (bool, error) {
+	// Send the proof
+	sent := make(chan error, 1)
+	if n.Signer != nil {
+		sig, err := n.Signer.Sign(bindData(n.Binding, hello, peer))
+		if err != nil {
+			return false, err
+		}
+		msg := &ControlMessage{Type: ControlBind, Body: sig}
+		go func() {
+			sent <- w.WriteFrame(msg.Frame())
+		}()
+	} else {
+		sent <- nil
+	}
+
+	// Read and verify the peer's proof
+	var err error
+	if n.Verifier != nil {
+		var sig []byte
+		if sig, err = readControl(r, ControlBind); err == nil {
+			if verr := n.Verifier.Verify(peer.NodeID, bindData(n.Binding, peer, hello), sig); verr != nil {
+				err = fmt.Errorf("node %s: %w: %w", peer.NodeID, ErrBadBinding, verr)
+			}
+		}
+	}
+	if sendErr := <-sent; sendErr != nil {
+		return false, sendErr
+	} else if err != nil {
+		return false, err
+	}
+
+	return n.Verifier != nil, nil
+}
@@ -0,0 +1,498 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testHelloData = []byte{
+	0x00, 0x00, 0x00, 0x01,
+	0x00, 0x00, 0x00, 0x03,
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+}
+
+var testHello = &Hello{
+	MinProto: 1,
+	MaxProto: 3,
+	NodeID:   NodeID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+}
+
+// testLink is an io.ReadWriter that reads from one buffer and writes
+// to another.
+type testLink struct {
+	r   io.Reader
+	w   bytes.Buffer
+	err error
+}
+
+func (l *testLink) Read(p []byte) (int, error) {
+	return l.r.Read(p)
+}
+
+func (l *testLink) Write(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	return l.w.Write(p)
+}
+
+func helloFrame(t *testing.T, h *Hello) []byte {
+	body := make([]byte, HelloSize)
+	h.ToBytes(body) //nolint:errcheck
+	msg := &ControlMessage{Type: ControlHello, Body: body}
+	f := msg.Frame()
+	data := make([]byte, f.Size())
+	_, err := f.ToBytes(data)
+	assert.NoError(t, err)
+
+	return data
+}
+
+func TestHelloFromBytesBase(t *testing.T) {
+	obj := &Hello{}
+
+	result, err := obj.FromBytes(testHelloData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, HelloSize, result)
+	assert.Equal(t, testHello, obj)
+}
+
+func TestHelloFromBytesShort(t *testing.T) {
+	obj := &Hello{}
+
+	result, err := obj.FromBytes(testHelloData[:HelloSize-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Equal(t, 0, result)
+	assert.Equal(t, &Hello{}, obj)
+}
+
+func TestHelloToBytesBase(t *testing.T) {
+	data := make([]byte, HelloSize)
+
+	result, err := testHello.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, HelloSize, result)
+	assert.Equal(t, testHelloData, data)
+}
+
+func TestHelloToBytesShort(t *testing.T) {
+	data := make([]byte, HelloSize-1)
+
+	result, err := testHello.ToBytes(data)
+
+	assert.ErrorIs(t, err, ErrShortOutput)
+	assert.Equal(t, 0, result)
+}
+
+func TestHelloFromBytesMajors(t *testing.T) {
+	obj := &Hello{MinMajor: 5, MaxMajor: 5}
+
+	result, err := obj.FromBytes(append(append([]byte{}, testHelloData...), 0x01, 0x02))
+
+	assert.NoError(t, err)
+	assert.Equal(t, HelloMajorSize, result)
+	assert.Equal(t, &Hello{
+		MinProto: 1,
+		MaxProto: 3,
+		NodeID:   testHello.NodeID,
+		MinMajor: 1,
+		MaxMajor: 2,
+	}, obj)
+}
+
+func TestHelloFromBytesLegacy(t *testing.T) {
+	obj := &Hello{MinMajor: 5, MaxMajor: 5}
+
+	result, err := obj.FromBytes(append(append([]byte{}, testHelloData...), 0x01))
+
+	assert.NoError(t, err)
+	assert.Equal(t, HelloSize, result)
+	assert.Equal(t, testHello, obj)
+}
+
+func TestHelloSize(t *testing.T) {
+	assert.Equal(t, HelloSize, testHello.Size())
+	assert.Equal(t, HelloMajorSize, (&Hello{MaxMajor: 1}).Size())
+}
+
+func TestHelloToBytesMajors(t *testing.T) {
+	obj := *testHello
+	obj.MinMajor = 1
+	obj.MaxMajor = 2
+	data := make([]byte, HelloMajorSize)
+
+	result, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, HelloMajorSize, result)
+	assert.Equal(t, append(append([]byte{}, testHelloData...), 0x01, 0x02), data)
+}
+
+func TestHelloToBytesMajorsShort(t *testing.T) {
+	obj := &Hello{MaxMajor: 1}
+
+	result, err := obj.ToBytes(make([]byte, HelloSize))
+
+	assert.Same(t, ErrShortOutput, err)
+	assert.Equal(t, 0, result)
+}
+
+func TestNegotiatorSelectProtoBase(t *testing.T) {
+	obj := &Negotiator{MinProto: 2, MaxProto: 5}
+
+	result, err := obj.selectProto(testHello)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), result)
+}
+
+func TestNegotiatorSelectProtoNoCommon(t *testing.T) {
+	obj := &Negotiator{MinProto: 4, MaxProto: 5}
+
+	result, err := obj.selectProto(testHello)
+
+	assert.ErrorIs(t, err, ErrNoCommonVersion)
+	assert.Equal(t, uint32(0), result)
+}
+
+func TestNegotiatorSelectMajorBase(t *testing.T) {
+	obj := &Negotiator{MinMajor: 0, MaxMajor: 2}
+
+	result, err := obj.selectMajor(&Hello{MinMajor: 1, MaxMajor: 3})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2), result)
+}
+
+func TestNegotiatorSelectMajorNoCommon(t *testing.T) {
+	obj := &Negotiator{MinMajor: 2, MaxMajor: 3}
+
+	result, err := obj.selectMajor(testHello)
+
+	assert.ErrorIs(t, err, ErrNoCommonVersion)
+	assert.Equal(t, uint8(0), result)
+}
+
+func TestReadHelloBase(t *testing.T) {
+	result, err := readHello(NewReader(bytes.NewReader(helloFrame(t, testHello))))
+
+	assert.NoError(t, err)
+	assert.Equal(t, testHello, result)
+}
+
+func TestReadHelloReadError(t *testing.T) {
+	result, err := readHello(NewReader(bytes.NewReader([]byte{})))
+
+	assert.Same(t, io.EOF, err)
+	assert.Nil(t, result)
+}
+
+func TestReadHelloWrongProtocol(t *testing.T) {
+	result, err := readHello(NewReader(bytes.NewReader([]byte{0x00, 0x17, 0x00, 0x00})))
+
+	assert.ErrorIs(t, err, ErrNegotiation)
+	assert.Nil(t, result)
+}
+
+func TestReadHelloEmptyMessage(t *testing.T) {
+	result, err := readHello(NewReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00})))
+
+	assert.ErrorIs(t, err, ErrNegotiation)
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestReadHelloWrongType(t *testing.T) {
+	result, err := readHello(NewReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x01, 0x01})))
+
+	assert.ErrorIs(t, err, ErrNegotiation)
+	assert.Nil(t, result)
+}
+
+func TestReadHelloShortHello(t *testing.T) {
+	result, err := readHello(NewReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x02, 0x03, 0x00})))
+
+	assert.ErrorIs(t, err, ErrNegotiation)
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestHelloIsClient(t *testing.T) {
+	assert.True(t, (&Hello{MaxProto: 1}).IsClient())
+	assert.False(t, (&Hello{MaxProto: 1, NodeID: NodeID{1}}).IsClient())
+}
+
+func TestNegotiatorNegotiateBase(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1 := &Negotiator{MinProto: 0, MaxProto: 2, NodeID: NodeID{1}}
+	n2 := &Negotiator{MinProto: 1, MaxProto: 4, NodeID: NodeID{2}}
+	done := make(chan struct{})
+	var result2 *Negotiation
+	var err2 error
+	go func() {
+		defer close(done)
+		result2, err2 = n2.Negotiate(c2)
+	}()
+
+	result1, err1 := n1.Negotiate(c1)
+	<-done
+
+	assert.NoError(t, err1)
+	assert.Equal(t, &Negotiation{
+		Proto: 2,
+		Peer:  Hello{MinProto: 1, MaxProto: 4, NodeID: NodeID{2}},
+	}, result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &Negotiation{
+		Proto: 2,
+		Peer:  Hello{MinProto: 0, MaxProto: 2, NodeID: NodeID{1}},
+	}, result2)
+}
+
+func TestNegotiatorNegotiateFrames(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1 := &Negotiator{MinProto: 0, MaxProto: 2, NodeID: NodeID{1}}
+	n2 := &Negotiator{MinProto: 1, MaxProto: 4, NodeID: NodeID{2}}
+	done := make(chan struct{})
+	var result2 *Negotiation
+	var err2 error
+	go func() {
+		defer close(done)
+		result2, err2 = n2.NegotiateFrames(NewMessageReader(c2), NewWriter(c2))
+	}()
+
+	result1, err1 := n1.NegotiateFrames(NewMessageReader(c1), NewWriter(c1))
+	<-done
+
+	assert.NoError(t, err1)
+	assert.Equal(t, &Negotiation{
+		Proto: 2,
+		Peer:  Hello{MinProto: 1, MaxProto: 4, NodeID: NodeID{2}},
+	}, result1)
+	assert.NoError(t, err2)
+	assert.Equal(t, &Negotiation{
+		Proto: 2,
+		Peer:  Hello{MinProto: 0, MaxProto: 2, NodeID: NodeID{1}},
+	}, result2)
+}
+
+func TestNegotiatorNegotiateSelf(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	obj := &Negotiator{MaxProto: 1, NodeID: NodeID{1}}
+	done := make(chan error, 1)
+	go func() {
+		_, err := obj.Negotiate(c2)
+		done <- err
+	}()
+
+	result, err := obj.Negotiate(c1)
+
+	assert.ErrorIs(t, err, ErrSelfConnection)
+	assert.Nil(t, result)
+	assert.ErrorIs(t, <-done, ErrSelfConnection)
+}
+
+func TestNegotiatorNegotiateWriteError(t *testing.T) {
+	link := &testLink{
+		r:   bytes.NewReader(helloFrame(t, testHello)),
+		err: assert.AnError,
+	}
+	obj := &Negotiator{MinProto: 1, MaxProto: 1}
+
+	result, err := obj.Negotiate(link)
+
+	assert.Same(t, assert.AnError, err)
+	assert.Nil(t, result)
+}
+
+func TestNegotiatorNegotiateReadError(t *testing.T) {
+	link := &testLink{
+		r: bytes.NewReader([]byte{}),
+	}
+	obj := &Negotiator{MinProto: 1, MaxProto: 1}
+
+	result, err := obj.Negotiate(link)
+
+	assert.Same(t, io.EOF, err)
+	assert.Nil(t, result)
+	assert.Equal(t, helloFrame(t, &Hello{MinProto: 1, MaxProto: 1}), link.w.Bytes())
+}
+
+func TestNegotiatorNegotiateNoCommon(t *testing.T) {
+	link := &testLink{
+		r: bytes.NewReader(helloFrame(t, testHello)),
+	}
+	obj := &Negotiator{MinProto: 4, MaxProto: 5}
+
+	result, err := obj.Negotiate(link)
+
+	assert.ErrorIs(t, err, ErrNoCommonVersion)
+	assert.Nil(t, result)
+}
+
+// boundNegotiators returns a pair of negotiators proving possession
+// of their identity keys over the specified channel bindings.
+func boundNegotiators(b1, b2 []byte) (*Negotiator, *Negotiator) {
+	pub, priv := testKeys()
+	keys := Ed25519Keys{NodeID{1}: pub, NodeID{2}: pub}
+	n1 := &Negotiator{MaxProto: 1, NodeID: NodeID{1}, Binding: b1, Signer: Ed25519Signer(priv), Verifier: keys}
+	n2 := &Negotiator{MaxProto: 1, NodeID: NodeID{2}, Binding: b2, Signer: Ed25519Signer(priv), Verifier: keys}
+
+	return n1, n2
+}
+
+func TestNegotiatorNegotiateBound(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1, n2 := boundNegotiators([]byte("binding"), []byte("binding"))
+	done := make(chan struct{})
+	var result2 *Negotiation
+	var err2 error
+	go func() {
+		defer close(done)
+		result2, err2 = n2.Negotiate(c2)
+	}()
+
+	result1, err1 := n1.Negotiate(c1)
+	<-done
+
+	assert.NoError(t, err1)
+	assert.True(t, result1.Bound)
+	assert.NoError(t, err2)
+	assert.True(t, result2.Bound)
+}
+
+func TestNegotiatorNegotiateMajors(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1 := &Negotiator{MinProto: 1, MaxProto: 1, MinMajor: 0, MaxMajor: 1, NodeID: NodeID{1}}
+	n2 := &Negotiator{MinProto: 1, MaxProto: 1, MinMajor: 1, MaxMajor: 2, NodeID: NodeID{2}}
+	done := make(chan struct{})
+	var result2 *Negotiation
+	var err2 error
+	go func() {
+		defer close(done)
+		result2, err2 = n2.Negotiate(c2)
+	}()
+
+	result1, err1 := n1.Negotiate(c1)
+	<-done
+
+	assert.NoError(t, err1)
+	assert.Equal(t, uint8(1), result1.Major)
+	assert.Equal(t, Hello{MinProto: 1, MaxProto: 1, NodeID: NodeID{2}, MinMajor: 1, MaxMajor: 2}, result1.Peer)
+	assert.NoError(t, err2)
+	assert.Equal(t, uint8(1), result2.Major)
+}
+
+func TestNegotiatorNegotiateMajorsNoCommon(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1 := &Negotiator{MinProto: 1, MaxProto: 1, NodeID: NodeID{1}}
+	n2 := &Negotiator{MinProto: 1, MaxProto: 1, MinMajor: 1, MaxMajor: 2, NodeID: NodeID{2}}
+	done := make(chan struct{})
+	var err2 error
+	go func() {
+		defer close(done)
+		_, err2 = n2.Negotiate(c2)
+	}()
+
+	result1, err1 := n1.Negotiate(c1)
+	<-done
+
+	assert.ErrorIs(t, err1, ErrNoCommonVersion)
+	assert.Nil(t, result1)
+	assert.ErrorIs(t, err2, ErrNoCommonVersion)
+}
+
+func TestNegotiatorNegotiateBoundMajors(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1, n2 := boundNegotiators([]byte("binding"), []byte("binding"))
+	n1.MaxMajor = 1
+	done := make(chan struct{})
+	var result2 *Negotiation
+	var err2 error
+	go func() {
+		defer close(done)
+		result2, err2 = n2.Negotiate(c2)
+	}()
+
+	result1, err1 := n1.Negotiate(c1)
+	<-done
+
+	assert.NoError(t, err1)
+	assert.True(t, result1.Bound)
+	assert.Equal(t, uint8(0), result1.Major)
+	assert.NoError(t, err2)
+	assert.True(t, result2.Bound)
+}
+
+func TestNegotiatorNegotiateBindingMismatch(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1, n2 := boundNegotiators([]byte("binding1"), []byte("binding2"))
+	done := make(chan struct{})
+	var err2 error
+	go func() {
+		defer close(done)
+		_, err2 = n2.Negotiate(c2)
+	}()
+
+	result1, err1 := n1.Negotiate(c1)
+	<-done
+
+	assert.ErrorIs(t, err1, ErrBadBinding)
+	assert.Nil(t, result1)
+	assert.ErrorIs(t, err2, ErrBadBinding)
+}
+
+func TestNegotiatorNegotiateUnbound(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	n1, _ := boundNegotiators(nil, nil)
+	n1.Verifier = nil
+	n2 := &Negotiator{MaxProto: 1, NodeID: NodeID{2}}
+	go n2.Negotiate(c2)        //nolint:errcheck
+	go io.Copy(io.Discard, c2) //nolint:errcheck
+
+	result, err := n1.Negotiate(c1)
+
+	assert.NoError(t, err)
+	assert.False(t, result.Bound)
+}
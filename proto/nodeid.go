@@ -0,0 +1,167 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// NodeIDSize is the size of a node identifier, in bytes.
+const NodeIDSize int = 16
+
+// NodeID is the identifier of a Humboldt node.
+type NodeID [NodeIDSize]byte
+
+// GenerateNodeID generates a random node identifier.  Nodes with
+// cryptographic identities should instead derive their identifiers
+// from their identity keys.
+func GenerateNodeID() (NodeID, error) {
+	id := NodeID{}
+	if _, err := io.ReadFull(randReader, id[:]); err != nil {
+		return NodeID{}, err
+	}
+
+	return id, nil
+}
+
+// AnycastID returns the identifier of the anycast destination for a
+// named service, such as "log-collector".  Any number of nodes may
+// claim the destination; data messages addressed to it are delivered
+// to the nearest of them.  The identifier is derived from a hash of
+// the name, and so shares the space of node identifiers without
+// colliding with them in practice.
+func AnycastID(service string) NodeID {
+	sum := sha256.Sum256([]byte("humboldt-anycast:" + service))
+	id := NodeID{}
+	copy(id[:], sum[:])
+
+	return id
+}
+
+// ParseNodeID parses a node identifier in hexadecimal, as returned by
+// String.
+func ParseNodeID(s string) (NodeID, error) {
+	id := NodeID{}
+	if err := id.UnmarshalText([]byte(s)); err != nil {
+		return NodeID{}, err
+	}
+
+	return id, nil
+}
+
+// String returns the node identifier in hexadecimal.
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero tests whether the node identifier is the zero value, which
+// identifies no node.
+func (id NodeID) IsZero() bool {
+	return id == NodeID{}
+}
+
+// Compare compares two node identifiers as big-endian unsigned
+// integers, returning -1, 0, or +1 if the identifier is less than,
+// equal to, or greater than the other.  The ordering is total, making
+// it suitable for deterministic tie-breaking in routing decisions.
+func (id NodeID) Compare(other NodeID) int {
+	return bytes.Compare(id[:], other[:])
+}
+
+// Less tests whether the node identifier orders before the other.
+func (id NodeID) Less(other NodeID) bool {
+	return id.Compare(other) < 0
+}
+
+// MarshalText encodes the node identifier in hexadecimal, allowing
+// node identifiers to be used as keys of JSON objects.
+func (id NodeID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes a node identifier encoded by MarshalText.
+func (id *NodeID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != NodeIDSize {
+		return ErrBadNodeID
+	}
+	_, err := hex.Decode(id[:], text)
+
+	return err
+}
+
+// MarshalBinary encodes the node identifier as its raw bytes.
+func (id NodeID) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), id[:]...), nil
+}
+
+// UnmarshalBinary decodes a node identifier encoded by
+// MarshalBinary.
+func (id *NodeID) UnmarshalBinary(data []byte) error {
+	if len(data) != NodeIDSize {
+		return ErrBadNodeID
+	}
+	copy(id[:], data)
+
+	return nil
+}
+
+// LoadNodeID loads a node identifier from a file, which must contain
+// the identifier in hexadecimal.  Surrounding whitespace is ignored.
+// If the file does not exist, it returns an error wrapping
+// fs.ErrNotExist.
+func LoadNodeID(path string) (NodeID, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return NodeID{}, err
+	}
+
+	return ParseNodeID(string(bytes.TrimSpace(data)))
+}
+
+// SaveNodeID saves a node identifier to a file in hexadecimal.  The
+// file is replaced atomically.
+func SaveNodeID(path string, id NodeID) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := writeFile(tmp, []byte(id.String()+"\n"), 0o644); err != nil {
+		return err
+	}
+
+	return rename(tmp, path)
+}
+
+// LoadOrGenerateNodeID loads a node identifier from a file,
+// generating and saving a new identifier if the file does not exist.
+// This allows a node to keep its identifier across restarts.
+func LoadOrGenerateNodeID(path string) (NodeID, error) {
+	id, err := LoadNodeID(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return id, err
+	}
+
+	if id, err = GenerateNodeID(); err != nil {
+		return NodeID{}, err
+	}
+	if err := SaveNodeID(path, id); err != nil {
+		return NodeID{}, err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeIDString(t *testing.T) {
+	obj := NodeID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+	assert.Equal(t, "0123456789abcdef0000000000000000", obj.String())
+}
+
+func TestNodeIDMarshalText(t *testing.T) {
+	obj := NodeID{0x01, 0x23}
+
+	result, err := obj.MarshalText()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("01230000000000000000000000000000"), result)
+}
+
+func TestNodeIDUnmarshalTextBase(t *testing.T) {
+	obj := NodeID{}
+
+	err := obj.UnmarshalText([]byte("01230000000000000000000000000000"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeID{0x01, 0x23}, obj)
+}
+
+func TestNodeIDUnmarshalTextShort(t *testing.T) {
+	obj := NodeID{}
+
+	err := obj.UnmarshalText([]byte("0123"))
+
+	assert.ErrorIs(t, err, ErrBadNodeID)
+}
+
+func TestNodeIDUnmarshalTextBadHex(t *testing.T) {
+	obj := NodeID{}
+
+	err := obj.UnmarshalText([]byte("zz230000000000000000000000000000"))
+
+	assert.Error(t, err)
+}
+
+func TestGenerateNodeIDBase(t *testing.T) {
+	defer patcher.SetVar(&randReader, bytes.NewReader(bytes.Repeat([]byte{0x5a}, NodeIDSize))).Install().Restore()
+
+	result, err := GenerateNodeID()
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeID{0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a, 0x5a}, result)
+}
+
+func TestGenerateNodeIDShort(t *testing.T) {
+	defer patcher.SetVar(&randReader, bytes.NewReader([]byte{0x5a})).Install().Restore()
+
+	result, err := GenerateNodeID()
+
+	assert.Error(t, err)
+	assert.Equal(t, NodeID{}, result)
+}
+
+func TestGenerateNodeIDRandom(t *testing.T) {
+	result1, err1 := GenerateNodeID()
+	result2, err2 := GenerateNodeID()
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.NotEqual(t, result1, result2)
+}
+
+func TestAnycastID(t *testing.T) {
+	result := AnycastID("log-collector")
+
+	assert.Equal(t, result, AnycastID("log-collector"))
+	assert.NotEqual(t, result, AnycastID("metrics"))
+	assert.False(t, result.IsZero())
+}
+
+func TestParseNodeIDBase(t *testing.T) {
+	result, err := ParseNodeID("01230000000000000000000000000000")
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeID{0x01, 0x23}, result)
+}
+
+func TestParseNodeIDError(t *testing.T) {
+	result, err := ParseNodeID("0123")
+
+	assert.ErrorIs(t, err, ErrBadNodeID)
+	assert.Equal(t, NodeID{}, result)
+}
+
+func TestNodeIDIsZero(t *testing.T) {
+	assert.True(t, NodeID{}.IsZero())
+	assert.False(t, NodeID{15: 1}.IsZero())
+}
+
+func TestNodeIDCompare(t *testing.T) {
+	assert.Equal(t, 0, NodeID{1}.Compare(NodeID{1}))
+	assert.Equal(t, -1, NodeID{1}.Compare(NodeID{2}))
+	assert.Equal(t, 1, NodeID{2}.Compare(NodeID{1, 0xff}))
+	assert.Equal(t, -1, NodeID{15: 1}.Compare(NodeID{14: 1}))
+}
+
+func TestNodeIDLess(t *testing.T) {
+	assert.True(t, NodeID{1}.Less(NodeID{2}))
+	assert.False(t, NodeID{2}.Less(NodeID{1}))
+	assert.False(t, NodeID{1}.Less(NodeID{1}))
+}
+
+func TestNodeIDMarshalBinary(t *testing.T) {
+	obj := NodeID{0x01, 0x23}
+
+	result, err := obj.MarshalBinary()
+
+	assert.NoError(t, err)
+	assert.Equal(t, obj[:], result)
+	result[0] = 0xff
+	assert.Equal(t, NodeID{0x01, 0x23}, obj)
+}
+
+func TestNodeIDUnmarshalBinaryBase(t *testing.T) {
+	obj := NodeID{}
+
+	err := obj.UnmarshalBinary([]byte{0x01, 0x23, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeID{0x01, 0x23}, obj)
+}
+
+func TestNodeIDUnmarshalBinaryShort(t *testing.T) {
+	obj := NodeID{}
+
+	err := obj.UnmarshalBinary([]byte{0x01, 0x23})
+
+	assert.ErrorIs(t, err, ErrBadNodeID)
+}
+
+func TestSaveNodeIDBase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node-id")
+
+	err := SaveNodeID(path, NodeID{0x01, 0x23})
+
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "01230000000000000000000000000000\n", string(data))
+}
+
+func TestSaveNodeIDWriteError(t *testing.T) {
+	defer patcher.SetVar(&writeFile, func(name string, data []byte, perm os.FileMode) error {
+		return assert.AnError
+	}).Install().Restore()
+
+	err := SaveNodeID("node-id", NodeID{1})
+
+	assert.Same(t, assert.AnError, err)
+}
+
+func TestLoadNodeIDBase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node-id")
+	require.NoError(t, os.WriteFile(path, []byte("  01230000000000000000000000000000\n"), 0o644))
+
+	result, err := LoadNodeID(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeID{0x01, 0x23}, result)
+}
+
+func TestLoadNodeIDBad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node-id")
+	require.NoError(t, os.WriteFile(path, []byte("0123\n"), 0o644))
+
+	result, err := LoadNodeID(path)
+
+	assert.ErrorIs(t, err, ErrBadNodeID)
+	assert.Equal(t, NodeID{}, result)
+}
+
+func TestLoadOrGenerateNodeIDNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node-id")
+
+	result, err := LoadOrGenerateNodeID(path)
+
+	assert.NoError(t, err)
+	assert.False(t, result.IsZero())
+	loaded, err := LoadNodeID(path)
+	assert.NoError(t, err)
+	assert.Equal(t, result, loaded)
+}
+
+func TestLoadOrGenerateNodeIDExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node-id")
+	require.NoError(t, SaveNodeID(path, NodeID{1}))
+
+	result, err := LoadOrGenerateNodeID(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, NodeID{1}, result)
+}
+
+func TestLoadOrGenerateNodeIDLoadError(t *testing.T) {
+	defer patcher.SetVar(&readFile, func(name string) ([]byte, error) {
+		return nil, assert.AnError
+	}).Install().Restore()
+
+	result, err := LoadOrGenerateNodeID("node-id")
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, NodeID{}, result)
+}
+
+func TestLoadOrGenerateNodeIDSaveError(t *testing.T) {
+	defer patcher.SetVar(&writeFile, func(name string, data []byte, perm os.FileMode) error {
+		return assert.AnError
+	}).Install().Restore()
+
+	result, err := LoadOrGenerateNodeID(filepath.Join(t.TempDir(), "node-id"))
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, NodeID{}, result)
+}
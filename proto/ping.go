@@ -0,0 +1,381 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Defaults for the Pinger.
+const (
+	PingSize             int           = 8                // Size of a ping body
+	ProbeSize            int           = 1024             // Size of a bandwidth probe body
+	ProbeAckSize         int           = 16               // Size of a bandwidth probe acknowledgment body
+	DefaultPingInterval  time.Duration = 10 * time.Second // Default ping interval
+	DefaultPingMaxMissed int           = 3                // Default unanswered pings allowed
+)
+
+// Pinger implements the ping protocol, which periodically sends echo
+// requests over a conduit and measures the round-trip time from the
+// replies.  The round-trip time is smoothed using the
+// Jacobson/Karels estimator.  The Pinger does not read from the
+// conduit; received ping and pong control messages must be passed to
+// its Handle method, as must bandwidth probes and their
+// acknowledgments.
+//
+// The loss of the conduit is estimated from the pings which go
+// unanswered.  If ProbeEvery is set, the bandwidth of the conduit is
+// also estimated, by the packet-pair technique: every ProbeEvery
+// pings, a pair of probes of ProbeSize bytes is sent back to back,
+// and the peer acknowledges the pair with the time which separated
+// their arrivals, from which the bandwidth of the bottleneck of the
+// path follows.  Both estimates are smoothed with an exponentially
+// weighted moving average.  Peers which do not implement probing
+// ignore the probes, leaving the bandwidth unmeasured.
+type Pinger struct {
+	Interval       time.Duration                // Interval between pings
+	MaxMissed      int                          // Unanswered pings before the peer is unresponsive
+	ProbeEvery     int                          // Pings between bandwidth probes; 0 disables probing
+	Send           func(f *Frame) error         // Sends a frame over the conduit
+	OnRTT          func(rtt, dev time.Duration) // Called with each updated estimate
+	OnBandwidth    func(bps uint64)             // Called with each updated bandwidth estimate
+	OnLoss         func(loss float64)           // Called with each updated loss estimate
+	OnUnresponsive func()                       // Called when the peer stops responding
+	OnResponsive   func()                       // Called when the peer responds again
+
+	sync.Mutex
+	seq          uint64               // Sequence number of the last ping
+	outstanding  map[uint64]time.Time // Times of the outstanding pings
+	missed       int                  // Pings sent since the last reply
+	unresponsive bool                 // Peer has been declared unresponsive
+	srtt         time.Duration        // Smoothed round-trip time
+	rttvar       time.Duration        // Round-trip time variation
+	loss         float64              // Fraction of pings lost
+	bandwidth    uint64               // Bandwidth, in bits per second
+	probeSeq     uint64               // Sequence number of the last probe pair sent
+	rxProbeSeq   uint64               // Sequence number of the last probe received
+	rxProbeAt    time.Time            // When the first probe of a pair was received
+	stop         chan struct{}        // Closed to stop the pinger
+	done         chan struct{}        // Closed when the pinger has stopped
+}
+
+// pingFrame constructs a ping or pong frame.
+func pingFrame(t ControlType, seq uint64) *Frame {
+	body := make([]byte, PingSize)
+	binary.BigEndian.PutUint64(body, seq)
+	msg := &ControlMessage{Type: t, Body: body}
+
+	return msg.Frame()
+}
+
+// probeFrame constructs a bandwidth probe frame, the first or second
+// of a pair.
+func probeFrame(seq uint64, second bool) *Frame {
+	body := make([]byte, ProbeSize)
+	binary.BigEndian.PutUint64(body, seq)
+	if second {
+		body[PingSize] = 1
+	}
+	msg := &ControlMessage{Type: ControlProbe, Body: body}
+
+	return msg.Frame()
+}
+
+// probeAckFrame constructs a bandwidth probe acknowledgment frame,
+// giving the time which separated the arrivals of a probe pair.
+func probeAckFrame(seq uint64, gap time.Duration) *Frame {
+	body := make([]byte, ProbeAckSize)
+	binary.BigEndian.PutUint64(body, seq)
+	binary.BigEndian.PutUint64(body[PingSize:], uint64(gap))
+	msg := &ControlMessage{Type: ControlProbeAck, Body: body}
+
+	return msg.Frame()
+}
+
+// Start starts sending pings in the background.
+func (p *Pinger) Start() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+
+	go p.run(p.stop, p.done)
+}
+
+// Stop stops sending pings.
+func (p *Pinger) Stop() {
+	p.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	p.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// run sends pings until stopped.
+func (p *Pinger) run(stop, done chan struct{}) {
+	defer close(done)
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultPingInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.tick()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick sends a single ping and checks whether the peer has stopped
+// responding.
+func (p *Pinger) tick() {
+	p.Lock()
+	maxMissed := p.MaxMissed
+	if maxMissed <= 0 {
+		maxMissed = DefaultPingMaxMissed
+	}
+
+	// Check for an unresponsive peer
+	notify := false
+	if p.missed >= maxMissed && !p.unresponsive {
+		p.unresponsive = true
+		notify = true
+	}
+
+	// Allocate the sequence number and discard stale pings, which
+	// count as lost
+	p.seq++
+	seq := p.seq
+	if p.outstanding == nil {
+		p.outstanding = map[uint64]time.Time{}
+	}
+	lost := false
+	for s := range p.outstanding {
+		if seq-s > uint64(maxMissed) {
+			delete(p.outstanding, s)
+			p.updateLoss(1)
+			lost = true
+		}
+	}
+	loss := p.loss
+	p.outstanding[seq] = timeNow()
+	p.missed++
+	probe := p.ProbeEvery > 0 && (seq-1)%uint64(p.ProbeEvery) == 0
+	if probe {
+		p.probeSeq = seq
+	}
+	p.Unlock()
+
+	if notify && p.OnUnresponsive != nil {
+		p.OnUnresponsive()
+	}
+	if lost && p.OnLoss != nil {
+		p.OnLoss(loss)
+	}
+	p.Send(pingFrame(ControlPing, seq)) //nolint:errcheck
+	if probe {
+		p.Send(probeFrame(seq, false)) //nolint:errcheck
+		p.Send(probeFrame(seq, true))  //nolint:errcheck
+	}
+}
+
+// updateLoss updates the loss estimate with a new sample: 1 for a
+// ping lost, 0 for a ping answered.  Must be called with the lock
+// held.
+func (p *Pinger) updateLoss(sample float64) {
+	p.loss = (7*p.loss + sample) / 8
+}
+
+// updateBandwidth updates the bandwidth estimate with a new sample.
+// Must be called with the lock held.
+func (p *Pinger) updateBandwidth(sample uint64) {
+	if p.bandwidth == 0 {
+		p.bandwidth = sample
+		return
+	}
+
+	p.bandwidth = (7*p.bandwidth + sample) / 8
+}
+
+// update updates the round-trip time estimate with a new sample.
+// Must be called with the lock held.
+func (p *Pinger) update(sample time.Duration) {
+	if p.srtt == 0 && p.rttvar == 0 {
+		p.srtt = sample
+		p.rttvar = sample / 2
+		return
+	}
+
+	diff := p.srtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	p.rttvar = (3*p.rttvar + diff) / 4
+	p.srtt = (7*p.srtt + sample) / 8
+}
+
+// RTT returns the current round-trip time estimate and its deviation.
+func (p *Pinger) RTT() (time.Duration, time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.srtt, p.rttvar
+}
+
+// Loss returns the current estimate of the fraction of pings lost.
+func (p *Pinger) Loss() float64 {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.loss
+}
+
+// Bandwidth returns the current bandwidth estimate, in bits per
+// second, or 0 if the bandwidth has not been measured.
+func (p *Pinger) Bandwidth() uint64 {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.bandwidth
+}
+
+// Handle processes a ping-related control message received over the
+// conduit.  Pings are answered with pongs; pongs update the
+// round-trip time estimate.  Pairs of bandwidth probes are answered
+// with acknowledgments, which update the bandwidth estimate.  It
+// returns false if the message is not related to pinging.
+func (p *Pinger) Handle(msg *ControlMessage) bool {
+	switch msg.Type {
+	case ControlPing:
+		if len(msg.Body) >= PingSize {
+			p.Send(pingFrame(ControlPong, binary.BigEndian.Uint64(msg.Body))) //nolint:errcheck
+		}
+		return true
+
+	case ControlPong:
+		if len(msg.Body) >= PingSize {
+			p.pong(binary.BigEndian.Uint64(msg.Body))
+		}
+		return true
+
+	case ControlProbe:
+		if len(msg.Body) >= PingSize+1 {
+			p.probe(binary.BigEndian.Uint64(msg.Body), msg.Body[PingSize] != 0)
+		}
+		return true
+
+	case ControlProbeAck:
+		if len(msg.Body) >= ProbeAckSize {
+			p.probeAck(binary.BigEndian.Uint64(msg.Body), time.Duration(binary.BigEndian.Uint64(msg.Body[PingSize:])))
+		}
+		return true
+	}
+
+	return false
+}
+
+// pong processes a pong with the specified sequence number.
+func (p *Pinger) pong(seq uint64) {
+	p.Lock()
+	sent, ok := p.outstanding[seq]
+	if !ok {
+		p.Unlock()
+		return
+	}
+	delete(p.outstanding, seq)
+	p.update(timeNow().Sub(sent))
+	p.updateLoss(0)
+	rtt, dev, loss := p.srtt, p.rttvar, p.loss
+	p.missed = 0
+	recovered := p.unresponsive
+	p.unresponsive = false
+	p.Unlock()
+
+	if recovered && p.OnResponsive != nil {
+		p.OnResponsive()
+	}
+	if p.OnRTT != nil {
+		p.OnRTT(rtt, dev)
+	}
+	if p.OnLoss != nil {
+		p.OnLoss(loss)
+	}
+}
+
+// probe processes a bandwidth probe with the specified sequence
+// number.  The arrival of the first probe of a pair is noted, and the
+// second is acknowledged with the time since; pairs whose first probe
+// was lost are ignored.
+func (p *Pinger) probe(seq uint64, second bool) {
+	now := timeNow()
+	p.Lock()
+	if !second {
+		p.rxProbeSeq = seq
+		p.rxProbeAt = now
+		p.Unlock()
+		return
+	}
+	if p.rxProbeSeq != seq || p.rxProbeAt.IsZero() {
+		p.Unlock()
+		return
+	}
+	gap := now.Sub(p.rxProbeAt)
+	p.rxProbeAt = time.Time{}
+	p.Unlock()
+
+	p.Send(probeAckFrame(seq, gap)) //nolint:errcheck
+}
+
+// probeAck processes the acknowledgment of a pair of bandwidth
+// probes: the second probe took the acknowledged gap to cross the
+// bottleneck of the path, giving a bandwidth sample.  Gaps too short
+// to measure, and acknowledgments of pairs other than the last one
+// sent, are ignored.
+func (p *Pinger) probeAck(seq uint64, gap time.Duration) {
+	if gap <= 0 {
+		return
+	}
+
+	p.Lock()
+	if seq != p.probeSeq {
+		p.Unlock()
+		return
+	}
+	p.probeSeq = 0
+	p.updateBandwidth(uint64(float64(ProbeSize*8) / gap.Seconds()))
+	bandwidth := p.bandwidth
+	p.Unlock()
+
+	if p.OnBandwidth != nil {
+		p.OnBandwidth(bandwidth)
+	}
+}
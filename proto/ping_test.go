@@ -0,0 +1,430 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+// frameRecorder records frames sent by a Pinger.
+type frameRecorder struct {
+	sync.Mutex
+	frames []*Frame
+}
+
+func (r *frameRecorder) Send(f *Frame) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.frames = append(r.frames, f)
+
+	return nil
+}
+
+func (r *frameRecorder) Frames() []*Frame {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.frames
+}
+
+func TestPingFrame(t *testing.T) {
+	result := pingFrame(ControlPing, 0x0102)
+
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Protocol: ProtoControl,
+		},
+		Payload: []byte{0x04, 0, 0, 0, 0, 0, 0, 0x01, 0x02},
+	}, result)
+}
+
+func TestPingerStartStop(t *testing.T) {
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		Interval: time.Millisecond,
+		Send:     rec.Send,
+	}
+
+	obj.Start()
+	obj.Start()
+	for len(rec.Frames()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	obj.Stop()
+	obj.Stop()
+
+	assert.Nil(t, obj.stop)
+	assert.Equal(t, pingFrame(ControlPing, 1), rec.Frames()[0])
+	assert.Equal(t, pingFrame(ControlPing, 2), rec.Frames()[1])
+}
+
+func TestPingerTickBase(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		Send: rec.Send,
+	}
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+
+	obj.tick()
+
+	assert.Equal(t, []*Frame{pingFrame(ControlPing, 1)}, rec.Frames())
+	assert.Equal(t, map[uint64]time.Time{1: now}, obj.outstanding)
+	assert.Equal(t, 1, obj.missed)
+	assert.False(t, obj.unresponsive)
+}
+
+func TestPingerTickUnresponsive(t *testing.T) {
+	rec := &frameRecorder{}
+	calls := 0
+	obj := &Pinger{
+		MaxMissed: 2,
+		Send:      rec.Send,
+		OnUnresponsive: func() {
+			calls++
+		},
+	}
+
+	obj.tick()
+	obj.tick()
+	assert.Equal(t, 0, calls)
+	obj.tick()
+	assert.Equal(t, 1, calls)
+	obj.tick()
+	assert.Equal(t, 1, calls)
+
+	assert.True(t, obj.unresponsive)
+	assert.Len(t, obj.outstanding, 3)
+	assert.Contains(t, obj.outstanding, uint64(2))
+	assert.Contains(t, obj.outstanding, uint64(3))
+	assert.Contains(t, obj.outstanding, uint64(4))
+}
+
+func TestPingerUpdateFirst(t *testing.T) {
+	obj := &Pinger{}
+
+	obj.update(100 * time.Millisecond)
+
+	assert.Equal(t, 100*time.Millisecond, obj.srtt)
+	assert.Equal(t, 50*time.Millisecond, obj.rttvar)
+}
+
+func TestPingerUpdateSubsequent(t *testing.T) {
+	obj := &Pinger{
+		srtt:   100 * time.Millisecond,
+		rttvar: 40 * time.Millisecond,
+	}
+
+	obj.update(180 * time.Millisecond)
+
+	assert.Equal(t, 110*time.Millisecond, obj.srtt)
+	assert.Equal(t, 50*time.Millisecond, obj.rttvar)
+}
+
+func TestPingerRTT(t *testing.T) {
+	obj := &Pinger{
+		srtt:   100 * time.Millisecond,
+		rttvar: 40 * time.Millisecond,
+	}
+
+	rtt, dev := obj.RTT()
+
+	assert.Equal(t, 100*time.Millisecond, rtt)
+	assert.Equal(t, 40*time.Millisecond, dev)
+}
+
+func TestPingerHandlePing(t *testing.T) {
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		Send: rec.Send,
+	}
+
+	result := obj.Handle(&ControlMessage{Type: ControlPing, Body: []byte{0, 0, 0, 0, 0, 0, 0, 5}})
+
+	assert.True(t, result)
+	assert.Equal(t, []*Frame{pingFrame(ControlPong, 5)}, rec.Frames())
+}
+
+func TestPingerHandlePingShort(t *testing.T) {
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		Send: rec.Send,
+	}
+
+	result := obj.Handle(&ControlMessage{Type: ControlPing, Body: []byte{5}})
+
+	assert.True(t, result)
+	assert.Nil(t, rec.Frames())
+}
+
+func TestPingerHandlePong(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var rtt, dev time.Duration
+	recovered := false
+	obj := &Pinger{
+		OnRTT: func(r, d time.Duration) {
+			rtt, dev = r, d
+		},
+		OnResponsive: func() {
+			recovered = true
+		},
+		outstanding:  map[uint64]time.Time{5: now.Add(-100 * time.Millisecond)},
+		missed:       4,
+		unresponsive: true,
+	}
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+
+	result := obj.Handle(&ControlMessage{Type: ControlPong, Body: []byte{0, 0, 0, 0, 0, 0, 0, 5}})
+
+	assert.True(t, result)
+	assert.Equal(t, 100*time.Millisecond, rtt)
+	assert.Equal(t, 50*time.Millisecond, dev)
+	assert.True(t, recovered)
+	assert.Equal(t, 0, obj.missed)
+	assert.False(t, obj.unresponsive)
+	assert.Empty(t, obj.outstanding)
+}
+
+func TestPingerHandlePongUnknown(t *testing.T) {
+	called := false
+	obj := &Pinger{
+		OnRTT: func(r, d time.Duration) {
+			called = true
+		},
+		missed: 2,
+	}
+
+	result := obj.Handle(&ControlMessage{Type: ControlPong, Body: []byte{0, 0, 0, 0, 0, 0, 0, 5}})
+
+	assert.True(t, result)
+	assert.False(t, called)
+	assert.Equal(t, 2, obj.missed)
+}
+
+func TestPingerHandlePongShort(t *testing.T) {
+	obj := &Pinger{}
+
+	result := obj.Handle(&ControlMessage{Type: ControlPong})
+
+	assert.True(t, result)
+}
+
+func TestPingerHandleOther(t *testing.T) {
+	obj := &Pinger{}
+
+	result := obj.Handle(&ControlMessage{Type: ControlDrain})
+
+	assert.False(t, result)
+}
+
+func TestProbeFrame(t *testing.T) {
+	result := probeFrame(0x0102, true)
+
+	msg := &ControlMessage{}
+	_, err := msg.FromBytes(result.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, ControlProbe, msg.Type)
+	assert.Len(t, msg.Body, ProbeSize)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0x01, 0x02, 1}, msg.Body[:PingSize+1])
+}
+
+func TestProbeAckFrame(t *testing.T) {
+	result := probeAckFrame(0x0102, 0x0304)
+
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Protocol: ProtoControl,
+		},
+		Payload: []byte{0x0c, 0, 0, 0, 0, 0, 0, 0x01, 0x02, 0, 0, 0, 0, 0, 0, 0x03, 0x04},
+	}, result)
+}
+
+func TestPingerTickProbe(t *testing.T) {
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		ProbeEvery: 2,
+		Send:       rec.Send,
+	}
+
+	obj.tick()
+	obj.tick()
+	obj.tick()
+
+	assert.Equal(t, []*Frame{
+		pingFrame(ControlPing, 1),
+		probeFrame(1, false),
+		probeFrame(1, true),
+		pingFrame(ControlPing, 2),
+		pingFrame(ControlPing, 3),
+		probeFrame(3, false),
+		probeFrame(3, true),
+	}, rec.Frames())
+	assert.Equal(t, uint64(3), obj.probeSeq)
+}
+
+func TestPingerTickLoss(t *testing.T) {
+	rec := &frameRecorder{}
+	var loss float64
+	obj := &Pinger{
+		MaxMissed: 1,
+		Send:      rec.Send,
+		OnLoss: func(l float64) {
+			loss = l
+		},
+	}
+
+	obj.tick()
+	obj.tick()
+	obj.tick()
+
+	assert.Equal(t, 0.125, loss)
+	assert.Equal(t, 0.125, obj.Loss())
+	assert.Len(t, obj.outstanding, 2)
+}
+
+func TestPingerHandlePongLoss(t *testing.T) {
+	var loss float64
+	obj := &Pinger{
+		OnLoss: func(l float64) {
+			loss = l
+		},
+		outstanding: map[uint64]time.Time{5: time.Now()},
+		loss:        0.5,
+	}
+
+	obj.Handle(&ControlMessage{Type: ControlPong, Body: []byte{0, 0, 0, 0, 0, 0, 0, 5}})
+
+	assert.Equal(t, 0.4375, loss)
+}
+
+func TestPingerHandleProbePair(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		Send: rec.Send,
+	}
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	first := &ControlMessage{}
+	_, _ = first.FromBytes(probeFrame(5, false).Payload)
+	second := &ControlMessage{}
+	_, _ = second.FromBytes(probeFrame(5, true).Payload)
+
+	result1 := obj.Handle(first)
+	now = now.Add(time.Millisecond)
+	result2 := obj.Handle(second)
+
+	assert.True(t, result1)
+	assert.True(t, result2)
+	assert.Equal(t, []*Frame{probeAckFrame(5, time.Millisecond)}, rec.Frames())
+}
+
+func TestPingerHandleProbeSecondOnly(t *testing.T) {
+	rec := &frameRecorder{}
+	obj := &Pinger{
+		Send:       rec.Send,
+		rxProbeSeq: 4,
+		rxProbeAt:  time.Unix(1000, 0),
+	}
+	second := &ControlMessage{}
+	_, _ = second.FromBytes(probeFrame(5, true).Payload)
+
+	result := obj.Handle(second)
+
+	assert.True(t, result)
+	assert.Empty(t, rec.Frames())
+}
+
+func TestPingerHandleProbeShort(t *testing.T) {
+	obj := &Pinger{}
+
+	result := obj.Handle(&ControlMessage{Type: ControlProbe})
+
+	assert.True(t, result)
+	assert.Equal(t, uint64(0), obj.rxProbeSeq)
+}
+
+func TestPingerHandleProbeAck(t *testing.T) {
+	var bandwidth uint64
+	obj := &Pinger{
+		OnBandwidth: func(bps uint64) {
+			bandwidth = bps
+		},
+		probeSeq: 5,
+	}
+	msg := &ControlMessage{}
+	_, _ = msg.FromBytes(probeAckFrame(5, time.Millisecond).Payload)
+
+	result := obj.Handle(msg)
+
+	assert.True(t, result)
+	assert.Equal(t, uint64(ProbeSize*8*1000), bandwidth)
+	assert.Equal(t, uint64(ProbeSize*8*1000), obj.Bandwidth())
+	assert.Equal(t, uint64(0), obj.probeSeq)
+}
+
+func TestPingerHandleProbeAckSmoothed(t *testing.T) {
+	obj := &Pinger{
+		probeSeq:  5,
+		bandwidth: 16000,
+	}
+	msg := &ControlMessage{}
+	_, _ = msg.FromBytes(probeAckFrame(5, time.Second).Payload)
+
+	obj.Handle(msg)
+
+	assert.Equal(t, uint64((7*16000+ProbeSize*8)/8), obj.Bandwidth())
+}
+
+func TestPingerHandleProbeAckStale(t *testing.T) {
+	called := false
+	obj := &Pinger{
+		OnBandwidth: func(bps uint64) {
+			called = true
+		},
+		probeSeq: 6,
+	}
+	msg := &ControlMessage{}
+	_, _ = msg.FromBytes(probeAckFrame(5, time.Millisecond).Payload)
+
+	obj.Handle(msg)
+
+	assert.False(t, called)
+	assert.Equal(t, uint64(0), obj.Bandwidth())
+}
+
+func TestPingerHandleProbeAckZeroGap(t *testing.T) {
+	obj := &Pinger{
+		probeSeq: 5,
+	}
+	msg := &ControlMessage{}
+	_, _ = msg.FromBytes(probeAckFrame(5, 0).Payload)
+
+	obj.Handle(msg)
+
+	assert.Equal(t, uint64(0), obj.Bandwidth())
+	assert.Equal(t, uint64(5), obj.probeSeq)
+}
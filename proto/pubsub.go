@@ -0,0 +1,213 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ProtoPubSub is the protocol number of the publish/subscribe
+// protocol, over which nodes distribute the topics their clients
+// subscribe to and the messages published to them.
+const ProtoPubSub uint8 = 6
+
+// Sizes of the components of encoded publish/subscribe messages.
+const (
+	TopicsHeaderSize  int = 1 + NodeIDSize + 8 // Type, origin, and sequence
+	PublishHeaderSize int = 1 + NodeIDSize + 1 // Type, source, and hop limit
+)
+
+// MaxTopicSize is the maximum size of a topic, in bytes.
+const MaxTopicSize = 255
+
+// PubSubType identifies the type of a publish/subscribe message.
+type PubSubType uint8
+
+// Publish/subscribe message types.
+const (
+	PubSubTopics  PubSubType = 0x01 // The topics subscribed to at a node, flooded
+	PubSubPublish PubSubType = 0x02 // A message published to a topic
+)
+
+// PubSubTypeOf returns the type of an encoded publish/subscribe
+// message, or 0 if the data is empty.
+func PubSubTypeOf(data []byte) PubSubType {
+	if len(data) == 0 {
+		return 0
+	}
+
+	return PubSubType(data[0])
+}
+
+// Topics is a record of the topics the clients of a node subscribe
+// to.  Each node originates records for its own topics, incrementing
+// the sequence number whenever they change, and floods them, so that
+// the nodes publishing messages know where the subscribers are.
+type Topics struct {
+	Origin   NodeID   // Identifier of the node originating the record
+	Sequence uint64   // Sequence number of the record
+	Topics   []string // The topics subscribed to
+}
+
+// Encode encodes the topics record.  The record must fit in the
+// payload of a single PDU.
+func (t *Topics) Encode() ([]byte, error) {
+	data := make([]byte, 0, TopicsHeaderSize)
+	data = append(data, uint8(PubSubTopics))
+	data = append(data, t.Origin[:]...)
+	data = binary.BigEndian.AppendUint64(data, t.Sequence)
+
+	data, err := putStrings(data, t.Topics)
+	if err != nil {
+		return nil, fmt.Errorf("topics: %w", err)
+	}
+	if len(data) > MaxLength {
+		return nil, fmt.Errorf("topics of %s: %w", t.Origin, ErrTooLong)
+	}
+
+	return data, nil
+}
+
+// DecodeTopics decodes a topics record.
+func DecodeTopics(data []byte) (*Topics, error) {
+	if len(data) < TopicsHeaderSize {
+		return nil, ErrShortInput
+	}
+	if PubSubType(data[0]) != PubSubTopics {
+		return nil, fmt.Errorf("topics: %w", ErrBadMessageType)
+	}
+	t := &Topics{}
+	copy(t.Origin[:], data[1:])
+	t.Sequence = binary.BigEndian.Uint64(data[1+NodeIDSize:])
+
+	topics, rest, err := getStrings(data[TopicsHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("topics: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, ErrBadLength
+	}
+	t.Topics = topics
+
+	return t, nil
+}
+
+// Frame constructs a frame carrying the topics record.
+func (t *Topics) Frame() (*Frame, error) {
+	payload, err := t.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoPubSub,
+		},
+		Payload: payload,
+	}, nil
+}
+
+// Publish is a message published to a topic.  A client publishes a
+// message by sending it to its node with no source and no targets;
+// the node gives it its own identifier as the source, and the nodes
+// whose clients subscribe to the topic as the targets.  Each node the
+// message reaches delivers it to its subscribing clients, if it is a
+// target, and passes it on toward the remaining targets, one copy to
+// each next hop, carrying the targets reached through that hop; the
+// message thus follows the routes to the subscribers, and crosses
+// each link at most once.
+type Publish struct {
+	Source   NodeID   // Identifier of the node the message was published at
+	HopLimit uint8    // Remaining hops
+	Topic    string   // The topic published to
+	Targets  []NodeID // The nodes to deliver the message to
+	Payload  []byte   // The published payload
+}
+
+// Encode encodes the published message.  The message must fit in the
+// payload of a single PDU.
+func (p *Publish) Encode() ([]byte, error) {
+	if len(p.Topic) > MaxTopicSize {
+		return nil, fmt.Errorf("publish topic: %w", ErrTooLong)
+	}
+	size := PublishHeaderSize + 1 + len(p.Topic) + 2 + len(p.Targets)*NodeIDSize + len(p.Payload)
+	if len(p.Targets) > 0xffff || size > MaxLength {
+		return nil, fmt.Errorf("publish to %q: %w", p.Topic, ErrTooLong)
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, uint8(PubSubPublish))
+	data = append(data, p.Source[:]...)
+	data = append(data, p.HopLimit, uint8(len(p.Topic)))
+	data = append(data, p.Topic...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(p.Targets)))
+	for _, id := range p.Targets {
+		data = append(data, id[:]...)
+	}
+	data = append(data, p.Payload...)
+
+	return data, nil
+}
+
+// DecodePublish decodes a published message.
+func DecodePublish(data []byte) (*Publish, error) {
+	if len(data) < PublishHeaderSize+1 {
+		return nil, ErrShortInput
+	}
+	if PubSubType(data[0]) != PubSubPublish {
+		return nil, fmt.Errorf("publish: %w", ErrBadMessageType)
+	}
+	p := &Publish{}
+	copy(p.Source[:], data[1:])
+	p.HopLimit = data[1+NodeIDSize]
+	data = data[PublishHeaderSize:]
+
+	size := int(data[0])
+	if len(data) < 1+size+2 {
+		return nil, ErrShortInput
+	}
+	p.Topic = string(data[1 : 1+size])
+	data = data[1+size:]
+
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < count*NodeIDSize {
+		return nil, ErrShortInput
+	}
+	p.Targets = make([]NodeID, count)
+	for i := range p.Targets {
+		copy(p.Targets[i][:], data)
+		data = data[NodeIDSize:]
+	}
+	p.Payload = data
+
+	return p, nil
+}
+
+// Frame constructs a frame carrying the published message.
+func (p *Publish) Frame() (*Frame, error) {
+	payload, err := p.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoPubSub,
+		},
+		Payload: payload,
+	}, nil
+}
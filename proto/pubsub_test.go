@@ -0,0 +1,242 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTopicsData = []byte{
+	0x01,
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2a,
+	0x00, 0x02,
+	0x00, 0x04, 'l', 'o', 'g', 's',
+	0x00, 0x01, 'm',
+}
+
+func testTopics() *Topics {
+	return &Topics{
+		Origin:   NodeID{0x01},
+		Sequence: 42,
+		Topics:   []string{"logs", "m"},
+	}
+}
+
+var testPublishData = []byte{
+	0x02,
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x10,
+	0x04, 'l', 'o', 'g', 's',
+	0x00, 0x02,
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	'h', 'i',
+}
+
+func testPublish() *Publish {
+	return &Publish{
+		Source:   NodeID{0x01},
+		HopLimit: 16,
+		Topic:    "logs",
+		Targets:  []NodeID{{0x02}, {0x03}},
+		Payload:  []byte("hi"),
+	}
+}
+
+func TestPubSubTypeOf(t *testing.T) {
+	assert.Equal(t, PubSubTopics, PubSubTypeOf(testTopicsData))
+	assert.Equal(t, PubSubPublish, PubSubTypeOf(testPublishData))
+	assert.Equal(t, PubSubType(0), PubSubTypeOf(nil))
+}
+
+func TestTopicsEncodeBase(t *testing.T) {
+	obj := testTopics()
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testTopicsData, result)
+}
+
+func TestTopicsEncodeTooLong(t *testing.T) {
+	obj := &Topics{Topics: []string{strings.Repeat("x", MaxLength/2), strings.Repeat("x", MaxLength/2)}}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodeTopicsBase(t *testing.T) {
+	result, err := DecodeTopics(testTopicsData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testTopics(), result)
+}
+
+func TestDecodeTopicsShort(t *testing.T) {
+	result, err := DecodeTopics(testTopicsData[:TopicsHeaderSize-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeTopicsBadType(t *testing.T) {
+	data := append([]byte{0x02}, testTopicsData[1:]...)
+
+	result, err := DecodeTopics(data)
+
+	assert.ErrorIs(t, err, ErrBadMessageType)
+	assert.Nil(t, result)
+}
+
+func TestDecodeTopicsShortTopics(t *testing.T) {
+	result, err := DecodeTopics(testTopicsData[:len(testTopicsData)-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeTopicsTrailing(t *testing.T) {
+	result, err := DecodeTopics(append(testTopicsData[:len(testTopicsData):len(testTopicsData)], 0))
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestTopicsFrameBase(t *testing.T) {
+	obj := testTopics()
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoPubSub},
+		Payload: testTopicsData,
+	}, result)
+}
+
+func TestTopicsFrameError(t *testing.T) {
+	obj := &Topics{Topics: make([]string, MaxLength+1)}
+
+	result, err := obj.Frame()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestPublishEncodeBase(t *testing.T) {
+	obj := testPublish()
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testPublishData, result)
+}
+
+func TestPublishEncodeTopicTooLong(t *testing.T) {
+	obj := &Publish{Topic: strings.Repeat("x", MaxTopicSize+1)}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestPublishEncodeTooLong(t *testing.T) {
+	obj := &Publish{Payload: make([]byte, MaxLength)}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodePublishBase(t *testing.T) {
+	result, err := DecodePublish(testPublishData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testPublish(), result)
+}
+
+func TestDecodePublishNoTargets(t *testing.T) {
+	obj := &Publish{Topic: "logs", Targets: []NodeID{}, Payload: []byte{}}
+	data, err := obj.Encode()
+	assert.NoError(t, err)
+
+	result, err := DecodePublish(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, obj, result)
+}
+
+func TestDecodePublishShort(t *testing.T) {
+	result, err := DecodePublish(testPublishData[:PublishHeaderSize])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodePublishBadType(t *testing.T) {
+	data := append([]byte{0x01}, testPublishData[1:]...)
+
+	result, err := DecodePublish(data)
+
+	assert.ErrorIs(t, err, ErrBadMessageType)
+	assert.Nil(t, result)
+}
+
+func TestDecodePublishShortTopic(t *testing.T) {
+	result, err := DecodePublish(testPublishData[:PublishHeaderSize+3])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodePublishShortTargets(t *testing.T) {
+	result, err := DecodePublish(testPublishData[:PublishHeaderSize+7+NodeIDSize])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestPublishFrameBase(t *testing.T) {
+	obj := testPublish()
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoPubSub},
+		Payload: testPublishData,
+	}, result)
+}
+
+func TestPublishFrameError(t *testing.T) {
+	obj := &Publish{Payload: make([]byte, MaxLength)}
+
+	result, err := obj.Frame()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Quota describes limits on the inbound PDUs of a single protocol
+// number over a single conduit.  Zero values indicate no limit.
+type Quota struct {
+	MaxSize int     // Maximum size of a PDU, including the header
+	Rate    float64 // Maximum sustained PDUs per second
+	Burst   int     // Maximum PDUs in a burst; at least 1 if Rate is set
+}
+
+// Quotas maps protocol numbers to the quotas applying to them.
+type Quotas map[uint8]Quota
+
+// bucket is a token bucket tracking the rate of PDUs for a single
+// protocol.
+type bucket struct {
+	tokens float64   // Tokens available
+	last   time.Time // Time tokens were last added
+}
+
+// Limiter enforces a set of quotas on the PDUs received over a single
+// conduit.  A single Limiter should be used for each conduit.  The
+// zero value enforces no quotas.
+type Limiter struct {
+	sync.Mutex
+
+	Quotas Quotas // The quotas to enforce
+
+	buckets map[uint8]*bucket
+}
+
+// burst returns the burst size of a quota.
+func (q Quota) burst() float64 {
+	if q.Burst <= 0 {
+		return 1
+	}
+
+	return float64(q.Burst)
+}
+
+// Check is called with the protocol number and size of an incoming
+// PDU.  It returns an error wrapping ErrQuotaSize if the PDU is too
+// large, or ErrQuotaRate if the PDU would exceed the permitted rate;
+// otherwise, the PDU is counted against the rate and Check returns
+// nil.  PDUs that are too large are not counted.
+func (l *Limiter) Check(protocol uint8, size int) error {
+	l.Lock()
+	defer l.Unlock()
+
+	q, ok := l.Quotas[protocol]
+	if !ok {
+		return nil
+	}
+
+	// Check the size
+	if q.MaxSize > 0 && size > q.MaxSize {
+		return fmt.Errorf("protocol %d: size %d: %w", protocol, size, ErrQuotaSize)
+	}
+	if q.Rate <= 0 {
+		return nil
+	}
+
+	// Refill the bucket
+	now := timeNow()
+	if l.buckets == nil {
+		l.buckets = map[uint8]*bucket{}
+	}
+	b, ok := l.buckets[protocol]
+	if !ok {
+		b = &bucket{tokens: q.burst(), last: now}
+		l.buckets[protocol] = b
+	} else {
+		b.tokens = min(q.burst(), b.tokens+now.Sub(b.last).Seconds()*q.Rate)
+		b.last = now
+	}
+
+	// Take a token
+	if b.tokens < 1 {
+		return fmt.Errorf("protocol %d: %w", protocol, ErrQuotaRate)
+	}
+	b.tokens--
+
+	return nil
+}
+
+// SetQuotas replaces the quotas enforced by the limiter.  The rate
+// state of all protocols is reset.
+func (l *Limiter) SetQuotas(quotas Quotas) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.Quotas = quotas
+	l.buckets = nil
+}
+
+// QuotaReply constructs the reply sent when a frame violates a quota,
+// which is the error reply constructed by ErrorReply.
+func QuotaReply(f *Frame) *Frame {
+	return ErrorReply(f)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaBurstDefault(t *testing.T) {
+	obj := Quota{}
+
+	result := obj.burst()
+
+	assert.Equal(t, 1.0, result)
+}
+
+func TestQuotaBurstSet(t *testing.T) {
+	obj := Quota{Burst: 5}
+
+	result := obj.burst()
+
+	assert.Equal(t, 5.0, result)
+}
+
+func TestLimiterCheckNoQuota(t *testing.T) {
+	obj := &Limiter{}
+
+	err := obj.Check(0x17, 65535)
+
+	assert.NoError(t, err)
+}
+
+func TestLimiterCheckSize(t *testing.T) {
+	obj := &Limiter{Quotas: Quotas{0x17: {MaxSize: 100}}}
+
+	err1 := obj.Check(0x17, 100)
+	err2 := obj.Check(0x17, 101)
+	err3 := obj.Check(0x18, 101)
+
+	assert.NoError(t, err1)
+	assert.ErrorIs(t, err2, ErrQuotaSize)
+	assert.NoError(t, err3)
+}
+
+func TestLimiterCheckRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	obj := &Limiter{Quotas: Quotas{0x17: {Rate: 2, Burst: 2}}}
+
+	err1 := obj.Check(0x17, 10)
+	err2 := obj.Check(0x17, 10)
+	err3 := obj.Check(0x17, 10)
+	now = now.Add(500 * time.Millisecond)
+	err4 := obj.Check(0x17, 10)
+	err5 := obj.Check(0x17, 10)
+	now = now.Add(time.Hour)
+	err6 := obj.Check(0x17, 10)
+	err7 := obj.Check(0x17, 10)
+	err8 := obj.Check(0x17, 10)
+
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.ErrorIs(t, err3, ErrQuotaRate)
+	assert.NoError(t, err4)
+	assert.ErrorIs(t, err5, ErrQuotaRate)
+	assert.NoError(t, err6)
+	assert.NoError(t, err7)
+	assert.ErrorIs(t, err8, ErrQuotaRate)
+}
+
+func TestLimiterCheckOversizeNotCounted(t *testing.T) {
+	obj := &Limiter{Quotas: Quotas{0x17: {MaxSize: 100, Rate: 1}}}
+
+	err1 := obj.Check(0x17, 101)
+	err2 := obj.Check(0x17, 10)
+
+	assert.ErrorIs(t, err1, ErrQuotaSize)
+	assert.NoError(t, err2)
+}
+
+func TestLimiterSetQuotas(t *testing.T) {
+	obj := &Limiter{
+		Quotas:  Quotas{0x17: {Rate: 1}},
+		buckets: map[uint8]*bucket{0x17: {}},
+	}
+
+	obj.SetQuotas(Quotas{0x18: {MaxSize: 10}})
+
+	assert.Equal(t, Quotas{0x18: {MaxSize: 10}}, obj.Quotas)
+	assert.Nil(t, obj.buckets)
+}
+
+func TestQuotaReplyBase(t *testing.T) {
+	f := &Frame{
+		Header: Header{Protocol: 0x81},
+		Extensions: Extensions{
+			{Number: 0x81, Header: ExtHeader{Protocol: 0x17}},
+		},
+		Payload: []byte("abc"),
+	}
+
+	result := QuotaReply(f)
+
+	assert.Equal(t, &Frame{
+		Header: Header{
+			Reply:    true,
+			Error:    true,
+			Protocol: 0x17,
+		},
+	}, result)
+}
+
+func TestQuotaReplyReply(t *testing.T) {
+	f := &Frame{
+		Header:  Header{Reply: true, Protocol: 0x17},
+		Payload: []byte("abc"),
+	}
+
+	result := QuotaReply(f)
+
+	assert.Nil(t, result)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ProtoRelay is the protocol number of the relay protocol, which
+// carries tunnels through which a node reaches another node by way of
+// a relay node that it and the other node both have conduits to.
+const ProtoRelay uint8 = 5
+
+// RelayHeaderSize is the size of the header of a relay message: the
+// message type and the tunnel identifier.
+const RelayHeaderSize int = 1 + 4
+
+// MaxRelayData is the maximum size of the payload of a relay data
+// message.
+const MaxRelayData int = MaxLength - RelayHeaderSize
+
+// RelayType identifies the type of a relay message.
+type RelayType uint8
+
+// Relay message types.
+const (
+	RelayOpen    RelayType = 0x01 // Asks the relay node for a tunnel; the payload names the target
+	RelayConnect RelayType = 0x02 // Opens a tunnel from the relay node; the payload names the dialer
+	RelayAccept  RelayType = 0x03 // The tunnel has been opened
+	RelayData    RelayType = 0x04 // Data carried through the tunnel
+	RelayClose   RelayType = 0x05 // The tunnel is closed or refused; the payload is the reason
+)
+
+// Relay is a relay message.  A tunnel is opened by sending an open
+// message to the relay node, naming the target node, over a conduit
+// to it; the relay node opens a second tunnel to the target with a
+// connect message naming the node which opened the first, and splices
+// the two together, passing the target's accept message back.  Each
+// tunnel is identified by a number chosen by the node opening it,
+// which is unique among the tunnels over the conduit.
+type Relay struct {
+	Type    RelayType // The type of the message
+	Tunnel  uint32    // Identifier of the tunnel on the conduit
+	Payload []byte    // The payload of the message
+}
+
+// Encode encodes the relay message.  The message must fit in the
+// payload of a single PDU.
+func (r *Relay) Encode() ([]byte, error) {
+	if RelayHeaderSize+len(r.Payload) > MaxLength {
+		return nil, fmt.Errorf("relay tunnel %d: %w", r.Tunnel, ErrTooLong)
+	}
+
+	data := make([]byte, 0, RelayHeaderSize+len(r.Payload))
+	data = append(data, uint8(r.Type))
+	data = binary.BigEndian.AppendUint32(data, r.Tunnel)
+
+	return append(data, r.Payload...), nil
+}
+
+// DecodeRelay decodes a relay message.  The payload refers to the
+// passed-in data.
+func DecodeRelay(data []byte) (*Relay, error) {
+	if len(data) < RelayHeaderSize {
+		return nil, ErrShortInput
+	}
+
+	return &Relay{
+		Type:    RelayType(data[0]),
+		Tunnel:  binary.BigEndian.Uint32(data[1:]),
+		Payload: data[RelayHeaderSize:],
+	}, nil
+}
+
+// Frame constructs a frame carrying the relay message.
+func (r *Relay) Frame() (*Frame, error) {
+	payload, err := r.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoRelay,
+		},
+		Payload: payload,
+	}, nil
+}
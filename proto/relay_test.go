@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRelayData = []byte{
+	0x04,
+	0x00, 0x00, 0x01, 0x02,
+	'h', 'e', 'l', 'l', 'o',
+}
+
+func testRelay() *Relay {
+	return &Relay{
+		Type:    RelayData,
+		Tunnel:  0x0102,
+		Payload: []byte("hello"),
+	}
+}
+
+func TestRelayEncodeBase(t *testing.T) {
+	obj := testRelay()
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testRelayData, result)
+}
+
+func TestRelayEncodeTooLong(t *testing.T) {
+	obj := &Relay{Payload: make([]byte, MaxRelayData+1)}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodeRelayBase(t *testing.T) {
+	result, err := DecodeRelay(testRelayData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testRelay(), result)
+}
+
+func TestDecodeRelayShort(t *testing.T) {
+	result, err := DecodeRelay(testRelayData[:RelayHeaderSize-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestRelayFrameBase(t *testing.T) {
+	obj := testRelay()
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoRelay},
+		Payload: testRelayData,
+	}, result)
+}
+
+func TestRelayFrameError(t *testing.T) {
+	obj := &Relay{Payload: make([]byte, MaxRelayData+1)}
+
+	result, err := obj.Frame()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
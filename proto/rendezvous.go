@@ -0,0 +1,111 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import "fmt"
+
+// ProtoRendezvous is the protocol number of the rendezvous protocol,
+// through which a node introduces two of its peers to each other so
+// that they may punch holes through the network address translators
+// between them and establish a conduit directly.
+const ProtoRendezvous uint8 = 4
+
+// RendezvousHeaderSize is the size of the header of a rendezvous
+// message: the message type and the initiator and target node
+// identifiers.
+const RendezvousHeaderSize int = 1 + 2*NodeIDSize
+
+// RendezvousType identifies the type of a rendezvous message.
+type RendezvousType uint8
+
+// Rendezvous message types.
+const (
+	RendezvousRequest RendezvousType = 0x01 // Initiator asks to be introduced to the target
+	RendezvousOffer   RendezvousType = 0x02 // Introduction passed on to the target
+	RendezvousAnswer  RendezvousType = 0x03 // Target's reply, passed back to the initiator
+	RendezvousRefuse  RendezvousType = 0x04 // The introduction cannot be made
+)
+
+// Rendezvous is a rendezvous message.  The initiator sends a request
+// to a peer which has a conduit to the target, listing the URIs at
+// which the initiator may be reached; the rendezvous node passes it
+// on to the target as an offer.  The target answers with its own
+// URIs, which the rendezvous node passes back to the initiator, and
+// both then punch holes toward each other.  If the rendezvous node
+// has no conduit to the target, or the target has no URIs through
+// which holes may be punched, the initiator is sent a refusal
+// instead.  The initiator and target are the same in every message
+// of an exchange.
+type Rendezvous struct {
+	Type      RendezvousType // The type of the message
+	Initiator NodeID         // Identifier of the node asking for the introduction
+	Target    NodeID         // Identifier of the node to be introduced
+	URIs      []string       // URIs of the sender: the initiator or the target
+}
+
+// Encode encodes the rendezvous message.  The message must fit in
+// the payload of a single PDU.
+func (r *Rendezvous) Encode() ([]byte, error) {
+	data := make([]byte, 0, RendezvousHeaderSize)
+	data = append(data, uint8(r.Type))
+	data = append(data, r.Initiator[:]...)
+	data = append(data, r.Target[:]...)
+
+	data, err := putStrings(data, r.URIs)
+	if err != nil {
+		return nil, fmt.Errorf("rendezvous URIs: %w", err)
+	}
+	if len(data) > MaxLength {
+		return nil, fmt.Errorf("rendezvous for %s: %w", r.Target, ErrTooLong)
+	}
+
+	return data, nil
+}
+
+// DecodeRendezvous decodes a rendezvous message.
+func DecodeRendezvous(data []byte) (*Rendezvous, error) {
+	if len(data) < RendezvousHeaderSize {
+		return nil, ErrShortInput
+	}
+	r := &Rendezvous{Type: RendezvousType(data[0])}
+	copy(r.Initiator[:], data[1:])
+	copy(r.Target[:], data[1+NodeIDSize:])
+
+	uris, rest, err := getStrings(data[RendezvousHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("rendezvous URIs: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, ErrBadLength
+	}
+	r.URIs = uris
+
+	return r, nil
+}
+
+// Frame constructs a frame carrying the rendezvous message.
+func (r *Rendezvous) Frame() (*Frame, error) {
+	payload, err := r.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Frame{
+		Header: Header{
+			Protocol: ProtoRendezvous,
+		},
+		Payload: payload,
+	}, nil
+}
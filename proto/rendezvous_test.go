@@ -0,0 +1,108 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRendezvousData = []byte{
+	0x02,
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x01,
+	0x00, 0x0c, 'u', 'd', 'p', ':', '/', '/', 'h', 'o', 's', 't', ':', '1',
+}
+
+func testRendezvous() *Rendezvous {
+	return &Rendezvous{
+		Type:      RendezvousOffer,
+		Initiator: NodeID{0x01},
+		Target:    NodeID{0x02},
+		URIs:      []string{"udp://host:1"},
+	}
+}
+
+func TestRendezvousEncodeBase(t *testing.T) {
+	obj := testRendezvous()
+
+	result, err := obj.Encode()
+
+	assert.NoError(t, err)
+	assert.Equal(t, testRendezvousData, result)
+}
+
+func TestRendezvousEncodeTooLong(t *testing.T) {
+	obj := &Rendezvous{URIs: []string{strings.Repeat("x", MaxLength/2), strings.Repeat("x", MaxLength/2)}}
+
+	result, err := obj.Encode()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
+
+func TestDecodeRendezvousBase(t *testing.T) {
+	result, err := DecodeRendezvous(testRendezvousData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testRendezvous(), result)
+}
+
+func TestDecodeRendezvousShort(t *testing.T) {
+	result, err := DecodeRendezvous(testRendezvousData[:RendezvousHeaderSize-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeRendezvousShortURIs(t *testing.T) {
+	result, err := DecodeRendezvous(testRendezvousData[:len(testRendezvousData)-1])
+
+	assert.ErrorIs(t, err, ErrShortInput)
+	assert.Nil(t, result)
+}
+
+func TestDecodeRendezvousTrailing(t *testing.T) {
+	result, err := DecodeRendezvous(append(testRendezvousData[:len(testRendezvousData):len(testRendezvousData)], 0))
+
+	assert.ErrorIs(t, err, ErrBadLength)
+	assert.Nil(t, result)
+}
+
+func TestRendezvousFrameBase(t *testing.T) {
+	obj := testRendezvous()
+
+	result, err := obj.Frame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{
+		Header:  Header{Protocol: ProtoRendezvous},
+		Payload: testRendezvousData,
+	}, result)
+}
+
+func TestRendezvousFrameError(t *testing.T) {
+	obj := &Rendezvous{URIs: make([]string, MaxLength+1)}
+
+	result, err := obj.Frame()
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default values for the reply cache.
+const (
+	DefaultReplyCacheTTL  = 30 * time.Second
+	DefaultReplyCacheSize = 1024
+)
+
+// ReplyCacheVersion is the version of the reply cache snapshot
+// format.
+const ReplyCacheVersion = 1
+
+// RequestKey identifies a request PDU for the purposes of duplicate
+// detection.  Requests are identified by the protocol number and the
+// correlation ID carried by the request.
+type RequestKey struct {
+	Protocol uint8  // Protocol number of the request
+	ID       uint32 // Correlation ID of the request
+}
+
+// replyEntry describes an entry in the reply cache.
+type replyEntry struct {
+	key     RequestKey // The key of the entry
+	reply   []byte     // The cached reply; nil if still in progress
+	expires time.Time  // When the entry expires
+	elem    *list.Element
+}
+
+// ReplyCache is an idempotency cache that remembers recently answered
+// request PDUs.  When a request is retransmitted, perhaps because a
+// lossy link dropped the reply, the cache allows the cached reply to
+// be resent rather than executing the request handler a second time.
+// It is consulted by the Dispatcher it is set on, see
+// Dispatcher.Replies, or directly through Received and Sent.  A
+// single ReplyCache should be used for each conduit.  The zero value
+// is ready to use with the default TTL and size.
+type ReplyCache struct {
+	sync.Mutex
+
+	TTL  time.Duration // How long replies are remembered
+	Size int           // Maximum number of requests remembered
+
+	entries map[RequestKey]*replyEntry
+	order   *list.List
+}
+
+// ttl returns the configured TTL, or the default.
+func (rc *ReplyCache) ttl() time.Duration {
+	if rc.TTL <= 0 {
+		return DefaultReplyCacheTTL
+	}
+
+	return rc.TTL
+}
+
+// size returns the configured size, or the default.
+func (rc *ReplyCache) size() int {
+	if rc.Size <= 0 {
+		return DefaultReplyCacheSize
+	}
+
+	return rc.Size
+}
+
+// expire discards expired entries and, if the cache is full, the
+// oldest entries.  Must be called with the lock held.
+func (rc *ReplyCache) expire(now time.Time) {
+	if rc.entries == nil {
+		rc.entries = map[RequestKey]*replyEntry{}
+		rc.order = list.New()
+	}
+
+	for elem := rc.order.Front(); elem != nil; elem = rc.order.Front() {
+		ent := elem.Value.(*replyEntry)
+		if now.Before(ent.expires) && rc.order.Len() < rc.size() {
+			break
+		}
+		rc.order.Remove(elem)
+		delete(rc.entries, ent.key)
+	}
+}
+
+// Check is called with the header and correlation ID of an incoming
+// PDU.  PDUs with the Reply bit set are never duplicates.  If the
+// request has not been seen before, it is recorded as in progress and
+// Check returns false; the caller should then execute the request.
+// Otherwise, Check returns true, along with the cached reply, if
+// any; a nil reply indicates that the original request is still being
+// processed, and the duplicate should simply be dropped.
+func (rc *ReplyCache) Check(hdr *Header, id uint32) ([]byte, bool) {
+	if hdr.Reply {
+		return nil, false
+	}
+
+	rc.Lock()
+	defer rc.Unlock()
+
+	now := timeNow()
+	rc.expire(now)
+
+	key := RequestKey{Protocol: hdr.Protocol, ID: id}
+	if ent, ok := rc.entries[key]; ok {
+		return ent.reply, true
+	}
+
+	// Record the request as in progress
+	ent := &replyEntry{
+		key:     key,
+		expires: now.Add(rc.ttl()),
+	}
+	ent.elem = rc.order.PushBack(ent)
+	rc.entries[key] = ent
+
+	return nil, false
+}
+
+// Store is called with the header, correlation ID, and encoded bytes
+// of an outgoing PDU.  If the Reply bit is set, the reply is saved so
+// that it may be resent should the request be retransmitted; other
+// PDUs are ignored.
+func (rc *ReplyCache) Store(hdr *Header, id uint32, reply []byte) {
+	if !hdr.Reply {
+		return
+	}
+
+	rc.Lock()
+	defer rc.Unlock()
+
+	now := timeNow()
+	rc.expire(now)
+
+	// Save a copy of the reply
+	key := RequestKey{Protocol: hdr.Protocol, ID: id}
+	ent, ok := rc.entries[key]
+	if !ok {
+		ent = &replyEntry{key: key}
+		ent.elem = rc.order.PushBack(ent)
+		rc.entries[key] = ent
+	} else {
+		rc.order.MoveToBack(ent.elem)
+	}
+	ent.reply = append([]byte{}, reply...)
+	ent.expires = now.Add(rc.ttl())
+}
+
+// Received checks a frame received against the cache, as for Check,
+// using the request extension of the frame for the correlation ID.
+// Frames which are not requests are never duplicates.  If the frame
+// is a duplicate, true is returned, along with the cached reply
+// decoded, if any.
+func (rc *ReplyCache) Received(f *Frame) (*Frame, bool, error) {
+	req := FindRequest(f.Extensions)
+	if req == nil || f.Header.Reply {
+		return nil, false, nil
+	}
+
+	reply, dup := rc.Check(&Header{Protocol: f.Protocol()}, req.ID)
+	if reply == nil {
+		return nil, dup, nil
+	}
+	result, err := Decode(reply)
+
+	return result, true, err
+}
+
+// Sent saves a frame sent in answer to a request, as for Store, using
+// the request extension of the frame for the correlation ID.  Frames
+// other than replies carrying the request extension are ignored.
+func (rc *ReplyCache) Sent(f *Frame) error {
+	req := FindRequest(f.Extensions)
+	if req == nil || !f.Header.Reply {
+		return nil
+	}
+
+	data := make([]byte, f.Size())
+	if _, err := f.ToBytes(data); err != nil {
+		return err
+	}
+	rc.Store(&Header{Reply: true, Protocol: f.Protocol()}, req.ID, data)
+
+	return nil
+}
+
+// Forget discards any record of the specified request.  This should
+// be called if a request fails in a way that makes it safe to
+// re-execute.
+func (rc *ReplyCache) Forget(protocol uint8, id uint32) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	key := RequestKey{Protocol: protocol, ID: id}
+	if ent, ok := rc.entries[key]; ok {
+		rc.order.Remove(ent.elem)
+		delete(rc.entries, key)
+	}
+}
+
+// Len returns the number of requests currently remembered.
+func (rc *ReplyCache) Len() int {
+	rc.Lock()
+	defer rc.Unlock()
+
+	return len(rc.entries)
+}
+
+// ReplyCacheEntry describes a single request in a snapshot of a reply
+// cache.  The reply is nil if the request is still in progress.
+type ReplyCacheEntry struct {
+	Protocol uint8     `json:"protocol"`        // Protocol number of the request
+	ID       uint32    `json:"id"`              // Correlation ID of the request
+	Reply    []byte    `json:"reply,omitempty"` // The cached reply
+	Expires  time.Time `json:"expires"`         // When the entry expires
+}
+
+// ReplyCacheSnapshot is a serializable snapshot of the state of a
+// reply cache, including requests still in progress.  Snapshots may
+// be used to set up the state of a cache in tests, or to carry the
+// state across a restart.
+type ReplyCacheSnapshot struct {
+	Version int               `json:"version"` // Version of the snapshot format
+	Entries []ReplyCacheEntry `json:"entries"` // Entries, oldest first
+}
+
+// Snapshot returns a snapshot of the state of the cache.  Expired
+// entries are not included.
+func (rc *ReplyCache) Snapshot() *ReplyCacheSnapshot {
+	rc.Lock()
+	defer rc.Unlock()
+
+	rc.expire(timeNow())
+
+	result := &ReplyCacheSnapshot{
+		Version: ReplyCacheVersion,
+		Entries: []ReplyCacheEntry{},
+	}
+	for elem := rc.order.Front(); elem != nil; elem = elem.Next() {
+		ent := elem.Value.(*replyEntry)
+		var reply []byte
+		if ent.reply != nil {
+			reply = append([]byte{}, ent.reply...)
+		}
+		result.Entries = append(result.Entries, ReplyCacheEntry{
+			Protocol: ent.key.Protocol,
+			ID:       ent.key.ID,
+			Reply:    reply,
+			Expires:  ent.expires,
+		})
+	}
+
+	return result
+}
+
+// Restore replaces the state of the cache with the contents of a
+// snapshot.  Expired entries are discarded, as are the oldest entries
+// if the snapshot contains more entries than the cache may hold.
+func (rc *ReplyCache) Restore(snap *ReplyCacheSnapshot) error {
+	if snap.Version != ReplyCacheVersion {
+		return fmt.Errorf("reply cache snapshot version %d: %w", snap.Version, ErrSnapshotVersion)
+	}
+
+	rc.Lock()
+	defer rc.Unlock()
+
+	rc.entries = map[RequestKey]*replyEntry{}
+	rc.order = list.New()
+	for _, e := range snap.Entries {
+		key := RequestKey{Protocol: e.Protocol, ID: e.ID}
+		if old, ok := rc.entries[key]; ok {
+			rc.order.Remove(old.elem)
+		}
+		ent := &replyEntry{
+			key:     key,
+			expires: e.Expires,
+		}
+		if e.Reply != nil {
+			ent.reply = append([]byte{}, e.Reply...)
+		}
+		ent.elem = rc.order.PushBack(ent)
+		rc.entries[key] = ent
+	}
+
+	// Discard expired and excess entries
+	now := timeNow()
+	for elem := rc.order.Front(); elem != nil; elem = rc.order.Front() {
+		ent := elem.Value.(*replyEntry)
+		if now.Before(ent.expires) && rc.order.Len() <= rc.size() {
+			break
+		}
+		rc.order.Remove(elem)
+		delete(rc.entries, ent.key)
+	}
+
+	return nil
+}
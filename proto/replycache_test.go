@@ -0,0 +1,391 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyCacheTTLDefault(t *testing.T) {
+	obj := &ReplyCache{}
+
+	result := obj.ttl()
+
+	assert.Equal(t, DefaultReplyCacheTTL, result)
+}
+
+func TestReplyCacheTTLSet(t *testing.T) {
+	obj := &ReplyCache{TTL: time.Second}
+
+	result := obj.ttl()
+
+	assert.Equal(t, time.Second, result)
+}
+
+func TestReplyCacheSizeDefault(t *testing.T) {
+	obj := &ReplyCache{}
+
+	result := obj.size()
+
+	assert.Equal(t, DefaultReplyCacheSize, result)
+}
+
+func TestReplyCacheSizeSet(t *testing.T) {
+	obj := &ReplyCache{Size: 5}
+
+	result := obj.size()
+
+	assert.Equal(t, 5, result)
+}
+
+func TestReplyCacheCheckReply(t *testing.T) {
+	obj := &ReplyCache{}
+
+	reply, dup := obj.Check(&Header{Reply: true, Protocol: 5}, 42)
+
+	assert.Nil(t, reply)
+	assert.False(t, dup)
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheCheckNew(t *testing.T) {
+	obj := &ReplyCache{}
+
+	reply, dup := obj.Check(&Header{Protocol: 5}, 42)
+
+	assert.Nil(t, reply)
+	assert.False(t, dup)
+	assert.Equal(t, 1, obj.Len())
+}
+
+func TestReplyCacheCheckInProgress(t *testing.T) {
+	obj := &ReplyCache{}
+	obj.Check(&Header{Protocol: 5}, 42)
+
+	reply, dup := obj.Check(&Header{Protocol: 5}, 42)
+
+	assert.Nil(t, reply)
+	assert.True(t, dup)
+}
+
+func TestReplyCacheCheckAnswered(t *testing.T) {
+	obj := &ReplyCache{}
+	obj.Check(&Header{Protocol: 5}, 42)
+	obj.Store(&Header{Reply: true, Protocol: 5}, 42, []byte("reply"))
+
+	reply, dup := obj.Check(&Header{Protocol: 5}, 42)
+
+	assert.Equal(t, []byte("reply"), reply)
+	assert.True(t, dup)
+}
+
+func TestReplyCacheCheckDistinctProtocol(t *testing.T) {
+	obj := &ReplyCache{}
+	obj.Check(&Header{Protocol: 5}, 42)
+
+	reply, dup := obj.Check(&Header{Protocol: 6}, 42)
+
+	assert.Nil(t, reply)
+	assert.False(t, dup)
+	assert.Equal(t, 2, obj.Len())
+}
+
+func TestReplyCacheCheckExpired(t *testing.T) {
+	now := time.Now()
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	obj := &ReplyCache{TTL: time.Second}
+	obj.Check(&Header{Protocol: 5}, 42)
+	obj.Store(&Header{Reply: true, Protocol: 5}, 42, []byte("reply"))
+	now = now.Add(2 * time.Second)
+
+	reply, dup := obj.Check(&Header{Protocol: 5}, 42)
+
+	assert.Nil(t, reply)
+	assert.False(t, dup)
+	assert.Equal(t, 1, obj.Len())
+}
+
+func TestReplyCacheCheckFull(t *testing.T) {
+	obj := &ReplyCache{Size: 2}
+	obj.Check(&Header{Protocol: 5}, 1)
+	obj.Check(&Header{Protocol: 5}, 2)
+
+	reply, dup := obj.Check(&Header{Protocol: 5}, 3)
+
+	assert.Nil(t, reply)
+	assert.False(t, dup)
+	assert.Equal(t, 2, obj.Len())
+	assert.NotContains(t, obj.entries, RequestKey{Protocol: 5, ID: 1})
+}
+
+func TestReplyCacheStoreRequest(t *testing.T) {
+	obj := &ReplyCache{}
+
+	obj.Store(&Header{Protocol: 5}, 42, []byte("request"))
+
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheStoreUnseen(t *testing.T) {
+	obj := &ReplyCache{}
+
+	obj.Store(&Header{Reply: true, Protocol: 5}, 42, []byte("reply"))
+
+	assert.Equal(t, 1, obj.Len())
+	assert.Equal(t, []byte("reply"), obj.entries[RequestKey{Protocol: 5, ID: 42}].reply)
+}
+
+func TestReplyCacheStoreCopies(t *testing.T) {
+	obj := &ReplyCache{}
+	buf := []byte("reply")
+
+	obj.Store(&Header{Reply: true, Protocol: 5}, 42, buf)
+	buf[0] = 'X'
+
+	reply, dup := obj.Check(&Header{Protocol: 5}, 42)
+	assert.Equal(t, []byte("reply"), reply)
+	assert.True(t, dup)
+}
+
+func TestReplyCacheStoreRefreshes(t *testing.T) {
+	obj := &ReplyCache{Size: 2}
+	obj.Check(&Header{Protocol: 5}, 1)
+	obj.Check(&Header{Protocol: 5}, 2)
+
+	obj.Store(&Header{Reply: true, Protocol: 5}, 1, []byte("reply"))
+	obj.Check(&Header{Protocol: 5}, 3)
+
+	assert.Contains(t, obj.entries, RequestKey{Protocol: 5, ID: 1})
+	assert.NotContains(t, obj.entries, RequestKey{Protocol: 5, ID: 2})
+}
+
+func TestReplyCacheReceivedNew(t *testing.T) {
+	obj := &ReplyCache{}
+
+	reply, dup, err := obj.Received(requestFrame(3, 42, false))
+
+	assert.NoError(t, err)
+	assert.False(t, dup)
+	assert.Nil(t, reply)
+	assert.Equal(t, 1, obj.Len())
+}
+
+func TestReplyCacheReceivedNotRequest(t *testing.T) {
+	obj := &ReplyCache{}
+
+	reply, dup, err := obj.Received(&Frame{Header: Header{Protocol: 3}})
+
+	assert.NoError(t, err)
+	assert.False(t, dup)
+	assert.Nil(t, reply)
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheReceivedReply(t *testing.T) {
+	obj := &ReplyCache{}
+
+	reply, dup, err := obj.Received(requestFrame(3, 42, true))
+
+	assert.NoError(t, err)
+	assert.False(t, dup)
+	assert.Nil(t, reply)
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheReceivedAnswered(t *testing.T) {
+	obj := &ReplyCache{}
+	answer := requestFrame(3, 42, true)
+	_, _, err := obj.Received(requestFrame(3, 42, false))
+	require.NoError(t, err)
+	require.NoError(t, obj.Sent(answer))
+
+	reply, dup, err := obj.Received(requestFrame(3, 42, false))
+
+	assert.NoError(t, err)
+	assert.True(t, dup)
+	require.NotNil(t, reply)
+	assert.True(t, reply.Header.Reply)
+	assert.Equal(t, uint8(3), reply.Protocol())
+	assert.Equal(t, answer.Payload, reply.Payload)
+}
+
+func TestReplyCacheReceivedBadReply(t *testing.T) {
+	obj := &ReplyCache{}
+	obj.Store(&Header{Reply: true, Protocol: 3}, 42, []byte{0xff})
+
+	reply, dup, err := obj.Received(requestFrame(3, 42, false))
+
+	assert.Error(t, err)
+	assert.True(t, dup)
+	assert.Nil(t, reply)
+}
+
+func TestReplyCacheSentNotReply(t *testing.T) {
+	obj := &ReplyCache{}
+
+	err := obj.Sent(requestFrame(3, 42, false))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheSentTooLong(t *testing.T) {
+	obj := &ReplyCache{}
+	f := requestFrame(3, 42, true)
+	f.Payload = make([]byte, MaxLength+1)
+
+	err := obj.Sent(f)
+
+	assert.ErrorIs(t, err, ErrTooLong)
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheForget(t *testing.T) {
+	obj := &ReplyCache{}
+	obj.Check(&Header{Protocol: 5}, 42)
+
+	obj.Forget(5, 42)
+
+	assert.Equal(t, 0, obj.Len())
+	reply, dup := obj.Check(&Header{Protocol: 5}, 42)
+	assert.Nil(t, reply)
+	assert.False(t, dup)
+}
+
+func TestReplyCacheForgetUnknown(t *testing.T) {
+	obj := &ReplyCache{}
+
+	obj.Forget(5, 42)
+
+	assert.Equal(t, 0, obj.Len())
+}
+
+func TestReplyCacheSnapshot(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	obj := &ReplyCache{TTL: time.Second}
+	obj.Check(&Header{Protocol: 5}, 1)
+	now = now.Add(500 * time.Millisecond)
+	obj.Check(&Header{Protocol: 5}, 2)
+	obj.Store(&Header{Reply: true, Protocol: 5}, 2, []byte("reply"))
+	now = now.Add(700 * time.Millisecond)
+
+	result := obj.Snapshot()
+
+	assert.Equal(t, &ReplyCacheSnapshot{
+		Version: ReplyCacheVersion,
+		Entries: []ReplyCacheEntry{
+			{
+				Protocol: 5,
+				ID:       2,
+				Reply:    []byte("reply"),
+				Expires:  time.Unix(1001, 500000000),
+			},
+		},
+	}, result)
+}
+
+func TestReplyCacheSnapshotEmpty(t *testing.T) {
+	obj := &ReplyCache{}
+
+	result := obj.Snapshot()
+
+	assert.Equal(t, &ReplyCacheSnapshot{
+		Version: ReplyCacheVersion,
+		Entries: []ReplyCacheEntry{},
+	}, result)
+}
+
+func TestReplyCacheRestoreBase(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	obj := &ReplyCache{Size: 2}
+	obj.Check(&Header{Protocol: 7}, 7)
+
+	err := obj.Restore(&ReplyCacheSnapshot{
+		Version: ReplyCacheVersion,
+		Entries: []ReplyCacheEntry{
+			{Protocol: 5, ID: 1, Expires: time.Unix(999, 0)},
+			{Protocol: 5, ID: 2, Expires: time.Unix(1001, 0)},
+			{Protocol: 5, ID: 3, Reply: []byte("reply"), Expires: time.Unix(1002, 0)},
+			{Protocol: 5, ID: 4, Expires: time.Unix(1003, 0)},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, obj.Len())
+	assert.Equal(t, []byte("reply"), obj.entries[RequestKey{Protocol: 5, ID: 3}].reply)
+	assert.Contains(t, obj.entries, RequestKey{Protocol: 5, ID: 4})
+	assert.NotContains(t, obj.entries, RequestKey{Protocol: 7, ID: 7})
+}
+
+func TestReplyCacheRestoreDuplicate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	defer patcher.SetVar(&timeNow, func() time.Time {
+		return now
+	}).Install().Restore()
+	obj := &ReplyCache{}
+
+	err := obj.Restore(&ReplyCacheSnapshot{
+		Version: ReplyCacheVersion,
+		Entries: []ReplyCacheEntry{
+			{Protocol: 5, ID: 1, Expires: time.Unix(1001, 0)},
+			{Protocol: 5, ID: 1, Reply: []byte("reply"), Expires: time.Unix(1002, 0)},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, obj.Len())
+	assert.Equal(t, 1, obj.order.Len())
+}
+
+func TestReplyCacheRestoreBadVersion(t *testing.T) {
+	obj := &ReplyCache{}
+
+	err := obj.Restore(&ReplyCacheSnapshot{Version: 2})
+
+	assert.ErrorIs(t, err, ErrSnapshotVersion)
+}
+
+func TestReplyCacheSnapshotRoundTrip(t *testing.T) {
+	obj1 := &ReplyCache{}
+	obj1.Check(&Header{Protocol: 5}, 1)
+	obj1.Check(&Header{Protocol: 5}, 2)
+	obj1.Store(&Header{Reply: true, Protocol: 5}, 2, []byte("reply"))
+	data, err := json.Marshal(obj1.Snapshot())
+	require.NoError(t, err)
+	snap := &ReplyCacheSnapshot{}
+	require.NoError(t, json.Unmarshal(data, snap))
+	obj2 := &ReplyCache{}
+
+	err = obj2.Restore(snap)
+
+	assert.NoError(t, err)
+	result, err := json.Marshal(obj2.Snapshot())
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(result))
+}
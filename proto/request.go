@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import "encoding/binary"
+
+// ExtRequest is the extension protocol number of the request
+// extension, which correlates a request PDU with its reply.  A reply
+// carries the Reply bit in the carrier header and the request
+// extension of the request it answers; a reply with the Error bit
+// set reports the failure of the request, its payload describing
+// the error.  The extension is end-to-end, and is carried untouched
+// by the nodes a PDU transits.
+const ExtRequest uint8 = 0x83
+
+// RequestSize is the size of the data of a request extension.
+const RequestSize = 4
+
+// Request describes the request extension.
+type Request struct {
+	ID uint32 // Correlation ID of the request
+}
+
+// FromBytes is a method of Request that fills in the information from
+// the data of a request extension.
+func (r *Request) FromBytes(data []byte) (int, error) {
+	// Make sure we have enough data
+	if len(data) < RequestSize {
+		return 0, ErrShortInput
+	}
+
+	// Fill in the extension
+	r.ID = binary.BigEndian.Uint32(data)
+
+	return RequestSize, nil
+}
+
+// ToBytes is a method of Request that encodes the extension data into
+// a sequence of bytes.  The byte slice to fill in must be passed in.
+func (r *Request) ToBytes(data []byte) (int, error) {
+	// Make sure we have enough space
+	if len(data) < RequestSize {
+		return 0, ErrShortOutput
+	}
+
+	// Fill in the data
+	binary.BigEndian.PutUint32(data, r.ID)
+
+	return RequestSize, nil
+}
+
+// Extension constructs the request extension.  The extension is
+// end-to-end, and is ignored by recipients which do not understand
+// it.
+func (r *Request) Extension() *Extension {
+	data := make([]byte, RequestSize)
+	r.ToBytes(data) //nolint:errcheck
+
+	return &Extension{
+		Number: ExtRequest,
+		Header: ExtHeader{Ignore: true},
+		Data:   data,
+	}
+}
+
+// FindRequest decodes the request extension in an extension chain,
+// returning nil if there is none or it is malformed.
+func FindRequest(exts Extensions) *Request {
+	ext := exts.Find(ExtRequest)
+	if ext == nil {
+		return nil
+	}
+	r := &Request{}
+	if _, err := r.FromBytes(ext.Data); err != nil {
+		return nil
+	}
+
+	return r
+}
+
+// SetRequest returns a copy of an extension chain with the request
+// extension replaced by, or extended with, the specified request.
+// The passed-in chain is not modified.
+func SetRequest(exts Extensions, r *Request) Extensions {
+	result := make(Extensions, 0, len(exts)+1)
+	for _, ext := range exts {
+		if ext.Number != ExtRequest {
+			result = append(result, ext)
+		}
+	}
+
+	return append(result, r.Extension())
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRequestData = []byte{0x00, 0x00, 0x01, 0x02}
+
+func TestRequestFromBytesBase(t *testing.T) {
+	obj := &Request{}
+
+	n, err := obj.FromBytes(testRequestData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, RequestSize, n)
+	assert.Equal(t, &Request{ID: 0x102}, obj)
+}
+
+func TestRequestFromBytesShort(t *testing.T) {
+	obj := &Request{}
+
+	n, err := obj.FromBytes(testRequestData[:RequestSize-1])
+
+	assert.Same(t, ErrShortInput, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, &Request{}, obj)
+}
+
+func TestRequestToBytesBase(t *testing.T) {
+	obj := &Request{ID: 0x102}
+	data := make([]byte, RequestSize+1)
+
+	n, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, RequestSize, n)
+	assert.Equal(t, testRequestData, data[:n])
+}
+
+func TestRequestToBytesShort(t *testing.T) {
+	obj := &Request{}
+
+	n, err := obj.ToBytes(make([]byte, RequestSize-1))
+
+	assert.Same(t, ErrShortOutput, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestRequestExtension(t *testing.T) {
+	obj := &Request{ID: 0x102}
+
+	result := obj.Extension()
+
+	assert.Equal(t, &Extension{
+		Number: ExtRequest,
+		Header: ExtHeader{Ignore: true},
+		Data:   testRequestData,
+	}, result)
+	assert.True(t, IsExtension(result.Number))
+}
+
+func TestFindRequestBase(t *testing.T) {
+	exts := Extensions{(&Flood{}).Extension(), (&Request{ID: 0x102}).Extension()}
+
+	result := FindRequest(exts)
+
+	assert.Equal(t, &Request{ID: 0x102}, result)
+}
+
+func TestFindRequestMissing(t *testing.T) {
+	exts := Extensions{(&Flood{}).Extension()}
+
+	result := FindRequest(exts)
+
+	assert.Nil(t, result)
+}
+
+func TestFindRequestMalformed(t *testing.T) {
+	exts := Extensions{{Number: ExtRequest, Data: []byte{0x01}}}
+
+	result := FindRequest(exts)
+
+	assert.Nil(t, result)
+}
+
+func TestSetRequestBase(t *testing.T) {
+	flood := (&Flood{}).Extension()
+	exts := Extensions{(&Request{ID: 1}).Extension(), flood}
+
+	result := SetRequest(exts, &Request{ID: 2})
+
+	assert.Equal(t, Extensions{flood, (&Request{ID: 2}).Extension()}, result)
+	assert.Equal(t, &Request{ID: 1}, FindRequest(exts))
+}
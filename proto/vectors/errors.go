@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import "errors"
+
+// Common simple errors that may be returned by the vectors package.
+var (
+	ErrBadVectors   = errors.New("invalid vectors file")
+	ErrUnknownKind  = errors.New("unknown kind of vector")
+	ErrTrailingData = errors.New("data follows the encoded unit")
+	ErrBadControl   = errors.New("unexpected control message type")
+	ErrMismatch     = errors.New("result does not match the vector")
+	ErrAccepted     = errors.New("invalid encoding was accepted")
+	ErrRejected     = errors.New("remote codec reported an error")
+	ErrUnknownOp    = errors.New("unknown codec operation")
+)
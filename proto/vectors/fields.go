@@ -0,0 +1,98 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import "github.com/hydralang/humboldt/proto"
+
+// Header gives the fields of the carrier header of a PDU.
+type Header struct {
+	Major    uint8  `json:"major"`    // Major protocol version
+	Reply    bool   `json:"reply"`    // Reply flag
+	Error    bool   `json:"error"`    // Error flag
+	Protocol uint8  `json:"protocol"` // Protocol number
+	Length   uint16 `json:"length"`   // Length following the header
+}
+
+// ExtHeader gives the fields of an extension header.
+type ExtHeader struct {
+	Ignore   bool   `json:"ignore"`     // Ignore flag
+	Close    bool   `json:"close"`      // Close flag
+	HopByHop bool   `json:"hop_by_hop"` // Hop-by-hop flag
+	Protocol uint8  `json:"protocol"`   // Next protocol number
+	Length   uint16 `json:"length"`     // Extension length
+}
+
+// Extension gives the fields of an extension in the chain of a PDU.
+type Extension struct {
+	Number uint8     `json:"number"` // The extension protocol number
+	Header ExtHeader `json:"header"` // The extension header
+	Data   Hex       `json:"data"`   // The extension data
+}
+
+// Frame gives the fields of a complete PDU.  The lengths in the
+// headers are checked when decoding, but are computed from the data
+// when encoding.
+type Frame struct {
+	Header     Header      `json:"header"`     // The carrier header
+	Extensions []Extension `json:"extensions"` // The extension chain
+	Payload    Hex         `json:"payload"`    // The payload
+}
+
+// Control gives the fields of a control protocol message.
+type Control struct {
+	Type uint8 `json:"type"` // The control message type
+	Body Hex   `json:"body"` // The body of the message
+}
+
+// Hello gives the fields of the body of a hello message.
+type Hello struct {
+	MinProto uint32       `json:"min_proto"`           // Minimum supported protocol version
+	MaxProto uint32       `json:"max_proto"`           // Maximum supported protocol version
+	NodeID   proto.NodeID `json:"node_id"`             // Identifier of the sending node
+	MinMajor uint8        `json:"min_major,omitempty"` // Minimum supported major version
+	MaxMajor uint8        `json:"max_major,omitempty"` // Maximum supported major version
+}
+
+// Ping gives the fields of a ping or pong control message.
+type Ping struct {
+	Pong     bool   `json:"pong"`     // The message is a pong
+	Sequence uint64 `json:"sequence"` // The sequence number
+}
+
+// Flood gives the fields of the data of a flood extension.
+type Flood struct {
+	Origin   proto.NodeID `json:"origin"`   // Identifier of the originating node
+	Sequence uint64       `json:"sequence"` // Sequence number assigned by the origin
+	TTL      uint8        `json:"ttl"`      // Remaining hop limit
+}
+
+// Request gives the fields of the data of a request extension.
+type Request struct {
+	ID uint32 `json:"id"` // Correlation ID of the request
+}
+
+// Link gives the fields of a link of a link-state record.
+type Link struct {
+	Neighbor proto.NodeID `json:"neighbor"` // Identifier of the neighbor
+	Cost     uint32       `json:"cost"`     // Cost of the link
+	Anycast  bool         `json:"anycast"`  // The link claims an anycast destination
+}
+
+// LinkState gives the fields of a link-state record.
+type LinkState struct {
+	Origin   proto.NodeID `json:"origin"`   // Identifier of the originating node
+	Sequence uint64       `json:"sequence"` // Sequence number of the record
+	Links    []Link       `json:"links"`    // Links of the originating node
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// Native is the Codec of this implementation of Humboldt, which
+// decodes and encodes the units of the wire format with the proto
+// package.
+type Native struct{}
+
+// nativeKind describes how Native decodes and encodes a kind of unit.
+type nativeKind struct {
+	decode func(data []byte) (interface{}, error)       // Decodes the unit to its fields
+	encode func(fields json.RawMessage) ([]byte, error) // Encodes the unit from its fields
+}
+
+// mkKind constructs a nativeKind from functions decoding and
+// encoding the fields of type T.
+func mkKind[T any](decode func(data []byte) (*T, error), encode func(fields *T) ([]byte, error)) nativeKind {
+	return nativeKind{
+		decode: func(data []byte) (interface{}, error) {
+			fields, err := decode(data)
+			if err != nil {
+				return nil, err
+			}
+			return fields, nil
+		},
+		encode: func(raw json.RawMessage) ([]byte, error) {
+			fields := new(T)
+			if err := json.Unmarshal(raw, fields); err != nil {
+				return nil, err
+			}
+			return encode(fields)
+		},
+	}
+}
+
+// nativeKinds maps the kinds of units to their codecs.
+var nativeKinds = map[string]nativeKind{
+	KindHeader:    mkKind(decodeHeader, encodeHeader),
+	KindExtHeader: mkKind(decodeExtHeader, encodeExtHeader),
+	KindFrame:     mkKind(decodeFrame, encodeFrame),
+	KindControl:   mkKind(decodeControl, encodeControl),
+	KindHello:     mkKind(decodeHello, encodeHello),
+	KindPing:      mkKind(decodePing, encodePing),
+	KindFlood:     mkKind(decodeFlood, encodeFlood),
+	KindRequest:   mkKind(decodeRequest, encodeRequest),
+	KindLinkState: mkKind(decodeLinkState, encodeLinkState),
+}
+
+// Decode decodes a unit of the wire format, returning its fields.
+func (Native) Decode(kind string, data []byte) (json.RawMessage, error) {
+	k, ok := nativeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", kind, ErrUnknownKind)
+	}
+	fields, err := k.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fields)
+}
+
+// Encode encodes a unit of the wire format from its fields.
+func (Native) Encode(kind string, fields json.RawMessage) ([]byte, error) {
+	k, ok := nativeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", kind, ErrUnknownKind)
+	}
+
+	return k.encode(fields)
+}
+
+// exact checks that decoding a unit consumed all of the data.
+func exact(n int, err error, data []byte) error {
+	if err != nil {
+		return err
+	}
+	if n < len(data) {
+		return fmt.Errorf("%d bytes: %w", len(data)-n, ErrTrailingData)
+	}
+
+	return nil
+}
+
+// encodeWith encodes a unit with a ToBytes method into a buffer of
+// the specified size.
+func encodeWith(size int, toBytes func(data []byte) (int, error)) ([]byte, error) {
+	data := make([]byte, size)
+	n, err := toBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data[:n], nil
+}
+
+// decodeHeader decodes a carrier header.
+func decodeHeader(data []byte) (*Header, error) {
+	hdr := proto.Header{}
+	n, err := hdr.FromBytes(data)
+	if err := exact(n, err, data); err != nil {
+		return nil, err
+	}
+
+	return &Header{
+		Major:    hdr.Major,
+		Reply:    hdr.Reply,
+		Error:    hdr.Error,
+		Protocol: hdr.Protocol,
+		Length:   hdr.Length,
+	}, nil
+}
+
+// encodeHeader encodes a carrier header.
+func encodeHeader(h *Header) ([]byte, error) {
+	hdr := &proto.Header{
+		Major:    h.Major,
+		Reply:    h.Reply,
+		Error:    h.Error,
+		Protocol: h.Protocol,
+		Length:   h.Length,
+	}
+
+	return encodeWith(proto.HeaderSize, hdr.ToBytes)
+}
+
+// decodeExtHeader decodes an extension header.
+func decodeExtHeader(data []byte) (*ExtHeader, error) {
+	hdr := proto.ExtHeader{}
+	n, err := hdr.FromBytes(data)
+	if err := exact(n, err, data); err != nil {
+		return nil, err
+	}
+
+	return fromExtHeader(hdr), nil
+}
+
+// fromExtHeader converts an extension header to its fields.
+func fromExtHeader(hdr proto.ExtHeader) *ExtHeader {
+	return &ExtHeader{
+		Ignore:   hdr.Ignore,
+		Close:    hdr.Close,
+		HopByHop: hdr.HopByHop,
+		Protocol: hdr.Protocol,
+		Length:   hdr.Length,
+	}
+}
+
+// toExtHeader converts the fields of an extension header.
+func toExtHeader(h *ExtHeader) proto.ExtHeader {
+	return proto.ExtHeader{
+		Ignore:   h.Ignore,
+		Close:    h.Close,
+		HopByHop: h.HopByHop,
+		Protocol: h.Protocol,
+		Length:   h.Length,
+	}
+}
+
+// encodeExtHeader encodes an extension header.
+func encodeExtHeader(h *ExtHeader) ([]byte, error) {
+	hdr := toExtHeader(h)
+
+	return encodeWith(proto.ExtHeaderSize, hdr.ToBytes)
+}
+
+// decodeFrame decodes a complete PDU.
+func decodeFrame(data []byte) (*Frame, error) {
+	f, err := proto.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, _ := decodeHeader(data[:proto.HeaderSize])
+	result := &Frame{
+		Header:     *hdr,
+		Extensions: make([]Extension, 0, len(f.Extensions)),
+		Payload:    f.Payload,
+	}
+	for _, ext := range f.Extensions {
+		result.Extensions = append(result.Extensions, Extension{
+			Number: ext.Number,
+			Header: *fromExtHeader(ext.Header),
+			Data:   ext.Data,
+		})
+	}
+
+	return result, nil
+}
+
+// encodeFrame encodes a complete PDU.
+func encodeFrame(fr *Frame) ([]byte, error) {
+	f := &proto.Frame{
+		Header: proto.Header{
+			Major:    fr.Header.Major,
+			Reply:    fr.Header.Reply,
+			Error:    fr.Header.Error,
+			Protocol: fr.Header.Protocol,
+		},
+		Payload: fr.Payload,
+	}
+	for i := range fr.Extensions {
+		f.Extensions = append(f.Extensions, &proto.Extension{
+			Number: fr.Extensions[i].Number,
+			Header: toExtHeader(&fr.Extensions[i].Header),
+			Data:   fr.Extensions[i].Data,
+		})
+	}
+
+	return encodeWith(f.Size(), f.ToBytes)
+}
+
+// decodeControl decodes a control message.
+func decodeControl(data []byte) (*Control, error) {
+	msg := proto.ControlMessage{}
+	if _, err := msg.FromBytes(data); err != nil {
+		return nil, err
+	}
+
+	return &Control{Type: uint8(msg.Type), Body: msg.Body}, nil
+}
+
+// encodeControl encodes a control message.
+func encodeControl(c *Control) ([]byte, error) {
+	msg := &proto.ControlMessage{Type: proto.ControlType(c.Type), Body: c.Body}
+
+	return encodeWith(proto.ControlHeaderSize+len(c.Body), msg.ToBytes)
+}
+
+// decodeHello decodes the body of a hello message.
+func decodeHello(data []byte) (*Hello, error) {
+	hello := proto.Hello{}
+	n, err := hello.FromBytes(data)
+	if err := exact(n, err, data); err != nil {
+		return nil, err
+	}
+
+	return &Hello{
+		MinProto: hello.MinProto,
+		MaxProto: hello.MaxProto,
+		NodeID:   hello.NodeID,
+		MinMajor: hello.MinMajor,
+		MaxMajor: hello.MaxMajor,
+	}, nil
+}
+
+// encodeHello encodes the body of a hello message.
+func encodeHello(h *Hello) ([]byte, error) {
+	hello := &proto.Hello{
+		MinProto: h.MinProto,
+		MaxProto: h.MaxProto,
+		NodeID:   h.NodeID,
+		MinMajor: h.MinMajor,
+		MaxMajor: h.MaxMajor,
+	}
+
+	return encodeWith(hello.Size(), hello.ToBytes)
+}
+
+// decodePing decodes a ping or pong control message.
+func decodePing(data []byte) (*Ping, error) {
+	msg := proto.ControlMessage{}
+	if _, err := msg.FromBytes(data); err != nil {
+		return nil, err
+	}
+	if msg.Type != proto.ControlPing && msg.Type != proto.ControlPong {
+		return nil, fmt.Errorf("%d: %w", msg.Type, ErrBadControl)
+	}
+	if len(msg.Body) < proto.PingSize {
+		return nil, proto.ErrShortInput
+	}
+	if err := exact(proto.PingSize, nil, msg.Body); err != nil {
+		return nil, err
+	}
+
+	return &Ping{
+		Pong:     msg.Type == proto.ControlPong,
+		Sequence: binary.BigEndian.Uint64(msg.Body),
+	}, nil
+}
+
+// encodePing encodes a ping or pong control message.
+func encodePing(p *Ping) ([]byte, error) {
+	msg := &proto.ControlMessage{
+		Type: proto.ControlPing,
+		Body: binary.BigEndian.AppendUint64(nil, p.Sequence),
+	}
+	if p.Pong {
+		msg.Type = proto.ControlPong
+	}
+
+	return encodeWith(proto.ControlHeaderSize+proto.PingSize, msg.ToBytes)
+}
+
+// decodeFlood decodes the data of a flood extension.
+func decodeFlood(data []byte) (*Flood, error) {
+	fl := proto.Flood{}
+	n, err := fl.FromBytes(data)
+	if err := exact(n, err, data); err != nil {
+		return nil, err
+	}
+
+	return &Flood{Origin: fl.Origin, Sequence: fl.Sequence, TTL: fl.TTL}, nil
+}
+
+// encodeFlood encodes the data of a flood extension.
+func encodeFlood(f *Flood) ([]byte, error) {
+	fl := &proto.Flood{Origin: f.Origin, Sequence: f.Sequence, TTL: f.TTL}
+
+	return encodeWith(proto.FloodSize, fl.ToBytes)
+}
+
+// decodeRequest decodes the data of a request extension.
+func decodeRequest(data []byte) (*Request, error) {
+	r := proto.Request{}
+	n, err := r.FromBytes(data)
+	if err := exact(n, err, data); err != nil {
+		return nil, err
+	}
+
+	return &Request{ID: r.ID}, nil
+}
+
+// encodeRequest encodes the data of a request extension.
+func encodeRequest(r *Request) ([]byte, error) {
+	req := &proto.Request{ID: r.ID}
+
+	return encodeWith(proto.RequestSize, req.ToBytes)
+}
+
+// decodeLinkState decodes a link-state record.
+func decodeLinkState(data []byte) (*LinkState, error) {
+	ls, err := proto.DecodeLinkState(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LinkState{
+		Origin:   ls.Origin,
+		Sequence: ls.Sequence,
+		Links:    make([]Link, 0, len(ls.Links)),
+	}
+	for _, l := range ls.Links {
+		result.Links = append(result.Links, Link(l))
+	}
+
+	return result, nil
+}
+
+// encodeLinkState encodes a link-state record.
+func encodeLinkState(l *LinkState) ([]byte, error) {
+	ls := &proto.LinkState{Origin: l.Origin, Sequence: l.Sequence}
+	for _, link := range l.Links {
+		ls.Links = append(ls.Links, proto.Link(link))
+	}
+
+	return ls.Encode()
+}
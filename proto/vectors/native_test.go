@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+func TestNativeVectors(t *testing.T) {
+	vectors, err := Load()
+	require.NoError(t, err)
+
+	result := Run(Native{}, vectors)
+
+	assert.Empty(t, result)
+}
+
+func TestNativeDecodeUnknownKind(t *testing.T) {
+	result, err := Native{}.Decode("bogus", []byte{})
+
+	assert.ErrorIs(t, err, ErrUnknownKind)
+	assert.Nil(t, result)
+}
+
+func TestNativeDecodeErrors(t *testing.T) {
+	tests := []struct {
+		kind string
+		data []byte
+		err  error
+	}{
+		{KindHeader, []byte{0, 3, 0, 0, 0}, ErrTrailingData},
+		{KindHeader, []byte{0x10, 3, 0, 0}, proto.ErrMaxVersion},
+		{KindFrame, []byte{0, 3, 0, 1}, proto.ErrShortInput},
+		{KindPing, []byte{byte(proto.ControlDrain)}, ErrBadControl},
+		{KindPing, []byte{byte(proto.ControlPing), 0}, proto.ErrShortInput},
+		{KindLinkState, make([]byte, proto.LinkStateHeaderSize+1), proto.ErrBadLength},
+	}
+
+	for _, test := range tests {
+		result, err := Native{}.Decode(test.kind, test.data)
+
+		assert.ErrorIs(t, err, test.err, test.kind)
+		assert.Nil(t, result)
+	}
+}
+
+func TestNativeEncodeUnknownKind(t *testing.T) {
+	result, err := Native{}.Encode("bogus", json.RawMessage(`{}`))
+
+	assert.ErrorIs(t, err, ErrUnknownKind)
+	assert.Nil(t, result)
+}
+
+func TestNativeEncodeBadFields(t *testing.T) {
+	result, err := Native{}.Encode(KindRequest, json.RawMessage(`{"id": "x"}`))
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
+
+func TestNativeEncodeError(t *testing.T) {
+	result, err := Native{}.Encode(KindHeader, json.RawMessage(`{"major": 1}`))
+
+	assert.ErrorIs(t, err, proto.ErrMaxVersion)
+	assert.Nil(t, result)
+}
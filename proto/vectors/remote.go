@@ -0,0 +1,176 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Operations of the line protocol spoken by Remote and Serve.
+const (
+	OpDecode = "decode" // Decode the data of a unit
+	OpEncode = "encode" // Encode the fields of a unit
+)
+
+// message is a request or response of the line protocol.  Requests
+// carry the operation, the kind, and the data to decode or the fields
+// to encode; responses carry the fields or data, or an error.
+type message struct {
+	Op     string          `json:"op,omitempty"`     // Operation requested
+	Kind   string          `json:"kind,omitempty"`   // Kind of the unit
+	Data   Hex             `json:"data,omitempty"`   // Encoded unit
+	Fields json.RawMessage `json:"fields,omitempty"` // Decoded fields
+	Error  string          `json:"error,omitempty"`  // Error reported
+}
+
+// Remote is a Codec which forwards each operation to the codec of
+// another implementation, over a line protocol of JSON objects.  Each
+// request is an object with the keys "op", either "decode" or
+// "encode"; "kind", the kind of the unit; and "data" or "fields",
+// represented as in the vectors file.  Each response is an object
+// with the key "fields" or "data" giving the result, or "error"
+// giving a description of the failure.  Requests are sent one at a
+// time, each waiting for its response.
+type Remote struct {
+	lock  sync.Mutex    // Serializes the requests
+	enc   *json.Encoder // Encodes the requests
+	dec   *json.Decoder // Decodes the responses
+	close func() error  // Closes the connection to the codec
+}
+
+// NewRemote constructs a Remote which sends requests to w and reads
+// the responses from r.
+func NewRemote(r io.Reader, w io.Writer) *Remote {
+	return &Remote{
+		enc:   json.NewEncoder(w),
+		dec:   json.NewDecoder(r),
+		close: func() error { return nil },
+	}
+}
+
+// StartCommand starts a command implementing the codec of another
+// implementation, which reads requests from its standard input and
+// writes responses to its standard output; its standard error is
+// passed through.  Closing the Remote closes the standard input of
+// the command and waits for it to exit.
+func StartCommand(ctx context.Context, name string, args ...string) (*Remote, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	r := NewRemote(stdout, stdin)
+	r.close = func() error {
+		stdin.Close() //nolint:errcheck
+		return cmd.Wait()
+	}
+
+	return r, nil
+}
+
+// Close closes the connection to the codec.
+func (r *Remote) Close() error {
+	return r.close()
+}
+
+// call sends a request and waits for its response.
+func (r *Remote) call(req *message) (*message, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	resp := &message{}
+	if err := r.dec.Decode(resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrRejected, resp.Error)
+	}
+
+	return resp, nil
+}
+
+// Decode decodes a unit of the wire format, returning its fields.
+func (r *Remote) Decode(kind string, data []byte) (json.RawMessage, error) {
+	resp, err := r.call(&message{Op: OpDecode, Kind: kind, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Fields, nil
+}
+
+// Encode encodes a unit of the wire format from its fields.
+func (r *Remote) Encode(kind string, fields json.RawMessage) ([]byte, error) {
+	resp, err := r.call(&message{Op: OpEncode, Kind: kind, Fields: fields})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// Serve serves the line protocol of Remote for a codec, reading
+// requests from r and writing responses to w until r is exhausted.
+// It allows another implementation's conformance runner to check
+// this implementation, by serving Native.
+func Serve(codec Codec, r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		req := &message{}
+		if err := dec.Decode(req); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		resp := &message{}
+		var err error
+		switch req.Op {
+		case OpDecode:
+			resp.Fields, err = codec.Decode(req.Kind, req.Data)
+		case OpEncode:
+			resp.Data, err = codec.Encode(req.Kind, req.Fields)
+		default:
+			err = fmt.Errorf("%q: %w", req.Op, ErrUnknownOp)
+		}
+		if err != nil {
+			resp = &message{Error: err.Error()}
+		}
+
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeRemote constructs a Remote connected to a codec served by
+// Serve.
+func pipeRemote(t *testing.T, codec Codec) *Remote {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(codec, reqR, respW)
+		respW.Close()
+	}()
+	t.Cleanup(func() {
+		reqW.Close()
+		assert.NoError(t, <-done)
+	})
+
+	return NewRemote(respR, reqW)
+}
+
+func TestRemoteVectors(t *testing.T) {
+	vectors, err := Load()
+	require.NoError(t, err)
+	obj := pipeRemote(t, Native{})
+
+	result := Run(obj, vectors)
+
+	assert.Empty(t, result)
+	assert.NoError(t, obj.Close())
+}
+
+func TestRemoteDecodeRejected(t *testing.T) {
+	obj := pipeRemote(t, Native{})
+
+	result, err := obj.Decode("bogus", []byte{1})
+
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.Contains(t, err.Error(), ErrUnknownKind.Error())
+	assert.Nil(t, result)
+}
+
+func TestRemoteEncodeRejected(t *testing.T) {
+	obj := pipeRemote(t, Native{})
+
+	result, err := obj.Encode("bogus", json.RawMessage(`{}`))
+
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.Nil(t, result)
+}
+
+func TestRemoteCallClosed(t *testing.T) {
+	obj := NewRemote(strings.NewReader(""), io.Discard)
+
+	result, err := obj.Decode(KindRequest, []byte{0, 0, 0, 7})
+
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Nil(t, result)
+}
+
+func TestServeUnknownOp(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := Serve(Native{}, strings.NewReader(`{"op": "bogus"}`), out)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"error": "\"bogus\": unknown codec operation"}`, out.String())
+}
+
+func TestServeBadRequest(t *testing.T) {
+	err := Serve(Native{}, strings.NewReader(`{"op": `), io.Discard)
+
+	assert.Error(t, err)
+}
+
+func TestStartCommand(t *testing.T) {
+	if os.Getenv("HUMBOLDT_VECTORS_SERVE") != "" {
+		Serve(Native{}, os.Stdin, os.Stdout) //nolint:errcheck
+		return
+	}
+	t.Setenv("HUMBOLDT_VECTORS_SERVE", "1")
+	vectors, err := Load()
+	require.NoError(t, err)
+	obj, err := StartCommand(context.Background(), os.Args[0], "-test.run=^TestStartCommand$")
+	require.NoError(t, err)
+
+	result := Run(obj, vectors)
+
+	assert.Empty(t, result)
+	assert.NoError(t, obj.Close())
+}
+
+func TestStartCommandError(t *testing.T) {
+	result, err := StartCommand(context.Background(), "/nonexistent/codec")
+
+	assert.Error(t, err)
+	assert.Nil(t, result)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec decodes and encodes the units of the wire format of an
+// implementation of Humboldt.  The fields are exchanged as JSON,
+// using the representation of the field types of this package.
+type Codec interface {
+	// Decode decodes a unit of the specified kind, returning its
+	// fields.  An error must be returned if the data is not a
+	// valid encoding of exactly one unit.
+	Decode(kind string, data []byte) (json.RawMessage, error)
+
+	// Encode encodes a unit of the specified kind from its fields.
+	Encode(kind string, fields json.RawMessage) ([]byte, error)
+}
+
+// Failure describes a vector which a codec failed.
+type Failure struct {
+	Vector *Vector // The vector which failed
+	Err    error   // The reason for the failure
+}
+
+// Error returns the failure as a string.
+func (f *Failure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Vector.Name, f.Err)
+}
+
+// Unwrap returns the reason for the failure.
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Run checks a codec against the vectors, returning the failures.
+// The data of each valid vector must decode to its fields, and its
+// fields must encode to its data; the data of each invalid vector
+// must be rejected.
+func Run(codec Codec, vectors []*Vector) []*Failure {
+	failures := []*Failure{}
+	for _, v := range vectors {
+		if err := check(codec, v); err != nil {
+			failures = append(failures, &Failure{Vector: v, Err: err})
+		}
+	}
+
+	return failures
+}
+
+// check checks a codec against a single vector.
+func check(codec Codec, v *Vector) error {
+	fields, err := codec.Decode(v.Kind, v.Data)
+	switch {
+	case v.Invalid && err == nil:
+		return ErrAccepted
+	case v.Invalid:
+		return nil
+	case err != nil:
+		return fmt.Errorf("decode: %w", err)
+	}
+	if ok, err := equalJSON(fields, v.Fields); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	} else if !ok {
+		return fmt.Errorf("decode: %w: got %s", ErrMismatch, fields)
+	}
+
+	data, err := codec.Encode(v.Kind, v.Fields)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	if !bytes.Equal(data, v.Data) {
+		return fmt.Errorf("encode: %w: got %x", ErrMismatch, data)
+	}
+
+	return nil
+}
+
+// equalJSON compares two JSON values, ignoring the order of object
+// keys and insignificant white space.
+func equalJSON(a, b json.RawMessage) (bool, error) {
+	var va, vb interface{}
+	if err := unmarshalNumbers(a, &va); err != nil {
+		return false, err
+	}
+	if err := unmarshalNumbers(b, &vb); err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(va, vb), nil
+}
+
+// unmarshalNumbers unmarshals a JSON value, preserving numbers as
+// json.Number so that large integers are compared exactly.
+func unmarshalNumbers(data json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
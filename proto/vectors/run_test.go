@@ -0,0 +1,151 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockCodec struct {
+	mock.Mock
+}
+
+func (m *mockCodec) Decode(kind string, data []byte) (json.RawMessage, error) {
+	args := m.MethodCalled("Decode", kind, data)
+
+	if tmp := args.Get(0); tmp != nil {
+		return json.RawMessage(tmp.(string)), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+func (m *mockCodec) Encode(kind string, fields json.RawMessage) ([]byte, error) {
+	args := m.MethodCalled("Encode", kind, fields)
+
+	if tmp := args.Get(0); tmp != nil {
+		return tmp.([]byte), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+var (
+	validVector   = &Vector{Name: "valid", Kind: KindRequest, Data: Hex{0, 0, 0, 7}, Fields: json.RawMessage(`{"id": 7}`)}
+	invalidVector = &Vector{Name: "invalid", Kind: KindRequest, Data: Hex{7}, Invalid: true}
+)
+
+func TestFailureError(t *testing.T) {
+	obj := &Failure{Vector: validVector, Err: ErrMismatch}
+
+	result := obj.Error()
+
+	assert.Equal(t, "valid: result does not match the vector", result)
+}
+
+func TestFailureUnwrap(t *testing.T) {
+	obj := &Failure{Vector: validVector, Err: ErrMismatch}
+
+	assert.ErrorIs(t, obj, ErrMismatch)
+}
+
+func TestRunBase(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(validVector.Data)).Return("{\"id\":7}\n", nil)
+	codec.On("Encode", KindRequest, validVector.Fields).Return([]byte{0, 0, 0, 7}, nil)
+	codec.On("Decode", KindRequest, []byte(invalidVector.Data)).Return(nil, assert.AnError)
+
+	result := Run(codec, []*Vector{validVector, invalidVector})
+
+	assert.Empty(t, result)
+	codec.AssertExpectations(t)
+}
+
+func TestRunAccepted(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(invalidVector.Data)).Return(`{"id": 7}`, nil)
+
+	result := Run(codec, []*Vector{invalidVector})
+
+	assert.Len(t, result, 1)
+	assert.Same(t, invalidVector, result[0].Vector)
+	assert.ErrorIs(t, result[0], ErrAccepted)
+}
+
+func TestRunDecodeError(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(validVector.Data)).Return(nil, assert.AnError)
+
+	result := Run(codec, []*Vector{validVector})
+
+	assert.Len(t, result, 1)
+	assert.ErrorIs(t, result[0], assert.AnError)
+}
+
+func TestRunDecodeMismatch(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(validVector.Data)).Return(`{"id": 8}`, nil)
+
+	result := Run(codec, []*Vector{validVector})
+
+	assert.Len(t, result, 1)
+	assert.ErrorIs(t, result[0], ErrMismatch)
+}
+
+func TestRunDecodeBadJSON(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(validVector.Data)).Return(`{`, nil)
+
+	result := Run(codec, []*Vector{validVector})
+
+	assert.Len(t, result, 1)
+	assert.NotErrorIs(t, result[0], ErrMismatch)
+}
+
+func TestRunEncodeError(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(validVector.Data)).Return(`{"id": 7}`, nil)
+	codec.On("Encode", KindRequest, validVector.Fields).Return(nil, assert.AnError)
+
+	result := Run(codec, []*Vector{validVector})
+
+	assert.Len(t, result, 1)
+	assert.ErrorIs(t, result[0], assert.AnError)
+}
+
+func TestRunEncodeMismatch(t *testing.T) {
+	codec := &mockCodec{}
+	codec.On("Decode", KindRequest, []byte(validVector.Data)).Return(`{"id": 7}`, nil)
+	codec.On("Encode", KindRequest, validVector.Fields).Return([]byte{0, 0, 0, 8}, nil)
+
+	result := Run(codec, []*Vector{validVector})
+
+	assert.Len(t, result, 1)
+	assert.ErrorIs(t, result[0], ErrMismatch)
+}
+
+func TestEqualJSONLargeNumbers(t *testing.T) {
+	result, err := equalJSON(
+		json.RawMessage(`{"a": 18446744073709551615, "b": 1}`),
+		json.RawMessage(`{"b": 1, "a": 18446744073709551614}`),
+	)
+
+	assert.NoError(t, err)
+	assert.False(t, result)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package vectors holds the golden test vectors of the Humboldt wire
+// format: canonical byte-level encodings of the carrier header, the
+// extension headers and extensions, complete PDUs, the negotiation
+// and ping control messages, and link-state records, together with
+// malformed encodings which must be rejected.  The vectors are kept
+// in the checked-in vectors.json file, so that other implementations
+// of Humboldt may load the same file; Run checks a Codec against the
+// vectors, and Remote and Serve allow the codec of an implementation
+// in another language to be checked over a simple line protocol.
+//
+// The vectors file is a JSON array of objects with the keys "name",
+// a unique name for the vector; "kind", identifying the unit of the
+// wire format encoded; "data", the encoding in lower-case
+// hexadecimal; and either "fields", giving the decoded fields of the
+// unit as described by the types of this package, or "invalid",
+// which is true if decoding the data must fail.  Decoding is strict:
+// the data must contain exactly one unit of the specified kind.
+package vectors
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Kinds of the units of the wire format covered by the vectors.
+const (
+	KindHeader    = "header"     // Carrier header, as Header
+	KindExtHeader = "ext_header" // Extension header, as ExtHeader
+	KindFrame     = "frame"      // Complete PDU, as Frame
+	KindControl   = "control"    // Control message, as Control
+	KindHello     = "hello"      // Body of a hello message, as Hello
+	KindPing      = "ping"       // Ping or pong control message, as Ping
+	KindFlood     = "flood"      // Data of a flood extension, as Flood
+	KindRequest   = "request"    // Data of a request extension, as Request
+	KindLinkState = "linkstate"  // Link-state record, as LinkState
+)
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+// Hex is a byte string which is represented in JSON as lower-case
+// hexadecimal.
+type Hex []byte
+
+// MarshalText encodes the byte string as hexadecimal.
+func (h Hex) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+// UnmarshalText decodes the byte string from hexadecimal.
+func (h *Hex) UnmarshalText(text []byte) error {
+	data, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*h = data
+
+	return nil
+}
+
+// Vector describes a single test vector.
+type Vector struct {
+	Name    string          `json:"name"`              // Unique name of the vector
+	Kind    string          `json:"kind"`              // Kind of the unit encoded
+	Data    Hex             `json:"data"`              // The encoding of the unit
+	Fields  json.RawMessage `json:"fields,omitempty"`  // The decoded fields, if valid
+	Invalid bool            `json:"invalid,omitempty"` // Decoding must fail
+}
+
+// Load returns the vectors of the checked-in vectors file.
+func Load() ([]*Vector, error) {
+	return Parse(vectorsJSON)
+}
+
+// Parse parses the contents of a vectors file.  Names must be unique,
+// and each vector must have fields unless it is invalid.
+func Parse(data []byte) ([]*Vector, error) {
+	vectors := []*Vector{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&vectors); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadVectors, err)
+	}
+
+	names := map[string]bool{}
+	for _, v := range vectors {
+		switch {
+		case v.Name == "" || names[v.Name]:
+			return nil, fmt.Errorf("%w: missing or duplicate name %q", ErrBadVectors, v.Name)
+		case v.Invalid == (len(v.Fields) > 0):
+			return nil, fmt.Errorf("%s: %w: vector must have either fields or the invalid flag", v.Name, ErrBadVectors)
+		}
+		names[v.Name] = true
+	}
+
+	return vectors, nil
+}
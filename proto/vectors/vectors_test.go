@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vectors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHexMarshalText(t *testing.T) {
+	obj := Hex{0x01, 0xab}
+
+	result, err := obj.MarshalText()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("01ab"), result)
+}
+
+func TestHexUnmarshalTextBase(t *testing.T) {
+	obj := Hex{}
+
+	err := obj.UnmarshalText([]byte("01AB"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, Hex{0x01, 0xab}, obj)
+}
+
+func TestHexUnmarshalTextError(t *testing.T) {
+	obj := Hex{}
+
+	err := obj.UnmarshalText([]byte("xyz"))
+
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	result, err := Load()
+
+	require.NoError(t, err)
+	kinds := map[string]bool{}
+	for _, v := range result {
+		kinds[v.Kind] = true
+	}
+	for kind := range nativeKinds {
+		assert.True(t, kinds[kind], kind)
+	}
+}
+
+func TestParseBase(t *testing.T) {
+	result, err := Parse([]byte(`[
+		{"name": "a", "kind": "request", "data": "00000007", "fields": {"id": 7}},
+		{"name": "b", "kind": "request", "data": "07", "invalid": true}
+	]`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*Vector{
+		{Name: "a", Kind: KindRequest, Data: Hex{0, 0, 0, 7}, Fields: []byte(`{"id": 7}`)},
+		{Name: "b", Kind: KindRequest, Data: Hex{7}, Invalid: true},
+	}, result)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		`{}`,
+		`[{"name": "a", "kind": "request", "data": "zz", "invalid": true}]`,
+		`[{"name": "a", "kind": "request", "data": "", "invalid": true, "bogus": 1}]`,
+		`[{"kind": "request", "data": "", "invalid": true}]`,
+		`[{"name": "a", "kind": "request", "data": "", "invalid": true}, {"name": "a", "kind": "request", "data": "", "invalid": true}]`,
+		`[{"name": "a", "kind": "request", "data": ""}]`,
+		`[{"name": "a", "kind": "request", "data": "", "fields": {}, "invalid": true}]`,
+	}
+
+	for _, data := range tests {
+		result, err := Parse([]byte(data))
+
+		assert.ErrorIs(t, err, ErrBadVectors, data)
+		assert.Nil(t, result)
+	}
+}
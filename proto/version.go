@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MajorCodec encodes and decodes the PDUs of a major version of the
+// wire format.  The carrier header, which gives the major version and
+// the length of the PDU, is common to all major versions, so that the
+// PDUs may be delimited, and the codec for the body selected, before
+// the body is decoded; the codec is responsible for the body, the
+// data following the header.
+type MajorCodec interface {
+	// BodySize returns the size of the encoded body of a frame.
+	BodySize(f *Frame) int
+
+	// EncodeBody encodes the extensions and payload of a frame
+	// into data, which must be at least BodySize bytes long.
+	EncodeBody(f *Frame, data []byte) (int, error)
+
+	// DecodeBody decodes the body of a PDU, filling in the
+	// extensions and payload of the frame.  The header of the
+	// frame has already been decoded.  The data slices of the
+	// frame may refer to the passed-in body.
+	DecodeBody(f *Frame, body []byte) error
+}
+
+// majorV0 is the codec for major version 0 of the wire format, in
+// which the body is the extension chain followed by the payload.
+type majorV0 struct{}
+
+// BodySize returns the size of the encoded body of a frame.
+func (majorV0) BodySize(f *Frame) int {
+	return f.Extensions.Size() + len(f.Payload)
+}
+
+// EncodeBody encodes the extensions and payload of a frame.
+func (majorV0) EncodeBody(f *Frame, data []byte) (int, error) {
+	n, err := f.Extensions.ToBytes(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return n + copy(data[n:], f.Payload), nil
+}
+
+// DecodeBody decodes the body of a PDU.
+func (majorV0) DecodeBody(f *Frame, body []byte) error {
+	exts, _, payload, err := ParseExtensions(f.Header.Protocol, body, nil)
+	if err != nil {
+		return err
+	}
+	f.Extensions = exts
+	f.Payload = payload
+
+	return nil
+}
+
+// majorCodecs is a registry of the codecs of the major versions of
+// the wire format, indexed by major version.
+var (
+	majorCodecs = map[uint8]MajorCodec{
+		0: majorV0{},
+	}
+	majorLock sync.RWMutex
+)
+
+// RegisterMajorCodec registers the codec for a major version of the
+// wire format.  Headers giving a major version with no registered
+// codec are rejected with an error wrapping ErrMaxVersion.  Major
+// versions above the largest that fits in the header, MajorMask >>
+// MajorShift, cannot be registered, and are ignored.
+func RegisterMajorCodec(major uint8, codec MajorCodec) {
+	if major > MajorMask>>MajorShift {
+		return
+	}
+
+	majorLock.Lock()
+	defer majorLock.Unlock()
+
+	majorCodecs[major] = codec
+}
+
+// lookupMajorCodec looks up the codec for a major version.
+func lookupMajorCodec(major uint8) (MajorCodec, error) {
+	majorLock.RLock()
+	defer majorLock.RUnlock()
+
+	codec, ok := majorCodecs[major]
+	if !ok {
+		return nil, fmt.Errorf("%d: %w", major, ErrMaxVersion)
+	}
+
+	return codec, nil
+}
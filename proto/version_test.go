@@ -0,0 +1,170 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMajor is a codec for a test major version, in which the body
+// is the payload alone, reversed.
+type testMajor struct{}
+
+func (testMajor) BodySize(f *Frame) int {
+	return len(f.Payload)
+}
+
+func (testMajor) EncodeBody(f *Frame, data []byte) (int, error) {
+	for i, b := range f.Payload {
+		data[len(f.Payload)-1-i] = b
+	}
+
+	return len(f.Payload), nil
+}
+
+func (testMajor) DecodeBody(f *Frame, body []byte) error {
+	if len(body) == 0 {
+		return assert.AnError
+	}
+	f.Payload = make([]byte, len(body))
+	for i, b := range body {
+		f.Payload[len(body)-1-i] = b
+	}
+
+	return nil
+}
+
+// testMajorCodecs returns a registry of major version codecs with
+// testMajor as the codec for major version 1.
+func testMajorCodecs() map[uint8]MajorCodec {
+	return map[uint8]MajorCodec{
+		0: majorV0{},
+		1: testMajor{},
+	}
+}
+
+func TestRegisterMajorCodecBase(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, map[uint8]MajorCodec{}).Install().Restore()
+
+	RegisterMajorCodec(1, testMajor{})
+
+	assert.Equal(t, map[uint8]MajorCodec{1: testMajor{}}, majorCodecs)
+}
+
+func TestRegisterMajorCodecTooHigh(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, map[uint8]MajorCodec{}).Install().Restore()
+
+	RegisterMajorCodec(16, testMajor{})
+
+	assert.Equal(t, map[uint8]MajorCodec{}, majorCodecs)
+}
+
+func TestLookupMajorCodecBase(t *testing.T) {
+	result, err := lookupMajorCodec(0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, majorV0{}, result)
+}
+
+func TestLookupMajorCodecUnknown(t *testing.T) {
+	result, err := lookupMajorCodec(1)
+
+	assert.ErrorIs(t, err, ErrMaxVersion)
+	assert.Nil(t, result)
+}
+
+func TestFrameMajorRoundTrip(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, testMajorCodecs()).Install().Restore()
+	obj := &Frame{Header: Header{Major: 1, Protocol: ProtoData}, Payload: []byte("abc")}
+	data := make([]byte, obj.Size())
+
+	n, err := obj.ToBytes(data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x10, ProtoData, 0x00, 0x03, 'c', 'b', 'a'}, data[:n])
+	result, err := Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{Header: Header{Major: 1, Protocol: ProtoData, Length: 3}, Payload: []byte("abc")}, result)
+}
+
+func TestFrameMajorDecodeError(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, testMajorCodecs()).Install().Restore()
+	obj := &Frame{Payload: []byte("x")}
+
+	n, err := obj.FromBytes([]byte{0x10, ProtoData, 0x00, 0x00})
+
+	assert.Same(t, assert.AnError, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, &Frame{Payload: []byte("x")}, obj)
+}
+
+func TestFrameSizeUnknownMajor(t *testing.T) {
+	obj := &Frame{Header: Header{Major: 2}, Payload: []byte("abc")}
+
+	result := obj.Size()
+
+	assert.Equal(t, HeaderSize+3, result)
+}
+
+func TestReaderSetMajorsAccepted(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, testMajorCodecs()).Install().Restore()
+	obj := NewReader(bytes.NewReader([]byte{0x10, ProtoData, 0x00, 0x01, 'a'}))
+	obj.SetMajors(1, 1)
+
+	result, err := obj.ReadFrame()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Frame{Header: Header{Major: 1, Protocol: ProtoData, Length: 1}, Payload: []byte("a")}, result)
+}
+
+func TestReaderSetMajorsRejected(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, testMajorCodecs()).Install().Restore()
+	obj := NewReader(bytes.NewReader(testFrameData))
+	obj.SetMajors(1, 1)
+
+	result, err := obj.ReadFrame()
+
+	assert.ErrorIs(t, err, ErrMajorVersion)
+	assert.Nil(t, result)
+}
+
+func TestReaderSetMajorsMessage(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, testMajorCodecs()).Install().Restore()
+	obj := NewMessageReader(bytes.NewReader(testFrameData))
+	obj.SetMajors(1, 1)
+
+	result, err := obj.ReadFrame()
+
+	assert.ErrorIs(t, err, ErrMajorVersion)
+	assert.Nil(t, result)
+}
+
+func TestWriterSetMajor(t *testing.T) {
+	defer patcher.SetVar(&majorCodecs, testMajorCodecs()).Install().Restore()
+	w := &errWriter{}
+	obj := NewWriter(w)
+	obj.SetMajor(1)
+	f := &Frame{Header: Header{Protocol: ProtoData}, Payload: []byte("abc")}
+
+	err := obj.WriteFrame(f)
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{0x10, ProtoData, 0x00, 0x03, 'c', 'b', 'a'}}, w.writes)
+	assert.Equal(t, uint8(0), f.Header.Major)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package routing
+
+import (
+	"math"
+	"time"
+)
+
+// Parameters of MetricCost.
+const (
+	CostReferenceSize     = 64 * 1024 // Bytes whose transmission time is added to the cost
+	CostLossPenalty       = 10.0      // Factor by which loss inflates the cost
+	CostCongestionPenalty = 4.0       // Factor by which congestion multiplies the cost
+)
+
+// LinkMetrics are the measurements of a link from which its cost is
+// computed.
+type LinkMetrics struct {
+	RTT       time.Duration // Round-trip time; 0 if not measured
+	Bandwidth uint64        // Bandwidth, in bits per second; 0 if not measured
+	Loss      float64       // Fraction of packets lost
+	Congested bool          // The send queue of the link is congested
+}
+
+// CostFunc computes the cost of a link from its metrics.  Costs are
+// summed along paths, so a cost function should be proportional to
+// the penalty of sending over the link.
+type CostFunc func(m LinkMetrics) uint32
+
+// MetricCost is the default cost function.  The cost is the
+// round-trip time in microseconds, inflated by the loss, plus the
+// time in microseconds to transmit CostReferenceSize bytes at the
+// bandwidth, so that a slow link is penalized even if its latency is
+// low.  The cost of a congested link is multiplied by
+// CostCongestionPenalty, so that paths avoid it while its send queue
+// drains.  A link whose round-trip time is not measured is assumed to
+// have the round-trip time corresponding to DefaultLinkCost; an
+// unmeasured bandwidth adds nothing.  The cost is never less than 1.
+func MetricCost(m LinkMetrics) uint32 {
+	rtt := float64(DefaultLinkCost)
+	if m.RTT > 0 {
+		rtt = float64(m.RTT.Microseconds())
+	}
+	cost := rtt * (1 + CostLossPenalty*min(max(m.Loss, 0), 1))
+	if m.Bandwidth > 0 {
+		cost += CostReferenceSize * 8 * 1e6 / float64(m.Bandwidth)
+	}
+	if m.Congested {
+		cost *= CostCongestionPenalty
+	}
+
+	switch {
+	case cost < 1:
+		return 1
+	case cost > math.MaxUint32:
+		return math.MaxUint32
+	}
+
+	return uint32(cost)
+}
+
+// RTTCost is a cost function considering only the round-trip time of
+// a link, as computed by CostFromRTT.  A link whose round-trip time
+// is not measured costs DefaultLinkCost.
+func RTTCost(m LinkMetrics) uint32 {
+	if m.RTT <= 0 {
+		return DefaultLinkCost
+	}
+
+	return CostFromRTT(m.RTT)
+}
+
+// HopCost is a cost function giving every link a cost of 1, so that
+// routes minimize the number of hops.
+func HopCost(m LinkMetrics) uint32 {
+	return 1
+}
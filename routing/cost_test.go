@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package routing
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricCostRTT(t *testing.T) {
+	assert.Equal(t, uint32(1500), MetricCost(LinkMetrics{RTT: 1500 * time.Microsecond}))
+	assert.Equal(t, DefaultLinkCost, MetricCost(LinkMetrics{}))
+	assert.Equal(t, uint32(1), MetricCost(LinkMetrics{RTT: time.Nanosecond}))
+}
+
+func TestMetricCostLoss(t *testing.T) {
+	assert.Equal(t, uint32(3000), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Loss: 0.2}))
+	assert.Equal(t, uint32(11000), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Loss: 2}))
+	assert.Equal(t, uint32(1000), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Loss: -1}))
+}
+
+func TestMetricCostBandwidth(t *testing.T) {
+	// 64 KiB at 1 Gb/s takes 524µs; at 1 Mb/s, 524ms
+	assert.Equal(t, uint32(1524), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Bandwidth: 1000000000}))
+	assert.Equal(t, uint32(525288), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Bandwidth: 1000000}))
+	assert.Equal(t, uint32(math.MaxUint32), MetricCost(LinkMetrics{RTT: time.Millisecond, Bandwidth: 1}))
+}
+
+func TestMetricCostCongested(t *testing.T) {
+	assert.Equal(t, uint32(4000), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Congested: true}))
+	assert.Equal(t, uint32(12000), MetricCost(LinkMetrics{RTT: 1000 * time.Microsecond, Loss: 0.2, Congested: true}))
+}
+
+func TestMetricCostPrefersFastLink(t *testing.T) {
+	slow := MetricCost(LinkMetrics{RTT: 10 * time.Millisecond, Bandwidth: 1000000})
+	fast := MetricCost(LinkMetrics{RTT: 30 * time.Millisecond, Bandwidth: 1000000000})
+
+	assert.Less(t, fast, slow)
+}
+
+func TestRTTCost(t *testing.T) {
+	assert.Equal(t, uint32(1500), RTTCost(LinkMetrics{RTT: 1500 * time.Microsecond, Bandwidth: 1000, Loss: 0.5}))
+	assert.Equal(t, DefaultLinkCost, RTTCost(LinkMetrics{}))
+}
+
+func TestHopCost(t *testing.T) {
+	assert.Equal(t, uint32(1), HopCost(LinkMetrics{RTT: time.Second, Bandwidth: 1000, Loss: 0.5}))
+}
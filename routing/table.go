@@ -0,0 +1,520 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package routing maintains the topology of the Humboldt overlay, as
+// learned from link-state records, and computes routes through it.
+// Each node describes its links to its neighbors in a link-state
+// record; once the records are distributed, every node holds the same
+// graph and computes the shortest paths from itself to every other
+// node with Dijkstra's algorithm.  The first hop of each path is the
+// neighbor to which PDUs for the destination should be forwarded.
+package routing
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// DefaultLinkCost is the cost of a link whose round-trip time has not
+// yet been measured.  It corresponds to a round-trip time of 100ms.
+const DefaultLinkCost uint32 = 100000
+
+// CostFromRTT computes the cost of a link from its round-trip time.
+// The cost is the round-trip time in microseconds, but is never less
+// than 1, so that every hop adds to the cost of a path.
+func CostFromRTT(rtt time.Duration) uint32 {
+	us := rtt.Microseconds()
+	switch {
+	case us < 1:
+		return 1
+	case us > math.MaxUint32:
+		return math.MaxUint32
+	}
+
+	return uint32(us)
+}
+
+// Route describes the route to a destination node, or to an anycast
+// destination.  Routes to anycast destinations lead to the nearest
+// node claiming them, the claimant.
+type Route struct {
+	Dest     proto.NodeID `json:"dest"`              // The destination node
+	NextHop  proto.NodeID `json:"next_hop"`          // The neighbor to forward PDUs to
+	Cost     uint64       `json:"cost"`              // Total cost of the path
+	Hops     int          `json:"hops"`              // Number of hops in the path
+	Anycast  bool         `json:"anycast,omitempty"` // The destination is an anycast destination
+	Claimant proto.NodeID `json:"claimant,omitzero"` // The node reached, for anycast destinations
+}
+
+// Table is a link-state routing table.  It holds the links of the
+// local node, which are set as conduits to neighbors open and close,
+// and the link-state records received from other nodes.  Routes are
+// computed lazily: changes to the topology mark the routes stale, and
+// they are recomputed on the next lookup.  Records that do not change
+// the topology, such as periodic refreshes, leave the routes intact.
+//
+// A link between two remote nodes is used only if both nodes
+// advertise it, so that a link which has failed in one direction, or
+// a record which is stale, does not attract traffic.  Links of the
+// local node are used as soon as they are set.
+//
+// A node which is shutting down withdraws its links with Withdraw:
+// its record no longer advertises them, so that the other nodes
+// route around it, but it continues to route over them itself.
+//
+// Nodes may claim anycast destinations with Claim; the claims are
+// advertised in their records, and the route to an anycast
+// destination leads to the claimant with the least total cost, the
+// cost of the claim included.  Claims are never used to transit to
+// other destinations.  When the claimant fails or withdraws its
+// claim, the route converges on the next nearest claimant.
+type Table struct {
+	sync.Mutex
+	self      proto.NodeID                      // Identifier of the local node
+	seq       uint64                            // Sequence number of the local record
+	local     map[proto.NodeID]uint32           // Costs of the local links
+	claims    map[proto.NodeID]uint32           // Costs of the local anycast claims
+	states    map[proto.NodeID]*proto.LinkState // Records of the remote nodes
+	routes    map[proto.NodeID]Route            // Computed routes
+	stale     bool                              // Routes must be recomputed
+	withdrawn bool                              // Local links are not advertised
+	cost      CostFunc                          // Computes link costs from metrics
+}
+
+// NewTable constructs a new routing table for the local node.  The
+// sequence number of the local link-state record is seeded from the
+// clock, so that records originated after a restart supersede those
+// originated before it.
+func NewTable(self proto.NodeID) *Table {
+	return &Table{
+		self:   self,
+		seq:    uint64(timeNow().UnixNano()),
+		local:  map[proto.NodeID]uint32{},
+		claims: map[proto.NodeID]uint32{},
+		states: map[proto.NodeID]*proto.LinkState{},
+		routes: map[proto.NodeID]Route{},
+	}
+}
+
+// Self returns the identifier of the local node.
+func (t *Table) Self() proto.NodeID {
+	return t.self
+}
+
+// SetLink sets the cost of the link from the local node to a
+// neighbor, adding the link if necessary.  It returns true if the
+// local links changed, in which case a new local record should be
+// distributed.
+func (t *Table) SetLink(neighbor proto.NodeID, cost uint32) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if neighbor == t.self {
+		return false
+	}
+	if old, ok := t.local[neighbor]; ok && old == cost {
+		return false
+	}
+	t.local[neighbor] = cost
+	t.seq++
+	t.stale = true
+
+	return true
+}
+
+// SetCostFunc sets the function computing the costs of the local
+// links from their metrics with SetLinkMetrics.  If nil, MetricCost
+// is used.  Links already set keep their costs until set again.
+func (t *Table) SetCostFunc(f CostFunc) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.cost = f
+}
+
+// SetLinkMetrics sets the cost of the link from the local node to a
+// neighbor, as computed from its metrics by the table's cost
+// function, adding the link if necessary.  It returns true if the
+// local links changed, as SetLink does.
+func (t *Table) SetLinkMetrics(neighbor proto.NodeID, m LinkMetrics) bool {
+	t.Lock()
+	cost := t.cost
+	t.Unlock()
+	if cost == nil {
+		cost = MetricCost
+	}
+
+	return t.SetLink(neighbor, cost(m))
+}
+
+// RemoveLink removes the link from the local node to a neighbor.  It
+// returns true if the local links changed, in which case a new local
+// record should be distributed.
+func (t *Table) RemoveLink(neighbor proto.NodeID) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.local[neighbor]; !ok {
+		return false
+	}
+	delete(t.local, neighbor)
+	t.seq++
+	t.stale = true
+
+	return true
+}
+
+// Claim claims an anycast destination for the local node, with the
+// specified cost, which is added to the costs of the routes of other
+// nodes to the destination through the local node.  It returns true
+// if the local claims changed, in which case a new local record
+// should be distributed.
+func (t *Table) Claim(anycast proto.NodeID, cost uint32) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if old, ok := t.claims[anycast]; ok && old == cost {
+		return false
+	}
+	t.claims[anycast] = cost
+	t.seq++
+
+	return true
+}
+
+// Unclaim withdraws the local node's claim of an anycast destination.
+// It returns true if the local claims changed, in which case a new
+// local record should be distributed.
+func (t *Table) Unclaim(anycast proto.NodeID) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.claims[anycast]; !ok {
+		return false
+	}
+	delete(t.claims, anycast)
+	t.seq++
+
+	return true
+}
+
+// Claimed tests whether the local node claims an anycast destination.
+func (t *Table) Claimed(anycast proto.NodeID) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	_, ok := t.claims[anycast]
+	return ok
+}
+
+// Withdraw withdraws the links of the local node, so that the local
+// record no longer advertises them; the routes computed from them are
+// unaffected.  It returns true if the local links had not already
+// been withdrawn, in which case a new local record should be
+// distributed.
+func (t *Table) Withdraw() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.withdrawn {
+		return false
+	}
+	t.withdrawn = true
+	t.seq++
+
+	return true
+}
+
+// LocalState returns the link-state record describing the links and
+// anycast claims of the local node.  The links are sorted by
+// neighbor.  Once the links have been withdrawn, the record has
+// none.
+func (t *Table) LocalState() *proto.LinkState {
+	t.Lock()
+	defer t.Unlock()
+
+	ls := &proto.LinkState{
+		Origin:   t.self,
+		Sequence: t.seq,
+		Links:    make([]proto.Link, 0, len(t.local)+len(t.claims)),
+	}
+	if t.withdrawn {
+		return ls
+	}
+	for neighbor, cost := range t.local {
+		ls.Links = append(ls.Links, proto.Link{Neighbor: neighbor, Cost: cost})
+	}
+	for anycast, cost := range t.claims {
+		ls.Links = append(ls.Links, proto.Link{Neighbor: anycast, Cost: cost, Anycast: true})
+	}
+	sortLinks(ls.Links)
+
+	return ls
+}
+
+// sortLinks sorts links by neighbor.
+func sortLinks(links []proto.Link) {
+	slices.SortFunc(links, func(a, b proto.Link) int {
+		return a.Neighbor.Compare(b.Neighbor)
+	})
+}
+
+// Update updates the table with a link-state record received from
+// another node.  It returns true if the record was accepted, which
+// occurs if its sequence number is greater than that of the record
+// held for the node; accepted records should be distributed to the
+// other neighbors.  Records originated by the local node are never
+// accepted.  The table keeps a copy of the record.
+func (t *Table) Update(ls *proto.LinkState) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	old, ok := t.states[ls.Origin]
+	if ls.Origin == t.self || ok && ls.Sequence <= old.Sequence {
+		return false
+	}
+
+	tmp := *ls
+	tmp.Links = slices.Clone(ls.Links)
+	sortLinks(tmp.Links)
+	t.states[ls.Origin] = &tmp
+	if !ok || !slices.Equal(old.Links, tmp.Links) {
+		t.stale = true
+	}
+
+	return true
+}
+
+// Remove removes the record held for a node, such as when it has
+// expired.  It returns true if a record was held.
+func (t *Table) Remove(origin proto.NodeID) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.states[origin]; !ok {
+		return false
+	}
+	delete(t.states, origin)
+	t.stale = true
+
+	return true
+}
+
+// State returns the record held for a node, or nil if none is held.
+// The record must not be modified.
+func (t *Table) State(origin proto.NodeID) *proto.LinkState {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.states[origin]
+}
+
+// States returns copies of the records held for the remote nodes,
+// sorted by origin.
+func (t *Table) States() []*proto.LinkState {
+	t.Lock()
+	result := make([]*proto.LinkState, 0, len(t.states))
+	for _, ls := range t.states {
+		tmp := *ls
+		tmp.Links = slices.Clone(ls.Links)
+		result = append(result, &tmp)
+	}
+	t.Unlock()
+
+	slices.SortFunc(result, func(a, b *proto.LinkState) int {
+		return a.Origin.Compare(b.Origin)
+	})
+
+	return result
+}
+
+// NextHop returns the neighbor to which PDUs for the destination
+// should be forwarded.  It returns false if the destination is not
+// reachable.
+func (t *Table) NextHop(dest proto.NodeID) (proto.NodeID, bool) {
+	r, ok := t.Route(dest)
+
+	return r.NextHop, ok
+}
+
+// Route returns the route to the destination.  It returns false if
+// the destination is not reachable.
+func (t *Table) Route(dest proto.NodeID) (Route, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.compute()
+	r, ok := t.routes[dest]
+
+	return r, ok
+}
+
+// Routes returns the routes to all reachable destinations, sorted by
+// destination.
+func (t *Table) Routes() []Route {
+	t.Lock()
+	defer t.Unlock()
+
+	t.compute()
+	result := make([]Route, 0, len(t.routes))
+	for _, r := range t.routes {
+		result = append(result, r)
+	}
+	slices.SortFunc(result, func(a, b Route) int {
+		return a.Dest.Compare(b.Dest)
+	})
+
+	return result
+}
+
+// links returns the usable links of a node, including its anycast
+// claims.  The local node's claims are not included, since it does
+// not route to itself.  The table must be locked.
+func (t *Table) links(node proto.NodeID) []proto.Link {
+	if node == t.self {
+		result := make([]proto.Link, 0, len(t.local))
+		for neighbor, cost := range t.local {
+			result = append(result, proto.Link{Neighbor: neighbor, Cost: cost})
+		}
+
+		return result
+	}
+
+	ls, ok := t.states[node]
+	if !ok {
+		return nil
+	}
+	result := make([]proto.Link, 0, len(ls.Links))
+	for _, l := range ls.Links {
+		if l.Anycast || t.hasLink(l.Neighbor, node) {
+			result = append(result, l)
+		}
+	}
+
+	return result
+}
+
+// hasLink tests whether a node advertises a link to a neighbor.  The
+// table must be locked.
+func (t *Table) hasLink(node, neighbor proto.NodeID) bool {
+	if node == t.self {
+		_, ok := t.local[neighbor]
+		return ok
+	}
+
+	ls, ok := t.states[node]
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearchFunc(ls.Links, neighbor, func(l proto.Link, id proto.NodeID) int {
+		return l.Neighbor.Compare(id)
+	})
+
+	return found
+}
+
+// compute recomputes the routes, if they are stale, using Dijkstra's
+// algorithm.  Paths of equal cost are broken in favor of the lowest
+// next hop, so that the choice is deterministic.  Anycast
+// destinations are leaves of the graph: routes to them are computed,
+// but never extended.  The table must be locked.
+func (t *Table) compute() {
+	if !t.stale {
+		return
+	}
+	t.stale = false
+
+	routes := map[proto.NodeID]Route{}
+	done := map[proto.NodeID]bool{}
+	q := &routeQueue{}
+	heap.Push(q, Route{Dest: t.self})
+	for q.Len() > 0 {
+		r := heap.Pop(q).(Route)
+		if done[r.Dest] {
+			continue
+		}
+		done[r.Dest] = true
+		if r.Dest != t.self {
+			routes[r.Dest] = r
+		}
+		if r.Anycast {
+			continue
+		}
+
+		for _, l := range t.links(r.Dest) {
+			if done[l.Neighbor] {
+				continue
+			}
+			next := Route{
+				Dest:    l.Neighbor,
+				NextHop: r.NextHop,
+				Cost:    r.Cost + uint64(l.Cost),
+				Hops:    r.Hops + 1,
+			}
+			if l.Anycast {
+				next.Hops = r.Hops
+				next.Anycast = true
+				next.Claimant = r.Dest
+			}
+			if r.Dest == t.self {
+				next.NextHop = l.Neighbor
+			}
+			heap.Push(q, next)
+		}
+	}
+
+	t.routes = routes
+}
+
+// routeQueue is a priority queue of candidate routes, ordered by cost
+// and then by next hop.
+type routeQueue []Route
+
+// Len returns the number of candidate routes.
+func (q routeQueue) Len() int {
+	return len(q)
+}
+
+// Less tests whether a candidate route is preferred to another.
+func (q routeQueue) Less(i, j int) bool {
+	if q[i].Cost != q[j].Cost {
+		return q[i].Cost < q[j].Cost
+	}
+
+	return q[i].NextHop.Less(q[j].NextHop)
+}
+
+// Swap swaps two candidate routes.
+func (q routeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+// Push adds a candidate route.
+func (q *routeQueue) Push(x any) {
+	*q = append(*q, x.(Route))
+}
+
+// Pop removes the last candidate route.
+func (q *routeQueue) Pop() any {
+	old := *q
+	r := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return r
+}
@@ -0,0 +1,421 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package routing
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/klmitch/patcher"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// node constructs a node identifier for testing.
+func node(n byte) proto.NodeID {
+	return proto.NodeID{n}
+}
+
+// state constructs a link-state record for testing.  The links are
+// given as pairs of neighbor and cost.
+func state(origin byte, seq uint64, links ...uint32) *proto.LinkState {
+	ls := &proto.LinkState{Origin: node(origin), Sequence: seq, Links: []proto.Link{}}
+	for i := 0; i+1 < len(links); i += 2 {
+		ls.Links = append(ls.Links, proto.Link{Neighbor: node(byte(links[i])), Cost: links[i+1]})
+	}
+
+	return ls
+}
+
+func TestCostFromRTT(t *testing.T) {
+	assert.Equal(t, uint32(1), CostFromRTT(0))
+	assert.Equal(t, uint32(1), CostFromRTT(-time.Second))
+	assert.Equal(t, uint32(1), CostFromRTT(time.Microsecond))
+	assert.Equal(t, uint32(1500), CostFromRTT(1500*time.Microsecond))
+	assert.Equal(t, DefaultLinkCost, CostFromRTT(100*time.Millisecond))
+	assert.Equal(t, uint32(math.MaxUint32), CostFromRTT(time.Duration(math.MaxInt64)))
+}
+
+func TestNewTable(t *testing.T) {
+	defer patcher.SetVar(&timeNow, func() time.Time { return time.Unix(0, 12345) }).Install().Restore()
+
+	result := NewTable(node(1))
+
+	assert.Equal(t, node(1), result.Self())
+	assert.Equal(t, &proto.LinkState{Origin: node(1), Sequence: 12345, Links: []proto.Link{}}, result.LocalState())
+	assert.Empty(t, result.Routes())
+}
+
+func TestTableSetLink(t *testing.T) {
+	obj := NewTable(node(1))
+	seq := obj.LocalState().Sequence
+
+	assert.True(t, obj.SetLink(node(3), 30))
+	assert.True(t, obj.SetLink(node(2), 20))
+	assert.False(t, obj.SetLink(node(2), 20))
+	assert.False(t, obj.SetLink(node(1), 10))
+	assert.True(t, obj.SetLink(node(2), 25))
+
+	assert.Equal(t, state(1, seq+3, 2, 25, 3, 30), obj.LocalState())
+}
+
+func TestTableSetLinkMetricsDefault(t *testing.T) {
+	obj := NewTable(node(1))
+	seq := obj.LocalState().Sequence
+
+	result := obj.SetLinkMetrics(node(2), LinkMetrics{RTT: 1500 * time.Microsecond, Loss: 0.1})
+
+	assert.True(t, result)
+	assert.Equal(t, state(1, seq+1, 2, 3000), obj.LocalState())
+}
+
+func TestTableSetLinkMetricsCostFunc(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetCostFunc(HopCost)
+	seq := obj.LocalState().Sequence
+
+	result := obj.SetLinkMetrics(node(2), LinkMetrics{RTT: 1500 * time.Microsecond})
+
+	assert.True(t, result)
+	assert.Equal(t, state(1, seq+1, 2, 1), obj.LocalState())
+}
+
+func TestTableClaim(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(3), 30)
+	seq := obj.LocalState().Sequence
+
+	assert.True(t, obj.Claim(node(0xa0), 5))
+	assert.False(t, obj.Claim(node(0xa0), 5))
+	assert.True(t, obj.Claimed(node(0xa0)))
+	assert.False(t, obj.Claimed(node(0xb0)))
+
+	assert.Equal(t, &proto.LinkState{Origin: node(1), Sequence: seq + 1, Links: []proto.Link{
+		{Neighbor: node(3), Cost: 30},
+		{Neighbor: node(0xa0), Cost: 5, Anycast: true},
+	}}, obj.LocalState())
+}
+
+func TestTableUnclaim(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.Claim(node(0xa0), 0)
+	seq := obj.LocalState().Sequence
+
+	assert.False(t, obj.Unclaim(node(0xb0)))
+	assert.True(t, obj.Unclaim(node(0xa0)))
+
+	assert.False(t, obj.Claimed(node(0xa0)))
+	assert.Equal(t, state(1, seq+1), obj.LocalState())
+}
+
+func TestTableClaimWithdrawn(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.Claim(node(0xa0), 0)
+
+	obj.Withdraw()
+
+	assert.Empty(t, obj.LocalState().Links)
+	assert.True(t, obj.Claimed(node(0xa0)))
+}
+
+func TestTableRemoveLink(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 20)
+	seq := obj.LocalState().Sequence
+
+	assert.False(t, obj.RemoveLink(node(3)))
+	assert.True(t, obj.RemoveLink(node(2)))
+
+	assert.Equal(t, state(1, seq+1), obj.LocalState())
+	_, ok := obj.NextHop(node(2))
+	assert.False(t, ok)
+}
+
+func TestTableWithdraw(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 20)
+	seq := obj.LocalState().Sequence
+
+	assert.True(t, obj.Withdraw())
+	assert.False(t, obj.Withdraw())
+
+	assert.Equal(t, state(1, seq+1), obj.LocalState())
+	hop, ok := obj.NextHop(node(2))
+	assert.True(t, ok)
+	assert.Equal(t, node(2), hop)
+}
+
+func TestTableUpdate(t *testing.T) {
+	obj := NewTable(node(1))
+	ls := state(2, 5, 3, 10, 1, 10)
+
+	assert.True(t, obj.Update(ls))
+	assert.False(t, obj.Update(state(2, 5)))
+	assert.False(t, obj.Update(state(2, 4)))
+	assert.False(t, obj.Update(state(1, math.MaxUint64)))
+	assert.True(t, obj.Update(state(2, 6, 3, 10, 1, 10)))
+
+	assert.Equal(t, state(2, 6, 1, 10, 3, 10), obj.State(node(2)))
+	assert.Equal(t, state(2, 5, 3, 10, 1, 10), ls)
+	assert.Nil(t, obj.State(node(1)))
+}
+
+func TestTableUpdateRefresh(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10))
+	obj.Routes()
+
+	obj.Update(state(2, 2, 1, 10))
+
+	assert.False(t, obj.stale)
+	obj.Update(state(2, 3, 1, 10, 3, 10))
+	assert.True(t, obj.stale)
+}
+
+func TestTableRemove(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10, 3, 10))
+	obj.Update(state(3, 1, 2, 10))
+
+	assert.False(t, obj.Remove(node(4)))
+	assert.True(t, obj.Remove(node(3)))
+
+	assert.Nil(t, obj.State(node(3)))
+	_, ok := obj.NextHop(node(3))
+	assert.False(t, ok)
+}
+
+func TestTableStates(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.Update(state(3, 1, 2, 10))
+	obj.Update(state(2, 1, 1, 10, 3, 10))
+
+	result := obj.States()
+
+	assert.Equal(t, []*proto.LinkState{
+		state(2, 1, 1, 10, 3, 10),
+		state(3, 1, 2, 10),
+	}, result)
+	result[0].Links[0].Cost = 42
+	assert.Equal(t, state(2, 1, 1, 10, 3, 10), obj.State(node(2)))
+}
+
+func TestTableNextHopDirect(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+
+	result, ok := obj.NextHop(node(2))
+
+	assert.True(t, ok)
+	assert.Equal(t, node(2), result)
+}
+
+func TestTableNextHopSelf(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10))
+
+	_, ok := obj.NextHop(node(1))
+
+	assert.False(t, ok)
+}
+
+func TestTableNextHopMultiHop(t *testing.T) {
+	// 1 -- 2 -- 3 -- 4
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10, 3, 10))
+	obj.Update(state(3, 1, 2, 10, 4, 10))
+	obj.Update(state(4, 1, 3, 10))
+
+	result, ok := obj.Route(node(4))
+
+	assert.True(t, ok)
+	assert.Equal(t, Route{Dest: node(4), NextHop: node(2), Cost: 30, Hops: 3}, result)
+}
+
+func TestTableNextHopShortest(t *testing.T) {
+	// 1 -- 2 -- 4 costs 10 + 10; 1 -- 3 -- 4 costs 5 + 30
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.SetLink(node(3), 5)
+	obj.Update(state(2, 1, 1, 10, 4, 10))
+	obj.Update(state(3, 1, 1, 5, 4, 30))
+	obj.Update(state(4, 1, 2, 10, 3, 30))
+
+	result, ok := obj.NextHop(node(4))
+
+	assert.True(t, ok)
+	assert.Equal(t, node(2), result)
+
+	// Raising the cost of the link to 2 moves the route
+	obj.SetLink(node(2), 100)
+
+	result, ok = obj.NextHop(node(4))
+
+	assert.True(t, ok)
+	assert.Equal(t, node(3), result)
+}
+
+func TestTableNextHopEqualCost(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(3), 10)
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10, 4, 10))
+	obj.Update(state(3, 1, 1, 10, 4, 10))
+	obj.Update(state(4, 1, 2, 10, 3, 10))
+
+	result, ok := obj.NextHop(node(4))
+
+	assert.True(t, ok)
+	assert.Equal(t, node(2), result)
+}
+
+func TestTableNextHopOneWay(t *testing.T) {
+	// 3 does not advertise the link from 2
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10, 3, 10))
+	obj.Update(state(3, 1))
+
+	_, ok := obj.NextHop(node(3))
+
+	assert.False(t, ok)
+}
+
+// anycastTable constructs a table for testing anycast routes: 1 --
+// 2 -- 3 -- 4, with 2 and 4 claiming anycast destination 0xa0 at the
+// specified costs.
+func anycastTable(claim2, claim4 uint32) *Table {
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(&proto.LinkState{Origin: node(2), Sequence: 1, Links: []proto.Link{
+		{Neighbor: node(1), Cost: 10},
+		{Neighbor: node(3), Cost: 10},
+		{Neighbor: node(0xa0), Cost: claim2, Anycast: true},
+	}})
+	obj.Update(state(3, 1, 2, 10, 4, 10))
+	obj.Update(&proto.LinkState{Origin: node(4), Sequence: 1, Links: []proto.Link{
+		{Neighbor: node(3), Cost: 10},
+		{Neighbor: node(0xa0), Cost: claim4, Anycast: true},
+	}})
+
+	return obj
+}
+
+func TestTableRouteAnycastNearest(t *testing.T) {
+	obj := anycastTable(0, 0)
+
+	result, ok := obj.Route(node(0xa0))
+
+	assert.True(t, ok)
+	assert.Equal(t, Route{Dest: node(0xa0), NextHop: node(2), Cost: 10, Hops: 1, Anycast: true, Claimant: node(2)}, result)
+}
+
+func TestTableRouteAnycastClaimCost(t *testing.T) {
+	obj := anycastTable(100, 0)
+
+	result, ok := obj.Route(node(0xa0))
+
+	assert.True(t, ok)
+	assert.Equal(t, Route{Dest: node(0xa0), NextHop: node(2), Cost: 30, Hops: 3, Anycast: true, Claimant: node(4)}, result)
+}
+
+func TestTableRouteAnycastConverges(t *testing.T) {
+	obj := anycastTable(0, 0)
+	obj.Route(node(0xa0)) //nolint:errcheck
+
+	// 2 withdraws its claim
+	obj.Update(state(2, 2, 1, 10, 3, 10))
+	result, ok := obj.Route(node(0xa0))
+
+	assert.True(t, ok)
+	assert.Equal(t, node(4), result.Claimant)
+
+	// 4 fails
+	obj.Remove(node(4))
+	_, ok = obj.Route(node(0xa0))
+
+	assert.False(t, ok)
+}
+
+func TestTableRouteAnycastNoTransit(t *testing.T) {
+	// 2 claims 0xa0, and 0xa0 advertises a link to 3
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(&proto.LinkState{Origin: node(2), Sequence: 1, Links: []proto.Link{
+		{Neighbor: node(1), Cost: 10},
+		{Neighbor: node(0xa0), Cost: 0, Anycast: true},
+	}})
+	obj.Update(state(0xa0, 1, 3, 10))
+	obj.Update(state(3, 1, 0xa0, 10))
+
+	_, ok := obj.Route(node(3))
+
+	assert.False(t, ok)
+}
+
+func TestTableRouteAnycastLocal(t *testing.T) {
+	obj := anycastTable(0, 0)
+	obj.Claim(node(0xa0), 0)
+
+	result, ok := obj.Route(node(0xa0))
+
+	assert.True(t, ok)
+	assert.Equal(t, node(2), result.Claimant)
+	assert.True(t, obj.Claimed(node(0xa0)))
+}
+
+func TestTableNextHopUnknown(t *testing.T) {
+	// 3 has not advertised its links
+	obj := NewTable(node(1))
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10, 3, 10))
+
+	_, ok := obj.NextHop(node(3))
+
+	assert.False(t, ok)
+}
+
+func TestTableNextHopPartition(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.Update(state(2, 1, 3, 10))
+	obj.Update(state(3, 1, 2, 10))
+
+	_, ok := obj.NextHop(node(3))
+
+	assert.False(t, ok)
+}
+
+func TestTableRoutes(t *testing.T) {
+	obj := NewTable(node(1))
+	obj.SetLink(node(3), 10)
+	obj.SetLink(node(2), 10)
+	obj.Update(state(2, 1, 1, 10))
+	obj.Update(state(3, 1, 1, 10, 4, 5))
+	obj.Update(state(4, 1, 3, 5))
+
+	result := obj.Routes()
+
+	assert.Equal(t, []Route{
+		{Dest: node(2), NextHop: node(2), Cost: 10, Hops: 1},
+		{Dest: node(3), NextHop: node(3), Cost: 10, Hops: 1},
+		{Dest: node(4), NextHop: node(3), Cost: 15, Hops: 2},
+	}, result)
+}
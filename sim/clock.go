@@ -0,0 +1,225 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Kinds of events, in the order in which events due at the same
+// instant run.
+const (
+	kindTimer = iota // A timer set with AfterFunc
+	kindFrame        // Delivery of a frame over a link
+)
+
+// event describes an event scheduled on the virtual clock.  Events
+// due at the same instant run in a fixed order: timers before frame
+// deliveries, timers in the order they were set, and frame deliveries
+// ordered by the receiving node, the sending node, and the order in
+// which they were sent over the link.  The order thus does not depend
+// on the order in which the events were scheduled, which may vary
+// with map iteration.
+type event struct {
+	at    time.Time // When the event is due
+	kind  int       // The kind of event
+	seq   uint64    // Timer sequence number, or link frame counter
+	to    string    // Receiving node of a frame delivery
+	from  string    // Sending node of a frame delivery
+	fn    func()    // Function to call
+	index int       // Index of the event in the queue; -1 once removed
+}
+
+// before returns true if the event runs before another.
+func (e *event) before(o *event) bool {
+	switch {
+	case !e.at.Equal(o.at):
+		return e.at.Before(o.at)
+	case e.kind != o.kind:
+		return e.kind < o.kind
+	case e.to != o.to:
+		return e.to < o.to
+	case e.from != o.from:
+		return e.from < o.from
+	}
+
+	return e.seq < o.seq
+}
+
+// eventQueue is a priority queue of events, implementing
+// heap.Interface.
+type eventQueue []*event
+
+func (q eventQueue) Len() int {
+	return len(q)
+}
+
+func (q eventQueue) Less(i, j int) bool {
+	return q[i].before(q[j])
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+
+	return e
+}
+
+// Clock is a virtual clock.  Time stands still except when the clock
+// is advanced, at which point the events falling due are run in
+// order, each with the clock reading the time it was due.  Events
+// run on the goroutine advancing the clock, and may schedule further
+// events.
+type Clock struct {
+	lock   sync.Mutex // Protects the clock
+	now    time.Time  // The current time
+	seq    uint64     // Counter ordering timers
+	events eventQueue // The scheduled events
+}
+
+// NewClock constructs a virtual clock reading the specified time.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Since returns the time elapsed on the clock since t.
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// schedule schedules an event.
+func (c *Clock) schedule(e *event) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e.at.Before(c.now) {
+		e.at = c.now
+	}
+	heap.Push(&c.events, e)
+}
+
+// Timer describes a function scheduled with AfterFunc.
+type Timer struct {
+	clock *Clock // The clock
+	ev    *event // The scheduled event
+}
+
+// AfterFunc schedules a function to be called once the clock has
+// advanced by the specified duration, returning a Timer which may be
+// used to cancel the call.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *Timer {
+	c.lock.Lock()
+	c.seq++
+	e := &event{at: c.now.Add(d), kind: kindTimer, seq: c.seq, fn: f}
+	c.lock.Unlock()
+
+	c.schedule(e)
+
+	return &Timer{clock: c, ev: e}
+}
+
+// Stop cancels the call, returning false if the function has already
+// been called or the call cancelled.
+func (t *Timer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	if t.ev.index < 0 {
+		return false
+	}
+	heap.Remove(&t.clock.events, t.ev.index)
+
+	return true
+}
+
+// next removes and returns the next event, if it is due no later
+// than the deadline, advancing the clock to when it is due.
+func (c *Clock) next(deadline time.Time) *event {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.events) == 0 || c.events[0].at.After(deadline) {
+		return nil
+	}
+	e := heap.Pop(&c.events).(*event)
+	c.now = e.at
+
+	return e
+}
+
+// Step runs the next event, advancing the clock to when it is due.
+// It returns false if there are no events scheduled.
+func (c *Clock) Step() bool {
+	e := c.next(maxTime)
+	if e == nil {
+		return false
+	}
+	e.fn()
+
+	return true
+}
+
+// Advance advances the clock by the specified duration, running the
+// events falling due in order.  It returns the number of events run.
+func (c *Clock) Advance(d time.Duration) int {
+	deadline := c.Now().Add(d)
+	count := 0
+	for e := c.next(deadline); e != nil; e = c.next(deadline) {
+		e.fn()
+		count++
+	}
+
+	c.lock.Lock()
+	c.now = deadline
+	c.lock.Unlock()
+
+	return count
+}
+
+// Pending returns the number of events scheduled.
+func (c *Clock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.events)
+}
+
+// maxTime is a time later than any event.
+var maxTime = time.Unix(1<<62, 0)
@@ -0,0 +1,113 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockNow(t *testing.T) {
+	obj := NewClock(Epoch)
+
+	result := obj.Now()
+
+	assert.Equal(t, Epoch, result)
+}
+
+func TestClockAdvance(t *testing.T) {
+	obj := NewClock(Epoch)
+	var calls []string
+	var at []time.Time
+	obj.AfterFunc(2*time.Second, func() {
+		calls = append(calls, "second")
+		at = append(at, obj.Now())
+	})
+	obj.AfterFunc(time.Second, func() {
+		calls = append(calls, "first")
+		at = append(at, obj.Now())
+		obj.AfterFunc(0, func() { calls = append(calls, "nested") })
+	})
+	obj.AfterFunc(5*time.Second, func() { calls = append(calls, "late") })
+
+	result := obj.Advance(3 * time.Second)
+
+	assert.Equal(t, 3, result)
+	assert.Equal(t, []string{"first", "nested", "second"}, calls)
+	assert.Equal(t, []time.Time{Epoch.Add(time.Second), Epoch.Add(2 * time.Second)}, at)
+	assert.Equal(t, Epoch.Add(3*time.Second), obj.Now())
+	assert.Equal(t, 1, obj.Pending())
+}
+
+func TestClockAdvanceOrder(t *testing.T) {
+	obj := NewClock(Epoch)
+	var calls []string
+	obj.schedule(&event{at: Epoch, kind: kindFrame, to: "b", from: "a", seq: 1, fn: func() { calls = append(calls, "frame b") }})
+	obj.schedule(&event{at: Epoch, kind: kindFrame, to: "a", from: "b", seq: 2, fn: func() { calls = append(calls, "frame a 2") }})
+	obj.schedule(&event{at: Epoch, kind: kindFrame, to: "a", from: "b", seq: 1, fn: func() { calls = append(calls, "frame a 1") }})
+	obj.AfterFunc(0, func() { calls = append(calls, "timer 1") })
+	obj.AfterFunc(0, func() { calls = append(calls, "timer 2") })
+
+	obj.Advance(0)
+
+	assert.Equal(t, []string{"timer 1", "timer 2", "frame a 1", "frame a 2", "frame b"}, calls)
+}
+
+func TestClockStep(t *testing.T) {
+	obj := NewClock(Epoch)
+	called := false
+	obj.AfterFunc(time.Minute, func() { called = true })
+
+	result := obj.Step()
+
+	assert.True(t, result)
+	assert.True(t, called)
+	assert.Equal(t, Epoch.Add(time.Minute), obj.Now())
+	assert.False(t, obj.Step())
+}
+
+func TestTimerStop(t *testing.T) {
+	obj := NewClock(Epoch)
+	called := false
+	timer := obj.AfterFunc(time.Second, func() { called = true })
+
+	result := timer.Stop()
+
+	assert.True(t, result)
+	assert.False(t, timer.Stop())
+	obj.Advance(time.Minute)
+	assert.False(t, called)
+}
+
+func TestTimerStopFired(t *testing.T) {
+	obj := NewClock(Epoch)
+	timer := obj.AfterFunc(time.Second, func() {})
+	obj.Advance(time.Minute)
+
+	result := timer.Stop()
+
+	assert.False(t, result)
+}
+
+func TestClockSince(t *testing.T) {
+	obj := NewClock(Epoch)
+	obj.Advance(time.Minute)
+
+	result := obj.Since(Epoch)
+
+	assert.Equal(t, time.Minute, result)
+}
@@ -0,0 +1,572 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sim is a deterministic simulation harness for testing the
+// routing and flooding behavior of many Humboldt nodes in a unit
+// test.  A Network instantiates nodes in process, each with its own
+// routing table, flooder, and gossiper, and connects them by
+// in-memory links whose latency, jitter, and loss are controlled by
+// the test, and which may be partitioned and healed.  Nothing happens
+// in real time: frames are delivered and the nodes' periodic work is
+// performed as the network's virtual clock is advanced, on the
+// goroutine advancing it, and all randomness is drawn from sources
+// seeded from the network's seed.  A simulation run with the same
+// seed and the same sequence of operations thus always unfolds the
+// same way.
+package sim
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/hydralang/humboldt/flood"
+	"github.com/hydralang/humboldt/gossip"
+	"github.com/hydralang/humboldt/peer"
+	"github.com/hydralang/humboldt/proto"
+	"github.com/hydralang/humboldt/routing"
+)
+
+// Defaults for the Network.
+const (
+	DefaultLinkStateInterval = 30 * time.Second // Default interval between link-state refreshes
+	DefaultGossipInterval    = 30 * time.Second // Default interval between gossip rounds
+	DefaultDetectTime        = 3 * time.Second  // Default time to detect a partitioned link
+)
+
+// Epoch is the time the virtual clock of a new network reads.
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// allNeighbors is the gossip fanout of simulated nodes.  The gossiper
+// chooses the neighbors it gossips to from the global random source,
+// so simulated nodes gossip to all of them, keeping the simulation
+// deterministic.
+const allNeighbors = 1 << 30
+
+// LinkConfig describes the behavior of a simulated link.  The delay
+// of each frame is the latency plus a random amount up to the jitter;
+// frames are nonetheless delivered in the order they were sent.  Each
+// frame is lost with the probability given by Loss.
+type LinkConfig struct {
+	Latency time.Duration // One-way delay of every frame
+	Jitter  time.Duration // Maximum additional random delay
+	Loss    float64       // Probability of losing a frame, from 0 to 1
+}
+
+// Stats counts the frames sent over the links of a network.
+type Stats struct {
+	Sent      uint64 // Frames sent
+	Delivered uint64 // Frames delivered
+	Lost      uint64 // Frames lost to the links' loss rates
+	Blocked   uint64 // Frames dropped by partitions or downed links
+}
+
+// Network is a simulated network of Humboldt nodes.  The interval
+// fields must be set before nodes are added; zero values select the
+// defaults.  A Network is not safe for concurrent use; all of its
+// methods, and the advancing of its clock, must be called from a
+// single goroutine.
+type Network struct {
+	Clock             *Clock        // The virtual clock
+	LinkStateInterval time.Duration // Interval between link-state refreshes
+	GossipInterval    time.Duration // Interval between gossip rounds
+	DetectTime        time.Duration // Time for a node to notice a partitioned link
+
+	seed   uint64                         // Seed of the random sources
+	nodes  map[proto.NodeID]*Node         // The nodes
+	groups map[proto.NodeID]int           // Partition groups of the nodes
+	cut    map[[2]proto.NodeID]LinkConfig // Links severed by partitions
+	stats  Stats                          // Frame counts
+}
+
+// New constructs an empty network whose random sources are seeded
+// from the specified seed.
+func New(seed uint64) *Network {
+	return &Network{
+		Clock:  NewClock(Epoch),
+		seed:   seed,
+		nodes:  map[proto.NodeID]*Node{},
+		groups: map[proto.NodeID]int{},
+		cut:    map[[2]proto.NodeID]LinkConfig{},
+	}
+}
+
+// interval returns a configured interval, or the default.
+func interval(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+
+	return d
+}
+
+// AddNode adds a node with the specified identifier to the network.
+// The node's periodic work begins immediately.
+func (net *Network) AddNode(id proto.NodeID) (*Node, error) {
+	if _, ok := net.nodes[id]; ok {
+		return nil, fmt.Errorf("node %s: %w", id, ErrNodeExists)
+	}
+
+	n := &Node{
+		ID:     id,
+		Routes: routing.NewTable(id),
+		Peers:  &peer.Store{},
+		net:    net,
+		links:  map[proto.NodeID]*link{},
+	}
+	n.Flooder = &flood.Flooder{Self: id, Deliver: n.deliverFlood}
+	n.Gossiper = &gossip.Gossiper{
+		Store:  n.Peers,
+		Self:   id,
+		Fanout: allNeighbors,
+		Policy: proto.AdvertAllowUnsigned,
+	}
+	n.Peers.Merge(&proto.Advert{
+		NodeID: id,
+		Issued: net.Clock.Now(),
+		URIs:   []string{"sim:" + id.String()},
+	})
+	net.nodes[id] = n
+
+	net.Clock.AfterFunc(interval(net.LinkStateInterval, DefaultLinkStateInterval), n.refresh)
+	net.Clock.AfterFunc(interval(net.GossipInterval, DefaultGossipInterval), n.gossip)
+
+	return n, nil
+}
+
+// Node returns the node with the specified identifier, or nil.
+func (net *Network) Node(id proto.NodeID) *Node {
+	return net.nodes[id]
+}
+
+// Nodes returns the nodes of the network, ordered by identifier.
+func (net *Network) Nodes() []*Node {
+	result := make([]*Node, 0, len(net.nodes))
+	for _, n := range net.nodes {
+		result = append(result, n)
+	}
+	slices.SortFunc(result, func(a, b *Node) int {
+		return compareIDs(a.ID, b.ID)
+	})
+
+	return result
+}
+
+// compareIDs compares two node identifiers.
+func compareIDs(a, b proto.NodeID) int {
+	return slices.Compare(a[:], b[:])
+}
+
+// lookup looks up a pair of nodes.
+func (net *Network) lookup(a, b proto.NodeID) (*Node, *Node, error) {
+	na := net.nodes[a]
+	if na == nil {
+		return nil, nil, fmt.Errorf("node %s: %w", a, ErrUnknownNode)
+	}
+	nb := net.nodes[b]
+	if nb == nil {
+		return nil, nil, fmt.Errorf("node %s: %w", b, ErrUnknownNode)
+	}
+
+	return na, nb, nil
+}
+
+// Connect connects two nodes by a link with the specified
+// configuration in both directions.  Both nodes add the link to
+// their routing tables, its cost computed from the configured
+// latency and loss, and flood their link-state records.
+func (net *Network) Connect(a, b proto.NodeID, cfg LinkConfig) error {
+	na, nb, err := net.lookup(a, b)
+	if err != nil {
+		return err
+	}
+	if na.links[b] != nil {
+		return fmt.Errorf("nodes %s and %s: %w", a, b, ErrLinkExists)
+	}
+
+	na.attach(nb, cfg)
+	nb.attach(na, cfg)
+
+	return nil
+}
+
+// Disconnect takes down the link between two nodes.  Frames in
+// flight over the link are lost, and both nodes remove the link from
+// their routing tables and flood their link-state records.
+func (net *Network) Disconnect(a, b proto.NodeID) error {
+	na, nb, err := net.lookup(a, b)
+	if err != nil {
+		return err
+	}
+	if na.links[b] == nil {
+		return fmt.Errorf("nodes %s and %s: %w", a, b, ErrNoLink)
+	}
+
+	na.detach(b)
+	nb.detach(a)
+
+	return nil
+}
+
+// Partition partitions the network into the specified groups of
+// nodes.  Frames between nodes in different groups are dropped; nodes
+// in no group are unaffected.  After the detection time, the nodes at
+// either end of each link crossing the partition notice that it has
+// failed, and take it down as with Disconnect.  Any earlier partition
+// is healed first.
+func (net *Network) Partition(groups ...[]proto.NodeID) {
+	net.Heal()
+	for i, group := range groups {
+		for _, id := range group {
+			net.groups[id] = i + 1
+		}
+	}
+
+	net.Clock.AfterFunc(interval(net.DetectTime, DefaultDetectTime), func() {
+		for _, n := range net.Nodes() {
+			for _, id := range n.Neighbors() {
+				if compareIDs(n.ID, id) < 0 && net.partitioned(n.ID, id) {
+					net.cut[[2]proto.NodeID{n.ID, id}] = n.links[id].config
+					net.Disconnect(n.ID, id) //nolint:errcheck
+				}
+			}
+		}
+	})
+}
+
+// Heal heals the partition of the network, restoring the links it
+// severed.
+func (net *Network) Heal() {
+	clear(net.groups)
+	cut := make([][2]proto.NodeID, 0, len(net.cut))
+	for ends := range net.cut {
+		cut = append(cut, ends)
+	}
+	slices.SortFunc(cut, func(a, b [2]proto.NodeID) int {
+		if c := compareIDs(a[0], b[0]); c != 0 {
+			return c
+		}
+		return compareIDs(a[1], b[1])
+	})
+	for _, ends := range cut {
+		net.Connect(ends[0], ends[1], net.cut[ends]) //nolint:errcheck
+	}
+	clear(net.cut)
+}
+
+// partitioned returns true if a partition separates two nodes.
+func (net *Network) partitioned(a, b proto.NodeID) bool {
+	ga, gb := net.groups[a], net.groups[b]
+
+	return ga != 0 && gb != 0 && ga != gb
+}
+
+// Run advances the virtual clock by the specified duration, running
+// the simulation.
+func (net *Network) Run(d time.Duration) {
+	net.Clock.Advance(d)
+}
+
+// Stats returns the counts of the frames sent over the links.
+func (net *Network) Stats() Stats {
+	return net.stats
+}
+
+// distances returns the costs of the shortest paths from a node to
+// the nodes reachable from it over the links which are up and not
+// partitioned, the cost of each link being that the node at its near
+// end advertises.
+func (net *Network) distances(from proto.NodeID) map[proto.NodeID]uint64 {
+	result := map[proto.NodeID]uint64{}
+	tentative := map[proto.NodeID]uint64{from: 0}
+	for len(tentative) > 0 {
+		// Settle the nearest tentative node
+		var cur proto.NodeID
+		first := true
+		for id, d := range tentative {
+			if first || d < tentative[cur] || (d == tentative[cur] && compareIDs(id, cur) < 0) {
+				cur, first = id, false
+			}
+		}
+		result[cur] = tentative[cur]
+		delete(tentative, cur)
+
+		for _, l := range net.nodes[cur].Routes.LocalState().Links {
+			if _, done := result[l.Neighbor]; done || l.Anycast || net.nodes[cur].links[l.Neighbor] == nil || net.partitioned(cur, l.Neighbor) {
+				continue
+			}
+			d := result[cur] + uint64(l.Cost)
+			if old, ok := tentative[l.Neighbor]; !ok || d < old {
+				tentative[l.Neighbor] = d
+			}
+		}
+	}
+
+	return result
+}
+
+// Path returns the path from one node to another, following the next
+// hops of the routing tables of the nodes along it.  It returns nil
+// if a node along the path has no route to the destination, or the
+// next hops loop or are not linked to the nodes choosing them.
+func (net *Network) Path(from, to proto.NodeID) []proto.NodeID {
+	path := []proto.NodeID{from}
+	for cur := from; cur != to; {
+		n := net.nodes[cur]
+		if n == nil {
+			return nil
+		}
+		hop, ok := n.Routes.NextHop(to)
+		if !ok || n.links[hop] == nil || slices.Contains(path, hop) {
+			return nil
+		}
+		path = append(path, hop)
+		cur = hop
+	}
+
+	return path
+}
+
+// Converged returns true if the routing tables of the nodes have
+// converged: each node has a loop-free route along a shortest path
+// to every node reachable from it, and no route to any other node.
+func (net *Network) Converged() bool {
+	for _, n := range net.Nodes() {
+		dist := net.distances(n.ID)
+		for _, o := range net.Nodes() {
+			if o == n {
+				continue
+			}
+			route, routed := n.Routes.Route(o.ID)
+			d, reachable := dist[o.ID]
+			switch {
+			case routed != reachable:
+				return false
+			case routed && (route.Cost != d || net.Path(n.ID, o.ID) == nil):
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Settle runs the simulation until the routing tables converge,
+// checking at the specified interval, for no longer than the
+// specified limit.  It returns the time taken to converge, and false
+// if they did not.
+func (net *Network) Settle(step, limit time.Duration) (time.Duration, bool) {
+	start := net.Clock.Now()
+	for !net.Converged() {
+		if net.Clock.Since(start) >= limit {
+			return net.Clock.Since(start), false
+		}
+		net.Run(step)
+	}
+
+	return net.Clock.Since(start), true
+}
+
+// Node is a node of a simulated network.
+type Node struct {
+	ID       proto.NodeID                            // Identifier of the node
+	Routes   *routing.Table                          // The routing table
+	Flooder  *flood.Flooder                          // Floods link-state records
+	Gossiper *gossip.Gossiper                        // Gossips the peer store
+	Peers    *peer.Store                             // The peer store
+	Handler  func(from proto.NodeID, f *proto.Frame) // Called with frames of other protocols; may be nil
+
+	net   *Network               // The network
+	links map[proto.NodeID]*link // Links to the neighbors
+}
+
+// Neighbors returns the identifiers of the nodes linked to the node,
+// in order.
+func (n *Node) Neighbors() []proto.NodeID {
+	result := make([]proto.NodeID, 0, len(n.links))
+	for id := range n.links {
+		result = append(result, id)
+	}
+	slices.SortFunc(result, compareIDs)
+
+	return result
+}
+
+// Send sends a frame to a neighbor, over the link to it.
+func (n *Node) Send(to proto.NodeID, f *proto.Frame) error {
+	l := n.links[to]
+	if l == nil {
+		return fmt.Errorf("node %s: %w", to, ErrNoLink)
+	}
+
+	return l.Send(f)
+}
+
+// attach attaches the sending end of a link to a neighbor.
+func (n *Node) attach(to *Node, cfg LinkConfig) {
+	h := fnv.New64a()
+	h.Write(n.ID[:])  //nolint:errcheck
+	h.Write(to.ID[:]) //nolint:errcheck
+	l := &link{
+		from:   n,
+		to:     to,
+		config: cfg,
+		rng:    rand.New(rand.NewPCG(n.net.seed, h.Sum64())),
+	}
+	n.links[to.ID] = l
+	n.Flooder.Add(l)
+	n.Gossiper.Add(l)
+	n.Routes.SetLinkMetrics(to.ID, routing.LinkMetrics{RTT: 2 * cfg.Latency, Loss: cfg.Loss})
+	n.floodState()
+}
+
+// detach detaches the link to a neighbor.
+func (n *Node) detach(to proto.NodeID) {
+	l := n.links[to]
+	l.down = true
+	delete(n.links, to)
+	n.Flooder.Remove(l)
+	n.Gossiper.Remove(l)
+	if n.Routes.RemoveLink(to) {
+		n.floodState()
+	}
+}
+
+// floodState floods the node's link-state record.
+func (n *Node) floodState() {
+	f, err := n.Routes.LocalState().Frame()
+	if err != nil {
+		return
+	}
+	n.Flooder.Flood(f) //nolint:errcheck
+}
+
+// refresh floods the node's link-state record, and schedules the next
+// refresh.
+func (n *Node) refresh() {
+	n.floodState()
+	n.net.Clock.AfterFunc(interval(n.net.LinkStateInterval, DefaultLinkStateInterval), n.refresh)
+}
+
+// gossip performs a gossip round, and schedules the next.
+func (n *Node) gossip() {
+	n.Gossiper.Round() //nolint:errcheck
+	n.net.Clock.AfterFunc(interval(n.net.GossipInterval, DefaultGossipInterval), n.gossip)
+}
+
+// deliverFlood is called by the flooder with each new flooded PDU.
+func (n *Node) deliverFlood(from flood.Sender, f *proto.Frame) {
+	if f.Protocol() != proto.ProtoLinkState {
+		return
+	}
+	if ls, err := proto.DecodeLinkState(f.Payload); err == nil {
+		n.Routes.Update(ls)
+	}
+}
+
+// receive dispatches a frame received from a neighbor.
+func (n *Node) receive(from *Node, f *proto.Frame) {
+	switch f.Protocol() {
+	case proto.ProtoLinkState:
+		if l := n.links[from.ID]; l != nil {
+			n.Flooder.Handle(l, f) //nolint:errcheck
+		}
+
+	case proto.ProtoGossip:
+		n.Gossiper.Handle(f) //nolint:errcheck
+
+	default:
+		if n.Handler != nil {
+			n.Handler(from.ID, f)
+		}
+	}
+}
+
+// link is one direction of a simulated link between two nodes.  It
+// implements flood.Sender and gossip.Sender.
+type link struct {
+	from   *Node      // The sending node
+	to     *Node      // The receiving node
+	config LinkConfig // The link's configuration
+	rng    *rand.Rand // Source of the link's jitter and loss
+	count  uint64     // Frames sent over the link
+	last   time.Time  // When the last frame sent is to be delivered
+	down   bool       // The link has been taken down
+}
+
+// Send sends a frame over the link.  The frame is encoded as it would
+// be on the wire, and decoded when it is delivered, so that the
+// receiver shares no state with the sender.
+func (l *link) Send(f *proto.Frame) error {
+	net := l.from.net
+	if l.down {
+		return ErrLinkDown
+	}
+	data := make([]byte, f.Size())
+	if _, err := f.ToBytes(data); err != nil {
+		return err
+	}
+	net.stats.Sent++
+	l.count++
+
+	// Draw the loss and jitter for every frame, so that the draws
+	// do not depend on which frames are blocked
+	lost := l.rng.Float64() < l.config.Loss
+	delay := l.config.Latency
+	if l.config.Jitter > 0 {
+		delay += time.Duration(l.rng.Int64N(int64(l.config.Jitter) + 1))
+	}
+	switch {
+	case net.partitioned(l.from.ID, l.to.ID):
+		net.stats.Blocked++
+		return nil
+	case lost:
+		net.stats.Lost++
+		return nil
+	}
+
+	at := net.Clock.Now().Add(delay)
+	if at.Before(l.last) {
+		at = l.last
+	}
+	l.last = at
+	net.Clock.schedule(&event{
+		at:   at,
+		kind: kindFrame,
+		seq:  l.count,
+		to:   string(l.to.ID[:]),
+		from: string(l.from.ID[:]),
+		fn:   func() { l.deliver(data) },
+	})
+
+	return nil
+}
+
+// deliver delivers a frame to the receiving node, unless the link has
+// since gone down or been partitioned.
+func (l *link) deliver(data []byte) {
+	net := l.from.net
+	if l.down || net.partitioned(l.from.ID, l.to.ID) {
+		net.stats.Blocked++
+		return
+	}
+	f := &proto.Frame{}
+	if _, err := f.FromBytes(data); err != nil {
+		return
+	}
+	net.stats.Delivered++
+	l.to.receive(l.from, f)
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2021 Kevin L. Mitchell
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you
+// may not use this file except in compliance with the License.  You
+// may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.  See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hydralang/humboldt/proto"
+)
+
+// testNetwork constructs a network of nodes with the identifiers 1
+// through count.
+func testNetwork(t *testing.T, seed uint64, count int) *Network {
+	t.Helper()
+
+	net := New(seed)
+	net.LinkStateInterval = time.Second
+	net.GossipInterval = time.Second
+	for i := 1; i <= count; i++ {
+		_, err := net.AddNode(proto.NodeID{byte(i)})
+		require.NoError(t, err)
+	}
+
+	return net
+}
+
+// connect connects pairs of nodes, identified by number.
+func connect(t *testing.T, net *Network, cfg LinkConfig, pairs ...[2]byte) {
+	t.Helper()
+
+	for _, p := range pairs {
+		require.NoError(t, net.Connect(proto.NodeID{p[0]}, proto.NodeID{p[1]}, cfg))
+	}
+}
+
+// ids constructs node identifiers from numbers.
+func ids(nums ...byte) []proto.NodeID {
+	result := make([]proto.NodeID, len(nums))
+	for i, num := range nums {
+		result[i] = proto.NodeID{num}
+	}
+
+	return result
+}
+
+var fast = LinkConfig{Latency: 10 * time.Millisecond}
+
+func TestNetworkConverge(t *testing.T) {
+	obj := testNetwork(t, 1, 5)
+	connect(t, obj, fast, [2]byte{1, 2}, [2]byte{2, 3}, [2]byte{3, 4}, [2]byte{4, 5})
+
+	elapsed, ok := obj.Settle(10*time.Millisecond, time.Minute)
+
+	assert.True(t, ok)
+	assert.Less(t, elapsed, time.Second)
+	assert.Equal(t, ids(1, 2, 3, 4, 5), obj.Path(proto.NodeID{1}, proto.NodeID{5}))
+	assert.Equal(t, ids(5, 4, 3, 2, 1), obj.Path(proto.NodeID{5}, proto.NodeID{1}))
+}
+
+func TestNetworkShortestPath(t *testing.T) {
+	// 1 -- 2 -- 3 is faster than the direct link from 1 to 3
+	obj := testNetwork(t, 1, 3)
+	connect(t, obj, fast, [2]byte{1, 2}, [2]byte{2, 3})
+	connect(t, obj, LinkConfig{Latency: time.Second}, [2]byte{1, 3})
+	_, ok := obj.Settle(10*time.Millisecond, time.Minute)
+	require.True(t, ok)
+	require.Equal(t, ids(1, 2, 3), obj.Path(proto.NodeID{1}, proto.NodeID{3}))
+
+	require.NoError(t, obj.Disconnect(proto.NodeID{2}, proto.NodeID{3}))
+	_, ok = obj.Settle(10*time.Millisecond, time.Minute)
+
+	assert.True(t, ok)
+	assert.Equal(t, ids(1, 3), obj.Path(proto.NodeID{1}, proto.NodeID{3}))
+}
+
+func TestNetworkPartition(t *testing.T) {
+	// A ring of 1 -- 2 -- 3 -- 4 -- 1
+	obj := testNetwork(t, 1, 4)
+	connect(t, obj, fast, [2]byte{1, 2}, [2]byte{2, 3}, [2]byte{3, 4}, [2]byte{4, 1})
+	_, ok := obj.Settle(10*time.Millisecond, time.Minute)
+	require.True(t, ok)
+
+	obj.Partition(ids(1, 2), ids(3, 4))
+	obj.Run(DefaultDetectTime)
+	_, ok = obj.Settle(10*time.Millisecond, time.Minute)
+
+	assert.True(t, ok)
+	assert.Nil(t, obj.Path(proto.NodeID{1}, proto.NodeID{3}))
+	assert.Equal(t, ids(2), obj.Node(proto.NodeID{1}).Neighbors())
+
+	obj.Heal()
+	_, ok = obj.Settle(10*time.Millisecond, time.Minute)
+
+	assert.True(t, ok)
+	assert.Len(t, obj.Path(proto.NodeID{1}, proto.NodeID{3}), 3)
+	assert.Equal(t, ids(2, 4), obj.Node(proto.NodeID{1}).Neighbors())
+}
+
+func TestNetworkPartitionBlocks(t *testing.T) {
+	obj := testNetwork(t, 1, 2)
+	connect(t, obj, fast, [2]byte{1, 2})
+	var received []proto.NodeID
+	obj.Node(proto.NodeID{2}).Handler = func(from proto.NodeID, f *proto.Frame) {
+		received = append(received, from)
+	}
+	obj.Partition(ids(1), ids(2))
+
+	err := obj.Node(proto.NodeID{1}).Send(proto.NodeID{2}, &proto.Frame{Header: proto.Header{Protocol: 42}})
+
+	assert.NoError(t, err)
+	obj.Run(time.Second)
+	assert.Empty(t, received)
+	assert.NotZero(t, obj.Stats().Blocked)
+}
+
+func TestNetworkLoss(t *testing.T) {
+	obj := testNetwork(t, 1, 2)
+	connect(t, obj, LinkConfig{Latency: 10 * time.Millisecond, Loss: 1}, [2]byte{1, 2})
+
+	obj.Run(10 * time.Second)
+
+	assert.Nil(t, obj.Node(proto.NodeID{1}).Routes.State(proto.NodeID{2}))
+	stats := obj.Stats()
+	assert.NotZero(t, stats.Sent)
+	assert.Equal(t, stats.Sent, stats.Lost)
+	assert.Zero(t, stats.Delivered)
+}
+
+func TestNetworkLatency(t *testing.T) {
+	obj := testNetwork(t, 1, 2)
+	connect(t, obj, LinkConfig{Latency: 100 * time.Millisecond, Jitter: 50 * time.Millisecond}, [2]byte{1, 2})
+	var at []time.Time
+	var payloads []string
+	obj.Node(proto.NodeID{2}).Handler = func(from proto.NodeID, f *proto.Frame) {
+		at = append(at, obj.Clock.Now())
+		payloads = append(payloads, string(f.Payload))
+	}
+	start := obj.Clock.Now()
+	for _, p := range []string{"one", "two", "three"} {
+		require.NoError(t, obj.Node(proto.NodeID{1}).Send(proto.NodeID{2}, &proto.Frame{Header: proto.Header{Protocol: 42}, Payload: []byte(p)}))
+	}
+
+	obj.Run(time.Second)
+
+	assert.Equal(t, []string{"one", "two", "three"}, payloads)
+	for _, a := range at {
+		assert.GreaterOrEqual(t, a.Sub(start), 100*time.Millisecond)
+		assert.LessOrEqual(t, a.Sub(start), 150*time.Millisecond)
+	}
+}
+
+func TestNetworkDeterministic(t *testing.T) {
+	run := func(seed uint64) (Stats, []time.Time) {
+		obj := testNetwork(t, seed, 6)
+		cfg := LinkConfig{Latency: 10 * time.Millisecond, Jitter: 20 * time.Millisecond, Loss: 0.2}
+		connect(t, obj, cfg, [2]byte{1, 2}, [2]byte{2, 3}, [2]byte{3, 4}, [2]byte{4, 5}, [2]byte{5, 6}, [2]byte{6, 1}, [2]byte{1, 4})
+		var at []time.Time
+		for _, n := range obj.Nodes() {
+			n.Handler = func(from proto.NodeID, f *proto.Frame) {
+				at = append(at, obj.Clock.Now())
+			}
+		}
+		for _, n := range obj.Nodes() {
+			for _, id := range n.Neighbors() {
+				n.Send(id, &proto.Frame{Header: proto.Header{Protocol: 42}}) //nolint:errcheck
+			}
+		}
+		obj.Run(30 * time.Second)
+		return obj.Stats(), at
+	}
+
+	stats1, at1 := run(42)
+	stats2, at2 := run(42)
+
+	assert.Equal(t, stats1, stats2)
+	assert.Equal(t, at1, at2)
+	assert.NotZero(t, stats1.Lost)
+}
+
+func TestNetworkGossip(t *testing.T) {
+	obj := testNetwork(t, 1, 3)
+	connect(t, obj, fast, [2]byte{1, 2}, [2]byte{2, 3})
+
+	obj.Run(3 * time.Second)
+
+	a := obj.Node(proto.NodeID{1}).Peers.Get(proto.NodeID{3})
+	require.NotNil(t, a)
+	assert.Equal(t, []string{"sim:" + proto.NodeID{3}.String()}, a.URIs)
+}
+
+func TestNetworkAddNodeExists(t *testing.T) {
+	obj := testNetwork(t, 1, 1)
+
+	result, err := obj.AddNode(proto.NodeID{1})
+
+	assert.ErrorIs(t, err, ErrNodeExists)
+	assert.Nil(t, result)
+}
+
+func TestNetworkConnectErrors(t *testing.T) {
+	obj := testNetwork(t, 1, 2)
+	connect(t, obj, fast, [2]byte{1, 2})
+
+	err1 := obj.Connect(proto.NodeID{1}, proto.NodeID{3}, fast)
+	err2 := obj.Connect(proto.NodeID{3}, proto.NodeID{1}, fast)
+	err3 := obj.Connect(proto.NodeID{2}, proto.NodeID{1}, fast)
+
+	assert.ErrorIs(t, err1, ErrUnknownNode)
+	assert.ErrorIs(t, err2, ErrUnknownNode)
+	assert.ErrorIs(t, err3, ErrLinkExists)
+}
+
+func TestNetworkDisconnectErrors(t *testing.T) {
+	obj := testNetwork(t, 1, 2)
+
+	err1 := obj.Disconnect(proto.NodeID{1}, proto.NodeID{3})
+	err2 := obj.Disconnect(proto.NodeID{1}, proto.NodeID{2})
+
+	assert.ErrorIs(t, err1, ErrUnknownNode)
+	assert.ErrorIs(t, err2, ErrNoLink)
+}
+
+func TestNodeSendErrors(t *testing.T) {
+	obj := testNetwork(t, 1, 2)
+	connect(t, obj, fast, [2]byte{1, 2})
+	n := obj.Node(proto.NodeID{1})
+	l := n.links[proto.NodeID{2}]
+	require.NoError(t, obj.Disconnect(proto.NodeID{1}, proto.NodeID{2}))
+
+	err1 := n.Send(proto.NodeID{2}, &proto.Frame{})
+	err2 := l.Send(&proto.Frame{})
+
+	assert.ErrorIs(t, err1, ErrNoLink)
+	assert.ErrorIs(t, err2, ErrLinkDown)
+}